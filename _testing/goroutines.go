@@ -0,0 +1,67 @@
+package testing
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// leakWait is how long goroutines get to exit before they count as leaked.
+const leakWait = 2 * time.Second
+
+// CheckGoroutines notes the running goroutines and returns a function that
+// fails t if goroutines started since run VIIPER code and do not exit
+// within leakWait; goleak, restricted to this module. Call the returned
+// function once everything the test started should have stopped.
+func CheckGoroutines(t testing.TB) func() {
+	t.Helper()
+	before := map[string]bool{}
+	for _, g := range goroutines() {
+		before[goroutineID(g)] = true
+	}
+	return func() {
+		t.Helper()
+		var leaked []string
+		for deadline := time.Now().Add(leakWait); ; {
+			leaked = leaked[:0]
+			for _, g := range goroutines() {
+				if !before[goroutineID(g)] && strings.Contains(g, "github.com/Alia5/VIIPER/") {
+					leaked = append(leaked, g)
+				}
+			}
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		for _, g := range leaked {
+			t.Errorf("leaked goroutine:\n%s", g)
+		}
+	}
+}
+
+// goroutines returns the stacks of all goroutines, one per entry.
+func goroutines() []string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	var out []string
+	for _, g := range bytes.Split(buf, []byte("\n\n")) {
+		out = append(out, string(g))
+	}
+	return out
+}
+
+// goroutineID returns the "goroutine N" prefix of a stack.
+func goroutineID(stack string) string {
+	id, _, _ := strings.Cut(stack, " [")
+	return id
+}
@@ -17,12 +17,23 @@ type MockServer struct {
 	UsbServer *usb.Server
 }
 
-func NewTestServerWithConfig(t *testing.T, cfg *config.CLI) *MockServer {
+func NewTestServerWithConfig(t testing.TB, cfg *config.CLI) *MockServer {
 	t.Helper()
 
 	logger := slog.Default()
 
 	usbServer := usb.New(cfg.Server.UsbServerConfig, logger, nil)
+	apiServer := api.New(
+		usbServer,
+		cfg.Server.ApiServerConfig.Addr,
+		cfg.Server.ApiServerConfig,
+		logger,
+	)
+	if cfg.Server.StateFile != "" {
+		if err := cfg.Server.RestoreStateFile(apiServer, logger); err != nil {
+			t.Fatalf("restore state: %v", err)
+		}
+	}
 
 	usbErrCh := make(chan error, 1)
 	go func() {
@@ -42,23 +53,18 @@ func NewTestServerWithConfig(t *testing.T, cfg *config.CLI) *MockServer {
 
 	return &MockServer{
 		UsbServer: usbServer,
-		ApiServer: api.New(
-			usbServer,
-			cfg.Server.ApiServerConfig.Addr,
-			cfg.Server.ApiServerConfig,
-			logger,
-		),
+		ApiServer: apiServer,
 	}
 }
 
-func NewTestServer(t *testing.T) *MockServer {
+func NewTestServer(t testing.TB) *MockServer {
 	t.Helper()
 
 	cfg := TestServerConfig(t)
 	return NewTestServerWithConfig(t, cfg)
 }
 
-func TestServerConfig(t *testing.T) *config.CLI {
+func TestServerConfig(t testing.TB) *config.CLI {
 	t.Helper()
 
 	return &config.CLI{
@@ -0,0 +1,139 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	apitypes "github.com/Alia5/VIIPER/apitypes"
+)
+
+var (
+	// ErrBatchNotExecuted is returned by BatchCall.Result before the batch ran.
+	ErrBatchNotExecuted = errors.New("batch not executed")
+	// ErrBatchSkipped is returned by BatchCall.Result for requests skipped
+	// after an earlier failure in a StopOnError batch.
+	ErrBatchSkipped = errors.New("skipped after an earlier batch failure")
+)
+
+// Batch queues management requests and sends them to the server in one
+// round trip, where they run sequentially in the order queued.
+//
+// Batches are not transactional: when a request fails, the ones before it
+// stay applied and, unless StopOnError is set, the ones after it still run.
+// Use the queueing methods (BusCreate, DeviceAdd, ...) to add requests and
+// read each result from the returned BatchCall after Execute.
+type Batch struct {
+	c        *Client
+	req      apitypes.BatchRequest
+	calls    []batchSlot
+	err      error
+	executed bool
+}
+
+type batchSlot interface {
+	resolve(raw json.RawMessage, err error)
+}
+
+// BatchCall is the result of a request queued on a Batch.
+type BatchCall[T any] struct {
+	res *T
+	err error
+}
+
+// Result returns the response of the request, or its problem.
+func (c *BatchCall[T]) Result() (*T, error) { return c.res, c.err }
+
+func (c *BatchCall[T]) resolve(raw json.RawMessage, err error) {
+	if err != nil {
+		c.err = err
+		return
+	}
+	c.res, c.err = parse[T](string(raw))
+}
+
+// NewBatch returns an empty batch executed through c.
+func (c *Client) NewBatch() *Batch { return &Batch{c: c} }
+
+// StopOnError makes the server skip the remaining requests after the first
+// failure. It returns b for chaining.
+func (b *Batch) StopOnError() *Batch {
+	b.req.StopOnError = true
+	return b
+}
+
+// Len returns the number of queued requests.
+func (b *Batch) Len() int { return len(b.req.Requests) }
+
+// Execute runs the batch.
+func (b *Batch) Execute() (*apitypes.BatchResponse, error) {
+	return b.ExecuteCtx(context.Background())
+}
+
+// ExecuteCtx runs the batch and resolves every queued BatchCall. The error is
+// non-nil only if the batch as a whole failed; failures of single requests
+// are reported by their BatchCall and in the response.
+func (b *Batch) ExecuteCtx(ctx context.Context) (*apitypes.BatchResponse, error) {
+	if b.executed {
+		return nil, errors.New("batch already executed")
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	b.executed = true
+	raw, err := b.c.transport.DoCtx(ctx, "batch", b.req, nil)
+	if err != nil {
+		return nil, err
+	}
+	type batchResult struct {
+		Result  json.RawMessage    `json:"result"`
+		Problem *apitypes.ApiError `json:"problem"`
+		Skipped bool               `json:"skipped"`
+	}
+	out, err := parse[struct {
+		Results []batchResult `json:"results"`
+	}](raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Results) != len(b.calls) {
+		return nil, fmt.Errorf("batch returned %d results for %d requests", len(out.Results), len(b.calls))
+	}
+
+	resp := &apitypes.BatchResponse{Results: make([]apitypes.BatchResult, len(out.Results))}
+	for i, r := range out.Results {
+		switch {
+		case r.Problem != nil:
+			resp.Results[i].Problem = r.Problem
+			b.calls[i].resolve(nil, r.Problem)
+		case r.Skipped:
+			resp.Results[i].Skipped = true
+			b.calls[i].resolve(nil, ErrBatchSkipped)
+		default:
+			resp.Results[i].Result = r.Result
+			b.calls[i].resolve(r.Result, nil)
+		}
+	}
+	return resp, nil
+}
+
+func queueBatchCall[T any](b *Batch, path string, payload any, pathParams map[string]string) *BatchCall[T] {
+	call := &BatchCall[T]{err: ErrBatchNotExecuted}
+	pb, ok := toPayloadBytes(payload)
+	if !ok {
+		return failBatchCall[T](b, fmt.Errorf("marshal payload for %s", path))
+	}
+	b.req.Requests = append(b.req.Requests, apitypes.BatchEntry{Path: fillPath(path, pathParams), Payload: string(pb)})
+	b.calls = append(b.calls, call)
+	return call
+}
+
+// failBatchCall records a request that could not be queued; the batch then
+// refuses to execute.
+func failBatchCall[T any](b *Batch, err error) *BatchCall[T] {
+	if b.err == nil {
+		b.err = err
+	}
+	return &BatchCall[T]{err: err}
+}
@@ -0,0 +1,18 @@
+package apiclient
+
+import (
+	"context"
+
+	apitypes "github.com/Alia5/VIIPER/apitypes"
+)
+
+// BusCreateLabeled creates a new virtual USB bus carrying a label and description.
+// A busID of 0 picks the next free bus number.
+func (c *Client) BusCreateLabeled(busID uint32, label, description string) (*apitypes.BusCreateResponse, error) {
+	return c.BusCreateLabeledCtx(context.Background(), busID, label, description)
+}
+
+// BusCreateLabeledCtx is the context-aware version of BusCreateLabeled.
+func (c *Client) BusCreateLabeledCtx(ctx context.Context, busID uint32, label, description string) (*apitypes.BusCreateResponse, error) {
+	return c.BusCreateWithCtx(ctx, apitypes.BusCreateRequest{BusID: busID, Label: label, Description: description})
+}
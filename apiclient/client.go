@@ -2,10 +2,12 @@ package apiclient
 
 import (
 	"bytes"
-	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	apitypes "github.com/Alia5/VIIPER/apitypes"
 	"github.com/Alia5/VIIPER/device"
@@ -13,97 +15,94 @@ import (
 
 // Client provides a high-level interface to the VIIPER API, handling request
 // formatting, response parsing, and error handling.
-type Client struct{ transport *Transport }
+type Client struct {
+	transport *Transport
+
+	featuresMu sync.Mutex
+	features   map[string]bool // fetched on first Supports
+}
 
 // New constructs a high-level API client using the internal low-level Transport.
 // The addr parameter specifies the TCP address (host:port) of the VIIPER API server.
-func New(addr string) *Client { return &Client{transport: NewTransport(addr)} }
-
-// NewWithPassword constructs a client that authenticates with the given password.
-func NewWithPassword(addr, password string) *Client {
-	return &Client{transport: NewTransportWithPassword(addr, password)}
+func New(addr string, opts ...Option) *Client {
+	cfg := defaultConfig()
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return &Client{transport: NewTransportWithConfig(addr, &cfg)}
 }
 
-// NewWithConfig constructs a client with custom transport timeouts.
-func NewWithConfig(addr string, cfg *Config) *Client {
-	return &Client{transport: NewTransportWithConfig(addr, cfg)}
-}
+// Option changes the transport configuration of a client made with New.
+type Option func(*Config)
 
-// WithTransport constructs a Client using a custom Transport implementation.
-// This is primarily useful for testing or when advanced transport configuration is needed.
-func WithTransport(t *Transport) *Client { return &Client{transport: t} }
+// WithDialTimeout bounds connecting, including the TLS handshake.
+func WithDialTimeout(d time.Duration) Option {
+	return func(c *Config) { c.DialTimeout = d }
+}
 
-// Ping returns the version and identity of the VIIPER server.
-func (c *Client) Ping() (*apitypes.PingResponse, error) {
-	return c.PingCtx(context.Background())
+// WithReadTimeout bounds waiting for the server in management calls and
+// handshakes; see Config.ReadTimeout.
+func WithReadTimeout(d time.Duration) Option {
+	return func(c *Config) { c.ReadTimeout = d }
 }
 
-// PingCtx is the context-aware version of Ping.
-func (c *Client) PingCtx(ctx context.Context) (*apitypes.PingResponse, error) {
-	const path = "ping"
-	raw, err := c.transport.DoCtx(ctx, path, nil, nil)
-	if err != nil {
-		return nil, err
-	}
-	return parse[apitypes.PingResponse](raw)
+// WithWriteTimeout bounds sending a request.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(c *Config) { c.WriteTimeout = d }
 }
 
-// BusCreate creates a new virtual USB bus with the specified bus number.
-// Returns the created bus ID or an error if the bus number is already allocated.
-func (c *Client) BusCreate(busID uint32) (*apitypes.BusCreateResponse, error) {
-	return c.BusCreateCtx(context.Background(), busID)
+// WithKeepAlive sets the TCP keepalive period; see Config.KeepAlive.
+func WithKeepAlive(d time.Duration) Option {
+	return func(c *Config) { c.KeepAlive = d }
 }
 
-func (c *Client) BusCreateCtx(ctx context.Context, busID uint32) (*apitypes.BusCreateResponse, error) {
-	const path = "bus/create"
-	raw, err := c.transport.DoCtx(ctx, path, fmt.Sprintf("%d", busID), nil)
-	if err != nil {
-		return nil, err
-	}
-	return parse[apitypes.BusCreateResponse](raw)
+// WithPassword authenticates with password, like NewWithPassword.
+func WithPassword(password string) Option {
+	return func(c *Config) { c.Password = password }
 }
 
-// BusRemove removes an existing virtual USB bus and all devices attached to it.
-// Returns the removed bus ID or an error if the bus does not exist.
-func (c *Client) BusRemove(busID uint32) (*apitypes.BusRemoveResponse, error) {
-	return c.BusRemoveCtx(context.Background(), busID)
+// WithServerFingerprint pins the identity of the server; see
+// Config.ServerFingerprint.
+func WithServerFingerprint(fingerprint string) Option {
+	return func(c *Config) { c.ServerFingerprint = fingerprint }
 }
 
-func (c *Client) BusRemoveCtx(ctx context.Context, busID uint32) (*apitypes.BusRemoveResponse, error) {
-	const path = "bus/remove"
-	raw, err := c.transport.DoCtx(ctx, path, fmt.Sprintf("%d", busID), nil)
-	if err != nil {
-		return nil, err
-	}
-	return parse[apitypes.BusRemoveResponse](raw)
+// NewWithPassword constructs a client that authenticates with the given password.
+func NewWithPassword(addr, password string) *Client {
+	return &Client{transport: NewTransportWithPassword(addr, password)}
 }
 
-// BusList retrieves a list of all active virtual USB bus numbers.
-func (c *Client) BusList() (*apitypes.BusListResponse, error) {
-	return c.BusListCtx(context.Background())
+// NewWithConfig constructs a client with custom transport timeouts.
+func NewWithConfig(addr string, cfg *Config) *Client {
+	return &Client{transport: NewTransportWithConfig(addr, cfg)}
 }
 
-func (c *Client) BusListCtx(ctx context.Context) (*apitypes.BusListResponse, error) {
-	const path = "bus/list"
-	raw, err := c.transport.DoCtx(ctx, path, nil, nil)
-	if err != nil {
-		return nil, err
-	}
-	return parse[apitypes.BusListResponse](raw)
+// WithToken makes c authenticate with a scoped per-client token of the form
+// "<id>:<secret>" instead of a password. Requests outside the token's scopes
+// fail with ErrForbidden. Call it before the first request.
+func (c *Client) WithToken(token string) *Client {
+	c.transport.cfg.Token = token
+	return c
 }
 
-// DeviceAdd adds a new device of the specified type to the given bus.
-// The devType parameter specifies the device type (e.g., "xbox360").
-// Returns the assigned bus ID (e.g., "1-1") or an error if the bus does not exist
-// or the device type is unknown.
-func (c *Client) DeviceAdd(busID uint32, devType string, o *device.CreateOptions) (*apitypes.Device, error) {
-	return c.DeviceAddCtx(context.Background(), busID, devType, o)
+// WithTLS makes c connect to a server serving the API over TLS, with cfg
+// holding e.g. RootCAs for a self-signed certificate. Call it before the
+// first request.
+func (c *Client) WithTLS(cfg *tls.Config) *Client {
+	c.transport.cfg.TLS = cfg
+	return c
 }
 
-func (c *Client) DeviceAddCtx(ctx context.Context, busID uint32, devType string, o *device.CreateOptions) (*apitypes.Device, error) {
-	pathParams := map[string]string{"id": fmt.Sprintf("%d", busID)}
-	const path = "bus/{id}/add"
+// WithTransport constructs a Client using a custom Transport implementation.
+// This is primarily useful for testing or when advanced transport configuration is needed.
+func WithTransport(t *Transport) *Client { return &Client{transport: t} }
+
+// Management API methods (Ping, BusCreate, DeviceAdd, ...) are generated from
+// the registered routes into client_gen.go; run "viiper codegen --lang go"
+// after adding or changing a route.
 
+// deviceCreatePayload builds the JSON body for bus/{id}/add.
+func deviceCreatePayload(devType string, o *device.CreateOptions) (string, error) {
 	if o == nil {
 		o = &device.CreateOptions{}
 	}
@@ -111,51 +110,40 @@ func (c *Client) DeviceAddCtx(ctx context.Context, busID uint32, devType string,
 		Type:           &devType,
 		IdVendor:       o.IdVendor,
 		IdProduct:      o.IdProduct,
+		SerialNumber:   o.SerialNumber,
+		ProductString:  o.ProductString,
 		DeviceSpecific: o.DeviceSpecific,
+		StrictInput:    o.StrictInput,
+		PlayerSlot:     o.PlayerSlot,
 	}
-	payloadBytes, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf("marshal device create request: %w", err)
+	if m := o.MSOS20; m != nil {
+		req.MSOSDescriptors = &apitypes.MSOSDescriptors{CompatibleID: m.CompatibleID, SubCompatibleID: m.SubCompatibleID}
 	}
-	raw, err := c.transport.DoCtx(ctx, path, string(payloadBytes), pathParams)
-	if err != nil {
-		return nil, err
+	if p := o.StreamPolicy; p != nil {
+		s := string(*p)
+		req.StreamPolicy = &s
 	}
-	return parse[apitypes.Device](raw)
-}
-
-// DeviceRemove removes a device from the specified bus by its device ID.
-// The busid parameter is the device number (e.g., "1") on the given bus.
-// Active USB-IP connections to the device will be closed.
-// Returns the removed device's bus and device ID or an error if not found.
-func (c *Client) DeviceRemove(busID uint32, busid string) (*apitypes.DeviceRemoveResponse, error) {
-	return c.DeviceRemoveCtx(context.Background(), busID, busid)
-}
-
-func (c *Client) DeviceRemoveCtx(ctx context.Context, busID uint32, busid string) (*apitypes.DeviceRemoveResponse, error) {
-	pathParams := map[string]string{"id": fmt.Sprintf("%d", busID)}
-	const path = "bus/{id}/remove"
-	raw, err := c.transport.DoCtx(ctx, path, busid, pathParams)
-	if err != nil {
-		return nil, err
+	if h := o.Humanize; h != nil {
+		req.Humanize = &apitypes.HumanizeConfig{
+			Enabled:           h.Enabled,
+			KeyIntervalMeanMs: uint32(h.KeyIntervalMean.Milliseconds()),
+			KeyIntervalStdDev: uint32(h.KeyIntervalStdDev.Milliseconds()),
+			MouseJitterPx:     uint32(h.MouseJitter),
+			Seed:              h.Seed,
+		}
 	}
-	return parse[apitypes.DeviceRemoveResponse](raw)
-}
-
-// DevicesList retrieves a list of all devices attached to the specified bus.
-// Each device entry includes bus ID, device ID, VID, PID, and device type.
-func (c *Client) DevicesList(busID uint32) (*apitypes.DevicesListResponse, error) {
-	return c.DevicesListCtx(context.Background(), busID)
-}
-
-func (c *Client) DevicesListCtx(ctx context.Context, busID uint32) (*apitypes.DevicesListResponse, error) {
-	pathParams := map[string]string{"id": fmt.Sprintf("%d", busID)}
-	const path = "bus/{id}/list"
-	raw, err := c.transport.DoCtx(ctx, path, nil, pathParams)
+	if d := o.Deterministic; d != nil {
+		req.Deterministic = &apitypes.DeterministicConfig{Enabled: d.Enabled, Manual: d.Manual}
+	}
+	if p := o.Disconnect; p != nil {
+		s := string(*p)
+		req.Disconnect = &s
+	}
+	payloadBytes, err := json.Marshal(req)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("marshal device create request: %w", err)
 	}
-	return parse[apitypes.DevicesListResponse](raw)
+	return string(payloadBytes), nil
 }
 
 func parse[T any](data string) (*T, error) {
@@ -0,0 +1,31 @@
+package apiclient_test
+
+import (
+	"reflect"
+	"testing"
+
+	apiclient "github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/internal/codegen/generator/golang"
+	"github.com/Alia5/VIIPER/internal/codegen/scanner"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientCoversRegisteredRoutes(t *testing.T) {
+	routes, err := scanner.ScanRoutesInPackage("../internal/cmd")
+	require.NoError(t, err)
+	require.NotEmpty(t, routes)
+
+	ct := reflect.TypeOf(&apiclient.Client{})
+	for _, r := range routes {
+		if r.Method != "Register" {
+			continue
+		}
+		name := golang.MethodName(r)
+		for _, m := range []string{name, name + "Ctx"} {
+			_, ok := ct.MethodByName(m)
+			assert.True(t, ok, "route %q has no Client.%s; run \"viiper codegen --lang go\"", r.Path, m)
+		}
+	}
+}
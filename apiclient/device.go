@@ -0,0 +1,24 @@
+package apiclient
+
+import (
+	"context"
+	"fmt"
+
+	apitypes "github.com/Alia5/VIIPER/apitypes"
+)
+
+// DeviceAddWith adds a device from the full JSON form of the bus/{id}/add
+// payload, e.g. to give it a label.
+func (c *Client) DeviceAddWith(busID uint32, req apitypes.DeviceCreateRequest) (*apitypes.Device, error) {
+	return c.DeviceAddWithCtx(context.Background(), busID, req)
+}
+
+// DeviceAddWithCtx is the context-aware version of DeviceAddWith.
+func (c *Client) DeviceAddWithCtx(ctx context.Context, busID uint32, req apitypes.DeviceCreateRequest) (*apitypes.Device, error) {
+	pathParams := map[string]string{"id": fmt.Sprintf("%d", busID)}
+	raw, err := c.transport.DoCtx(ctx, "bus/{id}/add", req, pathParams)
+	if err != nil {
+		return nil, err
+	}
+	return parse[apitypes.Device](raw)
+}
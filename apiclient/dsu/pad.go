@@ -0,0 +1,43 @@
+package dsu
+
+import (
+	"fmt"
+
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/device/dualshock4"
+)
+
+// Pad feeds a VIIPER DualShock 4 through its device stream and mirrors every
+// state it sends into a DSU slot.
+type Pad struct {
+	srv    *Server
+	slot   int
+	stream *apiclient.DeviceStream
+}
+
+// Bind maps the DualShock 4 behind stream to a DSU slot. The caller keeps
+// owning the stream and closes it after the pad.
+func (s *Server) Bind(slot int, stream *apiclient.DeviceStream) (*Pad, error) {
+	if slot < 0 || slot >= MaxSlots {
+		return nil, fmt.Errorf("dsu: slot %d out of range", slot)
+	}
+	return &Pad{srv: s, slot: slot, stream: stream}, nil
+}
+
+// Slot returns the DSU slot of the pad.
+func (p *Pad) Slot() int { return p.slot }
+
+// Send writes st to the device and, once VIIPER accepted it, to the DSU
+// clients subscribed to the slot.
+func (p *Pad) Send(st *dualshock4.InputState) error {
+	if err := p.stream.WriteBinary(st); err != nil {
+		return err
+	}
+	return p.srv.Update(p.slot, st)
+}
+
+// Close reports the slot as disconnected to DSU clients.
+func (p *Pad) Close() error {
+	p.srv.Disconnect(p.slot)
+	return nil
+}
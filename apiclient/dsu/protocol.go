@@ -0,0 +1,250 @@
+// Package dsu serves VIIPER DualShock 4 pads to emulators over the DSU
+// ("cemuhook") UDP protocol, so motion fed into VIIPER reaches emulators such
+// as Cemu, Dolphin or Yuzu without a separate translation daemon.
+package dsu
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+
+	"github.com/Alia5/VIIPER/device/dualshock4"
+)
+
+// DefaultPort is the UDP port DSU clients connect to unless configured
+// otherwise.
+const DefaultPort = 26760
+
+// ProtocolVersion is the DSU protocol version spoken.
+const ProtocolVersion = 1001
+
+// MaxSlots is the number of pad slots a DSU server exposes.
+const MaxSlots = 4
+
+const (
+	headerSize = 16
+	// Every message starts with its type, counted in the header length.
+	typeSize = 4
+
+	magicClient = "DSUC"
+	magicServer = "DSUS"
+)
+
+// Message types.
+const (
+	MsgVersion = 0x100000
+	MsgPorts   = 0x100001
+	MsgPadData = 0x100002
+)
+
+// Slot states, device models and connection types of the shared response
+// prefix.
+const (
+	slotConnected = 2
+
+	modelFullGyro = 2
+
+	connectionUSB = 1
+
+	batteryCharged = 0xef
+)
+
+// Registration flags of a pad data request. Zero subscribes to all slots.
+const (
+	registerSlot = 0x01
+	registerMAC  = 0x02
+)
+
+var (
+	errShort     = errors.New("packet too short")
+	errMagic     = errors.New("not a DSU client packet")
+	errVersion   = errors.New("unsupported protocol version")
+	errLength    = errors.New("length field exceeds packet")
+	errChecksum  = errors.New("checksum mismatch")
+	errTruncated = errors.New("message body too short")
+)
+
+// packet is a decoded client packet.
+type packet struct {
+	clientID uint32
+	msgType  uint32
+	body     []byte // after the message type
+}
+
+// parsePacket validates a client packet: magic, version, length and CRC32
+// of the whole packet with the checksum field zeroed.
+func parsePacket(b []byte) (packet, error) {
+	if len(b) < headerSize+typeSize {
+		return packet{}, errShort
+	}
+	if string(b[0:4]) != magicClient {
+		return packet{}, errMagic
+	}
+	if binary.LittleEndian.Uint16(b[4:6]) != ProtocolVersion {
+		return packet{}, errVersion
+	}
+	n := int(binary.LittleEndian.Uint16(b[6:8]))
+	if n < typeSize || headerSize+n > len(b) {
+		return packet{}, errLength
+	}
+	b = b[:headerSize+n]
+	if binary.LittleEndian.Uint32(b[8:12]) != checksum(b) {
+		return packet{}, errChecksum
+	}
+	return packet{
+		clientID: binary.LittleEndian.Uint32(b[12:16]),
+		msgType:  binary.LittleEndian.Uint32(b[16:20]),
+		body:     b[headerSize+typeSize:],
+	}, nil
+}
+
+// checksum is the CRC32 of b with its checksum field taken as zero.
+func checksum(b []byte) uint32 {
+	crc := crc32.Update(0, crc32.IEEETable, b[:8])
+	crc = crc32.Update(crc, crc32.IEEETable, []byte{0, 0, 0, 0})
+	return crc32.Update(crc, crc32.IEEETable, b[12:])
+}
+
+// encodePacket frames a server message. body follows the message type.
+func encodePacket(serverID, msgType uint32, body []byte) []byte {
+	b := make([]byte, headerSize+typeSize+len(body))
+	copy(b[0:4], magicServer)
+	binary.LittleEndian.PutUint16(b[4:6], ProtocolVersion)
+	binary.LittleEndian.PutUint16(b[6:8], uint16(typeSize+len(body)))
+	binary.LittleEndian.PutUint32(b[12:16], serverID)
+	binary.LittleEndian.PutUint32(b[16:20], msgType)
+	copy(b[headerSize+typeSize:], body)
+	binary.LittleEndian.PutUint32(b[8:12], checksum(b))
+	return b
+}
+
+// slotInfo is the prefix shared by port info and pad data responses.
+type slotInfo struct {
+	slot      uint8
+	connected bool
+	mac       [6]byte
+}
+
+func (s slotInfo) put(b []byte) {
+	b[0] = s.slot
+	if !s.connected {
+		return // everything else stays zero: not applicable
+	}
+	b[1] = slotConnected
+	b[2] = modelFullGyro
+	b[3] = connectionUSB
+	copy(b[4:10], s.mac[:])
+	b[10] = batteryCharged
+}
+
+const slotInfoSize = 11
+
+func encodePortInfo(s slotInfo) []byte {
+	b := make([]byte, slotInfoSize+1)
+	s.put(b)
+	return b
+}
+
+const padDataSize = 80
+
+// encodePadData converts a DualShock 4 input state into a DSU pad data
+// body. Sticks are flipped to DSU's "Y up" convention; gyro and accel axes
+// are passed through in the DualShock 4's orientation, converted from
+// VIIPER's fixed-point units to °/s and g.
+func encodePadData(s slotInfo, packetNum uint32, motionUs uint64, st *dualshock4.InputState) []byte {
+	b := make([]byte, padDataSize)
+	s.put(b)
+	b[11] = 1
+	binary.LittleEndian.PutUint32(b[12:16], packetNum)
+
+	var buttons1, buttons2 uint8
+	for _, m := range []struct {
+		set bool
+		bit uint8
+	}{
+		{st.DPad&dualshock4.DPadLeft != 0, 0x80},
+		{st.DPad&dualshock4.DPadDown != 0, 0x40},
+		{st.DPad&dualshock4.DPadRight != 0, 0x20},
+		{st.DPad&dualshock4.DPadUp != 0, 0x10},
+		{st.Buttons&dualshock4.ButtonOptions != 0, 0x08},
+		{st.Buttons&dualshock4.ButtonR3 != 0, 0x04},
+		{st.Buttons&dualshock4.ButtonL3 != 0, 0x02},
+		{st.Buttons&dualshock4.ButtonShare != 0, 0x01},
+	} {
+		if m.set {
+			buttons1 |= m.bit
+		}
+	}
+	for _, m := range []struct {
+		btn uint16
+		bit uint8
+	}{
+		{dualshock4.ButtonTriangle, 0x80},
+		{dualshock4.ButtonCircle, 0x40},
+		{dualshock4.ButtonCross, 0x20},
+		{dualshock4.ButtonSquare, 0x10},
+		{dualshock4.ButtonR1, 0x08},
+		{dualshock4.ButtonL1, 0x04},
+		{dualshock4.ButtonR2, 0x02},
+		{dualshock4.ButtonL2, 0x01},
+	} {
+		if st.Buttons&m.btn != 0 {
+			buttons2 |= m.bit
+		}
+	}
+	b[16] = buttons1
+	b[17] = buttons2
+	b[18] = boolByte(st.Buttons&dualshock4.ButtonPS != 0)
+	b[19] = boolByte(st.Buttons&dualshock4.ButtonTouchpadClick != 0)
+
+	b[20] = uint8(int16(st.LX) + 128)
+	b[21] = uint8(127 - int16(st.LY))
+	b[22] = uint8(int16(st.RX) + 128)
+	b[23] = uint8(127 - int16(st.RY))
+
+	// Analog buttons: D-pad left, down, right, up, then triangle, circle,
+	// cross, square, R1, L1, R2, L2.
+	for i, bit := range []uint8{0x80, 0x40, 0x20, 0x10} {
+		if buttons1&bit != 0 {
+			b[24+i] = 0xff
+		}
+	}
+	for i, bit := range []uint8{0x80, 0x40, 0x20, 0x10, 0x08, 0x04} {
+		if buttons2&bit != 0 {
+			b[28+i] = 0xff
+		}
+	}
+	b[34] = st.R2
+	b[35] = st.L2
+
+	putTouch(b[36:42], 0, st.Touch1Active, st.Touch1X, st.Touch1Y)
+	putTouch(b[42:48], 1, st.Touch2Active, st.Touch2X, st.Touch2Y)
+
+	binary.LittleEndian.PutUint64(b[48:56], motionUs)
+	for i, v := range []float64{
+		dualshock4.AccelRawToMS2(st.AccelX) / dualshock4.StandardGravityMS2,
+		dualshock4.AccelRawToMS2(st.AccelY) / dualshock4.StandardGravityMS2,
+		dualshock4.AccelRawToMS2(st.AccelZ) / dualshock4.StandardGravityMS2,
+		dualshock4.GyroRawToDps(st.GyroX),
+		dualshock4.GyroRawToDps(st.GyroY),
+		dualshock4.GyroRawToDps(st.GyroZ),
+	} {
+		binary.LittleEndian.PutUint32(b[56+4*i:], math.Float32bits(float32(v)))
+	}
+	return b
+}
+
+func putTouch(b []byte, id uint8, active bool, x, y uint16) {
+	b[0] = boolByte(active)
+	b[1] = id
+	binary.LittleEndian.PutUint16(b[2:4], x)
+	binary.LittleEndian.PutUint16(b[4:6], y)
+}
+
+func boolByte(v bool) uint8 {
+	if v {
+		return 1
+	}
+	return 0
+}
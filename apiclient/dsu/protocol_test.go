@@ -0,0 +1,114 @@
+package dsu
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/device/dualshock4"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestParsePacket(t *testing.T) {
+	tests := []struct {
+		name    string
+		packet  string
+		msgType uint32
+		body    string
+	}{
+		{name: "version", packet: "44535543e9030400ef02b82e4433221100001000", msgType: MsgVersion},
+		{name: "ports", packet: "44535543e9030a00e386fee44433221101001000020000000003", msgType: MsgPorts, body: "020000000003"},
+		{name: "pad data", packet: "44535543e9030c00d850682e44332211020010000102000000000000", msgType: MsgPadData, body: "0102000000000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := parsePacket(unhex(t, tt.packet))
+			require.NoError(t, err)
+			assert.Equal(t, uint32(0x11223344), p.clientID)
+			assert.Equal(t, tt.msgType, p.msgType)
+			assert.Equal(t, tt.body, hex.EncodeToString(p.body))
+		})
+	}
+
+	valid := unhex(t, tests[1].packet)
+	corrupt := func(i int, v byte) []byte {
+		b := append([]byte(nil), valid...)
+		b[i] = v
+		return b
+	}
+	_, err := parsePacket(valid[:18])
+	assert.ErrorIs(t, err, errShort)
+	_, err = parsePacket(corrupt(3, 'S'))
+	assert.ErrorIs(t, err, errMagic, "server packets are not requests")
+	_, err = parsePacket(corrupt(4, 0xea))
+	assert.ErrorIs(t, err, errVersion)
+	_, err = parsePacket(corrupt(6, 0x40))
+	assert.ErrorIs(t, err, errLength)
+	_, err = parsePacket(corrupt(25, 0x01))
+	assert.ErrorIs(t, err, errChecksum)
+
+	p, err := parsePacket(append(valid, 0xaa, 0xbb))
+	require.NoError(t, err, "bytes past the length field are ignored")
+	assert.Equal(t, "020000000003", hex.EncodeToString(p.body))
+}
+
+func TestEncodePacket(t *testing.T) {
+	var version [2]byte
+	binary.LittleEndian.PutUint16(version[:], ProtocolVersion)
+	assert.Equal(t, "44535553e9030600962b88c1bebafeca00001000e903",
+		hex.EncodeToString(encodePacket(0xcafebabe, MsgVersion, version[:])))
+
+	info := encodePortInfo(slotInfo{slot: 1, connected: true, mac: slotMAC(1)})
+	assert.Equal(t, "44535553e9031000d48b54aabebafeca0100100001020201025649500002ef00",
+		hex.EncodeToString(encodePacket(0xcafebabe, MsgPorts, info)))
+	assert.Equal(t, []byte{3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, encodePortInfo(slotInfo{slot: 3}))
+}
+
+func TestEncodePadData(t *testing.T) {
+	st := &dualshock4.InputState{
+		LX: -128, LY: -128, RX: 127, RY: 0,
+		Buttons: dualshock4.ButtonCross | dualshock4.ButtonR1 | dualshock4.ButtonR2 | dualshock4.ButtonOptions |
+			dualshock4.ButtonPS,
+		DPad:         dualshock4.DPadUp | dualshock4.DPadLeft,
+		L2:           10,
+		R2:           200,
+		Touch1Active: true, Touch1X: 1920, Touch1Y: 942,
+		GyroX:  dualshock4.GyroDpsToRaw(90),
+		GyroY:  dualshock4.GyroDpsToRaw(-45.5),
+		AccelZ: dualshock4.DefaultAccelZRaw,
+		AccelX: dualshock4.AccelMS2ToRaw(dualshock4.StandardGravityMS2 / 2),
+	}
+	b := encodePadData(slotInfo{slot: 2, connected: true, mac: slotMAC(2)}, 7, 123456, st)
+	require.Len(t, b, padDataSize)
+
+	assert.Equal(t, []byte{2, 2, 2, 1, 0x02, 'V', 'I', 'P', 0, 3, 0xef, 1}, b[:12])
+	assert.Equal(t, uint32(7), binary.LittleEndian.Uint32(b[12:16]))
+	assert.Equal(t, uint8(0x80|0x10|0x08), b[16], "left, up, options")
+	assert.Equal(t, uint8(0x20|0x08|0x02), b[17], "cross, R1, R2")
+	assert.Equal(t, []byte{1, 0}, b[18:20], "home, touch click")
+	assert.Equal(t, []byte{0, 255, 255, 127}, b[20:24], "sticks with Y up")
+	assert.Equal(t, []byte{255, 0, 0, 255, 0, 0, 255, 0, 255, 0, 200, 10}, b[24:36])
+	assert.Equal(t, []byte{1, 0, 0x80, 0x07, 0xae, 0x03}, b[36:42])
+	assert.Equal(t, []byte{0, 1, 0, 0, 0, 0}, b[42:48])
+	assert.Equal(t, uint64(123456), binary.LittleEndian.Uint64(b[48:56]))
+
+	float := func(off int) float64 {
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b[off:])))
+	}
+	assert.InDelta(t, 0.5, float(56), 0.001, "accel X in g")
+	assert.InDelta(t, 0, float(60), 0.001)
+	assert.InDelta(t, -1, float(64), 0.001, "resting accel Z is -1 g")
+	assert.InDelta(t, 90, float(68), 0.001, "pitch in °/s")
+	assert.InDelta(t, -45.5, float(72), 0.001)
+	assert.InDelta(t, 0, float(76), 0.001)
+}
@@ -0,0 +1,237 @@
+package dsu
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Alia5/VIIPER/device/dualshock4"
+)
+
+// clientTimeout is how long a pad data request keeps a client subscribed.
+// DSU clients repeat their requests about once a second.
+const clientTimeout = 5 * time.Second
+
+// Server answers DSU clients on a UDP socket and pushes pad data to the
+// clients subscribed to a slot whenever that slot is updated.
+type Server struct {
+	conn   net.PacketConn
+	id     uint32
+	logger *slog.Logger
+	epoch  time.Time
+
+	mu      sync.Mutex
+	pads    [MaxSlots]*padSlot
+	clients map[string]*subscriber
+}
+
+type padSlot struct {
+	state dualshock4.InputState
+	at    time.Time
+}
+
+// subscriber remembers when a client last asked for pad data, per kind of
+// registration.
+type subscriber struct {
+	addr  net.Addr
+	seq   uint32
+	all   time.Time
+	slots [MaxSlots]time.Time
+	macs  map[[6]byte]time.Time
+}
+
+func (c *subscriber) wants(slot int, now time.Time) bool {
+	return now.Sub(c.all) < clientTimeout ||
+		now.Sub(c.slots[slot]) < clientTimeout ||
+		now.Sub(c.macs[slotMAC(slot)]) < clientTimeout
+}
+
+func (c *subscriber) wantsAny(now time.Time) bool {
+	for i := range MaxSlots {
+		if c.wants(i, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewServer serves DSU on conn, typically a UDP socket bound to DefaultPort.
+// A nil logger discards logs.
+func NewServer(conn net.PacketConn, logger *slog.Logger) *Server {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	return &Server{
+		conn:    conn,
+		id:      uint32(time.Now().UnixNano()),
+		logger:  logger,
+		epoch:   time.Now(),
+		clients: map[string]*subscriber{},
+	}
+}
+
+// slotMAC is the MAC address reported for a slot.
+func slotMAC(slot int) [6]byte {
+	return [6]byte{0x02, 'V', 'I', 'P', 0x00, byte(slot + 1)}
+}
+
+// Serve answers client requests until ctx is done or the socket fails.
+func (s *Server) Serve(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { _ = s.conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("dsu: read: %w", err)
+		}
+		p, err := parsePacket(buf[:n])
+		if err != nil {
+			s.logger.Debug("dsu: ignoring packet", "from", addr, "error", err)
+			continue
+		}
+		if err := s.handle(addr, p); err != nil {
+			s.logger.Debug("dsu: bad request", "from", addr, "type", fmt.Sprintf("0x%x", p.msgType), "error", err)
+		}
+	}
+}
+
+func (s *Server) handle(addr net.Addr, p packet) error {
+	switch p.msgType {
+	case MsgVersion:
+		var body [2]byte
+		binary.LittleEndian.PutUint16(body[:], ProtocolVersion)
+		s.send(addr, MsgVersion, body[:])
+	case MsgPorts:
+		if len(p.body) < 4 {
+			return errTruncated
+		}
+		count := int(int32(binary.LittleEndian.Uint32(p.body[0:4])))
+		slots := p.body[4:]
+		if count < 0 || count > len(slots) {
+			return errTruncated
+		}
+		for _, slot := range slots[:min(count, MaxSlots)] {
+			if int(slot) >= MaxSlots {
+				continue
+			}
+			s.send(addr, MsgPorts, encodePortInfo(s.slotInfo(int(slot))))
+		}
+	case MsgPadData:
+		if len(p.body) < 8 {
+			return errTruncated
+		}
+		s.subscribe(addr, p.body[0], p.body[1], [6]byte(p.body[2:8]))
+	default:
+		return errors.New("unknown message type")
+	}
+	return nil
+}
+
+func (s *Server) slotInfo(slot int) slotInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slotInfo{slot: uint8(slot), connected: s.pads[slot] != nil, mac: slotMAC(slot)}
+}
+
+// subscribe registers a pad data request and answers it with the current
+// state of the requested slots, so clients see pads before their next input.
+func (s *Server) subscribe(addr net.Addr, flags, slot uint8, mac [6]byte) {
+	now := time.Now()
+	s.mu.Lock()
+	c := s.clients[addr.String()]
+	if c == nil {
+		c = &subscriber{addr: addr, macs: map[[6]byte]time.Time{}}
+		s.clients[addr.String()] = c
+		s.logger.Info("dsu: client subscribed", "addr", addr)
+	}
+	if flags == 0 {
+		c.all = now
+	}
+	if flags&registerSlot != 0 && int(slot) < MaxSlots {
+		c.slots[slot] = now
+	}
+	if flags&registerMAC != 0 {
+		for i := range MaxSlots {
+			if mac == slotMAC(i) {
+				c.macs[mac] = now
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for i := range MaxSlots {
+		s.push(i, c, now)
+	}
+}
+
+// Update publishes the input state of a slot to subscribed clients. The
+// slot is reported as connected from then on.
+func (s *Server) Update(slot int, st *dualshock4.InputState) error {
+	if slot < 0 || slot >= MaxSlots {
+		return fmt.Errorf("dsu: slot %d out of range", slot)
+	}
+	now := time.Now()
+	s.mu.Lock()
+	s.pads[slot] = &padSlot{state: *st, at: now}
+	var targets []*subscriber
+	for key, c := range s.clients {
+		if !c.wantsAny(now) {
+			delete(s.clients, key)
+			s.logger.Info("dsu: client timed out", "addr", c.addr)
+			continue
+		}
+		targets = append(targets, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range targets {
+		s.push(slot, c, now)
+	}
+	return nil
+}
+
+// Disconnect reports the slot as disconnected.
+func (s *Server) Disconnect(slot int) {
+	if slot < 0 || slot >= MaxSlots {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pads[slot] = nil
+}
+
+// push sends the state of slot to c if c subscribed to it and the slot is
+// connected.
+func (s *Server) push(slot int, c *subscriber, now time.Time) {
+	s.mu.Lock()
+	pad := s.pads[slot]
+	if pad == nil || !c.wants(slot, now) {
+		s.mu.Unlock()
+		return
+	}
+	c.seq++
+	body := encodePadData(
+		slotInfo{slot: uint8(slot), connected: true, mac: slotMAC(slot)},
+		c.seq,
+		uint64(pad.at.Sub(s.epoch).Microseconds()),
+		&pad.state,
+	)
+	s.mu.Unlock()
+	s.send(c.addr, MsgPadData, body)
+}
+
+func (s *Server) send(addr net.Addr, msgType uint32, body []byte) {
+	if _, err := s.conn.WriteTo(encodePacket(s.id, msgType, body), addr); err != nil {
+		s.logger.Debug("dsu: write failed", "to", addr, "error", err)
+	}
+}
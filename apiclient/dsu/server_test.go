@@ -0,0 +1,156 @@
+package dsu_test
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apiclient/dsu"
+	"github.com/Alia5/VIIPER/device/dualshock4"
+	_ "github.com/Alia5/VIIPER/internal/registry"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// dsuClient is a scripted DSU client, the way emulators talk to a server.
+type dsuClient struct {
+	t    *testing.T
+	conn *net.UDPConn
+}
+
+func (c *dsuClient) request(msgType uint32, body ...byte) {
+	c.t.Helper()
+	b := make([]byte, 20+len(body))
+	copy(b, "DSUC")
+	binary.LittleEndian.PutUint16(b[4:], dsu.ProtocolVersion)
+	binary.LittleEndian.PutUint16(b[6:], uint16(4+len(body)))
+	binary.LittleEndian.PutUint32(b[12:], 42)
+	binary.LittleEndian.PutUint32(b[16:], msgType)
+	copy(b[20:], body)
+	binary.LittleEndian.PutUint32(b[8:], crc32.ChecksumIEEE(b))
+	_, err := c.conn.Write(b)
+	require.NoError(c.t, err)
+}
+
+// read returns the message type and body of the next server packet.
+func (c *dsuClient) read() (uint32, []byte) {
+	c.t.Helper()
+	buf := make([]byte, 1500)
+	require.NoError(c.t, c.conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	n, err := c.conn.Read(buf)
+	require.NoError(c.t, err)
+	b := buf[:n]
+	require.Equal(c.t, "DSUS", string(b[:4]))
+	require.Equal(c.t, int(binary.LittleEndian.Uint16(b[6:])), n-16)
+	sum := binary.LittleEndian.Uint32(b[8:])
+	copy(b[8:12], []byte{0, 0, 0, 0})
+	require.Equal(c.t, crc32.ChecksumIEEE(b), sum, "checksum")
+	return binary.LittleEndian.Uint32(b[16:]), b[20:]
+}
+
+func TestBridge(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90120)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	defer func() { _ = s.UsbServer.RemoveBus(90120) }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, _, err := apiclient.New(s.ApiServer.Addr()).AddDeviceAndConnect(ctx, 90120, "dualshock4", nil)
+	require.NoError(t, err)
+	defer stream.Close()
+	ds4 := b.GetAllDeviceMetas()[0].Dev.(*dualshock4.DualShock4)
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer udp.Close()
+	bridge := dsu.NewServer(udp, nil)
+	served := make(chan error, 1)
+	go func() { served <- bridge.Serve(ctx) }()
+	pad, err := bridge.Bind(1, stream)
+	require.NoError(t, err)
+	_, err = bridge.Bind(dsu.MaxSlots, stream)
+	assert.Error(t, err)
+
+	conn, err := net.DialUDP("udp", nil, udp.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := &dsuClient{t: t, conn: conn}
+
+	client.request(dsu.MsgVersion)
+	typ, body := client.read()
+	assert.Equal(t, uint32(dsu.MsgVersion), typ)
+	assert.Equal(t, uint16(dsu.ProtocolVersion), binary.LittleEndian.Uint16(body))
+
+	// Slot 1 only counts as connected once the pad sent a state.
+	client.request(dsu.MsgPorts, 1, 0, 0, 0, 1)
+	_, body = client.read()
+	assert.Equal(t, []byte{1, 0}, body[:2])
+
+	st := &dualshock4.InputState{
+		LX:      100,
+		Buttons: dualshock4.ButtonCircle,
+		GyroZ:   dualshock4.GyroDpsToRaw(180),
+		AccelZ:  dualshock4.DefaultAccelZRaw,
+	}
+	require.NoError(t, pad.Send(st))
+
+	client.request(dsu.MsgPorts, 2, 0, 0, 0, 1, 3)
+	_, body = client.read()
+	assert.Equal(t, []byte{1, 2, 2, 1}, body[:4], "slot 1 connected, full gyro, USB")
+	_, body = client.read()
+	assert.Equal(t, []byte{3, 0}, body[:2], "slot 3 empty")
+
+	// Subscribing to slot 1 answers with the current state right away.
+	client.request(dsu.MsgPadData, 0x01, 1, 0, 0, 0, 0, 0, 0)
+	typ, body = client.read()
+	assert.Equal(t, uint32(dsu.MsgPadData), typ)
+	assert.Equal(t, uint8(1), body[0])
+	assert.Equal(t, uint8(228), body[20], "left stick X")
+	assert.Equal(t, uint8(0x40), body[17], "circle")
+	assert.InDelta(t, 180, math.Float32frombits(binary.LittleEndian.Uint32(body[76:])), 0.001, "roll")
+	first := binary.LittleEndian.Uint32(body[12:])
+
+	st.LX = -100
+	require.NoError(t, pad.Send(st))
+	_, body = client.read()
+	assert.Equal(t, uint8(28), body[20])
+	assert.Equal(t, first+1, binary.LittleEndian.Uint32(body[12:]), "packet numbers count up")
+
+	assert.Eventually(t, func() bool {
+		report := ds4.HandleTransfer(dualshock4.EndpointIn&0x0f, usbip.DirIn, nil)
+		return len(report) > 1 && report[1] == 28
+	}, time.Second, 5*time.Millisecond, "VIIPER device receives what DSU clients see")
+
+	require.NoError(t, pad.Close())
+	client.request(dsu.MsgPorts, 1, 0, 0, 0, 1)
+	_, body = client.read()
+	assert.Equal(t, []byte{1, 0}, body[:2], "closed pads are disconnected")
+
+	cancel()
+	select {
+	case err := <-served:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after cancel")
+	}
+}
@@ -0,0 +1,29 @@
+package apiclient
+
+import (
+	apitypes "github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api/auth"
+)
+
+// APIError is the RFC 7807 problem a request failed with. Management calls,
+// stream activation and batch results return it as *APIError; match the
+// kind of failure with errors.Is against the sentinels below, or get the
+// details with errors.As.
+type APIError = apitypes.ApiError
+
+// Sentinels matching any *APIError with the same status, e.g.
+// errors.Is(err, ErrNotFound) for a missing bus or device.
+var (
+	ErrBadRequest    = &APIError{Status: 400, Title: "Bad Request"}
+	ErrUnauthorized  = &APIError{Status: 401, Title: "Unauthorized"}
+	ErrForbidden     = &APIError{Status: 403, Title: "Forbidden"}
+	ErrNotFound      = &APIError{Status: 404, Title: "Not Found"}
+	ErrConflict      = &APIError{Status: 409, Title: "Conflict"}
+	ErrUnprocessable = &APIError{Status: 422, Title: "Unprocessable Entity"}
+	ErrInternal      = &APIError{Status: 500, Title: "Internal Server Error"}
+	ErrUnavailable   = &APIError{Status: 503, Title: "Service Unavailable"}
+)
+
+// ErrServerIdentity is returned when a client pinning Config.ServerFingerprint
+// reaches a server proving another identity, or none.
+var ErrServerIdentity = auth.ErrIdentityMismatch
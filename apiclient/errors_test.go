@@ -0,0 +1,67 @@
+package apiclient_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	apiclient "github.com/Alia5/VIIPER/apiclient"
+	handler "github.com/Alia5/VIIPER/internal/server/api/handler"
+)
+
+func TestAPIErrors(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+	r := s.ApiServer.Router()
+	r.Register("features", handler.Features())
+	r.Register("bus/create", handler.BusCreate(s.UsbServer))
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/remove", handler.BusDeviceRemove(s.UsbServer))
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.Register("bus/{id}/{deviceid}/test-feedback", handler.DeviceTestFeedback(s.UsbServer, s.ApiServer))
+	require.NoError(t, s.ApiServer.Start())
+	defer func() { _ = s.UsbServer.RemoveBus(90149) }()
+
+	client := apiclient.New(s.ApiServer.Addr())
+	_, err := client.BusCreate(90149)
+	require.NoError(t, err)
+
+	dev, err := client.DeviceAdd(90149, "xbox360", nil)
+	require.NoError(t, err)
+
+	_, err = client.DeviceTestFeedbackCtx(context.Background(), 90149, dev.DevId, nil)
+	assert.ErrorIs(t, err, apiclient.ErrConflict)
+	assert.NotErrorIs(t, err, apiclient.ErrNotFound)
+	var apiErr *apiclient.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, apiclient.APIError{Status: 409, Title: "Conflict", Detail: "no stream connected to device"}, *apiErr)
+
+	_, err = client.DeviceRemoveCtx(context.Background(), 90149, "7")
+	assert.ErrorIs(t, err, apiclient.ErrNotFound)
+	assert.NotErrorIs(t, err, apiclient.ErrConflict)
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "device 7 not found on bus 90149", apiErr.Detail)
+
+	_, err = client.BusCreate(90149)
+	assert.ErrorIs(t, err, apiclient.ErrBadRequest, "the bus number is taken")
+
+	// Without a stream route the server refuses the activation line.
+	stream, added, err := client.AddDeviceAndConnect(context.Background(), 90149, "xbox360", nil)
+	assert.Nil(t, stream)
+	require.NotNil(t, added)
+	assert.ErrorIs(t, err, apiclient.ErrNotFound)
+	list, listErr := client.DevicesList(90149)
+	require.NoError(t, listErr)
+	require.Len(t, list.Devices, 1, "the device of the refused stream is removed")
+	assert.Equal(t, dev.DevId, list.Devices[0].DevId)
+
+	// Wrapping keeps the problem matchable; specific problems only match
+	// themselves.
+	assert.ErrorIs(t, errors.Join(errors.New("context"), err), apiclient.ErrNotFound)
+	assert.False(t, errors.Is(apiclient.ErrNotFound, err))
+}
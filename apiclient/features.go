@@ -0,0 +1,48 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apitypes "github.com/Alia5/VIIPER/apitypes"
+)
+
+// ErrUnsupported is returned when the server lacks a feature an operation
+// needs and there is no fallback.
+var ErrUnsupported = errors.New("not supported by the server")
+
+// Supports reports whether the server implements feature, one of the
+// Feature* constants. The feature list is fetched once per client; servers
+// that predate the "features" route support none of them.
+func (c *Client) Supports(ctx context.Context, feature string) (bool, error) {
+	c.featuresMu.Lock()
+	defer c.featuresMu.Unlock()
+	if c.features == nil {
+		resp, err := c.FeaturesCtx(ctx)
+		var apiErr *apitypes.ApiError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.Status == 404:
+			resp = &apitypes.FeaturesResponse{}
+		case err != nil:
+			return false, fmt.Errorf("query features: %w", err)
+		}
+		c.features = make(map[string]bool, len(resp.Features))
+		for _, f := range resp.Features {
+			c.features[f.Name] = true
+		}
+	}
+	return c.features[feature], nil
+}
+
+// require fails with ErrUnsupported unless the server implements feature.
+func (c *Client) require(ctx context.Context, feature string) error {
+	ok, err := c.Supports(ctx, feature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s: %w", feature, ErrUnsupported)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package apiclient_test
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	apiclient "github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/internal/server/api/auth"
+	handler "github.com/Alia5/VIIPER/internal/server/api/handler"
+)
+
+// oldServer serves pings behind the plain password handshake only, as
+// servers without an identity did, refusing other connections.
+func oldServer(t *testing.T, password string) string {
+	t.Helper()
+	key, err := auth.DeriveKey(password)
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				magic, _ := r.Peek(len(auth.HandshakeMagic))
+				if string(magic) != auth.HandshakeMagic {
+					_, _ = conn.Write([]byte(`{"status":401,"title":"Unauthorized","detail":"authentication required"}` + "\n"))
+					return
+				}
+				clientNonce, serverNonce, err := auth.HandleAuthHandshake(r, conn, key, false)
+				if err != nil {
+					return
+				}
+				secConn, err := auth.WrapConn(conn, auth.DeriveSessionKey(key, serverNonce, clientNonce))
+				if err != nil {
+					return
+				}
+				if _, err := bufio.NewReader(secConn).ReadString(0); err != nil {
+					return
+				}
+				_, _ = secConn.Write([]byte(`{"server":"VIIPER","version":"old"}` + "\n"))
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestServerFingerprint(t *testing.T) {
+	cfg := viiperTesting.TestServerConfig(t)
+	cfg.Server.ApiServerConfig.Password = "test123"
+	cfg.Server.ApiServerConfig.RequireLocalHostAuth = true
+	cfg.Server.ApiServerConfig.Tokens = []auth.Token{{ID: "pad", Secret: "pad-secret", Scopes: []string{"stream"}}}
+	s := viiperTesting.NewTestServerWithConfig(t, cfg)
+	t.Cleanup(func() { _ = s.UsbServer.Close() })
+	t.Cleanup(s.ApiServer.Close)
+	s.ApiServer.Router().Register("ping", handler.Ping(s.ApiServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	identity, err := s.ApiServer.Identity()
+	require.NoError(t, err)
+	fingerprint := identity.Fingerprint()
+	other, err := auth.NewIdentity()
+	require.NoError(t, err)
+
+	t.Run("pinned", func(t *testing.T) {
+		client := apiclient.New(s.ApiServer.Addr(), apiclient.WithPassword("test123"), apiclient.WithServerFingerprint(fingerprint))
+		ping, err := client.Ping()
+		require.NoError(t, err)
+		assert.Equal(t, fingerprint, ping.Fingerprint)
+
+		pad := apiclient.New(s.ApiServer.Addr(), apiclient.WithServerFingerprint(fingerprint)).WithToken("pad:pad-secret")
+		_, err = pad.Ping()
+		assert.NoError(t, err)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		client := apiclient.New(s.ApiServer.Addr(), apiclient.WithPassword("test123"), apiclient.WithServerFingerprint(other.Fingerprint()))
+		_, err := client.Ping()
+		assert.ErrorIs(t, err, apiclient.ErrServerIdentity)
+
+		pad := apiclient.New(s.ApiServer.Addr(), apiclient.WithServerFingerprint(other.Fingerprint())).WithToken("pad:pad-secret")
+		_, err = pad.Ping()
+		assert.ErrorIs(t, err, apiclient.ErrServerIdentity)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		client := apiclient.New(s.ApiServer.Addr(), apiclient.WithPassword("wrong"), apiclient.WithServerFingerprint(fingerprint))
+		_, err := client.Ping()
+		assert.ErrorIs(t, err, apiclient.ErrUnauthorized)
+		assert.NotErrorIs(t, err, apiclient.ErrServerIdentity)
+	})
+
+	t.Run("without credentials", func(t *testing.T) {
+		_, err := apiclient.New(s.ApiServer.Addr(), apiclient.WithServerFingerprint(fingerprint)).Ping()
+		assert.ErrorContains(t, err, "requires a password or token")
+	})
+
+	t.Run("old server", func(t *testing.T) {
+		addr := oldServer(t, "test123")
+		ping, err := apiclient.NewWithPassword(addr, "test123").Ping()
+		require.NoError(t, err)
+		assert.Equal(t, "old", ping.Version)
+		assert.Empty(t, ping.Fingerprint)
+
+		_, err = apiclient.New(addr, apiclient.WithPassword("test123"), apiclient.WithServerFingerprint(fingerprint)).Ping()
+		assert.ErrorIs(t, err, apiclient.ErrServerIdentity)
+	})
+}
@@ -0,0 +1,45 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	apitypes "github.com/Alia5/VIIPER/apitypes"
+)
+
+// RecordFor records the device stream server-side for duration d and returns
+// the finished recording (see package device/replay for its format).
+func (c *Client) RecordFor(ctx context.Context, busID uint32, devID string, d time.Duration) ([]byte, error) {
+	req := &apitypes.RecordStartRequest{MaxDurationMs: uint32(d.Milliseconds())}
+	if _, err := c.RecordStartCtx(ctx, busID, devID, req); err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		_, _ = c.RecordStop(busID, devID)
+		return nil, ctx.Err()
+	case <-time.After(d):
+	}
+	if _, err := c.RecordStopCtx(ctx, busID, devID); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for {
+		chunk, err := c.RecordDownloadCtx(ctx, busID, devID, uint64(buf.Len()))
+		if err != nil {
+			return nil, err
+		}
+		data, err := base64.StdEncoding.DecodeString(chunk.Data)
+		if err != nil {
+			return nil, fmt.Errorf("decode recording chunk: %w", err)
+		}
+		buf.Write(data)
+		if chunk.EOF || len(data) == 0 {
+			return buf.Bytes(), nil
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package apiclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	apitypes "github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api/auth"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+)
+
+// ticketMargin is how long before its expiry a ticket is no longer used, so
+// it does not expire mid handshake.
+const ticketMargin = 30 * time.Second
+
+// sessions caches per server address, password or token and pinned
+// fingerprint what authenticated connections share: the password key and the
+// resumption ticket. Transports created per call share them as well.
+var (
+	sessionsMu sync.Mutex
+	sessions   = map[sessionID]*session{}
+)
+
+type sessionID struct{ addr, password, token, fingerprint string }
+
+type session struct {
+	mu       sync.Mutex
+	key      []byte
+	ticket   []byte
+	secret   []byte
+	expires  time.Time
+	fetching bool
+	noResume bool // the server issues no tickets
+}
+
+func sessionFor(addr, password, fingerprint string) *session {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return lookupSession(sessionID{addr: addr, password: password, fingerprint: fingerprint})
+}
+
+func lookupSession(id sessionID) *session {
+	s, ok := sessions[id]
+	if !ok {
+		s = &session{}
+		sessions[id] = s
+	}
+	return s
+}
+
+// tokenSession returns the session of a token, which only caches its key.
+func tokenSession(addr, token, fingerprint string) *session {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return lookupSession(sessionID{addr: addr, token: token, fingerprint: fingerprint})
+}
+
+// passwordKey derives the password key once per session.
+func (s *session) passwordKey(password string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.key == nil {
+		key, err := auth.DeriveKey(password)
+		if err != nil {
+			return nil, err
+		}
+		s.key = key
+	}
+	return s.key, nil
+}
+
+// resumable returns the ticket to resume with, if there is one.
+func (s *session) resumable() (ticket, secret []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ticket == nil || time.Until(s.expires) < ticketMargin {
+		return nil, nil
+	}
+	return s.ticket, s.secret
+}
+
+// drop forgets ticket after the server refused it.
+func (s *session) drop(ticket []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bytes.Equal(s.ticket, ticket) {
+		s.ticket, s.secret = nil, nil
+	}
+}
+
+// wantTicket reports whether a ticket should be fetched and, if so, claims
+// the fetch.
+func (s *session) wantTicket() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.noResume || s.fetching || (s.ticket != nil && time.Until(s.expires) >= ticketMargin) {
+		return false
+	}
+	s.fetching = true
+	return true
+}
+
+func (s *session) fetched(resp *apitypes.AuthTicketResponse, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetching = false
+	if err != nil {
+		// Servers without resumption don't know the route, or turned it off.
+		var apiErr *apitypes.ApiError
+		if errors.As(err, &apiErr) && apiErr.Status == 404 {
+			s.noResume = true
+		}
+		return
+	}
+	ticket, err1 := base64.StdEncoding.DecodeString(resp.Ticket)
+	secret, err2 := base64.StdEncoding.DecodeString(resp.Secret)
+	lifetime := time.Duration(resp.ExpiresIn) * time.Second
+	if err1 != nil || err2 != nil || len(ticket) != auth.TicketSize || lifetime < 2*ticketMargin {
+		// Tickets this short lived would be fetched more often than used.
+		s.noResume = true
+		return
+	}
+	s.ticket, s.secret = ticket, secret
+	s.expires = time.Now().Add(lifetime)
+}
+
+// dial connects to the server and, with a password configured, runs the auth
+// handshake. A cached ticket skips the password proof; if resuming fails,
+// e.g. because the server restarted or the password changed, dial drops the
+// ticket and falls back to the full handshake on a new connection.
+func (t *Transport) dial(ctx context.Context) (net.Conn, error) {
+	conn, err := t.dialTCP(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if t.cfg.Token != "" {
+		return t.tokenHandshake(ctx, conn)
+	}
+	if t.cfg.Password == "" {
+		if t.cfg.ServerFingerprint != "" {
+			conn.Close()
+			return nil, errors.New("a server fingerprint requires a password or token")
+		}
+		return conn, nil
+	}
+	s := sessionFor(t.addr, t.cfg.Password, t.cfg.ServerFingerprint)
+	if ticket, secret := s.resumable(); ticket != nil && !t.cfg.DisableResume {
+		resumeConn := conn
+		secConn, err := guard(ctx, resumeConn, func() (net.Conn, error) {
+			return secure(resumeConn, t.cfg.WriteTimeout, t.cfg.ReadTimeout, func(r *bufio.Reader) ([]byte, []byte, []byte, error) {
+				clientNonce, serverNonce, err := auth.ResumeHandshake(r, resumeConn, ticket, secret)
+				return secret, clientNonce, serverNonce, err
+			})
+		})
+		if err == nil {
+			return secConn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		slog.Debug("session resumption failed, falling back to the full handshake", "error", err)
+		s.drop(ticket)
+		if conn, err = t.dialTCP(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := s.passwordKey(t.cfg.Password)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	secConn, err := guard(ctx, conn, func() (net.Conn, error) {
+		return secure(conn, t.cfg.WriteTimeout, t.cfg.ReadTimeout, func(r *bufio.Reader) ([]byte, []byte, []byte, error) {
+			if t.cfg.ServerFingerprint != "" {
+				return auth.SignedAuthHandshake(r, conn, key, t.cfg.ServerFingerprint)
+			}
+			clientNonce, serverNonce, err := auth.HandleAuthHandshake(r, conn, key, true)
+			return key, clientNonce, serverNonce, err
+		})
+	})
+	if err != nil && t.cfg.ServerFingerprint != "" {
+		return nil, unproven(err)
+	}
+	if err != nil && ctx.Err() == nil && strings.Contains(err.Error(), "read handshake response: EOF") {
+		problem := apierror.ErrUnauthorized("invalid password")
+		return nil, &problem
+	}
+	return secConn, err
+}
+
+// tokenHandshake authenticates conn with the configured token.
+func (t *Transport) tokenHandshake(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	id, secret, err := auth.ParseToken(t.cfg.Token)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	key, err := tokenSession(t.addr, t.cfg.Token, t.cfg.ServerFingerprint).passwordKey(secret)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	secConn, err := guard(ctx, conn, func() (net.Conn, error) {
+		return secure(conn, t.cfg.WriteTimeout, t.cfg.ReadTimeout, func(r *bufio.Reader) ([]byte, []byte, []byte, error) {
+			if t.cfg.ServerFingerprint != "" {
+				return auth.SignedTokenHandshake(r, conn, id, key, t.cfg.ServerFingerprint)
+			}
+			clientNonce, serverNonce, err := auth.TokenHandshake(r, conn, id, key)
+			return key, clientNonce, serverNonce, err
+		})
+	})
+	if err != nil && t.cfg.ServerFingerprint != "" {
+		return nil, unproven(err)
+	}
+	return secConn, err
+}
+
+// unproven turns the refusal of a signed handshake by a server without an
+// identity, or predating them, into an ErrServerIdentity. Wrong credentials
+// and connection failures are returned as they are.
+func unproven(err error) error {
+	var apiErr *apitypes.ApiError
+	if !errors.As(err, &apiErr) || (apiErr.Status == 401 && apiErr.Detail != "authentication required") {
+		return err
+	}
+	return fmt.Errorf("%w: the server proves no identity: %w", ErrServerIdentity, err)
+}
+
+// guard runs a handshake on conn, closing conn if ctx ends before the
+// handshake does.
+func guard(ctx context.Context, conn net.Conn, handshake func() (net.Conn, error)) (net.Conn, error) {
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	c, err := handshake()
+	if !stop() {
+		if err == nil {
+			c.Close()
+			return nil, fmt.Errorf("handshake: %w", ctx.Err())
+		}
+		return nil, interrupted(ctx, err)
+	}
+	return c, err
+}
+
+// interrupted returns err as caused by ctx if ctx has ended, which means the
+// connection err happened on was closed for it.
+func interrupted(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%w: %v", ctxErr, err)
+	}
+	return err
+}
+
+// secure runs handshake on conn and wraps conn with the session key derived
+// from the key and nonces it returns. conn is closed on failure.
+func secure(conn net.Conn, writeTimeout, readTimeout time.Duration, handshake func(r *bufio.Reader) (key, clientNonce, serverNonce []byte, err error)) (net.Conn, error) {
+	if writeTimeout > 0 {
+		_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	}
+	if readTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(readTimeout))
+	}
+	key, clientNonce, serverNonce, err := handshake(bufio.NewReader(conn))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	secConn, err := auth.WrapConn(conn, auth.DeriveSessionKey(key, serverNonce, clientNonce))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return secConn, nil
+}
+
+func (t *Transport) dialTCP(ctx context.Context) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	d := &net.Dialer{Timeout: t.cfg.DialTimeout, KeepAlive: t.cfg.KeepAlive}
+	conn, err := d.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := tcpConn.SetNoDelay(true); err != nil {
+			slog.Warn("failed to set TCP_NODELAY", "error", err)
+		}
+	}
+	if t.cfg.TLS == nil {
+		return conn, nil
+	}
+	cfg := t.cfg.TLS
+	if cfg.ServerName == "" && !cfg.InsecureSkipVerify {
+		cfg = cfg.Clone()
+		cfg.ServerName, _, _ = net.SplitHostPort(t.addr)
+	}
+	tlsConn := tls.Client(conn, cfg)
+	hsCtx := ctx
+	if t.cfg.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		hsCtx, cancel = context.WithTimeout(ctx, t.cfg.DialTimeout)
+		defer cancel()
+	}
+	if err := tlsConn.HandshakeContext(hsCtx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("tls handshake: %w", err)
+	}
+	return tlsConn, nil
+}
+
+// fetchTicket asks the server for a resumption ticket in the background
+// unless one is cached or being fetched. Servers without resumption are
+// noted and not asked again.
+func (t *Transport) fetchTicket() {
+	if t.cfg.Password == "" || t.cfg.DisableResume {
+		return
+	}
+	s := sessionFor(t.addr, t.cfg.Password, t.cfg.ServerFingerprint)
+	if !s.wantTicket() {
+		return
+	}
+	go func() {
+		var resp *apitypes.AuthTicketResponse
+		raw, err := t.DoCtx(context.Background(), "auth/ticket", nil, nil)
+		if err == nil {
+			resp, err = parse[apitypes.AuthTicketResponse](raw)
+		}
+		s.fetched(resp, err)
+	}()
+}
@@ -4,83 +4,222 @@ import (
 	"bufio"
 	"context"
 	"encoding"
+	"errors"
 	"fmt"
 	"io"
-	"log/slog"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	apitypes "github.com/Alia5/VIIPER/apitypes"
 	"github.com/Alia5/VIIPER/device"
-	"github.com/Alia5/VIIPER/internal/server/api/auth"
+	"github.com/Alia5/VIIPER/internal/server/api/frame"
 )
 
+// ErrStreamClosed is returned by operations on a closed DeviceStream.
+// It wraps net.ErrClosed.
+var ErrStreamClosed = fmt.Errorf("stream closed: %w", net.ErrClosed)
+
+// ErrReadConflict is returned when Read and StartReading are mixed on the same
+// stream: Read fails once StartReading owns the read side, and StartReading
+// reports it on its error channel while a Read is still in progress.
+var ErrReadConflict = errors.New("stream is read by both Read and StartReading")
+
 // DeviceStream represents a bidirectional connection to a device stream.
+//
+// DeviceStream implements io.ReadWriteCloser. Writes may be issued from
+// multiple goroutines; each call is sent as a unit. The read side is consumed
+// either through Read (or the adapters built on it) or through StartReading,
+// never both, see ErrReadConflict.
 type DeviceStream struct {
 	conn   net.Conn
 	BusID  uint32
 	DevID  string
-	closed bool
+	closed atomic.Bool
 
 	readCancel context.CancelFunc
+	readDone   chan struct{} // closed when the StartReading goroutine exits
+	reads      int           // Read calls in progress
 	readMu     sync.Mutex
+	writeMu    sync.Mutex
+
+	// layout is set when the stream was opened in delta-update mode.
+	layout device.WireLayout
+	// fullStates is set when delta mode was requested from a server that
+	// lacks it; WriteDelta then sends full states.
+	fullStates bool
+	// events is set when the stream was opened in event mode.
+	events bool
+	// seq is set when states carry a sequence header; lastSeq is the
+	// number of the last one, guarded by writeMu.
+	seq     bool
+	lastSeq uint32
+	// flushTimeout is set when the server flushes input on close.
+	flushTimeout time.Duration
+
+	// coalesce is the interval of a coalescing stream; writes collect in
+	// pending until flushTimer sends them. flushErr is the error of a
+	// background flush, returned by the next write. All guarded by writeMu.
+	coalesce   time.Duration
+	pending    []byte
+	flushTimer *time.Timer
+	flushErr   error
+	// noDelay is the TCP_NODELAY setting made with SetNoDelay, if any.
+	noDelay atomic.Pointer[bool]
+
+	// client, options and ack reopen the stream, see EnableAutoReconnect.
+	client  *Client
+	options string
+	ack     bool
+	// lastState is the last full state of a delta stream, replayed after a
+	// reconnect so deltas apply to it again.
+	lastState atomic.Pointer[[]byte]
 }
 
 // OpenStream connects to an existing device's stream channel.
-// The device must already exist on the bus (use DeviceAdd first).
+// The device must already exist on the bus (use DeviceAdd first). On servers
+// with FeatureStreamAck, a missing bus or device fails with ErrNotFound.
+// On servers with FeatureFlush, the stream flushes on Close, see Config.FlushTimeout.
 func (c *Client) OpenStream(ctx context.Context, busID uint32, devID string) (*DeviceStream, error) {
-	addr := c.transport.addr
+	return c.openStream(ctx, busID, devID, "")
+}
+
+// OpenDeltaStream connects to a device stream in delta-update mode.
+// layout must be the device's input wire layout (e.g. xbox360.InputLayout).
+// Use WriteDelta to send only changed fields; WriteBinary still sends full states.
+// If the server lacks FeatureDelta, a plain stream is opened and WriteDelta
+// sends full states instead.
+func (c *Client) OpenDeltaStream(ctx context.Context, busID uint32, devID string, layout device.WireLayout) (*DeviceStream, error) {
+	if len(layout) == 0 {
+		return nil, fmt.Errorf("empty wire layout")
+	}
+	ok, err := c.Supports(ctx, FeatureDelta)
+	if err != nil {
+		return nil, err
+	}
+	options := fmt.Sprintf("delta=%d", device.DeltaVersion)
+	if !ok {
+		options = ""
+	}
+	ds, err := c.openStream(ctx, busID, devID, options)
+	if err != nil {
+		return nil, err
+	}
+	ds.layout = layout
+	ds.fullStates = !ok
+	return ds, nil
+}
+
+func (c *Client) openStream(ctx context.Context, busID uint32, devID string, options string) (*DeviceStream, error) {
 	if c.transport.mock != nil {
 		return nil, fmt.Errorf("stream connections not supported with mock transport")
 	}
 
-	d := &net.Dialer{Timeout: c.transport.cfg.DialTimeout}
-	conn, err := d.DialContext(ctx, "tcp", addr)
+	ack, err := c.Supports(ctx, FeatureStreamAck)
 	if err != nil {
-		return nil, fmt.Errorf("dial: %w", err)
+		return nil, err
 	}
-
-	if tcpConn, ok := conn.(*net.TCPConn); ok {
-		if err := tcpConn.SetNoDelay(true); err != nil {
-			slog.Warn("failed to set TCP_NODELAY", "error", err)
-		}
+	if ack {
+		options = strings.TrimSpace(options + " ack=1")
 	}
 
-	if c.transport.cfg.Password != "" {
-		key, err := auth.DeriveKey(c.transport.cfg.Password)
-		if err != nil {
-			return nil, err
-		}
-		r := bufio.NewReader(conn)
-		clientNonce, serverNonce, err := auth.HandleAuthHandshake(r, conn, key, true)
+	flushTimeout := c.transport.cfg.FlushTimeout
+	if flushTimeout == 0 {
+		flushTimeout = defaultFlushTimeout
+	}
+	if flushTimeout > 0 {
+		ok, err := c.Supports(ctx, FeatureFlush)
 		if err != nil {
 			return nil, err
 		}
-		sessionKey := auth.DeriveSessionKey(key, serverNonce, clientNonce)
-		conn, err = auth.WrapConn(conn, sessionKey)
-		if err != nil {
-			conn.Close()
-			return nil, err
+		if ok {
+			options = strings.TrimSpace(options + " flush=1")
+		} else {
+			flushTimeout = 0
 		}
 	}
 
-	streamPath := fmt.Sprintf("bus/%d/%s\x00", busID, devID)
-	if _, err := conn.Write([]byte(streamPath)); err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("write stream path: %w", err)
+	conn, err := c.dialStream(ctx, busID, devID, options, ack)
+	if err != nil {
+		return nil, err
 	}
-
 	ds := &DeviceStream{
-		conn:  conn,
-		BusID: busID,
-		DevID: devID,
+		conn:         conn,
+		BusID:        busID,
+		DevID:        devID,
+		flushTimeout: max(flushTimeout, 0),
+		client:       c,
+		options:      options,
+		ack:          ack,
 	}
 	return ds, nil
 }
 
+// dialStream connects and sends the stream request with the already
+// negotiated options.
+func (c *Client) dialStream(ctx context.Context, busID uint32, devID string, options string, ack bool) (net.Conn, error) {
+	conn, err := c.transport.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	streamPath := fmt.Sprintf("bus/%d/%s", busID, devID)
+	if options != "" {
+		streamPath += " " + options
+	}
+	return guard(ctx, conn, func() (net.Conn, error) {
+		if err := c.transport.writeRequest(conn, []byte(streamPath)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("write stream path: %w", err)
+		}
+		if ack {
+			if err := c.readStreamAck(conn); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	})
+}
+
+// readStreamAck reads the server's answer to a stream request sent with
+// ack=1: an empty JSON object, or the problem the stream was refused with.
+func (c *Client) readStreamAck(conn net.Conn) error {
+	if c.transport.cfg.ReadTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(c.transport.cfg.ReadTimeout))
+		defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+	}
+	var line []byte
+	if c.transport.cfg.ProtocolVersion >= 2 {
+		body, err := frame.Read(conn)
+		if err != nil {
+			return fmt.Errorf("read stream ack: %w", err)
+		}
+		line = body
+	} else {
+		// Feedback may follow right behind the line, so don't read ahead.
+		var b [1]byte
+		for {
+			if _, err := io.ReadFull(conn, b[:]); err != nil {
+				return fmt.Errorf("read stream ack: %w", err)
+			}
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+	}
+	_, err := parse[struct{}](string(line))
+	return err
+}
+
 // AddDeviceAndConnect creates a device on the specified bus and immediately connects to its stream.
 // This is a convenience wrapper that combines DeviceAdd + OpenStream in one call.
+// If the stream cannot be opened, e.g. because the server refuses it or ctx
+// ends in between, the device is removed again and returned along with the
+// error.
 func (c *Client) AddDeviceAndConnect(ctx context.Context, busID uint32, deviceType string, o *device.CreateOptions) (*DeviceStream, *apitypes.Device, error) {
 	resp, err := c.DeviceAddCtx(ctx, busID, deviceType, o)
 	if err != nil {
@@ -89,40 +228,121 @@ func (c *Client) AddDeviceAndConnect(ctx context.Context, busID uint32, deviceTy
 
 	stream, err := c.OpenStream(ctx, busID, resp.DevId)
 	if err != nil {
+		// ctx may be what failed the stream; the configured timeouts bound
+		// the cleanup instead.
+		_, _ = c.DeviceRemoveCtx(context.WithoutCancel(ctx), busID, resp.DevId)
 		return nil, resp, err
 	}
 
 	return stream, resp, nil
 }
 
+// AddDevicesAndConnect creates the devices on the specified bus in one
+// DeviceAddBatch call and opens their streams concurrently. Streams and
+// devices are returned in the order requested. If any stream cannot be
+// opened, the others are closed, all devices removed again, and the devices
+// returned along with the first error.
+func (c *Client) AddDevicesAndConnect(ctx context.Context, busID uint32, devices []apitypes.DeviceCreateRequest) ([]*DeviceStream, []apitypes.Device, error) {
+	resp, err := c.DeviceAddBatchCtx(ctx, busID, devices)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	streams := make([]*DeviceStream, len(resp.Devices))
+	errs := make([]error, len(resp.Devices))
+	var wg sync.WaitGroup
+	for i, d := range resp.Devices {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			streams[i], errs[i] = c.OpenStream(ctx, busID, d.DevId)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		// As in AddDeviceAndConnect, ctx may be what failed the streams.
+		cleanup := context.WithoutCancel(ctx)
+		for i, d := range resp.Devices {
+			if streams[i] != nil {
+				_ = streams[i].Close()
+			}
+			_, _ = c.DeviceRemoveCtx(cleanup, busID, d.DevId)
+		}
+		return nil, resp.Devices, err
+	}
+	return streams, resp.Devices, nil
+}
+
+var _ io.ReadWriteCloser = (*DeviceStream)(nil)
+
 // Write sends raw bytes to the device stream (client → device input).
+// The bytes are sent as-is, also in delta mode.
 func (s *DeviceStream) Write(data []byte) (int, error) {
-	if s.closed {
-		return 0, fmt.Errorf("stream closed")
+	if s.closed.Load() {
+		return 0, ErrStreamClosed
 	}
-	return s.conn.Write(data)
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.send(data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
 }
 
 // WriteBinary marshals and sends a BinaryMarshaler to the device stream.
 // This is the preferred way to send device input (e.g., xbox360.InputState, keyboard.InputState).
+// On a stream opened with OpenSequencedStream it prefixes the sequence header.
 func (s *DeviceStream) WriteBinary(v encoding.BinaryMarshaler) error {
-	if s.closed {
-		return fmt.Errorf("stream closed")
+	return s.WriteBatch([]encoding.BinaryMarshaler{v})
+}
+
+// WriteDelta sends only the named wire fields (viiper:wire names, e.g. "lx") of v.
+// The stream must have been opened with OpenDeltaStream.
+func (s *DeviceStream) WriteDelta(v encoding.BinaryMarshaler, changedFields ...string) error {
+	if s.layout == nil {
+		return fmt.Errorf("stream not in delta mode")
 	}
-	data, err := v.MarshalBinary()
+	if s.fullStates {
+		return s.WriteBinary(v)
+	}
+	full, err := v.MarshalBinary()
 	if err != nil {
 		return fmt.Errorf("marshal: %w", err)
 	}
-	_, err = s.conn.Write(data)
-	return err
+	data, err := s.layout.EncodeDelta(full, changedFields...)
+	if err != nil {
+		return err
+	}
+	if _, err := s.Write(data); err != nil {
+		return err
+	}
+	s.lastState.Store(&full)
+	return nil
 }
 
 // Read receives raw bytes from the device stream (device → client feedback).
 // For event-driven reading, use StartReading() instead to avoid blocking/polling.
+// Read returns ErrReadConflict once StartReading has been called.
 func (s *DeviceStream) Read(buf []byte) (int, error) {
-	if s.closed {
-		return 0, fmt.Errorf("stream closed")
+	if s.closed.Load() {
+		return 0, ErrStreamClosed
 	}
+	s.readMu.Lock()
+	if s.readCancel != nil {
+		s.readMu.Unlock()
+		return 0, ErrReadConflict
+	}
+	s.reads++
+	s.readMu.Unlock()
+	defer func() {
+		s.readMu.Lock()
+		s.reads--
+		s.readMu.Unlock()
+	}()
 	return s.conn.Read(buf)
 }
 
@@ -140,6 +360,9 @@ func (s *DeviceStream) Read(buf []byte) (int, error) {
 //	    if err := msg.UnmarshalBinary(b[:]); err != nil { return nil, err }
 //	    return msg, nil
 //	})
+//
+// StartReading takes over the read side of the stream for good; if a Read is in
+// progress, it instead reports ErrReadConflict on the error channel.
 func (s *DeviceStream) StartReading(ctx context.Context, chSize int, decode func(r *bufio.Reader) (encoding.BinaryUnmarshaler, error)) (<-chan encoding.BinaryUnmarshaler, <-chan error) {
 	s.readMu.Lock()
 	defer s.readMu.Unlock()
@@ -151,10 +374,20 @@ func (s *DeviceStream) StartReading(ctx context.Context, chSize int, decode func
 	msgCh := make(chan encoding.BinaryUnmarshaler, chSize)
 	errCh := make(chan error, 1)
 
+	if s.reads > 0 {
+		errCh <- ErrReadConflict
+		close(errCh)
+		close(msgCh)
+		return msgCh, errCh
+	}
+
 	readCtx, cancel := context.WithCancel(ctx)
 	s.readCancel = cancel
+	done := make(chan struct{})
+	s.readDone = done
 
 	go func() {
+		defer close(done)
 		defer close(msgCh)
 		defer close(errCh)
 		defer cancel()
@@ -168,7 +401,7 @@ func (s *DeviceStream) StartReading(ctx context.Context, chSize int, decode func
 			default:
 			}
 
-			if s.closed {
+			if s.closed.Load() {
 				errCh <- io.EOF
 				return
 			}
@@ -191,6 +424,34 @@ func (s *DeviceStream) StartReading(ctx context.Context, chSize int, decode func
 	return msgCh, errCh
 }
 
+// StartReadingOutputs is StartReading with the feedback decoder registered for
+// deviceType (see device.OutputDecoder), so messages arrive as the device's
+// output type, e.g. *xbox360.XRumbleState. The device package must be imported.
+// Device types with variable-size feedback need StartReading and a decode func.
+//
+//	rumbleCh, errCh := stream.StartReadingOutputs(ctx, "xbox360", 10)
+//
+// If deviceType has no fixed-size feedback, the error is reported on the error
+// channel and the read side is left untouched.
+func (s *DeviceStream) StartReadingOutputs(ctx context.Context, deviceType string, chSize int) (<-chan encoding.BinaryUnmarshaler, <-chan error) {
+	dec, ok := device.LookupOutputDecoder(deviceType)
+	if !ok || dec.Size == 0 {
+		msgCh := make(chan encoding.BinaryUnmarshaler)
+		errCh := make(chan error, 1)
+		if !ok {
+			errCh <- fmt.Errorf("no output decoder registered for device type %q", deviceType)
+		} else {
+			errCh <- fmt.Errorf("device type %q sends no feedback", deviceType)
+		}
+		close(errCh)
+		close(msgCh)
+		return msgCh, errCh
+	}
+	return s.StartReading(ctx, chSize, func(r *bufio.Reader) (encoding.BinaryUnmarshaler, error) {
+		return dec.Decode(r)
+	})
+}
+
 // SetReadDeadline sets the read deadline for the underlying connection.
 func (s *DeviceStream) SetReadDeadline(t time.Time) error {
 	return s.conn.SetReadDeadline(t)
@@ -202,11 +463,30 @@ func (s *DeviceStream) SetWriteDeadline(t time.Time) error {
 }
 
 // Close closes the stream connection and stops any background reading.
+// Close is idempotent; afterwards Read and Write return ErrStreamClosed.
+//
+// If the stream flushes on close, Close first ends the input and waits until
+// the server has applied everything written before and closed its side, so
+// a final state (e.g. all buttons released) is visible to the host once Close
+// returns nil. Feedback arriving meanwhile is discarded. An error means the
+// server did not confirm the input within Config.FlushTimeout.
 func (s *DeviceStream) Close() error {
-	if s.closed {
+	if s.closed.Swap(true) {
 		return nil
 	}
-	s.closed = true
+	s.writeMu.Lock()
+	flushErr := s.flushPending()
+	s.coalesce = 0
+	s.writeMu.Unlock()
+	if rc, ok := s.conn.(*reconnConn); ok {
+		rc.stop()
+	}
+
+	if s.flushTimeout > 0 {
+		if err := s.flushOnClose(); flushErr == nil {
+			flushErr = err
+		}
+	}
 
 	s.readMu.Lock()
 	if s.readCancel != nil {
@@ -214,5 +494,40 @@ func (s *DeviceStream) Close() error {
 	}
 	s.readMu.Unlock()
 
-	return s.conn.Close()
+	if err := s.conn.Close(); err != nil && flushErr == nil {
+		return err
+	}
+	return flushErr
+}
+
+// flushOnClose half-closes the stream and reads until the server closes its side.
+func (s *DeviceStream) flushOnClose() error {
+	s.writeMu.Lock()
+	cw, ok := s.conn.(interface{ CloseWrite() error })
+	var err error
+	if ok {
+		err = cw.CloseWrite()
+	}
+	s.writeMu.Unlock()
+	if !ok || errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+
+	if err := s.conn.SetReadDeadline(time.Now().Add(s.flushTimeout)); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	s.readMu.Lock()
+	cancel, done := s.readCancel, s.readDone
+	s.readMu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+	if _, err := io.Copy(io.Discard, s.conn); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	return nil
 }
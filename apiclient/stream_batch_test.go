@@ -0,0 +1,240 @@
+package apiclient_test
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/apiclient"
+)
+
+// sinkServer answers the features request of a client like a server without
+// any, and hands each device stream's reads to onRead.
+type sinkServer struct {
+	addr  string
+	reads atomic.Int64
+}
+
+func startSinkServer(t testing.TB, onRead func(stream string, data []byte)) *sinkServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	s := &sinkServer{addr: ln.Addr().String()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, onRead)
+		}
+	}()
+	return s
+}
+
+func (s *sinkServer) serve(conn net.Conn, onRead func(stream string, data []byte)) {
+	defer conn.Close()
+	var req []byte
+	var b [1]byte
+	for {
+		if _, err := conn.Read(b[:]); err != nil {
+			return
+		}
+		if b[0] == 0 {
+			break
+		}
+		req = append(req, b[0])
+	}
+	if string(req) == "features" {
+		_, _ = io.WriteString(conn, `{"features":[]}`+"\n")
+		return
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			s.reads.Add(1)
+			onRead(string(req), slices.Clone(buf[:n]))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// rawState is a 4-byte input state.
+type rawState [4]byte
+
+func (r rawState) MarshalBinary() ([]byte, error) { return r[:], nil }
+
+func TestDeviceStreamBatching(t *testing.T) {
+	var mu sync.Mutex
+	got := map[string][][]byte{}
+	received := func(stream string) [][]byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(got[stream])
+	}
+	sink := startSinkServer(t, func(stream string, data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		got[stream] = append(got[stream], data)
+	})
+	open := func(t *testing.T, devID string) *apiclient.DeviceStream {
+		stream, err := apiclient.New(sink.addr).OpenStream(context.Background(), 1, devID)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = stream.Close() })
+		return stream
+	}
+	joined := func(stream string) []byte { return bytes.Join(received(stream), nil) }
+
+	t.Run("batch is one write", func(t *testing.T) {
+		stream := open(t, "1")
+		require.NoError(t, stream.WriteBatch([]encoding.BinaryMarshaler{rawState{1}, rawState{2}, rawState{3}}))
+		require.Eventually(t, func() bool { return len(joined("bus/1/1")) == 12 }, time.Second, time.Millisecond)
+		assert.Equal(t, [][]byte{{1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0}}, received("bus/1/1"))
+		assert.NoError(t, stream.WriteBatch(nil))
+	})
+
+	t.Run("coalescing waits for the interval", func(t *testing.T) {
+		stream := open(t, "2")
+		require.NoError(t, stream.SetCoalescing(200*time.Millisecond))
+		start := time.Now()
+		for i := range 5 {
+			require.NoError(t, stream.WriteBinary(rawState{byte(i)}))
+		}
+		n, err := stream.Write([]byte{9})
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		time.Sleep(50 * time.Millisecond)
+		assert.Empty(t, received("bus/1/2"), "buffered")
+		require.Eventually(t, func() bool { return len(joined("bus/1/2")) == 21 }, time.Second, time.Millisecond)
+		assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+		assert.Len(t, received("bus/1/2"), 1, "one write")
+	})
+
+	t.Run("flush and close send the buffer", func(t *testing.T) {
+		stream := open(t, "3")
+		require.NoError(t, stream.SetCoalescing(time.Hour))
+		require.NoError(t, stream.WriteBinary(rawState{1}))
+		require.NoError(t, stream.Flush())
+		require.Eventually(t, func() bool { return len(joined("bus/1/3")) == 4 }, time.Second, time.Millisecond)
+		require.NoError(t, stream.WriteBinary(rawState{2}))
+		require.NoError(t, stream.Close())
+		require.Eventually(t, func() bool { return len(joined("bus/1/3")) == 8 }, time.Second, time.Millisecond)
+		assert.ErrorIs(t, stream.Flush(), apiclient.ErrStreamClosed)
+	})
+
+	t.Run("size threshold", func(t *testing.T) {
+		stream := open(t, "4")
+		require.NoError(t, stream.SetCoalescing(time.Hour))
+		for range 400 {
+			require.NoError(t, stream.WriteBinary(rawState{7}))
+		}
+		require.Eventually(t, func() bool { return len(joined("bus/1/4")) >= 1000 }, time.Second, time.Millisecond)
+		assert.Less(t, len(joined("bus/1/4")), 1600, "the rest waits")
+		require.NoError(t, stream.SetCoalescing(0))
+		require.Eventually(t, func() bool { return len(joined("bus/1/4")) == 1600 }, time.Second, time.Millisecond)
+	})
+
+	t.Run("no delay", func(t *testing.T) {
+		stream := open(t, "5")
+		assert.NoError(t, stream.SetNoDelay(false))
+		assert.NoError(t, stream.SetNoDelay(true))
+	})
+}
+
+// stampedState carries the time it was marshaled at.
+type stampedState struct{}
+
+var benchEpoch = time.Now()
+
+func (stampedState) MarshalBinary() ([]byte, error) {
+	return binary.LittleEndian.AppendUint64(nil, uint64(time.Since(benchEpoch))), nil
+}
+
+// BenchmarkDeviceStreamFeeders feeds 8 streams at 1 kHz each and reports the
+// reads the server needs per state, a proxy for the syscalls and packets on
+// both ends, and the p99 latency from marshaling a state to its arrival.
+func BenchmarkDeviceStreamFeeders(b *testing.B) {
+	const devices = 8
+	modes := []struct {
+		name  string
+		setup func(s *apiclient.DeviceStream) error
+	}{
+		{"write-each", func(*apiclient.DeviceStream) error { return nil }},
+		{"nagle", func(s *apiclient.DeviceStream) error { return s.SetNoDelay(false) }},
+		{"coalesce-1ms", func(s *apiclient.DeviceStream) error { return s.SetCoalescing(time.Millisecond) }},
+		{"coalesce-4ms", func(s *apiclient.DeviceStream) error { return s.SetCoalescing(4 * time.Millisecond) }},
+	}
+	for _, mode := range modes {
+		b.Run(mode.name, func(b *testing.B) {
+			var mu sync.Mutex
+			var latencies []time.Duration
+			partial := map[string][]byte{}
+			sink := startSinkServer(b, func(stream string, data []byte) {
+				now := time.Since(benchEpoch)
+				mu.Lock()
+				defer mu.Unlock()
+				buf := append(partial[stream], data...)
+				for ; len(buf) >= 8; buf = buf[8:] {
+					latencies = append(latencies, now-time.Duration(binary.LittleEndian.Uint64(buf)))
+				}
+				partial[stream] = buf
+			})
+			client := apiclient.New(sink.addr)
+			streams := make([]*apiclient.DeviceStream, devices)
+			for i := range streams {
+				s, err := client.OpenStream(context.Background(), 1, fmt.Sprint(i+1))
+				require.NoError(b, err)
+				require.NoError(b, mode.setup(s))
+				streams[i] = s
+			}
+
+			b.ResetTimer()
+			var wg sync.WaitGroup
+			for _, s := range streams {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					tick := time.NewTicker(time.Millisecond)
+					defer tick.Stop()
+					for range b.N {
+						<-tick.C
+						if err := s.WriteBinary(stampedState{}); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			b.StopTimer()
+			for _, s := range streams {
+				require.NoError(b, s.Close())
+			}
+			require.Eventually(b, func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(latencies) == devices*b.N
+			}, 5*time.Second, time.Millisecond)
+
+			slices.Sort(latencies)
+			b.ReportMetric(float64(sink.reads.Load())/float64(devices*b.N), "reads/state")
+			b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds()), "p99-µs")
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package apiclient
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Alia5/VIIPER/device"
+)
+
+// OpenEventStream connects to a device stream in event mode: the client sends
+// press/release events and the server keeps the input state, releasing
+// everything when the stream ends. Only some device types support it, and it
+// fails with ErrUnsupported if the server lacks FeatureEvents.
+func (c *Client) OpenEventStream(ctx context.Context, busID uint32, devID string) (*DeviceStream, error) {
+	if err := c.require(ctx, FeatureEvents); err != nil {
+		return nil, err
+	}
+	ds, err := c.openStream(ctx, busID, devID, fmt.Sprintf("events=%d", device.EventVersion))
+	if err != nil {
+		return nil, err
+	}
+	ds.events = true
+	return ds, nil
+}
+
+// WriteEvent sends one input event, e.g. device.EventPress with a key code.
+// The stream must have been opened with OpenEventStream.
+func (s *DeviceStream) WriteEvent(typ, code uint8) error {
+	if !s.events {
+		return fmt.Errorf("stream not in event mode")
+	}
+	_, err := s.Write([]byte{typ, code})
+	return err
+}
+
+// KeyboardStream is an event-mode stream to a keyboard device.
+// Keys are HID usage codes (keyboard.KeyA, ...); keyboard.KeyLeftCtrl through
+// keyboard.KeyRightGUI press and release modifiers.
+type KeyboardStream struct {
+	*DeviceStream
+}
+
+// OpenKeyboardStream connects to a keyboard device stream in event mode.
+func (c *Client) OpenKeyboardStream(ctx context.Context, busID uint32, devID string) (*KeyboardStream, error) {
+	ds, err := c.OpenEventStream(ctx, busID, devID)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyboardStream{ds}, nil
+}
+
+// KeyDown presses key. Presses beyond keyboard.MaxHeldKeys are dropped by the server.
+func (k *KeyboardStream) KeyDown(key uint8) error { return k.WriteEvent(device.EventPress, key) }
+
+// KeyUp releases key.
+func (k *KeyboardStream) KeyUp(key uint8) error { return k.WriteEvent(device.EventRelease, key) }
+
+// ReleaseAll releases every key and modifier.
+func (k *KeyboardStream) ReleaseAll() error { return k.WriteEvent(device.EventReleaseAll, 0) }
@@ -0,0 +1,53 @@
+package apiclient
+
+import "io"
+
+type streamReader struct{ s *DeviceStream }
+
+func (r streamReader) Read(p []byte) (int, error) { return r.s.Read(p) }
+
+type streamWriter struct{ s *DeviceStream }
+
+func (w streamWriter) Write(p []byte) (int, error) { return w.s.Write(p) }
+
+// Split returns the read and write halves of the stream for callers that hand
+// them to different components. The halves follow the Read and Write
+// semantics of the stream; closing the stream ends both.
+func (s *DeviceStream) Split() (io.Reader, io.Writer) {
+	return streamReader{s}, streamWriter{s}
+}
+
+// PacketConn is a message-oriented view of a DeviceStream for devices whose
+// feedback messages have a fixed size.
+type PacketConn struct {
+	s   *DeviceStream
+	buf []byte
+}
+
+// NewPacketConn wraps s. packetSize is the size of the device's feedback
+// messages, e.g. 2 for xbox360 rumble. It panics if packetSize is not positive.
+func NewPacketConn(s *DeviceStream, packetSize int) *PacketConn {
+	if packetSize <= 0 {
+		panic("apiclient: non-positive packet size")
+	}
+	return &PacketConn{s: s, buf: make([]byte, packetSize)}
+}
+
+// ReadPacket blocks until the next complete feedback message arrives.
+// The returned slice is only valid until the next call. A stream ending
+// mid-message yields io.ErrUnexpectedEOF.
+func (c *PacketConn) ReadPacket() ([]byte, error) {
+	if _, err := io.ReadFull(c.s, c.buf); err != nil {
+		return nil, err
+	}
+	return c.buf, nil
+}
+
+// WritePacket sends p as one input message.
+func (c *PacketConn) WritePacket(p []byte) error {
+	_, err := c.s.Write(p)
+	return err
+}
+
+// Close closes the underlying stream.
+func (c *PacketConn) Close() error { return c.s.Close() }
@@ -0,0 +1,23 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// OpenMixedStream connects to the stream of a device with the mixed stream
+// policy, claiming the named fields of its input wire layout (e.g. "lx" and
+// "ly" of xbox360.InputLayout). Only those fields are taken from the full
+// states written; they return to neutral when the stream closes. A field
+// another stream owns fails with a 409 *apitypes.ApiError, and the call
+// fails with ErrUnsupported if the server lacks FeatureStreamMixing.
+func (c *Client) OpenMixedStream(ctx context.Context, busID uint32, devID string, fields ...string) (*DeviceStream, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("no fields to claim")
+	}
+	if err := c.require(ctx, FeatureStreamMixing); err != nil {
+		return nil, err
+	}
+	return c.openStream(ctx, busID, devID, "claim="+strings.Join(fields, ","))
+}
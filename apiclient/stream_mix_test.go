@@ -0,0 +1,111 @@
+package apiclient_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	apiclient "github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	handler "github.com/Alia5/VIIPER/internal/server/api/handler"
+)
+
+func TestMixedStreams(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+	r := s.ApiServer.Router()
+	r.Register("features", handler.Features())
+	r.Register("bus/create", handler.BusCreate(s.UsbServer))
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+	defer func() { _ = s.UsbServer.RemoveBus(90184) }()
+
+	ctx := context.Background()
+	client := apiclient.New(s.ApiServer.Addr())
+	_, err := client.BusCreate(90184)
+	require.NoError(t, err)
+	typ, policy := "xbox360", "mixed"
+	dev, err := client.DeviceAddWith(90184, apitypes.DeviceCreateRequest{Type: &typ, StreamPolicy: &policy})
+	require.NoError(t, err)
+	assert.Equal(t, "mixed", dev.StreamPolicy)
+
+	usbip := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := usbip.AttachDevice("90184-1")
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+	// waitReport polls the host side until it reports want.
+	waitReport := func(want xbox360.InputState) {
+		t.Helper()
+		var got []byte
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+			got, err = usbip.ReadInputReport(imp.Conn)
+			require.NoError(t, err)
+			if assert.ObjectsAreEqual(want.BuildReport(), got) {
+				return
+			}
+		}
+		assert.Equal(t, want.BuildReport(), got)
+	}
+
+	_, err = client.OpenStream(ctx, 90184, "1")
+	assert.ErrorIs(t, err, apiclient.ErrBadRequest, "streams of a mixed device must claim fields")
+
+	sticks, err := client.OpenMixedStream(ctx, 90184, "1", "lx", "ly", "rx", "ry")
+	require.NoError(t, err)
+	defer sticks.Close()
+	buttons, err := client.OpenMixedStream(ctx, 90184, "1", "buttons", "lt", "rt")
+	require.NoError(t, err)
+
+	_, err = client.OpenMixedStream(ctx, 90184, "1", "lt")
+	assert.ErrorIs(t, err, apiclient.ErrConflict)
+
+	list, err := client.DevicesList(90184)
+	require.NoError(t, err)
+	require.Len(t, list.Devices, 1)
+	claims := list.Devices[0].Claims
+	require.Len(t, claims, 2)
+	assert.Equal(t, []string{"buttons", "lt", "rt"}, claims[0].Fields)
+	assert.Equal(t, []string{"lx", "ly", "rx", "ry"}, claims[1].Fields)
+	assert.NotEqual(t, claims[0].Source, claims[1].Source)
+
+	// Each stream sends whole states; the fields it does not own are ignored.
+	done := make(chan error, 1)
+	go func() {
+		done <- buttons.WriteBinary(&xbox360.InputState{Buttons: xbox360.ButtonA, LT: 200, LX: -1})
+	}()
+	require.NoError(t, sticks.WriteBinary(&xbox360.InputState{Buttons: xbox360.ButtonB, LX: 1000, RY: -2000}))
+	require.NoError(t, <-done)
+	waitReport(xbox360.InputState{Buttons: xbox360.ButtonA, LT: 200, LX: 1000, RY: -2000})
+
+	require.NoError(t, buttons.Close())
+	waitReport(xbox360.InputState{LX: 1000, RY: -2000})
+
+	list, err = client.DevicesList(90184)
+	require.NoError(t, err)
+	require.Len(t, list.Devices[0].Claims, 1)
+	assert.Equal(t, []string{"lx", "ly", "rx", "ry"}, list.Devices[0].Claims[0].Fields)
+
+	// The released fields are free again.
+	buttons, err = client.OpenMixedStream(ctx, 90184, "1", "buttons")
+	require.NoError(t, err)
+	defer buttons.Close()
+	require.NoError(t, buttons.WriteBinary(&xbox360.InputState{Buttons: xbox360.ButtonY}))
+	waitReport(xbox360.InputState{Buttons: xbox360.ButtonY, LX: 1000, RY: -2000})
+
+	t.Run("single policy", func(t *testing.T) {
+		plain, err := client.DeviceAdd(90184, "xbox360", nil)
+		require.NoError(t, err)
+		assert.Empty(t, plain.StreamPolicy)
+		_, err = client.OpenMixedStream(ctx, 90184, plain.DevId, "lx")
+		assert.ErrorIs(t, err, apiclient.ErrBadRequest)
+	})
+}
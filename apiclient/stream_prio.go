@@ -0,0 +1,38 @@
+package apiclient
+
+import (
+	"context"
+	"fmt"
+)
+
+// StreamPriority orders the USB/IP work of a streamed device against that of
+// other devices on the server, see OpenPriorityStream.
+type StreamPriority string
+
+const (
+	StreamPriorityLow    StreamPriority = "low"
+	StreamPriorityNormal StreamPriority = "normal"
+	StreamPriorityHigh   StreamPriority = "high"
+)
+
+// OpenPriorityStream connects to a device stream whose device keeps prio
+// while the stream is open: the server serves and answers the URBs of lower
+// priority devices after those of higher ones. Tokens cap the priority at
+// their maxPriority; DeviceStats reports the one in effect. If the server
+// lacks FeatureStreamPriority, a plain stream is opened.
+func (c *Client) OpenPriorityStream(ctx context.Context, busID uint32, devID string, prio StreamPriority) (*DeviceStream, error) {
+	switch prio {
+	case StreamPriorityLow, StreamPriorityNormal, StreamPriorityHigh:
+	default:
+		return nil, fmt.Errorf("unknown stream priority %q", prio)
+	}
+	ok, err := c.Supports(ctx, FeatureStreamPriority)
+	if err != nil {
+		return nil, err
+	}
+	options := "prio=" + string(prio)
+	if !ok {
+		options = ""
+	}
+	return c.openStream(ctx, busID, devID, options)
+}
@@ -0,0 +1,401 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	apitypes "github.com/Alia5/VIIPER/apitypes"
+)
+
+// ErrReconnecting is returned by writes to a stream whose connection dropped
+// while it is being reopened, see ReconnectPolicy.WriteWait.
+var ErrReconnecting = errors.New("stream is reconnecting")
+
+// StreamStatus is the connection state of a DeviceStream.
+type StreamStatus int
+
+const (
+	StreamConnected StreamStatus = iota
+	// StreamReconnecting means the connection dropped and is being reopened.
+	StreamReconnecting
+	// StreamFailed means reconnecting was given up; the stream stays unusable.
+	StreamFailed
+	StreamClosed
+)
+
+func (s StreamStatus) String() string {
+	switch s {
+	case StreamConnected:
+		return "connected"
+	case StreamReconnecting:
+		return "reconnecting"
+	case StreamFailed:
+		return "failed"
+	case StreamClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("StreamStatus(%d)", int(s))
+}
+
+// ReconnectPolicy configures EnableAutoReconnect.
+type ReconnectPolicy struct {
+	// InitialDelay is the wait before the first attempt; it doubles after
+	// every failed attempt up to MaxDelay. Defaults: 100ms and 2s.
+	//
+	// The server removes a device whose stream stays away longer than its
+	// device handler timeout (5s by default), so keep MaxDelay well below it.
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	// MaxAttempts gives up after that many failed attempts in a row; zero
+	// retries until the stream is closed.
+	MaxAttempts int
+	// WriteWait is how long writes block while reconnecting before they
+	// fail with ErrReconnecting. Zero fails them right away.
+	WriteWait time.Duration
+	// OnStatus, if set, is called on every status change with the error that
+	// caused it. It runs on the reconnecting goroutine and must not block.
+	OnStatus func(status StreamStatus, err error)
+}
+
+// EnableAutoReconnect makes the stream reopen its connection when it drops,
+// e.g. on a network blip. Reads wait for the new connection, so StartReading
+// keeps delivering on the same channels; a feedback message cut off by the
+// drop may be lost. Writes follow policy.WriteWait. Delta streams resend
+// their last full state first, and event streams start with everything
+// released, as the server releases held input when a stream ends.
+//
+// On servers with FeatureStreamAck, reconnecting stops for good when the
+// server refuses the stream, e.g. because the device was removed or the server
+// restarted; Status then reports StreamFailed and reads and writes return the
+// error.
+//
+// Call it before reading from the stream.
+func (s *DeviceStream) EnableAutoReconnect(policy ReconnectPolicy) error {
+	if s.client == nil {
+		return errors.New("stream cannot be reopened")
+	}
+	if policy.InitialDelay <= 0 {
+		policy.InitialDelay = 100 * time.Millisecond
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = 2 * time.Second
+	}
+	policy.MaxDelay = max(policy.MaxDelay, policy.InitialDelay)
+
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+	if s.readCancel != nil || s.reads > 0 {
+		return errors.New("EnableAutoReconnect called after reading started")
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.closed.Load() {
+		return ErrStreamClosed
+	}
+	if _, ok := s.conn.(*reconnConn); ok {
+		return errors.New("auto reconnect already enabled")
+	}
+	s.conn = newReconnConn(s.conn, policy, s.reopen)
+	return nil
+}
+
+// Status reports the connection state of the stream.
+func (s *DeviceStream) Status() StreamStatus {
+	if s.closed.Load() {
+		return StreamClosed
+	}
+	if rc, ok := s.conn.(*reconnConn); ok {
+		return rc.status()
+	}
+	return StreamConnected
+}
+
+// reopen dials the stream again and restores what the server does not keep
+// across connections.
+func (s *DeviceStream) reopen(ctx context.Context) (net.Conn, error) {
+	conn, err := s.client.dialStream(ctx, s.BusID, s.DevID, s.options, s.ack)
+	if err != nil {
+		return nil, err
+	}
+	if noDelay := s.noDelay.Load(); noDelay != nil {
+		_ = setNoDelay(conn, *noDelay)
+	}
+	if last := s.lastState.Load(); last != nil {
+		if _, err := conn.Write(append(s.layout.FullMask(), *last...)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("resend state: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// reconnConn is a net.Conn that replaces its connection when it fails.
+// While reconnecting, conn is nil and ready is open.
+type reconnConn struct {
+	policy ReconnectPolicy
+	dial   func(ctx context.Context) (net.Conn, error)
+	ctx    context.Context
+	cancel context.CancelFunc
+	local  net.Addr
+	remote net.Addr
+
+	mu            sync.Mutex
+	conn          net.Conn
+	ready         chan struct{}
+	err           error // set once reconnecting failed
+	stopped       bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newReconnConn(conn net.Conn, policy ReconnectPolicy, dial func(ctx context.Context) (net.Conn, error)) *reconnConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	ready := make(chan struct{})
+	close(ready)
+	return &reconnConn{
+		policy: policy,
+		dial:   dial,
+		ctx:    ctx,
+		cancel: cancel,
+		local:  conn.LocalAddr(),
+		remote: conn.RemoteAddr(),
+		conn:   conn,
+		ready:  ready,
+	}
+}
+
+// current returns the live connection, or nil and a channel closed once
+// reconnecting ends.
+func (c *reconnConn) current() (net.Conn, <-chan struct{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.err != nil:
+		return nil, nil, c.err
+	case c.stopped && c.conn == nil:
+		return nil, nil, ErrStreamClosed
+	}
+	return c.conn, c.ready, nil
+}
+
+func (c *reconnConn) status() StreamStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.err != nil:
+		return StreamFailed
+	case c.conn == nil:
+		return StreamReconnecting
+	}
+	return StreamConnected
+}
+
+func (c *reconnConn) notify(status StreamStatus, err error) {
+	if c.policy.OnStatus != nil {
+		c.policy.OnStatus(status, err)
+	}
+}
+
+// lost starts reconnecting unless conn was already replaced or the stream
+// is closing, in which case errors are passed through.
+func (c *reconnConn) lost(conn net.Conn, cause error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return false
+	}
+	if c.conn != conn {
+		return true
+	}
+	_ = conn.Close()
+	c.conn = nil
+	c.ready = make(chan struct{})
+	go c.reconnect(c.ready, cause)
+	return true
+}
+
+func (c *reconnConn) reconnect(ready chan struct{}, cause error) {
+	c.notify(StreamReconnecting, cause)
+	delay := c.policy.InitialDelay
+	for attempt := 1; ; attempt++ {
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-c.ctx.Done():
+			t.Stop()
+			return
+		}
+
+		conn, err := c.dial(c.ctx)
+		if err == nil {
+			c.mu.Lock()
+			if c.stopped {
+				c.mu.Unlock()
+				conn.Close()
+				return
+			}
+			_ = conn.SetReadDeadline(c.readDeadline)
+			_ = conn.SetWriteDeadline(c.writeDeadline)
+			c.conn = conn
+			close(ready)
+			c.mu.Unlock()
+			c.notify(StreamConnected, nil)
+			return
+		}
+
+		var apiErr *apitypes.ApiError
+		if errors.As(err, &apiErr) || (c.policy.MaxAttempts > 0 && attempt >= c.policy.MaxAttempts) {
+			c.mu.Lock()
+			if c.stopped {
+				c.mu.Unlock()
+				return
+			}
+			c.err = fmt.Errorf("reconnect: %w", err)
+			close(ready)
+			c.mu.Unlock()
+			c.notify(StreamFailed, err)
+			return
+		}
+		delay = min(2*delay, c.policy.MaxDelay)
+	}
+}
+
+// stop ends reconnecting; from then on errors of the current connection are
+// returned as they are.
+func (c *reconnConn) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return
+	}
+	c.stopped = true
+	c.cancel()
+	if c.conn == nil && c.err == nil {
+		close(c.ready)
+	}
+}
+
+func (c *reconnConn) Read(p []byte) (int, error) {
+	for {
+		conn, ready, err := c.current()
+		if err != nil {
+			return 0, err
+		}
+		if conn == nil {
+			if err := c.wait(ready, c.deadline(&c.readDeadline)); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		n, err := conn.Read(p)
+		if n > 0 || err == nil {
+			return n, nil
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) || !c.lost(conn, err) {
+			return 0, err
+		}
+	}
+}
+
+func (c *reconnConn) Write(p []byte) (int, error) {
+	var waitUntil time.Time
+	for {
+		conn, ready, err := c.current()
+		if err != nil {
+			return 0, err
+		}
+		if conn == nil {
+			if c.policy.WriteWait <= 0 {
+				return 0, ErrReconnecting
+			}
+			if waitUntil.IsZero() {
+				waitUntil = time.Now().Add(c.policy.WriteWait)
+			}
+			if err := c.wait(ready, waitUntil); err != nil {
+				return 0, ErrReconnecting
+			}
+			continue
+		}
+		n, err := conn.Write(p)
+		if err == nil || errors.Is(err, os.ErrDeadlineExceeded) || !c.lost(conn, err) {
+			return n, err
+		}
+	}
+}
+
+func (c *reconnConn) deadline(t *time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *t
+}
+
+// wait blocks until ready is closed or the deadline, if any, passes.
+func (c *reconnConn) wait(ready <-chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-ready
+		return nil
+	}
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+	select {
+	case <-ready:
+		return nil
+	case <-t.C:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (c *reconnConn) Close() error {
+	c.stop()
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+// CloseWrite half-closes the current connection, for flushing on Close.
+func (c *reconnConn) CloseWrite() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	cw, ok := conn.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return cw.CloseWrite()
+}
+
+func (c *reconnConn) LocalAddr() net.Addr  { return c.local }
+func (c *reconnConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *reconnConn) SetDeadline(t time.Time) error {
+	return errors.Join(c.SetReadDeadline(t), c.SetWriteDeadline(t))
+}
+
+func (c *reconnConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *reconnConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,31 @@
+package apiclient
+
+import (
+	"context"
+)
+
+// OpenSequencedStream connects to a device stream whose full states carry a
+// sequence number and the local monotonic time they were sent at, so the
+// server can report their latency in DeviceStats. WriteBinary adds the
+// header; Write sends bytes as-is. Only device types with an input wire
+// layout support it, and it fails with ErrUnsupported if the server lacks
+// FeatureInputLatency.
+func (c *Client) OpenSequencedStream(ctx context.Context, busID uint32, devID string) (*DeviceStream, error) {
+	if err := c.require(ctx, FeatureInputLatency); err != nil {
+		return nil, err
+	}
+	ds, err := c.openStream(ctx, busID, devID, "seq=1")
+	if err != nil {
+		return nil, err
+	}
+	ds.seq = true
+	return ds, nil
+}
+
+// Seq returns the sequence number of the last state sent on a sequenced
+// stream. Timestamps are on the clock TimeSync converts from.
+func (s *DeviceStream) Seq() uint32 {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.lastSeq
+}
@@ -3,11 +3,14 @@ package apiclient_test
 import (
 	"bufio"
 	"context"
+	"encoding"
 	"encoding/json"
 	"errors"
+	"io"
 	"log/slog"
 	"net"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -161,7 +164,9 @@ func TestDeviceStream_Operations(t *testing.T) {
 						return nil
 					},
 				)
+				orig := api.GetRegistration("xbox360")
 				api.RegisterDevice("xbox360", testReg)
+				defer api.RegisterDevice("xbox360", orig)
 			}
 			r.Register("bus/{id}/add", handler.BusDeviceAdd(usbSrv, apiSrv))
 			r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(usbSrv))
@@ -223,6 +228,11 @@ func TestEncryptedStream(t *testing.T) {
 		if err != nil {
 			return
 		}
+		if line == "features\x00" || line == "auth/ticket\x00" {
+			// A server from before the features and auth/ticket routes.
+			_, _ = secureConn.Write([]byte(`{"status":404,"title":"Not Found","detail":"unknown path"}` + "\n"))
+			return
+		}
 
 		assert.Equal(t, "bus/1/1\x00", line)
 	}
@@ -266,11 +276,13 @@ func TestEncryptedStream(t *testing.T) {
 			defer ln.Close()
 
 			go func() {
-				conn, err := ln.Accept()
-				if err != nil {
-					return
+				for {
+					conn, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					go tc.serverHandler(t, conn)
 				}
-				tc.serverHandler(t, conn)
 			}()
 
 			cfg := &apiclient.Config{
@@ -294,3 +306,283 @@ func TestEncryptedStream(t *testing.T) {
 		})
 	}
 }
+
+// startEchoServer serves a bus of xbox360 devices whose stream handler echoes
+// every input byte back as feedback. register adds routes before the start.
+func startEchoServer(t *testing.T, busID uint32, register func(r *api.Router)) (string, *virtualbus.VirtualBus) {
+	t.Helper()
+	orig := api.GetRegistration("xbox360")
+	api.RegisterDevice("xbox360", htesting.CreateMockRegistration(t, "xbox360",
+		func(o *device.CreateOptions) (pusb.Device, error) { return xbox360.New(o) },
+		func(conn net.Conn, devPtr *pusb.Device, l *slog.Logger) error {
+			_, _ = io.Copy(conn, conn)
+			return nil
+		},
+	))
+	t.Cleanup(func() { api.RegisterDevice("xbox360", orig) })
+
+	usbSrv := usb.New(usb.ServerConfig{Addr: "127.0.0.1:0"}, slog.Default(), log.NewRaw(nil))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	apiSrv := api.New(usbSrv, addr, api.ServerConfig{Addr: addr, DeviceHandlerConnectTimeout: time.Second}, slog.Default())
+	r := apiSrv.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(usbSrv, apiSrv))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(usbSrv))
+	if register != nil {
+		register(r)
+	}
+	require.NoError(t, apiSrv.Start())
+	t.Cleanup(apiSrv.Close)
+
+	b, err := virtualbus.NewWithBusId(busID)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+	require.NoError(t, usbSrv.AddBus(b))
+	return addr, b
+}
+
+// openEchoStream opens a stream to a device of startEchoServer.
+func openEchoStream(t *testing.T, busID uint32) *apiclient.DeviceStream {
+	t.Helper()
+	addr, _ := startEchoServer(t, busID, nil)
+	stream, _, err := apiclient.New(addr).AddDeviceAndConnect(context.Background(), busID, "xbox360", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = stream.Close() })
+	return stream
+}
+
+func TestDeviceStream_IO(t *testing.T) {
+	t.Run("StartReading owns the read side", func(t *testing.T) {
+		stream := openEchoStream(t, 203)
+		msgCh, _ := stream.StartReading(context.Background(), 1, func(r *bufio.Reader) (encoding.BinaryUnmarshaler, error) {
+			var b [2]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return nil, err
+			}
+			msg := new(xbox360.XRumbleState)
+			return msg, msg.UnmarshalBinary(b[:])
+		})
+		_, err := stream.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, apiclient.ErrReadConflict)
+
+		_, err = stream.Write([]byte{1, 2})
+		require.NoError(t, err)
+		select {
+		case msg := <-msgCh:
+			assert.Equal(t, &xbox360.XRumbleState{LeftMotor: 1, RightMotor: 2}, msg)
+		case <-time.After(time.Second):
+			t.Fatal("no message")
+		}
+	})
+
+	t.Run("StartReading during Read", func(t *testing.T) {
+		stream := openEchoStream(t, 204)
+		require.NoError(t, stream.SetReadDeadline(time.Now().Add(300*time.Millisecond)))
+		readDone := make(chan error, 1)
+		go func() {
+			_, err := stream.Read(make([]byte, 1))
+			readDone <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		msgCh, errCh := stream.StartReading(context.Background(), 1, func(r *bufio.Reader) (encoding.BinaryUnmarshaler, error) {
+			return nil, errors.New("unreachable")
+		})
+		assert.ErrorIs(t, <-errCh, apiclient.ErrReadConflict)
+		_, ok := <-msgCh
+		assert.False(t, ok)
+		var ne net.Error
+		require.ErrorAs(t, <-readDone, &ne)
+		assert.True(t, ne.Timeout())
+	})
+
+	t.Run("StartReadingOutputs decodes the output type", func(t *testing.T) {
+		stream := openEchoStream(t, 208)
+		msgCh, _ := stream.StartReadingOutputs(context.Background(), "XBOX360", 2)
+		_, err := stream.Write([]byte{3, 4, 5, 6})
+		require.NoError(t, err)
+		for _, want := range []*xbox360.XRumbleState{{LeftMotor: 3, RightMotor: 4}, {LeftMotor: 5, RightMotor: 6}} {
+			select {
+			case msg := <-msgCh:
+				assert.Equal(t, want, msg)
+			case <-time.After(time.Second):
+				t.Fatal("no message")
+			}
+		}
+	})
+
+	t.Run("StartReadingOutputs without decoder", func(t *testing.T) {
+		stream := openEchoStream(t, 209)
+		_, errCh := stream.StartReadingOutputs(context.Background(), "unknown", 1)
+		assert.ErrorContains(t, <-errCh, `no output decoder registered for device type "unknown"`)
+
+		// The read side is still free.
+		require.NoError(t, stream.SetReadDeadline(time.Now().Add(time.Second)))
+		_, err := stream.Write([]byte{1})
+		require.NoError(t, err)
+		_, err = stream.Read(make([]byte, 1))
+		assert.NoError(t, err)
+	})
+
+	t.Run("write deadline", func(t *testing.T) {
+		stream := openEchoStream(t, 205)
+		require.NoError(t, stream.SetWriteDeadline(time.Now().Add(-time.Millisecond)))
+		_, err := stream.Write([]byte{1})
+		var ne net.Error
+		require.ErrorAs(t, err, &ne)
+		assert.True(t, ne.Timeout())
+
+		require.NoError(t, stream.SetWriteDeadline(time.Time{}))
+		_, err = stream.Write([]byte{1})
+		assert.NoError(t, err)
+	})
+
+	t.Run("split halves with a line scanner", func(t *testing.T) {
+		stream := openEchoStream(t, 206)
+		r, w := stream.Split()
+		_, err := io.WriteString(w, "hello\nworld\n")
+		require.NoError(t, err)
+
+		require.NoError(t, stream.SetReadDeadline(time.Now().Add(time.Second)))
+		sc := bufio.NewScanner(r)
+		var lines []string
+		for len(lines) < 2 && sc.Scan() {
+			lines = append(lines, sc.Text())
+		}
+		require.NoError(t, sc.Err())
+		assert.Equal(t, []string{"hello", "world"}, lines)
+	})
+
+	t.Run("packet conn", func(t *testing.T) {
+		stream := openEchoStream(t, 207)
+		pc := apiclient.NewPacketConn(stream, 2)
+		require.NoError(t, pc.WritePacket([]byte{1, 2, 3}))
+		require.NoError(t, pc.WritePacket([]byte{4}))
+		require.NoError(t, stream.SetReadDeadline(time.Now().Add(time.Second)))
+		for _, want := range [][]byte{{1, 2}, {3, 4}} {
+			got, err := pc.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		}
+
+		require.NoError(t, pc.Close())
+		_, err := pc.ReadPacket()
+		assert.ErrorIs(t, err, apiclient.ErrStreamClosed)
+		assert.ErrorIs(t, pc.WritePacket([]byte{1, 2}), net.ErrClosed)
+	})
+}
+
+// dropProxy forwards connections to a server and cuts them on demand.
+type dropProxy struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func startDropProxy(t *testing.T, target string) *dropProxy {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	p := &dropProxy{ln: ln}
+	t.Cleanup(func() {
+		_ = ln.Close()
+		p.drop()
+	})
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			up, err := net.Dial("tcp", target)
+			if err != nil {
+				_ = c.Close()
+				continue
+			}
+			p.mu.Lock()
+			p.conns = append(p.conns, c, up)
+			p.mu.Unlock()
+			go func() { _, _ = io.Copy(up, c); _ = up.Close() }()
+			go func() { _, _ = io.Copy(c, up); _ = c.Close() }()
+		}
+	}()
+	return p
+}
+
+func (p *dropProxy) drop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		_ = c.Close()
+	}
+	p.conns = nil
+}
+
+func TestDeviceStream_AutoReconnect(t *testing.T) {
+	addr, b := startEchoServer(t, 205, func(r *api.Router) { r.Register("features", handler.Features()) })
+	proxy := startDropProxy(t, addr)
+	ctx := context.Background()
+
+	stream, dev, err := apiclient.New(proxy.ln.Addr().String()).AddDeviceAndConnect(ctx, 205, "xbox360", nil)
+	require.NoError(t, err)
+	defer stream.Close()
+	statuses := make(chan apiclient.StreamStatus, 8)
+	require.NoError(t, stream.EnableAutoReconnect(apiclient.ReconnectPolicy{
+		InitialDelay: 10 * time.Millisecond,
+		MaxDelay:     20 * time.Millisecond,
+		WriteWait:    time.Second,
+		OnStatus:     func(st apiclient.StreamStatus, _ error) { statuses <- st },
+	}))
+	nextStatus := func() apiclient.StreamStatus {
+		select {
+		case st := <-statuses:
+			return st
+		case <-time.After(2 * time.Second):
+			t.Fatal("no status change")
+			return 0
+		}
+	}
+
+	msgCh, _ := stream.StartReading(ctx, 1, func(r *bufio.Reader) (encoding.BinaryUnmarshaler, error) {
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		msg := new(xbox360.XRumbleState)
+		return msg, msg.UnmarshalBinary(b[:])
+	})
+	echo := func(v byte) {
+		t.Helper()
+		_, err := stream.Write([]byte{v, v})
+		require.NoError(t, err)
+		select {
+		case msg := <-msgCh:
+			assert.Equal(t, &xbox360.XRumbleState{LeftMotor: v, RightMotor: v}, msg)
+		case <-time.After(time.Second):
+			t.Fatal("no echo")
+		}
+	}
+
+	echo(1)
+	proxy.drop()
+	assert.Equal(t, apiclient.StreamReconnecting, nextStatus())
+	assert.Equal(t, apiclient.StreamConnected, nextStatus())
+	echo(2)
+	assert.Equal(t, apiclient.StreamConnected, stream.Status())
+
+	// The device is gone after a server restart; reconnecting gives up.
+	require.NoError(t, b.RemoveDeviceByID(dev.DevId))
+	proxy.drop()
+	assert.Equal(t, apiclient.StreamReconnecting, nextStatus())
+	assert.Equal(t, apiclient.StreamFailed, nextStatus())
+	assert.Equal(t, apiclient.StreamFailed, stream.Status())
+	_, err = stream.Write([]byte{3, 3})
+	var apiErr *apitypes.ApiError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, 404, apiErr.Status)
+
+	require.NoError(t, stream.Close())
+	assert.Equal(t, apiclient.StreamClosed, stream.Status())
+}
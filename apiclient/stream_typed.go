@@ -0,0 +1,40 @@
+package apiclient
+
+import (
+	"bufio"
+	"context"
+	"encoding"
+	"io"
+)
+
+// ReadMessages is StartReading for fixed-size messages of one type, e.g. the
+// feedback of a device: every message is size bytes decoded into a new *T.
+// The generated device clients (e.g. xbox360client) use it for their Outputs.
+func ReadMessages[T any, P interface {
+	*T
+	encoding.BinaryUnmarshaler
+}](ctx context.Context, s *DeviceStream, size, chSize int) (<-chan *T, <-chan error) {
+	msgCh, errCh := s.StartReading(ctx, chSize, func(r *bufio.Reader) (encoding.BinaryUnmarshaler, error) {
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		msg := P(new(T))
+		if err := msg.UnmarshalBinary(buf); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	})
+	out := make(chan *T, chSize)
+	go func() {
+		defer close(out)
+		for msg := range msgCh {
+			select {
+			case out <- (*T)(msg.(P)):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, errCh
+}
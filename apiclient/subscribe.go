@@ -0,0 +1,92 @@
+package apiclient
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+
+	apitypes "github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api/frame"
+)
+
+// eventQueue is the number of events buffered for the receiver of
+// SubscribeEvents.
+const eventQueue = 64
+
+// SubscribeEvents subscribes to the bus and device events of the server
+// (FeatureLifecycleEvents). It returns once the server confirmed the
+// subscription, so no later change is missed. Events arrive in order until
+// ctx ends or the subscription fails; the error channel then yields the failure, if any, and
+// both channels are closed. A receiver that falls behind makes the server
+// drop the oldest events, see apitypes.Event.Dropped.
+func (c *Client) SubscribeEvents(ctx context.Context) (<-chan apitypes.Event, <-chan error) {
+	events := make(chan apitypes.Event, eventQueue)
+	errs := make(chan error, 1)
+	ready := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(errs)
+		defer close(events)
+		if err := c.subscribeEvents(ctx, events, ready); err != nil && ctx.Err() == nil {
+			errs <- err
+		}
+	}()
+	select {
+	case <-ready:
+	case <-done:
+	}
+	return events, errs
+}
+
+func (c *Client) subscribeEvents(ctx context.Context, events chan<- apitypes.Event, ready chan<- struct{}) error {
+	if c.transport.mock != nil {
+		return fmt.Errorf("event subscriptions not supported with mock transport")
+	}
+	conn, err := c.transport.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	if err := c.transport.writeRequest(conn, []byte("events")); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	next := func() (string, error) {
+		if c.transport.cfg.ProtocolVersion >= 2 {
+			body, err := frame.Read(br)
+			return string(body), err
+		}
+		line, err := br.ReadString('\n')
+		return strings.TrimSuffix(line, "\n"), err
+	}
+
+	// The server confirms the subscription with an empty object.
+	line, err := next()
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	if _, err := parse[struct{}](line); err != nil {
+		return err
+	}
+	close(ready)
+	for {
+		line, err := next()
+		if err != nil {
+			return fmt.Errorf("read: %w", err)
+		}
+		ev, err := parse[apitypes.Event](line)
+		if err != nil {
+			return err
+		}
+		select {
+		case events <- *ev:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package apiclient
+
+import (
+	"context"
+	"fmt"
+
+	apitypes "github.com/Alia5/VIIPER/apitypes"
+)
+
+// DeviceAddFromTemplate adds a device configured by the named server-side
+// template. Fields set in overrides replace those of the template; deviceSpecific
+// is merged per key. The returned Device reflects the resolved configuration.
+func (c *Client) DeviceAddFromTemplate(busID uint32, template string, overrides *apitypes.DeviceDefaults) (*apitypes.Device, error) {
+	return c.DeviceAddFromTemplateCtx(context.Background(), busID, template, overrides)
+}
+
+// DeviceAddFromTemplateCtx is the context-aware version of DeviceAddFromTemplate.
+func (c *Client) DeviceAddFromTemplateCtx(ctx context.Context, busID uint32, template string, overrides *apitypes.DeviceDefaults) (*apitypes.Device, error) {
+	pathParams := map[string]string{"id": fmt.Sprintf("%d", busID)}
+	req := apitypes.DeviceCreateRequest{Template: &template, Overrides: overrides}
+	raw, err := c.transport.DoCtx(ctx, "bus/{id}/add", req, pathParams)
+	if err != nil {
+		return nil, err
+	}
+	return parse[apitypes.Device](raw)
+}
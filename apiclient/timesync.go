@@ -0,0 +1,45 @@
+package apiclient
+
+import (
+	"context"
+	"time"
+)
+
+// localEpoch anchors local monotonic readings; time.Since uses the
+// monotonic clock, so wall-clock steps do not affect them.
+var localEpoch = time.Now()
+
+// TimeSync relates server monotonic timestamps (apitypes "...MonoNs" fields)
+// to local time.
+type TimeSync struct {
+	// Offset is the server monotonic time minus the local monotonic time.
+	Offset time.Duration
+	// RTT is the round trip of the exchange; conversions are accurate to
+	// within half of it.
+	RTT time.Duration
+}
+
+// TimeOffset measures the offset of the server's monotonic clock with a
+// single exchange on the "time" route, like a one-shot NTP query. Repeat it
+// and keep the result with the smallest RTT for better accuracy.
+func (c *Client) TimeOffset(ctx context.Context) (TimeSync, error) {
+	sent := time.Since(localEpoch)
+	resp, err := c.TimeSyncCtx(ctx, int64(sent))
+	received := time.Since(localEpoch)
+	if err != nil {
+		return TimeSync{}, err
+	}
+	rtt := received - sent
+	// Assume the server read its clock halfway through the round trip.
+	return TimeSync{Offset: time.Duration(resp.ServerMonoNs) - (sent + rtt/2), RTT: rtt}, nil
+}
+
+// ToLocal converts a server monotonic timestamp to local time.
+func (ts TimeSync) ToLocal(serverMonoNs int64) time.Time {
+	return localEpoch.Add(time.Duration(serverMonoNs) - ts.Offset)
+}
+
+// ToServer converts a local time to a server monotonic timestamp.
+func (ts TimeSync) ToServer(t time.Time) int64 {
+	return int64(t.Sub(localEpoch) + ts.Offset)
+}
@@ -0,0 +1,151 @@
+package apiclient_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	apiclient "github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/device/keyboard"
+	api "github.com/Alia5/VIIPER/internal/server/api"
+	handler "github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// selfSignedCert writes a certificate for localhost and 127.0.0.1 and its
+// key to dir and returns the pool trusting it.
+func selfSignedCert(t *testing.T, dir string) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "viiper test"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	pool = x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(certPEM))
+	return certFile, keyFile, pool
+}
+
+func TestTLS(t *testing.T) {
+	certFile, keyFile, pool := selfSignedCert(t, t.TempDir())
+
+	start := func(t *testing.T, busID uint32, password string) *viiperTesting.MockServer {
+		t.Helper()
+		cfg := viiperTesting.TestServerConfig(t)
+		cfg.Server.ApiServerConfig.TLSCert = certFile
+		cfg.Server.ApiServerConfig.TLSKey = keyFile
+		cfg.Server.ApiServerConfig.Password = password
+		cfg.Server.ApiServerConfig.RequireLocalHostAuth = password != ""
+		s := viiperTesting.NewTestServerWithConfig(t, cfg)
+		t.Cleanup(func() { _ = s.UsbServer.Close() })
+		t.Cleanup(s.ApiServer.Close)
+
+		r := s.ApiServer.Router()
+		r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+		r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+		require.NoError(t, s.ApiServer.Start())
+
+		b, err := virtualbus.NewWithBusId(busID)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = b.Close() })
+		require.NoError(t, s.UsbServer.AddBus(b))
+		return s
+	}
+	// writeInput streams a state and checks a host attached over USBIP sees it.
+	writeInput := func(t *testing.T, s *viiperTesting.MockServer, client *apiclient.Client, busID uint32) {
+		t.Helper()
+		stream, added, err := client.AddDeviceAndConnect(context.Background(), busID, "keyboard", nil)
+		require.NoError(t, err)
+		defer stream.Close()
+
+		usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+		imp, err := usbipClient.AttachDevice(fmt.Sprintf("%d-%s", busID, added.DevId))
+		require.NoError(t, err)
+		defer imp.Conn.Close()
+
+		state := keyboard.PressKey(keyboard.KeyA)
+		require.NoError(t, stream.WriteBinary(&state))
+		want := state.BuildReport()
+		got, err := usbipClient.PollInputReport(imp.Conn, want, 750*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	t.Run("device stream", func(t *testing.T) {
+		s := start(t, 90156, "")
+		client := apiclient.New(s.ApiServer.Addr()).WithTLS(&tls.Config{RootCAs: pool})
+		writeInput(t, s, client, 90156)
+	})
+
+	t.Run("password inside TLS", func(t *testing.T) {
+		s := start(t, 90157, "test123")
+		client := apiclient.NewWithPassword(s.ApiServer.Addr(), "test123").WithTLS(&tls.Config{RootCAs: pool})
+		writeInput(t, s, client, 90157)
+	})
+
+	t.Run("server name", func(t *testing.T) {
+		s := start(t, 90158, "")
+		_, port, err := net.SplitHostPort(s.ApiServer.Addr())
+		require.NoError(t, err)
+		client := apiclient.New(net.JoinHostPort("127.0.0.1", port)).WithTLS(&tls.Config{RootCAs: pool, ServerName: "localhost"})
+		_, err = client.DeviceAdd(90158, "keyboard", nil)
+		require.NoError(t, err)
+
+		client = apiclient.New(s.ApiServer.Addr()).WithTLS(&tls.Config{RootCAs: pool, ServerName: "viiper.example"})
+		_, err = client.DeviceAdd(90158, "keyboard", nil)
+		assert.ErrorContains(t, err, "tls handshake")
+	})
+
+	t.Run("untrusted certificate", func(t *testing.T) {
+		s := start(t, 90159, "")
+		_, err := apiclient.New(s.ApiServer.Addr()).WithTLS(&tls.Config{}).DeviceAdd(90159, "keyboard", nil)
+		assert.ErrorContains(t, err, "tls handshake")
+
+		_, err = apiclient.New(s.ApiServer.Addr()).DeviceAdd(90159, "keyboard", nil)
+		assert.Error(t, err, "plain client on a TLS listener")
+	})
+
+	t.Run("incomplete config", func(t *testing.T) {
+		cfg := viiperTesting.TestServerConfig(t)
+		cfg.Server.ApiServerConfig.TLSCert = certFile
+		s := viiperTesting.NewTestServerWithConfig(t, cfg)
+		defer s.UsbServer.Close()
+		assert.Error(t, s.ApiServer.Start())
+	})
+}
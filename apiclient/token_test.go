@@ -0,0 +1,134 @@
+package apiclient_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	apiclient "github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/device/keyboard"
+	api "github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/auth"
+	handler "github.com/Alia5/VIIPER/internal/server/api/handler"
+)
+
+func TestTokenScopes(t *testing.T) {
+	cfg := viiperTesting.TestServerConfig(t)
+	cfg.Server.ApiServerConfig.Password = "test123"
+	cfg.Server.ApiServerConfig.RequireLocalHostAuth = true
+	cfg.Server.ApiServerConfig.Tokens = []auth.Token{
+		{ID: "pad", Secret: "pad-secret", Scopes: []string{"device:add:keyboard", "device:remove", "stream"}},
+		{ID: "fast", Secret: "fast-secret", Scopes: []string{"device:add:keyboard", "stream"}, MaxPriority: "high"},
+	}
+	s := viiperTesting.NewTestServerWithConfig(t, cfg)
+	t.Cleanup(func() { _ = s.UsbServer.Close() })
+	t.Cleanup(s.ApiServer.Close)
+
+	r := s.ApiServer.Router()
+	r.Register("features", handler.Features())
+	r.Register("bus/create", handler.BusCreate(s.UsbServer), api.Mutating)
+	r.Register("bus/remove", handler.BusRemove(s.UsbServer), api.Mutating)
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer), api.Mutating)
+	r.Register("bus/{id}/remove", handler.BusDeviceRemove(s.UsbServer), api.Mutating)
+	r.Register("bus/{id}/{deviceid}/record/download", handler.DeviceRecordDownload(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/{deviceid}/stats", handler.DeviceStats(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	const busID = 90160
+	admin := apiclient.NewWithPassword(s.ApiServer.Addr(), "test123")
+	_, err := admin.BusCreate(busID)
+	require.NoError(t, err)
+	pad := apiclient.New(s.ApiServer.Addr()).WithToken("pad:pad-secret")
+
+	t.Run("streams to its own device", func(t *testing.T) {
+		stream, added, err := pad.AddDeviceAndConnect(context.Background(), busID, "keyboard", nil)
+		require.NoError(t, err)
+		defer stream.Close()
+
+		usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+		imp, err := usbipClient.AttachDevice(fmt.Sprintf("%d-%s", busID, added.DevId))
+		require.NoError(t, err)
+		defer imp.Conn.Close()
+
+		state := keyboard.PressKey(keyboard.KeyA)
+		require.NoError(t, stream.WriteBinary(&state))
+		want := state.BuildReport()
+		got, err := usbipClient.PollInputReport(imp.Conn, want, 750*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("cannot remove buses", func(t *testing.T) {
+		_, err := pad.BusRemove(busID)
+		assert.ErrorIs(t, err, apiclient.ErrForbidden)
+		_, err = pad.BusCreate(busID + 1)
+		assert.ErrorIs(t, err, apiclient.ErrForbidden)
+	})
+
+	t.Run("cannot add other device types", func(t *testing.T) {
+		_, err := pad.DeviceAdd(busID, "mouse", nil)
+		assert.ErrorIs(t, err, apiclient.ErrForbidden)
+	})
+
+	t.Run("cannot touch devices of others", func(t *testing.T) {
+		other, err := admin.DeviceAdd(busID, "keyboard", nil)
+		require.NoError(t, err)
+
+		_, err = pad.OpenStream(context.Background(), busID, other.DevId)
+		assert.ErrorIs(t, err, apiclient.ErrForbidden)
+		_, err = pad.DeviceRemove(busID, other.DevId)
+		assert.ErrorIs(t, err, apiclient.ErrForbidden)
+		_, err = pad.RecordDownload(busID, other.DevId, 0)
+		assert.ErrorIs(t, err, apiclient.ErrForbidden, "recordings are captured data")
+
+		own, err := pad.DeviceAdd(busID, "keyboard", nil)
+		require.NoError(t, err)
+		_, err = pad.RecordDownload(busID, own.DevId, 0)
+		assert.ErrorIs(t, err, apiclient.ErrNotFound, "nothing recorded yet")
+		_, err = pad.DeviceRemove(busID, own.DevId)
+		assert.NoError(t, err)
+	})
+
+	t.Run("stream priority capped", func(t *testing.T) {
+		for _, tc := range []struct {
+			client *apiclient.Client
+			want   string
+		}{
+			{pad, "normal"},
+			{apiclient.New(s.ApiServer.Addr()).WithToken("fast:fast-secret"), "high"},
+		} {
+			added, err := tc.client.DeviceAdd(busID, "keyboard", nil)
+			require.NoError(t, err)
+			stream, err := tc.client.OpenPriorityStream(context.Background(), busID, added.DevId, apiclient.StreamPriorityHigh)
+			require.NoError(t, err)
+			stats, err := tc.client.DeviceStats(busID, added.DevId)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, stats.Priority)
+			require.NoError(t, stream.Close())
+		}
+	})
+
+	t.Run("reads are open", func(t *testing.T) {
+		_, err := pad.DevicesList(busID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		_, err := apiclient.New(s.ApiServer.Addr()).WithToken("pad:wrong").DevicesList(busID)
+		assert.ErrorIs(t, err, apiclient.ErrUnauthorized)
+	})
+
+	t.Run("password mode unchanged", func(t *testing.T) {
+		_, err := apiclient.New(s.ApiServer.Addr()).DevicesList(busID)
+		assert.ErrorIs(t, err, apiclient.ErrUnauthorized)
+		_, err = admin.BusRemove(busID)
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,370 @@
+package apiclient
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	apitypes "github.com/Alia5/VIIPER/apitypes"
+)
+
+const (
+	defaultTopologyResync = time.Minute
+	defaultTopologyRetry  = 5 * time.Second
+)
+
+// TopologyCacheConfig tunes a TopologyCache. Zero fields take the defaults.
+type TopologyCacheConfig struct {
+	// ResyncInterval is how often the whole topology is listed again while
+	// the event stream is up. Labels change without an event, so this bounds
+	// how long a relabel goes unseen. Default 1 minute; negative only
+	// resyncs on a gap in the events.
+	ResyncInterval time.Duration
+	// RetryInterval is how often the topology is listed and the event stream
+	// resubscribed while the stream is down, or the server sends no
+	// lifecycle events, bounding how stale the cache gets then. Default 5
+	// seconds.
+	RetryInterval time.Duration
+}
+
+// Topology is the buses of a server and their devices, in the order the
+// server lists them.
+type Topology struct {
+	Buses []TopologyBus `json:"buses"`
+	// SyncedAt is when the topology was last listed in full.
+	SyncedAt time.Time `json:"syncedAt"`
+}
+
+type TopologyBus struct {
+	apitypes.BusInfo
+	Devices []TopologyDevice `json:"devices"`
+}
+
+// TopologyDevice is a device of a TopologyBus. Attached is set while a
+// USB/IP host has it imported.
+type TopologyDevice struct {
+	apitypes.Device
+	Attached bool `json:"attached"`
+}
+
+// TopologyCache keeps the buses and devices of a server in memory, for tools
+// that render them repeatedly without polling the list routes. It lists them
+// once, then follows the lifecycle events (FeatureLifecycleEvents), and
+// lists them again when an event was missed, when the stream drops and every
+// ResyncInterval. It is safe for concurrent use.
+type TopologyCache struct {
+	c   *Client
+	cfg TopologyCacheConfig
+
+	mu       sync.RWMutex
+	topo     Topology
+	watchers []chan struct{}
+	stopped  bool
+}
+
+// NewTopologyCache lists the topology of the server and keeps it up to date
+// until ctx ends. It fails if the first listing does.
+func (c *Client) NewTopologyCache(ctx context.Context, cfg TopologyCacheConfig) (*TopologyCache, error) {
+	if cfg.ResyncInterval == 0 {
+		cfg.ResyncInterval = defaultTopologyResync
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = defaultTopologyRetry
+	}
+	tc := &TopologyCache{c: c, cfg: cfg}
+	// Subscribed before listing, so no change falls in between.
+	events := tc.subscribe(ctx)
+	if err := tc.resync(ctx); err != nil {
+		return nil, err
+	}
+	go tc.run(ctx, events)
+	return tc, nil
+}
+
+// Snapshot returns a copy of the cached topology.
+func (tc *TopologyCache) Snapshot() Topology {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	topo := Topology{Buses: make([]TopologyBus, len(tc.topo.Buses)), SyncedAt: tc.topo.SyncedAt}
+	for i, b := range tc.topo.Buses {
+		topo.Buses[i] = b
+		topo.Buses[i].Devices = slices.Clone(b.Devices)
+	}
+	return topo
+}
+
+// Bus returns the cached bus busID.
+func (tc *TopologyCache) Bus(busID uint32) (TopologyBus, bool) {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	i := tc.busIndexLocked(busID)
+	if i < 0 {
+		return TopologyBus{}, false
+	}
+	b := tc.topo.Buses[i]
+	b.Devices = slices.Clone(b.Devices)
+	return b, true
+}
+
+// Device returns the cached device devID of bus busID.
+func (tc *TopologyCache) Device(busID uint32, devID string) (TopologyDevice, bool) {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	i := tc.busIndexLocked(busID)
+	if i < 0 {
+		return TopologyDevice{}, false
+	}
+	for _, d := range tc.topo.Buses[i].Devices {
+		if d.DevId == devID {
+			return d, true
+		}
+	}
+	return TopologyDevice{}, false
+}
+
+// Changes returns a channel that receives a value after the cache changed.
+// Values do not queue up: a receiver that is busy gets one for any number of
+// changes. The channel is closed once ctx of NewTopologyCache ends.
+func (tc *TopologyCache) Changes() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.stopped {
+		close(ch)
+		return ch
+	}
+	tc.watchers = append(tc.watchers, ch)
+	return ch
+}
+
+func (tc *TopologyCache) run(ctx context.Context, events <-chan apitypes.Event) {
+	defer tc.stop()
+	for {
+		if events != nil {
+			tc.follow(ctx, events)
+		}
+		// The stream ended, or the server has none: poll until it is back.
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(tc.cfg.RetryInterval):
+		}
+		events = tc.subscribe(ctx)
+		_ = tc.resync(ctx) // stays stale until the next attempt
+	}
+}
+
+// subscribe returns the lifecycle events of the server, nil if it sends
+// none. The channel is closed when the stream drops.
+func (tc *TopologyCache) subscribe(ctx context.Context) <-chan apitypes.Event {
+	if ok, _ := tc.c.Supports(ctx, FeatureLifecycleEvents); !ok {
+		return nil
+	}
+	events, _ := tc.c.SubscribeEvents(ctx)
+	return events
+}
+
+// follow applies events until the stream or ctx ends. A gap in the sequence
+// numbers, or events the server dropped, trigger a resync.
+func (tc *TopologyCache) follow(ctx context.Context, events <-chan apitypes.Event) {
+	var tick <-chan time.Time
+	if tc.cfg.ResyncInterval > 0 {
+		t := time.NewTicker(tc.cfg.ResyncInterval)
+		defer t.Stop()
+		tick = t.C
+	}
+	var last uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			_ = tc.resync(ctx)
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			// Servers predating sequence numbers send 0.
+			gap := ev.Dropped > 0 || last != 0 && ev.Seq != 0 && ev.Seq != last+1
+			last = ev.Seq
+			if gap || tc.apply(ctx, ev) != nil {
+				_ = tc.resync(ctx)
+			}
+		}
+	}
+}
+
+// apply updates the cache for ev. Buses and devices that appear are listed
+// for their details.
+func (tc *TopologyCache) apply(ctx context.Context, ev apitypes.Event) error {
+	switch ev.Type {
+	case "BusCreated", "DeviceAdded":
+		return tc.refreshBus(ctx, ev.BusID)
+	case "BusRemoved":
+		tc.update(func() {
+			tc.topo.Buses = slices.DeleteFunc(tc.topo.Buses, func(b TopologyBus) bool { return b.BusID == ev.BusID })
+		})
+	case "DeviceRemoved":
+		tc.update(func() {
+			if i := tc.busIndexLocked(ev.BusID); i >= 0 {
+				b := &tc.topo.Buses[i]
+				b.Devices = slices.DeleteFunc(b.Devices, func(d TopologyDevice) bool { return d.DevId == ev.DevId })
+				b.DeviceCount = len(b.Devices)
+			}
+		})
+	case "DeviceAttached", "DeviceDetached":
+		tc.update(func() {
+			if i := tc.busIndexLocked(ev.BusID); i >= 0 {
+				b := &tc.topo.Buses[i]
+				for j := range b.Devices {
+					if b.Devices[j].DevId == ev.DevId {
+						b.Devices[j].Attached = ev.Type == "DeviceAttached"
+					}
+				}
+			}
+		})
+	}
+	return nil
+}
+
+// refreshBus lists bus busID again, keeping the attachment of its devices,
+// which events report.
+func (tc *TopologyCache) refreshBus(ctx context.Context, busID uint32) error {
+	batch := tc.c.NewBatch()
+	busList := batch.BusList()
+	devList := batch.DevicesList(busID)
+	if _, err := batch.ExecuteCtx(ctx); err != nil {
+		return err
+	}
+	buses, err := busList.Result()
+	if err != nil {
+		return err
+	}
+	devs, err := devList.Result()
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	info, found := apitypes.BusInfo{}, false
+	if err == nil {
+		for _, b := range buses.BusInfo {
+			if b.BusID == busID {
+				info, found = b, true
+			}
+		}
+	}
+	tc.update(func() {
+		i := tc.busIndexLocked(busID)
+		if !found {
+			// Removed meanwhile; its event follows.
+			if i >= 0 {
+				tc.topo.Buses = slices.Delete(tc.topo.Buses, i, i+1)
+			}
+			return
+		}
+		attached := map[string]bool{}
+		if i >= 0 {
+			for _, d := range tc.topo.Buses[i].Devices {
+				attached[d.DevId] = d.Attached
+			}
+		}
+		bus := TopologyBus{BusInfo: info, Devices: make([]TopologyDevice, 0, len(devs.Devices))}
+		for _, d := range devs.Devices {
+			bus.Devices = append(bus.Devices, TopologyDevice{Device: d, Attached: attached[d.DevId]})
+		}
+		if i >= 0 {
+			tc.topo.Buses[i] = bus
+			return
+		}
+		tc.topo.Buses = append(tc.topo.Buses, bus)
+		slices.SortFunc(tc.topo.Buses, func(a, b TopologyBus) int { return cmp.Compare(a.BusID, b.BusID) })
+	})
+	return nil
+}
+
+// resync lists the whole topology: the buses, their devices and whether a
+// host has them imported.
+func (tc *TopologyCache) resync(ctx context.Context) error {
+	buses, err := tc.c.BusListCtx(ctx)
+	if err != nil {
+		return err
+	}
+	topo := Topology{Buses: make([]TopologyBus, 0, len(buses.BusInfo))}
+
+	lists := tc.c.NewBatch()
+	devLists := make([]*BatchCall[apitypes.DevicesListResponse], len(buses.BusInfo))
+	for i, b := range buses.BusInfo {
+		devLists[i] = lists.DevicesList(b.BusID)
+	}
+	if lists.Len() > 0 {
+		if _, err := lists.ExecuteCtx(ctx); err != nil {
+			return err
+		}
+	}
+	statuses := tc.c.NewBatch()
+	var calls [][]*BatchCall[apitypes.DeviceStatusResponse]
+	for i, b := range buses.BusInfo {
+		devs, err := devLists[i].Result()
+		if errors.Is(err, ErrNotFound) {
+			continue // removed since it was listed
+		}
+		if err != nil {
+			return fmt.Errorf("list bus %d: %w", b.BusID, err)
+		}
+		bus := TopologyBus{BusInfo: b, Devices: make([]TopologyDevice, len(devs.Devices))}
+		busCalls := make([]*BatchCall[apitypes.DeviceStatusResponse], len(devs.Devices))
+		for j, d := range devs.Devices {
+			bus.Devices[j].Device = d
+			busCalls[j] = statuses.DeviceStatus(b.BusID, d.DevId)
+		}
+		topo.Buses = append(topo.Buses, bus)
+		calls = append(calls, busCalls)
+	}
+	if statuses.Len() > 0 {
+		if _, err := statuses.ExecuteCtx(ctx); err != nil {
+			return err
+		}
+	}
+	for i := range topo.Buses {
+		for j := range topo.Buses[i].Devices {
+			// A device removed since it was listed has no status; its
+			// event follows.
+			if st, err := calls[i][j].Result(); err == nil {
+				topo.Buses[i].Devices[j].Attached = st.Attached
+			}
+		}
+	}
+	topo.SyncedAt = time.Now()
+	tc.update(func() { tc.topo = topo })
+	return nil
+}
+
+// update runs f under the lock and notifies the watchers.
+func (tc *TopologyCache) update(f func()) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	f()
+	for _, ch := range tc.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (tc *TopologyCache) stop() {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.stopped = true
+	for _, ch := range tc.watchers {
+		close(ch)
+	}
+	tc.watchers = nil
+}
+
+func (tc *TopologyCache) busIndexLocked(busID uint32) int {
+	return slices.IndexFunc(tc.topo.Buses, func(b TopologyBus) bool { return b.BusID == busID })
+}
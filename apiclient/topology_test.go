@@ -0,0 +1,246 @@
+package apiclient_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	apiclient "github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	"github.com/Alia5/VIIPER/internal/cmd"
+)
+
+// eventProxy forwards API connections to a server. On event subscriptions
+// it can drop events, leaving a gap in their sequence, and cut the stream.
+type eventProxy struct {
+	addr string
+	drop atomic.Int32 // events still to drop
+
+	mu      sync.Mutex
+	streams []net.Conn
+}
+
+func startEventProxy(t *testing.T, target string) *eventProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	p := &eventProxy{addr: ln.Addr().String()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn, target)
+		}
+	}()
+	return p
+}
+
+func (p *eventProxy) serve(conn net.Conn, target string) {
+	defer conn.Close()
+	up, err := net.Dial("tcp", target)
+	if err != nil {
+		return
+	}
+	defer up.Close()
+	br := bufio.NewReader(conn)
+	req, err := br.ReadString(0)
+	if err != nil {
+		return
+	}
+	if _, err := io.WriteString(up, req); err != nil {
+		return
+	}
+	go func() { _, _ = io.Copy(up, br) }()
+	if req != "events\x00" {
+		_, _ = io.Copy(conn, up)
+		return
+	}
+	p.mu.Lock()
+	p.streams = append(p.streams, conn)
+	p.mu.Unlock()
+	lines := bufio.NewReader(up)
+	for first := true; ; first = false {
+		line, err := lines.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if !first && p.drop.Load() > 0 {
+			p.drop.Add(-1)
+			continue
+		}
+		if _, err := io.WriteString(conn, line); err != nil {
+			return
+		}
+	}
+}
+
+// cut closes the event streams passing through p.
+func (p *eventProxy) cut() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.streams {
+		_ = c.Close()
+	}
+	p.streams = nil
+}
+
+func TestTopologyCache(t *testing.T) {
+	cfg := viiperTesting.TestServerConfig(t)
+	cfg.Server.ApiServerConfig.DeviceHandlerConnectTimeout = time.Minute
+	s := viiperTesting.NewTestServerWithConfig(t, cfg)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+	cmd.RegisterRoutes(s.ApiServer)
+	require.NoError(t, s.ApiServer.Start())
+	for _, id := range []uint32{90188, 90189, 90190} {
+		defer func() { _ = s.UsbServer.RemoveBus(id) }()
+	}
+
+	direct := apiclient.New(s.ApiServer.Addr())
+	_, err := direct.BusCreate(90188)
+	require.NoError(t, err)
+	dev, err := direct.DeviceAdd(90188, "xbox360", nil)
+	require.NoError(t, err)
+
+	proxy := startEventProxy(t, s.ApiServer.Addr())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache, err := apiclient.New(proxy.addr).NewTopologyCache(ctx, apiclient.TopologyCacheConfig{
+		ResyncInterval: -1, // only on gaps
+		RetryInterval:  20 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	changes := cache.Changes()
+
+	// Readers race the updates below; -race checks they are safe.
+	stopReaders := make(chan struct{})
+	var readers sync.WaitGroup
+	for range 4 {
+		readers.Go(func() {
+			for {
+				select {
+				case <-stopReaders:
+					return
+				case <-time.After(time.Millisecond):
+				}
+				for _, b := range cache.Snapshot().Buses {
+					for _, d := range b.Devices {
+						_, _ = cache.Device(b.BusID, d.DevId)
+					}
+					_, _ = cache.Bus(b.BusID)
+				}
+			}
+		})
+	}
+	defer func() {
+		close(stopReaders)
+		readers.Wait()
+	}()
+
+	waitFor := func(msg string, cond func(apiclient.Topology) bool) {
+		t.Helper()
+		deadline := time.After(2 * time.Second)
+		for !cond(cache.Snapshot()) {
+			select {
+			case <-changes:
+			case <-time.After(10 * time.Millisecond):
+			case <-deadline:
+				t.Fatalf("cache never saw: %s; have %+v", msg, cache.Snapshot())
+			}
+		}
+	}
+	hasBus := func(id uint32) func(apiclient.Topology) bool {
+		return func(topo apiclient.Topology) bool {
+			for _, b := range topo.Buses {
+				if b.BusID == id {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	bus, ok := cache.Bus(90188)
+	require.True(t, ok, "listed initially")
+	require.Len(t, bus.Devices, 1)
+	assert.Equal(t, "xbox360", bus.Devices[0].Type)
+	assert.False(t, bus.Devices[0].Attached)
+	synced := cache.Snapshot().SyncedAt
+
+	// Incremental: added, labeled on creation, attached and removed.
+	_, err = direct.SetDeviceLabel(90188, dev.DevId, "p1")
+	require.NoError(t, err)
+	dev2, err := direct.DeviceAdd(90188, "keyboard", nil)
+	require.NoError(t, err)
+	waitFor("added keyboard", func(apiclient.Topology) bool {
+		d, ok := cache.Device(90188, dev2.DevId)
+		return ok && d.Type == "keyboard"
+	})
+	d, _ := cache.Device(90188, dev.DevId)
+	assert.Equal(t, "p1", d.Label, "the bus is listed again for the added device")
+
+	usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := usbipClient.AttachDevice("90188-" + dev.DevId)
+	require.NoError(t, err)
+	waitFor("attached", func(apiclient.Topology) bool {
+		d, _ := cache.Device(90188, dev.DevId)
+		return d.Attached
+	})
+	require.NoError(t, imp.Conn.Close())
+	waitFor("detached", func(apiclient.Topology) bool {
+		d, _ := cache.Device(90188, dev.DevId)
+		return !d.Attached
+	})
+	_, err = direct.DeviceRemove(90188, dev2.DevId)
+	require.NoError(t, err)
+	waitFor("removed keyboard", func(apiclient.Topology) bool {
+		b, _ := cache.Bus(90188)
+		return len(b.Devices) == 1 && b.DeviceCount == 1
+	})
+	assert.Equal(t, synced, cache.Snapshot().SyncedAt, "no resync while events flow")
+
+	// A gap: the BusCreated of 90189 never arrives, so only a resync prompted
+	// by the next event can show the bus.
+	proxy.drop.Store(1)
+	_, err = direct.BusCreate(90189)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return proxy.drop.Load() == 0 }, time.Second, 5*time.Millisecond)
+	assert.False(t, hasBus(90189)(cache.Snapshot()))
+	_, err = direct.SetDeviceLabel(90188, dev.DevId, "p2")
+	require.NoError(t, err) // no event
+	xdev, err := xbox360.New(nil)
+	require.NoError(t, err)
+	_, err = s.UsbServer.GetBus(90188).Add(xdev)
+	require.NoError(t, err)
+	waitFor("bus created during the gap", hasBus(90189))
+	assert.True(t, cache.Snapshot().SyncedAt.After(synced), "resynced")
+	d, _ = cache.Device(90188, dev.DevId)
+	assert.Equal(t, "p2", d.Label)
+	synced = cache.Snapshot().SyncedAt
+
+	// The stream drops: the cache resubscribes and lists again.
+	proxy.cut()
+	_, err = direct.BusCreate(90190)
+	require.NoError(t, err)
+	waitFor("bus created while the stream was down", hasBus(90190))
+	assert.True(t, cache.Snapshot().SyncedAt.After(synced), "resynced")
+	require.NoError(t, s.UsbServer.RemoveBus(90190))
+	waitFor("bus removed after resubscribing", func(topo apiclient.Topology) bool { return !hasBus(90190)(topo) })
+
+	cancel()
+	for range changes {
+	}
+	_, open := <-cache.Changes()
+	assert.False(t, open, "closed once ctx ends")
+}
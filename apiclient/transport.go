@@ -1,29 +1,60 @@
 package apiclient
 
 import (
-	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log/slog"
-	"net"
 	"net/url"
 	"strings"
 	"time"
 
-	"github.com/Alia5/VIIPER/internal/server/api/auth"
-	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/internal/server/api/frame"
 )
 
 // Config controls low-level transport behavior such as timeouts.
 type Config struct {
-	DialTimeout  time.Duration
+	DialTimeout time.Duration
+	// ReadTimeout bounds waiting for the response of a management call and
+	// for each step of a handshake.
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
-	Password     string
+	// KeepAlive is the TCP keepalive period of connections, which keeps idle
+	// device streams alive through NATs. Zero means 15s; a negative value
+	// disables keepalives.
+	KeepAlive time.Duration
+	Password  string
+	// ProtocolVersion selects the request framing: 0 or 1 uses legacy
+	// null-terminated requests, 2 uses length-prefixed frames in both
+	// directions so payloads may contain arbitrary bytes.
+	ProtocolVersion int
+	// FlushTimeout bounds how long DeviceStream.Close waits for the server to
+	// apply the input written last, on servers with FeatureFlush. Zero means
+	// 2s; a negative value closes streams without waiting.
+	FlushTimeout time.Duration
+	// DisableResume runs the full password handshake on every connection.
+	// By default the first authenticated request fetches a session ticket
+	// from servers with FeatureResume, and later connections present it
+	// instead, which skips the costly password key derivation.
+	DisableResume bool
+	// Token authenticates with a scoped per-client token, "<id>:<secret>",
+	// instead of the password. Token connections are not resumed.
+	Token string
+	// TLS, if set, connects over TLS for management calls and device
+	// streams alike; the password handshake runs inside it. An empty
+	// ServerName defaults to the host of the server address.
+	TLS *tls.Config
+	// ServerFingerprint, "sha256:<hex>" as the server logs at startup, pins
+	// the identity of the server: the password or token handshake then has
+	// the server sign it and aborts with ErrServerIdentity if the server
+	// proves a different identity, or none, as servers predating it do.
+	// Requires a password or token.
+	ServerFingerprint string
 }
 
+const defaultFlushTimeout = 2 * time.Second
+
 func defaultConfig() Config {
 	return Config{
 		DialTimeout:  3 * time.Second,
@@ -38,6 +69,8 @@ func defaultConfig() Config {
 // Response framing: server writes a single JSON (or empty success) line terminated by `\n` and then
 // closes the connection. We therefore read until EOF (connection close) and trim a single trailing
 // newline if present. Embedded newlines in the response (future multi-line responses) are preserved.
+// With Config.ProtocolVersion 2 the request is preceded by frame.Magic and both directions use
+// u32 length-prefixed frames instead, which makes payloads fully opaque (null bytes included).
 type Transport struct {
 	addr string
 	mock func(path string, payload any, pathParams map[string]string) (string, error)
@@ -94,63 +127,53 @@ func (t *Transport) DoCtx(ctx context.Context, path string, payload any, pathPar
 	} else {
 		lineBytes = []byte(fullPath)
 	}
-	if err := ctx.Err(); err != nil {
-		return "", fmt.Errorf("dial: %w", err)
-	}
-	d := &net.Dialer{Timeout: t.cfg.DialTimeout}
-	conn, err := d.DialContext(ctx, "tcp", t.addr)
+	conn, err := t.dial(ctx)
 	if err != nil {
-		return "", fmt.Errorf("dial: %w", err)
+		return "", err
 	}
 	defer conn.Close()
-
-	if tcpConn, ok := conn.(*net.TCPConn); ok {
-		if err := tcpConn.SetNoDelay(true); err != nil {
-			slog.Warn("failed to set TCP_NODELAY", "error", err)
-		}
-	}
+	// A canceled ctx interrupts the round trip by closing conn.
+	defer context.AfterFunc(ctx, func() { _ = conn.Close() })()
+	t.fetchTicket()
 
 	if t.cfg.WriteTimeout > 0 {
 		_ = conn.SetWriteDeadline(time.Now().Add(t.cfg.WriteTimeout))
 	}
 
-	if t.cfg.Password != "" {
-		key, err := auth.DeriveKey(t.cfg.Password)
-		if err != nil {
-			return "", err
-		}
-		r := bufio.NewReader(conn)
-		clientNonce, serverNonce, err := auth.HandleAuthHandshake(r, conn, key, true)
-		if err != nil {
-
-			if strings.Contains(err.Error(), "read handshake response: EOF") {
-				return "", apierror.ErrUnauthorized("invalid password")
-			}
-			return "", err
-		}
-		sessionKey := auth.DeriveSessionKey(key, serverNonce, clientNonce)
-		conn, err = auth.WrapConn(conn, sessionKey)
-		if err != nil {
-			conn.Close()
-			return "", err
-		}
-	}
-
-	if _, err := conn.Write(append(lineBytes, '\x00')); err != nil {
-		return "", fmt.Errorf("write: %w", err)
+	if err := t.writeRequest(conn, lineBytes); err != nil {
+		return "", interrupted(ctx, fmt.Errorf("write: %w", err))
 	}
 	if t.cfg.ReadTimeout > 0 {
 		_ = conn.SetReadDeadline(time.Now().Add(t.cfg.ReadTimeout))
 	}
+	if t.cfg.ProtocolVersion >= 2 {
+		body, err := frame.Read(conn)
+		if err != nil {
+			return "", interrupted(ctx, fmt.Errorf("read: %w", err))
+		}
+		return string(body), nil
+	}
 	respBytes, err := io.ReadAll(conn)
-	if err != nil && len(respBytes) == 0 {
-		return "", fmt.Errorf("read: %w", err)
+	if err != nil && (len(respBytes) == 0 || ctx.Err() != nil) {
+		return "", interrupted(ctx, fmt.Errorf("read: %w", err))
 	}
 	resp := string(respBytes)
 
 	return strings.TrimSuffix(resp, "\n"), nil
 }
 
+// writeRequest sends a request line using the configured framing.
+func (t *Transport) writeRequest(w io.Writer, line []byte) error {
+	if t.cfg.ProtocolVersion >= 2 {
+		if _, err := io.WriteString(w, frame.Magic); err != nil {
+			return err
+		}
+		return frame.Write(w, line)
+	}
+	_, err := w.Write(append(line, '\x00'))
+	return err
+}
+
 func fillPath(pattern string, params map[string]string) string {
 	if len(params) == 0 {
 		return strings.ToLower(pattern)
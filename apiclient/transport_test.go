@@ -2,6 +2,7 @@ package apiclient_test
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"log/slog"
@@ -15,6 +16,7 @@ import (
 	"github.com/Alia5/VIIPER/internal/server/api/auth"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func startTestServer(t *testing.T, response string) (addr string, gotReqLine *string, closeFn func()) {
@@ -266,3 +268,99 @@ func TestEncryptedTransport(t *testing.T) {
 		})
 	}
 }
+
+// startSilentServer accepts connections but never answers.
+func startSilentServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		_ = ln.Close()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				<-done
+				conn.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestTransportUnresponsiveServer(t *testing.T) {
+	addr := startSilentServer(t)
+	slow := []apiclient.Option{apiclient.WithReadTimeout(time.Minute), apiclient.WithWriteTimeout(time.Minute)}
+
+	tests := []struct {
+		name   string
+		client *apiclient.Client
+		call   func(ctx context.Context, c *apiclient.Client) error
+	}{
+		{
+			name:   "management call",
+			client: apiclient.New(addr, slow...),
+			call: func(ctx context.Context, c *apiclient.Client) error {
+				_, err := c.BusListCtx(ctx)
+				return err
+			},
+		},
+		{
+			name:   "password handshake",
+			client: apiclient.NewWithConfig(addr, &apiclient.Config{DialTimeout: time.Second, ReadTimeout: time.Minute, Password: "secret"}),
+			call: func(ctx context.Context, c *apiclient.Client) error {
+				_, err := c.BusListCtx(ctx)
+				return err
+			},
+		},
+		{
+			name:   "device stream",
+			client: apiclient.New(addr, slow...),
+			call: func(ctx context.Context, c *apiclient.Client) error {
+				_, err := c.OpenStream(ctx, 1, "1")
+				return err
+			},
+		},
+		{
+			name:   "add and connect",
+			client: apiclient.New(addr, slow...),
+			call: func(ctx context.Context, c *apiclient.Client) error {
+				_, _, err := c.AddDeviceAndConnect(ctx, 1, "xbox360", nil)
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" deadline", func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			err := tt.call(ctx, tt.client)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.Less(t, time.Since(start), 2*time.Second)
+		})
+		t.Run(tt.name+" cancel", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+			start := time.Now()
+			err := tt.call(ctx, tt.client)
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.Less(t, time.Since(start), 2*time.Second)
+		})
+	}
+
+	t.Run("read timeout", func(t *testing.T) {
+		start := time.Now()
+		_, err := apiclient.New(addr, apiclient.WithReadTimeout(100*time.Millisecond)).BusList()
+		var netErr net.Error
+		require.ErrorAs(t, err, &netErr)
+		assert.True(t, netErr.Timeout())
+		assert.Less(t, time.Since(start), 2*time.Second)
+	})
+}
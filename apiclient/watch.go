@@ -0,0 +1,388 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	apitypes "github.com/Alia5/VIIPER/apitypes"
+)
+
+// WatchSnapshot is the state of the watched devices at one point in time.
+type WatchSnapshot struct {
+	At      time.Time     `json:"at"`
+	Devices []DeviceWatch `json:"devices"`
+}
+
+// DeviceWatch is one device of a WatchSnapshot. Rates cover the time since
+// the previous snapshot; they are zero in the first one, except
+// ReportsPerSec, which the server measures over the last second.
+type DeviceWatch struct {
+	BusID uint32 `json:"busId"`
+	DevId string `json:"devId"`
+	Type  string `json:"type"`
+	Label string `json:"label,omitempty"`
+	// Streaming is set while a client streams the device, Attached while a
+	// USB/IP host has it imported.
+	Streaming bool `json:"streaming"`
+	Attached  bool `json:"attached"`
+	// InputPerSec is the input states streamed per second, estimated from
+	// the stream bytes and the state size in the wire metadata. It stays
+	// zero for devices with states of variable size, and is exact only for
+	// plain streams (no delta, events or seq).
+	InputPerSec      float64 `json:"inputPerSec"`
+	InputBytesPerSec float64 `json:"inputBytesPerSec"`
+	// ReportsPerSec is the input reports delivered to the host per second.
+	ReportsPerSec float64 `json:"reportsPerSec"`
+	// PollIntervalNs is the shortest interval advertised by the interrupt
+	// IN endpoints, MeasuredPollNs the shortest the host was measured
+	// polling them at (0 until measured).
+	PollIntervalNs int64 `json:"pollIntervalNs,omitempty"`
+	MeasuredPollNs int64 `json:"measuredPollNs,omitempty"`
+	// HostPollingDegraded is set while the host polls slower than
+	// advertised, Degraded while simulated link degradation is enabled.
+	HostPollingDegraded bool  `json:"hostPollingDegraded"`
+	Degraded            bool  `json:"degraded"`
+	LatencyP95Ns        int64 `json:"latencyP95Ns,omitempty"` // of sequenced input
+	// Feedback counts the feedback messages sent on the current stream.
+	Feedback     uint64            `json:"feedback"`
+	LastFeedback *FeedbackSnapshot `json:"lastFeedback,omitempty"`
+}
+
+// FeedbackSnapshot is the last feedback message sent to the client
+// streaming a device. Fields is decoded with the device's wire metadata and
+// empty when that does not describe the message.
+type FeedbackSnapshot struct {
+	At     string      `json:"at"` // RFC 3339
+	Raw    []byte      `json:"raw"`
+	Fields []WireValue `json:"fields,omitempty"`
+}
+
+// WireValue is one decoded field of a wire message.
+type WireValue struct {
+	Name  string `json:"name"`
+	Value int64  `json:"value"`
+}
+
+// String returns the fields as "name=value" pairs, or the raw bytes in hex.
+func (f *FeedbackSnapshot) String() string {
+	if len(f.Fields) == 0 {
+		return fmt.Sprintf("% x", f.Raw)
+	}
+	parts := make([]string, len(f.Fields))
+	for i, v := range f.Fields {
+		parts[i] = v.Name + "=" + strconv.FormatInt(v.Value, 10)
+	}
+	return strings.Join(parts, " ")
+}
+
+// StatsWatcher gathers the stats of the devices of a bus, or of all buses,
+// into snapshots, e.g. for a dashboard. It is not safe for concurrent use.
+type StatsWatcher struct {
+	c     *Client
+	busID uint32 // 0: all buses
+	devID string // "": all devices of the bus
+
+	wire    map[string]wireLayout // by device type; nil until fetched
+	prevAt  time.Time
+	samples map[string]watchSample // by "busId-devId"
+}
+
+type watchSample struct {
+	streamIn  uint64
+	reportsIn uint64
+}
+
+// NewStatsWatcher returns a watcher of device devID on bus busID. An empty
+// devID watches every device of the bus, a busID of 0 every bus.
+func (c *Client) NewStatsWatcher(busID uint32, devID string) *StatsWatcher {
+	return &StatsWatcher{c: c, busID: busID, devID: devID}
+}
+
+// Snapshot gathers the current stats of the watched devices.
+func (w *StatsWatcher) Snapshot(ctx context.Context) (*WatchSnapshot, error) {
+	if w.wire == nil {
+		w.wire = w.fetchWire(ctx)
+	}
+	buses := []uint32{w.busID}
+	if w.busID == 0 {
+		resp, err := w.c.BusListCtx(ctx)
+		if err != nil {
+			return nil, err
+		}
+		buses = resp.Buses
+	}
+
+	list := w.c.NewBatch()
+	lists := make([]*BatchCall[apitypes.DevicesListResponse], len(buses))
+	for i, id := range buses {
+		lists[i] = list.DevicesList(id)
+	}
+	var devices []apitypes.Device
+	if list.Len() > 0 {
+		if _, err := list.ExecuteCtx(ctx); err != nil {
+			return nil, err
+		}
+	}
+	for i, call := range lists {
+		resp, err := call.Result()
+		if err != nil {
+			// A bus removed since it was listed.
+			if w.busID == 0 && errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("list bus %d: %w", buses[i], err)
+		}
+		for _, d := range resp.Devices {
+			if w.devID == "" || d.DevId == w.devID {
+				devices = append(devices, d)
+			}
+		}
+	}
+	if w.busID != 0 && w.devID != "" && len(devices) == 0 {
+		return nil, fmt.Errorf("device %d-%s: %w", w.busID, w.devID, ErrNotFound)
+	}
+
+	stats := w.c.NewBatch()
+	calls := make([]*BatchCall[apitypes.DeviceStatsResponse], len(devices))
+	for i, d := range devices {
+		calls[i] = stats.DeviceStats(d.BusID, d.DevId)
+	}
+	if stats.Len() > 0 {
+		if _, err := stats.ExecuteCtx(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(w.prevAt).Seconds()
+	samples := make(map[string]watchSample, len(devices))
+	snap := &WatchSnapshot{At: now, Devices: make([]DeviceWatch, 0, len(devices))}
+	for i, d := range devices {
+		st, err := calls[i].Result()
+		if errors.Is(err, ErrNotFound) {
+			continue // removed since it was listed
+		}
+		if err != nil {
+			return nil, fmt.Errorf("stats of device %d-%s: %w", d.BusID, d.DevId, err)
+		}
+		key := fmt.Sprintf("%d-%s", d.BusID, d.DevId)
+		layout := w.wire[d.Type]
+		dw := DeviceWatch{
+			BusID:               d.BusID,
+			DevId:               d.DevId,
+			Type:                d.Type,
+			Label:               d.Label,
+			Streaming:           st.Stream != nil,
+			Attached:            st.Attached,
+			ReportsPerSec:       float64(st.ReportsInPerSec),
+			HostPollingDegraded: st.HostPollingDegraded,
+			Degraded:            d.Degrade != nil,
+		}
+		for _, ep := range st.Endpoints {
+			if dw.PollIntervalNs == 0 || ep.IntervalNs < dw.PollIntervalNs {
+				dw.PollIntervalNs = ep.IntervalNs
+			}
+			if ep.MeasuredIntervalNs > 0 && (dw.MeasuredPollNs == 0 || ep.MeasuredIntervalNs < dw.MeasuredPollNs) {
+				dw.MeasuredPollNs = ep.MeasuredIntervalNs
+			}
+		}
+		if st.InputLatency != nil {
+			dw.LatencyP95Ns = st.InputLatency.P95Ns
+		}
+		cur := watchSample{reportsIn: st.ReportsIn}
+		if st.Stream != nil {
+			cur.streamIn = st.Stream.BytesIn
+			dw.Feedback = st.Stream.Feedback
+			if st.Stream.LastFeedback != "" {
+				raw, err := base64.StdEncoding.DecodeString(st.Stream.LastFeedback)
+				if err != nil {
+					return nil, fmt.Errorf("feedback of device %s: %w", key, err)
+				}
+				dw.LastFeedback = &FeedbackSnapshot{At: st.Stream.LastFeedbackAt, Raw: raw, Fields: layout.s2c.decode(raw)}
+			}
+		}
+		if prev, ok := w.samples[key]; ok && elapsed > 0 {
+			// Counters restart with a new import.
+			if cur.reportsIn >= prev.reportsIn {
+				dw.ReportsPerSec = float64(cur.reportsIn-prev.reportsIn) / elapsed
+			}
+			if cur.streamIn >= prev.streamIn {
+				dw.InputBytesPerSec = float64(cur.streamIn-prev.streamIn) / elapsed
+			}
+			if size := layout.c2s.size(); size > 0 {
+				dw.InputPerSec = dw.InputBytesPerSec / float64(size)
+			}
+		}
+		samples[key] = cur
+		snap.Devices = append(snap.Devices, dw)
+	}
+	w.samples, w.prevAt = samples, now
+	return snap, nil
+}
+
+// Watch sends a snapshot every interval, and right away when devices are
+// added, removed, attached or detached if the server sends lifecycle
+// events, until ctx ends or gathering a snapshot fails. The error channel
+// then yields the failure, if any, and both channels are closed.
+func (w *StatsWatcher) Watch(ctx context.Context, interval time.Duration) (<-chan WatchSnapshot, <-chan error) {
+	snaps := make(chan WatchSnapshot)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(snaps)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var events <-chan apitypes.Event
+		if ok, _ := w.c.Supports(ctx, FeatureLifecycleEvents); ok {
+			events, _ = w.c.SubscribeEvents(ctx)
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			snap, err := w.Snapshot(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					errs <- err
+				}
+				return
+			}
+			select {
+			case snaps <- *snap:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case <-ticker.C:
+			case _, ok := <-events:
+				if !ok {
+					events = nil // keep polling
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return snaps, errs
+}
+
+// fetchWire returns the wire layouts of the server's devices, none if the
+// server does not serve its protocol reference.
+func (w *StatsWatcher) fetchWire(ctx context.Context) map[string]wireLayout {
+	layouts := map[string]wireLayout{}
+	resp, err := w.c.FetchProtocolCtx(ctx)
+	if err != nil {
+		return layouts
+	}
+	raw, err := json.Marshal(resp.Protocol["wire"])
+	if err != nil {
+		return layouts
+	}
+	var wire map[string]map[string]struct {
+		Fields wireFields `json:"fields"`
+	}
+	if err := json.Unmarshal(raw, &wire); err != nil {
+		return layouts
+	}
+	for typ, dirs := range wire {
+		layouts[typ] = wireLayout{c2s: dirs["c2s"].Fields, s2c: dirs["s2c"].Fields}
+	}
+	return layouts
+}
+
+// wireLayout is the wire metadata of a device type.
+type wireLayout struct {
+	c2s, s2c wireFields
+}
+
+// wireFields are the fields of a wire message, e.g. {"name": "left",
+// "type": "u8"}. Types are scalars or arrays of them ("u8*6"), little
+// endian; arrays with a count field ("u8*count") make the size variable.
+type wireFields []struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// scalarSize returns the size of a scalar wire type, 0 if unknown.
+func scalarSize(typ string) int {
+	switch typ {
+	case "u8", "i8", "bool":
+		return 1
+	case "u16", "i16":
+		return 2
+	case "u32", "i32":
+		return 4
+	case "u64", "i64":
+		return 8
+	}
+	return 0
+}
+
+// fieldSize returns the size of a field of type typ, 0 if unknown or
+// variable.
+func fieldSize(typ string) int {
+	elem, count, isArray := strings.Cut(typ, "*")
+	n := 1
+	if isArray {
+		var err error
+		if n, err = strconv.Atoi(count); err != nil {
+			return 0
+		}
+	}
+	return scalarSize(elem) * n
+}
+
+// size returns the size of the message, 0 if unknown or variable.
+func (f wireFields) size() int {
+	total := 0
+	for _, field := range f {
+		n := fieldSize(field.Type)
+		if n == 0 {
+			return 0
+		}
+		total += n
+	}
+	return total
+}
+
+// decode returns the scalar fields of msg, nil unless msg has the size of
+// the message.
+func (f wireFields) decode(msg []byte) []WireValue {
+	if size := f.size(); size == 0 || size != len(msg) {
+		return nil
+	}
+	values := make([]WireValue, 0, len(f))
+	for _, field := range f {
+		n := fieldSize(field.Type)
+		b := msg[:n]
+		msg = msg[n:]
+		var v int64
+		switch field.Type {
+		case "u8", "bool":
+			v = int64(b[0])
+		case "i8":
+			v = int64(int8(b[0]))
+		case "u16":
+			v = int64(binary.LittleEndian.Uint16(b))
+		case "i16":
+			v = int64(int16(binary.LittleEndian.Uint16(b)))
+		case "u32":
+			v = int64(binary.LittleEndian.Uint32(b))
+		case "i32":
+			v = int64(int32(binary.LittleEndian.Uint32(b)))
+		case "u64", "i64":
+			v = int64(binary.LittleEndian.Uint64(b))
+		default:
+			continue // arrays
+		}
+		values = append(values, WireValue{Name: field.Name, Value: v})
+	}
+	return values
+}
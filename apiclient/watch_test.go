@@ -0,0 +1,132 @@
+package apiclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	apiclient "github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	handler "github.com/Alia5/VIIPER/internal/server/api/handler"
+)
+
+func TestStatsWatcher(t *testing.T) {
+	cfg := viiperTesting.TestServerConfig(t)
+	cfg.Server.UsbServerConfig.SlowHostThreshold = 3
+	s := viiperTesting.NewTestServerWithConfig(t, cfg)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+	r := s.ApiServer.Router()
+	r.Register("features", handler.Features())
+	r.Register("meta/protocol", handler.MetaProtocol())
+	r.Register("batch", handler.Batch(s.ApiServer))
+	r.Register("bus/list", handler.BusList(s.UsbServer))
+	r.Register("bus/create", handler.BusCreate(s.UsbServer))
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/{deviceid}/stats", handler.DeviceStats(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/{deviceid}/test-feedback", handler.DeviceTestFeedback(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+	defer func() { _ = s.UsbServer.RemoveBus(90182) }()
+
+	ctx := context.Background()
+	client := apiclient.New(s.ApiServer.Addr())
+	_, err := client.BusCreate(90182)
+	require.NoError(t, err)
+	typ, label := "xbox360", "pad"
+	_, err = client.DeviceAddWith(90182, apitypes.DeviceCreateRequest{Type: &typ, Label: &label})
+	require.NoError(t, err)
+
+	w := client.NewStatsWatcher(0, "")
+	snap, err := w.Snapshot(ctx)
+	require.NoError(t, err)
+	require.Len(t, snap.Devices, 1)
+	assert.Equal(t, apiclient.DeviceWatch{BusID: 90182, DevId: "1", Type: "xbox360", Label: "pad"}, snap.Devices[0])
+
+	stream, err := client.OpenStream(ctx, 90182, "1")
+	require.NoError(t, err)
+	defer stream.Close()
+	usbip := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := usbip.AttachDevice("90182-1")
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+
+	_, err = w.Snapshot(ctx)
+	require.NoError(t, err)
+	for i := range 5 {
+		require.NoError(t, stream.WriteBinary(&xbox360.InputState{LX: int16(i)}))
+		_, err := usbip.ReadInputReport(imp.Conn)
+		require.NoError(t, err)
+	}
+	fb, err := client.DeviceTestFeedback(90182, "1", &apitypes.TestFeedbackRequest{DurationMs: 20, RateHz: 100})
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	snap, err = w.Snapshot(ctx)
+	require.NoError(t, err)
+	require.Len(t, snap.Devices, 1)
+	d := snap.Devices[0]
+	assert.True(t, d.Streaming)
+	assert.True(t, d.Attached)
+	assert.Positive(t, d.ReportsPerSec)
+	assert.Positive(t, d.InputBytesPerSec)
+	assert.InDelta(t, d.InputBytesPerSec/20, d.InputPerSec, 1e-9, "xbox360 states are 20 bytes")
+	assert.Positive(t, d.PollIntervalNs)
+	assert.Equal(t, uint64(fb.Messages), d.Feedback)
+	require.NotNil(t, d.LastFeedback)
+	require.Len(t, d.LastFeedback.Fields, 2)
+	assert.Equal(t, "left", d.LastFeedback.Fields[0].Name)
+	assert.Equal(t, "right", d.LastFeedback.Fields[1].Name)
+	assert.Equal(t, []byte{byte(d.LastFeedback.Fields[0].Value), byte(d.LastFeedback.Fields[1].Value)}, d.LastFeedback.Raw)
+
+	// The JSON snapshots of viiper watch --json.
+	raw, err := json.Marshal(snap)
+	require.NoError(t, err)
+	var shape struct {
+		At      time.Time        `json:"at"`
+		Devices []map[string]any `json:"devices"`
+	}
+	require.NoError(t, json.Unmarshal(raw, &shape))
+	assert.False(t, shape.At.IsZero())
+	require.Len(t, shape.Devices, 1)
+	for _, key := range []string{"busId", "devId", "type", "label", "streaming", "attached", "inputPerSec", "reportsPerSec", "pollIntervalNs", "hostPollingDegraded", "degraded", "feedback"} {
+		assert.Contains(t, shape.Devices[0], key)
+	}
+	last, ok := shape.Devices[0]["lastFeedback"].(map[string]any)
+	require.True(t, ok)
+	assert.Contains(t, last, "at")
+	assert.Len(t, last["fields"], 2)
+
+	t.Run("device scope", func(t *testing.T) {
+		_, err := client.NewStatsWatcher(90182, "2").Snapshot(ctx)
+		assert.ErrorIs(t, err, apiclient.ErrNotFound)
+	})
+
+	t.Run("watch follows events", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		snaps, errs := client.NewStatsWatcher(90182, "").Watch(ctx, time.Hour)
+		first := <-snaps
+		require.Len(t, first.Devices, 1)
+		_, err := client.DeviceAdd(90182, "xbox360", nil)
+		require.NoError(t, err)
+		select {
+		case next := <-snaps:
+			assert.Len(t, next.Devices, 2)
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "no snapshot after the device was added")
+		}
+		cancel()
+		for range snaps {
+		}
+		assert.NoError(t, <-errs)
+	})
+}
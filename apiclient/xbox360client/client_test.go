@@ -0,0 +1,70 @@
+package xbox360client_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apiclient/xbox360client"
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func TestRoundTrip(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90170)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	defer func() { _ = s.UsbServer.RemoveBus(b.BusID()) }()
+
+	ctx := context.Background()
+	stream, dev, err := xbox360client.AddAndConnect(ctx, apiclient.New(s.ApiServer.Addr()), b.BusID(), nil)
+	require.NoError(t, err)
+	defer stream.Close()
+	assert.Equal(t, xbox360client.DeviceType, dev.Type)
+
+	dec, ok := device.LookupOutputDecoder(xbox360client.DeviceType)
+	require.True(t, ok)
+	assert.Equal(t, dec.Size, xbox360client.OutputSize)
+	wire, err := (&xbox360.InputState{}).MarshalBinary()
+	require.NoError(t, err)
+	assert.Len(t, wire, xbox360client.InputSize)
+
+	usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := usbipClient.AttachDevice(fmt.Sprintf("%d-%s", dev.BusID, dev.DevId))
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+
+	state := &xbox360.InputState{Buttons: xbox360.ButtonA, LT: 200, LX: -12345}
+	require.NoError(t, stream.WriteState(state))
+	_, err = usbipClient.PollInputReport(imp.Conn, state.BuildReport(), time.Second)
+	require.NoError(t, err)
+
+	outputs, errs := stream.Outputs(ctx, 1)
+	require.NoError(t, usbipClient.Submit(imp.Conn, usbip.DirOut, 1, []byte{0x00, 0x08, 0x00, 0x40, 0xc0, 0x00, 0x00, 0x00}, nil))
+	select {
+	case rumble := <-outputs:
+		assert.Equal(t, &xbox360.XRumbleState{LeftMotor: 0x40, RightMotor: 0xc0}, rumble)
+	case err := <-errs:
+		t.Fatalf("read outputs: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("no rumble")
+	}
+}
@@ -0,0 +1,73 @@
+package apitypes
+
+// How a client opts into an optional feature.
+const (
+	NegotiationRoute        = "route"         // call a management route
+	NegotiationStreamOption = "stream-option" // pass an option when opening a device stream
+	NegotiationCreateOption = "create-option" // set a field of the bus/{id}/add payload
+	NegotiationFraming      = "framing"       // send requests with the feature's framing
+)
+
+// Feature describes an optional protocol feature.
+type Feature struct {
+	Name        string `json:"name"`
+	Since       string `json:"since"`       // first server version that supports it
+	Negotiation string `json:"negotiation"` // one of the Negotiation* constants
+}
+
+type FeaturesResponse struct {
+	Version  string    `json:"version"`
+	Features []Feature `json:"features"`
+}
+
+// Features is the canonical list of optional protocol features. The server
+// serves it on the "features" route and codegen emits it into the SDKs, which
+// derive bitmasks from the position of each entry: append only, and keep the
+// fields literal so codegen can read them.
+var Features = []Feature{
+	{Name: "delta", Since: "0.3.0", Negotiation: NegotiationStreamOption},
+	{Name: "framing-v2", Since: "0.3.0", Negotiation: NegotiationFraming},
+	{Name: "test-feedback", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "bus-defaults", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "record", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "strict-input", Since: "0.3.0", Negotiation: NegotiationCreateOption},
+	{Name: "player-slot", Since: "0.3.0", Negotiation: NegotiationCreateOption},
+	{Name: "bus-labels", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "events", Since: "0.3.0", Negotiation: NegotiationStreamOption},
+	{Name: "alias", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "batch", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "degrade", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "templates", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "flush", Since: "0.3.0", Negotiation: NegotiationStreamOption},
+	{Name: "time-sync", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "meta-protocol", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "device-stats", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "read-only", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "stream-ack", Since: "0.3.0", Negotiation: NegotiationStreamOption},
+	{Name: "ms-os-descriptors", Since: "0.3.0", Negotiation: NegotiationCreateOption},
+	{Name: "stream-mixing", Since: "0.3.0", Negotiation: NegotiationCreateOption},
+	{Name: "humanize", Since: "0.3.0", Negotiation: NegotiationCreateOption},
+	{Name: "mouse-hires-scroll", Since: "0.3.0", Negotiation: NegotiationCreateOption},
+	{Name: "xbox360-led-feedback", Since: "0.3.0", Negotiation: NegotiationCreateOption},
+	{Name: "deterministic", Since: "0.3.0", Negotiation: NegotiationCreateOption},
+	{Name: "ds4-bluetooth-mode", Since: "0.3.0", Negotiation: NegotiationCreateOption},
+	{Name: "resume", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "ds4-audio-stub", Since: "0.3.0", Negotiation: NegotiationCreateOption},
+	{Name: "joystick-force-feedback", Since: "0.3.0", Negotiation: NegotiationCreateOption},
+	{Name: "disconnect-policy", Since: "0.3.0", Negotiation: NegotiationCreateOption},
+	{Name: "device-status", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "stream-status", Since: "0.3.0", Negotiation: NegotiationStreamOption},
+	{Name: "lifecycle-events", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "device-identity", Since: "0.3.0", Negotiation: NegotiationCreateOption},
+	{Name: "bus-capacity", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "input-latency", Since: "0.3.0", Negotiation: NegotiationStreamOption},
+	{Name: "keyboard-media-keys", Since: "0.3.0", Negotiation: NegotiationCreateOption},
+	{Name: "xbox360-headset", Since: "0.3.0", Negotiation: NegotiationCreateOption},
+	{Name: "usbip-stats", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "metrics", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "macro", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "device-labels", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "device-add-batch", Since: "0.3.0", Negotiation: NegotiationRoute},
+	{Name: "device-ids", Since: "0.3.0", Negotiation: NegotiationCreateOption},
+	{Name: "stream-priority", Since: "0.3.0", Negotiation: NegotiationStreamOption},
+}
@@ -1,6 +1,7 @@
 package apitypes
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"math"
@@ -18,6 +19,8 @@ type ApiError struct {
 	Title string `json:"title"`
 	// Detail is a human-readable explanation specific to this occurrence
 	Detail string `json:"detail"`
+	// Type is a URI identifying the problem type; empty means "about:blank"
+	Type string `json:"type,omitempty"`
 }
 
 func (e ApiError) Error() string {
@@ -30,21 +33,114 @@ func (e ApiError) Error() string {
 	return fmt.Sprintf("%d %s: %s", e.Status, e.Title, e.Detail)
 }
 
+// Is reports whether target is a problem of the same status without a
+// Detail, so a generic problem such as apiclient.ErrNotFound matches every
+// specific one.
+func (e ApiError) Is(target error) bool {
+	var t ApiError
+	switch v := target.(type) {
+	case *ApiError:
+		if v == nil {
+			return false
+		}
+		t = *v
+	case ApiError:
+		t = v
+	default:
+		return false
+	}
+	return t.Detail == "" && t.Status != 0 && t.Status == e.Status
+}
+
 // --
 
 type PingResponse struct {
-	Server  string `json:"server"`
-	Version string `json:"version"`
+	Server   string `json:"server"`
+	Version  string `json:"version"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+	// Fingerprint identifies the key the server signs handshakes with, for
+	// clients to pin. Only trust it when fetched over a trusted channel.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// ReadOnlyRequest switches the server's read-only mode.
+type ReadOnlyRequest struct {
+	ReadOnly bool `json:"readOnly"`
+}
+
+type ReadOnlyResponse struct {
+	ReadOnly bool `json:"readOnly"`
+}
+
+// StateSnapshotsResponse lists the snapshots of the state file, newest first.
+type StateSnapshotsResponse struct {
+	Snapshots []StateSnapshotInfo `json:"snapshots"`
+}
+
+type StateSnapshotInfo struct {
+	Name      string `json:"name"`
+	CreatedAt string `json:"createdAt"` // RFC 3339
+}
+
+// StateRestoreRequest names the snapshot to roll back to, as listed by
+// admin/state/snapshots.
+type StateRestoreRequest struct {
+	Snapshot string `json:"snapshot"`
+}
+
+// StateRestoreResponse reports what was restored. Errors lists the buses and
+// devices of the snapshot that could not be recreated.
+type StateRestoreResponse struct {
+	Snapshot string   `json:"snapshot"`
+	Buses    int      `json:"buses"`
+	Devices  int      `json:"devices"`
+	Errors   []string `json:"errors,omitempty"`
 }
 
+// AuthTicketResponse carries a session resumption ticket and the secret it
+// grants, both base64. Present the ticket instead of the password proof
+// until ExpiresIn seconds have passed.
+type AuthTicketResponse struct {
+	Ticket    string `json:"ticket"`
+	Secret    string `json:"secret"`
+	ExpiresIn uint64 `json:"expiresIn"`
+}
+
+// BusListResponse lists the active buses. Buses carries the bare bus numbers
+// for older clients; BusInfo details each of them in the same order.
 type BusListResponse struct {
-	Buses []uint32 `json:"buses"`
+	Buses   []uint32  `json:"buses"`
+	BusInfo []BusInfo `json:"busInfo"`
+}
+
+type BusInfo struct {
+	BusID       uint32 `json:"busId"`
+	Label       string `json:"label,omitempty"`
+	Description string `json:"description,omitempty"`
+	DeviceCount int    `json:"deviceCount"`
+	MaxDevices  int    `json:"maxDevices"`
+}
+
+// BusCreateRequest is the JSON form of the bus/create payload; a bare bus
+// number is accepted as well. BusID 0 picks the next free bus number.
+type BusCreateRequest struct {
+	BusID       uint32 `json:"busId,omitempty"`
+	Label       string `json:"label,omitempty"`
+	Description string `json:"description,omitempty"`
+	// MaxDevices limits the devices on the bus; 0 keeps the default of 8.
+	MaxDevices int `json:"maxDevices,omitempty"`
 }
 
 type BusCreateResponse struct {
 	BusID uint32 `json:"busId"`
 }
 
+// BusLabelRequest replaces the label and description of a bus.
+type BusLabelRequest struct {
+	Label       string `json:"label"`
+	Description string `json:"description"`
+}
+
 type BusRemoveResponse struct {
 	BusID uint32 `json:"busId"`
 }
@@ -56,6 +152,68 @@ type Device struct {
 	Pid            string         `json:"pid"`
 	Type           string         `json:"type"`
 	DeviceSpecific map[string]any `json:"deviceSpecific"`
+	PlayerSlot     int            `json:"playerSlot,omitempty"`
+	// Port is the port the device occupies, as "busId-port".
+	Port string `json:"port,omitempty"`
+	// ProductString and SerialNumber are the strings the device reports,
+	// empty where it has none.
+	ProductString string `json:"productString,omitempty"`
+	SerialNumber  string `json:"serialNumber,omitempty"`
+	// AliasOf is the "busId-devId" of the device an alias mirrors.
+	AliasOf string `json:"aliasOf,omitempty"`
+	// Degrade is the simulated link degradation, if enabled.
+	Degrade *DegradeConfig `json:"degrade,omitempty"`
+	// StreamPolicy is the stream policy, unless it is "single"; Claims are
+	// the fields the streams of a mixed device own.
+	StreamPolicy string        `json:"streamPolicy,omitempty"`
+	Claims       []StreamClaim `json:"claims,omitempty"`
+	// Humanize is the input humanization, if enabled, with the seed in use.
+	Humanize *HumanizeConfig `json:"humanize,omitempty"`
+	// Deterministic is the deterministic input mode, if enabled.
+	Deterministic *DeterministicConfig `json:"deterministic,omitempty"`
+	// Disconnect is the disconnect policy, unless it is "hold-last-state".
+	Disconnect string `json:"disconnect,omitempty"`
+	// Label is the user-assigned name of the device, if any.
+	Label string `json:"label,omitempty"`
+	// LocalAttach is the attachment to the server host's vhci_hcd, if the
+	// server runs with --auto-attach-local.
+	LocalAttach *LocalAttachStatus `json:"localAttach,omitempty"`
+}
+
+// LocalAttachStatus is the attachment of a device to the server host.
+// Neither attached nor failed means the attach is still pending.
+type LocalAttachStatus struct {
+	Attached bool `json:"attached"`
+	// VhciPort is the vhci_hcd port of an attached device.
+	VhciPort int `json:"vhciPort,omitempty"`
+	// Error is why the attach failed.
+	Error string `json:"error,omitempty"`
+}
+
+// DeviceLabelRequest replaces the label of a device; an empty label clears it.
+type DeviceLabelRequest struct {
+	Label string `json:"label"`
+}
+
+// DeviceAddBatchRequest adds several devices to a bus at once, all or none.
+// A bare JSON array of entries is accepted as well.
+type DeviceAddBatchRequest struct {
+	Devices []DeviceCreateRequest `json:"devices"`
+}
+
+// UnmarshalJSON accepts both the object form and a bare array of entries.
+func (r *DeviceAddBatchRequest) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		*r = DeviceAddBatchRequest{}
+		return json.Unmarshal(trimmed, &r.Devices)
+	}
+	type plain DeviceAddBatchRequest
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+// DeviceAddBatchResponse lists the added devices in the order requested.
+type DeviceAddBatchResponse struct {
+	Devices []Device `json:"devices"`
 }
 
 type DevicesListResponse struct {
@@ -67,11 +225,114 @@ type DeviceRemoveResponse struct {
 	DevId string `json:"devId"`
 }
 
+// TestFeedbackRequest asks a device to emit a synthetic feedback sequence
+// to its stream client, for validating client-side feedback decoding.
+type TestFeedbackRequest struct {
+	Pattern    string `json:"pattern,omitempty"`    // "ramp" (default) or "square"
+	DurationMs uint32 `json:"durationMs,omitempty"` // default 100
+	RateHz     uint32 `json:"rateHz,omitempty"`     // default 100
+}
+
+type TestFeedbackResponse struct {
+	BusID     uint32 `json:"busId"`
+	DevId     string `json:"devId"`
+	Synthetic bool   `json:"synthetic"`
+	Messages  uint32 `json:"messages"`
+}
+
+// RecordStartRequest starts a server-side recording of a device stream.
+type RecordStartRequest struct {
+	MaxDurationMs uint32 `json:"maxDurationMs,omitempty"` // default 60000
+	MaxBytes      uint32 `json:"maxBytes,omitempty"`      // default 16 MiB
+}
+
+type RecordingStatus struct {
+	BusID      uint32 `json:"busId"`
+	DevId      string `json:"devId"`
+	Active     bool   `json:"active"`
+	Records    uint32 `json:"records"`
+	Bytes      uint64 `json:"bytes"`
+	DurationMs uint32 `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// RecordDownloadRequest selects the chunk of a finished recording to download.
+type RecordDownloadRequest struct {
+	Offset uint64 `json:"offset"`
+}
+
+type RecordChunk struct {
+	BusID  uint32 `json:"busId"`
+	DevId  string `json:"devId"`
+	Offset uint64 `json:"offset"`
+	Data   string `json:"data"` // base64
+	EOF    bool   `json:"eof"`
+}
+
+// MacroStep is one input state of a macro, held for HoldMs. State holds the
+// fields of the device's input state by name, e.g. {"buttons": 1, "lx": 100}
+// for an xbox360; omitted fields are zero.
+type MacroStep struct {
+	State  map[string]any `json:"state"`
+	HoldMs uint32         `json:"holdMs"`
+}
+
+// MacroRequest plays Steps Repeat times, default 1, against a device.
+type MacroRequest struct {
+	Steps  []MacroStep `json:"steps"`
+	Repeat uint32      `json:"repeat,omitempty"`
+}
+
+type MacroStatus struct {
+	BusID      uint32 `json:"busId"`
+	DevId      string `json:"devId"`
+	Playing    bool   `json:"playing"`
+	DurationMs uint32 `json:"durationMs,omitempty"` // of the whole macro
+	Cancelled  bool   `json:"cancelled,omitempty"`
+}
+
 type DeviceCreateRequest struct {
 	Type           *string        `json:"type"`
 	IdVendor       *uint16        `json:"idVendor,omitempty"`
 	IdProduct      *uint16        `json:"idProduct,omitempty"`
 	DeviceSpecific map[string]any `json:"deviceSpecific,omitempty"`
+	// SerialNumber and ProductString replace the strings reported in the USB
+	// descriptors, at most 126 characters each.
+	SerialNumber  *string `json:"serialNumber,omitempty"`
+	ProductString *string `json:"productString,omitempty"`
+	// MSOSDescriptors replaces the Microsoft OS 2.0 descriptors of the device.
+	MSOSDescriptors *MSOSDescriptors `json:"msOsDescriptors,omitempty"`
+	// StrictInput rejects out-of-range stream inputs instead of clamping them.
+	StrictInput *bool `json:"strictInput,omitempty"`
+	// PlayerSlot assigns a 1-based player number, unique per bus.
+	PlayerSlot *int `json:"playerSlot,omitempty"`
+	// StreamPolicy is how the device takes input from several streams:
+	// "single" (default), the newest stream taking over, or "mixed", each
+	// stream claiming some of the wire fields.
+	StreamPolicy *string `json:"streamPolicy,omitempty"`
+	// Humanize shapes the timing of keyboard and mouse input.
+	Humanize *HumanizeConfig `json:"humanize,omitempty"`
+	// Deterministic queues streamed states for the host to read one by one.
+	Deterministic *DeterministicConfig `json:"deterministic,omitempty"`
+	// Disconnect is what happens when the stream client goes away:
+	// "hold-last-state" (default), "neutral-state" or "detach".
+	Disconnect *string `json:"disconnect,omitempty"`
+	// Template creates the device from a stored DeviceTemplate; Type may
+	// then be omitted. The other options must go into Overrides.
+	Template *string `json:"template,omitempty"`
+	// Overrides replace single options of the template; deviceSpecific is
+	// merged per key.
+	Overrides *DeviceDefaults `json:"overrides,omitempty"`
+	// Label names the device in listings, at most 64 bytes without
+	// control characters.
+	Label *string `json:"label,omitempty"`
+	// DevId is the ID to add the device under instead of the lowest free
+	// number: a number, or up to 20 of a-z, 0-9 and - used in its USB-IP
+	// busid, e.g. "1-left-pad".
+	DevId *string `json:"devId,omitempty"`
+	// Upsert returns the device already under DevId instead of failing, if
+	// it is of the requested type.
+	Upsert bool `json:"upsert,omitempty"`
 }
 
 // UnmarshalJSON implements custom unmarshaling to accept both uint16 and hex string formats
@@ -79,10 +340,24 @@ type DeviceCreateRequest struct {
 func (d *DeviceCreateRequest) UnmarshalJSON(data []byte) error {
 	// Parse into a temporary structure with flexible types
 	var raw struct {
-		Type           *string        `json:"type"`
-		IdVendor       any            `json:"idVendor,omitempty"`
-		IdProduct      any            `json:"idProduct,omitempty"`
-		DeviceSpecific map[string]any `json:"deviceSpecific,omitempty"`
+		Type            *string              `json:"type"`
+		IdVendor        any                  `json:"idVendor,omitempty"`
+		IdProduct       any                  `json:"idProduct,omitempty"`
+		SerialNumber    *string              `json:"serialNumber,omitempty"`
+		ProductString   *string              `json:"productString,omitempty"`
+		DeviceSpecific  map[string]any       `json:"deviceSpecific,omitempty"`
+		MSOSDescriptors *MSOSDescriptors     `json:"msOsDescriptors,omitempty"`
+		StrictInput     *bool                `json:"strictInput,omitempty"`
+		PlayerSlot      *int                 `json:"playerSlot,omitempty"`
+		StreamPolicy    *string              `json:"streamPolicy,omitempty"`
+		Humanize        *HumanizeConfig      `json:"humanize,omitempty"`
+		Deterministic   *DeterministicConfig `json:"deterministic,omitempty"`
+		Disconnect      *string              `json:"disconnect,omitempty"`
+		Template        *string              `json:"template,omitempty"`
+		Overrides       *DeviceDefaults      `json:"overrides,omitempty"`
+		Label           *string              `json:"label,omitempty"`
+		DevId           *string              `json:"devId,omitempty"`
+		Upsert          bool                 `json:"upsert,omitempty"`
 	}
 
 	if err := json.Unmarshal(data, &raw); err != nil {
@@ -107,11 +382,327 @@ func (d *DeviceCreateRequest) UnmarshalJSON(data []byte) error {
 		d.IdProduct = &val
 	}
 
+	d.SerialNumber = raw.SerialNumber
+	d.ProductString = raw.ProductString
 	d.DeviceSpecific = raw.DeviceSpecific
+	d.MSOSDescriptors = raw.MSOSDescriptors
+	d.StrictInput = raw.StrictInput
+	d.PlayerSlot = raw.PlayerSlot
+	d.StreamPolicy = raw.StreamPolicy
+	d.Humanize = raw.Humanize
+	d.Deterministic = raw.Deterministic
+	d.Disconnect = raw.Disconnect
+	d.Template = raw.Template
+	d.Overrides = raw.Overrides
+	d.Label = raw.Label
+	d.DevId = raw.DevId
+	d.Upsert = raw.Upsert
 
 	return nil
 }
 
+// DeviceAliasRequest names the bus an alias of a device is created on.
+type DeviceAliasRequest struct {
+	BusID uint32 `json:"busId"`
+}
+
+// StreamClaim is the set of wire fields one stream of a mixed device owns.
+// Source is the address of the stream client.
+type StreamClaim struct {
+	Source string   `json:"source"`
+	Fields []string `json:"fields"`
+}
+
+// MSOSDescriptors are the Microsoft OS 2.0 descriptors a device reports,
+// with which Windows binds a driver by compatible ID whatever the VID/PID.
+// xbox360 devices report the compatible ID XUSB10 by default; an empty
+// CompatibleID turns the descriptors off. IDs are up to 8 ASCII characters.
+type MSOSDescriptors struct {
+	CompatibleID    string `json:"compatibleId"`
+	SubCompatibleID string `json:"subCompatibleId,omitempty"`
+}
+
+// DegradeConfig simulates a bad link between the stream client and a device.
+// Every state is delayed by DelayMs plus a uniform random jitter below
+// JitterMs; with probability DropRate a state starts a burst of DropBurst
+// (default 1) lost states. The zero value disables degradation.
+type DegradeConfig struct {
+	DelayMs   uint32  `json:"delayMs,omitempty"`
+	JitterMs  uint32  `json:"jitterMs,omitempty"`
+	DropRate  float64 `json:"dropRate,omitempty"`
+	DropBurst uint32  `json:"dropBurst,omitempty"`
+	Seed      uint64  `json:"seed,omitempty"` // 0 picks a random seed
+}
+
+// HumanizeConfig makes keyboard and mouse input look typed and moved by hand.
+// Each keyboard state waits a log-normal interval (mean KeyIntervalMeanMs,
+// default 120, standard deviation KeyIntervalStdDev ms, default 40) after the
+// previous one. Mouse movements are eased over steps 8ms apart whose points
+// are offset by up to MouseJitterPx; the total movement is kept exact.
+type HumanizeConfig struct {
+	Enabled           bool   `json:"enabled"`
+	KeyIntervalMeanMs uint32 `json:"keyIntervalMeanMs,omitempty"`
+	KeyIntervalStdDev uint32 `json:"keyIntervalStdDev,omitempty"`
+	MouseJitterPx     uint32 `json:"mouseJitterPx,omitempty"`
+	Seed              uint64 `json:"seed,omitempty"` // 0 picks a random seed
+}
+
+// DeterministicConfig makes a device report the states streamed to it
+// strictly in order, one per step, so the same input yields the same reports
+// regardless of timing. Each host poll of the input endpoint is a step and
+// waits for the next state; with Manual, only bus/{id}/{deviceid}/step is.
+type DeterministicConfig struct {
+	Enabled bool `json:"enabled"`
+	Manual  bool `json:"manual,omitempty"`
+}
+
+// DeviceStepRequest advances a device in deterministic mode by Count states,
+// default 1.
+type DeviceStepRequest struct {
+	Count uint32 `json:"count,omitempty"`
+}
+
+type DeviceStepResponse struct {
+	BusID   uint32 `json:"busId"`
+	DevId   string `json:"devId"`
+	Stepped uint32 `json:"stepped"`
+	Queued  uint32 `json:"queued"`
+}
+
+type DeviceDegradeResponse struct {
+	BusID   uint32        `json:"busId"`
+	DevId   string        `json:"devId"`
+	Degrade DegradeConfig `json:"degrade"`
+	Delayed uint64        `json:"delayed"`
+	Dropped uint64        `json:"dropped"`
+}
+
+// EndpointPolling compares how often the host polls an interrupt IN endpoint
+// with the interval its descriptor advertises.
+type EndpointPolling struct {
+	Endpoint           uint8 `json:"endpoint"` // bEndpointAddress
+	IntervalNs         int64 `json:"intervalNs"`
+	MeasuredIntervalNs int64 `json:"measuredIntervalNs"` // 0 until measured
+	Degraded           bool  `json:"degraded"`
+}
+
+// DeviceStatsResponse reports the USB traffic of a device. "In" is device to
+// host and "out" host to device, as in USB; rates cover the last second.
+// Everything is zero while no host has the device attached.
+type DeviceStatsResponse struct {
+	BusID          uint32 `json:"busId"`
+	DevId          string `json:"devId"`
+	Attached       bool   `json:"attached"`
+	BytesIn        uint64 `json:"bytesIn"`
+	BytesOut       uint64 `json:"bytesOut"`
+	BytesInPerSec  uint64 `json:"bytesInPerSec"`
+	BytesOutPerSec uint64 `json:"bytesOutPerSec"`
+	// ReportsIn counts the input reports delivered to the host.
+	ReportsIn       uint64 `json:"reportsIn"`
+	ReportsInPerSec uint64 `json:"reportsInPerSec"`
+	// HostPollingDegraded is set while the host polls any interrupt endpoint
+	// slower than the server's slow-host threshold allows.
+	HostPollingDegraded bool              `json:"hostPollingDegraded"`
+	Endpoints           []EndpointPolling `json:"endpoints"`
+	// Priority is that of the device's USB/IP work, "low", "normal" or
+	// "high", as set by the prio option of its stream. Deferred counts the
+	// URBs and reply writes held back for devices of a higher priority.
+	Priority string `json:"priority"`
+	Deferred uint64 `json:"deferred"`
+	// InputLatency is set once a stream opened with seq=1 sent input.
+	InputLatency *InputLatencyStats `json:"inputLatency,omitempty"`
+	// Stream is set while a client streams the device, attached or not.
+	Stream *StreamStats `json:"stream,omitempty"`
+}
+
+// StreamStats reports the client stream of a device. Byte counts cover all
+// streams the device had, the feedback those of the current one.
+type StreamStats struct {
+	BytesIn        uint64 `json:"bytesIn"`                  // from the client
+	BytesOut       uint64 `json:"bytesOut"`                 // to the client
+	Feedback       uint64 `json:"feedback"`                 // feedback messages sent
+	LastFeedback   string `json:"lastFeedback,omitempty"`   // base64
+	LastFeedbackAt string `json:"lastFeedbackAt,omitempty"` // RFC 3339
+}
+
+// InputLatencyStats measures the time from the server reading a state off a
+// stream opened with seq=1 to the first report built for the host after it.
+// Percentiles cover the last 1024 delivered states.
+type InputLatencyStats struct {
+	Received  uint64 `json:"received"`
+	SeqGaps   uint64 `json:"seqGaps"` // sequence numbers skipped by the client
+	Delivered uint64 `json:"delivered"`
+	P50Ns     int64  `json:"p50Ns"`
+	P95Ns     int64  `json:"p95Ns"`
+	P99Ns     int64  `json:"p99Ns"`
+	// LastSeq and LastClientMonoNs echo the header of the last delivered
+	// state; the timestamp is on the client's clock.
+	LastSeq          uint32 `json:"lastSeq"`
+	LastClientMonoNs int64  `json:"lastClientMonoNs"`
+}
+
+// UsbipStatsResponse counts the USB/IP connections of the server and those
+// it refused or closed under its connection limits.
+type UsbipStatsResponse struct {
+	ActiveConnections        int    `json:"activeConnections"`
+	MaxConcurrentConnections int    `json:"maxConcurrentConnections"` // 0: no limit
+	Refused                  uint64 `json:"refused"`                  // over maxConcurrentConnections
+	RateLimited              uint64 `json:"rateLimited"`              // over the per-IP connection rate
+	IdleClosed               uint64 `json:"idleClosed"`               // imported but sent no URB in time
+}
+
+// MetricsResponse lists the metrics of the server, the series the
+// --metrics-addr listener serves in the Prometheus text format.
+type MetricsResponse struct {
+	Metrics []MetricFamily `json:"metrics"`
+}
+
+// MetricFamily is one metric and its series. Type is "counter", "gauge" or
+// "histogram".
+type MetricFamily struct {
+	Name    string         `json:"name"`
+	Help    string         `json:"help"`
+	Type    string         `json:"type"`
+	Samples []MetricSample `json:"samples"`
+}
+
+// MetricSample is one series of a metric. Histograms carry the sum of their
+// observations in Value, their number in Count and cumulative Buckets.
+type MetricSample struct {
+	Labels  map[string]string `json:"labels,omitempty"`
+	Value   float64           `json:"value"`
+	Count   uint64            `json:"count,omitempty"`
+	Buckets []MetricBucket    `json:"buckets,omitempty"`
+}
+
+// MetricBucket counts the observations of a histogram up to Le.
+type MetricBucket struct {
+	Le    float64 `json:"le"`
+	Count uint64  `json:"count"`
+}
+
+// DeviceStatusResponse reports whether a USB/IP host has the device imported.
+// It is also the body of the status messages of streams opened with status=1.
+type DeviceStatusResponse struct {
+	BusID         uint32 `json:"busId"`
+	DevId         string `json:"devId"`
+	Attached      bool   `json:"attached"`
+	AttachedSince string `json:"attachedSince,omitempty"` // RFC 3339
+	RemoteAddr    string `json:"remoteAddr,omitempty"`    // host:port of the USB/IP host
+}
+
+// Event is a change of the server's buses or devices, as sent by the events
+// route. Type is BusCreated, BusRemoved, DeviceAdded, DeviceRemoved,
+// DeviceAttached (imported by a USB/IP host) or DeviceDetached.
+type Event struct {
+	Type       string `json:"type"`
+	BusID      uint32 `json:"busId"`
+	DevId      string `json:"devId,omitempty"` // empty for bus events
+	OccurredAt string `json:"occurredAt"`
+	// Seq numbers the events of the server from 1 without gaps; a
+	// subscriber seeing a number skipped missed events.
+	Seq uint64 `json:"seq"`
+	// Dropped counts the events lost right before this one because the
+	// subscriber fell behind.
+	Dropped uint64 `json:"dropped,omitempty"`
+}
+
+// BatchEntry is one management request of a batch.
+type BatchEntry struct {
+	Path    string `json:"path"`
+	Payload string `json:"payload,omitempty"`
+}
+
+// BatchRequest runs management requests sequentially in one round trip.
+// A bare JSON array of entries is accepted as well.
+type BatchRequest struct {
+	Requests []BatchEntry `json:"requests"`
+	// StopOnError skips the remaining requests after the first failure.
+	StopOnError bool `json:"stopOnError,omitempty"`
+}
+
+// UnmarshalJSON accepts both the object form and a bare array of entries.
+func (b *BatchRequest) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		*b = BatchRequest{}
+		return json.Unmarshal(trimmed, &b.Requests)
+	}
+	type plain BatchRequest
+	return json.Unmarshal(data, (*plain)(b))
+}
+
+// BatchResult is the outcome of one batch entry: exactly one of Result,
+// Problem or Skipped is set.
+type BatchResult struct {
+	Result  any       `json:"result,omitempty"`
+	Problem *ApiError `json:"problem,omitempty"`
+	Skipped bool      `json:"skipped,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// DeviceDefaults are create options without a device type, as inherited from
+// bus defaults or stored in a template.
+type DeviceDefaults struct {
+	IdVendor       *uint16        `json:"idVendor,omitempty"`
+	IdProduct      *uint16        `json:"idProduct,omitempty"`
+	SerialNumber   *string        `json:"serialNumber,omitempty"`
+	ProductString  *string        `json:"productString,omitempty"`
+	DeviceSpecific map[string]any `json:"deviceSpecific,omitempty"`
+	StrictInput    *bool          `json:"strictInput,omitempty"`
+}
+
+// UnmarshalJSON accepts the same idVendor/idProduct formats as DeviceCreateRequest.
+func (d *DeviceDefaults) UnmarshalJSON(data []byte) error {
+	var req DeviceCreateRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return err
+	}
+	d.IdVendor = req.IdVendor
+	d.IdProduct = req.IdProduct
+	d.SerialNumber = req.SerialNumber
+	d.ProductString = req.ProductString
+	d.DeviceSpecific = req.DeviceSpecific
+	d.StrictInput = req.StrictInput
+	return nil
+}
+
+// ProtocolResponse carries the protocol reference (routes, DTOs, device wire
+// formats and features) of the code the server was built from.
+type ProtocolResponse struct {
+	Version  string         `json:"version"`
+	Commit   string         `json:"commit"`
+	Protocol map[string]any `json:"protocol"`
+}
+
+// DeviceTemplate is a named device configuration stored on the server,
+// usable with the template field of DeviceCreateRequest.
+type DeviceTemplate struct {
+	Name       string         `json:"name"`
+	DeviceType string         `json:"deviceType"`
+	Options    DeviceDefaults `json:"options"`
+}
+
+type TemplateListResponse struct {
+	Templates []DeviceTemplate `json:"templates"`
+}
+
+type TemplateRemoveResponse struct {
+	Name string `json:"name"`
+}
+
+// BusDefaultsRequest sets the defaults of a bus, keyed by device type or "*" for all types.
+type BusDefaultsRequest struct {
+	Defaults map[string]DeviceDefaults `json:"defaults"`
+}
+
+type BusDefaultsResponse struct {
+	BusID    uint32                    `json:"busId"`
+	Defaults map[string]DeviceDefaults `json:"defaults"`
+}
+
 // parseUint16OrHex accepts either a JSON number or a hex string like "0x12ac"
 func parseNumberOrHex[N constraints.Integer](v any) (N, error) {
 	var zero N
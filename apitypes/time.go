@@ -0,0 +1,22 @@
+package apitypes
+
+// Timestamps in DTOs follow one of two conventions, picked by the suffix of
+// the JSON field name:
+//
+//   - "...At": server wall-clock time, an RFC 3339 string in UTC. Meant for
+//     display and logs; client and server wall clocks drift apart, so never
+//     subtract it from a local time.
+//   - "...MonoNs": server monotonic time in nanoseconds since the server
+//     started. Convert it with the offset from the "time" route (see
+//     apiclient.TimeOffset) before comparing it with local time.
+//
+// Durations are integers suffixed "...Ms" or "...Ns". No other time-like
+// field names are used; a test over this package enforces it.
+
+// TimeSyncResponse answers a time-sync exchange: the client's monotonic
+// reading is echoed next to the server clocks at the time of the request.
+type TimeSyncResponse struct {
+	ClientMonoNs int64  `json:"clientMonoNs"`
+	ServerMonoNs int64  `json:"serverMonoNs"`
+	ServerAt     string `json:"serverAt"`
+}
@@ -0,0 +1,82 @@
+package apitypes_test
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTimeFieldConventions checks every DTO field against the timestamp
+// conventions documented in time.go.
+func TestTimeFieldConventions(t *testing.T) {
+	fset := token.NewFileSet()
+	entries, err := os.ReadDir(".")
+	require.NoError(t, err)
+	checked := 0
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".go") || strings.HasSuffix(e.Name(), "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, e.Name(), nil, 0)
+		require.NoError(t, err)
+		ast.Inspect(file, func(n ast.Node) bool {
+			ts, ok := n.(*ast.TypeSpec)
+			if !ok {
+				return true
+			}
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok {
+				return true
+			}
+			for _, f := range st.Fields.List {
+				if f.Tag == nil || len(f.Names) == 0 {
+					continue
+				}
+				tag, _ := strconv.Unquote(f.Tag.Value)
+				name, _, _ := strings.Cut(reflect.StructTag(tag).Get("json"), ",")
+				if name == "" || name == "-" {
+					continue
+				}
+				checked++
+				where := ts.Name.Name + "." + f.Names[0].Name
+				typ := typeName(f.Type)
+				lower := strings.ToLower(name)
+				switch {
+				case strings.HasSuffix(name, "MonoNs"):
+					assert.Equal(t, "int64", typ, "%s: monotonic timestamps are int64 nanoseconds", where)
+				case strings.HasSuffix(name, "At"):
+					assert.Equal(t, "string", typ, "%s: wall-clock timestamps are RFC 3339 strings", where)
+				case strings.HasSuffix(name, "Ms") || strings.HasSuffix(name, "Ns"):
+					assert.Contains(t, []string{"int", "int32", "int64", "uint32", "uint64"}, typ, "%s: durations are integers", where)
+				default:
+					for _, word := range []string{"time", "date", "stamp", "epoch"} {
+						assert.NotContains(t, lower, word, "%s: time-like field without a convention suffix", where)
+					}
+				}
+				assert.NotContains(t, []string{"time.Time", "time.Duration"}, typ, "%s: use a suffixed field instead", where)
+			}
+			return true
+		})
+	}
+	assert.Positive(t, checked)
+}
+
+func typeName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.StarExpr:
+		return typeName(e.X)
+	case *ast.SelectorExpr:
+		return typeName(e.X) + "." + e.Sel.Name
+	}
+	return ""
+}
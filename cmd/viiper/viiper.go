@@ -81,6 +81,20 @@ func findUserConfig(args []string) string {
 }
 
 func setupRawLogger(cli *config.CLI, logger *slog.Logger, closeFiles *[]io.Closer) log.RawLogger {
+	raw := setupTextRawLogger(cli, logger, closeFiles)
+	if cli.UsbPcap == "" {
+		return raw
+	}
+	pcap, err := log.NewPcapLogger(cli.UsbPcap, int64(cli.UsbPcapMaxSize)<<20)
+	if err != nil {
+		logger.Error("failed to open pcap file", "file", cli.UsbPcap, "error", err)
+		return raw
+	}
+	*closeFiles = append(*closeFiles, pcap)
+	return log.MultiRaw(raw, pcap)
+}
+
+func setupTextRawLogger(cli *config.CLI, logger *slog.Logger, closeFiles *[]io.Closer) log.RawLogger {
 	if cli.Log.RawFile != "" {
 		f, err := os.OpenFile(cli.Log.RawFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
 		if err != nil {
@@ -0,0 +1,79 @@
+package device
+
+import "sync"
+
+// HID class requests and the protocols of SET_PROTOCOL.
+const (
+	hidGetIdle     = 0x02
+	hidGetProtocol = 0x03
+	hidSetIdle     = 0x0A
+	hidSetProtocol = 0x0B
+
+	hidProtocolBoot   = 0x00
+	hidProtocolReport = 0x01
+)
+
+// BootProtocol tracks the protocol and idle rate a host selects for a HID
+// boot interface (keyboard or mouse). BIOS/UEFI hosts select the boot
+// protocol and then parse the fixed boot report regardless of the report
+// descriptor. The zero value is in report protocol, as after a USB reset.
+type BootProtocol struct {
+	mu   sync.Mutex
+	boot bool
+	idle uint8 // in units of 4 ms; 0 reports only on change
+}
+
+// Boot reports whether the host selected the boot protocol.
+func (p *BootProtocol) Boot() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.boot
+}
+
+// Idle returns the idle rate set by SET_IDLE, in units of 4 ms.
+func (p *BootProtocol) Idle() uint8 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.idle
+}
+
+// Reset returns to the report protocol and an idle rate of 0.
+func (p *BootProtocol) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.boot, p.idle = false, 0
+}
+
+// HandleControl answers GET_PROTOCOL, SET_PROTOCOL, GET_IDLE and SET_IDLE
+// sent to interface 0, the boot interface; handled is false for other
+// requests. The idle rate is kept for all reports alike.
+func (p *BootProtocol) HandleControl(bmRequestType, bRequest uint8, wValue, wIndex uint16) (resp []byte, handled bool) {
+	if wIndex != 0 {
+		return nil, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case bmRequestType == 0xA1 && bRequest == hidGetProtocol:
+		if p.boot {
+			return []byte{hidProtocolBoot}, true
+		}
+		return []byte{hidProtocolReport}, true
+	case bmRequestType == 0x21 && bRequest == hidSetProtocol:
+		switch wValue {
+		case hidProtocolBoot:
+			p.boot = true
+		case hidProtocolReport:
+			p.boot = false
+		default:
+			return nil, false
+		}
+		return nil, true
+	case bmRequestType == 0xA1 && bRequest == hidGetIdle:
+		return []byte{p.idle}, true
+	case bmRequestType == 0x21 && bRequest == hidSetIdle:
+		p.idle = uint8(wValue >> 8)
+		return nil, true
+	}
+	return nil, false
+}
@@ -0,0 +1,184 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits of DegradeConfig.
+const (
+	MaxDegradeDelay = 10 * time.Second
+	MaxDropBurst    = 1000
+)
+
+// Clock is the time source of a Degrader.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine once d has elapsed.
+	AfterFunc(d time.Duration, f func())
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                      { return time.Now() }
+func (systemClock) AfterFunc(d time.Duration, f func()) { time.AfterFunc(d, f) }
+
+// SystemClock is the wall clock.
+var SystemClock Clock = systemClock{}
+
+// DegradeConfig describes a simulated bad link between the stream client and
+// the device. The zero value disables degradation.
+type DegradeConfig struct {
+	Delay  time.Duration // added to every state
+	Jitter time.Duration // uniform random extra delay in [0, Jitter)
+	// DropRate is the probability that a state starts a drop burst.
+	DropRate float64
+	// DropBurst is the number of consecutive states lost per burst; 0 means 1.
+	DropBurst int
+	// Seed makes delays and drops reproducible; 0 picks a random seed.
+	Seed uint64
+}
+
+// Enabled reports whether c alters anything.
+func (c DegradeConfig) Enabled() bool {
+	return c.Delay > 0 || c.Jitter > 0 || c.DropRate > 0
+}
+
+// Validate checks c against the limits.
+func (c DegradeConfig) Validate() error {
+	switch {
+	case c.Delay < 0 || c.Jitter < 0:
+		return errors.New("delay and jitter must not be negative")
+	case c.Delay+c.Jitter > MaxDegradeDelay:
+		return errors.New("delay plus jitter exceeds 10s")
+	case c.DropRate < 0 || c.DropRate > 1:
+		return errors.New("drop rate must be between 0 and 1")
+	case c.DropBurst < 0 || c.DropBurst > MaxDropBurst:
+		return errors.New("drop burst must be between 0 and 1000")
+	}
+	return nil
+}
+
+// DegradeStats counts the states a Degrader has held back or lost.
+type DegradeStats struct {
+	Delayed uint64
+	Dropped uint64
+}
+
+// Degradable is implemented by device types that can simulate link
+// degradation between their stream handler and their input state.
+type Degradable interface {
+	Degrader() *Degrader
+}
+
+// Degrader delays and drops input states on their way to the device.
+// The zero value is ready to use, is disabled, and applies states
+// immediately. Delayed states are applied in the order they arrived.
+type Degrader struct {
+	clock  Clock
+	active atomic.Pointer[degradeState]
+	queue  delayQueue // latches run with its lock held and must not call back
+
+	delayed atomic.Uint64
+	dropped atomic.Uint64
+}
+
+type degradeState struct {
+	cfg DegradeConfig
+
+	mu    sync.Mutex // guards rng and burst
+	rng   *rand.Rand
+	burst int
+}
+
+// SetClock replaces the wall clock, e.g. with a fake one in tests.
+// It must be called before the Degrader is configured.
+func (d *Degrader) SetClock(c Clock) { d.clock = c }
+
+// Configure replaces the configuration; states already delayed are still
+// applied. A disabled configuration turns the Degrader off.
+func (d *Degrader) Configure(cfg DegradeConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if !cfg.Enabled() {
+		d.active.Store(nil)
+		return nil
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	d.active.Store(&degradeState{cfg: cfg, rng: rand.New(rand.NewPCG(seed, seed))})
+	return nil
+}
+
+// Config returns the current configuration and whether it is enabled.
+func (d *Degrader) Config() (DegradeConfig, bool) {
+	st := d.active.Load()
+	if st == nil {
+		return DegradeConfig{}, false
+	}
+	return st.cfg, true
+}
+
+// Active reports whether states must go through Apply: degradation is on or
+// delayed states are still pending. Stream handlers check it before building
+// the closure passed to Apply, which keeps the disabled path allocation-free.
+func (d *Degrader) Active() bool { return d.active.Load() != nil || d.queue.pending.Load() > 0 }
+
+// Stats returns the counters accumulated since the device was created.
+func (d *Degrader) Stats() DegradeStats {
+	return DegradeStats{Delayed: d.delayed.Load(), Dropped: d.dropped.Load()}
+}
+
+// Apply runs latch immediately, after the configured delay, or never.
+func (d *Degrader) Apply(latch func()) {
+	var delay time.Duration
+	if st := d.active.Load(); st != nil {
+		var drop bool
+		delay, drop = st.next()
+		if drop {
+			d.dropped.Add(1)
+			return
+		}
+	}
+	// States that are not delayed still queue up behind delayed ones.
+	due := func(now, _ time.Time) time.Time { return now.Add(delay) }
+	if d.queue.push(d.clockOrSystem(), due, latch) && delay > 0 {
+		d.delayed.Add(1)
+	}
+}
+
+// Wait blocks until every delayed state has been applied or ctx is done.
+func (d *Degrader) Wait(ctx context.Context) error { return d.queue.wait(ctx) }
+
+func (d *Degrader) clockOrSystem() Clock {
+	if d.clock == nil {
+		return SystemClock
+	}
+	return d.clock
+}
+
+// next decides the fate of one state.
+func (st *degradeState) next() (time.Duration, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.burst > 0 {
+		st.burst--
+		return 0, true
+	}
+	if st.cfg.DropRate > 0 && st.rng.Float64() < st.cfg.DropRate {
+		st.burst = max(st.cfg.DropBurst, 1) - 1
+		return 0, true
+	}
+	delay := st.cfg.Delay
+	if st.cfg.Jitter > 0 {
+		delay += time.Duration(st.rng.Int64N(int64(st.cfg.Jitter)))
+	}
+	return delay, false
+}
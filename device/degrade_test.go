@@ -0,0 +1,156 @@
+package device_test
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/device"
+)
+
+type fakeTimer struct {
+	due time.Time
+	seq int
+	f   func()
+}
+
+// fakeClock fires AfterFunc callbacks synchronously from Advance.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    int
+	timers []fakeTimer
+}
+
+func newFakeClock() *fakeClock { return &fakeClock{now: time.Unix(0, 0)} }
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	c.timers = append(c.timers, fakeTimer{due: c.now.Add(d), seq: c.seq, f: f})
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+	for {
+		c.mu.Lock()
+		sort.Slice(c.timers, func(i, j int) bool {
+			if c.timers[i].due.Equal(c.timers[j].due) {
+				return c.timers[i].seq < c.timers[j].seq
+			}
+			return c.timers[i].due.Before(c.timers[j].due)
+		})
+		if len(c.timers) == 0 || c.timers[0].due.After(end) {
+			c.now = end
+			c.mu.Unlock()
+			return
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = t.due
+		c.mu.Unlock()
+		t.f()
+	}
+}
+
+func TestDegraderDelay(t *testing.T) {
+	clock := newFakeClock()
+	var d device.Degrader
+	d.SetClock(clock)
+
+	var applied []time.Duration
+	latch := func() { applied = append(applied, clock.Now().Sub(time.Unix(0, 0))) }
+
+	d.Apply(latch)
+	assert.Equal(t, []time.Duration{0}, applied, "disabled degrader applies immediately")
+	assert.False(t, d.Active())
+
+	require.NoError(t, d.Configure(device.DegradeConfig{Delay: 50 * time.Millisecond}))
+	for range 3 {
+		d.Apply(latch)
+		clock.Advance(10 * time.Millisecond)
+	}
+	clock.Advance(100 * time.Millisecond)
+	assert.Equal(t, []time.Duration{0, 50 * time.Millisecond, 60 * time.Millisecond, 70 * time.Millisecond}, applied)
+
+	// States sent after turning degradation off still queue behind delayed ones.
+	applied = nil
+	d.Apply(latch) // due at 180 ms
+	require.NoError(t, d.Configure(device.DegradeConfig{}))
+	assert.True(t, d.Active())
+	d.Apply(latch)
+	assert.Empty(t, applied)
+	clock.Advance(50 * time.Millisecond)
+	assert.Equal(t, []time.Duration{180 * time.Millisecond, 180 * time.Millisecond}, applied)
+	assert.False(t, d.Active())
+	assert.Equal(t, device.DegradeStats{Delayed: 4}, d.Stats())
+}
+
+func TestDegraderWait(t *testing.T) {
+	clock := newFakeClock()
+	var d device.Degrader
+	d.SetClock(clock)
+	require.NoError(t, d.Wait(context.Background()), "nothing pending")
+
+	require.NoError(t, d.Configure(device.DegradeConfig{Delay: 50 * time.Millisecond}))
+	applied := 0
+	d.Apply(func() { applied++ })
+	d.Apply(func() { applied++ })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, d.Wait(ctx), context.Canceled)
+
+	done := make(chan error, 1)
+	go func() { done <- d.Wait(context.Background()) }()
+	clock.Advance(50 * time.Millisecond)
+	require.NoError(t, <-done)
+	assert.Equal(t, 2, applied)
+}
+
+func TestDegraderDrop(t *testing.T) {
+	run := func(cfg device.DegradeConfig) []int {
+		var d device.Degrader
+		d.SetClock(newFakeClock())
+		require.NoError(t, d.Configure(cfg))
+		var dropped []int
+		for i := range 20 {
+			ok := false
+			d.Apply(func() { ok = true })
+			if !ok {
+				dropped = append(dropped, i)
+			}
+		}
+		assert.Equal(t, uint64(len(dropped)), d.Stats().Dropped)
+		return dropped
+	}
+
+	assert.Equal(t, []int{5, 14}, run(device.DegradeConfig{DropRate: 0.25, Seed: 42}))
+	assert.Equal(t, []int{5, 6, 7, 16, 17, 18}, run(device.DegradeConfig{DropRate: 0.25, DropBurst: 3, Seed: 42}))
+}
+
+func TestDegradeConfigValidate(t *testing.T) {
+	for _, cfg := range []device.DegradeConfig{
+		{Delay: -time.Millisecond},
+		{Delay: 8 * time.Second, Jitter: 3 * time.Second},
+		{DropRate: 1.5},
+		{DropBurst: device.MaxDropBurst + 1},
+	} {
+		var d device.Degrader
+		assert.Error(t, d.Configure(cfg), "%+v", cfg)
+	}
+}
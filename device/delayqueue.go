@@ -0,0 +1,81 @@
+package device
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// delayQueue applies latches in the order they were pushed, each no earlier
+// than its due time.
+type delayQueue struct {
+	// mu guards the queue and serializes delivery; latches run with mu held
+	// and must not call back into the owner of the queue.
+	mu      sync.Mutex
+	queue   []func()
+	last    time.Time     // due time of the last queued latch
+	drained chan struct{} // closed when the queue empties, see wait
+	pending atomic.Int64  // len(queue), readable without mu
+}
+
+// push runs latch at the time returned by due, given the current time and
+// the due time of the previous latch, and never before that previous latch.
+// A latch due now with nothing queued runs right away; push reports whether
+// latch was queued instead.
+func (q *delayQueue) push(clock Clock, due func(now, last time.Time) time.Time, latch func()) bool {
+	q.mu.Lock()
+	now := clock.Now()
+	at := due(now, q.last)
+	if at.Before(q.last) {
+		at = q.last
+	}
+	if len(q.queue) == 0 && !at.After(now) {
+		q.mu.Unlock()
+		latch()
+		return false
+	}
+	q.last = at
+	q.queue = append(q.queue, latch)
+	q.pending.Add(1)
+	q.mu.Unlock()
+	// Timers fire in due order, so each one delivers the oldest queued
+	// latch, which is due no later than the latch it was started for.
+	clock.AfterFunc(max(at.Sub(now), 0), q.deliverNext)
+	return true
+}
+
+func (q *delayQueue) deliverNext() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	latch := q.queue[0]
+	q.queue[0] = nil
+	q.queue = q.queue[1:]
+	latch()
+	// Only now may stream handlers bypass the queue again.
+	q.pending.Add(-1)
+	if len(q.queue) == 0 && q.drained != nil {
+		close(q.drained)
+		q.drained = nil
+	}
+}
+
+// wait blocks until every queued latch has run or ctx is done.
+func (q *delayQueue) wait(ctx context.Context) error {
+	q.mu.Lock()
+	if len(q.queue) == 0 {
+		q.mu.Unlock()
+		return nil
+	}
+	if q.drained == nil {
+		q.drained = make(chan struct{})
+	}
+	drained := q.drained
+	q.mu.Unlock()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,38 @@
+package device
+
+import "fmt"
+
+// DisconnectPolicy is what happens to a device when the client streaming its
+// input goes away.
+type DisconnectPolicy string
+
+const (
+	// DisconnectHoldLastState keeps reporting the last streamed state until a
+	// client reconnects or the reconnect timeout removes the device.
+	DisconnectHoldLastState DisconnectPolicy = "hold-last-state"
+	// DisconnectNeutralState releases all controls, see InputResetter, and
+	// otherwise behaves like DisconnectHoldLastState.
+	DisconnectNeutralState DisconnectPolicy = "neutral-state"
+	// DisconnectDetach removes the device from its bus at once, which the
+	// host sees as an unplug.
+	DisconnectDetach DisconnectPolicy = "detach"
+)
+
+// ParseDisconnectPolicy returns the policy named s; the empty string is
+// DisconnectHoldLastState.
+func ParseDisconnectPolicy(s string) (DisconnectPolicy, error) {
+	switch p := DisconnectPolicy(s); p {
+	case "":
+		return DisconnectHoldLastState, nil
+	case DisconnectHoldLastState, DisconnectNeutralState, DisconnectDetach:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown disconnect policy %q", s)
+}
+
+// InputResetter is implemented by devices that can return to the state they
+// report before any input was streamed: no buttons or keys held, sticks
+// centered.
+type InputResetter interface {
+	ResetInputState()
+}
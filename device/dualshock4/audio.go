@@ -0,0 +1,189 @@
+package dualshock4
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+)
+
+// With the audio stub a DualShock 4 enumerates like the real controller: a
+// composite of the headset jack's audio functions (interfaces 0-2) and the
+// gamepad (interface 3). Speaker audio is discarded and the microphone
+// records silence.
+const (
+	audioSpeakerEp    = 0x01 // isochronous OUT, 32 kHz stereo 16 bit
+	audioMicEp        = 0x82 // isochronous IN, 16 kHz mono 16 bit
+	audioSpeakerRate  = 32000
+	audioMicRate      = 16000
+	audioMicFrameSize = audioMicRate / 1000 * 2
+
+	audioInterfaces = 3
+	audioHIDIface   = 3
+)
+
+// Audio class 1.0 requests and control selectors.
+const (
+	audioSetCur = 0x01
+	audioGetCur = 0x81
+	audioGetMin = 0x82
+	audioGetMax = 0x83
+	audioGetRes = 0x84
+
+	audioMuteControl     = 0x01
+	audioVolumeControl   = 0x02
+	audioSamplingFreqCtl = 0x01
+
+	audioVolumeMin = 0xC400 // -60 dB in 1/256 dB steps
+	audioVolumeMax = 0x0000
+	audioVolumeRes = 0x0100
+)
+
+// audioStub holds the audio controls the host set, keyed by wValue and wIndex.
+type audioStub struct {
+	mu  sync.Mutex
+	cur map[[2]uint16][]byte
+}
+
+func newAudioStub() *audioStub {
+	a := &audioStub{cur: map[[2]uint16][]byte{}}
+	for ep, rate := range map[uint16]uint32{audioSpeakerEp: audioSpeakerRate, audioMicEp: audioMicRate} {
+		a.cur[[2]uint16{audioSamplingFreqCtl << 8, ep}] = []byte{byte(rate), byte(rate >> 8), byte(rate >> 16)}
+	}
+	return a
+}
+
+// control answers an audio class request. handled is false for requests
+// that are not addressed to an audio interface or endpoint.
+func (a *audioStub) control(bmRequestType, bRequest uint8, wValue, wIndex uint16, data []byte) (resp []byte, handled bool) {
+	var size int
+	cs := uint8(wValue >> 8)
+	switch bmRequestType &^ 0x80 {
+	case 0x21: // class, interface
+		if uint8(wIndex) >= audioInterfaces {
+			return nil, false
+		}
+		switch cs {
+		case audioMuteControl:
+			size = 1
+		case audioVolumeControl:
+			size = 2
+		}
+	case 0x22: // class, endpoint
+		if ep := uint8(wIndex); (ep != audioSpeakerEp && ep != audioMicEp) || cs != audioSamplingFreqCtl {
+			return nil, false
+		}
+		size = 3
+	default:
+		return nil, false
+	}
+	if size == 0 {
+		return nil, false
+	}
+
+	key := [2]uint16{wValue, wIndex}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case bRequest == audioSetCur && bmRequestType&0x80 == 0:
+		v := make([]byte, size)
+		copy(v, data)
+		a.cur[key] = v
+		return nil, true
+	case bRequest == audioGetCur && bmRequestType&0x80 != 0:
+		if v, ok := a.cur[key]; ok {
+			return append([]byte(nil), v...), true
+		}
+		return make([]byte, size), true
+	case cs == audioVolumeControl && size == 2 && bmRequestType&0x80 != 0:
+		var v uint16
+		switch bRequest {
+		case audioGetMin:
+			v = audioVolumeMin
+		case audioGetMax:
+			v = audioVolumeMax
+		case audioGetRes:
+			v = audioVolumeRes
+		default:
+			return nil, false
+		}
+		return binary.LittleEndian.AppendUint16(nil, v), true
+	}
+	return nil, false
+}
+
+// transfer serves the audio endpoints.
+func (a *audioStub) transfer(ep uint32, dir uint32) ([]byte, bool) {
+	switch {
+	case dir == usbip.DirOut && ep == audioSpeakerEp:
+		return nil, true
+	case dir == usbip.DirIn && ep == audioMicEp&0x0f:
+		return make([]byte, audioMicFrameSize), true
+	}
+	return nil, false
+}
+
+// compositeInterfaces returns the interfaces of the audio stub: the audio
+// interfaces of a real DualShock 4 followed by a copy of hidIface.
+func compositeInterfaces(hidIface usb.InterfaceConfig) []usb.InterfaceConfig {
+	cs := func(payload ...uint8) usb.ClassSpecificDescriptor {
+		return usb.ClassSpecificDescriptor{DescriptorType: 0x24, Payload: payload}
+	}
+	iface := func(num, alt, numEps, subClass uint8) usb.InterfaceDescriptor {
+		return usb.InterfaceDescriptor{
+			BInterfaceNumber:   num,
+			BAlternateSetting:  alt,
+			BNumEndpoints:      numEps,
+			BInterfaceClass:    0x01,
+			BInterfaceSubClass: subClass,
+		}
+	}
+	isoEndpoint := func(addr, attrs uint8, maxPacket uint16) usb.EndpointDescriptor {
+		return usb.EndpointDescriptor{
+			BEndpointAddress: addr,
+			BMAttributes:     attrs,
+			WMaxPacketSize:   maxPacket,
+			BInterval:        1,
+			Audio:            true,
+			ClassDescriptors: []usb.ClassSpecificDescriptor{
+				{DescriptorType: 0x25, Payload: usb.Data{0x01, 0x00, 0x00, 0x00, 0x00}},
+			},
+		}
+	}
+
+	hidIface.Descriptor.BInterfaceNumber = audioHIDIface
+	return []usb.InterfaceConfig{
+		{
+			Descriptor: iface(0, 0, 0, 0x01),
+			ClassDescriptors: []usb.ClassSpecificDescriptor{
+				cs(0x01, 0x00, 0x01, 0x47, 0x00, 0x02, 0x01, 0x02),             // header, interfaces 1 and 2
+				cs(0x02, 0x01, 0x01, 0x01, 0x06, 0x02, 0x03, 0x00, 0x00, 0x00), // USB streaming in
+				cs(0x06, 0x02, 0x01, 0x01, 0x03, 0x00, 0x00, 0x00),             // feature unit: mute, volume
+				cs(0x03, 0x03, 0x02, 0x04, 0x04, 0x02, 0x00),                   // headset out
+				cs(0x02, 0x04, 0x02, 0x04, 0x03, 0x01, 0x00, 0x00, 0x00, 0x00), // headset mic in
+				cs(0x06, 0x05, 0x04, 0x01, 0x03, 0x00, 0x00),                   // feature unit: mute, volume
+				cs(0x03, 0x06, 0x01, 0x01, 0x01, 0x05, 0x00),                   // USB streaming out
+			},
+		},
+		{Descriptor: iface(1, 0, 0, 0x02)},
+		{
+			Descriptor: iface(1, 1, 1, 0x02),
+			ClassDescriptors: []usb.ClassSpecificDescriptor{
+				cs(0x01, 0x01, 0x01, 0x01, 0x00),
+				cs(0x02, 0x01, 0x02, 0x02, 0x10, 0x01, 0x00, 0x7D, 0x00), // PCM, 2 ch, 16 bit, 32 kHz
+			},
+			Endpoints: []usb.EndpointDescriptor{isoEndpoint(audioSpeakerEp, 0x09, 0x84)}, // adaptive
+		},
+		{Descriptor: iface(2, 0, 0, 0x02)},
+		{
+			Descriptor: iface(2, 1, 1, 0x02),
+			ClassDescriptors: []usb.ClassSpecificDescriptor{
+				cs(0x01, 0x06, 0x01, 0x01, 0x00),
+				cs(0x02, 0x01, 0x01, 0x02, 0x10, 0x01, 0x80, 0x3E, 0x00), // PCM, 1 ch, 16 bit, 16 kHz
+			},
+			Endpoints: []usb.EndpointDescriptor{isoEndpoint(audioMicEp, 0x05, 0x22)}, // asynchronous
+		},
+		hidIface,
+	}
+}
@@ -0,0 +1,124 @@
+package dualshock4
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usb/hid"
+)
+
+// In Bluetooth mode the device speaks the report format of the controller's
+// Bluetooth link: input and output report 0x11 carry the USB report body
+// after two header bytes and end with a CRC32 over a seed byte and the
+// report.
+const (
+	ReportIDBluetooth   = 0x11
+	BluetoothReportSize = 78
+)
+
+const (
+	modeUSB       = "usb"
+	modeBluetooth = "bluetooth"
+
+	btHeaderFlags = 0xC0 // HID report with CRC
+
+	btSeedInput   = 0xA1
+	btSeedOutput  = 0xA2
+	btSeedFeature = 0xA3
+
+	// btOutShift is how far the output fields of report 0x11 sit behind
+	// the OutOffset* of the USB output report.
+	btOutShift = 2
+
+	btCalibrationSize = 41
+)
+
+// btCRC returns the CRC32 of the report b prefixed by seed.
+func btCRC(seed byte, b []byte) uint32 {
+	return crc32.Update(crc32.ChecksumIEEE([]byte{seed}), crc32.IEEETable, b)
+}
+
+// putBTCRC stores the CRC of the report b in its last 4 bytes.
+func putBTCRC(seed byte, b []byte) {
+	n := len(b) - 4
+	binary.LittleEndian.PutUint32(b[n:], btCRC(seed, b[:n]))
+}
+
+func validBTCRC(seed byte, b []byte) bool {
+	n := len(b) - 4
+	return n > 0 && binary.LittleEndian.Uint32(b[n:]) == btCRC(seed, b[:n])
+}
+
+// buildBTInputReport builds input report 0x11 from the USB input report.
+func (d *DualShock4) buildBTInputReport(s InputState) []byte {
+	b := make([]byte, BluetoothReportSize)
+	b[0] = ReportIDBluetooth
+	b[1] = btHeaderFlags
+	copy(b[3:], d.buildUSBInputReport(s)[1:])
+	putBTCRC(btSeedInput, b)
+	return b
+}
+
+// btCalibration returns feature report 0x05, which Bluetooth hosts read
+// instead of 0x02 and check against its CRC.
+func btCalibration() []byte {
+	b := make([]byte, btCalibrationSize)
+	b[0] = 0x05
+	putBTCRC(btSeedFeature, b)
+	return b
+}
+
+// parseOutputReport decodes a USB output report 0x05 or, in Bluetooth mode,
+// an output report 0x11 with a valid CRC.
+func (d *DualShock4) parseOutputReport(b []byte) (OutputState, bool) {
+	shift := 0
+	switch {
+	case len(b) >= 11 && b[OutOffsetReportID] == ReportIDOutput:
+	case d.bluetooth && len(b) == BluetoothReportSize && b[0] == ReportIDBluetooth:
+		if !validBTCRC(btSeedOutput, b) {
+			return OutputState{}, false
+		}
+		shift = btOutShift
+	default:
+		return OutputState{}, false
+	}
+	return OutputState{
+		RumbleSmall: b[shift+OutOffsetRumbleSmall],
+		RumbleLarge: b[shift+OutOffsetRumbleLarge],
+		LedRed:      b[shift+OutOffsetLedRed],
+		LedGreen:    b[shift+OutOffsetLedGreen],
+		LedBlue:     b[shift+OutOffsetLedBlue],
+		FlashOn:     b[shift+OutOffsetFlashOn],
+		FlashOff:    b[shift+OutOffsetFlashOff],
+	}, true
+}
+
+// bluetoothInterface returns iface with the report descriptor of the
+// Bluetooth report format.
+func bluetoothInterface(iface usb.InterfaceConfig) usb.InterfaceConfig {
+	f := *iface.HID
+	f.Report = hid.Report{
+		Items: []hid.Item{
+			hid.UsagePage{Page: hid.UsagePageGenericDesktop},
+			hid.Usage{Usage: hid.UsageGamePad},
+			hid.Collection{Kind: hid.CollectionApplication, Items: []hid.Item{
+				hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x08, Data: hid.Data{ReportIDBluetooth}},
+
+				hid.UsagePage{Page: 0xFF00},
+				hid.Usage{Usage: 0x20},
+				hid.LogicalMinimum{Min: 0},
+				hid.LogicalMaximum{Max: 255},
+				hid.ReportSize{Bits: 8},
+				hid.ReportCount{Count: BluetoothReportSize - 1},
+				hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+
+				hid.Usage{Usage: 0x21},
+				hid.ReportCount{Count: BluetoothReportSize - 1},
+				hid.Output{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+			}},
+		},
+	}
+	iface.HID = &f
+	return iface
+}
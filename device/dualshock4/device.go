@@ -2,6 +2,8 @@ package dualshock4
 
 import (
 	"encoding/binary"
+	"encoding/json"
+	"fmt"
 	"log/slog"
 	"sync"
 	"sync/atomic"
@@ -15,27 +17,97 @@ import (
 type DualShock4 struct {
 	inputState *InputState
 	stateMu    sync.Mutex
-	outputFunc func(OutputState)
+	outputFunc func(OutputState) // guarded by stateMu
 	descriptor usb.Descriptor
+	// outputSizes are the sizes of the output reports, by report ID.
+	outputSizes map[uint8]int
+
+	playerSlot   int
+	lightBar     [3]uint8
+	lightBarHost bool // set once the host has sent an output report
 
 	usbReportTimestamp uint32
 	usbPacketCounter   uint32
+
+	inputChanged bool // since the last input report
+	notify       usb.Notifier
+
+	degrade device.Degrader
+	step    device.Stepper
+	audio   *audioStub // nil unless created with the audio stub
+
+	bluetooth bool // reports in the Bluetooth format, see ReportIDBluetooth
+}
+
+type DualShock4CreateOptions struct {
+	// AudioStub adds the audio interfaces of the real controller.
+	AudioStub *bool `json:"audioStub"`
+	// Mode is the report format, "usb" (default) or "bluetooth".
+	Mode *string `json:"mode"`
 }
 
 func New(o *device.CreateOptions) (*DualShock4, error) {
 	d := &DualShock4{
 		descriptor: defaultDescriptor,
 	}
+	hidIface := defaultDescriptor.Interfaces[0]
 	if o != nil {
-		if o.IdVendor != nil {
-			d.descriptor.Device.IDVendor = *o.IdVendor
+		if err := o.ApplyIdentity(&d.descriptor); err != nil {
+			return nil, err
+		}
+		if o.PlayerSlot != nil {
+			d.playerSlot = *o.PlayerSlot
+			if d.playerSlot >= 1 && d.playerSlot <= len(slotColors) {
+				d.lightBar = slotColors[d.playerSlot-1]
+			}
 		}
-		if o.IdProduct != nil {
-			d.descriptor.Device.IDProduct = *o.IdProduct
+		if o.Deterministic != nil {
+			d.step.Configure(*o.Deterministic)
 		}
+		if o.DeviceSpecific != nil {
+			data, err := json.Marshal(o.DeviceSpecific)
+			var args DualShock4CreateOptions
+			if err != nil {
+				return nil, fmt.Errorf("invalid JSON payload: %w", err)
+			}
+			err = json.Unmarshal(data, &args)
+			if err != nil {
+				return nil, fmt.Errorf("invalid JSON payload: %w", err)
+			}
+			if args.Mode != nil {
+				switch *args.Mode {
+				case modeUSB:
+				case modeBluetooth:
+					d.bluetooth = true
+					hidIface = bluetoothInterface(hidIface)
+					d.descriptor.Interfaces = []usb.InterfaceConfig{hidIface}
+				default:
+					return nil, fmt.Errorf("unknown mode %q", *args.Mode)
+				}
+			}
+			if args.AudioStub != nil && *args.AudioStub {
+				d.audio = newAudioStub()
+				d.descriptor.Interfaces = compositeInterfaces(hidIface)
+			}
+		}
+	}
+
+	sizes, err := hidIface.HID.Report.OutputReportSizes()
+	if err != nil {
+		return nil, fmt.Errorf("size output reports: %w", err)
 	}
+	d.outputSizes = sizes
+
+	d.inputState = neutralInputState()
+	d.inputChanged = true
+
+	return d, nil
+}
 
-	d.inputState = &InputState{
+// neutralInputState returns the state of a controller at rest: nothing
+// pressed, sticks centered, lying flat.
+func neutralInputState() *InputState {
+	return &InputState{
 		LX:           0,
 		LY:           0,
 		RX:           0,
@@ -57,54 +129,149 @@ func New(o *device.CreateOptions) (*DualShock4, error) {
 		AccelY:       DefaultAccelYRaw,
 		AccelZ:       DefaultAccelZRaw,
 	}
+}
 
-	return d, nil
+// slotColors are the light bar colors a PS4 assigns to players 1-4.
+var slotColors = [][3]uint8{
+	{0x00, 0x00, 0x40}, // blue
+	{0x40, 0x00, 0x00}, // red
+	{0x00, 0x40, 0x00}, // green
+	{0x20, 0x00, 0x20}, // pink
 }
 
 func (d *DualShock4) SetOutputCallback(f func(OutputState)) {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
 	d.outputFunc = f
 }
 
+// PlayerSlot returns the player slot the device was created with.
+func (d *DualShock4) PlayerSlot() int {
+	return d.playerSlot
+}
+
+// Degrader returns the link degradation simulator applied to streamed input.
+func (d *DualShock4) Degrader() *device.Degrader {
+	return &d.degrade
+}
+
+// Stepper returns the queue of streamed states in deterministic mode.
+func (d *DualShock4) Stepper() *device.Stepper {
+	return &d.step
+}
+
+// LightBar returns the effective light bar color: the one last set by the
+// host, or the player slot color until the host sets one.
+func (d *DualShock4) LightBar() (r, g, b uint8) {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	return d.lightBar[0], d.lightBar[1], d.lightBar[2]
+}
+
+// SlotHint returns the feedback announcing the player slot color, as long as
+// the device has a slot color and the host has not set the light bar yet.
+func (d *DualShock4) SlotHint() (OutputState, bool) {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	if d.lightBarHost || d.lightBar == [3]uint8{} {
+		return OutputState{}, false
+	}
+	return OutputState{LedRed: d.lightBar[0], LedGreen: d.lightBar[1], LedBlue: d.lightBar[2]}, true
+}
+
+func (d *DualShock4) handleOutput(feedback OutputState) {
+	d.stateMu.Lock()
+	d.lightBar = [3]uint8{feedback.LedRed, feedback.LedGreen, feedback.LedBlue}
+	d.lightBarHost = true
+	outputFunc := d.outputFunc
+	d.stateMu.Unlock()
+	if outputFunc != nil {
+		outputFunc(feedback)
+	}
+}
+
 func (d *DualShock4) UpdateInputState(state *InputState) {
 	d.stateMu.Lock()
 	defer d.stateMu.Unlock()
 	d.inputState = state
+	d.inputChanged = true
+	d.notify.Notify()
 }
 
+// ResetInputState releases all buttons and touches, centers the sticks and
+// leaves the controller lying flat.
+func (d *DualShock4) ResetInputState() {
+	d.UpdateInputState(neutralInputState())
+}
+
+// HandleTransfer serves the input and output endpoints, and those of the
+// audio stub if enabled.
 func (d *DualShock4) HandleTransfer(ep uint32, dir uint32, out []byte) []byte {
-	if dir == usbip.DirIn {
-		switch ep {
-		case 4:
-			d.stateMu.Lock()
-			st := *d.inputState
-			d.stateMu.Unlock()
-			return d.buildUSBInputReport(st)
-		default:
-			return nil
+	resp, _ := d.transfer(ep, dir, out)
+	return resp
+}
+
+// transfer is HandleTransfer reporting whether ep serves dir.
+func (d *DualShock4) transfer(ep uint32, dir uint32, out []byte) ([]byte, bool) {
+	switch {
+	case dir == usbip.DirIn && ep == 4:
+		d.stateMu.Lock()
+		st := *d.inputState
+		d.inputChanged = false
+		d.stateMu.Unlock()
+		return d.buildInputReport(st), true
+	case dir == usbip.DirOut && ep == 3:
+		if feedback, ok := d.parseOutputReport(out); ok {
+			d.handleOutput(feedback)
 		}
+		return nil, true
 	}
+	if d.audio != nil {
+		return d.audio.transfer(ep, dir)
+	}
+	return nil, false
+}
 
-	if dir == usbip.DirOut && ep == 3 {
-		if len(out) >= 11 && out[OutOffsetReportID] == ReportIDOutput {
-			feedback := OutputState{
-				RumbleSmall: out[OutOffsetRumbleSmall],
-				RumbleLarge: out[OutOffsetRumbleLarge],
-				LedRed:      out[OutOffsetLedRed],
-				LedGreen:    out[OutOffsetLedGreen],
-				LedBlue:     out[OutOffsetLedBlue],
-				FlashOn:     out[OutOffsetFlashOn],
-				FlashOff:    out[OutOffsetFlashOff],
-			}
-			if d.outputFunc != nil {
-				d.outputFunc(feedback)
-			}
+// OutputReportSize returns the size of the output reports hosts may split
+// across several transfers, as declared in the report descriptor.
+func (d *DualShock4) OutputReportSize(ep uint32, first byte) int {
+	if ep != EndpointOut {
+		return 0
+	}
+	return d.outputSizes[first]
+}
+
+// HandleTransferStatus holds polls of the input endpoint while the input
+// state is unchanged since the last report, see usb.ErrPending, and stalls
+// transfers no endpoint serves.
+func (d *DualShock4) HandleTransferStatus(ep uint32, dir uint32, out []byte) ([]byte, error) {
+	if dir == usbip.DirIn && ep == 4 {
+		d.stateMu.Lock()
+		changed := d.inputChanged
+		d.stateMu.Unlock()
+		if !changed {
+			return nil, usb.ErrPending
 		}
 	}
+	resp, ok := d.transfer(ep, dir, out)
+	if !ok {
+		return nil, usb.StatusStall
+	}
+	return resp, nil
+}
 
-	return nil
+// InputNotifier returns the notifier fired by UpdateInputState.
+func (d *DualShock4) InputNotifier() *usb.Notifier {
+	return &d.notify
 }
 
-func (d *DualShock4) HandleControl(bmRequestType, bRequest uint8, wValue, _ /* wIndex */, wLength uint16, data []byte) ([]byte, bool) {
+func (d *DualShock4) HandleControl(bmRequestType, bRequest uint8, wValue, wIndex, wLength uint16, data []byte) ([]byte, bool) {
+	if d.audio != nil {
+		if resp, ok := d.audio.control(bmRequestType, bRequest, wValue, wIndex, data); ok {
+			return resp, true
+		}
+	}
+
 	const (
 		hidGetReport = 0x01
 		hidSetReport = 0x09
@@ -120,11 +287,11 @@ func (d *DualShock4) HandleControl(bmRequestType, bRequest uint8, wValue, _ /* w
 	reportID := uint8(wValue & 0xFF)
 
 	if bmRequestType == 0xA1 && bRequest == hidGetReport {
-		if reportType == reportTypeInput && reportID == ReportIDInput {
+		if reportType == reportTypeInput && reportID == d.inputReportID() {
 			d.stateMu.Lock()
 			st := *d.inputState
 			d.stateMu.Unlock()
-			report := d.buildUSBInputReport(st)
+			report := d.buildInputReport(st)
 			if wLength > 0 && int(wLength) < len(report) {
 				return report[:wLength], true
 			}
@@ -138,6 +305,9 @@ func (d *DualShock4) HandleControl(bmRequestType, bRequest uint8, wValue, _ /* w
 			case 0x03: // Device capabilities
 				return make([]byte, 48), true
 			case 0x05: // Gyro calibration
+				if d.bluetooth {
+					return btCalibration(), true
+				}
 				return make([]byte, 41), true
 			case 0x12: // Serial number
 				return make([]byte, 16), true
@@ -146,20 +316,11 @@ func (d *DualShock4) HandleControl(bmRequestType, bRequest uint8, wValue, _ /* w
 	}
 
 	if bmRequestType == 0x21 && bRequest == hidSetReport {
-		if reportType == reportTypeOutput && reportID == ReportIDOutput && len(data) >= 11 {
-			feedback := OutputState{
-				RumbleSmall: data[OutOffsetRumbleSmall],
-				RumbleLarge: data[OutOffsetRumbleLarge],
-				LedRed:      data[OutOffsetLedRed],
-				LedGreen:    data[OutOffsetLedGreen],
-				LedBlue:     data[OutOffsetLedBlue],
-				FlashOn:     data[OutOffsetFlashOn],
-				FlashOff:    data[OutOffsetFlashOff],
+		if reportType == reportTypeOutput && len(data) > 0 && data[0] == reportID {
+			if feedback, ok := d.parseOutputReport(data); ok {
+				d.handleOutput(feedback)
+				return nil, true
 			}
-			if d.outputFunc != nil {
-				d.outputFunc(feedback)
-			}
-			return nil, true
 		}
 	}
 
@@ -175,7 +336,34 @@ func (d *DualShock4) GetDescriptor() *usb.Descriptor {
 }
 
 func (x *DualShock4) GetDeviceSpecificArgs() map[string]any {
-	return map[string]any{}
+	args := map[string]any{}
+	if x.audio != nil {
+		args["audioStub"] = true
+	}
+	if x.bluetooth {
+		args["mode"] = modeBluetooth
+	}
+	return args
+}
+
+// Bluetooth reports whether the device was created in Bluetooth mode.
+func (d *DualShock4) Bluetooth() bool {
+	return d.bluetooth
+}
+
+func (d *DualShock4) inputReportID() uint8 {
+	if d.bluetooth {
+		return ReportIDBluetooth
+	}
+	return ReportIDInput
+}
+
+// buildInputReport builds the input report of the device's mode.
+func (d *DualShock4) buildInputReport(s InputState) []byte {
+	if d.bluetooth {
+		return d.buildBTInputReport(s)
+	}
+	return d.buildUSBInputReport(s)
 }
 
 func (d *DualShock4) buildUSBInputReport(s InputState) []byte {
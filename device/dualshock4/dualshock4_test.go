@@ -3,18 +3,21 @@ package dualshock4_test
 import (
 	"context"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"testing"
 	"time"
 
 	viiperTesting "github.com/Alia5/VIIPER/_testing"
 	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/device"
 	"github.com/Alia5/VIIPER/device/dualshock4"
 	"github.com/Alia5/VIIPER/internal/server/api"
 	"github.com/Alia5/VIIPER/internal/server/api/handler"
 	"github.com/Alia5/VIIPER/usbip"
 	"github.com/Alia5/VIIPER/virtualbus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	_ "github.com/Alia5/VIIPER/internal/registry" // Register devices
 )
@@ -472,3 +475,219 @@ func TestFeedback(t *testing.T) {
 		})
 	}
 }
+
+func TestPlayerSlotLightBar(t *testing.T) {
+	s := viiperTesting.NewTestServerWithConfig(t, viiperTesting.TestServerConfig(t))
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90106)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	client := apiclient.New(s.ApiServer.Addr())
+
+	readFeedback := func(stream *apiclient.DeviceStream) dualshock4.OutputState {
+		var buf [7]byte
+		_ = stream.SetReadDeadline(time.Now().Add(750 * time.Millisecond))
+		_, err := io.ReadFull(stream, buf[:])
+		require.NoError(t, err)
+		var out dualshock4.OutputState
+		require.NoError(t, out.UnmarshalBinary(buf[:]))
+		return out
+	}
+
+	colors := [][3]uint8{{0x00, 0x00, 0x40}, {0x40, 0x00, 0x00}, {0x00, 0x40, 0x00}, {0x20, 0x00, 0x20}}
+	streams := make([]*apiclient.DeviceStream, len(colors))
+	devIDs := make([]string, len(colors))
+	for i, c := range colors {
+		slot := i + 1
+		stream, dev, err := client.AddDeviceAndConnect(context.Background(), b.BusID(), "dualshock4", &device.CreateOptions{PlayerSlot: &slot})
+		require.NoError(t, err)
+		defer stream.Close()
+		assert.Equal(t, slot, dev.PlayerSlot)
+		streams[i], devIDs[i] = stream, dev.DevId
+
+		fb := readFeedback(stream)
+		assert.Equal(t, c, [3]uint8{fb.LedRed, fb.LedGreen, fb.LedBlue}, "slot %d", slot)
+	}
+
+	taken := 2
+	_, err = client.DeviceAdd(b.BusID(), "dualshock4", &device.CreateOptions{PlayerSlot: &taken})
+	assert.EqualError(t, err, "409 Conflict: player slot 2 is taken by device 2")
+	invalid := 0
+	_, err = client.DeviceAdd(b.BusID(), "keyboard", &device.CreateOptions{PlayerSlot: &invalid})
+	assert.EqualError(t, err, "400 Bad Request: playerSlot must be between 1 and 8")
+
+	list, err := client.DevicesList(b.BusID())
+	require.NoError(t, err)
+	require.Len(t, list.Devices, len(colors))
+	for i, d := range list.Devices {
+		assert.Equal(t, i+1, d.PlayerSlot)
+	}
+
+	// A host LED command overrides the slot hint.
+	usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := usbipClient.AttachDevice(fmt.Sprintf("%d-%s", b.BusID(), devIDs[2]))
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+	out := []byte{dualshock4.ReportIDOutput, 0x00, 0x00, 0x00, 0x00, 0x00, 0x11, 0x22, 0x33, 0x00, 0x00}
+	require.NoError(t, usbipClient.Submit(imp.Conn, usbip.DirOut, 3, out, nil))
+	fb := readFeedback(streams[2])
+	assert.Equal(t, [3]uint8{0x11, 0x22, 0x33}, [3]uint8{fb.LedRed, fb.LedGreen, fb.LedBlue})
+
+	var ds4 *dualshock4.DualShock4
+	for _, m := range b.GetAllDeviceMetas() {
+		if fmt.Sprintf("%d", m.Meta.DevId) == devIDs[2] {
+			ds4 = m.Dev.(*dualshock4.DualShock4)
+		}
+	}
+	require.NotNil(t, ds4)
+	red, green, blue := ds4.LightBar()
+	assert.Equal(t, [3]uint8{0x11, 0x22, 0x33}, [3]uint8{red, green, blue})
+	_, hinted := ds4.SlotHint()
+	assert.False(t, hinted, "no slot hint once the host set the light bar")
+}
+
+func TestAudioStub(t *testing.T) {
+	s := viiperTesting.NewTestServerWithConfig(t, viiperTesting.TestServerConfig(t))
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90130)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+
+	client := apiclient.New(s.ApiServer.Addr())
+	stream, dev, err := client.AddDeviceAndConnect(context.Background(), b.BusID(), "dualshock4",
+		&device.CreateOptions{DeviceSpecific: map[string]any{"audioStub": true}})
+	require.NoError(t, err)
+	defer stream.Close()
+	assert.Equal(t, map[string]any{"audioStub": true}, dev.DeviceSpecific)
+
+	usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	devs, err := usbipClient.ListDevices()
+	require.NoError(t, err)
+	require.Len(t, devs, 1)
+	assert.Equal(t, uint8(4), devs[0].NumIfaces)
+	require.Len(t, devs[0].Interfaces, 4, "alternate settings are not listed")
+	assert.Equal(t, uint8(0x03), devs[0].Interfaces[3].Class)
+	imp, err := usbipClient.AttachDevice(devs[0].BusID)
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+
+	// Select the streaming settings and set the speaker volume.
+	for _, iface := range []uint8{1, 2} {
+		_, err := usbipClient.Control(imp.Conn, [8]byte{0x01, 0x0b, 1, 0, iface, 0, 0, 0}, nil)
+		require.NoError(t, err)
+		alt, err := usbipClient.Control(imp.Conn, [8]byte{0x81, 0x0a, 0, 0, iface, 0, 1, 0}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1}, alt)
+	}
+	_, err = usbipClient.Control(imp.Conn, [8]byte{0x21, 0x01, 0x01, 0x02, 0x00, 0x02, 2, 0}, []byte{0x00, 0xf6})
+	require.NoError(t, err)
+	vol, err := usbipClient.Control(imp.Conn, [8]byte{0xa1, 0x81, 0x01, 0x02, 0x00, 0x02, 2, 0}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0xf6}, vol)
+	volMin, err := usbipClient.Control(imp.Conn, [8]byte{0xa1, 0x82, 0x01, 0x02, 0x00, 0x02, 2, 0}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0xc4}, volMin)
+	rate, err := usbipClient.Control(imp.Conn, [8]byte{0xa2, 0x81, 0x00, 0x01, 0x82, 0x00, 3, 0}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x80, 0x3e, 0x00}, rate, "16 kHz microphone")
+
+	// Speaker audio is swallowed; the microphone records silence.
+	speaker := make([]byte, 8*128)
+	for i := range speaker {
+		speaker[i] = byte(i)
+	}
+	pkts, _, err := usbipClient.IsoSubmit(imp.Conn, usbip.DirOut, 1, []uint32{128, 128, 128, 128, 128, 128, 128, 128}, speaker)
+	require.NoError(t, err)
+	for _, p := range pkts {
+		assert.Equal(t, uint32(128), p.ActualLength)
+	}
+	pkts, mic, err := usbipClient.IsoSubmit(imp.Conn, usbip.DirIn, 2, []uint32{34, 34, 34}, nil)
+	require.NoError(t, err)
+	for _, p := range pkts {
+		assert.Equal(t, uint32(32), p.ActualLength, "one 1 ms frame of 16 kHz mono")
+	}
+	assert.Equal(t, make([]byte, 3*32), mic)
+
+	// Audio does not disturb the gamepad on interface 3.
+	require.NoError(t, stream.WriteBinary(&dualshock4.InputState{Buttons: dualshock4.ButtonCross}))
+	deadline := time.Now().Add(750 * time.Millisecond)
+	var report []byte
+	for time.Now().Before(deadline) {
+		report, err = usbipClient.ReadEndpointWithTimeout(imp.Conn, 4, 250*time.Millisecond)
+		require.NoError(t, err)
+		if report[5]&0xf0 == uint8(dualshock4.ButtonCross) {
+			break
+		}
+	}
+	require.Len(t, report, dualshock4.InputReportSize)
+	assert.Equal(t, uint8(dualshock4.ButtonCross)|dualshock4.DPadUSBNeutral, report[5])
+
+	var ds4 *dualshock4.DualShock4
+	for _, m := range b.GetAllDeviceMetas() {
+		ds4 = m.Dev.(*dualshock4.DualShock4)
+	}
+	st, ok := s.UsbServer.LinkStats(ds4)
+	require.True(t, ok)
+	assert.GreaterOrEqual(t, st.BytesOut, uint64(len(speaker)))
+}
+
+func TestBluetoothMode(t *testing.T) {
+	ds4, err := dualshock4.New(&device.CreateOptions{DeviceSpecific: map[string]any{"mode": "bluetooth"}})
+	require.NoError(t, err)
+	assert.True(t, ds4.Bluetooth())
+	assert.Equal(t, map[string]any{"mode": "bluetooth"}, ds4.GetDeviceSpecificArgs())
+	assert.Equal(t, dualshock4.BluetoothReportSize, ds4.OutputReportSize(dualshock4.EndpointOut, dualshock4.ReportIDBluetooth))
+
+	// The CRC32 covers the seed byte 0xA1 followed by the first 74 bytes.
+	want := make([]byte, dualshock4.BluetoothReportSize)
+	want[0], want[1] = 0x11, 0xc0
+	want[3], want[4], want[5], want[6] = 0x80, 0x80, 0x80, 0x80
+	want[7] = 0x08
+	want[9] = 0x04
+	want[12] = 0x01
+	want[25], want[26] = 0x61, 0xec
+	want[32] = 0x0b
+	want[37] = 0x80
+	want[41] = 0x80
+	copy(want[74:], []byte{0x92, 0x06, 0x08, 0x79})
+	got := ds4.HandleTransfer(4, usbip.DirIn, nil)
+	assert.Equal(t, want, got)
+
+	var fb []dualshock4.OutputState
+	ds4.SetOutputCallback(func(o dualshock4.OutputState) { fb = append(fb, o) })
+	out := make([]byte, dualshock4.BluetoothReportSize)
+	out[0], out[1], out[3] = 0x11, 0xc0, 0x07
+	copy(out[6:], []byte{0x12, 0xfe, 0x01, 0x02, 0x03, 0x04, 0x05})
+	copy(out[74:], []byte{0x6b, 0x0d, 0x0d, 0x45})
+	ds4.HandleTransfer(3, usbip.DirOut, out)
+	bad := append([]byte(nil), out...)
+	bad[77] ^= 0xff
+	ds4.HandleTransfer(3, usbip.DirOut, bad)
+	_, ok := ds4.HandleControl(0x21, 0x09, 0x0211, 0, uint16(len(out)), out)
+	require.True(t, ok)
+	want1 := dualshock4.OutputState{RumbleSmall: 0x12, RumbleLarge: 0xfe, LedRed: 0x01, LedGreen: 0x02, LedBlue: 0x03, FlashOn: 0x04, FlashOff: 0x05}
+	assert.Equal(t, []dualshock4.OutputState{want1, want1}, fb, "reports with a bad CRC are dropped")
+
+	cal, ok := ds4.HandleControl(0xa1, 0x01, 0x0305, 0, 41, nil)
+	require.True(t, ok)
+	require.Len(t, cal, 41)
+	assert.Equal(t, []byte{0x05, 0x40, 0xb2, 0x66, 0x26}, append(cal[:1:1], cal[37:]...))
+
+	_, err = dualshock4.New(&device.CreateOptions{DeviceSpecific: map[string]any{"mode": "serial"}})
+	assert.EqualError(t, err, `unknown mode "serial"`)
+}
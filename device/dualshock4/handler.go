@@ -1,6 +1,8 @@
 package dualshock4
 
 import (
+	"encoding"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
@@ -13,12 +15,32 @@ import (
 
 func init() {
 	api.RegisterDevice("dualshock4", &handler{})
+	device.RegisterOutputDecoder("dualshock4", device.OutputDecoder{
+		Size: OutputLayout.Size(),
+		New:  func() encoding.BinaryUnmarshaler { return new(OutputState) },
+	})
 }
 
 type handler struct{}
 
 func (h *handler) CreateDevice(o *device.CreateOptions) (usb.Device, error) { return New(o) }
 
+func (h *handler) InputLayout(usb.Device) device.WireLayout { return InputLayout }
+
+func (h *handler) OutputLayout(usb.Device) device.WireLayout { return OutputLayout }
+
+func (h *handler) MacroState(dev usb.Device, state []byte) (func(), error) {
+	ds4, ok := dev.(*DualShock4)
+	if !ok {
+		return nil, fmt.Errorf("device is not dualshock4")
+	}
+	var st InputState
+	if err := json.Unmarshal(state, &st); err != nil {
+		return nil, err
+	}
+	return func() { ds4.UpdateInputState(&st) }, nil
+}
+
 func (h *handler) StreamHandler() api.StreamHandlerFunc {
 	return func(conn net.Conn, devPtr *usb.Device, logger *slog.Logger) error {
 		if devPtr == nil || *devPtr == nil {
@@ -39,6 +61,12 @@ func (h *handler) StreamHandler() api.StreamHandlerFunc {
 				logger.Error("failed to send feedback", "error", err)
 			}
 		})
+		if hint, ok := ds4.SlotHint(); ok {
+			data, _ := hint.MarshalBinary()
+			if _, err := conn.Write(data); err != nil {
+				return fmt.Errorf("send player slot hint: %w", err)
+			}
+		}
 
 		buf := make([]byte, 31)
 		for {
@@ -54,6 +82,18 @@ func (h *handler) StreamHandler() api.StreamHandlerFunc {
 			if err := state.UnmarshalBinary(buf); err != nil {
 				return fmt.Errorf("unmarshal input state: %w", err)
 			}
+			if ds4.step.Active() {
+				st := state
+				if err := ds4.step.Push(func() { ds4.UpdateInputState(&st) }); err != nil {
+					return err
+				}
+				continue
+			}
+			if ds4.degrade.Active() {
+				st := state
+				ds4.degrade.Apply(func() { ds4.UpdateInputState(&st) })
+				continue
+			}
 			ds4.UpdateInputState(&state)
 		}
 	}
@@ -3,9 +3,11 @@ package dualshock4
 import (
 	"encoding/binary"
 	"io"
+
+	"github.com/Alia5/VIIPER/device"
 )
 
-// viiper:wire dualshock4 c2s stickLX:i8 stickLY:i8 stickRX:i8 stickRY:i8 buttons:u16 dpad:u8 triggerL2:u8 triggerR2:u8 touch1X:u16 touch1Y:u16 touch1Active:bool touch2X:u16 touch2Y:u16 touch2Active:bool gyroX:i16 gyroY:i16 gyroZ:i16 accelX:i16 accelY:i16 accelZ:i16
+// viiper:wire dualshock4 c2s stickLX:i8 stickLY:i8 stickRX:i8 stickRY:i8 buttons:u16 dpad:u8:0..15 triggerL2:u8 triggerR2:u8 touch1X:u16:0..1920 touch1Y:u16:0..942 touch1Active:bool touch2X:u16:0..1920 touch2Y:u16:0..942 touch2Active:bool gyroX:i16 gyroY:i16 gyroZ:i16 accelX:i16 accelY:i16 accelZ:i16
 type InputState struct {
 	LX, LY  int8
 	RX, RY  int8
@@ -22,6 +24,38 @@ type InputState struct {
 	AccelX, AccelY, AccelZ int16
 }
 
+// InputLayout is the field layout of the InputState wire format, used for delta updates.
+// Each touch point's coordinates and active flag must be updated together.
+// Ranges match the viiper:wire annotations, bool fields being 0..1.
+var InputLayout = device.WireLayout{
+	{Name: "stickLX", Size: 1},
+	{Name: "stickLY", Size: 1},
+	{Name: "stickRX", Size: 1},
+	{Name: "stickRY", Size: 1},
+	{Name: "buttons", Size: 2},
+	{Name: "dpad", Size: 1, Range: &device.ValueRange{Min: 0, Max: int64(DPadMask)}},
+	{Name: "triggerL2", Size: 1},
+	{Name: "triggerR2", Size: 1},
+	{Name: "touch1X", Size: 2, Group: "touch1", Range: &touchRangeX},
+	{Name: "touch1Y", Size: 2, Group: "touch1", Range: &touchRangeY},
+	{Name: "touch1Active", Size: 1, Group: "touch1", Range: &boolRange},
+	{Name: "touch2X", Size: 2, Group: "touch2", Range: &touchRangeX},
+	{Name: "touch2Y", Size: 2, Group: "touch2", Range: &touchRangeY},
+	{Name: "touch2Active", Size: 1, Group: "touch2", Range: &boolRange},
+	{Name: "gyroX", Size: 2},
+	{Name: "gyroY", Size: 2},
+	{Name: "gyroZ", Size: 2},
+	{Name: "accelX", Size: 2},
+	{Name: "accelY", Size: 2},
+	{Name: "accelZ", Size: 2},
+}
+
+var (
+	touchRangeX = device.ValueRange{Min: int64(TouchpadMinX), Max: int64(TouchpadMaxX)}
+	touchRangeY = device.ValueRange{Min: int64(TouchpadMinY), Max: int64(TouchpadMaxY)}
+	boolRange   = device.ValueRange{Min: 0, Max: 1}
+)
+
 func (s *InputState) MarshalBinary() ([]byte, error) {
 	b := make([]byte, 31)
 	b[0] = uint8(s.LX)
@@ -82,6 +116,17 @@ func (s *InputState) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+// OutputLayout is the field layout of the OutputState wire format.
+var OutputLayout = device.WireLayout{
+	{Name: "rumbleSmall", Size: 1},
+	{Name: "rumbleLarge", Size: 1},
+	{Name: "ledRed", Size: 1},
+	{Name: "ledGreen", Size: 1},
+	{Name: "ledBlue", Size: 1},
+	{Name: "flashOn", Size: 1},
+	{Name: "flashOff", Size: 1},
+}
+
 // viiper:wire dualshock4 s2c rumbleSmall:u8 rumbleLarge:u8 ledRed:u8 ledGreen:u8 ledBlue:u8 flashOn:u8 flashOff:u8
 type OutputState struct {
 	RumbleSmall uint8 // (0-255)
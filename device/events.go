@@ -0,0 +1,33 @@
+package device
+
+import "errors"
+
+// EventVersion is the event-mode wire version negotiated at stream activation
+// by sending "events=<EventVersion>" as the stream request payload.
+//
+// In event mode the client sends fixed-size {type u8, code u8} packets instead
+// of full input states; the server folds them into the authoritative state.
+const EventVersion = 1
+
+// EventSize is the size of an event-mode packet in bytes.
+const EventSize = 2
+
+// Event types of event-mode packets. The meaning of the code is device
+// specific, e.g. a HID usage code for keyboards.
+const (
+	EventPress      = 0x01
+	EventRelease    = 0x02
+	EventReleaseAll = 0x03 // code is ignored
+)
+
+// ErrEventDropped marks events that are ignored without ending the stream,
+// e.g. a key press beyond the held-key cap.
+var ErrEventDropped = errors.New("event dropped")
+
+// EventFolder maintains the input state of an event-mode stream.
+type EventFolder interface {
+	// Fold applies one event and returns the resulting full wire state.
+	Fold(typ, code uint8) ([]byte, error)
+	// ReleaseAll resets the state and returns the resulting full wire state.
+	ReleaseAll() []byte
+}
@@ -0,0 +1,217 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits and defaults of HumanizeConfig.
+const (
+	MaxKeyInterval           = 2 * time.Second
+	MaxMouseJitter           = 64
+	DefaultKeyIntervalMean   = 120 * time.Millisecond
+	DefaultKeyIntervalStdDev = 40 * time.Millisecond
+)
+
+// MouseStepInterval is the time between the steps a humanized mouse
+// movement is split into; a step covers about mouseStepPx pixels.
+const (
+	MouseStepInterval = 8 * time.Millisecond
+	mouseStepPx       = 8
+	maxMouseSteps     = 16
+)
+
+// HumanizeConfig shapes keyboard and mouse input to look typed and moved by
+// hand: every keyboard state waits a log-normal interval after the previous
+// one, and mouse movements are eased over several steps with jitter. The
+// zero value disables it.
+type HumanizeConfig struct {
+	Enabled bool
+	// KeyIntervalMean and KeyIntervalStdDev shape the interval between
+	// keyboard states; zero picks the defaults.
+	KeyIntervalMean   time.Duration
+	KeyIntervalStdDev time.Duration
+	// MouseJitter is the largest offset in pixels added to the points along
+	// a movement; where a movement ends is never changed.
+	MouseJitter int
+	// Seed makes intervals and jitter reproducible; 0 picks a random seed.
+	Seed uint64
+}
+
+// Validate checks c against the limits.
+func (c HumanizeConfig) Validate() error {
+	switch {
+	case c.KeyIntervalMean < 0 || c.KeyIntervalStdDev < 0:
+		return errors.New("key intervals must not be negative")
+	case c.KeyIntervalMean > MaxKeyInterval || c.KeyIntervalStdDev > MaxKeyInterval:
+		return errors.New("key intervals must not exceed 2s")
+	case c.MouseJitter < 0 || c.MouseJitter > MaxMouseJitter:
+		return errors.New("mouse jitter must be between 0 and 64 pixels")
+	}
+	return nil
+}
+
+// Humanizable is implemented by device types whose streamed input can be
+// humanized through CreateOptions.Humanize.
+type Humanizable interface {
+	Humanizer() *Humanizer
+}
+
+// Humanizer paces keyboard states and eases mouse movements on their way to
+// the device. The zero value is ready to use, is disabled, and applies input
+// immediately.
+type Humanizer struct {
+	clock  Clock
+	active atomic.Pointer[humanizeState]
+	queue  delayQueue // latches run with its lock held and must not call back
+}
+
+type humanizeState struct {
+	cfg HumanizeConfig
+	// mu and sigma are the parameters of the normal distribution whose
+	// exponent gives the key intervals.
+	mu, sigma float64
+
+	rngMu sync.Mutex
+	rng   *rand.Rand
+}
+
+// SetClock replaces the wall clock, e.g. with a fake one in tests.
+// It must be called before input is streamed.
+func (h *Humanizer) SetClock(c Clock) { h.clock = c }
+
+// Configure replaces the configuration, filling in defaults. A disabled
+// configuration turns the Humanizer off; input already held back is still
+// applied.
+func (h *Humanizer) Configure(cfg HumanizeConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if !cfg.Enabled {
+		h.active.Store(nil)
+		return nil
+	}
+	if cfg.KeyIntervalMean == 0 {
+		cfg.KeyIntervalMean = DefaultKeyIntervalMean
+		if cfg.KeyIntervalStdDev == 0 {
+			cfg.KeyIntervalStdDev = DefaultKeyIntervalStdDev
+		}
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = rand.Uint64()
+	}
+	mean := float64(cfg.KeyIntervalMean)
+	sigma2 := math.Log1p(math.Pow(float64(cfg.KeyIntervalStdDev)/mean, 2))
+	h.active.Store(&humanizeState{
+		cfg:   cfg,
+		mu:    math.Log(mean) - sigma2/2,
+		sigma: math.Sqrt(sigma2),
+		rng:   rand.New(rand.NewPCG(cfg.Seed, cfg.Seed)),
+	})
+	return nil
+}
+
+// Config returns the current configuration, including the seed in use, and
+// whether it is enabled.
+func (h *Humanizer) Config() (HumanizeConfig, bool) {
+	st := h.active.Load()
+	if st == nil {
+		return HumanizeConfig{}, false
+	}
+	return st.cfg, true
+}
+
+// Active reports whether input must go through Key or Move.
+func (h *Humanizer) Active() bool { return h.active.Load() != nil || h.queue.pending.Load() > 0 }
+
+// Key runs latch, which applies one keyboard state, a key interval after the
+// previous state was applied.
+func (h *Humanizer) Key(latch func()) {
+	var gap time.Duration
+	if st := h.active.Load(); st != nil {
+		gap = st.keyInterval()
+	}
+	h.queue.push(h.clockOrSystem(), func(now, last time.Time) time.Time {
+		return later(now, last).Add(gap)
+	}, latch)
+}
+
+// Move splits a relative movement into eased, jittered steps and calls step
+// for each, MouseStepInterval apart. The steps add up to exactly dx, dy;
+// last is set on the final one.
+func (h *Humanizer) Move(dx, dy int, step func(dx, dy int, last bool)) {
+	steps := [][2]int{{dx, dy}}
+	if st := h.active.Load(); st != nil {
+		steps = st.path(dx, dy)
+	}
+	clock := h.clockOrSystem()
+	for i, s := range steps {
+		var gap time.Duration
+		if i > 0 {
+			gap = MouseStepInterval
+		}
+		last := i == len(steps)-1
+		h.queue.push(clock, func(now, prev time.Time) time.Time {
+			return later(now, prev).Add(gap)
+		}, func() { step(s[0], s[1], last) })
+	}
+}
+
+// Wait blocks until all held back input has been applied or ctx is done.
+func (h *Humanizer) Wait(ctx context.Context) error { return h.queue.wait(ctx) }
+
+func (h *Humanizer) clockOrSystem() Clock {
+	if h.clock == nil {
+		return SystemClock
+	}
+	return h.clock
+}
+
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// keyInterval draws a log-normal interval with the configured mean and
+// standard deviation, rounded to milliseconds.
+func (st *humanizeState) keyInterval() time.Duration {
+	st.rngMu.Lock()
+	n := st.rng.NormFloat64()
+	st.rngMu.Unlock()
+	d := time.Duration(math.Exp(st.mu + st.sigma*n))
+	return min(d.Round(time.Millisecond), MaxKeyInterval)
+}
+
+// path returns the steps of a movement along a smoothstep curve, slow at
+// both ends. Jitter moves the points in between, never the end point, so
+// the steps add up to the movement.
+func (st *humanizeState) path(dx, dy int) [][2]int {
+	n := int(math.Ceil(math.Hypot(float64(dx), float64(dy)) / mouseStepPx))
+	n = min(max(n, 1), maxMouseSteps)
+	jitter := st.cfg.MouseJitter
+
+	steps := make([][2]int, n)
+	var px, py int
+	st.rngMu.Lock()
+	defer st.rngMu.Unlock()
+	for i := 1; i <= n; i++ {
+		t := float64(i) / float64(n)
+		e := t * t * (3 - 2*t)
+		x := int(math.Round(e * float64(dx)))
+		y := int(math.Round(e * float64(dy)))
+		if i < n && jitter > 0 {
+			x += st.rng.IntN(2*jitter+1) - jitter
+			y += st.rng.IntN(2*jitter+1) - jitter
+		}
+		steps[i-1] = [2]int{x - px, y - py}
+		px, py = x, y
+	}
+	return steps
+}
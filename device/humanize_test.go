@@ -0,0 +1,156 @@
+package device_test
+
+import (
+	"context"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/device"
+)
+
+func newHumanizer(t *testing.T, cfg device.HumanizeConfig) (*device.Humanizer, *fakeClock) {
+	t.Helper()
+	clock := newFakeClock()
+	h := &device.Humanizer{}
+	h.SetClock(clock)
+	require.NoError(t, h.Configure(cfg))
+	return h, clock
+}
+
+func TestHumanizerKeyIntervals(t *testing.T) {
+	cfg := device.HumanizeConfig{
+		Enabled:           true,
+		KeyIntervalMean:   100 * time.Millisecond,
+		KeyIntervalStdDev: 30 * time.Millisecond,
+		Seed:              42,
+	}
+	typeBurst := func() []time.Duration {
+		h, clock := newHumanizer(t, cfg)
+		var applied []time.Duration
+		for range 6 {
+			h.Key(func() { applied = append(applied, clock.Now().Sub(time.Unix(0, 0))) })
+		}
+		assert.Empty(t, applied, "every state waits an interval")
+		clock.Advance(10 * time.Second)
+		require.NoError(t, h.Wait(context.Background()))
+
+		var gaps []time.Duration
+		var prev time.Duration
+		for _, at := range applied {
+			gaps = append(gaps, at-prev)
+			prev = at
+		}
+		return gaps
+	}
+
+	ms := time.Millisecond
+	want := []time.Duration{88 * ms, 70 * ms, 67 * ms, 166 * ms, 104 * ms, 155 * ms}
+	assert.Equal(t, want, typeBurst())
+	assert.Equal(t, want, typeBurst(), "the seed fixes the intervals")
+}
+
+func TestHumanizerKeyPacesAfterArrival(t *testing.T) {
+	h, clock := newHumanizer(t, device.HumanizeConfig{Enabled: true, KeyIntervalMean: 50 * time.Millisecond, Seed: 1})
+	var applied []time.Time
+	latch := func() { applied = append(applied, clock.Now()) }
+
+	h.Key(latch)
+	clock.Advance(time.Second)
+	require.Len(t, applied, 1)
+	sent := clock.Now()
+	h.Key(latch)
+	assert.Len(t, applied, 1, "an idle keyboard still waits an interval")
+	clock.Advance(time.Second)
+	require.Len(t, applied, 2)
+	assert.Positive(t, applied[1].Sub(sent))
+	assert.LessOrEqual(t, applied[1].Sub(sent), device.MaxKeyInterval)
+}
+
+func TestHumanizerMove(t *testing.T) {
+	h, clock := newHumanizer(t, device.HumanizeConfig{Enabled: true, MouseJitter: 3, Seed: 9})
+
+	type step struct {
+		at     time.Duration
+		dx, dy int
+		last   bool
+	}
+	var steps []step
+	record := func(dx, dy int, last bool) {
+		steps = append(steps, step{clock.Now().Sub(time.Unix(0, 0)), dx, dy, last})
+	}
+	h.Move(40, -12, record)
+	clock.Advance(time.Second)
+
+	require.Len(t, steps, 6, "about 8px per step")
+	var sx, sy int
+	for i, s := range steps {
+		assert.Equal(t, time.Duration(i)*device.MouseStepInterval, s.at)
+		assert.Equal(t, i == len(steps)-1, s.last)
+		sx += s.dx
+		sy += s.dy
+	}
+	assert.Equal(t, []int{40, -12}, []int{sx, sy}, "jitter keeps the end point")
+	assert.Less(t, abs(steps[0].dx), abs(steps[2].dx)+3, "eased: slow start")
+
+	// A small movement is a single step, so it is never delayed.
+	steps = nil
+	h.Move(3, 0, record)
+	assert.Equal(t, []step{{time.Second, 3, 0, true}}, steps)
+}
+
+func TestHumanizerMovePreservesTotals(t *testing.T) {
+	h, clock := newHumanizer(t, device.HumanizeConfig{Enabled: true, MouseJitter: device.MaxMouseJitter, Seed: 3})
+	rng := rand.New(rand.NewPCG(5, 5))
+	var sx, sy, wantX, wantY int
+	for range 200 {
+		dx, dy := rng.IntN(2001)-1000, rng.IntN(2001)-1000
+		wantX += dx
+		wantY += dy
+		h.Move(dx, dy, func(dx, dy int, _ bool) {
+			sx += dx
+			sy += dy
+		})
+	}
+	clock.Advance(time.Hour)
+	assert.Equal(t, wantX, sx)
+	assert.Equal(t, wantY, sy)
+}
+
+func TestHumanizerDisabled(t *testing.T) {
+	var h device.Humanizer
+	assert.False(t, h.Active())
+	_, ok := h.Config()
+	assert.False(t, ok)
+
+	var got [][2]int
+	h.Move(100, 50, func(dx, dy int, last bool) {
+		assert.True(t, last)
+		got = append(got, [2]int{dx, dy})
+	})
+	assert.Equal(t, [][2]int{{100, 50}}, got, "applied at once, unchanged")
+
+	require.NoError(t, h.Configure(device.HumanizeConfig{Enabled: true}))
+	cfg, ok := h.Config()
+	require.True(t, ok)
+	assert.Equal(t, device.DefaultKeyIntervalMean, cfg.KeyIntervalMean)
+	assert.Equal(t, device.DefaultKeyIntervalStdDev, cfg.KeyIntervalStdDev)
+	assert.NotZero(t, cfg.Seed, "the random seed is reported")
+	require.NoError(t, h.Configure(device.HumanizeConfig{}))
+	assert.False(t, h.Active())
+}
+
+func TestHumanizeConfigValidate(t *testing.T) {
+	for _, cfg := range []device.HumanizeConfig{
+		{KeyIntervalMean: -time.Millisecond},
+		{KeyIntervalStdDev: 3 * time.Second},
+		{MouseJitter: device.MaxMouseJitter + 1},
+	} {
+		assert.Error(t, cfg.Validate(), "%+v", cfg)
+	}
+}
+
+func abs(v int) int { return max(v, -v) }
@@ -0,0 +1,88 @@
+package device
+
+import (
+	"fmt"
+	"maps"
+	"unicode/utf8"
+
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// maxStringLen is the most characters a USB string descriptor holds.
+const maxStringLen = 126
+
+// ApplyIdentity applies the VID/PID, serial number, product string and
+// Microsoft OS 2.0 descriptors of o to desc. A string the descriptor has no
+// index for, such as the serial number of a device that reports none, gets
+// the lowest free one.
+func (o *CreateOptions) ApplyIdentity(desc *usb.Descriptor) error {
+	if o == nil {
+		return nil
+	}
+	if o.IdVendor != nil {
+		desc.Device.IDVendor = *o.IdVendor
+	}
+	if o.IdProduct != nil {
+		desc.Device.IDProduct = *o.IdProduct
+	}
+	if m := o.MSOS20; m != nil {
+		desc.MSOS20 = nil
+		if m.CompatibleID != "" {
+			if err := m.Validate(); err != nil {
+				return fmt.Errorf("msOsDescriptors: %w", err)
+			}
+			msos := *m
+			desc.MSOS20 = &msos
+		}
+	}
+	if o.SerialNumber == nil && o.ProductString == nil {
+		return nil
+	}
+	// Devices share the string table of their default descriptor.
+	desc.Strings = maps.Clone(desc.Strings)
+	if desc.Strings == nil {
+		desc.Strings = map[uint8]string{}
+	}
+	if o.SerialNumber != nil {
+		if err := setString(desc, &desc.Device.ISerialNumber, "serialNumber", *o.SerialNumber); err != nil {
+			return err
+		}
+	}
+	if o.ProductString != nil {
+		if err := setString(desc, &desc.Device.IProduct, "productString", *o.ProductString); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setString stores s as the string *idx of desc, assigning *idx if unset.
+func setString(desc *usb.Descriptor, idx *uint8, name, s string) error {
+	if utf8.RuneCountInString(s) > maxStringLen {
+		return fmt.Errorf("%s is longer than %d characters", name, maxStringLen)
+	}
+	for _, r := range s {
+		if r > 0xFFFF {
+			return fmt.Errorf("%s contains %q, which USB strings cannot encode", name, r)
+		}
+	}
+	for i := uint8(1); *idx == 0; i++ {
+		if _, ok := desc.Strings[i]; !ok {
+			*idx = i
+		}
+	}
+	desc.Strings[*idx] = s
+	return nil
+}
+
+// Identity returns the product string and serial number desc reports, empty
+// where it has none.
+func Identity(desc *usb.Descriptor) (product, serial string) {
+	if i := desc.Device.IProduct; i != 0 {
+		product = desc.Strings[i]
+	}
+	if i := desc.Device.ISerialNumber; i != 0 {
+		serial = desc.Strings[i]
+	}
+	return product, serial
+}
@@ -0,0 +1,52 @@
+package device_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+func TestApplyIdentity(t *testing.T) {
+	str := func(s string) *string { return &s }
+	base := func() usb.Descriptor {
+		return usb.Descriptor{
+			Device:  usb.DeviceDescriptor{IManufacturer: 1, IProduct: 2},
+			Strings: map[uint8]string{0: "\x04\x09", 1: "VIIPER", 2: "Pad"},
+		}
+	}
+
+	t.Run("serial gets a free index", func(t *testing.T) {
+		desc := base()
+		shared := desc.Strings
+		require.NoError(t, (&device.CreateOptions{SerialNumber: str("42"), ProductString: str("Other")}).ApplyIdentity(&desc))
+		assert.Equal(t, uint8(3), desc.Device.ISerialNumber)
+		product, serial := device.Identity(&desc)
+		assert.Equal(t, "Other", product)
+		assert.Equal(t, "42", serial)
+		assert.Equal(t, "Pad", shared[2], "the shared table is not modified")
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		desc := base()
+		err := (&device.CreateOptions{ProductString: str(strings.Repeat("ä", 127))}).ApplyIdentity(&desc)
+		assert.ErrorContains(t, err, "productString")
+		require.NoError(t, (&device.CreateOptions{ProductString: str(strings.Repeat("ä", 126))}).ApplyIdentity(&desc))
+	})
+
+	t.Run("outside the BMP", func(t *testing.T) {
+		desc := base()
+		assert.ErrorContains(t, (&device.CreateOptions{SerialNumber: str("🎮")}).ApplyIdentity(&desc), "serialNumber")
+	})
+
+	t.Run("nil options", func(t *testing.T) {
+		desc := base()
+		var o *device.CreateOptions
+		require.NoError(t, o.ApplyIdentity(&desc))
+		assert.Equal(t, base(), desc)
+	})
+}
@@ -0,0 +1,94 @@
+package joystick
+
+// Geometry limits. A joystick created without options has the largest
+// geometry, with a hat switch.
+const (
+	MaxAxes    = 8
+	MaxButtons = 32
+)
+
+// Hat switch directions, clockwise from up. HatCentered reports the hat's
+// null state.
+const (
+	HatUp        = 0
+	HatUpRight   = 1
+	HatRight     = 2
+	HatDownRight = 3
+	HatDown      = 4
+	HatDownLeft  = 5
+	HatLeft      = 6
+	HatUpLeft    = 7
+	HatCentered  = 8
+)
+
+// MaxEffects is the number of effect slots of a force feedback joystick.
+const MaxEffects = 16
+
+// Force feedback report IDs. The input report carries ReportIDInput once
+// force feedback is enabled.
+const (
+	ReportIDInput = 0x01
+
+	// Output reports
+	ReportIDSetEffect     = 0x01
+	ReportIDSetEnvelope   = 0x02
+	ReportIDSetConstant   = 0x05
+	ReportIDEffectOp      = 0x0A
+	ReportIDBlockFree     = 0x0B
+	ReportIDDeviceControl = 0x0C
+	ReportIDDeviceGain    = 0x0D
+
+	// Feature reports
+	ReportIDCreateEffect = 0x11
+	ReportIDBlockLoad    = 0x12
+	ReportIDPool         = 0x13
+)
+
+// Effect types, as in Effect.EffectType and the Create New Effect report.
+const (
+	EffectConstantForce = 1
+	EffectRamp          = 2
+	EffectSquare        = 3
+	EffectSine          = 4
+	EffectTriangle      = 5
+	EffectSawtoothUp    = 6
+	EffectSawtoothDown  = 7
+	EffectSpring        = 8
+	EffectDamper        = 9
+	EffectInertia       = 10
+	EffectFriction      = 11
+)
+
+// Effect message operations.
+const (
+	// EffectOpSet carries new parameters of an effect.
+	EffectOpSet       = 1
+	EffectOpStart     = 2
+	EffectOpStartSolo = 3
+	EffectOpStop      = 4
+	EffectOpFree      = 5
+	// EffectOpDeviceControl carries a DeviceControl* command in Control.
+	EffectOpDeviceControl = 6
+	// EffectOpDeviceGain carries the overall gain in Gain.
+	EffectOpDeviceGain = 7
+)
+
+// Device control commands, as in Effect.Control.
+const (
+	DeviceControlEnableActuators  = 1
+	DeviceControlDisableActuators = 2
+	DeviceControlStopAll          = 3
+	DeviceControlReset            = 4
+	DeviceControlPause            = 5
+	DeviceControlContinue         = 6
+)
+
+// Block load statuses of the Block Load report.
+const (
+	BlockLoadSuccess = 1
+	BlockLoadFull    = 2
+	BlockLoadError   = 3
+)
+
+// DurationInfinite is the effect duration of effects playing until stopped.
+const DurationInfinite = 0xFFFF
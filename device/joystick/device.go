@@ -0,0 +1,349 @@
+// Package joystick provides a generic HID joystick whose axes, buttons and
+// hat switch are chosen at creation time.
+package joystick
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usb/hid"
+	"github.com/Alia5/VIIPER/usbip"
+)
+
+// Joystick implements a HID joystick with the axes, buttons and hat switch
+// of its Geometry.
+type Joystick struct {
+	geometry   Geometry
+	inputState InputState
+	stateMu    sync.Mutex
+	descriptor usb.Descriptor
+	degrade    device.Degrader
+	step       device.Stepper
+	ffb        *pid // nil unless created with force feedback
+}
+
+// Geometry is the set of controls a joystick reports.
+type Geometry struct {
+	NumAxes    int
+	NumButtons int
+	HasHat     bool
+}
+
+// ReportSize returns the size of the input report in bytes.
+func (g Geometry) ReportSize() int {
+	n := 2*g.NumAxes + (g.NumButtons+7)/8
+	if g.HasHat {
+		n++
+	}
+	return n
+}
+
+type JoystickCreateOptions struct {
+	// NumAxes is the number of 16-bit axes, 0-8.
+	NumAxes *int `json:"numAxes"`
+	// NumButtons is the number of buttons, 0-32.
+	NumButtons *int `json:"numButtons"`
+	// HasHat adds an 8-way hat switch.
+	HasHat *bool `json:"hasHat"`
+	// ForceFeedback adds the PID force feedback reports.
+	ForceFeedback *bool `json:"forceFeedback"`
+}
+
+// New returns a new Joystick device.
+func New(o *device.CreateOptions) (*Joystick, error) {
+	g := Geometry{NumAxes: MaxAxes, NumButtons: MaxButtons, HasHat: true}
+	d := &Joystick{}
+	if o != nil {
+		if o.DeviceSpecific != nil {
+			data, err := json.Marshal(o.DeviceSpecific)
+			var args JoystickCreateOptions
+			if err != nil {
+				return nil, fmt.Errorf("invalid JSON payload: %w", err)
+			}
+			err = json.Unmarshal(data, &args)
+			if err != nil {
+				return nil, fmt.Errorf("invalid JSON payload: %w", err)
+			}
+			if args.NumAxes != nil {
+				g.NumAxes = *args.NumAxes
+			}
+			if args.NumButtons != nil {
+				g.NumButtons = *args.NumButtons
+			}
+			if args.HasHat != nil {
+				g.HasHat = *args.HasHat
+			}
+			if args.ForceFeedback != nil && *args.ForceFeedback {
+				d.ffb = &pid{}
+			}
+		}
+		if o.Deterministic != nil {
+			d.step.Configure(*o.Deterministic)
+		}
+	}
+	if g.NumAxes < 0 || g.NumAxes > MaxAxes {
+		return nil, fmt.Errorf("numAxes must be between 0 and %d", MaxAxes)
+	}
+	if g.NumButtons < 0 || g.NumButtons > MaxButtons {
+		return nil, fmt.Errorf("numButtons must be between 0 and %d", MaxButtons)
+	}
+	if g.ReportSize() == 0 {
+		return nil, fmt.Errorf("joystick needs at least one axis, button or hat")
+	}
+
+	d.geometry = g
+	d.inputState = InputState{Hat: HatCentered}
+	d.descriptor = newDescriptor(g, d.ffb != nil)
+	if o != nil {
+		if err := o.ApplyIdentity(&d.descriptor); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// SetOutputCallback sets the function receiving force feedback effects.
+func (j *Joystick) SetOutputCallback(f func(Effect)) {
+	if j.ffb != nil {
+		j.ffb.setOutputCallback(f)
+	}
+}
+
+// ForceFeedback reports whether the joystick has the PID reports.
+func (j *Joystick) ForceFeedback() bool {
+	return j.ffb != nil
+}
+
+// Geometry returns the controls the joystick was created with.
+func (j *Joystick) Geometry() Geometry {
+	return j.geometry
+}
+
+// Degrader returns the link degradation simulator applied to streamed input.
+func (j *Joystick) Degrader() *device.Degrader {
+	return &j.degrade
+}
+
+// Stepper returns the queue of streamed states in deterministic mode.
+func (j *Joystick) Stepper() *device.Stepper {
+	return &j.step
+}
+
+// UpdateInputState updates the device's current input state (thread-safe).
+func (j *Joystick) UpdateInputState(state InputState) {
+	j.stateMu.Lock()
+	defer j.stateMu.Unlock()
+	j.inputState = state
+}
+
+// ResetInputState releases all buttons and centers the axes and the hat.
+func (j *Joystick) ResetInputState() {
+	j.UpdateInputState(InputState{Hat: HatCentered})
+}
+
+func (j *Joystick) report() []byte {
+	j.stateMu.Lock()
+	defer j.stateMu.Unlock()
+	r := j.inputState.BuildReport(j.geometry)
+	if j.ffb != nil {
+		return append([]byte{ReportIDInput}, r...)
+	}
+	return r
+}
+
+// HandleTransfer implements interrupt IN for Joystick.
+func (j *Joystick) HandleTransfer(ep uint32, dir uint32, out []byte) []byte {
+	if ep != 1 || dir != usbip.DirIn {
+		return nil
+	}
+	return j.report()
+}
+
+// HandleTransferStatus stalls transfers other than interrupt IN.
+func (j *Joystick) HandleTransferStatus(ep uint32, dir uint32, out []byte) ([]byte, error) {
+	if ep != 1 || dir != usbip.DirIn {
+		return nil, usb.StatusStall
+	}
+	return j.HandleTransfer(ep, dir, out), nil
+}
+
+// HandleControl answers HID GET_REPORT with the current input report and,
+// with force feedback, takes the PID output and feature reports.
+func (j *Joystick) HandleControl(bmRequestType, bRequest uint8, wValue, _ /* wIndex */, _ /* wLength */ uint16, data []byte) ([]byte, bool) {
+	const (
+		hidGetReport      = 0x01
+		hidSetReport      = 0x09
+		reportTypeInput   = 0x01
+		reportTypeOutput  = 0x02
+		reportTypeFeature = 0x03
+	)
+	reportType := uint8(wValue >> 8)
+	switch {
+	case bmRequestType == 0xA1 && bRequest == hidGetReport && reportType == reportTypeInput:
+		return j.report(), true
+	case j.ffb == nil:
+		return nil, false
+	case bmRequestType == 0xA1 && bRequest == hidGetReport && reportType == reportTypeFeature:
+		return j.ffb.getFeature(uint8(wValue))
+	case bmRequestType == 0x21 && bRequest == hidSetReport && reportType == reportTypeOutput:
+		j.ffb.handleOutput(data)
+		return nil, true
+	case bmRequestType == 0x21 && bRequest == hidSetReport && reportType == reportTypeFeature:
+		return nil, j.ffb.setFeature(data)
+	}
+	return nil, false
+}
+
+// axisUsages are the Generic Desktop usages of the axes, in report order.
+var axisUsages = [MaxAxes]uint16{
+	hid.UsageX, hid.UsageY, hid.UsageZ,
+	hid.UsageRx, hid.UsageRy, hid.UsageRz,
+	hid.UsageSlider, hid.UsageDial,
+}
+
+// reportDescriptor builds the HID report descriptor of geometry g, laid out
+// as InputState.BuildReport encodes it, with the PID reports if ffb is set.
+func reportDescriptor(g Geometry, ffb bool) hid.Report {
+	var items []hid.Item
+	if g.NumAxes > 0 {
+		items = append(items, hid.UsagePage{Page: hid.UsagePageGenericDesktop})
+		for _, u := range axisUsages[:g.NumAxes] {
+			items = append(items, hid.Usage{Usage: u})
+		}
+		items = append(items,
+			hid.LogicalMinimum{Min: -32768},
+			hid.LogicalMaximum{Max: 32767},
+			hid.ReportSize{Bits: 16},
+			hid.ReportCount{Count: uint16(g.NumAxes)},
+			hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+		)
+	}
+	if g.HasHat {
+		items = append(items,
+			hid.UsagePage{Page: hid.UsagePageGenericDesktop},
+			hid.Usage{Usage: hid.UsageHatSwitch},
+			hid.LogicalMinimum{Min: 0},
+			hid.LogicalMaximum{Max: 7},
+			// Physical 0-315, unit degrees
+			hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x3, Data: hid.Data{0x00}},
+			hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x4, Data: hid.Data{0x3B, 0x01}},
+			hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x6, Data: hid.Data{0x14}},
+			hid.ReportSize{Bits: 4},
+			hid.ReportCount{Count: 1},
+			hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs | hid.MainNullState},
+			hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x6, Data: hid.Data{0x00}},
+			hid.ReportCount{Count: 1},
+			hid.Input{Flags: hid.MainConst},
+		)
+	}
+	if g.NumButtons > 0 {
+		items = append(items,
+			hid.UsagePage{Page: hid.UsagePageButton},
+			hid.UsageMinimum{Min: 0x01},
+			hid.UsageMaximum{Max: uint16(g.NumButtons)},
+			hid.LogicalMinimum{Min: 0},
+			hid.LogicalMaximum{Max: 1},
+			hid.ReportSize{Bits: 1},
+			hid.ReportCount{Count: uint16(g.NumButtons)},
+			hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+		)
+		if pad := -g.NumButtons & 7; pad > 0 {
+			items = append(items,
+				hid.ReportCount{Count: uint16(pad)},
+				hid.Input{Flags: hid.MainConst},
+			)
+		}
+	}
+	app := []hid.Item{
+		hid.Usage{Usage: hid.UsagePointer},
+		hid.Collection{Kind: hid.CollectionPhysical, Items: items},
+	}
+	if ffb {
+		app = append([]hid.Item{reportID(ReportIDInput)}, app...)
+		app = append(app, pidReportItems()...)
+	}
+	return hid.Report{
+		Items: []hid.Item{
+			hid.UsagePage{Page: hid.UsagePageGenericDesktop},
+			hid.Usage{Usage: hid.UsageJoystick},
+			hid.Collection{Kind: hid.CollectionApplication, Items: app},
+		},
+	}
+}
+
+// newDescriptor returns the USB descriptor of a joystick of geometry g.
+func newDescriptor(g Geometry, ffb bool) usb.Descriptor {
+	return usb.Descriptor{
+		Device: usb.DeviceDescriptor{
+			BcdUSB:             0x0200,
+			BDeviceClass:       0x00,
+			BDeviceSubClass:    0x00,
+			BDeviceProtocol:    0x00,
+			BMaxPacketSize0:    0x40, // 64 bytes
+			IDVendor:           0x2E8A,
+			IDProduct:          0x0012,
+			BcdDevice:          0x0100,
+			IManufacturer:      0x01,
+			IProduct:           0x02,
+			ISerialNumber:      0x03,
+			BNumConfigurations: 0x01,
+			Speed:              2, // Full speed
+		},
+		Interfaces: []usb.InterfaceConfig{
+			{
+				Descriptor: usb.InterfaceDescriptor{
+					BInterfaceNumber:   0x00,
+					BAlternateSetting:  0x00,
+					BNumEndpoints:      0x01,
+					BInterfaceClass:    0x03, // HID
+					BInterfaceSubClass: 0x00, // No boot interface
+					BInterfaceProtocol: 0x00,
+					IInterface:         0x00,
+				},
+				HID: &usb.HIDFunction{
+					Descriptor: usb.HIDDescriptor{
+						BcdHID:       0x0111,
+						BCountryCode: 0x00,
+						Descriptors: []usb.HIDSubDescriptor{
+							{Type: usb.ReportDescType},
+						},
+					},
+					Report: reportDescriptor(g, ffb),
+				},
+				Endpoints: []usb.EndpointDescriptor{
+					{
+						BEndpointAddress: 0x81,
+						BMAttributes:     0x03,   // Interrupt
+						WMaxPacketSize:   0x0020, // 32 bytes (21 at most)
+						BInterval:        0x01,   // 1 ms
+					},
+				},
+			},
+		},
+		Strings: map[uint8]string{
+			0: "\x04\x09", // LangID: en-US (0x0409)
+			1: "VIIPER",
+			2: "HID Joystick",
+			3: "1337",
+		},
+	}
+}
+
+func (j *Joystick) GetDescriptor() *usb.Descriptor {
+	return &j.descriptor
+}
+
+func (j *Joystick) GetDeviceSpecificArgs() map[string]any {
+	args := map[string]any{
+		"numAxes":    j.geometry.NumAxes,
+		"numButtons": j.geometry.NumButtons,
+		"hasHat":     j.geometry.HasHat,
+	}
+	if j.ffb != nil {
+		args["forceFeedback"] = true
+	}
+	return args
+}
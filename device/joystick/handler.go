@@ -0,0 +1,103 @@
+package joystick
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+func init() {
+	api.RegisterDevice("joystick", &handler{})
+	device.RegisterOutputDecoder("joystick", device.OutputDecoder{
+		Size: OutputLayout.Size(),
+		New:  func() encoding.BinaryUnmarshaler { return new(Effect) },
+	})
+}
+
+type handler struct{}
+
+func (h *handler) CreateDevice(o *device.CreateOptions) (usb.Device, error) { return New(o) }
+
+func (h *handler) OutputLayout(usb.Device) device.WireLayout { return OutputLayout }
+
+func (h *handler) MacroState(dev usb.Device, state []byte) (func(), error) {
+	jdev, ok := dev.(*Joystick)
+	if !ok {
+		return nil, fmt.Errorf("device is not joystick")
+	}
+	st := InputState{Hat: HatCentered}
+	if err := json.Unmarshal(state, &st); err != nil {
+		return nil, err
+	}
+	if len(st.Axes) > MaxAxes {
+		return nil, fmt.Errorf("%d axes, at most %d", len(st.Axes), MaxAxes)
+	}
+	return func() { jdev.UpdateInputState(st) }, nil
+}
+
+func (h *handler) StreamHandler() api.StreamHandlerFunc {
+	return func(conn net.Conn, devPtr *usb.Device, logger *slog.Logger) error {
+		if devPtr == nil || *devPtr == nil {
+			return fmt.Errorf("nil device")
+		}
+		jdev, ok := (*devPtr).(*Joystick)
+		if !ok {
+			return fmt.Errorf("device is not joystick")
+		}
+
+		jdev.SetOutputCallback(func(e Effect) {
+			data, err := e.MarshalBinary()
+			if err != nil {
+				logger.Error("failed to marshal effect", "error", err)
+				return
+			}
+			if _, err := conn.Write(data); err != nil {
+				logger.Error("failed to send effect", "error", err)
+			}
+		})
+
+		buf := make([]byte, 6+2*MaxAxes)
+		for {
+			// Header: buttons, hat and axis count
+			if _, err := io.ReadFull(conn, buf[:6]); err != nil {
+				if err == io.EOF {
+					logger.Info("client disconnected")
+					return nil
+				}
+				return fmt.Errorf("read header: %w", err)
+			}
+			n := int(buf[5])
+			if n > MaxAxes {
+				return fmt.Errorf("%d axes, at most %d", n, MaxAxes)
+			}
+			if _, err := io.ReadFull(conn, buf[6:6+2*n]); err != nil {
+				return fmt.Errorf("read axes: %w", err)
+			}
+
+			var state InputState
+			if err := state.UnmarshalBinary(buf[:6+2*n]); err != nil {
+				return fmt.Errorf("unmarshal input state: %w", err)
+			}
+			if jdev.step.Active() {
+				st := state
+				if err := jdev.step.Push(func() { jdev.UpdateInputState(st) }); err != nil {
+					return err
+				}
+				continue
+			}
+			if jdev.degrade.Active() {
+				st := state
+				jdev.degrade.Apply(func() { jdev.UpdateInputState(st) })
+				continue
+			}
+			jdev.UpdateInputState(state)
+		}
+	}
+}
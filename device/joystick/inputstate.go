@@ -0,0 +1,167 @@
+package joystick
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/Alia5/VIIPER/device"
+)
+
+// InputState represents the joystick state used to build a report.
+// Axes beyond the device's axis count are ignored, missing ones are centered.
+// viiper:wire joystick c2s buttons:u32 hat:u8 axisCount:u8 axes:i16*axisCount
+type InputState struct {
+	// Buttons: bit n is button n+1
+	Buttons uint32
+	// Hat: HatUp..HatUpLeft, or HatCentered
+	Hat uint8
+	// Axes: X, Y, Z, Rx, Ry, Rz, Slider, Dial; -32768 to 32767
+	Axes []int16
+}
+
+// MarshalBinary encodes InputState to variable-length wire format.
+//
+// Wire format:
+//
+//	Bytes 0-3: Buttons (uint32 little-endian)
+//	Byte 4: Hat
+//	Byte 5: Axis count
+//	Bytes 6+: Axes (int16 little-endian each)
+func (j *InputState) MarshalBinary() ([]byte, error) {
+	if len(j.Axes) > MaxAxes {
+		return nil, fmt.Errorf("%d axes, at most %d", len(j.Axes), MaxAxes)
+	}
+	b := make([]byte, 6+2*len(j.Axes))
+	binary.LittleEndian.PutUint32(b[0:4], j.Buttons)
+	b[4] = j.Hat
+	b[5] = uint8(len(j.Axes))
+	for i, v := range j.Axes {
+		binary.LittleEndian.PutUint16(b[6+2*i:], uint16(v))
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes the variable-length wire format into InputState.
+func (j *InputState) UnmarshalBinary(data []byte) error {
+	if len(data) < 6 {
+		return io.ErrUnexpectedEOF
+	}
+	n := int(data[5])
+	if n > MaxAxes {
+		return fmt.Errorf("%d axes, at most %d", n, MaxAxes)
+	}
+	if len(data) < 6+2*n {
+		return io.ErrUnexpectedEOF
+	}
+	j.Buttons = binary.LittleEndian.Uint32(data[0:4])
+	j.Hat = data[4]
+	j.Axes = make([]int16, n)
+	for i := range j.Axes {
+		j.Axes[i] = int16(binary.LittleEndian.Uint16(data[6+2*i:]))
+	}
+	return nil
+}
+
+// BuildReport encodes an InputState into the HID report of geometry g.
+//
+// Report layout:
+//
+//	Axes: int16 little-endian each, g.NumAxes of them
+//	Hat: low nibble of one byte, if g.HasHat
+//	Buttons: g.NumButtons bits, padded to whole bytes
+func (j *InputState) BuildReport(g Geometry) []byte {
+	b := make([]byte, g.ReportSize())
+	for i := range g.NumAxes {
+		if i < len(j.Axes) {
+			binary.LittleEndian.PutUint16(b[2*i:], uint16(j.Axes[i]))
+		}
+	}
+	off := 2 * g.NumAxes
+	if g.HasHat {
+		b[off] = min(j.Hat, HatCentered)
+		off++
+	}
+	buttons := j.Buttons
+	if g.NumButtons < 32 {
+		buttons &= 1<<g.NumButtons - 1
+	}
+	for i := range (g.NumButtons + 7) / 8 {
+		b[off+i] = uint8(buttons >> (8 * i))
+	}
+	return b
+}
+
+// OutputLayout is the field layout of the Effect wire format.
+var OutputLayout = device.WireLayout{
+	{Name: "op", Size: 1},
+	{Name: "effectIndex", Size: 1},
+	{Name: "effectType", Size: 1},
+	{Name: "duration", Size: 2},
+	{Name: "gain", Size: 1},
+	{Name: "direction", Size: 2},
+	{Name: "magnitude", Size: 2},
+	{Name: "attackLevel", Size: 2},
+	{Name: "attackTime", Size: 2},
+	{Name: "fadeLevel", Size: 2},
+	{Name: "fadeTime", Size: 2},
+	{Name: "loopCount", Size: 1},
+	{Name: "control", Size: 1},
+}
+
+// Effect is a force feedback message decoded from the host's PID reports.
+// Messages about one effect carry all of its parameters known so far.
+// viiper:wire joystick s2c op:u8 effectIndex:u8 effectType:u8 duration:u16 gain:u8 direction:u16 magnitude:i16 attackLevel:u16 attackTime:u16 fadeLevel:u16 fadeTime:u16 loopCount:u8 control:u8
+type Effect struct {
+	Op          uint8  // EffectOp*
+	EffectIndex uint8  // 1-MaxEffects, 0 for device-wide operations
+	EffectType  uint8  // Effect*
+	Duration    uint16 // (ms), DurationInfinite
+	Gain        uint8  // (0-255)
+	Direction   uint16 // (1/100 °, 0-35999)
+	Magnitude   int16  // constant force (-10000 to 10000)
+	AttackLevel uint16 // (0-10000)
+	AttackTime  uint16 // (ms)
+	FadeLevel   uint16 // (0-10000)
+	FadeTime    uint16 // (ms)
+	LoopCount   uint8  // EffectOpStart*
+	Control     uint8  // DeviceControl*, EffectOpDeviceControl
+}
+
+func (e *Effect) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 20)
+	b[0] = e.Op
+	b[1] = e.EffectIndex
+	b[2] = e.EffectType
+	binary.LittleEndian.PutUint16(b[3:5], e.Duration)
+	b[5] = e.Gain
+	binary.LittleEndian.PutUint16(b[6:8], e.Direction)
+	binary.LittleEndian.PutUint16(b[8:10], uint16(e.Magnitude))
+	binary.LittleEndian.PutUint16(b[10:12], e.AttackLevel)
+	binary.LittleEndian.PutUint16(b[12:14], e.AttackTime)
+	binary.LittleEndian.PutUint16(b[14:16], e.FadeLevel)
+	binary.LittleEndian.PutUint16(b[16:18], e.FadeTime)
+	b[18] = e.LoopCount
+	b[19] = e.Control
+	return b, nil
+}
+
+func (e *Effect) UnmarshalBinary(data []byte) error {
+	if len(data) < 20 {
+		return io.ErrUnexpectedEOF
+	}
+	e.Op = data[0]
+	e.EffectIndex = data[1]
+	e.EffectType = data[2]
+	e.Duration = binary.LittleEndian.Uint16(data[3:5])
+	e.Gain = data[5]
+	e.Direction = binary.LittleEndian.Uint16(data[6:8])
+	e.Magnitude = int16(binary.LittleEndian.Uint16(data[8:10]))
+	e.AttackLevel = binary.LittleEndian.Uint16(data[10:12])
+	e.AttackTime = binary.LittleEndian.Uint16(data[12:14])
+	e.FadeLevel = binary.LittleEndian.Uint16(data[14:16])
+	e.FadeTime = binary.LittleEndian.Uint16(data[16:18])
+	e.LoopCount = data[18]
+	e.Control = data[19]
+	return nil
+}
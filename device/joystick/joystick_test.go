@@ -0,0 +1,296 @@
+package joystick_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/device/joystick"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/virtualbus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/Alia5/VIIPER/internal/registry" // Register devices
+)
+
+func TestReportDescriptor(t *testing.T) {
+	cases := []struct {
+		name       string
+		args       map[string]any
+		reportSize int
+		expected   []byte
+	}{
+		{
+			name:       "two axes, four buttons",
+			args:       map[string]any{"numAxes": 2, "numButtons": 4, "hasHat": false},
+			reportSize: 5,
+			expected: []byte{
+				0x05, 0x01, 0x09, 0x04, 0xA1, 0x01, 0x09, 0x01, 0xA1, 0x00,
+				0x05, 0x01, 0x09, 0x30, 0x09, 0x31, 0x16, 0x00, 0x80, 0x26, 0xFF, 0x7F, 0x75, 0x10, 0x95, 0x02, 0x81, 0x02,
+				0x05, 0x09, 0x19, 0x01, 0x29, 0x04, 0x15, 0x00, 0x25, 0x01, 0x75, 0x01, 0x95, 0x04, 0x81, 0x02, 0x95, 0x04, 0x81, 0x01,
+				0xC0, 0xC0,
+			},
+		},
+		{
+			name:       "one axis and a hat",
+			args:       map[string]any{"numAxes": 1, "numButtons": 0},
+			reportSize: 3,
+			expected: []byte{
+				0x05, 0x01, 0x09, 0x04, 0xA1, 0x01, 0x09, 0x01, 0xA1, 0x00,
+				0x05, 0x01, 0x09, 0x30, 0x16, 0x00, 0x80, 0x26, 0xFF, 0x7F, 0x75, 0x10, 0x95, 0x01, 0x81, 0x02,
+				0x05, 0x01, 0x09, 0x39, 0x15, 0x00, 0x25, 0x07, 0x35, 0x00, 0x46, 0x3B, 0x01, 0x65, 0x14, 0x75, 0x04, 0x95, 0x01, 0x81, 0x42,
+				0x65, 0x00, 0x95, 0x01, 0x81, 0x01,
+				0xC0, 0xC0,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := joystick.New(&device.CreateOptions{DeviceSpecific: tc.args})
+			require.NoError(t, err)
+			assert.Equal(t, tc.reportSize, d.Geometry().ReportSize())
+			got, err := d.GetDescriptor().Interfaces[0].HID.Report.Bytes()
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, []byte(got))
+		})
+	}
+
+	d, err := joystick.New(nil)
+	require.NoError(t, err)
+	assert.Equal(t, joystick.Geometry{NumAxes: 8, NumButtons: 32, HasHat: true}, d.Geometry())
+	assert.Equal(t, 21, d.Geometry().ReportSize())
+
+	for _, args := range []map[string]any{
+		{"numAxes": 9},
+		{"numButtons": 33},
+		{"numAxes": -1},
+		{"numAxes": 0, "numButtons": 0, "hasHat": false},
+	} {
+		_, err := joystick.New(&device.CreateOptions{DeviceSpecific: args})
+		assert.Error(t, err, "%v", args)
+	}
+}
+
+func TestInputReports(t *testing.T) {
+	cases := []struct {
+		name           string
+		inputState     joystick.InputState
+		expectedReport []byte
+	}{
+		{
+			name:           "neutral",
+			inputState:     joystick.InputState{Hat: joystick.HatCentered},
+			expectedReport: []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x08, 0x00, 0x00},
+		},
+		{
+			name:           "axes",
+			inputState:     joystick.InputState{Hat: joystick.HatCentered, Axes: []int16{-32768, 32767, 256}},
+			expectedReport: []byte{0x00, 0x80, 0xFF, 0x7F, 0x00, 0x01, 0x08, 0x00, 0x00},
+		},
+		{
+			name:           "missing axes are centered",
+			inputState:     joystick.InputState{Hat: joystick.HatCentered, Axes: []int16{1}},
+			expectedReport: []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x08, 0x00, 0x00},
+		},
+		{
+			name:           "extra axes are ignored",
+			inputState:     joystick.InputState{Hat: joystick.HatCentered, Axes: []int16{1, 2, 3, 4, 5, 6, 7, 8}},
+			expectedReport: []byte{0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x08, 0x00, 0x00},
+		},
+		{
+			name:           "hat and buttons",
+			inputState:     joystick.InputState{Hat: joystick.HatDownLeft, Buttons: 0x1 | 0x800},
+			expectedReport: []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x01, 0x08},
+		},
+		{
+			name:           "buttons beyond the geometry are dropped",
+			inputState:     joystick.InputState{Hat: 0xFF, Buttons: 0xFFFFF000},
+			expectedReport: []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x08, 0x00, 0x00},
+		},
+	}
+
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90134)
+	require.NoError(t, err)
+	defer b.Close()
+	require.NoError(t, s.UsbServer.AddBus(b))
+
+	client := apiclient.New(s.ApiServer.Addr())
+	stream, dev, err := client.AddDeviceAndConnect(context.Background(), b.BusID(), "joystick", &device.CreateOptions{
+		DeviceSpecific: map[string]any{"numAxes": 3, "numButtons": 12, "hasHat": true},
+	})
+	require.NoError(t, err)
+	defer stream.Close()
+	assert.Equal(t, map[string]any{"numAxes": float64(3), "numButtons": float64(12), "hasHat": true}, dev.DeviceSpecific)
+
+	usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	devs, err := usbipClient.ListDevices()
+	require.NoError(t, err)
+	require.Len(t, devs, 1)
+	imp, err := usbipClient.AttachDevice(devs[0].BusID)
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if !assert.NoError(t, stream.WriteBinary(&tc.inputState)) {
+				return
+			}
+			got, err := usbipClient.PollInputReport(imp.Conn, tc.expectedReport, 750*time.Millisecond)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tc.expectedReport, got)
+		})
+	}
+}
+
+func TestForceFeedback(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90135)
+	require.NoError(t, err)
+	defer b.Close()
+	require.NoError(t, s.UsbServer.AddBus(b))
+
+	client := apiclient.New(s.ApiServer.Addr())
+	stream, dev, err := client.AddDeviceAndConnect(context.Background(), b.BusID(), "joystick", &device.CreateOptions{
+		DeviceSpecific: map[string]any{"numAxes": 2, "numButtons": 8, "forceFeedback": true},
+	})
+	require.NoError(t, err)
+	defer stream.Close()
+	assert.Equal(t, true, dev.DeviceSpecific["forceFeedback"])
+
+	usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	devs, err := usbipClient.ListDevices()
+	require.NoError(t, err)
+	require.Len(t, devs, 1)
+	imp, err := usbipClient.AttachDevice(devs[0].BusID)
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+
+	setReport := func(reportType uint8, report []byte) {
+		t.Helper()
+		_, err := usbipClient.Control(imp.Conn, [8]byte{0x21, 0x09, report[0], reportType, 0, 0, uint8(len(report)), 0}, report)
+		require.NoError(t, err)
+	}
+	getFeature := func(id, n uint8) []byte {
+		t.Helper()
+		got, err := usbipClient.Control(imp.Conn, [8]byte{0xA1, 0x01, id, 0x03, 0, 0, n, 0}, nil)
+		require.NoError(t, err)
+		return got
+	}
+	readEffect := func() joystick.Effect {
+		t.Helper()
+		var buf [20]byte
+		_ = stream.SetReadDeadline(time.Now().Add(750 * time.Millisecond))
+		_, err := io.ReadFull(stream, buf[:])
+		require.NoError(t, err)
+		var e joystick.Effect
+		require.NoError(t, e.UnmarshalBinary(buf[:]))
+		return e
+	}
+
+	// Input reports carry a report ID next to the PID reports.
+	require.NoError(t, stream.WriteBinary(&joystick.InputState{Buttons: 0x81, Hat: joystick.HatUp, Axes: []int16{-2, 3}}))
+	want := []byte{joystick.ReportIDInput, 0xFE, 0xFF, 0x03, 0x00, joystick.HatUp, 0x81}
+	got, err := usbipClient.PollInputReport(imp.Conn, want, 750*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	assert.Equal(t, []byte{joystick.ReportIDPool, 0xFF, 0xFF, joystick.MaxEffects, 0x01}, getFeature(joystick.ReportIDPool, 5))
+
+	// CreateNewEffectReport, then the block load tells the allocated slot.
+	setReport(0x03, []byte{joystick.ReportIDCreateEffect, joystick.EffectConstantForce, 0x00, 0x00})
+	assert.Equal(t, []byte{joystick.ReportIDBlockLoad, 1, joystick.BlockLoadSuccess, 0xFF, 0xFF}, getFeature(joystick.ReportIDBlockLoad, 5))
+
+	// SetEffectReport: 1000 ms, full gain, X and Y enabled, 90°.
+	setReport(0x02, []byte{joystick.ReportIDSetEffect, 1, joystick.EffectConstantForce, 0xE8, 0x03, 0, 0, 0, 0, 0xFF, 0, 0x03, 0x28, 0x23})
+	effect := joystick.Effect{
+		Op:          joystick.EffectOpSet,
+		EffectIndex: 1,
+		EffectType:  joystick.EffectConstantForce,
+		Duration:    1000,
+		Gain:        0xFF,
+		Direction:   9000,
+	}
+	assert.Equal(t, effect, readEffect())
+
+	setReport(0x02, []byte{joystick.ReportIDSetEnvelope, 1, 0x10, 0x27, 0x00, 0x00, 0x64, 0x00, 0xC8, 0x00})
+	effect.AttackLevel, effect.AttackTime, effect.FadeTime = 10000, 100, 200
+	assert.Equal(t, effect, readEffect())
+
+	setReport(0x02, []byte{joystick.ReportIDSetConstant, 1, 0x78, 0xEC})
+	effect.Magnitude = -5000
+	assert.Equal(t, effect, readEffect())
+
+	setReport(0x02, []byte{joystick.ReportIDEffectOp, 1, 1, 2})
+	start := effect
+	start.Op, start.LoopCount = joystick.EffectOpStart, 2
+	assert.Equal(t, start, readEffect())
+
+	setReport(0x02, []byte{joystick.ReportIDBlockFree, 1})
+	effect.Op = joystick.EffectOpFree
+	assert.Equal(t, effect, readEffect())
+	// Reports for the freed slot are dropped.
+	setReport(0x02, []byte{joystick.ReportIDSetConstant, 1, 0x00, 0x00})
+
+	setReport(0x02, []byte{joystick.ReportIDDeviceGain, 0x80})
+	assert.Equal(t, joystick.Effect{Op: joystick.EffectOpDeviceGain, Gain: 0x80}, readEffect())
+
+	for i := range joystick.MaxEffects {
+		setReport(0x03, []byte{joystick.ReportIDCreateEffect, joystick.EffectSine, 0x00, 0x00})
+		assert.Equal(t, uint8(i+1), getFeature(joystick.ReportIDBlockLoad, 5)[1])
+	}
+	setReport(0x03, []byte{joystick.ReportIDCreateEffect, joystick.EffectSine, 0x00, 0x00})
+	assert.Equal(t, uint8(joystick.BlockLoadFull), getFeature(joystick.ReportIDBlockLoad, 5)[2])
+
+	// A reset frees all slots.
+	setReport(0x02, []byte{joystick.ReportIDDeviceControl, joystick.DeviceControlReset})
+	assert.Equal(t, joystick.Effect{Op: joystick.EffectOpDeviceControl, Control: joystick.DeviceControlReset}, readEffect())
+	setReport(0x03, []byte{joystick.ReportIDCreateEffect, joystick.EffectSpring, 0x00, 0x00})
+	assert.Equal(t, []byte{joystick.ReportIDBlockLoad, 1, joystick.BlockLoadSuccess, 0xFF, 0xFF}, getFeature(joystick.ReportIDBlockLoad, 5))
+}
+
+func TestWireRoundTrip(t *testing.T) {
+	in := joystick.InputState{Buttons: 0x80000001, Hat: joystick.HatLeft, Axes: []int16{-1, 0, 1, 32767, -32768, 2, 3, 4}}
+	b, err := in.MarshalBinary()
+	require.NoError(t, err)
+	assert.Len(t, b, 22)
+	var got joystick.InputState
+	require.NoError(t, got.UnmarshalBinary(b))
+	assert.Equal(t, in, got)
+
+	_, err = (&joystick.InputState{Axes: make([]int16, 9)}).MarshalBinary()
+	assert.Error(t, err)
+
+	e := joystick.Effect{Op: joystick.EffectOpStart, EffectIndex: 3, EffectType: joystick.EffectRamp, Duration: 1, Gain: 2, Direction: 3, Magnitude: -4, AttackLevel: 5, AttackTime: 6, FadeLevel: 7, FadeTime: 8, LoopCount: 9, Control: 10}
+	b, err = e.MarshalBinary()
+	require.NoError(t, err)
+	require.Len(t, b, joystick.OutputLayout.Size())
+	var gotEffect joystick.Effect
+	require.NoError(t, gotEffect.UnmarshalBinary(b))
+	assert.Equal(t, e, gotEffect)
+}
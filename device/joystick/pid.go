@@ -0,0 +1,373 @@
+package joystick
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/Alia5/VIIPER/usb/hid"
+)
+
+// pid is the force feedback side of a joystick: the effect slots the host
+// allocates through the PID (Physical Interface Device) reports.
+type pid struct {
+	mu        sync.Mutex
+	effects   [MaxEffects]*Effect // slot i holds effect block index i+1
+	blockLoad [4]byte             // last Block Load report, without its ID
+	emit      func(Effect)
+}
+
+// ramPoolSize is the RAM pool the Pool report declares; allocation is
+// bounded by the effect slots, not by it.
+const ramPoolSize = 0xFFFF
+
+func (p *pid) setOutputCallback(f func(Effect)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.emit = f
+}
+
+// handleOutput decodes an output report, data[0] being its report ID.
+func (p *pid) handleOutput(data []byte) {
+	if len(data) < 2 {
+		return
+	}
+	p.mu.Lock()
+	msg, ok := p.outputLocked(data)
+	emit := p.emit
+	p.mu.Unlock()
+	if ok && emit != nil {
+		emit(msg)
+	}
+}
+
+func (p *pid) outputLocked(data []byte) (Effect, bool) {
+	switch data[0] {
+	case ReportIDDeviceControl:
+		if data[1] == DeviceControlReset {
+			p.effects = [MaxEffects]*Effect{}
+		}
+		return Effect{Op: EffectOpDeviceControl, Control: data[1]}, true
+	case ReportIDDeviceGain:
+		return Effect{Op: EffectOpDeviceGain, Gain: data[1]}, true
+	}
+
+	e := p.effectLocked(data[1])
+	if e == nil {
+		return Effect{}, false
+	}
+	switch data[0] {
+	case ReportIDSetEffect:
+		if len(data) < 14 {
+			return Effect{}, false
+		}
+		if t := data[2]; t >= EffectConstantForce && t <= EffectFriction {
+			e.EffectType = t
+		}
+		e.Duration = binary.LittleEndian.Uint16(data[3:5])
+		e.Gain = data[9]
+		e.Direction = binary.LittleEndian.Uint16(data[12:14])
+	case ReportIDSetEnvelope:
+		if len(data) < 10 {
+			return Effect{}, false
+		}
+		e.AttackLevel = binary.LittleEndian.Uint16(data[2:4])
+		e.FadeLevel = binary.LittleEndian.Uint16(data[4:6])
+		e.AttackTime = binary.LittleEndian.Uint16(data[6:8])
+		e.FadeTime = binary.LittleEndian.Uint16(data[8:10])
+	case ReportIDSetConstant:
+		if len(data) < 4 {
+			return Effect{}, false
+		}
+		e.Magnitude = int16(binary.LittleEndian.Uint16(data[2:4]))
+	case ReportIDEffectOp:
+		if len(data) < 4 {
+			return Effect{}, false
+		}
+		msg := *e
+		switch data[2] {
+		case 1:
+			msg.Op = EffectOpStart
+		case 2:
+			msg.Op = EffectOpStartSolo
+		case 3:
+			msg.Op = EffectOpStop
+		default:
+			return Effect{}, false
+		}
+		msg.LoopCount = data[3]
+		return msg, true
+	case ReportIDBlockFree:
+		p.effects[data[1]-1] = nil
+		msg := *e
+		msg.Op = EffectOpFree
+		return msg, true
+	default:
+		return Effect{}, false
+	}
+	msg := *e
+	msg.Op = EffectOpSet
+	return msg, true
+}
+
+// effectLocked returns the allocated effect with the given block index.
+func (p *pid) effectLocked(index uint8) *Effect {
+	if index < 1 || index > MaxEffects {
+		return nil
+	}
+	return p.effects[index-1]
+}
+
+// setFeature handles SET_REPORT of a feature report: Create New Effect
+// allocates a slot and stores the outcome for the following Block Load.
+func (p *pid) setFeature(data []byte) bool {
+	if len(data) < 2 || data[0] != ReportIDCreateEffect {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.blockLoad = [4]byte{0, BlockLoadError}
+	t := data[1]
+	if t < EffectConstantForce || t > EffectFriction {
+		return true
+	}
+	p.blockLoad[1] = BlockLoadFull
+	for i, e := range p.effects {
+		if e == nil {
+			p.effects[i] = &Effect{EffectIndex: uint8(i + 1), EffectType: t, Duration: DurationInfinite, Gain: 0xFF}
+			p.blockLoad = [4]byte{uint8(i + 1), BlockLoadSuccess}
+			break
+		}
+	}
+	binary.LittleEndian.PutUint16(p.blockLoad[2:], ramPoolSize)
+	return true
+}
+
+// getFeature answers GET_REPORT of the Block Load and Pool reports.
+func (p *pid) getFeature(id uint8) ([]byte, bool) {
+	switch id {
+	case ReportIDBlockLoad:
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return append([]byte{id}, p.blockLoad[:]...), true
+	case ReportIDPool:
+		r := []byte{id, 0, 0, MaxEffects, 0x01} // device managed pool, no shared parameter blocks
+		binary.LittleEndian.PutUint16(r[1:3], ramPoolSize)
+		return r, true
+	}
+	return nil, false
+}
+
+// PID usage page usages (HID PID 1.0).
+const (
+	pidSetEffectReport     = 0x21
+	pidEffectBlockIndex    = 0x22
+	pidEffectType          = 0x25
+	pidDuration            = 0x50
+	pidSamplePeriod        = 0x51
+	pidGain                = 0x52
+	pidTriggerButton       = 0x53
+	pidTriggerRepeat       = 0x54
+	pidAxesEnable          = 0x55
+	pidDirectionEnable     = 0x56
+	pidDirection           = 0x57
+	pidSetEnvelopeReport   = 0x5A
+	pidAttackLevel         = 0x5B
+	pidAttackTime          = 0x5C
+	pidFadeLevel           = 0x5D
+	pidFadeTime            = 0x5E
+	pidMagnitude           = 0x70
+	pidSetConstantReport   = 0x73
+	pidEffectOpReport      = 0x77
+	pidEffectOperation     = 0x78
+	pidLoopCount           = 0x7C
+	pidDeviceGainReport    = 0x7D
+	pidDeviceGain          = 0x7E
+	pidPoolReport          = 0x7F
+	pidRAMPoolSize         = 0x80
+	pidSimultaneousMax     = 0x83
+	pidBlockLoadReport     = 0x89
+	pidBlockLoadStatus     = 0x8B
+	pidBlockFreeReport     = 0x90
+	pidDeviceControlReport = 0x95
+	pidDeviceControl       = 0x96
+	pidDeviceManagedPool   = 0xA9
+	pidSharedParamBlocks   = 0xAA
+	pidCreateEffectReport  = 0xAB
+	pidRAMPoolAvailable    = 0xAC
+)
+
+// Selector usages in the order of their array values, starting at 1.
+var (
+	pidEffectTypes     = []uint16{0x26, 0x27, 0x30, 0x31, 0x32, 0x33, 0x34, 0x40, 0x41, 0x42, 0x43}
+	pidEffectOps       = []uint16{0x79, 0x7A, 0x7B}
+	pidDeviceControls  = []uint16{0x97, 0x98, 0x99, 0x9A, 0x9B, 0x9C}
+	pidBlockLoadStates = []uint16{0x8C, 0x8D, 0x8E}
+)
+
+func reportID(id uint8) hid.Item {
+	return hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x8, Data: hid.Data{id}}
+}
+
+// pidField declares one variable field read with main.
+func pidField(main hid.Item, usage uint16, bits uint8, lo, hi int32) []hid.Item {
+	return []hid.Item{
+		hid.Usage{Usage: usage},
+		hid.LogicalMinimum{Min: lo},
+		hid.LogicalMaximum{Max: hi},
+		hid.ReportSize{Bits: bits},
+		hid.ReportCount{Count: 1},
+		main,
+	}
+}
+
+// pidTime declares a 16-bit field in milliseconds.
+func pidTime(main hid.Item, usage uint16) []hid.Item {
+	items := []hid.Item{
+		hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x5, Data: hid.Data{0x0D}},       // Unit Exponent -3
+		hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x6, Data: hid.Data{0x01, 0x10}}, // Unit: seconds
+	}
+	items = append(items, pidField(main, usage, 16, 0, 0xFFFF)...)
+	return append(items,
+		hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x5, Data: hid.Data{0x00}},
+		hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x6, Data: hid.Data{0x00}},
+	)
+}
+
+// pidSelector declares an 8-bit array field selecting one of usages.
+func pidSelector(main hid.Item, usage uint16, usages []uint16) []hid.Item {
+	items := []hid.Item{hid.Usage{Usage: usage}}
+	var sel []hid.Item
+	for _, u := range usages {
+		sel = append(sel, hid.Usage{Usage: u})
+	}
+	sel = append(sel,
+		hid.LogicalMinimum{Min: 1},
+		hid.LogicalMaximum{Max: int32(len(usages))},
+		hid.ReportSize{Bits: 8},
+		hid.ReportCount{Count: 1},
+		main,
+	)
+	return append(items, hid.Collection{Kind: hid.CollectionLogical, Items: sel})
+}
+
+// pidReport declares a report collection with the given ID and fields.
+func pidReport(usage uint16, id uint8, fields ...[]hid.Item) []hid.Item {
+	items := []hid.Item{reportID(id)}
+	for _, f := range fields {
+		items = append(items, f...)
+	}
+	return []hid.Item{
+		hid.Usage{Usage: usage},
+		hid.Collection{Kind: hid.CollectionLogical, Items: items},
+	}
+}
+
+// pidReportItems declares the PID output and feature reports handled by
+// pid, laid out as it decodes them.
+func pidReportItems() []hid.Item {
+	out := hid.Output{Flags: hid.MainData | hid.MainVar | hid.MainAbs}
+	outArray := hid.Output{Flags: hid.MainData | hid.MainArray | hid.MainAbs}
+	feat := hid.Feature{Flags: hid.MainData | hid.MainVar | hid.MainAbs}
+	featArray := hid.Feature{Flags: hid.MainData | hid.MainArray | hid.MainAbs}
+	blockIndex := func(main hid.Item) []hid.Item { return pidField(main, pidEffectBlockIndex, 8, 1, MaxEffects) }
+
+	items := []hid.Item{hid.UsagePage{Page: hid.UsagePagePID}}
+	items = append(items, pidReport(pidSetEffectReport, ReportIDSetEffect,
+		blockIndex(out),
+		pidSelector(outArray, pidEffectType, pidEffectTypes),
+		pidTime(out, pidDuration),
+		pidTime(out, pidTriggerRepeat),
+		pidTime(out, pidSamplePeriod),
+		pidField(out, pidGain, 8, 0, 0xFF),
+		pidField(out, pidTriggerButton, 8, 0, MaxButtons),
+		[]hid.Item{
+			hid.Usage{Usage: pidAxesEnable},
+			hid.Collection{Kind: hid.CollectionLogical, Items: []hid.Item{
+				hid.UsagePage{Page: hid.UsagePageGenericDesktop},
+				hid.Usage{Usage: hid.UsageX},
+				hid.Usage{Usage: hid.UsageY},
+				hid.LogicalMinimum{Min: 0},
+				hid.LogicalMaximum{Max: 1},
+				hid.ReportSize{Bits: 1},
+				hid.ReportCount{Count: 2},
+				out,
+			}},
+			hid.UsagePage{Page: hid.UsagePagePID},
+			hid.Usage{Usage: pidDirectionEnable},
+			hid.ReportCount{Count: 1},
+			out,
+			hid.ReportCount{Count: 5},
+			hid.Output{Flags: hid.MainConst},
+			hid.Usage{Usage: pidDirection},
+			hid.Collection{Kind: hid.CollectionLogical, Items: []hid.Item{
+				hid.UsagePage{Page: hid.UsagePageOrdinal},
+				hid.Usage{Usage: 0x01},
+				hid.LogicalMinimum{Min: 0},
+				hid.LogicalMaximum{Max: 35999},
+				hid.ReportSize{Bits: 16},
+				hid.ReportCount{Count: 1},
+				out,
+			}},
+			hid.UsagePage{Page: hid.UsagePagePID},
+		},
+	)...)
+	items = append(items, pidReport(pidSetEnvelopeReport, ReportIDSetEnvelope,
+		blockIndex(out),
+		pidField(out, pidAttackLevel, 16, 0, 10000),
+		pidField(out, pidFadeLevel, 16, 0, 10000),
+		pidTime(out, pidAttackTime),
+		pidTime(out, pidFadeTime),
+	)...)
+	items = append(items, pidReport(pidSetConstantReport, ReportIDSetConstant,
+		blockIndex(out),
+		pidField(out, pidMagnitude, 16, -10000, 10000),
+	)...)
+	items = append(items, pidReport(pidEffectOpReport, ReportIDEffectOp,
+		blockIndex(out),
+		pidSelector(outArray, pidEffectOperation, pidEffectOps),
+		pidField(out, pidLoopCount, 8, 0, 0xFF),
+	)...)
+	items = append(items, pidReport(pidBlockFreeReport, ReportIDBlockFree,
+		blockIndex(out),
+	)...)
+	items = append(items, pidReport(pidDeviceControlReport, ReportIDDeviceControl,
+		pidSelector(outArray, pidDeviceControl, pidDeviceControls),
+	)...)
+	items = append(items, pidReport(pidDeviceGainReport, ReportIDDeviceGain,
+		pidField(out, pidDeviceGain, 8, 0, 0xFF),
+	)...)
+	items = append(items, pidReport(pidCreateEffectReport, ReportIDCreateEffect,
+		pidSelector(featArray, pidEffectType, pidEffectTypes),
+		[]hid.Item{
+			hid.UsagePage{Page: hid.UsagePageGenericDesktop},
+			hid.Usage{Usage: 0x3B}, // Byte Count
+			hid.LogicalMinimum{Min: 0},
+			hid.LogicalMaximum{Max: 0x1FF},
+			hid.ReportSize{Bits: 16},
+			hid.ReportCount{Count: 1},
+			feat,
+			hid.UsagePage{Page: hid.UsagePagePID},
+		},
+	)...)
+	items = append(items, pidReport(pidBlockLoadReport, ReportIDBlockLoad,
+		pidField(feat, pidEffectBlockIndex, 8, 0, MaxEffects),
+		pidSelector(featArray, pidBlockLoadStatus, pidBlockLoadStates),
+		pidField(feat, pidRAMPoolAvailable, 16, 0, 0xFFFF),
+	)...)
+	items = append(items, pidReport(pidPoolReport, ReportIDPool,
+		pidField(feat, pidRAMPoolSize, 16, 0, 0xFFFF),
+		pidField(feat, pidSimultaneousMax, 8, 0, MaxEffects),
+		[]hid.Item{
+			hid.Usage{Usage: pidDeviceManagedPool},
+			hid.Usage{Usage: pidSharedParamBlocks},
+			hid.LogicalMinimum{Min: 0},
+			hid.LogicalMaximum{Max: 1},
+			hid.ReportSize{Bits: 1},
+			hid.ReportCount{Count: 2},
+			feat,
+			hid.ReportCount{Count: 6},
+			hid.Feature{Flags: hid.MainConst},
+		},
+	)...)
+	return items
+}
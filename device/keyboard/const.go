@@ -21,6 +21,24 @@ const (
 	LEDKana       = 0x10
 )
 
+// Report IDs of a keyboard created with media keys. They also select the
+// kind of each packet its stream reads, see Report.
+const (
+	ReportIDKeys  = 0x01 // key state and LED output report
+	ReportIDMedia = 0x02 // consumer control report
+)
+
+// Media key bitmasks of MediaState
+const (
+	MediaVolumeUp      = 0x0001
+	MediaVolumeDown    = 0x0002
+	MediaMute          = 0x0004
+	MediaPlayPause     = 0x0008
+	MediaNextTrack     = 0x0010
+	MediaPreviousTrack = 0x0020
+	MediaStop          = 0x0040
+)
+
 // HID Usage codes for keyboard keys (USB HID Keyboard/Keypad usage page)
 const (
 	// Letters A-Z
@@ -169,6 +187,16 @@ const (
 	KeyVolumeUp   = 0x80
 	KeyVolumeDown = 0x81
 
+	// Modifier keys; in event mode they drive the modifier byte
+	KeyLeftCtrl   = 0xE0
+	KeyLeftShift  = 0xE1
+	KeyLeftAlt    = 0xE2
+	KeyLeftGUI    = 0xE3
+	KeyRightCtrl  = 0xE4
+	KeyRightShift = 0xE5
+	KeyRightAlt   = 0xE6
+	KeyRightGUI   = 0xE7
+
 	// Media control keys
 	KeyMediaPlayPause = 0xE8 // Play/Pause
 	KeyMediaStop      = 0xE9 // Stop
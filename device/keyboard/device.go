@@ -2,6 +2,8 @@
 package keyboard
 
 import (
+	"encoding/json"
+	"fmt"
 	"sync"
 	"sync/atomic"
 
@@ -17,28 +19,89 @@ type Keyboard struct {
 	inputState  *InputState
 	stateMu     sync.Mutex
 	ledState    uint8
-	ledCallback func(LEDState)
+	ledCallback func(LEDState) // guarded by stateMu
 	descriptor  usb.Descriptor
+	humanize    device.Humanizer
+	step        device.Stepper
+	protocol    device.BootProtocol
+
+	// With media keys, reports carry report IDs and the consumer control
+	// report shares the interrupt IN endpoint with the key report.
+	mediaKeys  bool
+	mediaState uint16
+	pending    []uint8 // IDs of reports changed since the host read them, oldest first
+
+	inputChanged bool // since the last interrupt IN report
+	notify       usb.Notifier
+}
+
+type KeyboardCreateOptions struct {
+	// MediaKeys adds the consumer control report for MediaState. The stream
+	// then expects a report ID before every packet, see Report.
+	MediaKeys *bool `json:"mediaKeys"`
 }
 
 // New returns a new Keyboard device.
 func New(o *device.CreateOptions) (*Keyboard, error) {
-	d := &Keyboard{
-		descriptor: defaultDescriptor,
+	d := &Keyboard{inputChanged: true}
+	if o != nil && o.DeviceSpecific != nil {
+		data, err := json.Marshal(o.DeviceSpecific)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON payload: %w", err)
+		}
+		var args KeyboardCreateOptions
+		if err := json.Unmarshal(data, &args); err != nil {
+			return nil, fmt.Errorf("invalid JSON payload: %w", err)
+		}
+		d.mediaKeys = args.MediaKeys != nil && *args.MediaKeys
 	}
+	d.descriptor = newDescriptor(d.mediaKeys)
 	if o != nil {
-		if o.IdVendor != nil {
-			d.descriptor.Device.IDVendor = *o.IdVendor
+		if err := o.ApplyIdentity(&d.descriptor); err != nil {
+			return nil, err
 		}
-		if o.IdProduct != nil {
-			d.descriptor.Device.IDProduct = *o.IdProduct
+		if o.Humanize != nil {
+			if err := d.humanize.Configure(*o.Humanize); err != nil {
+				return nil, err
+			}
+		}
+		if o.Deterministic != nil {
+			d.step.Configure(*o.Deterministic)
 		}
 	}
 	return d, nil
 }
 
+// MediaKeys reports whether the keyboard was created with media keys.
+func (k *Keyboard) MediaKeys() bool {
+	return k.mediaKeys
+}
+
+// BootProtocol returns the protocol selected by the host. In boot protocol
+// the keyboard sends the 8-byte boot report and no media keys.
+func (k *Keyboard) BootProtocol() *device.BootProtocol {
+	return &k.protocol
+}
+
+// BusReset returns to the report protocol for a newly attached host.
+func (k *Keyboard) BusReset() {
+	k.protocol.Reset()
+}
+
+// Humanizer returns the pacing applied to streamed key states.
+func (k *Keyboard) Humanizer() *device.Humanizer {
+	return &k.humanize
+}
+
+// Stepper returns the queue of streamed states in deterministic mode.
+func (k *Keyboard) Stepper() *device.Stepper {
+	return &k.step
+}
+
 // SetLEDCallback sets a callback that will be invoked when LED state changes.
 func (k *Keyboard) SetLEDCallback(f func(LEDState)) {
+	k.stateMu.Lock()
+	defer k.stateMu.Unlock()
 	k.ledCallback = f
 }
 
@@ -60,157 +123,304 @@ func (k *Keyboard) UpdateInputState(state InputState) {
 	k.stateMu.Lock()
 	defer k.stateMu.Unlock()
 	k.inputState = &state
+	k.changed(ReportIDKeys)
+}
+
+// UpdateMediaState updates the held media keys (thread-safe). It has no
+// effect on keyboards created without media keys.
+func (k *Keyboard) UpdateMediaState(state MediaState) {
+	if !k.mediaKeys {
+		return
+	}
+	k.stateMu.Lock()
+	defer k.stateMu.Unlock()
+	k.mediaState = state.Keys
+	k.changed(ReportIDMedia)
+}
+
+// ResetInputState releases all keys, modifiers and media keys.
+func (k *Keyboard) ResetInputState() {
+	k.UpdateInputState(InputState{})
+	k.UpdateMediaState(MediaState{})
+}
+
+// changed queues report id for the host's next poll and wakes a held one.
+// Caller holds stateMu.
+func (k *Keyboard) changed(id uint8) {
+	k.inputChanged = true
+	k.notify.Notify()
+	if !k.mediaKeys {
+		return
+	}
+	for _, p := range k.pending {
+		if p == id {
+			return
+		}
+	}
+	k.pending = append(k.pending, id)
 }
 
 // HandleTransfer implements interrupt IN/OUT for Keyboard.
 func (k *Keyboard) HandleTransfer(ep uint32, dir uint32, out []byte) []byte {
+	if ep != 1 {
+		return nil
+	}
 	if dir == usbip.DirIn {
-		switch ep {
-		case 1: // 0x81 - keyboard input reports
-			atomic.AddUint64(&k.tick, 1)
-
-			k.stateMu.Lock()
-			var st InputState
-			if k.inputState != nil {
-				st = *k.inputState
-			}
-			k.stateMu.Unlock()
-			return st.BuildReport()
-		default:
-			return nil
+		// 0x81 - keyboard input reports
+		atomic.AddUint64(&k.tick, 1)
+		return k.nextReport()
+	}
+	// 0x01 - LED state from host
+	if leds, ok := k.outputLEDs(out); ok {
+		k.setLEDs(leds)
+	}
+	return nil
+}
+
+// HandleTransferStatus holds polls of 0x81 while no report changed since the
+// last one, see usb.ErrPending, and stalls endpoints other than 1.
+func (k *Keyboard) HandleTransferStatus(ep uint32, dir uint32, out []byte) ([]byte, error) {
+	if ep != 1 {
+		return nil, usb.StatusStall
+	}
+	if dir == usbip.DirIn {
+		k.stateMu.Lock()
+		changed := k.inputChanged
+		k.stateMu.Unlock()
+		if !changed {
+			return nil, usb.ErrPending
 		}
 	}
-	if dir == usbip.DirOut && ep == 1 {
-		// 0x01 - LED state from host
-		if len(out) >= 1 {
-			k.stateMu.Lock()
-			k.ledState = out[0]
-			k.stateMu.Unlock()
-
-			if k.ledCallback != nil {
-				k.ledCallback(LEDState{
-					NumLock:    out[0]&LEDNumLock != 0,
-					CapsLock:   out[0]&LEDCapsLock != 0,
-					ScrollLock: out[0]&LEDScrollLock != 0,
-					Compose:    out[0]&LEDCompose != 0,
-					Kana:       out[0]&LEDKana != 0,
-				})
-			}
+	return k.HandleTransfer(ep, dir, out), nil
+}
+
+// InputNotifier returns the notifier fired by UpdateInputState and
+// UpdateMediaState.
+func (k *Keyboard) InputNotifier() *usb.Notifier {
+	return &k.notify
+}
+
+// HandleControl answers HID GET_REPORT with the requested input report, takes
+// LED state from SET_REPORT, which hosts use instead of the OUT endpoint, and
+// serves the protocol and idle requests of a boot keyboard.
+func (k *Keyboard) HandleControl(bmRequestType, bRequest uint8, wValue, wIndex, _ /* wLength */ uint16, data []byte) ([]byte, bool) {
+	const (
+		hidGetReport     = 0x01
+		hidSetReport     = 0x09
+		reportTypeInput  = 0x01
+		reportTypeOutput = 0x02
+	)
+	if resp, ok := k.protocol.HandleControl(bmRequestType, bRequest, wValue, wIndex); ok {
+		return resp, true
+	}
+	reportType := uint8(wValue >> 8)
+	switch {
+	case bmRequestType == 0xA1 && bRequest == hidGetReport && reportType == reportTypeInput:
+		if !k.mediaKeys || k.protocol.Boot() {
+			return k.report(ReportIDKeys), true
+		}
+		if id := uint8(wValue); id == ReportIDKeys || id == ReportIDMedia {
+			return k.report(id), true
+		}
+	case bmRequestType == 0x21 && bRequest == hidSetReport && reportType == reportTypeOutput:
+		if leds, ok := k.outputLEDs(data); ok {
+			k.setLEDs(leds)
+			return nil, true
 		}
 	}
-	return nil
+	return nil, false
 }
 
-// HID Report Descriptor for a full keyboard with 256-bit key bitmap and LED output.
-var reportDescriptor = hid.Report{
-	Items: []hid.Item{
-		hid.UsagePage{Page: hid.UsagePageGenericDesktop},
-		hid.Usage{Usage: hid.UsageKeyboard},
-		hid.Collection{
-			Kind: hid.CollectionApplication,
-			Items: []hid.Item{
-				// Input Report: Modifiers (1 byte)
-				hid.UsagePage{Page: hid.UsagePageKeyboard},
-				hid.UsageMinimum{Min: 0xE0}, // Left Control
-				hid.UsageMaximum{Max: 0xE7}, // Right GUI
-				hid.LogicalMinimum{Min: 0},
-				hid.LogicalMaximum{Max: 1},
-				hid.ReportSize{Bits: 1},
-				hid.ReportCount{Count: 8},
-				hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
-
-				// Input Report: Reserved byte (1 byte)
-				hid.ReportSize{Bits: 8},
-				hid.ReportCount{Count: 1},
-				hid.Input{Flags: hid.MainConst},
-
-				// Input Report: Key array bitmap (32 bytes = 256 bits)
-				hid.UsagePage{Page: hid.UsagePageKeyboard},
-				hid.UsageMinimum{Min: 0x00},
-				hid.UsageMaximum{Max: 0xFF},
-				hid.LogicalMinimum{Min: 0},
-				hid.LogicalMaximum{Max: 1},
-				hid.ReportSize{Bits: 1},
-				hid.ReportCount{Count: 256},
-				hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
-
-				// Output Report: LEDs (1 byte)
-				hid.UsagePage{Page: hid.UsagePageLEDs},
-				hid.UsageMinimum{Min: 0x01}, // Num Lock
-				hid.UsageMaximum{Max: 0x05}, // Kana
-				hid.LogicalMinimum{Min: 0},
-				hid.LogicalMaximum{Max: 1},
-				hid.ReportSize{Bits: 1},
-				hid.ReportCount{Count: 5},
-				hid.Output{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
-				hid.ReportSize{Bits: 3},
-				hid.ReportCount{Count: 1},
-				hid.Output{Flags: hid.MainConst},
-			},
+// nextReport returns the report for an interrupt IN poll: the oldest one
+// changed since the last poll, else the key report.
+func (k *Keyboard) nextReport() []byte {
+	id := uint8(ReportIDKeys)
+	k.stateMu.Lock()
+	if k.protocol.Boot() {
+		k.pending = k.pending[:0]
+	} else if len(k.pending) > 0 {
+		id = k.pending[0]
+		k.pending = k.pending[1:]
+	}
+	k.inputChanged = len(k.pending) > 0
+	k.stateMu.Unlock()
+	return k.report(id)
+}
+
+// report builds the input report id, prefixed with its ID on keyboards with
+// media keys, or the boot report in boot protocol.
+func (k *Keyboard) report(id uint8) []byte {
+	k.stateMu.Lock()
+	var st InputState
+	if k.inputState != nil {
+		st = *k.inputState
+	}
+	media := MediaState{Keys: k.mediaState}
+	k.stateMu.Unlock()
+	switch {
+	case k.protocol.Boot():
+		return st.BuildBootReport()
+	case !k.mediaKeys:
+		return st.BuildReport()
+	case id == ReportIDMedia:
+		return media.BuildReport()
+	default:
+		return append([]byte{ReportIDKeys}, st.BuildReport()...)
+	}
+}
+
+// outputLEDs extracts the LED bits from an output report.
+func (k *Keyboard) outputLEDs(data []byte) (uint8, bool) {
+	if !k.mediaKeys || k.protocol.Boot() {
+		if len(data) < 1 {
+			return 0, false
+		}
+		return data[0], true
+	}
+	if len(data) < 2 || data[0] != ReportIDKeys {
+		return 0, false
+	}
+	return data[1], true
+}
+
+func (k *Keyboard) setLEDs(v uint8) {
+	k.stateMu.Lock()
+	k.ledState = v
+	ledCallback := k.ledCallback
+	k.stateMu.Unlock()
+
+	if ledCallback != nil {
+		ledCallback(LEDState{
+			NumLock:    v&LEDNumLock != 0,
+			CapsLock:   v&LEDCapsLock != 0,
+			ScrollLock: v&LEDScrollLock != 0,
+			Compose:    v&LEDCompose != 0,
+			Kana:       v&LEDKana != 0,
+		})
+	}
+}
+
+// keyboardReportItems describe the report of a full keyboard with 256-bit key
+// bitmap and LED output.
+var keyboardReportItems = []hid.Item{
+	hid.UsagePage{Page: hid.UsagePageGenericDesktop},
+	hid.Usage{Usage: hid.UsageKeyboard},
+	hid.Collection{
+		Kind: hid.CollectionApplication,
+		Items: []hid.Item{
+			// Input Report: Modifiers (1 byte)
+			hid.UsagePage{Page: hid.UsagePageKeyboard},
+			hid.UsageMinimum{Min: 0xE0}, // Left Control
+			hid.UsageMaximum{Max: 0xE7}, // Right GUI
+			hid.LogicalMinimum{Min: 0},
+			hid.LogicalMaximum{Max: 1},
+			hid.ReportSize{Bits: 1},
+			hid.ReportCount{Count: 8},
+			hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+
+			// Input Report: Reserved byte (1 byte)
+			hid.ReportSize{Bits: 8},
+			hid.ReportCount{Count: 1},
+			hid.Input{Flags: hid.MainConst},
+
+			// Input Report: Key array bitmap (32 bytes = 256 bits)
+			hid.UsagePage{Page: hid.UsagePageKeyboard},
+			hid.UsageMinimum{Min: 0x00},
+			hid.UsageMaximum{Max: 0xFF},
+			hid.LogicalMinimum{Min: 0},
+			hid.LogicalMaximum{Max: 1},
+			hid.ReportSize{Bits: 1},
+			hid.ReportCount{Count: 256},
+			hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+
+			// Output Report: LEDs (1 byte)
+			hid.UsagePage{Page: hid.UsagePageLEDs},
+			hid.UsageMinimum{Min: 0x01}, // Num Lock
+			hid.UsageMaximum{Max: 0x05}, // Kana
+			hid.LogicalMinimum{Min: 0},
+			hid.LogicalMaximum{Max: 1},
+			hid.ReportSize{Bits: 1},
+			hid.ReportCount{Count: 5},
+			hid.Output{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+			hid.ReportSize{Bits: 3},
+			hid.ReportCount{Count: 1},
+			hid.Output{Flags: hid.MainConst},
 		},
 	},
 }
 
-// Descriptor defines the static USB descriptor for the keyboard.
-var defaultDescriptor = usb.Descriptor{
-	Device: usb.DeviceDescriptor{
-		BcdUSB:             0x0200,
-		BDeviceClass:       0x00,
-		BDeviceSubClass:    0x00,
-		BDeviceProtocol:    0x00,
-		BMaxPacketSize0:    0x40, // 64 bytes
-		IDVendor:           0x2E8A,
-		IDProduct:          0x0010,
-		BcdDevice:          0x0100,
-		IManufacturer:      0x01,
-		IProduct:           0x02,
-		ISerialNumber:      0x03,
-		BNumConfigurations: 0x01,
-		Speed:              2, // Full speed
-	},
-	Interfaces: []usb.InterfaceConfig{
-		{
-			Descriptor: usb.InterfaceDescriptor{
-				BInterfaceNumber:   0x00,
-				BAlternateSetting:  0x00,
-				BNumEndpoints:      0x02,
-				BInterfaceClass:    0x03, // HID
-				BInterfaceSubClass: 0x00, // No Subclass
-				BInterfaceProtocol: 0x00, // None
-				IInterface:         0x00,
-			},
-			HID: &usb.HIDFunction{
-				Descriptor: usb.HIDDescriptor{
-					BcdHID:       0x0111,
-					BCountryCode: 0x00,
-					Descriptors: []usb.HIDSubDescriptor{
-						{Type: usb.ReportDescType}, // Length auto-filled from Report
-					},
+// reportDescriptor returns the HID report descriptor, with report IDs and the
+// consumer control report if media is set.
+func reportDescriptor(media bool) hid.Report {
+	if !media {
+		return hid.Report{Items: keyboardReportItems}
+	}
+	items := append([]hid.Item{reportID(ReportIDKeys)}, keyboardReportItems...)
+	return hid.Report{Items: append(items, mediaReportItems()...)}
+}
+
+// newDescriptor returns the USB descriptor of the keyboard.
+func newDescriptor(media bool) usb.Descriptor {
+	return usb.Descriptor{
+		Device: usb.DeviceDescriptor{
+			BcdUSB:             0x0200,
+			BDeviceClass:       0x00,
+			BDeviceSubClass:    0x00,
+			BDeviceProtocol:    0x00,
+			BMaxPacketSize0:    0x40, // 64 bytes
+			IDVendor:           0x2E8A,
+			IDProduct:          0x0010,
+			BcdDevice:          0x0100,
+			IManufacturer:      0x01,
+			IProduct:           0x02,
+			ISerialNumber:      0x03,
+			BNumConfigurations: 0x01,
+			Speed:              2, // Full speed
+		},
+		Interfaces: []usb.InterfaceConfig{
+			{
+				Descriptor: usb.InterfaceDescriptor{
+					BInterfaceNumber:   0x00,
+					BAlternateSetting:  0x00,
+					BNumEndpoints:      0x02,
+					BInterfaceClass:    0x03, // HID
+					BInterfaceSubClass: 0x01, // Boot interface
+					BInterfaceProtocol: 0x01, // Keyboard
+					IInterface:         0x00,
 				},
-				Report: reportDescriptor,
-			},
-			Endpoints: []usb.EndpointDescriptor{
-				{
-					BEndpointAddress: 0x81,
-					BMAttributes:     0x03, // Interrupt
-					WMaxPacketSize:   0x0040,
-					BInterval:        0x05, // 5 ms
+				HID: &usb.HIDFunction{
+					Descriptor: usb.HIDDescriptor{
+						BcdHID:       0x0111,
+						BCountryCode: 0x00,
+						Descriptors: []usb.HIDSubDescriptor{
+							{Type: usb.ReportDescType}, // Length auto-filled from Report
+						},
+					},
+					Report: reportDescriptor(media),
 				},
-				{
-					BEndpointAddress: 0x01,
-					BMAttributes:     0x03, // Interrupt
-					WMaxPacketSize:   0x0008,
-					BInterval:        0x05, // 5 ms
+				Endpoints: []usb.EndpointDescriptor{
+					{
+						BEndpointAddress: 0x81,
+						BMAttributes:     0x03, // Interrupt
+						WMaxPacketSize:   0x0040,
+						BInterval:        0x05, // 5 ms
+					},
+					{
+						BEndpointAddress: 0x01,
+						BMAttributes:     0x03, // Interrupt
+						WMaxPacketSize:   0x0008,
+						BInterval:        0x05, // 5 ms
+					},
 				},
 			},
 		},
-	},
-	Strings: map[uint8]string{
-		0: "\x04\x09", // LangID: en-US (0x0409)
-		1: "VIIPER",
-		2: "HID Keyboard",
-		3: "1337",
-	},
+		Strings: map[uint8]string{
+			0: "\x04\x09", // LangID: en-US (0x0409)
+			1: "VIIPER",
+			2: "HID Keyboard",
+			3: "1337",
+		},
+	}
 }
 
 func (k *Keyboard) GetDescriptor() *usb.Descriptor {
@@ -218,5 +428,8 @@ func (k *Keyboard) GetDescriptor() *usb.Descriptor {
 }
 
 func (x *Keyboard) GetDeviceSpecificArgs() map[string]any {
+	if x.mediaKeys {
+		return map[string]any{"mediaKeys": true}
+	}
 	return map[string]any{}
 }
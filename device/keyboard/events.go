@@ -0,0 +1,76 @@
+package keyboard
+
+import (
+	"fmt"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// MaxHeldKeys caps the non-modifier keys held at once in event mode; further
+// presses are dropped until a key is released.
+const MaxHeldKeys = 32
+
+// eventFolder folds event-mode packets into an InputState. Event codes are HID
+// usage codes; KeyLeftCtrl through KeyRightGUI map onto the modifier byte.
+// Media keys are not reachable in event mode.
+type eventFolder struct {
+	state    InputState
+	held     int
+	reportID bool // prefix states with ReportIDKeys, for keyboards with media keys
+}
+
+func (h *handler) EventFolder(dev usb.Device) device.EventFolder {
+	kb, ok := dev.(*Keyboard)
+	return &eventFolder{reportID: ok && kb.MediaKeys()}
+}
+
+func (f *eventFolder) Fold(typ, code uint8) ([]byte, error) {
+	switch typ {
+	case device.EventPress:
+		if code >= KeyLeftCtrl && code <= KeyRightGUI {
+			f.state.Modifiers |= 1 << (code - KeyLeftCtrl)
+			break
+		}
+		if f.pressed(code) {
+			break
+		}
+		if f.held >= MaxHeldKeys {
+			return nil, fmt.Errorf("%w: key 0x%02x exceeds %d held keys", device.ErrEventDropped, code, MaxHeldKeys)
+		}
+		f.state.KeyBitmap[code/8] |= 1 << (code % 8)
+		f.held++
+	case device.EventRelease:
+		if code >= KeyLeftCtrl && code <= KeyRightGUI {
+			f.state.Modifiers &^= 1 << (code - KeyLeftCtrl)
+			break
+		}
+		if f.pressed(code) {
+			f.state.KeyBitmap[code/8] &^= 1 << (code % 8)
+			f.held--
+		}
+	case device.EventReleaseAll:
+		return f.ReleaseAll(), nil
+	default:
+		return nil, fmt.Errorf("unknown event type 0x%02x", typ)
+	}
+	return f.marshal(), nil
+}
+
+func (f *eventFolder) ReleaseAll() []byte {
+	f.state, f.held = InputState{}, 0
+	return f.marshal()
+}
+
+func (f *eventFolder) marshal() []byte {
+	if f.reportID {
+		b, _ := KeysReport(f.state).MarshalBinary()
+		return b
+	}
+	b, _ := f.state.MarshalBinary()
+	return b
+}
+
+func (f *eventFolder) pressed(code uint8) bool {
+	return f.state.KeyBitmap[code/8]&(1<<(code%8)) != 0
+}
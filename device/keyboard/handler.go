@@ -1,6 +1,8 @@
 package keyboard
 
 import (
+	"encoding"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
@@ -13,12 +15,30 @@ import (
 
 func init() {
 	api.RegisterDevice("keyboard", &handler{})
+	device.RegisterOutputDecoder("keyboard", device.OutputDecoder{
+		Size: OutputLayout.Size(),
+		New:  func() encoding.BinaryUnmarshaler { return new(LEDState) },
+	})
 }
 
 type handler struct{}
 
 func (h *handler) CreateDevice(o *device.CreateOptions) (usb.Device, error) { return New(o) }
 
+func (h *handler) OutputLayout(usb.Device) device.WireLayout { return OutputLayout }
+
+func (h *handler) MacroState(dev usb.Device, state []byte) (func(), error) {
+	kdev, ok := dev.(*Keyboard)
+	if !ok {
+		return nil, fmt.Errorf("device is not keyboard")
+	}
+	var st InputState
+	if err := json.Unmarshal(state, &st); err != nil {
+		return nil, err
+	}
+	return func() { kdev.UpdateInputState(st) }, nil
+}
+
 func (h *handler) StreamHandler() api.StreamHandlerFunc {
 	return func(conn net.Conn, devPtr *usb.Device, logger *slog.Logger) error {
 		if devPtr == nil || *devPtr == nil {
@@ -54,34 +74,87 @@ func (h *handler) StreamHandler() api.StreamHandlerFunc {
 
 		// Read loop: Client → Device (key presses)
 		for {
-			// Read header (2 bytes minimum: modifiers + key count)
-			header := make([]byte, 2)
-			if _, err := io.ReadFull(conn, header); err != nil {
-				if err == io.EOF {
-					logger.Info("client disconnected")
-					return nil
+			id := uint8(ReportIDKeys)
+			if kdev.mediaKeys {
+				var sel [1]byte
+				if _, err := io.ReadFull(conn, sel[:]); err != nil {
+					if err == io.EOF {
+						logger.Info("client disconnected")
+						return nil
+					}
+					return fmt.Errorf("read report ID: %w", err)
 				}
-				return fmt.Errorf("read header: %w", err)
+				id = sel[0]
 			}
 
-			keyCount := header[1]
-
-			// Read key codes
-			keys := make([]byte, keyCount)
-			if keyCount > 0 {
-				if _, err := io.ReadFull(conn, keys); err != nil {
-					return fmt.Errorf("read keys: %w", err)
+			var apply func()
+			switch id {
+			case ReportIDKeys:
+				state, err := readInputState(conn, !kdev.mediaKeys)
+				if err != nil {
+					if err == io.EOF {
+						logger.Info("client disconnected")
+						return nil
+					}
+					return err
+				}
+				apply = func() { kdev.UpdateInputState(state) }
+			case ReportIDMedia:
+				buf := make([]byte, 2)
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					return fmt.Errorf("read media state: %w", err)
+				}
+				var state MediaState
+				if err := state.UnmarshalBinary(buf); err != nil {
+					return fmt.Errorf("unmarshal media state: %w", err)
 				}
+				apply = func() { kdev.UpdateMediaState(state) }
+			default:
+				return fmt.Errorf("unknown report ID 0x%02x", id)
 			}
 
-			// Build full packet and unmarshal
-			fullPacket := append(header, keys...)
-			var state InputState
-			if err := state.UnmarshalBinary(fullPacket); err != nil {
-				return fmt.Errorf("unmarshal input state: %w", err)
+			if kdev.step.Active() {
+				if err := kdev.step.Push(apply); err != nil {
+					return err
+				}
+				continue
+			}
+			if kdev.humanize.Active() {
+				kdev.humanize.Key(apply)
+				continue
 			}
+			apply()
+		}
+	}
+}
 
-			kdev.UpdateInputState(state)
+// readInputState reads one key state packet. A clean end of stream before it
+// is io.EOF if first is set, i.e. no report ID preceded it.
+func readInputState(conn net.Conn, first bool) (InputState, error) {
+	var state InputState
+	// Read header (2 bytes minimum: modifiers + key count)
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		if err == io.EOF && first {
+			return state, io.EOF
 		}
+		return state, fmt.Errorf("read header: %w", err)
+	}
+
+	keyCount := header[1]
+
+	// Read key codes
+	keys := make([]byte, keyCount)
+	if keyCount > 0 {
+		if _, err := io.ReadFull(conn, keys); err != nil {
+			return state, fmt.Errorf("read keys: %w", err)
+		}
+	}
+
+	// Build full packet and unmarshal
+	fullPacket := append(header, keys...)
+	if err := state.UnmarshalBinary(fullPacket); err != nil {
+		return state, fmt.Errorf("unmarshal input state: %w", err)
 	}
+	return state, nil
 }
@@ -17,6 +17,8 @@ func NeedsShift(c byte) bool {
 // TypeString converts a string into a sequence of InputState press/release pairs.
 // Automatically handles shift modifiers for uppercase letters and symbols.
 // Returns a slice of states alternating between press and release.
+// It types bytes on the US layout; unsupported bytes yield an empty pair.
+// Use TypeStringLayout for other layouts and non-ASCII text.
 //
 // Example:
 //
@@ -25,9 +27,12 @@ func NeedsShift(c byte) bool {
 func TypeString(s string) []InputState {
 	var states []InputState
 	for i := 0; i < len(s); i++ {
-		c := s[i]
-		press, release := TypeChar(c)
-		states = append(states, press, release)
+		strokes, ok := LayoutUS.Chars[rune(s[i])]
+		if !ok {
+			states = append(states, InputState{}, InputState{})
+			continue
+		}
+		states = appendStrokes(states, strokes)
 	}
 	return states
 }
@@ -2,6 +2,8 @@ package keyboard
 
 import (
 	"io"
+
+	"github.com/Alia5/VIIPER/device"
 )
 
 // InputState represents the keyboard state used to build a report.
@@ -22,6 +24,11 @@ type LEDState struct {
 	Kana       bool
 }
 
+// OutputLayout is the field layout of the LEDState wire format.
+var OutputLayout = device.WireLayout{
+	{Name: "leds", Size: 1},
+}
+
 // UnmarshalBinary decodes a 1-byte LED bitmask into LEDState.
 // Bits are defined by LEDNumLock, LEDCapsLock, LEDScrollLock, LEDCompose, LEDKana.
 func (ls *LEDState) UnmarshalBinary(data []byte) error {
@@ -52,6 +59,34 @@ func (kb *InputState) BuildReport() []byte {
 	return b
 }
 
+// BuildBootReport encodes an InputState into the 8-byte boot protocol report.
+//
+// Report layout (8 bytes):
+//
+//	Byte 0: Modifiers (8 bits)
+//	Byte 1: Reserved (0x00)
+//	Bytes 2-7: Up to 6 pressed keys, lowest usage first; all 0x01 (ErrorRollOver)
+//	           when more are pressed
+func (kb *InputState) BuildBootReport() []byte {
+	b := make([]byte, 8)
+	b[0] = kb.Modifiers
+	n := 0
+	for i := 1; i < KeyLeftCtrl; i++ {
+		if kb.KeyBitmap[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		if n == 6 {
+			for j := 2; j < 8; j++ {
+				b[j] = 0x01
+			}
+			break
+		}
+		b[2+n] = uint8(i)
+		n++
+	}
+	return b
+}
+
 // MarshalBinary encodes InputState to variable-length wire format.
 //
 // Wire format:
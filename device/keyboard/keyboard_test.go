@@ -8,14 +8,17 @@ import (
 
 	viiperTesting "github.com/Alia5/VIIPER/_testing"
 	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/device"
 	"github.com/Alia5/VIIPER/device/keyboard"
 	"github.com/Alia5/VIIPER/internal/server/api"
 	"github.com/Alia5/VIIPER/internal/server/api/handler"
 	"github.com/Alia5/VIIPER/usbip"
 	"github.com/Alia5/VIIPER/virtualbus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	_ "github.com/Alia5/VIIPER/internal/registry" // Register devices
+	"github.com/Alia5/VIIPER/usb"
 )
 
 func TestInputReports(t *testing.T) {
@@ -59,6 +62,12 @@ func TestInputReports(t *testing.T) {
 			inputState:     keyboard.PressKey(keyboard.KeyW, keyboard.KeyA, keyboard.KeyS, keyboard.KeyD),
 			expectedReport: []byte{0x00, 0x00, 0x90, 0x00, 0x40, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
 		},
+		{
+			name: "10 keys (N-key rollover)",
+			inputState: keyboard.PressKey(keyboard.KeyA, keyboard.KeyB, keyboard.KeyC, keyboard.KeyD, keyboard.KeyE,
+				keyboard.KeyF, keyboard.KeyG, keyboard.KeyH, keyboard.KeyI, keyboard.KeyJ),
+			expectedReport: []byte{0x00, 0x00, 0xF0, 0x3F, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
 	}
 
 	s := viiperTesting.NewTestServer(t)
@@ -212,3 +221,358 @@ func TestLEDs(t *testing.T) {
 		})
 	}
 }
+
+func TestEventMode(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("features", handler.Features())
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90108)
+	require.NoError(t, err)
+	defer b.Close()
+	require.NoError(t, s.UsbServer.AddBus(b))
+
+	client := apiclient.New(s.ApiServer.Addr())
+	dev, err := client.DeviceAdd(b.BusID(), "keyboard", nil)
+	require.NoError(t, err)
+	kb, err := client.OpenKeyboardStream(context.Background(), b.BusID(), dev.DevId)
+	require.NoError(t, err)
+	defer kb.Close()
+
+	usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	devs, err := usbipClient.ListDevices()
+	require.NoError(t, err)
+	require.Len(t, devs, 1)
+	imp, err := usbipClient.AttachDevice(devs[0].BusID)
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+
+	expect := func(t *testing.T, want keyboard.InputState) {
+		t.Helper()
+		report := want.BuildReport()
+		got, err := usbipClient.PollInputReport(imp.Conn, report, 750*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, report, got)
+	}
+
+	steps := []struct {
+		name  string
+		event func() error
+		want  keyboard.InputState
+	}{
+		{"shift down", func() error { return kb.KeyDown(keyboard.KeyLeftShift) }, keyboard.PressKeyWithMod(keyboard.ModLeftShift)},
+		{"a down", func() error { return kb.KeyDown(keyboard.KeyA) }, keyboard.PressKeyWithMod(keyboard.ModLeftShift, keyboard.KeyA)},
+		{"repeated a down", func() error { return kb.KeyDown(keyboard.KeyA) }, keyboard.PressKeyWithMod(keyboard.ModLeftShift, keyboard.KeyA)},
+		{"shift up", func() error { return kb.KeyUp(keyboard.KeyLeftShift) }, keyboard.PressKey(keyboard.KeyA)},
+		{"c down", func() error { return kb.KeyDown(keyboard.KeyC) }, keyboard.PressKey(keyboard.KeyA, keyboard.KeyC)},
+		{"a up", func() error { return kb.KeyUp(keyboard.KeyA) }, keyboard.PressKey(keyboard.KeyC)},
+		{"release all", kb.ReleaseAll, keyboard.Release()},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			require.NoError(t, step.event())
+			expect(t, step.want)
+		})
+	}
+
+	t.Run("held key cap", func(t *testing.T) {
+		var held []uint8
+		for key := uint8(keyboard.KeyA); key < keyboard.KeyA+keyboard.MaxHeldKeys+1; key++ {
+			require.NoError(t, kb.KeyDown(key))
+			if len(held) < keyboard.MaxHeldKeys {
+				held = append(held, key)
+			}
+		}
+		require.NoError(t, kb.KeyDown(keyboard.KeyRightAlt))
+		expect(t, keyboard.PressKeyWithMod(keyboard.ModRightAlt, held...))
+	})
+
+	t.Run("release all on disconnect", func(t *testing.T) {
+		require.NoError(t, kb.Close())
+		expect(t, keyboard.Release())
+	})
+}
+
+func TestTypeStringLayout(t *testing.T) {
+	press := keyboard.PressKeyWithMod
+	release := keyboard.Release()
+
+	assert.Equal(t, []keyboard.InputState{
+		press(keyboard.ModLeftShift, keyboard.KeyH), release,
+		press(0, keyboard.KeyI), release,
+		press(keyboard.ModLeftShift, keyboard.Key1), release,
+	}, keyboard.TypeString("Hi!"))
+	assert.Equal(t, []keyboard.InputState{{}, {}}, keyboard.TypeString("é")[:2], "non-ASCII bytes stay empty pairs")
+
+	states, err := keyboard.TypeStringLayout("yZ@ß\\é^", keyboard.LayoutDE)
+	require.NoError(t, err)
+	assert.Equal(t, []keyboard.InputState{
+		press(0, keyboard.KeyZ), release,
+		press(keyboard.ModLeftShift, keyboard.KeyY), release,
+		press(keyboard.ModRightAlt, keyboard.KeyQ), release,
+		press(0, keyboard.KeyMinus), release,
+		press(keyboard.ModRightAlt, keyboard.KeyMinus), release,
+		press(0, keyboard.KeyEqual), release, press(0, keyboard.KeyE), release,
+		press(0, keyboard.KeyGrave), release, press(0, keyboard.KeySpace), release,
+	}, states)
+
+	_, err = keyboard.TypeStringLayout("aßäaé→", keyboard.LayoutUS)
+	var untypable *keyboard.UntypableError
+	require.ErrorAs(t, err, &untypable)
+	assert.Equal(t, "en-US", untypable.Layout)
+	assert.Equal(t, []rune{'ß', 'ä', 'é', '→'}, untypable.Runes)
+
+	alt := keyboard.LayoutUS
+	alt.Fallback = keyboard.AltCode
+	states, err = keyboard.TypeStringLayout("é", alt)
+	require.NoError(t, err)
+	hold := keyboard.InputState{Modifiers: keyboard.ModLeftAlt}
+	assert.Equal(t, []keyboard.InputState{
+		hold,
+		press(keyboard.ModLeftAlt, keyboard.KeyKp0), hold,
+		press(keyboard.ModLeftAlt, keyboard.KeyKp2), hold,
+		press(keyboard.ModLeftAlt, keyboard.KeyKp3), hold,
+		press(keyboard.ModLeftAlt, keyboard.KeyKp3), hold,
+		release,
+	}, states, "é is Alt+0233")
+
+	hex := keyboard.LayoutDE
+	hex.Fallback = keyboard.UnicodeHex
+	states, err = keyboard.TypeStringLayout("→", hex)
+	require.NoError(t, err)
+	assert.Equal(t, []keyboard.InputState{
+		press(keyboard.ModLeftCtrl|keyboard.ModLeftShift, keyboard.KeyU), release,
+		press(0, keyboard.Key2), release,
+		press(0, keyboard.Key1), release,
+		press(0, keyboard.Key9), release,
+		press(0, keyboard.Key2), release,
+		press(0, keyboard.KeySpace), release,
+	}, states)
+}
+
+func TestMediaKeys(t *testing.T) {
+	mediaKeys := &device.CreateOptions{DeviceSpecific: map[string]any{"mediaKeys": true}}
+	keysReport := func(s keyboard.InputState) []byte {
+		return append([]byte{keyboard.ReportIDKeys}, s.BuildReport()...)
+	}
+
+	t.Run("reports interleave in order of change", func(t *testing.T) {
+		kb, err := keyboard.New(mediaKeys)
+		require.NoError(t, err)
+		poll := func() []byte {
+			report := kb.HandleTransfer(1, usbip.DirIn, nil)
+			return report
+		}
+
+		kb.UpdateInputState(keyboard.PressKey(keyboard.KeyA))
+		kb.UpdateMediaState(keyboard.PressMedia(keyboard.MediaVolumeUp))
+		kb.UpdateInputState(keyboard.PressKey(keyboard.KeyB))
+		assert.Equal(t, keysReport(keyboard.PressKey(keyboard.KeyB)), poll(), "keys changed first")
+		assert.Equal(t, []byte{keyboard.ReportIDMedia, 0x01, 0x00}, poll())
+		assert.Equal(t, keysReport(keyboard.PressKey(keyboard.KeyB)), poll(), "key report while nothing changed")
+
+		kb.UpdateMediaState(keyboard.PressMedia(keyboard.MediaPlayPause, keyboard.MediaStop))
+		kb.UpdateInputState(keyboard.Release())
+		assert.Equal(t, []byte{keyboard.ReportIDMedia, 0x48, 0x00}, poll())
+		assert.Equal(t, keysReport(keyboard.Release()), poll())
+
+		kb.ResetInputState()
+		assert.Equal(t, keysReport(keyboard.Release()), poll())
+		assert.Equal(t, []byte{keyboard.ReportIDMedia, 0x00, 0x00}, poll())
+	})
+
+	t.Run("descriptor", func(t *testing.T) {
+		plain, err := keyboard.New(nil)
+		require.NoError(t, err)
+		kb, err := keyboard.New(mediaKeys)
+		require.NoError(t, err)
+		report := func(kb *keyboard.Keyboard) []byte {
+			b, err := kb.GetDescriptor().Interfaces[0].HID.Report.Bytes()
+			require.NoError(t, err)
+			return b
+		}
+		assert.NotContains(t, string(report(plain)), "\x85")
+		assert.Contains(t, string(report(kb)), "\x85\x01")
+		assert.Contains(t, string(report(kb)), "\x05\x0c\x09\x01\xa1\x01\x85\x02", "consumer control collection")
+		assert.Equal(t, map[string]any{"mediaKeys": true}, kb.GetDeviceSpecificArgs())
+		assert.Empty(t, plain.GetDeviceSpecificArgs())
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		s := viiperTesting.NewTestServer(t)
+		defer s.UsbServer.Close()
+		defer s.ApiServer.Close()
+
+		r := s.ApiServer.Router()
+		r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+		r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+		require.NoError(t, s.ApiServer.Start())
+
+		b, err := virtualbus.NewWithBusId(90153)
+		require.NoError(t, err)
+		defer b.Close()
+		require.NoError(t, s.UsbServer.AddBus(b))
+
+		client := apiclient.New(s.ApiServer.Addr())
+		stream, _, err := client.AddDeviceAndConnect(context.Background(), b.BusID(), "keyboard", mediaKeys)
+		require.NoError(t, err)
+		defer stream.Close()
+
+		usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+		imp, err := usbipClient.AttachDevice("90153-1")
+		require.NoError(t, err)
+		defer imp.Conn.Close()
+
+		held := keyboard.PressKey(keyboard.KeyA)
+		require.NoError(t, stream.WriteBinary(keyboard.KeysReport(held)))
+		got, err := usbipClient.PollInputReport(imp.Conn, keysReport(held), 750*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, keysReport(held), got)
+
+		require.NoError(t, stream.WriteBinary(keyboard.MediaReport(keyboard.PressMedia(keyboard.MediaMute))))
+		want := []byte{keyboard.ReportIDMedia, 0x04, 0x00}
+		got, err = usbipClient.PollInputReport(imp.Conn, want, 750*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+		got, err = usbipClient.ReadInputReport(imp.Conn)
+		require.NoError(t, err)
+		assert.Equal(t, keysReport(held), got, "media keys leave the key report alone")
+
+		// LED output reports carry the report ID as well.
+		require.NoError(t, usbipClient.Submit(imp.Conn, usbip.DirOut, 1, []byte{keyboard.ReportIDKeys, keyboard.LEDCapsLock}, nil))
+		var led [1]byte
+		require.NoError(t, stream.SetReadDeadline(time.Now().Add(750*time.Millisecond)))
+		_, err = io.ReadFull(stream, led[:])
+		require.NoError(t, err)
+		assert.Equal(t, byte(keyboard.LEDCapsLock), led[0])
+
+		assert.Error(t, stream.WriteBinary(keyboard.Report{ID: 0x03, State: &held}))
+	})
+}
+
+func TestBootProtocol(t *testing.T) {
+	const (
+		getIdle     = 0x02
+		getProtocol = 0x03
+		setIdle     = 0x0A
+		setProtocol = 0x0B
+	)
+	setBoot := func(t *testing.T, kb *keyboard.Keyboard, boot bool) {
+		t.Helper()
+		var proto uint16 = 1
+		if boot {
+			proto = 0
+		}
+		_, ok := kb.HandleControl(0x21, setProtocol, proto, 0, 0, nil)
+		require.True(t, ok)
+	}
+	poll := func(t *testing.T, kb *keyboard.Keyboard) []byte {
+		t.Helper()
+		report := kb.HandleTransfer(1, usbip.DirIn, nil)
+		return report
+	}
+
+	t.Run("protocol and idle requests", func(t *testing.T) {
+		kb, err := keyboard.New(nil)
+		require.NoError(t, err)
+		get := func() []byte {
+			resp, ok := kb.HandleControl(0xA1, getProtocol, 0, 0, 1, nil)
+			require.True(t, ok)
+			return resp
+		}
+		assert.Equal(t, []byte{1}, get(), "report protocol after attach")
+		setBoot(t, kb, true)
+		assert.Equal(t, []byte{0}, get())
+		assert.True(t, kb.BootProtocol().Boot())
+		setBoot(t, kb, false)
+		assert.Equal(t, []byte{1}, get())
+
+		_, ok := kb.HandleControl(0x21, setProtocol, 2, 0, 0, nil)
+		assert.False(t, ok, "unknown protocol is left to stall")
+		_, ok = kb.HandleControl(0x21, setProtocol, 0, 1, 0, nil)
+		assert.False(t, ok, "other interface")
+
+		_, ok = kb.HandleControl(0x21, setIdle, 0x7D00, 0, 0, nil)
+		require.True(t, ok)
+		resp, ok := kb.HandleControl(0xA1, getIdle, 0, 0, 1, nil)
+		require.True(t, ok)
+		assert.Equal(t, []byte{0x7D}, resp)
+
+		setBoot(t, kb, true)
+		kb.BusReset()
+		assert.Equal(t, []byte{1}, get(), "bus reset returns to report protocol")
+		assert.Zero(t, kb.BootProtocol().Idle())
+	})
+
+	t.Run("report shapes", func(t *testing.T) {
+		kb, err := keyboard.New(nil)
+		require.NoError(t, err)
+		kb.UpdateInputState(keyboard.InputState{Modifiers: keyboard.ModLeftShift, KeyBitmap: keyboard.PressKey(keyboard.KeyB, keyboard.KeyA).KeyBitmap})
+		assert.Len(t, poll(t, kb), 34)
+
+		setBoot(t, kb, true)
+		assert.Equal(t, []byte{keyboard.ModLeftShift, 0, keyboard.KeyA, keyboard.KeyB, 0, 0, 0, 0}, poll(t, kb))
+
+		kb.UpdateInputState(keyboard.PressKey(keyboard.KeyA, keyboard.KeyB, keyboard.KeyC, keyboard.KeyD, keyboard.KeyE, keyboard.KeyF, keyboard.KeyG))
+		assert.Equal(t, []byte{0, 0, 1, 1, 1, 1, 1, 1}, poll(t, kb), "rollover error")
+
+		resp, ok := kb.HandleControl(0xA1, 0x01, 0x0100, 0, 8, nil)
+		require.True(t, ok)
+		assert.Len(t, resp, 8, "GET_REPORT follows the protocol")
+
+		kb.HandleTransfer(1, usbip.DirOut, []byte{keyboard.LEDNumLock})
+		assert.True(t, kb.GetLEDState().NumLock)
+
+		setBoot(t, kb, false)
+		assert.Len(t, poll(t, kb), 34)
+	})
+
+	t.Run("media keyboard", func(t *testing.T) {
+		kb, err := keyboard.New(&device.CreateOptions{DeviceSpecific: map[string]any{"mediaKeys": true}})
+		require.NoError(t, err)
+		setBoot(t, kb, true)
+		kb.UpdateMediaState(keyboard.PressMedia(keyboard.MediaMute))
+		kb.UpdateInputState(keyboard.PressKey(keyboard.KeyA))
+		assert.Equal(t, []byte{0, 0, keyboard.KeyA, 0, 0, 0, 0, 0}, poll(t, kb), "no report ID and no media report")
+		assert.Equal(t, []byte{0, 0, keyboard.KeyA, 0, 0, 0, 0, 0}, poll(t, kb))
+
+		_, ok := kb.HandleControl(0x21, 0x09, 0x0200, 0, 1, []byte{keyboard.LEDCapsLock})
+		require.True(t, ok)
+		assert.True(t, kb.GetLEDState().CapsLock, "boot LED report has no report ID")
+	})
+}
+
+func TestHeldInputReports(t *testing.T) {
+	k, err := keyboard.New(&device.CreateOptions{DeviceSpecific: map[string]any{"mediaKeys": true}})
+	require.NoError(t, err)
+	var _ usb.PendingDevice = k
+
+	read := func() []byte {
+		t.Helper()
+		r, err := k.HandleTransferStatus(1, usbip.DirIn, nil)
+		require.NoError(t, err)
+		return r
+	}
+	assert.Equal(t, byte(keyboard.ReportIDKeys), read()[0], "the first report is not held")
+	_, err = k.HandleTransferStatus(1, usbip.DirIn, nil)
+	assert.ErrorIs(t, err, usb.ErrPending, "unchanged input is held")
+
+	wake := k.InputNotifier().Wait()
+	k.UpdateInputState(keyboard.PressKey(keyboard.KeyA))
+	k.UpdateMediaState(keyboard.MediaState{Keys: keyboard.MediaMute})
+	select {
+	case <-wake:
+	default:
+		t.Error("UpdateInputState does not wake held polls")
+	}
+	assert.Equal(t, byte(keyboard.ReportIDKeys), read()[0])
+	assert.Equal(t, byte(keyboard.ReportIDMedia), read()[0], "every changed report is read before polls are held")
+	_, err = k.HandleTransferStatus(1, usbip.DirIn, nil)
+	assert.ErrorIs(t, err, usb.ErrPending)
+}
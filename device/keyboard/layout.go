@@ -0,0 +1,209 @@
+package keyboard
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// KeyStroke is one key press with its modifiers, released before the next
+// stroke.
+type KeyStroke struct {
+	Key       uint8
+	Modifiers uint8
+}
+
+// Fallback types a rune layout l has no keys for, reporting false if it
+// cannot. AltCode and UnicodeHex are the built-in ones.
+type Fallback func(l Layout, r rune) ([]InputState, bool)
+
+// Layout maps runes to the key strokes typing them on a host keyboard
+// layout. Characters behind dead keys take several strokes. Without a
+// Fallback, untypable runes are an error.
+type Layout struct {
+	Name     string
+	Chars    map[rune][]KeyStroke
+	Fallback Fallback
+}
+
+// LayoutUS is the US QWERTY layout, as used by TypeString.
+var LayoutUS = Layout{Name: "en-US", Chars: usChars()}
+
+// LayoutDE is the German QWERTZ layout (T1), including AltGr characters and
+// accented vowels typed through its dead keys.
+var LayoutDE = Layout{Name: "de-DE", Chars: deChars()}
+
+// UntypableError lists the runes a layout has no keys for.
+type UntypableError struct {
+	Layout string
+	Runes  []rune
+}
+
+func (e *UntypableError) Error() string {
+	quoted := make([]string, len(e.Runes))
+	for i, r := range e.Runes {
+		quoted[i] = strconv.QuoteRune(r)
+	}
+	return fmt.Sprintf("keyboard: layout %s cannot type %s", e.Layout, strings.Join(quoted, ", "))
+}
+
+// TypeStringLayout converts a string into press/release pairs for a host
+// using layout l. Runes the layout cannot type go to l.Fallback; those left
+// over are listed by the returned *UntypableError.
+//
+// Example:
+//
+//	states, err := TypeStringLayout("Grüße", LayoutDE)
+func TypeStringLayout(s string, l Layout) ([]InputState, error) {
+	var states []InputState
+	var missing []rune
+	for _, r := range s {
+		if strokes, ok := l.Chars[r]; ok {
+			states = appendStrokes(states, strokes)
+			continue
+		}
+		if l.Fallback != nil {
+			if fb, ok := l.Fallback(l, r); ok {
+				states = append(states, fb...)
+				continue
+			}
+		}
+		if !slices.Contains(missing, r) {
+			missing = append(missing, r)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &UntypableError{Layout: l.Name, Runes: missing}
+	}
+	return states, nil
+}
+
+func appendStrokes(states []InputState, strokes []KeyStroke) []InputState {
+	for _, k := range strokes {
+		states = append(states, PressKeyWithMod(k.Modifiers, k.Key), Release())
+	}
+	return states
+}
+
+var keypadDigits = [10]uint8{KeyKp0, KeyKp1, KeyKp2, KeyKp3, KeyKp4, KeyKp5, KeyKp6, KeyKp7, KeyKp8, KeyKp9}
+
+// AltCode holds Alt and types the decimal code point on the keypad, as
+// Windows Alt codes. NumLock must be on; code points above 0xFF only come
+// out right in applications accepting Unicode Alt codes.
+func AltCode(_ Layout, r rune) ([]InputState, bool) {
+	digits := strconv.Itoa(int(r))
+	if r <= 0xff {
+		// A leading zero selects the Windows-1252 code page instead of
+		// the OEM one.
+		digits = "0" + digits
+	}
+	hold := InputState{Modifiers: ModLeftAlt}
+	states := []InputState{hold}
+	for _, d := range digits {
+		states = append(states, PressKeyWithMod(ModLeftAlt, keypadDigits[d-'0']), hold)
+	}
+	return append(states, Release()), true
+}
+
+// UnicodeHex types Ctrl+Shift+U, the hex code point and Space, as
+// understood by GTK and IBus on Linux.
+func UnicodeHex(l Layout, r rune) ([]InputState, bool) {
+	states := []InputState{PressKeyWithMod(ModLeftCtrl|ModLeftShift, KeyU), Release()}
+	for _, d := range strconv.FormatInt(int64(r), 16) + " " {
+		strokes, ok := l.Chars[d]
+		if !ok {
+			return nil, false
+		}
+		states = appendStrokes(states, strokes)
+	}
+	return states, true
+}
+
+func usChars() map[rune][]KeyStroke {
+	chars := make(map[rune][]KeyStroke, len(CharToKey))
+	for c, key := range CharToKey {
+		var mod uint8
+		if ShiftChars[c] {
+			mod = ModLeftShift
+		}
+		chars[rune(c)] = []KeyStroke{{Key: key, Modifiers: mod}}
+	}
+	return chars
+}
+
+func deChars() map[rune][]KeyStroke {
+	chars := map[rune][]KeyStroke{}
+	set := func(key uint8, plain, shifted, altGr rune) {
+		if plain != 0 {
+			chars[plain] = []KeyStroke{{Key: key}}
+		}
+		if shifted != 0 {
+			chars[shifted] = []KeyStroke{{Key: key, Modifiers: ModLeftShift}}
+		}
+		if altGr != 0 {
+			chars[altGr] = []KeyStroke{{Key: key, Modifiers: ModRightAlt}}
+		}
+	}
+
+	for c := 'a'; c <= 'z'; c++ {
+		key := KeyA + uint8(c-'a')
+		switch c {
+		case 'y':
+			key = KeyZ
+		case 'z':
+			key = KeyY
+		}
+		set(key, c, c-'a'+'A', 0)
+	}
+	set(KeyQ, 0, 0, '@')
+	set(KeyE, 0, 0, '€')
+	set(KeyM, 0, 0, 'µ')
+
+	set(Key1, '1', '!', 0)
+	set(Key2, '2', '"', '²')
+	set(Key3, '3', '§', '³')
+	set(Key4, '4', '$', 0)
+	set(Key5, '5', '%', 0)
+	set(Key6, '6', '&', 0)
+	set(Key7, '7', '/', '{')
+	set(Key8, '8', '(', '[')
+	set(Key9, '9', ')', ']')
+	set(Key0, '0', '=', '}')
+	set(KeyMinus, 'ß', '?', '\\')
+	set(KeyLeftBrace, 'ü', 'Ü', 0)
+	set(KeyRightBrace, '+', '*', '~')
+	set(KeySemicolon, 'ö', 'Ö', 0)
+	set(KeyApostrophe, 'ä', 'Ä', 0)
+	set(KeyNonUSHash, '#', '\'', 0)
+	set(KeyGrave, 0, '°', 0)
+	set(KeyNonUSBackslash, '<', '>', '|')
+	set(KeyComma, ',', ';', 0)
+	set(KeyPeriod, '.', ':', 0)
+	set(KeySlash, '-', '_', 0)
+	set(KeySpace, ' ', 0, 0)
+	set(KeyEnter, '\n', 0, 0)
+	chars['\r'] = chars['\n']
+	set(KeyTab, '\t', 0, 0)
+
+	// Dead keys: followed by Space they type themselves, followed by a
+	// vowel the accented vowel.
+	deadKeys := []struct {
+		dead   KeyStroke
+		char   rune
+		vowels string
+		result string
+	}{
+		{KeyStroke{Key: KeyGrave}, '^', "aeiouAEIOU", "âêîôûÂÊÎÔÛ"},
+		{KeyStroke{Key: KeyEqual}, '´', "aeiouyAEIOUY", "áéíóúýÁÉÍÓÚÝ"},
+		{KeyStroke{Key: KeyEqual, Modifiers: ModLeftShift}, '`', "aeiouAEIOU", "àèìòùÀÈÌÒÙ"},
+	}
+	for _, d := range deadKeys {
+		chars[d.char] = []KeyStroke{d.dead, {Key: KeySpace}}
+		result := []rune(d.result)
+		for i, v := range []rune(d.vowels) {
+			chars[result[i]] = append([]KeyStroke{d.dead}, chars[v]...)
+		}
+	}
+	return chars
+}
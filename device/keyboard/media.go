@@ -0,0 +1,128 @@
+package keyboard
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/Alia5/VIIPER/usb/hid"
+)
+
+// MediaState is the set of held media keys of a keyboard created with media
+// keys, sent to the host as its consumer control report.
+// viiper:wire keyboardmedia c2s keys:u16
+type MediaState struct {
+	Keys uint16 // Media* bitmask
+}
+
+// mediaUsages are the Consumer page usages of the MediaState bits, LSB first.
+var mediaUsages = []uint16{
+	0xE9, // Volume Increment
+	0xEA, // Volume Decrement
+	0xE2, // Mute
+	0xCD, // Play/Pause
+	0xB5, // Scan Next Track
+	0xB6, // Scan Previous Track
+	0xB7, // Stop
+}
+
+// MarshalBinary encodes MediaState to 2 bytes (little-endian bitmask).
+func (m *MediaState) MarshalBinary() ([]byte, error) {
+	return binary.LittleEndian.AppendUint16(nil, m.Keys), nil
+}
+
+// UnmarshalBinary decodes 2 bytes into MediaState.
+func (m *MediaState) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 {
+		return io.ErrUnexpectedEOF
+	}
+	m.Keys = binary.LittleEndian.Uint16(data)
+	return nil
+}
+
+// BuildReport encodes a MediaState into the consumer control report, report
+// ID included.
+//
+// Report layout (3 bytes):
+//
+//	Byte 0: ReportIDMedia
+//	Bytes 1-2: Media key bits (little-endian, unknown bits cleared)
+func (m *MediaState) BuildReport() []byte {
+	keys := m.Keys & (1<<len(mediaUsages) - 1)
+	return []byte{ReportIDMedia, uint8(keys), uint8(keys >> 8)}
+}
+
+// PressMedia creates a MediaState with the given media keys held.
+//
+// Example:
+//
+//	state := PressMedia(MediaVolumeUp)
+func PressMedia(keys ...uint16) MediaState {
+	var m MediaState
+	for _, k := range keys {
+		m.Keys |= k
+	}
+	return m
+}
+
+// ReleaseMedia creates an empty MediaState with all media keys released.
+func ReleaseMedia() MediaState {
+	return MediaState{}
+}
+
+// Report is one packet of the stream of a keyboard created with media keys:
+// a state prefixed with the report ID selecting its kind.
+type Report struct {
+	ID    uint8 // ReportIDKeys or ReportIDMedia
+	State encoding.BinaryMarshaler
+}
+
+// KeysReport wraps a key state for a keyboard created with media keys.
+func KeysReport(s InputState) Report {
+	return Report{ID: ReportIDKeys, State: &s}
+}
+
+// MediaReport wraps a media key state.
+func MediaReport(m MediaState) Report {
+	return Report{ID: ReportIDMedia, State: &m}
+}
+
+// MarshalBinary encodes the report ID followed by the wire format of the state.
+func (r Report) MarshalBinary() ([]byte, error) {
+	if r.ID != ReportIDKeys && r.ID != ReportIDMedia {
+		return nil, fmt.Errorf("unknown report ID 0x%02x", r.ID)
+	}
+	b, err := r.State.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{r.ID}, b...), nil
+}
+
+// mediaReportItems describes the consumer control report as a second
+// application collection.
+func mediaReportItems() []hid.Item {
+	items := []hid.Item{reportID(ReportIDMedia)}
+	for _, u := range mediaUsages {
+		items = append(items, hid.Usage{Usage: u})
+	}
+	items = append(items,
+		hid.LogicalMinimum{Min: 0},
+		hid.LogicalMaximum{Max: 1},
+		hid.ReportSize{Bits: 1},
+		hid.ReportCount{Count: uint16(len(mediaUsages))},
+		hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+		hid.ReportCount{Count: uint16(16 - len(mediaUsages))},
+		hid.Input{Flags: hid.MainConst},
+	)
+	return []hid.Item{
+		hid.UsagePage{Page: hid.UsagePageConsumer},
+		hid.Usage{Usage: 0x01}, // Consumer Control
+		hid.Collection{Kind: hid.CollectionApplication, Items: items},
+	}
+}
+
+func reportID(id uint8) hid.Item {
+	return hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x8, Data: hid.Data{id}}
+}
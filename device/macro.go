@@ -0,0 +1,98 @@
+package device
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MacroStep is one step of a Macro: Apply sets an input state, which is held
+// for Hold before the next step.
+type MacroStep struct {
+	Apply func()
+	Hold  time.Duration
+}
+
+// Macro plays a sequence of input states against a device. Every step is
+// due at a fixed offset from the start, so a late timer shortens the
+// following hold instead of delaying the rest of the macro.
+type Macro struct {
+	steps  []MacroStep
+	repeat int
+	clock  Clock
+
+	mu      sync.Mutex
+	ctx     context.Context
+	start   time.Time
+	next    int           // steps applied so far, across repeats
+	due     time.Duration // offset of step next from start
+	stopped bool
+	done    chan struct{}
+}
+
+// NewMacro returns a macro playing steps repeat times; repeat < 1 plays
+// them once.
+func NewMacro(steps []MacroStep, repeat int) *Macro {
+	return &Macro{steps: steps, repeat: max(repeat, 1), clock: SystemClock, done: make(chan struct{})}
+}
+
+// SetClock replaces the wall clock, e.g. with a fake one in tests.
+// It must be called before Start.
+func (m *Macro) SetClock(c Clock) { m.clock = c }
+
+// Duration returns the playback time of the whole macro.
+func (m *Macro) Duration() time.Duration {
+	var d time.Duration
+	for _, s := range m.steps {
+		d += s.Hold
+	}
+	return d * time.Duration(m.repeat)
+}
+
+// Start applies the first step and schedules the others. Playback stops
+// early once ctx ends or Cancel is called; no step is applied after that.
+func (m *Macro) Start(ctx context.Context) {
+	m.mu.Lock()
+	m.ctx = ctx
+	m.start = m.clock.Now()
+	m.mu.Unlock()
+	context.AfterFunc(ctx, func() { m.Cancel() })
+	m.step()
+}
+
+// Cancel stops playback and reports whether the macro was still playing.
+// The last applied state stays.
+func (m *Macro) Cancel() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return false
+	}
+	m.stopLocked()
+	return true
+}
+
+// Done is closed once the macro ended or was cancelled.
+func (m *Macro) Done() <-chan struct{} { return m.done }
+
+func (m *Macro) step() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return
+	}
+	if m.ctx.Err() != nil || m.next == len(m.steps)*m.repeat {
+		m.stopLocked()
+		return
+	}
+	s := m.steps[m.next%len(m.steps)]
+	m.next++
+	m.due += s.Hold
+	s.Apply()
+	m.clock.AfterFunc(m.start.Add(m.due).Sub(m.clock.Now()), m.step)
+}
+
+func (m *Macro) stopLocked() {
+	m.stopped = true
+	close(m.done)
+}
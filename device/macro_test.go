@@ -0,0 +1,89 @@
+package device_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Alia5/VIIPER/device"
+)
+
+// lateClock fires every timer late by lag, like a loaded scheduler.
+type lateClock struct {
+	*fakeClock
+	lag time.Duration
+}
+
+func (c lateClock) AfterFunc(d time.Duration, f func()) { c.fakeClock.AfterFunc(d+c.lag, f) }
+
+// macroSteps returns n steps of hold each that log when they are applied.
+func macroSteps(clock device.Clock, n int, hold time.Duration, applied *[]time.Duration) []device.MacroStep {
+	start := clock.Now()
+	steps := make([]device.MacroStep, n)
+	for i := range steps {
+		steps[i] = device.MacroStep{
+			Apply: func() { *applied = append(*applied, clock.Now().Sub(start)) },
+			Hold:  hold,
+		}
+	}
+	return steps
+}
+
+func TestMacroTiming(t *testing.T) {
+	const (
+		hold = 16 * time.Millisecond
+		lag  = 3 * time.Millisecond
+	)
+	clock := newFakeClock()
+	var applied []time.Duration
+	m := device.NewMacro(macroSteps(clock, 4, hold, &applied), 25)
+	m.SetClock(lateClock{clock, lag})
+	assert.Equal(t, 100*hold, m.Duration())
+
+	m.Start(context.Background())
+	clock.Advance(m.Duration() + lag)
+
+	select {
+	case <-m.Done():
+	default:
+		t.Fatal("macro still playing")
+	}
+	assert.Len(t, applied, 100)
+	// Late timers must not accumulate: every step is within one lag of its
+	// offset, even the last one.
+	for i, at := range applied {
+		want := time.Duration(i) * hold
+		assert.InDelta(t, want, at, float64(lag), "step %d", i)
+	}
+}
+
+func TestMacroCancel(t *testing.T) {
+	clock := newFakeClock()
+	var applied []time.Duration
+	m := device.NewMacro(macroSteps(clock, 3, 10*time.Millisecond, &applied), 1)
+	m.SetClock(clock)
+
+	m.Start(context.Background())
+	clock.Advance(15 * time.Millisecond)
+	assert.True(t, m.Cancel())
+	assert.False(t, m.Cancel())
+	clock.Advance(time.Second)
+	assert.Equal(t, []time.Duration{0, 10 * time.Millisecond}, applied)
+}
+
+func TestMacroContext(t *testing.T) {
+	clock := newFakeClock()
+	var applied []time.Duration
+	m := device.NewMacro(macroSteps(clock, 3, 10*time.Millisecond, &applied), 1)
+	m.SetClock(clock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.Start(ctx)
+	cancel()
+	clock.Advance(time.Second)
+	assert.Equal(t, []time.Duration{0}, applied)
+	<-m.Done()
+	assert.False(t, m.Cancel())
+}
@@ -0,0 +1,165 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// StreamPolicy is how a device takes input from several streams.
+type StreamPolicy string
+
+const (
+	// StreamPolicySingle has the newest stream take over the feedback of
+	// the device; every stream sets the whole input state.
+	StreamPolicySingle StreamPolicy = "single"
+	// StreamPolicyMixed merges the input of several streams, each of which
+	// claims a disjoint set of wire fields, see Mixer.
+	StreamPolicyMixed StreamPolicy = "mixed"
+)
+
+// ParseStreamPolicy returns the policy named s; the empty string is
+// StreamPolicySingle.
+func ParseStreamPolicy(s string) (StreamPolicy, error) {
+	switch p := StreamPolicy(s); p {
+	case "":
+		return StreamPolicySingle, nil
+	case StreamPolicySingle, StreamPolicyMixed:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown stream policy %q", s)
+}
+
+// ErrFieldClaimed is returned by Mixer.Claim for a field another source owns.
+var ErrFieldClaimed = errors.New("field already claimed")
+
+// MixClaim is the set of wire fields a source owns, in layout order.
+type MixClaim struct {
+	Source string
+	Fields []string
+}
+
+// Mixer merges the full wire states of several sources into one latched
+// state. Every source owns the fields it claimed and only those are taken
+// from its states. Fields nobody owns, and those of a released source, are
+// neutral: the all-zero wire value delta streams start from.
+type Mixer struct {
+	layout WireLayout
+
+	mu     sync.Mutex
+	state  []byte
+	owners []string // per field, "" if unclaimed
+}
+
+// NewMixer returns a mixer for states of layout.
+func NewMixer(layout WireLayout) *Mixer {
+	return &Mixer{
+		layout: layout,
+		state:  make([]byte, layout.Size()),
+		owners: make([]string, len(layout)),
+	}
+}
+
+// Layout returns the wire layout of the mixed states.
+func (m *Mixer) Layout() WireLayout { return m.layout }
+
+// Claim gives source the named fields. Fields of a coupled group must be
+// claimed together. A source claims once; nothing is claimed on error.
+func (m *Mixer) Claim(source string, fields []string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("no fields claimed")
+	}
+	mask := make([]byte, m.layout.MaskSize())
+	for _, name := range fields {
+		i := m.layout.index(name)
+		if i < 0 {
+			return fmt.Errorf("unknown wire field %q", name)
+		}
+		setMaskBit(mask, i)
+	}
+	if err := m.layout.checkGroups(mask); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if slices.Contains(m.owners, source) {
+		return fmt.Errorf("source %s already claimed fields", source)
+	}
+	for i, f := range m.layout {
+		if maskBit(mask, i) && m.owners[i] != "" {
+			return fmt.Errorf("%w: %s is owned by %s", ErrFieldClaimed, f.Name, m.owners[i])
+		}
+	}
+	for i := range m.layout {
+		if maskBit(mask, i) {
+			m.owners[i] = source
+		}
+	}
+	return nil
+}
+
+// Merge takes the fields source owns from the full state and returns the
+// merged state. Relative fields count once: they read as zero in the states
+// merged after.
+func (m *Mixer) Merge(source string, state []byte) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	off := 0
+	for i, f := range m.layout {
+		if m.owners[i] == source {
+			copy(m.state[off:off+f.Size], state[off:off+f.Size])
+		}
+		off += f.Size
+	}
+	out := slices.Clone(m.state)
+	m.clearRelative()
+	return out
+}
+
+// Release drops the claim of source and returns the merged state with its
+// fields back to neutral.
+func (m *Mixer) Release(source string) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	off := 0
+	for i, f := range m.layout {
+		if m.owners[i] == source {
+			m.owners[i] = ""
+			clear(m.state[off : off+f.Size])
+		}
+		off += f.Size
+	}
+	return slices.Clone(m.state)
+}
+
+// Claims returns the current claims, ordered by their first field.
+func (m *Mixer) Claims() []MixClaim {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []MixClaim
+	for i, owner := range m.owners {
+		if owner == "" {
+			continue
+		}
+		j := slices.IndexFunc(out, func(c MixClaim) bool { return c.Source == owner })
+		if j < 0 {
+			out = append(out, MixClaim{Source: owner})
+			j = len(out) - 1
+		}
+		out[j].Fields = append(out[j].Fields, m.layout[i].Name)
+	}
+	return out
+}
+
+// clearRelative zeroes the relative fields of the latched state; m.mu is held.
+func (m *Mixer) clearRelative() {
+	off := 0
+	for _, f := range m.layout {
+		if f.Relative {
+			clear(m.state[off : off+f.Size])
+		}
+		off += f.Size
+	}
+}
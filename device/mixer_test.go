@@ -0,0 +1,55 @@
+package device_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/device"
+)
+
+var mixLayout = device.WireLayout{
+	{Name: "buttons", Size: 2},
+	{Name: "x", Size: 1},
+	{Name: "dx", Size: 1, Relative: true},
+	{Name: "touchX", Size: 1, Group: "touch"},
+	{Name: "touchActive", Size: 1, Group: "touch"},
+}
+
+func TestMixer(t *testing.T) {
+	m := device.NewMixer(mixLayout)
+	require.NoError(t, m.Claim("a", []string{"buttons", "dx"}))
+	require.NoError(t, m.Claim("b", []string{"x", "touchX", "touchActive"}))
+
+	assert.Equal(t, []byte{1, 2, 0, 3, 0, 0}, m.Merge("a", []byte{1, 2, 9, 3, 9, 9}), "only owned fields are taken")
+	assert.Equal(t, []byte{1, 2, 4, 0, 5, 1}, m.Merge("b", []byte{9, 9, 4, 9, 5, 1}), "relative fields count once")
+	assert.Equal(t, []device.MixClaim{
+		{Source: "a", Fields: []string{"buttons", "dx"}},
+		{Source: "b", Fields: []string{"x", "touchX", "touchActive"}},
+	}, m.Claims())
+
+	assert.Equal(t, []byte{0, 0, 4, 0, 5, 1}, m.Release("a"))
+	assert.Equal(t, []device.MixClaim{{Source: "b", Fields: []string{"x", "touchX", "touchActive"}}}, m.Claims())
+	require.NoError(t, m.Claim("c", []string{"buttons"}), "released fields can be claimed again")
+}
+
+func TestMixerClaimRejected(t *testing.T) {
+	m := device.NewMixer(mixLayout)
+	require.NoError(t, m.Claim("a", []string{"buttons"}))
+
+	err := m.Claim("b", []string{"x", "buttons"})
+	assert.ErrorIs(t, err, device.ErrFieldClaimed)
+	assert.EqualError(t, err, "field already claimed: buttons is owned by a")
+	assert.ErrorContains(t, m.Claim("b", []string{"touchX"}), "partial field group")
+	assert.EqualError(t, m.Claim("b", []string{"y"}), `unknown wire field "y"`)
+	assert.EqualError(t, m.Claim("b", nil), "no fields claimed")
+	assert.EqualError(t, m.Claim("a", []string{"x"}), "source a already claimed fields")
+	assert.Len(t, m.Claims(), 1, "nothing claimed on error")
+
+	_, err = device.ParseStreamPolicy("shared")
+	assert.EqualError(t, err, `unknown stream policy "shared"`)
+	p, err := device.ParseStreamPolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, device.StreamPolicySingle, p)
+}
@@ -2,6 +2,10 @@
 package mouse
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
 	"sync"
 	"sync/atomic"
 
@@ -18,162 +22,360 @@ type Mouse struct {
 	inputState *InputState
 	stateMu    sync.Mutex
 	descriptor usb.Descriptor
+	humanize   device.Humanizer
+	step       device.Stepper
+	protocol   device.BootProtocol
+
+	// With hi-res scrolling the host may set the resolution multiplier of
+	// each wheel; until it does, hi-res movement is reported in notches
+	// and the fractions carry over.
+	hiRes      bool
+	multiplier uint8 // feature report, multiplier* bits
+	wheelRem   int
+	panRem     int
+
+	inputChanged bool // since the last input report
+	notify       usb.Notifier
+}
+
+type MouseCreateOptions struct {
+	// HiResScroll adds resolution multipliers for both wheels. The stream
+	// then expects HiResState packets.
+	HiResScroll *bool `json:"hiResScroll"`
 }
 
 // New returns a new Mouse device.
 func New(o *device.CreateOptions) (*Mouse, error) {
-	d := &Mouse{
-		descriptor: defaultDescriptor,
+	d := &Mouse{inputChanged: true}
+	if o != nil && o.DeviceSpecific != nil {
+		data, err := json.Marshal(o.DeviceSpecific)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON payload: %w", err)
+		}
+		var args MouseCreateOptions
+		if err := json.Unmarshal(data, &args); err != nil {
+			return nil, fmt.Errorf("invalid JSON payload: %w", err)
+		}
+		d.hiRes = args.HiResScroll != nil && *args.HiResScroll
 	}
+	d.descriptor = newDescriptor(d.hiRes)
 	if o != nil {
-		if o.IdVendor != nil {
-			d.descriptor.Device.IDVendor = *o.IdVendor
+		if err := o.ApplyIdentity(&d.descriptor); err != nil {
+			return nil, err
+		}
+		if o.Humanize != nil {
+			if err := d.humanize.Configure(*o.Humanize); err != nil {
+				return nil, err
+			}
 		}
-		if o.IdProduct != nil {
-			d.descriptor.Device.IDProduct = *o.IdProduct
+		if o.Deterministic != nil {
+			d.step.Configure(*o.Deterministic)
 		}
 	}
 	return d, nil
 }
 
+// HiResScroll reports whether the mouse was created with hi-res scrolling.
+func (m *Mouse) HiResScroll() bool {
+	return m.hiRes
+}
+
+// BootProtocol returns the protocol selected by the host. In boot protocol
+// the mouse sends the 3-byte boot report without wheels.
+func (m *Mouse) BootProtocol() *device.BootProtocol {
+	return &m.protocol
+}
+
+// BusReset returns to the report protocol and notch scrolling for a newly
+// attached host.
+func (m *Mouse) BusReset() {
+	m.protocol.Reset()
+	m.stateMu.Lock()
+	m.multiplier = 0
+	m.wheelRem, m.panRem = 0, 0
+	m.stateMu.Unlock()
+}
+
+// Humanizer returns the easing applied to streamed movements.
+func (m *Mouse) Humanizer() *device.Humanizer {
+	return &m.humanize
+}
+
+// Stepper returns the queue of streamed states in deterministic mode.
+func (m *Mouse) Stepper() *device.Stepper {
+	return &m.step
+}
+
 // UpdateInputState updates the device's current input state (thread-safe).
 func (m *Mouse) UpdateInputState(state InputState) {
 	m.stateMu.Lock()
 	defer m.stateMu.Unlock()
 	m.inputState = &state
+	m.inputChanged = true
+	m.notify.Notify()
+}
+
+// ResetInputState releases all buttons and drops pending motion.
+func (m *Mouse) ResetInputState() {
+	m.UpdateInputState(InputState{})
+}
+
+// addMotion adds one step of a humanized movement to the pending deltas, so
+// steps arriving between two polls are not lost. The last step also applies
+// the buttons and wheels of st.
+func (m *Mouse) addMotion(dx, dy int, st InputState, last bool) {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	if m.inputState == nil {
+		m.inputState = &InputState{}
+	}
+	cur := m.inputState
+	cur.DX = addClamped(cur.DX, dx)
+	cur.DY = addClamped(cur.DY, dy)
+	if last {
+		cur.Buttons = st.Buttons
+		cur.Wheel = addClamped(cur.Wheel, int(st.Wheel))
+		cur.Pan = addClamped(cur.Pan, int(st.Pan))
+		cur.WheelHiRes = addClamped(cur.WheelHiRes, int(st.WheelHiRes))
+		cur.PanHiRes = addClamped(cur.PanHiRes, int(st.PanHiRes))
+	}
+	m.inputChanged = true
+	m.notify.Notify()
+}
+
+func addClamped(v int16, d int) int16 {
+	return int16(min(max(int(v)+d, math.MinInt16), math.MaxInt16))
 }
 
 // HandleTransfer implements interrupt IN for Mouse.
 func (m *Mouse) HandleTransfer(ep uint32, dir uint32, out []byte) []byte {
-	if dir == usbip.DirIn {
-		switch ep {
-		case 1: // 0x81 - main input reports
-			atomic.AddUint64(&m.tick, 1)
-
-			m.stateMu.Lock()
-			var st InputState
-			if m.inputState != nil {
-				// Snapshot current state
-				st = *m.inputState
-				// Consume relative deltas so they are one-shot per poll cycle.
-				// Buttons persist until explicitly changed by the client.
-				m.inputState.DX = 0
-				m.inputState.DY = 0
-				m.inputState.Wheel = 0
-				m.inputState.Pan = 0
-			}
-			m.stateMu.Unlock()
-			return st.BuildReport()
-		default:
-			return nil
+	if ep != 1 || dir != usbip.DirIn {
+		return nil
+	}
+	// 0x81 - main input reports
+	atomic.AddUint64(&m.tick, 1)
+
+	boot := m.protocol.Boot()
+	m.stateMu.Lock()
+	var st InputState
+	m.inputChanged = false
+	if m.inputState != nil {
+		// Snapshot current state
+		st = *m.inputState
+		// Consume relative deltas so they are one-shot per poll cycle.
+		// Buttons persist until explicitly changed by the client.
+		m.inputState.DX = 0
+		m.inputState.DY = 0
+		m.inputState.Wheel = 0
+		m.inputState.Pan = 0
+		m.inputState.WheelHiRes = 0
+		m.inputState.PanHiRes = 0
+		if boot {
+			// The boot report moves at most 127 per poll; the rest is
+			// left for the next ones.
+			m.inputState.DX = st.DX - int16(clampInt8(st.DX))
+			m.inputState.DY = st.DY - int16(clampInt8(st.DY))
+			m.inputChanged = m.inputState.DX != 0 || m.inputState.DY != 0
 		}
 	}
-	return nil
+	if !boot {
+		st.Wheel = scroll(st.Wheel, st.WheelHiRes, m.multiplier&multiplierWheel != 0, &m.wheelRem)
+		st.Pan = scroll(st.Pan, st.PanHiRes, m.multiplier&multiplierPan != 0, &m.panRem)
+	}
+	m.stateMu.Unlock()
+	if boot {
+		return st.BuildBootReport()
+	}
+	return st.BuildReport()
 }
 
-// HID Report Descriptor for a 5-button mouse with vertical and horizontal wheels.
-// Boot protocol compatible.
-var reportDescriptor = hid.Report{
-	Items: []hid.Item{
+// HandleTransferStatus holds polls of 0x81 while there is neither new input
+// nor motion left over from the last report, see usb.ErrPending, and stalls
+// other transfers.
+func (m *Mouse) HandleTransferStatus(ep uint32, dir uint32, out []byte) ([]byte, error) {
+	if ep != 1 || dir != usbip.DirIn {
+		return nil, usb.StatusStall
+	}
+	m.stateMu.Lock()
+	changed := m.inputChanged
+	m.stateMu.Unlock()
+	if !changed {
+		return nil, usb.ErrPending
+	}
+	return m.HandleTransfer(ep, dir, out), nil
+}
+
+// InputNotifier returns the notifier fired by UpdateInputState and streamed
+// motion.
+func (m *Mouse) InputNotifier() *usb.Notifier {
+	return &m.notify
+}
+
+// HandleControl answers HID GET_REPORT with the held buttons, gets and sets
+// the resolution multipliers of a hi-res mouse through its feature report
+// and serves the protocol and idle requests of a boot mouse. Motion is left
+// for the interrupt endpoint, so it is not reported twice.
+func (m *Mouse) HandleControl(bmRequestType, bRequest uint8, wValue, wIndex, _ /* wLength */ uint16, data []byte) ([]byte, bool) {
+	const (
+		hidGetReport      = 0x01
+		hidSetReport      = 0x09
+		reportTypeInput   = 0x01
+		reportTypeFeature = 0x03
+	)
+	if resp, ok := m.protocol.HandleControl(bmRequestType, bRequest, wValue, wIndex); ok {
+		return resp, true
+	}
+	if m.hiRes && uint8(wValue>>8) == reportTypeFeature {
+		m.stateMu.Lock()
+		defer m.stateMu.Unlock()
+		switch {
+		case bmRequestType == 0xA1 && bRequest == hidGetReport:
+			return []byte{m.multiplier}, true
+		case bmRequestType == 0x21 && bRequest == hidSetReport && len(data) >= 1:
+			m.multiplier = data[0] & multiplierMask
+			return nil, true
+		}
+		return nil, false
+	}
+	if bmRequestType != 0xA1 || bRequest != hidGetReport || uint8(wValue>>8) != reportTypeInput {
+		return nil, false
+	}
+	m.stateMu.Lock()
+	var st InputState
+	if m.inputState != nil {
+		st.Buttons = m.inputState.Buttons
+	}
+	m.stateMu.Unlock()
+	if m.protocol.Boot() {
+		return st.BuildBootReport(), true
+	}
+	return st.BuildReport(), true
+}
+
+// reportDescriptor returns the HID report descriptor of a 5-button mouse with
+// vertical and horizontal wheels, boot protocol compatible. With hiRes each
+// wheel sits in a logical collection with its resolution multiplier.
+func reportDescriptor(hiRes bool) hid.Report {
+	wheel := []hid.Item{
+		hid.Usage{Usage: hid.UsageWheel},
+		hid.LogicalMinimum{Min: -32768},
+		hid.LogicalMaximum{Max: 32767},
+		hid.ReportSize{Bits: 16},
+		hid.ReportCount{Count: 1},
+		hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainRel},
+	}
+	pan := []hid.Item{
+		hid.UsagePage{Page: hid.UsagePageConsumer},
+		hid.Usage{Usage: hid.UsageACPan},
+		hid.LogicalMinimum{Min: -32768},
+		hid.LogicalMaximum{Max: 32767},
+		hid.ReportSize{Bits: 16},
+		hid.ReportCount{Count: 1},
+		hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainRel},
+	}
+	wheels := append(wheel, pan...)
+	if hiRes {
+		wheels = []hid.Item{
+			hid.Collection{Kind: hid.CollectionLogical, Items: append(slices.Clone(multiplierItems), wheel...)},
+			hid.Collection{Kind: hid.CollectionLogical, Items: append(slices.Clone(multiplierItems), pan...)},
+			// Feature report: 4 bits padding
+			hid.ReportSize{Bits: 4},
+			hid.ReportCount{Count: 1},
+			hid.Feature{Flags: hid.MainConst},
+		}
+	}
+	items := []hid.Item{
+		hid.UsagePage{Page: hid.UsagePageButton},
+		hid.UsageMinimum{Min: 0x01}, // Button 1
+		hid.UsageMaximum{Max: 0x05}, // Button 5
+		hid.LogicalMinimum{Min: 0},
+		hid.LogicalMaximum{Max: 1},
+		hid.ReportCount{Count: 5},
+		hid.ReportSize{Bits: 1},
+		hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+		hid.ReportCount{Count: 1},
+		hid.ReportSize{Bits: 3},
+		hid.Input{Flags: hid.MainConst},
 		hid.UsagePage{Page: hid.UsagePageGenericDesktop},
-		hid.Usage{Usage: hid.UsageMouse},
-		hid.Collection{Kind: hid.CollectionApplication, Items: []hid.Item{
-			hid.Usage{Usage: hid.UsagePointer},
-			hid.Collection{
-				Kind: hid.CollectionPhysical,
-				Items: []hid.Item{
-					hid.UsagePage{Page: hid.UsagePageButton},
-					hid.UsageMinimum{Min: 0x01}, // Button 1
-					hid.UsageMaximum{Max: 0x05}, // Button 5
-					hid.LogicalMinimum{Min: 0},
-					hid.LogicalMaximum{Max: 1},
-					hid.ReportCount{Count: 5},
-					hid.ReportSize{Bits: 1},
-					hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
-					hid.ReportCount{Count: 1},
-					hid.ReportSize{Bits: 3},
-					hid.Input{Flags: hid.MainConst},
-					hid.UsagePage{Page: hid.UsagePageGenericDesktop},
-					hid.Usage{Usage: hid.UsageX},
-					hid.Usage{Usage: hid.UsageY},
-					hid.LogicalMinimum{Min: -32768},
-					hid.LogicalMaximum{Max: 32767},
-					hid.ReportSize{Bits: 16},
-					hid.ReportCount{Count: 2},
-					hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainRel},
-					hid.Usage{Usage: hid.UsageWheel},
-					hid.LogicalMinimum{Min: -32768},
-					hid.LogicalMaximum{Max: 32767},
-					hid.ReportSize{Bits: 16},
-					hid.ReportCount{Count: 1},
-					hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainRel},
-					hid.UsagePage{Page: hid.UsagePageConsumer},
-					hid.Usage{Usage: hid.UsageACPan},
-					hid.LogicalMinimum{Min: -32768},
-					hid.LogicalMaximum{Max: 32767},
-					hid.ReportSize{Bits: 16},
-					hid.ReportCount{Count: 1},
-					hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainRel},
+		hid.Usage{Usage: hid.UsageX},
+		hid.Usage{Usage: hid.UsageY},
+		hid.LogicalMinimum{Min: -32768},
+		hid.LogicalMaximum{Max: 32767},
+		hid.ReportSize{Bits: 16},
+		hid.ReportCount{Count: 2},
+		hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainRel},
+	}
+	return hid.Report{
+		Items: []hid.Item{
+			hid.UsagePage{Page: hid.UsagePageGenericDesktop},
+			hid.Usage{Usage: hid.UsageMouse},
+			hid.Collection{Kind: hid.CollectionApplication, Items: []hid.Item{
+				hid.Usage{Usage: hid.UsagePointer},
+				hid.Collection{
+					Kind:  hid.CollectionPhysical,
+					Items: append(items, wheels...),
 				},
-			},
-		}},
-	},
-}
-
-// Descriptor defines the static USB descriptor for the mouse.
-var defaultDescriptor = usb.Descriptor{
-	Device: usb.DeviceDescriptor{
-		BcdUSB:             0x0200,
-		BDeviceClass:       0x00,
-		BDeviceSubClass:    0x00,
-		BDeviceProtocol:    0x00,
-		BMaxPacketSize0:    0x40, // 64 bytes
-		IDVendor:           0x2E8A,
-		IDProduct:          0x0011,
-		BcdDevice:          0x0100,
-		IManufacturer:      0x01,
-		IProduct:           0x02,
-		ISerialNumber:      0x03,
-		BNumConfigurations: 0x01,
-		Speed:              2, // Full speed
-	},
-	Interfaces: []usb.InterfaceConfig{
-		{
-			Descriptor: usb.InterfaceDescriptor{
-				BInterfaceNumber:   0x00,
-				BAlternateSetting:  0x00,
-				BNumEndpoints:      0x01,
-				BInterfaceClass:    0x03, // HID
-				BInterfaceSubClass: 0x01, // Boot Interface
-				BInterfaceProtocol: 0x02, // Mouse
-				IInterface:         0x00,
-			},
-			HID: &usb.HIDFunction{
-				Descriptor: usb.HIDDescriptor{
-					BcdHID:       0x0111,
-					BCountryCode: 0x00,
-					Descriptors: []usb.HIDSubDescriptor{
-						{Type: usb.ReportDescType},
+			}},
+		},
+	}
+}
+
+// newDescriptor returns the USB descriptor of the mouse.
+func newDescriptor(hiRes bool) usb.Descriptor {
+	return usb.Descriptor{
+		Device: usb.DeviceDescriptor{
+			BcdUSB:             0x0200,
+			BDeviceClass:       0x00,
+			BDeviceSubClass:    0x00,
+			BDeviceProtocol:    0x00,
+			BMaxPacketSize0:    0x40, // 64 bytes
+			IDVendor:           0x2E8A,
+			IDProduct:          0x0011,
+			BcdDevice:          0x0100,
+			IManufacturer:      0x01,
+			IProduct:           0x02,
+			ISerialNumber:      0x03,
+			BNumConfigurations: 0x01,
+			Speed:              2, // Full speed
+		},
+		Interfaces: []usb.InterfaceConfig{
+			{
+				Descriptor: usb.InterfaceDescriptor{
+					BInterfaceNumber:   0x00,
+					BAlternateSetting:  0x00,
+					BNumEndpoints:      0x01,
+					BInterfaceClass:    0x03, // HID
+					BInterfaceSubClass: 0x01, // Boot Interface
+					BInterfaceProtocol: 0x02, // Mouse
+					IInterface:         0x00,
+				},
+				HID: &usb.HIDFunction{
+					Descriptor: usb.HIDDescriptor{
+						BcdHID:       0x0111,
+						BCountryCode: 0x00,
+						Descriptors: []usb.HIDSubDescriptor{
+							{Type: usb.ReportDescType},
+						},
 					},
+					Report: reportDescriptor(hiRes),
 				},
-				Report: reportDescriptor,
-			},
-			Endpoints: []usb.EndpointDescriptor{
-				{
-					BEndpointAddress: 0x81,
-					BMAttributes:     0x03,   // Interrupt
-					WMaxPacketSize:   0x0010, // 16 bytes (9 needed)
-					BInterval:        0x05,   // 5 ms
+				Endpoints: []usb.EndpointDescriptor{
+					{
+						BEndpointAddress: 0x81,
+						BMAttributes:     0x03,   // Interrupt
+						WMaxPacketSize:   0x0010, // 16 bytes (9 needed)
+						BInterval:        0x05,   // 5 ms
+					},
 				},
 			},
 		},
-	},
-	Strings: map[uint8]string{
-		0: "\x04\x09", // LangID: en-US (0x0409)
-		1: "VIIPER",
-		2: "HID Mouse",
-		3: "1337",
-	},
+		Strings: map[uint8]string{
+			0: "\x04\x09", // LangID: en-US (0x0409)
+			1: "VIIPER",
+			2: "HID Mouse",
+			3: "1337",
+		},
+	}
 }
 
 func (m *Mouse) GetDescriptor() *usb.Descriptor {
@@ -181,5 +383,8 @@ func (m *Mouse) GetDescriptor() *usb.Descriptor {
 }
 
 func (x *Mouse) GetDeviceSpecificArgs() map[string]any {
+	if x.hiRes {
+		return map[string]any{"hiResScroll": true}
+	}
 	return map[string]any{}
 }
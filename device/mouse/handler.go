@@ -1,6 +1,7 @@
 package mouse
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
@@ -13,12 +14,32 @@ import (
 
 func init() {
 	api.RegisterDevice("mouse", &handler{})
+	device.RegisterOutputDecoder("mouse", device.OutputDecoder{}) // no feedback
 }
 
 type handler struct{}
 
 func (h *handler) CreateDevice(o *device.CreateOptions) (usb.Device, error) { return New(o) }
 
+func (h *handler) InputLayout(dev usb.Device) device.WireLayout {
+	if m, ok := dev.(*Mouse); ok && m.hiRes {
+		return HiResInputLayout
+	}
+	return InputLayout
+}
+
+func (h *handler) MacroState(dev usb.Device, state []byte) (func(), error) {
+	mdev, ok := dev.(*Mouse)
+	if !ok {
+		return nil, fmt.Errorf("device is not mouse")
+	}
+	var st InputState
+	if err := json.Unmarshal(state, &st); err != nil {
+		return nil, err
+	}
+	return func() { mdev.UpdateInputState(st) }, nil
+}
+
 func (r *handler) StreamHandler() api.StreamHandlerFunc {
 	return func(conn net.Conn, devPtr *usb.Device, logger *slog.Logger) error {
 		if devPtr == nil || *devPtr == nil {
@@ -29,7 +50,10 @@ func (r *handler) StreamHandler() api.StreamHandlerFunc {
 			return fmt.Errorf("device is not mouse")
 		}
 
-		buf := make([]byte, 9)
+		buf := make([]byte, InputLayout.Size())
+		if mdev.hiRes {
+			buf = make([]byte, HiResInputLayout.Size())
+		}
 		for {
 			if _, err := io.ReadFull(conn, buf); err != nil {
 				if err == io.EOF {
@@ -40,9 +64,28 @@ func (r *handler) StreamHandler() api.StreamHandlerFunc {
 			}
 
 			var state InputState
-			if err := state.UnmarshalBinary(buf); err != nil {
+			var err error
+			if mdev.hiRes {
+				err = (*HiResState)(&state).UnmarshalBinary(buf)
+			} else {
+				err = state.UnmarshalBinary(buf)
+			}
+			if err != nil {
 				return fmt.Errorf("unmarshal input state: %w", err)
 			}
+			if mdev.step.Active() {
+				st := state
+				if err := mdev.step.Push(func() { mdev.UpdateInputState(st) }); err != nil {
+					return err
+				}
+				continue
+			}
+			if mdev.humanize.Active() {
+				mdev.humanize.Move(int(state.DX), int(state.DY), func(dx, dy int, last bool) {
+					mdev.addMotion(dx, dy, state, last)
+				})
+				continue
+			}
 			mdev.UpdateInputState(state)
 		}
 	}
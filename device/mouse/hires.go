@@ -0,0 +1,82 @@
+package mouse
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"slices"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/usb/hid"
+)
+
+// HiResDetent is the WheelHiRes and PanHiRes movement of one wheel notch.
+const HiResDetent = 120
+
+// HiResState is the stream format of a mouse created with hiResScroll: the
+// InputState wire format followed by the hi-res wheel movement.
+// viiper:wire mousehires c2s buttons:u8 dx:i16 dy:i16 wheel:i16 pan:i16 wheelHiRes:i16 panHiRes:i16
+type HiResState InputState
+
+// HiResInputLayout is the field layout of the HiResState wire format.
+var HiResInputLayout = append(slices.Clone(InputLayout),
+	device.WireField{Name: "wheelHiRes", Size: 2, Relative: true},
+	device.WireField{Name: "panHiRes", Size: 2, Relative: true},
+)
+
+// MarshalBinary encodes HiResState to 13 bytes.
+func (h *HiResState) MarshalBinary() ([]byte, error) {
+	b, _ := (*InputState)(h).MarshalBinary()
+	b = binary.LittleEndian.AppendUint16(b, uint16(h.WheelHiRes))
+	return binary.LittleEndian.AppendUint16(b, uint16(h.PanHiRes)), nil
+}
+
+// UnmarshalBinary decodes 13 bytes into HiResState.
+func (h *HiResState) UnmarshalBinary(data []byte) error {
+	if len(data) < 13 {
+		return io.ErrUnexpectedEOF
+	}
+	if err := (*InputState)(h).UnmarshalBinary(data); err != nil {
+		return err
+	}
+	h.WheelHiRes = int16(binary.LittleEndian.Uint16(data[9:]))
+	h.PanHiRes = int16(binary.LittleEndian.Uint16(data[11:]))
+	return nil
+}
+
+// Resolution multiplier feature report bits, one 2-bit field per wheel. A
+// field set to 1 makes the host read that wheel in 1/120 of a notch.
+const (
+	multiplierWheel = 0x01
+	multiplierPan   = 0x04
+	multiplierMask  = 0x0F
+)
+
+// scroll returns the movement of a wheel to report for notches plus hiRes:
+// in 1/120 of a notch if the host set the resolution multiplier, else in
+// whole notches with the fraction kept in rem for the next report.
+func scroll(notches, hiRes int16, fine bool, rem *int) int16 {
+	total := int(notches)*HiResDetent + int(hiRes) + *rem
+	*rem = 0
+	if !fine {
+		*rem = total % HiResDetent
+		total /= HiResDetent
+	}
+	return int16(min(max(total, math.MinInt16), math.MaxInt16))
+}
+
+// multiplierItems are the 2-bit resolution multiplier feature of the wheel
+// that follows it in the same logical collection.
+var multiplierItems = []hid.Item{
+	hid.UsagePage{Page: hid.UsagePageGenericDesktop},
+	hid.Usage{Usage: hid.UsageResolutionMultiplier},
+	hid.LogicalMinimum{Min: 0},
+	hid.LogicalMaximum{Max: 1},
+	hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x3, Data: hid.Data{0x01}}, // Physical Minimum 1
+	hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x4, Data: hid.Data{0x78}}, // Physical Maximum 120
+	hid.ReportSize{Bits: 2},
+	hid.ReportCount{Count: 1},
+	hid.Feature{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+	hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x3, Data: hid.Data{0x00}},
+	hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x4, Data: hid.Data{0x00}},
+}
@@ -2,6 +2,8 @@ package mouse
 
 import (
 	"io"
+
+	"github.com/Alia5/VIIPER/device"
 )
 
 // InputState represents the mouse state used to build a report.
@@ -15,9 +17,25 @@ type InputState struct {
 	Wheel int16
 	// Pan: signed 16-bit horizontal scroll
 	Pan int16
+	// WheelHiRes, PanHiRes: scroll in 1/120 of a notch, added to Wheel and
+	// Pan. Only mice created with hiResScroll take them from the stream,
+	// see HiResState.
+	WheelHiRes, PanHiRes int16
+}
+
+// InputLayout is the field layout of the InputState wire format, used for delta updates.
+// Movement and scroll are relative and read as zero when omitted.
+var InputLayout = device.WireLayout{
+	{Name: "buttons", Size: 1},
+	{Name: "dx", Size: 2, Relative: true},
+	{Name: "dy", Size: 2, Relative: true},
+	{Name: "wheel", Size: 2, Relative: true},
+	{Name: "pan", Size: 2, Relative: true},
 }
 
 // BuildReport encodes an InputState into the 9-byte HID mouse report.
+// Wheel and Pan are encoded as they are; the device folds the hi-res
+// fields into them according to the host's resolution multiplier.
 //
 // Report layout (9 bytes):
 //
@@ -40,6 +58,22 @@ func (m *InputState) BuildReport() []byte {
 	return b
 }
 
+// BuildBootReport encodes an InputState into the 3-byte boot protocol report.
+// Movement beyond the 8-bit range is clamped; wheels are not part of it.
+//
+// Report layout (3 bytes):
+//
+//	Byte 0: Button bitfield (bit 0=Left, 1=Right, 2=Middle, bits 3-7=padding)
+//	Byte 1: DX (int8, -127 to +127)
+//	Byte 2: DY (int8)
+func (m *InputState) BuildBootReport() []byte {
+	return []byte{m.Buttons & 0x07, byte(clampInt8(m.DX)), byte(clampInt8(m.DY))}
+}
+
+func clampInt8(v int16) int8 {
+	return int8(min(max(v, -127), 127))
+}
+
 // MarshalBinary encodes InputState to 9 bytes.
 func (m *InputState) MarshalBinary() ([]byte, error) {
 	b := make([]byte, 9)
@@ -2,18 +2,23 @@ package mouse_test
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
 	viiperTesting "github.com/Alia5/VIIPER/_testing"
 	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/device"
 	"github.com/Alia5/VIIPER/device/mouse"
 	"github.com/Alia5/VIIPER/internal/server/api"
 	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/usbip"
 	"github.com/Alia5/VIIPER/virtualbus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	_ "github.com/Alia5/VIIPER/internal/registry" // Register devices
+	"github.com/Alia5/VIIPER/usb"
 )
 
 func TestInputReports(t *testing.T) {
@@ -192,3 +197,173 @@ func TestInputReports(t *testing.T) {
 		})
 	}
 }
+
+func TestBootProtocol(t *testing.T) {
+	m, err := mouse.New(nil)
+	require.NoError(t, err)
+	poll := func() []byte {
+		report := m.HandleTransfer(1, usbip.DirIn, nil)
+		return report
+	}
+	setProtocol := func(proto uint16) {
+		_, ok := m.HandleControl(0x21, 0x0B, proto, 0, 0, nil)
+		require.True(t, ok)
+	}
+	getProtocol := func() []byte {
+		resp, ok := m.HandleControl(0xA1, 0x03, 0, 0, 1, nil)
+		require.True(t, ok)
+		return resp
+	}
+
+	assert.Equal(t, []byte{1}, getProtocol())
+	m.UpdateInputState(mouse.InputState{Buttons: mouse.Btn_Left, DX: 5, DY: -3, Wheel: 1})
+	assert.Len(t, poll(), 9)
+
+	setProtocol(0)
+	assert.Equal(t, []byte{0}, getProtocol())
+	m.UpdateInputState(mouse.InputState{Buttons: mouse.Btn_Left | mouse.Btn_Back, DX: 5, DY: -3, Wheel: 1})
+	assert.Equal(t, []byte{mouse.Btn_Left, 5, 0xFD}, poll(), "extra buttons and wheel dropped")
+
+	m.UpdateInputState(mouse.InputState{DX: 300, DY: -200})
+	assert.Equal(t, []byte{0, 127, 0x81}, poll(), "clamped to int8")
+	assert.Equal(t, []byte{0, 127, 0xB7}, poll(), "rest of the motion follows")
+	assert.Equal(t, []byte{0, 46, 0}, poll())
+	assert.Equal(t, []byte{0, 0, 0}, poll())
+
+	m.UpdateInputState(mouse.InputState{Buttons: mouse.Btn_Right, DX: 10})
+	resp, ok := m.HandleControl(0xA1, 0x01, 0x0100, 0, 3, nil)
+	require.True(t, ok)
+	assert.Equal(t, []byte{mouse.Btn_Right, 0, 0}, resp, "GET_REPORT follows the protocol")
+
+	m.BusReset()
+	assert.Equal(t, []byte{1}, getProtocol())
+	assert.Len(t, poll(), 9)
+}
+
+func TestHiResScroll(t *testing.T) {
+	m, err := mouse.New(&device.CreateOptions{DeviceSpecific: map[string]any{"hiResScroll": true}})
+	require.NoError(t, err)
+	poll := func() []byte {
+		report := m.HandleTransfer(1, usbip.DirIn, nil)
+		return report
+	}
+	getFeature := func() []byte {
+		resp, ok := m.HandleControl(0xA1, 0x01, 0x0300, 0, 1, nil)
+		require.True(t, ok)
+		return resp
+	}
+	setFeature := func(v uint8) {
+		_, ok := m.HandleControl(0x21, 0x09, 0x0300, 0, 1, []byte{v})
+		require.True(t, ok)
+	}
+
+	desc := m.GetDescriptor().Interfaces[0].HID.Report.String()
+	assert.Equal(t, 2, strings.Count(desc, "Usage (Resolution Multiplier)"))
+	assert.Equal(t, map[string]any{"hiResScroll": true}, m.GetDeviceSpecificArgs())
+
+	// Until the host sets the multipliers, hi-res movement adds up to notches.
+	assert.Equal(t, []byte{0}, getFeature())
+	for range 3 {
+		m.UpdateInputState(mouse.InputState{WheelHiRes: 30, PanHiRes: -30})
+		assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0}, poll())
+	}
+	m.UpdateInputState(mouse.InputState{WheelHiRes: 30, PanHiRes: -30})
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 1, 0, 0xFF, 0xFF}, poll())
+
+	setFeature(0x05)
+	assert.Equal(t, []byte{0x05}, getFeature())
+	m.UpdateInputState(mouse.InputState{WheelHiRes: 30})
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 30, 0, 0, 0}, poll())
+	m.UpdateInputState(mouse.InputState{Wheel: -1, Pan: 2, PanHiRes: 5})
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0x88, 0xFF, 0xF5, 0x00}, poll(), "notches in 1/120")
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0}, poll())
+
+	setFeature(0x01)
+	m.UpdateInputState(mouse.InputState{WheelHiRes: 30, Pan: 1})
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 30, 0, 1, 0}, poll(), "multipliers are per wheel")
+
+	m.BusReset()
+	assert.Equal(t, []byte{0}, getFeature())
+
+	plain, err := mouse.New(nil)
+	require.NoError(t, err)
+	_, ok := plain.HandleControl(0xA1, 0x01, 0x0300, 0, 1, nil)
+	assert.False(t, ok, "no feature report without hiResScroll")
+	assert.NotContains(t, plain.GetDescriptor().Interfaces[0].HID.Report.String(), "Resolution Multiplier")
+}
+
+func TestHiResStream(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(1)
+	require.NoError(t, err)
+	defer b.Close()
+	_ = s.UsbServer.AddBus(b)
+
+	client := apiclient.New(s.ApiServer.Addr())
+	stream, _, err := client.AddDeviceAndConnect(context.Background(), b.BusID(), "mouse", &device.CreateOptions{
+		DeviceSpecific: map[string]any{"hiResScroll": true},
+	})
+	require.NoError(t, err)
+	defer stream.Close()
+
+	usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := usbipClient.AttachDevice("1-1")
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+
+	// SET_REPORT(Feature) enabling both multipliers, as hosts do on probe.
+	_, err = usbipClient.Control(imp.Conn, [8]byte{0x21, 0x09, 0x00, 0x03, 0x00, 0x00, 0x01, 0x00}, []byte{0x05})
+	require.NoError(t, err)
+	got, err := usbipClient.Control(imp.Conn, [8]byte{0xA1, 0x01, 0x00, 0x03, 0x00, 0x00, 0x01, 0x00}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x05}, got)
+
+	state := mouse.HiResState{Buttons: mouse.Btn_Left, WheelHiRes: 30}
+	wire, err := state.MarshalBinary()
+	require.NoError(t, err)
+	assert.Len(t, wire, 13)
+	require.NoError(t, stream.WriteBinary(&state))
+	want := []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x1E, 0x00, 0x00, 0x00}
+	got, err = usbipClient.PollInputReport(imp.Conn, want, 750*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestHeldInputReports(t *testing.T) {
+	m, err := mouse.New(nil)
+	require.NoError(t, err)
+	var _ usb.PendingDevice = m
+
+	read := func() []byte {
+		t.Helper()
+		r, err := m.HandleTransferStatus(1, usbip.DirIn, nil)
+		require.NoError(t, err)
+		return r
+	}
+	read()
+	_, err = m.HandleTransferStatus(1, usbip.DirIn, nil)
+	assert.ErrorIs(t, err, usb.ErrPending, "unchanged input is held")
+
+	// The boot report moves at most 127 per poll; the rest is not held.
+	_, ok := m.HandleControl(0x21, 0x0B, 0, 0, 0, nil)
+	require.True(t, ok)
+	wake := m.InputNotifier().Wait()
+	m.UpdateInputState(mouse.InputState{DX: 200})
+	select {
+	case <-wake:
+	default:
+		t.Error("UpdateInputState does not wake held polls")
+	}
+	assert.Equal(t, byte(127), read()[1])
+	assert.Equal(t, byte(73), read()[1])
+	_, err = m.HandleTransferStatus(1, usbip.DirIn, nil)
+	assert.ErrorIs(t, err, usb.ErrPending)
+}
@@ -1,7 +1,59 @@
 package device
 
+import "github.com/Alia5/VIIPER/usb"
+
 type CreateOptions struct {
 	IdVendor       *uint16
 	IdProduct      *uint16
 	DeviceSpecific map[string]any
+	// SerialNumber and ProductString replace the strings the device reports,
+	// see ApplyIdentity.
+	SerialNumber  *string
+	ProductString *string
+	// MSOS20 replaces the Microsoft OS 2.0 descriptors of the device; an
+	// empty CompatibleID removes them. See ApplyIdentity.
+	MSOS20 *usb.MSOS20
+	// StrictInput rejects out-of-range stream inputs instead of clamping them.
+	StrictInput *bool
+	// PlayerSlot is the 1-based player number of the device, see PlayerSlotter.
+	PlayerSlot *int
+	// StreamPolicy is how the device takes input from several streams.
+	StreamPolicy *StreamPolicy
+	// Humanize shapes the timing of streamed input, see Humanizable.
+	Humanize *HumanizeConfig
+	// Deterministic queues streamed input for steps, see Steppable.
+	Deterministic *DeterministicConfig
+	// Disconnect is what happens when the stream client goes away.
+	Disconnect *DisconnectPolicy
+}
+
+// WithDefaults returns a copy of o with unset fields taken from def.
+// DeviceSpecific is merged shallowly per key, with keys set in o winning.
+func (o CreateOptions) WithDefaults(def CreateOptions) CreateOptions {
+	out := o
+	if out.IdVendor == nil {
+		out.IdVendor = def.IdVendor
+	}
+	if out.IdProduct == nil {
+		out.IdProduct = def.IdProduct
+	}
+	if out.SerialNumber == nil {
+		out.SerialNumber = def.SerialNumber
+	}
+	if out.ProductString == nil {
+		out.ProductString = def.ProductString
+	}
+	if out.StrictInput == nil {
+		out.StrictInput = def.StrictInput
+	}
+	if len(def.DeviceSpecific) > 0 {
+		out.DeviceSpecific = make(map[string]any, len(def.DeviceSpecific)+len(o.DeviceSpecific))
+		for k, v := range def.DeviceSpecific {
+			out.DeviceSpecific[k] = v
+		}
+		for k, v := range o.DeviceSpecific {
+			out.DeviceSpecific[k] = v
+		}
+	}
+	return out
 }
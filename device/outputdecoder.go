@@ -0,0 +1,58 @@
+package device
+
+import (
+	"encoding"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// OutputDecoder decodes the server-to-client feedback messages of a device
+// type whose s2c wire format has a fixed size.
+type OutputDecoder struct {
+	// Size is the size of one message in bytes; 0 for device types that send
+	// no feedback.
+	Size int
+	// New returns an empty message to decode into, e.g. new(xbox360.XRumbleState).
+	New func() encoding.BinaryUnmarshaler
+}
+
+// Decode reads one message from r and decodes it.
+func (d OutputDecoder) Decode(r io.Reader) (encoding.BinaryUnmarshaler, error) {
+	if d.Size == 0 || d.New == nil {
+		return nil, fmt.Errorf("device type sends no feedback")
+	}
+	buf := make([]byte, d.Size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	msg := d.New()
+	if err := msg.UnmarshalBinary(buf); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+var (
+	outputDecoders   = make(map[string]OutputDecoder)
+	outputDecodersMu sync.RWMutex
+)
+
+// RegisterOutputDecoder registers the feedback decoder of a device type.
+// This should be called from device package init() functions, next to the
+// device's registration with the API server. The name is case-insensitive.
+func RegisterOutputDecoder(deviceType string, d OutputDecoder) {
+	outputDecodersMu.Lock()
+	defer outputDecodersMu.Unlock()
+	outputDecoders[strings.ToLower(deviceType)] = d
+}
+
+// LookupOutputDecoder returns the feedback decoder of a device type; ok is
+// false if its package registered none, e.g. because it was not imported.
+func LookupOutputDecoder(deviceType string) (d OutputDecoder, ok bool) {
+	outputDecodersMu.RLock()
+	defer outputDecodersMu.RUnlock()
+	d, ok = outputDecoders[strings.ToLower(deviceType)]
+	return d, ok
+}
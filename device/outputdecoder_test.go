@@ -0,0 +1,54 @@
+package device_test
+
+import (
+	"bytes"
+	"encoding"
+	"path/filepath"
+	"testing"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/internal/codegen/common"
+	"github.com/Alia5/VIIPER/internal/codegen/scanner"
+	_ "github.com/Alia5/VIIPER/internal/registry"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutputDecodersCoverDeviceTypes(t *testing.T) {
+	types := api.ListDeviceTypes()
+	require.NotEmpty(t, types)
+	for _, name := range types {
+		t.Run(name, func(t *testing.T) {
+			dec, ok := device.LookupOutputDecoder(name)
+			require.True(t, ok, "no output decoder registered")
+
+			tags, err := scanner.ScanWireTags([]string{filepath.Join(".", name)})
+			require.NoError(t, err)
+			tag := tags.GetTag(name, "s2c")
+			if tag == nil {
+				assert.Zero(t, dec.Size)
+				_, err := dec.Decode(bytes.NewReader(nil))
+				assert.Error(t, err)
+				return
+			}
+			require.Equal(t, common.CalculateOutputSize(tag), dec.Size)
+			require.NotNil(t, dec.New)
+
+			wire := make([]byte, dec.Size)
+			for i := range wire {
+				wire[i] = byte(i + 1)
+			}
+			msg, err := dec.Decode(bytes.NewReader(wire))
+			require.NoError(t, err)
+			if m, ok := msg.(encoding.BinaryMarshaler); ok {
+				out, err := m.MarshalBinary()
+				require.NoError(t, err)
+				assert.Equal(t, wire, out)
+			}
+
+			_, err = dec.Decode(bytes.NewReader(wire[:dec.Size-1]))
+			assert.Error(t, err)
+		})
+	}
+}
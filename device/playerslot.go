@@ -0,0 +1,20 @@
+package device
+
+// MaxPlayerSlot is the highest player slot that can be assigned to a device.
+const MaxPlayerSlot = 8
+
+// PlayerSlotter is implemented by device types that accept a player slot
+// through CreateOptions.PlayerSlot. How (and whether) a slot affects the
+// emulated hardware is up to the device type.
+type PlayerSlotter interface {
+	// PlayerSlot returns the 1-based player slot, or 0 if none was assigned.
+	PlayerSlot() int
+}
+
+// PlayerSlotOf returns the player slot of dev, or 0 if it has none.
+func PlayerSlotOf(dev any) int {
+	if p, ok := dev.(PlayerSlotter); ok {
+		return p.PlayerSlot()
+	}
+	return 0
+}
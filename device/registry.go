@@ -0,0 +1,146 @@
+package device
+
+import (
+	"encoding"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// Factory creates a device of a registered type.
+type Factory func(o *CreateOptions) (usb.Device, error)
+
+// WireInfo describes the stream wire format of a device type registered
+// with Register.
+type WireInfo struct {
+	// InputSize is the size of one client-to-server input packet in bytes.
+	InputSize int
+	// OutputSize is the size of one server-to-client feedback message; 0 for
+	// device types that send none.
+	OutputSize int
+	// NewOutput optionally returns an empty feedback message to decode into,
+	// so apiclient streams can read the device's feedback.
+	NewOutput func() encoding.BinaryUnmarshaler
+}
+
+// InputHandler is implemented by devices of types registered with Register.
+// The API server passes every input packet of the device's stream to
+// HandleInput; an error ends the stream.
+type InputHandler interface {
+	HandleInput(packet []byte) error
+}
+
+// FeedbackSender is implemented by registered devices that send feedback.
+// When a stream opens, SetFeedback gets the function writing a message of
+// WireInfo.OutputSize bytes to the client; when it closes, nil.
+type FeedbackSender interface {
+	SetFeedback(send func(msg []byte) error)
+}
+
+// Registration is a device type added with Register.
+type Registration struct {
+	Name    string
+	Factory Factory
+	Wire    WireInfo
+}
+
+var (
+	registrations   = make(map[string]Registration)
+	registeredTypes = make(map[reflect.Type]string)
+	registrationsMu sync.RWMutex
+)
+
+// Register adds a device type implemented outside this module, so the API
+// server can create and stream devices of it like the built-in ones.
+// Programs embedding the server call it before starting it. The name is
+// case-insensitive; built-in types of the same name take precedence.
+// Devices created by factory must implement InputHandler.
+//
+// Register lints the descriptor of a device created with default options,
+// see usb.Descriptor.Lint: it panics on errors and logs warnings.
+func Register(name string, factory Factory, wire WireInfo) {
+	if name == "" || factory == nil {
+		panic("device: Register needs a name and a factory")
+	}
+	if wire.InputSize <= 0 {
+		panic(fmt.Sprintf("device: Register %s: InputSize must be positive", name))
+	}
+	name = strings.ToLower(name)
+	lintDefault(name, factory)
+	registrationsMu.Lock()
+	registrations[name] = Registration{Name: name, Factory: factory, Wire: wire}
+	registrationsMu.Unlock()
+	if wire.OutputSize > 0 && wire.NewOutput != nil {
+		RegisterOutputDecoder(name, OutputDecoder{Size: wire.OutputSize, New: wire.NewOutput})
+	}
+}
+
+// lintDefault lints the descriptor of a device of a type being registered.
+// Factories that need options to create a device are not linted; their
+// devices are still validated when added to a bus.
+func lintDefault(name string, factory Factory) {
+	dev, err := factory(nil)
+	if err != nil || dev == nil {
+		return
+	}
+	desc := dev.GetDescriptor()
+	if desc == nil {
+		return
+	}
+	report := desc.Lint()
+	if errs := report.Errors(); len(errs) > 0 {
+		panic(fmt.Sprintf("device: Register %s: broken descriptor:\n%s", name, errs))
+	}
+	for _, f := range report.Warnings() {
+		slog.Warn("Descriptor lint warning", "type", name, "code", f.Code, "problem", f.Message, "hint", f.Hint)
+	}
+}
+
+// LookupRegistration returns the device type registered as name.
+func LookupRegistration(name string) (Registration, bool) {
+	registrationsMu.RLock()
+	defer registrationsMu.RUnlock()
+	r, ok := registrations[strings.ToLower(name)]
+	return r, ok
+}
+
+// RegisteredNames returns the names of all types added with Register, sorted.
+func RegisteredNames() []string {
+	registrationsMu.RLock()
+	defer registrationsMu.RUnlock()
+	names := make([]string, 0, len(registrations))
+	for name := range registrations {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Create calls the factory of r and remembers the Go type of the device, so
+// RegisteredType can name the type of its devices later.
+func (r Registration) Create(o *CreateOptions) (usb.Device, error) {
+	dev, err := r.Factory(o)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := dev.(InputHandler); !ok {
+		return nil, fmt.Errorf("device type %s: %T does not implement device.InputHandler", r.Name, dev)
+	}
+	registrationsMu.Lock()
+	registeredTypes[reflect.TypeOf(dev)] = r.Name
+	registrationsMu.Unlock()
+	return dev, nil
+}
+
+// RegisteredType returns the name of the registered type that created dev.
+func RegisteredType(dev usb.Device) (string, bool) {
+	registrationsMu.RLock()
+	defer registrationsMu.RUnlock()
+	name, ok := registeredTypes[reflect.TypeOf(dev)]
+	return name, ok
+}
@@ -0,0 +1,166 @@
+package device_test
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// fakeButton reports the last input packet on endpoint 1 and echoes OUT
+// transfers on endpoint 2 back to the stream as feedback.
+type fakeButton struct {
+	mu       sync.Mutex
+	state    [2]byte
+	feedback func(msg []byte) error
+
+	numEndpoints uint8 // overrides bNumEndpoints if set
+}
+
+func (b *fakeButton) HandleInput(packet []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	copy(b.state[:], packet)
+	return nil
+}
+
+func (b *fakeButton) SetFeedback(send func(msg []byte) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.feedback = send
+}
+
+func (b *fakeButton) HandleTransfer(ep uint32, dir uint32, out []byte) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case ep == 1 && dir == usbip.DirIn:
+		return b.state[:]
+	case ep == 2 && dir == usbip.DirOut:
+		if b.feedback != nil {
+			_ = b.feedback(out)
+		}
+	}
+	return nil
+}
+
+func (b *fakeButton) GetDescriptor() *usb.Descriptor {
+	numEndpoints := uint8(2)
+	if b.numEndpoints != 0 {
+		numEndpoints = b.numEndpoints
+	}
+	return &usb.Descriptor{
+		Device: usb.DeviceDescriptor{BcdUSB: 0x0200, BMaxPacketSize0: 64, IDVendor: 0x1209, IDProduct: 0x0001, BNumConfigurations: 1},
+		Interfaces: []usb.InterfaceConfig{{
+			Descriptor: usb.InterfaceDescriptor{BNumEndpoints: numEndpoints, BInterfaceClass: 0xff},
+			Endpoints: []usb.EndpointDescriptor{
+				{BEndpointAddress: 0x81, BMAttributes: 0x03, WMaxPacketSize: 8, BInterval: 1},
+				{BEndpointAddress: 0x02, BMAttributes: 0x03, WMaxPacketSize: 8, BInterval: 1},
+			},
+		}},
+	}
+}
+
+func (b *fakeButton) GetDeviceSpecificArgs() map[string]any { return map[string]any{} }
+
+type fakeFeedback struct{ data []byte }
+
+func (f *fakeFeedback) UnmarshalBinary(data []byte) error {
+	f.data = append([]byte(nil), data...)
+	return nil
+}
+
+func TestRegister(t *testing.T) {
+	device.Register("FakeButton", func(o *device.CreateOptions) (usb.Device, error) {
+		return &fakeButton{}, nil
+	}, device.WireInfo{
+		InputSize:  2,
+		OutputSize: 2,
+		NewOutput:  func() encoding.BinaryUnmarshaler { return &fakeFeedback{} },
+	})
+	device.Register("fakenoinput", func(o *device.CreateOptions) (usb.Device, error) {
+		return &struct{ usb.Device }{&fakeButton{}}, nil
+	}, device.WireInfo{InputSize: 1})
+
+	_, ok := device.LookupRegistration("fakebutton")
+	assert.True(t, ok, "names are case-insensitive")
+	assert.Contains(t, device.RegisteredNames(), "fakebutton")
+	assert.Contains(t, api.ListDeviceTypes(), "fakebutton")
+	assert.Panics(t, func() { device.Register("broken", nil, device.WireInfo{InputSize: 1}) })
+	assert.Panics(t, func() {
+		device.Register("broken", func(*device.CreateOptions) (usb.Device, error) { return nil, nil }, device.WireInfo{})
+	})
+	assert.PanicsWithValue(t, "device: Register badendpoints: broken descriptor:\n"+
+		"error [num-endpoints] interface 0 alt 0: bNumEndpoints is 3 but 2 endpoints are defined (set bNumEndpoints to the number of Endpoints)\n", func() {
+		device.Register("badendpoints", func(*device.CreateOptions) (usb.Device, error) {
+			return &fakeButton{numEndpoints: 3}, nil
+		}, device.WireInfo{InputSize: 1})
+	})
+	_, ok = device.LookupRegistration("badendpoints")
+	assert.False(t, ok, "types with broken descriptors are not registered")
+
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90167)
+	require.NoError(t, err)
+	defer b.Close()
+	require.NoError(t, s.UsbServer.AddBus(b))
+
+	ctx := context.Background()
+	client := apiclient.New(s.ApiServer.Addr())
+	_, _, err = client.AddDeviceAndConnect(ctx, b.BusID(), "fakenoinput", nil)
+	assert.ErrorContains(t, err, "does not implement device.InputHandler")
+
+	stream, added, err := client.AddDeviceAndConnect(ctx, b.BusID(), "fakebutton", nil)
+	require.NoError(t, err)
+	defer stream.Close()
+	assert.Equal(t, "fakebutton", added.Type)
+
+	list, err := client.DevicesListCtx(ctx, b.BusID())
+	require.NoError(t, err)
+	require.Len(t, list.Devices, 1)
+	assert.Equal(t, "fakebutton", list.Devices[0].Type)
+
+	usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := usbipClient.AttachDevice(fmt.Sprintf("%d-%s", added.BusID, added.DevId))
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+
+	_, err = stream.Write([]byte{0x01, 0x02})
+	require.NoError(t, err)
+	got, err := usbipClient.PollInputReport(imp.Conn, []byte{0x01, 0x02}, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x01, 0x02}, got)
+
+	outCh, errCh := stream.StartReadingOutputs(ctx, "fakebutton", 1)
+	require.NoError(t, usbipClient.Submit(imp.Conn, usbip.DirOut, 2, []byte{0x0a, 0x0b}, nil))
+	select {
+	case msg := <-outCh:
+		assert.Equal(t, []byte{0x0a, 0x0b}, msg.(*fakeFeedback).data)
+	case err := <-errCh:
+		t.Fatalf("read feedback: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("no feedback")
+	}
+}
@@ -0,0 +1,190 @@
+// Package replay defines the VIIPER device recording format and a Player
+// that feeds recorded input states back into a device stream.
+//
+// A recording starts with a header (magic, version, device type, start time)
+// followed by records, each holding the offset from the start, the record
+// kind and the raw wire bytes. All integers are little-endian.
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Magic identifies a VIIPER recording.
+const Magic = "VRPL"
+
+// Version is the recording format version written by Writer.
+const Version = 1
+
+// maxRecordSize bounds a single record so corrupt files fail fast.
+const maxRecordSize = 1 << 20
+
+// Kind tells which direction of the device stream a record was taken from.
+type Kind uint8
+
+const (
+	// KindInput is a client-to-server input state.
+	KindInput Kind = 1
+	// KindFeedback is a server-to-client feedback message.
+	KindFeedback Kind = 2
+)
+
+// Header describes a recording.
+type Header struct {
+	DeviceType string
+	Start      time.Time
+}
+
+// Record is a single recorded wire message.
+type Record struct {
+	Offset time.Duration
+	Kind   Kind
+	Data   []byte
+}
+
+// ErrFormat is returned for data that is not a valid recording.
+var ErrFormat = errors.New("invalid recording")
+
+// Writer appends records to a recording.
+type Writer struct {
+	w   io.Writer
+	buf []byte
+	n   int64
+}
+
+// NewWriter writes the recording header to w and returns a Writer for its records.
+func NewWriter(w io.Writer, h Header) (*Writer, error) {
+	if len(h.DeviceType) > 0xff {
+		return nil, fmt.Errorf("device type too long: %d bytes", len(h.DeviceType))
+	}
+	buf := append([]byte(Magic), Version, byte(len(h.DeviceType)))
+	buf = append(buf, h.DeviceType...)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(h.Start.UnixNano()))
+	n, err := w.Write(buf)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, n: int64(n)}, nil
+}
+
+// Write appends a record.
+func (w *Writer) Write(r Record) error {
+	if len(r.Data) > maxRecordSize {
+		return fmt.Errorf("record too large: %d bytes", len(r.Data))
+	}
+	w.buf = binary.LittleEndian.AppendUint64(w.buf[:0], uint64(r.Offset))
+	w.buf = append(w.buf, byte(r.Kind))
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(len(r.Data)))
+	w.buf = append(w.buf, r.Data...)
+	n, err := w.w.Write(w.buf)
+	w.n += int64(n)
+	return err
+}
+
+// Size returns the number of bytes written so far, including the header.
+func (w *Writer) Size() int64 { return w.n }
+
+// Reader reads records from a recording.
+type Reader struct {
+	r      *bufio.Reader
+	header Header
+}
+
+// NewReader reads and validates the recording header.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	var fixed [len(Magic) + 2]byte
+	if _, err := io.ReadFull(br, fixed[:]); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrFormat, err)
+	}
+	if string(fixed[:len(Magic)]) != Magic {
+		return nil, fmt.Errorf("%w: bad magic", ErrFormat)
+	}
+	if v := fixed[len(Magic)]; v != Version {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrFormat, v)
+	}
+	rest := make([]byte, int(fixed[len(Magic)+1])+8)
+	if _, err := io.ReadFull(br, rest); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrFormat, err)
+	}
+	typeLen := len(rest) - 8
+	return &Reader{
+		r: br,
+		header: Header{
+			DeviceType: string(rest[:typeLen]),
+			Start:      time.Unix(0, int64(binary.LittleEndian.Uint64(rest[typeLen:]))),
+		},
+	}, nil
+}
+
+// Header returns the recording header.
+func (r *Reader) Header() Header { return r.header }
+
+// Next returns the next record, or io.EOF at the end of the recording.
+func (r *Reader) Next() (Record, error) {
+	var hdr [13]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("%w: truncated record", ErrFormat)
+		}
+		return Record{}, err
+	}
+	size := binary.LittleEndian.Uint32(hdr[9:])
+	if size > maxRecordSize {
+		return Record{}, fmt.Errorf("%w: record of %d bytes", ErrFormat, size)
+	}
+	rec := Record{
+		Offset: time.Duration(binary.LittleEndian.Uint64(hdr[:8])),
+		Kind:   Kind(hdr[8]),
+		Data:   make([]byte, size),
+	}
+	if _, err := io.ReadFull(r.r, rec.Data); err != nil {
+		return Record{}, fmt.Errorf("%w: truncated record", ErrFormat)
+	}
+	return rec, nil
+}
+
+// Player writes the input records of a recording to a device stream.
+type Player struct {
+	// Paced waits for each record's offset before writing it; otherwise all
+	// inputs are written back to back.
+	Paced bool
+}
+
+// Play writes every KindInput record read from r to w and returns the number
+// of records played.
+func (p *Player) Play(ctx context.Context, r *Reader, w io.Writer) (int, error) {
+	start := time.Now()
+	played := 0
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return played, nil
+		}
+		if err != nil {
+			return played, err
+		}
+		if rec.Kind != KindInput {
+			continue
+		}
+		if p.Paced {
+			if d := time.Until(start.Add(rec.Offset)); d > 0 {
+				select {
+				case <-ctx.Done():
+					return played, ctx.Err()
+				case <-time.After(d):
+				}
+			}
+		}
+		if _, err := w.Write(rec.Data); err != nil {
+			return played, err
+		}
+		played++
+	}
+}
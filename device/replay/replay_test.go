@@ -0,0 +1,58 @@
+package replay_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/device/replay"
+)
+
+func TestRoundTrip(t *testing.T) {
+	start := time.Unix(1700000000, 123)
+	records := []replay.Record{
+		{Offset: 0, Kind: replay.KindInput, Data: []byte{1, 2, 3}},
+		{Offset: 5 * time.Millisecond, Kind: replay.KindFeedback, Data: []byte{0xff, 0}},
+		{Offset: 9 * time.Millisecond, Kind: replay.KindInput, Data: []byte{}},
+	}
+
+	var buf bytes.Buffer
+	w, err := replay.NewWriter(&buf, replay.Header{DeviceType: "xbox360", Start: start})
+	require.NoError(t, err)
+	for _, r := range records {
+		require.NoError(t, w.Write(r))
+	}
+	assert.Equal(t, int64(buf.Len()), w.Size())
+
+	r, err := replay.NewReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "xbox360", r.Header().DeviceType)
+	assert.True(t, start.Equal(r.Header().Start))
+	for _, want := range records {
+		got, err := r.Next()
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err = r.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReaderRejectsInvalidData(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := replay.NewWriter(&buf, replay.Header{DeviceType: "mouse"})
+	require.NoError(t, err)
+	require.NoError(t, w.Write(replay.Record{Kind: replay.KindInput, Data: []byte{1, 2, 3, 4}}))
+	valid := buf.Bytes()
+
+	_, err = replay.NewReader(bytes.NewReader([]byte("NOPE\x01\x00")))
+	assert.ErrorIs(t, err, replay.ErrFormat)
+
+	r, err := replay.NewReader(bytes.NewReader(valid[:len(valid)-1]))
+	require.NoError(t, err)
+	_, err = r.Next()
+	assert.ErrorIs(t, err, replay.ErrFormat)
+}
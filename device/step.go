@@ -0,0 +1,145 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// MaxStepQueue bounds the input states a deterministic device holds.
+const MaxStepQueue = 1 << 16
+
+// ErrStepQueueFull is returned by Stepper.Push once MaxStepQueue states wait.
+var ErrStepQueueFull = errors.New("deterministic input queue is full")
+
+// DeterministicConfig makes a device report queued input states strictly in
+// order, one per step, instead of whatever state was last streamed when the
+// host polls. The zero value disables it.
+type DeterministicConfig struct {
+	Enabled bool
+	// Manual advances only on explicit steps; otherwise every host poll of
+	// the input endpoint takes the next state, waiting for one if none is
+	// queued.
+	Manual bool
+}
+
+// Steppable is implemented by device types that support deterministic mode
+// through CreateOptions.Deterministic.
+type Steppable interface {
+	Stepper() *Stepper
+}
+
+// Stepper queues the input states of a device in deterministic mode. The
+// zero value is ready to use and disabled.
+type Stepper struct {
+	mu    sync.Mutex
+	cfg   DeterministicConfig
+	queue []func()
+	// wake is closed and replaced whenever a state is queued or the
+	// configuration changes, releasing Poll.
+	wake chan struct{}
+}
+
+// Configure replaces the configuration. Turning deterministic mode off
+// applies the states still queued, in order.
+func (s *Stepper) Configure(cfg DeterministicConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+	if !cfg.Enabled {
+		s.stepLocked(len(s.queue))
+	}
+	s.notifyLocked()
+}
+
+// Config returns the configuration and whether deterministic mode is on.
+func (s *Stepper) Config() (DeterministicConfig, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg, s.cfg.Enabled
+}
+
+// Active reports whether input must go through Push.
+func (s *Stepper) Active() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg.Enabled
+}
+
+// Push queues latch, which applies one input state, for a later step.
+func (s *Stepper) Push(latch func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.cfg.Enabled {
+		latch()
+		return nil
+	}
+	if len(s.queue) >= MaxStepQueue {
+		return ErrStepQueueFull
+	}
+	s.queue = append(s.queue, latch)
+	s.notifyLocked()
+	return nil
+}
+
+// Step applies up to n queued states and returns how many it applied.
+func (s *Stepper) Step(n int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stepLocked(n)
+}
+
+// Queued returns the number of states waiting for a step.
+func (s *Stepper) Queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue)
+}
+
+// Poll is called before the host reads the input endpoint. Unless stepping
+// is manual or deterministic mode is off, it applies the next queued state,
+// waiting for one until ctx is done.
+func (s *Stepper) Poll(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		if !s.cfg.Enabled || s.cfg.Manual {
+			s.mu.Unlock()
+			return nil
+		}
+		if s.stepLocked(1) == 1 {
+			s.mu.Unlock()
+			return nil
+		}
+		if s.wake == nil {
+			s.wake = make(chan struct{})
+		}
+		wake := s.wake
+		s.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Stepper) stepLocked(n int) int {
+	n = min(n, len(s.queue))
+	for i := range n {
+		s.queue[i]()
+		s.queue[i] = nil
+	}
+	s.queue = s.queue[n:]
+	if len(s.queue) == 0 {
+		s.queue = nil
+	}
+	return n
+}
+
+func (s *Stepper) notifyLocked() {
+	if s.wake != nil {
+		close(s.wake)
+		s.wake = nil
+	}
+}
@@ -0,0 +1,69 @@
+package device_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/device"
+)
+
+func TestStepper(t *testing.T) {
+	var s device.Stepper
+	var applied []int
+	push := func(v int) { require.NoError(t, s.Push(func() { applied = append(applied, v) })) }
+
+	push(0)
+	assert.Equal(t, []int{0}, applied, "applied at once while disabled")
+
+	s.Configure(device.DeterministicConfig{Enabled: true})
+	push(1)
+	push(2)
+	push(3)
+	assert.Equal(t, 3, s.Queued())
+	require.NoError(t, s.Poll(context.Background()))
+	assert.Equal(t, []int{0, 1}, applied, "a poll takes one state")
+	assert.Equal(t, 1, s.Step(1))
+
+	s.Configure(device.DeterministicConfig{Enabled: true, Manual: true})
+	require.NoError(t, s.Poll(context.Background()))
+	assert.Equal(t, []int{0, 1, 2}, applied, "polls do not step manual devices")
+
+	s.Configure(device.DeterministicConfig{})
+	assert.Equal(t, []int{0, 1, 2, 3}, applied, "turning it off applies the queue")
+	assert.Zero(t, s.Queued())
+}
+
+func TestStepperPollWaits(t *testing.T) {
+	var s device.Stepper
+	s.Configure(device.DeterministicConfig{Enabled: true})
+
+	done := make(chan error, 1)
+	applied := make(chan struct{})
+	go func() { done <- s.Poll(context.Background()) }()
+	select {
+	case <-done:
+		t.Fatal("poll returned without a queued state")
+	case <-time.After(20 * time.Millisecond):
+	}
+	require.NoError(t, s.Push(func() { close(applied) }))
+	require.NoError(t, <-done)
+	<-applied
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- s.Poll(ctx) }()
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestStepperQueueLimit(t *testing.T) {
+	var s device.Stepper
+	s.Configure(device.DeterministicConfig{Enabled: true, Manual: true})
+	for range device.MaxStepQueue {
+		require.NoError(t, s.Push(func() {}))
+	}
+	assert.ErrorIs(t, s.Push(func() {}), device.ErrStepQueueFull)
+}
@@ -0,0 +1,92 @@
+package switchpro
+
+const (
+	DefaultVID = 0x057E
+	DefaultPID = 0x2009
+)
+
+const (
+	EndpointIn  = 0x81
+	EndpointOut = 0x01
+)
+
+// Input report IDs.
+const (
+	ReportIDStandardFull    = 0x30
+	ReportIDSubcommandReply = 0x21
+	ReportIDUSBReply        = 0x81
+)
+
+// Output report IDs.
+const (
+	ReportIDRumbleSubcommand = 0x01
+	ReportIDRumble           = 0x10
+	ReportIDUSBCommand       = 0x80
+)
+
+const InputReportSize = 64
+
+// Button bitmasks. The low three bytes follow the button bytes of the
+// standard input report: right side, shared, left side.
+const (
+	ButtonY  uint32 = 0x000001
+	ButtonX  uint32 = 0x000002
+	ButtonB  uint32 = 0x000004
+	ButtonA  uint32 = 0x000008
+	ButtonR  uint32 = 0x000040
+	ButtonZR uint32 = 0x000080
+
+	ButtonMinus   uint32 = 0x000100
+	ButtonPlus    uint32 = 0x000200
+	ButtonRStick  uint32 = 0x000400
+	ButtonLStick  uint32 = 0x000800
+	ButtonHome    uint32 = 0x001000
+	ButtonCapture uint32 = 0x002000
+
+	ButtonDPadDown  uint32 = 0x010000
+	ButtonDPadUp    uint32 = 0x020000
+	ButtonDPadRight uint32 = 0x040000
+	ButtonDPadLeft  uint32 = 0x080000
+	ButtonL         uint32 = 0x400000
+	ButtonZL        uint32 = 0x800000
+)
+
+// Gyro and accel fields of InputState use the same fixed-point physical
+// units as the DualShock 4: °/s scaled by GyroCountsPerDps and m/s² scaled by
+// AccelCountsPerMS2. The device converts them to the controller's own
+// sensor counts.
+const (
+	GyroCountsPerDps   = 16.0
+	AccelCountsPerMS2  = 512.0
+	StandardGravityMS2 = 9.81
+)
+
+// DefaultAccelZRaw is the accel Z value of a controller lying flat on a
+// table: 9.81 m/s² * AccelCountsPerMS2.
+const DefaultAccelZRaw int16 = 5023
+
+// Subcommands of the 0x01 output report the device answers.
+const (
+	SubcmdBluetoothPairing = 0x01
+	SubcmdDeviceInfo       = 0x02
+	SubcmdInputMode        = 0x03
+	SubcmdTriggerElapsed   = 0x04
+	SubcmdShipmentMode     = 0x08
+	SubcmdSPIRead          = 0x10
+	SubcmdMCUConfig        = 0x21
+	SubcmdMCUState         = 0x22
+	SubcmdPlayerLights     = 0x30
+	SubcmdHomeLight        = 0x38
+	SubcmdEnableIMU        = 0x40
+	SubcmdIMUSensitivity   = 0x41
+	SubcmdEnableVibration  = 0x48
+)
+
+// Commands of the USB-only 0x80 output report.
+const (
+	USBCmdStatus    = 0x01
+	USBCmdHandshake = 0x02
+	USBCmdBaudrate  = 0x03
+	USBCmdHIDOnly   = 0x04
+	USBCmdTimeout   = 0x05
+)
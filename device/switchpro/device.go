@@ -0,0 +1,475 @@
+package switchpro
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usb/hid"
+	"github.com/Alia5/VIIPER/usbip"
+)
+
+// maxPendingReplies bounds the replies waiting for the host to read the IN
+// endpoint; a host that never reads them loses the oldest.
+const maxPendingReplies = 16
+
+type SwitchPro struct {
+	inputState InputState
+	stateMu    sync.Mutex
+	descriptor usb.Descriptor
+	mac        [6]byte
+	playerSlot int
+
+	// Guarded by stateMu.
+	replies      [][]byte
+	imuEnabled   bool
+	playerLights uint8
+	rumble       OutputState
+	outputFunc   func(OutputState)
+	inputChanged bool // since the last standard input report
+
+	notify usb.Notifier
+	timer  atomic.Uint32
+
+	degrade device.Degrader
+	step    device.Stepper
+}
+
+func New(o *device.CreateOptions) (*SwitchPro, error) {
+	d := &SwitchPro{
+		descriptor:   defaultDescriptor,
+		inputState:   InputState{AccelZ: DefaultAccelZRaw},
+		inputChanged: true,
+	}
+	// A locally administered address; hosts tell controllers apart by it.
+	_, _ = rand.Read(d.mac[:])
+	d.mac[0] = d.mac[0]&^0x01 | 0x02
+	if o != nil {
+		if err := o.ApplyIdentity(&d.descriptor); err != nil {
+			return nil, err
+		}
+		if o.PlayerSlot != nil {
+			d.playerSlot = *o.PlayerSlot
+		}
+		if o.Deterministic != nil {
+			d.step.Configure(*o.Deterministic)
+		}
+	}
+	return d, nil
+}
+
+func (d *SwitchPro) SetOutputCallback(f func(OutputState)) {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	d.outputFunc = f
+}
+
+// PlayerSlot returns the player slot the device was created with.
+// It is informational only; the host sets the player lights.
+func (d *SwitchPro) PlayerSlot() int {
+	return d.playerSlot
+}
+
+// PlayerLights returns the player lights last set by the host: bits 0-3
+// are lit lights, bits 4-7 flashing ones.
+func (d *SwitchPro) PlayerLights() uint8 {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	return d.playerLights
+}
+
+// Degrader returns the link degradation simulator applied to streamed input.
+func (d *SwitchPro) Degrader() *device.Degrader {
+	return &d.degrade
+}
+
+// Stepper returns the queue of streamed states in deterministic mode.
+func (d *SwitchPro) Stepper() *device.Stepper {
+	return &d.step
+}
+
+func (d *SwitchPro) UpdateInputState(state InputState) {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	d.inputState = state
+	d.inputChanged = true
+	d.notify.Notify()
+}
+
+// ResetInputState releases all buttons, centers the sticks and leaves the
+// controller lying flat.
+func (d *SwitchPro) ResetInputState() {
+	d.UpdateInputState(InputState{AccelZ: DefaultAccelZRaw})
+}
+
+// HandleTransfer serves the interrupt endpoints. IN returns the next pending
+// command reply, otherwise a standard full input report; unlike the real
+// controller, reports flow before the host finishes the USB handshake.
+func (d *SwitchPro) HandleTransfer(ep uint32, dir uint32, out []byte) []byte {
+	if ep != 1 {
+		return nil
+	}
+	if dir == usbip.DirIn {
+		d.stateMu.Lock()
+		defer d.stateMu.Unlock()
+		if len(d.replies) > 0 {
+			r := d.replies[0]
+			d.replies = d.replies[1:]
+			return r
+		}
+		d.inputChanged = false
+		return d.inputReportLocked(ReportIDStandardFull)
+	}
+	d.handleOutput(out)
+	return nil
+}
+
+// HandleTransferStatus holds polls of the IN endpoint while no reply is
+// pending and the input state is unchanged since the last report, see
+// usb.ErrPending, and stalls endpoints other than 1.
+func (d *SwitchPro) HandleTransferStatus(ep uint32, dir uint32, out []byte) ([]byte, error) {
+	if ep != 1 {
+		return nil, usb.StatusStall
+	}
+	if dir == usbip.DirIn {
+		d.stateMu.Lock()
+		idle := !d.inputChanged && len(d.replies) == 0
+		d.stateMu.Unlock()
+		if idle {
+			return nil, usb.ErrPending
+		}
+	}
+	return d.HandleTransfer(ep, dir, out), nil
+}
+
+// InputNotifier returns the notifier fired by UpdateInputState and queued
+// replies.
+func (d *SwitchPro) InputNotifier() *usb.Notifier {
+	return &d.notify
+}
+
+// HandleControl takes output reports sent with SET_REPORT.
+func (d *SwitchPro) HandleControl(bmRequestType, bRequest uint8, wValue, _ /* wIndex */, _ /* wLength */ uint16, data []byte) ([]byte, bool) {
+	const (
+		hidSetReport     = 0x09
+		reportTypeOutput = 0x02
+	)
+	if bmRequestType == 0x21 && bRequest == hidSetReport && uint8(wValue>>8) == reportTypeOutput {
+		d.handleOutput(data)
+		return nil, true
+	}
+	return nil, false
+}
+
+func (d *SwitchPro) handleOutput(out []byte) {
+	if len(out) < 2 {
+		return
+	}
+	switch out[0] {
+	case ReportIDUSBCommand:
+		d.handleUSBCommand(out[1])
+	case ReportIDRumble:
+		if len(out) >= 10 {
+			d.handleRumble(out[2:10])
+		}
+	case ReportIDRumbleSubcommand:
+		if len(out) >= 11 {
+			d.handleRumble(out[2:10])
+			d.handleSubcommand(out[10], out[11:])
+		}
+	}
+}
+
+func (d *SwitchPro) handleUSBCommand(cmd uint8) {
+	switch cmd {
+	case USBCmdStatus:
+		// Controller type, then the MAC address little endian.
+		r := []byte{ReportIDUSBReply, cmd, 0x00, 0x03}
+		for i := range d.mac {
+			r = append(r, d.mac[len(d.mac)-1-i])
+		}
+		d.queueReply(r)
+	case USBCmdHandshake, USBCmdBaudrate:
+		d.queueReply([]byte{ReportIDUSBReply, cmd})
+	}
+}
+
+func (d *SwitchPro) handleRumble(data []byte) {
+	st := OutputState{Left: decodeRumble(data[0:4]), Right: decodeRumble(data[4:8])}
+	d.stateMu.Lock()
+	changed := st != d.rumble
+	d.rumble = st
+	outputFunc := d.outputFunc
+	d.stateMu.Unlock()
+	if changed && outputFunc != nil {
+		outputFunc(st)
+	}
+}
+
+// handleSubcommand answers a subcommand with a 0x21 report: an ACK byte
+// (0x80, with the reply data type in the low bits), the subcommand and
+// its reply data.
+func (d *SwitchPro) handleSubcommand(cmd uint8, args []byte) {
+	ack := uint8(0x80)
+	var data []byte
+	switch cmd {
+	case SubcmdDeviceInfo:
+		// Firmware 3.72, Pro Controller, MAC, colors in SPI.
+		ack = 0x82
+		data = append([]byte{0x03, 0x48, 0x03, 0x02}, d.mac[:]...)
+		data = append(data, 0x01, 0x01)
+	case SubcmdTriggerElapsed:
+		ack = 0x83
+		data = make([]byte, 14)
+	case SubcmdSPIRead:
+		if len(args) < 5 {
+			break
+		}
+		addr := binary.LittleEndian.Uint32(args[0:4])
+		size := min(args[4], spiMaxRead)
+		ack = 0x90
+		data = append([]byte{args[0], args[1], args[2], args[3], size}, spiRead(addr, size)...)
+	case SubcmdMCUConfig:
+		ack = 0xA0
+		data = make([]byte, 34)
+		copy(data, []byte{0x01, 0x00, 0xFF, 0x00, 0x08, 0x00, 0x1B, 0x01})
+		data[33] = 0xC8 // CRC-8 of the MCU reply
+	case SubcmdPlayerLights:
+		if len(args) > 0 {
+			d.stateMu.Lock()
+			d.playerLights = args[0]
+			d.stateMu.Unlock()
+		}
+	case SubcmdEnableIMU:
+		if len(args) > 0 {
+			d.stateMu.Lock()
+			d.imuEnabled = args[0] != 0
+			d.stateMu.Unlock()
+		}
+	case SubcmdBluetoothPairing, SubcmdInputMode, SubcmdShipmentMode, SubcmdMCUState,
+		SubcmdHomeLight, SubcmdIMUSensitivity, SubcmdEnableVibration:
+	default:
+		slog.Debug("Unsupported subcommand", "subcommand", cmd)
+	}
+
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	r := d.inputReportLocked(ReportIDSubcommandReply)
+	r[13] = ack
+	r[14] = cmd
+	copy(r[15:], data)
+	d.queueReplyLocked(r)
+}
+
+func (d *SwitchPro) queueReply(r []byte) {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	d.queueReplyLocked(r)
+}
+
+func (d *SwitchPro) queueReplyLocked(r []byte) {
+	if len(d.replies) >= maxPendingReplies {
+		d.replies = d.replies[1:]
+	}
+	d.replies = append(d.replies, r)
+	d.notify.Notify()
+}
+
+// inputReportLocked builds an input report with the current state: timer,
+// battery and connection, buttons and sticks, and for the standard full
+// report three IMU samples if the host enabled the IMU.
+func (d *SwitchPro) inputReportLocked(id uint8) []byte {
+	s := d.inputState
+	b := make([]byte, InputReportSize)
+	b[0] = id
+	b[1] = uint8(d.timer.Add(1))
+	b[2] = 0x81 // battery full, USB powered
+	b[3] = uint8(s.Buttons)
+	b[4] = uint8(s.Buttons >> 8)
+	b[5] = uint8(s.Buttons >> 16)
+	packStick(b[6:9], stickRaw(s.LX), stickRaw(s.LY))
+	packStick(b[9:12], stickRaw(s.RX), stickRaw(s.RY))
+
+	if id == ReportIDStandardFull && d.imuEnabled {
+		for i := range 3 {
+			p := b[13+12*i:]
+			for j, v := range []int16{
+				imuAccel(s.AccelX), imuAccel(s.AccelY), imuAccel(s.AccelZ),
+				imuGyro(s.GyroX), imuGyro(s.GyroY), imuGyro(s.GyroZ),
+			} {
+				binary.LittleEndian.PutUint16(p[2*j:], uint16(v))
+			}
+		}
+	}
+	return b
+}
+
+func (d *SwitchPro) GetDescriptor() *usb.Descriptor {
+	return &d.descriptor
+}
+
+func (d *SwitchPro) GetDeviceSpecificArgs() map[string]any {
+	return map[string]any{}
+}
+
+var defaultDescriptor = usb.Descriptor{
+	Device: usb.DeviceDescriptor{
+		BcdUSB:             0x0200,
+		BDeviceClass:       0x00,
+		BDeviceSubClass:    0x00,
+		BDeviceProtocol:    0x00,
+		BMaxPacketSize0:    0x40,
+		IDVendor:           DefaultVID,
+		IDProduct:          DefaultPID,
+		BcdDevice:          0x0200,
+		IManufacturer:      0x01,
+		IProduct:           0x02,
+		ISerialNumber:      0x03,
+		BNumConfigurations: 0x01,
+		Speed:              2,
+	},
+	Interfaces: []usb.InterfaceConfig{
+		{
+			Descriptor: usb.InterfaceDescriptor{
+				BInterfaceNumber:   0x00,
+				BAlternateSetting:  0x00,
+				BNumEndpoints:      0x02,
+				BInterfaceClass:    0x03,
+				BInterfaceSubClass: 0x00,
+				BInterfaceProtocol: 0x00,
+				IInterface:         0x00,
+			},
+			HID: &usb.HIDFunction{
+				Descriptor: usb.HIDDescriptor{
+					BcdHID:       0x0111,
+					BCountryCode: 0x00,
+					Descriptors: []usb.HIDSubDescriptor{
+						{Type: usb.ReportDescType},
+					},
+				},
+				Report: hid.Report{
+					Items: []hid.Item{
+						hid.UsagePage{Page: hid.UsagePageGenericDesktop},
+						hid.LogicalMinimum{Min: 0},
+						hid.Usage{Usage: hid.UsageJoystick},
+						hid.Collection{Kind: hid.CollectionApplication, Items: []hid.Item{
+							hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x08, Data: hid.Data{ReportIDStandardFull}},
+							hid.UsagePage{Page: hid.UsagePageGenericDesktop},
+							hid.UsagePage{Page: hid.UsagePageButton},
+							hid.UsageMinimum{Min: 0x01},
+							hid.UsageMaximum{Max: 0x0A},
+							hid.LogicalMinimum{Min: 0},
+							hid.LogicalMaximum{Max: 1},
+							hid.ReportSize{Bits: 1},
+							hid.ReportCount{Count: 10},
+							hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x5, Data: hid.Data{0x00}},
+							hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x6, Data: hid.Data{0x00}},
+							hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+							hid.UsagePage{Page: hid.UsagePageButton},
+							hid.UsageMinimum{Min: 0x0B},
+							hid.UsageMaximum{Max: 0x0E},
+							hid.LogicalMinimum{Min: 0},
+							hid.LogicalMaximum{Max: 1},
+							hid.ReportSize{Bits: 1},
+							hid.ReportCount{Count: 4},
+							hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+							hid.ReportSize{Bits: 1},
+							hid.ReportCount{Count: 2},
+							hid.Input{Flags: hid.MainConst | hid.MainVar | hid.MainAbs},
+
+							hid.AnyItem{Type: hid.ItemTypeLocal, Tag: 0x0, Data: hid.Data{0x01, 0x00, 0x01, 0x00}},
+							hid.Collection{Kind: hid.CollectionPhysical, Items: []hid.Item{
+								hid.AnyItem{Type: hid.ItemTypeLocal, Tag: 0x0, Data: hid.Data{0x30, 0x00, 0x01, 0x00}},
+								hid.AnyItem{Type: hid.ItemTypeLocal, Tag: 0x0, Data: hid.Data{0x31, 0x00, 0x01, 0x00}},
+								hid.AnyItem{Type: hid.ItemTypeLocal, Tag: 0x0, Data: hid.Data{0x32, 0x00, 0x01, 0x00}},
+								hid.AnyItem{Type: hid.ItemTypeLocal, Tag: 0x0, Data: hid.Data{0x35, 0x00, 0x01, 0x00}},
+								hid.LogicalMinimum{Min: 0},
+								hid.LogicalMaximum{Max: 0xFFFF},
+								hid.ReportSize{Bits: 16},
+								hid.ReportCount{Count: 4},
+								hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+							}},
+
+							hid.AnyItem{Type: hid.ItemTypeLocal, Tag: 0x0, Data: hid.Data{0x39, 0x00, 0x01, 0x00}},
+							hid.LogicalMinimum{Min: 0},
+							hid.LogicalMaximum{Max: 7},
+							hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x3, Data: hid.Data{0x00}},
+							hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x4, Data: hid.Data{0x3B, 0x01}},
+							hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x6, Data: hid.Data{0x14}},
+							hid.ReportSize{Bits: 4},
+							hid.ReportCount{Count: 1},
+							hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+							hid.UsagePage{Page: hid.UsagePageButton},
+							hid.UsageMinimum{Min: 0x0F},
+							hid.UsageMaximum{Max: 0x12},
+							hid.LogicalMinimum{Min: 0},
+							hid.LogicalMaximum{Max: 1},
+							hid.ReportSize{Bits: 1},
+							hid.ReportCount{Count: 4},
+							hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+							hid.ReportSize{Bits: 8},
+							hid.ReportCount{Count: 52},
+							hid.Input{Flags: hid.MainConst | hid.MainVar | hid.MainAbs},
+
+							hid.UsagePage{Page: 0xFF00},
+							hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x08, Data: hid.Data{ReportIDSubcommandReply}},
+							hid.Usage{Usage: 0x01},
+							hid.ReportSize{Bits: 8},
+							hid.ReportCount{Count: 63},
+							hid.Input{Flags: hid.MainConst | hid.MainVar | hid.MainAbs},
+							hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x08, Data: hid.Data{ReportIDUSBReply}},
+							hid.Usage{Usage: 0x02},
+							hid.ReportSize{Bits: 8},
+							hid.ReportCount{Count: 63},
+							hid.Input{Flags: hid.MainConst | hid.MainVar | hid.MainAbs},
+							hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x08, Data: hid.Data{ReportIDRumbleSubcommand}},
+							hid.Usage{Usage: 0x03},
+							hid.ReportSize{Bits: 8},
+							hid.ReportCount{Count: 63},
+							hid.Output{Flags: hid.MainConst | hid.MainVar | hid.MainAbs | hid.MainVolatile},
+							hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x08, Data: hid.Data{ReportIDRumble}},
+							hid.Usage{Usage: 0x04},
+							hid.ReportSize{Bits: 8},
+							hid.ReportCount{Count: 63},
+							hid.Output{Flags: hid.MainConst | hid.MainVar | hid.MainAbs | hid.MainVolatile},
+							hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x08, Data: hid.Data{ReportIDUSBCommand}},
+							hid.Usage{Usage: 0x05},
+							hid.ReportSize{Bits: 8},
+							hid.ReportCount{Count: 63},
+							hid.Output{Flags: hid.MainConst | hid.MainVar | hid.MainAbs | hid.MainVolatile},
+							hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x08, Data: hid.Data{0x82}},
+							hid.Usage{Usage: 0x06},
+							hid.ReportSize{Bits: 8},
+							hid.ReportCount{Count: 63},
+							hid.Output{Flags: hid.MainConst | hid.MainVar | hid.MainAbs | hid.MainVolatile},
+						}},
+					},
+				},
+			},
+			Endpoints: []usb.EndpointDescriptor{
+				{
+					BEndpointAddress: EndpointIn,
+					BMAttributes:     0x03,
+					WMaxPacketSize:   64,
+					BInterval:        8,
+				},
+				{
+					BEndpointAddress: EndpointOut,
+					BMAttributes:     0x03,
+					WMaxPacketSize:   64,
+					BInterval:        8,
+				},
+			},
+		},
+	},
+	Strings: map[uint8]string{
+		0: "\x04\x09",
+		1: "Nintendo Co., Ltd.",
+		2: "Pro Controller",
+		3: "000000000001",
+	},
+}
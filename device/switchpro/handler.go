@@ -0,0 +1,94 @@
+package switchpro
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+func init() {
+	api.RegisterDevice("switchpro", &handler{})
+	device.RegisterOutputDecoder("switchpro", device.OutputDecoder{
+		Size: OutputLayout.Size(),
+		New:  func() encoding.BinaryUnmarshaler { return new(OutputState) },
+	})
+}
+
+type handler struct{}
+
+func (h *handler) CreateDevice(o *device.CreateOptions) (usb.Device, error) { return New(o) }
+
+func (h *handler) InputLayout(usb.Device) device.WireLayout { return InputLayout }
+
+func (h *handler) OutputLayout(usb.Device) device.WireLayout { return OutputLayout }
+
+func (h *handler) MacroState(dev usb.Device, state []byte) (func(), error) {
+	pro, ok := dev.(*SwitchPro)
+	if !ok {
+		return nil, fmt.Errorf("device is not switchpro")
+	}
+	var st InputState
+	if err := json.Unmarshal(state, &st); err != nil {
+		return nil, err
+	}
+	return func() { pro.UpdateInputState(st) }, nil
+}
+
+func (h *handler) StreamHandler() api.StreamHandlerFunc {
+	return func(conn net.Conn, devPtr *usb.Device, logger *slog.Logger) error {
+		if devPtr == nil || *devPtr == nil {
+			return fmt.Errorf("nil device")
+		}
+		pro, ok := (*devPtr).(*SwitchPro)
+		if !ok {
+			return fmt.Errorf("device is not switchpro")
+		}
+
+		pro.SetOutputCallback(func(rumble OutputState) {
+			data, err := rumble.MarshalBinary()
+			if err != nil {
+				logger.Error("failed to marshal rumble", "error", err)
+				return
+			}
+			if _, err := conn.Write(data); err != nil {
+				logger.Error("failed to send rumble", "error", err)
+			}
+		})
+
+		buf := make([]byte, 24)
+		for {
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				if err == io.EOF {
+					logger.Info("client disconnected")
+					return nil
+				}
+				return fmt.Errorf("read input state: %w", err)
+			}
+
+			var state InputState
+			if err := state.UnmarshalBinary(buf); err != nil {
+				return fmt.Errorf("unmarshal input state: %w", err)
+			}
+			if pro.step.Active() {
+				st := state
+				if err := pro.step.Push(func() { pro.UpdateInputState(st) }); err != nil {
+					return err
+				}
+				continue
+			}
+			if pro.degrade.Active() {
+				st := state
+				pro.degrade.Apply(func() { pro.UpdateInputState(st) })
+				continue
+			}
+			pro.UpdateInputState(state)
+		}
+	}
+}
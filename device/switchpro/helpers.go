@@ -0,0 +1,103 @@
+package switchpro
+
+import "math"
+
+// GyroDpsToRaw converts a gyro angular velocity value in degrees/second (°/s)
+// into the fixed-point raw int16 wire representation.
+func GyroDpsToRaw(dps float64) int16 {
+	return clampI16(math.Round(dps * GyroCountsPerDps))
+}
+
+// AccelMS2ToRaw converts an acceleration value in meters/second^2 (m/s²)
+// into the fixed-point raw int16 wire representation.
+func AccelMS2ToRaw(ms2 float64) int16 {
+	return clampI16(math.Round(ms2 * AccelCountsPerMS2))
+}
+
+// The factory IMU calibration the device reports: accel counts per g and
+// gyro counts per 936 °/s.
+const (
+	imuAccelCountsPerG  = 4096
+	imuGyroSensitivity  = 13371
+	imuGyroSensitiveDps = 936
+)
+
+// imuAccel converts a wire accel value into sensor counts.
+func imuAccel(raw int16) int16 {
+	return clampI16(math.Round(float64(raw) / AccelCountsPerMS2 / StandardGravityMS2 * imuAccelCountsPerG))
+}
+
+// imuGyro converts a wire gyro value into sensor counts.
+func imuGyro(raw int16) int16 {
+	return clampI16(math.Round(float64(raw) / GyroCountsPerDps * imuGyroSensitivity / imuGyroSensitiveDps))
+}
+
+// Sticks report 12-bit values around stickCenter; the factory calibration
+// declares stickRange in either direction.
+const (
+	stickCenter = 0x800
+	stickRange  = 0x600
+)
+
+// stickRaw maps a signed 16-bit stick value onto the 12-bit report range.
+func stickRaw(v int16) uint16 {
+	return uint16(stickCenter + math.Round(float64(v)*stickRange/32768))
+}
+
+// packStick packs two 12-bit values into 3 bytes, as sticks and stick
+// calibration are stored.
+func packStick(b []byte, x, y uint16) {
+	b[0] = uint8(x)
+	b[1] = uint8(x>>8&0x0F) | uint8(y&0x0F)<<4
+	b[2] = uint8(y >> 4)
+}
+
+// decodeRumble decodes the 4 bytes of HD rumble data of one motor.
+// The high band frequency is stored as (f-0x60)*4 over 9 bits with its
+// amplitude in the upper 7 bits of byte 1; the low band frequency as f-0x40
+// in 7 bits, its amplitude halved plus 0x40 in byte 3 with the low bit in
+// bit 7 of byte 2. Encoded frequencies are 32 steps per octave from 10 Hz.
+func decodeRumble(b []byte) Rumble {
+	hf := uint16(b[0]) | uint16(b[1]&0x01)<<8
+	hfAmp := b[1] >> 1
+	lf := b[2] & 0x7F
+	lfAmp := (int(b[3])-0x40)*2 + int(b[2]>>7)
+	return Rumble{
+		HighFreq: rumbleFreq(float64(hf/4 + 0x60)),
+		HighAmp:  rumbleAmp(int(hfAmp)),
+		LowFreq:  rumbleFreq(float64(lf) + 0x40),
+		LowAmp:   rumbleAmp(lfAmp),
+	}
+}
+
+func rumbleFreq(encoded float64) uint16 {
+	return uint16(math.Round(10 * math.Exp2(encoded/32)))
+}
+
+// rumbleAmp maps an encoded amplitude (0-100) to 0-255, inverting the
+// piecewise logarithmic encoding; the smallest amplitudes are approximated
+// linearly.
+func rumbleAmp(encoded int) uint8 {
+	var amp float64
+	switch {
+	case encoded <= 0:
+		return 0
+	case encoded >= 32:
+		amp = math.Exp2(float64(encoded)/32) / 8.7
+	case encoded >= 16:
+		amp = math.Exp2(float64(encoded)/16) / 17
+	default:
+		amp = 0.12 * float64(encoded) / 16
+	}
+	return uint8(math.Round(min(amp, 1) * 255))
+}
+
+func clampI16(v float64) int16 {
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(v)
+}
@@ -0,0 +1,108 @@
+package switchpro
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/Alia5/VIIPER/device"
+)
+
+// InputState is the controller state streamed by clients. Sticks are signed
+// 16-bit values with up and right positive, like XInput's.
+// viiper:wire switchpro c2s buttons:u32 lx:i16 ly:i16 rx:i16 ry:i16 gyroX:i16 gyroY:i16 gyroZ:i16 accelX:i16 accelY:i16 accelZ:i16
+type InputState struct {
+	Buttons uint32
+	LX, LY  int16
+	RX, RY  int16
+
+	GyroX, GyroY, GyroZ    int16
+	AccelX, AccelY, AccelZ int16
+}
+
+// InputLayout is the field layout of the InputState wire format, used for delta updates.
+var InputLayout = device.WireLayout{
+	{Name: "buttons", Size: 4},
+	{Name: "lx", Size: 2},
+	{Name: "ly", Size: 2},
+	{Name: "rx", Size: 2},
+	{Name: "ry", Size: 2},
+	{Name: "gyroX", Size: 2},
+	{Name: "gyroY", Size: 2},
+	{Name: "gyroZ", Size: 2},
+	{Name: "accelX", Size: 2},
+	{Name: "accelY", Size: 2},
+	{Name: "accelZ", Size: 2},
+}
+
+func (s *InputState) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 24)
+	binary.LittleEndian.PutUint32(b[0:4], s.Buttons)
+	for i, v := range []int16{s.LX, s.LY, s.RX, s.RY, s.GyroX, s.GyroY, s.GyroZ, s.AccelX, s.AccelY, s.AccelZ} {
+		binary.LittleEndian.PutUint16(b[4+2*i:], uint16(v))
+	}
+	return b, nil
+}
+
+func (s *InputState) UnmarshalBinary(data []byte) error {
+	if len(data) < 24 {
+		return io.ErrUnexpectedEOF
+	}
+	s.Buttons = binary.LittleEndian.Uint32(data[0:4])
+	for i, v := range []*int16{&s.LX, &s.LY, &s.RX, &s.RY, &s.GyroX, &s.GyroY, &s.GyroZ, &s.AccelX, &s.AccelY, &s.AccelZ} {
+		*v = int16(binary.LittleEndian.Uint16(data[4+2*i:]))
+	}
+	return nil
+}
+
+// OutputLayout is the field layout of the OutputState wire format.
+var OutputLayout = device.WireLayout{
+	{Name: "leftHighFreq", Size: 2},
+	{Name: "leftHighAmp", Size: 1},
+	{Name: "leftLowFreq", Size: 2},
+	{Name: "leftLowAmp", Size: 1},
+	{Name: "rightHighFreq", Size: 2},
+	{Name: "rightHighAmp", Size: 1},
+	{Name: "rightLowFreq", Size: 2},
+	{Name: "rightLowAmp", Size: 1},
+}
+
+// OutputState is the HD rumble the host sent, decoded per motor into the
+// frequency and amplitude of its high and low band.
+// viiper:wire switchpro s2c leftHighFreq:u16 leftHighAmp:u8 leftLowFreq:u16 leftLowAmp:u8 rightHighFreq:u16 rightHighAmp:u8 rightLowFreq:u16 rightLowAmp:u8
+type OutputState struct {
+	Left, Right Rumble
+}
+
+// Rumble is the decoded HD rumble of one motor.
+type Rumble struct {
+	HighFreq uint16 // (Hz)
+	HighAmp  uint8  // (0-255)
+	LowFreq  uint16 // (Hz)
+	LowAmp   uint8  // (0-255)
+}
+
+func (f *OutputState) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 12)
+	for i, r := range []Rumble{f.Left, f.Right} {
+		p := b[6*i:]
+		binary.LittleEndian.PutUint16(p[0:2], r.HighFreq)
+		p[2] = r.HighAmp
+		binary.LittleEndian.PutUint16(p[3:5], r.LowFreq)
+		p[5] = r.LowAmp
+	}
+	return b, nil
+}
+
+func (f *OutputState) UnmarshalBinary(data []byte) error {
+	if len(data) < 12 {
+		return io.ErrUnexpectedEOF
+	}
+	for i, r := range []*Rumble{&f.Left, &f.Right} {
+		p := data[6*i:]
+		r.HighFreq = binary.LittleEndian.Uint16(p[0:2])
+		r.HighAmp = p[2]
+		r.LowFreq = binary.LittleEndian.Uint16(p[3:5])
+		r.LowAmp = p[5]
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package switchpro
+
+import "encoding/binary"
+
+// The SPI flash regions hosts read on connect. Everything else reads as
+// erased flash (0xFF), which includes the user calibration at 0x8010-0x803F:
+// hosts fall back to the factory calibration.
+const (
+	spiFactoryBase = 0x6000
+	spiFactorySize = 0xA0
+	spiMaxRead     = 0x1D
+)
+
+// factoryConfig is the factory configuration and calibration block of a Pro
+// Controller with neutral calibration.
+var factoryConfig = func() []byte {
+	b := make([]byte, spiFactorySize)
+	for i := range b {
+		b[i] = 0xFF
+	}
+	// 0x6012: device type; 0x601B: colors present in SPI.
+	b[0x12] = 0x03
+	b[0x1B] = 0x01
+
+	// 0x6020: IMU accel origin and sensitivity, gyro origin and sensitivity.
+	imu := b[0x20:0x38]
+	for i := range 3 {
+		binary.LittleEndian.PutUint16(imu[2*i:], 0)
+		binary.LittleEndian.PutUint16(imu[6+2*i:], 0x4000)
+		binary.LittleEndian.PutUint16(imu[12+2*i:], 0)
+		binary.LittleEndian.PutUint16(imu[18+2*i:], imuGyroSensitivity)
+	}
+
+	// 0x603D: left stick max above, center and min below center; right
+	// stick center, min below and max above center.
+	sticks := b[0x3D:0x4F]
+	for i, v := range []uint16{stickRange, stickCenter, stickRange, stickCenter, stickRange, stickRange} {
+		packStick(sticks[3*i:], v, v)
+	}
+
+	// 0x6050: body, button, left and right grip colors.
+	copy(b[0x50:], []byte{
+		0x32, 0x32, 0x32,
+		0xFF, 0xFF, 0xFF,
+		0x32, 0x32, 0x32,
+		0x32, 0x32, 0x32,
+	})
+
+	// 0x6080: IMU horizontal offsets, then the left and right stick
+	// parameters (dead zone and range ratio).
+	copy(b[0x80:], []byte{0x50, 0xFD, 0x00, 0x00, 0xC6, 0x0F})
+	stickParams := []byte{0x0F, 0x30, 0x61, 0x96, 0x30, 0xF3, 0xD4, 0x14, 0x54, 0x41, 0x15, 0x54, 0xC7, 0x79, 0x9C, 0x33, 0x36, 0x63}
+	copy(b[0x86:], stickParams)
+	copy(b[0x98:], stickParams)
+	return b
+}()
+
+// spiRead returns size bytes of flash at addr.
+func spiRead(addr uint32, size uint8) []byte {
+	out := make([]byte, size)
+	for i := range out {
+		a := addr + uint32(i)
+		if a >= spiFactoryBase && a < spiFactoryBase+spiFactorySize {
+			out[i] = factoryConfig[a-spiFactoryBase]
+		} else {
+			out[i] = 0xFF
+		}
+	}
+	return out
+}
@@ -0,0 +1,306 @@
+package switchpro_test
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/device/switchpro"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/Alia5/VIIPER/internal/registry" // Register devices
+	"github.com/Alia5/VIIPER/usb"
+)
+
+type testBench struct {
+	stream *apiclient.DeviceStream
+	usbip  *viiperTesting.TestUsbIpClient
+	conn   net.Conn
+	dev    *switchpro.SwitchPro
+}
+
+func newTestBench(t *testing.T, busID uint32) *testBench {
+	t.Helper()
+	s := viiperTesting.NewTestServerWithConfig(t, viiperTesting.TestServerConfig(t))
+	t.Cleanup(func() { s.UsbServer.Close() })
+	t.Cleanup(func() { s.ApiServer.Close() })
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(busID)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+
+	stream, dev, err := apiclient.New(s.ApiServer.Addr()).AddDeviceAndConnect(context.Background(), b.BusID(), "switchpro", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { stream.Close() })
+	assert.Equal(t, "0x057e", dev.Vid)
+	assert.Equal(t, "0x2009", dev.Pid)
+
+	client := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := client.AttachDevice(fmt.Sprintf("%d-%s", b.BusID(), dev.DevId))
+	require.NoError(t, err)
+	t.Cleanup(func() { imp.Conn.Close() })
+
+	tb := &testBench{stream: stream, usbip: client, conn: imp.Conn}
+	for _, m := range b.GetAllDeviceMetas() {
+		tb.dev = m.Dev.(*switchpro.SwitchPro)
+	}
+	return tb
+}
+
+// command sends an output report and returns the next input report with
+// the reply ID.
+func (tb *testBench) command(t *testing.T, out []byte, replyID uint8) []byte {
+	t.Helper()
+	require.NoError(t, tb.usbip.Submit(tb.conn, usbip.DirOut, 1, out, nil))
+	for range 8 {
+		r, err := tb.usbip.ReadInputReport(tb.conn)
+		require.NoError(t, err)
+		if r[0] == replyID {
+			return r
+		}
+	}
+	t.Fatalf("no 0x%02x reply to % x", replyID, out)
+	return nil
+}
+
+var neutralRumble = []byte{0x00, 0x01, 0x40, 0x40, 0x00, 0x01, 0x40, 0x40}
+
+func (tb *testBench) subcommand(t *testing.T, cmd uint8, args ...byte) []byte {
+	t.Helper()
+	out := append([]byte{switchpro.ReportIDRumbleSubcommand, 0x00}, neutralRumble...)
+	out = append(out, cmd)
+	out = append(out, args...)
+	r := tb.command(t, out, switchpro.ReportIDSubcommandReply)
+	require.Equal(t, cmd, r[14], "reply to subcommand")
+	return r
+}
+
+func TestInputReports(t *testing.T) {
+	cases := []struct {
+		name       string
+		inputState switchpro.InputState
+		// buttons and sticks, report bytes 3-11
+		expected []byte
+	}{
+		{
+			name:     "neutral",
+			expected: []byte{0x00, 0x00, 0x00, 0x00, 0x08, 0x80, 0x00, 0x08, 0x80},
+		},
+		{
+			name:       "face and shared buttons",
+			inputState: switchpro.InputState{Buttons: switchpro.ButtonA | switchpro.ButtonY | switchpro.ButtonHome | switchpro.ButtonPlus},
+			expected:   []byte{0x09, 0x12, 0x00, 0x00, 0x08, 0x80, 0x00, 0x08, 0x80},
+		},
+		{
+			name:       "left side buttons",
+			inputState: switchpro.InputState{Buttons: switchpro.ButtonDPadUp | switchpro.ButtonZL | switchpro.ButtonL},
+			expected:   []byte{0x00, 0x00, 0xC2, 0x00, 0x08, 0x80, 0x00, 0x08, 0x80},
+		},
+		{
+			name:       "sticks at the edges",
+			inputState: switchpro.InputState{LX: 32767, LY: -32768, RX: -32768, RY: 32767},
+			// 12-bit 0xE00/0x200 and 0x200/0xE00
+			expected: []byte{0x00, 0x00, 0x00, 0x00, 0x0E, 0x20, 0x00, 0x02, 0xE0},
+		},
+	}
+
+	tb := newTestBench(t, 90131)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, tb.stream.WriteBinary(&tc.inputState))
+			deadline := time.Now().Add(750 * time.Millisecond)
+			var got []byte
+			for time.Now().Before(deadline) {
+				r, err := tb.usbip.ReadInputReport(tb.conn)
+				require.NoError(t, err)
+				require.Len(t, r, switchpro.InputReportSize)
+				if r[0] == switchpro.ReportIDStandardFull {
+					if got = r[3:12]; assert.ObjectsAreEqual(tc.expected, got) {
+						break
+					}
+				}
+			}
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	tb := newTestBench(t, 90132)
+
+	status := tb.command(t, []byte{switchpro.ReportIDUSBCommand, switchpro.USBCmdStatus}, switchpro.ReportIDUSBReply)
+	assert.Equal(t, []byte{0x81, 0x01, 0x00, 0x03}, status[:4], "Pro Controller")
+	mac := status[4:10]
+	assert.Equal(t, []byte{0x81, 0x02}, tb.command(t, []byte{switchpro.ReportIDUSBCommand, switchpro.USBCmdHandshake}, switchpro.ReportIDUSBReply)[:2])
+	require.NoError(t, tb.usbip.Submit(tb.conn, usbip.DirOut, 1, []byte{switchpro.ReportIDUSBCommand, switchpro.USBCmdHIDOnly}, nil))
+
+	info := tb.subcommand(t, switchpro.SubcmdDeviceInfo)
+	assert.Equal(t, uint8(0x82), info[13])
+	assert.Equal(t, []byte{0x03, 0x48, 0x03, 0x02}, info[15:19], "firmware and controller type")
+	for i := range 6 {
+		assert.Equal(t, mac[5-i], info[19+i], "MAC byte %d", i)
+	}
+
+	assert.Equal(t, uint8(0x80), tb.subcommand(t, switchpro.SubcmdInputMode, 0x30)[13])
+
+	spiRead := func(addr uint32, size uint8) []byte {
+		args := binary.LittleEndian.AppendUint32(nil, addr)
+		r := tb.subcommand(t, switchpro.SubcmdSPIRead, append(args, size)...)
+		require.Equal(t, uint8(0x90), r[13])
+		require.Equal(t, append(args, size), r[15:20], "address and size echoed")
+		return r[20 : 20+int(size)]
+	}
+	imu := spiRead(0x6020, 0x18)
+	assert.Equal(t, []byte{0x00, 0x40}, imu[6:8], "accel sensitivity")
+	assert.Equal(t, []byte{0x3B, 0x34}, imu[18:20], "gyro sensitivity")
+	sticks := spiRead(0x603D, 0x12)
+	assert.Equal(t, []byte{0x00, 0x06, 0x60, 0x00, 0x08, 0x80, 0x00, 0x06, 0x60}, sticks[:9], "left stick max, center, min")
+	assert.Equal(t, []byte{0x00, 0x08, 0x80}, sticks[9:12], "right stick center first")
+	assert.Equal(t, []byte{0xFF, 0xFF}, spiRead(0x8010, 2), "no user calibration")
+
+	tb.subcommand(t, switchpro.SubcmdPlayerLights, 0x01)
+	assert.Equal(t, uint8(0x01), tb.dev.PlayerLights())
+
+	// IMU samples appear once the host enables the IMU.
+	state := switchpro.InputState{
+		GyroZ:  switchpro.GyroDpsToRaw(93.6),
+		AccelZ: switchpro.AccelMS2ToRaw(switchpro.StandardGravityMS2),
+	}
+	require.NoError(t, tb.stream.WriteBinary(&state))
+	tb.subcommand(t, switchpro.SubcmdEnableIMU, 0x01)
+	deadline := time.Now().Add(750 * time.Millisecond)
+	var sample []byte
+	for time.Now().Before(deadline) {
+		r, err := tb.usbip.ReadInputReport(tb.conn)
+		require.NoError(t, err)
+		if r[0] == switchpro.ReportIDStandardFull && binary.LittleEndian.Uint16(r[17:19]) != 0 {
+			sample = r[13:25]
+			break
+		}
+	}
+	require.NotNil(t, sample, "no IMU sample")
+	assert.InDelta(t, 4096, int16(binary.LittleEndian.Uint16(sample[4:6])), 2, "1 g")
+	assert.InDelta(t, 1337, int16(binary.LittleEndian.Uint16(sample[10:12])), 2, "93.6 °/s")
+}
+
+func TestRumble(t *testing.T) {
+	tb := newTestBench(t, 90133)
+
+	readRumble := func() switchpro.OutputState {
+		var buf [12]byte
+		_ = tb.stream.SetReadDeadline(time.Now().Add(750 * time.Millisecond))
+		_, err := io.ReadFull(tb.stream, buf[:])
+		require.NoError(t, err)
+		var out switchpro.OutputState
+		require.NoError(t, out.UnmarshalBinary(buf[:]))
+		return out
+	}
+
+	out := []byte{switchpro.ReportIDRumble, 0x00, 0x28, 0x88, 0x60, 0x61}
+	out = append(out, neutralRumble[4:]...)
+	require.NoError(t, tb.usbip.Submit(tb.conn, usbip.DirOut, 1, out, nil))
+	assert.Equal(t, switchpro.OutputState{
+		Left:  switchpro.Rumble{HighFreq: 99, HighAmp: 128, LowFreq: 320, LowAmp: 122},
+		Right: switchpro.Rumble{HighFreq: 320, LowFreq: 160},
+	}, readRumble())
+
+	// Rumble also rides along subcommands; unchanged rumble is not resent.
+	tb.subcommand(t, switchpro.SubcmdEnableVibration, 0x01)
+	require.NoError(t, tb.usbip.Submit(tb.conn, usbip.DirOut, 1, append([]byte{switchpro.ReportIDRumble, 0x01}, neutralRumble...), nil))
+	assert.Equal(t, switchpro.OutputState{
+		Left:  switchpro.Rumble{HighFreq: 320, LowFreq: 160},
+		Right: switchpro.Rumble{HighFreq: 320, LowFreq: 160},
+	}, readRumble())
+}
+
+// TestOutputCallbackConcurrent swaps the callback while rumble arrives, as
+// a stream attaching to a device the host already drives does; run it with
+// -race.
+func TestOutputCallbackConcurrent(t *testing.T) {
+	d, err := switchpro.New(nil)
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			d.SetOutputCallback(func(switchpro.OutputState) {})
+		}
+	}()
+	for i := range 100 {
+		d.HandleTransfer(1, usbip.DirOut, []byte{switchpro.ReportIDRumble, 0x00, byte(i), 0x88, 0x60, 0x61, 0x00, 0x01, 0x40, 0x40})
+	}
+	wg.Wait()
+}
+
+func TestWireRoundTrip(t *testing.T) {
+	in := switchpro.InputState{Buttons: switchpro.ButtonZR | switchpro.ButtonCapture, LX: -1, LY: 2, RX: -300, RY: 400, GyroX: 5, GyroY: -6, GyroZ: 7, AccelX: -8, AccelY: 9, AccelZ: -10}
+	b, err := in.MarshalBinary()
+	require.NoError(t, err)
+	require.Len(t, b, switchpro.InputLayout.Size())
+	var gotIn switchpro.InputState
+	require.NoError(t, gotIn.UnmarshalBinary(b))
+	assert.Equal(t, in, gotIn)
+
+	out := switchpro.OutputState{Left: switchpro.Rumble{HighFreq: 1253, HighAmp: 1, LowFreq: 41, LowAmp: 2}, Right: switchpro.Rumble{HighFreq: 3, HighAmp: 4, LowFreq: 5, LowAmp: 6}}
+	b, err = out.MarshalBinary()
+	require.NoError(t, err)
+	require.Len(t, b, switchpro.OutputLayout.Size())
+	var gotOut switchpro.OutputState
+	require.NoError(t, gotOut.UnmarshalBinary(b))
+	assert.Equal(t, out, gotOut)
+}
+
+func TestHeldInputReports(t *testing.T) {
+	d, err := switchpro.New(nil)
+	require.NoError(t, err)
+	var _ usb.PendingDevice = d
+
+	read := func() []byte {
+		t.Helper()
+		r, err := d.HandleTransferStatus(1, usbip.DirIn, nil)
+		require.NoError(t, err)
+		return r
+	}
+	assert.Equal(t, byte(switchpro.ReportIDStandardFull), read()[0], "the first report is not held")
+	_, err = d.HandleTransferStatus(1, usbip.DirIn, nil)
+	assert.ErrorIs(t, err, usb.ErrPending, "unchanged input is held")
+
+	wake := d.InputNotifier().Wait()
+	d.UpdateInputState(switchpro.InputState{Buttons: switchpro.ButtonA})
+	select {
+	case <-wake:
+	default:
+		t.Error("UpdateInputState does not wake held polls")
+	}
+	assert.Equal(t, byte(switchpro.ReportIDStandardFull), read()[0])
+
+	wake = d.InputNotifier().Wait()
+	_, err = d.HandleTransferStatus(1, usbip.DirOut, []byte{switchpro.ReportIDUSBCommand, switchpro.USBCmdStatus})
+	require.NoError(t, err)
+	select {
+	case <-wake:
+	default:
+		t.Error("a queued reply does not wake held polls")
+	}
+	assert.Equal(t, byte(switchpro.ReportIDUSBReply), read()[0])
+	_, err = d.HandleTransferStatus(1, usbip.DirIn, nil)
+	assert.ErrorIs(t, err, usb.ErrPending)
+}
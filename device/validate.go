@@ -0,0 +1,112 @@
+package device
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// ValueRange restricts the value of a little-endian integer wire field.
+// It mirrors the optional range annotation of a viiper:wire field spec,
+// e.g. "touch1X:u16:0..1920" or "mode:u8:0|2|4".
+type ValueRange struct {
+	Min, Max int64
+	// Valid, when non-empty, lists the only accepted values; Min and Max are ignored.
+	Valid []int64
+	// Signed decodes the field as a two's-complement integer.
+	Signed bool
+}
+
+func (r *ValueRange) contains(v int64) bool {
+	if len(r.Valid) > 0 {
+		return slices.Contains(r.Valid, v)
+	}
+	return v >= r.Min && v <= r.Max
+}
+
+// clamp returns the in-range value closest to v. Values outside a valid set
+// fall back to its first entry.
+func (r *ValueRange) clamp(v int64) int64 {
+	if len(r.Valid) > 0 {
+		return r.Valid[0]
+	}
+	return min(max(v, r.Min), r.Max)
+}
+
+func (r *ValueRange) String() string {
+	if len(r.Valid) > 0 {
+		return fmt.Sprint(r.Valid)
+	}
+	return fmt.Sprintf("%d..%d", r.Min, r.Max)
+}
+
+// RangeError reports a field value outside its ValueRange.
+type RangeError struct {
+	Field string
+	Value int64
+	Range *ValueRange
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("field %s: value %d out of range %s", e.Field, e.Value, e.Range)
+}
+
+// Validate checks the ranged fields of a full wire state.
+// Out-of-range values are clamped in place and counted, unless strict is set,
+// in which case the first offending field is returned as a *RangeError and
+// state is left untouched. In-range states are never modified.
+func (l WireLayout) Validate(state []byte, strict bool) (clamped int, err error) {
+	if len(state) != l.Size() {
+		return 0, fmt.Errorf("state size %d, want %d", len(state), l.Size())
+	}
+	off := 0
+	for _, f := range l {
+		b := state[off : off+f.Size]
+		off += f.Size
+		if f.Range == nil {
+			continue
+		}
+		v := decodeInt(b, f.Range.Signed)
+		if f.Range.contains(v) {
+			continue
+		}
+		if strict {
+			return 0, &RangeError{Field: f.Name, Value: v, Range: f.Range}
+		}
+		encodeInt(b, f.Range.clamp(v))
+		clamped++
+	}
+	return clamped, nil
+}
+
+func decodeInt(b []byte, signed bool) int64 {
+	var u uint64
+	switch len(b) {
+	case 1:
+		u = uint64(b[0])
+	case 2:
+		u = uint64(binary.LittleEndian.Uint16(b))
+	case 4:
+		u = uint64(binary.LittleEndian.Uint32(b))
+	case 8:
+		u = binary.LittleEndian.Uint64(b)
+	}
+	if signed && len(b) < 8 {
+		shift := 64 - 8*len(b)
+		return int64(u<<shift) >> shift
+	}
+	return int64(u)
+}
+
+func encodeInt(b []byte, v int64) {
+	switch len(b) {
+	case 1:
+		b[0] = byte(v)
+	case 2:
+		binary.LittleEndian.PutUint16(b, uint16(v))
+	case 4:
+		binary.LittleEndian.PutUint32(b, uint32(v))
+	case 8:
+		binary.LittleEndian.PutUint64(b, uint64(v))
+	}
+}
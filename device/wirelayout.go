@@ -0,0 +1,163 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DeltaVersion is the delta-update wire version negotiated at stream activation
+// by sending "delta=<DeltaVersion>" as the stream request payload.
+const DeltaVersion = 1
+
+// WireField describes one field of a fixed-size client-to-server wire state.
+type WireField struct {
+	Name string
+	Size int
+	// Relative fields (mouse deltas, ...) read as zero when omitted from a delta
+	// instead of keeping their previous value.
+	Relative bool
+	// Fields sharing a non-empty Group are semantically coupled
+	// (e.g. touch active + coordinates) and must be sent together.
+	Group string
+	// Range optionally restricts the values of an integer field; see Validate.
+	Range *ValueRange
+}
+
+// WireLayout lists the fields of a fixed-size wire state in viiper:wire tag order.
+//
+// A delta packet consists of a field mask of MaskSize() bytes (bit i, LSB first,
+// set when field i is present) followed by the bytes of the present fields in
+// layout order. A mask with all bits set carries a full state.
+type WireLayout []WireField
+
+// Size returns the size of the full wire state in bytes.
+func (l WireLayout) Size() int {
+	n := 0
+	for _, f := range l {
+		n += f.Size
+	}
+	return n
+}
+
+// MaskSize returns the size of the delta field mask in bytes.
+func (l WireLayout) MaskSize() int { return (len(l) + 7) / 8 }
+
+// DeltaSize returns the number of field bytes following the given mask.
+func (l WireLayout) DeltaSize(mask []byte) int {
+	n := 0
+	for i, f := range l {
+		if maskBit(mask, i) {
+			n += f.Size
+		}
+	}
+	return n
+}
+
+// ApplyDelta merges the fields present in mask from body onto state.
+// state must hold a full wire state; it is left untouched on error.
+func (l WireLayout) ApplyDelta(state, mask, body []byte) error {
+	if len(state) != l.Size() {
+		return fmt.Errorf("state size %d, want %d", len(state), l.Size())
+	}
+	if len(mask) != l.MaskSize() {
+		return fmt.Errorf("mask size %d, want %d", len(mask), l.MaskSize())
+	}
+	for i := len(l); i < len(mask)*8; i++ {
+		if maskBit(mask, i) {
+			return fmt.Errorf("mask bit %d set beyond last field", i)
+		}
+	}
+	if len(body) != l.DeltaSize(mask) {
+		return fmt.Errorf("delta body size %d, want %d", len(body), l.DeltaSize(mask))
+	}
+	if err := l.checkGroups(mask); err != nil {
+		return err
+	}
+
+	off, src := 0, 0
+	for i, f := range l {
+		switch {
+		case maskBit(mask, i):
+			copy(state[off:off+f.Size], body[src:src+f.Size])
+			src += f.Size
+		case f.Relative:
+			clear(state[off : off+f.Size])
+		}
+		off += f.Size
+	}
+	return nil
+}
+
+// EncodeDelta builds a delta packet carrying the named fields of the full state.
+// Members of a coupled group are included automatically.
+func (l WireLayout) EncodeDelta(full []byte, fields ...string) ([]byte, error) {
+	if len(full) != l.Size() {
+		return nil, fmt.Errorf("state size %d, want %d", len(full), l.Size())
+	}
+	mask := make([]byte, l.MaskSize())
+	for _, name := range fields {
+		idx := l.index(name)
+		if idx < 0 {
+			return nil, fmt.Errorf("unknown wire field %q", name)
+		}
+		setMaskBit(mask, idx)
+		if g := l[idx].Group; g != "" {
+			for i, f := range l {
+				if f.Group == g {
+					setMaskBit(mask, i)
+				}
+			}
+		}
+	}
+
+	out := append(make([]byte, 0, len(mask)+l.DeltaSize(mask)), mask...)
+	off := 0
+	for i, f := range l {
+		if maskBit(mask, i) {
+			out = append(out, full[off:off+f.Size]...)
+		}
+		off += f.Size
+	}
+	return out, nil
+}
+
+// FullMask returns a delta mask selecting every field.
+func (l WireLayout) FullMask() []byte {
+	mask := make([]byte, l.MaskSize())
+	for i := range l {
+		setMaskBit(mask, i)
+	}
+	return mask
+}
+
+func (l WireLayout) index(name string) int {
+	for i, f := range l {
+		if f.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+var errPartialGroup = errors.New("delta carries a partial field group")
+
+func (l WireLayout) checkGroups(mask []byte) error {
+	present := map[string]bool{}
+	for i, f := range l {
+		if f.Group == "" {
+			continue
+		}
+		p, seen := present[f.Group]
+		if seen && p != maskBit(mask, i) {
+			return fmt.Errorf("%w: %s", errPartialGroup, f.Group)
+		}
+		present[f.Group] = maskBit(mask, i)
+	}
+	return nil
+}
+
+func maskBit(mask []byte, i int) bool {
+	return i/8 < len(mask) && mask[i/8]&(1<<(i%8)) != 0
+}
+
+func setMaskBit(mask []byte, i int) { mask[i/8] |= 1 << (i % 8) }
@@ -0,0 +1,221 @@
+package device_test
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/device/dualshock4"
+	"github.com/Alia5/VIIPER/device/keyboard"
+	"github.com/Alia5/VIIPER/device/mouse"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	"github.com/Alia5/VIIPER/device/xboxone"
+	"github.com/Alia5/VIIPER/internal/codegen/common"
+	"github.com/Alia5/VIIPER/internal/codegen/scanner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayoutsMatchWireTags(t *testing.T) {
+	tests := []struct {
+		device    string
+		direction string
+		layout    device.WireLayout
+	}{
+		{"xbox360", "c2s", xbox360.InputLayout},
+		{"xbox360", "s2c", xbox360.OutputLayout},
+		{"xboxone", "c2s", xboxone.InputLayout},
+		{"xboxone", "s2c", xboxone.OutputLayout},
+		{"mouse", "c2s", mouse.InputLayout},
+		{"dualshock4", "c2s", dualshock4.InputLayout},
+		{"dualshock4", "s2c", dualshock4.OutputLayout},
+		{"keyboard", "s2c", keyboard.OutputLayout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.device+"/"+tt.direction, func(t *testing.T) {
+			tags, err := scanner.ScanWireTags([]string{filepath.Join(".", tt.device)})
+			require.NoError(t, err)
+			tag := tags.GetTag(tt.device, tt.direction)
+			require.NotNil(t, tag)
+			require.Len(t, tt.layout, len(tag.Fields))
+			for i, f := range tag.Fields {
+				base, count, _ := strings.Cut(f.Type, "*")
+				size := common.WireTypeSize(base)
+				if count != "" {
+					n, err := strconv.Atoi(count)
+					require.NoError(t, err, "variable-length field %s", f.Name)
+					size *= n
+				}
+				assert.Equal(t, f.Name, tt.layout[i].Name)
+				assert.Equal(t, size, tt.layout[i].Size, "field %s", f.Name)
+				wantRange := f.Range
+				if f.Type == "bool" {
+					wantRange = "0..1"
+				}
+				assert.Equal(t, wantRange, rangeSpec(tt.layout[i].Range), "field %s", f.Name)
+				if r := tt.layout[i].Range; r != nil {
+					assert.Equal(t, strings.HasPrefix(base, "i"), r.Signed, "field %s", f.Name)
+				}
+			}
+		})
+	}
+}
+
+// rangeSpec renders r in viiper:wire annotation syntax.
+func rangeSpec(r *device.ValueRange) string {
+	switch {
+	case r == nil:
+		return ""
+	case len(r.Valid) > 0:
+		vals := make([]string, len(r.Valid))
+		for i, v := range r.Valid {
+			vals[i] = strconv.FormatInt(v, 10)
+		}
+		return strings.Join(vals, "|")
+	default:
+		return fmt.Sprintf("%d..%d", r.Min, r.Max)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	layout := device.WireLayout{
+		{Name: "plain", Size: 1},
+		{Name: "u16", Size: 2, Range: &device.ValueRange{Min: 10, Max: 1000}},
+		{Name: "i8", Size: 1, Range: &device.ValueRange{Min: -5, Max: 5, Signed: true}},
+		{Name: "mode", Size: 1, Range: &device.ValueRange{Valid: []int64{2, 4, 8}}},
+	}
+	tests := []struct {
+		name        string
+		state       []byte
+		want        []byte
+		wantClamped int
+		wantField   string
+	}{
+		{name: "in range", state: []byte{0xff, 0xe8, 0x03, 0xfb, 8}, want: []byte{0xff, 0xe8, 0x03, 0xfb, 8}},
+		{name: "above max", state: []byte{0, 0xe9, 0x03, 0, 2}, want: []byte{0, 0xe8, 0x03, 0, 2}, wantClamped: 1, wantField: "u16"},
+		{name: "below signed min", state: []byte{0, 10, 0, 0x80, 2}, want: []byte{0, 10, 0, 0xfb, 2}, wantClamped: 1, wantField: "i8"},
+		{name: "outside valid set", state: []byte{0, 10, 0, 0, 3}, want: []byte{0, 10, 0, 0, 2}, wantClamped: 1, wantField: "mode"},
+		{name: "several fields", state: []byte{0, 0, 0, 6, 0}, want: []byte{0, 10, 0, 5, 2}, wantClamped: 3, wantField: "u16"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := bytes.Clone(tt.state)
+			_, err := layout.Validate(state, true)
+			if tt.wantField == "" {
+				require.NoError(t, err)
+			} else {
+				var rangeErr *device.RangeError
+				require.ErrorAs(t, err, &rangeErr)
+				assert.Equal(t, tt.wantField, rangeErr.Field)
+			}
+			assert.Equal(t, tt.state, state, "strict validation never modifies the state")
+
+			clamped, err := layout.Validate(state, false)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantClamped, clamped)
+			assert.Equal(t, tt.want, state)
+		})
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	layout := device.WireLayout{
+		{Name: "a", Size: 1},
+		{Name: "b", Size: 2, Relative: true},
+		{Name: "x", Size: 1, Group: "g"},
+		{Name: "y", Size: 1, Group: "g"},
+	}
+	tests := []struct {
+		name    string
+		mask    []byte
+		body    []byte
+		want    []byte
+		wantErr bool
+	}{
+		{name: "full state", mask: []byte{0x0f}, body: []byte{1, 2, 3, 4, 5}, want: []byte{1, 2, 3, 4, 5}},
+		{name: "single field keeps others", mask: []byte{0x01}, body: []byte{9}, want: []byte{9, 0, 0, 7, 8}},
+		{name: "group together", mask: []byte{0x0c}, body: []byte{4, 5}, want: []byte{1, 0, 0, 4, 5}},
+		{name: "partial group", mask: []byte{0x04}, body: []byte{4}, wantErr: true},
+		{name: "bit beyond layout", mask: []byte{0x11}, body: []byte{1}, wantErr: true},
+		{name: "short body", mask: []byte{0x02}, body: []byte{1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := []byte{1, 2, 3, 7, 8}
+			before := bytes.Clone(state)
+			err := layout.ApplyDelta(state, tt.mask, tt.body)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Equal(t, before, state, "state must be untouched on error")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, state)
+		})
+	}
+}
+
+// FuzzDeltaSequence drives random delta sequences through EncodeDelta/ApplyDelta
+// and checks the result never diverges from a per-field shadow model.
+func FuzzDeltaSequence(f *testing.F) {
+	f.Add([]byte{0xff, 0xff, 0xff})
+	f.Add([]byte{0x01, 0x00, 0x00, 0x00, 0x02, 0x04})
+	f.Add([]byte{0x00, 0x02, 0x00, 0x10, 0x20, 0x30, 0x40, 0x50})
+
+	layout := dualshock4.InputLayout
+	f.Fuzz(func(t *testing.T, data []byte) {
+		state := make([]byte, layout.Size())
+		shadow := make([][]byte, len(layout))
+		for i, fld := range layout {
+			shadow[i] = make([]byte, fld.Size)
+		}
+
+		step := layout.MaskSize() + 1
+		for len(data) >= step {
+			sel, seed := data[:layout.MaskSize()], data[layout.MaskSize()]
+			data = data[step:]
+
+			full := make([]byte, layout.Size())
+			for i := range full {
+				full[i] = seed + byte(i)
+			}
+			var names []string
+			for i, fld := range layout {
+				if sel[i/8]&(1<<(i%8)) != 0 {
+					names = append(names, fld.Name)
+				}
+			}
+			pkt, err := layout.EncodeDelta(full, names...)
+			require.NoError(t, err)
+			mask := pkt[:layout.MaskSize()]
+			require.NoError(t, layout.ApplyDelta(state, mask, pkt[layout.MaskSize():]))
+
+			off := 0
+			for i, fld := range layout {
+				if mask[i/8]&(1<<(i%8)) != 0 {
+					copy(shadow[i], full[off:off+fld.Size])
+				} else if fld.Relative {
+					clear(shadow[i])
+				}
+				off += fld.Size
+			}
+			assert.Equal(t, bytes.Join(shadow, nil), state)
+
+			// Arbitrary bytes must never panic nor corrupt the state.
+			before := bytes.Clone(state)
+			if layout.ApplyDelta(state, sel, data) != nil {
+				assert.Equal(t, before, state)
+			} else {
+				off := 0
+				for i, fld := range layout {
+					copy(shadow[i], state[off:off+fld.Size])
+					off += fld.Size
+				}
+			}
+		}
+	})
+}
@@ -18,3 +18,31 @@ const (
 	ButtonX         = 0x4000
 	ButtonY         = 0x8000
 )
+
+// XUSB capability queries: vendor IN requests (bmRequestType 0xC1) with
+// bRequest XUSBRequest, selected by wValue. The answers are those of a wired
+// pad.
+const (
+	XUSBRequest        = 0x01
+	XUSBCapsVibration  = 0x0000 // 8 bytes: the motors
+	XUSBCapsGamepad    = 0x0100 // 20 bytes: the buttons, triggers and sticks
+	XUSBCapsAttachment = 0x0200 // 3 bytes: an attachment message
+
+	XUSBCapsGamepadLen   = 0x14
+	XUSBCapsVibrationLen = 0x08
+)
+
+// msosCompatibleID is the compatible ID xusb22.inf binds the Xbox 360
+// driver to, reported through the MS OS 2.0 descriptors so pads with custom
+// VID/PIDs still get it.
+const msosCompatibleID = "XUSB10"
+
+// Attachment messages report whether a headset is plugged into the pad. A
+// host queries it with a message of type XUSBMsgAttachment on endpoint 0x01
+// or by XUSBCapsAttachment; the pad answers [XUSBMsgAttachment, 0x03, status],
+// on endpoint 0x81 for the former.
+const (
+	XUSBMsgAttachment     = 0x08
+	XUSBAttachmentNone    = 0x00
+	XUSBAttachmentHeadset = 0x02
+)
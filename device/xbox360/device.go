@@ -16,25 +16,59 @@ type Xbox360 struct {
 	tick       uint64
 	inputState *InputState
 	stateMu    sync.Mutex
-	rumbleFunc func(XRumbleState)
+	rumbleFunc func(XRumbleState) // guarded by stateMu
+	ledFunc    func(LedState)     // guarded by stateMu
+	led        LedState
 	descriptor usb.Descriptor
+	playerSlot int
+	degrade    device.Degrader
+	step       device.Stepper
+	headset    bool
+	ledFeed    bool
+	pending    [][]byte // messages sent on 0x81 ahead of the input report
+
+	inputChanged bool // since the last input report
+	notify       usb.Notifier
 }
 
 type Xbox360CreateOptions struct {
 	SubType *uint8 `json:"subType"`
+	// Headset makes the pad answer attachment queries with a plugged in
+	// headset. Its endpoints still carry no audio.
+	Headset *bool `json:"headset"`
+	// LEDFeedback forwards the LED ring pattern on the stream; all
+	// feedback is then sent as Feedback messages.
+	LEDFeedback *bool `json:"ledFeedback"`
 }
 
+// Capability answers of a wired pad: every button but the unused 0x0800, full
+// triggers, 10-bit sticks and both motors.
+var (
+	capsGamepad = []byte{
+		0x00, XUSBCapsGamepadLen,
+		0xff, 0xf7,
+		0xff, 0xff,
+		0xc0, 0xff, 0xc0, 0xff, 0xc0, 0xff, 0xc0, 0xff,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	capsVibration = []byte{0x00, XUSBCapsVibrationLen, 0x00, 0xff, 0xff, 0x00, 0x00, 0x00}
+)
+
 // New returns a new Xbox360 device.
 func New(o *device.CreateOptions) (*Xbox360, error) {
 	d := &Xbox360{
-		descriptor: MakeDescriptor(),
+		descriptor:   MakeDescriptor(),
+		inputChanged: true,
 	}
 	if o != nil {
-		if o.IdVendor != nil {
-			d.descriptor.Device.IDVendor = *o.IdVendor
+		if err := o.ApplyIdentity(&d.descriptor); err != nil {
+			return nil, err
 		}
-		if o.IdProduct != nil {
-			d.descriptor.Device.IDProduct = *o.IdProduct
+		if o.PlayerSlot != nil {
+			d.playerSlot = *o.PlayerSlot
+		}
+		if o.Deterministic != nil {
+			d.step.Configure(*o.Deterministic)
 		}
 		if o.DeviceSpecific != nil {
 			data, err := json.Marshal(o.DeviceSpecific)
@@ -49,60 +83,190 @@ func New(o *device.CreateOptions) (*Xbox360, error) {
 			if args.SubType != nil {
 				d.descriptor.Interfaces[0].ClassDescriptors[0].Payload[2] = *args.SubType
 			}
+			d.headset = args.Headset != nil && *args.Headset
+			d.ledFeed = args.LEDFeedback != nil && *args.LEDFeedback
 		}
 	}
 	return d, nil
 }
 
+// PlayerSlot returns the player slot the device was created with.
+// It is informational only; the host assigns the controller's LED quadrant.
+func (x *Xbox360) PlayerSlot() int {
+	return x.playerSlot
+}
+
+// Degrader returns the link degradation simulator applied to streamed input.
+func (x *Xbox360) Degrader() *device.Degrader {
+	return &x.degrade
+}
+
+// Stepper returns the queue of streamed states in deterministic mode.
+func (x *Xbox360) Stepper() *device.Stepper {
+	return &x.step
+}
+
+// Headset reports whether the pad claims a plugged in headset.
+func (x *Xbox360) Headset() bool {
+	return x.headset
+}
+
+// LEDFeedback reports whether the pad forwards its LED ring pattern, see
+// Feedback.
+func (x *Xbox360) LEDFeedback() bool {
+	return x.ledFeed
+}
+
+// LED returns the LED ring pattern the host set last.
+func (x *Xbox360) LED() LedState {
+	x.stateMu.Lock()
+	defer x.stateMu.Unlock()
+	return x.led
+}
+
+// attachment returns the attachment message of the pad.
+func (x *Xbox360) attachment() []byte {
+	status := byte(XUSBAttachmentNone)
+	if x.headset {
+		status = XUSBAttachmentHeadset
+	}
+	return []byte{XUSBMsgAttachment, 0x03, status}
+}
+
 // SetRumbleCallback sets a callback that will be invoked when rumble commands arrive.
 func (x *Xbox360) SetRumbleCallback(f func(XRumbleState)) {
+	x.stateMu.Lock()
+	defer x.stateMu.Unlock()
 	x.rumbleFunc = f
 }
 
+// SetLEDCallback sets a callback that will be invoked when the host sets the
+// LED ring pattern.
+func (x *Xbox360) SetLEDCallback(f func(LedState)) {
+	x.stateMu.Lock()
+	defer x.stateMu.Unlock()
+	x.ledFunc = f
+}
+
 // UpdateInputState updates the device's current input state (thread-safe).
 func (x *Xbox360) UpdateInputState(state InputState) {
 	x.stateMu.Lock()
 	defer x.stateMu.Unlock()
 	x.inputState = &state
+	x.inputChanged = true
+	x.notify.Notify()
+}
+
+// ResetInputState releases all buttons and triggers and centers the sticks.
+func (x *Xbox360) ResetInputState() {
+	x.UpdateInputState(InputState{})
 }
 
 // HandleTransfer implements interrupt IN/OUT for Xbox360.
 func (x *Xbox360) HandleTransfer(ep uint32, dir uint32, out []byte) []byte {
+	if ep != 1 {
+		// The headset endpoints exist but carry nothing; answer them empty
+		// instead of stalling.
+		return nil
+	}
 	if dir == usbip.DirIn {
-		switch ep {
-		case 1: // 0x81 - main input reports
-			atomic.AddUint64(&x.tick, 1)
-
-			x.stateMu.Lock()
-			var st InputState
-			if x.inputState != nil {
-				st = *x.inputState
-			}
+		// 0x81 - main input reports
+		atomic.AddUint64(&x.tick, 1)
+
+		x.stateMu.Lock()
+		if len(x.pending) > 0 {
+			msg := x.pending[0]
+			x.pending = x.pending[1:]
 			x.stateMu.Unlock()
-			return st.BuildReport()
-		default:
-			return nil
+			return msg
 		}
+		var st InputState
+		if x.inputState != nil {
+			st = *x.inputState
+		}
+		x.inputChanged = false
+		x.stateMu.Unlock()
+		return st.BuildReport()
 	}
-	if dir == usbip.DirOut && ep == 1 {
-		// Host->Device output reports used by the wired Xbox 360 controller include
-		// an 8-byte rumble packet: [0]=ReportID(0x00), [1]=Len(0x08), [2]=Reserved/Status(0x00),
-		// [3]=Left (low-frequency/large) motor 0-255, [4]=Right (high-frequency/small) motor 0-255,
-		// [5..7]=Reserved (often 0x00).
-		// Some other outbound reports (e.g. LED control) use different IDs/lengths; we ignore those here.
-		if len(out) >= 8 && out[0] == 0x00 && out[1] == 0x08 {
-			rumble := XRumbleState{
-				LeftMotor:  out[3], // big / low-frequency motor
-				RightMotor: out[4], // small / high-frequency motor
-			}
-			if x.rumbleFunc != nil {
-				x.rumbleFunc(rumble)
-			}
+	if len(out) >= 1 && out[0] == XUSBMsgAttachment {
+		x.stateMu.Lock()
+		x.pending = append(x.pending, x.attachment())
+		x.notify.Notify()
+		x.stateMu.Unlock()
+		return nil
+	}
+	if len(out) >= 3 && out[0] == XUSBMsgLED && out[1] == 0x03 {
+		led := LedState{Pattern: out[2]}
+		x.stateMu.Lock()
+		x.led = led
+		ledFunc := x.ledFunc
+		x.stateMu.Unlock()
+		if ledFunc != nil {
+			ledFunc(led)
+		}
+		return nil
+	}
+	// Host->Device output reports used by the wired Xbox 360 controller include
+	// an 8-byte rumble packet: [0]=ReportID(0x00), [1]=Len(0x08), [2]=Reserved/Status(0x00),
+	// [3]=Left (low-frequency/large) motor 0-255, [4]=Right (high-frequency/small) motor 0-255,
+	// [5..7]=Reserved (often 0x00).
+	// Other outbound reports use different IDs/lengths; we ignore those here.
+	if len(out) >= 8 && out[0] == 0x00 && out[1] == 0x08 {
+		rumble := XRumbleState{
+			LeftMotor:  out[3], // big / low-frequency motor
+			RightMotor: out[4], // small / high-frequency motor
+		}
+		x.stateMu.Lock()
+		rumbleFunc := x.rumbleFunc
+		x.stateMu.Unlock()
+		if rumbleFunc != nil {
+			rumbleFunc(rumble)
 		}
 	}
 	return nil
 }
 
+// HandleTransferStatus holds polls of 0x81 while neither a message is queued
+// nor the input state changed since the last report, see usb.ErrPending.
+func (x *Xbox360) HandleTransferStatus(ep uint32, dir uint32, out []byte) ([]byte, error) {
+	if dir == usbip.DirIn && ep == 1 {
+		x.stateMu.Lock()
+		idle := !x.inputChanged && len(x.pending) == 0
+		x.stateMu.Unlock()
+		if idle {
+			return nil, usb.ErrPending
+		}
+	}
+	return x.HandleTransfer(ep, dir, out), nil
+}
+
+// InputNotifier returns the notifier fired by UpdateInputState and queued
+// messages.
+func (x *Xbox360) InputNotifier() *usb.Notifier {
+	return &x.notify
+}
+
+// HandleControl answers the XUSB capability queries and accepts the other
+// vendor requests the XUSB driver sends while starting the controller; those
+// only need not to stall.
+func (x *Xbox360) HandleControl(bmRequestType, bRequest uint8, wValue, _ /* wIndex */, _ /* wLength */ uint16, _ []byte) ([]byte, bool) {
+	const (
+		reqTypeVendor   = 0x40
+		reqTypeVendorIn = 0xC1
+	)
+	if bmRequestType == reqTypeVendorIn && bRequest == XUSBRequest {
+		switch wValue {
+		case XUSBCapsGamepad:
+			return capsGamepad, true
+		case XUSBCapsVibration:
+			return capsVibration, true
+		case XUSBCapsAttachment:
+			return x.attachment(), true
+		}
+	}
+	return nil, bmRequestType&0x60 == reqTypeVendor
+}
+
 func MakeDescriptor() usb.Descriptor {
 	return usb.Descriptor{
 		Device: usb.DeviceDescriptor{
@@ -214,7 +378,9 @@ func MakeDescriptor() usb.Descriptor {
 			1: "©Microsoft Corporation",
 			2: "VIIPER Controller", //"Controller",
 			3: "296013F",
+			4: "Xbox Security Method 3, Version 1.00, © 2005 Microsoft Corporation. All rights reserved.",
 		},
+		MSOS20: &usb.MSOS20{CompatibleID: msosCompatibleID},
 	}
 }
 
@@ -223,5 +389,12 @@ func (x *Xbox360) GetDescriptor() *usb.Descriptor {
 }
 
 func (x *Xbox360) GetDeviceSpecificArgs() map[string]any {
-	return map[string]any{"subType": x.descriptor.Interfaces[0].ClassDescriptors[0].Payload[2]}
+	args := map[string]any{"subType": x.descriptor.Interfaces[0].ClassDescriptors[0].Payload[2]}
+	if x.headset {
+		args["headset"] = true
+	}
+	if x.ledFeed {
+		args["ledFeedback"] = true
+	}
+	return args
 }
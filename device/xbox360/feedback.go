@@ -0,0 +1,91 @@
+package xbox360
+
+import (
+	"io"
+
+	"github.com/Alia5/VIIPER/device"
+)
+
+// LED ring messages: the host sets the pattern with [XUSBMsgLED, 0x03,
+// pattern] on endpoint 0x01. XInput flashes then lights the quadrant of the
+// player slot it assigned, LEDFlash1 and LEDOn1 for player 1.
+const (
+	XUSBMsgLED = 0x01
+
+	LEDOff       = 0x00
+	LEDBlinkAll  = 0x01
+	LEDFlash1    = 0x02
+	LEDFlash2    = 0x03
+	LEDFlash3    = 0x04
+	LEDFlash4    = 0x05
+	LEDOn1       = 0x06
+	LEDOn2       = 0x07
+	LEDOn3       = 0x08
+	LEDOn4       = 0x09
+	LEDRotate    = 0x0A
+	LEDBlink     = 0x0B
+	LEDSlowBlink = 0x0C
+	LEDAlternate = 0x0D
+)
+
+// Kinds of Feedback messages.
+const (
+	FeedbackRumble = 0x00
+	FeedbackLED    = 0x01
+)
+
+// LedState is the LED ring pattern the host set, one of the LED* patterns.
+type LedState struct {
+	Pattern uint8
+}
+
+// Player returns the player slot 1-4 the pattern shows, 0 for patterns not
+// showing one.
+func (l LedState) Player() int {
+	switch {
+	case l.Pattern >= LEDFlash1 && l.Pattern <= LEDFlash4:
+		return int(l.Pattern-LEDFlash1) + 1
+	case l.Pattern >= LEDOn1 && l.Pattern <= LEDOn4:
+		return int(l.Pattern-LEDOn1) + 1
+	}
+	return 0
+}
+
+// Feedback is the feedback message of a pad created with ledFeedback: the
+// kind followed by the rumble or the LED pattern, zero padded to 2 bytes.
+// viiper:wire xbox360feedback s2c kind:u8 body:u8*2
+type Feedback struct {
+	Kind   uint8        // FeedbackRumble or FeedbackLED
+	Rumble XRumbleState // set for FeedbackRumble
+	LED    LedState     // set for FeedbackLED
+}
+
+// FeedbackLayout is the field layout of the Feedback wire format.
+var FeedbackLayout = device.WireLayout{
+	{Name: "kind", Size: 1},
+	{Name: "body", Size: 2},
+}
+
+// MarshalBinary encodes Feedback to 3 bytes.
+func (f *Feedback) MarshalBinary() ([]byte, error) {
+	if f.Kind == FeedbackLED {
+		return []byte{f.Kind, f.LED.Pattern, 0}, nil
+	}
+	return []byte{f.Kind, f.Rumble.LeftMotor, f.Rumble.RightMotor}, nil
+}
+
+// UnmarshalBinary decodes 3 bytes into Feedback. The body of unknown kinds
+// is skipped.
+func (f *Feedback) UnmarshalBinary(data []byte) error {
+	if len(data) < 3 {
+		return io.ErrUnexpectedEOF
+	}
+	*f = Feedback{Kind: data[0]}
+	switch f.Kind {
+	case FeedbackRumble:
+		f.Rumble = XRumbleState{LeftMotor: data[1], RightMotor: data[2]}
+	case FeedbackLED:
+		f.LED = LedState{Pattern: data[1]}
+	}
+	return nil
+}
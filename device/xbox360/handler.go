@@ -1,6 +1,8 @@
 package xbox360
 
 import (
+	"encoding"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
@@ -13,12 +15,37 @@ import (
 
 func init() {
 	api.RegisterDevice("xbox360", &handler{})
+	device.RegisterOutputDecoder("xbox360", device.OutputDecoder{
+		Size: OutputLayout.Size(),
+		New:  func() encoding.BinaryUnmarshaler { return new(XRumbleState) },
+	})
 }
 
 type handler struct{}
 
 func (h *handler) CreateDevice(o *device.CreateOptions) (usb.Device, error) { return New(o) }
 
+func (h *handler) InputLayout(usb.Device) device.WireLayout { return InputLayout }
+
+func (h *handler) OutputLayout(dev usb.Device) device.WireLayout {
+	if x, ok := dev.(*Xbox360); ok && x.ledFeed {
+		return FeedbackLayout
+	}
+	return OutputLayout
+}
+
+func (h *handler) MacroState(dev usb.Device, state []byte) (func(), error) {
+	xdev, ok := dev.(*Xbox360)
+	if !ok {
+		return nil, fmt.Errorf("device is not xbox360")
+	}
+	var st InputState
+	if err := json.Unmarshal(state, &st); err != nil {
+		return nil, err
+	}
+	return func() { xdev.UpdateInputState(st) }, nil
+}
+
 func (r *handler) StreamHandler() api.StreamHandlerFunc {
 	return func(conn net.Conn, devPtr *usb.Device, logger *slog.Logger) error {
 		if devPtr == nil || *devPtr == nil {
@@ -29,16 +56,22 @@ func (r *handler) StreamHandler() api.StreamHandlerFunc {
 			return fmt.Errorf("device is not xbox360")
 		}
 
-		xdev.SetRumbleCallback(func(rumble XRumbleState) {
-			data, err := rumble.MarshalBinary()
+		send := func(msg encoding.BinaryMarshaler) {
+			data, err := msg.MarshalBinary()
 			if err != nil {
-				logger.Error("failed to marshal rumble", "error", err)
+				logger.Error("failed to marshal feedback", "error", err)
 				return
 			}
 			if _, err := conn.Write(data); err != nil {
-				logger.Error("failed to send rumble", "error", err)
+				logger.Error("failed to send feedback", "error", err)
 			}
-		})
+		}
+		if xdev.ledFeed {
+			xdev.SetRumbleCallback(func(rumble XRumbleState) { send(&Feedback{Kind: FeedbackRumble, Rumble: rumble}) })
+			xdev.SetLEDCallback(func(led LedState) { send(&Feedback{Kind: FeedbackLED, LED: led}) })
+		} else {
+			xdev.SetRumbleCallback(func(rumble XRumbleState) { send(&rumble) })
+		}
 
 		buf := make([]byte, 20)
 		for {
@@ -54,6 +87,18 @@ func (r *handler) StreamHandler() api.StreamHandlerFunc {
 			if err := state.UnmarshalBinary(buf); err != nil {
 				return fmt.Errorf("unmarshal input state: %w", err)
 			}
+			if xdev.step.Active() {
+				st := state
+				if err := xdev.step.Push(func() { xdev.UpdateInputState(st) }); err != nil {
+					return err
+				}
+				continue
+			}
+			if xdev.degrade.Active() {
+				st := state
+				xdev.degrade.Apply(func() { xdev.UpdateInputState(st) })
+				continue
+			}
 			xdev.UpdateInputState(state)
 		}
 	}
@@ -3,6 +3,8 @@ package xbox360
 import (
 	"encoding/binary"
 	"io"
+
+	"github.com/Alia5/VIIPER/device"
 )
 
 // InputState represents the controller state used to build a report.
@@ -19,6 +21,18 @@ type InputState struct {
 	Reserved [6]byte
 }
 
+// InputLayout is the field layout of the InputState wire format, used for delta updates.
+var InputLayout = device.WireLayout{
+	{Name: "buttons", Size: 4},
+	{Name: "lt", Size: 1},
+	{Name: "rt", Size: 1},
+	{Name: "lx", Size: 2},
+	{Name: "ly", Size: 2},
+	{Name: "rx", Size: 2},
+	{Name: "ry", Size: 2},
+	{Name: "reserved", Size: 6},
+}
+
 // viiper:wire xbox360guitarherodrums c2s buttons:u32 _:u8 _:u8 greenVelocity:u8 redVelocity:u8 yellowVelocity:u8 blueVelocity:u8 orangeVelocity:u8 kickVelocity:u8 midiPacket:u8*6
 type GuitarHeroDrumsInputState struct {
 	// Button bitfield (lower 16 bits used typically), higher bits reserved
@@ -108,6 +122,12 @@ type XRumbleState struct {
 	RightMotor uint8
 }
 
+// OutputLayout is the field layout of the XRumbleState wire format.
+var OutputLayout = device.WireLayout{
+	{Name: "left", Size: 1},
+	{Name: "right", Size: 1},
+}
+
 // MarshalBinary encodes XRumbleState to 2 bytes.
 func (r *XRumbleState) MarshalBinary() ([]byte, error) {
 	return []byte{r.LeftMotor, r.RightMotor}, nil
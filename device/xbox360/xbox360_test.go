@@ -2,20 +2,26 @@ package xbox360_test
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"sync"
 	"testing"
 	"time"
 
 	viiperTesting "github.com/Alia5/VIIPER/_testing"
 	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device"
 	"github.com/Alia5/VIIPER/device/xbox360"
 	"github.com/Alia5/VIIPER/internal/server/api"
 	"github.com/Alia5/VIIPER/internal/server/api/handler"
 	"github.com/Alia5/VIIPER/usbip"
 	"github.com/Alia5/VIIPER/virtualbus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	_ "github.com/Alia5/VIIPER/internal/registry" // Register devices
+	"github.com/Alia5/VIIPER/usb"
 )
 
 func TestInputReports(t *testing.T) {
@@ -477,3 +483,372 @@ func TestRumble(t *testing.T) {
 	}
 
 }
+
+func TestLEDFeedback(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90185)
+	require.NoError(t, err)
+	defer b.Close()
+	require.NoError(t, s.UsbServer.AddBus(b))
+
+	ctx := context.Background()
+	client := apiclient.New(s.ApiServer.Addr())
+	stream, dev, err := client.AddDeviceAndConnect(ctx, b.BusID(), "xbox360", &device.CreateOptions{
+		DeviceSpecific: map[string]any{"ledFeedback": true},
+	})
+	require.NoError(t, err)
+	defer stream.Close()
+	assert.Equal(t, true, dev.DeviceSpecific["ledFeedback"])
+	msgs, errs := apiclient.ReadMessages[xbox360.Feedback](ctx, stream, xbox360.FeedbackLayout.Size(), 10)
+
+	usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := usbipClient.AttachDevice("90185-1")
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+
+	// The XUSB driver blinks the ring, then flashes and lights the slot it
+	// assigned; games rumble in between.
+	out := [][]byte{
+		{xbox360.XUSBMsgLED, 0x03, xbox360.LEDBlinkAll},
+		{0x00, 0x08, 0x00, 0x40, 0x80, 0x00, 0x00, 0x00},
+		{xbox360.XUSBMsgLED, 0x03, xbox360.LEDFlash2},
+		{xbox360.XUSBMsgLED, 0x03, xbox360.LEDOn2},
+		{0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+	}
+	for _, o := range out {
+		require.NoError(t, usbipClient.Submit(imp.Conn, usbip.DirOut, 1, o, nil))
+	}
+	want := []xbox360.Feedback{
+		{Kind: xbox360.FeedbackLED, LED: xbox360.LedState{Pattern: xbox360.LEDBlinkAll}},
+		{Kind: xbox360.FeedbackRumble, Rumble: xbox360.XRumbleState{LeftMotor: 0x40, RightMotor: 0x80}},
+		{Kind: xbox360.FeedbackLED, LED: xbox360.LedState{Pattern: xbox360.LEDFlash2}},
+		{Kind: xbox360.FeedbackLED, LED: xbox360.LedState{Pattern: xbox360.LEDOn2}},
+		{Kind: xbox360.FeedbackRumble},
+	}
+	for i, w := range want {
+		select {
+		case got := <-msgs:
+			assert.Equal(t, w, *got, "message %d", i)
+		case err := <-errs:
+			t.Fatalf("stream failed: %v", err)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for message %d", i)
+		}
+	}
+	assert.Equal(t, 2, want[3].LED.Player())
+	assert.Equal(t, 0, want[0].LED.Player())
+
+	wire, err := (&want[2]).MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{xbox360.FeedbackLED, xbox360.LEDFlash2, 0x00}, wire)
+}
+
+func TestDeltaUpdates(t *testing.T) { testDeltaUpdates(t, true) }
+
+// Servers without delta support get full states from the same client calls.
+func TestDeltaUpdatesFallback(t *testing.T) { testDeltaUpdates(t, false) }
+
+func testDeltaUpdates(t *testing.T, serverSupportsDelta bool) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	if serverSupportsDelta {
+		r.Register("features", handler.Features())
+	}
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+
+	if err := s.ApiServer.Start(); err != nil {
+		t.Fatalf("Failed to start API server: %v", err)
+	}
+
+	b, err := virtualbus.NewWithBusId(1)
+	if err != nil {
+		t.Fatalf("Failed to create virtual bus: %v", err)
+	}
+	defer b.Close()
+	_ = s.UsbServer.AddBus(b)
+
+	client := apiclient.New(s.ApiServer.Addr())
+	dev, err := client.DeviceAddCtx(context.Background(), b.BusID(), "xbox360", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	stream, err := client.OpenDeltaStream(context.Background(), b.BusID(), dev.DevId, xbox360.InputLayout)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer stream.Close()
+
+	usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	devs, err := usbipClient.ListDevices()
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.Len(t, devs, 1) {
+		return
+	}
+	imp, err := usbipClient.AttachDevice(devs[0].BusID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if imp != nil && imp.Conn != nil {
+		defer imp.Conn.Close()
+	}
+
+	state := xbox360.InputState{Buttons: xbox360.ButtonA, LT: 0x10, LX: 0x1234}
+	steps := []struct {
+		name    string
+		update  func(s *xbox360.InputState)
+		changed []string
+	}{
+		{name: "full state", update: func(*xbox360.InputState) {}},
+		{name: "lx only", update: func(s *xbox360.InputState) { s.LX = -2 }, changed: []string{"lx"}},
+		{name: "buttons only", update: func(s *xbox360.InputState) { s.Buttons = xbox360.ButtonB }, changed: []string{"buttons"}},
+		{name: "rt and ry", update: func(s *xbox360.InputState) { s.RT = 0xff; s.RY = 0x7fff }, changed: []string{"rt", "ry"}},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			step.update(&state)
+			if step.changed == nil {
+				err = stream.WriteBinary(&state)
+			} else {
+				err = stream.WriteDelta(&state, step.changed...)
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			want := state.BuildReport()
+			got, err := usbipClient.PollInputReport(imp.Conn, want, 750*time.Millisecond)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestFlushOnClose(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("features", handler.Features())
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/{deviceid}/degrade", handler.DeviceDegrade(s.UsbServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	if err := s.ApiServer.Start(); err != nil {
+		t.Fatalf("Failed to start API server: %v", err)
+	}
+
+	b, err := virtualbus.NewWithBusId(1)
+	if err != nil {
+		t.Fatalf("Failed to create virtual bus: %v", err)
+	}
+	defer b.Close()
+	_ = s.UsbServer.AddBus(b)
+
+	client := apiclient.New(s.ApiServer.Addr())
+	dev, err := client.DeviceAddCtx(context.Background(), b.BusID(), "xbox360", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := usbipClient.AttachDevice(fmt.Sprintf("%d-%s", b.BusID(), dev.DevId))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer imp.Conn.Close()
+
+	pressed := xbox360.InputState{Buttons: xbox360.ButtonA, RT: 0xff}
+	released := xbox360.InputState{}
+	// Iterations alternate the final state, so a stale report never matches.
+	writeAndClose := func(t *testing.T, i int) bool {
+		first, last := pressed, released
+		if i%2 == 1 {
+			first, last = released, pressed
+		}
+		stream, err := client.OpenStream(context.Background(), b.BusID(), dev.DevId)
+		if !assert.NoError(t, err) {
+			return false
+		}
+		if !assert.NoError(t, stream.WriteBinary(&first)) || !assert.NoError(t, stream.WriteBinary(&last)) {
+			_ = stream.Close()
+			return false
+		}
+		if !assert.NoError(t, stream.Close()) {
+			return false
+		}
+		// A single read: the final state must already be latched.
+		got, err := usbipClient.ReadInputReport(imp.Conn)
+		return assert.NoError(t, err) && assert.Equal(t, last.BuildReport(), got, "iteration %d", i)
+	}
+
+	t.Run("plain", func(t *testing.T) {
+		for i := range 200 {
+			if !writeAndClose(t, i) {
+				return
+			}
+		}
+	})
+
+	t.Run("degraded link", func(t *testing.T) {
+		_, err := client.DeviceDegrade(b.BusID(), dev.DevId, &apitypes.DegradeConfig{DelayMs: 30})
+		if !assert.NoError(t, err) {
+			return
+		}
+		for i := range 6 {
+			if !writeAndClose(t, i) {
+				return
+			}
+		}
+	})
+}
+
+func TestCapabilities(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	attach := func(t *testing.T, busID uint32, opts *device.CreateOptions) (*viiperTesting.TestUsbIpClient, *viiperTesting.ImportResult) {
+		t.Helper()
+		b, err := virtualbus.NewWithBusId(busID)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = b.Close() })
+		require.NoError(t, s.UsbServer.AddBus(b))
+		dev, err := xbox360.New(opts)
+		require.NoError(t, err)
+		_, err = b.Add(dev)
+		require.NoError(t, err)
+
+		usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+		imp, err := usbipClient.AttachDevice(fmt.Sprintf("%d-1", busID))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = imp.Conn.Close() })
+		return usbipClient, imp
+	}
+	capsRequest := func(wValue, wLength uint16) [8]byte {
+		return [8]byte{0xC1, xbox360.XUSBRequest, byte(wValue), byte(wValue >> 8), 0, 0, byte(wLength), byte(wLength >> 8)}
+	}
+
+	t.Run("wired pad", func(t *testing.T) {
+		c, imp := attach(t, 90154, nil)
+
+		got, err := c.Control(imp.Conn, capsRequest(xbox360.XUSBCapsGamepad, 20), nil)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{
+			0x00, 0x14, 0xff, 0xf7, 0xff, 0xff, 0xc0, 0xff, 0xc0, 0xff,
+			0xc0, 0xff, 0xc0, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		}, got)
+
+		got, err = c.Control(imp.Conn, capsRequest(xbox360.XUSBCapsVibration, 8), nil)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0x00, 0x08, 0x00, 0xff, 0xff, 0x00, 0x00, 0x00}, got)
+
+		got, err = c.Control(imp.Conn, capsRequest(xbox360.XUSBCapsAttachment, 3), nil)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0x08, 0x03, 0x00}, got)
+
+		require.NoError(t, c.Submit(imp.Conn, usbip.DirOut, 1, []byte{xbox360.XUSBMsgAttachment, 0x03, 0x00}, nil))
+		got, err = c.ReadInputReport(imp.Conn)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0x08, 0x03, 0x00}, got, "no headset attached")
+		got, err = c.ReadInputReport(imp.Conn)
+		require.NoError(t, err)
+		assert.Len(t, got, 20, "input reports follow")
+	})
+
+	t.Run("headset", func(t *testing.T) {
+		c, imp := attach(t, 90155, &device.CreateOptions{DeviceSpecific: map[string]any{"headset": true}})
+
+		got, err := c.Control(imp.Conn, capsRequest(xbox360.XUSBCapsAttachment, 3), nil)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0x08, 0x03, 0x02}, got)
+
+		require.NoError(t, c.Submit(imp.Conn, usbip.DirOut, 1, []byte{xbox360.XUSBMsgAttachment, 0x03, 0x00}, nil))
+		got, err = c.ReadInputReport(imp.Conn)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0x08, 0x03, 0x02}, got)
+	})
+
+	t.Run("args", func(t *testing.T) {
+		dev, err := xbox360.New(&device.CreateOptions{DeviceSpecific: map[string]any{"headset": true}})
+		require.NoError(t, err)
+		assert.True(t, dev.Headset())
+		assert.Equal(t, true, dev.GetDeviceSpecificArgs()["headset"])
+		plain, err := xbox360.New(nil)
+		require.NoError(t, err)
+		assert.NotContains(t, plain.GetDeviceSpecificArgs(), "headset")
+	})
+}
+
+// TestCallbacksConcurrent swaps the callbacks while rumble and LED reports
+// arrive, as a stream attaching to a pad the host already drives does; run
+// it with -race.
+func TestCallbacksConcurrent(t *testing.T) {
+	x, err := xbox360.New(nil)
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			x.SetRumbleCallback(func(xbox360.XRumbleState) {})
+			x.SetLEDCallback(func(xbox360.LedState) {})
+		}
+	}()
+	for i := range 100 {
+		x.HandleTransfer(1, usbip.DirOut, []byte{0x00, 0x08, 0x00, byte(i), 0x00, 0x00, 0x00, 0x00})
+		x.HandleTransfer(1, usbip.DirOut, []byte{xbox360.XUSBMsgLED, 0x03, byte(i % 14)})
+	}
+	wg.Wait()
+}
+
+func TestHeldInputReports(t *testing.T) {
+	x, err := xbox360.New(nil)
+	require.NoError(t, err)
+	var _ usb.PendingDevice = x
+
+	_, err = x.HandleTransferStatus(1, usbip.DirIn, nil)
+	require.NoError(t, err, "the first report is not held")
+	_, err = x.HandleTransferStatus(1, usbip.DirIn, nil)
+	assert.ErrorIs(t, err, usb.ErrPending, "unchanged input is held")
+
+	wake := x.InputNotifier().Wait()
+	state := xbox360.InputState{Buttons: xbox360.ButtonA}
+	x.UpdateInputState(state)
+	select {
+	case <-wake:
+	default:
+		t.Error("UpdateInputState does not wake held polls")
+	}
+	resp, err := x.HandleTransferStatus(1, usbip.DirIn, nil)
+	require.NoError(t, err)
+	assert.Equal(t, state.BuildReport(), resp)
+
+	wake = x.InputNotifier().Wait()
+	_, err = x.HandleTransferStatus(1, usbip.DirOut, []byte{xbox360.XUSBMsgAttachment, 0x03})
+	require.NoError(t, err)
+	select {
+	case <-wake:
+	default:
+		t.Error("a queued message does not wake held polls")
+	}
+	resp, err = x.HandleTransferStatus(1, usbip.DirIn, nil)
+	require.NoError(t, err)
+	assert.Equal(t, byte(xbox360.XUSBMsgAttachment), resp[0])
+	_, err = x.HandleTransferStatus(1, usbip.DirIn, nil)
+	assert.ErrorIs(t, err, usb.ErrPending)
+}
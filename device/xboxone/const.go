@@ -0,0 +1,76 @@
+package xboxone
+
+// Button bitmasks of InputState.Buttons. The low 16 bits are those of the GIP
+// input message; Guide is reported by a message of its own and Share by a
+// separate byte of the input message.
+const (
+	ButtonSync      = 0x0001 // pairing button
+	ButtonMenu      = 0x0004
+	ButtonView      = 0x0008
+	ButtonA         = 0x0010
+	ButtonB         = 0x0020
+	ButtonX         = 0x0040
+	ButtonY         = 0x0080
+	ButtonDPadUp    = 0x0100
+	ButtonDPadDown  = 0x0200
+	ButtonDPadLeft  = 0x0400
+	ButtonDPadRight = 0x0800
+	ButtonLShoulder = 0x1000 // Left bumper (LB)
+	ButtonRShoulder = 0x2000 // Right bumper (RB)
+	ButtonLThumb    = 0x4000 // Left stick button
+	ButtonRThumb    = 0x8000 // Right stick button
+	ButtonGuide     = 0x10000
+	ButtonShare     = 0x20000
+)
+
+// TriggerMax is the value of a fully pressed trigger.
+const TriggerMax = 1023
+
+// GIP message commands. Messages start with the command, the options, a
+// sequence number and the payload length as a varint.
+const (
+	CmdAcknowledge  = 0x01
+	CmdAnnounce     = 0x02
+	CmdStatus       = 0x03
+	CmdIdentify     = 0x04
+	CmdPower        = 0x05
+	CmdAuthenticate = 0x06
+	CmdGuideButton  = 0x07
+	CmdRumble       = 0x09
+	CmdLED          = 0x0a
+	CmdInput        = 0x20
+)
+
+// GIP message options. A chunked message carries the offset of the chunk as
+// a second varint after the length; the first chunk instead carries the
+// length of the whole message.
+const (
+	OptAcknowledge = 0x10 // the receiver acknowledges the message
+	OptInternal    = 0x20 // system message rather than a gamepad one
+	OptChunkStart  = 0x40
+	OptChunk       = 0x80
+)
+
+// Power modes set by CmdPower.
+const (
+	PowerOn    = 0x00
+	PowerSleep = 0x01
+	PowerOff   = 0x04
+)
+
+// Motor selection bits of a rumble command; motors not selected keep their
+// magnitude.
+const (
+	MotorRight        = 0x01 // small / high-frequency motor
+	MotorLeft         = 0x02 // big / low-frequency motor
+	MotorRightTrigger = 0x04
+	MotorLeftTrigger  = 0x08
+)
+
+// Windows binds its GIP driver to devices whose Microsoft OS descriptor
+// reports the compatible ID "XGIP10". The vendor code of the OS string
+// descriptor selects the request answering with it.
+const (
+	MSOSStringIndex = 0xEE
+	MSOSVendorCode  = 0x90
+)
@@ -0,0 +1,338 @@
+// Package xboxone provides an Xbox One / Series controller device speaking
+// the Gaming Input Protocol (GIP).
+package xboxone
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+)
+
+// maxPending bounds the messages waiting for the host to read the IN
+// endpoint; a host that never reads them loses the oldest.
+const maxPending = 32
+
+// gipEndpoint is the number of both GIP endpoints, 0x02 OUT and 0x82 IN.
+const gipEndpoint = 2
+
+type XboxOne struct {
+	stateMu    sync.Mutex
+	inputState InputState
+	descriptor usb.Descriptor
+	mac        [6]byte
+	playerSlot int
+
+	// Guarded by stateMu.
+	pending    [][]byte
+	seq        uint8
+	power      uint8
+	led        [2]uint8 // mode, brightness
+	rumble     OutputState
+	outputFunc func(OutputState)
+
+	inputChanged bool // since the last input message
+	notify       usb.Notifier
+
+	degrade device.Degrader
+	step    device.Stepper
+}
+
+// New returns a new XboxOne device. Its announce message is the first
+// thing the host reads.
+func New(o *device.CreateOptions) (*XboxOne, error) {
+	d := &XboxOne{
+		descriptor:   defaultDescriptor,
+		power:        PowerOff,
+		inputChanged: true,
+	}
+	// A locally administered address, reported by the announce message.
+	_, _ = rand.Read(d.mac[:])
+	d.mac[0] = d.mac[0]&^0x01 | 0x02
+	if o != nil {
+		if err := o.ApplyIdentity(&d.descriptor); err != nil {
+			return nil, err
+		}
+		if o.PlayerSlot != nil {
+			d.playerSlot = *o.PlayerSlot
+		}
+		if o.Deterministic != nil {
+			d.step.Configure(*o.Deterministic)
+		}
+	}
+	d.queueLocked(Message(CmdAnnounce, OptInternal, d.nextSeqLocked(), d.announce()))
+	return d, nil
+}
+
+// announce returns the payload of the announce message: the address, the
+// vendor and product ID and the firmware and hardware versions.
+func (d *XboxOne) announce() []byte {
+	b := make([]byte, 28)
+	copy(b[0:6], d.mac[:])
+	binary.LittleEndian.PutUint16(b[8:10], d.descriptor.Device.IDVendor)
+	binary.LittleEndian.PutUint16(b[10:12], d.descriptor.Device.IDProduct)
+	for i, v := range []uint16{5, 17, 3202, 0, 1, 0, 1, 0} {
+		binary.LittleEndian.PutUint16(b[12+2*i:], v)
+	}
+	return b
+}
+
+func (d *XboxOne) SetOutputCallback(f func(OutputState)) {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	d.outputFunc = f
+}
+
+// PlayerSlot returns the player slot the device was created with.
+// It is informational only; the pad has no player indicator.
+func (d *XboxOne) PlayerSlot() int {
+	return d.playerSlot
+}
+
+// Powered reports whether the host has powered the pad on.
+func (d *XboxOne) Powered() bool {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	return d.power == PowerOn
+}
+
+// LED returns the mode and brightness of the Guide button LED last set by
+// the host.
+func (d *XboxOne) LED() (mode, brightness uint8) {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	return d.led[0], d.led[1]
+}
+
+// Degrader returns the link degradation simulator applied to streamed input.
+func (d *XboxOne) Degrader() *device.Degrader {
+	return &d.degrade
+}
+
+// Stepper returns the queue of streamed states in deterministic mode.
+func (d *XboxOne) Stepper() *device.Stepper {
+	return &d.step
+}
+
+// UpdateInputState updates the device's current input state (thread-safe).
+// A change of the Guide button is reported by a message of its own.
+func (d *XboxOne) UpdateInputState(state InputState) {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	if (state.Buttons^d.inputState.Buttons)&ButtonGuide != 0 {
+		pressed := uint8(0)
+		if state.Buttons&ButtonGuide != 0 {
+			pressed = 1
+		}
+		d.queueLocked(Message(CmdGuideButton, OptAcknowledge|OptInternal, d.nextSeqLocked(), []byte{pressed, 0x5b}))
+	}
+	d.inputState = state
+	d.inputChanged = true
+	d.notify.Notify()
+}
+
+// ResetInputState releases all buttons and triggers and centers the sticks.
+func (d *XboxOne) ResetInputState() {
+	d.UpdateInputState(InputState{})
+}
+
+// nextSeqLocked returns the sequence number of the next message the pad
+// sends; 0 is skipped.
+func (d *XboxOne) nextSeqLocked() uint8 {
+	d.seq++
+	if d.seq == 0 {
+		d.seq = 1
+	}
+	return d.seq
+}
+
+func (d *XboxOne) queueLocked(msgs ...[]byte) {
+	d.pending = append(d.pending, msgs...)
+	if over := len(d.pending) - maxPending; over > 0 {
+		d.pending = d.pending[over:]
+	}
+	d.notify.Notify()
+}
+
+// HandleTransfer serves the GIP endpoints. IN returns the next pending
+// message, otherwise an input message; unlike the real pad, input flows
+// before the host powers it on.
+func (d *XboxOne) HandleTransfer(ep uint32, dir uint32, out []byte) []byte {
+	if ep != gipEndpoint {
+		return nil
+	}
+	if dir == usbip.DirIn {
+		d.stateMu.Lock()
+		defer d.stateMu.Unlock()
+		if len(d.pending) > 0 {
+			msg := d.pending[0]
+			d.pending = d.pending[1:]
+			return msg
+		}
+		d.inputChanged = false
+		return Message(CmdInput, 0, d.nextSeqLocked(), d.inputState.BuildPayload())
+	}
+	d.handleMessage(out)
+	return nil
+}
+
+// HandleTransferStatus holds polls of the IN endpoint while no message is
+// pending and the input state is unchanged since the last input message, see
+// usb.ErrPending, and stalls endpoints other than the GIP one.
+func (d *XboxOne) HandleTransferStatus(ep uint32, dir uint32, out []byte) ([]byte, error) {
+	if ep != gipEndpoint {
+		return nil, usb.StatusStall
+	}
+	if dir == usbip.DirIn {
+		d.stateMu.Lock()
+		idle := !d.inputChanged && len(d.pending) == 0
+		d.stateMu.Unlock()
+		if idle {
+			return nil, usb.ErrPending
+		}
+	}
+	return d.HandleTransfer(ep, dir, out), nil
+}
+
+// InputNotifier returns the notifier fired by UpdateInputState and queued
+// messages.
+func (d *XboxOne) InputNotifier() *usb.Notifier {
+	return &d.notify
+}
+
+// handleMessage takes a message of the host. Authentication is a stub: every
+// step is answered with a completion, which the pad drivers tolerate.
+func (d *XboxOne) handleMessage(msg []byte) {
+	h, payload, ok := ParseMessage(msg)
+	if !ok {
+		return
+	}
+	d.stateMu.Lock()
+	if h.Options&OptAcknowledge != 0 {
+		d.queueLocked(acknowledgement(h, len(payload)))
+	}
+	var rumble *OutputState
+	switch h.Command {
+	case CmdIdentify:
+		d.queueLocked(chunked(CmdIdentify, OptInternal, d.nextSeqLocked(), metadata())...)
+	case CmdPower:
+		if len(payload) >= 1 {
+			d.power = payload[0]
+		}
+	case CmdAuthenticate:
+		d.queueLocked(Message(CmdAuthenticate, OptInternal, d.nextSeqLocked(), []byte{0x01, 0x00}))
+	case CmdLED:
+		if len(payload) >= 3 {
+			d.led = [2]uint8{payload[1], payload[2]}
+		}
+	case CmdRumble:
+		// [0] reserved, [1] motors, [2..5] magnitudes, [6..8] timing
+		if len(payload) >= 6 {
+			motors := payload[1]
+			r := d.rumble
+			for _, m := range []struct {
+				bit uint8
+				v   *uint8
+				in  uint8
+			}{
+				{MotorLeftTrigger, &r.LeftTrigger, payload[2]},
+				{MotorRightTrigger, &r.RightTrigger, payload[3]},
+				{MotorLeft, &r.Left, payload[4]},
+				{MotorRight, &r.Right, payload[5]},
+			} {
+				if motors&m.bit != 0 {
+					*m.v = m.in
+				}
+			}
+			d.rumble = r
+			rumble = &r
+		}
+	}
+	outputFunc := d.outputFunc
+	d.stateMu.Unlock()
+	if rumble != nil && outputFunc != nil {
+		outputFunc(*rumble)
+	}
+}
+
+// HandleControl answers the Microsoft OS feature descriptor request with the
+// XGIP10 compatible ID.
+func (d *XboxOne) HandleControl(bmRequestType, bRequest uint8, _ /* wValue */, wIndex, _ /* wLength */ uint16, _ []byte) ([]byte, bool) {
+	const (
+		reqTypeVendorIn      = 0xC0
+		extendedCompatIDDesc = 0x0004
+	)
+	if bmRequestType == reqTypeVendorIn && bRequest == MSOSVendorCode && wIndex == extendedCompatIDDesc {
+		return compatID, true
+	}
+	return nil, false
+}
+
+// compatID is the extended compat ID descriptor: a 16-byte header and one
+// function section for interface 0.
+var compatID = []byte{
+	0x28, 0x00, 0x00, 0x00, // dwLength
+	0x00, 0x01, // bcdVersion
+	0x04, 0x00, // wIndex
+	0x01,                                     // bCount
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // reserved
+	0x00,                                     // bFirstInterfaceNumber
+	0x01,                                     // reserved
+	'X', 'G', 'I', 'P', '1', '0', 0x00, 0x00, // compatibleID
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // subCompatibleID
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // reserved
+}
+
+var defaultDescriptor = usb.Descriptor{
+	Device: usb.DeviceDescriptor{
+		BcdUSB:             0x0200,
+		BDeviceClass:       0xff,
+		BDeviceSubClass:    0x47,
+		BDeviceProtocol:    0xd0,
+		BMaxPacketSize0:    0x40,
+		IDVendor:           0x045e,
+		IDProduct:          0x0b12,
+		BcdDevice:          0x0507,
+		IManufacturer:      0x01,
+		IProduct:           0x02,
+		ISerialNumber:      0x03,
+		BNumConfigurations: 0x01,
+		Speed:              2, // Full speed
+	},
+	// Only the GIP interface; the audio and firmware update interfaces of
+	// the real pad are left out.
+	Interfaces: []usb.InterfaceConfig{
+		{
+			Descriptor: usb.InterfaceDescriptor{
+				BInterfaceNumber:   0x00,
+				BAlternateSetting:  0x00,
+				BNumEndpoints:      0x02,
+				BInterfaceClass:    0xff,
+				BInterfaceSubClass: 0x47,
+				BInterfaceProtocol: 0xd0,
+			},
+			Endpoints: []usb.EndpointDescriptor{
+				{BEndpointAddress: 0x02, BMAttributes: 0x03, WMaxPacketSize: 0x0040, BInterval: 0x04},
+				{BEndpointAddress: 0x82, BMAttributes: 0x03, WMaxPacketSize: 0x0040, BInterval: 0x04},
+			},
+		},
+	},
+	Strings: map[uint8]string{
+		0:               "\x04\x09", // LangID: en-US (0x0409)
+		1:               "Microsoft",
+		2:               "VIIPER Controller",
+		3:               "3039373130303637",
+		MSOSStringIndex: "MSFT100" + string(rune(MSOSVendorCode)),
+	},
+}
+
+func (d *XboxOne) GetDescriptor() *usb.Descriptor {
+	return &d.descriptor
+}
+
+func (d *XboxOne) GetDeviceSpecificArgs() map[string]any {
+	return map[string]any{}
+}
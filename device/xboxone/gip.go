@@ -0,0 +1,120 @@
+package xboxone
+
+import "encoding/binary"
+
+// maxChunk is the payload of a chunk, keeping chunks with their header
+// within the 64-byte packets of the endpoints.
+const maxChunk = 58
+
+// Header is the header of a GIP message.
+type Header struct {
+	Command  uint8
+	Options  uint8
+	Sequence uint8
+	Length   int
+	// Offset is the offset of a chunk, or for the first chunk the length of
+	// the whole message.
+	Offset int
+}
+
+// Message encodes a GIP message without chunking.
+func Message(cmd, opts, seq uint8, payload []byte) []byte {
+	b := []byte{cmd, opts, seq}
+	b = binary.AppendUvarint(b, uint64(len(payload)))
+	return append(b, payload...)
+}
+
+// ParseMessage splits a GIP message into its header and payload. ok is false
+// for a truncated message.
+func ParseMessage(b []byte) (h Header, payload []byte, ok bool) {
+	if len(b) < 4 {
+		return Header{}, nil, false
+	}
+	h = Header{Command: b[0], Options: b[1], Sequence: b[2]}
+	rest := b[3:]
+	length, n := binary.Uvarint(rest)
+	if n <= 0 {
+		return Header{}, nil, false
+	}
+	rest = rest[n:]
+	h.Length = int(length)
+	if h.Options&OptChunk != 0 {
+		offset, n := binary.Uvarint(rest)
+		if n <= 0 {
+			return Header{}, nil, false
+		}
+		rest = rest[n:]
+		h.Offset = int(offset)
+	}
+	if len(rest) < h.Length {
+		return Header{}, nil, false
+	}
+	return h, rest[:h.Length], true
+}
+
+// chunked encodes payload as chunks of the message cmd: a first chunk
+// carrying the total length, one per further maxChunk bytes carrying their
+// offset and an empty one marking the end.
+func chunked(cmd, opts, seq uint8, payload []byte) [][]byte {
+	var out [][]byte
+	for off := 0; off < len(payload); off += maxChunk {
+		data := payload[off:min(off+maxChunk, len(payload))]
+		o, pos := opts|OptChunk, off
+		if off == 0 {
+			o |= OptChunkStart | OptAcknowledge
+			pos = len(payload)
+		}
+		b := []byte{cmd, o, seq}
+		b = binary.AppendUvarint(b, uint64(len(data)))
+		b = binary.AppendUvarint(b, uint64(pos))
+		out = append(out, append(b, data...))
+	}
+	b := []byte{cmd, opts | OptChunk, seq, 0}
+	return append(out, binary.AppendUvarint(b, uint64(len(payload))))
+}
+
+// acknowledgement answers a message with OptAcknowledge.
+func acknowledgement(h Header, received int) []byte {
+	p := make([]byte, 9)
+	p[1] = h.Command
+	p[2] = h.Options & OptInternal
+	binary.LittleEndian.PutUint16(p[3:5], uint16(received))
+	return Message(CmdAcknowledge, OptInternal, h.Sequence, p)
+}
+
+// gamepadInterface is the GUID of the gamepad interface in its wire byte
+// order, {082E402C-07DF-45E1-A5AB-A3127AF197B5}.
+var gamepadInterface = []byte{
+	0x2c, 0x40, 0x2e, 0x08, 0xdf, 0x07, 0xe1, 0x45,
+	0xa5, 0xab, 0xa3, 0x12, 0x7a, 0xf1, 0x97, 0xb5,
+}
+
+// metadata returns the answer to CmdIdentify. It starts with 16 bytes the
+// drivers skip and the offsets of eight blocks, 0 for an absent one: the
+// commands understood beyond the system ones, firmware versions, audio
+// formats, output and input capabilities, classes, interfaces and a HID
+// descriptor. Each block is a count followed by its entries.
+func metadata() []byte {
+	str := func(s string) []byte {
+		return append(binary.LittleEndian.AppendUint16(nil, uint16(len(s))), s...)
+	}
+	blocks := [][]byte{
+		{2, CmdGuideButton, CmdRumble},
+		{1, 0x05, 0x00, 0x11, 0x00},
+		nil,
+		{0},
+		{0},
+		append(append([]byte{2}, str("Windows.Xbox.Input.Gamepad")...), str("Windows.Xbox.Input.NavigationController")...),
+		append([]byte{1}, gamepadInterface...),
+		nil,
+	}
+	b := make([]byte, 16+2*len(blocks))
+	for i, blk := range blocks {
+		if blk == nil {
+			continue
+		}
+		binary.LittleEndian.PutUint16(b[16+2*i:], uint16(len(b)))
+		b = append(b, blk...)
+	}
+	return b
+}
@@ -0,0 +1,94 @@
+package xboxone
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+func init() {
+	api.RegisterDevice("xboxone", &handler{})
+	device.RegisterOutputDecoder("xboxone", device.OutputDecoder{
+		Size: OutputLayout.Size(),
+		New:  func() encoding.BinaryUnmarshaler { return new(OutputState) },
+	})
+}
+
+type handler struct{}
+
+func (h *handler) CreateDevice(o *device.CreateOptions) (usb.Device, error) { return New(o) }
+
+func (h *handler) InputLayout(usb.Device) device.WireLayout { return InputLayout }
+
+func (h *handler) OutputLayout(usb.Device) device.WireLayout { return OutputLayout }
+
+func (h *handler) MacroState(dev usb.Device, state []byte) (func(), error) {
+	pad, ok := dev.(*XboxOne)
+	if !ok {
+		return nil, fmt.Errorf("device is not xboxone")
+	}
+	var st InputState
+	if err := json.Unmarshal(state, &st); err != nil {
+		return nil, err
+	}
+	return func() { pad.UpdateInputState(st) }, nil
+}
+
+func (h *handler) StreamHandler() api.StreamHandlerFunc {
+	return func(conn net.Conn, devPtr *usb.Device, logger *slog.Logger) error {
+		if devPtr == nil || *devPtr == nil {
+			return fmt.Errorf("nil device")
+		}
+		pad, ok := (*devPtr).(*XboxOne)
+		if !ok {
+			return fmt.Errorf("device is not xboxone")
+		}
+
+		pad.SetOutputCallback(func(rumble OutputState) {
+			data, err := rumble.MarshalBinary()
+			if err != nil {
+				logger.Error("failed to marshal rumble", "error", err)
+				return
+			}
+			if _, err := conn.Write(data); err != nil {
+				logger.Error("failed to send rumble", "error", err)
+			}
+		})
+
+		buf := make([]byte, 16)
+		for {
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				if err == io.EOF {
+					logger.Info("client disconnected")
+					return nil
+				}
+				return fmt.Errorf("read input state: %w", err)
+			}
+
+			var state InputState
+			if err := state.UnmarshalBinary(buf); err != nil {
+				return fmt.Errorf("unmarshal input state: %w", err)
+			}
+			if pad.step.Active() {
+				st := state
+				if err := pad.step.Push(func() { pad.UpdateInputState(st) }); err != nil {
+					return err
+				}
+				continue
+			}
+			if pad.degrade.Active() {
+				st := state
+				pad.degrade.Apply(func() { pad.UpdateInputState(st) })
+				continue
+			}
+			pad.UpdateInputState(state)
+		}
+	}
+}
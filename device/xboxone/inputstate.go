@@ -0,0 +1,122 @@
+package xboxone
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/Alia5/VIIPER/device"
+)
+
+// InputState is the controller state streamed by clients. Triggers range
+// from 0 to TriggerMax; sticks are signed 16-bit values with up and right
+// positive, like XInput's.
+// viiper:wire xboxone c2s buttons:u32 lt:u16:0..1023 rt:u16:0..1023 lx:i16 ly:i16 rx:i16 ry:i16
+type InputState struct {
+	Buttons uint32
+	LT, RT  uint16
+	LX, LY  int16
+	RX, RY  int16
+}
+
+// InputLayout is the field layout of the InputState wire format, used for delta updates.
+var InputLayout = device.WireLayout{
+	{Name: "buttons", Size: 4},
+	{Name: "lt", Size: 2, Range: &triggerRange},
+	{Name: "rt", Size: 2, Range: &triggerRange},
+	{Name: "lx", Size: 2},
+	{Name: "ly", Size: 2},
+	{Name: "rx", Size: 2},
+	{Name: "ry", Size: 2},
+}
+
+var triggerRange = device.ValueRange{Min: 0, Max: TriggerMax}
+
+// inputPayloadSize is the payload of the input message of firmware 5.x pads,
+// which report Share at shareOffset.
+const (
+	inputPayloadSize = 44
+	shareOffset      = 18
+)
+
+// BuildPayload encodes the payload of the CmdInput message:
+//
+//	 0-1: Buttons (low 16 bits, little-endian)
+//	 2-3: LT (0-1023)
+//	 4-5: RT (0-1023)
+//	 6-13: LX, LY, RX, RY (little-endian int16)
+//	18: Share (bit 0)
+//
+// The remaining bytes are zero.
+func (s *InputState) BuildPayload() []byte {
+	b := make([]byte, inputPayloadSize)
+	binary.LittleEndian.PutUint16(b[0:2], uint16(s.Buttons&0xffff))
+	binary.LittleEndian.PutUint16(b[2:4], min(s.LT, TriggerMax))
+	binary.LittleEndian.PutUint16(b[4:6], min(s.RT, TriggerMax))
+	binary.LittleEndian.PutUint16(b[6:8], uint16(s.LX))
+	binary.LittleEndian.PutUint16(b[8:10], uint16(s.LY))
+	binary.LittleEndian.PutUint16(b[10:12], uint16(s.RX))
+	binary.LittleEndian.PutUint16(b[12:14], uint16(s.RY))
+	if s.Buttons&ButtonShare != 0 {
+		b[shareOffset] = 0x01
+	}
+	return b
+}
+
+// MarshalBinary encodes InputState to 16 bytes.
+func (s *InputState) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 16)
+	binary.LittleEndian.PutUint32(b[0:4], s.Buttons)
+	binary.LittleEndian.PutUint16(b[4:6], s.LT)
+	binary.LittleEndian.PutUint16(b[6:8], s.RT)
+	for i, v := range []int16{s.LX, s.LY, s.RX, s.RY} {
+		binary.LittleEndian.PutUint16(b[8+2*i:], uint16(v))
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes 16 bytes into InputState.
+func (s *InputState) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return io.ErrUnexpectedEOF
+	}
+	s.Buttons = binary.LittleEndian.Uint32(data[0:4])
+	s.LT = binary.LittleEndian.Uint16(data[4:6])
+	s.RT = binary.LittleEndian.Uint16(data[6:8])
+	for i, v := range []*int16{&s.LX, &s.LY, &s.RX, &s.RY} {
+		*v = int16(binary.LittleEndian.Uint16(data[8+2*i:]))
+	}
+	return nil
+}
+
+// OutputState is the rumble state sent from device to client whenever the
+// host changes it. Magnitudes are those of the GIP rumble command, 0-100 for
+// the pads' own driver.
+// viiper:wire xboxone s2c leftTrigger:u8 rightTrigger:u8 left:u8 right:u8
+type OutputState struct {
+	LeftTrigger  uint8
+	RightTrigger uint8
+	Left         uint8 // big / low-frequency motor
+	Right        uint8 // small / high-frequency motor
+}
+
+// OutputLayout is the field layout of the OutputState wire format.
+var OutputLayout = device.WireLayout{
+	{Name: "leftTrigger", Size: 1},
+	{Name: "rightTrigger", Size: 1},
+	{Name: "left", Size: 1},
+	{Name: "right", Size: 1},
+}
+
+// MarshalBinary encodes OutputState to 4 bytes.
+func (o *OutputState) MarshalBinary() ([]byte, error) {
+	return []byte{o.LeftTrigger, o.RightTrigger, o.Left, o.Right}, nil
+}
+
+// UnmarshalBinary decodes 4 bytes into OutputState.
+func (o *OutputState) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return io.ErrUnexpectedEOF
+	}
+	o.LeftTrigger, o.RightTrigger, o.Left, o.Right = data[0], data[1], data[2], data[3]
+	return nil
+}
@@ -0,0 +1,339 @@
+package xboxone_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/device/xboxone"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/Alia5/VIIPER/internal/registry" // Register devices
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// payload pads the leading bytes of an input payload to its 44 bytes.
+func payload(b ...byte) []byte {
+	return append(b, make([]byte, 44-len(b))...)
+}
+
+// setup adds an xboxone device through the API, imports it and returns
+// the device stream and the imported connection.
+func setup(t *testing.T) (*apiclient.DeviceStream, *viiperTesting.TestUsbIpClient, net.Conn) {
+	t.Helper()
+	s := viiperTesting.NewTestServer(t)
+	t.Cleanup(func() { _ = s.UsbServer.Close() })
+	t.Cleanup(s.ApiServer.Close)
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(1)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+	_ = s.UsbServer.AddBus(b)
+
+	client := apiclient.New(s.ApiServer.Addr())
+	stream, _, err := client.AddDeviceAndConnect(context.Background(), b.BusID(), "xboxone", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = stream.Close() })
+
+	usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	devs, err := usbipClient.ListDevices()
+	require.NoError(t, err)
+	require.Len(t, devs, 1)
+	imp, err := usbipClient.AttachDevice(devs[0].BusID)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = imp.Conn.Close() })
+	return stream, usbipClient, imp.Conn
+}
+
+// readMessage reads the next GIP message from the IN endpoint.
+func readMessage(t *testing.T, c *viiperTesting.TestUsbIpClient, conn net.Conn) (xboxone.Header, []byte) {
+	t.Helper()
+	msg, err := c.ReadEndpointWithTimeout(conn, 2, 250*time.Millisecond)
+	require.NoError(t, err)
+	h, p, ok := xboxone.ParseMessage(msg)
+	require.True(t, ok, "message % x", msg)
+	return h, p
+}
+
+// readUntil reads messages until one of command cmd whose payload passes
+// match, if given, and returns it.
+func readUntil(t *testing.T, c *viiperTesting.TestUsbIpClient, conn net.Conn, cmd uint8, match func([]byte) bool) (xboxone.Header, []byte) {
+	t.Helper()
+	deadline := time.Now().Add(750 * time.Millisecond)
+	for {
+		h, p := readMessage(t, c, conn)
+		if h.Command == cmd && (match == nil || match(p)) {
+			return h, p
+		}
+		require.True(t, time.Now().Before(deadline), "no message %#02x", cmd)
+	}
+}
+
+func TestInputReports(t *testing.T) {
+
+	type testCase struct {
+		name            string
+		inputState      xboxone.InputState
+		expectedPayload []byte
+	}
+
+	cases := []testCase{
+		{
+			name:            "button a",
+			inputState:      xboxone.InputState{Buttons: xboxone.ButtonA},
+			expectedPayload: payload(0x10, 0x00),
+		},
+		{
+			name:            "button menu",
+			inputState:      xboxone.InputState{Buttons: xboxone.ButtonMenu},
+			expectedPayload: payload(0x04, 0x00),
+		},
+		{
+			name:            "dpad right",
+			inputState:      xboxone.InputState{Buttons: xboxone.ButtonDPadRight},
+			expectedPayload: payload(0x00, 0x08),
+		},
+		{
+			name:            "button rthumb",
+			inputState:      xboxone.InputState{Buttons: xboxone.ButtonRThumb},
+			expectedPayload: payload(0x00, 0x80),
+		},
+		{
+			name:       "button share",
+			inputState: xboxone.InputState{Buttons: xboxone.ButtonShare},
+			expectedPayload: payload(
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x01,
+			),
+		},
+		{
+			name:            "triggers max",
+			inputState:      xboxone.InputState{LT: xboxone.TriggerMax, RT: xboxone.TriggerMax},
+			expectedPayload: payload(0x00, 0x00, 0xff, 0x03, 0xff, 0x03),
+		},
+		{
+			name:            "trigger clamped",
+			inputState:      xboxone.InputState{LT: 0xffff},
+			expectedPayload: payload(0x00, 0x00, 0xff, 0x03),
+		},
+		{
+			name: "buttons axes combo",
+			inputState: xboxone.InputState{
+				Buttons: xboxone.ButtonX | xboxone.ButtonY | xboxone.ButtonLShoulder | xboxone.ButtonRShoulder,
+				LT:      1,
+				RT:      1022,
+				LX:      111,
+				LY:      -222,
+				RX:      333,
+				RY:      -444,
+			},
+			expectedPayload: payload(0xc0, 0x30, 0x01, 0x00, 0xfe, 0x03, 0x6f, 0x00, 0x22, 0xff, 0x4d, 0x01, 0x44, 0xfe),
+		},
+	}
+
+	stream, usbipClient, conn := setup(t)
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedPayload, tc.inputState.BuildPayload())
+			require.NoError(t, stream.WriteBinary(&tc.inputState))
+			readUntil(t, usbipClient, conn, xboxone.CmdInput, func(p []byte) bool {
+				return assert.ObjectsAreEqual(tc.expectedPayload, p)
+			})
+		})
+	}
+}
+
+func TestGuideButton(t *testing.T) {
+	stream, usbipClient, conn := setup(t)
+
+	require.NoError(t, stream.WriteBinary(&xboxone.InputState{Buttons: xboxone.ButtonGuide}))
+	h, p := readUntil(t, usbipClient, conn, xboxone.CmdGuideButton, nil)
+	assert.Equal(t, []byte{0x01, 0x5b}, p)
+	assert.Equal(t, uint8(xboxone.OptAcknowledge|xboxone.OptInternal), h.Options)
+
+	require.NoError(t, stream.WriteBinary(&xboxone.InputState{}))
+	_, p = readUntil(t, usbipClient, conn, xboxone.CmdGuideButton, nil)
+	assert.Equal(t, []byte{0x00, 0x5b}, p)
+}
+
+func TestHandshake(t *testing.T) {
+	_, usbipClient, conn := setup(t)
+
+	h, p := readMessage(t, usbipClient, conn)
+	require.Equal(t, uint8(xboxone.CmdAnnounce), h.Command, "the pad announces itself first")
+	require.Len(t, p, 28)
+	assert.Equal(t, uint16(0x045e), binary.LittleEndian.Uint16(p[8:10]))
+	assert.Equal(t, uint16(0x0b12), binary.LittleEndian.Uint16(p[10:12]))
+
+	// Identify, acknowledged and answered in chunks.
+	send := func(msg []byte) {
+		t.Helper()
+		require.NoError(t, usbipClient.Submit(conn, usbip.DirOut, 2, msg, nil))
+	}
+	send(xboxone.Message(xboxone.CmdIdentify, xboxone.OptAcknowledge|xboxone.OptInternal, 7, nil))
+	h, p = readMessage(t, usbipClient, conn)
+	assert.Equal(t, uint8(xboxone.CmdAcknowledge), h.Command)
+	assert.Equal(t, uint8(7), h.Sequence)
+	assert.Equal(t, uint8(xboxone.CmdIdentify), p[1])
+
+	var meta []byte
+	h, p = readMessage(t, usbipClient, conn)
+	require.Equal(t, uint8(xboxone.CmdIdentify), h.Command)
+	require.NotZero(t, h.Options&xboxone.OptChunkStart)
+	total := h.Offset
+	meta = append(meta, p...)
+	for {
+		h, p = readMessage(t, usbipClient, conn)
+		require.Equal(t, uint8(xboxone.CmdIdentify), h.Command)
+		require.NotZero(t, h.Options&xboxone.OptChunk)
+		if len(p) == 0 {
+			assert.Equal(t, total, h.Offset, "the end marker carries the length")
+			break
+		}
+		assert.Equal(t, len(meta), h.Offset)
+		meta = append(meta, p...)
+	}
+	require.Len(t, meta, total)
+	assert.Contains(t, string(meta), "Windows.Xbox.Input.Gamepad")
+
+	send(xboxone.Message(xboxone.CmdAuthenticate, xboxone.OptInternal, 8, []byte{0x01, 0x02}))
+	h, p = readMessage(t, usbipClient, conn)
+	assert.Equal(t, uint8(xboxone.CmdAuthenticate), h.Command)
+	assert.Equal(t, []byte{0x01, 0x00}, p, "auth stub completes")
+
+	send(xboxone.Message(xboxone.CmdPower, xboxone.OptInternal, 9, []byte{xboxone.PowerOn}))
+	send(xboxone.Message(xboxone.CmdLED, xboxone.OptInternal, 10, []byte{0x00, 0x01, 0x14}))
+	h, _ = readMessage(t, usbipClient, conn)
+	assert.Equal(t, uint8(xboxone.CmdInput), h.Command, "input once the handshake is through")
+
+	// Windows binds its GIP driver through the compatible ID.
+	osString, err := usbipClient.Control(conn, [8]byte{0x80, 0x06, xboxone.MSOSStringIndex, 0x03, 0x00, 0x00, 0x12, 0x00}, nil)
+	require.NoError(t, err)
+	require.Len(t, osString, 18)
+	assert.Equal(t, uint8(xboxone.MSOSVendorCode), osString[16])
+	compat, err := usbipClient.Control(conn, [8]byte{0xc0, xboxone.MSOSVendorCode, 0x00, 0x00, 0x04, 0x00, 0x28, 0x00}, nil)
+	require.NoError(t, err)
+	require.Len(t, compat, 40)
+	assert.Equal(t, "XGIP10", string(compat[18:24]))
+}
+
+func TestRumble(t *testing.T) {
+
+	type testCase struct {
+		name        string
+		rumbleState xboxone.OutputState
+		outPacket   []byte
+	}
+	cases := []testCase{
+		{
+			name:        "all motors",
+			rumbleState: xboxone.OutputState{LeftTrigger: 10, RightTrigger: 20, Left: 30, Right: 40},
+			outPacket:   []byte{0x09, 0x00, 0x01, 0x09, 0x00, 0x0f, 10, 20, 30, 40, 0xff, 0x00, 0xff},
+		},
+		{
+			name:        "main motors only",
+			rumbleState: xboxone.OutputState{LeftTrigger: 10, RightTrigger: 20, Left: 100, Right: 0},
+			outPacket:   []byte{0x09, 0x00, 0x02, 0x09, 0x00, 0x03, 99, 99, 100, 0, 0xff, 0x00, 0xff},
+		},
+		{
+			name:        "off",
+			rumbleState: xboxone.OutputState{},
+			outPacket:   []byte{0x09, 0x00, 0x03, 0x09, 0x00, 0x0f, 0, 0, 0, 0, 0x00, 0x00, 0x00},
+		},
+	}
+
+	stream, usbipClient, conn := setup(t)
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if !assert.NoError(t, usbipClient.Submit(conn, usbip.DirOut, 2, tc.outPacket, nil)) {
+				return
+			}
+			var buf [4]byte
+			_ = stream.SetReadDeadline(time.Now().Add(750 * time.Millisecond))
+			_, err := io.ReadFull(stream, buf[:])
+			if !assert.NoError(t, err) {
+				return
+			}
+			var got xboxone.OutputState
+			require.NoError(t, got.UnmarshalBinary(buf[:]))
+			assert.Equal(t, tc.rumbleState, got)
+		})
+	}
+
+}
+
+// TestOutputCallbackConcurrent swaps the callback while rumble arrives, as
+// a stream attaching to a device the host already drives does; run it with
+// -race.
+func TestOutputCallbackConcurrent(t *testing.T) {
+	d, err := xboxone.New(nil)
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			d.SetOutputCallback(func(xboxone.OutputState) {})
+		}
+	}()
+	for i := range 100 {
+		d.HandleTransfer(2, usbip.DirOut, []byte{0x09, 0x00, byte(i), 0x09, 0x00, 0x0f, 10, 20, byte(i), 40, 0xff, 0x00, 0xff})
+	}
+	wg.Wait()
+}
+
+func TestHeldInputMessages(t *testing.T) {
+	d, err := xboxone.New(nil)
+	require.NoError(t, err)
+	var _ usb.PendingDevice = d
+
+	read := func() []byte {
+		t.Helper()
+		msg, err := d.HandleTransferStatus(2, usbip.DirIn, nil)
+		require.NoError(t, err)
+		return msg
+	}
+	assert.Equal(t, byte(xboxone.CmdAnnounce), read()[0], "the announce message is not held")
+	assert.Equal(t, byte(xboxone.CmdInput), read()[0], "nor is the first input message")
+	_, err = d.HandleTransferStatus(2, usbip.DirIn, nil)
+	assert.ErrorIs(t, err, usb.ErrPending, "unchanged input is held")
+
+	wake := d.InputNotifier().Wait()
+	d.UpdateInputState(xboxone.InputState{Buttons: xboxone.ButtonA})
+	select {
+	case <-wake:
+	default:
+		t.Error("UpdateInputState does not wake held polls")
+	}
+	assert.Equal(t, byte(xboxone.CmdInput), read()[0])
+
+	wake = d.InputNotifier().Wait()
+	d.UpdateInputState(xboxone.InputState{Buttons: xboxone.ButtonA | xboxone.ButtonGuide})
+	select {
+	case <-wake:
+	default:
+		t.Error("the Guide button does not wake held polls")
+	}
+	assert.Equal(t, byte(xboxone.CmdGuideButton), read()[0])
+	assert.Equal(t, byte(xboxone.CmdInput), read()[0])
+	_, err = d.HandleTransferStatus(2, usbip.DirIn, nil)
+	assert.ErrorIs(t, err, usb.ErrPending)
+}
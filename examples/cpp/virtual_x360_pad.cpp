@@ -90,11 +90,7 @@ int main(int argc, char** argv) {
         std::exit(0);
     });
 
-    stream->on_output(viiper::xbox360::OUTPUT_SIZE, [](const std::uint8_t* data, std::size_t len) {
-        if (len < viiper::xbox360::OUTPUT_SIZE) return;
-        auto result = viiper::xbox360::Output::from_bytes(data, len);
-        if (result.is_error()) return;
-        auto& rumble = result.value();
+    stream->on_output<viiper::xbox360::Output>([](const viiper::xbox360::Output& rumble) {
         std::cout << "← Rumble: Left=" << static_cast<int>(rumble.left)
                   << ", Right=" << static_cast<int>(rumble.right) << "\n";
     });
@@ -36,11 +36,11 @@ bool createdBus = false;
 }
 
 // Add device and connect
-Device resp; ViiperDevice device;
+Device resp; Xbox360Stream device;
 try
 {
     resp = await client.BusDeviceAddAsync(busId, new DeviceCreateRequest { Type = "xbox360" });
-    device = await client.ConnectDeviceAsync(resp.BusID, resp.DevId);
+    device = await Xbox360Stream.ConnectAsync(client, resp.BusID, resp.DevId);
     Console.WriteLine($"Created and connected to device {resp.DevId} on bus {resp.BusID}");
 }
 catch (Exception ex)
@@ -59,18 +59,22 @@ async Task Cleanup()
     if (createdBus) { try { await client.BusRemoveAsync(busId); Console.WriteLine($"Removed bus {busId}"); } catch { } }
 }
 
-// Read rumble output using callback with stream
-device.OnOutput = async stream =>
+// Read rumble output as it arrives
+_ = Task.Run(async () =>
 {
-    var buf = new byte[Xbox360.OutputSize];
-    await stream.ReadAsync(buf, 0, buf.Length);
-    byte left = buf[0]; 
-    byte right = buf[1];
-    Console.WriteLine($"← Rumble: Left={left}, Right={right}");
-};
-
-// Handle disconnect
-device.OnDisconnect = () => Console.WriteLine("!!! Server disconnected");
+    try
+    {
+        await foreach (var rumble in device.ReadOutputsAsync())
+        {
+            Console.WriteLine($"← Rumble: Left={rumble.Left}, Right={rumble.Right}");
+        }
+        Console.WriteLine("!!! Server disconnected");
+    }
+    catch (Exception ex)
+    {
+        Console.WriteLine($"!!! Output stream error: {ex.Message}");
+    }
+});
 
 // Send inputs at ~60 FPS
 var sw = new PeriodicTimer(TimeSpan.FromMilliseconds(16));
@@ -89,7 +93,7 @@ while (await sw.WaitForNextTickAsync())
         Rx = 0,
         Ry = 0,
     };
-    await device.SendAsync(state);
+    await device.WriteAsync(state);
     if (frame % 60 == 0)
         Console.WriteLine($"→ Sent input (frame {frame}): buttons=0x{buttons:X4}, LT={state.Lt}, RT={state.Rt}");
 }
@@ -0,0 +1,165 @@
+// custom_device runs an embedded VIIPER server with a device type of its
+// own: a HID device with a single button, pressed and released every second.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/server"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usb/hid"
+	"github.com/Alia5/VIIPER/usbip"
+)
+
+// Button is a HID device reporting one button in a 1-byte report.
+type Button struct {
+	pressed    atomic.Bool
+	descriptor usb.Descriptor
+}
+
+func newButton(o *device.CreateOptions) (usb.Device, error) {
+	b := &Button{descriptor: descriptor}
+	if o != nil {
+		if err := o.ApplyIdentity(&b.descriptor); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// HandleInput takes the 1-byte input packets of the device stream.
+func (b *Button) HandleInput(packet []byte) error {
+	b.pressed.Store(packet[0] != 0)
+	return nil
+}
+
+func (b *Button) HandleTransfer(ep uint32, dir uint32, out []byte) []byte {
+	if ep != 1 || dir != usbip.DirIn {
+		return nil
+	}
+	if b.pressed.Load() {
+		return []byte{0x01}
+	}
+	return []byte{0x00}
+}
+
+func (b *Button) GetDescriptor() *usb.Descriptor { return &b.descriptor }
+
+func (b *Button) GetDeviceSpecificArgs() map[string]any { return map[string]any{} }
+
+var descriptor = usb.Descriptor{
+	Device: usb.DeviceDescriptor{
+		BcdUSB:             0x0200,
+		BMaxPacketSize0:    0x40,
+		IDVendor:           0x1209,
+		IDProduct:          0x0001,
+		BcdDevice:          0x0100,
+		IManufacturer:      0x01,
+		IProduct:           0x02,
+		BNumConfigurations: 0x01,
+		Speed:              2, // Full speed
+	},
+	Interfaces: []usb.InterfaceConfig{
+		{
+			Descriptor: usb.InterfaceDescriptor{
+				BNumEndpoints:   0x01,
+				BInterfaceClass: 0x03, // HID
+			},
+			HID: &usb.HIDFunction{
+				Descriptor: usb.HIDDescriptor{
+					BcdHID:      0x0111,
+					Descriptors: []usb.HIDSubDescriptor{{Type: usb.ReportDescType}},
+				},
+				Report: hid.Report{Items: []hid.Item{
+					hid.UsagePage{Page: hid.UsagePageGenericDesktop},
+					hid.Usage{Usage: hid.UsageGamePad},
+					hid.Collection{Kind: hid.CollectionApplication, Items: []hid.Item{
+						hid.UsagePage{Page: hid.UsagePageButton},
+						hid.UsageMinimum{Min: 0x01},
+						hid.UsageMaximum{Max: 0x01},
+						hid.LogicalMinimum{Min: 0},
+						hid.LogicalMaximum{Max: 1},
+						hid.ReportCount{Count: 1},
+						hid.ReportSize{Bits: 1},
+						hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+						hid.ReportSize{Bits: 7},
+						hid.Input{Flags: hid.MainConst},
+					}},
+				}},
+			},
+			Endpoints: []usb.EndpointDescriptor{
+				{
+					BEndpointAddress: 0x81,
+					BMAttributes:     0x03, // Interrupt
+					WMaxPacketSize:   0x0008,
+					BInterval:        0x0A,
+				},
+			},
+		},
+	},
+	Strings: map[uint8]string{
+		0: "\x04\x09", // LangID: en-US (0x0409)
+		1: "VIIPER",
+		2: "Single Button",
+	},
+}
+
+func main() {
+	device.Register("button", newButton, device.WireInfo{InputSize: 1})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv, err := server.New(server.Options{Logger: slog.Default()})
+	if err != nil {
+		fmt.Printf("Server error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := srv.Start(ctx); err != nil {
+		fmt.Printf("Server error: %v\n", err)
+		os.Exit(1)
+	}
+	defer srv.Close()
+
+	api := apiclient.New(srv.APIAddr())
+	bus, err := api.BusCreateCtx(ctx, 0)
+	if err != nil {
+		fmt.Printf("BusCreate failed: %v\n", err)
+		os.Exit(1)
+	}
+	stream, dev, err := api.AddDeviceAndConnect(ctx, bus.BusID, "button", nil)
+	if err != nil {
+		fmt.Printf("AddDeviceAndConnect error: %v\n", err)
+		os.Exit(1)
+	}
+	defer stream.Close()
+	fmt.Printf("Created button %s on bus %d, press Ctrl+C to stop\n", dev.DevId, dev.BusID)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	pressed := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pressed = !pressed
+			state := byte(0)
+			if pressed {
+				state = 1
+			}
+			if _, err := stream.Write([]byte{state}); err != nil {
+				fmt.Printf("Write error: %v\n", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apiclient/dsu"
+	"github.com/Alia5/VIIPER/device/dualshock4"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println("Usage: dsu_bridge <api_addr> [pads]")
+		fmt.Println("Example: dsu_bridge localhost:3242 2")
+		os.Exit(1)
+	}
+
+	addr := os.Args[1]
+	pads := 1
+	if len(os.Args) > 2 {
+		n, err := strconv.Atoi(os.Args[2])
+		if err != nil || n < 1 || n > dsu.MaxSlots {
+			fmt.Printf("pads must be between 1 and %d\n", dsu.MaxSlots)
+			os.Exit(1)
+		}
+		pads = n
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	api := apiclient.New(addr)
+
+	r, err := api.BusCreateCtx(ctx, 0)
+	if err != nil {
+		fmt.Printf("BusCreate failed: %v\n", err)
+		os.Exit(1)
+	}
+	busID := r.BusID
+	defer func() { _, _ = api.BusRemoveCtx(context.Background(), busID) }()
+
+	udp, err := net.ListenPacket("udp", fmt.Sprintf(":%d", dsu.DefaultPort))
+	if err != nil {
+		fmt.Printf("Listen failed: %v\n", err)
+		return
+	}
+	defer udp.Close()
+	bridge := dsu.NewServer(udp, nil)
+	go func() {
+		if err := bridge.Serve(ctx); err != nil && ctx.Err() == nil {
+			fmt.Printf("DSU server error: %v\n", err)
+			cancel()
+		}
+	}()
+
+	// Slot i is fed by the i-th DualShock 4 created here.
+	bound := make([]*dsu.Pad, 0, pads)
+	for slot := range pads {
+		stream, dev, err := api.AddDeviceAndConnect(ctx, busID, "dualshock4", nil)
+		if err != nil {
+			fmt.Printf("AddDeviceAndConnect error: %v\n", err)
+			return
+		}
+		defer stream.Close()
+		pad, err := bridge.Bind(slot, stream)
+		if err != nil {
+			fmt.Printf("Bind error: %v\n", err)
+			return
+		}
+		defer pad.Close()
+		bound = append(bound, pad)
+		fmt.Printf("DualShock 4 %d-%s serves DSU slot %d\n", dev.BusID, dev.DevId, slot)
+	}
+	fmt.Printf("DSU server on UDP port %d. Press Ctrl+C to exit.\n", dsu.DefaultPort)
+	fmt.Println("Demo: rocking the pads back and forth...")
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	start := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			// Pitch oscillates by ±30° at 0.5 Hz; the gyro reports its derivative.
+			phase := 2 * math.Pi * 0.5 * time.Since(start).Seconds()
+			pitch := 30 * math.Pi / 180 * math.Sin(phase)
+			pitchRate := 30 * 2 * math.Pi * 0.5 * math.Cos(phase)
+			state := dualshock4.InputState{
+				GyroX:  dualshock4.GyroDpsToRaw(pitchRate),
+				AccelY: dualshock4.AccelMS2ToRaw(dualshock4.StandardGravityMS2 * math.Sin(pitch)),
+				AccelZ: dualshock4.AccelMS2ToRaw(-dualshock4.StandardGravityMS2 * math.Cos(pitch)),
+			}
+			for _, pad := range bound {
+				if err := pad.Send(&state); err != nil {
+					fmt.Printf("Send error: %v\n", err)
+					return
+				}
+			}
+		case <-ctx.Done():
+			fmt.Println("\nShutting down...")
+			return
+		}
+	}
+}
@@ -1,11 +1,8 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"encoding"
 	"fmt"
-	"io"
 	"math"
 	"os"
 	"os/signal"
@@ -13,6 +10,7 @@ import (
 	"time"
 
 	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apiclient/dualshock4client"
 	"github.com/Alia5/VIIPER/device/dualshock4"
 )
 
@@ -55,9 +53,9 @@ func main() {
 	}
 
 	// Add device and connect to stream in one call
-	stream, addResp, err := api.AddDeviceAndConnect(ctx, busID, "dualshock4", nil)
+	stream, addResp, err := dualshock4client.AddAndConnect(ctx, api, busID, nil)
 	if err != nil {
-		fmt.Printf("AddDeviceAndConnect error: %v\n", err)
+		fmt.Printf("AddAndConnect error: %v\n", err)
 		if createdBus {
 			_, _ = api.BusRemoveCtx(ctx, busID)
 		}
@@ -82,23 +80,15 @@ func main() {
 		}
 	}()
 
-	feedbackCh, errCh := stream.StartReading(ctx, 10, func(r *bufio.Reader) (encoding.BinaryUnmarshaler, error) {
-		var b [7]byte
-		if _, err := io.ReadFull(r, b[:]); err != nil {
-			return nil, err
-		}
-		msg := new(dualshock4.OutputState)
-		if err := msg.UnmarshalBinary(b[:]); err != nil {
-			return nil, err
-		}
-		return msg, nil
-	})
+	feedbackCh, errCh := stream.Outputs(ctx, 10)
 
 	go func() {
 		for {
 			select {
-			case feedback := <-feedbackCh:
-				f := feedback.(*dualshock4.OutputState)
+			case f := <-feedbackCh:
+				if f == nil {
+					continue
+				}
 				fmt.Printf("[Output] Rumble: S=%d L=%d, LED: R=%d G=%d B=%d, Flash: On=%d Off=%d\n",
 					f.RumbleSmall, f.RumbleLarge, f.LedRed, f.LedGreen, f.LedBlue, f.FlashOn, f.FlashOff)
 			case err := <-errCh:
@@ -152,7 +142,7 @@ func main() {
 				AccelZ:       0,
 			}
 
-			if err := stream.WriteBinary(&state); err != nil {
+			if err := stream.WriteState(&state); err != nil {
 				fmt.Printf("Send error: %v\n", err)
 				return
 			}
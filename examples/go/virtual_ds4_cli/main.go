@@ -3,7 +3,7 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding"
+	"flag"
 	"fmt"
 	"io"
 	"os"
@@ -15,16 +15,21 @@ import (
 	"time"
 
 	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apiclient/dualshock4client"
 	"github.com/Alia5/VIIPER/device/dualshock4"
 )
 
 // Usage:
 //
-//	virtual_ds4_cli <api_addr>
+//	virtual_ds4_cli [-script file] [-loop N] <api_addr>
+//	virtual_ds4_cli record -o file <api_addr>
 //
-// Example:
+// Examples:
 //
 //	virtual_ds4_cli localhost:3242
+//	virtual_ds4_cli -script combo.txt -loop 3 localhost:3242
+//	virtual_ds4_cli localhost:3242 < combo.txt
+//	virtual_ds4_cli record -o combo.txt localhost:3242
 //
 // Commands (case-insensitive):
 //
@@ -40,15 +45,83 @@ import (
 //	reset
 //	help
 //	quit
+//
+// Scripts, given by -script or piped to stdin, hold the same commands, one
+// per line, each optionally prefixed by the time to wait before it:
+//
+//	+250ms Cross=true
+//	sleep 100ms
+//	Cross=false
+//
+// record runs an interactive session and writes its commands in that form.
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: virtual_ds4_cli <api_addr>")
-		fmt.Println("Example: virtual_ds4_cli localhost:3242")
-		os.Exit(1)
+	os.Exit(run(os.Args[1:]))
+}
+
+// run returns the exit code rather than exiting, so the device and bus are
+// removed on every path out of a session.
+func run(args []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(args) > 0 && args[0] == "record" {
+		return runRecord(ctx, args[1:])
 	}
 
-	addr := os.Args[1]
-	ctx, cancel := context.WithCancel(context.Background())
+	fs := flag.NewFlagSet("virtual_ds4_cli", flag.ContinueOnError)
+	scriptPath := fs.String("script", "", "run the script in `file` (- for stdin) instead of reading commands interactively")
+	loops := fs.Int("loop", 1, "run the script `N` times; 0 repeats it until interrupted")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: virtual_ds4_cli [-script file] [-loop N] <api_addr>")
+		fmt.Fprintln(fs.Output(), "       virtual_ds4_cli record -o file <api_addr>")
+		fmt.Fprintln(fs.Output(), "Example: virtual_ds4_cli localhost:3242")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if *loops < 0 {
+		fmt.Fprintln(os.Stderr, "-loop must not be negative")
+		return 2
+	}
+
+	if *scriptPath == "" && !stdinIsTerminal() {
+		*scriptPath = "-"
+	}
+	if *scriptPath == "" {
+		return session(ctx, fs.Arg(0), interactive(nil))
+	}
+
+	sc, err := loadScript(*scriptPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "script %s: %v\n", *scriptPath, err)
+		return 1
+	}
+	if *loops == 0 && sc.length == 0 {
+		fmt.Fprintln(os.Stderr, "-loop 0 needs a script that takes time; add a sleep")
+		return 2
+	}
+	return session(ctx, fs.Arg(0), func(ctx context.Context, box *stateBox) error {
+		return sc.run(ctx, *loops, box.exec)
+	})
+}
+
+// stdinIsTerminal reports whether stdin is interactive rather than a pipe or
+// a file.
+func stdinIsTerminal() bool {
+	fi, err := os.Stdin.Stat()
+	return err != nil || fi.Mode()&os.ModeCharDevice != 0
+}
+
+// session adds a DualShock 4 to the API server at addr, streams the state in
+// box to it while drive runs and removes it, and the bus if one was created
+// for it, once drive returns or ctx is done.
+func session(ctx context.Context, addr string, drive func(context.Context, *stateBox) error) int {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	api := apiclient.New(addr)
@@ -56,7 +129,7 @@ func main() {
 	busesResp, err := api.BusListCtx(ctx)
 	if err != nil {
 		fmt.Printf("BusList error: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 
 	var busID uint32
@@ -65,7 +138,7 @@ func main() {
 		r, err := api.BusCreateCtx(ctx, 0)
 		if err != nil {
 			fmt.Printf("BusCreate failed: %v\n", err)
-			os.Exit(1)
+			return 1
 		}
 		busID = r.BusID
 		createdBus = true
@@ -80,44 +153,45 @@ func main() {
 		fmt.Printf("Using existing bus %d\n", busID)
 	}
 
-	stream, addResp, err := api.AddDeviceAndConnect(ctx, busID, "dualshock4", nil)
+	// Cleanup runs after an interrupt has cancelled ctx, so it gets its own.
+	cleanupCtx := func() (context.Context, context.CancelFunc) {
+		return context.WithTimeout(context.Background(), 5*time.Second)
+	}
+
+	stream, addResp, err := dualshock4client.AddAndConnect(ctx, api, busID, nil)
 	if err != nil {
-		fmt.Printf("AddDeviceAndConnect error: %v\n", err)
+		fmt.Printf("AddAndConnect error: %v\n", err)
 		if createdBus {
-			_, _ = api.BusRemoveCtx(ctx, busID)
+			c, done := cleanupCtx()
+			defer done()
+			_, _ = api.BusRemoveCtx(c, busID)
 		}
-		os.Exit(1)
+		return 1
 	}
 	defer stream.Close()
 
 	fmt.Printf("Connected to DualShock 4 device %s on bus %d\n", addResp.DevId, addResp.BusID)
 
 	defer func() {
-		if _, err := api.DeviceRemoveCtx(ctx, stream.BusID, stream.DevID); err != nil {
+		c, done := cleanupCtx()
+		defer done()
+		if _, err := api.DeviceRemoveCtx(c, stream.BusID, stream.DevID); err != nil {
 			fmt.Printf("DeviceRemove error: %v\n", err)
 		}
 		if createdBus {
-			_, _ = api.BusRemoveCtx(ctx, busID)
+			_, _ = api.BusRemoveCtx(c, busID)
 		}
 	}()
 
-	feedbackCh, errCh := stream.StartReading(ctx, 10, func(r *bufio.Reader) (encoding.BinaryUnmarshaler, error) {
-		var b [7]byte
-		if _, err := io.ReadFull(r, b[:]); err != nil {
-			return nil, err
-		}
-		msg := new(dualshock4.OutputState)
-		if err := msg.UnmarshalBinary(b[:]); err != nil {
-			return nil, err
-		}
-		return msg, nil
-	})
+	feedbackCh, errCh := stream.Outputs(ctx, 10)
 
 	go func() {
 		for {
 			select {
-			case feedback := <-feedbackCh:
-				f := feedback.(*dualshock4.OutputState)
+			case f := <-feedbackCh:
+				if f == nil {
+					continue
+				}
 				fmt.Printf("[Output] Rumble: S=%d L=%d, LED: R=%d G=%d B=%d, Flash: On=%d Off=%d\n",
 					f.RumbleSmall, f.RumbleLarge, f.LedRed, f.LedGreen, f.LedBlue, f.FlashOn, f.FlashOff)
 			case err := <-errCh:
@@ -131,41 +205,19 @@ func main() {
 		}
 	}()
 
-	type stateBox struct {
-		mu     sync.Mutex
-		state  dualshock4.InputState
-		timers map[string]*time.Timer
-	}
-
-	box := &stateBox{
-		state: dualshock4.InputState{
-			LX: 0, LY: 0, RX: 0, RY: 0,
-			Buttons: 0,
-			DPad:    0,
-			L2:      0,
-			R2:      0,
-			GyroX:   0,
-			GyroY:   0,
-			GyroZ:   0,
-			AccelX:  0,
-			AccelY:  0,
-			AccelZ:  0,
-		},
-		timers: map[string]*time.Timer{},
-	}
+	box := newStateBox()
 
 	sendTicker := time.NewTicker(5 * time.Millisecond)
 	defer sendTicker.Stop()
 
+	sendErr := make(chan error, 1)
 	go func() {
 		for {
 			select {
 			case <-sendTicker.C:
-				box.mu.Lock()
-				st := box.state
-				box.mu.Unlock()
-				if err := stream.WriteBinary(&st); err != nil {
-					fmt.Printf("Send error: %v\n", err)
+				st := box.snapshot()
+				if err := stream.WriteState(&st); err != nil {
+					sendErr <- err
 					cancel()
 					return
 				}
@@ -175,84 +227,167 @@ func main() {
 		}
 	}()
 
-	fmt.Println("DS4 CLI ready. Type 'help' for commands. Ctrl+C to exit.")
+	err = drive(ctx, box)
+	select {
+	case err := <-sendErr:
+		fmt.Printf("Send error: %v\n", err)
+		return 1
+	default:
+	}
+	if ctx.Err() != nil {
+		fmt.Println("\nShutting down...")
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
 
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+// stateBox holds the input state streamed to the device.
+type stateBox struct {
+	mu     sync.Mutex
+	state  dualshock4.InputState
+	timers map[string]*time.Timer
+}
 
-	scanner := bufio.NewScanner(os.Stdin)
-	for {
-		fmt.Print("> ")
-		select {
-		case <-sigCh:
-			fmt.Println("\nShutting down...")
-			cancel()
-			return
-		default:
-		}
+func newStateBox() *stateBox {
+	return &stateBox{timers: map[string]*time.Timer{}}
+}
 
-		if !scanner.Scan() {
-			cancel()
-			return
-		}
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
+func (b *stateBox) snapshot() dualshock4.InputState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
 
-		lower := strings.ToLower(line)
-		switch lower {
-		case "quit", "exit":
-			cancel()
-			return
-		case "help", "?":
-			printHelp()
-			continue
-		case "print":
-			box.mu.Lock()
-			fmt.Printf("%+v\n", box.state)
-			box.mu.Unlock()
-			continue
-		case "reset":
-			box.mu.Lock()
-			box.state = dualshock4.InputState{}
-			box.mu.Unlock()
-			fmt.Println("state reset")
-			continue
-		}
+func (b *stateBox) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = dualshock4.InputState{}
+}
 
-		key, val, dur, ok, err := parseAssignment(line)
-		if err != nil {
-			fmt.Printf("parse error: %v\n", err)
-			continue
-		}
-		if !ok {
-			fmt.Println("unrecognized command; try 'help'")
-			continue
-		}
+// apply sets key to val and, for a non-zero pulse, reverts it once pulse
+// has passed. A new pulse of the same key replaces a pending one.
+func (b *stateBox) apply(key, val string, pulse time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	before := b.state
+	if err := applyKeyValue(&b.state, key, val); err != nil {
+		return err
+	}
+	if pulse > 0 {
+		id := strings.ToLower(key)
+		if t := b.timers[id]; t != nil {
+			t.Stop()
+		}
+		after := b.state
+		b.timers[id] = time.AfterFunc(pulse, func() {
+			b.mu.Lock()
+			_ = revertKey(&b.state, id, before, after)
+			b.mu.Unlock()
+		})
+	}
+	return nil
+}
+
+// exec runs a script command.
+func (b *stateBox) exec(c command) error {
+	switch c.op {
+	case opReset:
+		b.reset()
+	case opPrint:
+		fmt.Printf("%+v\n", b.snapshot())
+	default:
+		return b.apply(c.key, c.val, c.pulse)
+	}
+	return nil
+}
 
-		box.mu.Lock()
-		before := box.state
-		applyErr := applyKeyValue(&box.state, key, val)
-		if applyErr != nil {
-			box.mu.Unlock()
-			fmt.Printf("apply error: %v\n", applyErr)
-			continue
+// interactive returns a session driver reading commands from stdin. With a
+// non-nil rec, the commands changing the state are written to it as a
+// script.
+func interactive(rec io.Writer) func(context.Context, *stateBox) error {
+	return func(ctx context.Context, box *stateBox) error {
+		lines := make(chan string)
+		scanErr := make(chan error, 1)
+		go func() {
+			defer close(lines)
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				select {
+				case lines <- scanner.Text():
+				case <-ctx.Done():
+					return
+				}
+			}
+			scanErr <- scanner.Err()
+		}()
+
+		fmt.Println("DS4 CLI ready. Type 'help' for commands. Ctrl+C to exit.")
+		var r *recorder
+		if rec != nil {
+			r = newRecorder(rec, time.Now())
 		}
 
-		if dur > 0 {
-			id := strings.ToLower(key)
-			if t := box.timers[id]; t != nil {
-				t.Stop()
+		for {
+			fmt.Print("> ")
+			var line string
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case l, ok := <-lines:
+				if !ok {
+					select {
+					case err := <-scanErr:
+						return err
+					default:
+						return ctx.Err()
+					}
+				}
+				line = strings.TrimSpace(l)
+			}
+			if line == "" {
+				continue
+			}
+
+			lower := strings.ToLower(line)
+			switch lower {
+			case "quit", "exit":
+				return nil
+			case "help", "?":
+				printHelp()
+				continue
+			case "print":
+				fmt.Printf("%+v\n", box.snapshot())
+				continue
+			case "reset":
+				box.reset()
+				fmt.Println("state reset")
+				if err := r.record(time.Now(), lower); err != nil {
+					return err
+				}
+				continue
+			}
+
+			key, val, dur, ok, err := parseAssignment(line)
+			if err != nil {
+				fmt.Printf("parse error: %v\n", err)
+				continue
 			}
-			after := box.state
-			box.timers[id] = time.AfterFunc(dur, func() {
-				box.mu.Lock()
-				_ = revertKey(&box.state, id, before, after)
-				box.mu.Unlock()
-			})
-		}
-		box.mu.Unlock()
+			if !ok {
+				fmt.Println("unrecognized command; try 'help'")
+				continue
+			}
+			if err := box.apply(key, val, dur); err != nil {
+				fmt.Printf("apply error: %v\n", err)
+				continue
+			}
+			if err := r.record(time.Now(), line); err != nil {
+				return err
+			}
+		}
 	}
 }
 
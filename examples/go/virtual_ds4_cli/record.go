@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// runRecord implements the record subcommand.
+func runRecord(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("virtual_ds4_cli record", flag.ContinueOnError)
+	out := fs.String("o", "", "write the recorded script to `file`")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: virtual_ds4_cli record -o file <api_addr>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || *out == "" {
+		fs.Usage()
+		return 2
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	code := session(ctx, fs.Arg(0), interactive(f))
+	if err := f.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if code == 0 {
+		fmt.Printf("Recorded to %s\n", *out)
+	}
+	return code
+}
+
+// recorder writes commands as a script, each prefixed by the time since the
+// previous one, so that replaying it keeps the pauses of the session.
+type recorder struct {
+	w    io.Writer
+	last time.Time
+}
+
+// newRecorder returns a recorder timing its first command from start.
+func newRecorder(w io.Writer, start time.Time) *recorder {
+	return &recorder{w: w, last: start}
+}
+
+// record writes cmd as issued at now. A nil recorder discards it.
+func (r *recorder) record(now time.Time, cmd string) error {
+	if r == nil {
+		return nil
+	}
+	d := now.Sub(r.last).Round(time.Millisecond)
+	r.last = now
+	_, err := fmt.Fprintf(r.w, "+%v %s\n", d, cmd)
+	return err
+}
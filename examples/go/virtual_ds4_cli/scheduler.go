@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// run passes through the script loops times, or until ctx is done for 0,
+// handing each command to exec when it is due. Due times are taken against
+// the monotonic clock from the start of the run, so a late command delays
+// neither the ones after it nor the next pass.
+func (s *script) run(ctx context.Context, loops int, exec func(command) error) error {
+	start := time.Now()
+	for pass := 0; loops == 0 || pass < loops; pass++ {
+		for _, c := range s.commands {
+			if err := sleepUntil(ctx, start.Add(c.at)); err != nil {
+				return err
+			}
+			if err := exec(c); err != nil {
+				return fmt.Errorf("line %d: %w", c.line, err)
+			}
+		}
+		start = start.Add(s.length)
+	}
+	// Let trailing waits and pulses run out.
+	return sleepUntil(ctx, start)
+}
+
+// sleepUntil waits until t or until ctx is done.
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
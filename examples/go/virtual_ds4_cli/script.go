@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Alia5/VIIPER/device/dualshock4"
+)
+
+// Script operations.
+const (
+	opSet = iota
+	opReset
+	opPrint
+)
+
+// command is a parsed script line.
+type command struct {
+	line int           // 1-based line in the script
+	at   time.Duration // due time, relative to the start of a pass
+	op   int
+
+	// For opSet, the arguments of stateBox.apply.
+	key, val string
+	pulse    time.Duration
+}
+
+// script is a parsed script.
+type script struct {
+	commands []command
+	// length is the duration of a pass: up to the last wait or the end of
+	// the last pulse, whichever is later.
+	length time.Duration
+}
+
+// loadScript parses the script in the file at path, or stdin for "-".
+func loadScript(path string) (*script, error) {
+	if path == "-" {
+		return parseScript(os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseScript(f)
+}
+
+// parseScript parses a script. Each line holds one of
+//
+//	<command>
+//	+<duration> <command>
+//	sleep <duration>
+//
+// where command is an assignment as typed interactively, reset or print.
+// A "+" prefix waits like a preceding sleep. Blank lines and lines
+// starting with # are skipped. Errors carry the line they were found on.
+func parseScript(r io.Reader) (*script, error) {
+	var (
+		s       script
+		at      time.Duration
+		scratch dualshock4.InputState
+	)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if strings.EqualFold(fields[0], "sleep") {
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: expected sleep <duration>", n)
+			}
+			d, err := parseWait(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			at += d
+			s.length = max(s.length, at)
+			continue
+		}
+		if wait, ok := strings.CutPrefix(fields[0], "+"); ok {
+			d, err := parseWait(wait)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			line = strings.TrimSpace(line[len(fields[0]):])
+			if line == "" {
+				return nil, fmt.Errorf("line %d: missing command after %s", n, fields[0])
+			}
+			at += d
+		}
+
+		c := command{line: n, at: at}
+		switch strings.ToLower(line) {
+		case "reset":
+			c.op = opReset
+		case "print":
+			c.op = opPrint
+		default:
+			key, val, pulse, ok, err := parseAssignment(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if !ok {
+				return nil, fmt.Errorf("line %d: unrecognized command %q", n, line)
+			}
+			// Applied to a scratch state so bad keys and values fail
+			// before anything runs.
+			if err := applyKeyValue(&scratch, key, val); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			c.op, c.key, c.val, c.pulse = opSet, key, val, pulse
+		}
+		s.commands = append(s.commands, c)
+		s.length = max(s.length, at+c.pulse)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// parseWait parses the duration of a wait.
+func parseWait(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad duration %q", s)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration %q", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScript(t *testing.T) {
+	type testCase struct {
+		name     string
+		src      string
+		expected []command
+		length   time.Duration
+	}
+
+	cases := []testCase{
+		{
+			name: "immediate",
+			src:  "Cross=true\nreset\nprint\n",
+			expected: []command{
+				{line: 1, op: opSet, key: "Cross", val: "true"},
+				{line: 2, op: opReset},
+				{line: 3, op: opPrint},
+			},
+		},
+		{
+			name: "relative prefixes accumulate",
+			src:  "+250ms Cross=true\n+1.5s\tCross=false\n",
+			expected: []command{
+				{line: 1, at: 250 * time.Millisecond, op: opSet, key: "Cross", val: "true"},
+				{line: 2, at: 1750 * time.Millisecond, op: opSet, key: "Cross", val: "false"},
+			},
+			length: 1750 * time.Millisecond,
+		},
+		{
+			name: "sleep",
+			src:  "LX=-100\nSLEEP 100ms\nLX=0\nsleep 50ms\n",
+			expected: []command{
+				{line: 1, op: opSet, key: "LX", val: "-100"},
+				{line: 3, at: 100 * time.Millisecond, op: opSet, key: "LX", val: "0"},
+			},
+			length: 150 * time.Millisecond,
+		},
+		{
+			name: "comments and blank lines",
+			src:  "# combo\n\n  +10ms Circle=on\n",
+			expected: []command{
+				{line: 3, at: 10 * time.Millisecond, op: opSet, key: "Circle", val: "on"},
+			},
+			length: 10 * time.Millisecond,
+		},
+		{
+			name: "pulse extends the pass",
+			src:  "+5ms Triangle=true 20ms\n",
+			expected: []command{
+				{line: 1, at: 5 * time.Millisecond, op: opSet, key: "Triangle", val: "true", pulse: 20 * time.Millisecond},
+			},
+			length: 25 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := parseScript(strings.NewReader(tc.src))
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, s.commands)
+			assert.Equal(t, tc.length, s.length)
+		})
+	}
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	type testCase struct {
+		name     string
+		src      string
+		expected string
+	}
+
+	cases := []testCase{
+		{name: "bad prefix", src: "Cross=true\n+soon Cross=false\n", expected: `line 2: bad duration "soon"`},
+		{name: "negative prefix", src: "+-5ms Cross=true\n", expected: `line 1: negative duration "-5ms"`},
+		{name: "missing command", src: "# c\n+5ms\n", expected: "line 2: missing command after +5ms"},
+		{name: "bad sleep", src: "sleep\n", expected: "line 1: expected sleep <duration>"},
+		{name: "unknown command", src: "\n\njump\n", expected: `line 3: unrecognized command "jump"`},
+		{name: "unknown key", src: "Jump=true\n", expected: `line 1: unknown key "Jump"`},
+		{name: "bad value", src: "sleep 1ms\nCross=maybe\n", expected: `line 2: expected bool, got "maybe"`},
+		{name: "bad pulse", src: "Cross=true forever\n", expected: `line 1: bad duration "forever"`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseScript(strings.NewReader(tc.src))
+			assert.EqualError(t, err, tc.expected)
+		})
+	}
+}
+
+func TestScriptRun(t *testing.T) {
+	s, err := parseScript(strings.NewReader("Cross=true\n+20ms Cross=false\nsleep 20ms\n"))
+	require.NoError(t, err)
+
+	start := time.Now()
+	var due []time.Duration
+	require.NoError(t, s.run(context.Background(), 2, func(c command) error {
+		due = append(due, time.Since(start))
+		if c.line == 1 {
+			// A slow command does not push back the next ones.
+			time.Sleep(15 * time.Millisecond)
+		}
+		return nil
+	}))
+	total := time.Since(start)
+
+	require.Len(t, due, 4)
+	for i, at := range []time.Duration{0, 20, 40, 60} {
+		assert.GreaterOrEqual(t, due[i], at*time.Millisecond, "command %d", i)
+		assert.Less(t, due[i], (at+12)*time.Millisecond, "command %d", i)
+	}
+	assert.GreaterOrEqual(t, total, 80*time.Millisecond, "the trailing sleep runs out")
+}
+
+func TestScriptRunCancel(t *testing.T) {
+	s, err := parseScript(strings.NewReader("+1h Cross=true\n"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = s.run(ctx, 0, func(command) error {
+		t.Fatal("no command is due")
+		return nil
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Now()
+	r := newRecorder(&buf, start)
+	require.NoError(t, r.record(start.Add(250*time.Millisecond), "Cross=true"))
+	require.NoError(t, r.record(start.Add(1750*time.Millisecond+400*time.Microsecond), "Triangle=true 12ms"))
+	require.NoError(t, r.record(start.Add(1750*time.Millisecond+400*time.Microsecond), "reset"))
+	assert.Equal(t, "+250ms Cross=true\n+1.5s Triangle=true 12ms\n+0s reset\n", buf.String())
+
+	s, err := parseScript(&buf)
+	require.NoError(t, err)
+	require.Len(t, s.commands, 3)
+	assert.Equal(t, 250*time.Millisecond, s.commands[0].at)
+	assert.Equal(t, 1750*time.Millisecond, s.commands[1].at)
+	assert.Equal(t, 12*time.Millisecond, s.commands[1].pulse)
+	assert.Equal(t, opReset, s.commands[2].op)
+}
@@ -1,17 +1,15 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"encoding"
 	"fmt"
-	"io"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apiclient/keyboardclient"
 	"github.com/Alia5/VIIPER/device/keyboard"
 )
 
@@ -54,9 +52,9 @@ func main() {
 	}
 
 	// Add device and connect to stream in one call
-	stream, addResp, err := api.AddDeviceAndConnect(ctx, busID, "keyboard", nil)
+	stream, addResp, err := keyboardclient.AddAndConnect(ctx, api, busID, nil)
 	if err != nil {
-		fmt.Printf("AddDeviceAndConnect error: %v\n", err)
+		fmt.Printf("AddAndConnect error: %v\n", err)
 		if createdBus {
 			_, _ = api.BusRemoveCtx(ctx, busID)
 		}
@@ -82,27 +80,16 @@ func main() {
 		}
 	}()
 
-	// Start reading LED feedback (1 byte per LED state change) using StartReading
-	ledCh, ledErrCh := stream.StartReading(ctx, 10, func(r *bufio.Reader) (encoding.BinaryUnmarshaler, error) {
-		var b [1]byte
-		if _, err := io.ReadFull(r, b[:]); err != nil {
-			return nil, err
-		}
-		st := new(keyboard.LEDState)
-		if err := st.UnmarshalBinary(b[:]); err != nil {
-			return nil, err
-		}
-		return st, nil
-	})
+	// Start reading LED feedback, decoded into keyboard.LEDState
+	ledCh, ledErrCh := stream.Outputs(ctx, 10)
 
 	go func() {
 		for {
 			select {
-			case m := <-ledCh:
-				if m == nil {
+			case lm := <-ledCh:
+				if lm == nil {
 					continue
 				}
-				lm := m.(*keyboard.LEDState)
 				fmt.Printf("→ LEDs: Num=%v Caps=%v Scroll=%v Compose=%v Kana=%v\n",
 					lm.NumLock, lm.CapsLock, lm.ScrollLock, lm.Compose, lm.Kana)
 			case err := <-ledErrCh:
@@ -127,7 +114,7 @@ func main() {
 			// Type "Hello!" character by character
 			states := keyboard.TypeString("Hello!")
 			for _, state := range states {
-				if err := stream.WriteBinary(&state); err != nil {
+				if err := stream.WriteState(&state); err != nil {
 					fmt.Printf("Write error: %v\n", err)
 					return
 				}
@@ -137,14 +124,14 @@ func main() {
 			// Press and release Enter
 			time.Sleep(100 * time.Millisecond)
 			enterPress := keyboard.PressKey(keyboard.KeyEnter)
-			if err := stream.WriteBinary(&enterPress); err != nil {
+			if err := stream.WriteState(&enterPress); err != nil {
 				fmt.Printf("Write error (enter): %v\n", err)
 				return
 			}
 
 			time.Sleep(100 * time.Millisecond)
 			enterRelease := keyboard.Release()
-			if err := stream.WriteBinary(&enterRelease); err != nil {
+			if err := stream.WriteState(&enterRelease); err != nil {
 				fmt.Printf("Write error (release): %v\n", err)
 				return
 			}
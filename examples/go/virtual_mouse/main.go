@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apiclient/mouseclient"
 	"github.com/Alia5/VIIPER/device/mouse"
 )
 
@@ -51,9 +52,9 @@ func main() {
 	}
 
 	// Add device and connect to stream in one call
-	stream, addResp, err := api.AddDeviceAndConnect(ctx, busID, "mouse", nil)
+	stream, addResp, err := mouseclient.AddAndConnect(ctx, api, busID, nil)
 	if err != nil {
-		fmt.Printf("AddDeviceAndConnect error: %v\n", err)
+		fmt.Printf("AddAndConnect error: %v\n", err)
 		if createdBus {
 			_, _ = api.BusRemoveCtx(ctx, busID)
 		}
@@ -101,7 +102,7 @@ func main() {
 
 			// One-shot movement report (diagonal)
 			move := &mouse.InputState{DX: dx, DY: dy}
-			if err := stream.WriteBinary(move); err != nil {
+			if err := stream.WriteState(move); err != nil {
 				fmt.Printf("Write error (move): %v\n", err)
 				return
 			}
@@ -110,7 +111,7 @@ func main() {
 			// Zero state shortly after to keep movement one-shot (harmless safety)
 			time.Sleep(30 * time.Millisecond)
 			zero := &mouse.InputState{}
-			if err := stream.WriteBinary(zero); err != nil {
+			if err := stream.WriteState(zero); err != nil {
 				fmt.Printf("Write error (zero after move): %v\n", err)
 				return
 			}
@@ -118,13 +119,13 @@ func main() {
 			// Simulate a short left click: press then release
 			time.Sleep(50 * time.Millisecond)
 			press := &mouse.InputState{Buttons: mouse.Btn_Left}
-			if err := stream.WriteBinary(press); err != nil {
+			if err := stream.WriteState(press); err != nil {
 				fmt.Printf("Write error (press): %v\n", err)
 				return
 			}
 			time.Sleep(60 * time.Millisecond)
 			rel := &mouse.InputState{Buttons: 0x00}
-			if err := stream.WriteBinary(rel); err != nil {
+			if err := stream.WriteState(rel); err != nil {
 				fmt.Printf("Write error (release): %v\n", err)
 				return
 			}
@@ -133,13 +134,13 @@ func main() {
 			// Simulate a short scroll: one notch upwards
 			time.Sleep(50 * time.Millisecond)
 			scr := &mouse.InputState{Wheel: 1}
-			if err := stream.WriteBinary(scr); err != nil {
+			if err := stream.WriteState(scr); err != nil {
 				fmt.Printf("Write error (scroll): %v\n", err)
 				return
 			}
 			time.Sleep(30 * time.Millisecond)
 			scr0 := &mouse.InputState{}
-			if err := stream.WriteBinary(scr0); err != nil {
+			if err := stream.WriteState(scr0); err != nil {
 				fmt.Printf("Write error (zero after scroll): %v\n", err)
 				return
 			}
@@ -1,17 +1,16 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"encoding"
 	"fmt"
-	"io"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apiclient/xbox360client"
+	"github.com/Alia5/VIIPER/device"
 	"github.com/Alia5/VIIPER/device/xbox360"
 )
 
@@ -53,10 +52,13 @@ func main() {
 		fmt.Printf("Using existing bus %d\n", busID)
 	}
 
-	// Add device and connect to stream in one call
-	stream, addResp, err := api.AddDeviceAndConnect(ctx, busID, "xbox360", nil)
+	// Add device and connect to stream in one call; with ledFeedback the
+	// stream also reports the LED ring pattern the host sets.
+	stream, addResp, err := xbox360client.AddAndConnect(ctx, api, busID, &device.CreateOptions{
+		DeviceSpecific: map[string]any{"ledFeedback": true},
+	})
 	if err != nil {
-		fmt.Printf("AddDeviceAndConnect error: %v\n", err)
+		fmt.Printf("AddAndConnect error: %v\n", err)
 		if createdBus {
 			_, _ = api.BusRemoveCtx(ctx, busID)
 		}
@@ -82,26 +84,24 @@ func main() {
 		}
 	}()
 
-	// Start event-driven rumble reading
-	rumbleCh, errCh := stream.StartReading(ctx, 10, func(r *bufio.Reader) (encoding.BinaryUnmarshaler, error) {
-		var b [2]byte
-		if _, err := io.ReadFull(r, b[:]); err != nil {
-			return nil, err
-		}
-		msg := new(xbox360.XRumbleState)
-		if err := msg.UnmarshalBinary(b[:]); err != nil {
-			return nil, err
-		}
-		return msg, nil
-	})
+	// Start event-driven rumble and LED reading
+	feedbackCh, errCh := apiclient.ReadMessages[xbox360.Feedback](ctx, stream.DeviceStream, xbox360.FeedbackLayout.Size(), 10)
 
 	go func() {
+		player := 0
 		for {
 			select {
-			case msg := <-rumbleCh:
-				if msg != nil {
-					rumble := msg.(*xbox360.XRumbleState)
-					fmt.Printf("← Rumble: Left=%d, Right=%d\n", rumble.LeftMotor, rumble.RightMotor)
+			case fb := <-feedbackCh:
+				switch {
+				case fb == nil:
+				case fb.Kind == xbox360.FeedbackRumble:
+					fmt.Printf("← Rumble: Left=%d, Right=%d\n", fb.Rumble.LeftMotor, fb.Rumble.RightMotor)
+				case fb.Kind == xbox360.FeedbackLED && fb.LED.Player() != player:
+					// The ring flashes, then lights the same quadrant.
+					player = fb.LED.Player()
+					if player != 0 {
+						fmt.Printf("← LED: assigned to player %d\n", player)
+					}
 				}
 			case err := <-errCh:
 				if err != nil {
@@ -144,7 +144,7 @@ func main() {
 				RX:      0,
 				RY:      0,
 			}
-			if err := stream.WriteBinary(state); err != nil {
+			if err := stream.WriteState(state); err != nil {
 				fmt.Printf("Write error: %v\n", err)
 				return
 			}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Alia5/VIIPER/internal/metrics"
+)
+
+// serveMetrics serves the metrics of r at /metrics on addr until the returned
+// func is called.
+func serveMetrics(addr string, r *metrics.Registry, logger *slog.Logger) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("metrics listener: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler(r))
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("metrics listener failed", "error", err)
+		}
+	}()
+	logger.Info("Serving metrics", "addr", ln.Addr().String()+"/metrics")
+	return func() { _ = srv.Close() }, nil
+}
@@ -20,12 +20,22 @@ import (
 	"github.com/Alia5/VIIPER/internal/util"
 )
 
-const keyFileName = "viiper.key.txt"
+const (
+	keyFileName      = "viiper.key.txt"
+	identityFileName = "viiper.identity.pem"
+)
 
 type Server struct {
-	UsbServerConfig   usb.ServerConfig `embed:"" prefix:"usb."`
-	ApiServerConfig   api.ServerConfig `embed:"" prefix:"api."`
-	ConnectionTimeout time.Duration    `help:"ConnectionTimeout operation timeout" default:"30s" env:"VIIPER_CONNECTION_TIMEOUT"`
+	UsbServerConfig       usb.ServerConfig `embed:"" prefix:"usb."`
+	ApiServerConfig       api.ServerConfig `embed:"" prefix:"api."`
+	ConnectionTimeout     time.Duration    `help:"ConnectionTimeout operation timeout" default:"30s" env:"VIIPER_CONNECTION_TIMEOUT"`
+	StateFile             string           `help:"Save buses and devices to this JSON file and restore them on start (default: none)" env:"VIIPER_STATE_FILE"`
+	IgnoreState           bool             `help:"Start empty if the state file is corrupt instead of refusing to start" env:"VIIPER_IGNORE_STATE"`
+	StateSnapshots        int              `help:"Previous versions of the state file to keep next to it (0 keeps none)" default:"5" env:"VIIPER_STATE_SNAPSHOTS"`
+	StateSnapshotInterval time.Duration    `help:"Minimum time between two snapshots of the state file" default:"1m" env:"VIIPER_STATE_SNAPSHOT_INTERVAL"`
+	MetricsAddr           string           `help:"Serve Prometheus metrics over HTTP at /metrics on this address (default: none)" env:"VIIPER_METRICS_ADDR"`
+	ShutdownTimeout       time.Duration    `help:"How long a shutdown waits for clients to finish before closing their connections" default:"5s" env:"VIIPER_SHUTDOWN_TIMEOUT"`
+	serverPlatformOpts    `embed:""`
 }
 
 // Run is called by Kong when the server command is executed.
@@ -70,7 +80,43 @@ func (s *Server) StartServer(ctx context.Context, logger *slog.Logger, rawLogger
 		logger.Info("You can change this password at any time by editing the file")
 	}
 
+	identity, err := loadIdentity(s.ApiServerConfig.IdentityKey, filepath.Join(keyFileDir, identityFileName), logger)
+	if err != nil {
+		return err
+	}
+	s.ApiServerConfig.Identity = identity
+	logger.Info("API server identity", "fingerprint", identity.Fingerprint())
+
+	if s.ApiServerConfig.TokenFile != "" {
+		tokens, err := auth.LoadTokens(s.ApiServerConfig.TokenFile)
+		if err != nil {
+			return err
+		}
+		s.ApiServerConfig.Tokens = tokens
+		logger.Info("Loaded API tokens", "path", s.ApiServerConfig.TokenFile, "count", len(tokens))
+	}
+
+	if s.ApiServerConfig.Addr == "" {
+		logger.Error("API server address must be set (default :3242).")
+		return fmt.Errorf("API server address must be set (default :3242).")
+	}
+
+	if s.AutoAttachLocal && s.ApiServerConfig.AutoAttachLocalClient {
+		// Both would attach every device.
+		logger.Info("Local auto-attach replaces the usbip client auto-attach")
+		s.ApiServerConfig.AutoAttachLocalClient = false
+	}
+
 	usbSrv := usb.New(s.UsbServerConfig, logger, rawLogger)
+	apiSrv := api.New(usbSrv, s.ApiServerConfig.Addr, s.ApiServerConfig, logger)
+	RegisterRoutes(apiSrv)
+
+	// Restored devices exist before USB-IP hosts can ask for them.
+	if s.StateFile != "" {
+		if err := s.RestoreStateFile(apiSrv, logger); err != nil {
+			return err
+		}
+	}
 
 	usbErrCh := make(chan error, 1)
 	go func() {
@@ -79,25 +125,24 @@ func (s *Server) StartServer(ctx context.Context, logger *slog.Logger, rawLogger
 
 	select {
 	case err := <-usbErrCh:
+		apiSrv.Close()
 		return err
 	case <-usbSrv.Ready():
 	}
 
-	if s.ApiServerConfig.Addr == "" {
-		logger.Error("API server address must be set (default :3242).")
-		return fmt.Errorf("API server address must be set (default :3242).")
+	if s.MetricsAddr != "" {
+		stopMetrics, err := serveMetrics(s.MetricsAddr, usbSrv.Metrics(), logger)
+		if err != nil {
+			apiSrv.Close()
+			_ = usbSrv.Close()
+			return err
+		}
+		defer stopMetrics()
 	}
 
-	apiSrv := api.New(usbSrv, s.ApiServerConfig.Addr, s.ApiServerConfig, logger)
-	r := apiSrv.Router()
-	r.Register("ping", handler.Ping())
-	r.Register("bus/list", handler.BusList(usbSrv))
-	r.Register("bus/create", handler.BusCreate(usbSrv))
-	r.Register("bus/remove", handler.BusRemove(usbSrv))
-	r.Register("bus/{id}/list", handler.BusDevicesList(usbSrv))
-	r.Register("bus/{id}/add", handler.BusDeviceAdd(usbSrv, apiSrv))
-	r.Register("bus/{id}/remove", handler.BusDeviceRemove(usbSrv))
-	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(usbSrv))
+	if s.AutoAttachLocal {
+		go usbSrv.AutoAttachLocal(ctx)
+	}
 
 	if s.ApiServerConfig.AutoAttachLocalClient {
 		logger.Info("Auto-attach is enabled, checking prerequisites...")
@@ -108,9 +153,11 @@ func (s *Server) StartServer(ctx context.Context, logger *slog.Logger, rawLogger
 		} else {
 			logger.Info("Auto-attach prerequisites satisfied")
 		}
+		attachRestored(ctx, apiSrv, logger)
 	}
 
 	if err := apiSrv.Start(); err != nil {
+		apiSrv.Close()
 		logger.Error("failed to start API server", "error", err)
 		if util.IsRunFromGUI() {
 			fmt.Println("Press any key to exit...")
@@ -129,10 +176,7 @@ func (s *Server) StartServer(ctx context.Context, logger *slog.Logger, rawLogger
 
 	select {
 	case <-ctx.Done():
-		if apiSrv != nil {
-			apiSrv.Close()
-		}
-		_ = usbSrv.Close()
+		shutdown(apiSrv, usbSrv, s.ShutdownTimeout, logger)
 		_ = <-usbErrCh
 		return nil
 	case err := <-usbErrCh:
@@ -142,3 +186,88 @@ func (s *Server) StartServer(ctx context.Context, logger *slog.Logger, rawLogger
 		return err
 	}
 }
+
+// loadIdentity loads the identity the API server signs handshakes with from
+// path, or from defaultPath, where it is generated on first start.
+func loadIdentity(path, defaultPath string, logger *slog.Logger) (*auth.Identity, error) {
+	if path != "" {
+		return auth.LoadIdentity(path)
+	}
+	if _, err := os.Stat(defaultPath); err == nil {
+		return auth.LoadIdentity(defaultPath)
+	}
+	identity, err := auth.NewIdentity()
+	if err != nil {
+		return nil, err
+	}
+	data, err := identity.MarshalPEM()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(defaultPath, data, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write new API server identity to file: %w", err)
+	}
+	logger.Info("Generated API server identity", "path", defaultPath)
+	return identity, nil
+}
+
+// shutdown stops the API server first, so stream clients get the shutdown
+// notice while their devices still exist, then the USB-IP server, which
+// detaches the devices. Both share the timeout.
+func shutdown(apiSrv *api.Server, usbSrv *usb.Server, timeout time.Duration, logger *slog.Logger) {
+	logger.Info("Shutting down", "timeout", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := apiSrv.Shutdown(ctx); err != nil {
+		logger.Warn("API server shutdown incomplete", "error", err)
+	}
+	if err := usbSrv.Shutdown(ctx); err != nil {
+		logger.Warn("USBIP server shutdown incomplete", "error", err)
+	}
+}
+
+// RegisterRoutes registers the management and stream routes of the API.
+// Routes that change state are tagged api.Mutating, so read-only mode can
+// refuse them.
+func RegisterRoutes(apiSrv *api.Server) {
+	usbSrv := apiSrv.USB()
+	r := apiSrv.Router()
+	r.Register("ping", handler.Ping(apiSrv))
+	r.Register("features", handler.Features())
+	r.Register("auth/ticket", handler.AuthTicket(apiSrv))
+	r.Register("time", handler.TimeSync(apiSrv))
+	r.Register("meta/protocol", handler.MetaProtocol())
+	r.Register("admin/read-only", handler.AdminReadOnly(apiSrv), api.Admin)
+	r.Register("admin/state/snapshots", handler.AdminStateSnapshots(apiSrv), api.Admin)
+	r.Register("admin/state/restore", handler.AdminStateRestore(apiSrv), api.Admin)
+	r.Register("usbip/stats", handler.UsbipStats(usbSrv))
+	r.Register("metrics", handler.Metrics(usbSrv))
+	r.Register("bus/list", handler.BusList(usbSrv))
+	r.Register("bus/create", handler.BusCreate(usbSrv), api.Mutating)
+	r.Register("bus/remove", handler.BusRemove(usbSrv), api.Mutating)
+	r.Register("bus/{id}/list", handler.BusDevicesList(usbSrv))
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(usbSrv, apiSrv), api.Mutating)
+	r.Register("bus/{id}/add-batch", handler.BusDeviceAddBatch(usbSrv, apiSrv), api.Mutating)
+	r.Register("bus/{id}/remove", handler.BusDeviceRemove(usbSrv), api.Mutating)
+	r.Register("bus/{id}/defaults", handler.BusGetDefaults(usbSrv))
+	r.Register("bus/{id}/defaults/set", handler.BusSetDefaults(usbSrv), api.Mutating)
+	r.Register("bus/{id}/label", handler.BusSetLabel(usbSrv, apiSrv), api.Mutating)
+	r.Register("bus/{id}/{deviceid}/label", handler.DeviceSetLabel(usbSrv, apiSrv), api.Mutating)
+	r.Register("bus/{id}/{deviceid}/alias", handler.DeviceAlias(usbSrv), api.Mutating)
+	r.Register("bus/{id}/{deviceid}/degrade", handler.DeviceDegrade(usbSrv), api.Mutating)
+	r.Register("bus/{id}/{deviceid}/stats", handler.DeviceStats(usbSrv, apiSrv))
+	r.Register("bus/{id}/{deviceid}/status", handler.DeviceStatus(usbSrv))
+	r.Register("bus/{id}/{deviceid}/step", handler.DeviceStep(usbSrv), api.Mutating)
+	r.Register("bus/{id}/{deviceid}/macro", handler.DeviceMacroPlay(usbSrv, apiSrv), api.Mutating)
+	r.Register("bus/{id}/{deviceid}/macro/cancel", handler.DeviceMacroCancel(usbSrv, apiSrv), api.Mutating)
+	r.Register("bus/{id}/{deviceid}/test-feedback", handler.DeviceTestFeedback(usbSrv, apiSrv), api.Mutating)
+	r.Register("bus/{id}/{deviceid}/record/start", handler.DeviceRecordStart(usbSrv, apiSrv), api.Mutating)
+	r.Register("bus/{id}/{deviceid}/record/stop", handler.DeviceRecordStop(usbSrv, apiSrv), api.Mutating)
+	r.Register("bus/{id}/{deviceid}/record/download", handler.DeviceRecordDownload(usbSrv, apiSrv))
+	r.Register("templates/list", handler.TemplateList(apiSrv))
+	r.Register("templates/get", handler.TemplateGet(apiSrv))
+	r.Register("templates/set", handler.TemplateSet(apiSrv), api.Mutating)
+	r.Register("templates/remove", handler.TemplateRemove(apiSrv), api.Mutating)
+	r.Register("batch", handler.Batch(apiSrv))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(usbSrv))
+}
@@ -0,0 +1,7 @@
+//go:build linux
+
+package cmd
+
+type serverPlatformOpts struct {
+	AutoAttachLocal bool `help:"Attach devices to this host's vhci_hcd through sysfs, without the usbip tool, and detach them on removal" default:"false" env:"VIIPER_AUTO_ATTACH_LOCAL"`
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package cmd
+
+type serverPlatformOpts struct {
+	AutoAttachLocal bool `kong:"-"`
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+)
+
+// RestoreStateFile recreates the buses and devices saved in the state file of
+// s and keeps the file up to date from then on. Entries that cannot be
+// restored are logged and dropped; an unreadable or corrupt file is an error
+// unless IgnoreState is set, which starts empty and leaves the snapshots for
+// admin/state/restore.
+func (s *Server) RestoreStateFile(apiSrv *api.Server, logger *slog.Logger) error {
+	st, err := api.LoadState(s.StateFile)
+	switch {
+	case err != nil && s.IgnoreState:
+		logger.Warn("ignoring state file", "path", s.StateFile, "error", err)
+	case err != nil:
+		return fmt.Errorf("load state: %w (start with --ignore-state to boot without it)", err)
+	default:
+		if err := handler.RestoreState(apiSrv, st, logger); err != nil {
+			logger.Warn("state file partially restored", "path", s.StateFile, "error", err)
+		}
+	}
+	return apiSrv.PersistState(s.StateFile, api.StateOptions{
+		Snapshots:        s.StateSnapshots,
+		SnapshotInterval: s.StateSnapshotInterval,
+	})
+}
+
+// attachRestored attaches the devices present at startup, restored from the
+// state file, to the local USB-IP client.
+func attachRestored(ctx context.Context, apiSrv *api.Server, logger *slog.Logger) {
+	usbSrv := apiSrv.USB()
+	cfg := apiSrv.Config()
+	for _, busID := range usbSrv.ListBuses() {
+		b := usbSrv.GetBus(busID)
+		if b == nil {
+			continue
+		}
+		for _, m := range b.GetAllDeviceMetas() {
+			if err := api.AttachLocalhostClient(ctx, &m.Meta, usbSrv.GetListenPort(), cfg.AutoAttachWindowsNative, logger); err != nil {
+				logger.Error("failed to auto-attach restored device", "busID", busID, "devID", m.Meta.DevId, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Alia5/VIIPER/apiclient"
+	"golang.org/x/term"
+)
+
+// Watch live-tails the stats and feedback of devices.
+type Watch struct {
+	Scope       string        `arg:"" optional:"" help:"Bus or bus/device to watch, e.g. 1 or 1/2 (default: all buses)"`
+	Addr        string        `help:"API server address" default:"localhost:3242" env:"VIIPER_WATCH_ADDR"`
+	Password    string        `help:"API server password, required for remote servers" env:"VIIPER_WATCH_PASSWORD"`
+	Token       string        `help:"API token as <id>:<secret>, in place of the password" env:"VIIPER_WATCH_TOKEN"`
+	Fingerprint string        `help:"Server identity fingerprint to pin" env:"VIIPER_WATCH_FINGERPRINT"`
+	Interval    time.Duration `help:"Refresh interval" default:"1s" env:"VIIPER_WATCH_INTERVAL"`
+	JSON        bool          `help:"Print newline-delimited JSON snapshots instead of a table" name:"json"`
+}
+
+// Run is called by Kong when the watch command is executed.
+func (w *Watch) Run() error {
+	busID, devID, err := parseWatchScope(w.Scope)
+	if err != nil {
+		return err
+	}
+	if w.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	opts := []apiclient.Option{}
+	if w.Password != "" {
+		opts = append(opts, apiclient.WithPassword(w.Password))
+	}
+	if w.Fingerprint != "" {
+		opts = append(opts, apiclient.WithServerFingerprint(w.Fingerprint))
+	}
+	client := apiclient.New(w.Addr, opts...)
+	if w.Token != "" {
+		client = client.WithToken(w.Token)
+	}
+
+	redraw := !w.JSON && term.IsTerminal(int(os.Stdout.Fd()))
+	enc := json.NewEncoder(os.Stdout)
+	snaps, errs := client.NewStatsWatcher(busID, devID).Watch(ctx, w.Interval)
+	for snap := range snaps {
+		if w.JSON {
+			if err := enc.Encode(snap); err != nil {
+				return err
+			}
+			continue
+		}
+		if redraw {
+			fmt.Print("\x1b[H\x1b[2J")
+		}
+		w.render(os.Stdout, &snap)
+	}
+	return <-errs
+}
+
+// parseWatchScope parses "bus" or "bus/device"; "" watches all buses.
+func parseWatchScope(scope string) (uint32, string, error) {
+	if scope == "" {
+		return 0, "", nil
+	}
+	bus, dev, _ := strings.Cut(scope, "/")
+	id, err := strconv.ParseUint(bus, 10, 32)
+	if err != nil || id == 0 {
+		return 0, "", fmt.Errorf("invalid bus %q", bus)
+	}
+	return uint32(id), dev, nil
+}
+
+func (w *Watch) render(out io.Writer, snap *apiclient.WatchSnapshot) {
+	scope := "all buses"
+	if w.Scope != "" {
+		scope = "bus " + w.Scope
+	}
+	fmt.Fprintf(out, "VIIPER %s, %s: %s (every %s)\n\n", w.Addr, scope, snap.At.Format(time.TimeOnly), w.Interval)
+	if len(snap.Devices) == 0 {
+		fmt.Fprintln(out, "no devices")
+		return
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tTYPE\tSTREAM\tIMPORT\tIN/S\tREPORTS/S\tPOLL\tFLAGS\tFEEDBACK")
+	for _, d := range snap.Devices {
+		name := fmt.Sprintf("%d-%s", d.BusID, d.DevId)
+		if d.Label != "" {
+			name += " (" + d.Label + ")"
+		}
+		stream, imp := "-", "-"
+		if d.Streaming {
+			stream = "open"
+		}
+		if d.Attached {
+			imp = "attached"
+		}
+		in := fmt.Sprintf("%.0f", d.InputPerSec)
+		if d.InputPerSec == 0 && d.InputBytesPerSec > 0 {
+			in = fmt.Sprintf("%.0f B", d.InputBytesPerSec)
+		}
+		poll := "-"
+		if d.PollIntervalNs > 0 {
+			poll = time.Duration(d.PollIntervalNs).String()
+			if d.MeasuredPollNs > 0 {
+				poll += " (" + time.Duration(d.MeasuredPollNs).Round(10*time.Microsecond).String() + ")"
+			}
+		}
+		var flags []string
+		if d.HostPollingDegraded {
+			flags = append(flags, "slow-host")
+		}
+		if d.Degraded {
+			flags = append(flags, "degrade")
+		}
+		if d.LatencyP95Ns > 0 {
+			flags = append(flags, "p95="+time.Duration(d.LatencyP95Ns).Round(time.Microsecond).String())
+		}
+		if len(flags) == 0 {
+			flags = []string{"-"}
+		}
+		feedback := "-"
+		if d.LastFeedback != nil {
+			feedback = fmt.Sprintf("#%d %s", d.Feedback, d.LastFeedback)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%.0f\t%s\t%s\t%s\n", name, d.Type, stream, imp, in, d.ReportsPerSec, poll, strings.Join(flags, ","), feedback)
+	}
+	_ = tw.Flush()
+}
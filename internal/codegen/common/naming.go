@@ -27,6 +27,16 @@ func ToPascalCase(s string) string {
 	return result.String()
 }
 
+// TypeName returns the generated name for a Go type reference. Exported Go
+// identifiers (DTO names like "DeviceDefaults") are kept verbatim, since
+// ToPascalCase would lowercase their inner words.
+func TypeName(s string) string {
+	if s != "" && unicode.IsUpper(rune(s[0])) && !strings.ContainsAny(s, "_- ") {
+		return s
+	}
+	return ToPascalCase(s)
+}
+
 func ToCamelCase(s string) string {
 	pascal := ToPascalCase(s)
 	if len(pascal) == 0 {
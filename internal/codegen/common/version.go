@@ -2,6 +2,7 @@ package common
 
 import (
 	"fmt"
+	"runtime/debug"
 	"strconv"
 	"strings"
 )
@@ -9,6 +10,25 @@ import (
 // Version is set via ldflags at build time: -ldflags "-X viiper/internal/codegen/common.Version=x.y.z"
 var Version = ""
 
+// Commit is set via ldflags at build time: -ldflags "-X viiper/internal/codegen/common.Commit=abc1234"
+var Commit = ""
+
+// GetCommit returns the commit the binary was built from: Commit if set,
+// else the VCS revision recorded by the Go toolchain, else "unknown".
+func GetCommit() string {
+	if Commit != "" {
+		return Commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" && setting.Value != "" {
+				return setting.Value[:min(7, len(setting.Value))]
+			}
+		}
+	}
+	return "unknown"
+}
+
 // GetVersion returns the version string that was set at build time via ldflags.
 // Returns "0.0.1-dev" if Version is empty (development builds only).
 // For production releases, Version MUST be set via: go build -ldflags "-X viiper/internal/codegen/common.Version=x.y.z"
@@ -12,7 +12,7 @@ import (
 // WireTypeSize returns the size in bytes of a wire protocol type.
 func WireTypeSize(wireType string) int {
 	switch wireType {
-	case "u8", "i8":
+	case "u8", "i8", "bool":
 		return 1
 	case "u16", "i16":
 		return 2
@@ -25,6 +25,19 @@ func WireTypeSize(wireType string) int {
 	}
 }
 
+// WireRangeDoc describes the value range annotation of a wire field for
+// generated doc comments. It returns "" for fields without one.
+func WireRangeDoc(field scanner.WireField) string {
+	switch {
+	case field.Range == "":
+		return ""
+	case strings.Contains(field.Range, "|"):
+		return "Valid values: " + strings.ReplaceAll(field.Range, "|", ", ") + "."
+	default:
+		return "Valid range: " + strings.Replace(field.Range, "..", " to ", 1) + "."
+	}
+}
+
 // CalculateOutputSize computes the exact size in bytes of a device's output (s2c) message.
 // Returns 0 if the tag is nil or device has no output.
 // For variable-length fields (e.g., "u8*count"), returns 0 to indicate dynamic size.
@@ -80,6 +93,40 @@ func HasWireTag(md *meta.Metadata, deviceName, direction string) bool {
 	return GetWireTag(md, deviceName, direction) != nil
 }
 
+// WireEnum is an enum type used by wire fields, with its members taken from
+// the device constants sharing its prefix.
+type WireEnum struct {
+	Name    string // constant prefix (e.g. "Mode")
+	Base    string // wire type (e.g. "u8")
+	Members []scanner.ConstantInfo
+}
+
+// WireEnums returns the enums used by a device's wire fields, in order of
+// first use, input before output. Member names have the prefix trimmed.
+func WireEnums(md *meta.Metadata, deviceName string) []WireEnum {
+	var enums []WireEnum
+	seen := map[string]bool{}
+	for _, dir := range []string{"c2s", "s2c"} {
+		for _, field := range GetWireFields(md, deviceName, dir) {
+			if field.Enum == "" || seen[field.Enum] {
+				continue
+			}
+			seen[field.Enum] = true
+			enum := WireEnum{Name: field.Enum, Base: field.Type}
+			if pkg := md.DevicePackages[deviceName]; pkg != nil {
+				for _, c := range pkg.Constants {
+					if len(c.Name) > len(field.Enum) && strings.HasPrefix(c.Name, field.Enum) {
+						c.Name = SanitizeLeadingDigit(strings.TrimPrefix(c.Name, field.Enum))
+						enum.Members = append(enum.Members, c)
+					}
+				}
+			}
+			enums = append(enums, enum)
+		}
+	}
+	return enums
+}
+
 // ExtractPathParams parses a route pattern like "bus/{id}/list" and returns
 // the parameter names in order (e.g., ["id"]).
 func ExtractPathParams(path string) []string {
@@ -33,7 +33,10 @@ namespace viiper {
 namespace detail {
 
 class EncryptedSocket;
+struct ResumeTicket;
 Result<std::unique_ptr<EncryptedSocket>> perform_handshake(Socket&& socket, const std::string& password);
+Result<std::unique_ptr<EncryptedSocket>> perform_resume_handshake(Socket&& socket, const ResumeTicket& ticket);
+Result<std::unique_ptr<EncryptedSocket>> perform_signed_handshake(Socket&& socket, const std::string& password, const std::string& server_fingerprint);
 
 } // namespace detail
 } // namespace viiper
@@ -66,10 +69,16 @@ const authImplTemplate = `// Auto-generated VIIPER C++ Client Library - Authenti
 #include <vector>
 #include <array>
 #include <memory>
+#include <chrono>
+#include <optional>
+#include <string>
 #include <openssl/evp.h>
 #include <openssl/hmac.h>
 #include <openssl/rand.h>
 #include <openssl/sha.h>
+#include <openssl/bn.h>
+#include <openssl/ec.h>
+#include <openssl/x509.h>
 #include "socket.hpp"
 #include "../error.hpp"
 
@@ -86,6 +95,20 @@ constexpr const char* AUTH_CONTEXT = "VIIPER-Auth-v1";
 constexpr const char* SESSION_CONTEXT = "VIIPER-Session-v1";
 constexpr const char* PBKDF2_SALT = "VIIPER-Key-v1";
 constexpr uint32_t PBKDF2_ITERATIONS = 100000;
+constexpr const char* RESUME_MAGIC = "eVR1\x00";
+constexpr const char* RESUME_CONTEXT = "VIIPER-Resume-v1";
+constexpr size_t TICKET_SIZE = 24;
+// How long before its expiry a ticket is no longer used
+constexpr std::chrono::seconds TICKET_MARGIN{30};
+constexpr const char* SIGNED_HANDSHAKE_MAGIC = "eVS1\x00";
+constexpr const char* IDENTITY_CONTEXT = "VIIPER-Identity-v1";
+constexpr size_t POINT_SIZE = 65;
+constexpr size_t SIGNATURE_SIZE = 64;
+// DER SubjectPublicKeyInfo of a P-256 key up to its uncompressed point
+constexpr uint8_t P256_SPKI_PREFIX[] = {
+    0x30, 0x59, 0x30, 0x13, 0x06, 0x07, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x02, 0x01,
+    0x06, 0x08, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07, 0x03, 0x42, 0x00
+};
 
 // ============================================================================
 // OpenSSL-based Crypto Utilities
@@ -117,6 +140,98 @@ inline void sha256(const uint8_t* data, size_t len, uint8_t* out) {
     EVP_MD_CTX_free(ctx);
 }
 
+// Standard base64 decoding using OpenSSL
+inline std::optional<std::vector<uint8_t>> base64_decode(const std::string& in) {
+    if (in.size() % 4 != 0) return std::nullopt;
+    std::vector<uint8_t> out(in.size() / 4 * 3);
+    int len = EVP_DecodeBlock(out.data(), reinterpret_cast<const unsigned char*>(in.data()), static_cast<int>(in.size()));
+    if (len < 0) return std::nullopt;
+    // EVP_DecodeBlock counts padding as zero bytes
+    size_t padding = 0;
+    for (auto it = in.rbegin(); it != in.rend() && *it == '=' && padding < 2; ++it) ++padding;
+    out.resize(static_cast<size_t>(len) - padding);
+    return out;
+}
+
+// ============================================================================
+// Server Identity (ECDSA / ECDH P-256) using OpenSSL
+// ============================================================================
+
+using PKeyPtr = std::unique_ptr<EVP_PKEY, decltype(&EVP_PKEY_free)>;
+
+// Fingerprint of an uncompressed P-256 public key as servers log it at
+// startup: "sha256:" followed by the hex SHA-256 of the key.
+inline std::string fingerprint(const uint8_t* point, size_t len) {
+    static const char hex[] = "0123456789abcdef";
+    uint8_t digest[32];
+    sha256(point, len, digest);
+    std::string out = "sha256:";
+    for (uint8_t b : digest) {
+        out += hex[b >> 4];
+        out += hex[b & 0x0f];
+    }
+    return out;
+}
+
+// Parses an uncompressed P-256 point; null if it is not on the curve.
+inline PKeyPtr p256_public_key(const uint8_t* point) {
+    std::vector<uint8_t> der(P256_SPKI_PREFIX, P256_SPKI_PREFIX + sizeof(P256_SPKI_PREFIX));
+    der.insert(der.end(), point, point + POINT_SIZE);
+    const uint8_t* p = der.data();
+    return PKeyPtr(d2i_PUBKEY(nullptr, &p, static_cast<long>(der.size())), &EVP_PKEY_free);
+}
+
+inline PKeyPtr p256_generate() {
+    EVP_PKEY* pkey = nullptr;
+    EVP_PKEY_CTX* ctx = EVP_PKEY_CTX_new_id(EVP_PKEY_EC, nullptr);
+    if (ctx && EVP_PKEY_keygen_init(ctx) > 0 &&
+        EVP_PKEY_CTX_set_ec_paramgen_curve_nid(ctx, NID_X9_62_prime256v1) > 0) {
+        EVP_PKEY_keygen(ctx, &pkey);
+    }
+    EVP_PKEY_CTX_free(ctx);
+    return PKeyPtr(pkey, &EVP_PKEY_free);
+}
+
+// Uncompressed point of a P-256 key
+inline std::optional<std::array<uint8_t, POINT_SIZE>> p256_point(EVP_PKEY* pkey) {
+    int len = i2d_PUBKEY(pkey, nullptr);
+    if (len != static_cast<int>(sizeof(P256_SPKI_PREFIX) + POINT_SIZE)) return std::nullopt;
+    std::vector<uint8_t> der(static_cast<size_t>(len));
+    uint8_t* p = der.data();
+    i2d_PUBKEY(pkey, &p);
+    std::array<uint8_t, POINT_SIZE> point;
+    std::memcpy(point.data(), der.data() + sizeof(P256_SPKI_PREFIX), POINT_SIZE);
+    return point;
+}
+
+// Verifies an ECDSA P-256 signature, r and s of 32 bytes each, of the SHA-256 of data
+inline bool p256_verify(EVP_PKEY* pkey, const std::vector<uint8_t>& data, const uint8_t* sig) {
+    ECDSA_SIG* ecsig = ECDSA_SIG_new();
+    if (!ecsig) return false;
+    ECDSA_SIG_set0(ecsig, BN_bin2bn(sig, 32, nullptr), BN_bin2bn(sig + 32, 32, nullptr));
+    unsigned char* der = nullptr;
+    int der_len = i2d_ECDSA_SIG(ecsig, &der);
+    ECDSA_SIG_free(ecsig);
+    if (der_len <= 0) return false;
+
+    EVP_MD_CTX* ctx = EVP_MD_CTX_new();
+    bool ok = EVP_DigestVerifyInit(ctx, nullptr, EVP_sha256(), nullptr, pkey) > 0 &&
+              EVP_DigestVerify(ctx, der, static_cast<size_t>(der_len), data.data(), data.size()) == 1;
+    EVP_MD_CTX_free(ctx);
+    OPENSSL_free(der);
+    return ok;
+}
+
+// ECDH P-256 shared secret, the x coordinate of the shared point
+inline bool p256_derive(EVP_PKEY* key, EVP_PKEY* peer, std::array<uint8_t, 32>& out) {
+    EVP_PKEY_CTX* ctx = EVP_PKEY_CTX_new(key, nullptr);
+    size_t len = out.size();
+    bool ok = ctx && EVP_PKEY_derive_init(ctx) > 0 && EVP_PKEY_derive_set_peer(ctx, peer) > 0 &&
+              EVP_PKEY_derive(ctx, out.data(), &len) > 0 && len == out.size();
+    EVP_PKEY_CTX_free(ctx);
+    return ok;
+}
+
 // ============================================================================
 // ChaCha20-Poly1305 AEAD using OpenSSL
 // ============================================================================
@@ -212,49 +327,59 @@ public:
     }
 
     Result<size_t> recv(uint8_t* buffer, size_t size) {
-        std::vector<uint8_t> len_buf(4);
-        auto read_result = socket_.recv_exact(len_buf.data(), 4);
-        if (read_result.is_error()) {
-            if (read_result.error().message == "connection closed") {
-                return 0; // Return 0 bytes on EOF
+        if (recv_buffer_.empty()) {
+            auto packet = recv_packet();
+            if (packet.is_error()) {
+                if (packet.error().message == "connection closed") {
+                    return 0; // Return 0 bytes on EOF
+                }
+                return packet.error();
             }
-            return read_result.error();
+            recv_buffer_ = std::move(packet.value());
         }
 
-        uint32_t packet_len = (len_buf[0] << 24) | (len_buf[1] << 16) | 
-                             (len_buf[2] << 8) | len_buf[3];
+        // Keep what does not fit for the next call; a packet may carry
+        // several messages.
+        size_t to_copy = (recv_buffer_.size() < size) ? recv_buffer_.size() : size;
+        std::memcpy(buffer, recv_buffer_.data(), to_copy);
+        recv_buffer_.erase(recv_buffer_.begin(), recv_buffer_.begin() + to_copy);
+        return to_copy;
+    }
 
-        if (packet_len > 2 * 1024 * 1024) {
-            return Error("Packet too large");
+    Result<void> recv_exact(uint8_t* buffer, size_t size) {
+        size_t received = 0;
+        while (received < size) {
+            auto result = recv(buffer + received, size - received);
+            if (result.is_error()) return result.error();
+            if (result.value() == 0) return Error("connection closed");
+            received += result.value();
         }
+        return Result<void>();
+    }
 
-        std::vector<uint8_t> packet(packet_len);
-        read_result = socket_.recv_exact(packet.data(), packet_len);
-        if (read_result.is_error()) return read_result.error();
-
-        if (packet_len < 28) return Error("Packet too small");
-
-        const uint8_t* nonce = packet.data();
-        const uint8_t* ciphertext = packet.data() + 12;
-        size_t ct_len = packet_len - 12 - 16;
-        const uint8_t* tag = packet.data() + 12 + ct_len;
-
-        std::vector<uint8_t> plaintext(ct_len);
-        if (!cipher_.decrypt(nonce, ciphertext, ct_len, tag, plaintext.data())) {
-            return Error("Decryption failed");
-        }
+    Result<std::string> recv_line() {
+        auto packet = recv_packet();
+        if (packet.is_error()) return packet.error();
+        return std::string(reinterpret_cast<char*>(packet.value().data()), packet.value().size());
+    }
 
-        size_t to_copy = (ct_len < size) ? ct_len : size;
-        std::memcpy(buffer, plaintext.data(), to_copy);
-        return to_copy;
+    /// Wait up to timeout for plaintext to read, see Socket::wait_readable.
+    Result<bool> wait_readable(std::chrono::milliseconds timeout) {
+        if (!recv_buffer_.empty()) return true;
+        return socket_.wait_readable(timeout);
     }
 
-    Result<std::string> recv_line() {
+    Socket& get_socket() { return socket_; }
+    
+    bool is_valid() const { return socket_.is_valid(); }
+    
+    void force_close() { socket_.force_close(); }
+
+private:
+    Result<std::vector<uint8_t>> recv_packet() {
         std::vector<uint8_t> len_buf(4);
         auto read_result = socket_.recv_exact(len_buf.data(), 4);
-        if (read_result.is_error()) {
-            return read_result.error();
-        }
+        if (read_result.is_error()) return read_result.error();
 
         uint32_t packet_len = (len_buf[0] << 24) | (len_buf[1] << 16) | 
                              (len_buf[2] << 8) | len_buf[3];
@@ -278,53 +403,72 @@ public:
         if (!cipher_.decrypt(nonce, ciphertext, ct_len, tag, plaintext.data())) {
             return Error("Decryption failed");
         }
+        return plaintext;
+    }
+};
 
-        return std::string(reinterpret_cast<char*>(plaintext.data()), plaintext.size());
+// ============================================================================
+// Session Resumption
+// ============================================================================
+
+// Ticket fetched from the auth/ticket route. Presenting it skips the password
+// proof, and the PBKDF2 behind it, on later connections.
+struct ResumeTicket {
+    std::vector<uint8_t> ticket;
+    std::vector<uint8_t> secret;
+    std::chrono::steady_clock::time_point expires;
+
+    // Decodes a ticket response; nullopt if it is malformed or too short lived to be worth caching.
+    static std::optional<ResumeTicket> decode(const std::string& ticket_b64, const std::string& secret_b64, uint64_t expires_in) {
+        auto ticket = base64_decode(ticket_b64);
+        auto secret = base64_decode(secret_b64);
+        std::chrono::seconds lifetime(static_cast<std::chrono::seconds::rep>(expires_in));
+        if (!ticket || !secret || ticket->size() != TICKET_SIZE || lifetime < 2 * TICKET_MARGIN) {
+            return std::nullopt;
+        }
+        return ResumeTicket{std::move(*ticket), std::move(*secret), std::chrono::steady_clock::now() + lifetime};
     }
 
-    Socket& get_socket() { return socket_; }
-    
-    bool is_valid() const { return socket_.is_valid(); }
-    
-    void force_close() { socket_.force_close(); }
+    // Whether the ticket is far enough from its expiry to be presented
+    [[nodiscard]] bool usable() const {
+        return std::chrono::steady_clock::now() + TICKET_MARGIN <= expires;
+    }
 };
 
 // ============================================================================
 // Main Handshake Function
 // ============================================================================
 
-inline Result<std::unique_ptr<EncryptedSocket>> perform_handshake(Socket&& socket, const std::string& password) {
-    if (password.empty()) {
-        return Error("Password cannot be empty");
-    }
+inline std::array<uint8_t, NONCE_SIZE> random_nonce() {
+    std::array<uint8_t, NONCE_SIZE> nonce;
+    std::random_device rd;
+    std::mt19937 gen(rd());
+    std::uniform_int_distribution<> dis(0, 255);
+    for (auto& byte : nonce) byte = static_cast<uint8_t>(dis(gen));
+    return nonce;
+}
 
+inline std::array<uint8_t, 32> password_key(const std::string& password) {
     std::array<uint8_t, 32> key;
     pbkdf2_hmac_sha256(
         reinterpret_cast<const uint8_t*>(password.data()), password.size(),
         reinterpret_cast<const uint8_t*>(PBKDF2_SALT), std::strlen(PBKDF2_SALT),
         PBKDF2_ITERATIONS, key.data(), 32
     );
+    return key;
+}
 
-    std::array<uint8_t, NONCE_SIZE> client_nonce;
-    std::random_device rd;
-    std::mt19937 gen(rd());
-    std::uniform_int_distribution<> dis(0, 255);
-    for (auto& byte : client_nonce) byte = static_cast<uint8_t>(dis(gen));
-
+inline std::array<uint8_t, 32> password_proof(const std::array<uint8_t, 32>& key, const std::array<uint8_t, NONCE_SIZE>& client_nonce) {
     std::array<uint8_t, 32> auth_tag;
     std::vector<uint8_t> auth_data;
     auth_data.insert(auth_data.end(), AUTH_CONTEXT, AUTH_CONTEXT + std::strlen(AUTH_CONTEXT));
     auth_data.insert(auth_data.end(), client_nonce.begin(), client_nonce.end());
     hmac_sha256(key.data(), 32, auth_data.data(), auth_data.size(), auth_tag.data());
+    return auth_tag;
+}
 
-    std::string handshake;
-    handshake.append(HANDSHAKE_MAGIC, 5);
-    handshake.append(reinterpret_cast<char*>(client_nonce.data()), NONCE_SIZE);
-    handshake.append(reinterpret_cast<char*>(auth_tag.data()), 32);
-
-    auto send_result = socket.send(handshake);
-    if (send_result.is_error()) return send_result.error();
-
+// Reads the server nonce, or the error the server refused the handshake with.
+inline Result<std::array<uint8_t, NONCE_SIZE>> read_server_nonce(Socket& socket) {
     std::vector<uint8_t> response(3 + NONCE_SIZE);
     auto recv_result = socket.recv_exact(response.data(), response.size());
     if (recv_result.is_error()) return recv_result.error();
@@ -340,9 +484,15 @@ inline Result<std::unique_ptr<EncryptedSocket>> perform_handshake(Socket&& socke
 
     std::array<uint8_t, NONCE_SIZE> server_nonce;
     std::memcpy(server_nonce.data(), response.data() + 3, NONCE_SIZE);
+    return server_nonce;
+}
 
+// Wraps socket with the session key derived from key and both nonces.
+inline std::unique_ptr<EncryptedSocket> wrap_session(Socket&& socket, const uint8_t* key, size_t key_len,
+                                                     const std::array<uint8_t, NONCE_SIZE>& server_nonce,
+                                                     const std::array<uint8_t, NONCE_SIZE>& client_nonce) {
     std::vector<uint8_t> session_data;
-    session_data.insert(session_data.end(), key.begin(), key.end());
+    session_data.insert(session_data.end(), key, key + key_len);
     session_data.insert(session_data.end(), server_nonce.begin(), server_nonce.end());
     session_data.insert(session_data.end(), client_nonce.begin(), client_nonce.end());
     session_data.insert(session_data.end(), SESSION_CONTEXT, SESSION_CONTEXT + std::strlen(SESSION_CONTEXT));
@@ -350,8 +500,121 @@ inline Result<std::unique_ptr<EncryptedSocket>> perform_handshake(Socket&& socke
     std::array<uint8_t, 32> session_key;
     sha256(session_data.data(), session_data.size(), session_key.data());
 
-    auto encrypted = std::make_unique<EncryptedSocket>(std::move(socket), session_key);
-    return encrypted;
+    return std::make_unique<EncryptedSocket>(std::move(socket), session_key);
+}
+
+// Reads the server response to a handshake and wraps socket with the session
+// key derived from key and both nonces.
+inline Result<std::unique_ptr<EncryptedSocket>> complete_handshake(Socket&& socket, const uint8_t* key, size_t key_len,
+                                                                   const std::array<uint8_t, NONCE_SIZE>& client_nonce) {
+    auto server_nonce = read_server_nonce(socket);
+    if (server_nonce.is_error()) return server_nonce.error();
+    return wrap_session(std::move(socket), key, key_len, server_nonce.value(), client_nonce);
+}
+
+inline Result<std::unique_ptr<EncryptedSocket>> perform_handshake(Socket&& socket, const std::string& password) {
+    if (password.empty()) {
+        return Error("Password cannot be empty");
+    }
+
+    auto key = password_key(password);
+    auto client_nonce = random_nonce();
+    auto auth_tag = password_proof(key, client_nonce);
+
+    std::string handshake;
+    handshake.append(HANDSHAKE_MAGIC, 5);
+    handshake.append(reinterpret_cast<char*>(client_nonce.data()), NONCE_SIZE);
+    handshake.append(reinterpret_cast<char*>(auth_tag.data()), 32);
+
+    auto send_result = socket.send(handshake);
+    if (send_result.is_error()) return send_result.error();
+
+    return complete_handshake(std::move(socket), key.data(), key.size(), client_nonce);
+}
+
+// Resumed handshake: presents ticket and proves holding its secret with an
+// HMAC of the nonce and ticket instead of the password proof.
+inline Result<std::unique_ptr<EncryptedSocket>> perform_resume_handshake(Socket&& socket, const ResumeTicket& ticket) {
+    auto client_nonce = random_nonce();
+
+    std::array<uint8_t, 32> proof;
+    std::vector<uint8_t> proof_data;
+    proof_data.insert(proof_data.end(), RESUME_CONTEXT, RESUME_CONTEXT + std::strlen(RESUME_CONTEXT));
+    proof_data.insert(proof_data.end(), client_nonce.begin(), client_nonce.end());
+    proof_data.insert(proof_data.end(), ticket.ticket.begin(), ticket.ticket.end());
+    hmac_sha256(ticket.secret.data(), ticket.secret.size(), proof_data.data(), proof_data.size(), proof.data());
+
+    std::string handshake;
+    handshake.append(RESUME_MAGIC, 5);
+    handshake.append(reinterpret_cast<const char*>(client_nonce.data()), NONCE_SIZE);
+    handshake.append(reinterpret_cast<const char*>(ticket.ticket.data()), ticket.ticket.size());
+    handshake.append(reinterpret_cast<char*>(proof.data()), 32);
+
+    auto send_result = socket.send(handshake);
+    if (send_result.is_error()) return send_result.error();
+
+    return complete_handshake(std::move(socket), ticket.secret.data(), ticket.secret.size(), client_nonce);
+}
+
+// Password handshake with the server proving the identity pinned by
+// server_fingerprint: it signs the handshake, and the session key also derives
+// from an ephemeral ECDH P-256 exchange. Fails if the server proves another
+// identity, or none.
+inline Result<std::unique_ptr<EncryptedSocket>> perform_signed_handshake(Socket&& socket, const std::string& password,
+                                                                         const std::string& server_fingerprint) {
+    if (password.empty()) {
+        return Error("A server fingerprint requires a password");
+    }
+
+    auto key = password_key(password);
+    auto client_nonce = random_nonce();
+    auto auth_tag = password_proof(key, client_nonce);
+    auto eph = p256_generate();
+    auto eph_point = eph ? p256_point(eph.get()) : std::nullopt;
+    if (!eph_point) return Error("Failed to generate handshake key");
+
+    std::string handshake;
+    handshake.append(SIGNED_HANDSHAKE_MAGIC, 5);
+    handshake.append(reinterpret_cast<char*>(client_nonce.data()), NONCE_SIZE);
+    handshake.append(reinterpret_cast<char*>(auth_tag.data()), 32);
+    handshake.append(reinterpret_cast<char*>(eph_point->data()), POINT_SIZE);
+
+    auto send_result = socket.send(handshake);
+    if (send_result.is_error()) return send_result.error();
+
+    auto server_nonce = read_server_nonce(socket);
+    if (server_nonce.is_error()) return server_nonce.error();
+    std::vector<uint8_t> rest(2 * POINT_SIZE + SIGNATURE_SIZE);
+    auto recv_result = socket.recv_exact(rest.data(), rest.size());
+    if (recv_result.is_error()) return recv_result.error();
+    const uint8_t* server_key = rest.data();
+    const uint8_t* identity = rest.data() + POINT_SIZE;
+    const uint8_t* signature = rest.data() + 2 * POINT_SIZE;
+
+    auto presented = fingerprint(identity, POINT_SIZE);
+    if (presented != server_fingerprint) {
+        return Error("Server identity " + presented + " does not match the pinned fingerprint");
+    }
+    auto identity_key = p256_public_key(identity);
+    std::vector<uint8_t> transcript(IDENTITY_CONTEXT, IDENTITY_CONTEXT + std::strlen(IDENTITY_CONTEXT));
+    transcript.insert(transcript.end(), handshake.begin(), handshake.end());
+    transcript.insert(transcript.end(), server_nonce.value().begin(), server_nonce.value().end());
+    transcript.insert(transcript.end(), server_key, server_key + POINT_SIZE);
+    if (!identity_key || !p256_verify(identity_key.get(), transcript, signature)) {
+        return Error("Server identity does not match the pinned fingerprint: invalid handshake signature");
+    }
+
+    auto peer = p256_public_key(server_key);
+    std::array<uint8_t, 32> shared;
+    if (!peer || !p256_derive(eph.get(), peer.get(), shared)) {
+        return Error("Handshake key agreement failed");
+    }
+    std::vector<uint8_t> mix_data(IDENTITY_CONTEXT, IDENTITY_CONTEXT + std::strlen(IDENTITY_CONTEXT));
+    mix_data.insert(mix_data.end(), shared.begin(), shared.end());
+    std::array<uint8_t, 32> handshake_key;
+    hmac_sha256(key.data(), 32, mix_data.data(), mix_data.size(), handshake_key.data());
+
+    return wrap_session(std::move(socket), handshake_key.data(), handshake_key.size(), server_nonce.value(), client_nonce);
 }
 
 } // namespace detail
@@ -17,6 +17,7 @@ const clientTemplate = `{{.Header}}
 #include "config.hpp"
 #include "error.hpp"
 #include "types.hpp"
+#include "features.hpp"
 #include "device.hpp"
 #include "detail/socket.hpp"
 #include "detail/json.hpp"
@@ -25,6 +26,7 @@ const clientTemplate = `{{.Header}}
 #include <memory>
 #include <sstream>
 #include <mutex>
+#include <optional>
 
 namespace viiper {
 
@@ -34,8 +36,14 @@ namespace viiper {
 
 class ViiperClient {
 public:
-    ViiperClient(std::string host, std::uint16_t port = 3242, std::string password = "")
-        : host_(std::move(host)), port_(port), password_(std::move(password)) {}
+    // server_fingerprint, "sha256:<hex>" as the server logs it at startup, pins
+    // the server identity: the password handshake then fails unless the
+    // server proves it. Requires a password.
+    // protocol_version 2 prefixes requests with "eVI2\0" and frames requests
+    // and responses with a u32 big-endian length instead of the null and
+    // newline terminators, so payloads may contain either.
+    ViiperClient(std::string host, std::uint16_t port = 3242, std::string password = "", std::string server_fingerprint = "", int protocol_version = 1)
+        : host_(std::move(host)), port_(port), password_(std::move(password)), server_fingerprint_(std::move(server_fingerprint)), protocol_version_(protocol_version) {}
 
     ~ViiperClient() = default;
 
@@ -48,6 +56,31 @@ public:
     [[nodiscard]] std::uint16_t port() const noexcept { return port_; }
     [[nodiscard]] const std::string& password() const noexcept { return password_; }
 
+    // Returns the optional protocol features of the server as a FeatureMask.
+    // The list is fetched once; servers without the features route support none.
+    Result<FeatureMask> feature_mask() {
+        std::lock_guard<std::mutex> lock(features_mutex_);
+        if (!feature_mask_.has_value()) {
+            auto response = features();
+            if (response.is_error()) {
+                if (response.error().message.rfind("404 ", 0) != 0) return response.error();
+                feature_mask_ = 0;
+            } else {
+                FeatureMask mask = 0;
+                for (const auto& f : response.value().features) mask |= feature_bit(f.name);
+                feature_mask_ = mask;
+            }
+        }
+        return *feature_mask_;
+    }
+
+    // Reports whether the server implements all features in mask (e.g. features::delta).
+    Result<bool> supports(FeatureMask mask) {
+        auto server = feature_mask();
+        if (server.is_error()) return server.error();
+        return (server.value() & mask) == mask;
+    }
+
     // ========================================================================
     // Management API Methods (all return Result<T>)
     // ========================================================================
@@ -71,14 +104,10 @@ public:
         std::uint32_t bus_id,
         const std::string& dev_id
     ) {
-        detail::Socket socket;
-        auto conn_result = socket.connect(host_, port_);
-        if (conn_result.is_error()) return conn_result.error();
+        std::string handshake = frame_request("bus/" + std::to_string(bus_id) + "/" + dev_id);
 
-        std::string handshake = "bus/" + std::to_string(bus_id) + "/" + dev_id + '\0';
-
-        if (!password_.empty()) {
-            auto handshake_result = detail::perform_handshake(std::move(socket), password_);
+        if (!password_.empty() || !server_fingerprint_.empty()) {
+            auto handshake_result = open_encrypted();
             if (handshake_result.is_error()) return handshake_result.error();
             
             auto encrypted_socket = std::move(handshake_result.value());
@@ -87,6 +116,10 @@ public:
             
             return std::unique_ptr<ViiperDevice>(new ViiperDevice(std::move(encrypted_socket)));
         } else {
+            detail::Socket socket;
+            auto conn_result = socket.connect(host_, port_);
+            if (conn_result.is_error()) return conn_result.error();
+
             auto send_result = socket.send(handshake);
             if (send_result.is_error()) return send_result.error();
 
@@ -111,41 +144,125 @@ public:
 
 private:
     Result<json_type> do_request(const std::string& path, const std::string& payload) {
-        std::lock_guard<std::mutex> lock(request_mutex_);
+        auto response = send_request(path, payload);
+        if (!response.is_error()) fetch_ticket();
+        return response;
+    }
+
+    // Opens an authenticated connection, resuming with the cached ticket if
+    // there is one. A refused ticket, e.g. after a server restart or password
+    // change, is dropped and the full handshake runs on a new connection.
+    Result<std::unique_ptr<detail::EncryptedSocket>> open_encrypted() {
+        std::optional<detail::ResumeTicket> ticket;
+        {
+            std::lock_guard<std::mutex> lock(ticket_mutex_);
+            if (ticket_.has_value() && ticket_->usable()) ticket = ticket_;
+        }
+        if (ticket.has_value()) {
+            detail::Socket socket;
+            auto conn_result = socket.connect(host_, port_);
+            if (conn_result.is_error()) return conn_result.error();
+
+            auto resumed = detail::perform_resume_handshake(std::move(socket), *ticket);
+            if (!resumed.is_error()) return resumed;
+
+            std::lock_guard<std::mutex> lock(ticket_mutex_);
+            ticket_.reset();
+        }
 
         detail::Socket socket;
-        auto connect_result = socket.connect(host_, port_);
-        if (connect_result.is_error()) return connect_result.error();
+        auto conn_result = socket.connect(host_, port_);
+        if (conn_result.is_error()) return conn_result.error();
+        if (!server_fingerprint_.empty()) {
+            return detail::perform_signed_handshake(std::move(socket), password_, server_fingerprint_);
+        }
+        return detail::perform_handshake(std::move(socket), password_);
+    }
+
+    // Fetches a resumption ticket unless a usable one is cached. Servers
+    // without resumption are not asked again.
+    void fetch_ticket() {
+        if (password_.empty()) return;
+        {
+            std::lock_guard<std::mutex> lock(ticket_mutex_);
+            if (no_resume_ || (ticket_.has_value() && ticket_->usable())) return;
+        }
+        auto response = send_request("auth/ticket", "");
+        std::lock_guard<std::mutex> lock(ticket_mutex_);
+        if (response.is_error()) {
+            if (response.error().message.rfind("404 ", 0) == 0) no_resume_ = true;
+            return;
+        }
+        auto r = Authticketresponse::from_json(response.value());
+        ticket_ = detail::ResumeTicket::decode(r.ticket, r.secret, r.expiresin);
+        if (!ticket_.has_value()) no_resume_ = true;
+    }
+
+    Result<json_type> send_request(const std::string& path, const std::string& payload) {
+        std::lock_guard<std::mutex> lock(request_mutex_);
 
         std::string request = path;
         if (!payload.empty()) {
             request += " " + payload;
         }
-        request += '\0';
+        request = frame_request(request);
 
-        if (!password_.empty()) {
-            auto handshake_result = detail::perform_handshake(std::move(socket), password_);
+        if (!password_.empty() || !server_fingerprint_.empty()) {
+            auto handshake_result = open_encrypted();
             if (handshake_result.is_error()) return handshake_result.error();
             
             auto encrypted_socket = std::move(handshake_result.value());
             auto send_result = encrypted_socket->send(request);
             if (send_result.is_error()) return send_result.error();
 
-            auto recv_result = encrypted_socket->recv_line();
+            auto recv_result = recv_response(*encrypted_socket);
             if (recv_result.is_error()) return recv_result.error();
 
             return detail::parse_json_response(recv_result.value());
         } else {
+            detail::Socket socket;
+            auto connect_result = socket.connect(host_, port_);
+            if (connect_result.is_error()) return connect_result.error();
+
             auto send_result = socket.send(request);
             if (send_result.is_error()) return send_result.error();
 
-            auto recv_result = socket.recv_line();
+            auto recv_result = recv_response(socket);
             if (recv_result.is_error()) return recv_result.error();
 
             return detail::parse_json_response(recv_result.value());
         }
     }
 
+    // Encodes a request line in the configured framing.
+    std::string frame_request(const std::string& line) const {
+        if (protocol_version_ < 2) return line + '\0';
+        const auto n = static_cast<std::uint32_t>(line.size());
+        std::string framed("eVI2", 4);
+        framed += '\0';
+        framed += static_cast<char>((n >> 24) & 0xFF);
+        framed += static_cast<char>((n >> 16) & 0xFF);
+        framed += static_cast<char>((n >> 8) & 0xFF);
+        framed += static_cast<char>(n & 0xFF);
+        return framed + line;
+    }
+
+    // Reads one response in the configured framing.
+    template <typename Sock>
+    Result<std::string> recv_response(Sock& socket) {
+        if (protocol_version_ < 2) return socket.recv_line();
+        std::uint8_t header[4];
+        auto read_result = socket.recv_exact(header, sizeof(header));
+        if (read_result.is_error()) return read_result.error();
+        const std::uint32_t n = (std::uint32_t(header[0]) << 24) | (std::uint32_t(header[1]) << 16) |
+                                (std::uint32_t(header[2]) << 8) | std::uint32_t(header[3]);
+        if (n > (1u << 20)) return Error("response frame too large");
+        std::string body(n, '\0');
+        read_result = socket.recv_exact(reinterpret_cast<std::uint8_t*>(body.data()), n);
+        if (read_result.is_error()) return read_result.error();
+        return body;
+    }
+
     static std::string format_path(const std::string& pattern,
                                     std::initializer_list<std::pair<std::string, std::string>> params) {
         std::string result = pattern;
@@ -162,7 +279,14 @@ private:
     std::string host_;
     std::uint16_t port_;
     std::string password_;
+    std::string server_fingerprint_;
+    int protocol_version_;
     mutable std::mutex request_mutex_;
+    std::mutex features_mutex_;
+    std::optional<FeatureMask> feature_mask_;
+    std::mutex ticket_mutex_;
+    std::optional<detail::ResumeTicket> ticket_;
+    bool no_resume_ = false;
 };
 
 } // namespace viiper
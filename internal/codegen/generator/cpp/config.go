@@ -35,6 +35,8 @@ const configTemplate = `// Auto-generated VIIPER C++ Client Library
 //   - is_number(), is_string(), is_array(), is_object() -> bool
 //   - get<T>() -> T
 //   - size() -> std::size_t (for arrays)
+//   - array(), object() -> JsonType (empty values), push_back(JsonType)
+//   - items() -> range of entries with key() and value() (for objects)
 //
 // ============================================================================
 
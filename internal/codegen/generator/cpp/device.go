@@ -26,6 +26,9 @@ const deviceTemplate = `// Auto-generated VIIPER C++ Client Library
 #include <mutex>
 #include <concepts>
 #include <variant>
+#include <array>
+#include <chrono>
+#include <optional>
 
 namespace viiper {
 
@@ -34,6 +37,13 @@ concept DeviceInput = requires(T input) {
     { input.to_bytes() } -> std::convertible_to<std::vector<std::uint8_t>>;
 };
 
+// Fixed-size device feedback, e.g. xbox360::Output.
+template<typename T>
+concept DeviceOutput = requires(const std::uint8_t* data, std::size_t len) {
+    { T::OUTPUT_SIZE } -> std::convertible_to<std::size_t>;
+    { T::from_bytes(data, len) } -> std::same_as<Result<T>>;
+};
+
 // ============================================================================
 // Device Stream Connection (thread-safe)
 // ============================================================================
@@ -85,56 +95,66 @@ public:
     // Output (Device -> Client, async)
     // ========================================================================
 
+    // Raw callback: each call gets the bytes of one read, which need not
+    // line up with message boundaries.
     Result<void> on_output(std::size_t buffer_size, OutputCallback callback) {
-        std::lock_guard<std::mutex> lock(callback_mutex_);
-
-        if (output_thread_.joinable()) {
-            return Error("output callback already registered");
-        }
-
-        output_callback_ = std::move(callback);
-        output_buffer_size_ = buffer_size;
-        running_ = true;
-
-        output_thread_ = std::thread([this]() {
-            auto buffer = std::make_unique<std::uint8_t[]>(output_buffer_size_);
-
-            while (running_) {
-                auto recv_result = std::visit([&](auto& sock) -> Result<std::size_t> {
-                    if constexpr (std::is_same_v<std::decay_t<decltype(sock)>, std::unique_ptr<detail::EncryptedSocket>>) {
-                        return sock->recv(buffer.get(), output_buffer_size_);
-                    } else {
-                        return sock.recv(buffer.get(), output_buffer_size_);
-                    }
-                }, socket_);
-                
-                if (recv_result.is_error()) {
-                    if (error_callback_) {
-                        error_callback_(recv_result.error());
-                    }
-                    running_ = false;
-                    break;
-                }
-
-                auto bytes_read = recv_result.value();
-                if (bytes_read == 0) {
-                    running_ = false;
-                    break;
-                }
+        return start_output(buffer_size, false, std::move(callback));
+    }
 
-                std::lock_guard<std::mutex> lock(callback_mutex_);
-                if (output_callback_) {
-                    output_callback_(buffer.get(), bytes_read);
+    // Typed callback: each call gets one decoded T::OUTPUT_SIZE message.
+    // Registering again replaces the callback on the running reader thread.
+    // Callbacks do not fire once stop() returns.
+    template<DeviceOutput T>
+    Result<void> on_output(std::function<void(const T&)> callback) {
+        OutputCallback decode = [this, callback = std::move(callback)](const std::uint8_t* data, std::size_t len) {
+            auto result = T::from_bytes(data, len);
+            if (result.is_error()) {
+                if (error_callback_) {
+                    error_callback_(result.error());
                 }
+                return;
+            }
+            callback(result.value());
+        };
+        {
+            std::lock_guard<std::mutex> lock(callback_mutex_);
+            if (output_thread_.joinable() && running_ && output_exact_ && output_buffer_size_ == T::OUTPUT_SIZE) {
+                output_callback_ = std::move(decode);
+                return Result<void>();
             }
+        }
+        return start_output(T::OUTPUT_SIZE, true, std::move(decode));
+    }
 
+    // Waits up to timeout for the next T::OUTPUT_SIZE message, for callers
+    // that cannot run the reader thread. Returns std::nullopt on timeout.
+    // Not usable while an output callback is registered.
+    template<DeviceOutput T>
+    Result<std::optional<T>> try_read_output(std::chrono::milliseconds timeout) {
+        {
             std::lock_guard<std::mutex> lock(callback_mutex_);
-            if (disconnect_callback_) {
-                disconnect_callback_();
+            if (output_thread_.joinable()) {
+                return Error("output callback registered");
             }
-        });
+        }
 
-        return Result<void>();
+        auto ready = std::visit([&](auto& sock) -> Result<bool> {
+            if constexpr (std::is_same_v<std::decay_t<decltype(sock)>, std::unique_ptr<detail::EncryptedSocket>>) {
+                return sock->wait_readable(timeout);
+            } else {
+                return sock.wait_readable(timeout);
+            }
+        }, socket_);
+        if (ready.is_error()) return ready.error();
+        if (!ready.value()) return std::optional<T>();
+
+        std::array<std::uint8_t, T::OUTPUT_SIZE> buffer{};
+        auto read = recv(buffer.data(), buffer.size(), true);
+        if (read.is_error()) return read.error();
+
+        auto result = T::from_bytes(buffer.data(), buffer.size());
+        if (result.is_error()) return result.error();
+        return std::optional<T>(std::move(result.value()));
     }
 
     void on_disconnect(DisconnectCallback callback) {
@@ -147,6 +167,9 @@ public:
         error_callback_ = std::move(callback);
     }
 
+    // Closes the connection and joins the reader thread; callbacks in flight
+    // finish first. Safe to call from a callback, which then returns before
+    // the thread ends.
     void stop() {
         running_ = false;
         std::visit([](auto& sock) {
@@ -156,7 +179,7 @@ public:
                 sock.force_close();
             }
         }, socket_);
-        if (output_thread_.joinable()) {
+        if (output_thread_.joinable() && output_thread_.get_id() != std::this_thread::get_id()) {
             output_thread_.join();
         }
     }
@@ -172,15 +195,88 @@ public:
     }
 
     explicit ViiperDevice(detail::Socket socket)
-        : socket_(std::move(socket)), running_(false), output_buffer_size_(0) {}
+        : socket_(std::move(socket)), running_(false), output_buffer_size_(0), output_exact_(false) {}
 
     explicit ViiperDevice(std::unique_ptr<detail::EncryptedSocket> encrypted_socket)
-        : socket_(std::move(encrypted_socket)), running_(false), output_buffer_size_(0) {}
+        : socket_(std::move(encrypted_socket)), running_(false), output_buffer_size_(0), output_exact_(false) {}
 
 private:
+    // Reads size bytes if exact, else whatever one read returns, up to size.
+    // Returns 0 at the end of the stream.
+    Result<std::size_t> recv(std::uint8_t* buffer, std::size_t size, bool exact) {
+        return std::visit([&](auto& sock) -> Result<std::size_t> {
+            Result<void> result;
+            if constexpr (std::is_same_v<std::decay_t<decltype(sock)>, std::unique_ptr<detail::EncryptedSocket>>) {
+                if (!exact) return sock->recv(buffer, size);
+                result = sock->recv_exact(buffer, size);
+            } else {
+                if (!exact) return sock.recv(buffer, size);
+                result = sock.recv_exact(buffer, size);
+            }
+            if (result.is_error()) {
+                if (result.error().message == "connection closed") return std::size_t{0};
+                return result.error();
+            }
+            return size;
+        }, socket_);
+    }
+
+    Result<void> start_output(std::size_t buffer_size, bool exact, OutputCallback callback) {
+        std::lock_guard<std::mutex> lock(callback_mutex_);
+
+        if (output_thread_.joinable()) {
+            return Error("output callback already registered");
+        }
+
+        output_callback_ = std::move(callback);
+        output_buffer_size_ = buffer_size;
+        output_exact_ = exact;
+        running_ = true;
+
+        output_thread_ = std::thread([this]() {
+            auto buffer = std::make_unique<std::uint8_t[]>(output_buffer_size_);
+
+            while (running_) {
+                auto recv_result = recv(buffer.get(), output_buffer_size_, output_exact_);
+
+                std::lock_guard<std::mutex> lock(callback_mutex_);
+                // stop() closes the socket under a pending read; that is
+                // not an error to report.
+                if (!running_) {
+                    break;
+                }
+                if (recv_result.is_error()) {
+                    if (error_callback_) {
+                        error_callback_(recv_result.error());
+                    }
+                    running_ = false;
+                    break;
+                }
+
+                auto bytes_read = recv_result.value();
+                if (bytes_read == 0) {
+                    running_ = false;
+                    break;
+                }
+
+                if (output_callback_) {
+                    output_callback_(buffer.get(), bytes_read);
+                }
+            }
+
+            std::lock_guard<std::mutex> lock(callback_mutex_);
+            if (disconnect_callback_) {
+                disconnect_callback_();
+            }
+        });
+
+        return Result<void>();
+    }
+
     std::variant<detail::Socket, std::unique_ptr<detail::EncryptedSocket>> socket_;
     std::atomic<bool> running_;
     std::size_t output_buffer_size_;
+    bool output_exact_;
     OutputCallback output_callback_;
     DisconnectCallback disconnect_callback_;
     ErrorCallback error_callback_;
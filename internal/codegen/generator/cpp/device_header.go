@@ -1,6 +1,7 @@
 package cpp
 
 import (
+	"bytes"
 	"fmt"
 	"log/slog"
 	"os"
@@ -37,7 +38,10 @@ namespace {{camelcase .DeviceName}} {
 // ============================================================================
 // Constants
 // ============================================================================
-{{if gt .OutputSize 0}}
+{{if gt .InputSize 0}}
+constexpr std::size_t INPUT_SIZE = {{.InputSize}};
+{{end}}
+{{- if gt .OutputSize 0}}
 constexpr std::size_t OUTPUT_SIZE = {{.OutputSize}};
 {{end}}
 {{- range .Constants}}
@@ -85,7 +89,13 @@ constexpr std::uint64_t {{$mapName}}_{{$entry.Key}} = {{formatValue $entry.Value
 {{- end}}
 {{- end}}
 {{end}}
-{{if .HasInput}}
+{{range .Enums}}enum class {{.Name}} : {{cpptype .Base}} {
+{{- range .Members}}
+    {{.Name}} = {{.Value}},
+{{- end}}
+};
+
+{{end}}{{if .HasInput}}
 {{$fields := wireFields .DeviceName "c2s"}}
 // ============================================================================
 // Input: Client -> Device
@@ -100,7 +110,10 @@ struct Input {
 	std::vector<{{cpptype (baseType .Type)}}> {{camelcase .Name}};
 {{- end}}
 {{- else if not (isCountField $fields .Name)}}
-    {{cpptype .Type}} {{camelcase .Name}} = 0;
+{{- with wireRangeDoc .}}
+    // {{.}}
+{{- end}}
+    {{wireCppType .}} {{camelcase .Name}}{{wireInit .}};
 {{- end}}
 {{- end}}
 
@@ -114,6 +127,8 @@ struct Input {
 		    const auto v = {{camelcase .Name}}[i];
 	{{- if eq $abt "u8"}}
 		    buf.push_back(static_cast<std::uint8_t>(v));
+	{{- else if eq $abt "bool"}}
+		    buf.push_back(v ? 1 : 0);
 	{{- else if eq $abt "i8"}}
 		    buf.push_back(static_cast<std::uint8_t>(static_cast<std::int8_t>(v)));
 	{{- else if or (eq $abt "u16") (eq $abt "i16")}}
@@ -131,6 +146,8 @@ struct Input {
 		for (const auto& v : {{camelcase .Name}}) {
 	{{- if eq $abt "u8"}}
 		    buf.push_back(static_cast<std::uint8_t>(v));
+	{{- else if eq $abt "bool"}}
+		    buf.push_back(v ? 1 : 0);
 	{{- else if eq $abt "i8"}}
 		    buf.push_back(static_cast<std::uint8_t>(static_cast<std::int8_t>(v)));
 	{{- else if or (eq $abt "u16") (eq $abt "i16")}}
@@ -146,24 +163,41 @@ struct Input {
 	{{- end}}
 {{- else if not (isCountField $fields .Name)}}
 {{- $bt := .Type}}
-{{- if eq $bt "u8"}}
-        buf.push_back({{camelcase .Name}});
+{{- $v := wireValue .}}
+{{- if eq $bt "bool"}}
+        buf.push_back({{$v}} ? 1 : 0);
+{{- else if eq $bt "u8"}}
+        buf.push_back({{$v}});
 {{- else if eq $bt "i8"}}
-        buf.push_back(static_cast<std::uint8_t>({{camelcase .Name}}));
+        buf.push_back(static_cast<std::uint8_t>({{$v}}));
 {{- else if or (eq $bt "u16") (eq $bt "i16")}}
-        buf.push_back(static_cast<std::uint8_t>({{camelcase .Name}} & 0xFF));
-        buf.push_back(static_cast<std::uint8_t>(({{camelcase .Name}} >> 8) & 0xFF));
+        buf.push_back(static_cast<std::uint8_t>({{$v}} & 0xFF));
+        buf.push_back(static_cast<std::uint8_t>(({{$v}} >> 8) & 0xFF));
 {{- else if or (eq $bt "u32") (eq $bt "i32")}}
-        buf.push_back(static_cast<std::uint8_t>({{camelcase .Name}} & 0xFF));
-        buf.push_back(static_cast<std::uint8_t>(({{camelcase .Name}} >> 8) & 0xFF));
-        buf.push_back(static_cast<std::uint8_t>(({{camelcase .Name}} >> 16) & 0xFF));
-        buf.push_back(static_cast<std::uint8_t>(({{camelcase .Name}} >> 24) & 0xFF));
+        buf.push_back(static_cast<std::uint8_t>({{$v}} & 0xFF));
+        buf.push_back(static_cast<std::uint8_t>(({{$v}} >> 8) & 0xFF));
+        buf.push_back(static_cast<std::uint8_t>(({{$v}} >> 16) & 0xFF));
+        buf.push_back(static_cast<std::uint8_t>(({{$v}} >> 24) & 0xFF));
 {{- end}}
 {{- end}}
 {{- end}}
         return buf;
     }
 };
+{{- if gt .InputSize 0}}
+
+// Wire offset and size of each Input field.
+struct InputLayout {
+{{- $prev := ""}}
+{{- range $fields}}
+    static constexpr std::size_t {{pascalcase .Name}}_offset = {{if $prev}}{{$prev}}_offset + {{$prev}}_size{{else}}0{{end}};
+    static constexpr std::size_t {{pascalcase .Name}}_size = sizeof(Input::{{camelcase .Name}});
+{{- $prev = pascalcase .Name}}
+{{- end}}
+    static constexpr std::size_t size = {{$prev}}_offset + {{$prev}}_size;
+};
+static_assert(InputLayout::size == INPUT_SIZE, "Input fields do not add up to INPUT_SIZE");
+{{- end}}
 {{end}}
 {{if .HasOutput}}
 {{$fields := wireFields .DeviceName "s2c"}}
@@ -172,37 +206,43 @@ struct Input {
 // ============================================================================
 
 struct Output {
+{{- if gt .OutputSize 0}}
+    static constexpr std::size_t OUTPUT_SIZE = {{camelcase .DeviceName}}::OUTPUT_SIZE;
+{{end}}
 {{- range $fields}}
-    {{cpptype .Type}} {{camelcase .Name}} = 0;
+    {{wireCppType .}} {{camelcase .Name}}{{wireInit .}};
 {{- end}}
 
     static Result<Output> from_bytes(const std::uint8_t* data, std::size_t len) {
         Output result;
         std::size_t offset = 0;
 {{- range $fields}}
-{{- if eq .Type "u8"}}
+{{- if eq .Type "bool"}}
+        if (offset >= len) return Error("buffer too short");
+        result.{{camelcase .Name}} = data[offset++] != 0;
+{{- else if eq .Type "u8"}}
         if (offset >= len) return Error("buffer too short");
-        result.{{camelcase .Name}} = data[offset++];
+        result.{{camelcase .Name}} = {{with .Enum}}static_cast<{{.}}>({{end}}data[offset++]{{if .Enum}}){{end}};
 {{- else if eq .Type "i8"}}
         if (offset >= len) return Error("buffer too short");
-        result.{{camelcase .Name}} = static_cast<std::int8_t>(data[offset++]);
+        result.{{camelcase .Name}} = {{with .Enum}}static_cast<{{.}}>({{end}}static_cast<std::int8_t>(data[offset++]){{if .Enum}}){{end}};
 {{- else if eq .Type "u16"}}
         if (offset + 2 > len) return Error("buffer too short");
-        result.{{camelcase .Name}} = data[offset] | (static_cast<std::uint16_t>(data[offset + 1]) << 8);
+        result.{{camelcase .Name}} = {{with .Enum}}static_cast<{{.}}>({{end}}data[offset] | (static_cast<std::uint16_t>(data[offset + 1]) << 8){{if .Enum}}){{end}};
         offset += 2;
 {{- else if eq .Type "i16"}}
         if (offset + 2 > len) return Error("buffer too short");
-        result.{{camelcase .Name}} = static_cast<std::int16_t>(data[offset] | (static_cast<std::uint16_t>(data[offset + 1]) << 8));
+        result.{{camelcase .Name}} = {{with .Enum}}static_cast<{{.}}>({{end}}static_cast<std::int16_t>(data[offset] | (static_cast<std::uint16_t>(data[offset + 1]) << 8)){{if .Enum}}){{end}};
         offset += 2;
 {{- else if eq .Type "u32"}}
         if (offset + 4 > len) return Error("buffer too short");
-        result.{{camelcase .Name}} = data[offset] | (static_cast<std::uint32_t>(data[offset + 1]) << 8) |
-                                     (static_cast<std::uint32_t>(data[offset + 2]) << 16) | (static_cast<std::uint32_t>(data[offset + 3]) << 24);
+        result.{{camelcase .Name}} = {{with .Enum}}static_cast<{{.}}>({{end}}data[offset] | (static_cast<std::uint32_t>(data[offset + 1]) << 8) |
+                                     (static_cast<std::uint32_t>(data[offset + 2]) << 16) | (static_cast<std::uint32_t>(data[offset + 3]) << 24){{if .Enum}}){{end}};
         offset += 4;
 {{- else if eq .Type "i32"}}
         if (offset + 4 > len) return Error("buffer too short");
-        result.{{camelcase .Name}} = static_cast<std::int32_t>(data[offset] | (static_cast<std::uint32_t>(data[offset + 1]) << 8) |
-                                     (static_cast<std::uint32_t>(data[offset + 2]) << 16) | (static_cast<std::uint32_t>(data[offset + 3]) << 24));
+        result.{{camelcase .Name}} = {{with .Enum}}static_cast<{{.}}>({{end}}static_cast<std::int32_t>(data[offset] | (static_cast<std::uint32_t>(data[offset + 1]) << 8) |
+                                     (static_cast<std::uint32_t>(data[offset + 2]) << 16) | (static_cast<std::uint32_t>(data[offset + 3]) << 24)){{if .Enum}}){{end}};
         offset += 4;
 {{- end}}
 {{- end}}
@@ -210,19 +250,75 @@ struct Output {
         return result;
     }
 };
+
+// Wire offset and size of each Output field.
+struct OutputLayout {
+{{- $prev := ""}}
+{{- range $fields}}
+    static constexpr std::size_t {{pascalcase .Name}}_offset = {{if $prev}}{{$prev}}_offset + {{$prev}}_size{{else}}0{{end}};
+    static constexpr std::size_t {{pascalcase .Name}}_size = sizeof(Output::{{camelcase .Name}});
+{{- $prev = pascalcase .Name}}
+{{- end}}
+    static constexpr std::size_t size = {{$prev}}_offset + {{$prev}}_size;
+};
+static_assert(OutputLayout::size == OUTPUT_SIZE, "Output fields do not add up to OUTPUT_SIZE");
+
+// OutputView reads Output fields in place, without copying or allocating.
+// data must hold at least OUTPUT_SIZE bytes and outlive the view.
+class OutputView {
+public:
+    explicit constexpr OutputView(const std::uint8_t* data) noexcept : data_(data) {}
+{{range $fields}}
+    [[nodiscard]] constexpr {{wireCppType .}} {{camelcase .Name}}() const noexcept { return read<{{wireCppType .}}>(OutputLayout::{{pascalcase .Name}}_offset); }
+{{- end}}
+
+private:
+    template <typename T>
+    [[nodiscard]] constexpr T read(std::size_t offset) const noexcept {
+        std::uint64_t v = 0;
+        for (std::size_t i = 0; i < sizeof(T); i++) {
+            v |= static_cast<std::uint64_t>(data_[offset + i]) << (8 * i);
+        }
+        return static_cast<T>(v);
+    }
+
+    const std::uint8_t* data_;
+};
 {{end}}
 
 } // namespace {{camelcase .DeviceName}}
 } // namespace viiper
 `
 
+// wireCppType returns the C++ type of a scalar wire field.
+func wireCppType(f scanner.WireField) string {
+	if f.Enum != "" {
+		return f.Enum
+	}
+	return cppType(f.Type)
+}
+
 func generateDeviceHeader(logger *slog.Logger, devicesDir, deviceName string, md *meta.Metadata) error {
 	logger.Debug("Generating device header", "device", deviceName)
 	outputFile := filepath.Join(devicesDir, deviceName+".hpp")
 
+	src, err := RenderDeviceHeader(md, deviceName)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputFile, src, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", outputFile, err)
+	}
+
+	logger.Info("Generated device header", "device", deviceName, "file", outputFile)
+	return nil
+}
+
+// RenderDeviceHeader returns the contents of devices/<deviceName>.hpp.
+func RenderDeviceHeader(md *meta.Metadata, deviceName string) ([]byte, error) {
 	devicePkg, ok := md.DevicePackages[deviceName]
 	if !ok {
-		return fmt.Errorf("device package %s not found in metadata", deviceName)
+		return nil, fmt.Errorf("device package %s not found in metadata", deviceName)
 	}
 
 	hasInput := md.WireTags != nil && md.WireTags.HasDirection(deviceName, "c2s")
@@ -232,14 +328,24 @@ func generateDeviceHeader(logger *slog.Logger, devicesDir, deviceName string, md
 	funcs["isLast"] = func(i int, entries []common.MapEntry) bool {
 		return i == len(entries)-1
 	}
-
-	tmpl := template.Must(template.New("device").Funcs(funcs).Parse(deviceHeaderTemplate))
-
-	f, err := os.Create(outputFile)
-	if err != nil {
-		return fmt.Errorf("create device header: %w", err)
+	funcs["wireCppType"] = wireCppType
+	funcs["wireInit"] = func(f scanner.WireField) string {
+		switch {
+		case f.Enum != "":
+			return "{}"
+		case f.Type == "bool":
+			return " = false"
+		}
+		return " = 0"
+	}
+	// wireValue converts enum fields to their base type.
+	funcs["wireValue"] = func(f scanner.WireField) string {
+		if f.Enum != "" {
+			return "static_cast<" + cppType(f.Type) + ">(" + common.ToCamelCase(f.Name) + ")"
+		}
+		return common.ToCamelCase(f.Name)
 	}
-	defer f.Close()
+	tmpl := template.Must(template.New("device").Funcs(funcs).Parse(deviceHeaderTemplate))
 
 	hasMaps := false
 	for _, m := range devicePkg.Maps {
@@ -250,12 +356,12 @@ func generateDeviceHeader(logger *slog.Logger, devicesDir, deviceName string, md
 		}
 	}
 
-	// Calculate OUTPUT_SIZE from s2c wire tag
-	outputSize := 0
+	// Calculate OUTPUT_SIZE and INPUT_SIZE from the wire tags; variable
+	// length messages get neither.
+	inputSize, outputSize := 0, 0
 	if md.WireTags != nil {
-		if s2cTag := md.WireTags.GetTag(deviceName, "s2c"); s2cTag != nil {
-			outputSize = common.CalculateOutputSize(s2cTag)
-		}
+		inputSize = common.CalculateOutputSize(md.WireTags.GetTag(deviceName, "c2s"))
+		outputSize = common.CalculateOutputSize(md.WireTags.GetTag(deviceName, "s2c"))
 	}
 
 	hasFixedWireArrays := false
@@ -277,27 +383,30 @@ func generateDeviceHeader(logger *slog.Logger, devicesDir, deviceName string, md
 		DeviceName         string
 		Constants          []scanner.ConstantInfo
 		Maps               []scanner.MapInfo
+		Enums              []common.WireEnum
 		HasInput           bool
 		HasOutput          bool
 		HasMaps            bool
 		HasFixedWireArrays bool
+		InputSize          int
 		OutputSize         int
 	}{
 		Header:             writeFileHeader(),
 		DeviceName:         deviceName,
 		Constants:          devicePkg.Constants,
 		Maps:               devicePkg.Maps,
+		Enums:              common.WireEnums(md, deviceName),
 		HasInput:           hasInput,
 		HasOutput:          hasOutput,
 		HasMaps:            hasMaps,
 		HasFixedWireArrays: hasFixedWireArrays,
+		InputSize:          inputSize,
 		OutputSize:         outputSize,
 	}
 
-	if err := tmpl.Execute(f, data); err != nil {
-		return fmt.Errorf("execute device template: %w", err)
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("execute device template: %w", err)
 	}
-
-	logger.Info("Generated device header", "device", deviceName, "file", outputFile)
-	return nil
+	return buf.Bytes(), nil
 }
@@ -0,0 +1,170 @@
+package cpp_test
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Alia5/VIIPER/internal/codegen/generator/cpp"
+	"github.com/Alia5/VIIPER/internal/codegen/meta"
+	"github.com/Alia5/VIIPER/internal/codegen/scanner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var goldenDevices = []string{"xbox360", "dualshock4"}
+
+func deviceMetadata(t *testing.T) *meta.Metadata {
+	t.Helper()
+	md := &meta.Metadata{DevicePackages: map[string]*scanner.DeviceConstants{}}
+	var paths []string
+	for _, name := range goldenDevices {
+		path := filepath.Join("..", "..", "..", "..", "device", name)
+		consts, err := scanner.ScanDeviceConstants(path)
+		require.NoError(t, err)
+		md.DevicePackages[name] = consts
+		paths = append(paths, path)
+	}
+	tags, err := scanner.ScanWireTags(paths)
+	require.NoError(t, err)
+	md.WireTags = tags
+	return md
+}
+
+func TestRenderDeviceHeaderGolden(t *testing.T) {
+	md := deviceMetadata(t)
+	for _, name := range goldenDevices {
+		t.Run(name, func(t *testing.T) {
+			got, err := cpp.RenderDeviceHeader(md, name)
+			require.NoError(t, err)
+
+			golden := filepath.Join("testdata", name+".hpp.golden")
+			if *update {
+				require.NoError(t, os.WriteFile(golden, got, 0o644))
+			}
+			want, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			assert.Equal(t, string(want), string(got), "%s is stale; rerun this test with -update", golden)
+		})
+	}
+}
+
+const layoutCheck = `#include <viiper/devices/xbox360.hpp>
+#include <viiper/devices/dualshock4.hpp>
+
+static_assert(viiper::xbox360::InputLayout::Lx_offset == 6);
+static_assert(viiper::xbox360::InputLayout::Reserved_size == 6);
+static_assert(viiper::dualshock4::OutputLayout::Ledblue_offset == 4);
+
+constexpr std::uint8_t report[] = {1, 2, 3, 4, 5, 6, 7};
+static_assert(viiper::dualshock4::OutputView(report).ledblue() == 5);
+
+int main() { return 0; }
+`
+
+func TestDeviceHeaderLayoutCompiles(t *testing.T) {
+	cxx, err := exec.LookPath("c++")
+	if err != nil {
+		t.Skip("no C++ compiler")
+	}
+	dir := t.TempDir()
+	require.NoError(t, cpp.Generate(slog.New(slog.DiscardHandler), dir, deviceMetadata(t)))
+	include := filepath.Join(dir, "include")
+	src := filepath.Join(dir, "layout.cpp")
+	require.NoError(t, os.WriteFile(src, []byte(layoutCheck), 0o644))
+	compile := func() (string, error) {
+		out, err := exec.Command(cxx, "-std=c++20", "-fsyntax-only", "-I", include, src).CombinedOutput()
+		return string(out), err
+	}
+
+	out, err := compile()
+	require.NoError(t, err, out)
+
+	// A stale size constant, as after a wire tag change, must not compile.
+	header := filepath.Join(include, "viiper", "devices", "xbox360.hpp")
+	b, err := os.ReadFile(header)
+	require.NoError(t, err)
+	corrupted := strings.Replace(string(b), "OUTPUT_SIZE = 2;", "OUTPUT_SIZE = 3;", 1)
+	require.NotEqual(t, string(b), corrupted)
+	require.NoError(t, os.WriteFile(header, []byte(corrupted), 0o644))
+	out, err = compile()
+	require.Error(t, err)
+	assert.Contains(t, out, "Output fields do not add up to OUTPUT_SIZE")
+}
+
+// gadgetMetadata describes a device with enum and bool wire fields.
+func gadgetMetadata() *meta.Metadata {
+	return &meta.Metadata{
+		DevicePackages: map[string]*scanner.DeviceConstants{
+			"gadget": {DeviceType: "gadget", Constants: []scanner.ConstantInfo{
+				{Name: "ModeIdle", Value: 0, Type: "uint8"},
+				{Name: "ModeSpin", Value: 1, Type: "uint8"},
+				{Name: "ModeBrake", Value: 2, Type: "uint8"},
+				{Name: "LightOff", Value: 0, Type: "uint16"},
+				{Name: "LightFull", Value: 0x200, Type: "uint16"},
+			}},
+		},
+		WireTags: &scanner.WireTags{Tags: map[string]map[string]*scanner.WireTag{
+			"gadget": {
+				"c2s": {Device: "gadget", Direction: "c2s", Fields: []scanner.WireField{
+					{Name: "mode", Type: "u8", Enum: "Mode"},
+					{Name: "armed", Type: "bool"},
+					{Name: "speed", Type: "u16"},
+				}},
+				"s2c": {Device: "gadget", Direction: "s2c", Fields: []scanner.WireField{
+					{Name: "light", Type: "u16", Enum: "Light"},
+					{Name: "fault", Type: "bool"},
+				}},
+			},
+		}},
+	}
+}
+
+const gadgetCheck = `#include <viiper/devices/gadget.hpp>
+
+using namespace viiper::gadget;
+
+static_assert(INPUT_SIZE == 4 && OUTPUT_SIZE == 3);
+static_assert(std::is_same_v<decltype(Input::mode), Mode>);
+static_assert(std::is_same_v<std::underlying_type_t<Light>, std::uint16_t>);
+
+constexpr std::uint8_t report[] = {0x00, 0x02, 0x01};
+static_assert(OutputView(report).light() == Light::Full);
+static_assert(OutputView(report).fault());
+
+int main() {
+    Input in;
+    in.mode = Mode::Brake;
+    in.armed = true;
+    in.speed = 0x1234;
+    if (in.to_bytes() != std::vector<std::uint8_t>{0x02, 0x01, 0x34, 0x12}) return 1;
+
+    auto out = Output::from_bytes(report, sizeof(report));
+    if (!out.ok() || out.value().light != Light::Full || !out.value().fault) return 2;
+    return 0;
+}
+`
+
+func TestDeviceHeaderEnumAndBoolRoundTrip(t *testing.T) {
+	cxx, err := exec.LookPath("c++")
+	if err != nil {
+		t.Skip("no C++ compiler")
+	}
+	dir := t.TempDir()
+	require.NoError(t, cpp.Generate(slog.New(slog.DiscardHandler), dir, gadgetMetadata()))
+
+	header, err := os.ReadFile(filepath.Join(dir, "include", "viiper", "devices", "gadget.hpp"))
+	require.NoError(t, err)
+	assert.Contains(t, string(header), "enum class Mode : std::uint8_t {\n    Idle = 0,\n    Spin = 1,\n    Brake = 2,\n};")
+
+	src := filepath.Join(dir, "gadget.cpp")
+	bin := filepath.Join(dir, "gadget")
+	require.NoError(t, os.WriteFile(src, []byte(gadgetCheck), 0o644))
+	out, err := exec.Command(cxx, "-std=c++20", "-Wall", "-Wextra", "-Werror", "-I", filepath.Join(dir, "include"), "-o", bin, src).CombinedOutput()
+	require.NoError(t, err, string(out))
+	out, err = exec.Command(bin).CombinedOutput()
+	require.NoError(t, err, string(out))
+}
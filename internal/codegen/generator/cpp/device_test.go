@@ -0,0 +1,72 @@
+package cpp_test
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Alia5/VIIPER/internal/codegen/generator/cpp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubJSON stands in for nlohmann::json; device.hpp only names the type.
+const stubJSON = `#pragma once
+namespace stubjson { struct json {}; }
+`
+
+const outputCheck = `#define VIIPER_JSON_INCLUDE "stub_json.hpp"
+#define VIIPER_JSON_NAMESPACE stubjson
+#define VIIPER_JSON_TYPE json
+
+#include <viiper/device.hpp>
+#include <viiper/devices/xbox360.hpp>
+#include <viiper/devices/dualshock4.hpp>
+
+static_assert(viiper::DeviceOutput<viiper::xbox360::Output>);
+static_assert(viiper::xbox360::Output::OUTPUT_SIZE == viiper::xbox360::OUTPUT_SIZE);
+static_assert(!viiper::DeviceOutput<viiper::xbox360::Input>);
+
+void typed(viiper::ViiperDevice& dev) {
+    auto registered = dev.on_output<viiper::dualshock4::Output>([](const viiper::dualshock4::Output& out) {
+        (void)out.ledred;
+    });
+    (void)registered;
+    viiper::Result<std::optional<viiper::xbox360::Output>> polled =
+        dev.try_read_output<viiper::xbox360::Output>(std::chrono::milliseconds(5));
+    (void)polled;
+    dev.stop();
+}
+
+int main() { return 0; }
+`
+
+func TestDeviceOutputCompiles(t *testing.T) {
+	cxx, err := exec.LookPath("c++")
+	if err != nil {
+		t.Skip("no C++ compiler")
+	}
+	dir := t.TempDir()
+	require.NoError(t, cpp.Generate(slog.New(slog.DiscardHandler), dir, deviceMetadata(t)))
+	include := filepath.Join(dir, "include")
+
+	device, err := os.ReadFile(filepath.Join(include, "viiper", "device.hpp"))
+	require.NoError(t, err)
+	for _, section := range []string{
+		"concept DeviceOutput",
+		"Result<void> on_output(std::function<void(const T&)> callback)",
+		"Result<std::optional<T>> try_read_output(std::chrono::milliseconds timeout)",
+		"sock->wait_readable(timeout)",
+		"sock->recv_exact(buffer, size)",
+	} {
+		assert.Contains(t, string(device), section)
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stub_json.hpp"), []byte(stubJSON), 0o644))
+	src := filepath.Join(dir, "output.cpp")
+	require.NoError(t, os.WriteFile(src, []byte(outputCheck), 0o644))
+	out, err := exec.Command(cxx, "-std=c++20", "-fsyntax-only", "-Wall", "-Wextra", "-I", include, "-I", dir, src).CombinedOutput()
+	require.NoError(t, err, string(out))
+}
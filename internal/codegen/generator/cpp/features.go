@@ -0,0 +1,81 @@
+package cpp
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/Alia5/VIIPER/internal/codegen/meta"
+)
+
+const featuresTemplate = `{{.Header}}
+#pragma once
+
+#include <cstdint>
+#include <string_view>
+
+namespace viiper {
+
+// ============================================================================
+// Optional protocol features, one bit each (see ViiperClient::supports)
+// ============================================================================
+
+using FeatureMask = std::uint64_t;
+
+namespace features {
+{{- range $i, $f := .Features}}
+// since {{$f.Since}}, negotiated by {{$f.Negotiation}}
+constexpr FeatureMask {{ident $f.Name}} = FeatureMask{1} << {{$i}};
+{{- end}}
+} // namespace features
+
+// Returns the bit of a feature name, or 0 for features unknown to this library.
+[[nodiscard]] inline FeatureMask feature_bit(std::string_view name) noexcept {
+{{- range .Features}}
+    if (name == "{{.Name}}") return features::{{ident .Name}};
+{{- end}}
+    return 0;
+}
+
+} // namespace viiper
+`
+
+// RenderFeatures returns features.hpp for the feature registry in md.
+// Each feature is assigned the bit of its registry position.
+func RenderFeatures(md *meta.Metadata) ([]byte, error) {
+	if len(md.Features) > 64 {
+		return nil, fmt.Errorf("%d features do not fit a 64-bit FeatureMask", len(md.Features))
+	}
+	tmpl, err := template.New("featuresCpp").Funcs(template.FuncMap{
+		"ident": func(name string) string { return strings.ReplaceAll(name, "-", "_") },
+	}).Parse(featuresTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("parse template: %w", err)
+	}
+	data := struct {
+		Header   string
+		Features any
+	}{Header: writeFileHeader(), Features: md.Features}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("execute template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func generateFeatures(logger *slog.Logger, includeDir string, md *meta.Metadata) error {
+	src, err := RenderFeatures(md)
+	if err != nil {
+		return err
+	}
+	outputFile := filepath.Join(includeDir, "features.hpp")
+	if err := os.WriteFile(outputFile, src, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", outputFile, err)
+	}
+	logger.Info("Generated features.hpp", "file", outputFile)
+	return nil
+}
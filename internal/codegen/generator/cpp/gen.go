@@ -52,6 +52,10 @@ func Generate(logger *slog.Logger, outputDir string, md *meta.Metadata) error {
 		return err
 	}
 
+	if err := generateFeatures(logger, includeDir, md); err != nil {
+		return err
+	}
+
 	if err := generateClient(logger, includeDir, md); err != nil {
 		return err
 	}
@@ -28,6 +28,7 @@ func tplFuncs(md *meta.Metadata) template.FuncMap {
 		"isCustomType": isCustomType,
 		"hasWireTag":   func(device, dir string) bool { return common.HasWireTag(md, device, dir) },
 		"wireFields":   func(device, dir string) []scanner.WireField { return common.GetWireFields(md, device, dir) },
+		"wireRangeDoc": common.WireRangeDoc,
 		"isArrayType":  func(t string) bool { return strings.Contains(t, "*") },
 		"isFixedArrayType": func(t string) bool {
 			idx := strings.Index(t, "*")
@@ -83,6 +84,10 @@ func tplFuncs(md *meta.Metadata) template.FuncMap {
 
 func fieldCppType(field scanner.FieldInfo) string {
 	t := cppType(field.Type)
+	// A DTO can't hold itself by value; the back edge stays raw JSON.
+	if field.Cyclic && field.TypeKind != "slice" {
+		t = "json_type"
+	}
 	if field.Optional {
 		if !strings.HasPrefix(t, "std::optional<") {
 			t = "std::optional<" + t + ">"
@@ -92,19 +97,21 @@ func fieldCppType(field scanner.FieldInfo) string {
 }
 
 func cppType(goType string) string {
-	base, isSlice, isPointer := common.NormalizeGoType(goType)
-	if strings.HasPrefix(base, "map[") {
+	switch {
+	case strings.HasPrefix(goType, "*"):
+		return "std::optional<" + cppType(goType[1:]) + ">"
+	case strings.HasPrefix(goType, "[]"):
+		return "std::vector<" + cppType(goType[2:]) + ">"
+	case strings.HasPrefix(goType, "map[string]"):
+		value := goType[len("map[string]"):]
+		if value == "any" || value == "interface{}" {
+			return "json_type"
+		}
+		return "std::map<std::string, " + cppType(value) + ">"
+	case strings.HasPrefix(goType, "map["), goType == "any", goType == "interface{}":
 		return "json_type"
 	}
-
-	cppBase := goBaseToCpp(base)
-	if isSlice {
-		return "std::vector<" + cppBase + ">"
-	}
-	if isPointer {
-		return "std::optional<" + cppBase + ">"
-	}
-	return cppBase
+	return goBaseToCpp(goType)
 }
 
 func goBaseToCpp(base string) string {
@@ -17,6 +17,7 @@ const jsonTemplate = `// Auto-generated VIIPER C++ Client Library
 #include <string>
 #include <optional>
 #include <vector>
+#include <map>
 #include <type_traits>
 
 namespace viiper {
@@ -64,39 +65,95 @@ inline Result<json_type> parse_json_response(const std::string& response) {
     }
 }
 
-template<typename T>
-inline std::optional<T> get_optional_field(const json_type& j, const std::string& key) {
-    if (j.contains(key) && !j[key].is_null()) {
-        return j[key].template get<T>();
-    }
-    return std::nullopt;
-}
-
 template<typename T, typename = void>
 struct has_from_json : std::false_type {};
 
 template<typename T>
 struct has_from_json<T, std::void_t<decltype(T::from_json(std::declval<const json_type&>()))>> : std::true_type {};
 
+template<typename T, typename = void>
+struct has_to_json : std::false_type {};
+
 template<typename T>
-inline std::vector<T> get_array(const json_type& j, const std::string& key) {
-    if (!j.contains(key) || !j[key].is_array()) {
-        return {};
-    }
+struct has_to_json<T, std::void_t<decltype(std::declval<const T&>().to_json())>> : std::true_type {};
+
+template<typename T>
+struct is_vector : std::false_type {};
+
+template<typename T>
+struct is_vector<std::vector<T>> : std::true_type {};
 
-    std::vector<T> result;
-    const auto& arr = j[key];
-    result.reserve(arr.size());
+template<typename T>
+struct is_string_map : std::false_type {};
+
+template<typename T>
+struct is_string_map<std::map<std::string, T>> : std::true_type {};
 
-    for (const auto& item : arr) {
-        if constexpr (has_from_json<T>::value) {
-            result.push_back(T::from_json(item));
-        } else {
-            result.push_back(item.template get<T>());
+// Decodes j into T, recursing into DTOs, arrays and objects.
+template<typename T>
+inline T from_json_value(const json_type& j) {
+    if constexpr (has_from_json<T>::value) {
+        return T::from_json(j);
+    } else if constexpr (is_vector<T>::value) {
+        T result;
+        if (!j.is_array()) {
+            return result;
+        }
+        result.reserve(j.size());
+        for (const auto& item : j) {
+            result.push_back(from_json_value<typename T::value_type>(item));
         }
+        return result;
+    } else if constexpr (is_string_map<T>::value) {
+        T result;
+        if (!j.is_object()) {
+            return result;
+        }
+        for (const auto& item : j.items()) {
+            result.emplace(item.key(), from_json_value<typename T::mapped_type>(item.value()));
+        }
+        return result;
+    } else {
+        return j.template get<T>();
+    }
+}
+
+// Encodes value, the inverse of from_json_value.
+template<typename T>
+inline json_type to_json_value(const T& value) {
+    if constexpr (has_to_json<T>::value) {
+        return value.to_json();
+    } else if constexpr (is_vector<T>::value) {
+        json_type arr = json_type::array();
+        for (const auto& item : value) {
+            arr.push_back(to_json_value(item));
+        }
+        return arr;
+    } else if constexpr (is_string_map<T>::value) {
+        json_type obj = json_type::object();
+        for (const auto& [key, item] : value) {
+            obj[key] = to_json_value(item);
+        }
+        return obj;
+    } else {
+        return json_type(value);
+    }
+}
+
+template<typename T>
+inline std::optional<T> get_optional_field(const json_type& j, const std::string& key) {
+    if (j.contains(key) && !j[key].is_null()) {
+        return from_json_value<T>(j[key]);
     }
+    return std::nullopt;
+}
 
-    return result;
+template<typename T>
+inline std::vector<T> get_array(const json_type& j, const std::string& key) {
+    if (!j.contains(key)) {
+        return {};
+    }
+    return from_json_value<std::vector<T>>(j[key]);
 }
 
 } // namespace detail
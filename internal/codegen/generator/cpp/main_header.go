@@ -37,6 +37,7 @@ const mainHeaderTemplate = `{{.Header}}
 #include "config.hpp"
 #include "error.hpp"
 #include "types.hpp"
+#include "features.hpp"
 #include "client.hpp"
 #include "device.hpp"
 
@@ -279,6 +279,35 @@ public:
         return line;
     }
 
+    /// Wait up to timeout for data to arrive. Returns false on timeout; a
+    /// closed connection counts as readable, the next recv reports it.
+    Result<bool> wait_readable(std::chrono::milliseconds timeout) {
+        std::lock_guard<std::mutex> lock(recv_mutex_);
+
+        if (!is_valid_internal()) {
+            return Error("socket not connected");
+        }
+
+#ifdef _WIN32
+        WSAPOLLFD pfd{};
+        pfd.fd = fd_;
+        pfd.events = POLLRDNORM;
+        auto result = ::WSAPoll(&pfd, 1, static_cast<int>(timeout.count()));
+#else
+        pollfd pfd{};
+        pfd.fd = fd_;
+        pfd.events = POLLIN;
+        int result;
+        do {
+            result = ::poll(&pfd, 1, static_cast<int>(timeout.count()));
+        } while (result < 0 && errno == EINTR);
+#endif
+        if (result < 0) {
+            return Error("poll failed");
+        }
+        return result > 0;
+    }
+
     void close() {
         std::scoped_lock lock(send_mutex_, recv_mutex_);
         close_internal();
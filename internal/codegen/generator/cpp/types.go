@@ -18,6 +18,7 @@ const typesTemplate = `{{.Header}}
 #include "detail/json.hpp"
 #include <string>
 #include <vector>
+#include <map>
 #include <optional>
 #include <cstdint>
 
@@ -41,26 +42,12 @@ struct {{pascalcase .Name}} {
     static {{pascalcase .Name}} from_json(const json_type& j) {
         {{pascalcase .Name}} result;
 {{- range .Fields}}
-{{- if and .Optional (eq .TypeKind "map")}}
-        if (j.contains("{{.JSONName}}") && !j["{{.JSONName}}"].is_null()) {
-            result.{{camelcase .Name}} = j["{{.JSONName}}"];
-        } else {
-            result.{{camelcase .Name}} = std::nullopt;
-        }
-{{- else if .Optional}}
+{{- if .Optional}}
         result.{{camelcase .Name}} = detail::get_optional_field<{{fieldcpptype . | unwrapOptional}}>(j, "{{.JSONName}}");
-{{- else if eq .TypeKind "slice"}}
-        result.{{camelcase .Name}} = detail::get_array<{{cpptype .Type | sliceElementType}}>(j, "{{.JSONName}}");
-{{- else if eq .TypeKind "map"}}
-        if (j.contains("{{.JSONName}}")) {
-            result.{{camelcase .Name}} = j["{{.JSONName}}"];
-        }
-{{- else if isCustomType .Type}}
+{{- else}}
         if (j.contains("{{.JSONName}}")) {
-            result.{{camelcase .Name}} = {{cpptype .Type}}::from_json(j["{{.JSONName}}"]);
+            result.{{camelcase .Name}} = detail::from_json_value<{{fieldcpptype .}}>(j["{{.JSONName}}"]);
         }
-{{- else}}
-        result.{{camelcase .Name}} = j.value("{{.JSONName}}", {{cpptype .Type}}{});
 {{- end}}
 {{- end}}
         return result;
@@ -71,22 +58,10 @@ struct {{pascalcase .Name}} {
 {{- range .Fields}}
 {{- if .Optional}}
         if ({{camelcase .Name}}.has_value()) {
-            j["{{.JSONName}}"] = {{camelcase .Name}}.value();
-        }
-{{- else if eq .TypeKind "slice"}}
-        {
-            json_type arr = json_type::array();
-            for (const auto& item : {{camelcase .Name}}) {
-                {{- if isCustomType .Type}}
-                arr.push_back(item.to_json());
-                {{- else}}
-                arr.push_back(item);
-                {{- end}}
-            }
-            j["{{.JSONName}}"] = std::move(arr);
+            j["{{.JSONName}}"] = detail::to_json_value({{camelcase .Name}}.value());
         }
 {{- else}}
-        j["{{.JSONName}}"] = {{camelcase .Name}};
+        j["{{.JSONName}}"] = detail::to_json_value({{camelcase .Name}});
 {{- end}}
 {{- end}}
         return j;
@@ -117,7 +92,7 @@ func generateTypes(logger *slog.Logger, includeDir string, md *meta.Metadata) er
 		DTOs   []scanner.DTOSchema
 	}{
 		Header: writeFileHeader(),
-		DTOs:   md.DTOs,
+		DTOs:   scanner.OrderDTOs(md.DTOs),
 	}
 
 	if err := tmpl.Execute(f, data); err != nil {
@@ -32,6 +32,13 @@ internal static class ViiperAuth
     private const string SessionContext = "VIIPER-Session-v1";
     private const int PBKDF2Iterations = 100000;
     private const string PBKDF2Salt = "VIIPER-Key-v1";
+    private const string ResumeMagic = "eVR1\0";
+    private const string ResumeContext = "VIIPER-Resume-v1";
+    private const int TicketSize = 24;
+    private const string SignedHandshakeMagic = "eVS1\0";
+    private const string IdentityContext = "VIIPER-Identity-v1";
+    private const int PointSize = 65;
+    private const int SignatureSize = 64;
 
     /// <summary>
     /// Derive a 32-byte key from password using PBKDF2-SHA256
@@ -111,6 +118,158 @@ internal static class ViiperAuth
         
         await stream.WriteAsync(handshakeMsg, 0, handshakeMsg.Length, cancellationToken);
         
+        var serverNonce = await ReadServerNonceAsync(stream, cancellationToken);
+        
+        var sessionKey = DeriveSessionKey(key, serverNonce, clientNonce);
+        
+        return new EncryptedStream(stream, sessionKey);
+    }
+
+    /// <summary>
+    /// Fingerprint of an uncompressed P-256 public key as servers log it at startup:
+    /// "sha256:" followed by the hex SHA-256 of the key
+    /// </summary>
+    public static string Fingerprint(byte[] publicKey)
+    {
+        return "sha256:" + Convert.ToHexString(SHA256.HashData(publicKey)).ToLowerInvariant();
+    }
+
+    /// <summary>
+    /// Perform the authentication handshake with the server proving the identity pinned by
+    /// serverFingerprint. Throws if it proves another identity, or none. The session key also
+    /// derives from an ephemeral ECDH P-256 exchange.
+    /// </summary>
+    public static async Task<Stream> PerformSignedHandshakeAsync(
+        Stream stream,
+        string password,
+        string serverFingerprint,
+        CancellationToken cancellationToken = default)
+    {
+        var key = DeriveKey(password);
+        var clientNonce = RandomNumberGenerator.GetBytes(NonceSize);
+
+        byte[] authTag;
+        using (var hmac = new HMACSHA256(key))
+        {
+            authTag = hmac.ComputeHash(Concat(Encoding.UTF8.GetBytes(AuthContext), clientNonce));
+        }
+
+        using var ecdh = ECDiffieHellman.Create(ECCurve.NamedCurves.nistP256);
+        var q = ecdh.ExportParameters(false).Q;
+        var handshakeMsg = Concat(Encoding.UTF8.GetBytes(SignedHandshakeMagic), clientNonce, authTag, new byte[] { 0x04 }, q.X!, q.Y!);
+        await stream.WriteAsync(handshakeMsg, 0, handshakeMsg.Length, cancellationToken);
+
+        var serverNonce = await ReadServerNonceAsync(stream, cancellationToken);
+        var rest = new byte[2 * PointSize + SignatureSize];
+        await ReadExactlyAsync(stream, rest, cancellationToken);
+        var serverKey = rest[..PointSize];
+        var identity = rest[PointSize..(2 * PointSize)];
+        var signature = rest[(2 * PointSize)..];
+
+        var presented = Fingerprint(identity);
+        if (presented != serverFingerprint)
+        {
+            throw new InvalidOperationException($"Server identity {presented} does not match the pinned fingerprint");
+        }
+        using (var ecdsa = ECDsa.Create(PublicKey(identity)))
+        {
+            var transcript = SHA256.HashData(Concat(Encoding.UTF8.GetBytes(IdentityContext), handshakeMsg, serverNonce, serverKey));
+            if (!ecdsa.VerifyHash(transcript, signature))
+            {
+                throw new InvalidOperationException("Server identity does not match the pinned fingerprint: invalid handshake signature");
+            }
+        }
+
+        byte[] shared;
+        using (var peer = ECDiffieHellman.Create(PublicKey(serverKey)))
+        {
+            shared = ecdh.DeriveRawSecretAgreement(peer.PublicKey);
+        }
+        byte[] handshakeKey;
+        using (var hmac = new HMACSHA256(key))
+        {
+            handshakeKey = hmac.ComputeHash(Concat(Encoding.UTF8.GetBytes(IdentityContext), shared));
+        }
+
+        return new EncryptedStream(stream, DeriveSessionKey(handshakeKey, serverNonce, clientNonce));
+    }
+
+    /// <summary>
+    /// Parameters of an uncompressed P-256 public key
+    /// </summary>
+    private static ECParameters PublicKey(byte[] point)
+    {
+        if (point.Length != PointSize || point[0] != 0x04)
+        {
+            throw new InvalidOperationException("Invalid P-256 public key from server");
+        }
+        return new ECParameters
+        {
+            Curve = ECCurve.NamedCurves.nistP256,
+            Q = new ECPoint { X = point[1..33], Y = point[33..] },
+        };
+    }
+
+    private static byte[] Concat(params byte[][] parts)
+    {
+        using var ms = new MemoryStream();
+        foreach (var part in parts)
+        {
+            ms.Write(part, 0, part.Length);
+        }
+        return ms.ToArray();
+    }
+
+    /// <summary>
+    /// Perform a resumed handshake, presenting a session ticket instead of the password proof.
+    /// The session key derives from the ticket secret and fresh nonces.
+    /// </summary>
+    public static async Task<Stream> PerformResumeHandshakeAsync(
+        Stream stream,
+        ResumeTicket ticket,
+        CancellationToken cancellationToken = default)
+    {
+        if (ticket.Ticket.Length != TicketSize)
+        {
+            throw new ArgumentException($"Resume ticket must be {TicketSize} bytes", nameof(ticket));
+        }
+
+        var clientNonce = RandomNumberGenerator.GetBytes(NonceSize);
+        var contextBytes = Encoding.UTF8.GetBytes(ResumeContext);
+
+        byte[] proof;
+        using (var hmac = new HMACSHA256(ticket.Secret))
+        {
+            var proofData = new byte[contextBytes.Length + clientNonce.Length + ticket.Ticket.Length];
+            Buffer.BlockCopy(contextBytes, 0, proofData, 0, contextBytes.Length);
+            Buffer.BlockCopy(clientNonce, 0, proofData, contextBytes.Length, clientNonce.Length);
+            Buffer.BlockCopy(ticket.Ticket, 0, proofData, contextBytes.Length + clientNonce.Length, ticket.Ticket.Length);
+            proof = hmac.ComputeHash(proofData);
+        }
+
+        var magicBytes = Encoding.UTF8.GetBytes(ResumeMagic);
+        var msg = new byte[magicBytes.Length + clientNonce.Length + ticket.Ticket.Length + proof.Length];
+        var offset = 0;
+        Buffer.BlockCopy(magicBytes, 0, msg, offset, magicBytes.Length);
+        offset += magicBytes.Length;
+        Buffer.BlockCopy(clientNonce, 0, msg, offset, clientNonce.Length);
+        offset += clientNonce.Length;
+        Buffer.BlockCopy(ticket.Ticket, 0, msg, offset, ticket.Ticket.Length);
+        offset += ticket.Ticket.Length;
+        Buffer.BlockCopy(proof, 0, msg, offset, proof.Length);
+
+        await stream.WriteAsync(msg, 0, msg.Length, cancellationToken);
+
+        var serverNonce = await ReadServerNonceAsync(stream, cancellationToken);
+
+        return new EncryptedStream(stream, DeriveSessionKey(ticket.Secret, serverNonce, clientNonce));
+    }
+
+    /// <summary>
+    /// Read the server nonce, or throw the error the server refused the handshake with
+    /// </summary>
+    private static async Task<byte[]> ReadServerNonceAsync(Stream stream, CancellationToken cancellationToken)
+    {
         var response = new byte[3 + NonceSize];
         await ReadExactlyAsync(stream, response, cancellationToken);
         
@@ -145,10 +304,7 @@ internal static class ViiperAuth
         
         var serverNonce = new byte[NonceSize];
         Buffer.BlockCopy(response, 3, serverNonce, 0, NonceSize);
-        
-        var sessionKey = DeriveSessionKey(key, serverNonce, clientNonce);
-        
-        return new EncryptedStream(stream, sessionKey);
+        return serverNonce;
     }
 
     /// <summary>
@@ -176,6 +332,11 @@ internal static class ViiperAuth
     }
 }
 
+/// <summary>
+/// A session resumption ticket and the secret it grants, as issued by the auth/ticket route
+/// </summary>
+internal sealed record ResumeTicket(byte[] Ticket, byte[] Secret, DateTime Expires);
+
 /// <summary>
 /// Encrypted stream wrapper using ChaCha20-Poly1305
 /// Requires .NET 5+ for ChaCha20Poly1305 support
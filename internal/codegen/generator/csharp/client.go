@@ -12,7 +12,8 @@ import (
 	"github.com/Alia5/VIIPER/internal/codegen/scanner"
 )
 
-const clientTemplate = `{{writeFileHeader}}using System.Net.Sockets;
+const clientTemplate = `{{writeFileHeader}}using System.Buffers.Binary;
+using System.Net.Sockets;
 using System.Text;
 using System.Text.Json;
 using Viiper.Client.Types;
@@ -27,7 +28,16 @@ public class ViiperClient : IDisposable
     private readonly string _host;
     private readonly int _port;
     private readonly string _password;
+    private readonly string _serverFingerprint;
+    private readonly int _protocolVersion;
     private bool _disposed;
+    private readonly SemaphoreSlim _featuresLock = new(1, 1);
+    private HashSet<string>? _features;
+    private static readonly TimeSpan TicketMargin = TimeSpan.FromSeconds(30);
+    private ResumeTicket? _ticket;
+    private int _ticketFetching;
+    private bool _noResume;
+    private static readonly byte[] ProtocolV2Magic = Encoding.ASCII.GetBytes("eVI2\0");
 
     /// <summary>
     /// Creates a new VIIPER client instance
@@ -35,11 +45,50 @@ public class ViiperClient : IDisposable
     /// <param name="host">VIIPER server hostname or IP address</param>
     /// <param name="port">VIIPER API server port (default: 3242)</param>
     /// <param name="password">Authentication password (default: "" = no auth). Empty string explicitly means no authentication.</param>
-    public ViiperClient(string host, int port = 3242, string password = "")
+    /// <param name="serverFingerprint">Pins the server identity, "sha256:&lt;hex&gt;" as the server logs it at startup (default: "" = not pinned).
+    /// The password handshake then fails unless the server proves that identity. Requires a password.</param>
+    /// <param name="protocolVersion">Request framing (default: 1). 2 prefixes requests with "eVI2\0" and frames requests and
+    /// responses with a u32 big-endian length instead of the null and newline terminators, so payloads may contain either.</param>
+    public ViiperClient(string host, int port = 3242, string password = "", string serverFingerprint = "", int protocolVersion = 1)
     {
         _host = host ?? throw new ArgumentNullException(nameof(host));
         _port = port;
         _password = password ?? "";
+        _serverFingerprint = serverFingerprint ?? "";
+        _protocolVersion = protocolVersion;
+        if (_serverFingerprint.Length > 0 && _password.Length == 0)
+        {
+            throw new ArgumentException("A server fingerprint requires a password", nameof(serverFingerprint));
+        }
+    }
+
+    /// <summary>
+    /// Reports whether the server implements an optional protocol feature, see <see cref="Features"/>.
+    /// The feature list is fetched once; servers without the features route support none.
+    /// </summary>
+    public async Task<bool> SupportsAsync(string feature, CancellationToken cancellationToken = default)
+    {
+        await _featuresLock.WaitAsync(cancellationToken);
+        try
+        {
+            if (_features == null)
+            {
+                try
+                {
+                    var resp = await FeaturesAsync(cancellationToken);
+                    _features = resp.Features.Select(f => f.Name).ToHashSet();
+                }
+                catch (InvalidOperationException e) when (e.Message.Contains("\"status\":404"))
+                {
+                    _features = new HashSet<string>();
+                }
+            }
+            return _features.Contains(feature);
+        }
+        finally
+        {
+            _featuresLock.Release();
+        }
     }
 {{range .Routes}}{{if eq .Method "Register"}}
     /// <summary>
@@ -50,44 +99,29 @@ public class ViiperClient : IDisposable
     {
         var path = "{{.Path}}"{{range $key, $value := .PathParams}}.Replace("{{lb}}{{$key}}{{rb}}", {{toCamelCase $key}}.ToString()){{end}};
         {{/* Build payload based on classification */}}
-		{{if eq .Payload.Kind "none"}}string? payload = null;{{else if eq .Payload.Kind "json"}}string? payload = JsonSerializer.Serialize({{payloadParamNameCS .}});{{else if eq .Payload.Kind "numeric"}}{{if .Payload.Required}}string? payload = {{payloadParamNameCS .}}.ToString();{{else}}string? payload = {{payloadParamNameCS .}}?.ToString();{{end}}{{else if eq .Payload.Kind "string"}}string? payload = {{payloadParamNameCS .}};{{end}}
-        {{if .ResponseDTO}}return await SendRequestAsync<{{.ResponseDTO}}>(path, payload, cancellationToken);{{else}}await SendRequestAsync<object>(path, payload, cancellationToken);
+		{{if eq .Payload.Kind "none"}}string? body = null;{{else if eq .Payload.Kind "json"}}string? body = JsonSerializer.Serialize({{payloadParamNameCS .}});{{else if eq .Payload.Kind "numeric"}}{{if .Payload.Required}}string? body = {{payloadParamNameCS .}}.ToString();{{else}}string? body = {{payloadParamNameCS .}}?.ToString();{{end}}{{else if eq .Payload.Kind "string"}}string? body = {{payloadParamNameCS .}};{{end}}
+        {{if .ResponseDTO}}return await SendRequestAsync<{{.ResponseDTO}}>(path, body, cancellationToken);{{else}}await SendRequestAsync<object>(path, body, cancellationToken);
         return true;{{end}}
     }
 {{end}}{{end}}
     private async Task<T> SendRequestAsync<T>(string path, string? payload, CancellationToken cancellationToken)
     {
-        using var client = new TcpClient();
-        await client.ConnectAsync(_host, _port, cancellationToken);
-        client.NoDelay = true;
-        
-        Stream stream = client.GetStream();
-        
-        if (!string.IsNullOrEmpty(_password))
-        {
-            stream = await ViiperAuth.PerformHandshakeAsync(stream, _password, cancellationToken);
-        }
+        var (client, stream) = await OpenAsync(cancellationToken);
+        using var _ = client;
         
         string commandLine = path.ToLowerInvariant();
         if (!string.IsNullOrEmpty(payload))
         {
             commandLine += " " + payload;
         }
-		commandLine += "\0";
         
-        var requestBytes = Encoding.UTF8.GetBytes(commandLine);
+        var requestBytes = FrameRequest(commandLine);
         await stream.WriteAsync(requestBytes, cancellationToken);
         
-        var responseBuilder = new StringBuilder();
-        var buffer = new byte[128];
-        int bytesRead;
+        using var responseBytes = new MemoryStream();
+        await stream.CopyToAsync(responseBytes, cancellationToken);
         
-        while ((bytesRead = await stream.ReadAsync(buffer, cancellationToken)) > 0)
-        {
-            responseBuilder.Append(Encoding.UTF8.GetString(buffer, 0, bytesRead));
-        }
-        
-		var responseJson = responseBuilder.ToString().TrimEnd('\n');
+		var responseJson = ReadResponse(responseBytes.ToArray());
 		// Typed error detection (RFC 7807 style): check for status field prefix
 		if (responseJson.StartsWith("{\"status\":"))
 		{
@@ -96,9 +130,120 @@ public class ViiperClient : IDisposable
 		var response = JsonSerializer.Deserialize<T>(responseJson)
 			?? throw new InvalidOperationException("Failed to deserialize response");
         
+        FetchTicket();
         return response;
     }
 
+    /// <summary>
+    /// Connects and, with a password, authenticates. A cached session ticket skips the password
+    /// handshake; if the server refuses it, e.g. after a restart or a password change, the full
+    /// handshake runs on a new connection.
+    /// </summary>
+    private async Task<(TcpClient Client, Stream Stream)> OpenAsync(CancellationToken cancellationToken)
+    {
+        var ticket = _ticket;
+        if (!string.IsNullOrEmpty(_password) && ticket != null && ticket.Expires - DateTime.UtcNow > TicketMargin)
+        {
+            var resumeClient = await DialAsync(cancellationToken);
+            try
+            {
+                var resumed = await ViiperAuth.PerformResumeHandshakeAsync(resumeClient.GetStream(), ticket, cancellationToken);
+                return (resumeClient, resumed);
+            }
+            catch (Exception e) when (e is InvalidOperationException or IOException)
+            {
+                resumeClient.Dispose();
+                Interlocked.CompareExchange(ref _ticket, null, ticket);
+            }
+        }
+
+        var client = await DialAsync(cancellationToken);
+        try
+        {
+            Stream stream = client.GetStream();
+            if (!string.IsNullOrEmpty(_serverFingerprint))
+            {
+                stream = await ViiperAuth.PerformSignedHandshakeAsync(stream, _password, _serverFingerprint, cancellationToken);
+            }
+            else if (!string.IsNullOrEmpty(_password))
+            {
+                stream = await ViiperAuth.PerformHandshakeAsync(stream, _password, cancellationToken);
+            }
+            return (client, stream);
+        }
+        catch
+        {
+            client.Dispose();
+            throw;
+        }
+    }
+
+    private async Task<TcpClient> DialAsync(CancellationToken cancellationToken)
+    {
+        var client = new TcpClient();
+        try
+        {
+            await client.ConnectAsync(_host, _port, cancellationToken);
+        }
+        catch
+        {
+            client.Dispose();
+            throw;
+        }
+        client.NoDelay = true;
+        return client;
+    }
+
+    /// <summary>
+    /// Fetches a session ticket in the background after a full handshake.
+    /// Servers without session resumption are not asked again.
+    /// </summary>
+    private void FetchTicket()
+    {
+        if (string.IsNullOrEmpty(_password) || _noResume)
+        {
+            return;
+        }
+        var ticket = _ticket;
+        if (ticket != null && ticket.Expires - DateTime.UtcNow > TicketMargin)
+        {
+            return;
+        }
+        if (Interlocked.Exchange(ref _ticketFetching, 1) == 1)
+        {
+            return;
+        }
+        _ = Task.Run(async () =>
+        {
+            try
+            {
+                var resp = await AuthTicketAsync();
+                var lifetime = TimeSpan.FromSeconds(resp.ExpiresIn);
+                if (lifetime < 2 * TicketMargin)
+                {
+                    _noResume = true;
+                    return;
+                }
+                _ticket = new ResumeTicket(
+                    Convert.FromBase64String(resp.Ticket),
+                    Convert.FromBase64String(resp.Secret),
+                    DateTime.UtcNow + lifetime);
+            }
+            catch (InvalidOperationException e) when (e.Message.Contains("\"status\":404"))
+            {
+                _noResume = true;
+            }
+            catch (Exception)
+            {
+                // Asked again after the next full handshake.
+            }
+            finally
+            {
+                Interlocked.Exchange(ref _ticketFetching, 0);
+            }
+        });
+    }
+
     /// <summary>
     /// Creates a device stream connection for sending input and receiving output
     /// </summary>
@@ -108,23 +253,47 @@ public class ViiperClient : IDisposable
     /// <returns>ViiperDevice stream wrapper</returns>
 	public async Task<ViiperDevice> ConnectDeviceAsync(uint busId, string devId, CancellationToken cancellationToken = default)
 	{
-		var client = new TcpClient();
-		await client.ConnectAsync(_host, _port, cancellationToken);
-		client.NoDelay = true;
-		Stream stream = client.GetStream();
+		var (client, stream) = await OpenAsync(cancellationToken);
 		
-		if (!string.IsNullOrEmpty(_password))
-		{
-			stream = await ViiperAuth.PerformHandshakeAsync(stream, _password, cancellationToken);
-		}
-		
-		// Streaming handshake uses null terminator (same framing as management).
-		var streamPath = $"bus/{{lb}}busId{{rb}}/{{lb}}devId{{rb}}\0";
-		var handshake = Encoding.UTF8.GetBytes(streamPath);
+		// Streaming handshake uses the same framing as management requests.
+		var handshake = FrameRequest($"bus/{{lb}}busId{{rb}}/{{lb}}devId{{rb}}");
 		await stream.WriteAsync(handshake, cancellationToken);
 		return new ViiperDevice(client, stream);
 	}
 
+    /// <summary>
+    /// Encodes a request line in the configured framing.
+    /// </summary>
+    private byte[] FrameRequest(string line)
+    {
+        if (_protocolVersion < 2)
+        {
+            return Encoding.UTF8.GetBytes(line + "\0");
+        }
+        var body = Encoding.UTF8.GetBytes(line);
+        var framed = new byte[ProtocolV2Magic.Length + 4 + body.Length];
+        ProtocolV2Magic.CopyTo(framed, 0);
+        BinaryPrimitives.WriteUInt32BigEndian(framed.AsSpan(ProtocolV2Magic.Length), (uint)body.Length);
+        body.CopyTo(framed, ProtocolV2Magic.Length + 4);
+        return framed;
+    }
+
+    /// <summary>
+    /// Decodes a complete response in the configured framing.
+    /// </summary>
+    private string ReadResponse(byte[] data)
+    {
+        if (_protocolVersion < 2)
+        {
+            return Encoding.UTF8.GetString(data).TrimEnd('\n');
+        }
+        if (data.Length < 4 || data.Length - 4 < BinaryPrimitives.ReadUInt32BigEndian(data))
+        {
+            throw new InvalidOperationException("Truncated VIIPER response frame");
+        }
+        return Encoding.UTF8.GetString(data, 4, (int)BinaryPrimitives.ReadUInt32BigEndian(data));
+    }
+
     public void Dispose()
     {
         if (_disposed) return;
@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -63,11 +64,15 @@ func generateConstants(logger *slog.Logger, deviceDir string, deviceName string,
 		Maps:       maps,
 	}
 
+	// Enums typed wire fields refer to take their name over any group
+	// ExtractPrefix would have made of the same constants.
+	wireEnums := wireEnumGroups(md, deviceName)
 	for _, eg := range enumGroups {
-		if shouldGenerateEnum(eg) {
+		if shouldGenerateEnum(eg) && !slices.ContainsFunc(wireEnums, func(w enumGroup) bool { return w.Name == eg.Name }) {
 			data.EnumGroups = append(data.EnumGroups, eg)
 		}
 	}
+	data.EnumGroups = append(data.EnumGroups, wireEnums...)
 
 	f, err := os.Create(outputPath)
 	if err != nil {
@@ -147,6 +152,23 @@ func groupConstantsByPrefix(constants []scanner.ConstantInfo) []enumGroup {
 	return result
 }
 
+// wireEnumGroups returns the enums of deviceName's enum wire fields.
+func wireEnumGroups(md *meta.Metadata, deviceName string) []enumGroup {
+	var groups []enumGroup
+	for _, we := range common.WireEnums(md, deviceName) {
+		eg := enumGroup{Name: we.Name, Type: mapGoTypeToCSharp(we.Base)}
+		for _, c := range we.Members {
+			eg.Constants = append(eg.Constants, constantInfo{
+				Name:  c.Name,
+				Value: formatConstValue(c.Value, c.Type),
+				Type:  eg.Type,
+			})
+		}
+		groups = append(groups, eg)
+	}
+	return groups
+}
+
 func shouldGenerateEnum(eg enumGroup) bool {
 	return len(eg.Constants) >= 3
 }
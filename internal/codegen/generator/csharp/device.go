@@ -90,6 +90,40 @@ public sealed class ViiperDevice : IAsyncDisposable, IDisposable
 		await _stream.WriteAsync(data, 0, data.Length, cancellationToken);
 	}
 
+	/// <summary>
+	/// Fill buffer with the next message, over as many reads (and encrypted
+	/// packets) as it spans. Returns false if the stream ended between messages.
+	/// Cancelling disposes the device: the stream would be left mid-message.
+	/// </summary>
+	internal async Task<bool> ReadExactAsync(byte[] buffer, CancellationToken cancellationToken)
+	{
+		ThrowIfDisposed();
+		if (_onOutput != null)
+			throw new InvalidOperationException("OnOutput is reading the device stream");
+
+		using var registration = cancellationToken.Register(Dispose);
+		int read = 0;
+		try
+		{
+			while (read < buffer.Length)
+			{
+				int n = await _stream.ReadAsync(buffer, read, buffer.Length - read, cancellationToken).ConfigureAwait(false);
+				if (n == 0)
+				{
+					if (read == 0) return false;
+					throw new EndOfStreamException("device stream ended mid-message");
+				}
+				read += n;
+			}
+		}
+		catch (Exception ex) when (ex is not OperationCanceledException && cancellationToken.IsCancellationRequested)
+		{
+			// Disposing under a pending read fails it with an I/O error.
+			throw new OperationCanceledException(cancellationToken);
+		}
+		return true;
+	}
+
 	private async Task ReadLoopAsync()
 	{
 		try
@@ -0,0 +1,162 @@
+package csharp
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/Alia5/VIIPER/internal/codegen/common"
+	"github.com/Alia5/VIIPER/internal/codegen/meta"
+)
+
+const deviceStreamBaseTemplate = `{{writeFileHeader}}using System.IO;
+using System.Runtime.CompilerServices;
+
+namespace Viiper.Client;
+
+/// <summary>
+/// Typed stream of a device that takes <typeparamref name="TInput"/> states.
+/// The generated per-device streams (e.g. Xbox360Stream) derive from it.
+/// </summary>
+public class DeviceStream<TInput> : IAsyncDisposable, IDisposable where TInput : IBinarySerializable
+{
+	protected DeviceStream(ViiperDevice device)
+	{
+		Device = device;
+	}
+
+	/// <summary>
+	/// The untyped stream underneath.
+	/// </summary>
+	public ViiperDevice Device { get; }
+
+	/// <summary>
+	/// Send an input state to the device.
+	/// </summary>
+	public Task WriteAsync(TInput input, CancellationToken cancellationToken = default) => Device.SendAsync(input, cancellationToken);
+
+	public void Dispose() => Device.Dispose();
+
+	public ValueTask DisposeAsync() => Device.DisposeAsync();
+}
+
+/// <summary>
+/// Typed stream of a device that also sends fixed-size <typeparamref name="TOutput"/> feedback.
+/// </summary>
+public class DeviceStream<TInput, TOutput> : DeviceStream<TInput> where TInput : IBinarySerializable
+{
+	private readonly int _outputSize;
+	private readonly Func<BinaryReader, TOutput> _read;
+
+	protected DeviceStream(ViiperDevice device, int outputSize, Func<BinaryReader, TOutput> read) : base(device)
+	{
+		_outputSize = outputSize;
+		_read = read;
+	}
+
+	/// <summary>
+	/// Reads the device's outputs until the server ends the stream.
+	/// Cancelling closes the stream, as a read cut short would leave it mid-message.
+	/// Cannot be used while <see cref="ViiperDevice.OnOutput"/> is set.
+	/// </summary>
+	public async IAsyncEnumerable<TOutput> ReadOutputsAsync([EnumeratorCancellation] CancellationToken cancellationToken = default)
+	{
+		var buf = new byte[_outputSize];
+		while (await Device.ReadExactAsync(buf, cancellationToken).ConfigureAwait(false))
+		{
+			using var reader = new BinaryReader(new MemoryStream(buf, writable: false));
+			yield return _read(reader);
+		}
+	}
+}
+`
+
+const deviceStreamTemplate = `{{writeFileHeader}}namespace Viiper.Client.Devices.{{.Device}};
+
+/// <summary>
+/// Typed stream of a {{.Name}} device.
+/// </summary>
+public sealed class {{.Device}}Stream : {{if .HasOutput}}DeviceStream<{{.Device}}Input, {{.Device}}Output>{{else}}DeviceStream<{{.Device}}Input>{{end}}
+{
+	public {{.Device}}Stream(ViiperDevice device) : base(device{{if .HasOutput}}, {{.Device}}.OutputSize, {{.Device}}Output.Read{{end}})
+	{
+	}
+
+	/// <summary>
+	/// Connects to the stream of the {{.Name}} device devId on busId.
+	/// </summary>
+	public static async Task<{{.Device}}Stream> ConnectAsync(ViiperClient client, uint busId, string devId, CancellationToken cancellationToken = default)
+	{
+		var device = await client.ConnectDeviceAsync(busId, devId, cancellationToken).ConfigureAwait(false);
+		return new {{.Device}}Stream(device);
+	}
+}
+`
+
+// RenderDeviceStream returns Devices/<Device>/<Device>Stream.cs, the typed
+// stream of deviceName, or nil if the device has no input wire tag.
+func RenderDeviceStream(md *meta.Metadata, deviceName string) ([]byte, error) {
+	if md.WireTags == nil || md.WireTags.GetTag(deviceName, "c2s") == nil {
+		return nil, nil
+	}
+	data := struct {
+		Name      string
+		Device    string
+		HasOutput bool
+	}{
+		Name:   deviceName,
+		Device: toPascalCase(deviceName),
+	}
+	// Variable-size feedback has no OutputSize to frame by; such devices
+	// only get the input half.
+	if out := md.WireTags.GetTag(deviceName, "s2c"); out != nil {
+		data.HasOutput = common.CalculateOutputSize(out) > 0
+	}
+
+	tmpl, err := template.New("deviceStreamCS").Funcs(template.FuncMap{
+		"writeFileHeader": writeFileHeader,
+	}).Parse(deviceStreamTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("parse template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("execute template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func generateDeviceStreamBase(logger *slog.Logger, projectDir string) error {
+	tmpl, err := template.New("deviceStreamBaseCS").Funcs(template.FuncMap{
+		"writeFileHeader": writeFileHeader,
+	}).Parse(deviceStreamBaseTemplate)
+	if err != nil {
+		return fmt.Errorf("parse template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return fmt.Errorf("execute template: %w", err)
+	}
+	outputFile := filepath.Join(projectDir, "DeviceStream.cs")
+	if err := os.WriteFile(outputFile, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", outputFile, err)
+	}
+	logger.Info("Generated DeviceStream.cs", "file", outputFile)
+	return nil
+}
+
+func generateDeviceStream(logger *slog.Logger, deviceDir, deviceName string, md *meta.Metadata) error {
+	src, err := RenderDeviceStream(md, deviceName)
+	if err != nil || src == nil {
+		return err
+	}
+	outputFile := filepath.Join(deviceDir, toPascalCase(deviceName)+"Stream.cs")
+	if err := os.WriteFile(outputFile, src, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", outputFile, err)
+	}
+	logger.Debug("Generated device stream", "device", deviceName, "path", outputFile)
+	return nil
+}
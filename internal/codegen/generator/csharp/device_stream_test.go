@@ -0,0 +1,73 @@
+package csharp_test
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Alia5/VIIPER/internal/codegen/generator"
+	"github.com/Alia5/VIIPER/internal/codegen/generator/csharp"
+	"github.com/Alia5/VIIPER/internal/codegen/meta"
+	"github.com/Alia5/VIIPER/internal/codegen/scanner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func deviceMetadata(t *testing.T, names ...string) *meta.Metadata {
+	t.Helper()
+	md := &meta.Metadata{DevicePackages: map[string]*scanner.DeviceConstants{}}
+	var paths []string
+	for _, name := range names {
+		path := filepath.Join("..", "..", "..", "..", "device", name)
+		consts, err := scanner.ScanDeviceConstants(path)
+		require.NoError(t, err)
+		md.DevicePackages[name] = consts
+		paths = append(paths, path)
+	}
+	tags, err := scanner.ScanWireTags(paths)
+	require.NoError(t, err)
+	md.WireTags = tags
+	return md
+}
+
+func TestRenderDeviceStreamGolden(t *testing.T) {
+	got, err := csharp.RenderDeviceStream(deviceMetadata(t, "xbox360"), "xbox360")
+	require.NoError(t, err)
+
+	golden := filepath.Join("testdata", "Xbox360Stream.cs.golden")
+	if *update {
+		require.NoError(t, os.WriteFile(golden, got, 0o644))
+	}
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got), "%s is stale; rerun this test with -update", golden)
+}
+
+func TestRenderDeviceStreamInputOnly(t *testing.T) {
+	got, err := csharp.RenderDeviceStream(deviceMetadata(t, "mouse"), "mouse")
+	require.NoError(t, err)
+	assert.Contains(t, string(got), "public sealed class MouseStream : DeviceStream<MouseInput>\n")
+	assert.Contains(t, string(got), ": base(device)\n")
+}
+
+func TestGeneratedProjectBuilds(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a .NET project")
+	}
+	dotnet, err := exec.LookPath("dotnet")
+	if err != nil {
+		t.Skip("no dotnet SDK")
+	}
+	t.Chdir(filepath.Join("..", "..", "..", ".."))
+	dir := t.TempDir()
+	md, err := generator.New(dir, slog.New(slog.DiscardHandler)).ScanAll()
+	require.NoError(t, err)
+	require.NoError(t, csharp.Generate(slog.New(slog.DiscardHandler), dir, md))
+
+	cmd := exec.Command(dotnet, "build", "-nologo", "-v", "q", filepath.Join(dir, "Viiper.Client", "Viiper.Client.csproj"))
+	cmd.Env = append(os.Environ(), "DOTNET_CLI_TELEMETRY_OPTOUT=1", "DOTNET_NOLOGO=1")
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"text/template"
 
+	"github.com/Alia5/VIIPER/internal/codegen/common"
 	"github.com/Alia5/VIIPER/internal/codegen/meta"
 	"github.com/Alia5/VIIPER/internal/codegen/scanner"
 )
@@ -68,7 +69,7 @@ func generateWireClass(outputPath, device, className string, tag *scanner.WireTa
 	}
 
 	for _, field := range tag.Fields {
-		wf := wireField{Name: toPascalCase(field.Name)}
+		wf := wireField{Name: toPascalCase(field.Name), Doc: common.WireRangeDoc(field)}
 		if idx := strings.Index(field.Type, "*"); idx >= 0 {
 			wf.IsArray = true
 			baseType := field.Type[:idx]
@@ -82,6 +83,11 @@ func generateWireClass(outputPath, device, className string, tag *scanner.WireTa
 		} else {
 			wf.CSType = mapGoTypeToCSharp(field.Type)
 		}
+		wf.WireCSType = wf.CSType
+		if field.Enum != "" {
+			wf.Enum = true
+			wf.CSType = field.Enum
+		}
 
 		data.Fields = append(data.Fields, wf)
 	}
@@ -102,9 +108,12 @@ func generateWireClass(outputPath, device, className string, tag *scanner.WireTa
 type wireField struct {
 	Name           string
 	CSType         string
+	WireCSType     string // CSType as written, the base type of enums
+	Enum           bool
 	IsArray        bool
 	CountFieldName string
 	FixedLen       int
+	Doc            string
 }
 
 func mapGoTypeToCSharp(goType string) string {
@@ -125,6 +134,8 @@ func mapGoTypeToCSharp(goType string) string {
 		return "int"
 	case "i64":
 		return "long"
+	case "bool":
+		return "bool"
 	default:
 		return "byte"
 	}
@@ -148,6 +159,8 @@ func getCSharpReaderMethod(csType string) string {
 		return "UInt64"
 	case "long":
 		return "Int64"
+	case "bool":
+		return "Boolean"
 	default:
 		return "Byte"
 	}
@@ -163,7 +176,8 @@ namespace Viiper.Client.Devices.{{.Device}};
 /// </summary>
 public class {{.Device}}{{.ClassName}} : IBinarySerializable
 {
-{{range .Fields}}{{if and .IsArray (gt .FixedLen 0)}}    public {{.CSType}}[] {{.Name}} { get; set; } = new {{.CSType}}[{{.FixedLen}}];
+{{range .Fields}}{{if .Doc}}    /// <summary>{{.Doc}}</summary>
+{{end}}{{if and .IsArray (gt .FixedLen 0)}}    public {{.CSType}}[] {{.Name}} { get; set; } = new {{.CSType}}[{{.FixedLen}}];
 {{else}}    public required {{.CSType}}{{if .IsArray}}[]{{end}} {{.Name}} { get; set; }
 {{end}}{{end}}
     public void Write(BinaryWriter writer)
@@ -176,7 +190,7 @@ public class {{.Device}}{{.ClassName}} : IBinarySerializable
 		{
 			writer.Write({{.Name}}[i]);
 		}
-{{end}}{{else}}        writer.Write({{.Name}});
+{{end}}{{else}}        writer.Write({{if .Enum}}({{.WireCSType}}){{end}}{{.Name}});
 {{end}}{{end}}    }
 
     /// <summary>
@@ -194,7 +208,7 @@ public class {{.Device}}{{.ClassName}} : IBinarySerializable
 		{
 		    {{toCamel .Name}}[i] = reader.Read{{readerMethod .CSType}}();
 		}
-	{{end}}{{else}}        var {{toCamel .Name}} = reader.Read{{readerMethod .CSType}}();
+	{{end}}{{else}}        var {{toCamel .Name}} = {{if .Enum}}({{.CSType}}){{end}}reader.Read{{readerMethod .WireCSType}}();
 	{{end}}{{end}}
 
 		return new {{.Device}}{{.ClassName}}
@@ -0,0 +1,89 @@
+package csharp_test
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Alia5/VIIPER/internal/codegen/generator"
+	"github.com/Alia5/VIIPER/internal/codegen/generator/csharp"
+	"github.com/Alia5/VIIPER/internal/codegen/meta"
+	"github.com/Alia5/VIIPER/internal/codegen/scanner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gadgetMetadata() *meta.Metadata {
+	return &meta.Metadata{
+		DevicePackages: map[string]*scanner.DeviceConstants{
+			"gadget": {DeviceType: "gadget", Constants: []scanner.ConstantInfo{
+				{Name: "ModeIdle", Value: 0, Type: "uint8"},
+				{Name: "ModeSpin", Value: 1, Type: "uint8"},
+				{Name: "ModeBrake", Value: 2, Type: "uint8"},
+			}},
+		},
+		WireTags: &scanner.WireTags{Tags: map[string]map[string]*scanner.WireTag{
+			"gadget": {
+				"c2s": {Device: "gadget", Direction: "c2s", Fields: []scanner.WireField{
+					{Name: "mode", Type: "u8", Enum: "Mode"},
+					{Name: "armed", Type: "bool"},
+				}},
+				"s2c": {Device: "gadget", Direction: "s2c", Fields: []scanner.WireField{
+					{Name: "mode", Type: "u8", Enum: "Mode"},
+					{Name: "fault", Type: "bool"},
+				}},
+			},
+		}},
+	}
+}
+
+func TestWireEnumAndBoolFields(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, csharp.Generate(slog.New(slog.DiscardHandler), dir, gadgetMetadata()))
+	deviceDir := filepath.Join(dir, "Viiper.Client", "Devices", "Gadget")
+
+	constants, err := os.ReadFile(filepath.Join(deviceDir, "GadgetConstants.cs"))
+	require.NoError(t, err)
+	assert.Contains(t, string(constants), "public enum Mode : byte\n{\n    Idle = 0x0,\n    Spin = 0x1,\n    Brake = 0x2,\n}")
+
+	input, err := os.ReadFile(filepath.Join(deviceDir, "GadgetInput.cs"))
+	require.NoError(t, err)
+	for _, want := range []string{
+		"public required Mode Mode { get; set; }",
+		"public required bool Armed { get; set; }",
+		"writer.Write((byte)Mode);",
+		"writer.Write(Armed);",
+	} {
+		assert.Contains(t, string(input), want)
+	}
+
+	output, err := os.ReadFile(filepath.Join(deviceDir, "GadgetOutput.cs"))
+	require.NoError(t, err)
+	assert.Contains(t, string(output), "var mode = (Mode)reader.ReadByte();")
+	assert.Contains(t, string(output), "var fault = reader.ReadBoolean();")
+}
+
+func TestWireEnumProjectBuilds(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a .NET project")
+	}
+	dotnet, err := exec.LookPath("dotnet")
+	if err != nil {
+		t.Skip("no dotnet SDK")
+	}
+	t.Chdir(filepath.Join("..", "..", "..", ".."))
+	dir := t.TempDir()
+	md, err := generator.New(dir, slog.New(slog.DiscardHandler)).ScanAll()
+	require.NoError(t, err)
+	gadget := gadgetMetadata()
+	md.DevicePackages["gadget"] = gadget.DevicePackages["gadget"]
+	md.WireTags.Tags["gadget"] = gadget.WireTags.Tags["gadget"]
+	require.NoError(t, csharp.Generate(slog.New(slog.DiscardHandler), dir, md))
+
+	cmd := exec.Command(dotnet, "build", "-nologo", "-v", "q", filepath.Join(dir, "Viiper.Client", "Viiper.Client.csproj"))
+	cmd.Env = append(os.Environ(), "DOTNET_CLI_TELEMETRY_OPTOUT=1", "DOTNET_NOLOGO=1")
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+}
@@ -0,0 +1,55 @@
+package csharp
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/Alia5/VIIPER/internal/codegen/meta"
+)
+
+const featuresTemplate = `{{writeFileHeader}}namespace Viiper.Client;
+
+/// <summary>
+/// Optional protocol features of the server, see <see cref="ViiperClient.SupportsAsync"/>
+/// </summary>
+public static class Features
+{
+{{- range .}}
+    /// <summary>Since {{.Since}}, negotiated by {{.Negotiation}}</summary>
+    public const string {{toPascalCase .Name}} = "{{.Name}}";
+{{- end}}
+}
+`
+
+// RenderFeatures returns Features.cs for the feature registry in md.
+func RenderFeatures(md *meta.Metadata) ([]byte, error) {
+	tmpl, err := template.New("featuresCS").Funcs(template.FuncMap{
+		"writeFileHeader": writeFileHeader,
+		"toPascalCase":    toPascalCase,
+	}).Parse(featuresTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("parse template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, md.Features); err != nil {
+		return nil, fmt.Errorf("execute template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func generateFeatures(logger *slog.Logger, projectDir string, md *meta.Metadata) error {
+	src, err := RenderFeatures(md)
+	if err != nil {
+		return err
+	}
+	outputFile := filepath.Join(projectDir, "Features.cs")
+	if err := os.WriteFile(outputFile, src, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", outputFile, err)
+	}
+	logger.Info("Generated Features.cs", "file", outputFile)
+	return nil
+}
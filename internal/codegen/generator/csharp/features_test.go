@@ -0,0 +1,31 @@
+package csharp_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Alia5/VIIPER/internal/codegen/generator/csharp"
+	"github.com/Alia5/VIIPER/internal/codegen/meta"
+	"github.com/Alia5/VIIPER/internal/codegen/scanner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func TestRenderFeaturesGolden(t *testing.T) {
+	features, err := scanner.ScanFeatures(filepath.Join("..", "..", "..", "..", "apitypes"))
+	require.NoError(t, err)
+	got, err := csharp.RenderFeatures(&meta.Metadata{Features: features})
+	require.NoError(t, err)
+
+	golden := filepath.Join("testdata", "Features.cs.golden")
+	if *update {
+		require.NoError(t, os.WriteFile(golden, got, 0o644))
+	}
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got), "%s is stale; rerun this test with -update", golden)
+}
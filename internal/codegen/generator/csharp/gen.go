@@ -35,6 +35,10 @@ func Generate(logger *slog.Logger, outputDir string, md *meta.Metadata) error {
 		return err
 	}
 
+	if err := generateFeatures(logger, projectDir, md); err != nil {
+		return err
+	}
+
 	if err := generateClient(logger, projectDir, md); err != nil {
 		return err
 	}
@@ -44,6 +48,9 @@ func Generate(logger *slog.Logger, outputDir string, md *meta.Metadata) error {
 	if err := generateDevice(logger, projectDir, md); err != nil {
 		return err
 	}
+	if err := generateDeviceStreamBase(logger, projectDir); err != nil {
+		return err
+	}
 
 	for deviceName := range md.DevicePackages {
 		deviceDir := filepath.Join(devicesDir, toPascalCase(deviceName))
@@ -58,6 +65,10 @@ func Generate(logger *slog.Logger, outputDir string, md *meta.Metadata) error {
 		if err := generateConstants(logger, deviceDir, deviceName, md); err != nil {
 			return err
 		}
+
+		if err := generateDeviceStream(logger, deviceDir, deviceName, md); err != nil {
+			return err
+		}
 	}
 
 	if err := common.GenerateLicense(logger, outputDir); err != nil {
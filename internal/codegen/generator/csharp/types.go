@@ -70,31 +70,25 @@ func generateTypes(logger *slog.Logger, typesDir string, md *meta.Metadata) erro
 
 func fieldTypeToCSharp(field interface{}) string {
 	v := reflect.ValueOf(field)
-	typeStr := v.FieldByName("Type").String()
-	typeKind := v.FieldByName("TypeKind").String()
+	return typeExprToCSharp(v.FieldByName("Type").String())
+}
 
-	if typeKind == "map" || strings.HasPrefix(typeStr, "map[") {
-		keyType, valueType, ok := parseGoMapType(typeStr)
+// typeExprToCSharp maps a scanned Go type, which may nest slices and
+// string-keyed maps (e.g. "map[string][]Device"), to C#.
+func typeExprToCSharp(typeStr string) string {
+	typeStr = strings.TrimPrefix(typeStr, "*")
+	if strings.HasPrefix(typeStr, "[]") {
+		return typeExprToCSharp(strings.TrimPrefix(typeStr, "[]")) + "[]"
+	}
+	if strings.HasPrefix(typeStr, "map[") {
+		_, valueType, ok := parseGoMapType(typeStr)
 		if !ok {
 			return "Dictionary<string, object>"
 		}
-		_ = keyType
-		csVal := goTypeToCSharp(valueType)
 		if valueType == "any" || valueType == "interface{}" {
-			csVal = "object?"
+			return "Dictionary<string, object?>"
 		}
-		return "Dictionary<string, " + csVal + ">"
-	}
-
-	if typeKind == "slice" || strings.HasPrefix(typeStr, "[]") {
-		elemType := strings.TrimPrefix(typeStr, "[]")
-		csharpElemType := goTypeToCSharp(elemType)
-		return csharpElemType + "[]"
+		return "Dictionary<string, " + typeExprToCSharp(valueType) + ">"
 	}
-
-	if typeKind == "struct" {
-		return toPascalCase(typeStr)
-	}
-
 	return goTypeToCSharp(typeStr)
 }
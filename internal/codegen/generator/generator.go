@@ -5,9 +5,12 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/Alia5/VIIPER/internal/codegen/generator/cpp"
 	"github.com/Alia5/VIIPER/internal/codegen/generator/csharp"
+	"github.com/Alia5/VIIPER/internal/codegen/generator/golang"
+	"github.com/Alia5/VIIPER/internal/codegen/generator/protocol"
 	"github.com/Alia5/VIIPER/internal/codegen/generator/rust"
 	"github.com/Alia5/VIIPER/internal/codegen/generator/typescript"
 	"github.com/Alia5/VIIPER/internal/codegen/meta"
@@ -24,10 +27,19 @@ type LanguageGenerator func(logger *slog.Logger, outputDir string, md *meta.Meta
 var generators = map[string]LanguageGenerator{
 	"cpp":        cpp.Generate,
 	"csharp":     csharp.Generate,
+	"go":         golang.Generate,
+	"protocol":   protocol.Generate,
 	"rust":       rust.Generate,
 	"typescript": typescript.Generate,
 }
 
+// inTreeOutputs maps languages whose output lives inside the module
+// (relative to its root) rather than below the codegen output directory.
+var inTreeOutputs = map[string]string{
+	"go":       "apiclient",
+	"protocol": "internal/protocol",
+}
+
 func New(outputDir string, logger *slog.Logger) *Generator {
 	return &Generator{
 		outputDir: outputDir,
@@ -62,6 +74,13 @@ func (g *Generator) GenerateLang(lang string) error {
 	}
 
 	outputPath := filepath.Join(g.outputDir, lang)
+	if dir, ok := inTreeOutputs[lang]; ok {
+		root, err := moduleRoot()
+		if err != nil {
+			return err
+		}
+		outputPath = filepath.Join(root, dir)
+	}
 	if err := os.MkdirAll(outputPath, 0o755); err != nil {
 		return fmt.Errorf("failed to create %s output directory: %w", lang, err)
 	}
@@ -74,6 +93,37 @@ func (g *Generator) GenerateLang(lang string) error {
 	return nil
 }
 
+// moduleRoot returns the directory of the VIIPER go.mod enclosing the
+// working directory.
+func moduleRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if data, err := os.ReadFile(filepath.Join(dir, "go.mod")); err == nil && modulePath(data) == modulePathVIIPER {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("codegen must be run inside the viiper module: no go.mod of %s above the working directory", modulePathVIIPER)
+		}
+		dir = parent
+	}
+}
+
+const modulePathVIIPER = "github.com/Alia5/VIIPER"
+
+// modulePath returns the module path declared by the go.mod data.
+func modulePath(data []byte) string {
+	for line := range strings.Lines(string(data)) {
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(line), "module"); ok {
+			return strings.Trim(strings.TrimSpace(rest), `"`)
+		}
+	}
+	return ""
+}
+
 func (g *Generator) ScanAll() (*meta.Metadata, error) {
 	requiredPaths := []string{"internal/cmd", "apitypes", "device"}
 	for _, path := range requiredPaths {
@@ -105,6 +155,14 @@ func (g *Generator) ScanAll() (*meta.Metadata, error) {
 	md.DTOs = dtos
 	g.logger.Info("Found DTOs", "count", len(dtos))
 
+	g.logger.Debug("Scanning feature registry")
+	features, err := scanner.ScanFeatures("apitypes")
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan features: %w", err)
+	}
+	md.Features = features
+	g.logger.Info("Found protocol features", "count", len(features))
+
 	md.CTypeNames = make(map[string]string)
 	for _, dto := range dtos {
 		if dto.Name == "Device" {
@@ -127,6 +185,11 @@ func (g *Generator) ScanAll() (*meta.Metadata, error) {
 
 		deviceName := entry.Name()
 		devicePath := filepath.Join(deviceBaseDir, deviceName)
+		// Helper packages (e.g. device/replay) carry no wire format.
+		if tags, err := scanner.ScanWireTags([]string{devicePath}); err == nil && len(tags.Tags) == 0 {
+			g.logger.Debug("Skipping package without viiper:wire tags", "package", devicePath)
+			continue
+		}
 		devicePaths = append(devicePaths, devicePath)
 
 		g.logger.Debug("Scanning device package", "device", deviceName)
@@ -0,0 +1,26 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModuleRoot(t *testing.T) {
+	want, err := filepath.Abs(filepath.Join("..", "..", ".."))
+	require.NoError(t, err)
+
+	t.Chdir("golang")
+	root, err := moduleRoot()
+	require.NoError(t, err)
+	assert.Equal(t, want, root)
+	_, err = os.Stat(filepath.Join(root, inTreeOutputs["go"], "client.go"))
+	assert.NoError(t, err, "in-tree outputs resolve inside the module")
+
+	t.Chdir(t.TempDir())
+	_, err = moduleRoot()
+	assert.Error(t, err, "outside the module")
+}
@@ -0,0 +1,165 @@
+package golang
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/template"
+
+	"github.com/Alia5/VIIPER/internal/codegen/common"
+	"github.com/Alia5/VIIPER/internal/codegen/meta"
+)
+
+const deviceClientTemplateGo = `// Code generated by "viiper codegen --lang go". DO NOT EDIT.
+
+// Package {{.Package}} is a typed stream client for {{.Device}} devices.
+package {{.Package}}
+
+import (
+	"context"
+
+	"github.com/Alia5/VIIPER/apiclient"
+	apitypes "github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/device/{{.Device}}"
+)
+
+// DeviceType is the device type name used by the server.
+const DeviceType = "{{.Device}}"
+{{- if or .InputSize .OutputSize}}
+
+// Wire sizes in bytes.
+const (
+{{- if .InputSize}}
+	InputSize = {{.InputSize}} // {{.Device}}.{{.InputType}}
+{{- end}}
+{{- if .OutputSize}}
+	OutputSize = {{.OutputSize}} // {{.Device}}.{{.OutputType}}
+{{- end}}
+)
+{{- end}}
+
+// Stream is the stream of a {{.Device}} device.
+type Stream struct {
+	*apiclient.DeviceStream
+}
+
+// AddAndConnect adds a {{.Device}} device to busID and opens its stream,
+// see apiclient.Client.AddDeviceAndConnect.
+func AddAndConnect(ctx context.Context, c *apiclient.Client, busID uint32, o *device.CreateOptions) (*Stream, *apitypes.Device, error) {
+	s, dev, err := c.AddDeviceAndConnect(ctx, busID, DeviceType, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Stream{s}, dev, nil
+}
+
+// Open opens the stream of the {{.Device}} device devID on busID.
+func Open(ctx context.Context, c *apiclient.Client, busID uint32, devID string) (*Stream, error) {
+	s, err := c.OpenStream(ctx, busID, devID)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{s}, nil
+}
+
+// WriteState sends an input state to the device.
+func (s *Stream) WriteState(st *{{.Device}}.{{.InputType}}) error {
+	return s.WriteBinary(st)
+}
+{{- if .OutputSize}}
+
+// Outputs decodes the feedback of the device until ctx ends or the stream
+// fails, see apiclient.DeviceStream.StartReading.
+func (s *Stream) Outputs(ctx context.Context, chSize int) (<-chan *{{.Device}}.{{.OutputType}}, <-chan error) {
+	return apiclient.ReadMessages[{{.Device}}.{{.OutputType}}](ctx, s.DeviceStream, OutputSize, chSize)
+}
+{{- end}}
+`
+
+type deviceClientView struct {
+	Package    string
+	Device     string
+	InputType  string
+	InputSize  int
+	OutputType string
+	OutputSize int
+}
+
+// DeviceClientDir returns the directory, relative to the apiclient package,
+// of the generated client for a device package.
+func DeviceClientDir(name string) string { return name + "client" }
+
+// DeviceClients returns the device packages that get a typed client: those
+// with a client-to-server wire tag on a Go type.
+func DeviceClients(md *meta.Metadata) []string {
+	if md.WireTags == nil {
+		return nil
+	}
+	var names []string
+	for name := range md.DevicePackages {
+		if tag := md.WireTags.GetTag(name, "c2s"); tag != nil && tag.GoType != "" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// RenderDeviceClient returns the gofmt'ed source of the typed client for the
+// device package name.
+func RenderDeviceClient(md *meta.Metadata, name string) ([]byte, error) {
+	in := md.WireTags.GetTag(name, "c2s")
+	if in == nil || in.GoType == "" {
+		return nil, fmt.Errorf("device %s has no input wire tag", name)
+	}
+	v := deviceClientView{
+		Package:   DeviceClientDir(name),
+		Device:    name,
+		InputType: in.GoType,
+		InputSize: common.CalculateOutputSize(in),
+	}
+	// Variable-size feedback needs a hand-written decoder, see StartReading.
+	if out := md.WireTags.GetTag(name, "s2c"); out != nil && out.GoType != "" {
+		v.OutputType = out.GoType
+		v.OutputSize = common.CalculateOutputSize(out)
+	}
+
+	tmpl, err := template.New("deviceClientGo").Parse(deviceClientTemplateGo)
+	if err != nil {
+		return nil, fmt.Errorf("parse template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, v); err != nil {
+		return nil, fmt.Errorf("execute template: %w", err)
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated source: %w", err)
+	}
+	return src, nil
+}
+
+// generateDeviceClients writes the typed device clients below outputDir.
+func generateDeviceClients(logger *slog.Logger, outputDir string, md *meta.Metadata) error {
+	for _, name := range DeviceClients(md) {
+		src, err := RenderDeviceClient(md, name)
+		if err != nil {
+			return err
+		}
+		dir := filepath.Join(outputDir, DeviceClientDir(name))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("create %s: %w", dir, err)
+		}
+		outputFile := filepath.Join(dir, OutputFile)
+		if err := os.WriteFile(outputFile, src, 0o644); err != nil {
+			return fmt.Errorf("write %s: %w", outputFile, err)
+		}
+		logger.Info("Generated Go device client", "device", name, "file", outputFile)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package golang
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/Alia5/VIIPER/internal/codegen/common"
+	"github.com/Alia5/VIIPER/internal/codegen/meta"
+)
+
+// OutputFile is the name of the generated file inside the apiclient package.
+const OutputFile = "client_gen.go"
+
+const clientTemplateGo = `// Code generated by "viiper codegen --lang go". DO NOT EDIT.
+
+package apiclient
+
+import (
+{{range .Imports}}	{{.}}
+{{end}})
+{{- if .Features}}
+
+// Optional protocol features of the server, see Client.Supports.
+const (
+{{- range .Features}}
+	{{.Const}} = "{{.Name}}" // since {{.Since}}, negotiated by {{.Negotiation}}
+{{- end}}
+)
+{{- end}}
+{{range .Methods}}
+{{range .Doc}}// {{.}}
+{{end}}func (c *Client) {{.Name}}({{.Params}}) {{.Results}} {
+	return c.{{.Name}}Ctx(context.Background(){{.Args}})
+}
+
+// {{.Name}}Ctx is the context-aware version of {{.Name}}.
+func (c *Client) {{.Name}}Ctx(ctx context.Context{{.CtxParams}}) {{.Results}} {
+{{- if .PathParams}}
+	pathParams := map[string]string{ {{- .PathParams -}} }
+{{- end}}
+	const path = "{{.Path}}"
+{{- if .PayloadErr}}
+	payload, err := {{.Payload}}
+	if err != nil {
+		return {{.ErrReturn}}
+	}
+{{- end}}
+	raw, err := c.transport.DoCtx(ctx, path, {{if .PayloadErr}}payload{{else if .Payload}}{{.Payload}}{{else}}nil{{end}}, {{if .PathParams}}pathParams{{else}}nil{{end}})
+	if err != nil {
+		return {{.ErrReturn}}
+	}
+{{- if .ResponseDTO}}
+	return parse[apitypes.{{.ResponseDTO}}](raw)
+{{- else}}
+	_, err = parse[struct{}](raw)
+	return err
+{{- end}}
+}
+{{end}}
+{{- range .Methods}}{{if .Batch}}
+// {{.Name}} queues a {{.Name}} request on the batch, see Client.{{.Name}}.
+func (b *Batch) {{.Name}}({{.Params}}) *BatchCall[{{.ResultType}}] {
+{{- if .PathParams}}
+	pathParams := map[string]string{ {{- .PathParams -}} }
+{{- end}}
+	const path = "{{.Path}}"
+{{- if .PayloadErr}}
+	payload, err := {{.Payload}}
+	if err != nil {
+		return failBatchCall[{{.ResultType}}](b, err)
+	}
+{{- end}}
+	return queueBatchCall[{{.ResultType}}](b, path, {{if .PayloadErr}}payload{{else if .Payload}}{{.Payload}}{{else}}nil{{end}}, {{if .PathParams}}pathParams{{else}}nil{{end}})
+}
+{{end}}{{end}}`
+
+type featureView struct {
+	Const       string
+	Name        string
+	Since       string
+	Negotiation string
+}
+
+type methodView struct {
+	Name        string
+	Doc         []string
+	Path        string
+	Params      string
+	CtxParams   string
+	Args        string
+	PathParams  string
+	Payload     string
+	PayloadErr  bool
+	ResponseDTO string
+	Results     string
+	ErrReturn   string
+	ResultType  string // type parameter of the Batch builder's BatchCall
+	Batch       bool   // also emit a Batch builder method
+}
+
+// Generate writes the apiclient management methods into outputDir and the
+// typed device clients into packages below it.
+func Generate(logger *slog.Logger, outputDir string, md *meta.Metadata) error {
+	src, err := Render(md)
+	if err != nil {
+		return err
+	}
+	outputFile := filepath.Join(outputDir, OutputFile)
+	if err := os.WriteFile(outputFile, src, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", outputFile, err)
+	}
+	logger.Info("Generated Go apiclient methods", "file", outputFile)
+	return generateDeviceClients(logger, outputDir, md)
+}
+
+// Render returns the gofmt'ed source of the generated apiclient file.
+func Render(md *meta.Metadata) ([]byte, error) {
+	var methods []methodView
+	for _, route := range md.Routes {
+		if route.Method != "Register" {
+			continue
+		}
+		spec := specFor(route)
+		methods = append(methods, buildView(route.Path, route.ResponseDTO, spec))
+	}
+
+	tmpl, err := template.New("clientGo").Parse(clientTemplateGo)
+	if err != nil {
+		return nil, fmt.Errorf("parse template: %w", err)
+	}
+	var features []featureView
+	for _, f := range md.Features {
+		features = append(features, featureView{
+			Const:       "Feature" + common.ToPascalCase(f.Name),
+			Name:        f.Name,
+			Since:       f.Since,
+			Negotiation: f.Negotiation,
+		})
+	}
+	data := struct {
+		Imports  []string
+		Features []featureView
+		Methods  []methodView
+	}{Imports: collectImports(methods), Features: features, Methods: methods}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("execute template: %w", err)
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated source: %w", err)
+	}
+	return src, nil
+}
+
+func buildView(path, responseDTO string, spec methodSpec) methodView {
+	var params, args []string
+	for _, p := range spec.Params {
+		params = append(params, p.Name+" "+p.Type)
+		args = append(args, p.Name)
+	}
+	v := methodView{
+		Name:        spec.Name,
+		Doc:         spec.Doc,
+		Path:        path,
+		Params:      strings.Join(params, ", "),
+		Payload:     spec.Payload,
+		PayloadErr:  spec.PayloadErr,
+		ResponseDTO: responseDTO,
+		Results:     "error",
+		ErrReturn:   "err",
+		ResultType:  "struct{}",
+		Batch:       !spec.NoBatch,
+	}
+	if len(params) > 0 {
+		v.CtxParams = ", " + v.Params
+		v.Args = ", " + strings.Join(args, ", ")
+	}
+	if responseDTO != "" {
+		v.Results = fmt.Sprintf("(*apitypes.%s, error)", responseDTO)
+		v.ErrReturn = "nil, err"
+		v.ResultType = "apitypes." + responseDTO
+	}
+	var pp []string
+	for _, key := range common.ExtractPathParams(path) {
+		expr, ok := spec.PathParams[key]
+		if !ok {
+			expr = common.ToCamelCase(key)
+		}
+		pp = append(pp, fmt.Sprintf("%q: %s", key, expr))
+	}
+	v.PathParams = strings.Join(pp, ", ")
+	return v
+}
+
+// collectImports picks the imports referenced by the method signatures and bodies.
+func collectImports(methods []methodView) []string {
+	var code strings.Builder
+	for _, m := range methods {
+		code.WriteString(m.Params + m.Results + m.PathParams + m.Payload)
+	}
+	imports := []string{`"context"`}
+	if strings.Contains(code.String(), "fmt.") {
+		imports = append(imports, `"fmt"`)
+	}
+	imports = append(imports, "")
+	if strings.Contains(code.String(), "apitypes.") {
+		imports = append(imports, `apitypes "github.com/Alia5/VIIPER/apitypes"`)
+	}
+	if strings.Contains(code.String(), "device.") {
+		imports = append(imports, `"github.com/Alia5/VIIPER/device"`)
+	}
+	return imports
+}
@@ -0,0 +1,82 @@
+package golang_test
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Alia5/VIIPER/internal/codegen/generator"
+	"github.com/Alia5/VIIPER/internal/codegen/generator/golang"
+	"github.com/Alia5/VIIPER/internal/codegen/meta"
+	"github.com/Alia5/VIIPER/internal/codegen/scanner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderMatchesCommittedClient(t *testing.T) {
+	t.Chdir(filepath.Join("..", "..", "..", ".."))
+
+	md, err := generator.New(t.TempDir(), slog.New(slog.DiscardHandler)).ScanAll()
+	require.NoError(t, err)
+
+	got, err := golang.Render(md)
+	require.NoError(t, err)
+
+	want, err := os.ReadFile(filepath.Join("apiclient", golang.OutputFile))
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got), "apiclient/%s is stale; run \"viiper codegen --lang go\"", golang.OutputFile)
+}
+
+func TestRenderMatchesCommittedDeviceClients(t *testing.T) {
+	t.Chdir(filepath.Join("..", "..", "..", ".."))
+
+	md, err := generator.New(t.TempDir(), slog.New(slog.DiscardHandler)).ScanAll()
+	require.NoError(t, err)
+
+	names := golang.DeviceClients(md)
+	assert.Subset(t, names, []string{"dualshock4", "keyboard", "mouse", "xbox360"})
+	for _, name := range names {
+		got, err := golang.RenderDeviceClient(md, name)
+		require.NoError(t, err)
+		path := filepath.Join("apiclient", golang.DeviceClientDir(name), golang.OutputFile)
+		want, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, string(want), string(got), "%s is stale; run \"viiper codegen --lang go\"", path)
+	}
+}
+
+func TestRenderDefaultSignatures(t *testing.T) {
+	md := &meta.Metadata{Routes: []scanner.RouteInfo{
+		{
+			Path:        "bus/{id}/stats",
+			Method:      "Register",
+			Handler:     "BusStats",
+			ResponseDTO: "BusStatsResponse",
+			Payload:     scanner.PayloadInfo{Kind: scanner.PayloadNumeric, RawType: "uint32"},
+		},
+		{
+			Path:    "bus/{id}/{deviceid}/poke",
+			Method:  "Register",
+			Handler: "DevicePoke",
+			Payload: scanner.PayloadInfo{Kind: scanner.PayloadJSON, RawType: "PokeRequest"},
+		},
+		{
+			Path:    "bus/{busId}/{deviceid}",
+			Method:  "RegisterStream",
+			Handler: "DeviceStreamHandler",
+		},
+	}}
+
+	src, err := golang.Render(md)
+	require.NoError(t, err)
+	out := string(src)
+
+	assert.Contains(t, out, "func (c *Client) BusStatsCtx(ctx context.Context, id string, value uint32) (*apitypes.BusStatsResponse, error)")
+	assert.Contains(t, out, `pathParams := map[string]string{"id": id, "deviceid": deviceid}`)
+	assert.Contains(t, out, "func (c *Client) DevicePoke(id string, deviceid string, req *apitypes.PokeRequest) error")
+	assert.Contains(t, out, "func (b *Batch) BusStats(id string, value uint32) *BatchCall[apitypes.BusStatsResponse]")
+	assert.Contains(t, out, "func (b *Batch) DevicePoke(id string, deviceid string, req *apitypes.PokeRequest) *BatchCall[struct{}]")
+	assert.False(t, strings.Contains(out, "DeviceStreamHandler"), "stream routes must not produce management methods")
+}
@@ -0,0 +1,404 @@
+package golang
+
+import (
+	"fmt"
+
+	"github.com/Alia5/VIIPER/internal/codegen/common"
+	"github.com/Alia5/VIIPER/internal/codegen/scanner"
+)
+
+type param struct {
+	Name string
+	Type string
+}
+
+// methodSpec describes how a management route is exposed on apiclient.Client.
+type methodSpec struct {
+	Name       string            // method name; the context-aware variant appends "Ctx"
+	Doc        []string          // doc comment lines for the plain variant
+	Params     []param           // parameters following ctx
+	PathParams map[string]string // path param -> Go expression yielding its string value
+	Payload    string            // Go expression for the payload; empty sends none
+	PayloadErr bool              // Payload expression returns (value, error)
+	NoBatch    bool              // the server refuses the route inside a batch
+}
+
+// methodOverrides pins hand-picked signatures (including those that predate
+// the generator); routes without an entry fall back to defaultSpec.
+// Keyed by handler factory name as discovered by the route scanner.
+var methodOverrides = map[string]methodSpec{
+	"Ping": {
+		Name: "Ping",
+		Doc:  []string{"Ping returns the version and identity of the VIIPER server."},
+	},
+	"Features": {
+		Name: "Features",
+		Doc: []string{
+			"Features lists the optional protocol features of the server.",
+			"Prefer Supports, which caches the list.",
+		},
+	},
+	"AuthTicket": {
+		Name: "AuthTicket",
+		Doc: []string{
+			"AuthTicket fetches a session resumption ticket. Clients with a password fetch",
+			"and present tickets on their own, see Config.DisableResume.",
+		},
+	},
+	"BusCreate": {
+		Name: "BusCreate",
+		Doc: []string{
+			"BusCreate creates a new virtual USB bus with the specified bus number, or with",
+			"the lowest free one if busID is 0. Returns the created bus ID or an error if the",
+			"bus number is already allocated.",
+		},
+		Params:  []param{{"busID", "uint32"}},
+		Payload: `fmt.Sprintf("%d", busID)`,
+	},
+	"BusCreateWith": {
+		Name: "BusCreateWith",
+		Doc: []string{
+			"BusCreateWith creates a new virtual USB bus from the full JSON form of the",
+			"bus/create payload, e.g. to limit the devices it holds.",
+		},
+		Params:  []param{{"req", "apitypes.BusCreateRequest"}},
+		Payload: "req",
+	},
+	"BusRemove": {
+		Name: "BusRemove",
+		Doc: []string{
+			"BusRemove removes an existing virtual USB bus and all devices attached to it.",
+			"Returns the removed bus ID or an error if the bus does not exist.",
+		},
+		Params:  []param{{"busID", "uint32"}},
+		Payload: `fmt.Sprintf("%d", busID)`,
+	},
+	"BusList": {
+		Name: "BusList",
+		Doc:  []string{"BusList retrieves all active virtual USB buses with their labels and device counts."},
+	},
+	"BusDeviceAdd": {
+		Name: "DeviceAdd",
+		Doc: []string{
+			"DeviceAdd adds a new device of the specified type to the given bus.",
+			"The devType parameter specifies the device type (e.g., \"xbox360\").",
+			"Returns the assigned bus ID (e.g., \"1-1\") or an error if the bus does not exist",
+			"or the device type is unknown.",
+		},
+		Params:     []param{{"busID", "uint32"}, {"devType", "string"}, {"o", "*device.CreateOptions"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`},
+		Payload:    "deviceCreatePayload(devType, o)",
+		PayloadErr: true,
+	},
+	"BusDeviceAddBatch": {
+		Name: "DeviceAddBatch",
+		Doc: []string{
+			"DeviceAddBatch adds several devices to the given bus in one call, returning them in",
+			"the order requested. If one fails, the devices added before it are removed again.",
+		},
+		Params:     []param{{"busID", "uint32"}, {"devices", "[]apitypes.DeviceCreateRequest"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`},
+		Payload:    "apitypes.DeviceAddBatchRequest{Devices: devices}",
+	},
+	"BusDeviceRemove": {
+		Name: "DeviceRemove",
+		Doc: []string{
+			"DeviceRemove removes a device from the specified bus by its device ID.",
+			"The busid parameter is the device number (e.g., \"1\") on the given bus.",
+			"Active USB-IP connections to the device will be closed.",
+			"Returns the removed device's bus and device ID or an error if not found.",
+		},
+		Params:     []param{{"busID", "uint32"}, {"busid", "string"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`},
+		Payload:    "busid",
+	},
+	"BusDevicesList": {
+		Name: "DevicesList",
+		Doc: []string{
+			"DevicesList retrieves a list of all devices attached to the specified bus.",
+			"Each device entry includes bus ID, device ID, VID, PID, and device type.",
+		},
+		Params:     []param{{"busID", "uint32"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`},
+	},
+	"BusGetDefaults": {
+		Name: "BusGetDefaults",
+		Doc: []string{
+			"BusGetDefaults retrieves the default create options of the specified bus,",
+			"keyed by device type or \"*\" for all types.",
+		},
+		Params:     []param{{"busID", "uint32"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`},
+	},
+	"BusSetDefaults": {
+		Name: "BusSetDefaults",
+		Doc: []string{
+			"BusSetDefaults replaces the default create options of the specified bus.",
+			"Devices added afterwards inherit them; explicit DeviceAdd options win per key.",
+		},
+		Params:     []param{{"busID", "uint32"}, {"defaults", "map[string]apitypes.DeviceDefaults"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`},
+		Payload:    "apitypes.BusDefaultsRequest{Defaults: defaults}",
+	},
+	"BusSetLabel": {
+		Name: "BusSetLabel",
+		Doc: []string{
+			"BusSetLabel replaces the label and description of the specified bus.",
+			"USB-IP paths of devices added afterwards embed the new label.",
+		},
+		Params:     []param{{"busID", "uint32"}, {"label", "string"}, {"description", "string"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`},
+		Payload:    "apitypes.BusLabelRequest{Label: label, Description: description}",
+	},
+	"MetaProtocol": {
+		Name: "FetchProtocol",
+		Doc: []string{
+			"FetchProtocol retrieves the protocol reference the server was built with:",
+			"routes, DTOs, device wire formats and features, as in protocol.json.",
+		},
+	},
+	"AdminReadOnly": {
+		Name: "SetReadOnly",
+		Doc: []string{
+			"SetReadOnly switches the server's read-only mode, in which management requests",
+			"that change state are refused. A nil req only reports the mode. Only served to",
+			"localhost clients.",
+		},
+		Params:  []param{{"req", "*apitypes.ReadOnlyRequest"}},
+		Payload: "req",
+	},
+	"AdminStateSnapshots": {
+		Name: "StateSnapshots",
+		Doc: []string{
+			"StateSnapshots lists the snapshots of the server's state file, newest first.",
+			"Only served to localhost clients.",
+		},
+	},
+	"AdminStateRestore": {
+		Name: "RestoreStateSnapshot",
+		Doc: []string{
+			"RestoreStateSnapshot replaces all buses and devices with those of the named",
+			"snapshot of the state file. Only served to localhost clients.",
+		},
+		Params:  []param{{"snapshot", "string"}},
+		Payload: "apitypes.StateRestoreRequest{Snapshot: snapshot}",
+	},
+	"TimeSync": {
+		Name: "TimeSync",
+		Doc: []string{
+			"TimeSync sends clientMonoNs to the server and returns it together with the",
+			"server clocks. Use TimeOffset, which turns the exchange into a clock offset.",
+		},
+		Params:  []param{{"clientMonoNs", "int64"}},
+		Payload: `fmt.Sprintf("%d", clientMonoNs)`,
+	},
+	"TemplateList": {
+		Name: "TemplateList",
+		Doc:  []string{"TemplateList lists the device templates stored on the server."},
+	},
+	"TemplateGet": {
+		Name:    "TemplateGet",
+		Doc:     []string{"TemplateGet retrieves the device template with the given name."},
+		Params:  []param{{"name", "string"}},
+		Payload: "name",
+	},
+	"TemplateSet": {
+		Name: "TemplateSet",
+		Doc: []string{
+			"TemplateSet stores a device template, replacing one of the same name.",
+			"The server validates the options against the device type before saving;",
+			"devices already created from the template keep their configuration.",
+		},
+		Params:  []param{{"t", "*apitypes.DeviceTemplate"}},
+		Payload: "t",
+	},
+	"TemplateRemove": {
+		Name:    "TemplateRemove",
+		Doc:     []string{"TemplateRemove deletes the device template with the given name."},
+		Params:  []param{{"name", "string"}},
+		Payload: "name",
+	},
+	"DeviceSetLabel": {
+		Name: "SetDeviceLabel",
+		Doc: []string{
+			"SetDeviceLabel replaces the label of the specified device; an empty label clears it.",
+		},
+		Params:     []param{{"busID", "uint32"}, {"devID", "string"}, {"label", "string"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`, "deviceid": "devID"},
+		Payload:    "apitypes.DeviceLabelRequest{Label: label}",
+	},
+	"DeviceAlias": {
+		Name: "DeviceAlias",
+		Doc: []string{
+			"DeviceAlias exposes the device on another bus. The alias reports the input of",
+			"the original, is removed with it, and delivers its own feedback on its stream.",
+		},
+		Params:     []param{{"busID", "uint32"}, {"devID", "string"}, {"targetBusID", "uint32"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`, "deviceid": "devID"},
+		Payload:    "apitypes.DeviceAliasRequest{BusID: targetBusID}",
+	},
+	"DeviceDegrade": {
+		Name: "DeviceDegrade",
+		Doc: []string{
+			"DeviceDegrade sets the simulated link degradation of the device. A nil cfg",
+			"only reports the current settings; a zero cfg turns degradation off.",
+		},
+		Params:     []param{{"busID", "uint32"}, {"devID", "string"}, {"cfg", "*apitypes.DegradeConfig"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`, "deviceid": "devID"},
+		Payload:    "cfg",
+	},
+	"DeviceStep": {
+		Name: "DeviceStep",
+		Doc: []string{
+			"DeviceStep applies the next queued input states of a device in deterministic",
+			"mode. A nil req steps one state.",
+		},
+		Params:     []param{{"busID", "uint32"}, {"devID", "string"}, {"req", "*apitypes.DeviceStepRequest"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`, "deviceid": "devID"},
+		Payload:    "req",
+	},
+	"DeviceStats": {
+		Name: "DeviceStats",
+		Doc: []string{
+			"DeviceStats reports the USB traffic of the device and whether the host polls",
+			"it as fast as its descriptors advertise.",
+		},
+		Params:     []param{{"busID", "uint32"}, {"devID", "string"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`, "deviceid": "devID"},
+	},
+	"Metrics": {
+		Name: "Metrics",
+		Doc: []string{
+			"Metrics returns the server's counters, gauges and histograms: devices,",
+			"URB and stream throughput, input report build times and error counts.",
+		},
+	},
+	"UsbipStats": {
+		Name: "UsbipStats",
+		Doc: []string{
+			"UsbipStats reports the open USB/IP connections and how many the server",
+			"refused or closed under its connection limits.",
+		},
+	},
+	"DeviceStatus": {
+		Name: "DeviceStatus",
+		Doc: []string{
+			"DeviceStatus reports whether a USB/IP host has the device imported, so a",
+			"feeder can wait for the host before streaming input.",
+		},
+		Params:     []param{{"busID", "uint32"}, {"devID", "string"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`, "deviceid": "devID"},
+	},
+	"DeviceRecordStart": {
+		Name: "RecordStart",
+		Doc: []string{
+			"RecordStart starts a server-side recording of the inputs and feedback streamed",
+			"for the device. A nil req uses the server defaults (60 s, 16 MiB).",
+		},
+		Params:     []param{{"busID", "uint32"}, {"devID", "string"}, {"req", "*apitypes.RecordStartRequest"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`, "deviceid": "devID"},
+		Payload:    "req",
+		NoBatch:    true,
+	},
+	"DeviceRecordStop": {
+		Name:       "RecordStop",
+		Doc:        []string{"RecordStop finalizes the recording of the device and returns its status."},
+		Params:     []param{{"busID", "uint32"}, {"devID", "string"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`, "deviceid": "devID"},
+	},
+	"DeviceRecordDownload": {
+		Name: "RecordDownload",
+		Doc: []string{
+			"RecordDownload returns the chunk of the finished recording starting at offset.",
+			"See RecordFor for downloading a complete recording.",
+		},
+		Params:     []param{{"busID", "uint32"}, {"devID", "string"}, {"offset", "uint64"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`, "deviceid": "devID"},
+		Payload:    "apitypes.RecordDownloadRequest{Offset: offset}",
+	},
+	"DeviceMacroPlay": {
+		Name: "PlayMacro",
+		Doc: []string{
+			"PlayMacro plays a sequence of input states against the device on the server,",
+			"each held for its HoldMs. It returns once playback started.",
+		},
+		Params:     []param{{"busID", "uint32"}, {"devID", "string"}, {"req", "*apitypes.MacroRequest"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`, "deviceid": "devID"},
+		Payload:    "req",
+		NoBatch:    true,
+	},
+	"DeviceMacroCancel": {
+		Name:       "CancelMacro",
+		Doc:        []string{"CancelMacro stops the macro playing against the device, if any."},
+		Params:     []param{{"busID", "uint32"}, {"devID", "string"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`, "deviceid": "devID"},
+	},
+	"DeviceTestFeedback": {
+		Name: "DeviceTestFeedback",
+		Doc: []string{
+			"DeviceTestFeedback makes the device emit a synthetic feedback sequence to its",
+			"stream client. A nil req uses the server defaults (100 ms ramp at 100 Hz).",
+		},
+		Params:     []param{{"busID", "uint32"}, {"devID", "string"}, {"req", "*apitypes.TestFeedbackRequest"}},
+		PathParams: map[string]string{"id": `fmt.Sprintf("%d", busID)`, "deviceid": "devID"},
+		Payload:    "req",
+		NoBatch:    true,
+	},
+	"Batch": {
+		Name: "ExecBatch",
+		Doc: []string{
+			"ExecBatch runs raw management requests in one round trip.",
+			"See NewBatch for a builder with typed results.",
+		},
+		Params:  []param{{"req", "*apitypes.BatchRequest"}},
+		Payload: "req",
+		NoBatch: true,
+	},
+}
+
+// MethodName returns the apiclient.Client method name generated for a route.
+func MethodName(route scanner.RouteInfo) string {
+	return specFor(route).Name
+}
+
+func specFor(route scanner.RouteInfo) methodSpec {
+	if spec, ok := methodOverrides[route.Handler]; ok {
+		return spec
+	}
+	return defaultSpec(route)
+}
+
+// defaultSpec derives a method from the scanned route metadata alone.
+// Path params become string arguments in pattern order, followed by the payload.
+func defaultSpec(route scanner.RouteInfo) methodSpec {
+	spec := methodSpec{
+		Name:       route.Handler,
+		Doc:        []string{fmt.Sprintf("%s calls the %q management route.", route.Handler, route.Path)},
+		PathParams: map[string]string{},
+	}
+	for _, key := range common.ExtractPathParams(route.Path) {
+		name := common.ToCamelCase(key)
+		spec.Params = append(spec.Params, param{name, "string"})
+		spec.PathParams[key] = name
+	}
+	switch route.Payload.Kind {
+	case scanner.PayloadNumeric:
+		typ := route.Payload.RawType
+		if typ == "" {
+			typ = "int"
+		}
+		spec.Params = append(spec.Params, param{"value", typ})
+		spec.Payload = `fmt.Sprintf("%d", value)`
+	case scanner.PayloadString:
+		spec.Params = append(spec.Params, param{"payload", "string"})
+		spec.Payload = "payload"
+	case scanner.PayloadJSON:
+		typ := "any"
+		if route.Payload.RawType != "" {
+			typ = "*apitypes." + route.Payload.RawType
+		}
+		spec.Params = append(spec.Params, param{"req", typ})
+		spec.Payload = "req"
+	}
+	return spec
+}
@@ -0,0 +1,58 @@
+// Package protocol renders the scanned metadata as protocol.json, a
+// machine-readable reference of the management routes, DTOs, device wire
+// formats and features. The server embeds it (see internal/protocol).
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/Alia5/VIIPER/internal/codegen/meta"
+	"github.com/Alia5/VIIPER/internal/codegen/scanner"
+)
+
+// OutputFile is the name of the generated file inside internal/protocol.
+const OutputFile = "protocol.json"
+
+// Document is the layout of protocol.json.
+type Document struct {
+	Routes   []scanner.RouteInfo                    `json:"routes"`
+	DTOs     []scanner.DTOSchema                    `json:"dtos"`
+	Wire     map[string]map[string]*scanner.WireTag `json:"wire"` // device -> direction -> layout
+	Devices  map[string]*scanner.DeviceConstants    `json:"devices"`
+	Features []scanner.FeatureInfo                  `json:"features"`
+}
+
+func Generate(logger *slog.Logger, outputDir string, md *meta.Metadata) error {
+	doc, err := Render(md)
+	if err != nil {
+		return err
+	}
+	outputFile := filepath.Join(outputDir, OutputFile)
+	if err := os.WriteFile(outputFile, doc, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", outputFile, err)
+	}
+	logger.Info("Generated protocol reference", "file", outputFile)
+	return nil
+}
+
+// Render returns the indented protocol.json for md.
+func Render(md *meta.Metadata) ([]byte, error) {
+	doc := Document{
+		Routes:   md.Routes,
+		DTOs:     md.DTOs,
+		Devices:  md.DevicePackages,
+		Features: md.Features,
+	}
+	if md.WireTags != nil {
+		doc.Wire = md.WireTags.Tags
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal protocol: %w", err)
+	}
+	return append(out, '\n'), nil
+}
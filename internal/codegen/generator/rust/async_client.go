@@ -15,7 +15,10 @@ import (
 const asyncClientTemplate = `{{.Header}}
 use crate::error::{ProblemJson, ViiperError};
 use crate::types::*;
+use std::collections::HashSet;
 use std::net::SocketAddr;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Mutex;
 use tokio::io::{AsyncRead, AsyncReadExt, AsyncWrite, AsyncWriteExt};
 use tokio::net::TcpStream;
 
@@ -141,20 +144,118 @@ impl AsyncWrite for AsyncWriteWrapper {
 pub struct AsyncViiperClient {
     addr: SocketAddr,
     password: Option<String>,
+    server_fingerprint: Option<String>,
+    protocol_version: u32,
+    feature_cache: Mutex<Option<HashSet<String>>>,
+    ticket: Mutex<Option<crate::auth::ResumeTicket>>,
+    no_resume: AtomicBool,
 }
 
 #[cfg(feature = "async")]
 impl AsyncViiperClient {
     /// Create a new async VIIPER client connecting to the specified address.
     pub fn new(addr: SocketAddr) -> Self {
-        Self { addr, password: None }
+        Self::new_with_password(addr, String::new())
     }
 
     /// Create a new async VIIPER client with password authentication.
     /// Empty password string explicitly means no authentication.
     pub fn new_with_password(addr: SocketAddr, password: String) -> Self {
         let password = if password.is_empty() { None } else { Some(password) };
-        Self { addr, password }
+        Self {
+            addr,
+            password,
+            server_fingerprint: None,
+            protocol_version: 1,
+            feature_cache: Mutex::new(None),
+            ticket: Mutex::new(None),
+            no_resume: AtomicBool::new(false),
+        }
+    }
+
+    /// Pin the server identity, "sha256:<hex>" as the server logs it at
+    /// startup: the password handshake then fails unless the server proves
+    /// it. Requires a password.
+    pub fn with_server_fingerprint(mut self, fingerprint: impl Into<String>) -> Self {
+        self.server_fingerprint = Some(fingerprint.into());
+        self
+    }
+
+    /// Set the request framing, 1 by default. Version 2 prefixes requests
+    /// with "eVI2\0" and frames requests and responses with a u32 big-endian
+    /// length instead of the null and newline terminators, so payloads may
+    /// contain either.
+    pub fn with_protocol_version(mut self, version: u32) -> Self {
+        self.protocol_version = version;
+        self
+    }
+
+    /// Reports whether the server implements an optional protocol feature,
+    /// see [crate::features]. The feature list is fetched once; servers
+    /// without the features route support none.
+    pub async fn supports(&self, feature: &str) -> Result<bool, ViiperError> {
+        if let Some(set) = self.feature_cache.lock().unwrap_or_else(|e| e.into_inner()).as_ref() {
+            return Ok(set.contains(feature));
+        }
+        let set: HashSet<String> = match self.features().await {
+            Ok(resp) => resp.features.into_iter().map(|f| f.name).collect(),
+            Err(ViiperError::Protocol(p)) if p.status == 404 => HashSet::new(),
+            Err(e) => return Err(e),
+        };
+        let supported = set.contains(feature);
+        *self.feature_cache.lock().unwrap_or_else(|e| e.into_inner()) = Some(set);
+        Ok(supported)
+    }
+
+    /// Open a connection, authenticating with the cached resumption ticket if
+    /// there is one. A refused ticket, e.g. after a server restart or password
+    /// change, is dropped and the full handshake runs on a new connection.
+    async fn open(&self) -> Result<AsyncStreamWrapper, ViiperError> {
+        let tcp_stream = TcpStream::connect(self.addr).await?;
+        tcp_stream.set_nodelay(true)?;
+        let Some(ref pwd) = self.password else {
+            if self.server_fingerprint.is_some() {
+                return Err(ViiperError::UnexpectedResponse("A server fingerprint requires a password".into()));
+            }
+            return Ok(AsyncStreamWrapper::Plain(tcp_stream));
+        };
+
+        let ticket = self.ticket.lock().unwrap_or_else(|e| e.into_inner()).clone();
+        let tcp_stream = match ticket.filter(|t| t.usable()) {
+            Some(ticket) => match crate::auth::perform_resume_handshake_async(tcp_stream, &ticket).await {
+                Ok(stream) => return Ok(AsyncStreamWrapper::Encrypted(stream)),
+                Err(_) => {
+                    *self.ticket.lock().unwrap_or_else(|e| e.into_inner()) = None;
+                    let tcp_stream = TcpStream::connect(self.addr).await?;
+                    tcp_stream.set_nodelay(true)?;
+                    tcp_stream
+                }
+            },
+            None => tcp_stream,
+        };
+        if let Some(ref fingerprint) = self.server_fingerprint {
+            return Ok(AsyncStreamWrapper::Encrypted(crate::auth::perform_signed_handshake_async(tcp_stream, pwd, fingerprint).await?));
+        }
+        Ok(AsyncStreamWrapper::Encrypted(crate::auth::perform_handshake_async(tcp_stream, pwd).await?))
+    }
+
+    /// Fetch a resumption ticket unless a usable one is cached. Servers
+    /// without resumption are not asked again.
+    async fn fetch_ticket(&self) {
+        if self.password.is_none() || self.no_resume.load(Ordering::Relaxed) {
+            return;
+        }
+        if self.ticket.lock().unwrap_or_else(|e| e.into_inner()).as_ref().map_or(false, |t| t.usable()) {
+            return;
+        }
+        match self.send_request::<AuthTicketResponse>("auth/ticket", None).await {
+            Ok(resp) => match crate::auth::ResumeTicket::from_response(&resp) {
+                Some(ticket) => *self.ticket.lock().unwrap_or_else(|e| e.into_inner()) = Some(ticket),
+                None => self.no_resume.store(true, Ordering::Relaxed),
+            },
+            Err(ViiperError::Protocol(p)) if p.status == 404 => self.no_resume.store(true, Ordering::Relaxed),
+            Err(_) => {}
+        }
     }
 
     async fn do_request<T: for<'de> serde::Deserialize<'de>>(
@@ -162,29 +263,31 @@ impl AsyncViiperClient {
         path: &str,
         payload: Option<&str>,
     ) -> Result<T, ViiperError> {
-        let tcp_stream = TcpStream::connect(self.addr).await?;
-        tcp_stream.set_nodelay(true)?;
+        let result = self.send_request(path, payload).await;
+        if result.is_ok() {
+            self.fetch_ticket().await;
+        }
+        result
+    }
 
-        let mut stream = if let Some(ref pwd) = self.password {
-            AsyncStreamWrapper::Encrypted(crate::auth::perform_handshake_async(tcp_stream, pwd).await?)
-        } else {
-            AsyncStreamWrapper::Plain(tcp_stream)
-        };
+    async fn send_request<T: for<'de> serde::Deserialize<'de>>(
+        &self,
+        path: &str,
+        payload: Option<&str>,
+    ) -> Result<T, ViiperError> {
+        let mut stream = self.open().await?;
 
-        stream.write_all(path.as_bytes()).await?;
+        let mut line = path.to_string();
         if let Some(p) = payload {
-            stream.write_all(b" ").await?;
-            stream.write_all(p.as_bytes()).await?;
+            line.push(' ');
+            line.push_str(p);
         }
-        stream.write_all(b"\0").await?;
+        stream.write_all(&crate::client::frame_request(&line, self.protocol_version)).await?;
 
         let mut buf = Vec::new();
         stream.read_to_end(&mut buf).await?;
 
-        let response = String::from_utf8(buf)
-            .map_err(|_| ViiperError::UnexpectedResponse("invalid UTF-8".into()))?
-            .trim_end_matches('\n')
-            .to_string();
+        let response = crate::client::decode_response(buf, self.protocol_version)?;
 
         if response.starts_with("{\"status\":") {
             let problem: ProblemJson = serde_json::from_str(&response)?;
@@ -204,7 +307,7 @@ impl AsyncViiperClient {
 {{end}}{{end}}
     /// Connect to a device stream for sending input and receiving output.
     pub async fn connect_device(&self, bus_id: u32, dev_id: &str) -> Result<AsyncDeviceStream, ViiperError> {
-        AsyncDeviceStream::connect(self.addr, bus_id, dev_id, self.password.as_deref()).await
+        AsyncDeviceStream::attach(self.open().await?, bus_id, dev_id, self.protocol_version).await
     }
 }
 
@@ -223,17 +326,28 @@ impl AsyncDeviceStream {
         let tcp_stream = TcpStream::connect(addr).await?;
 		tcp_stream.set_nodelay(true)?;
 		
-        let (read_stream, mut write_stream) = if let Some(pwd) = password {
-            let encrypted = crate::auth::perform_handshake_async(tcp_stream, pwd).await?;
-            let (read_half, write_half) = encrypted.into_split();
-            (AsyncReadWrapper::Encrypted(read_half), AsyncWriteWrapper::Encrypted(write_half))
+        let stream = if let Some(pwd) = password {
+            AsyncStreamWrapper::Encrypted(crate::auth::perform_handshake_async(tcp_stream, pwd).await?)
         } else {
-            let (read_half, write_half) = tcp_stream.into_split();
-            (AsyncReadWrapper::Plain(read_half), AsyncWriteWrapper::Plain(write_half))
+            AsyncStreamWrapper::Plain(tcp_stream)
         };
-        
-        let handshake = format!("bus/{}/{}\0", bus_id, dev_id);
-        write_stream.write_all(handshake.as_bytes()).await?;
+        Self::attach(stream, bus_id, dev_id, 1).await
+    }
+
+    async fn attach(stream: AsyncStreamWrapper, bus_id: u32, dev_id: &str, protocol_version: u32) -> Result<Self, ViiperError> {
+        let (read_stream, mut write_stream) = match stream {
+            AsyncStreamWrapper::Encrypted(encrypted) => {
+                let (read_half, write_half) = encrypted.into_split();
+                (AsyncReadWrapper::Encrypted(read_half), AsyncWriteWrapper::Encrypted(write_half))
+            }
+            AsyncStreamWrapper::Plain(tcp_stream) => {
+                let (read_half, write_half) = tcp_stream.into_split();
+                (AsyncReadWrapper::Plain(read_half), AsyncWriteWrapper::Plain(write_half))
+            }
+        };
+
+        let handshake = crate::client::frame_request(&format!("bus/{}/{}", bus_id, dev_id), protocol_version);
+        write_stream.write_all(&handshake).await?;
         
         Ok(Self { 
             read_stream: std::sync::Arc::new(tokio::sync::Mutex::new(read_stream)),
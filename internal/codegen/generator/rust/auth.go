@@ -15,6 +15,9 @@ use chacha20poly1305::{
     ChaCha20Poly1305, Nonce,
 };
 use hmac::{Hmac, Mac};
+use p256::ecdh::EphemeralSecret;
+use p256::ecdsa::{signature::Verifier, Signature, VerifyingKey};
+use p256::{EncodedPoint, PublicKey};
 use pbkdf2::pbkdf2_hmac;
 use rand::RngCore;
 use sha2::{Digest, Sha256};
@@ -36,6 +39,44 @@ const AUTH_CONTEXT: &[u8] = b"VIIPER-Auth-v1";
 const SESSION_CONTEXT: &[u8] = b"VIIPER-Session-v1";
 const PBKDF2_ITERATIONS: u32 = 100_000;
 const PBKDF2_SALT: &[u8] = b"VIIPER-Key-v1";
+const RESUME_MAGIC: &[u8] = b"eVR1\x00";
+const RESUME_CONTEXT: &[u8] = b"VIIPER-Resume-v1";
+const TICKET_SIZE: usize = 24;
+/// How long before its expiry a ticket is no longer used
+const TICKET_MARGIN: std::time::Duration = std::time::Duration::from_secs(30);
+const SIGNED_HANDSHAKE_MAGIC: &[u8] = b"eVS1\x00";
+const IDENTITY_CONTEXT: &[u8] = b"VIIPER-Identity-v1";
+const POINT_SIZE: usize = 65;
+const SIGNATURE_SIZE: usize = 64;
+
+/// Session resumption ticket fetched from the auth/ticket route. Presenting
+/// it skips the password proof, and the PBKDF2 behind it, on later connections.
+#[derive(Clone)]
+pub struct ResumeTicket {
+    ticket: Vec<u8>,
+    secret: Vec<u8>,
+    expires: std::time::Instant,
+}
+
+impl ResumeTicket {
+    /// Decode a ticket response; None if it is malformed or too short lived to be worth caching.
+    pub(crate) fn from_response(resp: &crate::types::AuthTicketResponse) -> Option<Self> {
+        use base64::Engine;
+        let engine = base64::engine::general_purpose::STANDARD;
+        let ticket = engine.decode(&resp.ticket).ok()?;
+        let secret = engine.decode(&resp.secret).ok()?;
+        let lifetime = std::time::Duration::from_secs(resp.expires_in);
+        if ticket.len() != TICKET_SIZE || lifetime < 2 * TICKET_MARGIN {
+            return None;
+        }
+        Some(Self { ticket, secret, expires: std::time::Instant::now() + lifetime })
+    }
+
+    /// Whether the ticket is far enough from its expiry to be presented
+    pub(crate) fn usable(&self) -> bool {
+        self.expires.saturating_duration_since(std::time::Instant::now()) >= TICKET_MARGIN
+    }
+}
 
 /// Derive a 32-byte key from password using PBKDF2-SHA256
 fn derive_key(password: &str) -> Result<[u8; 32], ViiperError> {
@@ -80,15 +121,8 @@ pub fn perform_handshake(mut stream: TcpStream, password: &str) -> Result<Encryp
     stream.read_exact(&mut response)?;
     
     if &response[0..3] != b"OK\x00" {
-        let mut error_buf = Vec::new();
-        let _ = stream.read_to_end(&mut error_buf);
-        let full_response = [response, error_buf].concat();
-        let error_str = String::from_utf8_lossy(&full_response);
-        
-        if let Ok(problem) = serde_json::from_str::<crate::error::ProblemJson>(&error_str) {
-            return Err(ViiperError::Protocol(problem));
-        }
-        return Err(ViiperError::UnexpectedResponse(format!("Invalid handshake response: {}", error_str)));
+        let _ = stream.read_to_end(&mut response);
+        return Err(handshake_error(&response));
     }
     
     let server_nonce = &response[3..];
@@ -123,15 +157,8 @@ pub async fn perform_handshake_async(mut stream: AsyncTcpStream, password: &str)
     stream.read_exact(&mut response).await?;
     
     if &response[0..3] != b"OK\x00" {
-        let mut error_buf = Vec::new();
-        let _ = stream.read_to_end(&mut error_buf).await;
-        let full_response = [response, error_buf].concat();
-        let error_str = String::from_utf8_lossy(&full_response);
-        
-        if let Ok(problem) = serde_json::from_str::<crate::error::ProblemJson>(&error_str) {
-            return Err(ViiperError::Protocol(problem));
-        }
-        return Err(ViiperError::UnexpectedResponse(format!("Invalid handshake response: {}", error_str)));
+        let _ = stream.read_to_end(&mut response).await;
+        return Err(handshake_error(&response));
     }
     
     let server_nonce = &response[3..];
@@ -141,6 +168,183 @@ pub async fn perform_handshake_async(mut stream: AsyncTcpStream, password: &str)
     Ok(AsyncEncryptedStream::new(stream, session_key))
 }
 
+/// Fingerprint of an uncompressed P-256 public key as servers log it at
+/// startup: "sha256:" followed by the hex SHA-256 of the key.
+pub fn fingerprint(public_key: &[u8]) -> String {
+    let digest = Sha256::digest(public_key);
+    let mut out = String::from("sha256:");
+    for b in digest {
+        out.push_str(&format!("{:02x}", b));
+    }
+    out
+}
+
+/// Perform the authentication handshake with the server proving the identity
+/// pinned by server_fingerprint (synchronous). Fails if it proves another
+/// identity, or none. The session key also derives from an ephemeral ECDH
+/// P-256 exchange.
+pub fn perform_signed_handshake(mut stream: TcpStream, password: &str, server_fingerprint: &str) -> Result<EncryptedStream, ViiperError> {
+    let signed = SignedHandshake::new(password)?;
+    stream.write_all(&signed.msg)?;
+
+    let mut response = vec![0u8; 3 + NONCE_SIZE + 2 * POINT_SIZE + SIGNATURE_SIZE];
+    stream.read_exact(&mut response[..3 + NONCE_SIZE])?;
+    if &response[0..3] != b"OK\x00" {
+        response.truncate(3 + NONCE_SIZE);
+        let _ = stream.read_to_end(&mut response);
+        return Err(handshake_error(&response));
+    }
+    stream.read_exact(&mut response[3 + NONCE_SIZE..])?;
+
+    let session_key = signed.finish(&response, server_fingerprint)?;
+    Ok(EncryptedStream::new(stream, session_key)?)
+}
+
+/// Perform the authentication handshake with the server proving the identity
+/// pinned by server_fingerprint (asynchronous), see perform_signed_handshake.
+#[cfg(feature = "async")]
+pub async fn perform_signed_handshake_async(mut stream: AsyncTcpStream, password: &str, server_fingerprint: &str) -> Result<AsyncEncryptedStream, ViiperError> {
+    let signed = SignedHandshake::new(password)?;
+    stream.write_all(&signed.msg).await?;
+
+    let mut response = vec![0u8; 3 + NONCE_SIZE + 2 * POINT_SIZE + SIGNATURE_SIZE];
+    stream.read_exact(&mut response[..3 + NONCE_SIZE]).await?;
+    if &response[0..3] != b"OK\x00" {
+        response.truncate(3 + NONCE_SIZE);
+        let _ = stream.read_to_end(&mut response).await;
+        return Err(handshake_error(&response));
+    }
+    stream.read_exact(&mut response[3 + NONCE_SIZE..]).await?;
+
+    let session_key = signed.finish(&response, server_fingerprint)?;
+    Ok(AsyncEncryptedStream::new(stream, session_key))
+}
+
+/// Client state of a signed handshake between sending msg and the answer
+struct SignedHandshake {
+    key: [u8; 32],
+    client_nonce: [u8; NONCE_SIZE],
+    secret: EphemeralSecret,
+    msg: Vec<u8>,
+}
+
+impl SignedHandshake {
+    /// Build the message: magic, client nonce, password proof and an ephemeral ECDH key
+    fn new(password: &str) -> Result<Self, ViiperError> {
+        let key = derive_key(password)?;
+        let mut client_nonce = [0u8; NONCE_SIZE];
+        rand::thread_rng().fill_bytes(&mut client_nonce);
+
+        let mut mac = <Hmac::<Sha256> as KeyInit>::new_from_slice(&key)
+            .map_err(|_| ViiperError::UnexpectedResponse("Invalid key length".into()))?;
+        mac.update(AUTH_CONTEXT);
+        mac.update(&client_nonce);
+        let auth_tag = mac.finalize().into_bytes();
+
+        let secret = EphemeralSecret::random(&mut rand::rngs::OsRng);
+        let point = EncodedPoint::from(secret.public_key());
+
+        let mut msg = Vec::with_capacity(SIGNED_HANDSHAKE_MAGIC.len() + NONCE_SIZE + 32 + POINT_SIZE);
+        msg.extend_from_slice(SIGNED_HANDSHAKE_MAGIC);
+        msg.extend_from_slice(&client_nonce);
+        msg.extend_from_slice(&auth_tag);
+        msg.extend_from_slice(point.as_bytes());
+        Ok(Self { key, client_nonce, secret, msg })
+    }
+
+    /// Check the server's answer, "OK\0" + server nonce + server ECDH key +
+    /// identity + signature, and derive the session key
+    fn finish(&self, response: &[u8], server_fingerprint: &str) -> Result<[u8; 32], ViiperError> {
+        let server_nonce = &response[3..3 + NONCE_SIZE];
+        let server_key = &response[3 + NONCE_SIZE..3 + NONCE_SIZE + POINT_SIZE];
+        let identity = &response[3 + NONCE_SIZE + POINT_SIZE..3 + NONCE_SIZE + 2 * POINT_SIZE];
+        let signature = &response[3 + NONCE_SIZE + 2 * POINT_SIZE..];
+
+        let presented = fingerprint(identity);
+        if presented != server_fingerprint {
+            return Err(ViiperError::UnexpectedResponse(format!("server identity {} does not match the pinned fingerprint", presented)));
+        }
+        let mismatch = || ViiperError::UnexpectedResponse("server identity does not match the pinned fingerprint: invalid handshake signature".into());
+        let verifying_key = VerifyingKey::from_sec1_bytes(identity).map_err(|_| mismatch())?;
+        let signature = Signature::from_slice(signature).map_err(|_| mismatch())?;
+        let transcript = [IDENTITY_CONTEXT, &self.msg[..], server_nonce, server_key].concat();
+        verifying_key.verify(&transcript, &signature).map_err(|_| mismatch())?;
+
+        let peer = PublicKey::from_sec1_bytes(server_key)
+            .map_err(|_| ViiperError::UnexpectedResponse("Invalid server handshake key".into()))?;
+        let shared = self.secret.diffie_hellman(&peer);
+        let mut mac = <Hmac::<Sha256> as KeyInit>::new_from_slice(&self.key)
+            .map_err(|_| ViiperError::UnexpectedResponse("Invalid key length".into()))?;
+        mac.update(IDENTITY_CONTEXT);
+        mac.update(shared.raw_secret_bytes());
+        let handshake_key = mac.finalize().into_bytes();
+        Ok(derive_session_key(&handshake_key, server_nonce, &self.client_nonce))
+    }
+}
+
+/// Perform a resumed handshake presenting ticket instead of the password (synchronous)
+pub fn perform_resume_handshake(mut stream: TcpStream, ticket: &ResumeTicket) -> Result<EncryptedStream, ViiperError> {
+    let (client_nonce, handshake_msg) = resume_message(ticket)?;
+    stream.write_all(&handshake_msg)?;
+
+    let mut response = vec![0u8; 3 + NONCE_SIZE];
+    stream.read_exact(&mut response)?;
+    if &response[0..3] != b"OK\x00" {
+        let _ = stream.read_to_end(&mut response);
+        return Err(handshake_error(&response));
+    }
+
+    let session_key = derive_session_key(&ticket.secret, &response[3..], &client_nonce);
+    Ok(EncryptedStream::new(stream, session_key)?)
+}
+
+/// Perform a resumed handshake presenting ticket instead of the password (asynchronous)
+#[cfg(feature = "async")]
+pub async fn perform_resume_handshake_async(mut stream: AsyncTcpStream, ticket: &ResumeTicket) -> Result<AsyncEncryptedStream, ViiperError> {
+    let (client_nonce, handshake_msg) = resume_message(ticket)?;
+    stream.write_all(&handshake_msg).await?;
+
+    let mut response = vec![0u8; 3 + NONCE_SIZE];
+    stream.read_exact(&mut response).await?;
+    if &response[0..3] != b"OK\x00" {
+        let _ = stream.read_to_end(&mut response).await;
+        return Err(handshake_error(&response));
+    }
+
+    let session_key = derive_session_key(&ticket.secret, &response[3..], &client_nonce);
+    Ok(AsyncEncryptedStream::new(stream, session_key))
+}
+
+/// Build the resume message: magic, client nonce, ticket and the proof of
+/// holding its secret, an HMAC of the nonce and ticket
+fn resume_message(ticket: &ResumeTicket) -> Result<([u8; NONCE_SIZE], Vec<u8>), ViiperError> {
+    let mut client_nonce = [0u8; NONCE_SIZE];
+    rand::thread_rng().fill_bytes(&mut client_nonce);
+
+    let mut mac = <Hmac::<Sha256> as KeyInit>::new_from_slice(&ticket.secret)
+        .map_err(|_| ViiperError::UnexpectedResponse("Invalid key length".into()))?;
+    mac.update(RESUME_CONTEXT);
+    mac.update(&client_nonce);
+    mac.update(&ticket.ticket);
+    let proof = mac.finalize().into_bytes();
+
+    let mut msg = Vec::with_capacity(RESUME_MAGIC.len() + NONCE_SIZE + TICKET_SIZE + 32);
+    msg.extend_from_slice(RESUME_MAGIC);
+    msg.extend_from_slice(&client_nonce);
+    msg.extend_from_slice(&ticket.ticket);
+    msg.extend_from_slice(&proof);
+    Ok((client_nonce, msg))
+}
+
+/// Turn a refused handshake response into an error
+fn handshake_error(response: &[u8]) -> ViiperError {
+    let error_str = String::from_utf8_lossy(response);
+    if let Ok(problem) = serde_json::from_str::<crate::error::ProblemJson>(error_str.trim_end()) {
+        return ViiperError::Protocol(problem);
+    }
+    ViiperError::UnexpectedResponse(format!("Invalid handshake response: {}", error_str))
+}
+
 /// Encrypted stream wrapper using ChaCha20-Poly1305 (synchronous)
 /// Read and write paths are independently locked to avoid blocking writes
 /// while a read thread is waiting for output.
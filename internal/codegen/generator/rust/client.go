@@ -15,8 +15,11 @@ import (
 const clientTemplate = `{{.Header}}
 use crate::error::{ProblemJson, ViiperError};
 use crate::types::*;
+use std::collections::HashSet;
 use std::io::{Read, Write};
 use std::net::{SocketAddr, TcpStream, Shutdown};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Mutex;
 
 /// Stream wrapper that can be either plain or encrypted
 enum StreamWrapper {
@@ -69,19 +72,115 @@ impl Write for StreamWrapper {
 pub struct ViiperClient {
     addr: SocketAddr,
     password: Option<String>,
+    server_fingerprint: Option<String>,
+    protocol_version: u32,
+    feature_cache: Mutex<Option<HashSet<String>>>,
+    ticket: Mutex<Option<crate::auth::ResumeTicket>>,
+    no_resume: AtomicBool,
 }
 
 impl ViiperClient {
     /// Create a new VIIPER client connecting to the specified address.
     pub fn new(addr: SocketAddr) -> Self {
-        Self { addr, password: None }
+        Self::new_with_password(addr, String::new())
     }
 
     /// Create a new VIIPER client with password authentication.
     /// Empty password string explicitly means no authentication.
     pub fn new_with_password(addr: SocketAddr, password: String) -> Self {
         let password = if password.is_empty() { None } else { Some(password) };
-        Self { addr, password }
+        Self {
+            addr,
+            password,
+            server_fingerprint: None,
+            protocol_version: 1,
+            feature_cache: Mutex::new(None),
+            ticket: Mutex::new(None),
+            no_resume: AtomicBool::new(false),
+        }
+    }
+
+    /// Pin the server identity, "sha256:<hex>" as the server logs it at
+    /// startup: the password handshake then fails unless the server proves
+    /// it. Requires a password.
+    pub fn with_server_fingerprint(mut self, fingerprint: impl Into<String>) -> Self {
+        self.server_fingerprint = Some(fingerprint.into());
+        self
+    }
+
+    /// Set the request framing, 1 by default. Version 2 prefixes requests
+    /// with "eVI2\0" and frames requests and responses with a u32 big-endian
+    /// length instead of the null and newline terminators, so payloads may
+    /// contain either.
+    pub fn with_protocol_version(mut self, version: u32) -> Self {
+        self.protocol_version = version;
+        self
+    }
+
+    /// Reports whether the server implements an optional protocol feature,
+    /// see [crate::features]. The feature list is fetched once; servers
+    /// without the features route support none.
+    pub fn supports(&self, feature: &str) -> Result<bool, ViiperError> {
+        let mut cache = self.feature_cache.lock().unwrap_or_else(|e| e.into_inner());
+        if cache.is_none() {
+            *cache = Some(match self.features() {
+                Ok(resp) => resp.features.into_iter().map(|f| f.name).collect(),
+                Err(ViiperError::Protocol(p)) if p.status == 404 => HashSet::new(),
+                Err(e) => return Err(e),
+            });
+        }
+        Ok(cache.as_ref().map_or(false, |set| set.contains(feature)))
+    }
+
+    /// Open a connection, authenticating with the cached resumption ticket if
+    /// there is one. A refused ticket, e.g. after a server restart or password
+    /// change, is dropped and the full handshake runs on a new connection.
+    fn open(&self) -> Result<StreamWrapper, ViiperError> {
+        let tcp_stream = TcpStream::connect(self.addr)?;
+        tcp_stream.set_nodelay(true)?;
+        let Some(ref pwd) = self.password else {
+            if self.server_fingerprint.is_some() {
+                return Err(ViiperError::UnexpectedResponse("A server fingerprint requires a password".into()));
+            }
+            return Ok(StreamWrapper::Plain(tcp_stream));
+        };
+
+        let ticket = self.ticket.lock().unwrap_or_else(|e| e.into_inner()).clone();
+        let tcp_stream = match ticket.filter(|t| t.usable()) {
+            Some(ticket) => match crate::auth::perform_resume_handshake(tcp_stream, &ticket) {
+                Ok(stream) => return Ok(StreamWrapper::Encrypted(stream)),
+                Err(_) => {
+                    *self.ticket.lock().unwrap_or_else(|e| e.into_inner()) = None;
+                    let tcp_stream = TcpStream::connect(self.addr)?;
+                    tcp_stream.set_nodelay(true)?;
+                    tcp_stream
+                }
+            },
+            None => tcp_stream,
+        };
+        if let Some(ref fingerprint) = self.server_fingerprint {
+            return Ok(StreamWrapper::Encrypted(crate::auth::perform_signed_handshake(tcp_stream, pwd, fingerprint)?));
+        }
+        Ok(StreamWrapper::Encrypted(crate::auth::perform_handshake(tcp_stream, pwd)?))
+    }
+
+    /// Fetch a resumption ticket unless a usable one is cached. Servers
+    /// without resumption are not asked again.
+    fn fetch_ticket(&self) {
+        if self.password.is_none() || self.no_resume.load(Ordering::Relaxed) {
+            return;
+        }
+        if self.ticket.lock().unwrap_or_else(|e| e.into_inner()).as_ref().map_or(false, |t| t.usable()) {
+            return;
+        }
+        match self.send_request::<AuthTicketResponse>("auth/ticket", None) {
+            Ok(resp) => match crate::auth::ResumeTicket::from_response(&resp) {
+                Some(ticket) => *self.ticket.lock().unwrap_or_else(|e| e.into_inner()) = Some(ticket),
+                None => self.no_resume.store(true, Ordering::Relaxed),
+            },
+            Err(ViiperError::Protocol(p)) if p.status == 404 => self.no_resume.store(true, Ordering::Relaxed),
+            Err(_) => {}
+        }
     }
 
     fn do_request<T: for<'de> serde::Deserialize<'de>>(
@@ -89,29 +188,31 @@ impl ViiperClient {
         path: &str,
         payload: Option<&str>,
     ) -> Result<T, ViiperError> {
-        let tcp_stream = TcpStream::connect(self.addr)?;
-        tcp_stream.set_nodelay(true)?;
+        let result = self.send_request(path, payload);
+        if result.is_ok() {
+            self.fetch_ticket();
+        }
+        result
+    }
 
-        let mut stream = if let Some(ref pwd) = self.password {
-            StreamWrapper::Encrypted(crate::auth::perform_handshake(tcp_stream, pwd)?)
-        } else {
-            StreamWrapper::Plain(tcp_stream)
-        };
+    fn send_request<T: for<'de> serde::Deserialize<'de>>(
+        &self,
+        path: &str,
+        payload: Option<&str>,
+    ) -> Result<T, ViiperError> {
+        let mut stream = self.open()?;
 
-        stream.write_all(path.as_bytes())?;
+        let mut line = path.to_string();
         if let Some(p) = payload {
-            stream.write_all(b" ")?;
-            stream.write_all(p.as_bytes())?;
+            line.push(' ');
+            line.push_str(p);
         }
-        stream.write_all(b"\0")?;
+        stream.write_all(&frame_request(&line, self.protocol_version))?;
 
         let mut buf = Vec::new();
         stream.read_to_end(&mut buf)?;
 
-        let response = String::from_utf8(buf)
-            .map_err(|_| ViiperError::UnexpectedResponse("invalid UTF-8".into()))?
-            .trim_end_matches('\n')
-            .to_string();
+        let response = decode_response(buf, self.protocol_version)?;
 
         if response.starts_with("{\"status\":") {
             let problem: ProblemJson = serde_json::from_str(&response)?;
@@ -131,10 +232,40 @@ impl ViiperClient {
 {{end}}{{end}}
     /// Connect to a device stream for sending input and receiving output.
     pub fn connect_device(&self, bus_id: u32, dev_id: &str) -> Result<DeviceStream, ViiperError> {
-        DeviceStream::connect(self.addr, bus_id, dev_id, self.password.as_deref())
+        DeviceStream::attach(self.open()?, bus_id, dev_id, self.protocol_version)
     }
 }
 
+/// Encode a request line in the given framing, see
+/// [ViiperClient::with_protocol_version].
+pub(crate) fn frame_request(line: &str, protocol_version: u32) -> Vec<u8> {
+    if protocol_version < 2 {
+        let mut framed = line.as_bytes().to_vec();
+        framed.push(0);
+        return framed;
+    }
+    let mut framed = b"eVI2\0".to_vec();
+    framed.extend_from_slice(&(line.len() as u32).to_be_bytes());
+    framed.extend_from_slice(line.as_bytes());
+    framed
+}
+
+/// Decode a complete response in the given framing.
+pub(crate) fn decode_response(buf: Vec<u8>, protocol_version: u32) -> Result<String, ViiperError> {
+    let body = if protocol_version < 2 {
+        buf
+    } else {
+        match buf.get(..4).map(|h| u32::from_be_bytes([h[0], h[1], h[2], h[3]]) as usize) {
+            Some(n) if buf.len() - 4 >= n => buf[4..4 + n].to_vec(),
+            _ => return Err(ViiperError::UnexpectedResponse("truncated response frame".into())),
+        }
+    };
+    Ok(String::from_utf8(body)
+        .map_err(|_| ViiperError::UnexpectedResponse("invalid UTF-8".into()))?
+        .trim_end_matches('\n')
+        .to_string())
+}
+
 /// A connected device stream for bidirectional communication.
 pub struct DeviceStream {
     stream: StreamWrapper,
@@ -147,14 +278,17 @@ impl DeviceStream {
         let tcp_stream = TcpStream::connect(addr)?;
 		tcp_stream.set_nodelay(true)?;
 		
-		let mut stream = if let Some(pwd) = password {
+		let stream = if let Some(pwd) = password {
 		    StreamWrapper::Encrypted(crate::auth::perform_handshake(tcp_stream, pwd)?)
 		} else {
 		    StreamWrapper::Plain(tcp_stream)
 		};
-		
-		let handshake = format!("bus/{}/{}\0", bus_id, dev_id);
-        stream.write_all(handshake.as_bytes())?;
+		Self::attach(stream, bus_id, dev_id, 1)
+    }
+
+    fn attach(mut stream: StreamWrapper, bus_id: u32, dev_id: &str, protocol_version: u32) -> Result<Self, ViiperError> {
+		let handshake = frame_request(&format!("bus/{}/{}", bus_id, dev_id), protocol_version);
+        stream.write_all(&handshake)?;
         Ok(Self { 
             stream,
             output_thread: None,
@@ -19,7 +19,8 @@ use crate::wire::DeviceInput;
 
 #[derive(Debug, Clone, Default)]
 pub struct {{.StructName}} {
-{{range .Fields}}    pub {{.RustName}}: {{.RustType}},
+{{range .Fields}}{{if .Doc}}    /// {{.Doc}}
+{{end}}    pub {{.RustName}}: {{.RustType}},
 {{end}}}
 
 impl DeviceInput for {{.StructName}} {
@@ -40,7 +41,8 @@ use crate::wire::DeviceOutput;
 
 #[derive(Debug, Clone, Default)]
 pub struct {{.StructName}} {
-{{range .Fields}}    pub {{.RustName}}: {{.RustType}},
+{{range .Fields}}{{if .Doc}}    /// {{.Doc}}
+{{end}}    pub {{.RustName}}: {{.RustType}},
 {{end}}}
 
 impl DeviceOutput for {{.StructName}} {
@@ -93,6 +95,7 @@ type rustWireField struct {
 	IsArray     bool
 	CountName   string
 	FixedLen    int
+	Doc         string
 }
 
 type deviceTypeData struct {
@@ -172,6 +175,7 @@ func generateDeviceWireStruct(outputPath, deviceName, className string, tag *sca
 			IsArray:     isArray,
 			CountName:   countName,
 			FixedLen:    fixedLen,
+			Doc:         common.WireRangeDoc(field),
 		})
 	}
 
@@ -0,0 +1,53 @@
+package rust
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/Alia5/VIIPER/internal/codegen/meta"
+)
+
+const featuresTemplate = `{{.Header}}
+//! Optional protocol features of the server, see ` + "`ViiperClient::supports`" + `.
+{{range .Features}}
+/// Since {{.Since}}, negotiated by {{.Negotiation}}.
+pub const {{constName .Name}}: &str = "{{.Name}}";
+{{- end}}
+`
+
+// RenderFeatures returns features.rs for the feature registry in md.
+func RenderFeatures(md *meta.Metadata) ([]byte, error) {
+	tmpl, err := template.New("featuresRust").Funcs(template.FuncMap{
+		"constName": func(name string) string { return strings.ToUpper(strings.ReplaceAll(name, "-", "_")) },
+	}).Parse(featuresTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("parse template: %w", err)
+	}
+	data := struct {
+		Header   string
+		Features any
+	}{Header: writeFileHeaderRust(), Features: md.Features}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("execute template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func generateFeatures(logger *slog.Logger, srcDir string, md *meta.Metadata) error {
+	src, err := RenderFeatures(md)
+	if err != nil {
+		return err
+	}
+	outputFile := filepath.Join(srcDir, "features.rs")
+	if err := os.WriteFile(outputFile, src, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", outputFile, err)
+	}
+	logger.Info("Generated features.rs", "file", outputFile)
+	return nil
+}
@@ -46,6 +46,10 @@ func Generate(logger *slog.Logger, outputDir string, md *meta.Metadata) error {
 		return err
 	}
 
+	if err := generateFeatures(logger, srcDir, md); err != nil {
+		return err
+	}
+
 	if err := generateClient(logger, srcDir, md); err != nil {
 		return err
 	}
@@ -105,6 +109,7 @@ func generateLibFile(logger *slog.Logger, srcDir string, md *meta.Metadata) erro
 pub mod error;
 pub mod wire;
 pub mod types;
+pub mod features;
 pub mod client;
 pub mod auth;
 
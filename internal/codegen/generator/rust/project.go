@@ -34,7 +34,9 @@ pbkdf2 = { version = "0.12", features = ["simple"] }
 sha2 = "0.10"
 hmac = "0.12"
 chacha20poly1305 = "0.10"
+p256 = { version = "0.13", features = ["ecdh", "ecdsa"] }
 rand = "0.8"
+base64 = "0.22"
 
 [dependencies.tokio]
 version = "1.0"
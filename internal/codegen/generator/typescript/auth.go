@@ -11,7 +11,7 @@ const authUtilsTemplate = `// Auto-generated VIIPER TypeScript Client Library
 // DO NOT EDIT - This file is generated from the VIIPER server codebase
 
 import { Socket } from 'net';
-import { createCipheriv, createDecipheriv, pbkdf2Sync, randomBytes, createHash, createHmac } from 'crypto';
+import { createCipheriv, createDecipheriv, pbkdf2Sync, randomBytes, createHash, createHmac, createECDH, createPublicKey, verify } from 'crypto';
 import { Duplex } from 'stream';
 
 const HANDSHAKE_MAGIC = 'eVI1\x00';
@@ -20,6 +20,23 @@ const AUTH_CONTEXT = 'VIIPER-Auth-v1';
 const SESSION_CONTEXT = 'VIIPER-Session-v1';
 const PBKDF2_ITERATIONS = 100000;
 const PBKDF2_SALT = 'VIIPER-Key-v1';
+const RESUME_MAGIC = 'eVR1\x00';
+const RESUME_CONTEXT = 'VIIPER-Resume-v1';
+const TICKET_SIZE = 24;
+const SIGNED_HANDSHAKE_MAGIC = 'eVS1\x00';
+const IDENTITY_CONTEXT = 'VIIPER-Identity-v1';
+const POINT_SIZE = 65;
+const SIGNATURE_SIZE = 64;
+
+/**
+ * A session resumption ticket and the secret it grants, as issued by the
+ * auth/ticket route. expires is in milliseconds since the epoch.
+ */
+export interface ResumeTicket {
+	ticket: Buffer;
+	secret: Buffer;
+	expires: number;
+}
 
 /**
  * Derive a 32-byte key from password using PBKDF2-SHA256
@@ -62,31 +79,133 @@ export async function performAuthHandshake(socket: Socket, password: string): Pr
 	]);
 	socket.write(handshakeMsg);
 	
+	const serverNonce = await readServerNonce(socket);
+	
+	const sessionKey = deriveSessionKey(key, serverNonce, clientNonce);
+	
+	return new EncryptedSocket(socket, sessionKey);
+}
+
+/**
+ * Fingerprint of an uncompressed P-256 public key as servers log it at startup:
+ * "sha256:" followed by the hex SHA-256 of the key.
+ */
+export function fingerprint(publicKey: Buffer): string {
+	return 'sha256:' + createHash('sha256').update(publicKey).digest('hex');
+}
+
+/**
+ * Perform the authentication handshake with the server proving the identity
+ * pinned by serverFingerprint. Throws if it proves another identity, or none.
+ * The session key also derives from an ephemeral ECDH P-256 exchange.
+ */
+export async function performSignedAuthHandshake(socket: Socket, password: string, serverFingerprint: string): Promise<EncryptedSocket> {
+	const key = deriveKey(password);
+	const clientNonce = randomBytes(NONCE_SIZE);
+	const hmac = createHmac('sha256', key);
+	hmac.update(Buffer.from(AUTH_CONTEXT));
+	hmac.update(clientNonce);
+	const ecdh = createECDH('prime256v1');
+
+	const handshakeMsg = Buffer.concat([
+		Buffer.from(SIGNED_HANDSHAKE_MAGIC),
+		clientNonce,
+		hmac.digest(),
+		ecdh.generateKeys()
+	]);
+	socket.write(handshakeMsg);
+
+	// Refusals are shorter than the answer; read them to the end.
+	const response = await readExactly(socket, 3 + NONCE_SIZE + 2 * POINT_SIZE + SIGNATURE_SIZE, true);
+	if (response.slice(0, 3).toString() !== 'OK\x00') {
+		throw handshakeError(response);
+	}
+	if (response.length < 3 + NONCE_SIZE + 2 * POINT_SIZE + SIGNATURE_SIZE) {
+		throw new Error('Connection closed before receiving full response');
+	}
+	const serverNonce = response.slice(3, 3 + NONCE_SIZE);
+	const serverKey = response.slice(3 + NONCE_SIZE, 3 + NONCE_SIZE + POINT_SIZE);
+	const identity = response.slice(3 + NONCE_SIZE + POINT_SIZE, 3 + NONCE_SIZE + 2 * POINT_SIZE);
+	const signature = response.slice(3 + NONCE_SIZE + 2 * POINT_SIZE);
+
+	if (fingerprint(identity) !== serverFingerprint) {
+		throw new Error(` + "`Server identity ${fingerprint(identity)} does not match the pinned fingerprint`" + `);
+	}
+	const publicKey = createPublicKey({
+		key: { kty: 'EC', crv: 'P-256', x: identity.slice(1, 33).toString('base64url'), y: identity.slice(33).toString('base64url') },
+		format: 'jwk'
+	});
+	const transcript = Buffer.concat([Buffer.from(IDENTITY_CONTEXT), handshakeMsg, serverNonce, serverKey]);
+	if (!verify('sha256', transcript, { key: publicKey, dsaEncoding: 'ieee-p1363' }, signature)) {
+		throw new Error('Server identity does not match the pinned fingerprint: invalid handshake signature');
+	}
+
+	const mixed = createHmac('sha256', key);
+	mixed.update(Buffer.from(IDENTITY_CONTEXT));
+	mixed.update(ecdh.computeSecret(serverKey));
+	return new EncryptedSocket(socket, deriveSessionKey(mixed.digest(), serverNonce, clientNonce));
+}
+
+/**
+ * Perform a resumed handshake, presenting a ticket instead of the password proof.
+ * The session key derives from the ticket secret and fresh nonces.
+ */
+export async function performResumeHandshake(socket: Socket, ticket: ResumeTicket): Promise<EncryptedSocket> {
+	if (ticket.ticket.length !== TICKET_SIZE) {
+		throw new Error(` + "`Resume ticket must be ${TICKET_SIZE} bytes`" + `);
+	}
+	const clientNonce = randomBytes(NONCE_SIZE);
+	const hmac = createHmac('sha256', ticket.secret);
+	hmac.update(Buffer.from(RESUME_CONTEXT));
+	hmac.update(clientNonce);
+	hmac.update(ticket.ticket);
+	const proof = hmac.digest();
+
+	socket.write(Buffer.concat([
+		Buffer.from(RESUME_MAGIC),
+		clientNonce,
+		ticket.ticket,
+		proof
+	]));
+
+	const serverNonce = await readServerNonce(socket);
+
+	return new EncryptedSocket(socket, deriveSessionKey(ticket.secret, serverNonce, clientNonce));
+}
+
+/**
+ * Read the server nonce, or throw the error the server refused the handshake with
+ */
+async function readServerNonce(socket: Socket): Promise<Buffer> {
 	const response = await readExactly(socket, 3 + NONCE_SIZE);
 	
 	const prefix = response.slice(0, 3).toString();
 	if (prefix !== 'OK\x00') {
 		const remaining = await readUntilEnd(socket);
-		const fullResponse = Buffer.concat([response, remaining]).toString().trim();
-		try {
-			const error = JSON.parse(fullResponse);
-			throw new Error(` + "`${error.status} ${error.title}: ${error.detail}`" + `);
-		} catch {
-			throw new Error(` + "`Invalid handshake response: ${fullResponse}`" + `);
-		}
+		throw handshakeError(Buffer.concat([response, remaining]));
 	}
 	
-	const serverNonce = response.slice(3);
-	
-	const sessionKey = deriveSessionKey(key, serverNonce, clientNonce);
-	
-	return new EncryptedSocket(socket, sessionKey);
+	return response.slice(3);
 }
 
 /**
- * Read exact number of bytes from socket
+ * The error of a refused handshake, from the server's response
  */
-function readExactly(socket: Socket, length: number): Promise<Buffer> {
+function handshakeError(response: Buffer): Error {
+	const fullResponse = response.toString().trim();
+	let error: any;
+	try {
+		error = JSON.parse(fullResponse);
+	} catch {
+		return new Error(` + "`Invalid handshake response: ${fullResponse}`" + `);
+	}
+	return new Error(` + "`${error.status} ${error.title}: ${error.detail}`" + `);
+}
+
+/**
+ * Read exact number of bytes from socket, or with partial, fewer if it closes first
+ */
+function readExactly(socket: Socket, length: number, partial: boolean = false): Promise<Buffer> {
 	return new Promise((resolve, reject) => {
 		const chunks: Buffer[] = [];
 		let received = 0;
@@ -114,6 +233,10 @@ function readExactly(socket: Socket, length: number): Promise<Buffer> {
 		const onEnd = () => {
 			socket.removeListener('data', onData);
 			socket.removeListener('error', onError);
+			if (partial) {
+				resolve(Buffer.concat(chunks));
+				return;
+			}
 			reject(new Error('Connection closed before receiving full response'));
 		};
 		
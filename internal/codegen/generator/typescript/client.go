@@ -17,29 +17,71 @@ const clientTemplateTS = `{{writeFileHeaderTS}}
 import { Socket } from 'net';
 import { TextDecoder, TextEncoder } from 'util';
 import type * as Types from './types/ManagementDtos';
+import type { Feature } from './Features';
 import { ViiperDevice } from './ViiperDevice';
-import { performAuthHandshake } from './utils/auth';
+import { performAuthHandshake, performResumeHandshake, performSignedAuthHandshake, type ResumeTicket } from './utils/auth';
 
 const encoder = new TextEncoder();
 const decoder = new TextDecoder();
 
+// Tickets are no longer used this close to their expiry.
+const TICKET_MARGIN_MS = 30_000;
+
+// Announces the length-prefixed (v2) framing ahead of the request frame.
+const PROTOCOL_V2_MAGIC = encoder.encode('eVI2\0');
+
 /**
  * VIIPER management & streaming API client.
  * Request framing: <path>[ <payload>]\0 (null terminator) ; Response framing: single JSON line ending in \n then connection close.
+ * With protocolVersion 2 the request is preceded by "eVI2\0" and both directions use u32 big-endian
+ * length-prefixed frames instead, so payloads may contain null bytes and newlines.
  * 
  * @param host - VIIPER server hostname or IP address
  * @param port - VIIPER API server port (default: 3242)
  * @param password - Authentication password (default: "" = no auth). Empty string explicitly means no authentication.
+ * @param serverFingerprint - Pins the server identity, "sha256:<hex>" as the server logs it at startup (default: "" = not pinned).
+ * The password handshake then fails unless the server proves that identity. Requires a password.
+ * @param protocolVersion - Request framing, 1 (default) or 2, see above.
  */
 export class ViiperClient {
 	private host: string;
 	private port: number;
 	private password: string;
+	private serverFingerprint: string;
+	private protocolVersion: number;
+
+	private featureSet?: Promise<Set<string>>;
 
-	constructor(host: string, port: number = 3242, password: string = "") {
+	private ticket?: ResumeTicket;
+	private ticketFetch?: Promise<void>;
+	private noResume = false;
+
+	constructor(host: string, port: number = 3242, password: string = "", serverFingerprint: string = "", protocolVersion: number = 1) {
 		this.host = host;
 		this.port = port;
 		this.password = password;
+		this.serverFingerprint = serverFingerprint;
+		this.protocolVersion = protocolVersion;
+	}
+
+	/**
+	 * Reports whether the server implements an optional protocol feature.
+	 * The feature list is fetched once; servers without the features route support none.
+	 */
+	async supports(feature: Feature): Promise<boolean> {
+		if (!this.featureSet) {
+			this.featureSet = this.features().then(
+				(resp) => new Set(resp.features.map((f) => f.name)),
+				(e) => {
+					if (e instanceof Error && e.message.startsWith('404 ')) {
+						return new Set<string>();
+					}
+					this.featureSet = undefined;
+					throw e;
+				},
+			);
+		}
+		return (await this.featureSet).has(feature);
 	}
 {{range .Routes}}{{if eq .Method "Register"}}
 	/**
@@ -53,79 +95,155 @@ export class ViiperClient {
 	}
 {{end}}{{end}}
 	private async sendRequest<T>(path: string, payload?: string | null): Promise<T> {
-		return new Promise<T>(async (resolve, reject) => {
-			const socket = new Socket();
-			socket.connect(this.port, this.host, async () => {
-				try {
-					socket.setNoDelay(true);
-					
-					let wrappedSocket: Socket | any = socket;
-					if (this.password) {
-						wrappedSocket = await performAuthHandshake(socket, this.password);
+		const wrappedSocket = await this.openConnection();
+		const result = await new Promise<T>((resolve, reject) => {
+			wrappedSocket.on('error', reject);
+
+			let line = path; // preserve case
+			if (payload && payload.length > 0) line += ' ' + payload;
+			wrappedSocket.write(this.frameRequest(line));
+			
+			let buffer = Buffer.alloc(0);
+			const handleData = (chunk: Buffer) => {
+				buffer = Buffer.concat([buffer, chunk]);
+				const jsonLine = this.readResponse(buffer);
+				if (jsonLine !== undefined) {
+					let parsed: any;
+					try {
+						parsed = JSON.parse(jsonLine);
+					} catch (e) {
+						wrappedSocket.end();
+						reject(e);
+						return;
 					}
-					
-					let line = path; // preserve case
-					if (payload && payload.length > 0) line += ' ' + payload;
-					line += '\0';
-					wrappedSocket.write(encoder.encode(line));
-					
-					let buffer = '';
-					const handleData = (chunk: Buffer) => {
-						buffer += decoder.decode(chunk);
-						const nlIdx = buffer.indexOf('\n');
-						if (nlIdx !== -1) {
-							const jsonLine = buffer.slice(0, nlIdx);
-							let parsed: any;
-							try {
-								parsed = JSON.parse(jsonLine);
-							} catch (e) {
-								wrappedSocket.end();
-								reject(e);
-								return;
-							}
-							if (parsed && typeof parsed === 'object' && 'status' in parsed && parsed.status >= 400) {
-								wrappedSocket.end();
-								reject(new Error(String(parsed.status) + ' ' + parsed.title + ': ' + parsed.detail));
-								return;
-							}
-							wrappedSocket.end();
-							resolve(parsed as T);
-						}
-					};
-					
-					wrappedSocket.on('data', handleData);
-				} catch (e) {
-					socket.end();
-					reject(e);
+					if (parsed && typeof parsed === 'object' && 'status' in parsed && parsed.status >= 400) {
+						wrappedSocket.end();
+						reject(new Error(String(parsed.status) + ' ' + parsed.title + ': ' + parsed.detail));
+						return;
+					}
+					wrappedSocket.end();
+					resolve(parsed as T);
 				}
-			});
-
-			socket.on('error', reject);
-			socket.on('end', () => {/* noop */});
+			};
+			
+			wrappedSocket.on('data', handleData);
 		});
+		this.fetchTicket();
+		return result;
 	}
 
 	async connectDevice(busId: number, devId: string): Promise<ViiperDevice> {
-		return new Promise<ViiperDevice>(async (resolve, reject) => {
-			const socket = new Socket();
-			socket.connect(this.port, this.host, async () => {
-				try {
-					socket.setNoDelay(true);
-					
-					let wrappedSocket: Socket | any = socket;
-					if (this.password) {
-						wrappedSocket = await performAuthHandshake(socket, this.password);
-					}
-					
-					const line = ` + "`" + `bus/${busId}/${devId}\0` + "`" + `;
-					wrappedSocket.write(encoder.encode(line));
-					resolve(new ViiperDevice(wrappedSocket));
-				} catch (e) {
-					socket.end();
-					reject(e);
+		const wrappedSocket = await this.openConnection();
+		wrappedSocket.write(this.frameRequest(` + "`" + `bus/${busId}/${devId}` + "`" + `));
+		return new ViiperDevice(wrappedSocket);
+	}
+
+	/**
+	 * Encodes a request line in the configured framing.
+	 */
+	private frameRequest(line: string): Uint8Array {
+		const body = encoder.encode(line);
+		if (this.protocolVersion < 2) {
+			return Buffer.concat([body, Buffer.from([0])]);
+		}
+		const header = Buffer.alloc(4);
+		header.writeUInt32BE(body.length);
+		return Buffer.concat([PROTOCOL_V2_MAGIC, header, body]);
+	}
+
+	/**
+	 * Returns the response in buffer, or undefined while it is incomplete.
+	 */
+	private readResponse(buffer: Buffer): string | undefined {
+		if (this.protocolVersion < 2) {
+			const nlIdx = buffer.indexOf(0x0a);
+			return nlIdx === -1 ? undefined : decoder.decode(buffer.subarray(0, nlIdx));
+		}
+		if (buffer.length < 4 || buffer.length < 4 + buffer.readUInt32BE(0)) {
+			return undefined;
+		}
+		return decoder.decode(buffer.subarray(4, 4 + buffer.readUInt32BE(0)));
+	}
+
+	/**
+	 * Connects and, with a password, authenticates. A cached session ticket skips
+	 * the password handshake; if the server refuses it, e.g. after a restart or a
+	 * password change, the full handshake runs on a new connection.
+	 */
+	private async openConnection(): Promise<Socket | any> {
+		const ticket = this.ticket;
+		if (this.password && ticket && ticket.expires - Date.now() > TICKET_MARGIN_MS) {
+			const socket = await this.dial();
+			try {
+				return await performResumeHandshake(socket, ticket);
+			} catch {
+				socket.destroy();
+				if (this.ticket === ticket) {
+					this.ticket = undefined;
 				}
+			}
+		}
+		if (!this.password && this.serverFingerprint) {
+			throw new Error('A server fingerprint requires a password');
+		}
+		const socket = await this.dial();
+		if (!this.password) {
+			return socket;
+		}
+		try {
+			if (this.serverFingerprint) {
+				return await performSignedAuthHandshake(socket, this.password, this.serverFingerprint);
+			}
+			return await performAuthHandshake(socket, this.password);
+		} catch (e) {
+			socket.destroy();
+			throw e;
+		}
+	}
+
+	private dial(): Promise<Socket> {
+		return new Promise<Socket>((resolve, reject) => {
+			const socket = new Socket();
+			socket.once('error', reject);
+			socket.connect(this.port, this.host, () => {
+				socket.removeListener('error', reject);
+				socket.setNoDelay(true);
+				resolve(socket);
 			});
-			socket.on('error', reject);
+		});
+	}
+
+	/**
+	 * Fetches a session ticket in the background after a full handshake.
+	 * Servers without session resumption are not asked again.
+	 */
+	private fetchTicket(): void {
+		if (!this.password || this.noResume || this.ticketFetch) {
+			return;
+		}
+		if (this.ticket && this.ticket.expires - Date.now() > TICKET_MARGIN_MS) {
+			return;
+		}
+		this.ticketFetch = this.authticket().then(
+			(resp) => {
+				const lifetime = resp.expiresIn * 1000;
+				if (lifetime < 2 * TICKET_MARGIN_MS) {
+					this.noResume = true;
+					return;
+				}
+				this.ticket = {
+					ticket: Buffer.from(resp.ticket, 'base64'),
+					secret: Buffer.from(resp.secret, 'base64'),
+					expires: Date.now() + lifetime,
+				};
+			},
+			(e) => {
+				if (e instanceof Error && e.message.startsWith('404 ')) {
+					this.noResume = true;
+				}
+			},
+		).finally(() => {
+			this.ticketFetch = undefined;
 		});
 	}
 
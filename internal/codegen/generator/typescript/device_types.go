@@ -46,6 +46,7 @@ type tsWireField struct {
 	IsArray   bool
 	CountName string
 	FixedLen  int
+	Doc       string
 }
 
 func splitWireType(wireType string) (baseType string, countToken string, isArray bool) {
@@ -124,6 +125,7 @@ func generateWireClassTS(outputPath, device, className string, tag *scanner.Wire
 			Writer:   writerFor(baseType),
 			Reader:   readerFor(baseType),
 			IsArray:  isArray,
+			Doc:      common.WireRangeDoc(field),
 		}
 		if isArray {
 			if n, err := strconv.Atoi(countToken); err == nil {
@@ -155,7 +157,8 @@ import { BinaryWriter, BinaryReader } from '../../utils/binary';
 import type { IBinarySerializable } from '../../ViiperDevice';
 
 export class {{.Device}}{{.ClassName}} implements IBinarySerializable {
-{{range .Fields}}  {{.Name}}!: {{if or (eq .BaseType "u64") (eq .BaseType "i64")}}bigint{{else}}number{{end}}{{if .IsArray}}[]{{end}};
+{{range .Fields}}{{if .Doc}}  /** {{.Doc}} */
+{{end}}  {{.Name}}!: {{if or (eq .BaseType "u64") (eq .BaseType "i64")}}bigint{{else}}number{{end}}{{if .IsArray}}[]{{end}};
 {{end}}
   constructor(init: Partial<{{.Device}}{{.ClassName}}> = {}) {
     Object.assign(this, init);
@@ -0,0 +1,55 @@
+package typescript
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/Alia5/VIIPER/internal/codegen/common"
+	"github.com/Alia5/VIIPER/internal/codegen/meta"
+)
+
+const featuresTemplateTS = `{{writeFileHeaderTS}}
+/**
+ * Optional protocol features of the server, see ViiperClient.supports.
+ */
+export const Features = {
+{{- range .}}
+	{{toPascalCase .Name}}: '{{.Name}}', // since {{.Since}}, negotiated by {{.Negotiation}}
+{{- end}}
+} as const;
+
+export type Feature = typeof Features[keyof typeof Features];
+`
+
+// RenderFeatures returns Features.ts for the feature registry in md.
+func RenderFeatures(md *meta.Metadata) ([]byte, error) {
+	tmpl, err := template.New("featuresTS").Funcs(template.FuncMap{
+		"writeFileHeaderTS": writeFileHeaderTS,
+		"toPascalCase":      common.ToPascalCase,
+	}).Parse(featuresTemplateTS)
+	if err != nil {
+		return nil, fmt.Errorf("parse template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, md.Features); err != nil {
+		return nil, fmt.Errorf("execute template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func generateFeatures(logger *slog.Logger, srcDir string, md *meta.Metadata) error {
+	src, err := RenderFeatures(md)
+	if err != nil {
+		return err
+	}
+	outputFile := filepath.Join(srcDir, "Features.ts")
+	if err := os.WriteFile(outputFile, src, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", outputFile, err)
+	}
+	logger.Info("Generated Features.ts", "file", outputFile)
+	return nil
+}
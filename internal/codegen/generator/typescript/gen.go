@@ -43,6 +43,9 @@ func Generate(logger *slog.Logger, outputDir string, md *meta.Metadata) error {
 	if err := generateTypes(logger, typesDir, md); err != nil {
 		return err
 	}
+	if err := generateFeatures(logger, srcDir, md); err != nil {
+		return err
+	}
 	if err := generateClient(logger, srcDir, md); err != nil {
 		return err
 	}
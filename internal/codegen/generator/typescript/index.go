@@ -13,6 +13,7 @@ import (
 const indexTemplate = `{{writeFileHeaderTS}}
 export * from './ViiperClient';
 export * from './ViiperDevice';
+export * from './Features';
 export * as Types from './types/ManagementDtos';
 export * as Keyboard from './devices/Keyboard';
 export * as Mouse from './devices/Mouse';
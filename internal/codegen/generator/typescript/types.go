@@ -9,7 +9,6 @@ import (
 	"strings"
 	"text/template"
 
-	"github.com/Alia5/VIIPER/internal/codegen/common"
 	"github.com/Alia5/VIIPER/internal/codegen/meta"
 )
 
@@ -70,8 +69,5 @@ func fieldTypeToTS(field interface{}) string {
 		elem := strings.TrimPrefix(typeStr, "[]")
 		return goTypeToTS(elem) + "[]"
 	}
-	if typeKind == "struct" {
-		return common.ToPascalCase(typeStr)
-	}
 	return goTypeToTS(typeStr)
 }
@@ -10,4 +10,5 @@ type Metadata struct {
 	DevicePackages map[string]*scanner.DeviceConstants // device name -> constants/maps
 	WireTags       *scanner.WireTags                   // parsed viiper:wire comments
 	CTypeNames     map[string]string                   // DTO name -> C typedef name (e.g., "Device" -> "device_info")
+	Features       []scanner.FeatureInfo               // optional protocol features, in registry order
 }
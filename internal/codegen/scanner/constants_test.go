@@ -2,6 +2,7 @@ package scanner
 
 import (
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -42,9 +43,35 @@ func TestScanXbox360Constants(t *testing.T) {
 		t.Fatalf("Failed to scan xbox360 constants: %v", err)
 	}
 
-	// Should find 15 button constants
-	if len(result.Constants) != 15 {
-		t.Errorf("Expected 15 button constants, got %d", len(result.Constants))
+	// Should find 15 button constants, 10 XUSB request constants, 14 LED
+	// patterns and 2 feedback kinds
+	var buttons, xusb, leds, feedback int
+	for _, c := range result.Constants {
+		switch {
+		case strings.HasPrefix(c.Name, "Button"):
+			buttons++
+		case strings.HasPrefix(c.Name, "XUSB"):
+			xusb++
+		case strings.HasPrefix(c.Name, "LED"):
+			leds++
+		case strings.HasPrefix(c.Name, "Feedback"):
+			feedback++
+		}
+	}
+	if buttons != 15 {
+		t.Errorf("Expected 15 button constants, got %d", buttons)
+	}
+	if xusb != 10 {
+		t.Errorf("Expected 10 XUSB constants, got %d", xusb)
+	}
+	if leds != 14 {
+		t.Errorf("Expected 14 LED constants, got %d", leds)
+	}
+	if feedback != 2 {
+		t.Errorf("Expected 2 feedback constants, got %d", feedback)
+	}
+	if len(result.Constants) != buttons+xusb+leds+feedback {
+		t.Errorf("Expected %d constants, got %d", buttons+xusb+leds+feedback, len(result.Constants))
 	}
 
 	// Xbox360 has no maps
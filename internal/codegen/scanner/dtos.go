@@ -7,6 +7,7 @@ import (
 	"go/token"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 )
 
@@ -18,122 +19,367 @@ type DTOSchema struct {
 
 // FieldInfo describes a single field in a DTO.
 type FieldInfo struct {
-	Name     string `json:"name"`     // Go field name (e.g., "BusID")
-	JSONName string `json:"jsonName"` // JSON tag name (e.g., "busId")
-	Type     string `json:"type"`     // Go type (e.g., "uint32", "[]Device")
-	TypeKind string `json:"typeKind"` // Kind: "primitive", "slice", "struct"
-	Optional bool   `json:"optional"` // Whether field can be omitted (pointer or has omitempty)
+	Name      string `json:"name"`                // Go field name (e.g., "BusID")
+	JSONName  string `json:"jsonName"`            // JSON tag name (e.g., "busId")
+	Type      string `json:"type"`                // Go type (e.g., "uint32", "[]Device", "map[string]string")
+	TypeKind  string `json:"typeKind"`            // Kind: "primitive", "slice", "array", "map", "struct"
+	Optional  bool   `json:"optional"`            // Whether field can be omitted (pointer or has omitempty)
+	KeyType   string `json:"keyType,omitempty"`   // Map key type; always "string"
+	ValueType string `json:"valueType,omitempty"` // Map value type (e.g., "string", "[]Device")
+	Elem      string `json:"elem,omitempty"`      // DTO the type refers to, through pointers, slices and map values
+	Cyclic    bool   `json:"cyclic,omitempty"`    // Elem leads back to the DTO holding the field
 }
 
 // ScanDTOs scans a Go file containing DTO struct definitions and extracts their schemas.
 func ScanDTOs(filePath string) ([]DTOSchema, error) {
-	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, filePath, nil, parser.ParseComments)
+	return scanDTOFiles([]string{filePath})
+}
+
+// ScanDTOsInPackage scans all Go files in a package and extracts DTO schemas.
+// Embedded structs may come from any file of the package.
+func ScanDTOsInPackage(pkgPath string) ([]DTOSchema, error) {
+	matches, err := filepath.Glob(filepath.Join(pkgPath, "*.go"))
 	if err != nil {
-		return nil, fmt.Errorf("parse file: %w", err)
+		return nil, fmt.Errorf("glob package files: %w", err)
 	}
 
-	var schemas []DTOSchema
-
-	ast.Inspect(node, func(n ast.Node) bool {
-		genDecl, ok := n.(*ast.GenDecl)
-		if !ok || genDecl.Tok != token.TYPE {
-			return true
+	var files []string
+	for _, file := range matches {
+		if !strings.HasSuffix(file, "_test.go") {
+			files = append(files, file)
 		}
+	}
+	return scanDTOFiles(files)
+}
 
-		for _, spec := range genDecl.Specs {
-			typeSpec, ok := spec.(*ast.TypeSpec)
-			if !ok {
-				continue
-			}
+// dtoScanner turns the struct types of a package into schemas. Anonymous
+// struct types are hoisted into schemas of their own, named after the
+// enclosing type and field (e.g. BusSummary.Stats -> BusSummaryStats).
+type dtoScanner struct {
+	structs map[string]*ast.StructType
+	schemas []DTOSchema
+}
 
-			structType, ok := typeSpec.Type.(*ast.StructType)
-			if !ok {
-				continue
-			}
+// dtoField is a field before encoding/json's rules for promoted fields are
+// applied; depth counts the embedded structs it was promoted through.
+type dtoField struct {
+	FieldInfo
+	depth  int
+	tagged bool
+}
 
-			schema := DTOSchema{
-				Name:   typeSpec.Name.Name,
-				Fields: []FieldInfo{},
+func scanDTOFiles(files []string) ([]DTOSchema, error) {
+	s := &dtoScanner{structs: map[string]*ast.StructType{}}
+	var names []string
+	fset := token.NewFileSet()
+	for _, file := range files {
+		node, err := parser.ParseFile(fset, file, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, fmt.Errorf("parse file: %w", err)
+		}
+		for _, decl := range node.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.TYPE {
+				continue
 			}
-
-			for _, field := range structType.Fields.List {
-				if len(field.Names) == 0 {
+			for _, spec := range genDecl.Specs {
+				typeSpec, ok := spec.(*ast.TypeSpec)
+				if !ok {
 					continue
 				}
-
-				fieldName := field.Names[0].Name
-
-				if !ast.IsExported(fieldName) {
-					continue
+				if structType, ok := typeSpec.Type.(*ast.StructType); ok {
+					s.structs[typeSpec.Name.Name] = structType
+					names = append(names, typeSpec.Name.Name)
 				}
+			}
+		}
+	}
 
-				jsonName := fieldName
-				optional := false
-				if field.Tag != nil {
-					tag := strings.Trim(field.Tag.Value, "`")
-					jsonTag := reflect.StructTag(tag).Get("json")
-					if jsonTag != "" && jsonTag != "-" {
-						parts := strings.Split(jsonTag, ",")
-						jsonName = parts[0]
-						for _, part := range parts[1:] {
-							if part == "omitempty" {
-								optional = true
-								break
-							}
-						}
-					}
-				}
+	for _, name := range names {
+		fields, err := s.collect(name, s.structs[name], 0, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		s.schemas = append(s.schemas, DTOSchema{Name: name, Fields: dominantFields(fields)})
+	}
+	resolveElems(s.schemas)
+	return s.schemas, nil
+}
 
-				typeName, typeKind := extractTypeInfo(field.Type)
+// collect returns the fields of st, with those of untagged embedded structs
+// inlined. embedding holds the structs embedded on the way to st.
+func (s *dtoScanner) collect(owner string, st *ast.StructType, depth int, embedding []string) ([]dtoField, error) {
+	var out []dtoField
+	for _, field := range st.Fields.List {
+		jsonName, omitempty, skip := jsonTag(field)
+		if skip {
+			continue
+		}
 
-				if _, isPtr := field.Type.(*ast.StarExpr); isPtr {
-					optional = true
+		if len(field.Names) == 0 {
+			typeExpr, ptr := field.Type, false
+			if star, ok := typeExpr.(*ast.StarExpr); ok {
+				typeExpr, ptr = star.X, true
+			}
+			ident, ok := typeExpr.(*ast.Ident)
+			if !ok {
+				return nil, fmt.Errorf("embedded %s: only structs of the same package can be embedded", exprString(field.Type))
+			}
+			embedded, isStruct := s.structs[ident.Name]
+			if jsonName != "" || !isStruct {
+				// A tagged embedded struct, or an embedded non-struct, is
+				// encoded as a field named after its type.
+				if !ast.IsExported(ident.Name) {
+					continue
 				}
-
-				schema.Fields = append(schema.Fields, FieldInfo{
-					Name:     fieldName,
-					JSONName: jsonName,
-					Type:     typeName,
-					TypeKind: typeKind,
-					Optional: optional,
-				})
+				f, err := s.field(owner, ident.Name, jsonName, field.Type, omitempty)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, dtoField{FieldInfo: f, depth: depth, tagged: jsonName != ""})
+				continue
 			}
+			if slices.Contains(embedding, ident.Name) {
+				return nil, fmt.Errorf("%s embeds itself", ident.Name)
+			}
+			inner, err := s.collect(owner, embedded, depth+1, append(embedding, ident.Name))
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range inner {
+				// A nil embedded pointer omits all of its fields.
+				f.Optional = f.Optional || ptr
+				out = append(out, f)
+			}
+			continue
+		}
 
-			schemas = append(schemas, schema)
+		for _, name := range field.Names {
+			if !ast.IsExported(name.Name) {
+				continue
+			}
+			f, err := s.field(owner, name.Name, jsonName, field.Type, omitempty)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, dtoField{FieldInfo: f, depth: depth, tagged: jsonName != ""})
 		}
+	}
+	return out, nil
+}
 
-		return true
-	})
+func (s *dtoScanner) field(owner, name, jsonName string, expr ast.Expr, omitempty bool) (FieldInfo, error) {
+	if jsonName == "" {
+		jsonName = name
+	}
+	f := FieldInfo{Name: name, JSONName: jsonName, Optional: omitempty}
+	if _, isPtr := expr.(*ast.StarExpr); isPtr {
+		f.Optional = true
+	}
 
-	return schemas, nil
+	var err error
+	f.Type, f.TypeKind, err = s.typeInfo(owner+name, expr)
+	if err != nil {
+		return FieldInfo{}, fmt.Errorf("field %s: %w", name, err)
+	}
+	if f.TypeKind == "map" {
+		f.KeyType = "string"
+		f.ValueType = strings.TrimPrefix(f.Type, "map[string]")
+	}
+	return f, nil
 }
 
-func extractTypeInfo(expr ast.Expr) (typeName string, typeKind string) {
+// typeInfo returns the type name and kind of expr. An anonymous struct type
+// becomes the schema hoistName.
+func (s *dtoScanner) typeInfo(hoistName string, expr ast.Expr) (typeName string, typeKind string, err error) {
 	switch t := expr.(type) {
 	case *ast.Ident:
-		return t.Name, determineTypeKind(t.Name)
+		return t.Name, determineTypeKind(t.Name), nil
 	case *ast.StarExpr:
-		innerType, innerKind := extractTypeInfo(t.X)
-		return "*" + innerType, innerKind
+		innerType, innerKind, err := s.typeInfo(hoistName, t.X)
+		return "*" + innerType, innerKind, err
 	case *ast.ArrayType:
+		elemType, _, err := s.typeInfo(hoistName, t.Elt)
 		if t.Len == nil {
-			elemType, _ := extractTypeInfo(t.Elt)
-			return "[]" + elemType, "slice"
+			return "[]" + elemType, "slice", err
 		}
-		elemType, _ := extractTypeInfo(t.Elt)
-		return "[]" + elemType, "array"
+		return "[]" + elemType, "array", err
 	case *ast.SelectorExpr:
 		if ident, ok := t.X.(*ast.Ident); ok {
-			return ident.Name + "." + t.Sel.Name, "struct"
+			return ident.Name + "." + t.Sel.Name, "struct", nil
 		}
-		return t.Sel.Name, "struct"
+		return t.Sel.Name, "struct", nil
 	case *ast.MapType:
-		keyType, _ := extractTypeInfo(t.Key)
-		valueType, _ := extractTypeInfo(t.Value)
-		return "map[" + keyType + "]" + valueType, "map"
+		// encoding/json also takes integer keys, but the SDKs model maps
+		// as JSON objects keyed by name.
+		if key, ok := t.Key.(*ast.Ident); !ok || key.Name != "string" {
+			return "", "", fmt.Errorf("map key %s: only string keys are supported", exprString(t.Key))
+		}
+		valueType, _, err := s.typeInfo(hoistName, t.Value)
+		return "map[string]" + valueType, "map", err
+	case *ast.StructType:
+		if err := s.hoist(hoistName, t); err != nil {
+			return "", "", err
+		}
+		return hoistName, "struct", nil
+	case *ast.InterfaceType:
+		return "any", determineTypeKind("any"), nil
+	default:
+		return "unknown", "unknown", nil
+	}
+}
+
+func (s *dtoScanner) hoist(name string, st *ast.StructType) error {
+	if _, ok := s.structs[name]; ok {
+		return fmt.Errorf("anonymous struct would be named %s, which is taken", name)
+	}
+	s.structs[name] = st
+	fields, err := s.collect(name, st, 0, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	s.schemas = append(s.schemas, DTOSchema{Name: name, Fields: dominantFields(fields)})
+	return nil
+}
+
+// dominantFields applies encoding/json's rule for fields sharing a JSON
+// name: the shallowest wins, a tagged one among equally shallow ones wins,
+// and remaining ties drop the name altogether.
+func dominantFields(fields []dtoField) []FieldInfo {
+	byName := map[string][]dtoField{}
+	for _, f := range fields {
+		byName[f.JSONName] = append(byName[f.JSONName], f)
+	}
+	out := []FieldInfo{}
+	for _, f := range fields {
+		candidates := byName[f.JSONName]
+		if candidates == nil {
+			continue // decided already
+		}
+		delete(byName, f.JSONName)
+		minDepth := slices.MinFunc(candidates, func(a, b dtoField) int { return a.depth - b.depth }).depth
+		var shallow, tagged []dtoField
+		for _, c := range candidates {
+			if c.depth == minDepth {
+				shallow = append(shallow, c)
+				if c.tagged {
+					tagged = append(tagged, c)
+				}
+			}
+		}
+		switch {
+		case len(shallow) == 1:
+			out = append(out, shallow[0].FieldInfo)
+		case len(tagged) == 1:
+			out = append(out, tagged[0].FieldInfo)
+		}
+	}
+	return out
+}
+
+// resolveElems sets Elem and Cyclic on the fields of schemas.
+func resolveElems(schemas []DTOSchema) {
+	refs := map[string][]string{}
+	for i := range schemas {
+		refs[schemas[i].Name] = nil
+	}
+	for i := range schemas {
+		for j := range schemas[i].Fields {
+			f := &schemas[i].Fields[j]
+			base := f.Type
+			for {
+				trimmed := strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(base, "*"), "[]"), "map[string]")
+				if trimmed == base {
+					break
+				}
+				base = trimmed
+			}
+			if _, ok := refs[base]; ok {
+				f.Elem = base
+				refs[schemas[i].Name] = append(refs[schemas[i].Name], base)
+			}
+		}
+	}
+
+	reaches := func(from, to string) bool {
+		seen := map[string]bool{}
+		stack := []string{from}
+		for len(stack) > 0 {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if n == to {
+				return true
+			}
+			if seen[n] {
+				continue
+			}
+			seen[n] = true
+			stack = append(stack, refs[n]...)
+		}
+		return false
+	}
+	for i := range schemas {
+		for j := range schemas[i].Fields {
+			f := &schemas[i].Fields[j]
+			f.Cyclic = f.Elem != "" && reaches(f.Elem, schemas[i].Name)
+		}
+	}
+}
+
+// OrderDTOs returns dtos with every DTO after those its fields refer to, for
+// languages that need a type defined before it is used by value. Cyclic
+// references are left out; the order is otherwise kept.
+func OrderDTOs(dtos []DTOSchema) []DTOSchema {
+	index := map[string]int{}
+	for i, dto := range dtos {
+		index[dto.Name] = i
+	}
+	done := make([]bool, len(dtos))
+	ordered := make([]DTOSchema, 0, len(dtos))
+	var visit func(i int)
+	visit = func(i int) {
+		if done[i] {
+			return
+		}
+		done[i] = true
+		for _, f := range dtos[i].Fields {
+			if j, ok := index[f.Elem]; ok && !f.Cyclic {
+				visit(j)
+			}
+		}
+		ordered = append(ordered, dtos[i])
+	}
+	for i := range dtos {
+		visit(i)
+	}
+	return ordered
+}
+
+// jsonTag returns the name and omitempty option of a field's json tag, and
+// whether the tag is "-".
+func jsonTag(field *ast.Field) (name string, omitempty bool, skip bool) {
+	if field.Tag == nil {
+		return "", false, false
+	}
+	tag := strings.Trim(field.Tag.Value, "`")
+	jsonTag := reflect.StructTag(tag).Get("json")
+	if jsonTag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(jsonTag, ",")
+	return parts[0], slices.Contains(parts[1:], "omitempty"), false
+}
+
+func exprString(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.StarExpr:
+		return "*" + exprString(t.X)
+	case *ast.SelectorExpr:
+		return exprString(t.X) + "." + t.Sel.Name
 	default:
-		return "unknown", "unknown"
+		return fmt.Sprintf("%T", expr)
 	}
 }
 
@@ -164,26 +410,3 @@ func determineTypeKind(typeName string) string {
 	}
 	return "struct"
 }
-
-// ScanDTOsInPackage scans all Go files in a package and extracts DTO schemas.
-func ScanDTOsInPackage(pkgPath string) ([]DTOSchema, error) {
-	matches, err := filepath.Glob(filepath.Join(pkgPath, "*.go"))
-	if err != nil {
-		return nil, fmt.Errorf("glob package files: %w", err)
-	}
-
-	var allSchemas []DTOSchema
-	for _, file := range matches {
-		if strings.HasSuffix(file, "_test.go") {
-			continue
-		}
-
-		schemas, err := ScanDTOs(file)
-		if err != nil {
-			return nil, fmt.Errorf("scan %s: %w", file, err)
-		}
-		allSchemas = append(allSchemas, schemas...)
-	}
-
-	return allSchemas, nil
-}
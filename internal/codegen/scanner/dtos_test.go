@@ -2,6 +2,9 @@ package scanner
 
 import (
 	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -90,3 +93,139 @@ func TestScanDTOs(t *testing.T) {
 		}
 	}
 }
+
+func scanFixtureDTOs(t *testing.T) map[string]DTOSchema {
+	t.Helper()
+	schemas, err := ScanDTOsInPackage(filepath.Join("testdata", "dtos"))
+	if err != nil {
+		t.Fatalf("ScanDTOsInPackage failed: %v", err)
+	}
+	byName := map[string]DTOSchema{}
+	for _, schema := range schemas {
+		byName[schema.Name] = schema
+	}
+	return byName
+}
+
+func fieldsByJSONName(schema DTOSchema) map[string]FieldInfo {
+	fields := map[string]FieldInfo{}
+	for _, f := range schema.Fields {
+		fields[f.JSONName] = f
+	}
+	return fields
+}
+
+func TestScanDTOsNestedAndMaps(t *testing.T) {
+	person, ok := scanFixtureDTOs(t)["Person"]
+	if !ok {
+		t.Fatal("Person not scanned")
+	}
+
+	want := map[string]FieldInfo{
+		"name":   {Name: "Name", JSONName: "name", Type: "string", TypeKind: "primitive"},
+		"home":   {Name: "Home", JSONName: "home", Type: "Address", TypeKind: "struct", Elem: "Address"},
+		"work":   {Name: "Work", JSONName: "work", Type: "*Address", TypeKind: "struct", Optional: true, Elem: "Address"},
+		"past":   {Name: "Past", JSONName: "past", Type: "[]Address", TypeKind: "slice", Elem: "Address"},
+		"labels": {Name: "Labels", JSONName: "labels", Type: "map[string]string", TypeKind: "map", KeyType: "string", ValueType: "string"},
+		"byName": {Name: "ByName", JSONName: "byName", Type: "map[string]Address", TypeKind: "map", KeyType: "string", ValueType: "Address", Elem: "Address"},
+		"extra":  {Name: "Extra", JSONName: "extra", Type: "map[string]any", TypeKind: "map", KeyType: "string", ValueType: "any"},
+		"stats":  {Name: "Stats", JSONName: "stats", Type: "PersonStats", TypeKind: "struct", Elem: "PersonStats"},
+	}
+	if got := fieldsByJSONName(person); !reflect.DeepEqual(got, want) {
+		t.Errorf("Person fields:\n got  %+v\n want %+v", got, want)
+	}
+}
+
+func TestScanDTOsHoistsAnonymousStructs(t *testing.T) {
+	stats, ok := scanFixtureDTOs(t)["PersonStats"]
+	if !ok {
+		t.Fatal("anonymous struct of Person.Stats not hoisted as PersonStats")
+	}
+	if len(stats.Fields) != 1 || stats.Fields[0].JSONName != "visits" || stats.Fields[0].Type != "int" {
+		t.Errorf("PersonStats fields: %+v", stats.Fields)
+	}
+}
+
+func TestScanDTOsEmbedded(t *testing.T) {
+	record, ok := scanFixtureDTOs(t)["Record"]
+	if !ok {
+		t.Fatal("Record not scanned")
+	}
+
+	var names []string
+	for _, f := range record.Fields {
+		names = append(names, f.JSONName)
+	}
+	// Base and *Audit are inlined, the tagged Address is not, Left.Tie and
+	// Right.Tie cancel out and Record.Shadow hides Base.Shadow.
+	if want := []string{"id", "created", "shadow", "by", "address"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("Record JSON names = %v, want %v", names, want)
+	}
+
+	fields := fieldsByJSONName(record)
+	if fields["id"].Optional {
+		t.Error("id promoted from Base should not be optional")
+	}
+	if !fields["by"].Optional {
+		t.Error("by promoted from *Audit should be optional")
+	}
+	if f := fields["shadow"]; f.Name != "Shadow" || f.Optional {
+		t.Errorf("shadow = %+v, want Record's own field", f)
+	}
+	if f := fields["address"]; f.Type != "Address" || f.Elem != "Address" {
+		t.Errorf("address = %+v, want a field of type Address", f)
+	}
+}
+
+func TestScanDTOsCycles(t *testing.T) {
+	schemas := scanFixtureDTOs(t)
+
+	node := fieldsByJSONName(schemas["Node"])
+	for _, name := range []string{"children", "parent", "index"} {
+		if !node[name].Cyclic {
+			t.Errorf("Node.%s should be cyclic: %+v", name, node[name])
+		}
+	}
+	if node["value"].Cyclic {
+		t.Error("Node.value should not be cyclic")
+	}
+	if owner := fieldsByJSONName(schemas["Leaf"])["owner"]; !owner.Cyclic || owner.Elem != "Node" {
+		t.Errorf("Leaf.owner = %+v, want cyclic reference to Node", owner)
+	}
+	if home := fieldsByJSONName(schemas["Person"])["home"]; home.Cyclic {
+		t.Error("Person.home should not be cyclic")
+	}
+}
+
+func TestScanDTOsRejectsNonStringMapKeys(t *testing.T) {
+	_, err := ScanDTOsInPackage(filepath.Join("testdata", "intkeys"))
+	if err == nil {
+		t.Fatal("expected an error for map[int]string")
+	}
+	if !strings.Contains(err.Error(), "Slots") || !strings.Contains(err.Error(), "ByIndex") {
+		t.Errorf("error should name the type and field: %v", err)
+	}
+}
+
+func TestOrderDTOs(t *testing.T) {
+	schemas, err := ScanDTOsInPackage(filepath.Join("testdata", "dtos"))
+	if err != nil {
+		t.Fatalf("ScanDTOsInPackage failed: %v", err)
+	}
+	ordered := OrderDTOs(schemas)
+	if len(ordered) != len(schemas) {
+		t.Fatalf("OrderDTOs returned %d DTOs, want %d", len(ordered), len(schemas))
+	}
+
+	pos := map[string]int{}
+	for i, dto := range ordered {
+		pos[dto.Name] = i
+	}
+	for _, dto := range ordered {
+		for _, f := range dto.Fields {
+			if f.Elem != "" && !f.Cyclic && pos[f.Elem] > pos[dto.Name] {
+				t.Errorf("%s comes before %s, which its field %s uses", dto.Name, f.Elem, f.Name)
+			}
+		}
+	}
+}
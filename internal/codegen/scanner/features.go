@@ -0,0 +1,115 @@
+package scanner
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FeatureInfo is one entry of the optional protocol feature registry.
+type FeatureInfo struct {
+	Name        string `json:"name"`
+	Since       string `json:"since"`
+	Negotiation string `json:"negotiation"`
+}
+
+// ScanFeatures reads the Features registry (apitypes.Features) from a package.
+// Entry fields must be string literals or string constants of that package.
+func ScanFeatures(pkgPath string) ([]FeatureInfo, error) {
+	entries, err := os.ReadDir(pkgPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %w", pkgPath, err)
+	}
+
+	fset := token.NewFileSet()
+	consts := map[string]string{}
+	var registry *ast.CompositeLit
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".go") || strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+		file, err := parseFile(fset, filepath.Join(pkgPath, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", entry.Name(), err)
+		}
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || (genDecl.Tok != token.CONST && genDecl.Tok != token.VAR) {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				vs, ok := spec.(*ast.ValueSpec)
+				if !ok {
+					continue
+				}
+				for i, name := range vs.Names {
+					if i >= len(vs.Values) {
+						continue
+					}
+					switch {
+					case genDecl.Tok == token.CONST:
+						if lit, ok := vs.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+							consts[name.Name], _ = strconv.Unquote(lit.Value)
+						}
+					case name.Name == "Features":
+						registry, _ = vs.Values[i].(*ast.CompositeLit)
+					}
+				}
+			}
+		}
+	}
+	if registry == nil {
+		return nil, fmt.Errorf("no Features registry in %s", pkgPath)
+	}
+
+	stringOf := func(expr ast.Expr) (string, error) {
+		switch e := expr.(type) {
+		case *ast.BasicLit:
+			if e.Kind == token.STRING {
+				return strconv.Unquote(e.Value)
+			}
+		case *ast.Ident:
+			if v, ok := consts[e.Name]; ok {
+				return v, nil
+			}
+		}
+		return "", fmt.Errorf("feature field %s is not a string literal or constant", exprToString(expr))
+	}
+
+	var features []FeatureInfo
+	for _, elt := range registry.Elts {
+		lit, ok := elt.(*ast.CompositeLit)
+		if !ok {
+			return nil, fmt.Errorf("feature entry %d is not a composite literal", len(features))
+		}
+		var f FeatureInfo
+		for _, field := range lit.Elts {
+			kv, ok := field.(*ast.KeyValueExpr)
+			if !ok {
+				return nil, fmt.Errorf("feature entry %d must use keyed fields", len(features))
+			}
+			key, _ := kv.Key.(*ast.Ident)
+			if key == nil {
+				continue
+			}
+			v, err := stringOf(kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			switch key.Name {
+			case "Name":
+				f.Name = v
+			case "Since":
+				f.Since = v
+			case "Negotiation":
+				f.Negotiation = v
+			}
+		}
+		features = append(features, f)
+	}
+	return features, nil
+}
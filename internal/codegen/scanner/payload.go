@@ -10,7 +10,8 @@ import (
 )
 
 // ScanHandlerPayloadInfo analyzes handler functions to infer payload semantics (kind, required, parser hints).
-// It complements JSON payload detection; if both numeric and JSON patterns appear JSON wins.
+// It complements JSON payload detection; if both numeric and JSON patterns appear JSON wins, unless the
+// handler tells them apart by a leading "{": the payload is then numeric with the JSON DTO as its JSONForm.
 func ScanHandlerPayloadInfo(pkgPath string) (map[string]PayloadInfo, error) {
 	matches, err := filepath.Glob(filepath.Join(pkgPath, "*.go"))
 	if err != nil {
@@ -83,6 +84,7 @@ func scanPayloadFile(filePath string, acc map[string]PayloadInfo) error {
 		hasJSON := false
 		hasNumeric := false
 		hasDirectUse := false
+		hasObjectCheck := false
 		numericBitSize := ""
 		jsonTargetType := ""
 
@@ -136,6 +138,9 @@ func scanPayloadFile(filePath string, acc map[string]PayloadInfo) error {
 					hasNumeric = true
 					numericBitSize = inferNumericBitSize(call)
 				}
+				if isObjectPrefixCheck(call) {
+					hasObjectCheck = true
+				}
 				if isFmtSscanf(call) && fmtSscanfUsesPayload(call) {
 					hasNumeric = true
 					if numericBitSize == "" {
@@ -166,6 +171,14 @@ func scanPayloadFile(filePath string, acc map[string]PayloadInfo) error {
 
 		// Determine kind precedence: JSON > Numeric > String > None
 		switch {
+		case hasJSON && hasNumeric && hasObjectCheck:
+			pi.Kind = PayloadNumeric
+			pi.Required = hasEmptyError || !hasNonEmptyBranch
+			if numericBitSize != "" {
+				pi.ParserHint, pi.RawType = numericBitSize, numericBitSize
+			}
+			pi.JSONForm = jsonTargetType
+			pi.Notes = "number, or a JSON object"
 		case hasJSON:
 			pi.Kind = PayloadJSON
 			pi.Required = hasEmptyError || !hasNonEmptyBranch // current JSON always required
@@ -356,6 +369,20 @@ func originatesFromPayload(expr ast.Expr) bool {
 	return false
 }
 
+// isObjectPrefixCheck detects strings.HasPrefix(<payload>, "{"), which tells a
+// JSON object payload from a bare value.
+func isObjectPrefixCheck(call *ast.CallExpr) bool {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "HasPrefix" || len(call.Args) != 2 {
+		return false
+	}
+	if ident, ok := sel.X.(*ast.Ident); !ok || ident.Name != "strings" {
+		return false
+	}
+	lit, ok := call.Args[1].(*ast.BasicLit)
+	return ok && lit.Kind == token.STRING && lit.Value == `"{"` && originatesFromPayload(call.Args[0])
+}
+
 func isFmtSscanf(call *ast.CallExpr) bool {
 	sel, ok := call.Fun.(*ast.SelectorExpr)
 	if !ok {
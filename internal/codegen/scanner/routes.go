@@ -11,12 +11,14 @@ import (
 
 // RouteInfo describes a discovered API route.
 type RouteInfo struct {
-	Path        string            `json:"path"`        // e.g., "bus/{id}/list"
-	Method      string            `json:"method"`      // "Register" or "RegisterStream"
-	Handler     string            `json:"handler"`     // e.g., "BusList"
-	PathParams  map[string]string `json:"pathParams"`  // e.g., {"id": "string"}
-	ResponseDTO string            `json:"responseDTO"` // Name of DTO type returned (e.g., "BusListResponse"), empty if none
-	Payload     PayloadInfo       `json:"payload"`     // payload classification
+	Path        string            `json:"path"`               // e.g., "bus/{id}/list"
+	Method      string            `json:"method"`             // "Register" or "RegisterStream"
+	Handler     string            `json:"handler"`            // e.g., "BusList"
+	PathParams  map[string]string `json:"pathParams"`         // e.g., {"id": "string"}
+	ResponseDTO string            `json:"responseDTO"`        // Name of DTO type returned (e.g., "BusListResponse"), empty if none
+	Payload     PayloadInfo       `json:"payload"`            // payload classification
+	Mutating    bool              `json:"mutating,omitempty"` // changes state; refused while the server is read-only
+	Admin       bool              `json:"admin,omitempty"`    // configures the server; localhost only
 }
 
 // PayloadKind enumerates recognized payload semantics.
@@ -35,6 +37,7 @@ type PayloadInfo struct {
 	Required   bool        `json:"required"`             // true if handler rejects empty payload
 	ParserHint string      `json:"parserHint,omitempty"` // e.g., uint32, DeviceCreateRequest, deviceID
 	RawType    string      `json:"rawType,omitempty"`    // Underlying Go type name for JSON / numeric width
+	JSONForm   string      `json:"jsonForm,omitempty"`   // DTO also accepted as a JSON object in place of a numeric payload
 	Notes      string      `json:"notes,omitempty"`      // Additional guidance for generators
 }
 
@@ -79,12 +82,21 @@ func ScanRoutes(filePath string) ([]RouteInfo, error) {
 
 		pathParams := extractPathParams(path)
 
-		routes = append(routes, RouteInfo{
+		route := RouteInfo{
 			Path:       path,
 			Method:     methodName,
 			Handler:    handlerName,
 			PathParams: pathParams,
-		})
+		}
+		for _, arg := range callExpr.Args[2:] {
+			switch routeFlagName(arg) {
+			case "Mutating":
+				route.Mutating = true
+			case "Admin":
+				route.Admin = true
+			}
+		}
+		routes = append(routes, route)
 
 		return true
 	})
@@ -110,6 +122,17 @@ func extractHandlerName(expr ast.Expr) string {
 	}
 }
 
+// routeFlagName returns the name of a route flag argument like api.Mutating.
+func routeFlagName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	case *ast.Ident:
+		return e.Name
+	}
+	return ""
+}
+
 // extractPathParams parses a route pattern like "bus/{id}/list" and returns
 // a map of parameter names to their types (currently all "string").
 func extractPathParams(pattern string) map[string]string {
@@ -169,5 +192,30 @@ func EnrichRoutesWithHandlerInfo(routes []RouteInfo, handlerPkgPath string) ([]R
 			enriched[i].Payload = PayloadInfo{Kind: PayloadNone, Required: false}
 		}
 	}
-	return enriched, nil
+	return withJSONForms(enriched), nil
+}
+
+// withJSONForms follows each route whose numeric payload has a JSON form with
+// a route of the same path taking that form, named after the handler plus
+// "With" (bus/create: BusCreate and BusCreateWith), so generators emit a
+// method for either payload.
+func withJSONForms(routes []RouteInfo) []RouteInfo {
+	out := make([]RouteInfo, 0, len(routes))
+	for _, route := range routes {
+		out = append(out, route)
+		if route.Payload.JSONForm == "" {
+			continue
+		}
+		alt := route
+		alt.Handler += "With"
+		alt.Payload = PayloadInfo{
+			Kind:       PayloadJSON,
+			Required:   true,
+			ParserHint: route.Payload.JSONForm,
+			RawType:    route.Payload.JSONForm,
+			Notes:      fmt.Sprintf("JSON form of the %s payload", route.Handler),
+		}
+		out = append(out, alt)
+	}
+	return out
 }
@@ -60,7 +60,10 @@ func TestScannerSuite(t *testing.T) {
 				}
 				seen := map[string]RouteInfo{}
 				for _, r := range enriched {
-					seen[r.Path] = r
+					seen[r.Handler] = r
+					if _, ok := seen[r.Path]; !ok {
+						seen[r.Path] = r
+					}
 				}
 				assertPayload := func(path string, kind PayloadKind, required bool) {
 					v, ok := seen[path]
@@ -74,10 +77,18 @@ func TestScannerSuite(t *testing.T) {
 				}
 				assertPayload("bus/{id}/add", PayloadJSON, true)
 				assertPayload("bus/create", PayloadNumeric, false)
+				assertPayload("BusCreateWith", PayloadJSON, true)
+				if got := seen["bus/create"].Payload.JSONForm; got != "BusCreateRequest" {
+					t.Errorf("bus/create expected JSON form BusCreateRequest, got %q", got)
+				}
+				if got := seen["BusCreateWith"]; got.Path != "bus/create" || got.Payload.RawType != "BusCreateRequest" {
+					t.Errorf("BusCreateWith expected the JSON form of bus/create, got %+v", got)
+				}
 				assertPayload("bus/remove", PayloadNumeric, true)
 				assertPayload("bus/{id}/remove", PayloadString, true)
 				assertPayload("bus/list", PayloadNone, false)
 				assertPayload("bus/{id}/list", PayloadNone, false)
+				assertPayload("bus/{id}/label", PayloadJSON, true)
 			},
 		},
 	}
@@ -0,0 +1,60 @@
+// Package dtos holds the DTO shapes the scanner tests run against.
+package dtos
+
+type Address struct {
+	Street string `json:"street"`
+	City   string `json:"city,omitempty"`
+}
+
+type Person struct {
+	Name   string             `json:"name"`
+	Home   Address            `json:"home"`
+	Work   *Address           `json:"work"`
+	Past   []Address          `json:"past"`
+	Labels map[string]string  `json:"labels"`
+	ByName map[string]Address `json:"byName"`
+	Extra  map[string]any     `json:"extra"`
+	Stats  struct {
+		Visits int `json:"visits"`
+	} `json:"stats"`
+	Ignored string `json:"-"`
+	private string
+}
+
+type Base struct {
+	ID      string `json:"id"`
+	Created int64  `json:"created"`
+	Shadow  string `json:"shadow"`
+}
+
+type Audit struct {
+	By string `json:"by"`
+}
+
+type Left struct {
+	Tie string `json:"tie"`
+}
+
+type Right struct {
+	Tie string `json:"tie"`
+}
+
+type Record struct {
+	Base
+	*Audit
+	Address `json:"address"`
+	Left
+	Right
+	Shadow string `json:"shadow"`
+}
+
+type Node struct {
+	Value    string          `json:"value"`
+	Children []Node          `json:"children"`
+	Parent   *Node           `json:"parent,omitempty"`
+	Index    map[string]Leaf `json:"index"`
+}
+
+type Leaf struct {
+	Owner *Node `json:"owner"`
+}
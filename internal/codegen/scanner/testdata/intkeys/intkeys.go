@@ -0,0 +1,5 @@
+package intkeys
+
+type Slots struct {
+	ByIndex map[int]string `json:"byIndex"`
+}
@@ -0,0 +1,8 @@
+package badenum
+
+const ModeIdle = 0
+
+// viiper:wire badenum c2s mode:enum:u8:Gear
+type InputState struct {
+	Mode uint8
+}
@@ -0,0 +1,27 @@
+package gadget
+
+const (
+	ModeIdle  uint8 = 0
+	ModeSpin  uint8 = 1
+	ModeBrake uint8 = 2
+)
+
+const (
+	LightOff   uint16 = 0
+	LightDim   uint16 = 0x100
+	LightFull  uint16 = 0x200
+	LightFlash uint16 = 0x300
+)
+
+// viiper:wire gadget c2s mode:enum:u8:Mode armed:bool speed:u16:0..500 bad:enum:u8 worse:enum:f32:Mode
+type InputState struct {
+	Mode  uint8
+	Armed bool
+	Speed uint16
+}
+
+// viiper:wire gadget s2c light:enum:u16:Light fault:bool
+type OutputState struct {
+	Light uint16
+	Fault bool
+}
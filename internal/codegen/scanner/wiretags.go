@@ -2,19 +2,23 @@ package scanner
 
 import (
 	"fmt"
+	"go/ast"
 	"go/parser"
 	"go/token"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 )
 
 // WireField represents a single field in a wire protocol struct
 type WireField struct {
-	Name string `json:"name"` // Field name (e.g., "modifiers", "keys")
-	Type string `json:"type"` // Wire type token (e.g., "u8", "i16", may include array marker like "u8*count")
-	Spec string `json:"spec"` // Full spec from tag (e.g., "keys:u8*count")
+	Name  string `json:"name"`            // Field name (e.g., "modifiers", "keys")
+	Type  string `json:"type"`            // Wire type token (e.g., "u8", "i16", "bool", may include array marker like "u8*count")
+	Range string `json:"range,omitempty"` // Optional value range "min..max" or valid set "a|b|c"
+	Enum  string `json:"enum,omitempty"`  // Constant prefix naming the values of an enum field; Type is its base
+	Spec  string `json:"spec"`            // Full spec from tag (e.g., "keys:u8*count", "dpad:u8:0..15", "mode:enum:u8:Mode")
 }
 
 // WireTag represents a parsed viiper:wire comment
@@ -22,6 +26,7 @@ type WireTag struct {
 	Device    string      `json:"device"`    // "keyboard", "mouse", "xbox360"
 	Direction string      `json:"direction"` // "c2s" or "s2c"
 	Fields    []WireField `json:"fields"`
+	GoType    string      `json:"-"` // type the tag documents (e.g. "InputState"), "" if none
 }
 
 // WireTags holds all wire tags for all devices
@@ -32,7 +37,8 @@ type WireTags struct {
 // wireTagPattern matches: viiper:wire <device> <direction> field:type ...
 var wireTagPattern = regexp.MustCompile(`viiper:wire\s+(\w+)\s+(c2s|s2c)\s+(.+)`)
 
-// ScanWireTags scans all device packages for viiper:wire comments
+// ScanWireTags scans all device packages for viiper:wire comments.
+// Enum fields must name a prefix of constants declared in the same package.
 func ScanWireTags(devicePkgPaths []string) (*WireTags, error) {
 	result := &WireTags{
 		Tags: make(map[string]map[string]*WireTag),
@@ -45,6 +51,8 @@ func ScanWireTags(devicePkgPaths []string) (*WireTags, error) {
 		}
 
 		fset := token.NewFileSet()
+		var pkgTags []*WireTag
+		var consts []string
 		for _, entry := range entries {
 			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".go") {
 				continue
@@ -56,9 +64,13 @@ func ScanWireTags(devicePkgPaths []string) (*WireTags, error) {
 				continue
 			}
 
+			consts = append(consts, constNames(file)...)
+			docTypes := typeDocs(file)
 			for _, commentGroup := range file.Comments {
 				for _, comment := range commentGroup.List {
 					if tag := parseWireTag(comment.Text); tag != nil {
+						tag.GoType = docTypes[commentGroup]
+						pkgTags = append(pkgTags, tag)
 						if result.Tags[tag.Device] == nil {
 							result.Tags[tag.Device] = make(map[string]*WireTag)
 						}
@@ -67,11 +79,62 @@ func ScanWireTags(devicePkgPaths []string) (*WireTags, error) {
 				}
 			}
 		}
+
+		for _, tag := range pkgTags {
+			for _, field := range tag.Fields {
+				if field.Enum == "" {
+					continue
+				}
+				if !slices.ContainsFunc(consts, func(name string) bool {
+					return len(name) > len(field.Enum) && strings.HasPrefix(name, field.Enum)
+				}) {
+					return nil, fmt.Errorf("%s %s field %s: no constants with prefix %s in %s", tag.Device, tag.Direction, field.Name, field.Enum, pkgPath)
+				}
+			}
+		}
 	}
 
 	return result, nil
 }
 
+// constNames returns the names of the constants declared in file.
+func constNames(file *ast.File) []string {
+	var names []string
+	for _, decl := range file.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			for _, name := range spec.(*ast.ValueSpec).Names {
+				names = append(names, name.Name)
+			}
+		}
+	}
+	return names
+}
+
+// typeDocs maps the doc comments of the type declarations in file to the
+// declared type names.
+func typeDocs(file *ast.File) map[*ast.CommentGroup]string {
+	docs := make(map[*ast.CommentGroup]string)
+	for _, decl := range file.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if ts.Doc != nil {
+				docs[ts.Doc] = ts.Name.Name
+			} else if gd.Doc != nil && len(gd.Specs) == 1 {
+				docs[gd.Doc] = ts.Name.Name
+			}
+		}
+	}
+	return docs
+}
+
 // parseWireTag parses a single viiper:wire comment line
 func parseWireTag(comment string) *WireTag {
 	text := strings.TrimSpace(strings.TrimPrefix(comment, "//"))
@@ -102,20 +165,38 @@ func parseWireTag(comment string) *WireTag {
 	return tag
 }
 
+// parseWireField parses name:type[:range]. Enum fields are written
+// name:enum:<base>:<Prefix>, where base is an integer wire type.
 func parseWireField(spec string) *WireField {
-	parts := strings.SplitN(spec, ":", 2)
-	if len(parts) != 2 {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) < 2 {
 		return nil
 	}
+	if parts[1] == "enum" {
+		enum := strings.Split(spec, ":")
+		if len(enum) != 4 || !isIntegerWireType(enum[2]) || enum[3] == "" {
+			return nil
+		}
+		return &WireField{Name: enum[0], Type: enum[2], Enum: enum[3], Spec: spec}
+	}
 
-	name := parts[0]
-	typeSpec := parts[1]
-
-	return &WireField{
-		Name: name,
-		Type: typeSpec,
+	field := &WireField{
+		Name: parts[0],
+		Type: parts[1],
 		Spec: spec,
 	}
+	if len(parts) == 3 {
+		field.Range = parts[2]
+	}
+	return field
+}
+
+func isIntegerWireType(t string) bool {
+	switch t {
+	case "u8", "i8", "u16", "i16", "u32", "i32", "u64", "i64":
+		return true
+	}
+	return false
 }
 
 // HasDirection checks if a device has a wire tag for the given direction
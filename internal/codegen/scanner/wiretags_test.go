@@ -0,0 +1,54 @@
+package scanner
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScanWireTagsBoolAndEnum(t *testing.T) {
+	tags, err := ScanWireTags([]string{filepath.Join("testdata", "wire", "gadget")})
+	if err != nil {
+		t.Fatalf("ScanWireTags failed: %v", err)
+	}
+
+	in := tags.GetTag("gadget", "c2s")
+	if in == nil {
+		t.Fatal("no c2s tag")
+	}
+	// bad (no prefix) and worse (non-integer base) are malformed and dropped.
+	want := []WireField{
+		{Name: "mode", Type: "u8", Enum: "Mode", Spec: "mode:enum:u8:Mode"},
+		{Name: "armed", Type: "bool", Spec: "armed:bool"},
+		{Name: "speed", Type: "u16", Range: "0..500", Spec: "speed:u16:0..500"},
+	}
+	if len(in.Fields) != len(want) {
+		t.Fatalf("expected %d fields, got %+v", len(want), in.Fields)
+	}
+	for i, f := range in.Fields {
+		if f != want[i] {
+			t.Errorf("field %d: got %+v, want %+v", i, f, want[i])
+		}
+	}
+
+	out := tags.GetTag("gadget", "s2c")
+	if out == nil {
+		t.Fatal("no s2c tag")
+	}
+	if f := out.Fields[0]; f.Type != "u16" || f.Enum != "Light" {
+		t.Errorf("light: got %+v", f)
+	}
+	if f := out.Fields[1]; f.Type != "bool" || f.Enum != "" {
+		t.Errorf("fault: got %+v", f)
+	}
+}
+
+func TestScanWireTagsUnknownEnumPrefix(t *testing.T) {
+	_, err := ScanWireTags([]string{filepath.Join("testdata", "wire", "badenum")})
+	if err == nil {
+		t.Fatal("expected an error for an enum prefix without constants")
+	}
+	if !strings.Contains(err.Error(), "mode") || !strings.Contains(err.Error(), "Gear") {
+		t.Errorf("error should name the field and prefix: %v", err)
+	}
+}
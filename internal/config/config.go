@@ -17,8 +17,12 @@ type CLI struct {
 	ConfigPath string `help:"Path to configuration file (json|yaml|toml)" name:"config" env:"VIIPER_CONFIG"`
 	Log        `embed:"" prefix:"log."`
 
+	UsbPcap        string `help:"Write USB traffic to this file as a pcapng capture for Wireshark (default: none)" name:"usb-pcap" env:"VIIPER_USB_PCAP"`
+	UsbPcapMaxSize int    `help:"Size in MiB after which the capture continues in a new file (0 = never)" name:"usb-pcap-max-size" default:"100" env:"VIIPER_USB_PCAP_MAX_SIZE"`
+
 	Server cmd.Server `cmd:"" help:"Start the VIIPER USB-IP server"`
 	Proxy  cmd.Proxy  `cmd:"" help:"Start the VIIPER USB-IP proxy"`
+	Watch  cmd.Watch  `cmd:"" help:"Live-tail device stats and feedback of a running server"`
 
 	Config    cmd.ConfigCommand `cmd:"" help:"Manage configuration files"`
 	Codegen   cmd.Codegen       `cmd:"" help:"Generate client libraries from server code"`
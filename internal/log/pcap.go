@@ -0,0 +1,478 @@
+package log
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Alia5/VIIPER/usbip"
+)
+
+// pcapng block types and the link type of usbmon records with the 64-byte
+// (mmapped) header.
+const (
+	blockSectionHeader   = 0x0A0D0D0A
+	blockInterface       = 0x00000001
+	blockEnhancedPacket  = 0x00000006
+	byteOrderMagic       = 0x1A2B3C4D
+	linkTypeUSBLinuxMmap = 220
+
+	usbmonHeaderSize = 64
+)
+
+// usbmon transfer types.
+const (
+	xferIso       = 0
+	xferInterrupt = 1
+	xferControl   = 2
+	xferBulk      = 3
+)
+
+// USB-IP framing sizes.
+const (
+	mgmtHeaderSize    = 8
+	importRequestSize = mgmtHeaderSize + 32
+	importReplySize   = mgmtHeaderSize + 312
+	urbHeaderSize     = 0x30
+	// maxTransfer bounds the payload a header may announce, so a corrupt
+	// stream stops the capture instead of buffering without end.
+	maxTransfer = 16 << 20
+)
+
+const (
+	errInProgress = -115 // status of usbmon submissions
+	errConnReset  = -104
+)
+
+// PcapLogger is a RawLogger that writes USB-IP traffic as a pcapng capture
+// of usbmon records, the format Wireshark decodes for Linux USB captures.
+// It reassembles each URB's submission and completion from the byte stream
+// of a connection; use Stream (or StreamOf) to get a logger per connection.
+type PcapLogger struct {
+	path    string
+	maxSize int64
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	files   int
+	streams uint32
+	def     *pcapStream
+}
+
+// NewPcapLogger creates the capture file at path. Once a file would grow
+// past maxSize bytes the capture continues in path-1, path-2, ... (before
+// the extension); maxSize 0 never rotates.
+func NewPcapLogger(path string, maxSize int64) (*PcapLogger, error) {
+	p := &PcapLogger{path: path, maxSize: maxSize}
+	if err := p.open(path); err != nil {
+		return nil, err
+	}
+	p.def = p.newStream()
+	return p, nil
+}
+
+// Log feeds data to a stream shared by all callers that don't use Stream.
+func (p *PcapLogger) Log(in bool, data []byte) { p.def.Log(in, data) }
+
+// Stream returns a logger for the traffic of one connection.
+func (p *PcapLogger) Stream() RawLogger { return p.newStream() }
+
+// Close closes the current capture file; later traffic is dropped.
+func (p *PcapLogger) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.f == nil {
+		return nil
+	}
+	err := p.f.Close()
+	p.f = nil
+	return err
+}
+
+func (p *PcapLogger) newStream() *pcapStream {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.streams++
+	return &pcapStream{
+		p:       p,
+		id:      p.streams,
+		pending: map[uint32]*pcapURB{},
+		unlinks: map[uint32]uint32{},
+		epTypes: map[uint32]map[uint8]uint8{},
+	}
+}
+
+// open starts a capture file with its section header and interface blocks.
+func (p *PcapLogger) open(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open pcap file: %w", err)
+	}
+	shb := make([]byte, 28)
+	binary.LittleEndian.PutUint32(shb[0:], blockSectionHeader)
+	binary.LittleEndian.PutUint32(shb[4:], 28)
+	binary.LittleEndian.PutUint32(shb[8:], byteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[12:], 1) // version 1.0
+	binary.LittleEndian.PutUint64(shb[16:], ^uint64(0))
+	binary.LittleEndian.PutUint32(shb[24:], 28)
+	idb := make([]byte, 20)
+	binary.LittleEndian.PutUint32(idb[0:], blockInterface)
+	binary.LittleEndian.PutUint32(idb[4:], 20)
+	binary.LittleEndian.PutUint16(idb[8:], linkTypeUSBLinuxMmap)
+	binary.LittleEndian.PutUint32(idb[16:], 20)
+	if _, err := f.Write(append(shb, idb...)); err != nil {
+		f.Close()
+		return fmt.Errorf("write pcap header: %w", err)
+	}
+	p.f, p.size = f, int64(len(shb)+len(idb))
+	return nil
+}
+
+// writePacket writes pkt in an enhanced packet block, rotating first if the
+// file would outgrow maxSize. Callers hold p.mu.
+func (p *PcapLogger) writePacket(ts time.Time, pkt []byte) {
+	if p.f == nil {
+		return
+	}
+	padded := (len(pkt) + 3) &^ 3
+	blk := make([]byte, 32+padded)
+	binary.LittleEndian.PutUint32(blk[0:], blockEnhancedPacket)
+	binary.LittleEndian.PutUint32(blk[4:], uint32(len(blk)))
+	us := uint64(ts.UnixMicro())
+	binary.LittleEndian.PutUint32(blk[12:], uint32(us>>32))
+	binary.LittleEndian.PutUint32(blk[16:], uint32(us))
+	binary.LittleEndian.PutUint32(blk[20:], uint32(len(pkt)))
+	binary.LittleEndian.PutUint32(blk[24:], uint32(len(pkt)))
+	copy(blk[28:], pkt)
+	binary.LittleEndian.PutUint32(blk[28+padded:], uint32(len(blk)))
+
+	if p.maxSize > 0 && p.size+int64(len(blk)) > p.maxSize {
+		p.f.Close()
+		p.f = nil
+		p.files++
+		if err := p.open(rotatedPath(p.path, p.files)); err != nil {
+			return
+		}
+	}
+	if _, err := p.f.Write(blk); err != nil {
+		p.f.Close()
+		p.f = nil
+		return
+	}
+	p.size += int64(len(blk))
+}
+
+// rotatedPath returns the n-th follow-up file of path: capture-1.pcapng.
+func rotatedPath(path string, n int) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + strconv.Itoa(n) + ext
+}
+
+type streamState int
+
+const (
+	streamStart  streamState = iota // before the first request
+	streamImport                    // waiting for OP_REP_IMPORT
+	streamURBs
+	streamOff // devlist, failed import or unparsable data
+)
+
+// pcapURB is a submitted URB awaiting its completion.
+type pcapURB struct {
+	seq, devid, dir, ep uint32
+	flags, length       uint32
+	startFrame, numPkts uint32
+	interval            uint32
+	setup               [8]byte
+}
+
+func (u *pcapURB) iso() bool { return u.numPkts != 0 && u.numPkts != 0xffffffff }
+
+func (u *pcapURB) epAddr() uint8 {
+	if u.dir == usbip.DirIn {
+		return uint8(u.ep) | 0x80
+	}
+	return uint8(u.ep)
+}
+
+// pcapStream reassembles the URBs of one connection.
+type pcapStream struct {
+	p       *PcapLogger
+	id      uint32
+	state   streamState
+	in, out []byte // unparsed client and server bytes
+
+	pending map[uint32]*pcapURB
+	unlinks map[uint32]uint32          // CMD_UNLINK seq -> unlinked seq
+	epTypes map[uint32]map[uint8]uint8 // devid -> endpoint address -> xfer type
+}
+
+// Log appends data to its direction and records every complete message.
+func (s *pcapStream) Log(in bool, data []byte) {
+	s.p.mu.Lock()
+	defer s.p.mu.Unlock()
+	if s.state == streamOff {
+		return
+	}
+	if in {
+		s.in = append(s.in, data...)
+	} else {
+		s.out = append(s.out, data...)
+	}
+	now := time.Now()
+	for {
+		state := s.state
+		n, m := s.parseClient(now), 0
+		s.in = s.in[n:]
+		if s.state != streamOff {
+			m = s.parseServer(now)
+			s.out = s.out[m:]
+		}
+		if s.state == streamOff {
+			s.in, s.out = nil, nil
+			return
+		}
+		if n == 0 && m == 0 && s.state == state {
+			return
+		}
+	}
+}
+
+// parseClient consumes one message from the client, returning its size or 0
+// if it is incomplete.
+func (s *pcapStream) parseClient(now time.Time) int {
+	b := s.in
+	switch s.state {
+	case streamStart:
+		if len(b) < mgmtHeaderSize {
+			return 0
+		}
+		if binary.BigEndian.Uint16(b) == usbip.Version {
+			switch binary.BigEndian.Uint16(b[2:]) {
+			case usbip.OpReqDevlist:
+				s.state = streamOff
+				return 0
+			case usbip.OpReqImport:
+				if len(b) < importRequestSize {
+					return 0
+				}
+				s.state = streamImport
+				return importRequestSize
+			}
+		}
+		s.state = streamURBs
+		return 0
+	case streamURBs:
+	default:
+		return 0
+	}
+	if len(b) < urbHeaderSize {
+		return 0
+	}
+	switch binary.BigEndian.Uint32(b) {
+	case usbip.CmdSubmitCode:
+		u := &pcapURB{
+			seq:        binary.BigEndian.Uint32(b[0x04:]),
+			devid:      binary.BigEndian.Uint32(b[0x08:]),
+			dir:        binary.BigEndian.Uint32(b[0x0c:]),
+			ep:         binary.BigEndian.Uint32(b[0x10:]),
+			flags:      binary.BigEndian.Uint32(b[0x14:]),
+			length:     binary.BigEndian.Uint32(b[0x18:]),
+			startFrame: binary.BigEndian.Uint32(b[0x1c:]),
+			numPkts:    binary.BigEndian.Uint32(b[0x20:]),
+			interval:   binary.BigEndian.Uint32(b[0x24:]),
+		}
+		copy(u.setup[:], b[0x28:urbHeaderSize])
+		var dataLen, descLen uint32
+		if u.dir == usbip.DirOut {
+			dataLen = u.length
+		}
+		if u.iso() {
+			descLen = u.numPkts * usbip.IsoPacketDescriptorSize
+		}
+		n, ok := s.frame(b, dataLen, descLen)
+		if !ok {
+			return 0
+		}
+		s.pending[u.seq] = u
+		s.record(now, 'S', u, errInProgress, u.length, b[urbHeaderSize:urbHeaderSize+dataLen],
+			usbip.ParseIsoPacketDescriptors(b[urbHeaderSize+dataLen:n]))
+		return n
+	case usbip.CmdUnlinkCode:
+		s.unlinks[binary.BigEndian.Uint32(b[0x04:])] = binary.BigEndian.Uint32(b[0x14:])
+		return urbHeaderSize
+	}
+	s.state = streamOff
+	return 0
+}
+
+// parseServer consumes one message from the server.
+func (s *pcapStream) parseServer(now time.Time) int {
+	b := s.out
+	switch s.state {
+	case streamImport:
+		if len(b) < mgmtHeaderSize {
+			return 0
+		}
+		if binary.BigEndian.Uint32(b[4:]) != 0 {
+			s.state = streamOff
+			return 0
+		}
+		if len(b) < importReplySize {
+			return 0
+		}
+		s.state = streamURBs
+		return importReplySize
+	case streamURBs:
+	default:
+		return 0
+	}
+	if len(b) < urbHeaderSize {
+		return 0
+	}
+	seq := binary.BigEndian.Uint32(b[0x04:])
+	status := int32(binary.BigEndian.Uint32(b[0x14:]))
+	switch binary.BigEndian.Uint32(b) {
+	case usbip.RetSubmitCode:
+		u := s.pending[seq]
+		if u == nil {
+			s.state = streamOff
+			return 0
+		}
+		actual := binary.BigEndian.Uint32(b[0x18:])
+		var dataLen, descLen uint32
+		if u.dir == usbip.DirIn {
+			dataLen = actual
+		}
+		if u.iso() {
+			descLen = binary.BigEndian.Uint32(b[0x20:]) * usbip.IsoPacketDescriptorSize
+		}
+		n, ok := s.frame(b, dataLen, descLen)
+		if !ok {
+			return 0
+		}
+		delete(s.pending, seq)
+		data := b[urbHeaderSize : urbHeaderSize+dataLen]
+		if status == 0 {
+			s.learnEndpoints(u, data)
+		}
+		s.record(now, 'C', u, status, actual, data,
+			usbip.ParseIsoPacketDescriptors(b[urbHeaderSize+dataLen:n]))
+		return n
+	case usbip.RetUnlinkCode:
+		target, ok := s.unlinks[seq]
+		delete(s.unlinks, seq)
+		// A successful unlink completes the URB; no RET_SUBMIT follows.
+		if u := s.pending[target]; ok && u != nil && status == errConnReset {
+			delete(s.pending, target)
+			s.record(now, 'C', u, status, 0, nil, nil)
+		}
+		return urbHeaderSize
+	}
+	s.state = streamOff
+	return 0
+}
+
+// frame returns the size of a message with a header, dataLen payload bytes
+// and descLen descriptor bytes, and whether b holds all of it.
+func (s *pcapStream) frame(b []byte, dataLen, descLen uint32) (int, bool) {
+	if dataLen > maxTransfer || descLen > maxTransfer {
+		s.state = streamOff
+		return 0, false
+	}
+	n := urbHeaderSize + int(dataLen) + int(descLen)
+	return n, len(b) >= n
+}
+
+// learnEndpoints records the endpoint types of a configuration descriptor
+// read by GET_DESCRIPTOR, so later records carry the right transfer type.
+func (s *pcapStream) learnEndpoints(u *pcapURB, data []byte) {
+	if u.ep != 0 || u.setup[0] != 0x80 || u.setup[1] != 0x06 || u.setup[3] != 0x02 {
+		return
+	}
+	types := s.epTypes[u.devid]
+	if types == nil {
+		types = map[uint8]uint8{}
+		s.epTypes[u.devid] = types
+	}
+	// bmAttributes transfer types, in usbmon's numbering.
+	usbmonType := [4]uint8{xferControl, xferIso, xferBulk, xferInterrupt}
+	for i := 0; i+2 <= len(data); i += int(data[i]) {
+		l := int(data[i])
+		if l < 2 {
+			return
+		}
+		if data[i+1] == 0x05 && l >= 4 && i+4 <= len(data) {
+			types[data[i+2]] = usbmonType[data[i+3]&0x03]
+		}
+	}
+}
+
+func (s *pcapStream) xferType(u *pcapURB) uint8 {
+	if u.ep == 0 {
+		return xferControl
+	}
+	if t, ok := s.epTypes[u.devid][u.epAddr()]; ok {
+		return t
+	}
+	if u.iso() {
+		return xferIso
+	}
+	return xferInterrupt
+}
+
+// record writes the usbmon record of a submission ('S') or completion ('C').
+func (s *pcapStream) record(now time.Time, typ byte, u *pcapURB, status int32, length uint32, data []byte, desc []usbip.IsoPacketDescriptor) {
+	pkt := make([]byte, usbmonHeaderSize, usbmonHeaderSize+len(desc)*16+len(data))
+	le := binary.LittleEndian
+	le.PutUint64(pkt[0:], uint64(s.id)<<32|uint64(u.seq))
+	pkt[8] = typ
+	pkt[9] = s.xferType(u)
+	pkt[10] = u.epAddr()
+	pkt[11] = uint8(u.devid)
+	le.PutUint16(pkt[12:], uint16(u.devid>>16))
+	pkt[14] = '-'
+	if typ == 'S' && u.ep == 0 {
+		pkt[14] = 0
+		copy(pkt[40:48], u.setup[:])
+	}
+	switch {
+	case len(data) > 0:
+		pkt[15] = 0
+	case u.dir == usbip.DirIn:
+		pkt[15] = '<'
+	default:
+		pkt[15] = '>'
+	}
+	le.PutUint64(pkt[16:], uint64(now.Unix()))
+	le.PutUint32(pkt[24:], uint32(now.Nanosecond()/1000))
+	le.PutUint32(pkt[28:], uint32(status))
+	le.PutUint32(pkt[32:], length)
+	le.PutUint32(pkt[36:], uint32(len(data)))
+	if u.iso() {
+		le.PutUint32(pkt[44:], uint32(len(desc)))
+	}
+	le.PutUint32(pkt[48:], u.interval)
+	le.PutUint32(pkt[52:], u.startFrame)
+	le.PutUint32(pkt[56:], u.flags)
+	le.PutUint32(pkt[60:], uint32(len(desc)))
+	for _, d := range desc {
+		var rec [16]byte
+		st, l := d.Status, d.ActualLength
+		if typ == 'S' {
+			st, l = errInProgress, d.Length
+		}
+		le.PutUint32(rec[0:], uint32(st))
+		le.PutUint32(rec[4:], d.Offset)
+		le.PutUint32(rec[8:], l)
+		pkt = append(pkt, rec[:]...)
+	}
+	pkt = append(pkt, data...)
+	s.p.writePacket(now, pkt)
+}
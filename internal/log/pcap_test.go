@@ -0,0 +1,206 @@
+package log_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/device/dualshock4"
+	"github.com/Alia5/VIIPER/internal/log"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+type usbmonRecord struct {
+	id     uint64
+	typ    byte
+	xfer   uint8
+	ep     uint8
+	status int32
+	length uint32
+	setup  []byte
+	data   []byte
+}
+
+// readPcapng checks the block structure of a capture file and returns its
+// usbmon records.
+func readPcapng(t *testing.T, path string) []usbmonRecord {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	le := binary.LittleEndian
+
+	var recs []usbmonRecord
+	for i := 0; len(b) > 0; i++ {
+		require.GreaterOrEqual(t, len(b), 12, "block %d truncated", i)
+		typ, n := le.Uint32(b), int(le.Uint32(b[4:]))
+		require.Zero(t, n%4, "block %d length", i)
+		require.LessOrEqual(t, n, len(b), "block %d length", i)
+		require.Equal(t, uint32(n), le.Uint32(b[n-4:]), "block %d trailing length", i)
+		blk := b[:n]
+		b = b[n:]
+
+		switch i {
+		case 0:
+			require.Equal(t, uint32(0x0A0D0D0A), typ, "section header first")
+			require.Equal(t, uint32(0x1A2B3C4D), le.Uint32(blk[8:]), "byte order magic")
+			require.Equal(t, uint16(1), le.Uint16(blk[12:]), "major version")
+			continue
+		case 1:
+			require.Equal(t, uint32(1), typ, "interface description second")
+			require.Equal(t, uint16(220), le.Uint16(blk[8:]), "LINKTYPE_USB_LINUX_MMAPPED")
+			continue
+		}
+		require.Equal(t, uint32(6), typ, "enhanced packet block %d", i)
+		require.Zero(t, le.Uint32(blk[8:]), "interface")
+		capLen := int(le.Uint32(blk[20:]))
+		require.Equal(t, le.Uint32(blk[20:]), le.Uint32(blk[24:]), "captured whole packet")
+		require.LessOrEqual(t, 28+capLen, n-4)
+		pkt := blk[28 : 28+capLen]
+
+		require.GreaterOrEqual(t, len(pkt), 64, "usbmon header")
+		dataLen := int(le.Uint32(pkt[36:]))
+		ndesc := int(le.Uint32(pkt[60:]))
+		require.Equal(t, 64+16*ndesc+dataLen, len(pkt), "len_cap")
+		r := usbmonRecord{
+			id:     le.Uint64(pkt),
+			typ:    pkt[8],
+			xfer:   pkt[9],
+			ep:     pkt[10],
+			status: int32(le.Uint32(pkt[28:])),
+			length: le.Uint32(pkt[32:]),
+			data:   pkt[64+16*ndesc:],
+		}
+		if pkt[14] == 0 {
+			r.setup = pkt[40:48]
+		}
+		recs = append(recs, r)
+	}
+	return recs
+}
+
+func startCapture(t *testing.T, busID uint32, pcap *log.PcapLogger) (*viiperTesting.TestUsbIpClient, net.Conn) {
+	t.Helper()
+	cfg := viiperTesting.TestServerConfig(t).Server.UsbServerConfig
+	srv := usb.New(cfg, slog.Default(), pcap)
+	go func() { _ = srv.ListenAndServe() }()
+	select {
+	case <-srv.Ready():
+	case <-time.After(2 * time.Second):
+		t.Fatal("USB server did not become ready")
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	b, err := virtualbus.NewWithBusId(busID)
+	require.NoError(t, err)
+	t.Cleanup(func() { b.Close() })
+	require.NoError(t, srv.AddBus(b))
+	ds4, err := dualshock4.New(nil)
+	require.NoError(t, err)
+	_, err = b.Add(ds4)
+	require.NoError(t, err)
+
+	client := viiperTesting.NewUsbIpClient(t, srv.Addr())
+	imp, err := client.AttachDevice(fmt.Sprintf("%d-1", busID))
+	require.NoError(t, err)
+	t.Cleanup(func() { imp.Conn.Close() })
+	return client, imp.Conn
+}
+
+func TestPcapLogger(t *testing.T) {
+	t.Run("ds4 exchange", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "usb.pcapng")
+		pcap, err := log.NewPcapLogger(path, 0)
+		require.NoError(t, err)
+		client, conn := startCapture(t, 90165, pcap)
+
+		getDevice := [8]byte{0x80, 0x06, 0x00, 0x01, 0x00, 0x00, 0x12, 0x00}
+		devDesc, err := client.Control(conn, getDevice, nil)
+		require.NoError(t, err)
+		_, err = client.Control(conn, [8]byte{0x80, 0x06, 0x00, 0x02, 0x00, 0x00, 0xff, 0x00}, nil)
+		require.NoError(t, err)
+		report, err := client.ReadEndpointWithTimeout(conn, 4, time.Second)
+		require.NoError(t, err)
+		rumble := []byte{0x05, 0xff, 0x00, 0x00, 0x40, 0x80}
+		require.NoError(t, client.Submit(conn, usbip.DirOut, 3, rumble, nil))
+
+		var recs []usbmonRecord
+		require.Eventually(t, func() bool {
+			recs = readPcapng(t, path)
+			return len(recs) == 8
+		}, time.Second, 10*time.Millisecond)
+		require.NoError(t, pcap.Close())
+
+		type transfer struct {
+			typ    byte
+			xfer   uint8
+			ep     uint8
+			status int32
+		}
+		var got []transfer
+		for _, r := range recs {
+			got = append(got, transfer{r.typ, r.xfer, r.ep, r.status})
+		}
+		assert.Equal(t, []transfer{
+			{'S', 2, 0x80, -115}, {'C', 2, 0x80, 0},
+			{'S', 2, 0x80, -115}, {'C', 2, 0x80, 0},
+			{'S', 1, 0x84, -115}, {'C', 1, 0x84, 0},
+			{'S', 1, 0x03, -115}, {'C', 1, 0x03, 0},
+		}, got, "submissions paired with completions, interrupt endpoints learned")
+
+		for i := 0; i < len(recs); i += 2 {
+			assert.Equal(t, recs[i].id, recs[i+1].id, "URB id of pair %d", i/2)
+		}
+		assert.NotEqual(t, recs[0].id, recs[2].id)
+		assert.Equal(t, getDevice[:], recs[0].setup, "setup packet")
+		assert.Nil(t, recs[1].setup)
+		assert.Equal(t, devDesc, recs[1].data)
+		assert.Equal(t, uint32(18), recs[1].length)
+		assert.Empty(t, recs[4].data, "IN submission has no data")
+		assert.Equal(t, report, recs[5].data)
+		assert.Equal(t, rumble, recs[6].data)
+		assert.Equal(t, uint32(len(rumble)), recs[7].length)
+		assert.Empty(t, recs[7].data, "OUT completion has no data")
+	})
+
+	t.Run("rotates", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "usb.pcapng")
+		pcap, err := log.NewPcapLogger(path, 512)
+		require.NoError(t, err)
+		client, conn := startCapture(t, 90166, pcap)
+
+		for range 6 {
+			_, err := client.ReadEndpointWithTimeout(conn, 4, time.Second)
+			require.NoError(t, err)
+		}
+		files := []string{path}
+		require.Eventually(t, func() bool {
+			files, _ = filepath.Glob(filepath.Join(dir, "usb*.pcapng"))
+			total := 0
+			for _, f := range files {
+				total += len(readPcapng(t, f))
+			}
+			return total == 12
+		}, time.Second, 10*time.Millisecond)
+		require.NoError(t, pcap.Close())
+
+		assert.Greater(t, len(files), 1)
+		for _, f := range files {
+			info, err := os.Stat(f)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, info.Size(), int64(512), f)
+		}
+		assert.FileExists(t, filepath.Join(dir, "usb-1.pcapng"))
+	})
+}
@@ -59,3 +59,34 @@ func (r *rawLogger) Log(in bool, data []byte) {
 	_, _ = r.w.Write([]byte(line))
 	r.mu.Unlock()
 }
+
+// StreamOf returns the logger for one connection. Loggers that reassemble
+// the protocol, such as PcapLogger, keep separate state per connection;
+// others are returned unchanged.
+func StreamOf(l RawLogger) RawLogger {
+	if s, ok := l.(interface{ Stream() RawLogger }); ok {
+		return s.Stream()
+	}
+	return l
+}
+
+// MultiRaw returns a RawLogger that logs to each of loggers.
+func MultiRaw(loggers ...RawLogger) RawLogger {
+	return multiRaw(loggers)
+}
+
+type multiRaw []RawLogger
+
+func (m multiRaw) Log(in bool, data []byte) {
+	for _, l := range m {
+		l.Log(in, data)
+	}
+}
+
+func (m multiRaw) Stream() RawLogger {
+	streams := make(multiRaw, len(m))
+	for i, l := range m {
+		streams[i] = StreamOf(l)
+	}
+	return streams
+}
@@ -0,0 +1,329 @@
+// Package metrics is a small registry of counters, gauges and histograms
+// describing the server, exported as JSON by the metrics route and in the
+// Prometheus text format.
+package metrics
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Kind is the type of a metric family.
+type Kind string
+
+const (
+	KindCounter   Kind = "counter"
+	KindGauge     Kind = "gauge"
+	KindHistogram Kind = "histogram"
+)
+
+// Family is the collected state of one metric.
+type Family struct {
+	Name    string
+	Help    string
+	Kind    Kind
+	Samples []Sample
+}
+
+// Sample is one series of a family. Histograms carry their sum in Value and
+// cumulative bucket counts; the implicit +Inf bucket is Count.
+type Sample struct {
+	Labels  map[string]string
+	Value   float64
+	Count   uint64
+	Buckets []Bucket
+}
+
+// Bucket counts the observations up to Le.
+type Bucket struct {
+	Le    float64
+	Count uint64
+}
+
+type collector interface {
+	family() Family
+	forget(label, value string)
+}
+
+// Registry holds metrics in the order they were registered.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry { return &Registry{} }
+
+func (r *Registry) add(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Gather collects every metric, series sorted by their label values.
+func (r *Registry) Gather() []Family {
+	r.mu.Lock()
+	cs := slices.Clone(r.collectors)
+	r.mu.Unlock()
+	out := make([]Family, 0, len(cs))
+	for _, c := range cs {
+		out = append(out, c.family())
+	}
+	return out
+}
+
+// Forget drops the series of every metric whose label is value, e.g. those
+// of a removed device.
+func (r *Registry) Forget(label, value string) {
+	r.mu.Lock()
+	cs := slices.Clone(r.collectors)
+	r.mu.Unlock()
+	for _, c := range cs {
+		c.forget(label, value)
+	}
+}
+
+// vec holds the series of a metric by label values.
+type vec[T any] struct {
+	name, help string
+	labels     []string
+	newSeries  func() T
+	mu         sync.RWMutex
+	series     map[string]*labeled[T]
+}
+
+type labeled[T any] struct {
+	values []string
+	m      T
+}
+
+func newVec[T any](name, help string, labels []string, newSeries func() T) *vec[T] {
+	return &vec[T]{name: name, help: help, labels: labels, newSeries: newSeries, series: map[string]*labeled[T]{}}
+}
+
+// with returns the series of values, one per label, creating it on first use.
+func (v *vec[T]) with(values []string) T {
+	if len(values) != len(v.labels) {
+		panic("metrics: " + v.name + " takes labels " + strings.Join(v.labels, ","))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return s.m
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[key]; ok {
+		return s.m
+	}
+	s = &labeled[T]{values: slices.Clone(values), m: v.newSeries()}
+	v.series[key] = s
+	return s.m
+}
+
+// each calls f for every series in label value order.
+func (v *vec[T]) each(f func(labels map[string]string, m T)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	series := make([]*labeled[T], len(keys))
+	for i, k := range keys {
+		series[i] = v.series[k]
+	}
+	v.mu.RUnlock()
+	for _, s := range series {
+		f(labelMap(v.labels, s.values), s.m)
+	}
+}
+
+func (v *vec[T]) forget(label, value string) {
+	i := slices.Index(v.labels, label)
+	if i < 0 {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for k, s := range v.series {
+		if s.values[i] == value {
+			delete(v.series, k)
+		}
+	}
+}
+
+func labelMap(names, values []string) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(names))
+	for i, n := range names {
+		m[n] = values[i]
+	}
+	return m
+}
+
+// Counter is a monotonically increasing count.
+type Counter struct{ v atomic.Uint64 }
+
+func (c *Counter) Inc()          { c.v.Add(1) }
+func (c *Counter) Add(n uint64)  { c.v.Add(n) }
+func (c *Counter) Value() uint64 { return c.v.Load() }
+
+// CounterVec is a counter per combination of label values.
+type CounterVec struct{ *vec[*Counter] }
+
+// Counter registers a counter with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newVec(name, help, labels, func() *Counter { return &Counter{} })}
+	r.add(v)
+	return v
+}
+
+// With returns the counter of the label values, in the order of the label
+// names.
+func (v *CounterVec) With(values ...string) *Counter { return v.with(values) }
+
+func (v *CounterVec) family() Family {
+	f := Family{Name: v.name, Help: v.help, Kind: KindCounter}
+	v.each(func(labels map[string]string, c *Counter) {
+		f.Samples = append(f.Samples, Sample{Labels: labels, Value: float64(c.Value())})
+	})
+	return f
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct{ bits atomic.Uint64 }
+
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+
+func (g *Gauge) Add(d float64) {
+	for {
+		old := g.bits.Load()
+		if g.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+d)) {
+			return
+		}
+	}
+}
+
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+// GaugeVec is a gauge per combination of label values.
+type GaugeVec struct{ *vec[*Gauge] }
+
+// Gauge registers a gauge with the given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{newVec(name, help, labels, func() *Gauge { return &Gauge{} })}
+	r.add(v)
+	return v
+}
+
+// With returns the gauge of the label values.
+func (v *GaugeVec) With(values ...string) *Gauge { return v.with(values) }
+
+func (v *GaugeVec) family() Family {
+	f := Family{Name: v.name, Help: v.help, Kind: KindGauge}
+	v.each(func(labels map[string]string, g *Gauge) {
+		f.Samples = append(f.Samples, Sample{Labels: labels, Value: g.Value()})
+	})
+	return f
+}
+
+// funcMetric reports values computed at collection time.
+type funcMetric struct {
+	name, help string
+	kind       Kind
+	labels     []string
+	collect    func(emit func(v float64, values ...string))
+}
+
+// GaugeFunc registers a gauge whose series collect emits when gathered, for
+// state the server already tracks elsewhere.
+func (r *Registry) GaugeFunc(name, help string, labels []string, collect func(emit func(v float64, values ...string))) {
+	r.add(&funcMetric{name: name, help: help, kind: KindGauge, labels: labels, collect: collect})
+}
+
+// CounterFunc is GaugeFunc for counts kept elsewhere.
+func (r *Registry) CounterFunc(name, help string, labels []string, collect func(emit func(v float64, values ...string))) {
+	r.add(&funcMetric{name: name, help: help, kind: KindCounter, labels: labels, collect: collect})
+}
+
+func (m *funcMetric) family() Family {
+	f := Family{Name: m.name, Help: m.help, Kind: m.kind}
+	m.collect(func(v float64, values ...string) {
+		f.Samples = append(f.Samples, Sample{Labels: labelMap(m.labels, values), Value: v})
+	})
+	slices.SortFunc(f.Samples, func(a, b Sample) int {
+		for _, l := range m.labels {
+			if c := strings.Compare(a.Labels[l], b.Labels[l]); c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+	return f
+}
+
+func (m *funcMetric) forget(string, string) {}
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe adds v.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i, _ := slices.BinarySearch(h.bounds, v); i < len(h.bounds) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *Histogram) sample(labels map[string]string) Sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := Sample{Labels: labels, Value: h.sum, Count: h.count, Buckets: make([]Bucket, len(h.bounds))}
+	var cum uint64
+	for i, le := range h.bounds {
+		cum += h.counts[i]
+		s.Buckets[i] = Bucket{Le: le, Count: cum}
+	}
+	return s
+}
+
+// HistogramVec is a histogram per combination of label values.
+type HistogramVec struct{ *vec[*Histogram] }
+
+// Histogram registers a histogram with the given upper bucket bounds, in
+// increasing order, and label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	v := &HistogramVec{newVec(name, help, labels, func() *Histogram {
+		return &Histogram{bounds: buckets, counts: make([]uint64, len(buckets))}
+	})}
+	r.add(v)
+	return v
+}
+
+// With returns the histogram of the label values.
+func (v *HistogramVec) With(values ...string) *Histogram { return v.with(values) }
+
+func (v *HistogramVec) family() Family {
+	f := Family{Name: v.name, Help: v.help, Kind: KindHistogram}
+	v.each(func(labels map[string]string, h *Histogram) {
+		f.Samples = append(f.Samples, h.sample(labels))
+	})
+	return f
+}
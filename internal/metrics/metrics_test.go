@@ -0,0 +1,73 @@
+package metrics_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/internal/metrics"
+)
+
+func TestRegistry(t *testing.T) {
+	r := metrics.NewRegistry()
+	urbs := r.Counter("urbs_total", "URBs.", "dir", "ep")
+	clients := r.Gauge("clients", "Clients.")
+	build := r.Histogram("build_seconds", "Build time.", []float64{0.001, 0.01}, "device")
+	r.GaugeFunc("buses", "Buses.", []string{"bus"}, func(emit func(float64, ...string)) {
+		emit(2, "7")
+		emit(1, "3")
+	})
+
+	urbs.With("in", "1").Add(3)
+	urbs.With("out", "0").Inc()
+	urbs.With("in", "1").Inc()
+	clients.With().Set(2)
+	clients.With().Add(-1)
+	build.With("1-1").Observe(0.0005)
+	build.With("1-1").Observe(0.001)
+	build.With("1-1").Observe(0.5)
+
+	fams := r.Gather()
+	require.Len(t, fams, 4)
+	assert.Equal(t, metrics.Family{Name: "urbs_total", Help: "URBs.", Kind: metrics.KindCounter, Samples: []metrics.Sample{
+		{Labels: map[string]string{"dir": "in", "ep": "1"}, Value: 4},
+		{Labels: map[string]string{"dir": "out", "ep": "0"}, Value: 1},
+	}}, fams[0])
+	assert.Equal(t, []metrics.Sample{{Value: 1}}, fams[1].Samples)
+	assert.Equal(t, []metrics.Sample{{
+		Labels:  map[string]string{"device": "1-1"},
+		Value:   0.5015,
+		Count:   3,
+		Buckets: []metrics.Bucket{{Le: 0.001, Count: 2}, {Le: 0.01, Count: 2}},
+	}}, fams[2].Samples)
+	assert.Equal(t, []metrics.Sample{
+		{Labels: map[string]string{"bus": "3"}, Value: 1},
+		{Labels: map[string]string{"bus": "7"}, Value: 2},
+	}, fams[3].Samples, "func series are sorted")
+
+	assert.Panics(t, func() { urbs.With("in") }, "label count")
+
+	r.Forget("device", "1-1")
+	assert.Empty(t, r.Gather()[2].Samples)
+}
+
+func TestWritePrometheus(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.Counter("streams_total", "Streams\nopened.", "device").With(`a"b`).Add(2)
+	r.Histogram("build_seconds", "Build time.", []float64{0.5}).With().Observe(0.25)
+
+	var buf bytes.Buffer
+	require.NoError(t, metrics.WritePrometheus(&buf, r.Gather()))
+	assert.Equal(t, `# HELP streams_total Streams\nopened.
+# TYPE streams_total counter
+streams_total{device="a\"b"} 2
+# HELP build_seconds Build time.
+# TYPE build_seconds histogram
+build_seconds_bucket{le="0.5"} 1
+build_seconds_bucket{le="+Inf"} 1
+build_seconds_sum 0.25
+build_seconds_count 1
+`, buf.String())
+}
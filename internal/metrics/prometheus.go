@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// WritePrometheus writes families in the Prometheus text exposition format.
+func WritePrometheus(w io.Writer, families []Family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		bw.WriteString("# HELP " + f.Name + " " + escapeHelp(f.Help) + "\n")
+		bw.WriteString("# TYPE " + f.Name + " " + string(f.Kind) + "\n")
+		for _, s := range f.Samples {
+			if f.Kind != KindHistogram {
+				writeSample(bw, f.Name, s.Labels, "", "", s.Value)
+				continue
+			}
+			for _, b := range s.Buckets {
+				writeSample(bw, f.Name+"_bucket", s.Labels, "le", formatFloat(b.Le), float64(b.Count))
+			}
+			writeSample(bw, f.Name+"_bucket", s.Labels, "le", "+Inf", float64(s.Count))
+			writeSample(bw, f.Name+"_sum", s.Labels, "", "", s.Value)
+			writeSample(bw, f.Name+"_count", s.Labels, "", "", float64(s.Count))
+		}
+	}
+	return bw.Flush()
+}
+
+// Handler serves the metrics of r in the Prometheus text format.
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = WritePrometheus(w, r.Gather())
+	})
+}
+
+// writeSample writes one line, with an extra label (le) if extraName is set.
+func writeSample(w *bufio.Writer, name string, labels map[string]string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+	if len(names) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, n := range names {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(n + `="` + escapeLabel(labels[n]) + `"`)
+		}
+		if extraName != "" {
+			if len(names) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraName + `="` + extraValue + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
@@ -0,0 +1,10 @@
+// Package protocol embeds protocol.json, the machine-readable reference of
+// the management routes, DTOs and device wire formats of this source tree.
+// Regenerate it with "viiper codegen --lang protocol" after changing any of
+// them; a test fails while it is stale.
+package protocol
+
+import _ "embed"
+
+//go:embed protocol.json
+var JSON []byte
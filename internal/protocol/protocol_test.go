@@ -0,0 +1,25 @@
+package protocol_test
+
+import (
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/internal/codegen/generator"
+	protocolgen "github.com/Alia5/VIIPER/internal/codegen/generator/protocol"
+	"github.com/Alia5/VIIPER/internal/protocol"
+)
+
+func TestEmbeddedMatchesSource(t *testing.T) {
+	t.Chdir(filepath.Join("..", ".."))
+
+	md, err := generator.New(t.TempDir(), slog.New(slog.DiscardHandler)).ScanAll()
+	require.NoError(t, err)
+
+	got, err := protocolgen.Render(md)
+	require.NoError(t, err)
+	assert.Equal(t, string(got), string(protocol.JSON), "internal/protocol/%s is stale; run \"viiper codegen --lang protocol\"", protocolgen.OutputFile)
+}
@@ -2,7 +2,10 @@ package registry
 
 import (
 	_ "github.com/Alia5/VIIPER/device/dualshock4"
+	_ "github.com/Alia5/VIIPER/device/joystick"
 	_ "github.com/Alia5/VIIPER/device/keyboard"
 	_ "github.com/Alia5/VIIPER/device/mouse"
+	_ "github.com/Alia5/VIIPER/device/switchpro"
 	_ "github.com/Alia5/VIIPER/device/xbox360"
+	_ "github.com/Alia5/VIIPER/device/xboxone"
 )
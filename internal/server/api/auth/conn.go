@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/cipher"
 	"encoding/binary"
+	"errors"
 	"io"
 	"net"
 	"sync"
@@ -84,3 +85,14 @@ func (s *Conn) Read(p []byte) (int, error) {
 	}
 	return s.recvBuf.Read(p)
 }
+
+// CloseWrite shuts down the sending side of the underlying connection,
+// if it supports half-closing.
+func (s *Conn) CloseWrite() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cw, ok := s.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
@@ -49,13 +49,14 @@ func WriteServerHandshake(w io.Writer) (serverNonce []byte, err error) {
 	return serverNonce, nil
 }
 
-// IsAuthHandshake checks if the next bytes in reader match the handshake magic
+// IsAuthHandshake checks if the next bytes in reader match the handshake
+// magic, plain or signed.
 func IsAuthHandshake(r *bufio.Reader) (bool, error) {
 	b, err := r.Peek(len(HandshakeMagic))
 	if err != nil {
 		return false, err
 	}
-	return string(b) == HandshakeMagic, nil
+	return string(b) == HandshakeMagic || string(b) == SignedHandshakeMagic, nil
 }
 
 // HandleAuthHandshake performs the authentication handshake
@@ -76,67 +77,94 @@ func HandleAuthHandshake(r *bufio.Reader, w io.Writer, key []byte, isClient bool
 			return nil, nil, fmt.Errorf("generate client nonce: %w", err)
 		}
 
-		mac := hmac.New(sha256.New, key)
-		_, _ = mac.Write([]byte(authContext))
-		_, _ = mac.Write(clientNonce)
-		clientAuth := mac.Sum(nil)
-
 		msg := append([]byte(HandshakeMagic), clientNonce...)
-		msg = append(msg, clientAuth...)
+		msg = append(msg, authProof(key, clientNonce)...)
 		if _, err := w.Write(msg); err != nil {
 			return nil, nil, fmt.Errorf("write handshake: %w", err)
 		}
 
-		respPrefix := make([]byte, 3)
-		if _, err := io.ReadFull(r, respPrefix); err != nil {
-			return nil, nil, fmt.Errorf("read handshake response: %w", err)
-		}
-		if string(respPrefix) != "OK\x00" {
-			rest, _ := io.ReadAll(r)
-			raw := append(respPrefix, rest...)
-			line := strings.TrimSuffix(string(raw), "\n")
-
-			var apiErr apitypes.ApiError
-			if err := json.Unmarshal([]byte(line), &apiErr); err == nil && (apiErr.Status != 0 || apiErr.Title != "") {
-				return nil, nil, &apiErr
-			}
-			return nil, nil, fmt.Errorf("invalid handshake response from server: %s", line)
-		}
-
-		serverNonce = make([]byte, NonceSize)
-		if _, err := io.ReadFull(r, serverNonce); err != nil {
-			return nil, nil, fmt.Errorf("read server nonce: %w", err)
+		serverNonce, err = readServerHandshake(r)
+		if err != nil {
+			return nil, nil, err
 		}
 		return clientNonce, serverNonce, nil
 	}
 
-	_, err = r.Discard(len(HandshakeMagic))
-	if err != nil {
-		return nil, nil, fmt.Errorf("discard handshake magic: %w", err)
+	_, clientNonce, serverNonce, err = AcceptAuth(r, w, key, nil)
+	return clientNonce, serverNonce, err
+}
+
+// AcceptAuth performs the server side of the password handshake, signing it
+// with identity if the client asks for it, and returns the key to derive the
+// session key from. Without an identity, signed handshakes are refused.
+func AcceptAuth(r *bufio.Reader, w io.Writer, key []byte, identity *Identity) (handshakeKey, clientNonce, serverNonce []byte, err error) {
+	magic, _ := r.Peek(len(HandshakeMagic))
+	signed := string(magic) == SignedHandshakeMagic
+	if _, err := r.Discard(len(HandshakeMagic)); err != nil {
+		return nil, nil, nil, fmt.Errorf("discard handshake magic: %w", err)
 	}
 
 	clientNonce, err = ReadClientNonce(r)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	clientAuth := make([]byte, sha256.Size)
 	if _, err := io.ReadFull(r, clientAuth); err != nil {
-		return nil, nil, fmt.Errorf("read client auth: %w", err)
+		return nil, nil, nil, fmt.Errorf("read client auth: %w", err)
 	}
 
-	mac := hmac.New(sha256.New, key)
-	_, _ = mac.Write([]byte(authContext))
-	_, _ = mac.Write(clientNonce)
-	expectedAuth := mac.Sum(nil)
-	if !hmac.Equal(clientAuth, expectedAuth) {
-		return nil, nil, apierror.ErrUnauthorized("invalid password")
+	if !hmac.Equal(clientAuth, authProof(key, clientNonce)) {
+		return nil, nil, nil, apierror.ErrUnauthorized("invalid password")
 	}
 
+	if signed {
+		if identity == nil {
+			return nil, nil, nil, apierror.ErrBadRequest("server has no identity")
+		}
+		msg := append([]byte(SignedHandshakeMagic), clientNonce...)
+		msg = append(msg, clientAuth...)
+		handshakeKey, serverNonce, err = identity.answer(r, w, msg, key)
+		return handshakeKey, clientNonce, serverNonce, err
+	}
 	serverNonce, err = WriteServerHandshake(w)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	return clientNonce, serverNonce, nil
+	return key, clientNonce, serverNonce, nil
+}
+
+// authProof proves knowledge of key for a handshake with clientNonce.
+func authProof(key, clientNonce []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(authContext))
+	_, _ = mac.Write(clientNonce)
+	return mac.Sum(nil)
+}
+
+// readServerHandshake reads the server nonce, or the error the server refused
+// the handshake with.
+func readServerHandshake(r io.Reader) ([]byte, error) {
+	respPrefix := make([]byte, 3)
+	if _, err := io.ReadFull(r, respPrefix); err != nil {
+		return nil, fmt.Errorf("read handshake response: %w", err)
+	}
+	if string(respPrefix) != "OK\x00" {
+		rest, _ := io.ReadAll(r)
+		raw := append(respPrefix, rest...)
+		line := strings.TrimSuffix(string(raw), "\n")
+
+		var apiErr apitypes.ApiError
+		if err := json.Unmarshal([]byte(line), &apiErr); err == nil && (apiErr.Status != 0 || apiErr.Title != "") {
+			return nil, &apiErr
+		}
+		return nil, fmt.Errorf("invalid handshake response from server: %s", line)
+	}
+
+	serverNonce := make([]byte, NonceSize)
+	if _, err := io.ReadFull(r, serverNonce); err != nil {
+		return nil, fmt.Errorf("read server nonce: %w", err)
+	}
+	return serverNonce, nil
 }
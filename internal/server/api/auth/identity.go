@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+)
+
+// Server identity lets a client check it reached the server it expects and
+// not someone relaying the handshake, who may know the shared password.
+// Clients asking for it start the password or token handshake with the
+// signed magic instead and append an ephemeral ECDH P-256 public key. The
+// server answers with its own ephemeral key and an ECDSA P-256 signature of
+// the handshake under its static identity key, which clients pin by
+// fingerprint. The ECDH secret is mixed into the handshake key, so a relay
+// cannot read the connection either.
+const (
+	SignedHandshakeMagic = "eVS1\x00"
+	SignedTokenMagic     = "eVK1\x00"
+	// PointSize is the size of an uncompressed P-256 public key.
+	PointSize = 65
+	// SignatureSize is the size of a signature, r and s of 32 bytes each.
+	SignatureSize   = 64
+	identityContext = "VIIPER-Identity-v1"
+)
+
+// ErrIdentityMismatch is returned by the signed handshakes when the server
+// presents an identity other than the pinned one.
+var ErrIdentityMismatch = errors.New("server identity does not match the pinned fingerprint")
+
+// Identity is the static key a server signs handshakes with.
+type Identity struct {
+	key *ecdsa.PrivateKey
+	pub []byte
+}
+
+// NewIdentity generates an identity.
+func NewIdentity() (*Identity, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate identity: %w", err)
+	}
+	return newIdentity(key)
+}
+
+func newIdentity(key *ecdsa.PrivateKey) (*Identity, error) {
+	pub, err := key.PublicKey.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("identity: %w", err)
+	}
+	return &Identity{key: key, pub: pub}, nil
+}
+
+// ParseIdentity parses a PEM encoded P-256 private key, PKCS #8 ("PRIVATE
+// KEY") or SEC 1 ("EC PRIVATE KEY"), as written by MarshalPEM or openssl.
+func ParseIdentity(data []byte) (*Identity, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("identity: no PEM data")
+	}
+	var key any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("identity: unexpected PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("identity: %w", err)
+	}
+	ec, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ec.Curve != elliptic.P256() {
+		return nil, errors.New("identity: not a P-256 key")
+	}
+	return newIdentity(ec)
+}
+
+// LoadIdentity reads an identity from a PEM file, see ParseIdentity.
+func LoadIdentity(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read identity: %w", err)
+	}
+	return ParseIdentity(data)
+}
+
+// MarshalPEM encodes the identity as a PKCS #8 PEM block.
+func (id *Identity) MarshalPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(id.key)
+	if err != nil {
+		return nil, fmt.Errorf("identity: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// Fingerprint returns the fingerprint clients pin the identity by.
+func (id *Identity) Fingerprint() string { return Fingerprint(id.pub) }
+
+// Fingerprint returns the fingerprint of an uncompressed P-256 public key:
+// "sha256:" followed by the hex encoded SHA-256 of the key.
+func Fingerprint(pub []byte) string {
+	sum := sha256.Sum256(pub)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// SignedAuthHandshake performs the client side of the password handshake
+// with the server proving the identity of fingerprint.
+// Sends: SignedHandshakeMagic + client_nonce[32] + proof[32] + client_key[65]
+// and returns the key to derive the session key from in place of key.
+func SignedAuthHandshake(r io.Reader, w io.Writer, key []byte, fingerprint string) (handshakeKey, clientNonce, serverNonce []byte, err error) {
+	clientNonce = make([]byte, NonceSize)
+	if _, err := rand.Read(clientNonce); err != nil {
+		return nil, nil, nil, fmt.Errorf("generate client nonce: %w", err)
+	}
+	msg := append([]byte(SignedHandshakeMagic), clientNonce...)
+	msg = append(msg, authProof(key, clientNonce)...)
+	handshakeKey, serverNonce, err = signedHandshake(r, w, msg, key, fingerprint)
+	return handshakeKey, clientNonce, serverNonce, err
+}
+
+// SignedTokenHandshake is TokenHandshake with the server proving the
+// identity of fingerprint, see SignedAuthHandshake.
+// Sends: SignedTokenMagic + client_nonce[32] + proof[32] + id_len(u8) + id + client_key[65]
+func SignedTokenHandshake(r io.Reader, w io.Writer, id string, key []byte, fingerprint string) (handshakeKey, clientNonce, serverNonce []byte, err error) {
+	if id == "" || len(id) > MaxTokenIDLen {
+		return nil, nil, nil, fmt.Errorf("token handshake: invalid token ID")
+	}
+	clientNonce = make([]byte, NonceSize)
+	if _, err := rand.Read(clientNonce); err != nil {
+		return nil, nil, nil, fmt.Errorf("generate client nonce: %w", err)
+	}
+	msg := append([]byte(SignedTokenMagic), clientNonce...)
+	msg = append(msg, authProof(key, clientNonce)...)
+	msg = append(msg, byte(len(id)))
+	msg = append(msg, id...)
+	handshakeKey, serverNonce, err = signedHandshake(r, w, msg, key, fingerprint)
+	return handshakeKey, clientNonce, serverNonce, err
+}
+
+// signedHandshake sends msg with an ephemeral ECDH key appended and checks
+// the server's answer.
+// Receives: "OK\0" + server_nonce[32] + server_key[65] + identity[65] + signature[64]
+func signedHandshake(r io.Reader, w io.Writer, msg, key []byte, fingerprint string) (handshakeKey, serverNonce []byte, err error) {
+	eph, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate handshake key: %w", err)
+	}
+	msg = append(msg, eph.PublicKey().Bytes()...)
+	if _, err := w.Write(msg); err != nil {
+		return nil, nil, fmt.Errorf("write handshake: %w", err)
+	}
+
+	serverNonce, err = readServerHandshake(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("signed handshake: %w", err)
+	}
+	rest := make([]byte, 2*PointSize+SignatureSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, nil, fmt.Errorf("read server identity: %w", err)
+	}
+	serverKey, identity, sig := rest[:PointSize], rest[PointSize:2*PointSize], rest[2*PointSize:]
+
+	if Fingerprint(identity) != fingerprint {
+		return nil, nil, fmt.Errorf("%w: server presented %s", ErrIdentityMismatch, Fingerprint(identity))
+	}
+	pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), identity)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse server identity: %w", err)
+	}
+	digest := transcript(msg, serverNonce, serverKey)
+	rs, ss := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(pub, digest, rs, ss) {
+		return nil, nil, fmt.Errorf("%w: invalid handshake signature", ErrIdentityMismatch)
+	}
+
+	peer, err := ecdh.P256().NewPublicKey(serverKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse server handshake key: %w", err)
+	}
+	shared, err := eph.ECDH(peer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("handshake key agreement: %w", err)
+	}
+	return mixShared(key, shared), serverNonce, nil
+}
+
+// answer completes the server side of a signed handshake whose client
+// message, up to the client's ECDH key, was msg, and returns the key to
+// derive the session key from in place of key.
+func (id *Identity) answer(r io.Reader, w io.Writer, msg, key []byte) (handshakeKey, serverNonce []byte, err error) {
+	clientKey := make([]byte, PointSize)
+	if _, err := io.ReadFull(r, clientKey); err != nil {
+		return nil, nil, fmt.Errorf("read client handshake key: %w", err)
+	}
+	peer, err := ecdh.P256().NewPublicKey(clientKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse client handshake key: %w", err)
+	}
+	eph, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate handshake key: %w", err)
+	}
+	shared, err := eph.ECDH(peer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("handshake key agreement: %w", err)
+	}
+
+	serverNonce = make([]byte, NonceSize)
+	if _, err := rand.Read(serverNonce); err != nil {
+		return nil, nil, fmt.Errorf("generate server nonce: %w", err)
+	}
+	serverKey := eph.PublicKey().Bytes()
+	rs, ss, err := ecdsa.Sign(rand.Reader, id.key, transcript(append(msg, clientKey...), serverNonce, serverKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("sign handshake: %w", err)
+	}
+
+	resp := append([]byte("OK\x00"), serverNonce...)
+	resp = append(resp, serverKey...)
+	resp = append(resp, id.pub...)
+	resp = append(resp, rs.FillBytes(make([]byte, 32))...)
+	resp = append(resp, ss.FillBytes(make([]byte, 32))...)
+	if _, err := w.Write(resp); err != nil {
+		return nil, nil, fmt.Errorf("write response: %w", err)
+	}
+	return mixShared(key, shared), serverNonce, nil
+}
+
+// transcript returns the digest the server signs: SHA-256 over the
+// identity context, everything the client sent, the server nonce and the
+// server's ECDH key.
+func transcript(clientMsg, serverNonce, serverKey []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(identityContext))
+	h.Write(clientMsg)
+	h.Write(serverNonce)
+	h.Write(serverKey)
+	return h.Sum(nil)
+}
+
+// mixShared binds the handshake key to the ECDH secret.
+func mixShared(key, shared []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(identityContext))
+	_, _ = mac.Write(shared)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,115 @@
+package auth_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type signedResult struct {
+	sessionKey []byte
+	err        error
+}
+
+// signed runs a signed handshake, a token one if id is set, between a client
+// pinning fingerprint and a server with identity, returning both session keys.
+func signed(t testing.TB, identity *auth.Identity, key []byte, id, fingerprint string) (client, server signedResult) {
+	t.Helper()
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	defer sc.Close()
+
+	done := make(chan signedResult, 1)
+	go func() {
+		r := bufio.NewReader(sc)
+		var handshakeKey, clientNonce, serverNonce []byte
+		var err error
+		if id != "" {
+			keyOf := func(string) ([]byte, bool) { return key, true }
+			_, handshakeKey, clientNonce, serverNonce, err = auth.AcceptToken(r, sc, keyOf, identity)
+		} else {
+			handshakeKey, clientNonce, serverNonce, err = auth.AcceptAuth(r, sc, key, identity)
+		}
+		if err != nil {
+			problem, _ := json.Marshal(err)
+			_, _ = sc.Write(append(problem, '\n'))
+			sc.Close()
+			done <- signedResult{err: err}
+			return
+		}
+		done <- signedResult{sessionKey: auth.DeriveSessionKey(handshakeKey, serverNonce, clientNonce)}
+	}()
+
+	var handshakeKey, clientNonce, serverNonce []byte
+	var err error
+	if id != "" {
+		handshakeKey, clientNonce, serverNonce, err = auth.SignedTokenHandshake(cc, cc, id, key, fingerprint)
+	} else {
+		handshakeKey, clientNonce, serverNonce, err = auth.SignedAuthHandshake(cc, cc, key, fingerprint)
+	}
+	if err == nil {
+		client.sessionKey = auth.DeriveSessionKey(handshakeKey, serverNonce, clientNonce)
+	}
+	client.err = err
+	return client, <-done
+}
+
+func TestSignedHandshake(t *testing.T) {
+	key, err := auth.DeriveKey("s3cret")
+	require.NoError(t, err)
+	identity, err := auth.NewIdentity()
+	require.NoError(t, err)
+
+	for _, id := range []string{"", "pad"} {
+		c, s := signed(t, identity, key, id, identity.Fingerprint())
+		require.NoError(t, c.err, id)
+		require.NoError(t, s.err, id)
+		assert.Equal(t, c.sessionKey, s.sessionKey, id)
+	}
+}
+
+func TestSignedHandshakeRejected(t *testing.T) {
+	key, err := auth.DeriveKey("s3cret")
+	require.NoError(t, err)
+	identity, err := auth.NewIdentity()
+	require.NoError(t, err)
+	other, err := auth.NewIdentity()
+	require.NoError(t, err)
+
+	t.Run("other identity", func(t *testing.T) {
+		c, _ := signed(t, other, key, "", identity.Fingerprint())
+		require.ErrorIs(t, c.err, auth.ErrIdentityMismatch)
+		assert.ErrorContains(t, c.err, other.Fingerprint())
+	})
+
+	t.Run("server without identity", func(t *testing.T) {
+		c, s := signed(t, nil, key, "pad", identity.Fingerprint())
+		assert.EqualError(t, s.err, "400 Bad Request: server has no identity")
+		var apiErr *apitypes.ApiError
+		require.ErrorAs(t, c.err, &apiErr)
+		assert.Equal(t, 400, apiErr.Status)
+	})
+}
+
+func TestIdentityPEM(t *testing.T) {
+	identity, err := auth.NewIdentity()
+	require.NoError(t, err)
+	pemData, err := identity.MarshalPEM()
+	require.NoError(t, err)
+
+	parsed, err := auth.ParseIdentity(pemData)
+	require.NoError(t, err)
+	assert.Equal(t, identity.Fingerprint(), parsed.Fingerprint())
+	assert.True(t, strings.HasPrefix(parsed.Fingerprint(), "sha256:"))
+	assert.Len(t, parsed.Fingerprint(), len("sha256:")+64)
+
+	_, err = auth.ParseIdentity([]byte("not a key"))
+	assert.Error(t, err)
+}
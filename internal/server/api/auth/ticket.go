@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+)
+
+// Session resumption lets a client that completed a full handshake skip the
+// password proof, and the PBKDF2 behind it, on later connections: it fetches
+// a ticket over the encrypted connection and presents it instead.
+const (
+	ResumeMagic = "eVR1\x00"
+	// TicketSize is the size of a ticket: its expiry in Unix nanoseconds
+	// (u64, big endian) followed by 16 random bytes.
+	TicketSize    = 24
+	resumeContext = "VIIPER-Resume-v1"
+)
+
+// Tickets issues and checks resumption tickets. They are stateless: the
+// secret a ticket grants is an HMAC of the ticket under a random key only
+// this Tickets knows, so replacing it revokes every ticket issued before.
+type Tickets struct {
+	key      []byte
+	lifetime time.Duration
+}
+
+// NewTickets creates an issuer of tickets valid for lifetime.
+func NewTickets(lifetime time.Duration) (*Tickets, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generate ticket key: %w", err)
+	}
+	return &Tickets{key: key, lifetime: lifetime}, nil
+}
+
+// Issue returns a new ticket and the secret it grants. Hand both to the
+// client over an encrypted connection only.
+func (t *Tickets) Issue() (ticket, secret []byte, expires time.Time, err error) {
+	expires = time.Now().Add(t.lifetime)
+	ticket = make([]byte, TicketSize)
+	binary.BigEndian.PutUint64(ticket, uint64(expires.UnixNano()))
+	if _, err := rand.Read(ticket[8:]); err != nil {
+		return nil, nil, time.Time{}, fmt.Errorf("generate ticket: %w", err)
+	}
+	return ticket, t.secret(ticket), expires, nil
+}
+
+func (t *Tickets) secret(ticket []byte) []byte {
+	mac := hmac.New(sha256.New, t.key)
+	_, _ = mac.Write(ticket)
+	return mac.Sum(nil)
+}
+
+// IsResumeHandshake checks if the next bytes in reader match ResumeMagic.
+func IsResumeHandshake(r *bufio.Reader) (bool, error) {
+	b, err := r.Peek(len(ResumeMagic))
+	if err != nil {
+		return false, err
+	}
+	return string(b) == ResumeMagic, nil
+}
+
+// ResumeHandshake performs the client side of a resumed handshake.
+// Sends: ResumeMagic + client_nonce[32] + ticket[24] + proof[32], where the
+// proof is an HMAC of the nonce and ticket under the ticket secret. The
+// session key derives from the secret like it does from the password key.
+func ResumeHandshake(r io.Reader, w io.Writer, ticket, secret []byte) (clientNonce, serverNonce []byte, err error) {
+	if len(ticket) != TicketSize {
+		return nil, nil, fmt.Errorf("resume: ticket must be %d bytes", TicketSize)
+	}
+	clientNonce = make([]byte, NonceSize)
+	if _, err := rand.Read(clientNonce); err != nil {
+		return nil, nil, fmt.Errorf("generate client nonce: %w", err)
+	}
+
+	msg := append([]byte(ResumeMagic), clientNonce...)
+	msg = append(msg, ticket...)
+	msg = append(msg, resumeProof(secret, clientNonce, ticket)...)
+	if _, err := w.Write(msg); err != nil {
+		return nil, nil, fmt.Errorf("write resume handshake: %w", err)
+	}
+
+	serverNonce, err = readServerHandshake(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return clientNonce, serverNonce, nil
+}
+
+// Accept performs the server side of a resumed handshake and returns the
+// secret of the presented ticket. Expired tickets, tickets of another issuer
+// and wrong proofs are refused with 401.
+func (t *Tickets) Accept(r *bufio.Reader, w io.Writer) (secret, clientNonce, serverNonce []byte, err error) {
+	if _, err := r.Discard(len(ResumeMagic)); err != nil {
+		return nil, nil, nil, fmt.Errorf("discard resume magic: %w", err)
+	}
+	clientNonce, err = ReadClientNonce(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ticket := make([]byte, TicketSize)
+	if _, err := io.ReadFull(r, ticket); err != nil {
+		return nil, nil, nil, fmt.Errorf("read ticket: %w", err)
+	}
+	proof := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, proof); err != nil {
+		return nil, nil, nil, fmt.Errorf("read resume proof: %w", err)
+	}
+
+	expires := time.Unix(0, int64(binary.BigEndian.Uint64(ticket)))
+	secret = t.secret(ticket)
+	if !time.Now().Before(expires) || !hmac.Equal(proof, resumeProof(secret, clientNonce, ticket)) {
+		return nil, nil, nil, apierror.ErrUnauthorized("invalid or expired ticket")
+	}
+
+	serverNonce, err = WriteServerHandshake(w)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return secret, clientNonce, serverNonce, nil
+}
+
+func resumeProof(secret, clientNonce, ticket []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(resumeContext))
+	_, _ = mac.Write(clientNonce)
+	_, _ = mac.Write(ticket)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,156 @@
+package auth_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type resumeResult struct {
+	sessionKey []byte
+	err        error
+}
+
+// resume runs a resumed handshake between a client presenting ticket and
+// secret and a server accepting with tickets, returning both session keys.
+func resume(t testing.TB, tickets *auth.Tickets, ticket, secret []byte) (client, server resumeResult) {
+	t.Helper()
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	defer sc.Close()
+
+	done := make(chan resumeResult, 1)
+	go func() {
+		r := bufio.NewReader(sc)
+		if ok, err := auth.IsResumeHandshake(r); !ok {
+			done <- resumeResult{err: err}
+			return
+		}
+		secret, clientNonce, serverNonce, err := tickets.Accept(r, sc)
+		if err != nil {
+			// Like the API server, answer refusals before closing.
+			_, _ = sc.Write([]byte(`{"status":401,"title":"Unauthorized"}` + "\n"))
+			sc.Close()
+			done <- resumeResult{err: err}
+			return
+		}
+		done <- resumeResult{sessionKey: auth.DeriveSessionKey(secret, serverNonce, clientNonce)}
+	}()
+
+	clientNonce, serverNonce, err := auth.ResumeHandshake(cc, cc, ticket, secret)
+	if err == nil {
+		client.sessionKey = auth.DeriveSessionKey(secret, serverNonce, clientNonce)
+	}
+	client.err = err
+	return client, <-done
+}
+
+func TestResumeHandshake(t *testing.T) {
+	tickets, err := auth.NewTickets(time.Hour)
+	require.NoError(t, err)
+	ticket, secret, expires, err := tickets.Issue()
+	require.NoError(t, err)
+	require.Len(t, ticket, auth.TicketSize)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expires, time.Minute)
+
+	c1, s1 := resume(t, tickets, ticket, secret)
+	require.NoError(t, c1.err)
+	require.NoError(t, s1.err)
+	assert.Equal(t, c1.sessionKey, s1.sessionKey)
+
+	// Every connection derives a fresh key from new nonces.
+	c2, s2 := resume(t, tickets, ticket, secret)
+	require.NoError(t, c2.err)
+	require.NoError(t, s2.err)
+	assert.NotEqual(t, c1.sessionKey, c2.sessionKey)
+}
+
+func TestResumeHandshakeRejected(t *testing.T) {
+	tickets, err := auth.NewTickets(time.Hour)
+	require.NoError(t, err)
+	ticket, secret, _, err := tickets.Issue()
+	require.NoError(t, err)
+
+	other, err := auth.NewTickets(time.Hour)
+	require.NoError(t, err)
+	otherTicket, otherSecret, _, err := other.Issue()
+	require.NoError(t, err)
+
+	// Moving the expiry changes the secret the ticket grants.
+	extended := bytes.Clone(ticket)
+	binary.BigEndian.PutUint64(extended, uint64(time.Now().Add(48*time.Hour).UnixNano()))
+
+	short, err := auth.NewTickets(time.Millisecond)
+	require.NoError(t, err)
+	expiredTicket, expiredSecret, _, err := short.Issue()
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	tests := []struct {
+		name           string
+		tickets        *auth.Tickets
+		ticket, secret []byte
+	}{
+		{"wrong secret", tickets, ticket, otherSecret},
+		{"other issuer", tickets, otherTicket, otherSecret},
+		{"extended expiry", tickets, extended, secret},
+		{"expired", short, expiredTicket, expiredSecret},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, s := resume(t, tc.tickets, tc.ticket, tc.secret)
+			assert.EqualError(t, s.err, "401 Unauthorized: invalid or expired ticket")
+			var apiErr *apitypes.ApiError
+			require.ErrorAs(t, c.err, &apiErr)
+			assert.Equal(t, 401, apiErr.Status)
+		})
+	}
+}
+
+// BenchmarkConnectionSetup compares the handshakes of a new connection, both
+// sides included: the full one derives the password key on each side as the
+// API server and clients do per connection.
+func BenchmarkConnectionSetup(b *testing.B) {
+	const password = "benchpassword123"
+
+	b.Run("full", func(b *testing.B) {
+		for b.Loop() {
+			cc, sc := net.Pipe()
+			done := make(chan error, 1)
+			go func() {
+				key, err := auth.DeriveKey(password)
+				if err == nil {
+					_, _, err = auth.HandleAuthHandshake(bufio.NewReader(sc), sc, key, false)
+				}
+				done <- err
+			}()
+			key, err := auth.DeriveKey(password)
+			require.NoError(b, err)
+			_, _, err = auth.HandleAuthHandshake(bufio.NewReader(cc), cc, key, true)
+			require.NoError(b, err)
+			require.NoError(b, <-done)
+			cc.Close()
+			sc.Close()
+		}
+	})
+
+	b.Run("resumed", func(b *testing.B) {
+		tickets, err := auth.NewTickets(time.Hour)
+		require.NoError(b, err)
+		ticket, secret, _, err := tickets.Issue()
+		require.NoError(b, err)
+		for b.Loop() {
+			c, s := resume(b, tickets, ticket, secret)
+			require.NoError(b, c.err)
+			require.NoError(b, s.err)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+)
+
+// Tokens are per-client credentials with scoped permissions, an alternative
+// to the shared password. A token handshake is the password handshake under
+// the key of the token's secret, followed by the token ID so the server
+// knows which key to check the proof with.
+const (
+	TokenMagic    = "eVT1\x00"
+	MaxTokenIDLen = 64
+)
+
+// ScopeAdmin grants every scope.
+const ScopeAdmin = "admin"
+
+// Token is a client credential and the scopes it grants, e.g. "bus:create",
+// "device:add:xbox360" or "stream". MaxPriority caps the priority its streams
+// ask for, "low", "normal" (the default) or "high"; admin tokens are uncapped.
+type Token struct {
+	ID          string   `json:"id"`
+	Secret      string   `json:"secret"`
+	Scopes      []string `json:"scopes"`
+	MaxPriority string   `json:"maxPriority,omitempty"`
+}
+
+// Allows reports whether t grants scope. A granted scope also covers the
+// scopes below it: "device:add" allows "device:add:xbox360".
+func (t *Token) Allows(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == ScopeAdmin || s == scope || strings.HasPrefix(scope, s+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseToken splits a token as handed to clients, "<id>:<secret>".
+func ParseToken(token string) (id, secret string, err error) {
+	id, secret, ok := strings.Cut(token, ":")
+	if !ok || id == "" || secret == "" {
+		return "", "", fmt.Errorf("token must be <id>:<secret>")
+	}
+	if len(id) > MaxTokenIDLen {
+		return "", "", fmt.Errorf("token ID exceeds %d bytes", MaxTokenIDLen)
+	}
+	return id, secret, nil
+}
+
+// LoadTokens reads a JSON array of tokens from path.
+func LoadTokens(path string) ([]Token, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read token file: %w", err)
+	}
+	var tokens []Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("parse token file: %w", err)
+	}
+	seen := map[string]bool{}
+	for _, t := range tokens {
+		if _, _, err := ParseToken(t.ID + ":" + t.Secret); err != nil || strings.Contains(t.ID, ":") {
+			return nil, fmt.Errorf("token %q: needs an ID of at most %d bytes without ':' and a secret", t.ID, MaxTokenIDLen)
+		}
+		if seen[t.ID] {
+			return nil, fmt.Errorf("token %q is listed twice", t.ID)
+		}
+		switch t.MaxPriority {
+		case "", "low", "normal", "high":
+		default:
+			return nil, fmt.Errorf("token %q: unknown maxPriority %q", t.ID, t.MaxPriority)
+		}
+		seen[t.ID] = true
+	}
+	return tokens, nil
+}
+
+// IsTokenHandshake checks if the next bytes in reader match TokenMagic or
+// SignedTokenMagic.
+func IsTokenHandshake(r *bufio.Reader) (bool, error) {
+	b, err := r.Peek(len(TokenMagic))
+	if err != nil {
+		return false, err
+	}
+	return string(b) == TokenMagic || string(b) == SignedTokenMagic, nil
+}
+
+// TokenHandshake performs the client side of a token handshake, key being
+// DeriveKey of the token's secret.
+// Sends: TokenMagic + client_nonce[32] + proof[32] + id_len(u8) + id
+func TokenHandshake(r io.Reader, w io.Writer, id string, key []byte) (clientNonce, serverNonce []byte, err error) {
+	if id == "" || len(id) > MaxTokenIDLen {
+		return nil, nil, fmt.Errorf("token handshake: invalid token ID")
+	}
+	clientNonce = make([]byte, NonceSize)
+	if _, err := rand.Read(clientNonce); err != nil {
+		return nil, nil, fmt.Errorf("generate client nonce: %w", err)
+	}
+
+	msg := append([]byte(TokenMagic), clientNonce...)
+	msg = append(msg, authProof(key, clientNonce)...)
+	msg = append(msg, byte(len(id)))
+	msg = append(msg, id...)
+	if _, err := w.Write(msg); err != nil {
+		return nil, nil, fmt.Errorf("write token handshake: %w", err)
+	}
+
+	serverNonce, err = readServerHandshake(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return clientNonce, serverNonce, nil
+}
+
+// AcceptToken performs the server side of a token handshake, signing it
+// with identity if the client asks for it, see AcceptAuth. keyOf returns the
+// key of a known token ID. Unknown IDs and wrong proofs are refused with 401
+// alike. The returned key is the one to derive the session key from.
+func AcceptToken(r *bufio.Reader, w io.Writer, keyOf func(id string) ([]byte, bool), identity *Identity) (id string, handshakeKey, clientNonce, serverNonce []byte, err error) {
+	magic, _ := r.Peek(len(TokenMagic))
+	signed := string(magic) == SignedTokenMagic
+	if _, err := r.Discard(len(TokenMagic)); err != nil {
+		return "", nil, nil, nil, fmt.Errorf("discard token magic: %w", err)
+	}
+	clientNonce, err = ReadClientNonce(r)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	proof := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, proof); err != nil {
+		return "", nil, nil, nil, fmt.Errorf("read token proof: %w", err)
+	}
+	idLen, err := r.ReadByte()
+	if err != nil {
+		return "", nil, nil, nil, fmt.Errorf("read token ID: %w", err)
+	}
+	idBytes := make([]byte, idLen)
+	if _, err := io.ReadFull(r, idBytes); err != nil {
+		return "", nil, nil, nil, fmt.Errorf("read token ID: %w", err)
+	}
+	id = string(idBytes)
+
+	key, ok := keyOf(id)
+	if !ok || !hmac.Equal(proof, authProof(key, clientNonce)) {
+		return "", nil, nil, nil, apierror.ErrUnauthorized("invalid token")
+	}
+
+	if signed {
+		if identity == nil {
+			return "", nil, nil, nil, apierror.ErrBadRequest("server has no identity")
+		}
+		msg := append([]byte(SignedTokenMagic), clientNonce...)
+		msg = append(msg, proof...)
+		msg = append(append(msg, idLen), idBytes...)
+		handshakeKey, serverNonce, err = identity.answer(r, w, msg, key)
+		if err != nil {
+			return "", nil, nil, nil, err
+		}
+		return id, handshakeKey, clientNonce, serverNonce, nil
+	}
+	serverNonce, err = WriteServerHandshake(w)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	return id, key, clientNonce, serverNonce, nil
+}
@@ -0,0 +1,115 @@
+package auth_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	apitypes "github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenAllows(t *testing.T) {
+	tok := auth.Token{ID: "pad", Scopes: []string{"stream", "device:add:keyboard", "bus"}}
+	assert.True(t, tok.Allows("stream"))
+	assert.True(t, tok.Allows("device:add:keyboard"))
+	assert.True(t, tok.Allows("bus:create"), "prefix scope")
+	assert.False(t, tok.Allows("device:add:xbox360"))
+	assert.False(t, tok.Allows("device:add"), "narrower scope does not cover its parent")
+	assert.False(t, tok.Allows("streaming"))
+
+	admin := auth.Token{ID: "root", Scopes: []string{auth.ScopeAdmin}}
+	assert.True(t, admin.Allows("bus:remove"))
+}
+
+func TestParseToken(t *testing.T) {
+	id, secret, err := auth.ParseToken("pad:s3cr:et")
+	require.NoError(t, err)
+	assert.Equal(t, "pad", id)
+	assert.Equal(t, "s3cr:et", secret)
+
+	for _, bad := range []string{"", "pad", "pad:", ":secret"} {
+		_, _, err := auth.ParseToken(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestLoadTokens(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "tokens.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	tokens, err := auth.LoadTokens(write(t, `[{"id":"pad","secret":"s","scopes":["stream"]}]`))
+	require.NoError(t, err)
+	assert.Equal(t, []auth.Token{{ID: "pad", Secret: "s", Scopes: []string{"stream"}}}, tokens)
+
+	_, err = auth.LoadTokens(write(t, `[{"id":"pad","secret":"s"},{"id":"pad","secret":"t"}]`))
+	assert.ErrorContains(t, err, "listed twice")
+	_, err = auth.LoadTokens(write(t, `[{"id":"a:b","secret":"s"}]`))
+	assert.Error(t, err)
+	_, err = auth.LoadTokens(write(t, `[{"id":"pad"}]`))
+	assert.Error(t, err)
+	_, err = auth.LoadTokens(write(t, `[{"id":"pad","secret":"s","maxPriority":"urgent"}]`))
+	assert.ErrorContains(t, err, "maxPriority")
+}
+
+func TestTokenHandshake(t *testing.T) {
+	key, err := auth.DeriveKey("s3cret")
+	require.NoError(t, err)
+	keyOf := func(id string) ([]byte, bool) { return key, id == "pad" }
+
+	run := func(id string, clientKey []byte) (serverID string, serverErr, clientErr error) {
+		client, server := net.Pipe()
+		defer client.Close()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer server.Close()
+			r := bufio.NewReader(server)
+			ok, err := auth.IsTokenHandshake(r)
+			if err != nil || !ok {
+				serverErr = errors.New("not a token handshake")
+				return
+			}
+			serverID, _, _, _, serverErr = auth.AcceptToken(r, server, keyOf, nil)
+			if serverErr != nil {
+				problem, _ := json.Marshal(serverErr)
+				_, _ = server.Write(append(problem, '\n'))
+			}
+		}()
+		_, _, clientErr = auth.TokenHandshake(client, client, id, clientKey)
+		<-done
+		return serverID, serverErr, clientErr
+	}
+
+	id, serverErr, clientErr := run("pad", key)
+	require.NoError(t, serverErr)
+	require.NoError(t, clientErr)
+	assert.Equal(t, "pad", id)
+
+	wrongKey, err := auth.DeriveKey("wrong")
+	require.NoError(t, err)
+	for name, tc := range map[string]struct {
+		id  string
+		key []byte
+	}{
+		"wrong secret": {"pad", wrongKey},
+		"unknown id":   {"other", key},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, serverErr, clientErr := run(tc.id, tc.key)
+			var apiErr apitypes.ApiError
+			require.ErrorAs(t, serverErr, &apiErr)
+			assert.Equal(t, 401, apiErr.Status)
+			assert.ErrorContains(t, clientErr, "invalid token")
+		})
+	}
+}
@@ -5,7 +5,6 @@ package api
 import (
 	"bytes"
 	"context"
-	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
@@ -24,7 +23,7 @@ func attachLocalhostClientImpl(ctx context.Context, deviceExportMeta *usbip.Expo
 		strconv.FormatUint(uint64(usbipServerPort), 10),
 		"attach",
 		"-r", "localhost",
-		"-b", fmt.Sprintf("%d-%d", deviceExportMeta.BusId, deviceExportMeta.DevId),
+		"-b", deviceExportMeta.BusIDString(),
 	)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
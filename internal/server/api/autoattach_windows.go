@@ -96,7 +96,7 @@ func attachViaIOCTL(ctx context.Context, deviceExportMeta *usbip.ExportMeta, usb
 	var ioctlData attachIOCTL
 	ioctlData.Size = uint32(unsafe.Sizeof(ioctlData))
 
-	busID := fmt.Sprintf("%d-%d", deviceExportMeta.BusId, deviceExportMeta.DevId)
+	busID := deviceExportMeta.BusIDString()
 	if len(busID) >= len(ioctlData.BusID) {
 		return fmt.Errorf("ArgumentValidation: bus ID too long: %s", busID)
 	}
@@ -169,7 +169,7 @@ func attachViaCommand(ctx context.Context, deviceExportMeta *usbip.ExportMeta, u
 		strconv.FormatUint(uint64(usbipServerPort), 10),
 		"attach",
 		"-r", "localhost",
-		"-b", fmt.Sprintf("%d-%d", deviceExportMeta.BusId, deviceExportMeta.DevId),
+		"-b", deviceExportMeta.BusIDString(),
 	)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
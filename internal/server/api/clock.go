@@ -0,0 +1,32 @@
+package api
+
+import (
+	"time"
+
+	"github.com/Alia5/VIIPER/device"
+)
+
+// SetClock replaces the clock behind the server's timestamps, e.g. with a
+// fake one in tests, and restarts the monotonic epoch at its current time.
+func (s *Server) SetClock(c device.Clock) {
+	s.clockMu.Lock()
+	defer s.clockMu.Unlock()
+	s.clock = c
+	s.epoch = c.Now()
+}
+
+// MonoNow returns the server monotonic time: nanoseconds since the epoch,
+// as carried by "...MonoNs" DTO fields.
+func (s *Server) MonoNow() int64 {
+	s.clockMu.Lock()
+	defer s.clockMu.Unlock()
+	return s.clock.Now().Sub(s.epoch).Nanoseconds()
+}
+
+// WallNow returns the server wall-clock time, as carried by "...At" DTO
+// fields once formatted with time.RFC3339Nano in UTC.
+func (s *Server) WallNow() time.Time {
+	s.clockMu.Lock()
+	defer s.clockMu.Unlock()
+	return s.clock.Now()
+}
@@ -1,15 +1,32 @@
 package api
 
-import "time"
+import (
+	"time"
+
+	"github.com/Alia5/VIIPER/internal/server/api/auth"
+)
 
 // ServerConfig represents the server subcommand configuration.
 type ServerConfig struct {
 	Addr                        string        `help:"API server listen address" default:":3242" env:"VIIPER_API_ADDR"`
 	DeviceHandlerConnectTimeout time.Duration `help:"Time before auto-cleanup occurs when device handler has no active connection" default:"5s" env:"VIIPER_API_DEVICE_HANDLER_TIMEOUT"`
+	RestoredDeviceTimeout       time.Duration `help:"Time before auto-cleanup of a device restored from the state file that no device handler connected to yet (0 keeps it)" default:"0s" env:"VIIPER_API_RESTORED_DEVICE_TIMEOUT"`
 	AutoAttachLocalClient       bool          `help:"Controls usbip-client on localhost to auto-attach devices added to the virtual bus" default:"true" env:"VIIPER_API_AUTO_ATTACH_LOCAL_CLIENT"`
 	RequireLocalHostAuth        bool          `help:"Require authentication for clients connecting from localhost" default:"false" env:"VIIPER_API_REQUIRE_LOCALHOST_AUTH"`
+	RecordingDir                string        `help:"Directory for server-side device recordings (default: <temp>/viiper-recordings)" env:"VIIPER_API_RECORDING_DIR"`
+	RecordingRetention          time.Duration `help:"Delete device recordings older than this when a new recording starts (0 keeps all)" default:"24h" env:"VIIPER_API_RECORDING_RETENTION"`
+	ReadOnly                    bool          `help:"Refuse management requests that change state; device streams keep working" default:"false" env:"VIIPER_API_READ_ONLY"`
+	IdentityKey                 string        `help:"PEM P-256 private key the server signs handshakes with, for clients pinning its fingerprint (default: generated next to the password file)" env:"VIIPER_API_IDENTITY_KEY"`
+	ResumeTicketLifetime        time.Duration `help:"How long session resumption tickets let authenticated clients reconnect without the password handshake (0: 12h, negative disables resumption)" default:"12h" env:"VIIPER_API_RESUME_TICKET_LIFETIME"`
+	TLSCert                     string        `help:"PEM certificate file; with a key, the API is served over TLS only" env:"VIIPER_API_TLS_CERT"`
+	TLSKey                      string        `help:"PEM private key file of the TLS certificate" env:"VIIPER_API_TLS_KEY"`
+	TokenFile                   string        `help:"JSON file of per-client tokens with scoped permissions, accepted besides the password" env:"VIIPER_API_TOKEN_FILE"`
 	ConnectionTimeout           time.Duration `kong:"-"`
 	platformOpts                `embed:""`
 	// password for api (remote) server auth (ALWAYS read from file)
 	Password string `kong:"-"`
+	// Identity is loaded from IdentityKey; nil generates one on first use.
+	Identity *auth.Identity `kong:"-"`
+	// Tokens are loaded from TokenFile.
+	Tokens []auth.Token `kong:"-"`
 }
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// externalRegistration adapts a device type added with device.Register.
+type externalRegistration struct {
+	reg device.Registration
+}
+
+func (r externalRegistration) CreateDevice(o *device.CreateOptions) (usb.Device, error) {
+	return r.reg.Create(o)
+}
+
+func (r externalRegistration) StreamHandler() StreamHandlerFunc {
+	return func(conn net.Conn, devPtr *usb.Device, logger *slog.Logger) error {
+		if devPtr == nil || *devPtr == nil {
+			return fmt.Errorf("nil device")
+		}
+		in, ok := (*devPtr).(device.InputHandler)
+		if !ok {
+			return fmt.Errorf("device is not %s", r.reg.Name)
+		}
+		if fb, ok := (*devPtr).(device.FeedbackSender); ok && r.reg.Wire.OutputSize > 0 {
+			size := r.reg.Wire.OutputSize
+			fb.SetFeedback(func(msg []byte) error {
+				if len(msg) != size {
+					return fmt.Errorf("feedback message is %d bytes, want %d", len(msg), size)
+				}
+				_, err := conn.Write(msg)
+				return err
+			})
+			defer fb.SetFeedback(nil)
+		}
+
+		buf := make([]byte, r.reg.Wire.InputSize)
+		for {
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				if err == io.EOF {
+					logger.Info("client disconnected")
+					return nil
+				}
+				return fmt.Errorf("read input state: %w", err)
+			}
+			if err := in.HandleInput(buf); err != nil {
+				return fmt.Errorf("handle input: %w", err)
+			}
+		}
+	}
+}
@@ -16,6 +16,35 @@ type DeviceRegistration interface {
 	StreamHandler() StreamHandlerFunc
 }
 
+// DeltaRegistration is implemented by device types whose client-to-server
+// input state has a fixed wire layout, enabling delta-update streams.
+type DeltaRegistration interface {
+	// InputLayout returns the wire layout of the input state accepted by dev.
+	InputLayout(dev usb.Device) device.WireLayout
+}
+
+// EventRegistration is implemented by device types accepting event-mode
+// streams (see device.EventVersion).
+type EventRegistration interface {
+	// EventFolder returns a fresh event folder for a stream to dev.
+	EventFolder(dev usb.Device) device.EventFolder
+}
+
+// FeedbackRegistration is implemented by device types whose server-to-client
+// feedback message has a fixed wire layout.
+type FeedbackRegistration interface {
+	// OutputLayout returns the wire layout of the feedback messages sent for dev.
+	OutputLayout(dev usb.Device) device.WireLayout
+}
+
+// MacroRegistration is implemented by device types accepting server-side
+// macros (see Server.PlayMacro).
+type MacroRegistration interface {
+	// MacroState decodes an input state of dev from JSON, the fields of the
+	// device's InputState matched by name, into the function applying it.
+	MacroState(dev usb.Device, state []byte) (func(), error)
+}
+
 var (
 	deviceRegistry   = make(map[string]DeviceRegistration)
 	deviceRegistryMu sync.RWMutex
@@ -31,11 +60,19 @@ func RegisterDevice(name string, reg DeviceRegistration) {
 }
 
 // GetRegistration retrieves a registered device handler by name for device creation.
+// Types added with device.Register are found after the built-in ones.
 // Returns nil if not found. Name lookup is case-insensitive.
 func GetRegistration(name string) DeviceRegistration {
 	deviceRegistryMu.RLock()
-	defer deviceRegistryMu.RUnlock()
-	return deviceRegistry[toLower(name)]
+	reg, ok := deviceRegistry[toLower(name)]
+	deviceRegistryMu.RUnlock()
+	if ok {
+		return reg
+	}
+	if r, ok := device.LookupRegistration(name); ok {
+		return externalRegistration{r}
+	}
+	return nil
 }
 
 // ListDeviceTypes returns a list of all registered device type names.
@@ -46,6 +83,11 @@ func ListDeviceTypes() []string {
 	for name := range deviceRegistry {
 		types = append(types, name)
 	}
+	for _, name := range device.RegisteredNames() {
+		if _, ok := deviceRegistry[name]; !ok {
+			types = append(types, name)
+		}
+	}
 	return types
 }
 
@@ -8,6 +8,7 @@ import (
 	"reflect"
 	"strings"
 
+	"github.com/Alia5/VIIPER/device"
 	"github.com/Alia5/VIIPER/internal/server/usb"
 	pusb "github.com/Alia5/VIIPER/usb"
 )
@@ -39,6 +40,11 @@ func inferDeviceType(dev any) string {
 	if dev == nil {
 		return ""
 	}
+	if d, ok := dev.(pusb.Device); ok {
+		if name, ok := device.RegisteredType(d); ok {
+			return name
+		}
+	}
 	t := reflect.TypeOf(dev)
 	if t.Kind() == reflect.Ptr {
 		t = t.Elem()
@@ -11,12 +11,21 @@ func ErrNotFound(detail string) apitypes.ApiError {
 func ErrConflict(detail string) apitypes.ApiError {
 	return apitypes.ApiError{Status: 409, Title: "Conflict", Detail: detail}
 }
+func ErrUnprocessable(detail string) apitypes.ApiError {
+	return apitypes.ApiError{Status: 422, Title: "Unprocessable Entity", Detail: detail}
+}
 func ErrInternal(detail string) apitypes.ApiError {
 	return apitypes.ApiError{Status: 500, Title: "Internal Server Error", Detail: detail}
 }
 func ErrUnauthorized(detail string) apitypes.ApiError {
 	return apitypes.ApiError{Status: 401, Title: "Unauthorized", Detail: detail}
 }
+func ErrForbidden(detail string) apitypes.ApiError {
+	return apitypes.ApiError{Status: 403, Title: "Forbidden", Detail: detail}
+}
+func ErrUnavailable(detail string) apitypes.ApiError {
+	return apitypes.ApiError{Status: 503, Title: "Service Unavailable", Detail: detail}
+}
 
 // WrapError normalizes any error into apitypes.ApiError.
 func WrapError(err error) apitypes.ApiError {
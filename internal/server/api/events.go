@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/Alia5/VIIPER/apitypes"
+)
+
+// eventsPath subscribes to the bus and device events of the server.
+const eventsPath = "events"
+
+// eventBuffer is the number of events kept for a subscriber that falls
+// behind; older ones are dropped.
+const eventBuffer = 256
+
+// serveEvents answers an events request with an empty JSON object and then
+// sends every event of the server as an apitypes.Event, each framed like a
+// response, until the client goes away.
+func (s *Server) serveEvents(ctx context.Context, r io.Reader, w io.Writer, logger *slog.Logger) {
+	sub := s.usbs.SubscribeEvents(eventBuffer)
+	defer sub.Close()
+	s.writeOK(w, "{}")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		// Clients send nothing more; reading only notices them leaving.
+		_, _ = io.Copy(io.Discard, r)
+		cancel()
+	}()
+
+	var reported uint64
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("api events end")
+			return
+		case ev := <-sub.C():
+			dropped := sub.Dropped()
+			out := apitypes.Event{
+				Type:       string(ev.Type),
+				BusID:      ev.BusID,
+				OccurredAt: ev.Time.UTC().Format(time.RFC3339Nano),
+				Seq:        ev.Seq,
+				Dropped:    dropped - reported,
+			}
+			reported = dropped
+			if ev.DevID != 0 {
+				out.DevId = ev.ID
+			}
+			body, err := json.Marshal(out)
+			if err != nil {
+				logger.Error("marshal event", "error", err)
+				return
+			}
+			if _, err := fmt.Fprintf(w, "%s\n", body); err != nil {
+				logger.Info("api events end", "error", err)
+				return
+			}
+		}
+	}
+}
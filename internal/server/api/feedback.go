@@ -0,0 +1,158 @@
+package api
+
+import (
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Alia5/VIIPER/device/replay"
+	"github.com/Alia5/VIIPER/internal/metrics"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// streamConn serializes writes to a device stream so server-originated
+// feedback (e.g. synthetic test feedback) never interleaves with the
+// device handler's own feedback messages. It also taps the traffic for
+// server-side recordings. With framed set, feedback goes out as
+// streamMsgFeedback messages.
+type streamConn struct {
+	net.Conn
+	mu     sync.Mutex
+	srv    *Server
+	dev    usb.Device
+	framed bool
+	// closing is set once the shutdown notice went out; nothing follows it.
+	closing bool
+	// in and out count the bytes read from and written to the client.
+	in, out *metrics.Counter
+	// feedback counts the feedback messages sent, last is the latest.
+	feedback uint64
+	last     []byte
+	lastAt   time.Time
+}
+
+// StreamStats is a snapshot of the stream of a device.
+type StreamStats struct {
+	BytesIn, BytesOut uint64 // of all streams of the device
+	Feedback          uint64
+	LastFeedback      []byte
+	LastFeedbackAt    time.Time
+}
+
+// StreamStats reports the stream of dev. ok is false while no client
+// streams it.
+func (s *Server) StreamStats(dev usb.Device) (st StreamStats, ok bool) {
+	s.streamsMu.Lock()
+	sc := s.streams[dev]
+	s.streamsMu.Unlock()
+	if sc == nil {
+		return StreamStats{}, false
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return StreamStats{
+		BytesIn:        sc.in.Value(),
+		BytesOut:       sc.out.Value(),
+		Feedback:       sc.feedback,
+		LastFeedback:   slices.Clone(sc.last),
+		LastFeedbackAt: sc.lastAt,
+	}, true
+}
+
+// sent notes a feedback message; c.mu is held.
+func (c *streamConn) sent(p []byte) {
+	c.feedback++
+	c.last = append(c.last[:0], p...)
+	c.lastAt = time.Now()
+	c.srv.record(c.dev, replay.KindFeedback, p)
+}
+
+func (c *streamConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.in.Add(uint64(n))
+		c.srv.record(c.dev, replay.KindInput, p[:n])
+	}
+	return n, err
+}
+
+func (c *streamConn) Write(p []byte) (int, error) {
+	if c.framed {
+		if err := c.writeMessage(streamMsgFeedback, p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return 0, net.ErrClosed
+	}
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.out.Add(uint64(n))
+		c.sent(p[:n])
+	}
+	return n, err
+}
+
+// writeMessage sends one message of a framed stream.
+func (c *streamConn) writeMessage(kind byte, body []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return net.ErrClosed
+	}
+	msg := frameStreamMessage(kind, body)
+	if _, err := c.Conn.Write(msg); err != nil {
+		return err
+	}
+	c.out.Add(uint64(len(msg)))
+	if kind == streamMsgFeedback {
+		c.sent(body)
+	}
+	return nil
+}
+
+func (s *Server) trackStream(dev usb.Device, id string, conn net.Conn) *streamConn {
+	sc := &streamConn{
+		Conn: conn,
+		srv:  s,
+		dev:  dev,
+		in:   s.m.streamBytes.With("c2s", id),
+		out:  s.m.streamBytes.With("s2c", id),
+	}
+	s.streamsMu.Lock()
+	s.streams[dev] = sc
+	s.streamsMu.Unlock()
+	// A stream starting while Shutdown collects the others ends right away.
+	if s.shuttingDown.Load() {
+		sc.notifyShutdown()
+	}
+	return sc
+}
+
+func (s *Server) untrackStream(dev usb.Device, sc *streamConn) {
+	s.streamsMu.Lock()
+	if s.streams[dev] == sc {
+		delete(s.streams, dev)
+	}
+	s.streamsMu.Unlock()
+	s.CancelMacro(dev)
+}
+
+// WriteFeedback sends a raw feedback (s2c) message to the client streaming dev.
+func (s *Server) WriteFeedback(dev usb.Device, data []byte) error {
+	s.streamsMu.Lock()
+	sc := s.streams[dev]
+	s.streamsMu.Unlock()
+	if sc == nil {
+		return apierror.ErrConflict("no stream connected to device")
+	}
+	if _, err := sc.Write(data); err != nil {
+		return apierror.ErrInternal("write feedback: " + err.Error())
+	}
+	return nil
+}
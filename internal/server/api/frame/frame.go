@@ -0,0 +1,76 @@
+// Package frame implements the length-prefixed (v2) management protocol framing.
+//
+// A v2 client starts the (plaintext or already encrypted) stream with Magic,
+// then sends the request as a single frame: u32 big-endian body length followed
+// by the body. The server answers with a frame as well. Payloads are opaque, so
+// they may contain null bytes or newlines. Clients that do not send Magic keep
+// using the legacy null-terminated (v1) framing.
+package frame
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Magic announces protocol v2. It shares its shape with the auth handshake
+// magic ("eVI1\x00") and is null-terminated, so a v1-only server rejects it as
+// an unknown path instead of waiting for more data.
+const Magic = "eVI2\x00"
+
+// MaxSize is the largest accepted frame body in bytes.
+const MaxSize = 1 << 20
+
+var ErrTooLarge = errors.New("frame too large")
+
+// IsV2 reports whether the next bytes in r are the v2 protocol marker.
+func IsV2(r *bufio.Reader) bool {
+	b, err := r.Peek(len(Magic))
+	return err == nil && string(b) == Magic
+}
+
+// ReadMagic consumes the v2 protocol marker.
+func ReadMagic(r io.Reader) error {
+	b := make([]byte, len(Magic))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return fmt.Errorf("read protocol marker: %w", err)
+	}
+	if string(b) != Magic {
+		return fmt.Errorf("invalid protocol marker %q", b)
+	}
+	return nil
+}
+
+// Write writes body as a single frame.
+func Write(w io.Writer, body []byte) error {
+	if len(body) > MaxSize {
+		return ErrTooLarge
+	}
+	buf := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(body)))
+	copy(buf[4:], body)
+	_, err := w.Write(buf)
+	return err
+}
+
+// Read reads a single frame body.
+func Read(r io.Reader) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > MaxSize {
+		return nil, ErrTooLarge
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return body, nil
+}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+)
+
+// AdminReadOnly returns a handler that switches the server's read-only mode.
+// Without a payload it reports the current mode.
+func AdminReadOnly(apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		if strings.TrimSpace(req.Payload) != "" {
+			var ro *apitypes.ReadOnlyRequest
+			if err := json.Unmarshal([]byte(req.Payload), &ro); err != nil {
+				return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+			}
+			// A JSON null, as sent for a nil request, only reports the mode.
+			if was := apiSrv.ReadOnly(); ro != nil && was != ro.ReadOnly {
+				apiSrv.SetReadOnly(ro.ReadOnly)
+				logger.Warn("read-only mode changed", "readOnly", ro.ReadOnly, "was", was)
+			}
+		}
+		payload, err := json.Marshal(apitypes.ReadOnlyResponse{ReadOnly: apiSrv.ReadOnly()})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
@@ -0,0 +1,122 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	"github.com/Alia5/VIIPER/internal/cmd"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/usbip"
+)
+
+func TestReadOnlyMode(t *testing.T) {
+	cfg := viiperTesting.TestServerConfig(t)
+	cfg.Server.ApiServerConfig.ReadOnly = true
+	s := viiperTesting.NewTestServerWithConfig(t, cfg)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+	cmd.RegisterRoutes(s.ApiServer)
+	require.NoError(t, s.ApiServer.Start())
+
+	client := apiclient.New(s.ApiServer.Addr())
+	transport := apiclient.NewTransport(s.ApiServer.Addr())
+	ping, err := client.Ping()
+	require.NoError(t, err)
+	assert.True(t, ping.ReadOnly)
+
+	assertForbidden := func(t *testing.T, err error) {
+		t.Helper()
+		var apiErr *apitypes.ApiError
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, 403, apiErr.Status)
+		assert.Equal(t, "server is in read-only mode", apiErr.Detail)
+	}
+	params := strings.NewReplacer("{id}", "90121", "{deviceid}", "1")
+	mutating := 0
+	for _, rt := range s.ApiServer.Router().Routes() {
+		if rt.Flags&api.Mutating == 0 {
+			continue
+		}
+		mutating++
+		t.Run(rt.Pattern, func(t *testing.T) {
+			raw, err := transport.Do(params.Replace(rt.Pattern), "{}", nil)
+			require.NoError(t, err)
+			var problem apitypes.ApiError
+			require.NoError(t, json.Unmarshal([]byte(raw), &problem))
+			assertForbidden(t, &problem)
+		})
+	}
+	assert.Positive(t, mutating)
+	_, err = client.BusList()
+	assert.NoError(t, err, "reads stay available")
+
+	resp, err := client.SetReadOnly(&apitypes.ReadOnlyRequest{ReadOnly: false})
+	require.NoError(t, err)
+	assert.False(t, resp.ReadOnly)
+	ping, err = client.Ping()
+	require.NoError(t, err)
+	assert.False(t, ping.ReadOnly)
+
+	_, err = client.BusCreate(90121)
+	require.NoError(t, err)
+	stream, dev, err := client.AddDeviceAndConnect(context.Background(), 90121, "xbox360", nil)
+	require.NoError(t, err)
+	defer stream.Close()
+
+	resp, err = client.SetReadOnly(&apitypes.ReadOnlyRequest{ReadOnly: true})
+	require.NoError(t, err)
+	assert.True(t, resp.ReadOnly)
+	resp, err = client.SetReadOnly(nil)
+	require.NoError(t, err)
+	assert.True(t, resp.ReadOnly, "no payload only reports")
+
+	_, err = client.DeviceRemove(90121, dev.DevId)
+	assertForbidden(t, err)
+	batch := client.NewBatch()
+	call := batch.BusCreate(90122)
+	_, err = batch.Execute()
+	require.NoError(t, err)
+	_, err = call.Result()
+	assertForbidden(t, err)
+
+	// Streams to existing devices keep working.
+	xdev := s.UsbServer.GetBus(90121).GetAllDeviceMetas()[0].Dev.(*xbox360.Xbox360)
+	want := (&xbox360.InputState{Buttons: xbox360.ButtonA}).BuildReport()
+	require.NoError(t, stream.WriteBinary(&xbox360.InputState{Buttons: xbox360.ButtonA}))
+	assert.Eventually(t, func() bool {
+		got := xdev.HandleTransfer(1, usbip.DirIn, nil)
+		return string(got) == string(want)
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestAdminRoutesAreLocalOnly(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+	cmd.RegisterRoutes(s.ApiServer)
+	s.ApiServer.SetReadOnly(true)
+
+	h, _ := s.ApiServer.Router().Match("admin/read-only")
+	require.NotNil(t, h)
+	err := h(&api.Request{Payload: `{"readOnly": false}`}, &api.Response{}, slog.Default())
+	var apiErr apitypes.ApiError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, 403, apiErr.Status)
+	assert.True(t, s.ApiServer.ReadOnly())
+
+	res := &api.Response{}
+	require.NoError(t, h(&api.Request{Payload: `{"readOnly": false}`, Local: true}, res, slog.Default()))
+	assert.JSONEq(t, `{"readOnly": false}`, res.JSON)
+	assert.False(t, s.ApiServer.ReadOnly())
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+)
+
+// AdminStateSnapshots returns a handler that lists the snapshots of the state
+// file.
+func AdminStateSnapshots(apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		snaps, err := apiSrv.StateSnapshots()
+		if errors.Is(err, api.ErrNoStateFile) {
+			return apierror.ErrConflict("server has no state file")
+		}
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to list snapshots: %v", err))
+		}
+		resp := apitypes.StateSnapshotsResponse{Snapshots: make([]apitypes.StateSnapshotInfo, 0, len(snaps))}
+		for _, sn := range snaps {
+			resp.Snapshots = append(resp.Snapshots, apitypes.StateSnapshotInfo{Name: sn.Name, CreatedAt: sn.Time.Format(time.RFC3339Nano)})
+		}
+		payload, err := json.Marshal(resp)
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
+
+// AdminStateRestore returns a handler that rolls the buses and devices back to
+// a snapshot of the state file. Every current bus is removed first; the
+// replaced state becomes a snapshot of its own.
+func AdminStateRestore(apiSrv *api.Server) api.HandlerFunc {
+	var mu sync.Mutex // one restore at a time
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		if strings.TrimSpace(req.Payload) == "" {
+			return apierror.ErrBadRequest("missing snapshot")
+		}
+		var r apitypes.StateRestoreRequest
+		if err := json.Unmarshal([]byte(req.Payload), &r); err != nil {
+			return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+		}
+		if r.Snapshot == "" {
+			return apierror.ErrBadRequest("missing snapshot")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		st, err := apiSrv.LoadStateSnapshot(r.Snapshot)
+		switch {
+		case errors.Is(err, api.ErrNoStateFile):
+			return apierror.ErrConflict("server has no state file")
+		case errors.Is(err, fs.ErrNotExist):
+			return apierror.ErrNotFound(fmt.Sprintf("snapshot %q not found", r.Snapshot))
+		case err != nil:
+			return apierror.ErrUnprocessable(err.Error())
+		}
+		s := apiSrv.USB()
+		for _, id := range s.ListBuses() {
+			_ = s.RemoveBus(id) // already gone
+		}
+		resp := apitypes.StateRestoreResponse{Snapshot: r.Snapshot}
+		if err := RestoreState(apiSrv, st, logger); err != nil {
+			if j, ok := err.(interface{ Unwrap() []error }); ok {
+				for _, e := range j.Unwrap() {
+					resp.Errors = append(resp.Errors, e.Error())
+				}
+			} else {
+				resp.Errors = append(resp.Errors, err.Error())
+			}
+		}
+		for _, id := range s.ListBuses() {
+			if b := s.GetBus(id); b != nil {
+				resp.Buses++
+				resp.Devices += b.DeviceCount()
+			}
+		}
+		logger.Warn("state restored from snapshot", "snapshot", r.Snapshot, "buses", resp.Buses, "devices", resp.Devices)
+		payload, err := json.Marshal(resp)
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
@@ -0,0 +1,224 @@
+package handler_test
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/cmd"
+	"github.com/Alia5/VIIPER/internal/config"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+)
+
+func stateConfig(t *testing.T) *config.CLI {
+	cfg := viiperTesting.TestServerConfig(t)
+	cfg.Server.StateFile = filepath.Join(t.TempDir(), "state.json")
+	cfg.Server.StateSnapshots = 5
+	cfg.Server.ApiServerConfig.DeviceHandlerConnectTimeout = time.Minute
+	cfg.Server.UsbServerConfig.BusCleanupTimeout = time.Minute
+	return cfg
+}
+
+func startStateServer(t *testing.T, cfg *config.CLI) (*viiperTesting.MockServer, *apiclient.Client) {
+	s := viiperTesting.NewTestServerWithConfig(t, cfg)
+	cmd.RegisterRoutes(s.ApiServer)
+	require.NoError(t, s.ApiServer.Start())
+	t.Cleanup(func() {
+		s.ApiServer.Close()
+		_ = s.UsbServer.Close()
+		for _, id := range s.UsbServer.ListBuses() {
+			_ = s.UsbServer.RemoveBus(id)
+		}
+	})
+	return s, apiclient.New(s.ApiServer.Addr())
+}
+
+// waitSaved waits until the state file holds devices devices on busID.
+func waitSaved(t *testing.T, path string, busID uint32, devices int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		st, err := api.LoadState(path)
+		if err != nil {
+			return false
+		}
+		for _, b := range st.Buses {
+			if b.BusID == busID {
+				return len(b.Devices) == devices
+			}
+		}
+		return false
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestStateFileCorrupt(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		corrupt func([]byte) []byte
+		err     string
+	}{
+		{"truncated", func(b []byte) []byte { return b[:len(b)/2] }, "unexpected end of JSON input"},
+		{"bad checksum", func(b []byte) []byte { return bytes.Replace(b, []byte(`"mouse"`), []byte(`"xbox360"`), 1) }, "checksum mismatch"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := stateConfig(t)
+			path := cfg.Server.StateFile
+
+			s, client := startStateServer(t, cfg)
+			_, err := client.BusCreate(90188)
+			require.NoError(t, err)
+			waitSaved(t, path, 90188, 0)
+			_, err = client.DeviceAdd(90188, "keyboard", nil)
+			require.NoError(t, err)
+			waitSaved(t, path, 90188, 1)
+			_, err = client.DeviceAdd(90188, "mouse", nil)
+			require.NoError(t, err)
+			waitSaved(t, path, 90188, 2)
+			s.ApiServer.Close()
+			_ = s.UsbServer.Close()
+			for _, id := range s.UsbServer.ListBuses() {
+				_ = s.UsbServer.RemoveBus(id)
+			}
+
+			raw, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(path, tc.corrupt(raw), 0o600))
+			_, err = api.LoadState(path)
+			require.ErrorContains(t, err, tc.err)
+
+			usbSrv := usb.New(cfg.Server.UsbServerConfig, slog.Default(), nil)
+			defer usbSrv.Close()
+			apiSrv := api.New(usbSrv, cfg.Server.ApiServerConfig.Addr, cfg.Server.ApiServerConfig, slog.Default())
+			defer apiSrv.Close()
+			err = cfg.Server.RestoreStateFile(apiSrv, slog.Default())
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), path)
+			assert.Contains(t, err.Error(), "--ignore-state")
+			assert.Empty(t, usbSrv.ListBuses())
+
+			cfg.Server.IgnoreState = true
+			_, client = startStateServer(t, cfg)
+			buses, err := client.BusList()
+			require.NoError(t, err)
+			assert.Empty(t, buses.BusInfo)
+
+			snaps, err := client.StateSnapshots()
+			require.NoError(t, err)
+			// Taken before the bus, the keyboard and the mouse were added.
+			require.Len(t, snaps.Snapshots, 3)
+			restored, err := client.RestoreStateSnapshot(snaps.Snapshots[0].Name)
+			require.NoError(t, err)
+			assert.Equal(t, apitypes.StateRestoreResponse{Snapshot: snaps.Snapshots[0].Name, Buses: 1, Devices: 1}, *restored)
+			devs, err := client.DevicesList(90188)
+			require.NoError(t, err)
+			require.Len(t, devs.Devices, 1)
+			assert.Equal(t, "keyboard", devs.Devices[0].Type)
+			waitSaved(t, path, 90188, 1)
+
+			_, err = client.RestoreStateSnapshot("state.json.20000101T000000.000000000Z")
+			var apiErr *apitypes.ApiError
+			require.ErrorAs(t, err, &apiErr)
+			assert.Equal(t, 404, apiErr.Status)
+		})
+	}
+}
+
+func TestStateSavesDuringChurn(t *testing.T) {
+	cfg := stateConfig(t)
+	cfg.Server.StateSnapshots = 3
+	path := cfg.Server.StateFile
+	s, client := startStateServer(t, cfg)
+	_, err := client.BusCreate(90189)
+	require.NoError(t, err)
+
+	// Every file next to the state file that is not a temporary one must load.
+	check := func() error {
+		entries, err := os.ReadDir(filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if filepath.Ext(e.Name()) == ".tmp" {
+				continue
+			}
+			b, err := os.ReadFile(filepath.Join(filepath.Dir(path), e.Name()))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // rotated out meanwhile
+			}
+			if err != nil {
+				return err
+			}
+			tmp := filepath.Join(t.TempDir(), "copy.json")
+			if err := os.WriteFile(tmp, b, 0o600); err != nil {
+				return err
+			}
+			if _, err := api.LoadState(tmp); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	stop := make(chan struct{})
+	checked := make(chan error, 1)
+	go func() {
+		for {
+			if err := check(); err != nil {
+				checked <- err
+				return
+			}
+			select {
+			case <-stop:
+				checked <- nil
+				return
+			default:
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			for range 25 {
+				dev, err := client.DeviceAdd(90189, "keyboard", nil)
+				if !assert.NoError(t, err) {
+					return
+				}
+				_, err = client.DeviceRemove(90189, dev.DevId)
+				assert.NoError(t, err)
+			}
+		})
+	}
+	wg.Wait()
+	close(stop)
+	require.NoError(t, <-checked)
+	waitSaved(t, path, 90189, 0)
+
+	snaps, err := client.StateSnapshots()
+	require.NoError(t, err)
+	assert.Len(t, snaps.Snapshots, 3)
+	for _, f := range s.UsbServer.Metrics().Gather() {
+		if f.Name != "viiper_state_saves_total" {
+			continue
+		}
+		for _, sm := range f.Samples {
+			if sm.Labels["result"] == "failed" {
+				assert.Zero(t, sm.Value)
+			} else {
+				assert.Positive(t, sm.Value)
+			}
+		}
+	}
+}
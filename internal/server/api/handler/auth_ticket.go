@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+)
+
+// AuthTicket returns a handler issuing session resumption tickets. The
+// ticket secret is sent back in the response, so only connections encrypted
+// by a handshake get one. Tickets carry no scopes, so tokens get none.
+func AuthTicket(apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		if !req.Authenticated {
+			return apierror.ErrForbidden("tickets are only issued over authenticated connections")
+		}
+		if req.Token != nil {
+			return apierror.ErrForbidden("tickets are not issued to tokens")
+		}
+		ticket, secret, expires, err := apiSrv.IssueTicket()
+		if errors.Is(err, api.ErrResumeDisabled) {
+			return apierror.ErrNotFound(err.Error())
+		}
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("issue ticket: %v", err))
+		}
+		payload, err := json.Marshal(apitypes.AuthTicketResponse{
+			Ticket:    base64.StdEncoding.EncodeToString(ticket),
+			Secret:    base64.StdEncoding.EncodeToString(secret),
+			ExpiresIn: uint64(time.Until(expires) / time.Second),
+		})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
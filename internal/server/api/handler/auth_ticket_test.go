@@ -0,0 +1,42 @@
+package handler_test
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	handlerTest "github.com/Alia5/VIIPER/internal/_testing"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/auth"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+)
+
+func TestAuthTicket(t *testing.T) {
+	addr, _, done := handlerTest.StartAPIServer(t, func(r *api.Router, s *usb.Server, apiSrv *api.Server) {
+		apiSrv.SetPassword("ticket-pass")
+		r.Register("auth/ticket", handler.AuthTicket(apiSrv))
+	})
+	defer done()
+
+	// Localhost may skip authentication, but the secret is only sent encrypted.
+	_, err := apiclient.New(addr).AuthTicket()
+	var apiErr *apitypes.ApiError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, 403, apiErr.Status)
+
+	resp, err := apiclient.NewWithPassword(addr, "ticket-pass").AuthTicket()
+	require.NoError(t, err)
+	ticket, err := base64.StdEncoding.DecodeString(resp.Ticket)
+	require.NoError(t, err)
+	assert.Len(t, ticket, auth.TicketSize)
+	secret, err := base64.StdEncoding.DecodeString(resp.Secret)
+	require.NoError(t, err)
+	assert.Len(t, secret, 32)
+	assert.InDelta(t, (12 * time.Hour).Seconds(), float64(resp.ExpiresIn), 5)
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+)
+
+// MaxBatchRequests bounds the number of entries in one batch.
+const MaxBatchRequests = 64
+
+// unbatchable lists routes that start background jobs, whose lifetime does
+// not fit a sequential batch.
+var unbatchable = map[string]bool{
+	"batch":                             true,
+	"bus/{id}/{deviceid}/test-feedback": true,
+	"bus/{id}/{deviceid}/record/start":  true,
+	"bus/{id}/{deviceid}/macro":         true,
+}
+
+// Batch returns a handler that runs several management requests in order
+// and answers with one result or problem per request. Batches are not
+// transactional: requests that succeeded before a failure stay applied.
+func Batch(apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		if req.Payload == "" {
+			return apierror.ErrBadRequest("missing payload")
+		}
+		var batchReq apitypes.BatchRequest
+		if err := json.Unmarshal([]byte(req.Payload), &batchReq); err != nil {
+			return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+		}
+		if len(batchReq.Requests) > MaxBatchRequests {
+			return apierror.ErrBadRequest(fmt.Sprintf("batch exceeds %d requests", MaxBatchRequests))
+		}
+
+		resp := apitypes.BatchResponse{Results: make([]apitypes.BatchResult, len(batchReq.Requests))}
+		failed := false
+		for i, entry := range batchReq.Requests {
+			if failed && batchReq.StopOnError {
+				resp.Results[i].Skipped = true
+				continue
+			}
+			result, err := runBatchEntry(apiSrv, req, entry, logger)
+			if err != nil {
+				problem := apierror.WrapError(err)
+				resp.Results[i].Problem = &problem
+				failed = true
+				continue
+			}
+			resp.Results[i].Result = result
+		}
+
+		payload, err := json.Marshal(resp)
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
+
+func runBatchEntry(apiSrv *api.Server, req *api.Request, entry apitypes.BatchEntry, logger *slog.Logger) (any, error) {
+	path := strings.ToLower(entry.Path)
+	r := apiSrv.Router()
+	h, params := r.Match(path)
+	if h == nil {
+		if sh, _ := r.MatchStream(path); sh != nil {
+			return nil, apierror.ErrBadRequest(fmt.Sprintf("stream %s cannot be batched", path))
+		}
+		return nil, apierror.ErrNotFound(fmt.Sprintf("unknown path: %s", path))
+	}
+	if unbatchable[r.Pattern(path)] {
+		return nil, apierror.ErrBadRequest(fmt.Sprintf("%s cannot be batched", r.Pattern(path)))
+	}
+
+	logger.Debug("api batch cmd", "path", path)
+	subRes := &api.Response{}
+	if err := h(&api.Request{Ctx: req.Ctx, Params: params, Payload: entry.Payload, Local: req.Local, Authenticated: req.Authenticated, Token: req.Token}, subRes, logger); err != nil {
+		return nil, err
+	}
+	if subRes.JSON == "" {
+		return json.RawMessage("{}"), nil
+	}
+	if !json.Valid([]byte(subRes.JSON)) {
+		return subRes.JSON, nil
+	}
+	return json.RawMessage(subRes.JSON), nil
+}
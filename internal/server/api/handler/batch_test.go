@@ -0,0 +1,132 @@
+package handler_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	_ "github.com/Alia5/VIIPER/internal/registry"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+)
+
+func TestBatch(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/list", handler.BusList(s.UsbServer))
+	r.Register("bus/create", handler.BusCreate(s.UsbServer))
+	r.Register("bus/remove", handler.BusRemove(s.UsbServer))
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/{deviceid}/record/start", handler.DeviceRecordStart(s.UsbServer, s.ApiServer))
+	r.Register("batch", handler.Batch(s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+	defer func() {
+		for _, id := range []uint32{90113, 90114, 90115} {
+			_ = s.UsbServer.RemoveBus(id)
+		}
+	}()
+
+	client := apiclient.New(s.ApiServer.Addr())
+
+	t.Run("mixed results in order", func(t *testing.T) {
+		b := client.NewBatch()
+		created := b.BusCreate(90113)
+		added := b.DeviceAdd(90113, "xbox360", nil)
+		dup := b.BusCreate(90113)
+		unknown := b.DeviceAdd(90113, "nope", nil)
+		listed := b.DevicesList(90113)
+		resp, err := b.Execute()
+		require.NoError(t, err)
+		require.Len(t, resp.Results, 5)
+
+		bus, err := created.Result()
+		require.NoError(t, err)
+		assert.Equal(t, uint32(90113), bus.BusID)
+		dev, err := added.Result()
+		require.NoError(t, err)
+		assert.Equal(t, "1", dev.DevId)
+
+		var problem *apitypes.ApiError
+		_, err = dup.Result()
+		require.ErrorAs(t, err, &problem)
+		assert.Equal(t, 400, problem.Status)
+		assert.Equal(t, problem, resp.Results[2].Problem)
+		_, err = unknown.Result()
+		assert.ErrorContains(t, err, "unknown device type: nope")
+
+		// Requests after a failure still run and see the effects of earlier ones.
+		list, err := listed.Result()
+		require.NoError(t, err)
+		require.Len(t, list.Devices, 1)
+		assert.Equal(t, dev.DevId, list.Devices[0].DevId)
+	})
+
+	t.Run("stop on error", func(t *testing.T) {
+		b := client.NewBatch().StopOnError()
+		created := b.BusCreate(90114)
+		missing := b.DeviceAdd(90199, "xbox360", nil)
+		skipped := b.BusCreate(90115)
+		resp, err := b.Execute()
+		require.NoError(t, err)
+
+		_, err = created.Result()
+		assert.NoError(t, err, "batches are not rolled back")
+		_, err = missing.Result()
+		assert.ErrorContains(t, err, "bus 90199 not found")
+		_, err = skipped.Result()
+		assert.ErrorIs(t, err, apiclient.ErrBatchSkipped)
+		assert.True(t, resp.Results[2].Skipped)
+		assert.Nil(t, s.UsbServer.GetBus(90115))
+	})
+
+	t.Run("raw entries", func(t *testing.T) {
+		resp, err := client.ExecBatch(&apitypes.BatchRequest{Requests: []apitypes.BatchEntry{
+			{Path: "bus/list"},
+			{Path: "bus/90113/1"},
+			{Path: "bus/90113/1/record/start"},
+			{Path: "batch", Payload: "[]"},
+			{Path: "bus/nope"},
+		}})
+		require.NoError(t, err)
+		require.Len(t, resp.Results, 5)
+		assert.NotNil(t, resp.Results[0].Result)
+		for i, want := range []string{
+			"stream bus/90113/1 cannot be batched",
+			"bus/{id}/{deviceid}/record/start cannot be batched",
+			"batch cannot be batched",
+			"unknown path: bus/nope",
+		} {
+			require.NotNil(t, resp.Results[i+1].Problem)
+			assert.Equal(t, want, resp.Results[i+1].Problem.Detail)
+		}
+	})
+
+	t.Run("bare array payload", func(t *testing.T) {
+		raw, err := apiclient.NewTransport(s.ApiServer.Addr()).Do("batch", `[{"path":"bus/90113/list"},{"path":"bus/remove","payload":"90114"}]`, nil)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(raw, `{"results":[{"result":{"devices":[`), raw)
+		assert.Nil(t, s.UsbServer.GetBus(90114))
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		b := client.NewBatch()
+		for range handler.MaxBatchRequests + 1 {
+			b.BusList()
+		}
+		_, err := b.Execute()
+		assert.ErrorContains(t, err, fmt.Sprintf("batch exceeds %d requests", handler.MaxBatchRequests))
+		_, err = b.Execute()
+		assert.ErrorContains(t, err, "batch already executed")
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"strconv"
+	"strings"
 
 	"github.com/Alia5/VIIPER/apitypes"
 	"github.com/Alia5/VIIPER/internal/server/api"
@@ -14,25 +15,56 @@ import (
 )
 
 // BusCreate returns a handler that creates a new bus.
+// The payload is either a bare bus number or a JSON apitypes.BusCreateRequest
+// carrying an optional label, description and device limit. Bus number 0, or
+// no payload, picks the lowest free bus number.
 // Error logging is centralized in the API server; this handler only returns errors.
 func BusCreate(s *usb.Server) api.HandlerFunc {
 	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
 		if req.Payload != "" {
-			busId, err := strconv.ParseUint(req.Payload, 10, 32)
-			if err != nil {
-				return apierror.ErrBadRequest(fmt.Sprintf("invalid busId: %v", err))
+			var createReq apitypes.BusCreateRequest
+			if strings.HasPrefix(strings.TrimSpace(req.Payload), "{") {
+				if err := json.Unmarshal([]byte(req.Payload), &createReq); err != nil {
+					return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+				}
+			} else {
+				busId, err := strconv.ParseUint(req.Payload, 10, 32)
+				if err != nil {
+					return apierror.ErrBadRequest(fmt.Sprintf("invalid busId: %v", err))
+				}
+				createReq.BusID = uint32(busId)
 			}
 
-			if busId == 0 {
-				busId = uint64(s.NextFreeBusID())
+			setLabel := func(b *virtualbus.VirtualBus) error {
+				if err := b.SetLabel(createReq.Label, createReq.Description); err != nil {
+					return apierror.ErrBadRequest(err.Error())
+				}
+				return nil
 			}
-
-			b, err := virtualbus.NewWithBusId(uint32(busId))
-			if err != nil {
-				return apierror.ErrBadRequest(fmt.Sprintf("invalid busId: %v", err))
+			var opts []virtualbus.Option
+			if createReq.MaxDevices != 0 {
+				opts = append(opts, virtualbus.WithMaxDevices(createReq.MaxDevices))
 			}
-			if err := s.AddBus(b); err != nil {
-				return apierror.ErrConflict(fmt.Sprintf("bus %d already exists", busId))
+			var b *virtualbus.VirtualBus
+			var err error
+			if createReq.BusID == 0 {
+				if b, err = s.AddFreeBus(setLabel, opts...); err != nil {
+					if _, ok := err.(apitypes.ApiError); ok {
+						return err
+					}
+					return apierror.ErrBadRequest(err.Error())
+				}
+			} else {
+				if b, err = virtualbus.NewWithBusId(createReq.BusID, opts...); err != nil {
+					return apierror.ErrBadRequest(fmt.Sprintf("invalid busId: %v", err))
+				}
+				if err := setLabel(b); err != nil {
+					_ = b.Close()
+					return err
+				}
+				if err := s.AddBus(b); err != nil {
+					return apierror.ErrConflict(fmt.Sprintf("bus %d already exists", createReq.BusID))
+				}
 			}
 			out, err := json.Marshal(apitypes.BusCreateResponse{BusID: b.BusID()})
 			if err != nil {
@@ -42,9 +74,8 @@ func BusCreate(s *usb.Server) api.HandlerFunc {
 			return nil
 		}
 
-		busId := s.NextFreeBusID()
-		b := virtualbus.New(busId)
-		if err := s.AddBus(b); err != nil {
+		b, err := s.AddFreeBus(nil)
+		if err != nil {
 			return apierror.ErrInternal(fmt.Sprintf("failed to add bus: %v", err))
 		}
 		out, err := json.Marshal(apitypes.BusCreateResponse{BusID: b.BusID()})
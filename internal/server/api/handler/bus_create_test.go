@@ -1,11 +1,15 @@
 package handler_test
 
 import (
+	"encoding/json"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
 	handlerTest "github.com/Alia5/VIIPER/internal/_testing"
 	"github.com/Alia5/VIIPER/internal/server/api"
 	"github.com/Alia5/VIIPER/internal/server/api/handler"
@@ -75,6 +79,21 @@ func TestBusCreate(t *testing.T) {
 			payload:          "-1",
 			expectedResponse: `{"status":400,"title":"Bad Request","detail":"invalid busId: strconv.ParseUint: parsing \"-1\": invalid syntax"}`,
 		},
+		{
+			name:             "JSON payload with label",
+			payload:          `{"busId":60007,"label":"CI rig pads","description":"nightly runs"}`,
+			expectedResponse: `{"busId":60007}`,
+		},
+		{
+			name:             "label too long",
+			payload:          `{"busId":60008,"label":"` + strings.Repeat("x", 65) + `"}`,
+			expectedResponse: `{"status":400,"title":"Bad Request","detail":"label exceeds 64 bytes"}`,
+		},
+		{
+			name:             "invalid JSON payload",
+			payload:          `{"busId":"x"}`,
+			expectedResponse: `{"status":400,"title":"Bad Request","detail":"invalid JSON payload: json: cannot unmarshal string into Go struct field BusCreateRequest.busId of type uint32"}`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -97,3 +116,43 @@ func TestBusCreate(t *testing.T) {
 		})
 	}
 }
+
+func TestBusCreateConcurrentFreeIDs(t *testing.T) {
+	addr, srv, done := handlerTest.StartAPIServer(t, func(r *api.Router, s *usb.Server, apiSrv *api.Server) {
+		r.Register("bus/create", handler.BusCreate(s))
+	})
+	defer done()
+
+	const clients = 16
+	ids := make(chan uint32, clients)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			payload := "0"
+			if i%2 == 1 {
+				payload = `{"label":"pad rig"}`
+			}
+			line, err := apiclient.NewTransport(addr).Do("bus/create", payload, nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+			var resp apitypes.BusCreateResponse
+			if assert.NoError(t, json.Unmarshal([]byte(line), &resp), line) {
+				ids <- resp.BusID
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := map[uint32]bool{}
+	for id := range ids {
+		assert.False(t, seen[id], "bus %d allocated twice", id)
+		seen[id] = true
+		assert.NotNil(t, srv.GetBus(id))
+		defer func() { _ = srv.RemoveBus(id) }()
+	}
+	assert.Len(t, seen, clients)
+}
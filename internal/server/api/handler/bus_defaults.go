@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// BusGetDefaults returns a handler that reports the default create options of a bus.
+func BusGetDefaults(s *usb.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		b, err := busFromParams(s, req.Params)
+		if err != nil {
+			return err
+		}
+		payload, err := json.Marshal(apitypes.BusDefaultsResponse{BusID: b.BusID(), Defaults: apiDefaults(b.Defaults())})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
+
+// BusSetDefaults returns a handler that replaces the default create options of a bus.
+// Devices added afterwards inherit them beneath their explicit options.
+func BusSetDefaults(s *usb.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		b, err := busFromParams(s, req.Params)
+		if err != nil {
+			return err
+		}
+		if req.Payload == "" {
+			return apierror.ErrBadRequest("missing payload")
+		}
+		var defaultsReq apitypes.BusDefaultsRequest
+		if err := json.Unmarshal([]byte(req.Payload), &defaultsReq); err != nil {
+			return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+		}
+
+		defaults := make(map[string]device.CreateOptions, len(defaultsReq.Defaults))
+		for name, d := range defaultsReq.Defaults {
+			defaults[strings.ToLower(name)] = device.CreateOptions{
+				IdVendor:       d.IdVendor,
+				IdProduct:      d.IdProduct,
+				SerialNumber:   d.SerialNumber,
+				ProductString:  d.ProductString,
+				DeviceSpecific: d.DeviceSpecific,
+				StrictInput:    d.StrictInput,
+			}
+		}
+
+		// Bad defaults must fail now rather than on the next add.
+		types := api.ListDeviceTypes()
+		slices.Sort(types)
+		for _, name := range types {
+			_, typed := defaults[name]
+			_, wildcard := defaults[virtualbus.AnyDeviceType]
+			if !typed && !wildcard {
+				continue
+			}
+			opts := virtualbus.ResolveOptions(defaults, name, device.CreateOptions{})
+			if _, err := api.GetRegistration(name).CreateDevice(&opts); err != nil {
+				return apierror.ErrBadRequest(fmt.Sprintf("invalid defaults for %s: %v", name, err))
+			}
+		}
+
+		b.SetDefaults(defaults)
+		logger.Info("set bus defaults", "busID", b.BusID(), "types", len(defaults))
+		payload, err := json.Marshal(apitypes.BusDefaultsResponse{BusID: b.BusID(), Defaults: apiDefaults(b.Defaults())})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
+
+func apiDefaults(defaults map[string]device.CreateOptions) map[string]apitypes.DeviceDefaults {
+	out := make(map[string]apitypes.DeviceDefaults, len(defaults))
+	for name, o := range defaults {
+		out[name] = deviceDefaults(o)
+	}
+	return out
+}
+
+func deviceDefaults(o device.CreateOptions) apitypes.DeviceDefaults {
+	return apitypes.DeviceDefaults{
+		IdVendor:       o.IdVendor,
+		IdProduct:      o.IdProduct,
+		SerialNumber:   o.SerialNumber,
+		ProductString:  o.ProductString,
+		DeviceSpecific: o.DeviceSpecific,
+		StrictInput:    o.StrictInput,
+	}
+}
@@ -0,0 +1,91 @@
+package handler_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device"
+	_ "github.com/Alia5/VIIPER/internal/registry"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func TestBusDefaults(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/defaults", handler.BusGetDefaults(s.UsbServer))
+	r.Register("bus/{id}/defaults/set", handler.BusSetDefaults(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90102)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+
+	client := apiclient.New(s.ApiServer.Addr())
+
+	_, err = client.BusGetDefaults(90199)
+	assert.EqualError(t, err, "404 Not Found: bus 90199 not found")
+
+	vid := uint16(0x1234)
+	defaults := map[string]apitypes.DeviceDefaults{
+		"*":       {IdVendor: &vid},
+		"Xbox360": {DeviceSpecific: map[string]any{"subType": 7}},
+	}
+	set, err := client.BusSetDefaults(90102, defaults)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(90102), set.BusID)
+	assert.Contains(t, set.Defaults, "xbox360", "device type keys are normalized")
+
+	got, err := client.BusGetDefaults(90102)
+	require.NoError(t, err)
+	assert.Equal(t, set, got)
+
+	tests := []struct {
+		name        string
+		devType     string
+		opts        *device.CreateOptions
+		wantVid     string
+		wantSubType float64
+	}{
+		{name: "inherits type and wildcard defaults", devType: "xbox360", wantVid: "0x1234", wantSubType: 7},
+		{
+			name:        "explicit options win per key",
+			devType:     "xbox360",
+			opts:        &device.CreateOptions{IdVendor: ptr(uint16(0x045e)), DeviceSpecific: map[string]any{"subType": 2}},
+			wantVid:     "0x045e",
+			wantSubType: 2,
+		},
+		{name: "wildcard only", devType: "keyboard", wantVid: "0x1234"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev, err := client.DeviceAdd(90102, tt.devType, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantVid, dev.Vid)
+			if tt.wantSubType != 0 {
+				assert.Equal(t, tt.wantSubType, dev.DeviceSpecific["subType"])
+			}
+		})
+	}
+
+	_, err = client.BusSetDefaults(90102, map[string]apitypes.DeviceDefaults{
+		"*": {DeviceSpecific: map[string]any{"subType": "a"}},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400 Bad Request: invalid defaults for xbox360")
+
+	got, err = client.BusGetDefaults(90102)
+	require.NoError(t, err)
+	assert.Equal(t, set, got, "rejected defaults must not be applied")
+}
+
+func ptr[T any](v T) *T { return &v }
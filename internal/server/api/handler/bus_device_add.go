@@ -1,17 +1,24 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Alia5/VIIPER/apitypes"
 	"github.com/Alia5/VIIPER/device"
 	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/auth"
 	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
 	usbs "github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
 )
 
 // BusDeviceAdd returns a handler to add devices to a bus.
@@ -37,85 +44,337 @@ func BusDeviceAdd(s *usbs.Server, apiSrv *api.Server) api.HandlerFunc {
 		if err != nil {
 			return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
 		}
-		if deviceCreateReq.Type == nil {
-			return apierror.ErrBadRequest("missing device type")
+		if err := checkAddScope(apiSrv, req.Token, deviceCreateReq); err != nil {
+			return err
 		}
+		existing, err := existingDevice(s, apiSrv, b, deviceCreateReq)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			payload, err := json.Marshal(existing)
+			if err != nil {
+				return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+			}
+			res.JSON = string(payload)
+			return nil
+		}
+		var resp apitypes.Device
+		resp, meta, err := addDevice(s, apiSrv, b, deviceCreateReq, 0, 0, req.Token, apiSrv.Config().DeviceHandlerConnectTimeout, logger)
+		if err != nil {
+			return err
+		}
+		apiSrv.StateChanged()
 
-		name := strings.ToLower(*deviceCreateReq.Type)
+		if apiSrv.Config().AutoAttachLocalClient {
+			err := api.AttachLocalhostClient(
+				req.Ctx,
+				meta,
+				s.GetListenPort(),
+				apiSrv.Config().AutoAttachWindowsNative,
+				logger,
+			)
+			if err != nil {
+				logger.Error("failed to auto-attach localhost client", "error", err)
+				return apierror.ErrConflict(fmt.Sprintf(
+					"Failed to auto-attach device: %v", err,
+				))
+			}
+		}
 
-		reg := api.GetRegistration(name)
-		if reg == nil {
-			return apierror.ErrBadRequest(fmt.Sprintf("unknown device type: %s", name))
+		payload, err := json.Marshal(resp)
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
 		}
 
-		opts := device.CreateOptions{
-			IdVendor:       deviceCreateReq.IdVendor,
-			IdProduct:      deviceCreateReq.IdProduct,
-			DeviceSpecific: deviceCreateReq.DeviceSpecific,
+		res.JSON = string(payload)
+		return nil
+	}
+}
+
+// checkAddScope reports whether tok, if any, may add the device described by
+// deviceCreateReq, which takes the "device:add:<type>" scope.
+func checkAddScope(apiSrv *api.Server, tok *auth.Token, deviceCreateReq apitypes.DeviceCreateRequest) error {
+	if tok == nil {
+		return nil
+	}
+	scope := "device:add:" + strings.ToLower(requestedType(apiSrv, deviceCreateReq))
+	if !tok.Allows(scope) {
+		return apierror.ErrForbidden(fmt.Sprintf("token %s lacks scope %s", tok.ID, scope))
+	}
+	return nil
+}
+
+// requestedType returns the device type deviceCreateReq asks for, directly
+// or through its template; empty if it names neither.
+func requestedType(apiSrv *api.Server, deviceCreateReq apitypes.DeviceCreateRequest) string {
+	if deviceCreateReq.Template != nil {
+		if t, ok := apiSrv.Template(*deviceCreateReq.Template); ok {
+			return t.DeviceType
 		}
+		return ""
+	}
+	if deviceCreateReq.Type != nil {
+		return *deviceCreateReq.Type
+	}
+	return ""
+}
 
-		dev, err := reg.CreateDevice(&opts)
-		if err != nil {
-			return apierror.ErrBadRequest(fmt.Sprintf("failed to create device: %v", err))
+// addDevice creates a device from deviceCreateReq and adds it to b under
+// devID on port, or under the lowest free ID and port if these are 0. The
+// options it ends up with are recorded for the state file, and owner, if
+// any, as the token that added it. The device is removed if no stream opens
+// within connTimeout; a negative connTimeout keeps it until one has come and
+// gone.
+func addDevice(s *usbs.Server, apiSrv *api.Server, b *virtualbus.VirtualBus, deviceCreateReq apitypes.DeviceCreateRequest, devID uint32, port int, owner *auth.Token, connTimeout time.Duration, logger *slog.Logger) (apitypes.Device, *usbip.ExportMeta, error) {
+	busID := b.BusID()
+	explicit := device.CreateOptions{
+		IdVendor:       deviceCreateReq.IdVendor,
+		IdProduct:      deviceCreateReq.IdProduct,
+		SerialNumber:   deviceCreateReq.SerialNumber,
+		ProductString:  deviceCreateReq.ProductString,
+		DeviceSpecific: deviceCreateReq.DeviceSpecific,
+		StrictInput:    deviceCreateReq.StrictInput,
+	}
+	if deviceCreateReq.Template != nil {
+		t, ok := apiSrv.Template(*deviceCreateReq.Template)
+		if !ok {
+			return apitypes.Device{}, nil, apierror.ErrNotFound(fmt.Sprintf("template %s not found", *deviceCreateReq.Template))
 		}
-		devCtx, err := b.Add(dev)
+		if deviceCreateReq.Type != nil && !strings.EqualFold(*deviceCreateReq.Type, t.DeviceType) {
+			return apitypes.Device{}, nil, apierror.ErrBadRequest(fmt.Sprintf("template %s is for device type %s", t.Name, t.DeviceType))
+		}
+		if explicit.IdVendor != nil || explicit.IdProduct != nil ||
+			explicit.SerialNumber != nil || explicit.ProductString != nil || explicit.DeviceSpecific != nil || explicit.StrictInput != nil {
+			return apitypes.Device{}, nil, apierror.ErrBadRequest("options of a templated device go into overrides")
+		}
+		if o := deviceCreateReq.Overrides; o != nil {
+			explicit = device.CreateOptions{
+				IdVendor:       o.IdVendor,
+				IdProduct:      o.IdProduct,
+				SerialNumber:   o.SerialNumber,
+				ProductString:  o.ProductString,
+				DeviceSpecific: o.DeviceSpecific,
+				StrictInput:    o.StrictInput,
+			}
+		}
+		explicit = explicit.WithDefaults(t.Options)
+		deviceCreateReq.Type = &t.DeviceType
+	} else if deviceCreateReq.Overrides != nil {
+		return apitypes.Device{}, nil, apierror.ErrBadRequest("overrides require a template")
+	}
+	if deviceCreateReq.Type == nil {
+		return apitypes.Device{}, nil, apierror.ErrBadRequest("missing device type")
+	}
+	var devName string
+	if id := deviceCreateReq.DevId; id != nil {
+		var num uint32
+		var err error
+		devName, num, err = parseDeviceID(*id)
 		if err != nil {
-			return apierror.ErrInternal(fmt.Sprintf("failed to add device to bus: %v", err))
+			return apitypes.Device{}, nil, err
+		}
+		if num != 0 {
+			devID = num
 		}
+	}
+	var label string
+	if deviceCreateReq.Label != nil {
+		label = *deviceCreateReq.Label
+		if err := virtualbus.ValidateDeviceLabel(label); err != nil {
+			return apitypes.Device{}, nil, apierror.ErrBadRequest(err.Error())
+		}
+	}
 
-		exportMeta := device.GetDeviceMeta(devCtx)
-		if exportMeta == nil {
-			return apierror.ErrInternal("failed to get device metadata from context")
+	name := strings.ToLower(*deviceCreateReq.Type)
+
+	reg := api.GetRegistration(name)
+	if reg == nil {
+		return apitypes.Device{}, nil, apierror.ErrBadRequest(fmt.Sprintf("unknown device type: %s", name))
+	}
+
+	if slot := deviceCreateReq.PlayerSlot; slot != nil {
+		if *slot < 1 || *slot > device.MaxPlayerSlot {
+			return apitypes.Device{}, nil, apierror.ErrBadRequest(fmt.Sprintf("playerSlot must be between 1 and %d", device.MaxPlayerSlot))
 		}
+		for _, m := range b.GetAllDeviceMetas() {
+			if device.PlayerSlotOf(m.Dev) == *slot {
+				return apitypes.Device{}, nil, apierror.ErrConflict(fmt.Sprintf("player slot %d is taken by device %d", *slot, m.Meta.DevId))
+			}
+		}
+	}
 
-		connTimer := device.GetConnTimer(devCtx)
-		if connTimer != nil {
-			connTimer.Reset(apiSrv.Config().DeviceHandlerConnectTimeout)
+	explicit.PlayerSlot = deviceCreateReq.PlayerSlot
+	if m := deviceCreateReq.MSOSDescriptors; m != nil {
+		explicit.MSOS20 = &usb.MSOS20{CompatibleID: m.CompatibleID, SubCompatibleID: m.SubCompatibleID}
+	}
+	if h := deviceCreateReq.Humanize; h != nil {
+		cfg := fromHumanizeConfig(*h)
+		explicit.Humanize = &cfg
+	}
+	if d := deviceCreateReq.Deterministic; d != nil {
+		explicit.Deterministic = &device.DeterministicConfig{Enabled: d.Enabled, Manual: d.Manual}
+	}
+	if d := deviceCreateReq.Disconnect; d != nil {
+		p, err := device.ParseDisconnectPolicy(*d)
+		if err != nil {
+			return apitypes.Device{}, nil, apierror.ErrBadRequest(err.Error())
+		}
+		explicit.Disconnect = &p
+	}
+	if p := deviceCreateReq.StreamPolicy; p != nil {
+		policy, err := device.ParseStreamPolicy(*p)
+		if err != nil {
+			return apitypes.Device{}, nil, apierror.ErrBadRequest(err.Error())
 		}
+		explicit.StreamPolicy = &policy
+	}
+	opts := b.ResolveOptions(name, explicit)
+	disconnect := device.DisconnectHoldLastState
+	if opts.Disconnect != nil {
+		disconnect = *opts.Disconnect
+	}
+	policy := device.StreamPolicySingle
+	if opts.StreamPolicy != nil {
+		policy = *opts.StreamPolicy
+	}
+	layouts, ok := reg.(api.DeltaRegistration)
+	if policy == device.StreamPolicyMixed && !ok {
+		return apitypes.Device{}, nil, apierror.ErrBadRequest(fmt.Sprintf("device type %s does not support mixed streams", name))
+	}
+	if opts.Deterministic != nil && opts.Deterministic.Enabled && opts.Humanize != nil && opts.Humanize.Enabled {
+		return apitypes.Device{}, nil, apierror.ErrBadRequest("deterministic mode cannot be combined with humanize")
+	}
+
+	dev, err := reg.CreateDevice(&opts)
+	if err != nil {
+		return apitypes.Device{}, nil, apierror.ErrBadRequest(fmt.Sprintf("failed to create device: %v", err))
+	}
+	if _, ok := dev.(device.PlayerSlotter); opts.PlayerSlot != nil && !ok {
+		return apitypes.Device{}, nil, apierror.ErrBadRequest(fmt.Sprintf("device type %s does not support player slots", name))
+	}
+	if _, ok := dev.(device.Humanizable); opts.Humanize != nil && opts.Humanize.Enabled && !ok {
+		return apitypes.Device{}, nil, apierror.ErrBadRequest(fmt.Sprintf("device type %s does not support humanize", name))
+	}
+	if _, ok := dev.(device.Steppable); opts.Deterministic != nil && opts.Deterministic.Enabled && !ok {
+		return apitypes.Device{}, nil, apierror.ErrBadRequest(fmt.Sprintf("device type %s does not support deterministic mode", name))
+	}
+	if _, ok := dev.(device.InputResetter); disconnect == device.DisconnectNeutralState && !ok {
+		return apitypes.Device{}, nil, apierror.ErrBadRequest(fmt.Sprintf("device type %s does not support the neutral-state disconnect policy", name))
+	}
+	var devCtx context.Context
+	switch {
+	case devName != "":
+		devCtx, err = b.AddNamed(dev, devName, devID, port)
+	case devID == 0:
+		devCtx, err = b.Add(dev)
+	default:
+		devCtx, err = b.AddWithID(dev, devID, port)
+	}
+	if errors.Is(err, virtualbus.ErrBusFull) || errors.Is(err, virtualbus.ErrDeviceIDTaken) {
+		return apitypes.Device{}, nil, apierror.ErrConflict(err.Error())
+	}
+	if errors.Is(err, virtualbus.ErrInvalidDescriptor) {
+		return apitypes.Device{}, nil, apierror.ErrUnprocessable(err.Error())
+	}
+	if err != nil {
+		return apitypes.Device{}, nil, apierror.ErrInternal(fmt.Sprintf("failed to add device to bus: %v", err))
+	}
+	_ = b.SetDisconnectPolicy(dev, disconnect)
+	if policy == device.StreamPolicyMixed {
+		_ = b.SetStreamMixer(dev, device.NewMixer(layouts.InputLayout(dev)))
+	}
+	_ = b.SetDeviceLabel(dev, label)
+
+	apiSrv.SetStrictInput(devCtx, dev, opts.StrictInput != nil && *opts.StrictInput)
+	humanize := humanizeOf(dev)
+	spec := apitypes.DeviceCreateRequest{
+		Type:            &name,
+		IdVendor:        opts.IdVendor,
+		IdProduct:       opts.IdProduct,
+		SerialNumber:    opts.SerialNumber,
+		ProductString:   opts.ProductString,
+		DeviceSpecific:  opts.DeviceSpecific,
+		StrictInput:     opts.StrictInput,
+		PlayerSlot:      opts.PlayerSlot,
+		MSOSDescriptors: deviceCreateReq.MSOSDescriptors,
+		Humanize:        humanize,
+		Deterministic:   deterministicOf(dev),
+		DevId:           deviceCreateReq.DevId,
+	}
+	if d := disconnectOf(disconnect); d != "" {
+		spec.Disconnect = &d
+	}
+	if policy != device.StreamPolicySingle {
+		p := string(policy)
+		spec.StreamPolicy = &p
+	}
+	apiSrv.SetDeviceSpec(devCtx, dev, spec)
+	apiSrv.SetDeviceOwner(devCtx, dev, owner)
+	if humanize != nil {
+		logger.Info("device input humanized", "busID", busID, "type", name,
+			"keyIntervalMeanMs", humanize.KeyIntervalMeanMs, "keyIntervalStdDev", humanize.KeyIntervalStdDev,
+			"mouseJitterPx", humanize.MouseJitterPx, "seed", humanize.Seed)
+	}
+
+	exportMeta := device.GetDeviceMeta(devCtx)
+	if exportMeta == nil {
+		return apitypes.Device{}, nil, apierror.ErrInternal("failed to get device metadata from context")
+	}
+
+	if connTimer := device.GetConnTimer(devCtx); connTimer != nil && connTimeout >= 0 {
+		connTimer.Reset(connTimeout)
 		go func() {
 			select {
 			case <-devCtx.Done():
 				connTimer.Stop()
 				return
 			case <-connTimer.C:
-				deviceIDStr := fmt.Sprintf("%d", exportMeta.DevId)
-				if err := s.RemoveDeviceByID(uint32(busID), deviceIDStr); err != nil {
+				deviceIDStr := virtualbus.DeviceID(exportMeta)
+				if err := s.RemoveDeviceByID(busID, deviceIDStr); err != nil {
 					logger.Error("timeout: failed to remove device", "busID", busID, "deviceID", deviceIDStr, "error", err)
 				} else {
 					logger.Info("timeout: removed device (no connection)", "busID", busID, "deviceID", deviceIDStr)
 				}
 			}
 		}()
+	}
 
-		if apiSrv.Config().AutoAttachLocalClient {
-			err := api.AttachLocalhostClient(
-				req.Ctx,
-				exportMeta,
-				s.GetListenPort(),
-				apiSrv.Config().AutoAttachWindowsNative,
-				logger,
-			)
-			if err != nil {
-				logger.Error("failed to auto-attach localhost client", "error", err)
-				return apierror.ErrConflict(fmt.Sprintf(
-					"Failed to auto-attach device: %v", err,
-				))
-			}
-		}
+	desc := dev.GetDescriptor()
+	product, serial := device.Identity(desc)
+	return apitypes.Device{
+		BusID:          busID,
+		DevId:          virtualbus.DeviceID(exportMeta),
+		Vid:            fmt.Sprintf("0x%04x", desc.Device.IDVendor),
+		Pid:            fmt.Sprintf("0x%04x", desc.Device.IDProduct),
+		ProductString:  product,
+		SerialNumber:   serial,
+		Port:           virtualbus.PortPath(busID, b.Port(dev)),
+		Type:           name,
+		DeviceSpecific: dev.GetDeviceSpecificArgs(),
+		PlayerSlot:     device.PlayerSlotOf(dev),
+		Humanize:       humanize,
+		Deterministic:  spec.Deterministic,
+		Disconnect:     disconnectOf(disconnect),
+		StreamPolicy:   streamPolicyOf(policy),
+		Label:          label,
+	}, exportMeta, nil
+}
 
-		payload, err := json.Marshal(apitypes.Device{
-			BusID:          uint32(busID),
-			DevId:          fmt.Sprintf("%d", exportMeta.DevId),
-			Vid:            fmt.Sprintf("0x%04x", dev.GetDescriptor().Device.IDVendor),
-			Pid:            fmt.Sprintf("0x%04x", dev.GetDescriptor().Device.IDProduct),
-			Type:           name,
-			DeviceSpecific: dev.GetDeviceSpecificArgs(),
-		})
-		if err != nil {
-			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
-		}
+// streamPolicyOf returns p as reported in device info, empty for the
+// default.
+func streamPolicyOf(p device.StreamPolicy) string {
+	if p == device.StreamPolicySingle {
+		return ""
+	}
+	return string(p)
+}
 
-		res.JSON = string(payload)
-		return nil
+// disconnectOf returns the disconnect policy as reported in apitypes.Device,
+// empty for the default.
+func disconnectOf(p device.DisconnectPolicy) string {
+	if p == device.DisconnectHoldLastState {
+		return ""
 	}
+	return string(p)
 }
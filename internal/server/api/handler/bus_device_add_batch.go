@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	usbs "github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/usbip"
+)
+
+// BusDeviceAddBatch returns a handler that adds several devices to a bus in
+// one call. Either all devices are added or, once one fails, the ones added
+// before it are removed again and the error of the failing entry returned.
+// Entries upserting a device that exists are answered with it and kept.
+func BusDeviceAddBatch(s *usbs.Server, apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		b, err := busFromParams(s, req.Params)
+		if err != nil {
+			return err
+		}
+		if req.Payload == "" {
+			return apierror.ErrBadRequest("missing payload")
+		}
+		var batchReq apitypes.DeviceAddBatchRequest
+		if err := json.Unmarshal([]byte(req.Payload), &batchReq); err != nil {
+			return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+		}
+		if len(batchReq.Devices) == 0 {
+			return apierror.ErrBadRequest("no devices to add")
+		}
+		existing := make([]*apitypes.Device, len(batchReq.Devices))
+		toAdd := 0
+		for i, r := range batchReq.Devices {
+			if err := checkAddScope(apiSrv, req.Token, r); err != nil {
+				return entryError(i, err)
+			}
+			if existing[i], err = existingDevice(s, apiSrv, b, r); err != nil {
+				return entryError(i, err)
+			}
+			if existing[i] == nil {
+				toAdd++
+			}
+		}
+		if free := b.MaxDevices() - b.DeviceCount(); toAdd > free {
+			return apierror.ErrConflict(fmt.Sprintf("bus %d has room for %d more devices", b.BusID(), free))
+		}
+
+		// Devices upserted rather than added are left alone on failure.
+		type added struct {
+			entry int
+			dev   apitypes.Device
+			meta  *usbip.ExportMeta
+		}
+		resp := apitypes.DeviceAddBatchResponse{Devices: make([]apitypes.Device, 0, len(batchReq.Devices))}
+		var created []added
+		rollback := func() {
+			for _, a := range created {
+				if err := s.RemoveDeviceByID(a.dev.BusID, a.dev.DevId); err != nil {
+					logger.Error("add-batch: failed to roll back device", "busID", a.dev.BusID, "deviceID", a.dev.DevId, "error", err)
+				}
+			}
+		}
+		for i, r := range batchReq.Devices {
+			if existing[i] != nil {
+				resp.Devices = append(resp.Devices, *existing[i])
+				continue
+			}
+			dev, meta, err := addDevice(s, apiSrv, b, r, 0, 0, req.Token, apiSrv.Config().DeviceHandlerConnectTimeout, logger)
+			if err != nil {
+				rollback()
+				return entryError(i, err)
+			}
+			resp.Devices = append(resp.Devices, dev)
+			created = append(created, added{entry: i, dev: dev, meta: meta})
+		}
+
+		if apiSrv.Config().AutoAttachLocalClient {
+			for _, a := range created {
+				err := api.AttachLocalhostClient(
+					req.Ctx,
+					a.meta,
+					s.GetListenPort(),
+					apiSrv.Config().AutoAttachWindowsNative,
+					logger,
+				)
+				if err != nil {
+					logger.Error("failed to auto-attach localhost client", "error", err)
+					rollback()
+					return entryError(a.entry, apierror.ErrConflict(fmt.Sprintf(
+						"Failed to auto-attach device: %v", err,
+					)))
+				}
+			}
+		}
+		apiSrv.StateChanged()
+
+		payload, err := json.Marshal(resp)
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
+
+// entryError prefixes the detail of err with the index of the batch entry
+// that caused it, keeping its status.
+func entryError(i int, err error) error {
+	ae := apierror.WrapError(err)
+	ae.Detail = fmt.Sprintf("device %d: %s", i, ae.Detail)
+	return ae
+}
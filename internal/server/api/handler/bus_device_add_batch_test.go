@@ -0,0 +1,104 @@
+package handler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	_ "github.com/Alia5/VIIPER/internal/registry"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+)
+
+func TestBusDeviceAddBatch(t *testing.T) {
+	cfg := viiperTesting.TestServerConfig(t)
+	cfg.Server.ApiServerConfig.DeviceHandlerConnectTimeout = time.Minute
+	s := viiperTesting.NewTestServerWithConfig(t, cfg)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/create", handler.BusCreate(s.UsbServer))
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.Register("bus/{id}/add-batch", handler.BusDeviceAddBatch(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/remove", handler.BusDeviceRemove(s.UsbServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+	client := apiclient.New(s.ApiServer.Addr())
+
+	_, err := client.BusCreateWith(apitypes.BusCreateRequest{BusID: 90172, MaxDevices: 4})
+	require.NoError(t, err)
+	defer func() { _ = s.UsbServer.RemoveBus(90172) }()
+
+	list := func() []apitypes.Device {
+		l, err := client.DevicesList(90172)
+		require.NoError(t, err)
+		return l.Devices
+	}
+	typed := func(types ...string) []apitypes.DeviceCreateRequest {
+		out := make([]apitypes.DeviceCreateRequest, len(types))
+		for i := range types {
+			out[i].Type = &types[i]
+		}
+		return out
+	}
+
+	t.Run("rolls back on an invalid entry", func(t *testing.T) {
+		_, err := client.DeviceAddBatch(90172, typed("xbox360", "nope", "keyboard"))
+		assert.EqualError(t, err, "400 Bad Request: device 1: unknown device type: nope")
+		assert.Empty(t, list(), "the xbox360 added first is removed again")
+	})
+
+	t.Run("rejects more devices than the bus has room for", func(t *testing.T) {
+		_, err := client.DeviceAddBatch(90172, typed("mouse", "mouse", "mouse", "mouse", "mouse"))
+		assert.EqualError(t, err, "409 Conflict: bus 90172 has room for 4 more devices")
+		_, err = client.DeviceAddBatch(90172, nil)
+		assert.EqualError(t, err, "400 Bad Request: no devices to add")
+		assert.Empty(t, list())
+	})
+
+	t.Run("adds all devices in order", func(t *testing.T) {
+		label := "p2"
+		reqs := typed("xbox360", "keyboard")
+		reqs[1].Label = &label
+		resp, err := client.DeviceAddBatch(90172, reqs)
+		require.NoError(t, err)
+		require.Len(t, resp.Devices, 2)
+		assert.Equal(t, "xbox360", resp.Devices[0].Type)
+		assert.Equal(t, "keyboard", resp.Devices[1].Type)
+		assert.Equal(t, "p2", resp.Devices[1].Label)
+		assert.ElementsMatch(t, resp.Devices, list())
+
+		for _, d := range resp.Devices {
+			_, err := client.DeviceRemove(90172, d.DevId)
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("accepts a bare array", func(t *testing.T) {
+		raw, err := apiclient.NewTransport(s.ApiServer.Addr()).Do("bus/{id}/add-batch", `[{"type":"mouse"}]`, map[string]string{"id": "90172"})
+		require.NoError(t, err)
+		assert.Contains(t, raw, `"type":"mouse"`)
+		for _, d := range list() {
+			_, err := client.DeviceRemove(90172, d.DevId)
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("connects all streams", func(t *testing.T) {
+		streams, devs, err := client.AddDevicesAndConnect(context.Background(), 90172, typed("xbox360", "xbox360", "mouse"))
+		require.NoError(t, err)
+		require.Len(t, streams, 3)
+		require.Len(t, devs, 3)
+		for _, st := range streams {
+			require.NotNil(t, st)
+			require.NoError(t, st.Close())
+		}
+	})
+}
@@ -2,16 +2,23 @@ package handler_test
 
 import (
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
 	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
 	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/device/keyboard"
+	_ "github.com/Alia5/VIIPER/device/mouse"
 	"github.com/Alia5/VIIPER/device/xbox360"
 	th "github.com/Alia5/VIIPER/internal/_testing"
 	"github.com/Alia5/VIIPER/internal/log"
@@ -44,7 +51,7 @@ func TestBusDeviceAdd(t *testing.T) {
 			},
 			pathParams:       map[string]string{"id": "80001"},
 			payload:          `{"type": "xbox360"}`,
-			expectedResponse: `{"busId":80001, "devId": "1", "deviceSpecific": {"subType": 1}, "vid":"0x045e", "pid":"0x028e", "type":"xbox360"}`,
+			expectedResponse: `{"busId":80001, "devId": "1", "port":"80001-1", "deviceSpecific": {"subType": 1}, "vid":"0x045e", "pid":"0x028e", "productString":"VIIPER Controller", "serialNumber":"296013F", "type":"xbox360"}`,
 		},
 		{
 			name: "add device to existing bus with device specific args",
@@ -59,7 +66,7 @@ func TestBusDeviceAdd(t *testing.T) {
 			},
 			pathParams:       map[string]string{"id": "80001"},
 			payload:          `{"type": "xbox360", "deviceSpecific":{"subType": 7}}`,
-			expectedResponse: `{"busId":80001, "devId": "1", "deviceSpecific": {"subType": 7}, "vid":"0x045e", "pid":"0x028e", "type":"xbox360"}`,
+			expectedResponse: `{"busId":80001, "devId": "1", "port":"80001-1", "deviceSpecific": {"subType": 7}, "vid":"0x045e", "pid":"0x028e", "productString":"VIIPER Controller", "serialNumber":"296013F", "type":"xbox360"}`,
 		},
 		{
 			name: "invalid device specific args",
@@ -143,7 +150,84 @@ func TestBusDeviceAdd(t *testing.T) {
 			},
 			pathParams:       map[string]string{"id": "80005"},
 			payload:          `{"type": "xbox360"}`,
-			expectedResponse: `{"busId":80005, "devId": "1", "deviceSpecific": {"subType":1}, "vid":"0x045e", "pid":"0x028e", "type":"xbox360"}`,
+			expectedResponse: `{"busId":80005, "devId": "1", "port":"80005-1", "deviceSpecific": {"subType":1}, "vid":"0x045e", "pid":"0x028e", "productString":"VIIPER Controller", "serialNumber":"296013F", "type":"xbox360"}`,
+		},
+		{
+			name: "humanized keyboard echoes its settings",
+			setup: func(t *testing.T, s *usb.Server, as *api.Server) {
+				b, err := virtualbus.NewWithBusId(80011)
+				require.NoError(t, err)
+				require.NoError(t, s.AddBus(b))
+			},
+			pathParams:       map[string]string{"id": "80011"},
+			payload:          `{"type": "keyboard", "humanize": {"enabled": true, "mouseJitterPx": 3, "seed": 7}}`,
+			expectedResponse: `{"busId":80011, "devId": "1", "port":"80011-1", "deviceSpecific": {}, "vid":"0x2e8a", "pid":"0x0010", "productString":"HID Keyboard", "serialNumber":"1337", "type":"keyboard", "humanize": {"enabled": true, "keyIntervalMeanMs": 120, "keyIntervalStdDev": 40, "mouseJitterPx": 3, "seed": 7}}`,
+		},
+		{
+			name: "humanize needs a keyboard or mouse",
+			setup: func(t *testing.T, s *usb.Server, as *api.Server) {
+				b, err := virtualbus.NewWithBusId(80012)
+				require.NoError(t, err)
+				require.NoError(t, s.AddBus(b))
+			},
+			pathParams:       map[string]string{"id": "80012"},
+			payload:          `{"type": "xbox360", "humanize": {"enabled": true}}`,
+			expectedResponse: `{"status":400,"title":"Bad Request","detail":"device type xbox360 does not support humanize"}`,
+		},
+		{
+			name: "humanize limits",
+			setup: func(t *testing.T, s *usb.Server, as *api.Server) {
+				b, err := virtualbus.NewWithBusId(80013)
+				require.NoError(t, err)
+				require.NoError(t, s.AddBus(b))
+			},
+			pathParams:       map[string]string{"id": "80013"},
+			payload:          `{"type": "mouse", "humanize": {"enabled": true, "mouseJitterPx": 500}}`,
+			expectedResponse: `{"status":400,"title":"Bad Request","detail":"failed to create device: mouse jitter must be between 0 and 64 pixels"}`,
+		},
+		{
+			name: "disconnect policy echoed",
+			setup: func(t *testing.T, s *usb.Server, as *api.Server) {
+				b, err := virtualbus.NewWithBusId(80014)
+				require.NoError(t, err)
+				require.NoError(t, s.AddBus(b))
+			},
+			pathParams:       map[string]string{"id": "80014"},
+			payload:          `{"type": "xbox360", "disconnect": "detach"}`,
+			expectedResponse: `{"busId":80014, "devId": "1", "port":"80014-1", "deviceSpecific": {"subType": 1}, "vid":"0x045e", "pid":"0x028e", "productString":"VIIPER Controller", "serialNumber":"296013F", "type":"xbox360", "disconnect": "detach"}`,
+		},
+		{
+			name: "unknown disconnect policy",
+			setup: func(t *testing.T, s *usb.Server, as *api.Server) {
+				b, err := virtualbus.NewWithBusId(80015)
+				require.NoError(t, err)
+				require.NoError(t, s.AddBus(b))
+			},
+			pathParams:       map[string]string{"id": "80015"},
+			payload:          `{"type": "xbox360", "disconnect": "freeze"}`,
+			expectedResponse: `{"status":400,"title":"Bad Request","detail":"unknown disconnect policy \"freeze\""}`,
+		},
+		{
+			name: "identity overrides",
+			setup: func(t *testing.T, s *usb.Server, as *api.Server) {
+				b, err := virtualbus.NewWithBusId(80016)
+				require.NoError(t, err)
+				require.NoError(t, s.AddBus(b))
+			},
+			pathParams:       map[string]string{"id": "80016"},
+			payload:          `{"type": "dualshock4", "idVendor": "0x1209", "serialNumber": "pad-2", "productString": "Rig Pad"}`,
+			expectedResponse: `{"busId":80016, "devId": "1", "port":"80016-1", "deviceSpecific": {}, "vid":"0x1209", "pid":"0x05c4", "productString":"Rig Pad", "serialNumber":"pad-2", "type":"dualshock4"}`,
+		},
+		{
+			name: "product string too long",
+			setup: func(t *testing.T, s *usb.Server, as *api.Server) {
+				b, err := virtualbus.NewWithBusId(80017)
+				require.NoError(t, err)
+				require.NoError(t, s.AddBus(b))
+			},
+			pathParams:       map[string]string{"id": "80017"},
+			payload:          `{"type": "mouse", "productString": "` + strings.Repeat("x", 127) + `"}`,
+			expectedResponse: `{"status":400,"title":"Bad Request","detail":"failed to create device: productString is longer than 126 characters"}`,
 		},
 		{
 			name: "autoattach fails returns error",
@@ -229,7 +313,9 @@ func TestBusDeviceAdd_NoConnection_TimeoutCleanup(t *testing.T) {
 		func(conn net.Conn, devPtr *pusb.Device, l *slog.Logger) error { return nil },
 	)
 
+	orig := api.GetRegistration("xbox360")
 	api.RegisterDevice("xbox360", testReg)
+	t.Cleanup(func() { api.RegisterDevice("xbox360", orig) })
 
 	c := apiclient.New(addr)
 	_, err = c.DeviceAdd(80100, "xbox360", nil)
@@ -248,3 +334,105 @@ func TestBusDeviceAdd_NoConnection_TimeoutCleanup(t *testing.T) {
 		return len(usbSrv.ListBuses()) == 0
 	}, 3*time.Second, 50*time.Millisecond)
 }
+
+func TestBusDeviceAddBusFull(t *testing.T) {
+	cfg := viiperTesting.TestServerConfig(t)
+	cfg.Server.ApiServerConfig.DeviceHandlerConnectTimeout = time.Minute
+	s := viiperTesting.NewTestServerWithConfig(t, cfg)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/list", handler.BusList(s.UsbServer))
+	r.Register("bus/create", handler.BusCreate(s.UsbServer))
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/remove", handler.BusDeviceRemove(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+	client := apiclient.New(s.ApiServer.Addr())
+
+	_, err := client.BusCreateWith(apitypes.BusCreateRequest{BusID: 90151, MaxDevices: virtualbus.MaxDevicesLimit + 1})
+	require.ErrorIs(t, err, apiclient.ErrBadRequest)
+	_, err = client.BusCreateWith(apitypes.BusCreateRequest{BusID: 90151, MaxDevices: 3})
+	require.NoError(t, err)
+	defer func() { _ = s.UsbServer.RemoveBus(90151) }()
+
+	for i := 1; i <= 3; i++ {
+		dev, err := client.DeviceAdd(90151, "keyboard", nil)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("90151-%d", i), dev.Port)
+	}
+	_, err = client.DeviceAdd(90151, "keyboard", nil)
+	require.ErrorIs(t, err, apiclient.ErrConflict)
+	assert.ErrorContains(t, err, "bus is full")
+
+	buses, err := client.BusList()
+	require.NoError(t, err)
+	assert.Contains(t, buses.BusInfo, apitypes.BusInfo{BusID: 90151, DeviceCount: 3, MaxDevices: 3})
+
+	_, err = client.DeviceRemove(90151, "2")
+	require.NoError(t, err)
+	dev, err := client.DeviceAdd(90151, "mouse", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "90151-2", dev.Port, "the freed port is reused")
+	assert.Equal(t, "2", dev.DevId)
+
+	list, err := client.DevicesList(90151)
+	require.NoError(t, err)
+	ports := make([]string, 0, len(list.Devices))
+	for _, d := range list.Devices {
+		ports = append(ports, d.Port)
+	}
+	assert.ElementsMatch(t, []string{"90151-1", "90151-2", "90151-3"}, ports)
+}
+
+// miscountedKeyboard is a keyboard whose interface announces one endpoint
+// more than it has.
+type miscountedKeyboard struct {
+	*keyboard.Keyboard
+	desc pusb.Descriptor
+}
+
+func (k *miscountedKeyboard) GetDescriptor() *pusb.Descriptor { return &k.desc }
+
+func (k *miscountedKeyboard) HandleInput([]byte) error { return nil }
+
+func TestBusDeviceAddInvalidDescriptor(t *testing.T) {
+	device.Register("miscountedkeyboard", func(o *device.CreateOptions) (pusb.Device, error) {
+		kb, err := keyboard.New(o)
+		if err != nil {
+			return nil, err
+		}
+		if o == nil {
+			// Register lints a device created without options and would
+			// refuse the type; only devices added through the API are broken.
+			return &miscountedKeyboard{Keyboard: kb, desc: *kb.GetDescriptor()}, nil
+		}
+		k := &miscountedKeyboard{Keyboard: kb, desc: *kb.GetDescriptor()}
+		k.desc.Interfaces = slices.Clone(k.desc.Interfaces)
+		k.desc.Interfaces[0].Descriptor.BNumEndpoints++
+		return k, nil
+	}, device.WireInfo{InputSize: 1})
+
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+	r := s.ApiServer.Router()
+	r.Register("bus/create", handler.BusCreate(s.UsbServer))
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	require.NoError(t, s.ApiServer.Start())
+	client := apiclient.New(s.ApiServer.Addr())
+
+	_, err := client.BusCreate(90174)
+	require.NoError(t, err)
+	defer func() { _ = s.UsbServer.RemoveBus(90174) }()
+
+	_, err = client.DeviceAdd(90174, "miscountedkeyboard", nil)
+	require.ErrorIs(t, err, apiclient.ErrUnprocessable)
+	assert.EqualError(t, err, "422 Unprocessable Entity: invalid device descriptor: usb: interface 0 alt 0: bNumEndpoints is 3 but 2 endpoints are defined")
+
+	list, err := client.DevicesList(90174)
+	require.NoError(t, err)
+	assert.Empty(t, list.Devices)
+}
@@ -10,9 +10,12 @@ import (
 	"strings"
 
 	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device"
 	"github.com/Alia5/VIIPER/internal/server/api"
 	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
 	"github.com/Alia5/VIIPER/internal/server/usb"
+	pusb "github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/virtualbus"
 )
 
 // BusDevicesList returns a handler that lists devices on a bus.
@@ -33,15 +36,7 @@ func BusDevicesList(s *usb.Server) api.HandlerFunc {
 		metas := b.GetAllDeviceMetas()
 		out := make([]apitypes.Device, 0, len(metas))
 		for _, m := range metas {
-			dtype := inferDeviceType(m.Dev)
-			out = append(out, apitypes.Device{
-				BusID:          m.Meta.BusId,
-				DevId:          fmt.Sprintf("%d", m.Meta.DevId),
-				Vid:            fmt.Sprintf("0x%04x", m.Dev.GetDescriptor().Device.IDVendor),
-				Pid:            fmt.Sprintf("0x%04x", m.Dev.GetDescriptor().Device.IDProduct),
-				Type:           dtype,
-				DeviceSpecific: m.Dev.GetDeviceSpecificArgs(),
-			})
+			out = append(out, deviceInfo(s, m))
 		}
 		payload, err := json.Marshal(apitypes.DevicesListResponse{Devices: out})
 		if err != nil {
@@ -52,6 +47,52 @@ func BusDevicesList(s *usb.Server) api.HandlerFunc {
 	}
 }
 
+// deviceInfo describes the device of m as listed by bus/{id}/list.
+func deviceInfo(s *usb.Server, m virtualbus.DeviceMeta) apitypes.Device {
+	desc := m.Dev.GetDescriptor()
+	product, serial := device.Identity(desc)
+	info := apitypes.Device{
+		BusID:          m.Meta.BusId,
+		DevId:          virtualbus.DeviceID(&m.Meta),
+		Vid:            fmt.Sprintf("0x%04x", desc.Device.IDVendor),
+		Pid:            fmt.Sprintf("0x%04x", desc.Device.IDProduct),
+		ProductString:  product,
+		SerialNumber:   serial,
+		Port:           virtualbus.PortPath(m.Meta.BusId, m.Port),
+		Type:           inferDeviceType(m.Dev),
+		DeviceSpecific: m.Dev.GetDeviceSpecificArgs(),
+		PlayerSlot:     device.PlayerSlotOf(m.Dev),
+		AliasOf:        aliasOf(s, m.Dev),
+		Degrade:        degradeOf(m.Dev),
+		Humanize:       humanizeOf(m.Dev),
+		Deterministic:  deterministicOf(m.Dev),
+		Disconnect:     disconnectOf(m.Disconnect),
+		Label:          m.Label,
+		LocalAttach:    localAttachOf(s, m),
+	}
+	if m.Mixer != nil {
+		info.StreamPolicy = string(device.StreamPolicyMixed)
+		for _, c := range m.Mixer.Claims() {
+			info.Claims = append(info.Claims, apitypes.StreamClaim{Source: c.Source, Fields: c.Fields})
+		}
+	}
+	return info
+}
+
+// localAttachOf returns the loopback attachment of the device of m, nil
+// unless the server attaches devices locally.
+func localAttachOf(s *usb.Server, m virtualbus.DeviceMeta) *apitypes.LocalAttachStatus {
+	st, ok := s.LoopbackStatus(m.Meta.BusId, virtualbus.DeviceID(&m.Meta))
+	if !ok {
+		return nil
+	}
+	out := &apitypes.LocalAttachStatus{Attached: st.Attached, VhciPort: st.Port}
+	if st.Err != nil {
+		out.Error = st.Err.Error()
+	}
+	return out
+}
+
 // inferDeviceType attempts to derive a friendly device type name from the concrete type.
 // For devices under /devices/<name>, we return the last path element (e.g., "xbox360").
 // Fallback to the lowercased concrete type name if the package path is unavailable.
@@ -59,6 +100,11 @@ func inferDeviceType(dev any) string {
 	if dev == nil {
 		return ""
 	}
+	if d, ok := dev.(pusb.Device); ok {
+		if name, ok := device.RegisteredType(d); ok {
+			return name
+		}
+	}
 	t := reflect.TypeOf(dev)
 	if t.Kind() == reflect.Ptr {
 		t = t.Elem()
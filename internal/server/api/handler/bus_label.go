@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+)
+
+// BusSetLabel returns a handler that replaces the label and description of a bus.
+// Exported USB-IP paths pick up the new label for devices added afterwards.
+func BusSetLabel(s *usb.Server, apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		b, err := busFromParams(s, req.Params)
+		if err != nil {
+			return err
+		}
+		if req.Payload == "" {
+			return apierror.ErrBadRequest("missing payload")
+		}
+		var labelReq apitypes.BusLabelRequest
+		if err := json.Unmarshal([]byte(req.Payload), &labelReq); err != nil {
+			return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+		}
+		if err := b.SetLabel(labelReq.Label, labelReq.Description); err != nil {
+			return apierror.ErrBadRequest(err.Error())
+		}
+		logger.Info("set bus label", "busID", b.BusID(), "label", labelReq.Label)
+		apiSrv.StateChanged()
+		payload, err := json.Marshal(apitypes.BusInfo{
+			BusID:       b.BusID(),
+			Label:       labelReq.Label,
+			Description: labelReq.Description,
+			DeviceCount: b.DeviceCount(),
+		})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
@@ -0,0 +1,69 @@
+package handler_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	_ "github.com/Alia5/VIIPER/internal/registry"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func TestBusLabel(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/list", handler.BusList(s.UsbServer))
+	r.Register("bus/create", handler.BusCreate(s.UsbServer))
+	r.Register("bus/remove", handler.BusRemove(s.UsbServer))
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/label", handler.BusSetLabel(s.UsbServer, s.ApiServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	client := apiclient.New(s.ApiServer.Addr())
+	created, err := client.BusCreateLabeled(90107, "CI rig: Pads!", "nightly runs")
+	require.NoError(t, err)
+	defer func() { _ = s.UsbServer.RemoveBus(created.BusID) }()
+
+	devicePath := func(devID uint32) string {
+		for _, m := range s.UsbServer.GetBus(90107).GetAllDeviceMetas() {
+			if m.Meta.DevId == devID {
+				return string(m.Meta.Path[:strings.IndexByte(string(m.Meta.Path[:]), 0)])
+			}
+		}
+		t.Fatalf("device %d not found", devID)
+		return ""
+	}
+
+	_, err = client.DeviceAdd(90107, "xbox360", nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(devicePath(1), "/usb90107-ci-rig-pads/90107-1"), devicePath(1))
+
+	list, err := client.BusList()
+	require.NoError(t, err)
+	assert.Contains(t, list.Buses, uint32(90107))
+	assert.Contains(t, list.BusInfo, apitypes.BusInfo{BusID: 90107, Label: "CI rig: Pads!", Description: "nightly runs", DeviceCount: 1, MaxDevices: virtualbus.DefaultMaxDevices})
+
+	long := strings.Repeat("abcdefgh ", 7)
+	info, err := client.BusSetLabel(90107, long, "")
+	require.NoError(t, err)
+	assert.Equal(t, apitypes.BusInfo{BusID: 90107, Label: long, DeviceCount: 1}, *info)
+
+	_, err = client.DeviceAdd(90107, "xbox360", nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(devicePath(1), "/usb90107-ci-rig-pads/90107-1"), "existing devices keep their path")
+	assert.True(t, strings.HasSuffix(devicePath(2), "/usb90107-abcdefgh-abcdefgh-abcdefgh-abcde/90107-2"), devicePath(2))
+
+	_, err = client.BusSetLabel(90107, "ok", strings.Repeat("x", 257))
+	assert.EqualError(t, err, "400 Bad Request: description exceeds 256 bytes")
+	_, err = client.BusSetLabel(90199, "ok", "")
+	assert.EqualError(t, err, "404 Not Found: bus 90199 not found")
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/Alia5/VIIPER/apitypes"
 	"github.com/Alia5/VIIPER/internal/server/api"
 	"github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/virtualbus"
 )
 
 // BusList returns a handler that lists registered busses.
@@ -14,7 +15,12 @@ import (
 func BusList(s *usb.Server) api.HandlerFunc {
 	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
 		buses := s.ListBuses()
-		payload := apitypes.BusListResponse{Buses: buses}
+		payload := apitypes.BusListResponse{Buses: buses, BusInfo: make([]apitypes.BusInfo, 0, len(buses))}
+		for _, id := range buses {
+			if bus := s.GetBus(id); bus != nil {
+				payload.BusInfo = append(payload.BusInfo, busInfo(bus))
+			}
+		}
 		b, err := json.Marshal(payload)
 		if err != nil {
 			return err
@@ -23,3 +29,14 @@ func BusList(s *usb.Server) api.HandlerFunc {
 		return nil
 	}
 }
+
+func busInfo(b *virtualbus.VirtualBus) apitypes.BusInfo {
+	label, description := b.Label()
+	return apitypes.BusInfo{
+		BusID:       b.BusID(),
+		Label:       label,
+		Description: description,
+		DeviceCount: b.DeviceCount(),
+		MaxDevices:  b.MaxDevices(),
+	}
+}
@@ -22,7 +22,7 @@ func TestBusList(t *testing.T) {
 		{
 			name:             "empty list",
 			setup:            nil,
-			expectedResponse: `{"buses":[]}`,
+			expectedResponse: `{"buses":[],"busInfo":[]}`,
 		},
 		{
 			name: "list with one bus",
@@ -35,7 +35,23 @@ func TestBusList(t *testing.T) {
 					t.Fatalf("add bus failed: %v", err)
 				}
 			},
-			expectedResponse: `{"buses":[60005]}`,
+			expectedResponse: `{"buses":[60005],"busInfo":[{"busId":60005,"deviceCount":0,"maxDevices":8}]}`,
+		},
+		{
+			name: "list with labeled bus",
+			setup: func(t *testing.T, s *usb.Server) {
+				b, err := virtualbus.NewWithBusId(60006)
+				if err != nil {
+					t.Fatalf("create bus failed: %v", err)
+				}
+				if err := b.SetLabel("CI rig pads", "nightly runs"); err != nil {
+					t.Fatalf("set label failed: %v", err)
+				}
+				if err := s.AddBus(b); err != nil {
+					t.Fatalf("add bus failed: %v", err)
+				}
+			},
+			expectedResponse: `{"buses":[60006],"busInfo":[{"busId":60006,"label":"CI rig pads","description":"nightly runs","deviceCount":0,"maxDevices":8}]}`,
 		},
 	}
 
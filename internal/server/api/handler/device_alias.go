@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strconv"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	usbs "github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// aliasMarker is appended to the product string of aliases so hosts can
+// tell them apart from the original.
+const aliasMarker = " (alias)"
+
+// DeviceAlias returns a handler that exposes a device on another bus.
+// The alias reports the input of the original but receives its own host
+// feedback, which is delivered on the alias's own stream.
+func DeviceAlias(s *usbs.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		busID, devID, dev, err := deviceFromParams(s, req.Params)
+		if err != nil {
+			return err
+		}
+		if _, ok := s.AliasSourceOf(dev); ok {
+			return apierror.ErrBadRequest("aliases cannot be aliased")
+		}
+		if req.Payload == "" {
+			return apierror.ErrBadRequest("missing payload")
+		}
+		var aliasReq apitypes.DeviceAliasRequest
+		if err := json.Unmarshal([]byte(req.Payload), &aliasReq); err != nil {
+			return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+		}
+		target := s.GetBus(aliasReq.BusID)
+		if target == nil {
+			return apierror.ErrNotFound(fmt.Sprintf("bus %d not found", aliasReq.BusID))
+		}
+		srcBus := s.GetBus(busID)
+		if srcBus == nil {
+			return apierror.ErrNotFound(fmt.Sprintf("bus %d not found", busID))
+		}
+		srcCtx := srcBus.GetDeviceContext(dev)
+		if srcCtx == nil {
+			return apierror.ErrNotFound(fmt.Sprintf("device %s not found on bus %d", devID, busID))
+		}
+
+		name := inferDeviceType(dev)
+		reg := api.GetRegistration(name)
+		if reg == nil {
+			return apierror.ErrBadRequest(fmt.Sprintf("unknown device type: %s", name))
+		}
+		// Relative input is consumed by each poll, so two hosts would each
+		// see only part of it.
+		if dreg, ok := reg.(api.DeltaRegistration); ok {
+			for _, f := range dreg.InputLayout(dev) {
+				if f.Relative {
+					return apierror.ErrBadRequest(fmt.Sprintf("device type %s reports relative input and cannot be aliased", name))
+				}
+			}
+		}
+
+		desc := dev.GetDescriptor()
+		vid, pid := desc.Device.IDVendor, desc.Device.IDProduct
+		opts := device.CreateOptions{
+			IdVendor:       &vid,
+			IdProduct:      &pid,
+			DeviceSpecific: dev.GetDeviceSpecificArgs(),
+		}
+		product, serial := device.Identity(desc)
+		if product != "" {
+			opts.ProductString = &product
+		}
+		if serial != "" {
+			opts.SerialNumber = &serial
+		}
+		alias, err := reg.CreateDevice(&opts)
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to create alias: %v", err))
+		}
+		markAlias(alias.GetDescriptor())
+
+		devIDNum, _ := strconv.ParseUint(devID, 10, 32)
+		s.AddAlias(alias, usbs.AliasSource{Dev: dev, BusID: busID, DevID: uint32(devIDNum)})
+		aliasCtx, err := target.Add(alias)
+		if errors.Is(err, virtualbus.ErrBusFull) {
+			s.RemoveAlias(alias)
+			return apierror.ErrConflict(err.Error())
+		}
+		if err != nil {
+			s.RemoveAlias(alias)
+			return apierror.ErrInternal(fmt.Sprintf("failed to add alias to bus: %v", err))
+		}
+		exportMeta := device.GetDeviceMeta(aliasCtx)
+		if exportMeta == nil {
+			s.RemoveAlias(alias)
+			return apierror.ErrInternal("failed to get device metadata from context")
+		}
+
+		aliasBusID, aliasDevID := target.BusID(), virtualbus.DeviceID(exportMeta)
+		go func() {
+			select {
+			case <-srcCtx.Done():
+				if err := s.RemoveDeviceByID(aliasBusID, aliasDevID); err != nil {
+					logger.Debug("alias already removed", "busID", aliasBusID, "deviceID", aliasDevID, "error", err)
+				}
+			case <-aliasCtx.Done():
+			}
+			s.RemoveAlias(alias)
+		}()
+
+		aliasProduct, _ := device.Identity(alias.GetDescriptor())
+		payload, err := json.Marshal(apitypes.Device{
+			BusID:          aliasBusID,
+			DevId:          aliasDevID,
+			Vid:            fmt.Sprintf("0x%04x", vid),
+			Pid:            fmt.Sprintf("0x%04x", pid),
+			ProductString:  aliasProduct,
+			SerialNumber:   serial,
+			Port:           virtualbus.PortPath(aliasBusID, target.Port(alias)),
+			Type:           name,
+			DeviceSpecific: alias.GetDeviceSpecificArgs(),
+			AliasOf:        fmt.Sprintf("%d-%s", busID, devID),
+		})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
+
+// markAlias appends aliasMarker to the product string of desc. The string
+// table is cloned since devices may share it.
+func markAlias(desc *usb.Descriptor) {
+	idx := desc.Device.IProduct
+	if _, ok := desc.Strings[idx]; idx == 0 || !ok {
+		return
+	}
+	desc.Strings = maps.Clone(desc.Strings)
+	desc.Strings[idx] += aliasMarker
+}
+
+// aliasOf renders the source of dev for apitypes.Device.AliasOf.
+func aliasOf(s *usbs.Server, dev usb.Device) string {
+	src, ok := s.AliasSourceOf(dev)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", src.BusID, src.DevID)
+}
@@ -0,0 +1,177 @@
+package handler_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	_ "github.com/Alia5/VIIPER/internal/registry"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func TestDeviceAlias(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/remove", handler.BusDeviceRemove(s.UsbServer))
+	r.Register("bus/{id}/{deviceid}/alias", handler.DeviceAlias(s.UsbServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	for _, id := range []uint32{90111, 90112} {
+		b, err := virtualbus.NewWithBusId(id)
+		require.NoError(t, err)
+		require.NoError(t, s.UsbServer.AddBus(b))
+		defer func() { _ = s.UsbServer.RemoveBus(id) }()
+	}
+
+	ctx := context.Background()
+	client := apiclient.New(s.ApiServer.Addr())
+	stream, orig, err := client.AddDeviceAndConnect(ctx, 90111, "xbox360", nil)
+	require.NoError(t, err)
+	defer stream.Close()
+
+	alias, err := client.DeviceAlias(90111, orig.DevId, 90112)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(90112), alias.BusID)
+	assert.Equal(t, "90111-"+orig.DevId, alias.AliasOf)
+	assert.Equal(t, orig.Vid, alias.Vid)
+	assert.Equal(t, orig.Pid, alias.Pid)
+
+	aliasDev := s.UsbServer.GetBus(90112).GetAllDeviceMetas()[0].Dev
+	desc := aliasDev.GetDescriptor()
+	assert.True(t, strings.HasSuffix(desc.Strings[desc.Device.IProduct], " (alias)"))
+
+	list, err := client.DevicesList(90112)
+	require.NoError(t, err)
+	require.Len(t, list.Devices, 1)
+	assert.Equal(t, alias.AliasOf, list.Devices[0].AliasOf)
+
+	_, err = client.DeviceAlias(90112, alias.DevId, 90111)
+	assert.ErrorContains(t, err, "aliases cannot be aliased")
+	_, err = client.DeviceAlias(90111, orig.DevId, 90199)
+	assert.ErrorContains(t, err, "bus 90199 not found")
+	mouse, err := client.DeviceAdd(90111, "mouse", nil)
+	require.NoError(t, err)
+	_, err = client.DeviceAlias(90111, mouse.DevId, 90112)
+	assert.ErrorContains(t, err, "cannot be aliased")
+
+	aliasStream, err := client.OpenStream(ctx, 90112, alias.DevId)
+	require.NoError(t, err)
+	defer aliasStream.Close()
+
+	usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	hostA, err := usbipClient.AttachDevice("90111-" + orig.DevId)
+	require.NoError(t, err)
+	defer hostA.Conn.Close()
+	hostB, err := usbipClient.AttachDevice("90112-" + alias.DevId)
+	require.NoError(t, err)
+	defer hostB.Conn.Close()
+
+	// Input written on the alias stream is ignored.
+	require.NoError(t, aliasStream.WriteBinary(&xbox360.InputState{Buttons: xbox360.ButtonB}))
+	require.NoError(t, stream.WriteBinary(&xbox360.InputState{Buttons: xbox360.ButtonA, LT: 0x40}))
+	want := (&xbox360.InputState{Buttons: xbox360.ButtonA, LT: 0x40}).BuildReport()
+	for name, host := range map[string]*viiperTesting.ImportResult{"original": hostA, "alias": hostB} {
+		got, err := usbipClient.PollInputReport(host.Conn, want, time.Second)
+		require.NoError(t, err, name)
+		assert.Equal(t, want, got, name)
+	}
+
+	// Feedback from each host reaches the stream of the device it attached.
+	readRumble := func(st *apiclient.DeviceStream) [2]byte {
+		var buf [2]byte
+		_ = st.SetReadDeadline(time.Now().Add(750 * time.Millisecond))
+		_, err := io.ReadFull(st, buf[:])
+		require.NoError(t, err)
+		return buf
+	}
+	require.NoError(t, usbipClient.Submit(hostA.Conn, usbip.DirOut, 1, []byte{0x00, 0x08, 0x00, 0x10, 0x20, 0x00, 0x00, 0x00}, nil))
+	assert.Equal(t, [2]byte{0x10, 0x20}, readRumble(stream))
+	require.NoError(t, usbipClient.Submit(hostB.Conn, usbip.DirOut, 1, []byte{0x00, 0x08, 0x00, 0x30, 0x40, 0x00, 0x00, 0x00}, nil))
+	assert.Equal(t, [2]byte{0x30, 0x40}, readRumble(aliasStream))
+
+	// Removing the original removes its aliases.
+	_, err = client.DeviceRemove(90111, orig.DevId)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return len(s.UsbServer.GetBus(90112).GetAllDeviceMetas()) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		_, ok := s.UsbServer.AliasSourceOf(aliasDev)
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestDeviceAliasConcurrent aliases, labels and lists a device from several
+// clients at once; run it with -race.
+func TestDeviceAliasConcurrent(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/remove", handler.BusDeviceRemove(s.UsbServer))
+	r.Register("bus/{id}/{deviceid}/alias", handler.DeviceAlias(s.UsbServer))
+	r.Register("bus/{id}/{deviceid}/label", handler.DeviceSetLabel(s.UsbServer, s.ApiServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	for _, id := range []uint32{90186, 90187} {
+		b, err := virtualbus.NewWithBusId(id)
+		require.NoError(t, err)
+		require.NoError(t, s.UsbServer.AddBus(b))
+		defer func() { _ = s.UsbServer.RemoveBus(id) }()
+	}
+
+	client := apiclient.New(s.ApiServer.Addr())
+	orig, err := client.DeviceAdd(90186, "xbox360", nil)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for range 10 {
+			alias, err := client.DeviceAlias(90186, orig.DevId, 90187)
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, err = client.SetDeviceLabel(90187, alias.DevId, "alias")
+			assert.NoError(t, err)
+			_, err = client.DeviceRemove(90187, alias.DevId)
+			assert.NoError(t, err)
+		}
+	})
+	wg.Go(func() {
+		for i := range 10 {
+			_, err := client.SetDeviceLabel(90186, orig.DevId, fmt.Sprintf("pad %d", i))
+			assert.NoError(t, err)
+		}
+	})
+	wg.Go(func() {
+		for range 10 {
+			for _, id := range []uint32{90186, 90187} {
+				_, err := client.DevicesList(id)
+				assert.NoError(t, err)
+			}
+		}
+	})
+	wg.Wait()
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	usbs "github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// DeviceDegrade returns a handler that configures the simulated link
+// degradation of a device. Without a payload it reports the current settings;
+// an empty object turns degradation off. States already delayed are still
+// applied after a change.
+func DeviceDegrade(s *usbs.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		busID, devID, dev, err := deviceFromParams(s, req.Params)
+		if err != nil {
+			return err
+		}
+		dd, ok := dev.(device.Degradable)
+		if !ok {
+			return apierror.ErrBadRequest(fmt.Sprintf("device type %s does not support degradation", inferDeviceType(dev)))
+		}
+		d := dd.Degrader()
+
+		if strings.TrimSpace(req.Payload) != "" {
+			var cfg apitypes.DegradeConfig
+			if err := json.Unmarshal([]byte(req.Payload), &cfg); err != nil {
+				return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+			}
+			if sd, ok := dev.(device.Steppable); ok && sd.Stepper().Active() && (cfg.DelayMs != 0 || cfg.JitterMs != 0 || cfg.DropRate > 0) {
+				return apierror.ErrConflict("device is in deterministic mode")
+			}
+			if err := d.Configure(fromDegradeConfig(cfg)); err != nil {
+				return apierror.ErrBadRequest(err.Error())
+			}
+			logger.Info("device degradation configured", "busID", busID, "deviceID", devID,
+				"delayMs", cfg.DelayMs, "jitterMs", cfg.JitterMs, "dropRate", cfg.DropRate, "dropBurst", cfg.DropBurst)
+		}
+
+		var cur apitypes.DegradeConfig
+		if c := degradeOf(dev); c != nil {
+			cur = *c
+		}
+		stats := d.Stats()
+		payload, err := json.Marshal(apitypes.DeviceDegradeResponse{
+			BusID:   busID,
+			DevId:   devID,
+			Degrade: cur,
+			Delayed: stats.Delayed,
+			Dropped: stats.Dropped,
+		})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
+
+// degradeOf returns the enabled degradation of dev, or nil.
+func degradeOf(dev usb.Device) *apitypes.DegradeConfig {
+	dd, ok := dev.(device.Degradable)
+	if !ok {
+		return nil
+	}
+	cfg, ok := dd.Degrader().Config()
+	if !ok {
+		return nil
+	}
+	return &apitypes.DegradeConfig{
+		DelayMs:   uint32(cfg.Delay.Milliseconds()),
+		JitterMs:  uint32(cfg.Jitter.Milliseconds()),
+		DropRate:  cfg.DropRate,
+		DropBurst: uint32(cfg.DropBurst),
+		Seed:      cfg.Seed,
+	}
+}
+
+func fromDegradeConfig(c apitypes.DegradeConfig) device.DegradeConfig {
+	return device.DegradeConfig{
+		Delay:     time.Duration(c.DelayMs) * time.Millisecond,
+		Jitter:    time.Duration(c.JitterMs) * time.Millisecond,
+		DropRate:  c.DropRate,
+		DropBurst: int(min(c.DropBurst, device.MaxDropBurst+1)),
+		Seed:      c.Seed,
+	}
+}
@@ -0,0 +1,85 @@
+package handler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	_ "github.com/Alia5/VIIPER/internal/registry"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func TestDeviceDegrade(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/{deviceid}/degrade", handler.DeviceDegrade(s.UsbServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90116)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	defer func() { _ = s.UsbServer.RemoveBus(90116) }()
+
+	client := apiclient.New(s.ApiServer.Addr())
+	stream, dev, err := client.AddDeviceAndConnect(context.Background(), 90116, "xbox360", nil)
+	require.NoError(t, err)
+	defer stream.Close()
+	xdev := b.GetAllDeviceMetas()[0].Dev.(*xbox360.Xbox360)
+	report := func() []byte {
+		r := xdev.HandleTransfer(1, usbip.DirIn, nil)
+		return r
+	}
+
+	resp, err := client.DeviceDegrade(90116, dev.DevId, nil)
+	require.NoError(t, err)
+	assert.Equal(t, apitypes.DegradeConfig{}, resp.Degrade, "off by default")
+
+	cfg := &apitypes.DegradeConfig{DelayMs: 30, JitterMs: 50, DropRate: 1, DropBurst: 2, Seed: 7}
+	resp, err = client.DeviceDegrade(90116, dev.DevId, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, *cfg, resp.Degrade)
+	list, err := client.DevicesList(90116)
+	require.NoError(t, err)
+	assert.Equal(t, cfg, list.Devices[0].Degrade)
+
+	// Every state is dropped.
+	require.NoError(t, stream.WriteBinary(&xbox360.InputState{Buttons: xbox360.ButtonA}))
+	assert.Eventually(t, func() bool {
+		resp, err := client.DeviceDegrade(90116, dev.DevId, nil)
+		return err == nil && resp.Dropped == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, (&xbox360.InputState{}).BuildReport(), report())
+
+	resp, err = client.DeviceDegrade(90116, dev.DevId, &apitypes.DegradeConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, apitypes.DegradeConfig{}, resp.Degrade)
+	assert.Equal(t, uint64(1), resp.Dropped, "stats survive reconfiguration")
+	want := (&xbox360.InputState{Buttons: xbox360.ButtonB}).BuildReport()
+	require.NoError(t, stream.WriteBinary(&xbox360.InputState{Buttons: xbox360.ButtonB}))
+	assert.Eventually(t, func() bool { return string(report()) == string(want) }, time.Second, 10*time.Millisecond)
+
+	_, err = client.DeviceDegrade(90116, dev.DevId, &apitypes.DegradeConfig{DropRate: 2})
+	assert.ErrorContains(t, err, "drop rate must be between 0 and 1")
+	_, err = client.DeviceDegrade(90116, dev.DevId, &apitypes.DegradeConfig{DelayMs: 20000})
+	assert.ErrorContains(t, err, "exceeds 10s")
+	mouse, err := client.DeviceAdd(90116, "mouse", nil)
+	require.NoError(t, err)
+	_, err = client.DeviceDegrade(90116, mouse.DevId, nil)
+	assert.ErrorContains(t, err, "does not support degradation")
+}
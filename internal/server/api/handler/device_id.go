@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// reservedDeviceIDs are the routes below bus/{id}/, which would shadow the
+// routes of a device named alike.
+var reservedDeviceIDs = map[string]bool{
+	"list":      true,
+	"add":       true,
+	"add-batch": true,
+	"remove":    true,
+	"defaults":  true,
+	"label":     true,
+}
+
+// parseDeviceID splits a caller-supplied device ID into a device number or,
+// if it is not one, a device name.
+func parseDeviceID(id string) (name string, num uint32, err error) {
+	if n, err := strconv.ParseUint(id, 10, 32); err == nil {
+		if n == 0 || strconv.FormatUint(n, 10) != id {
+			return "", 0, apierror.ErrBadRequest(fmt.Sprintf("invalid device id %q", id))
+		}
+		return "", uint32(n), nil
+	}
+	if err := virtualbus.ValidateDeviceName(id); err != nil {
+		return "", 0, apierror.ErrBadRequest(err.Error())
+	}
+	if reservedDeviceIDs[id] {
+		return "", 0, apierror.ErrBadRequest(fmt.Sprintf("device id %q is reserved", id))
+	}
+	return id, 0, nil
+}
+
+// existingDevice returns the device an upsert request refers to if it is
+// already on b, or nil. A device of another type under the ID is a conflict.
+func existingDevice(s *usb.Server, apiSrv *api.Server, b *virtualbus.VirtualBus, deviceCreateReq apitypes.DeviceCreateRequest) (*apitypes.Device, error) {
+	if !deviceCreateReq.Upsert {
+		return nil, nil
+	}
+	if deviceCreateReq.DevId == nil {
+		return nil, apierror.ErrBadRequest("upsert requires a devId")
+	}
+	id := *deviceCreateReq.DevId
+	if _, _, err := parseDeviceID(id); err != nil {
+		return nil, err
+	}
+	typ := requestedType(apiSrv, deviceCreateReq)
+	for _, m := range b.GetAllDeviceMetas() {
+		if virtualbus.DeviceID(&m.Meta) != id {
+			continue
+		}
+		info := deviceInfo(s, m)
+		if !strings.EqualFold(info.Type, typ) {
+			return nil, apierror.ErrConflict(fmt.Sprintf("device %s on bus %d is of type %s", id, b.BusID(), info.Type))
+		}
+		return &info, nil
+	}
+	return nil, nil
+}
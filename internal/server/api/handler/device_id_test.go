@@ -0,0 +1,133 @@
+package handler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	_ "github.com/Alia5/VIIPER/internal/registry"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func TestDeviceAddWithID(t *testing.T) {
+	cfg := viiperTesting.TestServerConfig(t)
+	cfg.Server.ApiServerConfig.DeviceHandlerConnectTimeout = time.Minute
+	s := viiperTesting.NewTestServerWithConfig(t, cfg)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/add-batch", handler.BusDeviceAddBatch(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/remove", handler.BusDeviceRemove(s.UsbServer))
+	r.Register("bus/{id}/{deviceid}/label", handler.DeviceSetLabel(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90173)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	defer func() { _ = s.UsbServer.RemoveBus(b.BusID()) }()
+
+	client := apiclient.New(s.ApiServer.Addr())
+	add := func(typ, id string, upsert bool) (*apitypes.Device, error) {
+		return client.DeviceAddWith(90173, apitypes.DeviceCreateRequest{Type: &typ, DevId: &id, Upsert: upsert})
+	}
+
+	pad, err := add("xbox360", "left-pad", false)
+	require.NoError(t, err)
+	assert.Equal(t, "left-pad", pad.DevId)
+	busids := map[string]bool{}
+	for _, m := range b.GetAllDeviceMetas() {
+		busids[m.Meta.BusIDString()] = true
+	}
+	assert.Equal(t, map[string]bool{"90173-left-pad": true}, busids, "the USB-IP busid carries the name")
+
+	num, err := add("mouse", "7", false)
+	require.NoError(t, err)
+	assert.Equal(t, "7", num.DevId)
+	auto, err := client.DeviceAdd(90173, "keyboard", nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, "7", auto.DevId)
+
+	t.Run("collision", func(t *testing.T) {
+		_, err := add("xbox360", "left-pad", false)
+		assert.ErrorIs(t, err, apiclient.ErrConflict)
+		_, err = add("keyboard", "7", false)
+		assert.ErrorIs(t, err, apiclient.ErrConflict)
+	})
+
+	t.Run("upsert with matching type", func(t *testing.T) {
+		again, err := add("xbox360", "left-pad", true)
+		require.NoError(t, err)
+		assert.Equal(t, pad, again)
+		list, err := client.DevicesList(90173)
+		require.NoError(t, err)
+		assert.Len(t, list.Devices, 3)
+	})
+
+	t.Run("upsert with conflicting type", func(t *testing.T) {
+		_, err := add("dualshock4", "left-pad", true)
+		assert.EqualError(t, err, "409 Conflict: device left-pad on bus 90173 is of type xbox360")
+	})
+
+	t.Run("upsert of a new device adds it", func(t *testing.T) {
+		dev, err := add("mouse", "right-mouse", true)
+		require.NoError(t, err)
+		assert.Equal(t, "right-mouse", dev.DevId)
+		_, err = client.DeviceRemove(90173, "right-mouse")
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid ids", func(t *testing.T) {
+		for id, detail := range map[string]string{
+			"Left":                  `device id "Left" may only contain a-z, 0-9 and -`,
+			"-pad":                  `device id "-pad" starts with a dash`,
+			"a-very-long-device-id": "device id exceeds 20 bytes",
+			"07":                    `invalid device id "07"`,
+			"list":                  `device id "list" is reserved`,
+		} {
+			_, err := add("mouse", id, false)
+			assert.EqualError(t, err, "400 Bad Request: "+detail, id)
+		}
+		typ := "mouse"
+		_, err := client.DeviceAddWith(90173, apitypes.DeviceCreateRequest{Type: &typ, Upsert: true})
+		assert.EqualError(t, err, "400 Bad Request: upsert requires a devId")
+	})
+
+	t.Run("batch upsert keeps existing devices", func(t *testing.T) {
+		xbox, mouse, bad := "xbox360", "mouse", "nope"
+		id1, id2 := "left-pad", "extra"
+		_, err := client.DeviceAddBatch(90173, []apitypes.DeviceCreateRequest{
+			{Type: &xbox, DevId: &id1, Upsert: true},
+			{Type: &mouse, DevId: &id2},
+			{Type: &bad},
+		})
+		assert.EqualError(t, err, "400 Bad Request: device 2: unknown device type: nope")
+		list, err := client.DevicesList(90173)
+		require.NoError(t, err)
+		assert.Len(t, list.Devices, 3, "left-pad stays, extra is rolled back")
+	})
+
+	t.Run("named devices are addressable", func(t *testing.T) {
+		labeled, err := client.SetDeviceLabel(90173, "left-pad", "p1")
+		require.NoError(t, err)
+		assert.Equal(t, "left-pad", labeled.DevId)
+		stream, err := client.OpenStream(context.Background(), 90173, "left-pad")
+		require.NoError(t, err)
+		require.NoError(t, stream.Close())
+		_, err = client.DeviceRemove(90173, "left-pad")
+		require.NoError(t, err)
+		_, err = add("xbox360", "left-pad", false)
+		require.NoError(t, err, "the name is free again")
+	})
+}
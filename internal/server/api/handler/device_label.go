@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+)
+
+// DeviceSetLabel returns a handler that replaces the label of a device and
+// responds with the device as listed by bus/{id}/list.
+func DeviceSetLabel(s *usb.Server, apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		busID, devID, dev, err := deviceFromParams(s, req.Params)
+		if err != nil {
+			return err
+		}
+		if req.Payload == "" {
+			return apierror.ErrBadRequest("missing payload")
+		}
+		var labelReq apitypes.DeviceLabelRequest
+		if err := json.Unmarshal([]byte(req.Payload), &labelReq); err != nil {
+			return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+		}
+		b := s.GetBus(busID)
+		if b == nil {
+			return apierror.ErrNotFound(fmt.Sprintf("bus %d not found", busID))
+		}
+		if err := b.SetDeviceLabel(dev, labelReq.Label); err != nil {
+			return apierror.ErrBadRequest(err.Error())
+		}
+		logger.Info("set device label", "busID", busID, "deviceID", devID, "label", labelReq.Label)
+		apiSrv.StateChanged()
+		var info apitypes.Device
+		for _, m := range b.GetAllDeviceMetas() {
+			if m.Dev == dev {
+				info = deviceInfo(s, m)
+				break
+			}
+		}
+		payload, err := json.Marshal(info)
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
@@ -0,0 +1,79 @@
+package handler_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	_ "github.com/Alia5/VIIPER/internal/registry"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func TestDeviceLabel(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.Register("bus/{id}/{deviceid}/label", handler.DeviceSetLabel(s.UsbServer, s.ApiServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90171)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	defer func() { _ = s.UsbServer.RemoveBus(b.BusID()) }()
+
+	client := apiclient.New(s.ApiServer.Addr())
+	typ, label := "xbox360", "Player 1 – left"
+	dev, err := client.DeviceAddWith(90171, apitypes.DeviceCreateRequest{Type: &typ, Label: &label})
+	require.NoError(t, err)
+	assert.Equal(t, label, dev.Label)
+
+	labelOf := func() string {
+		list, err := client.DevicesList(90171)
+		require.NoError(t, err)
+		require.Len(t, list.Devices, 1)
+		return list.Devices[0].Label
+	}
+	specLabel := func() *string {
+		st := s.ApiServer.Snapshot()
+		require.Len(t, st.Buses, 1)
+		require.Len(t, st.Buses[0].Devices, 1)
+		return st.Buses[0].Devices[0].Create.Label
+	}
+	assert.Equal(t, label, labelOf())
+	require.NotNil(t, specLabel())
+	assert.Equal(t, label, *specLabel())
+
+	updated, err := client.SetDeviceLabel(90171, dev.DevId, "steering")
+	require.NoError(t, err)
+	assert.Equal(t, "steering", updated.Label)
+	assert.Equal(t, dev.DevId, updated.DevId)
+	assert.Equal(t, "steering", labelOf())
+	require.NotNil(t, specLabel())
+	assert.Equal(t, "steering", *specLabel())
+
+	_, err = client.SetDeviceLabel(90171, dev.DevId, "")
+	require.NoError(t, err)
+	assert.Empty(t, labelOf())
+	assert.Nil(t, specLabel())
+
+	_, err = client.SetDeviceLabel(90171, dev.DevId, strings.Repeat("x", 65))
+	assert.EqualError(t, err, "400 Bad Request: label exceeds 64 bytes")
+	_, err = client.SetDeviceLabel(90171, dev.DevId, "tab\there")
+	assert.EqualError(t, err, "400 Bad Request: label contains control characters")
+	_, err = client.SetDeviceLabel(90171, "9", "x")
+	assert.EqualError(t, err, "404 Not Found: device 9 not found on bus 90171")
+
+	bad := "bell\a"
+	_, err = client.DeviceAddWith(90171, apitypes.DeviceCreateRequest{Type: &typ, Label: &bad})
+	assert.EqualError(t, err, "400 Bad Request: label contains control characters")
+}
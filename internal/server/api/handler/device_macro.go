@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+)
+
+const (
+	macroMaxSteps    = 4096
+	macroMaxPlayed   = 1 << 20 // steps times repeat
+	macroMaxDuration = 10 * time.Minute
+)
+
+// DeviceMacroPlay returns a handler that plays a macro of input states
+// against a device on the server.
+func DeviceMacroPlay(s *usb.Server, apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		var mr apitypes.MacroRequest
+		if err := json.Unmarshal([]byte(req.Payload), &mr); err != nil {
+			return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+		}
+		if len(mr.Steps) == 0 {
+			return apierror.ErrBadRequest("macro has no steps")
+		}
+		if len(mr.Steps) > macroMaxSteps {
+			return apierror.ErrBadRequest(fmt.Sprintf("macro has more than %d steps", macroMaxSteps))
+		}
+		repeat := max(mr.Repeat, 1)
+		if uint64(repeat)*uint64(len(mr.Steps)) > macroMaxPlayed {
+			return apierror.ErrBadRequest(fmt.Sprintf("macro plays more than %d steps", macroMaxPlayed))
+		}
+		var once time.Duration
+		for _, st := range mr.Steps {
+			once += time.Duration(st.HoldMs) * time.Millisecond
+		}
+		if once > 0 && time.Duration(repeat) > macroMaxDuration/once {
+			return apierror.ErrBadRequest(fmt.Sprintf("macro plays longer than %d ms", macroMaxDuration.Milliseconds()))
+		}
+
+		busID, deviceID, dev, err := deviceFromParams(s, req.Params)
+		if err != nil {
+			return err
+		}
+		devCtx := s.GetBus(busID).GetDeviceContext(dev)
+		if devCtx == nil {
+			return apierror.ErrNotFound(fmt.Sprintf("device %s not found on bus %d", deviceID, busID))
+		}
+		d, err := apiSrv.PlayMacro(devCtx, dev, mr.Steps, int(repeat))
+		if err != nil {
+			return err
+		}
+		logger.Debug("macro started", "busID", busID, "deviceID", deviceID, "steps", len(mr.Steps), "repeat", repeat)
+
+		payload, err := json.Marshal(apitypes.MacroStatus{
+			BusID:      busID,
+			DevId:      deviceID,
+			Playing:    true,
+			DurationMs: uint32(d.Milliseconds()),
+		})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
+
+// DeviceMacroCancel returns a handler that stops the macro playing against a
+// device. Cancelling when none plays is not an error.
+func DeviceMacroCancel(s *usb.Server, apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		busID, deviceID, dev, err := deviceFromParams(s, req.Params)
+		if err != nil {
+			return err
+		}
+		payload, err := json.Marshal(apitypes.MacroStatus{
+			BusID:     busID,
+			DevId:     deviceID,
+			Cancelled: apiSrv.CancelMacro(dev),
+		})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
@@ -0,0 +1,161 @@
+package handler_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/usbip"
+)
+
+// manualClock holds timers until Fire runs them.
+type manualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []func()
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) AfterFunc(d time.Duration, f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timers = append(c.timers, f)
+}
+
+// Fire advances the clock by d and runs the timers pending before the call.
+func (c *manualClock) Fire(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	timers := c.timers
+	c.timers = nil
+	c.mu.Unlock()
+	for _, f := range timers {
+		f()
+	}
+}
+
+func TestDeviceMacro(t *testing.T) {
+	const busID = 90169
+	s, client := newStepTestServer(t, busID)
+	s.ApiServer.Router().Register("bus/{id}/remove", handler.BusDeviceRemove(s.UsbServer))
+	s.ApiServer.Router().Register("bus/{id}/{deviceid}/macro", handler.DeviceMacroPlay(s.UsbServer, s.ApiServer))
+	s.ApiServer.Router().Register("bus/{id}/{deviceid}/macro/cancel", handler.DeviceMacroCancel(s.UsbServer, s.ApiServer))
+	clock := &manualClock{now: time.Unix(0, 0)}
+	s.ApiServer.SetClock(clock)
+	ctx := context.Background()
+
+	macro := &apitypes.MacroRequest{
+		Steps: []apitypes.MacroStep{
+			{State: map[string]any{"buttons": 1, "lx": -1000}, HoldMs: 50},
+			{State: map[string]any{"buttons": 2, "rt": 255}, HoldMs: 30},
+		},
+		Repeat: 2,
+	}
+	first := (&xbox360.InputState{Buttons: 1, LX: -1000}).BuildReport()
+	second := (&xbox360.InputState{Buttons: 2, RT: 255}).BuildReport()
+
+	connect := func() (*xbox360.Xbox360, string, func() error) {
+		stream, dev, err := client.AddDeviceAndConnect(ctx, busID, "xbox360", nil)
+		require.NoError(t, err)
+		for _, m := range s.UsbServer.GetBus(busID).GetAllDeviceMetas() {
+			if fmt.Sprint(m.Meta.DevId) == dev.DevId {
+				return m.Dev.(*xbox360.Xbox360), dev.DevId, stream.Close
+			}
+		}
+		t.Fatal("device not on bus")
+		return nil, "", nil
+	}
+	report := func(x *xbox360.Xbox360) []byte {
+		r := x.HandleTransfer(1, usbip.DirIn, nil)
+		return r
+	}
+
+	t.Run("plays", func(t *testing.T) {
+		xdev, devID, closeStream := connect()
+		defer closeStream()
+		status, err := client.PlayMacro(busID, devID, macro)
+		require.NoError(t, err)
+		assert.Equal(t, apitypes.MacroStatus{BusID: busID, DevId: devID, Playing: true, DurationMs: 160}, *status)
+		assert.Equal(t, first, report(xdev))
+
+		_, err = client.PlayMacro(busID, devID, macro)
+		assert.EqualError(t, err, "409 Conflict: macro already playing")
+
+		for _, want := range [][]byte{second, first, second} {
+			clock.Fire(50 * time.Millisecond)
+			assert.Equal(t, want, report(xdev))
+		}
+		clock.Fire(30 * time.Millisecond)
+		status, err = client.CancelMacro(busID, devID)
+		require.NoError(t, err)
+		assert.False(t, status.Cancelled, "the macro ended")
+		assert.Equal(t, second, report(xdev), "the last state stays")
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		xdev, devID, closeStream := connect()
+		defer closeStream()
+		_, err := client.PlayMacro(busID, devID, macro)
+		require.NoError(t, err)
+		status, err := client.CancelMacro(busID, devID)
+		require.NoError(t, err)
+		assert.True(t, status.Cancelled)
+		clock.Fire(50 * time.Millisecond)
+		assert.Equal(t, first, report(xdev))
+	})
+
+	t.Run("device removed", func(t *testing.T) {
+		xdev, devID, closeStream := connect()
+		defer closeStream()
+		_, err := client.PlayMacro(busID, devID, macro)
+		require.NoError(t, err)
+		_, err = client.DeviceRemove(busID, devID)
+		require.NoError(t, err)
+		clock.Fire(50 * time.Millisecond)
+		assert.Equal(t, first, report(xdev))
+	})
+
+	t.Run("stream closed", func(t *testing.T) {
+		xdev, devID, closeStream := connect()
+		_, err := client.PlayMacro(busID, devID, macro)
+		require.NoError(t, err)
+		require.NoError(t, closeStream())
+		require.Eventually(t, func() bool {
+			_, err := client.PlayMacro(busID, devID, &apitypes.MacroRequest{
+				Steps: []apitypes.MacroStep{{State: map[string]any{}}},
+			})
+			return err == nil
+		}, time.Second, 5*time.Millisecond, "the stream's end stops the macro")
+		clock.Fire(50 * time.Millisecond)
+		assert.Equal(t, (&xbox360.InputState{}).BuildReport(), report(xdev))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, devID, closeStream := connect()
+		defer closeStream()
+		_, err := client.PlayMacro(busID, devID, &apitypes.MacroRequest{})
+		assert.EqualError(t, err, "400 Bad Request: macro has no steps")
+		_, err = client.PlayMacro(busID, devID, &apitypes.MacroRequest{
+			Steps: []apitypes.MacroStep{{State: map[string]any{"lx": "left"}}},
+		})
+		assert.ErrorContains(t, err, "400 Bad Request: step 0: invalid state")
+		_, err = client.PlayMacro(busID, devID, &apitypes.MacroRequest{
+			Steps:  []apitypes.MacroStep{{HoldMs: 60000}},
+			Repeat: 11,
+		})
+		assert.EqualError(t, err, "400 Bad Request: macro plays longer than 600000 ms")
+	})
+}
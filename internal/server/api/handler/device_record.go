@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+)
+
+const (
+	recordDefaultDuration = time.Minute
+	recordMaxDuration     = 10 * time.Minute
+	recordDefaultBytes    = 16 << 20
+	recordMaxBytes        = 64 << 20
+	// recordChunkSize keeps a base64-encoded chunk well below a v2 frame.
+	recordChunkSize = 48 << 10
+)
+
+// DeviceRecordStart returns a handler that starts a server-side recording of
+// the input states and feedback streamed for a device.
+func DeviceRecordStart(s *usb.Server, apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		var rs apitypes.RecordStartRequest
+		if strings.TrimSpace(req.Payload) != "" {
+			if err := json.Unmarshal([]byte(req.Payload), &rs); err != nil {
+				return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+			}
+		}
+		duration := recordDefaultDuration
+		if rs.MaxDurationMs > 0 {
+			duration = time.Duration(rs.MaxDurationMs) * time.Millisecond
+		}
+		if duration > recordMaxDuration {
+			return apierror.ErrBadRequest(fmt.Sprintf("maxDurationMs exceeds %d", recordMaxDuration.Milliseconds()))
+		}
+		size := int64(recordDefaultBytes)
+		if rs.MaxBytes > 0 {
+			size = int64(rs.MaxBytes)
+		}
+		if size > recordMaxBytes {
+			return apierror.ErrBadRequest(fmt.Sprintf("maxBytes exceeds %d", recordMaxBytes))
+		}
+
+		busID, deviceID, dev, err := deviceFromParams(s, req.Params)
+		if err != nil {
+			return err
+		}
+		devCtx := s.GetBus(busID).GetDeviceContext(dev)
+		if devCtx == nil {
+			return apierror.ErrNotFound(fmt.Sprintf("device %s not found on bus %d", deviceID, busID))
+		}
+		if err := apiSrv.StartRecording(devCtx, dev, fmt.Sprintf("%d-%s", busID, deviceID), duration, size); err != nil {
+			return err
+		}
+
+		payload, err := json.Marshal(apitypes.RecordingStatus{BusID: busID, DevId: deviceID, Active: true})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
+
+// DeviceRecordStop returns a handler that finalizes the recording of a device.
+// Stopping an already finished recording just reports its status.
+func DeviceRecordStop(s *usb.Server, apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		busID, deviceID, dev, err := deviceFromParams(s, req.Params)
+		if err != nil {
+			return err
+		}
+		var status apitypes.RecordingStatus
+		status, err = apiSrv.StopRecording(dev)
+		if err != nil {
+			return err
+		}
+		status.BusID = busID
+		status.DevId = deviceID
+
+		payload, err := json.Marshal(status)
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
+
+// DeviceRecordDownload returns a handler that reads a finished recording
+// in bounded, base64-encoded chunks.
+func DeviceRecordDownload(s *usb.Server, apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		var dr apitypes.RecordDownloadRequest
+		if strings.TrimSpace(req.Payload) != "" {
+			if err := json.Unmarshal([]byte(req.Payload), &dr); err != nil {
+				return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+			}
+		}
+		if dr.Offset > math.MaxInt64 {
+			return apierror.ErrBadRequest(fmt.Sprintf("offset exceeds %d", int64(math.MaxInt64)))
+		}
+		busID, deviceID, dev, err := deviceFromParams(s, req.Params)
+		if err != nil {
+			return err
+		}
+		data, eof, err := apiSrv.RecordingChunk(dev, int64(dr.Offset), recordChunkSize)
+		if err != nil {
+			return err
+		}
+
+		payload, err := json.Marshal(apitypes.RecordChunk{
+			BusID:  busID,
+			DevId:  deviceID,
+			Offset: dr.Offset,
+			Data:   base64.StdEncoding.EncodeToString(data),
+			EOF:    eof,
+		})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
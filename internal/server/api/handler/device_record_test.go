@@ -0,0 +1,153 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/device/replay"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	_ "github.com/Alia5/VIIPER/internal/registry"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// timedWriter remembers when each write happened.
+type timedWriter struct {
+	mu     sync.Mutex
+	start  time.Time
+	writes []replay.Record
+}
+
+func (w *timedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes = append(w.writes, replay.Record{Offset: time.Since(w.start), Data: bytes.Clone(p)})
+	return len(p), nil
+}
+
+func TestDeviceRecording(t *testing.T) {
+	cfg := viiperTesting.TestServerConfig(t)
+	cfg.Server.ApiServerConfig.RecordingDir = t.TempDir()
+	s := viiperTesting.NewTestServerWithConfig(t, cfg)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/remove", handler.BusDeviceRemove(s.UsbServer))
+	r.Register("bus/{id}/{deviceid}/record/start", handler.DeviceRecordStart(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/{deviceid}/record/stop", handler.DeviceRecordStop(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/{deviceid}/record/download", handler.DeviceRecordDownload(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90104)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+
+	client := apiclient.New(s.ApiServer.Addr())
+
+	_, err = client.RecordStop(90104, "1")
+	assert.EqualError(t, err, "404 Not Found: device 1 not found on bus 90104")
+
+	stream, dev, err := client.AddDeviceAndConnect(context.Background(), 90104, "xbox360", nil)
+	require.NoError(t, err)
+	defer stream.Close()
+
+	_, err = client.RecordDownload(90104, dev.DevId, 0)
+	assert.EqualError(t, err, "404 Not Found: no recording for device")
+	_, err = client.RecordDownload(90104, dev.DevId, math.MaxInt64+1)
+	assert.EqualError(t, err, "400 Bad Request: offset exceeds 9223372036854775807")
+
+	script := []xbox360.InputState{
+		{Buttons: xbox360.ButtonA},
+		{Buttons: xbox360.ButtonA | xbox360.ButtonB, LT: 0x40},
+		{LX: -1200, LY: 3000},
+		{RT: 0xff, RX: 32767},
+		{},
+	}
+	const gap = 40 * time.Millisecond
+	go func() {
+		time.Sleep(gap)
+		for _, st := range script {
+			_ = stream.WriteBinary(&st)
+			time.Sleep(gap)
+		}
+	}()
+
+	data, err := client.RecordFor(context.Background(), 90104, dev.DevId, time.Duration(len(script)+3)*gap)
+	require.NoError(t, err)
+
+	rd, err := replay.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "xbox360", rd.Header().DeviceType)
+	var recorded []replay.Record
+	for {
+		rec, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if rec.Kind == replay.KindInput {
+			recorded = append(recorded, rec)
+		}
+	}
+	require.Len(t, recorded, len(script))
+	for i, st := range script {
+		want, err := st.MarshalBinary()
+		require.NoError(t, err)
+		assert.Equal(t, want, recorded[i].Data, "state %d", i)
+	}
+
+	rd, err = replay.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	out := &timedWriter{start: time.Now()}
+	played, err := (&replay.Player{Paced: true}).Play(context.Background(), rd, out)
+	require.NoError(t, err)
+	require.Equal(t, len(script), played)
+	for i, w := range out.writes {
+		assert.Equal(t, recorded[i].Data, w.Data)
+		assert.InDelta(t, recorded[i].Offset, w.Offset, float64(15*time.Millisecond), "timing of state %d", i)
+	}
+
+	_, err = client.RecordStart(90104, dev.DevId, nil)
+	require.NoError(t, err)
+	_, err = client.RecordStart(90104, dev.DevId, nil)
+	assert.EqualError(t, err, "409 Conflict: recording already active")
+	_, err = client.RecordDownload(90104, dev.DevId, 0)
+	assert.EqualError(t, err, "409 Conflict: recording still active")
+	st, err := client.RecordStop(90104, dev.DevId)
+	require.NoError(t, err)
+	assert.False(t, st.Active)
+
+	// Removing the device finishes its recording.
+	_, err = client.RecordStart(90104, dev.DevId, nil)
+	require.NoError(t, err)
+	_, err = client.DeviceRemove(90104, dev.DevId)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		files, err := filepath.Glob(filepath.Join(cfg.Server.ApiServerConfig.RecordingDir, "*"))
+		if err != nil || len(files) != 3 {
+			return false
+		}
+		for _, f := range files {
+			if fi, err := os.Stat(f); err != nil || fi.Size() == 0 {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+}
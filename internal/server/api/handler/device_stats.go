@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	usbs "github.com/Alia5/VIIPER/internal/server/usb"
+)
+
+// DeviceStats returns a handler that reports the USB traffic of a device,
+// whether the host keeps up with polling it, the latency of its sequenced
+// input, its stream and its priority.
+func DeviceStats(s *usbs.Server, apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		busID, devID, dev, err := deviceFromParams(s, req.Params)
+		if err != nil {
+			return err
+		}
+		st, attached := s.LinkStats(dev)
+		endpoints := make([]apitypes.EndpointPolling, 0, len(st.Endpoints))
+		for _, p := range st.Endpoints {
+			endpoints = append(endpoints, apitypes.EndpointPolling{
+				Endpoint:           p.Address,
+				IntervalNs:         p.Interval.Nanoseconds(),
+				MeasuredIntervalNs: p.Measured.Nanoseconds(),
+				Degraded:           p.Degraded,
+			})
+		}
+		resp := apitypes.DeviceStatsResponse{
+			BusID:               busID,
+			DevId:               devID,
+			Attached:            attached,
+			BytesIn:             st.BytesIn,
+			BytesOut:            st.BytesOut,
+			BytesInPerSec:       st.BytesInPerSec,
+			BytesOutPerSec:      st.BytesOutPerSec,
+			ReportsIn:           st.ReportsIn,
+			ReportsInPerSec:     st.ReportsInPerSec,
+			HostPollingDegraded: st.HostPollingDegraded,
+			Endpoints:           endpoints,
+			Priority:            s.Priority(dev).String(),
+			Deferred:            st.Deferred,
+		}
+		if l, ok := s.InputLatency(dev); ok {
+			resp.InputLatency = &apitypes.InputLatencyStats{
+				Received:         l.Received,
+				SeqGaps:          l.SeqGaps,
+				Delivered:        l.Delivered,
+				P50Ns:            l.P50.Nanoseconds(),
+				P95Ns:            l.P95.Nanoseconds(),
+				P99Ns:            l.P99.Nanoseconds(),
+				LastSeq:          l.LastSeq,
+				LastClientMonoNs: l.LastClientMonoNs,
+			}
+		}
+		if st, ok := apiSrv.StreamStats(dev); ok {
+			resp.Stream = &apitypes.StreamStats{
+				BytesIn:  st.BytesIn,
+				BytesOut: st.BytesOut,
+				Feedback: st.Feedback,
+			}
+			if st.Feedback > 0 {
+				resp.Stream.LastFeedback = base64.StdEncoding.EncodeToString(st.LastFeedback)
+				resp.Stream.LastFeedbackAt = st.LastFeedbackAt.UTC().Format(time.RFC3339Nano)
+			}
+		}
+		payload, err := json.Marshal(resp)
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
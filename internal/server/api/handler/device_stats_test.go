@@ -0,0 +1,179 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	_ "github.com/Alia5/VIIPER/internal/registry"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func TestDeviceStats(t *testing.T) {
+	cfg := viiperTesting.TestServerConfig(t)
+	cfg.Server.UsbServerConfig.SlowHostThreshold = 3
+	s := viiperTesting.NewTestServerWithConfig(t, cfg)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	s.ApiServer.Router().Register("bus/{id}/{deviceid}/stats", handler.DeviceStats(s.UsbServer, s.ApiServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90119)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	defer func() { _ = s.UsbServer.RemoveBus(90119) }()
+	dev, err := xbox360.New(nil)
+	require.NoError(t, err)
+	_, err = b.Add(dev)
+	require.NoError(t, err)
+
+	client := apiclient.New(s.ApiServer.Addr())
+	resp, err := client.DeviceStats(90119, "1")
+	require.NoError(t, err)
+	assert.Equal(t, &apitypes.DeviceStatsResponse{BusID: 90119, DevId: "1", Endpoints: []apitypes.EndpointPolling{}, Priority: "normal"}, resp)
+
+	usbip := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := usbip.AttachDevice("90119-1")
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+	report, err := usbip.ReadInputReport(imp.Conn)
+	require.NoError(t, err)
+
+	resp, err = client.DeviceStats(90119, "1")
+	require.NoError(t, err)
+	assert.True(t, resp.Attached)
+	assert.Equal(t, uint64(len(report)), resp.BytesIn)
+	assert.Equal(t, uint64(1), resp.ReportsIn)
+	assert.Nil(t, resp.Stream, "no stream open")
+	assert.False(t, resp.HostPollingDegraded)
+	require.NotEmpty(t, resp.Endpoints)
+	assert.Equal(t, apitypes.EndpointPolling{Endpoint: 0x81, IntervalNs: 4_000_000}, resp.Endpoints[0])
+
+	_, err = client.DeviceStats(90119, "2")
+	var apiErr *apitypes.ApiError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, 404, apiErr.Status)
+}
+
+func TestDeviceStatsInputLatency(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("features", handler.Features())
+	r.Register("bus/{id}/{deviceid}/stats", handler.DeviceStats(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90152)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	defer func() { _ = s.UsbServer.RemoveBus(90152) }()
+	dev, err := xbox360.New(nil)
+	require.NoError(t, err)
+	_, err = b.Add(dev)
+	require.NoError(t, err)
+
+	client := apiclient.New(s.ApiServer.Addr())
+	resp, err := client.DeviceStats(90152, "1")
+	require.NoError(t, err)
+	assert.Nil(t, resp.InputLatency, "no sequenced stream yet")
+
+	stream, err := client.OpenSequencedStream(context.Background(), 90152, "1")
+	require.NoError(t, err)
+	defer stream.Close()
+	usbip := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := usbip.AttachDevice("90152-1")
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+
+	latency := func() *apitypes.InputLatencyStats {
+		resp, err := client.DeviceStats(90152, "1")
+		require.NoError(t, err)
+		require.NotNil(t, resp.InputLatency)
+		return resp.InputLatency
+	}
+	// send writes a state and reads the report carrying it.
+	send := func(write func() error) {
+		received := latency().Received
+		require.NoError(t, write())
+		require.Eventually(t, func() bool { return latency().Received > received }, time.Second, 5*time.Millisecond)
+		_, err := usbip.ReadInputReport(imp.Conn)
+		require.NoError(t, err)
+	}
+
+	send(func() error { return stream.WriteBinary(&xbox360.InputState{Buttons: xbox360.ButtonA}) })
+	send(func() error { return stream.WriteBinary(&xbox360.InputState{}) })
+	assert.Equal(t, uint32(2), stream.Seq())
+	st := latency()
+	assert.Equal(t, uint64(2), st.Received)
+	assert.Equal(t, uint64(2), st.Delivered)
+	assert.Zero(t, st.SeqGaps)
+	assert.Equal(t, uint32(2), st.LastSeq)
+	assert.Positive(t, st.LastClientMonoNs)
+	assert.Positive(t, st.P50Ns)
+	assert.LessOrEqual(t, st.P50Ns, st.P95Ns)
+	assert.LessOrEqual(t, st.P95Ns, st.P99Ns)
+
+	// A client skipping sequence numbers 3 to 5.
+	state, err := (&xbox360.InputState{Buttons: xbox360.ButtonB}).MarshalBinary()
+	require.NoError(t, err)
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint32(header[0:4], 6)
+	binary.LittleEndian.PutUint64(header[4:12], 42)
+	send(func() error { _, err := stream.Write(append(header, state...)); return err })
+	st = latency()
+	assert.Equal(t, uint64(3), st.Received)
+	assert.Equal(t, uint64(3), st.Delivered)
+	assert.Equal(t, uint64(3), st.SeqGaps)
+	assert.Equal(t, uint32(6), st.LastSeq)
+	assert.Equal(t, int64(42), st.LastClientMonoNs)
+}
+
+func TestDeviceStatsPriority(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("features", handler.Features())
+	r.Register("bus/{id}/{deviceid}/stats", handler.DeviceStats(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90194)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	defer func() { _ = s.UsbServer.RemoveBus(90194) }()
+	dev, err := xbox360.New(nil)
+	require.NoError(t, err)
+	_, err = b.Add(dev)
+	require.NoError(t, err)
+
+	client := apiclient.New(s.ApiServer.Addr())
+	priority := func() string {
+		resp, err := client.DeviceStats(90194, "1")
+		require.NoError(t, err)
+		return resp.Priority
+	}
+	_, err = client.OpenPriorityStream(context.Background(), 90194, "1", "urgent")
+	assert.Error(t, err)
+
+	stream, err := client.OpenPriorityStream(context.Background(), 90194, "1", apiclient.StreamPriorityHigh)
+	require.NoError(t, err)
+	assert.Equal(t, "high", priority())
+	require.NoError(t, stream.Close())
+	require.Eventually(t, func() bool { return priority() == "normal" }, time.Second, 5*time.Millisecond, "reset with the stream")
+}
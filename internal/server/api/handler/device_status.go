@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	usbs "github.com/Alia5/VIIPER/internal/server/usb"
+)
+
+// DeviceStatus returns a handler that reports whether a USB/IP host has the
+// device imported.
+func DeviceStatus(s *usbs.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		busID, devID, dev, err := deviceFromParams(s, req.Params)
+		if err != nil {
+			return err
+		}
+		b := s.GetBus(busID)
+		if b == nil {
+			return apierror.ErrNotFound(fmt.Sprintf("bus %d not found", busID))
+		}
+		var status apitypes.DeviceStatusResponse
+		status = api.DeviceStatus(b, devID, dev)
+
+		payload, err := json.Marshal(status)
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
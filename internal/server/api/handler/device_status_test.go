@@ -0,0 +1,64 @@
+package handler_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func TestDeviceStatus(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	s.ApiServer.Router().Register("bus/{id}/{deviceid}/status", handler.DeviceStatus(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90145)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	defer func() { _ = s.UsbServer.RemoveBus(90145) }()
+	dev, err := xbox360.New(nil)
+	require.NoError(t, err)
+	_, err = b.Add(dev)
+	require.NoError(t, err)
+
+	client := apiclient.New(s.ApiServer.Addr())
+	resp, err := client.DeviceStatus(90145, "1")
+	require.NoError(t, err)
+	assert.Equal(t, &apitypes.DeviceStatusResponse{BusID: 90145, DevId: "1"}, resp)
+
+	before := time.Now()
+	usbip := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := usbip.AttachDevice("90145-1")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		resp, err = client.DeviceStatus(90145, "1")
+		return err == nil && resp.Attached
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, imp.Conn.LocalAddr().String(), resp.RemoteAddr)
+	since, err := time.Parse(time.RFC3339Nano, resp.AttachedSince)
+	require.NoError(t, err)
+	assert.False(t, since.Before(before.Truncate(time.Millisecond)))
+
+	require.NoError(t, imp.Conn.Close())
+	assert.Eventually(t, func() bool {
+		resp, err = client.DeviceStatus(90145, "1")
+		return err == nil && !resp.Attached
+	}, time.Second, 5*time.Millisecond, "detached once the URB stream ends")
+	assert.Empty(t, resp.AttachedSince)
+
+	_, err = client.DeviceStatus(90145, "2")
+	var apiErr *apitypes.ApiError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, 404, apiErr.Status)
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	usbs "github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// DeviceStep returns a handler that applies the next queued input states of a
+// device in deterministic mode, one unless the payload sets a count. It steps
+// at most as many states as are queued.
+func DeviceStep(s *usbs.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		busID, devID, dev, err := deviceFromParams(s, req.Params)
+		if err != nil {
+			return err
+		}
+		sd, ok := dev.(device.Steppable)
+		if !ok {
+			return apierror.ErrBadRequest(fmt.Sprintf("device type %s does not support deterministic mode", inferDeviceType(dev)))
+		}
+		st := sd.Stepper()
+		if !st.Active() {
+			return apierror.ErrConflict("device is not in deterministic mode")
+		}
+
+		count := 1
+		if strings.TrimSpace(req.Payload) != "" {
+			var sr apitypes.DeviceStepRequest
+			if err := json.Unmarshal([]byte(req.Payload), &sr); err != nil {
+				return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+			}
+			if sr.Count > 0 {
+				count = int(min(sr.Count, device.MaxStepQueue))
+			}
+		}
+		stepped := st.Step(count)
+		logger.Debug("device stepped", "busID", busID, "deviceID", devID, "stepped", stepped)
+
+		payload, err := json.Marshal(apitypes.DeviceStepResponse{
+			BusID:   busID,
+			DevId:   devID,
+			Stepped: uint32(stepped),
+			Queued:  uint32(st.Queued()),
+		})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
+
+// deterministicOf returns the enabled deterministic mode of dev, or nil.
+func deterministicOf(dev usb.Device) *apitypes.DeterministicConfig {
+	sd, ok := dev.(device.Steppable)
+	if !ok {
+		return nil
+	}
+	cfg, ok := sd.Stepper().Config()
+	if !ok {
+		return nil
+	}
+	return &apitypes.DeterministicConfig{Enabled: true, Manual: cfg.Manual}
+}
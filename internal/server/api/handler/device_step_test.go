@@ -0,0 +1,161 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/device/replay"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	_ "github.com/Alia5/VIIPER/internal/registry"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func newStepTestServer(t *testing.T, busIDs ...uint32) (*viiperTesting.MockServer, *apiclient.Client) {
+	t.Helper()
+	s := viiperTesting.NewTestServer(t)
+	t.Cleanup(func() {
+		s.ApiServer.Close()
+		s.UsbServer.Close()
+	})
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/{deviceid}/degrade", handler.DeviceDegrade(s.UsbServer))
+	r.Register("bus/{id}/{deviceid}/step", handler.DeviceStep(s.UsbServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	for _, id := range busIDs {
+		b, err := virtualbus.NewWithBusId(id)
+		require.NoError(t, err)
+		require.NoError(t, s.UsbServer.AddBus(b))
+		t.Cleanup(func() { _ = s.UsbServer.RemoveBus(id) })
+	}
+	return s, apiclient.New(s.ApiServer.Addr())
+}
+
+// stepRecording holds n distinct xbox360 states.
+func stepRecording(t *testing.T, n int) ([]byte, [][]byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := replay.NewWriter(&buf, replay.Header{DeviceType: "xbox360", Start: time.Unix(0, 0)})
+	require.NoError(t, err)
+	var reports [][]byte
+	for i := range n {
+		st := xbox360.InputState{Buttons: uint32(i % 0x10000), LX: int16(i * 31), RT: uint8(i)}
+		data, err := st.MarshalBinary()
+		require.NoError(t, err)
+		require.NoError(t, w.Write(replay.Record{Offset: time.Duration(i) * time.Millisecond, Kind: replay.KindInput, Data: data}))
+		reports = append(reports, st.BuildReport())
+	}
+	return buf.Bytes(), reports
+}
+
+func TestDeviceStepDeterministicReplay(t *testing.T) {
+	const states = 1000
+	s, client := newStepTestServer(t, 90127, 90128)
+	recording, want := stepRecording(t, states)
+
+	run := func(busID uint32) [][]byte {
+		ctx := context.Background()
+		stream, dev, err := client.AddDeviceAndConnect(ctx, busID, "xbox360", &device.CreateOptions{
+			Deterministic: &device.DeterministicConfig{Enabled: true},
+		})
+		require.NoError(t, err)
+		defer stream.Close()
+		assert.Equal(t, &apitypes.DeterministicConfig{Enabled: true}, dev.Deterministic)
+
+		usbipClient := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+		host, err := usbipClient.AttachDevice(fmt.Sprintf("%d-%s", busID, dev.DevId))
+		require.NoError(t, err)
+		defer host.Conn.Close()
+
+		// The host polls while the recording is still being streamed.
+		played := make(chan error, 1)
+		go func() {
+			r, err := replay.NewReader(bytes.NewReader(recording))
+			if err == nil {
+				_, err = (&replay.Player{}).Play(ctx, r, stream)
+			}
+			played <- err
+		}()
+		var got [][]byte
+		for range states {
+			report, err := usbipClient.ReadInputReportWithTimeout(host.Conn, 5*time.Second)
+			require.NoError(t, err)
+			got = append(got, report)
+		}
+		require.NoError(t, <-played)
+		return got
+	}
+
+	first := run(90127)
+	second := run(90128)
+	assert.Equal(t, want, first, "every state is reported once, in order")
+	assert.Equal(t, first, second)
+}
+
+func TestDeviceStep(t *testing.T) {
+	s, client := newStepTestServer(t, 90129)
+	ctx := context.Background()
+
+	stream, dev, err := client.AddDeviceAndConnect(ctx, 90129, "xbox360", &device.CreateOptions{
+		Deterministic: &device.DeterministicConfig{Enabled: true, Manual: true},
+	})
+	require.NoError(t, err)
+	defer stream.Close()
+	xdev := s.UsbServer.GetBus(90129).GetAllDeviceMetas()[0].Dev.(*xbox360.Xbox360)
+	report := func() []byte {
+		r := xdev.HandleTransfer(1, usbip.DirIn, nil)
+		return r
+	}
+
+	for i := range 3 {
+		require.NoError(t, stream.WriteBinary(&xbox360.InputState{Buttons: uint32(1) << i}))
+	}
+	require.Eventually(t, func() bool { return xdev.Stepper().Queued() == 3 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, (&xbox360.InputState{}).BuildReport(), report(), "nothing applied before a step")
+
+	resp, err := client.DeviceStep(90129, dev.DevId, nil)
+	require.NoError(t, err)
+	assert.Equal(t, apitypes.DeviceStepResponse{BusID: 90129, DevId: dev.DevId, Stepped: 1, Queued: 2}, *resp)
+	assert.Equal(t, (&xbox360.InputState{Buttons: 1}).BuildReport(), report())
+	assert.Equal(t, (&xbox360.InputState{Buttons: 1}).BuildReport(), report(), "host polls do not step manual devices")
+
+	resp, err = client.DeviceStep(90129, dev.DevId, &apitypes.DeviceStepRequest{Count: 5})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), resp.Stepped, "at most the queued states")
+	assert.Zero(t, resp.Queued)
+	assert.Equal(t, (&xbox360.InputState{Buttons: 4}).BuildReport(), report())
+
+	list, err := client.DevicesList(90129)
+	require.NoError(t, err)
+	assert.Equal(t, &apitypes.DeterministicConfig{Enabled: true, Manual: true}, list.Devices[0].Deterministic)
+
+	_, err = client.DeviceDegrade(90129, dev.DevId, &apitypes.DegradeConfig{DelayMs: 10})
+	assert.ErrorContains(t, err, "deterministic mode")
+
+	plain, err := client.DeviceAdd(90129, "xbox360", nil)
+	require.NoError(t, err)
+	_, err = client.DeviceStep(90129, plain.DevId, nil)
+	assert.ErrorContains(t, err, "not in deterministic mode")
+
+	_, err = client.DeviceAdd(90129, "keyboard", &device.CreateOptions{
+		Humanize:      &device.HumanizeConfig{Enabled: true},
+		Deterministic: &device.DeterministicConfig{Enabled: true},
+	})
+	assert.ErrorContains(t, err, "cannot be combined with humanize")
+}
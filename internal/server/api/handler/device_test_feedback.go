@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+)
+
+const (
+	testFeedbackMaxDuration = 10 * time.Second
+	testFeedbackMaxRate     = 1000
+)
+
+// DeviceTestFeedback returns a handler that makes a device emit a deterministic,
+// synthetic feedback sequence to its connected stream client.
+// Every byte of each message carries the pattern value for that step.
+func DeviceTestFeedback(s *usb.Server, apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		tf := apitypes.TestFeedbackRequest{Pattern: "ramp", DurationMs: 100, RateHz: 100}
+		if strings.TrimSpace(req.Payload) != "" {
+			if err := json.Unmarshal([]byte(req.Payload), &tf); err != nil {
+				return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+			}
+		}
+		if tf.Pattern == "" {
+			tf.Pattern = "ramp"
+		}
+		if tf.Pattern != "ramp" && tf.Pattern != "square" {
+			return apierror.ErrBadRequest(fmt.Sprintf("unknown pattern: %s", tf.Pattern))
+		}
+		if tf.RateHz == 0 || tf.RateHz > testFeedbackMaxRate {
+			return apierror.ErrBadRequest(fmt.Sprintf("rateHz must be within 1-%d", testFeedbackMaxRate))
+		}
+		duration := time.Duration(tf.DurationMs) * time.Millisecond
+		if duration > testFeedbackMaxDuration {
+			return apierror.ErrBadRequest(fmt.Sprintf("durationMs exceeds %d", testFeedbackMaxDuration.Milliseconds()))
+		}
+
+		busID, deviceID, dev, err := deviceFromParams(s, req.Params)
+		if err != nil {
+			return err
+		}
+		dtype := inferDeviceType(dev)
+		reg, ok := api.GetRegistration(dtype).(api.FeedbackRegistration)
+		if !ok {
+			return apierror.ErrBadRequest(fmt.Sprintf("device type %s has no feedback channel", dtype))
+		}
+		msg := make([]byte, reg.OutputLayout(dev).Size())
+
+		steps := max(tf.DurationMs*tf.RateHz/1000, 1)
+		interval := time.Second / time.Duration(tf.RateHz)
+		logger.Info("emitting synthetic feedback", "busID", busID, "deviceID", deviceID, "pattern", tf.Pattern, "messages", steps)
+		for i := range steps {
+			if i > 0 {
+				select {
+				case <-req.Ctx.Done():
+					return apierror.ErrInternal("request cancelled")
+				case <-time.After(interval):
+				}
+			}
+			v := testFeedbackValue(tf.Pattern, i, steps)
+			for j := range msg {
+				msg[j] = v
+			}
+			if err := apiSrv.WriteFeedback(dev, msg); err != nil {
+				return err
+			}
+		}
+
+		payload, err := json.Marshal(apitypes.TestFeedbackResponse{
+			BusID:     busID,
+			DevId:     deviceID,
+			Synthetic: true,
+			Messages:  steps,
+		})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
+
+// testFeedbackValue returns the byte value of step i out of n for pattern.
+// "ramp" rises linearly from 0 to 255, "square" alternates 255 and 0.
+func testFeedbackValue(pattern string, i, n uint32) byte {
+	if pattern == "square" {
+		if i%2 == 0 {
+			return 0xff
+		}
+		return 0
+	}
+	if n <= 1 {
+		return 0xff
+	}
+	return byte(i * 255 / (n - 1))
+}
@@ -0,0 +1,81 @@
+package handler_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	_ "github.com/Alia5/VIIPER/internal/registry"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func TestDeviceTestFeedback(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/{deviceid}/test-feedback", handler.DeviceTestFeedback(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90101)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+
+	client := apiclient.New(s.ApiServer.Addr())
+
+	_, err = client.DeviceTestFeedback(90101, "1", nil)
+	assert.EqualError(t, err, "404 Not Found: device 1 not found on bus 90101")
+
+	dev, err := client.DeviceAdd(90101, "xbox360", nil)
+	require.NoError(t, err)
+
+	_, err = client.DeviceTestFeedback(90101, dev.DevId, nil)
+	assert.EqualError(t, err, "409 Conflict: no stream connected to device")
+
+	stream, err := client.OpenStream(context.Background(), 90101, dev.DevId)
+	require.NoError(t, err)
+	defer stream.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = client.DeviceTestFeedback(90101, dev.DevId, &apitypes.TestFeedbackRequest{Pattern: "sine"})
+	assert.EqualError(t, err, "400 Bad Request: unknown pattern: sine")
+
+	resp, err := client.DeviceTestFeedback(90101, dev.DevId, &apitypes.TestFeedbackRequest{
+		Pattern:    "ramp",
+		DurationMs: 50,
+		RateHz:     100,
+	})
+	require.NoError(t, err)
+	assert.True(t, resp.Synthetic)
+	require.EqualValues(t, 5, resp.Messages)
+
+	want := []xbox360.XRumbleState{
+		{LeftMotor: 0, RightMotor: 0},
+		{LeftMotor: 63, RightMotor: 63},
+		{LeftMotor: 127, RightMotor: 127},
+		{LeftMotor: 191, RightMotor: 191},
+		{LeftMotor: 255, RightMotor: 255},
+	}
+	_ = stream.SetReadDeadline(time.Now().Add(time.Second))
+	for i, w := range want {
+		var buf [2]byte
+		_, err := io.ReadFull(stream, buf[:])
+		require.NoError(t, err, "message %d", i)
+		var got xbox360.XRumbleState
+		require.NoError(t, got.UnmarshalBinary(buf[:]))
+		assert.Equal(t, w, got, "message %d", i)
+	}
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/codegen/common"
+	"github.com/Alia5/VIIPER/internal/server/api"
+)
+
+// Features returns a handler listing the optional protocol features of the
+// server, so clients can gate behavior without probing.
+func Features() api.HandlerFunc {
+	return func(_ *api.Request, res *api.Response, logger *slog.Logger) error {
+		ver, err := common.GetVersion()
+		if err != nil {
+			ver = common.Version
+			logger.Error("features: invalid version format", "error", err, "version", ver)
+		}
+		b, err := json.Marshal(apitypes.FeaturesResponse{Version: ver, Features: apitypes.Features})
+		if err != nil {
+			return err
+		}
+		res.JSON = string(b)
+		return nil
+	}
+}
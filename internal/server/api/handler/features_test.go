@@ -0,0 +1,63 @@
+package handler_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/apiclient"
+	handlerTest "github.com/Alia5/VIIPER/internal/_testing"
+	"github.com/Alia5/VIIPER/internal/codegen/scanner"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+)
+
+func TestFeaturesMatchCodegenMetadata(t *testing.T) {
+	addr, _, done := handlerTest.StartAPIServer(t, func(r *api.Router, s *usb.Server, apiSrv *api.Server) {
+		r.Register("features", handler.Features())
+	})
+	defer done()
+
+	resp, err := apiclient.New(addr).Features()
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.Version)
+
+	scanned, err := scanner.ScanFeatures(filepath.Join("..", "..", "..", "..", "apitypes"))
+	require.NoError(t, err)
+	require.Len(t, resp.Features, len(scanned))
+	names := map[string]bool{}
+	for i, f := range resp.Features {
+		assert.Equal(t, scanned[i], scanner.FeatureInfo{Name: f.Name, Since: f.Since, Negotiation: f.Negotiation})
+		assert.False(t, names[f.Name], "duplicate feature %s", f.Name)
+		names[f.Name] = true
+	}
+}
+
+func TestSupports(t *testing.T) {
+	addr, _, done := handlerTest.StartAPIServer(t, func(r *api.Router, s *usb.Server, apiSrv *api.Server) {
+		r.Register("features", handler.Features())
+	})
+	defer done()
+	ctx := context.Background()
+
+	client := apiclient.New(addr)
+	ok, err := client.Supports(ctx, apiclient.FeatureDelta)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = client.Supports(ctx, "teleport")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Servers without the features route support nothing optional.
+	oldAddr, _, oldDone := handlerTest.StartAPIServer(t, nil)
+	defer oldDone()
+	ok, err = apiclient.New(oldAddr).Supports(ctx, apiclient.FeatureDelta)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, err = apiclient.New(oldAddr).OpenEventStream(ctx, 1, "1")
+	assert.ErrorIs(t, err, apiclient.ErrUnsupported)
+}
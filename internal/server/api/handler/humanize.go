@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// humanizeOf returns the enabled input humanization of dev, or nil.
+func humanizeOf(dev usb.Device) *apitypes.HumanizeConfig {
+	h, ok := dev.(device.Humanizable)
+	if !ok {
+		return nil
+	}
+	cfg, ok := h.Humanizer().Config()
+	if !ok {
+		return nil
+	}
+	return &apitypes.HumanizeConfig{
+		Enabled:           true,
+		KeyIntervalMeanMs: uint32(cfg.KeyIntervalMean.Milliseconds()),
+		KeyIntervalStdDev: uint32(cfg.KeyIntervalStdDev.Milliseconds()),
+		MouseJitterPx:     uint32(cfg.MouseJitter),
+		Seed:              cfg.Seed,
+	}
+}
+
+func fromHumanizeConfig(c apitypes.HumanizeConfig) device.HumanizeConfig {
+	return device.HumanizeConfig{
+		Enabled:           c.Enabled,
+		KeyIntervalMean:   time.Duration(c.KeyIntervalMeanMs) * time.Millisecond,
+		KeyIntervalStdDev: time.Duration(c.KeyIntervalStdDev) * time.Millisecond,
+		MouseJitter:       int(min(c.MouseJitterPx, device.MaxMouseJitter+1)),
+		Seed:              c.Seed,
+	}
+}
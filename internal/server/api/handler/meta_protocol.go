@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/codegen/common"
+	"github.com/Alia5/VIIPER/internal/protocol"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+)
+
+var embeddedProtocol = sync.OnceValues(func() (map[string]any, error) {
+	var doc map[string]any
+	err := json.Unmarshal(protocol.JSON, &doc)
+	return doc, err
+})
+
+// MetaProtocol returns a handler that serves the protocol reference embedded
+// at build time, so tools can introspect the running server's wire formats.
+func MetaProtocol() api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		doc, err := embeddedProtocol()
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("embedded protocol is invalid: %v", err))
+		}
+		ver, err := common.GetVersion()
+		if err != nil {
+			ver = common.Version
+		}
+		payload, err := json.Marshal(apitypes.ProtocolResponse{Version: ver, Commit: common.GetCommit(), Protocol: doc})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
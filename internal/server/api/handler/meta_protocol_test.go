@@ -0,0 +1,40 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/apiclient"
+	handlerTest "github.com/Alia5/VIIPER/internal/_testing"
+	"github.com/Alia5/VIIPER/internal/protocol"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+)
+
+func TestMetaProtocol(t *testing.T) {
+	addr, _, done := handlerTest.StartAPIServer(t, func(r *api.Router, s *usb.Server, apiSrv *api.Server) {
+		r.Register("meta/protocol", handler.MetaProtocol())
+	})
+	defer done()
+
+	resp, err := apiclient.New(addr).FetchProtocol()
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.Version)
+	assert.NotEmpty(t, resp.Commit)
+
+	var want map[string]any
+	require.NoError(t, json.Unmarshal(protocol.JSON, &want))
+	assert.Equal(t, want, resp.Protocol)
+
+	var compact bytes.Buffer
+	require.NoError(t, json.Compact(&compact, protocol.JSON))
+	served, err := json.Marshal(resp.Protocol)
+	require.NoError(t, err)
+	assert.Len(t, served, compact.Len())
+	assert.Contains(t, resp.Protocol, "wire")
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	usbs "github.com/Alia5/VIIPER/internal/server/usb"
+)
+
+// Metrics returns a handler that reports the server's metrics registry.
+func Metrics(s *usbs.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		families := s.Metrics().Gather()
+		resp := apitypes.MetricsResponse{Metrics: make([]apitypes.MetricFamily, 0, len(families))}
+		for _, f := range families {
+			mf := apitypes.MetricFamily{Name: f.Name, Help: f.Help, Type: string(f.Kind), Samples: make([]apitypes.MetricSample, 0, len(f.Samples))}
+			for _, smp := range f.Samples {
+				ms := apitypes.MetricSample{Labels: smp.Labels, Value: smp.Value, Count: smp.Count}
+				for _, b := range smp.Buckets {
+					ms.Buckets = append(ms.Buckets, apitypes.MetricBucket{Le: b.Le, Count: b.Count})
+				}
+				mf.Samples = append(mf.Samples, ms)
+			}
+			resp.Metrics = append(resp.Metrics, mf)
+		}
+		payload, err := json.Marshal(resp)
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
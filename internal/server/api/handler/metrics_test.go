@@ -0,0 +1,91 @@
+package handler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device/keyboard"
+	_ "github.com/Alia5/VIIPER/internal/registry"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// sample returns the value of the series of name with labels, or -1.
+func sample(resp *apitypes.MetricsResponse, name string, labels map[string]string) float64 {
+	for _, f := range resp.Metrics {
+		if f.Name != name {
+			continue
+		}
+		for _, s := range f.Samples {
+			if len(s.Labels) == len(labels) && func() bool {
+				for k, v := range labels {
+					if s.Labels[k] != v {
+						return false
+					}
+				}
+				return true
+			}() {
+				if f.Type == "histogram" {
+					return float64(s.Count)
+				}
+				return s.Value
+			}
+		}
+	}
+	return -1
+}
+
+func TestMetrics(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("metrics", handler.Metrics(s.UsbServer))
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90164)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	defer func() { _ = s.UsbServer.RemoveBus(90164) }()
+
+	client := apiclient.New(s.ApiServer.Addr())
+	stream, added, err := client.AddDeviceAndConnect(context.Background(), 90164, "keyboard", nil)
+	require.NoError(t, err)
+	defer stream.Close()
+	device := "90164-" + added.DevId
+
+	before, err := client.Metrics()
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, sample(before, "viiper_devices", map[string]string{"type": "keyboard", "bus": "90164"}))
+	assert.Equal(t, 1.0, sample(before, "viiper_stream_clients", nil))
+	assert.GreaterOrEqual(t, sample(before, "viiper_lifecycle_events_total", map[string]string{"type": "DeviceAdded"}), 1.0)
+
+	usbip := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := usbip.AttachDevice(device)
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+	state := keyboard.PressKey(keyboard.KeyA)
+	require.NoError(t, stream.WriteBinary(&state))
+	_, err = usbip.PollInputReport(imp.Conn, state.BuildReport(), 750*time.Millisecond)
+	require.NoError(t, err)
+
+	after, err := client.Metrics()
+	require.NoError(t, err)
+	assert.Greater(t, sample(after, "viiper_stream_bytes_total", map[string]string{"dir": "c2s", "device": device}), 0.0)
+	assert.Greater(t, sample(after, "viiper_urbs_total", map[string]string{"dir": "in", "ep": "1"}),
+		sample(before, "viiper_urbs_total", map[string]string{"dir": "in", "ep": "1"}))
+	assert.Greater(t, sample(after, "viiper_input_reports_total", map[string]string{"device": device}), 0.0)
+	assert.Greater(t, sample(after, "viiper_input_report_build_seconds", map[string]string{"device": device}), 0.0)
+	assert.Equal(t, 1.0, sample(after, "viiper_usbip_connections", nil))
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+	pusb "github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// busFromParams resolves the bus addressed by the "id" path parameter.
+func busFromParams(s *usb.Server, params map[string]string) (*virtualbus.VirtualBus, error) {
+	idStr, ok := params["id"]
+	if !ok {
+		return nil, apierror.ErrBadRequest("missing id parameter")
+	}
+	busID, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return nil, apierror.ErrBadRequest(fmt.Sprintf("invalid busId: %v", err))
+	}
+	b := s.GetBus(uint32(busID))
+	if b == nil {
+		return nil, apierror.ErrNotFound(fmt.Sprintf("bus %d not found", busID))
+	}
+	return b, nil
+}
+
+// deviceFromParams resolves the device addressed by the "id" and "deviceid" path parameters.
+func deviceFromParams(s *usb.Server, params map[string]string) (uint32, string, pusb.Device, error) {
+	deviceID, ok := params["deviceid"]
+	if !ok {
+		return 0, "", nil, apierror.ErrBadRequest("missing deviceid parameter")
+	}
+	b, err := busFromParams(s, params)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	for _, m := range b.GetAllDeviceMetas() {
+		if virtualbus.DeviceID(&m.Meta) == deviceID {
+			return b.BusID(), deviceID, m.Dev, nil
+		}
+	}
+	return 0, "", nil, apierror.ErrNotFound(fmt.Sprintf("device %s not found on bus %d", deviceID, b.BusID()))
+}
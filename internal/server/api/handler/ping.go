@@ -11,7 +11,7 @@ import (
 
 // Ping returns a handler for the "ping" endpoint.
 // It provides a minimal identity + version response.
-func Ping() api.HandlerFunc {
+func Ping(apiSrv *api.Server) api.HandlerFunc {
 	return func(_ *api.Request, res *api.Response, logger *slog.Logger) error {
 		ver, err := common.GetVersion()
 		if err != nil {
@@ -22,7 +22,10 @@ func Ping() api.HandlerFunc {
 			logger.Error("ping: invalid version format", "error", err, "version", ver)
 		}
 
-		payload := apitypes.PingResponse{Server: "VIIPER", Version: ver}
+		payload := apitypes.PingResponse{Server: "VIIPER", Version: ver, ReadOnly: apiSrv.ReadOnly()}
+		if id, err := apiSrv.Identity(); err == nil {
+			payload.Fingerprint = id.Fingerprint()
+		}
 		b, err := json.Marshal(payload)
 		if err != nil {
 			return err
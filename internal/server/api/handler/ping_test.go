@@ -16,7 +16,7 @@ import (
 
 func TestPing(t *testing.T) {
 	addr, _, done := handlerTest.StartAPIServer(t, func(r *api.Router, s *usb.Server, apiSrv *api.Server) {
-		r.Register("ping", handler.Ping())
+		r.Register("ping", handler.Ping(apiSrv))
 	})
 	defer done()
 
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// RestoreState recreates the buses and devices of st under their previous
+// IDs, as bus/create and bus/{id}/add would. Restored devices start with
+// neutral input and are not auto-attached. They wait for their first stream
+// for the RestoredDeviceTimeout of the server rather than its
+// DeviceHandlerConnectTimeout, as their clients reconnect on their own
+// schedule. Entries that fail are skipped and reported in the returned error.
+func RestoreState(apiSrv *api.Server, st api.State, logger *slog.Logger) error {
+	s := apiSrv.USB()
+	connTimeout := apiSrv.Config().RestoredDeviceTimeout
+	if connTimeout == 0 {
+		connTimeout = -1
+	}
+	var errs []error
+	for _, bs := range st.Buses {
+		var opts []virtualbus.Option
+		if bs.MaxDevices != 0 {
+			opts = append(opts, virtualbus.WithMaxDevices(bs.MaxDevices))
+		}
+		b, err := virtualbus.NewWithBusId(bs.BusID, opts...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("bus %d: %w", bs.BusID, err))
+			continue
+		}
+		if err := b.SetLabel(bs.Label, bs.Description); err != nil {
+			logger.Warn("restore: dropped invalid bus label", "busID", bs.BusID, "error", err)
+		}
+		if err := s.AddBus(b); err != nil {
+			_ = b.Close()
+			errs = append(errs, fmt.Errorf("bus %d: %w", bs.BusID, err))
+			continue
+		}
+		for _, ds := range bs.Devices {
+			if _, _, err := addDevice(s, apiSrv, b, ds.Create, ds.DevID, ds.Port, nil, connTimeout, logger); err != nil {
+				errs = append(errs, fmt.Errorf("device %d-%d: %w", bs.BusID, ds.DevID, err))
+			}
+		}
+		logger.Info("restored bus", "busID", bs.BusID, "devices", b.DeviceCount())
+	}
+	return errors.Join(errs...)
+}
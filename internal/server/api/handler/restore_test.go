@@ -0,0 +1,115 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+)
+
+func TestRestoreState(t *testing.T) {
+	cfg := viiperTesting.TestServerConfig(t)
+	cfg.Server.StateFile = filepath.Join(t.TempDir(), "state.json")
+	start := func() (*viiperTesting.MockServer, *apiclient.Client) {
+		s := viiperTesting.NewTestServerWithConfig(t, cfg)
+		r := s.ApiServer.Router()
+		r.Register("bus/list", handler.BusList(s.UsbServer))
+		r.Register("bus/create", handler.BusCreate(s.UsbServer))
+		r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+		r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+		r.Register("bus/{id}/remove", handler.BusDeviceRemove(s.UsbServer))
+		r.Register("bus/{id}/{deviceid}/label", handler.DeviceSetLabel(s.UsbServer, s.ApiServer))
+		r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+		require.NoError(t, s.ApiServer.Start())
+		return s, apiclient.New(s.ApiServer.Addr())
+	}
+	// stop shuts s down and frees its bus numbers, which are allocated
+	// process-wide, as a server exiting would.
+	stop := func(s *viiperTesting.MockServer) {
+		s.ApiServer.Close()
+		_ = s.UsbServer.Close()
+		for _, id := range s.UsbServer.ListBuses() {
+			_ = s.UsbServer.RemoveBus(id)
+		}
+	}
+
+	s, client := start()
+	_, err := client.BusCreateWith(apitypes.BusCreateRequest{BusID: 90150, Label: "desk", Description: "left side", MaxDevices: 4})
+	require.NoError(t, err)
+	vid := uint16(0x1234)
+	_, err = client.DeviceAdd(90150, "xbox360", &device.CreateOptions{IdVendor: &vid})
+	require.NoError(t, err)
+	_, err = client.DeviceAdd(90150, "keyboard", nil)
+	require.NoError(t, err)
+	_, err = client.DeviceAdd(90150, "mouse", nil)
+	require.NoError(t, err)
+	kb, name := "keyboard", "chat-kb"
+	_, err = client.DeviceAddWith(90150, apitypes.DeviceCreateRequest{Type: &kb, DevId: &name})
+	require.NoError(t, err)
+	_, err = client.DeviceRemove(90150, "2")
+	require.NoError(t, err)
+	_, err = client.SetDeviceLabel(90150, "3", "aim")
+	require.NoError(t, err)
+	before, err := client.DevicesList(90150)
+	require.NoError(t, err)
+	require.Len(t, before.Devices, 3)
+	stop(s)
+
+	raw, err := os.ReadFile(cfg.Server.StateFile)
+	require.NoError(t, err)
+	var st api.State
+	require.NoError(t, json.Unmarshal(raw, &st))
+	require.Len(t, st.Buses, 1)
+	assert.Equal(t, "desk", st.Buses[0].Label)
+	require.Len(t, st.Buses[0].Devices, 3)
+	assert.Equal(t, []uint32{1, 3, 4}, []uint32{st.Buses[0].Devices[0].DevID, st.Buses[0].Devices[1].DevID, st.Buses[0].Devices[2].DevID})
+	assert.Equal(t, &name, st.Buses[0].Devices[2].Create.DevId)
+	assert.Equal(t, 4, st.Buses[0].MaxDevices)
+
+	s, client = start()
+	// Restored devices outlive the device handler timeout without a stream.
+	time.Sleep(cfg.Server.ApiServerConfig.DeviceHandlerConnectTimeout + 500*time.Millisecond)
+	after, err := client.DevicesList(90150)
+	require.NoError(t, err)
+	assert.Equal(t, before, after, "same devices under the same IDs, ports and labels")
+	buses, err := client.BusList()
+	require.NoError(t, err)
+	assert.Contains(t, buses.BusInfo, apitypes.BusInfo{BusID: 90150, Label: "desk", Description: "left side", DeviceCount: 3, MaxDevices: 4})
+
+	stream, err := client.OpenStream(context.Background(), 90150, "3")
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+
+	// Changes after the restart keep being saved.
+	_, err = client.DeviceRemove(90150, "1")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		st, err := api.LoadState(cfg.Server.StateFile)
+		return err == nil && len(st.Buses) == 1 && len(st.Buses[0].Devices) == 2
+	}, time.Second, 10*time.Millisecond)
+	stop(s)
+
+	// With a restored device timeout, devices no stream opens are removed.
+	cfg.Server.ApiServerConfig.RestoredDeviceTimeout = 100 * time.Millisecond
+	s, client = start()
+	defer stop(s)
+	devs, err := client.DevicesList(90150)
+	require.NoError(t, err)
+	require.Len(t, devs.Devices, 2)
+	require.Eventually(t, func() bool {
+		devs, err := client.DevicesList(90150)
+		return err == nil && len(devs.Devices) == 0
+	}, time.Second, 10*time.Millisecond)
+}
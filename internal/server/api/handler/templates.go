@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+)
+
+var templateNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// TemplateList returns a handler that lists all stored device templates.
+func TemplateList(apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		templates := apiSrv.Templates()
+		out := make([]apitypes.DeviceTemplate, 0, len(templates))
+		for _, t := range templates {
+			out = append(out, apitypes.DeviceTemplate{Name: t.Name, DeviceType: t.DeviceType, Options: deviceDefaults(t.Options)})
+		}
+		payload, err := json.Marshal(apitypes.TemplateListResponse{Templates: out})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
+
+// TemplateGet returns a handler that reports a single device template by name.
+func TemplateGet(apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		if req.Payload == "" {
+			return apierror.ErrBadRequest("missing template name")
+		}
+		name := req.Payload
+		t, ok := apiSrv.Template(name)
+		if !ok {
+			return apierror.ErrNotFound(fmt.Sprintf("template %s not found", name))
+		}
+		payload, err := json.Marshal(apitypes.DeviceTemplate{Name: t.Name, DeviceType: t.DeviceType, Options: deviceDefaults(t.Options)})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
+
+// TemplateSet returns a handler that creates or replaces a device template.
+// The options are checked by creating a throwaway device of the template's type.
+func TemplateSet(apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		if req.Payload == "" {
+			return apierror.ErrBadRequest("missing payload")
+		}
+		var templateReq apitypes.DeviceTemplate
+		if err := json.Unmarshal([]byte(req.Payload), &templateReq); err != nil {
+			return apierror.ErrBadRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+		}
+		if !templateNamePattern.MatchString(templateReq.Name) {
+			return apierror.ErrBadRequest("template name must be 1-64 letters, digits, '.', '_' or '-'")
+		}
+		deviceType := strings.ToLower(templateReq.DeviceType)
+		reg := api.GetRegistration(deviceType)
+		if reg == nil {
+			return apierror.ErrBadRequest(fmt.Sprintf("unknown device type: %s", templateReq.DeviceType))
+		}
+
+		t := api.DeviceTemplate{
+			Name:       templateReq.Name,
+			DeviceType: deviceType,
+			Options: device.CreateOptions{
+				IdVendor:       templateReq.Options.IdVendor,
+				IdProduct:      templateReq.Options.IdProduct,
+				SerialNumber:   templateReq.Options.SerialNumber,
+				ProductString:  templateReq.Options.ProductString,
+				DeviceSpecific: templateReq.Options.DeviceSpecific,
+				StrictInput:    templateReq.Options.StrictInput,
+			},
+		}
+		opts := t.Options
+		if _, err := reg.CreateDevice(&opts); err != nil {
+			return apierror.ErrBadRequest(fmt.Sprintf("invalid options for %s: %v", deviceType, err))
+		}
+
+		apiSrv.SetTemplate(t)
+		logger.Info("set device template", "name", t.Name, "type", deviceType)
+		payload, err := json.Marshal(apitypes.DeviceTemplate{Name: t.Name, DeviceType: t.DeviceType, Options: deviceDefaults(t.Options)})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
+
+// TemplateRemove returns a handler that deletes a device template.
+// Devices created from it are unaffected.
+func TemplateRemove(apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		if req.Payload == "" {
+			return apierror.ErrBadRequest("missing template name")
+		}
+		name := req.Payload
+		if !apiSrv.RemoveTemplate(name) {
+			return apierror.ErrNotFound(fmt.Sprintf("template %s not found", name))
+		}
+		logger.Info("removed device template", "name", name)
+		payload, err := json.Marshal(apitypes.TemplateRemoveResponse{Name: name})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
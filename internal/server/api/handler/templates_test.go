@@ -0,0 +1,106 @@
+package handler_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	_ "github.com/Alia5/VIIPER/internal/registry"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func TestTemplates(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/list", handler.BusDevicesList(s.UsbServer))
+	r.Register("templates/list", handler.TemplateList(s.ApiServer))
+	r.Register("templates/get", handler.TemplateGet(s.ApiServer))
+	r.Register("templates/set", handler.TemplateSet(s.ApiServer))
+	r.Register("templates/remove", handler.TemplateRemove(s.ApiServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90117)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+
+	client := apiclient.New(s.ApiServer.Addr())
+
+	vid := uint16(0x1234)
+	pad := apitypes.DeviceTemplate{
+		Name:       "esports-pad",
+		DeviceType: "Xbox360",
+		Options:    apitypes.DeviceDefaults{IdVendor: &vid, DeviceSpecific: map[string]any{"subType": 7}},
+	}
+	saved, err := client.TemplateSet(&pad)
+	require.NoError(t, err)
+	assert.Equal(t, "xbox360", saved.DeviceType, "device types are normalized")
+
+	got, err := client.TemplateGet("esports-pad")
+	require.NoError(t, err)
+	assert.Equal(t, saved, got)
+
+	_, err = client.TemplateSet(&apitypes.DeviceTemplate{Name: "broken", DeviceType: "xbox360", Options: apitypes.DeviceDefaults{
+		DeviceSpecific: map[string]any{"subType": "a"},
+	}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400 Bad Request: invalid options for xbox360")
+	_, err = client.TemplateSet(&apitypes.DeviceTemplate{Name: "no spaces", DeviceType: "xbox360"})
+	assert.ErrorContains(t, err, "400 Bad Request")
+	_, err = client.TemplateSet(&apitypes.DeviceTemplate{Name: "nope", DeviceType: "toaster"})
+	assert.EqualError(t, err, "400 Bad Request: unknown device type: toaster")
+
+	list, err := client.TemplateList()
+	require.NoError(t, err)
+	require.Len(t, list.Templates, 1, "rejected templates must not be stored")
+
+	plain, err := client.DeviceAddFromTemplate(90117, "esports-pad", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "xbox360", plain.Type)
+	assert.Equal(t, "0x1234", plain.Vid)
+	assert.Equal(t, float64(7), plain.DeviceSpecific["subType"])
+
+	pid := uint16(0x0042)
+	overridden, err := client.DeviceAddFromTemplate(90117, "esports-pad", &apitypes.DeviceDefaults{
+		IdProduct:      &pid,
+		DeviceSpecific: map[string]any{"subType": 2},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "0x1234", overridden.Vid)
+	assert.Equal(t, "0x0042", overridden.Pid)
+	assert.Equal(t, float64(2), overridden.DeviceSpecific["subType"])
+
+	_, err = client.DeviceAddFromTemplate(90117, "missing", nil)
+	assert.EqualError(t, err, "404 Not Found: template missing not found")
+
+	// Editing the template leaves devices created from it alone.
+	otherVid := uint16(0x5678)
+	pad.Options = apitypes.DeviceDefaults{IdVendor: &otherVid}
+	_, err = client.TemplateSet(&pad)
+	require.NoError(t, err)
+	devices, err := client.DevicesList(90117)
+	require.NoError(t, err)
+	require.Len(t, devices.Devices, 2)
+	for _, d := range devices.Devices {
+		assert.Equal(t, "0x1234", d.Vid)
+	}
+	edited, err := client.DeviceAddFromTemplate(90117, "esports-pad", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "0x5678", edited.Vid)
+
+	removed, err := client.TemplateRemove("esports-pad")
+	require.NoError(t, err)
+	assert.Equal(t, "esports-pad", removed.Name)
+	_, err = client.TemplateGet("esports-pad")
+	assert.EqualError(t, err, "404 Not Found: template esports-pad not found")
+	_, err = client.TemplateRemove("esports-pad")
+	assert.EqualError(t, err, "404 Not Found: template esports-pad not found")
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+)
+
+// TimeSync returns a handler for one-shot clock synchronization. The payload
+// is the client's monotonic time in nanoseconds, echoed back next to the
+// server clocks so the client can compute offset and round trip.
+func TimeSync(apiSrv *api.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		mono := apiSrv.MonoNow()
+		wall := apiSrv.WallNow()
+		var clientMono int64
+		if req.Payload != "" {
+			v, err := strconv.ParseInt(req.Payload, 10, 64)
+			if err != nil {
+				return apierror.ErrBadRequest(fmt.Sprintf("invalid client time: %v", err))
+			}
+			clientMono = v
+		}
+		payload, err := json.Marshal(apitypes.TimeSyncResponse{
+			ClientMonoNs: clientMono,
+			ServerMonoNs: mono,
+			ServerAt:     wall.UTC().Format(time.RFC3339Nano),
+		})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
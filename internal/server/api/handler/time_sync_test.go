@@ -0,0 +1,73 @@
+package handler_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/apiclient"
+	handlerTest "github.com/Alia5/VIIPER/internal/_testing"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+)
+
+// skewedClock is a server clock that only moves when told to, with a wall
+// time far from the client's.
+type skewedClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *skewedClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *skewedClock) AfterFunc(time.Duration, func()) {}
+
+func (c *skewedClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestTimeSync(t *testing.T) {
+	clock := &skewedClock{now: time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)}
+	addr, _, done := handlerTest.StartAPIServer(t, func(r *api.Router, s *usb.Server, apiSrv *api.Server) {
+		apiSrv.SetClock(clock)
+		r.Register("time", handler.TimeSync(apiSrv))
+	})
+	defer done()
+	clock.Advance(90 * time.Second)
+
+	client := apiclient.New(addr)
+	resp, err := client.TimeSync(12345)
+	require.NoError(t, err)
+	assert.Equal(t, int64(12345), resp.ClientMonoNs)
+	assert.Equal(t, (90 * time.Second).Nanoseconds(), resp.ServerMonoNs)
+	assert.Equal(t, "2001-02-03T04:06:36Z", resp.ServerAt)
+
+	before := time.Now()
+	ts, err := client.TimeOffset(context.Background())
+	after := time.Now()
+	require.NoError(t, err)
+	assert.Positive(t, ts.RTT)
+	assert.LessOrEqual(t, ts.RTT, after.Sub(before))
+
+	// The server read 90s sometime during the exchange, regardless of its wall clock.
+	serverRead := ts.ToLocal((90 * time.Second).Nanoseconds())
+	assert.False(t, serverRead.Before(before.Add(-ts.RTT)), "server time mapped before the exchange")
+	assert.False(t, serverRead.After(after.Add(ts.RTT)), "server time mapped after the exchange")
+
+	for _, mono := range []int64{0, 1, (90 * time.Second).Nanoseconds(), (72 * time.Hour).Nanoseconds()} {
+		assert.Equal(t, mono, ts.ToServer(ts.ToLocal(mono)))
+	}
+	now := time.Now()
+	assert.True(t, now.Equal(ts.ToLocal(ts.ToServer(now))))
+}
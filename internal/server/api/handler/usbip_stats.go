@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	usbs "github.com/Alia5/VIIPER/internal/server/usb"
+)
+
+// UsbipStats returns a handler that reports the USB/IP connections of the
+// server and those its connection limits refused or closed.
+func UsbipStats(s *usbs.Server) api.HandlerFunc {
+	return func(req *api.Request, res *api.Response, logger *slog.Logger) error {
+		st := s.ConnStats()
+		payload, err := json.Marshal(apitypes.UsbipStatsResponse{
+			ActiveConnections:        st.Active,
+			MaxConcurrentConnections: st.Max,
+			Refused:                  st.Refused,
+			RateLimited:              st.RateLimited,
+			IdleClosed:               st.IdleClosed,
+		})
+		if err != nil {
+			return apierror.ErrInternal(fmt.Sprintf("failed to marshal response: %v", err))
+		}
+		res.JSON = string(payload)
+		return nil
+	}
+}
@@ -0,0 +1,26 @@
+package handler_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	handlerTest "github.com/Alia5/VIIPER/internal/_testing"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+)
+
+func TestUsbipStats(t *testing.T) {
+	addr, _, done := handlerTest.StartAPIServer(t, func(r *api.Router, s *usb.Server, apiSrv *api.Server) {
+		r.Register("usbip/stats", handler.UsbipStats(s))
+	})
+	defer done()
+
+	got, err := apiclient.New(addr).UsbipStats()
+	require.NoError(t, err)
+	assert.Equal(t, &apitypes.UsbipStatsResponse{}, got)
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"log/slog"
+
+	"github.com/Alia5/VIIPER/internal/server/api/auth"
+)
+
+// Identity returns the key the server signs handshakes with, generating one
+// on first use if none is configured. Clients pin its Fingerprint.
+func (s *Server) Identity() (*auth.Identity, error) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	if s.config.Identity == nil {
+		id, err := auth.NewIdentity()
+		if err != nil {
+			return nil, err
+		}
+		s.config.Identity = id
+	}
+	return s.config.Identity, nil
+}
+
+// handshakeIdentity returns the identity for a handshake, or nil, which
+// refuses signed handshakes, if there is none.
+func (s *Server) handshakeIdentity(logger *slog.Logger) *auth.Identity {
+	id, err := s.Identity()
+	if err != nil {
+		logger.Error("server identity unavailable", "error", err)
+		return nil
+	}
+	return id
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// PlayMacro plays steps repeat times against dev on the server clock and
+// returns the playback time. Playback ends early with CancelMacro, once
+// devCtx ends or when a stream to dev closes. Only one macro per device may
+// play at a time.
+func (s *Server) PlayMacro(devCtx context.Context, dev usb.Device, steps []apitypes.MacroStep, repeat int) (time.Duration, error) {
+	reg, ok := GetRegistration(inferDeviceType(dev)).(MacroRegistration)
+	if !ok {
+		return 0, apierror.ErrBadRequest("device type does not support macros")
+	}
+	ms := make([]device.MacroStep, len(steps))
+	for i, st := range steps {
+		raw, err := json.Marshal(st.State)
+		if err != nil {
+			return 0, apierror.ErrBadRequest(fmt.Sprintf("step %d: %v", i, err))
+		}
+		apply, err := reg.MacroState(dev, raw)
+		if err != nil {
+			return 0, apierror.ErrBadRequest(fmt.Sprintf("step %d: invalid state: %v", i, err))
+		}
+		ms[i] = device.MacroStep{Apply: apply, Hold: time.Duration(st.HoldMs) * time.Millisecond}
+	}
+	m := device.NewMacro(ms, repeat)
+	s.clockMu.Lock()
+	m.SetClock(s.clock)
+	s.clockMu.Unlock()
+
+	s.macrosMu.Lock()
+	if prev := s.macros[dev]; prev != nil {
+		select {
+		case <-prev.Done():
+		default:
+			s.macrosMu.Unlock()
+			return 0, apierror.ErrConflict("macro already playing")
+		}
+	}
+	s.macros[dev] = m
+	s.macrosMu.Unlock()
+
+	m.Start(devCtx)
+	go func() {
+		<-m.Done()
+		s.macrosMu.Lock()
+		if s.macros[dev] == m {
+			delete(s.macros, dev)
+		}
+		s.macrosMu.Unlock()
+	}()
+	return m.Duration(), nil
+}
+
+// CancelMacro stops the macro playing against dev and reports whether there
+// was one. The last state it applied stays.
+func (s *Server) CancelMacro(dev usb.Device) bool {
+	s.macrosMu.Lock()
+	m := s.macros[dev]
+	s.macrosMu.Unlock()
+	return m != nil && m.Cancel()
+}
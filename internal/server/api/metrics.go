@@ -0,0 +1,45 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/Alia5/VIIPER/internal/metrics"
+)
+
+// apiMetrics are the series of the API server, kept in the registry of the
+// USB server so one scrape covers both.
+type apiMetrics struct {
+	streamBytes *metrics.CounterVec
+	errors      *metrics.CounterVec
+	stateSaves  *metrics.CounterVec
+}
+
+func (s *Server) registerMetrics() {
+	r := s.usbs.Metrics()
+	r.GaugeFunc("viiper_devices", "Devices by type and bus.", []string{"type", "bus"}, func(emit func(float64, ...string)) {
+		for _, busID := range s.usbs.ListBuses() {
+			b := s.usbs.GetBus(busID)
+			if b == nil {
+				continue
+			}
+			counts := map[string]int{}
+			for _, m := range b.GetAllDeviceMetas() {
+				counts[inferDeviceType(m.Dev)]++
+			}
+			for typ, n := range counts {
+				emit(float64(n), typ, strconv.FormatUint(uint64(busID), 10))
+			}
+		}
+	})
+	r.GaugeFunc("viiper_stream_clients", "Open device streams.", nil, func(emit func(float64, ...string)) {
+		s.streamsMu.Lock()
+		n := len(s.streams)
+		s.streamsMu.Unlock()
+		emit(float64(n))
+	})
+	s.m = apiMetrics{
+		streamBytes: r.Counter("viiper_stream_bytes_total", "Device stream bytes, c2s from the client and s2c to it.", "dir", "device"),
+		errors:      r.Counter("viiper_api_errors_total", "API requests answered with an error, by status.", "status"),
+		stateSaves:  r.Counter("viiper_state_saves_total", "Writes of the state file, by result ok or failed.", "result"),
+	}
+}
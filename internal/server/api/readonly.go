@@ -0,0 +1,40 @@
+package api
+
+import (
+	"log/slog"
+
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+)
+
+// readOnlyDetail is the problem detail returned for mutating routes while the
+// server is read-only.
+const readOnlyDetail = "server is in read-only mode"
+
+// ReadOnly reports whether mutating management routes are refused.
+func (s *Server) ReadOnly() bool { return s.readOnly.Load() }
+
+// SetReadOnly switches read-only mode. Device streams are not affected.
+func (s *Server) SetReadOnly(on bool) { s.readOnly.Store(on) }
+
+// guardRoutes refuses mutating routes while read-only, admin routes to
+// remote clients, who share the API password with whatever front end the
+// server is exposed through, and routes outside the scopes of a token.
+func (s *Server) guardRoutes(rt Route, next HandlerFunc) HandlerFunc {
+	return func(req *Request, res *Response, logger *slog.Logger) error {
+		if req.Token != nil {
+			if err := s.checkScope(rt, req); err != nil {
+				return err
+			}
+		}
+		if rt.Flags&Admin != 0 {
+			if !req.Local {
+				return apierror.ErrForbidden("admin routes are only served to localhost clients")
+			}
+			return next(req, res, logger)
+		}
+		if rt.Flags&Mutating != 0 && s.ReadOnly() {
+			return apierror.ErrForbidden(readOnlyDetail)
+		}
+		return next(req, res, logger)
+	}
+}
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device/replay"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+const recordingExt = ".vrpl"
+
+// recording captures the decoded stream traffic of one device into a replay file.
+type recording struct {
+	mu          sync.Mutex
+	path        string
+	file        *os.File
+	bw          *bufio.Writer
+	w           *replay.Writer
+	start       time.Time
+	maxDuration time.Duration
+	maxBytes    int64
+	records     uint32
+	duration    time.Duration
+	active      bool
+	err         error
+	timer       *time.Timer
+}
+
+func (r *recording) record(kind replay.Kind, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.active {
+		return
+	}
+	offset := time.Since(r.start)
+	if offset > r.maxDuration || r.w.Size()+int64(len(data))+13 > r.maxBytes {
+		r.finishLocked()
+		return
+	}
+	if err := r.w.Write(replay.Record{Offset: offset, Kind: kind, Data: data}); err != nil {
+		r.err = err
+		r.finishLocked()
+		return
+	}
+	r.records++
+}
+
+func (r *recording) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finishLocked()
+}
+
+func (r *recording) finishLocked() {
+	if !r.active {
+		return
+	}
+	r.active = false
+	r.duration = min(time.Since(r.start), r.maxDuration)
+	r.timer.Stop()
+	if err := r.bw.Flush(); err != nil && r.err == nil {
+		r.err = err
+	}
+	if err := r.file.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *recording) status() apitypes.RecordingStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := apitypes.RecordingStatus{
+		Active:     r.active,
+		Records:    r.records,
+		Bytes:      uint64(r.w.Size()),
+		DurationMs: uint32(r.duration.Milliseconds()),
+	}
+	if r.active {
+		st.DurationMs = uint32(time.Since(r.start).Milliseconds())
+	}
+	if r.err != nil {
+		st.Error = r.err.Error()
+	}
+	return st
+}
+
+func (s *Server) recordingDir() string {
+	if s.config.RecordingDir != "" {
+		return s.config.RecordingDir
+	}
+	return filepath.Join(os.TempDir(), "viiper-recordings")
+}
+
+// StartRecording starts recording the input states and feedback streamed for dev.
+// name identifies the device in the file name (e.g. "1-1"). Only one recording
+// per device may be active; a finished one is replaced. The recording is
+// finished and forgotten once devCtx is done; its file stays.
+func (s *Server) StartRecording(devCtx context.Context, dev usb.Device, name string, maxDuration time.Duration, maxBytes int64) error {
+	s.recordingsMu.Lock()
+	defer s.recordingsMu.Unlock()
+	prev := s.recordings[dev]
+	if prev != nil && prev.status().Active {
+		return apierror.ErrConflict("recording already active")
+	}
+
+	dir := s.recordingDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return apierror.ErrInternal(fmt.Sprintf("create recording dir: %v", err))
+	}
+	s.cleanupRecordings(dir)
+
+	start := time.Now()
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s%s", name, start.Format("20060102T150405.000"), recordingExt))
+	f, err := os.Create(path)
+	if err != nil {
+		return apierror.ErrInternal(fmt.Sprintf("create recording: %v", err))
+	}
+	bw := bufio.NewWriter(f)
+	w, err := replay.NewWriter(bw, replay.Header{DeviceType: inferDeviceType(dev), Start: start})
+	if err != nil {
+		_ = f.Close()
+		return apierror.ErrInternal(fmt.Sprintf("write recording header: %v", err))
+	}
+	rec := &recording{
+		path:        path,
+		file:        f,
+		bw:          bw,
+		w:           w,
+		start:       start,
+		maxDuration: maxDuration,
+		maxBytes:    maxBytes,
+		active:      true,
+	}
+	rec.timer = time.AfterFunc(maxDuration, rec.finish)
+	s.recordings[dev] = rec
+	if prev == nil {
+		go func() {
+			<-devCtx.Done()
+			s.recordingsMu.Lock()
+			rec := s.recordings[dev]
+			delete(s.recordings, dev)
+			s.recordingsMu.Unlock()
+			rec.finish()
+		}()
+	}
+	s.logger.Info("recording started", "device", name, "file", path)
+	return nil
+}
+
+// StopRecording finalizes the recording of dev and returns its status.
+func (s *Server) StopRecording(dev usb.Device) (apitypes.RecordingStatus, error) {
+	rec := s.recordingFor(dev)
+	if rec == nil {
+		return apitypes.RecordingStatus{}, apierror.ErrNotFound("no recording for device")
+	}
+	rec.finish()
+	return rec.status(), nil
+}
+
+// RecordingChunk reads up to size bytes of the finished recording of dev at offset.
+func (s *Server) RecordingChunk(dev usb.Device, offset int64, size int) ([]byte, bool, error) {
+	rec := s.recordingFor(dev)
+	if rec == nil {
+		return nil, false, apierror.ErrNotFound("no recording for device")
+	}
+	if rec.status().Active {
+		return nil, false, apierror.ErrConflict("recording still active")
+	}
+	f, err := os.Open(rec.path)
+	if err != nil {
+		return nil, false, apierror.ErrNotFound(fmt.Sprintf("open recording: %v", err))
+	}
+	defer f.Close()
+	buf := make([]byte, size)
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return nil, false, apierror.ErrInternal(fmt.Sprintf("read recording: %v", err))
+	}
+	return buf[:n], err == io.EOF, nil
+}
+
+func (s *Server) recordingFor(dev usb.Device) *recording {
+	s.recordingsMu.Lock()
+	defer s.recordingsMu.Unlock()
+	return s.recordings[dev]
+}
+
+func (s *Server) record(dev usb.Device, kind replay.Kind, data []byte) {
+	if rec := s.recordingFor(dev); rec != nil {
+		rec.record(kind, data)
+	}
+}
+
+// cleanupRecordings removes recordings older than the configured retention.
+func (s *Server) cleanupRecordings(dir string) {
+	if s.config.RecordingRetention <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-s.config.RecordingRetention)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), recordingExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			s.logger.Warn("failed to remove old recording", "file", e.Name(), "error", err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Alia5/VIIPER/internal/server/api/auth"
+)
+
+const defaultTicketLifetime = 12 * time.Hour
+
+// ErrResumeDisabled is returned by IssueTicket when ResumeTicketLifetime is
+// negative.
+var ErrResumeDisabled = errors.New("session resumption is disabled")
+
+// password returns the API password.
+func (s *Server) password() string {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	return s.config.Password
+}
+
+// SetPassword replaces the API password. Session resumption tickets issued
+// for the old one are revoked; connections already open stay up.
+func (s *Server) SetPassword(password string) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	s.config.Password = password
+	s.tickets = nil
+}
+
+// resumeTickets returns the issuer of session resumption tickets, creating
+// it on first use or after the tickets were revoked.
+func (s *Server) resumeTickets() (*auth.Tickets, error) {
+	lifetime := s.config.ResumeTicketLifetime
+	if lifetime < 0 {
+		return nil, ErrResumeDisabled
+	}
+	if lifetime == 0 {
+		lifetime = defaultTicketLifetime
+	}
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	if s.tickets == nil {
+		t, err := auth.NewTickets(lifetime)
+		if err != nil {
+			return nil, err
+		}
+		s.tickets = t
+	}
+	return s.tickets, nil
+}
+
+// IssueTicket issues a session resumption ticket and the secret it grants.
+func (s *Server) IssueTicket() (ticket, secret []byte, expires time.Time, err error) {
+	t, err := s.resumeTickets()
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	return t.Issue()
+}
+
+// RevokeTickets invalidates every session resumption ticket issued so far;
+// their holders fall back to the full handshake.
+func (s *Server) RevokeTickets() {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	s.tickets = nil
+}
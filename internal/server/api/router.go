@@ -6,6 +6,7 @@ import (
 	"net"
 	"strings"
 
+	"github.com/Alia5/VIIPER/internal/server/api/auth"
 	"github.com/Alia5/VIIPER/usb"
 )
 
@@ -14,6 +15,14 @@ type Request struct {
 	Ctx     context.Context
 	Params  map[string]string
 	Payload string
+	// Local is set for clients connected from localhost.
+	Local bool
+	// Authenticated is set for connections encrypted after an auth or
+	// resume handshake.
+	Authenticated bool
+	// Token is the token the connection authenticated with; nil for the
+	// password and unauthenticated connections, which are not scoped.
+	Token *auth.Token
 }
 
 // Response holds the JSON string to return to the client.
@@ -32,10 +41,30 @@ type HandlerFunc func(req *Request, res *Response, logger *slog.Logger) error
 // the handler encountered a terminal failure; the dispatcher/server will log it.
 type StreamHandlerFunc func(conn net.Conn, dev *usb.Device, logger *slog.Logger) error
 
+// RouteFlag classifies a route at registration.
+type RouteFlag uint8
+
+const (
+	// Mutating marks routes that change server or device state.
+	Mutating RouteFlag = 1 << iota
+	// Admin marks routes that configure the server itself.
+	Admin
+)
+
+// Route describes a registered management route.
+type Route struct {
+	Pattern string // as registered
+	Flags   RouteFlag
+}
+
+// Middleware wraps the handler of a matched route.
+type Middleware func(rt Route, next HandlerFunc) HandlerFunc
+
 // Router implements simple path pattern matching with placeholders in {name}.
 type Router struct {
 	routes       []routeEntry
 	streamRoutes []streamRouteEntry
+	middleware   []Middleware
 }
 
 type routeEntry struct {
@@ -43,6 +72,7 @@ type routeEntry struct {
 	originalPattern string
 	parts           []string
 	handler         HandlerFunc
+	flags           RouteFlag
 }
 
 type streamRouteEntry struct {
@@ -56,10 +86,29 @@ type streamRouteEntry struct {
 func NewRouter() *Router { return &Router{} }
 
 // Register registers a handler for a path pattern like "bus/{id}/list".
-func (r *Router) Register(pattern string, handler HandlerFunc) {
+func (r *Router) Register(pattern string, handler HandlerFunc, flags ...RouteFlag) {
 	p := strings.ToLower(pattern)
 	parts := strings.Split(p, "/")
-	r.routes = append(r.routes, routeEntry{pattern: p, originalPattern: pattern, parts: parts, handler: handler})
+	var f RouteFlag
+	for _, flag := range flags {
+		f |= flag
+	}
+	r.routes = append(r.routes, routeEntry{pattern: p, originalPattern: pattern, parts: parts, handler: handler, flags: f})
+}
+
+// Use adds a middleware around the handlers returned by Match. The first
+// middleware added runs first.
+func (r *Router) Use(mw Middleware) {
+	r.middleware = append(r.middleware, mw)
+}
+
+// Routes lists the registered management routes in registration order.
+func (r *Router) Routes() []Route {
+	out := make([]Route, len(r.routes))
+	for i, rt := range r.routes {
+		out[i] = Route{Pattern: rt.originalPattern, Flags: rt.flags}
+	}
+	return out
 }
 
 // RegisterStream registers a StreamHandler for long-lived TCP connections.
@@ -72,9 +121,32 @@ func (r *Router) RegisterStream(pattern string, handler StreamHandlerFunc) {
 // Match returns the HandlerFunc and params if the given path matches any
 // registered pattern. Returns nil if none match.
 func (r *Router) Match(path string) (HandlerFunc, map[string]string) {
+	rt, params := r.matchRoute(path)
+	if rt == nil {
+		return nil, nil
+	}
+	h := rt.handler
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](Route{Pattern: rt.originalPattern, Flags: rt.flags}, h)
+	}
+	return h, params
+}
+
+// Pattern returns the pattern, as registered, of the route matching path,
+// or "" if none matches.
+func (r *Router) Pattern(path string) string {
+	rt, _ := r.matchRoute(path)
+	if rt == nil {
+		return ""
+	}
+	return rt.originalPattern
+}
+
+func (r *Router) matchRoute(path string) (*routeEntry, map[string]string) {
 	p := strings.ToLower(path)
 	parts := strings.Split(p, "/")
-	for _, rt := range r.routes {
+	for i := range r.routes {
+		rt := &r.routes[i]
 		if len(rt.parts) != len(parts) {
 			continue
 		}
@@ -94,7 +166,7 @@ func (r *Router) Match(path string) (HandlerFunc, map[string]string) {
 			}
 		}
 		if ok {
-			return rt.handler, params
+			return rt, params
 		}
 	}
 	return nil, nil
@@ -2,7 +2,9 @@ package api
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,13 +14,19 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Alia5/VIIPER/apitypes"
 	"github.com/Alia5/VIIPER/device"
 	"github.com/Alia5/VIIPER/internal/server/api/auth"
 	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/internal/server/api/frame"
 	"github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/internal/util"
 	pusb "github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/virtualbus"
 )
 
 // Server implements a small TCP API for managing virtual bus topology.
@@ -29,18 +37,78 @@ type Server struct {
 	logger *slog.Logger
 	router *Router
 	config *ServerConfig
+
+	streamsMu sync.Mutex
+	streams   map[pusb.Device]*streamConn
+
+	mixMu sync.Mutex
+	mixes map[pusb.Device]*mixSession
+
+	recordingsMu sync.Mutex
+	recordings   map[pusb.Device]*recording
+
+	macrosMu sync.Mutex
+	macros   map[pusb.Device]*device.Macro
+
+	strictMu sync.Mutex
+	strict   map[pusb.Device]bool
+
+	templatesMu sync.Mutex
+	templates   map[string]DeviceTemplate
+
+	specsMu sync.Mutex
+	specs   map[pusb.Device]apitypes.DeviceCreateRequest
+
+	stateMu sync.Mutex
+	state   *statePersister // nil unless PersistState
+
+	authMu  sync.Mutex
+	tickets *auth.Tickets // session resumption, created on first use
+
+	tokensMu  sync.Mutex
+	tokenKeys map[string][]byte
+	owners    map[pusb.Device]string // token IDs of the devices tokens added
+
+	clockMu sync.Mutex
+	clock   device.Clock
+	epoch   time.Time // zero point of MonoNow
+
+	readOnly atomic.Bool
+
+	served       chan struct{} // closed when serve returns; nil before Start
+	connCtx      context.Context
+	cancelConns  context.CancelFunc // ends connCtx, the parent of every connection's context
+	conns        util.ConnSet
+	shuttingDown atomic.Bool
+
+	m apiMetrics
 }
 
 // New creates a new ApiServer bound to a server.Server instance.
 func New(s *usb.Server, addr string, config ServerConfig, logger *slog.Logger) *Server {
 	cfg := config
 	a := &Server{
-		usbs:   s,
-		addr:   addr,
-		logger: logger,
-		config: &cfg,
+		usbs:       s,
+		addr:       addr,
+		logger:     logger,
+		config:     &cfg,
+		streams:    make(map[pusb.Device]*streamConn),
+		mixes:      make(map[pusb.Device]*mixSession),
+		recordings: make(map[pusb.Device]*recording),
+		macros:     make(map[pusb.Device]*device.Macro),
+		strict:     make(map[pusb.Device]bool),
+		templates:  make(map[string]DeviceTemplate),
+		specs:      make(map[pusb.Device]apitypes.DeviceCreateRequest),
+		tokenKeys:  make(map[string][]byte),
+		owners:     make(map[pusb.Device]string),
+		clock:      device.SystemClock,
+		epoch:      device.SystemClock.Now(),
 	}
+	a.connCtx, a.cancelConns = context.WithCancel(context.Background())
 	a.router = NewRouter()
+	a.router.Use(a.guardRoutes)
+	a.readOnly.Store(cfg.ReadOnly)
+	a.registerMetrics()
 	return a
 }
 
@@ -63,28 +131,55 @@ func (s *Server) Addr() string {
 }
 
 // Start listens on the configured address and serves incoming API commands.
+// With a TLS certificate configured, every connection runs TLS first; the
+// password handshake, if any, follows inside it.
 func (s *Server) Start() error {
+	tlsCfg, err := s.tlsConfig()
+	if err != nil {
+		return err
+	}
 	ln, err := net.Listen("tcp", s.addr)
 	if err != nil {
 		return err
 	}
+	if tlsCfg != nil {
+		ln = tls.NewListener(ln, tlsCfg)
+	}
 	s.ln = ln
 
 	s.addr = ln.Addr().String()
 	s.config.Addr = s.addr
-	s.logger.Info("API listening", "addr", s.addr)
+	s.logger.Info("API listening", "addr", s.addr, "tls", tlsCfg != nil)
+	s.served = make(chan struct{})
 	go s.serve()
 	return nil
 }
 
-// Close stops the API server.
+// tlsConfig loads the configured certificate; it is nil without one.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	if s.config.TLSCert == "" && s.config.TLSKey == "" {
+		return nil, nil
+	}
+	if s.config.TLSCert == "" || s.config.TLSKey == "" {
+		return nil, errors.New("TLS needs both a certificate and a key")
+	}
+	cert, err := tls.LoadX509KeyPair(s.config.TLSCert, s.config.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// Close stops the API server and the updates of its state file.
 func (s *Server) Close() {
+	s.stopPersisting()
 	if s.ln != nil {
 		_ = s.ln.Close()
 	}
 }
 
 func (s *Server) serve() {
+	defer close(s.served)
 	for {
 		c, err := s.ln.Accept()
 		if err != nil {
@@ -95,17 +190,22 @@ func (s *Server) serve() {
 			s.logger.Info("API accept error", "error", err)
 			return
 		}
-		if tcpConn, ok := c.(*net.TCPConn); ok {
+		nc := c
+		if tlsConn, ok := c.(*tls.Conn); ok {
+			nc = tlsConn.NetConn()
+		}
+		if tcpConn, ok := nc.(*net.TCPConn); ok {
 			if err := tcpConn.SetNoDelay(true); err != nil {
 				s.logger.Warn("failed to set TCP_NODELAY", "error", err)
 			}
 		}
-		go s.handleConn(c)
+		s.conns.Serve(c, s.handleConn)
 	}
 }
 
 func (s *Server) writeError(w io.Writer, err error) {
 	apiErr := apierror.WrapError(err)
+	s.m.errors.With(strconv.Itoa(apiErr.Status)).Inc()
 	problemJSON, _ := json.Marshal(apiErr)
 	fmt.Fprintf(w, "%s\n", string(problemJSON))
 }
@@ -118,15 +218,35 @@ func (s *Server) writeOK(w io.Writer, rest string) {
 	}
 }
 
+// bufferedConn reads through the request reader, so stream input the client
+// sent right behind the stream request is not lost.
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// frameWriter sends each response line as one v2 frame, without the line terminator.
+type frameWriter struct{ w io.Writer }
+
+func (f *frameWriter) Write(p []byte) (int, error) {
+	if err := frame.Write(f.w, bytes.TrimSuffix(p, []byte("\n"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 func (s *Server) handleConn(conn net.Conn) {
 	defer conn.Close()
+	raw := conn
 
-	connCtx, connCancel := context.WithCancel(context.Background())
+	connCtx, connCancel := context.WithCancel(s.connCtx)
 	defer connCancel()
 
 	connLogger := s.logger.With("remote", conn.RemoteAddr().String())
 	r := bufio.NewReader(conn)
-	w := conn
+	var w io.Writer = conn
 
 	isAuth, err := auth.IsAuthHandshake(r)
 	if err != nil {
@@ -134,21 +254,78 @@ func (s *Server) handleConn(conn net.Conn) {
 		// continue as unauthenticated
 	}
 
-	if !isAuth && s.requiresAuth(conn.RemoteAddr()) {
+	isResume, isToken := false, false
+	if !isAuth && err == nil {
+		isResume, _ = auth.IsResumeHandshake(r)
+		isToken, _ = auth.IsTokenHandshake(r)
+	}
+	var token *auth.Token
+
+	if !isAuth && !isResume && !isToken && s.requiresAuth(conn.RemoteAddr()) {
 		connLogger.Error("authentication required")
 		s.writeError(w, apierror.ErrUnauthorized("authentication required"))
 		return
 	}
 
-	if isAuth {
+	if isResume {
+		connLogger.Debug("Detected resume attempt")
+		tickets, err := s.resumeTickets()
+		if err != nil {
+			connLogger.Info("resume refused", "error", err)
+			s.writeError(w, apierror.ErrUnauthorized(err.Error()))
+			return
+		}
+		secret, clientNonce, serverNonce, err := tickets.Accept(r, w)
+		if err != nil {
+			connLogger.Info("resume handshake failed", "error", err)
+			var apiErr apitypes.ApiError
+			if errors.As(err, &apiErr) {
+				s.writeError(w, err)
+			}
+			return
+		}
+		secConn, err := auth.WrapConn(conn, auth.DeriveSessionKey(secret, serverNonce, clientNonce))
+		if err != nil {
+			connLogger.Error("wrap secure conn failed", "error", err)
+			return
+		}
+		conn = secConn
+		r = bufio.NewReader(conn)
+		w = conn
+
+		connLogger.Debug("resumed authenticated connection")
+	} else if isToken {
+		connLogger.Debug("Detected token auth attempt")
+		id, key, clientNonce, serverNonce, err := auth.AcceptToken(r, w, s.tokenKey, s.handshakeIdentity(connLogger))
+		if err != nil {
+			connLogger.Info("token handshake failed", "error", err)
+			var apiErr apitypes.ApiError
+			if errors.As(err, &apiErr) {
+				s.writeError(w, err)
+			}
+			return
+		}
+		secConn, err := auth.WrapConn(conn, auth.DeriveSessionKey(key, serverNonce, clientNonce))
+		if err != nil {
+			connLogger.Error("wrap secure conn failed", "error", err)
+			return
+		}
+		conn = secConn
+		r = bufio.NewReader(conn)
+		w = conn
+		token = s.token(id)
+		connLogger = connLogger.With("token", id)
+
+		connLogger.Debug("token authenticated connection established")
+	} else if isAuth {
 		connLogger.Debug("Detected auth attempt")
-		key, err := auth.DeriveKey(s.config.Password)
+		key, err := auth.DeriveKey(s.password())
 		if err != nil {
 			connLogger.Error("derive key failed", "error", err)
 			return
 		}
 
-		clientNonce, serverNonce, err := auth.HandleAuthHandshake(r, w, key, false)
+		key, clientNonce, serverNonce, err := auth.AcceptAuth(r, w, key, s.handshakeIdentity(connLogger))
 		if err != nil {
 			var apiErr apitypes.ApiError
 			if errors.As(err, &apiErr) {
@@ -175,18 +352,37 @@ func (s *Server) handleConn(conn net.Conn) {
 		connLogger.Debug("continuing unauthenticated connection")
 	}
 
-	// Read until null terminator
-	reqData, err := r.ReadString('\x00')
-	if err != nil {
-		if err == io.EOF {
-			connLogger.Error("api incomplete request (no null terminator)")
-		} else {
+	var reqData string
+	if frame.IsV2(r) {
+		// Length-prefixed framing; responses are framed as well.
+		if err := frame.ReadMagic(r); err != nil {
 			connLogger.Error("read api data", "error", err)
+			return
 		}
-		return
+		w = &frameWriter{w: w}
+		body, err := frame.Read(r)
+		if err != nil {
+			connLogger.Error("read api frame", "error", err)
+			if errors.Is(err, frame.ErrTooLarge) {
+				s.writeError(w, apierror.ErrBadRequest(fmt.Sprintf("request exceeds %d bytes", frame.MaxSize)))
+			}
+			return
+		}
+		reqData = string(body)
+	} else {
+		// Read until null terminator
+		reqData, err = r.ReadString('\x00')
+		if err != nil {
+			if err == io.EOF {
+				connLogger.Error("api incomplete request (no null terminator)")
+			} else {
+				connLogger.Error("read api data", "error", err)
+			}
+			return
+		}
+		// Remove null terminator
+		reqData = strings.TrimSuffix(reqData, "\x00")
 	}
-	// Remove null terminator
-	reqData = strings.TrimSuffix(reqData, "\x00")
 
 	if reqData == "" {
 		connLogger.Error("api empty command")
@@ -216,8 +412,20 @@ func (s *Server) handleConn(conn net.Conn) {
 	path = strings.ToLower(path)
 	connLogger.Info("api cmd", "path", path)
 
+	if path == eventsPath {
+		s.serveEvents(connCtx, r, w, connLogger)
+		return
+	}
+
 	if h, params := s.router.Match(path); h != nil {
-		req := &Request{Ctx: connCtx, Params: params, Payload: payload}
+		req := &Request{
+			Ctx:           connCtx,
+			Params:        params,
+			Payload:       payload,
+			Local:         s.isLocalHostClient(raw.RemoteAddr()),
+			Authenticated: isAuth || isResume || isToken,
+			Token:         token,
+		}
 		res := &Response{}
 		if err := h(req, res, connLogger); err != nil {
 			connLogger.Error("api handler error", "path", path, "error", err)
@@ -254,7 +462,7 @@ func (s *Server) handleConn(conn net.Conn) {
 		var devCtx context.Context
 		metas := bus.GetAllDeviceMetas()
 		for _, meta := range metas {
-			if fmt.Sprintf("%d", meta.Meta.DevId) == devIDStr {
+			if virtualbus.DeviceID(&meta.Meta) == devIDStr {
 				dev = meta.Dev
 				devCtx = bus.GetDeviceContext(dev)
 				break
@@ -264,20 +472,160 @@ func (s *Server) handleConn(conn net.Conn) {
 			s.writeError(w, apierror.ErrNotFound(fmt.Sprintf("device %s not found on bus %d", devIDStr, busID)))
 			return
 		}
+		if token != nil {
+			if err := s.checkStream(dev, token); err != nil {
+				s.writeError(w, err)
+				return
+			}
+		}
+
+		opts, err := parseStreamOptions(payload)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		if token != nil {
+			opts.prio = capPriority(opts.prio, token)
+		}
+		_, isAlias := s.usbs.AliasSourceOf(dev)
+		mixer := bus.StreamMixer(dev)
+		source := raw.RemoteAddr().String()
+		switch {
+		case mixer == nil && opts.claim != nil:
+			s.writeError(w, apierror.ErrBadRequest("claiming fields requires the mixed stream policy"))
+			return
+		case mixer != nil && opts.claim == nil:
+			s.writeError(w, apierror.ErrBadRequest("streams of a mixed device must claim fields"))
+			return
+		}
+		v := s.inputValidator(dev, connLogger)
+		if isAlias {
+			if opts.delta != 0 || opts.events != 0 || opts.seq {
+				s.writeError(w, apierror.ErrBadRequest("streams of aliased devices are read-only"))
+				return
+			}
+			v = nil
+			conn = &aliasConn{Conn: conn, r: r}
+		} else if opts.events != 0 {
+			folder, err := eventFolder(dev)
+			if err != nil {
+				s.writeError(w, err)
+				return
+			}
+			conn = newEventConn(conn, r, folder, connLogger)
+		} else if opts.delta != 0 {
+			layout, err := deltaLayout(dev)
+			if err != nil {
+				s.writeError(w, err)
+				return
+			}
+			conn = newDeltaConn(conn, r, layout, v)
+		} else {
+			var in io.Reader = r
+			if opts.seq {
+				layout, err := seqLayout(dev)
+				if err != nil {
+					s.writeError(w, err)
+					return
+				}
+				in = newSeqReader(r, layout.Size(), s.usbs.LatencyTracker(devCtx, dev))
+			}
+			if v != nil {
+				conn = newValidateConn(conn, in, v)
+			} else {
+				conn = &bufferedConn{Conn: conn, r: in}
+			}
+		}
+
+		if mixer != nil {
+			if err := mixer.Claim(source, opts.claim); errors.Is(err, device.ErrFieldClaimed) {
+				s.writeError(w, apierror.ErrConflict(err.Error()))
+				return
+			} else if err != nil {
+				s.writeError(w, apierror.ErrBadRequest(err.Error()))
+				return
+			}
+		}
+
+		// Everything that can refuse the stream has run; device data and
+		// feedback follow the acknowledgement.
+		if opts.prio != usb.PriorityNormal {
+			defer s.usbs.SetPriority(dev, opts.prio)()
+		}
+		if opts.ack {
+			s.writeOK(w, "{}")
+		}
 
 		connTimer := device.GetConnTimer(devCtx)
 		if connTimer != nil {
 			connTimer.Stop()
 		}
 
+		sc := s.trackStream(dev, fmt.Sprintf("%d-%s", busID, devIDStr), conn)
+		defer s.untrackStream(dev, sc)
+		if opts.status {
+			sc.framed = true
+			statusCtx, stopStatus := context.WithCancel(devCtx)
+			statusDone := make(chan struct{})
+			go func() {
+				defer close(statusDone)
+				pushStatus(statusCtx, sc, bus, devIDStr, dev, connLogger)
+			}()
+			defer func() { stopStatus(); <-statusDone }()
+		}
+
 		// Stream handler takes ownership of connection
-		if err := sh(conn, &dev, connLogger); err != nil {
-			connLogger.Error("api stream handler error", "path", path, "error", err)
+		var handlerConn net.Conn = sc
+		if opts.flush {
+			handlerConn = flushConn{sc}
+		}
+		var streamErr error
+		lastMixed := true
+		if mixer != nil {
+			lastMixed, streamErr = s.mixStream(dev, mixer, source, handlerConn, sc, sh, connLogger)
+		} else {
+			streamErr = sh(handlerConn, &dev, connLogger)
+		}
+		shutdown := s.shuttingDown.Load()
+		if shutdown {
+			connLogger.Info("api stream ended for shutdown", "path", path)
+		} else if streamErr != nil {
+			connLogger.Error("api stream handler error", "path", path, "error", streamErr)
+		}
+		// A flush would reset the connection on the cut-short read,
+		// discarding the shutdown notice.
+		if opts.flush && !shutdown {
+			finishFlush(raw, sc, dev, streamErr, connLogger)
+		}
+		if v != nil {
+			v.logStats()
 		}
 		connLogger.Info("api stream end", "path", path)
 
+		// The devices stay for the USB server's Shutdown to remove. The
+		// disconnect policy applies once the last stream of a mixed device
+		// is gone.
+		if shutdown || !lastMixed {
+			return
+		}
+
+		if !isAlias && devCtx.Err() == nil {
+			switch bus.StreamClosed(dev) {
+			case device.DisconnectNeutralState:
+				connLogger.Info("stream client gone: reset device to neutral state", "busID", busID, "deviceID", devIDStr)
+			case device.DisconnectDetach:
+				if err := s.usbs.RemoveDeviceByID(uint32(busID), devIDStr); err != nil {
+					connLogger.Error("stream client gone: failed to detach device", "busID", busID, "deviceID", devIDStr, "error", err)
+				} else {
+					connLogger.Info("stream client gone: detached device", "busID", busID, "deviceID", devIDStr)
+				}
+				return
+			}
+		}
+
+		// Aliases live as long as their original.
 		connTimer = device.GetConnTimer(devCtx)
-		if connTimer != nil {
+		if connTimer != nil && !isAlias {
 			connTimer.Reset(s.config.DeviceHandlerConnectTimeout)
 			go func() {
 				select {
@@ -287,7 +635,7 @@ func (s *Server) handleConn(conn net.Conn) {
 				case <-connTimer.C:
 					exportMeta := device.GetDeviceMeta(devCtx)
 					if exportMeta != nil {
-						deviceIDStr := fmt.Sprintf("%d", exportMeta.DevId)
+						deviceIDStr := virtualbus.DeviceID(exportMeta)
 						if err := bus.RemoveDeviceByID(deviceIDStr); err != nil {
 							connLogger.Error("disconnect timeout: failed to remove device", "busID", busID, "deviceID", deviceIDStr, "error", err)
 						} else {
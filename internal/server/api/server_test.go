@@ -2,10 +2,13 @@ package api_test
 
 import (
 	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -14,6 +17,7 @@ import (
 
 	viiperTesting "github.com/Alia5/VIIPER/_testing"
 	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
 	"github.com/Alia5/VIIPER/device"
 	"github.com/Alia5/VIIPER/device/xbox360"
 	th "github.com/Alia5/VIIPER/internal/_testing"
@@ -21,6 +25,7 @@ import (
 	_ "github.com/Alia5/VIIPER/internal/registry" // Register devices
 	"github.com/Alia5/VIIPER/internal/server/api"
 	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/internal/server/api/frame"
 	"github.com/Alia5/VIIPER/internal/server/api/handler"
 	srvusb "github.com/Alia5/VIIPER/internal/server/usb"
 	pusb "github.com/Alia5/VIIPER/usb"
@@ -264,3 +269,370 @@ func TestAPIServer_WrappedConn(t *testing.T) {
 	}
 
 }
+
+func TestAPIServer_FramedV2(t *testing.T) {
+	addr, _, done := th.StartAPIServer(t, func(r *api.Router, _ *srvusb.Server, _ *api.Server) {
+		r.Register("echo", func(req *api.Request, res *api.Response, _ *slog.Logger) error {
+			b, err := json.Marshal(map[string]string{"payload": req.Payload})
+			res.JSON = string(b)
+			return err
+		})
+	})
+	defer done()
+
+	tests := []struct {
+		name    string
+		version int
+		payload string
+		want    string
+	}{
+		{name: "v1 plain", version: 1, payload: "hello", want: "hello"},
+		{name: "v2 plain", version: 2, payload: "hello", want: "hello"},
+		{name: "v2 null bytes", version: 2, payload: "a\x00b\x00\nc", want: "a\x00b\x00\nc"},
+		{name: "v1 null bytes truncate", version: 1, payload: "a\x00b", want: "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := apiclient.NewTransportWithConfig(addr, &apiclient.Config{
+				DialTimeout:     time.Second,
+				ReadTimeout:     time.Second,
+				ProtocolVersion: tt.version,
+			})
+			raw, err := tr.Do("echo", tt.payload, nil)
+			require.NoError(t, err)
+			var got map[string]string
+			require.NoError(t, json.Unmarshal([]byte(raw), &got))
+			assert.Equal(t, tt.want, got["payload"])
+		})
+	}
+
+	t.Run("v2 oversized frame", func(t *testing.T) {
+		c, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer c.Close()
+		var hdr [4]byte
+		binary.BigEndian.PutUint32(hdr[:], frame.MaxSize+1)
+		_, err = c.Write(append([]byte(frame.Magic), hdr[:]...))
+		require.NoError(t, err)
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		body, err := frame.Read(c)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), `"status":400`)
+	})
+}
+
+func TestAPIServer_StreamAck(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+	r := s.ApiServer.Router()
+	r.Register("features", handler.Features())
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90123)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	defer func() { _ = s.UsbServer.RemoveBus(90123) }()
+
+	for _, version := range []int{1, 2} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			client := apiclient.NewWithConfig(s.ApiServer.Addr(), &apiclient.Config{
+				DialTimeout:     time.Second,
+				ReadTimeout:     time.Second,
+				ProtocolVersion: version,
+			})
+			ctx := context.Background()
+
+			for _, busID := range []uint32{90123, 90124} {
+				_, err := client.OpenStream(ctx, busID, "99")
+				var apiErr *apitypes.ApiError
+				require.ErrorAs(t, err, &apiErr)
+				assert.Equal(t, 404, apiErr.Status)
+			}
+
+			stream, dev, err := client.AddDeviceAndConnect(ctx, 90123, "xbox360", nil)
+			require.NoError(t, err)
+			defer stream.Close()
+			var xdev *xbox360.Xbox360
+			for _, m := range b.GetAllDeviceMetas() {
+				if fmt.Sprint(m.Meta.DevId) == dev.DevId {
+					xdev = m.Dev.(*xbox360.Xbox360)
+				}
+			}
+			require.NotNil(t, xdev)
+
+			// The acknowledgement is not part of the stream.
+			want := (&xbox360.InputState{Buttons: xbox360.ButtonB}).BuildReport()
+			require.NoError(t, stream.WriteBinary(&xbox360.InputState{Buttons: xbox360.ButtonB}))
+			assert.Eventually(t, func() bool {
+				got := xdev.HandleTransfer(1, usbip.DirIn, nil)
+				return string(got) == string(want)
+			}, time.Second, 5*time.Millisecond)
+		})
+	}
+}
+
+// resumeCounter is a log handler counting the connections the API server
+// resumed from a session ticket.
+type resumeCounter struct{ n atomic.Int32 }
+
+func (h *resumeCounter) Enabled(context.Context, slog.Level) bool { return true }
+func (h *resumeCounter) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *resumeCounter) WithGroup(string) slog.Handler            { return h }
+func (h *resumeCounter) Handle(_ context.Context, r slog.Record) error {
+	if r.Message == "resumed authenticated connection" {
+		h.n.Add(1)
+	}
+	return nil
+}
+
+func TestAPIServer_SessionResumption(t *testing.T) {
+	usbSrv := srvusb.New(srvusb.ServerConfig{Addr: "127.0.0.1:0"}, slog.Default(), log.NewRaw(nil))
+	resumed := &resumeCounter{}
+	cfg := api.ServerConfig{Password: "resume-pass-1", RequireLocalHostAuth: true}
+	apiSrv := api.New(usbSrv, "127.0.0.1:0", cfg, slog.New(resumed))
+	r := apiSrv.Router()
+	r.Register("ping", handler.Ping(apiSrv))
+	r.Register("auth/ticket", handler.AuthTicket(apiSrv))
+	require.NoError(t, apiSrv.Start())
+	defer apiSrv.Close()
+
+	// The first request runs the full handshake and fetches a ticket in
+	// the background; the following ones resume.
+	client := apiclient.NewWithPassword(apiSrv.Addr(), "resume-pass-1")
+	waitResumed := func(after int32) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			_, err := client.Ping()
+			return err == nil && resumed.n.Load() > after
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+	waitResumed(0)
+
+	// Revoked tickets fall back to the full handshake, after which the
+	// client fetches a new ticket.
+	apiSrv.RevokeTickets()
+	n := resumed.n.Load()
+	_, err := client.Ping()
+	require.NoError(t, err)
+	assert.Equal(t, n, resumed.n.Load())
+	waitResumed(n)
+
+	// A new password invalidates the tickets of the old one.
+	apiSrv.SetPassword("resume-pass-2")
+	_, err = client.Ping()
+	require.ErrorContains(t, err, "401 Unauthorized: invalid password")
+	_, err = apiclient.NewWithPassword(apiSrv.Addr(), "resume-pass-2").Ping()
+	require.NoError(t, err)
+
+	// Without resumption the client keeps using the full handshake.
+	n = resumed.n.Load()
+	noResume := apiclient.NewWithConfig(apiSrv.Addr(), &apiclient.Config{
+		DialTimeout:   time.Second,
+		ReadTimeout:   time.Second,
+		WriteTimeout:  time.Second,
+		Password:      "resume-pass-2",
+		DisableResume: true,
+	})
+	for range 3 {
+		_, err = noResume.Ping()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, n, resumed.n.Load())
+}
+
+func TestAPIServer_DisconnectPolicy(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90136)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	defer func() { _ = s.UsbServer.RemoveBus(90136) }()
+
+	client := apiclient.New(s.ApiServer.Addr())
+	pressed := string((&xbox360.InputState{Buttons: xbox360.ButtonA}).BuildReport())
+	neutral := string((&xbox360.InputState{}).BuildReport())
+	report := func(x *xbox360.Xbox360) string {
+		got := x.HandleTransfer(1, usbip.DirIn, nil)
+		return string(got)
+	}
+
+	// pressAndLeave adds an attached xbox360 with disconnect policy p, holds
+	// A and closes the stream.
+	pressAndLeave := func(t *testing.T, p device.DisconnectPolicy) (*apitypes.Device, *xbox360.Xbox360, net.Conn) {
+		t.Helper()
+		stream, dev, err := client.AddDeviceAndConnect(context.Background(), 90136, "xbox360", &device.CreateOptions{Disconnect: &p})
+		require.NoError(t, err)
+		var xdev *xbox360.Xbox360
+		for _, m := range b.GetAllDeviceMetas() {
+			if fmt.Sprint(m.Meta.DevId) == dev.DevId {
+				xdev = m.Dev.(*xbox360.Xbox360)
+				assert.Equal(t, p, m.Disconnect)
+			}
+		}
+		require.NotNil(t, xdev)
+		imp, err := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr()).AttachDevice(fmt.Sprintf("90136-%s", dev.DevId))
+		require.NoError(t, err)
+		t.Cleanup(func() { imp.Conn.Close() })
+
+		require.NoError(t, stream.WriteBinary(&xbox360.InputState{Buttons: xbox360.ButtonA}))
+		require.Eventually(t, func() bool { return report(xdev) == pressed }, time.Second, 5*time.Millisecond)
+		require.NoError(t, stream.Close())
+		return dev, xdev, imp.Conn
+	}
+
+	t.Run("hold-last-state", func(t *testing.T) {
+		dev, xdev, _ := pressAndLeave(t, device.DisconnectHoldLastState)
+		assert.Empty(t, dev.Disconnect)
+		assert.Never(t, func() bool { return report(xdev) != pressed }, 300*time.Millisecond, 10*time.Millisecond)
+	})
+
+	t.Run("neutral-state", func(t *testing.T) {
+		dev, xdev, _ := pressAndLeave(t, device.DisconnectNeutralState)
+		assert.Equal(t, "neutral-state", dev.Disconnect)
+		assert.Eventually(t, func() bool { return report(xdev) == neutral }, time.Second, 5*time.Millisecond)
+		assert.NotNil(t, b.GetDeviceContext(xdev), "device stays on the bus")
+	})
+
+	t.Run("detach", func(t *testing.T) {
+		dev, xdev, conn := pressAndLeave(t, device.DisconnectDetach)
+		assert.Equal(t, "detach", dev.Disconnect)
+		assert.Eventually(t, func() bool { return b.GetDeviceContext(xdev) == nil }, time.Second, 5*time.Millisecond)
+
+		// The USB/IP connection closes without the host sending another URB.
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := conn.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF)
+
+		exported, err := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr()).ListDevices()
+		require.NoError(t, err)
+		for _, d := range exported {
+			assert.NotEqual(t, fmt.Sprintf("90136-%s", dev.DevId), d.BusID)
+		}
+	})
+}
+
+func TestAPIServer_StreamStatus(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+	s.ApiServer.Router().RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90144)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	defer func() { _ = s.UsbServer.RemoveBus(90144) }()
+	dev, err := xbox360.New(nil)
+	require.NoError(t, err)
+	_, err = b.Add(dev)
+	require.NoError(t, err)
+
+	c, err := net.Dial("tcp", s.ApiServer.Addr())
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = fmt.Fprintf(c, "bus/90144/1 status=1\x00")
+	require.NoError(t, err)
+
+	next := func() (byte, []byte) {
+		t.Helper()
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		var hdr [3]byte
+		_, err := io.ReadFull(c, hdr[:])
+		require.NoError(t, err)
+		body := make([]byte, binary.LittleEndian.Uint16(hdr[1:]))
+		_, err = io.ReadFull(c, body)
+		require.NoError(t, err)
+		return hdr[0], body
+	}
+	nextStatus := func() apitypes.DeviceStatusResponse {
+		t.Helper()
+		kind, body := next()
+		require.Equal(t, byte(0x01), kind)
+		var st apitypes.DeviceStatusResponse
+		require.NoError(t, json.Unmarshal(body, &st))
+		return st
+	}
+
+	assert.Equal(t, apitypes.DeviceStatusResponse{BusID: 90144, DevId: "1"}, nextStatus(), "the current status comes first")
+
+	imp, err := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr()).AttachDevice("90144-1")
+	require.NoError(t, err)
+	st := nextStatus()
+	assert.True(t, st.Attached)
+	assert.Equal(t, imp.Conn.LocalAddr().String(), st.RemoteAddr)
+
+	require.NoError(t, s.ApiServer.WriteFeedback(dev, []byte{1, 2}))
+	kind, body := next()
+	assert.Equal(t, byte(0x00), kind)
+	assert.Equal(t, []byte{1, 2}, body, "feedback is framed")
+
+	require.NoError(t, imp.Conn.Close())
+	assert.False(t, nextStatus().Attached)
+}
+
+func TestAPIServer_Events(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+	r := s.ApiServer.Router()
+	r.Register("bus/create", handler.BusCreate(s.UsbServer))
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("bus/{id}/remove", handler.BusDeviceRemove(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+	defer func() { _ = s.UsbServer.RemoveBus(90148) }()
+
+	for _, version := range []int{1, 2} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			client := apiclient.NewWithConfig(s.ApiServer.Addr(), &apiclient.Config{ProtocolVersion: version})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			events, errs := client.SubscribeEvents(ctx)
+			var seq uint64
+			next := func() apitypes.Event {
+				t.Helper()
+				select {
+				case e := <-events:
+					_, err := time.Parse(time.RFC3339Nano, e.OccurredAt)
+					assert.NoError(t, err)
+					e.OccurredAt = ""
+					if seq != 0 {
+						assert.Equal(t, seq+1, e.Seq)
+					}
+					seq, e.Seq = e.Seq, 0
+					return e
+				case err := <-errs:
+					t.Fatalf("subscription failed: %v", err)
+				case <-time.After(time.Second):
+					t.Fatal("no event")
+				}
+				return apitypes.Event{}
+			}
+
+			if version == 1 {
+				_, err := client.BusCreate(90148)
+				require.NoError(t, err)
+				assert.Equal(t, apitypes.Event{Type: "BusCreated", BusID: 90148}, next())
+			}
+			dev, err := client.DeviceAdd(90148, "xbox360", nil)
+			require.NoError(t, err)
+			assert.Equal(t, apitypes.Event{Type: "DeviceAdded", BusID: 90148, DevId: dev.DevId}, next())
+			_, err = client.DeviceRemove(90148, dev.DevId)
+			require.NoError(t, err)
+			assert.Equal(t, apitypes.Event{Type: "DeviceRemoved", BusID: 90148, DevId: dev.DevId}, next())
+
+			cancel()
+			for range events {
+			}
+			assert.NoError(t, <-errs, "cancelling is not an error")
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+)
+
+// ShutdownNotice is the detail of the error object a device stream receives
+// as its last message when the server shuts down.
+const ShutdownNotice = "server-shutting-down"
+
+// shutdownWriteTimeout bounds the write of the shutdown notice to a client
+// that does not read.
+const shutdownWriteTimeout = time.Second
+
+// Shutdown stops the API server gracefully. It stops accepting connections
+// and the updates of the state file, sends every device stream the shutdown
+// notice before ending it, ends event subscriptions and the contexts of
+// running requests, and waits for the connections to finish. Once ctx is
+// done, it closes the connections still open and returns ctx.Err().
+//
+// Streams ended by Shutdown leave their devices in place; the USB server's
+// Shutdown removes them.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+	s.Close()
+	if s.served != nil {
+		<-s.served
+	}
+	s.cancelConns()
+
+	s.streamsMu.Lock()
+	streams := make([]*streamConn, 0, len(s.streams))
+	for _, sc := range s.streams {
+		streams = append(streams, sc)
+	}
+	s.streamsMu.Unlock()
+	for _, sc := range streams {
+		sc.notifyShutdown()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.conns.CloseAll()
+		s.logger.Warn("API shutdown timed out; closed remaining connections")
+		return ctx.Err()
+	}
+}
+
+// notifyShutdown sends the shutdown notice, as a streamMsgShutdown message
+// on a framed stream and as an error line otherwise, and cuts short the
+// handler's read of the next input so the stream ends.
+func (c *streamConn) notifyShutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return
+	}
+	c.closing = true
+
+	body, _ := json.Marshal(apierror.ErrUnavailable(ShutdownNotice))
+	var msg []byte
+	if c.framed {
+		msg = frameStreamMessage(streamMsgShutdown, body)
+	} else {
+		msg = append(body, '\n')
+	}
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(shutdownWriteTimeout))
+	if n, _ := c.Conn.Write(msg); n > 0 {
+		c.out.Add(uint64(n))
+	}
+	_ = c.Conn.SetReadDeadline(time.Now())
+}
@@ -0,0 +1,165 @@
+package api_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	"github.com/Alia5/VIIPER/virtualbus"
+
+	_ "github.com/Alia5/VIIPER/internal/registry"
+)
+
+func TestShutdownNotifiesStreams(t *testing.T) {
+	// readStatusMessage reads a message of a status=1 stream.
+	readStatusMessage := func(t *testing.T, r *bufio.Reader) (byte, []byte) {
+		var hdr [3]byte
+		_, err := io.ReadFull(r, hdr[:])
+		require.NoError(t, err)
+		body := make([]byte, binary.LittleEndian.Uint16(hdr[1:3]))
+		_, err = io.ReadFull(r, body)
+		require.NoError(t, err)
+		return hdr[0], body
+	}
+
+	type testCase struct {
+		name    string
+		options string
+		// started reads what shows the stream is up.
+		started func(t *testing.T, r *bufio.Reader)
+		// notice reads up to and including the shutdown notice.
+		notice func(t *testing.T, r *bufio.Reader) []byte
+	}
+
+	cases := []testCase{
+		{
+			name:    "raw stream",
+			options: "ack=1",
+			started: func(t *testing.T, r *bufio.Reader) {
+				line, err := r.ReadString('\n')
+				require.NoError(t, err)
+				require.Equal(t, "{}\n", line)
+			},
+			notice: func(t *testing.T, r *bufio.Reader) []byte {
+				line, err := r.ReadBytes('\n')
+				require.NoError(t, err)
+				return line
+			},
+		},
+		{
+			name:    "status messages",
+			options: "status=1",
+			started: func(t *testing.T, r *bufio.Reader) {
+				kind, _ := readStatusMessage(t, r)
+				require.Equal(t, byte(0x01), kind)
+			},
+			notice: func(t *testing.T, r *bufio.Reader) []byte {
+				for {
+					kind, body := readStatusMessage(t, r)
+					if kind == 0x02 {
+						return body
+					}
+					require.Equal(t, byte(0x01), kind, "only status messages before the notice")
+				}
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			checkLeaks := viiperTesting.CheckGoroutines(t)
+
+			s := viiperTesting.NewTestServer(t)
+			r := s.ApiServer.Router()
+			r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+			r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+			require.NoError(t, s.ApiServer.Start())
+
+			busID := uint32(90178 + i)
+			b, err := virtualbus.NewWithBusId(busID)
+			require.NoError(t, err)
+			require.NoError(t, s.UsbServer.AddBus(b))
+			dev, err := apiclient.New(s.ApiServer.Addr()).DeviceAdd(busID, "xbox360", nil)
+			require.NoError(t, err)
+
+			conn, err := net.Dial("tcp", s.ApiServer.Addr())
+			require.NoError(t, err)
+			defer conn.Close()
+			_, err = fmt.Fprintf(conn, "bus/%d/%s %s\x00", busID, dev.DevId, tc.options)
+			require.NoError(t, err)
+			_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+			rd := bufio.NewReader(conn)
+			tc.started(t, rd)
+
+			shutdownDone := make(chan error, 1)
+			go func() { shutdownDone <- shutdown(s) }()
+
+			var apiErr apitypes.ApiError
+			notice := tc.notice(t, rd)
+			require.NoError(t, json.Unmarshal(notice, &apiErr), "notice %q", notice)
+			assert.Equal(t, 503, apiErr.Status)
+			assert.Equal(t, api.ShutdownNotice, apiErr.Detail)
+			assert.ErrorIs(t, &apiErr, apiclient.ErrUnavailable)
+
+			rest, err := io.ReadAll(rd)
+			assert.NoError(t, err, "the connection closes cleanly after the notice")
+			assert.Empty(t, rest, "nothing follows the notice")
+
+			require.NoError(t, <-shutdownDone)
+			assert.Nil(t, s.UsbServer.GetBus(busID), "shutdown removes the buses")
+			_, err = net.Dial("tcp", s.ApiServer.Addr())
+			assert.Error(t, err, "no new connections")
+
+			conn.Close()
+			checkLeaks()
+		})
+	}
+}
+
+func TestShutdownClosesConnectionsAtDeadline(t *testing.T) {
+	checkLeaks := viiperTesting.CheckGoroutines(t)
+
+	s := viiperTesting.NewTestServer(t)
+	require.NoError(t, s.ApiServer.Start())
+
+	// A client that connects and never sends its request.
+	conn, err := net.Dial("tcp", s.ApiServer.Addr())
+	require.NoError(t, err)
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.ApiServer.Shutdown(ctx), context.DeadlineExceeded)
+	require.NoError(t, s.UsbServer.Shutdown(context.Background()))
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF, "the idle connection is closed")
+
+	checkLeaks()
+}
+
+// shutdown shuts s down the way the server command does.
+func shutdown(s *viiperTesting.MockServer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.ApiServer.Shutdown(ctx); err != nil {
+		return err
+	}
+	return s.UsbServer.Shutdown(ctx)
+}
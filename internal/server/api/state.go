@@ -0,0 +1,424 @@
+package api
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	pusb "github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// stateVersion is the format of the state file. Version 1 files, which have
+// no checksum, are still read.
+const stateVersion = 2
+
+// snapshotLayout is the UTC timestamp appended to the name of the state file
+// for its snapshots. Names sort in the order the snapshots were taken.
+const snapshotLayout = "20060102T150405.000000000Z"
+
+// ErrNoStateFile is returned by the snapshot methods of a server that does not
+// persist its state.
+var ErrNoStateFile = errors.New("no state file")
+
+// State is the bus and device configuration kept in the state file, see
+// PersistState. Input states are not part of it.
+type State struct {
+	Version int        `json:"version"`
+	Buses   []BusState `json:"buses"`
+}
+
+// BusState is a persisted bus.
+type BusState struct {
+	BusID       uint32        `json:"busId"`
+	Label       string        `json:"label,omitempty"`
+	Description string        `json:"description,omitempty"`
+	MaxDevices  int           `json:"maxDevices,omitempty"`
+	Devices     []DeviceState `json:"devices,omitempty"`
+}
+
+// DeviceState is a persisted device: its ID on the bus and the options it was
+// created with, templates and bus defaults already applied.
+type DeviceState struct {
+	DevID  uint32                       `json:"devId"`
+	Port   int                          `json:"port,omitempty"`
+	Create apitypes.DeviceCreateRequest `json:"create"`
+}
+
+// stateFile is the state file as written: the buses come with the checksum of
+// their compact JSON.
+type stateFile struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum,omitempty"`
+	Buses    json.RawMessage `json:"buses"`
+}
+
+func checksum(buses []byte) string {
+	sum := sha256.Sum256(buses)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// LoadState reads the state file at path. A missing file is an empty state.
+// A truncated file or one whose checksum does not match is an error.
+func LoadState(path string) (State, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return State{Version: stateVersion}, nil
+	}
+	if err != nil {
+		return State{}, err
+	}
+	st, err := decodeState(b)
+	if err != nil {
+		return State{}, fmt.Errorf("state file %s: %w", path, err)
+	}
+	return st, nil
+}
+
+func decodeState(b []byte) (State, error) {
+	var f stateFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return State{}, fmt.Errorf("parse: %w", err)
+	}
+	switch f.Version {
+	case 1:
+	case stateVersion:
+		var buses bytes.Buffer
+		if err := json.Compact(&buses, f.Buses); err != nil {
+			return State{}, fmt.Errorf("parse: %w", err)
+		}
+		if checksum(buses.Bytes()) != f.Checksum {
+			return State{}, errors.New("checksum mismatch")
+		}
+	default:
+		return State{}, fmt.Errorf("unsupported version %d", f.Version)
+	}
+	st := State{Version: stateVersion}
+	if err := json.Unmarshal(f.Buses, &st.Buses); err != nil {
+		return State{}, fmt.Errorf("parse: %w", err)
+	}
+	return st, nil
+}
+
+func encodeState(st State) ([]byte, error) {
+	if st.Buses == nil {
+		st.Buses = []BusState{}
+	}
+	buses, err := json.Marshal(st.Buses)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(stateFile{Version: stateVersion, Checksum: checksum(buses), Buses: buses}, "", "  ")
+}
+
+// SaveState writes st to path atomically: a crash leaves the old or the new
+// file, never a partial one.
+func SaveState(path string, st State) error {
+	b, err := encodeState(st)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+func writeFileAtomic(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op after the rename
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// SetDeviceSpec records how dev was created, for the state file. It is
+// forgotten once devCtx ends.
+func (s *Server) SetDeviceSpec(devCtx context.Context, dev pusb.Device, spec apitypes.DeviceCreateRequest) {
+	s.specsMu.Lock()
+	s.specs[dev] = spec
+	s.specsMu.Unlock()
+	go func() {
+		<-devCtx.Done()
+		s.specsMu.Lock()
+		delete(s.specs, dev)
+		s.specsMu.Unlock()
+	}()
+}
+
+// Snapshot returns the current buses and the devices created through the API,
+// ordered by ID. Aliases and devices added by other means are left out.
+func (s *Server) Snapshot() State {
+	st := State{Version: stateVersion, Buses: []BusState{}}
+	ids := s.usbs.ListBuses()
+	slices.Sort(ids)
+	s.specsMu.Lock()
+	defer s.specsMu.Unlock()
+	for _, id := range ids {
+		b := s.usbs.GetBus(id)
+		if b == nil {
+			continue
+		}
+		bs := BusState{BusID: id}
+		bs.Label, bs.Description = b.Label()
+		if n := b.MaxDevices(); n != virtualbus.DefaultMaxDevices {
+			bs.MaxDevices = n
+		}
+		for _, m := range b.GetAllDeviceMetas() {
+			if spec, ok := s.specs[m.Dev]; ok {
+				// The label can change after creation; the bus has the current one.
+				spec.Label = nil
+				if m.Label != "" {
+					label := m.Label
+					spec.Label = &label
+				}
+				bs.Devices = append(bs.Devices, DeviceState{DevID: m.Meta.DevId, Port: m.Port, Create: spec})
+			}
+		}
+		slices.SortFunc(bs.Devices, func(a, b DeviceState) int { return cmp.Compare(a.DevID, b.DevID) })
+		st.Buses = append(st.Buses, bs)
+	}
+	return st
+}
+
+// StateSnapshot is a previous version of the state file, kept next to it
+// under the name of the file and the time it was replaced.
+type StateSnapshot struct {
+	Name string
+	Time time.Time
+}
+
+// listSnapshots returns the snapshots of the state file at path, newest first.
+func listSnapshots(path string) ([]StateSnapshot, error) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(path) + "."
+	var snaps []StateSnapshot
+	for _, e := range entries {
+		ts, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+		t, err := time.Parse(snapshotLayout, ts)
+		if err != nil {
+			continue
+		}
+		snaps = append(snaps, StateSnapshot{Name: e.Name(), Time: t})
+	}
+	slices.SortFunc(snaps, func(a, b StateSnapshot) int { return strings.Compare(b.Name, a.Name) })
+	return snaps, nil
+}
+
+// StateOptions configures the snapshots PersistState keeps.
+type StateOptions struct {
+	// Snapshots is how many known-good versions of the file are kept when it
+	// is replaced; 0 keeps none.
+	Snapshots int
+	// SnapshotInterval is the minimum time between two snapshots, so a burst
+	// of changes does not rotate out the older ones.
+	SnapshotInterval time.Duration
+}
+
+// statePersister writes the snapshot of the server to a file on every change.
+type statePersister struct {
+	path  string
+	opts  StateOptions
+	dirty chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+
+	// Owned by the goroutine writing the file.
+	written      []byte // last written, known good
+	lastSnapshot time.Time
+}
+
+// PersistState writes the state to path now and after every change of the
+// buses or devices, until Close. Handlers changing state that is persisted
+// but not evented by the USB server call StateChanged.
+func (s *Server) PersistState(path string, opts StateOptions) error {
+	p := &statePersister{
+		path:  path,
+		opts:  opts,
+		dirty: make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if snaps, err := listSnapshots(path); err == nil && len(snaps) > 0 {
+		p.lastSnapshot = snaps[0].Time
+	}
+	if err := s.save(p); err != nil {
+		return fmt.Errorf("write state file: %w", err)
+	}
+	sub := s.usbs.SubscribeEvents(1)
+	s.stateMu.Lock()
+	s.state = p
+	s.stateMu.Unlock()
+	go func() {
+		defer close(p.done)
+		defer sub.Close()
+		for {
+			select {
+			case <-sub.C():
+			case <-p.dirty:
+			case <-p.stop:
+				s.saveState(p)
+				return
+			}
+			s.saveState(p)
+		}
+	}()
+	return nil
+}
+
+// StateSnapshots lists the snapshots of the state file, newest first.
+func (s *Server) StateSnapshots() ([]StateSnapshot, error) {
+	path, err := s.statePath()
+	if err != nil {
+		return nil, err
+	}
+	return listSnapshots(path)
+}
+
+// LoadStateSnapshot reads the snapshot of the state file called name, as
+// listed by StateSnapshots.
+func (s *Server) LoadStateSnapshot(name string) (State, error) {
+	path, err := s.statePath()
+	if err != nil {
+		return State{}, err
+	}
+	snaps, err := listSnapshots(path)
+	if err != nil {
+		return State{}, err
+	}
+	if !slices.ContainsFunc(snaps, func(sn StateSnapshot) bool { return sn.Name == name }) {
+		return State{}, fmt.Errorf("snapshot %q: %w", name, fs.ErrNotExist)
+	}
+	b, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+	if err != nil {
+		return State{}, fmt.Errorf("snapshot %q: %w", name, err)
+	}
+	st, err := decodeState(b)
+	if err != nil {
+		return State{}, fmt.Errorf("snapshot %q: %w", name, err)
+	}
+	return st, nil
+}
+
+func (s *Server) statePath() (string, error) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.state == nil {
+		return "", ErrNoStateFile
+	}
+	return s.state.path, nil
+}
+
+// StateChanged schedules a write of the state file, if there is one.
+func (s *Server) StateChanged() {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.state == nil {
+		return
+	}
+	select {
+	case s.state.dirty <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Server) saveState(p *statePersister) {
+	if err := s.save(p); err != nil {
+		s.logger.Error("failed to write state file", "path", p.path, "error", err)
+	}
+}
+
+// save writes the current state to the file of p unless it is unchanged,
+// first keeping the file it replaces as a snapshot if that one is intact.
+func (s *Server) save(p *statePersister) error {
+	b, err := encodeState(s.Snapshot())
+	if err == nil && bytes.Equal(b, p.written) {
+		return nil
+	}
+	if err == nil {
+		s.rotateSnapshots(p)
+		err = writeFileAtomic(p.path, b)
+	}
+	if err != nil {
+		s.m.stateSaves.With("failed").Inc()
+		return err
+	}
+	s.m.stateSaves.With("ok").Inc()
+	p.written = b
+	return nil
+}
+
+// rotateSnapshots copies the state file to a new snapshot and removes all but
+// the newest p.opts.Snapshots. Failures only cost the snapshot; they are
+// logged.
+func (s *Server) rotateSnapshots(p *statePersister) {
+	now := time.Now().UTC()
+	if p.opts.Snapshots <= 0 || now.Sub(p.lastSnapshot) < p.opts.SnapshotInterval {
+		return
+	}
+	old := p.written
+	if old == nil {
+		// Written by an earlier run; only a file that loads is worth keeping.
+		b, err := os.ReadFile(p.path)
+		if err != nil {
+			return
+		}
+		if _, err := decodeState(b); err != nil {
+			return
+		}
+		old = b
+	}
+	if err := writeFileAtomic(p.path+"."+now.Format(snapshotLayout), old); err != nil {
+		s.logger.Warn("failed to write state snapshot", "path", p.path, "error", err)
+		return
+	}
+	p.lastSnapshot = now
+	snaps, err := listSnapshots(p.path)
+	if err != nil {
+		return
+	}
+	for _, sn := range snaps[min(len(snaps), p.opts.Snapshots):] {
+		if err := os.Remove(filepath.Join(filepath.Dir(p.path), sn.Name)); err != nil {
+			s.logger.Warn("failed to remove state snapshot", "name", sn.Name, "error", err)
+		}
+	}
+}
+
+// stopPersisting writes the state a last time and stops updating it.
+func (s *Server) stopPersisting() {
+	s.stateMu.Lock()
+	p := s.state
+	s.state = nil
+	s.stateMu.Unlock()
+	if p != nil {
+		close(p.stop)
+		<-p.done
+	}
+}
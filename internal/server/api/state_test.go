@@ -0,0 +1,42 @@
+package api_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/internal/server/api"
+)
+
+func TestLoadState(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	st := api.State{Buses: []api.BusState{{BusID: 7, Label: "desk <left>"}}}
+	path := filepath.Join(dir, "state.json")
+	require.NoError(t, api.SaveState(path, st))
+	got, err := api.LoadState(path)
+	require.NoError(t, err)
+	assert.Equal(t, st.Buses, got.Buses)
+
+	got, err = api.LoadState(write("v1.json", `{"version": 1, "buses": [{"busId": 7}]}`))
+	require.NoError(t, err, "version 1 files have no checksum")
+	assert.Equal(t, []api.BusState{{BusID: 7}}, got.Buses)
+
+	_, err = api.LoadState(write("v9.json", `{"version": 9, "buses": []}`))
+	assert.ErrorContains(t, err, "unsupported version 9")
+
+	_, err = api.LoadState(write("nosum.json", `{"version": 2, "buses": []}`))
+	assert.ErrorContains(t, err, "checksum mismatch")
+
+	got, err = api.LoadState(filepath.Join(dir, "missing.json"))
+	require.NoError(t, err)
+	assert.Empty(t, got.Buses)
+}
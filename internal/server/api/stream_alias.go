@@ -0,0 +1,22 @@
+package api
+
+import (
+	"io"
+	"net"
+)
+
+// aliasConn discards client input on streams of aliased devices, whose
+// input is that of the original. Read blocks until the client goes away,
+// so the stream handler keeps delivering the alias's feedback.
+type aliasConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *aliasConn) Read(p []byte) (int, error) {
+	_, err := io.Copy(io.Discard, c.r)
+	if err == nil {
+		err = io.EOF
+	}
+	return 0, err
+}
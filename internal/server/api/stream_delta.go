@@ -0,0 +1,159 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Alia5/VIIPER/device"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	usbs "github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// streamOptions are negotiated through the payload of a stream request,
+// e.g. "bus/1/1 delta=1".
+type streamOptions struct {
+	delta  int  // delta-update wire version; 0 = full states only
+	events int  // event-mode wire version; 0 = full states only
+	flush  bool // settle input before closing, see flushConn
+	ack    bool // confirm the stream with an empty JSON object line
+	status bool // frame feedback and push attach status, see pushStatus
+	seq    bool // states carry a sequence number and timestamp, see seqReader
+	// prio orders the device's USB/IP work against other devices', see
+	// usbs.Priority; capped by the token.
+	prio usbs.Priority
+	// claim lists the wire fields a stream of a mixed device owns, see
+	// mixStream; nil if none were claimed.
+	claim []string
+}
+
+func parseStreamOptions(payload string) (streamOptions, error) {
+	var opts streamOptions
+	for _, kv := range strings.Fields(payload) {
+		key, value, _ := strings.Cut(kv, "=")
+		switch key {
+		case "delta":
+			v, err := strconv.Atoi(value)
+			if err != nil || v != device.DeltaVersion {
+				return opts, apierror.ErrBadRequest(fmt.Sprintf("unsupported delta version %q", value))
+			}
+			opts.delta = v
+		case "events":
+			v, err := strconv.Atoi(value)
+			if err != nil || v != device.EventVersion {
+				return opts, apierror.ErrBadRequest(fmt.Sprintf("unsupported events version %q", value))
+			}
+			opts.events = v
+		case "flush":
+			if value != "1" {
+				return opts, apierror.ErrBadRequest(fmt.Sprintf("unsupported flush version %q", value))
+			}
+			opts.flush = true
+		case "ack":
+			if value != "1" {
+				return opts, apierror.ErrBadRequest(fmt.Sprintf("unsupported ack version %q", value))
+			}
+			opts.ack = true
+		case "status":
+			if value != "1" {
+				return opts, apierror.ErrBadRequest(fmt.Sprintf("unsupported status version %q", value))
+			}
+			opts.status = true
+		case "seq":
+			if value != "1" {
+				return opts, apierror.ErrBadRequest(fmt.Sprintf("unsupported seq version %q", value))
+			}
+			opts.seq = true
+		case "prio":
+			p, err := usbs.ParsePriority(value)
+			if err != nil {
+				return opts, apierror.ErrBadRequest(fmt.Sprintf("unsupported prio %q", value))
+			}
+			opts.prio = p
+		case "claim":
+			opts.claim = strings.Split(value, ",")
+		default:
+			return opts, apierror.ErrBadRequest(fmt.Sprintf("unknown stream option %q", key))
+		}
+	}
+	if opts.delta != 0 && opts.events != 0 {
+		return opts, apierror.ErrBadRequest("delta and events stream options are mutually exclusive")
+	}
+	if opts.seq && (opts.delta != 0 || opts.events != 0) {
+		return opts, apierror.ErrBadRequest("the seq stream option requires full states")
+	}
+	return opts, nil
+}
+
+func deltaLayout(dev usb.Device) (device.WireLayout, error) {
+	deviceType := inferDeviceType(dev)
+	reg, ok := GetRegistration(deviceType).(DeltaRegistration)
+	if !ok {
+		return nil, apierror.ErrBadRequest(fmt.Sprintf("device type %s does not support delta updates", deviceType))
+	}
+	return reg.InputLayout(dev), nil
+}
+
+// deltaConn expands delta packets read from the client into full wire states,
+// so device stream handlers keep reading fixed-size states. Every delta yields
+// exactly one merged state, unless the validator drops it.
+type deltaConn struct {
+	net.Conn
+	r       io.Reader
+	v       *inputValidator
+	layout  device.WireLayout
+	state   []byte
+	mask    []byte
+	body    []byte
+	out     []byte
+	pending []byte
+}
+
+func newDeltaConn(conn net.Conn, r io.Reader, layout device.WireLayout, v *inputValidator) *deltaConn {
+	return &deltaConn{
+		Conn:   conn,
+		r:      r,
+		v:      v,
+		layout: layout,
+		state:  make([]byte, layout.Size()),
+		mask:   make([]byte, layout.MaskSize()),
+		body:   make([]byte, layout.Size()),
+		out:    make([]byte, layout.Size()),
+	}
+}
+
+func (c *deltaConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if _, err := io.ReadFull(c.r, c.mask); err != nil {
+			return 0, err
+		}
+		body := c.body[:c.layout.DeltaSize(c.mask)]
+		if _, err := io.ReadFull(c.r, body); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		copy(c.out, c.state)
+		if err := c.layout.ApplyDelta(c.out, c.mask, body); err != nil {
+			return 0, fmt.Errorf("apply delta: %w", err)
+		}
+		if c.v != nil {
+			ok, err := c.v.check(c.out)
+			if err != nil {
+				return 0, fmt.Errorf("validate input: %w", err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		copy(c.state, c.out)
+		c.pending = c.out
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
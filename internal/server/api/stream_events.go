@@ -0,0 +1,70 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+
+	"github.com/Alia5/VIIPER/device"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+func eventFolder(dev usb.Device) (device.EventFolder, error) {
+	deviceType := inferDeviceType(dev)
+	reg, ok := GetRegistration(deviceType).(EventRegistration)
+	if !ok {
+		return nil, apierror.ErrBadRequest(fmt.Sprintf("device type %s does not support event mode", deviceType))
+	}
+	return reg.EventFolder(dev), nil
+}
+
+// eventConn folds event packets read from the client into full wire states,
+// so device stream handlers keep reading states. When the client goes away,
+// for whatever reason, one final release-all state is delivered before the
+// read error so no input stays held.
+type eventConn struct {
+	net.Conn
+	r        io.Reader
+	folder   device.EventFolder
+	logger   *slog.Logger
+	event    [device.EventSize]byte
+	pending  []byte
+	err      error
+	released bool
+}
+
+func newEventConn(conn net.Conn, r io.Reader, folder device.EventFolder, logger *slog.Logger) *eventConn {
+	return &eventConn{Conn: conn, r: r, folder: folder, logger: logger}
+}
+
+func (c *eventConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.err != nil {
+			if !c.released {
+				c.released = true
+				c.pending = c.folder.ReleaseAll()
+				continue
+			}
+			return 0, c.err
+		}
+		if _, err := io.ReadFull(c.r, c.event[:]); err != nil {
+			c.err = err
+			continue
+		}
+		state, err := c.folder.Fold(c.event[0], c.event[1])
+		switch {
+		case errors.Is(err, device.ErrEventDropped):
+			c.logger.Warn("dropped input event", "error", err)
+		case err != nil:
+			c.err = fmt.Errorf("fold event: %w", err)
+		default:
+			c.pending = state
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// flushConn keeps the stream handler from closing a flush-on-close stream;
+// the server closes it in finishFlush once the input has settled.
+type flushConn struct{ net.Conn }
+
+// flushHumanizeTimeout bounds the wait for humanized input, which paces
+// every key state and so can hold back a long burst of typing.
+const flushHumanizeTimeout = time.Minute
+
+func (flushConn) Close() error { return nil }
+
+// finishFlush ends a stream opened with the flush option. After a clean end
+// of input every state read from the client has reached the device latch;
+// states delayed by a degraded link or held back by input humanization are
+// waited for, then the connection is closed in order, which the client takes
+// as its acknowledgement. Streams that ended with an error are reset instead,
+// so the client can tell.
+func finishFlush(raw net.Conn, conn net.Conn, dev usb.Device, streamErr error, logger *slog.Logger) {
+	defer conn.Close()
+	if streamErr != nil {
+		if tc, ok := raw.(*tls.Conn); ok {
+			raw = tc.NetConn()
+		}
+		if tc, ok := raw.(*net.TCPConn); ok {
+			_ = tc.SetLinger(0)
+		}
+		return
+	}
+	if d, ok := dev.(device.Degradable); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), device.MaxDegradeDelay)
+		defer cancel()
+		if err := d.Degrader().Wait(ctx); err != nil {
+			logger.Warn("flush: delayed input still pending", "error", err)
+		}
+	}
+	if h, ok := dev.(device.Humanizable); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), flushHumanizeTimeout)
+		defer cancel()
+		if err := h.Humanizer().Wait(ctx); err != nil {
+			logger.Warn("flush: humanized input still pending", "error", err)
+		}
+	}
+}
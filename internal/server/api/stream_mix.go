@@ -0,0 +1,120 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/Alia5/VIIPER/device"
+	pusb "github.com/Alia5/VIIPER/usb"
+)
+
+// mixSession runs the stream handler of a device with the mixed stream
+// policy once for all of its streams. The streams merge their states
+// through the mixer into the pipe the handler reads; feedback the handler
+// writes goes out to every stream.
+type mixSession struct {
+	mixer *device.Mixer
+	hub   *mixHub
+	done  chan struct{} // closed when the handler returned
+
+	mu sync.Mutex // keeps merged states in order
+	pw *io.PipeWriter
+
+	members int // guarded by Server.mixMu
+}
+
+// mixHub is the connection the stream handler of a mixSession gets.
+type mixHub struct {
+	net.Conn // nil; handlers only read, write and close
+	pr       *io.PipeReader
+
+	mu      sync.Mutex
+	streams map[*streamConn]bool
+}
+
+func (h *mixHub) Read(p []byte) (int, error) { return h.pr.Read(p) }
+
+func (h *mixHub) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sc := range h.streams {
+		_, _ = sc.Write(p)
+	}
+	return len(p), nil
+}
+
+func (h *mixHub) Close() error { return h.pr.Close() }
+
+// mixStream feeds the stream sc, whose source claimed its fields, into the
+// mix of dev until the client stops sending. The fields of the source are
+// released afterwards; last reports whether it was the last stream of dev.
+func (s *Server) mixStream(dev pusb.Device, mixer *device.Mixer, source string, conn net.Conn, sc *streamConn, sh StreamHandlerFunc, logger *slog.Logger) (last bool, err error) {
+	s.mixMu.Lock()
+	ms := s.mixes[dev]
+	if ms == nil {
+		pr, pw := io.Pipe()
+		ms = &mixSession{
+			mixer: mixer,
+			hub:   &mixHub{pr: pr, streams: map[*streamConn]bool{}},
+			done:  make(chan struct{}),
+			pw:    pw,
+		}
+		s.mixes[dev] = ms
+		go func() {
+			defer close(ms.done)
+			d := dev
+			if err := sh(ms.hub, &d, logger); err != nil {
+				logger.Error("mixed stream handler error", "error", err)
+			}
+		}()
+	}
+	ms.members++
+	ms.hub.mu.Lock()
+	ms.hub.streams[sc] = true
+	ms.hub.mu.Unlock()
+	s.mixMu.Unlock()
+
+	defer func() {
+		s.mixMu.Lock()
+		ms.hub.mu.Lock()
+		delete(ms.hub.streams, sc)
+		ms.hub.mu.Unlock()
+		ms.members--
+		last = ms.members == 0
+		if last {
+			delete(s.mixes, dev)
+		}
+		s.mixMu.Unlock()
+
+		ms.mu.Lock()
+		released := mixer.Release(source)
+		if !last {
+			_, _ = ms.pw.Write(released)
+		}
+		ms.mu.Unlock()
+		if last {
+			_ = ms.pw.Close()
+			<-ms.done
+		}
+	}()
+
+	defer conn.Close()
+	buf := make([]byte, mixer.Layout().Size())
+	for {
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			if errors.Is(err, io.EOF) {
+				return false, nil
+			}
+			return false, err
+		}
+		ms.mu.Lock()
+		_, err := ms.pw.Write(mixer.Merge(source, buf))
+		ms.mu.Unlock()
+		if err != nil {
+			return false, err
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/Alia5/VIIPER/device"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+	pusb "github.com/Alia5/VIIPER/usb"
+)
+
+// seqHeaderSize is the prefix of every state on a stream opened with seq=1:
+// sequence number (u32 little-endian) and client monotonic timestamp in
+// nanoseconds (u64 little-endian).
+const seqHeaderSize = 12
+
+// seqReader strips the seq=1 header off the full states read from the
+// client, recording each state with the device's latency tracker.
+type seqReader struct {
+	r       io.Reader
+	tracker *usb.LatencyTracker
+	header  [seqHeaderSize]byte
+	state   []byte
+	pending []byte
+}
+
+func newSeqReader(r io.Reader, stateSize int, tracker *usb.LatencyTracker) *seqReader {
+	return &seqReader{r: r, tracker: tracker, state: make([]byte, stateSize)}
+}
+
+func (r *seqReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		if _, err := io.ReadFull(r.r, r.header[:]); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(r.r, r.state); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		r.tracker.Received(binary.LittleEndian.Uint32(r.header[0:4]), int64(binary.LittleEndian.Uint64(r.header[4:12])))
+		r.pending = r.state
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// seqLayout returns the input layout of dev, which sizes its states.
+func seqLayout(dev pusb.Device) (device.WireLayout, error) {
+	deviceType := inferDeviceType(dev)
+	reg, ok := GetRegistration(deviceType).(DeltaRegistration)
+	if !ok {
+		return nil, apierror.ErrBadRequest(fmt.Sprintf("device type %s does not support sequenced input", deviceType))
+	}
+	return reg.InputLayout(dev), nil
+}
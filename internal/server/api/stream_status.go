@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// Kinds of the messages a stream opened with status=1 sends, each as
+// kind (u8), body length (u16 little-endian) and body.
+const (
+	streamMsgFeedback = 0x00 // the device's feedback, as sent without status=1
+	streamMsgStatus   = 0x01 // an apitypes.DeviceStatusResponse as JSON
+	streamMsgShutdown = 0x02 // the server is shutting down; an apitypes.ApiError as JSON
+)
+
+// DeviceStatus reports the attachment of dev on bus.
+func DeviceStatus(bus *virtualbus.VirtualBus, devID string, dev usb.Device) apitypes.DeviceStatusResponse {
+	a, ok := bus.Attachment(dev)
+	return deviceStatus(bus.BusID(), devID, a, ok)
+}
+
+func deviceStatus(busID uint32, devID string, a virtualbus.Attachment, ok bool) apitypes.DeviceStatusResponse {
+	st := apitypes.DeviceStatusResponse{BusID: busID, DevId: devID, Attached: ok}
+	if ok {
+		st.AttachedSince = a.Since.UTC().Format(time.RFC3339Nano)
+		st.RemoteAddr = a.RemoteAddr
+	}
+	return st
+}
+
+// frameStreamMessage prepends the status=1 message header to body.
+func frameStreamMessage(kind byte, body []byte) []byte {
+	msg := make([]byte, 3+len(body))
+	msg[0] = kind
+	binary.LittleEndian.PutUint16(msg[1:3], uint16(len(body)))
+	copy(msg[3:], body)
+	return msg
+}
+
+// pushStatus sends a status message to sc on every change of the attachment
+// of dev, starting with the current one, until ctx ends.
+func pushStatus(ctx context.Context, sc *streamConn, bus *virtualbus.VirtualBus, devID string, dev usb.Device, logger *slog.Logger) {
+	for {
+		a, ok, changed := bus.WatchAttachment(dev)
+		if changed == nil {
+			return
+		}
+		body, err := json.Marshal(deviceStatus(bus.BusID(), devID, a, ok))
+		if err != nil {
+			logger.Error("marshal device status", "error", err)
+			return
+		}
+		if err := sc.writeMessage(streamMsgStatus, body); err != nil {
+			return
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
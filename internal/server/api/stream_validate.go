@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+
+	"github.com/Alia5/VIIPER/device"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// SetStrictInput selects whether out-of-range stream inputs of dev are rejected
+// instead of clamped. The setting is dropped once devCtx is done.
+func (s *Server) SetStrictInput(devCtx context.Context, dev usb.Device, strict bool) {
+	if !strict {
+		return
+	}
+	s.strictMu.Lock()
+	s.strict[dev] = true
+	s.strictMu.Unlock()
+	go func() {
+		<-devCtx.Done()
+		s.strictMu.Lock()
+		delete(s.strict, dev)
+		s.strictMu.Unlock()
+	}()
+}
+
+func (s *Server) strictInput(dev usb.Device) bool {
+	s.strictMu.Lock()
+	defer s.strictMu.Unlock()
+	return s.strict[dev]
+}
+
+// inputValidator range-checks the full input states streamed to a device.
+// Devices without ranged wire fields have none.
+type inputValidator struct {
+	srv      *Server
+	dev      usb.Device
+	layout   device.WireLayout
+	strict   bool
+	logger   *slog.Logger
+	clamped  int
+	rejected int
+}
+
+func (s *Server) inputValidator(dev usb.Device, logger *slog.Logger) *inputValidator {
+	reg, ok := GetRegistration(inferDeviceType(dev)).(DeltaRegistration)
+	if !ok {
+		return nil
+	}
+	layout := reg.InputLayout(dev)
+	for _, f := range layout {
+		if f.Range != nil {
+			return &inputValidator{
+				srv:    s,
+				dev:    dev,
+				layout: layout,
+				strict: s.strictInput(dev),
+				logger: logger,
+			}
+		}
+	}
+	return nil
+}
+
+// check clamps state in place and reports whether it may be forwarded.
+// In strict mode an out-of-range state is dropped and the client is sent a
+// problem JSON line naming the offending field.
+func (v *inputValidator) check(state []byte) (bool, error) {
+	n, err := v.layout.Validate(state, v.strict)
+	var rangeErr *device.RangeError
+	switch {
+	case errors.As(err, &rangeErr):
+		v.rejected++
+		notice, _ := json.Marshal(apierror.ErrBadRequest(rangeErr.Error()))
+		if err := v.srv.WriteFeedback(v.dev, append(notice, '\n')); err != nil {
+			v.logger.Warn("failed to send input rejection", "error", err)
+		}
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	v.clamped += n
+	return true, nil
+}
+
+func (v *inputValidator) logStats() {
+	if v.clamped > 0 || v.rejected > 0 {
+		v.logger.Info("out-of-range inputs", "clamped", v.clamped, "rejected", v.rejected, "strict", v.strict)
+	}
+}
+
+// validateConn frames full wire states read from the client and passes on
+// those accepted by the validator.
+type validateConn struct {
+	net.Conn
+	r       io.Reader
+	v       *inputValidator
+	state   []byte
+	pending []byte
+}
+
+func newValidateConn(conn net.Conn, r io.Reader, v *inputValidator) *validateConn {
+	return &validateConn{
+		Conn:  conn,
+		r:     r,
+		v:     v,
+		state: make([]byte, v.layout.Size()),
+	}
+}
+
+func (c *validateConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if _, err := io.ReadFull(c.r, c.state); err != nil {
+			return 0, err
+		}
+		ok, err := c.v.check(c.state)
+		if err != nil {
+			return 0, fmt.Errorf("validate input: %w", err)
+		}
+		if ok {
+			c.pending = c.state
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,146 @@
+package api_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/apitypes"
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/device/dualshock4"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	"github.com/Alia5/VIIPER/internal/server/api/handler"
+	pusb "github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// captureRegistration wraps the dualshock4 registration and hands every input
+// state reaching the device stream handler to the test.
+type captureRegistration struct {
+	api.DeviceRegistration
+	states chan []byte
+}
+
+func (r *captureRegistration) InputLayout(dev pusb.Device) device.WireLayout {
+	return dualshock4.InputLayout
+}
+
+func (r *captureRegistration) StreamHandler() api.StreamHandlerFunc {
+	return func(conn net.Conn, _ *pusb.Device, _ *slog.Logger) error {
+		for {
+			buf := make([]byte, dualshock4.InputLayout.Size())
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return nil
+			}
+			r.states <- buf
+		}
+	}
+}
+
+func TestStreamInputValidation(t *testing.T) {
+	orig := api.GetRegistration("dualshock4")
+	reg := &captureRegistration{DeviceRegistration: orig, states: make(chan []byte, 8)}
+	api.RegisterDevice("dualshock4", reg)
+	t.Cleanup(func() { api.RegisterDevice("dualshock4", orig) })
+
+	s := viiperTesting.NewTestServerWithConfig(t, viiperTesting.TestServerConfig(t))
+	defer s.UsbServer.Close()
+	defer s.ApiServer.Close()
+
+	r := s.ApiServer.Router()
+	r.Register("bus/{id}/add", handler.BusDeviceAdd(s.UsbServer, s.ApiServer))
+	r.Register("features", handler.Features())
+	r.RegisterStream("bus/{busId}/{deviceid}", api.DeviceStreamHandler(s.UsbServer))
+	require.NoError(t, s.ApiServer.Start())
+
+	b, err := virtualbus.NewWithBusId(90105)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	client := apiclient.New(s.ApiServer.Addr())
+
+	marshal := func(st dualshock4.InputState) []byte {
+		data, err := st.MarshalBinary()
+		require.NoError(t, err)
+		return data
+	}
+	next := func() []byte {
+		select {
+		case st := <-reg.states:
+			return st
+		case <-time.After(time.Second):
+			t.Fatal("no input state reached the device")
+			return nil
+		}
+	}
+	inRange := dualshock4.InputState{
+		LX: -128, Buttons: dualshock4.ButtonCross, DPad: dualshock4.DPadUp | dualshock4.DPadLeft,
+		Touch1X: dualshock4.TouchpadMaxX, Touch1Y: 100, Touch1Active: true, GyroZ: -300, AccelZ: -5023,
+	}
+	outOfRange := marshal(inRange)
+	outOfRange[6] = 0xff                       // dpad
+	outOfRange[9], outOfRange[10] = 0x60, 0xea // touch1X = 60000
+	outOfRange[18] = 7                         // touch2Active
+
+	t.Run("clamp", func(t *testing.T) {
+		dev, err := client.DeviceAdd(90105, "dualshock4", nil)
+		require.NoError(t, err)
+		stream, err := client.OpenStream(context.Background(), 90105, dev.DevId)
+		require.NoError(t, err)
+		defer stream.Close()
+
+		_, err = stream.Write(marshal(inRange))
+		require.NoError(t, err)
+		assert.Equal(t, marshal(inRange), next(), "in-range states pass unchanged")
+
+		_, err = stream.Write(outOfRange)
+		require.NoError(t, err)
+		want := marshal(inRange)
+		want[6] = 0x0f
+		want[18] = 1
+		assert.Equal(t, want, next())
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		strict := true
+		dev, err := client.DeviceAdd(90105, "dualshock4", &device.CreateOptions{StrictInput: &strict})
+		require.NoError(t, err)
+		stream, err := client.OpenDeltaStream(context.Background(), 90105, dev.DevId, dualshock4.InputLayout)
+		require.NoError(t, err)
+		defer stream.Close()
+
+		bad := inRange
+		bad.Touch2Y = 5000
+		bad.Touch2Active = true
+		require.NoError(t, stream.WriteBinary(&bad))
+
+		_ = stream.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := bufio.NewReader(stream).ReadBytes('\n')
+		require.NoError(t, err)
+		var notice apitypes.ApiError
+		require.NoError(t, json.Unmarshal(line, &notice))
+		assert.Equal(t, 400, notice.Status)
+		assert.Contains(t, notice.Detail, "touch2Y")
+
+		// The rejected state is dropped entirely, so a delta builds on the
+		// last accepted (zero) state.
+		require.NoError(t, stream.WriteDelta(&inRange, "buttons"))
+		want := make([]byte, dualshock4.InputLayout.Size())
+		copy(want[4:6], marshal(inRange)[4:6])
+		assert.Equal(t, want, next())
+		select {
+		case st := <-reg.states:
+			t.Fatalf("unexpected input state %x", st)
+		default:
+		}
+	})
+}
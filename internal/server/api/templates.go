@@ -0,0 +1,57 @@
+package api
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/Alia5/VIIPER/device"
+)
+
+// DeviceTemplate is a named device configuration that devices can be created from.
+type DeviceTemplate struct {
+	Name       string
+	DeviceType string
+	Options    device.CreateOptions
+}
+
+// SetTemplate stores t, replacing any template of the same name. Devices
+// already created from the old template keep their configuration.
+func (s *Server) SetTemplate(t DeviceTemplate) {
+	t.Options.DeviceSpecific = maps.Clone(t.Options.DeviceSpecific)
+	s.templatesMu.Lock()
+	defer s.templatesMu.Unlock()
+	s.templates[strings.ToLower(t.Name)] = t
+}
+
+// Template returns the template called name.
+func (s *Server) Template(name string) (DeviceTemplate, bool) {
+	s.templatesMu.Lock()
+	defer s.templatesMu.Unlock()
+	t, ok := s.templates[strings.ToLower(name)]
+	t.Options.DeviceSpecific = maps.Clone(t.Options.DeviceSpecific)
+	return t, ok
+}
+
+// Templates returns all templates sorted by name.
+func (s *Server) Templates() []DeviceTemplate {
+	s.templatesMu.Lock()
+	defer s.templatesMu.Unlock()
+	out := make([]DeviceTemplate, 0, len(s.templates))
+	for _, t := range s.templates {
+		t.Options.DeviceSpecific = maps.Clone(t.Options.DeviceSpecific)
+		out = append(out, t)
+	}
+	slices.SortFunc(out, func(a, b DeviceTemplate) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+// RemoveTemplate deletes the template called name and reports whether it existed.
+func (s *Server) RemoveTemplate(name string) bool {
+	s.templatesMu.Lock()
+	defer s.templatesMu.Unlock()
+	key := strings.ToLower(name)
+	_, ok := s.templates[key]
+	delete(s.templates, key)
+	return ok
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/Alia5/VIIPER/internal/server/api/auth"
+	apierror "github.com/Alia5/VIIPER/internal/server/api/error"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+	pusb "github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// ScopeStream lets a token open the streams of the devices it added.
+const ScopeStream = "stream"
+
+// routeScope is what a token needs for a mutating route. owned routes only
+// act on devices the token added itself.
+type routeScope struct {
+	scope string
+	owned bool
+}
+
+// routeScopes maps mutating routes to their scopes; mutating routes missing
+// here need ScopeAdmin. Reading routes listed here return data captured from
+// a device and are limited to its owner. bus/{id}/add is checked by its handler against the
+// type of each device to add ("device:add:<type>"), as is bus/{id}/add-batch.
+var routeScopes = map[string]routeScope{
+	"bus/create":                          {scope: "bus:create"},
+	"bus/remove":                          {scope: "bus:remove"},
+	"bus/{id}/add":                        {},
+	"bus/{id}/add-batch":                  {},
+	"bus/{id}/remove":                     {scope: "device:remove", owned: true},
+	"bus/{id}/defaults/set":               {scope: "bus:defaults"},
+	"bus/{id}/label":                      {scope: "bus:label"},
+	"bus/{id}/{deviceid}/label":           {scope: "device:label", owned: true},
+	"bus/{id}/{deviceid}/alias":           {scope: "device:alias", owned: true},
+	"bus/{id}/{deviceid}/degrade":         {scope: "device:degrade", owned: true},
+	"bus/{id}/{deviceid}/step":            {scope: "device:step", owned: true},
+	"bus/{id}/{deviceid}/test-feedback":   {scope: "device:test-feedback", owned: true},
+	"bus/{id}/{deviceid}/record/start":    {scope: "device:record", owned: true},
+	"bus/{id}/{deviceid}/record/stop":     {scope: "device:record", owned: true},
+	"bus/{id}/{deviceid}/record/download": {owned: true},
+	"bus/{id}/{deviceid}/macro":           {scope: "device:macro", owned: true},
+	"bus/{id}/{deviceid}/macro/cancel":    {scope: "device:macro", owned: true},
+	"templates/set":                       {scope: "templates"},
+	"templates/remove":                    {scope: "templates"},
+}
+
+// tokenKey returns the handshake key of the token with id, deriving it on
+// first use.
+func (s *Server) tokenKey(id string) ([]byte, bool) {
+	if s.token(id) == nil {
+		return nil, false
+	}
+	s.tokensMu.Lock()
+	defer s.tokensMu.Unlock()
+	if key, ok := s.tokenKeys[id]; ok {
+		return key, true
+	}
+	key, err := auth.DeriveKey(s.token(id).Secret)
+	if err != nil {
+		return nil, false
+	}
+	s.tokenKeys[id] = key
+	return key, true
+}
+
+// token returns the configured token with id, or nil.
+func (s *Server) token(id string) *auth.Token {
+	for i := range s.config.Tokens {
+		if s.config.Tokens[i].ID == id {
+			return &s.config.Tokens[i]
+		}
+	}
+	return nil
+}
+
+// SetDeviceOwner records the token that added dev, until devCtx ends.
+// Devices added with the password or without authentication have no owner.
+func (s *Server) SetDeviceOwner(devCtx context.Context, dev pusb.Device, tok *auth.Token) {
+	if tok == nil {
+		return
+	}
+	s.tokensMu.Lock()
+	s.owners[dev] = tok.ID
+	s.tokensMu.Unlock()
+	go func() {
+		<-devCtx.Done()
+		s.tokensMu.Lock()
+		delete(s.owners, dev)
+		s.tokensMu.Unlock()
+	}()
+}
+
+// ownedBy reports whether tok may act on dev: it added dev or is an admin.
+func (s *Server) ownedBy(dev pusb.Device, tok *auth.Token) bool {
+	if tok.Allows(auth.ScopeAdmin) {
+		return true
+	}
+	s.tokensMu.Lock()
+	defer s.tokensMu.Unlock()
+	owner, ok := s.owners[dev]
+	return ok && owner == tok.ID
+}
+
+// checkScope refuses a route the token of req has no scope for. Reading
+// routes are open to every token, except those of routeScopes.
+func (s *Server) checkScope(rt Route, req *Request) error {
+	tok := req.Token
+	rs, ok := routeScopes[rt.Pattern]
+	if !ok && rt.Flags&(Mutating|Admin) == 0 {
+		return nil
+	}
+	if !ok || rt.Flags&Admin != 0 {
+		rs = routeScope{scope: auth.ScopeAdmin}
+	}
+	if rs.scope != "" && !tok.Allows(rs.scope) {
+		return apierror.ErrForbidden(fmt.Sprintf("token %s lacks scope %s", tok.ID, rs.scope))
+	}
+	if !rs.owned {
+		return nil
+	}
+	devID, ok := req.Params["deviceid"]
+	if !ok {
+		devID = req.Payload // bus/{id}/remove
+	}
+	dev := s.findDevice(req.Params["id"], devID)
+	if dev != nil && !s.ownedBy(dev, tok) {
+		return apierror.ErrForbidden(fmt.Sprintf("device %s was not added by token %s", devID, tok.ID))
+	}
+	return nil
+}
+
+// checkStream refuses streams to devices tok did not add.
+func (s *Server) checkStream(dev pusb.Device, tok *auth.Token) error {
+	if !tok.Allows(ScopeStream) {
+		return apierror.ErrForbidden(fmt.Sprintf("token %s lacks scope %s", tok.ID, ScopeStream))
+	}
+	if !s.ownedBy(dev, tok) {
+		return apierror.ErrForbidden(fmt.Sprintf("device was not added by token %s", tok.ID))
+	}
+	return nil
+}
+
+// capPriority lowers p to the maximum priority of tok.
+func capPriority(p usb.Priority, tok *auth.Token) usb.Priority {
+	if tok.Allows(auth.ScopeAdmin) {
+		return p
+	}
+	limit, err := usb.ParsePriority(tok.MaxPriority)
+	if err != nil {
+		limit = usb.PriorityNormal
+	}
+	return min(p, limit)
+}
+
+// findDevice returns the device with devID on the bus with busID, or nil;
+// the route handler reports missing ones.
+func (s *Server) findDevice(busID, devID string) pusb.Device {
+	id, err := strconv.ParseUint(busID, 10, 32)
+	if err != nil {
+		return nil
+	}
+	b := s.usbs.GetBus(uint32(id))
+	if b == nil {
+		return nil
+	}
+	for _, m := range b.GetAllDeviceMetas() {
+		if virtualbus.DeviceID(&m.Meta) == devID {
+			return m.Dev
+		}
+	}
+	return nil
+}
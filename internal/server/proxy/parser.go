@@ -5,124 +5,151 @@ import (
 	"encoding/binary"
 	"fmt"
 	"log/slog"
+	"strings"
+	"sync"
+	"unicode/utf16"
 
 	"github.com/Alia5/VIIPER/usbip"
 )
 
-// Parser handles USB-IP packet parsing for structured logging.
+const (
+	mgmtHeaderSize  = 8
+	urbHeaderSize   = 0x30
+	exportedDevSize = 312
+	errConnReset    = -104
+)
+
+// Parser handles USB-IP packet parsing for structured logging. One Parser
+// is fed both directions of a connection, since replies are decoded with the
+// requests they answer; messages may arrive split across any number of reads.
 type Parser struct {
 	logger *slog.Logger
-	buf    bytes.Buffer
+
+	mu      sync.Mutex
+	streams [2]parseStream // client->server, server->client
+	pending map[uint32]submitted
+	unlinks map[uint32]uint32 // CMD_UNLINK seq -> unlinked seq
+}
+
+// parseStream is the undecoded traffic of one direction.
+type parseStream struct {
+	buf  bytes.Buffer
+	need int // bytes the current message needs before it can be decoded
+	skip int // payload bytes of a decoded message not yet received
+}
+
+// submitted is what decoding a RET_SUBMIT needs to know of its CMD_SUBMIT.
+type submitted struct {
+	dir, ep, numPkts uint32
+	setup            [8]byte
 }
 
 func NewParser(logger *slog.Logger) *Parser {
 	return &Parser{
-		logger: logger,
+		logger:  logger,
+		pending: map[uint32]submitted{},
+		unlinks: map[uint32]uint32{},
 	}
 }
 
 // Parse processes incoming data and logs USB-IP protocol information.
 func (p *Parser) Parse(data []byte, clientToServer bool) {
-	p.buf.Write(data)
-
-	for p.buf.Len() >= 8 {
-		peek := p.buf.Bytes()
-
-		ver := binary.BigEndian.Uint16(peek[0:2])
-		code := binary.BigEndian.Uint16(peek[2:4])
-
-		if ver == usbip.Version {
-			switch code {
-			case usbip.OpReqDevlist:
-				if p.buf.Len() >= 8 {
-					p.logMgmtOp("OP_REQ_DEVLIST", clientToServer)
-					p.buf.Next(8)
-					continue
-				}
-				return
-
-			case usbip.OpRepDevlist:
-				if consumed := p.parseOpRepDevlist(peek, clientToServer); consumed > 0 {
-					p.buf.Next(consumed)
-					continue
-				}
-				return
-
-			case usbip.OpReqImport:
-				if p.buf.Len() >= 40 { // 8 byte header + 32 byte busid
-					busid := peek[8:40]
-					end := bytes.IndexByte(busid, 0)
-					if end == -1 {
-						end = len(busid)
-					}
-					p.logger.Info("USBIP packet",
-						"dir", dirString(clientToServer),
-						"op", "OP_REQ_IMPORT",
-						"busid", string(busid[:end]))
-					p.buf.Next(40)
-					continue
-				}
-				return
-
-			case usbip.OpRepImport:
-				if consumed := p.parseOpRepImport(peek, clientToServer); consumed > 0 {
-					p.buf.Next(consumed)
-					continue
-				}
-				return
-			}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := &p.streams[1]
+	if clientToServer {
+		st = &p.streams[0]
+	}
+
+	// Payloads that are not decoded are skipped without buffering them.
+	if st.skip > 0 {
+		n := min(st.skip, len(data))
+		st.skip -= n
+		data = data[n:]
+	}
+	st.buf.Write(data)
+
+	for st.skip == 0 && st.buf.Len() >= st.need {
+		n, skip := p.decode(st.buf.Bytes(), clientToServer)
+		if n == 0 {
+			p.logger.Warn("Unknown USBIP data, skipping",
+				"dir", dirString(clientToServer),
+				"bytes", st.buf.Len())
+			st.buf.Reset()
+			st.need = 0
+			return
+		}
+		if n > st.buf.Len() {
+			st.need = n
+			return
 		}
+		st.buf.Next(n)
+		st.need = 0
+		dropped := min(skip, st.buf.Len())
+		st.buf.Next(dropped)
+		st.skip = skip - dropped
+	}
+}
+
+// decode logs the message at the start of b and returns its length and the
+// length of the payload following it that is skipped unread. A length
+// beyond len(b) is what the message needs before it can be decoded; 0 means
+// b is not USB-IP.
+func (p *Parser) decode(b []byte, clientToServer bool) (n, skip int) {
+	if len(b) < mgmtHeaderSize {
+		return mgmtHeaderSize, 0
+	}
 
-		if p.buf.Len() >= 48 { // 0x30 bytes
-			cmd := binary.BigEndian.Uint32(peek[0:4])
-
-			switch cmd {
-			case usbip.CmdSubmitCode:
-				p.parseCmdSubmit(peek, clientToServer)
-				p.buf.Next(48)
-				dir := binary.BigEndian.Uint32(peek[12:16])
-				xferLen := binary.BigEndian.Uint32(peek[24:28])
-				if dir == usbip.DirOut && xferLen > 0 && uint32(p.buf.Len()) >= xferLen {
-					p.buf.Next(int(xferLen))
-				}
-				continue
-
-			case usbip.RetSubmitCode:
-				p.parseRetSubmit(peek, clientToServer)
-				p.buf.Next(48)
-				actualLen := binary.BigEndian.Uint32(peek[24:28])
-				if actualLen > 0 && uint32(p.buf.Len()) >= actualLen {
-					p.buf.Next(int(actualLen))
-				}
-				continue
-
-			case usbip.CmdUnlinkCode:
-				p.parseCmdUnlink(peek, clientToServer)
-				p.buf.Next(48)
-				continue
-
-			case usbip.RetUnlinkCode:
-				p.parseRetUnlink(peek, clientToServer)
-				p.buf.Next(48)
-				continue
+	if binary.BigEndian.Uint16(b[0:2]) == usbip.Version {
+		switch binary.BigEndian.Uint16(b[2:4]) {
+		case usbip.OpReqDevlist:
+			p.logMgmtOp("OP_REQ_DEVLIST", clientToServer)
+			return mgmtHeaderSize, 0
+		case usbip.OpRepDevlist:
+			return p.parseOpRepDevlist(b, clientToServer), 0
+		case usbip.OpReqImport:
+			if len(b) < mgmtHeaderSize+32 {
+				return mgmtHeaderSize + 32, 0
 			}
+			p.logger.Info("USBIP packet",
+				"dir", dirString(clientToServer),
+				"op", "OP_REQ_IMPORT",
+				"busid", cString(b[8:40]))
+			return mgmtHeaderSize + 32, 0
+		case usbip.OpRepImport:
+			return p.parseOpRepImport(b, clientToServer), 0
 		}
+	}
 
-		if p.buf.Len() > 64*1024 {
-			p.logger.Warn("Parser buffer overflow, resetting")
-			p.buf.Reset()
-		}
-		return
+	if len(b) < urbHeaderSize {
+		return urbHeaderSize, 0
+	}
+	switch binary.BigEndian.Uint32(b[0:4]) {
+	case usbip.CmdSubmitCode:
+		return urbHeaderSize, p.parseCmdSubmit(b, clientToServer)
+	case usbip.RetSubmitCode:
+		return p.parseRetSubmit(b, clientToServer)
+	case usbip.CmdUnlinkCode:
+		p.parseCmdUnlink(b, clientToServer)
+		return urbHeaderSize, 0
+	case usbip.RetUnlinkCode:
+		p.parseRetUnlink(b, clientToServer)
+		return urbHeaderSize, 0
 	}
+	return 0, 0
 }
 
-func (p *Parser) parseCmdSubmit(data []byte, clientToServer bool) {
+// parseCmdSubmit logs a CMD_SUBMIT header and returns the size of the OUT
+// payload and iso descriptors after it.
+func (p *Parser) parseCmdSubmit(data []byte, clientToServer bool) int {
 	seqnum := binary.BigEndian.Uint32(data[4:8])
 	devid := binary.BigEndian.Uint32(data[8:12])
 	dir := binary.BigEndian.Uint32(data[12:16])
 	ep := binary.BigEndian.Uint32(data[16:20])
 	xferLen := binary.BigEndian.Uint32(data[24:28])
-	setup := data[40:48]
+	numPkts := binary.BigEndian.Uint32(data[32:36])
+	var setup [8]byte
+	copy(setup[:], data[40:48])
 
 	args := []any{
 		"dir", dirString(clientToServer),
@@ -140,19 +167,58 @@ func (p *Parser) parseCmdSubmit(data []byte, clientToServer bool) {
 	}
 
 	p.logger.Info("USBIP packet", args...)
+
+	p.pending[seqnum] = submitted{dir: dir, ep: ep, numPkts: numPkts, setup: setup}
+	skip := isoDescSize(numPkts)
+	if dir == usbip.DirOut {
+		skip += int(xferLen)
+	}
+	return skip
 }
 
-func (p *Parser) parseRetSubmit(data []byte, clientToServer bool) {
+// parseRetSubmit logs a RET_SUBMIT, with the descriptor it carries if it
+// answers a GET_DESCRIPTOR.
+func (p *Parser) parseRetSubmit(data []byte, clientToServer bool) (n, skip int) {
 	seqnum := binary.BigEndian.Uint32(data[4:8])
 	status := int32(binary.BigEndian.Uint32(data[20:24]))
 	actualLen := binary.BigEndian.Uint32(data[24:28])
 
-	p.logger.Info("USBIP packet",
+	// RET_SUBMIT leaves direction and endpoint to the CMD_SUBMIT it answers;
+	// without it, assume IN like most transfers.
+	sub, known := p.pending[seqnum]
+	if !known {
+		sub.dir = usbip.DirIn
+	}
+	payload := 0
+	if sub.dir == usbip.DirIn {
+		payload = int(actualLen)
+	}
+	skip = isoDescSize(binary.BigEndian.Uint32(data[32:36]))
+
+	descriptor := known && status == 0 && sub.ep == 0 && sub.dir == usbip.DirIn &&
+		sub.setup[0]&0x60 == 0 && sub.setup[1] == usbReqGetDescriptor
+	if descriptor && len(data) < urbHeaderSize+payload {
+		return urbHeaderSize + payload, 0
+	}
+
+	args := []any{
 		"dir", dirString(clientToServer),
 		"op", "RET_SUBMIT",
 		"seq", seqnum,
 		"status", status,
-		"actual_len", actualLen)
+		"actual_len", actualLen,
+	}
+	if known {
+		args = append(args, "ep", sub.ep, "urb_dir", urbDirString(sub.dir))
+	}
+	p.logger.Info("USBIP packet", args...)
+	delete(p.pending, seqnum)
+
+	if !descriptor {
+		return urbHeaderSize, payload + skip
+	}
+	p.logDescriptor(sub.setup, data[urbHeaderSize:urbHeaderSize+payload])
+	return urbHeaderSize + payload, skip
 }
 
 func (p *Parser) parseCmdUnlink(data []byte, clientToServer bool) {
@@ -164,6 +230,7 @@ func (p *Parser) parseCmdUnlink(data []byte, clientToServer bool) {
 		"op", "CMD_UNLINK",
 		"seq", seqnum,
 		"unlink_seq", unlinkSeq)
+	p.unlinks[seqnum] = unlinkSeq
 }
 
 func (p *Parser) parseRetUnlink(data []byte, clientToServer bool) {
@@ -175,6 +242,12 @@ func (p *Parser) parseRetUnlink(data []byte, clientToServer bool) {
 		"op", "RET_UNLINK",
 		"seq", seqnum,
 		"status", status)
+
+	// An unlinked URB gets no RET_SUBMIT.
+	if target, ok := p.unlinks[seqnum]; ok && status == errConnReset {
+		delete(p.pending, target)
+	}
+	delete(p.unlinks, seqnum)
 }
 
 func (p *Parser) logMgmtOp(op string, clientToServer bool) {
@@ -183,145 +256,222 @@ func (p *Parser) logMgmtOp(op string, clientToServer bool) {
 		"op", op)
 }
 
+// parseOpRepDevlist logs a complete OP_REP_DEVLIST and returns its length,
+// or the length it needs so far.
 func (p *Parser) parseOpRepDevlist(data []byte, clientToServer bool) int {
-	if len(data) < 12 {
-		return 0
+	status := binary.BigEndian.Uint32(data[4:8])
+	if status != 0 {
+		p.logger.Info("USBIP packet",
+			"dir", dirString(clientToServer),
+			"op", "OP_REP_DEVLIST",
+			"status", status)
+		return mgmtHeaderSize
 	}
 
+	// Device entry: 312 bytes base (path[256] + busid[32] + busid(4) + devid(4) + speed(4) + ids(8) + class(6))
+	// Plus 4 bytes per interface (class, subclass, protocol, padding)
+	offset := 12
+	if len(data) < offset {
+		return offset
+	}
 	nDevices := binary.BigEndian.Uint32(data[8:12])
+	for i := uint32(0); i < nDevices; i++ {
+		if len(data) < offset+exportedDevSize {
+			return offset + exportedDevSize
+		}
+		offset += exportedDevSize + 4*int(data[offset+exportedDevSize-1])
+		if len(data) < offset {
+			return offset
+		}
+	}
+
 	p.logger.Info("USBIP packet",
 		"dir", dirString(clientToServer),
 		"op", "OP_REP_DEVLIST",
 		"nDevices", nDevices)
-
-	offset := 12
+	offset = 12
 	for i := uint32(0); i < nDevices; i++ {
-		// Device entry: 312 bytes base (path[256] + busid[32] + busid(4) + devid(4) + speed(4) + ids(8) + class(6))
-		// Plus 4 bytes per interface (class, subclass, protocol, padding)
-		if len(data) < offset+312 {
-			return 0 // Need more data
-		}
-
-		path := data[offset : offset+256]
-		pathEnd := bytes.IndexByte(path, 0)
-		if pathEnd == -1 {
-			pathEnd = len(path)
-		}
-		pathStr := string(path[:pathEnd])
+		p.logger.Info("  Device", exportedDevice(data[offset:])...)
+		bNumInterfaces := data[offset+exportedDevSize-1]
+		offset += exportedDevSize
 
-		busid := data[offset+256 : offset+288]
-		busidEnd := bytes.IndexByte(busid, 0)
-		if busidEnd == -1 {
-			busidEnd = len(busid)
-		}
-		busidStr := string(busid[:busidEnd])
-
-		busnum := binary.BigEndian.Uint32(data[offset+288 : offset+292])
-		devnum := binary.BigEndian.Uint32(data[offset+292 : offset+296])
-		speed := binary.BigEndian.Uint32(data[offset+296 : offset+300])
-		idVendor := binary.BigEndian.Uint16(data[offset+300 : offset+302])
-		idProduct := binary.BigEndian.Uint16(data[offset+302 : offset+304])
-		bcdDevice := binary.BigEndian.Uint16(data[offset+304 : offset+306])
-		bDeviceClass := data[offset+306]
-		bDeviceSubClass := data[offset+307]
-		bDeviceProtocol := data[offset+308]
-		bConfigurationValue := data[offset+309]
-		bNumConfigurations := data[offset+310]
-		bNumInterfaces := data[offset+311]
-
-		p.logger.Info("  Device",
-			"path", pathStr,
-			"busid", busidStr,
-			"bus", busnum,
-			"dev", devnum,
-			"speed", speed,
-			"vid", fmt.Sprintf("%04x", idVendor),
-			"pid", fmt.Sprintf("%04x", idProduct),
-			"bcd", fmt.Sprintf("%04x", bcdDevice),
-			"class", fmt.Sprintf("%02x", bDeviceClass),
-			"subclass", fmt.Sprintf("%02x", bDeviceSubClass),
-			"protocol", fmt.Sprintf("%02x", bDeviceProtocol),
-			"config", bConfigurationValue,
-			"nConfigs", bNumConfigurations,
-			"nInterfaces", bNumInterfaces)
-
-		offset += 312
-
-		// Parse interfaces (4 bytes each)
 		for j := uint8(0); j < bNumInterfaces; j++ {
-			if len(data) < offset+4 {
-				return 0
-			}
-			ifClass := data[offset]
-			ifSubClass := data[offset+1]
-			ifProtocol := data[offset+2]
 			p.logger.Info("    Interface",
 				"num", j,
-				"class", fmt.Sprintf("%02x", ifClass),
-				"subclass", fmt.Sprintf("%02x", ifSubClass),
-				"protocol", fmt.Sprintf("%02x", ifProtocol))
+				"class", fmt.Sprintf("%02x", data[offset]),
+				"subclass", fmt.Sprintf("%02x", data[offset+1]),
+				"protocol", fmt.Sprintf("%02x", data[offset+2]))
 			offset += 4
 		}
 	}
-
 	return offset
 }
 
+// parseOpRepImport logs an OP_REP_IMPORT: the header alone if the import
+// failed, else the imported device. Unlike devlist entries it lists no
+// interfaces; they are logged with the configuration descriptor.
 func (p *Parser) parseOpRepImport(data []byte, clientToServer bool) int {
-	// OP_REP_IMPORT: 8 byte header + 312 byte device descriptor (same as devlist, but without interfaces)
-	if len(data) < 320 {
-		return 0
-	}
-
 	status := binary.BigEndian.Uint32(data[4:8])
+	if status != 0 {
+		p.logger.Info("USBIP packet",
+			"dir", dirString(clientToServer),
+			"op", "OP_REP_IMPORT",
+			"status", status)
+		return mgmtHeaderSize
+	}
+	if len(data) < mgmtHeaderSize+exportedDevSize {
+		return mgmtHeaderSize + exportedDevSize
+	}
 
-	path := data[8 : 8+256]
-	pathEnd := bytes.IndexByte(path, 0)
-	if pathEnd == -1 {
-		pathEnd = len(path)
-	}
-	pathStr := string(path[:pathEnd])
-
-	busid := data[264 : 264+32]
-	busidEnd := bytes.IndexByte(busid, 0)
-	if busidEnd == -1 {
-		busidEnd = len(busid)
-	}
-	busidStr := string(busid[:busidEnd])
-
-	busnum := binary.BigEndian.Uint32(data[296:300])
-	devnum := binary.BigEndian.Uint32(data[300:304])
-	speed := binary.BigEndian.Uint32(data[304:308])
-	idVendor := binary.BigEndian.Uint16(data[308:310])
-	idProduct := binary.BigEndian.Uint16(data[310:312])
-	bcdDevice := binary.BigEndian.Uint16(data[312:314])
-	bDeviceClass := data[314]
-	bDeviceSubClass := data[315]
-	bDeviceProtocol := data[316]
-	bConfigurationValue := data[317]
-	bNumConfigurations := data[318]
-	bNumInterfaces := data[319]
-
-	p.logger.Info("USBIP packet",
+	args := []any{
 		"dir", dirString(clientToServer),
 		"op", "OP_REP_IMPORT",
 		"status", status,
-		"path", pathStr,
-		"busid", busidStr,
-		"bus", busnum,
-		"dev", devnum,
-		"speed", speed,
-		"vid", fmt.Sprintf("%04x", idVendor),
-		"pid", fmt.Sprintf("%04x", idProduct),
-		"bcd", fmt.Sprintf("%04x", bcdDevice),
-		"class", fmt.Sprintf("%02x", bDeviceClass),
-		"subclass", fmt.Sprintf("%02x", bDeviceSubClass),
-		"protocol", fmt.Sprintf("%02x", bDeviceProtocol),
-		"config", bConfigurationValue,
-		"nConfigs", bNumConfigurations,
-		"nInterfaces", bNumInterfaces)
-
-	return 320
+	}
+	p.logger.Info("USBIP packet", append(args, exportedDevice(data[mgmtHeaderSize:])...)...)
+	return mgmtHeaderSize + exportedDevSize
+}
+
+// exportedDevice returns the log attributes of a 312-byte device entry.
+func exportedDevice(d []byte) []any {
+	return []any{
+		"path", cString(d[0:256]),
+		"busid", cString(d[256:288]),
+		"bus", binary.BigEndian.Uint32(d[288:292]),
+		"dev", binary.BigEndian.Uint32(d[292:296]),
+		"speed", binary.BigEndian.Uint32(d[296:300]),
+		"vid", fmt.Sprintf("%04x", binary.BigEndian.Uint16(d[300:302])),
+		"pid", fmt.Sprintf("%04x", binary.BigEndian.Uint16(d[302:304])),
+		"bcd", fmt.Sprintf("%04x", binary.BigEndian.Uint16(d[304:306])),
+		"class", fmt.Sprintf("%02x", d[306]),
+		"subclass", fmt.Sprintf("%02x", d[307]),
+		"protocol", fmt.Sprintf("%02x", d[308]),
+		"config", d[309],
+		"nConfigs", d[310],
+		"nInterfaces", d[311],
+	}
+}
+
+// Standard descriptor types decoded from GET_DESCRIPTOR replies.
+const (
+	usbReqGetDescriptor = 0x06
+
+	descDevice    = 0x01
+	descConfig    = 0x02
+	descString    = 0x03
+	descInterface = 0x04
+	descEndpoint  = 0x05
+	descHID       = 0x21
+)
+
+var endpointTypes = [4]string{"control", "isochronous", "bulk", "interrupt"}
+
+// logDescriptor logs a summary of the descriptor a GET_DESCRIPTOR returned.
+// Replies cut short by wLength are decoded as far as they go.
+func (p *Parser) logDescriptor(setup [8]byte, d []byte) {
+	if len(d) == 0 {
+		return
+	}
+	// The type requested, as class descriptors such as HID reports have no
+	// header of their own.
+	switch setup[3] {
+	case descDevice:
+		if len(d) < 18 {
+			break
+		}
+		p.logger.Info("  Device descriptor",
+			"usb", fmt.Sprintf("%04x", binary.LittleEndian.Uint16(d[2:4])),
+			"class", fmt.Sprintf("%02x", d[4]),
+			"subclass", fmt.Sprintf("%02x", d[5]),
+			"protocol", fmt.Sprintf("%02x", d[6]),
+			"maxPacket0", d[7],
+			"vid", fmt.Sprintf("%04x", binary.LittleEndian.Uint16(d[8:10])),
+			"pid", fmt.Sprintf("%04x", binary.LittleEndian.Uint16(d[10:12])),
+			"bcd", fmt.Sprintf("%04x", binary.LittleEndian.Uint16(d[12:14])),
+			"nConfigs", d[17])
+		return
+	case descConfig:
+		p.logConfigDescriptor(d)
+		return
+	case descString:
+		if setup[2] == 0 {
+			var langs []string
+			for i := 2; i+2 <= len(d); i += 2 {
+				langs = append(langs, fmt.Sprintf("%04x", binary.LittleEndian.Uint16(d[i:])))
+			}
+			p.logger.Info("  String descriptor", "index", 0, "langids", strings.Join(langs, ","))
+			return
+		}
+		units := make([]uint16, 0, len(d)/2)
+		for i := 2; i+2 <= len(d); i += 2 {
+			units = append(units, binary.LittleEndian.Uint16(d[i:]))
+		}
+		p.logger.Info("  String descriptor", "index", setup[2], "value", string(utf16.Decode(units)))
+		return
+	}
+	p.logger.Info("  Descriptor", "type", fmt.Sprintf("%02x", setup[3]), "len", len(d))
+}
+
+// logConfigDescriptor logs a configuration and the interfaces, endpoints
+// and other descriptors that follow it.
+func (p *Parser) logConfigDescriptor(d []byte) {
+	if len(d) < 9 {
+		return
+	}
+	p.logger.Info("  Configuration descriptor",
+		"totalLength", binary.LittleEndian.Uint16(d[2:4]),
+		"nInterfaces", d[4],
+		"config", d[5],
+		"attributes", fmt.Sprintf("%02x", d[7]),
+		"maxPower_mA", int(d[8])*2)
+
+	for i := int(d[0]); i+2 <= len(d); {
+		l := int(d[i])
+		if l < 2 || i+l > len(d) {
+			return
+		}
+		desc := d[i : i+l]
+		switch {
+		case desc[1] == descInterface && l >= 9:
+			p.logger.Info("    Interface",
+				"num", desc[2],
+				"alt", desc[3],
+				"nEndpoints", desc[4],
+				"class", fmt.Sprintf("%02x", desc[5]),
+				"subclass", fmt.Sprintf("%02x", desc[6]),
+				"protocol", fmt.Sprintf("%02x", desc[7]))
+		case desc[1] == descEndpoint && l >= 7:
+			p.logger.Info("      Endpoint",
+				"addr", fmt.Sprintf("%02x", desc[2]),
+				"type", endpointTypes[desc[3]&0x03],
+				"maxPacket", binary.LittleEndian.Uint16(desc[4:6]),
+				"interval", desc[6])
+		case desc[1] == descHID && l >= 9:
+			p.logger.Info("      HID",
+				"bcd", fmt.Sprintf("%04x", binary.LittleEndian.Uint16(desc[2:4])),
+				"reportLength", binary.LittleEndian.Uint16(desc[7:9]))
+		default:
+			p.logger.Info("      Descriptor", "type", fmt.Sprintf("%02x", desc[1]), "len", l)
+		}
+		i += l
+	}
+}
+
+// isoDescSize is the size of the iso packet descriptors of a URB with
+// numPkts packets; non-iso URBs have 0 or 0xffffffff.
+func isoDescSize(numPkts uint32) int {
+	if numPkts == 0 || numPkts == 0xffffffff {
+		return 0
+	}
+	return int(numPkts) * usbip.IsoPacketDescriptorSize
+}
+
+// cString returns b up to its first NUL.
+func cString(b []byte) string {
+	if end := bytes.IndexByte(b, 0); end >= 0 {
+		return string(b[:end])
+	}
+	return string(b)
 }
 
 func dirString(clientToServer bool) string {
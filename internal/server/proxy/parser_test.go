@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+)
+
+type message struct {
+	clientToServer bool
+	data           []byte
+}
+
+func frame(t *testing.T, parts ...func(io.Writer) error) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	for _, write := range parts {
+		require.NoError(t, write(&b))
+	}
+	return b.Bytes()
+}
+
+func payload(data []byte) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}
+}
+
+func cmdSubmit(seq, dir, ep, length uint32, setup [8]byte) func(io.Writer) error {
+	c := usbip.CmdSubmit{
+		Basic:             usbip.HeaderBasic{Command: usbip.CmdSubmitCode, Seqnum: seq, Devid: 0x00010002, Dir: dir, Ep: ep},
+		TransferBufferLen: length,
+		Setup:             setup,
+	}
+	return c.Write
+}
+
+func retSubmit(seq, actual uint32) func(io.Writer) error {
+	r := usbip.RetSubmit{
+		Basic:        usbip.HeaderBasic{Command: usbip.RetSubmitCode, Seqnum: seq},
+		ActualLength: actual,
+	}
+	return r.Write
+}
+
+func getDescriptor(typ, index uint8, length uint16) [8]byte {
+	return [8]byte{0x80, 0x06, index, typ, 0x00, 0x00, uint8(length), uint8(length >> 8)}
+}
+
+// session is an import of a DualShock 4 look-alike and a few transfers.
+func session(t *testing.T) []message {
+	dev := usbip.ExportedDevice{
+		ExportMeta:          usbip.ExportMeta{BusId: 1, DevId: 2},
+		Speed:               2,
+		IDVendor:            0x054c,
+		IDProduct:           0x09cc,
+		BcdDevice:           0x0100,
+		BConfigurationValue: 1,
+		BNumConfigurations:  1,
+		BNumInterfaces:      2,
+		Interfaces:          []usbip.InterfaceDesc{{Class: 0x01, SubClass: 0x01}, {Class: 0x03}},
+	}
+	copy(dev.Path[:], "/sys/devices/viiper/1-2")
+	copy(dev.USBBusId[:], "1-2")
+	other := dev
+	other.BusId, other.DevId, other.IDProduct = 1, 3, 0x05c4
+	other.BNumInterfaces, other.Interfaces = 1, dev.Interfaces[1:]
+	busid := make([]byte, 32)
+	copy(busid, "1-2")
+
+	deviceDesc := usb.Descriptor{Device: usb.DeviceDescriptor{
+		BcdUSB: 0x0200, BMaxPacketSize0: 64, IDVendor: 0x054c, IDProduct: 0x09cc,
+		BcdDevice: 0x0100, IProduct: 2, BNumConfigurations: 1,
+	}}.Bytes()
+	configDesc := []byte{
+		0x09, 0x02, 0x29, 0x00, 0x01, 0x01, 0x00, 0xc0, 0xfa, // configuration
+		0x09, 0x04, 0x00, 0x00, 0x02, 0x03, 0x00, 0x00, 0x00, // interface 0, HID
+		0x09, 0x21, 0x11, 0x01, 0x00, 0x01, 0x22, 0xfb, 0x01, // HID, 507-byte report
+		0x07, 0x05, 0x84, 0x03, 0x40, 0x00, 0x05, // endpoint 0x84 interrupt
+		0x07, 0x05, 0x03, 0x03, 0x40, 0x00, 0x05, // endpoint 0x03 interrupt
+	}
+	langs := []byte{0x04, 0x03, 0x09, 0x04}
+	product := usb.EncodeStringDescriptor("VIIPER")
+	input := bytes.Repeat([]byte{0x01}, 64)
+	output := bytes.Repeat([]byte{0x05}, 32)
+
+	unlink := usbip.CmdUnlink{Basic: usbip.HeaderBasic{Command: usbip.CmdUnlinkCode, Seqnum: 9}, UnlinkSeqnum: 8}
+	unlinked := usbip.RetUnlink{Basic: usbip.HeaderBasic{Command: usbip.RetUnlinkCode, Seqnum: 9}, Status: -104}
+
+	return []message{
+		{true, frame(t, (&usbip.MgmtHeader{Version: usbip.Version, Command: usbip.OpReqDevlist}).Write)},
+		{false, frame(t,
+			(&usbip.MgmtHeader{Version: usbip.Version, Command: usbip.OpRepDevlist}).Write,
+			(&usbip.DevListReplyHeader{NDevices: 2}).Write,
+			dev.WriteDevlist, other.WriteDevlist)},
+		{true, frame(t, (&usbip.MgmtHeader{Version: usbip.Version, Command: usbip.OpReqImport}).Write, payload(busid))},
+		{false, frame(t, (&usbip.MgmtHeader{Version: usbip.Version, Command: usbip.OpRepImport}).Write, dev.WriteImport)},
+		{true, frame(t, cmdSubmit(1, usbip.DirIn, 0, 18, getDescriptor(0x01, 0, 18)))},
+		{false, frame(t, retSubmit(1, 18), payload(deviceDesc))},
+		{true, frame(t, cmdSubmit(2, usbip.DirIn, 0, 255, getDescriptor(0x02, 0, 255)))},
+		{false, frame(t, retSubmit(2, uint32(len(configDesc))), payload(configDesc))},
+		{true, frame(t, cmdSubmit(3, usbip.DirIn, 0, 255, getDescriptor(0x03, 0, 255)))},
+		{false, frame(t, retSubmit(3, uint32(len(langs))), payload(langs))},
+		{true, frame(t, cmdSubmit(4, usbip.DirIn, 0, 255, getDescriptor(0x03, 2, 255)))},
+		{false, frame(t, retSubmit(4, uint32(len(product))), payload(product))},
+		{true, frame(t, cmdSubmit(5, usbip.DirIn, 0, 255, [8]byte{0x81, 0x06, 0x00, 0x22, 0x00, 0x00, 0xff, 0x00}))},
+		{false, frame(t, retSubmit(5, 255), payload(make([]byte, 255)))},
+		{true, frame(t, cmdSubmit(6, usbip.DirOut, 3, 32, [8]byte{}), payload(output))},
+		{false, frame(t, retSubmit(6, 32))},
+		{true, frame(t, cmdSubmit(7, usbip.DirIn, 4, 64, [8]byte{}))},
+		{false, frame(t, retSubmit(7, 64), payload(input))},
+		{true, frame(t, cmdSubmit(8, usbip.DirIn, 4, 64, [8]byte{}))},
+		{true, frame(t, unlink.Write)},
+		{false, frame(t, unlinked.Write)},
+	}
+}
+
+// parseLog feeds messages to a parser chunk bytes at a time (whole messages
+// for 0) and returns what it logged.
+func parseLog(messages []message, chunk int) string {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	p := NewParser(logger)
+	for _, m := range messages {
+		if chunk == 0 {
+			p.Parse(m.data, m.clientToServer)
+			continue
+		}
+		for i := 0; i < len(m.data); i += chunk {
+			p.Parse(m.data[i:min(i+chunk, len(m.data))], m.clientToServer)
+		}
+	}
+	return out.String()
+}
+
+func TestParserChunked(t *testing.T) {
+	messages := session(t)
+	whole := parseLog(messages, 0)
+
+	assert.Equal(t, whole, parseLog(messages, 1), "1-byte chunks")
+	assert.Equal(t, whole, parseLog(messages, 7), "7-byte chunks")
+	assert.NotContains(t, whole, "Unknown USBIP data")
+	assert.Equal(t, len(messages), strings.Count(whole, `msg="USBIP packet"`), "one line per message")
+
+	for _, want := range []string{
+		`op=OP_REP_DEVLIST nDevices=2`,
+		`msg="  Device" path=/sys/devices/viiper/1-2 busid=1-2 bus=1 dev=3 speed=2 vid=054c pid=05c4`,
+		`msg="    Interface" num=1 class=03 subclass=00 protocol=00`,
+		`op=OP_REQ_IMPORT busid=1-2`,
+		`op=OP_REP_IMPORT status=0 path=/sys/devices/viiper/1-2 busid=1-2 bus=1 dev=2 speed=2 vid=054c pid=09cc bcd=0100 class=00 subclass=00 protocol=00 config=1 nConfigs=1 nInterfaces=2`,
+		`msg="  Device descriptor" usb=0200 class=00 subclass=00 protocol=00 maxPacket0=64 vid=054c pid=09cc bcd=0100 nConfigs=1`,
+		`msg="  Configuration descriptor" totalLength=41 nInterfaces=1 config=1 attributes=c0 maxPower_mA=500`,
+		`msg="    Interface" num=0 alt=0 nEndpoints=2 class=03 subclass=00 protocol=00`,
+		`msg="      HID" bcd=0111 reportLength=507`,
+		`msg="      Endpoint" addr=84 type=interrupt maxPacket=64 interval=5`,
+		`msg="  String descriptor" index=0 langids=0409`,
+		`msg="  String descriptor" index=2 value=VIIPER`,
+		`msg="  Descriptor" type=22 len=255`,
+		`op=RET_SUBMIT seq=6 status=0 actual_len=32 ep=3 urb_dir=OUT`,
+		`op=RET_SUBMIT seq=7 status=0 actual_len=64 ep=4 urb_dir=IN`,
+		`op=RET_UNLINK seq=9 status=-104`,
+	} {
+		assert.Contains(t, whole, want)
+	}
+}
+
+func TestParserFailedImport(t *testing.T) {
+	busid := make([]byte, 32)
+	copy(busid, "9-9")
+	messages := []message{
+		{true, frame(t, (&usbip.MgmtHeader{Version: usbip.Version, Command: usbip.OpReqImport}).Write, payload(busid))},
+		{false, frame(t, (&usbip.MgmtHeader{Version: usbip.Version, Command: usbip.OpRepImport, Status: 1}).Write)},
+	}
+	whole := parseLog(messages, 0)
+	assert.Equal(t, whole, parseLog(messages, 1))
+	assert.Contains(t, whole, "op=OP_REP_IMPORT status=1\n", "an 8-byte reply")
+}
@@ -90,12 +90,14 @@ func (s *Server) handleProxy(clientConn net.Conn) {
 		return
 	}
 
+	raw := log.StreamOf(s.rawLogger)
+	parser := NewParser(s.logger)
 	var wg sync.WaitGroup
 	wg.Add(2)
 
 	go func() {
 		defer wg.Done()
-		bytes, err := s.copyWithLogging(upstreamConn, clientConn, true)
+		bytes, err := s.copyWithLogging(upstreamConn, clientConn, true, raw, parser)
 		if err != nil && !isExpectedDisconnect(err) {
 			s.logger.Debug("Client->Server copy error", "error", err)
 		}
@@ -106,7 +108,7 @@ func (s *Server) handleProxy(clientConn net.Conn) {
 
 	go func() {
 		defer wg.Done()
-		bytes, err := s.copyWithLogging(clientConn, upstreamConn, false)
+		bytes, err := s.copyWithLogging(clientConn, upstreamConn, false, raw, parser)
 		if err != nil && !isExpectedDisconnect(err) {
 			s.logger.Debug("Server->Client copy error", "error", err)
 		}
@@ -119,16 +121,15 @@ func (s *Server) handleProxy(clientConn net.Conn) {
 	s.logger.Info("Connection closed", "client", clientConn.RemoteAddr())
 }
 
-func (s *Server) copyWithLogging(dst net.Conn, src net.Conn, clientToServer bool) (int64, error) {
+func (s *Server) copyWithLogging(dst net.Conn, src net.Conn, clientToServer bool, raw log.RawLogger, parser *Parser) (int64, error) {
 	buf := make([]byte, 32*1024)
 	var total int64
-	parser := NewParser(s.logger)
 	firstPacket := true
 
 	for {
 		n, rerr := src.Read(buf)
 		if n > 0 {
-			s.rawLogger.Log(clientToServer, buf[:n])
+			raw.Log(clientToServer, buf[:n])
 
 			parser.Parse(buf[:n], clientToServer)
 
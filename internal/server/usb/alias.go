@@ -0,0 +1,48 @@
+package usb
+
+import "github.com/Alia5/VIIPER/usb"
+
+// AliasSource identifies the device an alias mirrors.
+type AliasSource struct {
+	Dev   usb.Device
+	BusID uint32
+	DevID uint32
+}
+
+// AddAlias makes alias serve its interrupt IN transfers from src.Dev, so both
+// devices report the same input state. OUT and control transfers stay with
+// alias, keeping host feedback separate.
+//
+// aliasMu only guards the alias map and is never held while calling into
+// buses or devices.
+func (s *Server) AddAlias(alias usb.Device, src AliasSource) {
+	s.aliasMu.Lock()
+	defer s.aliasMu.Unlock()
+	if s.aliases == nil {
+		s.aliases = make(map[usb.Device]AliasSource)
+	}
+	s.aliases[alias] = src
+}
+
+// RemoveAlias forgets alias; it is a no-op for other devices.
+func (s *Server) RemoveAlias(alias usb.Device) {
+	s.aliasMu.Lock()
+	defer s.aliasMu.Unlock()
+	delete(s.aliases, alias)
+}
+
+// AliasSourceOf reports the device mirrored by dev, if dev is an alias.
+func (s *Server) AliasSourceOf(dev usb.Device) (AliasSource, bool) {
+	s.aliasMu.RLock()
+	defer s.aliasMu.RUnlock()
+	src, ok := s.aliases[dev]
+	return src, ok
+}
+
+// inputSource returns the device whose input dev reports.
+func (s *Server) inputSource(dev usb.Device) usb.Device {
+	if src, ok := s.AliasSourceOf(dev); ok {
+		return src.Dev
+	}
+	return dev
+}
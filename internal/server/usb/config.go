@@ -4,8 +4,14 @@ import "time"
 
 // ServerConfig represents the server subcommand configuration.
 type ServerConfig struct {
-	Addr                    string        `help:"USB-IP server listen address" default:":3241" env:"VIIPER_USB_ADDR"`
-	ConnectionTimeout       time.Duration `kong:"-"`
-	BusCleanupTimeout       time.Duration `help:"-"`
-	WriteBatchFlushInterval time.Duration `help:"Interval to flush write batches to clients; 0 to disable" default:"1ms" env:"VIIPER_USB_WRITE_BATCH_FLUSH_INTERVAL"`
+	Addr                     string        `help:"USB-IP server listen address" default:":3241" env:"VIIPER_USB_ADDR"`
+	ConnectionTimeout        time.Duration `kong:"-"`
+	BusCleanupTimeout        time.Duration `help:"-"`
+	WriteBatchFlushInterval  time.Duration `help:"Interval to flush write batches to clients; 0 to disable" default:"1ms" env:"VIIPER_USB_WRITE_BATCH_FLUSH_INTERVAL"`
+	SlowHostThreshold        float64       `help:"Warn when the host polls interrupt endpoints this many times slower than their descriptors advertise; 0 to disable" default:"3" env:"VIIPER_USB_SLOW_HOST_THRESHOLD"`
+	SlowHostWindow           time.Duration `help:"Window over which host polling is averaged for the slow-host warning" default:"2s" env:"VIIPER_USB_SLOW_HOST_WINDOW"`
+	MaxConcurrentConnections int           `help:"Close connections beyond this many open ones immediately; 0 for no limit" default:"256" env:"VIIPER_USB_MAX_CONCURRENT_CONNECTIONS"`
+	ConnectionRate           float64       `help:"Connections per second accepted from one IP address; 0 for no limit" default:"20" env:"VIIPER_USB_CONNECTION_RATE"`
+	ConnectionBurst          int           `help:"Connections one IP address may open at once before ConnectionRate applies" default:"32" env:"VIIPER_USB_CONNECTION_BURST"`
+	IdleTimeout              time.Duration `help:"Close connections that import a device but send no URB within this time; 0 to disable" default:"30s" env:"VIIPER_USB_IDLE_TIMEOUT"`
 }
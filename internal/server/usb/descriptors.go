@@ -0,0 +1,145 @@
+package usb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// descriptorCache holds the serialized descriptors of one usb.Descriptor so
+// GET_DESCRIPTOR requests are served without rebuilding them. Cached slices
+// are shared and must not be modified.
+type descriptorCache struct {
+	once    sync.Once
+	device  []byte
+	config  []byte
+	strings map[uint8][]byte
+	// bos and msos20 are the BOS descriptor and the MS OS 2.0 descriptor
+	// set, read with the vendor request msosCode; nil if the device has none.
+	bos      []byte
+	msos20   []byte
+	msosCode uint8
+	// ifaces maps interface numbers to descriptor type to bytes. An empty,
+	// non-nil entry marks a descriptor that failed to build and must stall.
+	ifaces map[uint8]map[uint8][]byte
+	// endpoints holds the endpoint addresses; interfaces maps interface
+	// numbers to whether they are HID; settings holds the interface number
+	// and alternate setting pairs.
+	endpoints  map[uint8]bool
+	interfaces map[uint8]bool
+	settings   map[[2]uint8]bool
+	// maxPackets maps interrupt endpoint addresses to their wMaxPacketSize.
+	maxPackets map[uint8]int
+	// inputEndpoint is the first interrupt IN endpoint, which carries input
+	// reports.
+	inputEndpoint uint8
+	// intervals maps interrupt endpoint addresses to their polling interval.
+	intervals map[uint8]time.Duration
+}
+
+// descriptors returns the cache for desc, building it on first use.
+// Caches are keyed by descriptor pointer, so a device swapping in a new
+// descriptor is served from a fresh cache.
+func (s *Server) descriptors(desc *usb.Descriptor) *descriptorCache {
+	v, ok := s.descCache.Load(desc)
+	if !ok {
+		v, _ = s.descCache.LoadOrStore(desc, &descriptorCache{})
+	}
+	c := v.(*descriptorCache)
+	c.once.Do(func() { c.build(s, desc) })
+	return c
+}
+
+// dropDescriptors evicts the cache for desc.
+func (s *Server) dropDescriptors(desc *usb.Descriptor) {
+	s.descCache.Delete(desc)
+}
+
+func (c *descriptorCache) build(s *Server, desc *usb.Descriptor) {
+	c.device = desc.Bytes()
+	c.config = s.buildConfigDescriptor(desc)
+	c.strings = make(map[uint8][]byte, len(desc.Strings))
+	for idx, str := range desc.Strings {
+		c.strings[idx] = usb.EncodeStringDescriptor(str)
+	}
+	if m := desc.MSOS20; m != nil {
+		c.bos = m.BOS()
+		c.msos20 = m.DescriptorSet()
+		c.msosCode = m.Code()
+	}
+	c.ifaces = make(map[uint8]map[uint8][]byte, len(desc.Interfaces))
+	c.maxPackets = make(map[uint8]int)
+	c.endpoints = make(map[uint8]bool)
+	c.interfaces = make(map[uint8]bool, len(desc.Interfaces))
+	c.settings = make(map[[2]uint8]bool, len(desc.Interfaces))
+	c.intervals = make(map[uint8]time.Duration)
+	for _, ifaceConf := range desc.Interfaces {
+		i := ifaceConf.Descriptor.BInterfaceNumber
+		c.interfaces[i] = c.interfaces[i] || ifaceConf.HID != nil
+		c.settings[[2]uint8{i, ifaceConf.Descriptor.BAlternateSetting}] = true
+		for _, ep := range ifaceConf.Endpoints {
+			c.endpoints[ep.BEndpointAddress] = true
+			if ep.Type() == usb.EndpointTypeInterrupt {
+				c.maxPackets[ep.BEndpointAddress] = int(ep.WMaxPacketSize & 0x7ff)
+				c.intervals[ep.BEndpointAddress] = pollInterval(desc.Device.Speed, ep.BInterval)
+			}
+			if c.inputEndpoint == 0 && ep.BEndpointAddress&0x80 != 0 && ep.Type() == usb.EndpointTypeInterrupt {
+				c.inputEndpoint = ep.BEndpointAddress
+			}
+		}
+		m := c.ifaces[i]
+		if m == nil {
+			m = make(map[uint8][]byte)
+			c.ifaces[i] = m
+		}
+		if ifaceConf.HID != nil && m[usbDescTypeHID] == nil {
+			if d, err := ifaceConf.HID.DescriptorBytes(); err != nil {
+				s.logger.Error("failed to build HID descriptor", "iface", i, "error", err)
+				m[usbDescTypeHID] = []byte{}
+			} else if len(d) > 0 {
+				m[usbDescTypeHID] = d
+			}
+			if d, err := ifaceConf.HID.ReportBytes(); err != nil {
+				s.logger.Error("failed to build HID report descriptor", "iface", i, "error", err)
+				m[usbDescTypeHIDReport] = []byte{}
+			} else if len(d) > 0 {
+				m[usbDescTypeHIDReport] = d
+			}
+		}
+		for _, cd := range ifaceConf.ClassDescriptors {
+			if _, ok := m[cd.DescriptorType]; !ok {
+				m[cd.DescriptorType] = cd.Bytes()
+			}
+		}
+	}
+}
+
+// standard returns a device-level descriptor, or nil if there is none.
+func (c *descriptorCache) standard(dtype, dindex uint8) []byte {
+	switch dtype {
+	case usbDescTypeDevice:
+		return c.device
+	case usbDescTypeConfiguration:
+		return c.config
+	case usbDescTypeString:
+		return c.strings[dindex]
+	case usbDescTypeBOS:
+		return c.bos
+	}
+	return nil
+}
+
+// msosDescriptorSet returns the MS OS 2.0 descriptor set if the request is
+// the vendor request reading it.
+func (c *descriptorCache) msosDescriptorSet(bm, breq uint8, wIndex uint16) ([]byte, bool) {
+	if c.msos20 == nil || bm != usbReqTypeVendorFromDevice || breq != c.msosCode || wIndex != usb.MSOS20DescriptorIndex {
+		return nil, false
+	}
+	return c.msos20, true
+}
+
+// iface returns an interface-level descriptor, or nil if there is none.
+func (c *descriptorCache) iface(iface, dtype uint8) []byte {
+	return c.ifaces[iface][dtype]
+}
@@ -0,0 +1,404 @@
+package usb_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/device/dualshock4"
+	"github.com/Alia5/VIIPER/device/joystick"
+	"github.com/Alia5/VIIPER/device/keyboard"
+	"github.com/Alia5/VIIPER/device/mouse"
+	"github.com/Alia5/VIIPER/device/switchpro"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	"github.com/Alia5/VIIPER/device/xboxone"
+	viiperUsb "github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/usb"
+)
+
+func newTestDevices(t testing.TB) map[string]usb.Device {
+	t.Helper()
+	x360, err := xbox360.New(nil)
+	require.NoError(t, err)
+	ds4, err := dualshock4.New(nil)
+	require.NoError(t, err)
+	kb, err := keyboard.New(nil)
+	require.NoError(t, err)
+	m, err := mouse.New(nil)
+	require.NoError(t, err)
+	return map[string]usb.Device{"xbox360": x360, "dualshock4": ds4, "keyboard": kb, "mouse": m}
+}
+
+const (
+	reqGetDescriptor      = 0x06
+	reqTypeFromDevice     = 0x80
+	reqTypeToInterface    = 0x81
+	descTypeDevice        = 0x01
+	descTypeConfiguration = 0x02
+	descTypeString        = 0x03
+	descTypeHID           = 0x21
+	descTypeHIDReport     = 0x22
+)
+
+func newDescriptorTestServer() *viiperUsb.Server {
+	return viiperUsb.New(viiperUsb.ServerConfig{}, slog.New(slog.DiscardHandler), nil)
+}
+
+func getDescriptorSetup(bm, dtype, dindex, iface uint8, wLength uint16) []byte {
+	setup := []byte{bm, reqGetDescriptor, dindex, dtype, iface, 0, 0, 0}
+	binary.LittleEndian.PutUint16(setup[6:8], wLength)
+	return setup
+}
+
+// onTheFlyDescriptor serializes a descriptor the way ProcessSubmit did before
+// descriptors were cached; it is the reference the cache must reproduce.
+func onTheFlyDescriptor(s *viiperUsb.Server, desc *usb.Descriptor, bm, dtype, dindex, iface uint8) []byte {
+	var data []byte
+	if bm == reqTypeFromDevice {
+		switch dtype {
+		case descTypeDevice:
+			data = desc.Bytes()
+		case descTypeConfiguration:
+			data = s.BuildConfigDescriptor(desc)
+		case descTypeString:
+			if str, ok := desc.Strings[dindex]; ok {
+				data = usb.EncodeStringDescriptor(str)
+			}
+		}
+		return data
+	}
+	if int(iface) >= len(desc.Interfaces) {
+		return nil
+	}
+	ifaceConf := desc.Interfaces[iface]
+	if ifaceConf.HID != nil {
+		switch dtype {
+		case descTypeHID:
+			d, err := ifaceConf.HID.DescriptorBytes()
+			if err != nil {
+				return nil
+			}
+			data = d
+		case descTypeHIDReport:
+			d, err := ifaceConf.HID.ReportBytes()
+			if err != nil {
+				return nil
+			}
+			data = d
+		}
+	}
+	if len(data) == 0 {
+		for _, cd := range ifaceConf.ClassDescriptors {
+			if cd.DescriptorType == dtype {
+				return cd.Bytes()
+			}
+		}
+	}
+	return data
+}
+
+func TestDescriptorCacheMatchesSerialization(t *testing.T) {
+	s := newDescriptorTestServer()
+	for name, dev := range newTestDevices(t) {
+		t.Run(name, func(t *testing.T) {
+			desc := dev.GetDescriptor()
+			type request struct{ bm, dtype, dindex, iface uint8 }
+			reqs := []request{
+				{reqTypeFromDevice, descTypeDevice, 0, 0},
+				{reqTypeFromDevice, descTypeConfiguration, 0, 0},
+				{reqTypeFromDevice, descTypeString, 0xee, 0},
+			}
+			for idx := range desc.Strings {
+				reqs = append(reqs, request{reqTypeFromDevice, descTypeString, idx, 0})
+			}
+			for i, ifaceConf := range desc.Interfaces {
+				types := []uint8{descTypeHID, descTypeHIDReport, 0x7f}
+				for _, cd := range ifaceConf.ClassDescriptors {
+					types = append(types, cd.DescriptorType)
+				}
+				for _, dtype := range types {
+					reqs = append(reqs, request{reqTypeToInterface, dtype, 0, uint8(i)})
+				}
+			}
+			reqs = append(reqs, request{reqTypeToInterface, descTypeHID, 0, uint8(len(desc.Interfaces))})
+
+			for _, r := range reqs {
+				want := onTheFlyDescriptor(s, desc, r.bm, r.dtype, r.dindex, r.iface)
+				got, status := s.ProcessSubmit(dev, 0, 0, getDescriptorSetup(r.bm, r.dtype, r.dindex, r.iface, 0xffff), nil)
+				if len(want) == 0 {
+					assert.Empty(t, got, "request %+v", r)
+					assert.Equal(t, int32(-32), status, "missing descriptors stall: %+v", r)
+					continue
+				}
+				assert.Equal(t, want, got, "request %+v", r)
+			}
+		})
+	}
+}
+
+func TestDescriptorIdentityOverrides(t *testing.T) {
+	vid, pid := uint16(0x1209), uint16(0x0001)
+	serial, product := "VIIPER-0042", "Test Pad ü"
+	opts := func() *device.CreateOptions {
+		return &device.CreateOptions{IdVendor: &vid, IdProduct: &pid, SerialNumber: &serial, ProductString: &product}
+	}
+	constructors := map[string]func(*device.CreateOptions) (usb.Device, error){
+		"xbox360":    func(o *device.CreateOptions) (usb.Device, error) { return xbox360.New(o) },
+		"dualshock4": func(o *device.CreateOptions) (usb.Device, error) { return dualshock4.New(o) },
+		"switchpro":  func(o *device.CreateOptions) (usb.Device, error) { return switchpro.New(o) },
+		"xboxone":    func(o *device.CreateOptions) (usb.Device, error) { return xboxone.New(o) },
+		"joystick":   func(o *device.CreateOptions) (usb.Device, error) { return joystick.New(o) },
+		"keyboard":   func(o *device.CreateOptions) (usb.Device, error) { return keyboard.New(o) },
+		"mouse":      func(o *device.CreateOptions) (usb.Device, error) { return mouse.New(o) },
+	}
+	s := newDescriptorTestServer()
+	getString := func(dev usb.Device, idx uint8) []byte {
+		data, _ := s.ProcessSubmit(dev, 0, 0, getDescriptorSetup(reqTypeFromDevice, descTypeString, idx, 0, 0xffff), nil)
+		return data
+	}
+	for name, newDev := range constructors {
+		t.Run(name, func(t *testing.T) {
+			plain, err := newDev(nil)
+			require.NoError(t, err)
+			plainDesc, _ := s.ProcessSubmit(plain, 0, 0, getDescriptorSetup(reqTypeFromDevice, descTypeDevice, 0, 0, 0xffff), nil)
+			plainProduct := getString(plain, plainDesc[15])
+
+			dev, err := newDev(opts())
+			require.NoError(t, err)
+			desc, _ := s.ProcessSubmit(dev, 0, 0, getDescriptorSetup(reqTypeFromDevice, descTypeDevice, 0, 0, 0xffff), nil)
+			require.Len(t, desc, 18)
+			assert.Equal(t, vid, binary.LittleEndian.Uint16(desc[8:10]))
+			assert.Equal(t, pid, binary.LittleEndian.Uint16(desc[10:12]))
+			iProduct, iSerial := desc[15], desc[16]
+			require.NotZero(t, iProduct)
+			require.NotZero(t, iSerial)
+			assert.Equal(t, usb.EncodeStringDescriptor(product), getString(dev, iProduct))
+			assert.Equal(t, usb.EncodeStringDescriptor(serial), getString(dev, iSerial))
+
+			again, err := newDev(nil)
+			require.NoError(t, err)
+			assert.Equal(t, plainProduct, getString(again, plainDesc[15]), "defaults are left alone")
+		})
+	}
+}
+
+func TestDescriptorCacheTruncation(t *testing.T) {
+	s := newDescriptorTestServer()
+	dev, err := xbox360.New(nil)
+	require.NoError(t, err)
+
+	full, _ := s.ProcessSubmit(dev, 0, 0, getDescriptorSetup(reqTypeFromDevice, descTypeConfiguration, 0, 0, 0xffff), nil)
+	require.Greater(t, len(full), usb.ConfigDescLen)
+	head, _ := s.ProcessSubmit(dev, 0, 0, getDescriptorSetup(reqTypeFromDevice, descTypeConfiguration, 0, 0, usb.ConfigDescLen), nil)
+	require.Len(t, head, usb.ConfigDescLen)
+	assert.Same(t, &full[0], &head[0], "truncated responses slice the cached bytes")
+}
+
+func TestDescriptorCacheConcurrentBuild(t *testing.T) {
+	s := newDescriptorTestServer()
+	dev, err := dualshock4.New(nil)
+	require.NoError(t, err)
+
+	caches := make([]any, 50)
+	var wg sync.WaitGroup
+	for i := range caches {
+		wg.Go(func() { caches[i] = s.DescriptorCache(dev.GetDescriptor()) })
+	}
+	wg.Wait()
+	for _, c := range caches {
+		assert.Same(t, caches[0], c)
+	}
+
+	s.DropDescriptors(dev.GetDescriptor())
+	assert.NotSame(t, caches[0], s.DescriptorCache(dev.GetDescriptor()), "dropped caches are rebuilt")
+}
+
+// ds4AudioInterfaces are the audio interfaces of a real DualShock 4
+// (CUH-ZCT1) configuration descriptor, followed by its HID interface up to
+// the report descriptor length.
+var ds4AudioInterfaces = []byte{
+	0x09, 0x04, 0x00, 0x00, 0x00, 0x01, 0x01, 0x00, 0x00,
+	0x0a, 0x24, 0x01, 0x00, 0x01, 0x47, 0x00, 0x02, 0x01, 0x02,
+	0x0c, 0x24, 0x02, 0x01, 0x01, 0x01, 0x06, 0x02, 0x03, 0x00, 0x00, 0x00,
+	0x0a, 0x24, 0x06, 0x02, 0x01, 0x01, 0x03, 0x00, 0x00, 0x00,
+	0x09, 0x24, 0x03, 0x03, 0x02, 0x04, 0x04, 0x02, 0x00,
+	0x0c, 0x24, 0x02, 0x04, 0x02, 0x04, 0x03, 0x01, 0x00, 0x00, 0x00, 0x00,
+	0x09, 0x24, 0x06, 0x05, 0x04, 0x01, 0x03, 0x00, 0x00,
+	0x09, 0x24, 0x03, 0x06, 0x01, 0x01, 0x01, 0x05, 0x00,
+	0x09, 0x04, 0x01, 0x00, 0x00, 0x01, 0x02, 0x00, 0x00,
+	0x09, 0x04, 0x01, 0x01, 0x01, 0x01, 0x02, 0x00, 0x00,
+	0x07, 0x24, 0x01, 0x01, 0x01, 0x01, 0x00,
+	0x0b, 0x24, 0x02, 0x01, 0x02, 0x02, 0x10, 0x01, 0x00, 0x7d, 0x00,
+	0x09, 0x05, 0x01, 0x09, 0x84, 0x00, 0x01, 0x00, 0x00,
+	0x07, 0x25, 0x01, 0x00, 0x00, 0x00, 0x00,
+	0x09, 0x04, 0x02, 0x00, 0x00, 0x01, 0x02, 0x00, 0x00,
+	0x09, 0x04, 0x02, 0x01, 0x01, 0x01, 0x02, 0x00, 0x00,
+	0x07, 0x24, 0x01, 0x06, 0x01, 0x01, 0x00,
+	0x0b, 0x24, 0x02, 0x01, 0x01, 0x02, 0x10, 0x01, 0x80, 0x3e, 0x00,
+	0x09, 0x05, 0x82, 0x05, 0x22, 0x00, 0x01, 0x00, 0x00,
+	0x07, 0x25, 0x01, 0x00, 0x00, 0x00, 0x00,
+	0x09, 0x04, 0x03, 0x00, 0x02, 0x03, 0x00, 0x00, 0x00,
+	0x09, 0x21, 0x11, 0x01, 0x00, 0x01, 0x22,
+}
+
+func TestDualShock4AudioStubDescriptor(t *testing.T) {
+	s := newDescriptorTestServer()
+	dev, err := dualshock4.New(&device.CreateOptions{DeviceSpecific: map[string]any{"audioStub": true}})
+	require.NoError(t, err)
+	report, err := dev.GetDescriptor().Interfaces[5].HID.ReportBytes()
+	require.NoError(t, err)
+
+	want := binary.LittleEndian.AppendUint16(bytes.Clone(ds4AudioInterfaces), uint16(len(report)))
+	want = append(want,
+		0x07, 0x05, 0x84, 0x03, 0x40, 0x00, 0x05,
+		0x07, 0x05, 0x03, 0x03, 0x40, 0x00, 0x05,
+	)
+	config, status := s.ProcessSubmit(dev, 0, 0, getDescriptorSetup(reqTypeFromDevice, descTypeConfiguration, 0, 0, 0xffff), nil)
+	require.Equal(t, int32(0), status)
+	require.Greater(t, len(config), usb.ConfigDescLen)
+	assert.Equal(t, uint16(225), binary.LittleEndian.Uint16(config[2:4]), "wTotalLength")
+	assert.Equal(t, uint8(4), config[4], "bNumInterfaces")
+	assert.Equal(t, want, config[usb.ConfigDescLen:])
+
+	got, status := s.ProcessSubmit(dev, 0, 0, getDescriptorSetup(reqTypeToInterface, descTypeHIDReport, 0, 3, 0xffff), nil)
+	assert.Equal(t, int32(0), status)
+	assert.Equal(t, []byte(report), got, "the HID report descriptor is served on interface 3")
+	_, status = s.ProcessSubmit(dev, 0, 0, getDescriptorSetup(reqTypeToInterface, descTypeHIDReport, 0, 0, 0xffff), nil)
+	assert.Equal(t, int32(-32), status)
+
+	setInterface := func(iface, alt uint8) int32 {
+		_, status := s.ProcessSubmit(dev, 0, 0, []byte{0x01, 0x0b, alt, 0, iface, 0, 0, 0}, nil)
+		return status
+	}
+	assert.Equal(t, int32(0), setInterface(1, 1))
+	assert.Equal(t, int32(0), setInterface(2, 0))
+	assert.Equal(t, int32(-32), setInterface(0, 1), "the control interface has no alternate setting")
+	assert.Equal(t, int32(-32), setInterface(2, 2))
+	assert.Equal(t, int32(-32), setInterface(4, 0))
+
+	plain, err := dualshock4.New(nil)
+	require.NoError(t, err)
+	config, _ = s.ProcessSubmit(plain, 0, 0, getDescriptorSetup(reqTypeFromDevice, descTypeConfiguration, 0, 0, 0xffff), nil)
+	assert.Equal(t, uint8(1), config[4], "HID only without the audio stub")
+}
+
+// BenchmarkEnumeration enumerates 50 devices concurrently per iteration, each
+// requesting descriptors the way Linux and Windows hosts do on attach.
+func BenchmarkEnumeration(b *testing.B) {
+	s := newDescriptorTestServer()
+	var devs []usb.Device
+	for len(devs) < 50 {
+		for _, dev := range newTestDevices(b) {
+			devs = append(devs, dev)
+		}
+	}
+	devs = devs[:50]
+
+	enumerate := func(serve func(dev usb.Device, setup []byte) []byte) {
+		var wg sync.WaitGroup
+		for _, dev := range devs {
+			wg.Go(func() {
+				desc := dev.GetDescriptor()
+				serve(dev, getDescriptorSetup(reqTypeFromDevice, descTypeDevice, 0, 0, 8))
+				serve(dev, getDescriptorSetup(reqTypeFromDevice, descTypeDevice, 0, 0, 18))
+				for range 3 {
+					serve(dev, getDescriptorSetup(reqTypeFromDevice, descTypeConfiguration, 0, 0, usb.ConfigDescLen))
+					serve(dev, getDescriptorSetup(reqTypeFromDevice, descTypeConfiguration, 0, 0, 0xffff))
+				}
+				for idx := range desc.Strings {
+					serve(dev, getDescriptorSetup(reqTypeFromDevice, descTypeString, idx, 0, 0xff))
+				}
+				for i, ifaceConf := range desc.Interfaces {
+					if ifaceConf.HID != nil {
+						serve(dev, getDescriptorSetup(reqTypeToInterface, descTypeHIDReport, 0, uint8(i), 0xffff))
+					}
+				}
+			})
+		}
+		wg.Wait()
+	}
+
+	b.Run("on-the-fly", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			enumerate(func(dev usb.Device, setup []byte) []byte {
+				dtype, dindex, iface := setup[3], setup[2], setup[4]
+				return onTheFlyDescriptor(s, dev.GetDescriptor(), setup[0], dtype, dindex, iface)
+			})
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			for _, dev := range devs {
+				s.DropDescriptors(dev.GetDescriptor())
+			}
+			enumerate(func(dev usb.Device, setup []byte) []byte {
+				resp, _ := s.ProcessSubmit(dev, 0, 0, setup, nil)
+				return resp
+			})
+		}
+	})
+}
+
+func TestMSOSDescriptors(t *testing.T) {
+	s := newDescriptorTestServer()
+	setRequest := []byte{0xC0, usb.MSOS20VendorCode, 0x00, 0x00, usb.MSOS20DescriptorIndex, 0x00, 0xFF, 0x00}
+	submit := func(t *testing.T, dev usb.Device, setup []byte) []byte {
+		t.Helper()
+		resp, status := s.ProcessSubmit(dev, 0, 0, setup, nil)
+		require.Zero(t, status)
+		return resp
+	}
+
+	t.Run("xbox360 default", func(t *testing.T) {
+		pid := uint16(0xBEEF)
+		dev, err := xbox360.New(&device.CreateOptions{IdProduct: &pid})
+		require.NoError(t, err)
+
+		dd := submit(t, dev, getDescriptorSetup(reqTypeFromDevice, descTypeDevice, 0, 0, 18))
+		assert.Equal(t, []byte{0x01, 0x02}, dd[2:4], "bcdUSB 2.01 announces the BOS descriptor")
+		assert.Equal(t, []byte{0xEF, 0xBE}, dd[10:12])
+
+		header := submit(t, dev, getDescriptorSetup(reqTypeFromDevice, usb.BOSDescType, 0, 0, 5))
+		assert.Equal(t, []byte{0x05, 0x0F, 0x21, 0x00, 0x01}, header)
+		bos := submit(t, dev, getDescriptorSetup(reqTypeFromDevice, usb.BOSDescType, 0, 0, 0x21))
+		assert.Equal(t, (&usb.MSOS20{CompatibleID: "XUSB10"}).BOS(), bos)
+
+		assert.Equal(t, []byte{
+			0x0A, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x06, 0x1E, 0x00,
+			0x14, 0x00, 0x03, 0x00,
+			'X', 'U', 'S', 'B', '1', '0', 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		}, submit(t, dev, setRequest))
+	})
+
+	t.Run("custom IDs", func(t *testing.T) {
+		dev, err := xbox360.New(&device.CreateOptions{MSOS20: &usb.MSOS20{CompatibleID: "XUSB20", SubCompatibleID: "01"}})
+		require.NoError(t, err)
+		set := submit(t, dev, setRequest)
+		require.Len(t, set, 30)
+		assert.Equal(t, "XUSB20\x00\x0001\x00\x00\x00\x00\x00\x00", string(set[14:]))
+	})
+
+	t.Run("off", func(t *testing.T) {
+		dev, err := xbox360.New(&device.CreateOptions{MSOS20: &usb.MSOS20{}})
+		require.NoError(t, err)
+		dd := submit(t, dev, getDescriptorSetup(reqTypeFromDevice, descTypeDevice, 0, 0, 18))
+		assert.Equal(t, []byte{0x00, 0x02}, dd[2:4])
+		_, status := s.ProcessSubmit(dev, 0, 0, getDescriptorSetup(reqTypeFromDevice, usb.BOSDescType, 0, 0, 5), nil)
+		assert.Equal(t, int32(-32), status, "no BOS descriptor")
+		assert.Empty(t, submit(t, dev, setRequest), "the pad accepts vendor requests")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := xbox360.New(&device.CreateOptions{MSOS20: &usb.MSOS20{CompatibleID: "TOOLONGID"}})
+		assert.ErrorContains(t, err, "longer than 8 characters")
+	})
+}
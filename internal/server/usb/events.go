@@ -0,0 +1,115 @@
+package usb
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// EventType is the kind of an Event: BusCreated, BusRemoved or one of the
+// virtualbus.DeviceEventType values.
+type EventType string
+
+const (
+	EventBusCreated EventType = "BusCreated"
+	EventBusRemoved EventType = "BusRemoved"
+)
+
+// Event is a change of the buses or devices of the server.
+type Event struct {
+	Type  EventType
+	BusID uint32
+	DevID uint32 // 0 for bus events
+	ID    string // DevID as addressed through the API, see virtualbus.DeviceID
+	Time  time.Time
+	// Seq numbers the events of the server from 1, in the order they are
+	// published.
+	Seq uint64
+}
+
+// Subscription receives the events of the server. A subscriber that falls
+// behind loses the oldest events rather than holding up the server; Dropped
+// counts them.
+type Subscription struct {
+	c       chan Event
+	dropped atomic.Uint64
+	hub     *eventHub
+}
+
+// C returns the channel events are delivered on. It is closed by Close.
+func (sub *Subscription) C() <-chan Event { return sub.c }
+
+// Dropped returns the number of events lost so far.
+func (sub *Subscription) Dropped() uint64 { return sub.dropped.Load() }
+
+// Close ends the subscription.
+func (sub *Subscription) Close() {
+	sub.hub.mu.Lock()
+	defer sub.hub.mu.Unlock()
+	if _, ok := sub.hub.subs[sub]; ok {
+		delete(sub.hub.subs, sub)
+		close(sub.c)
+	}
+}
+
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+	seq  uint64
+}
+
+// SubscribeEvents subscribes to the events of the server, keeping up to
+// buffer events the subscriber has not received yet.
+func (s *Server) SubscribeEvents(buffer int) *Subscription {
+	sub := &Subscription{c: make(chan Event, max(buffer, 1)), hub: &s.events}
+	s.events.mu.Lock()
+	defer s.events.mu.Unlock()
+	if s.events.subs == nil {
+		s.events.subs = make(map[*Subscription]struct{})
+	}
+	s.events.subs[sub] = struct{}{}
+	return sub
+}
+
+// publish counts e and delivers it to the subscribers.
+func (s *Server) publish(e Event) {
+	s.m.events.With(string(e.Type)).Inc()
+	s.events.publish(e)
+}
+
+// publish delivers e to every subscriber without blocking.
+func (h *eventHub) publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e.Time = time.Now()
+	h.seq++
+	e.Seq = h.seq
+	for sub := range h.subs {
+		select {
+		case sub.c <- e:
+			continue
+		default:
+		}
+		// Full: make room by dropping the oldest event. Only publish, under
+		// h.mu, adds events, so the send below cannot block.
+		select {
+		case <-sub.c:
+			sub.dropped.Add(1)
+		default:
+		}
+		sub.c <- e
+	}
+}
+
+// watchBus publishes the device events of b.
+func (s *Server) watchBus(b *virtualbus.VirtualBus) {
+	b.OnDeviceEvent(func(e virtualbus.DeviceEvent) {
+		s.publish(Event{Type: EventType(e.Type), BusID: e.BusID, DevID: e.DevID, ID: e.ID})
+		if e.Type == virtualbus.DeviceRemoved {
+			s.metrics.Forget("device", fmt.Sprintf("%d-%s", e.BusID, e.ID))
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package usb_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	srvusb "github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func TestEventsOrder(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	t.Cleanup(func() { s.UsbServer.Close() })
+	sub := s.UsbServer.SubscribeEvents(16)
+	defer sub.Close()
+
+	type event struct {
+		typ   srvusb.EventType
+		devID uint32
+	}
+	var seq uint64
+	next := func() event {
+		t.Helper()
+		select {
+		case e := <-sub.C():
+			assert.Equal(t, uint32(90146), e.BusID)
+			assert.WithinDuration(t, time.Now(), e.Time, time.Second)
+			if seq != 0 {
+				assert.Equal(t, seq+1, e.Seq, "numbered without gaps")
+			}
+			seq = e.Seq
+			return event{e.Type, e.DevID}
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+			return event{}
+		}
+	}
+
+	b, err := virtualbus.NewWithBusId(90146)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	assert.Equal(t, event{srvusb.EventBusCreated, 0}, next())
+	dev, err := xbox360.New(nil)
+	require.NoError(t, err)
+	_, err = b.Add(dev)
+	require.NoError(t, err)
+	assert.Equal(t, event{"DeviceAdded", 1}, next())
+
+	client := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := client.AttachDevice("90146-1")
+	require.NoError(t, err)
+	assert.Equal(t, event{"DeviceAttached", 1}, next())
+	require.NoError(t, imp.Conn.Close())
+	assert.Equal(t, event{"DeviceDetached", 1}, next())
+
+	imp, err = client.AttachDevice("90146-1")
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+	assert.Equal(t, event{"DeviceAttached", 1}, next())
+	require.NoError(t, s.UsbServer.RemoveBus(90146))
+	assert.Equal(t, event{"DeviceDetached", 1}, next(), "removing an imported device detaches it first")
+	assert.Equal(t, event{"DeviceRemoved", 1}, next())
+	assert.Equal(t, event{srvusb.EventBusRemoved, 0}, next())
+
+	select {
+	case e := <-sub.C():
+		t.Fatalf("unexpected event %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestEventsSlowSubscriber(t *testing.T) {
+	s := viiperTesting.NewTestServer(t)
+	t.Cleanup(func() { s.UsbServer.Close() })
+	slow := s.UsbServer.SubscribeEvents(2)
+	defer slow.Close()
+
+	b, err := virtualbus.NewWithBusId(90147)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	defer func() { _ = s.UsbServer.RemoveBus(90147) }()
+
+	// Nobody reads slow; adding and removing devices must not wait for it.
+	const rounds = 50
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range rounds {
+			dev, err := xbox360.New(nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, err = b.Add(dev)
+			assert.NoError(t, err)
+			assert.NoError(t, s.UsbServer.RemoveDeviceByID(90147, "1"))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("device operations stalled by a slow subscriber")
+	}
+
+	assert.Equal(t, uint64(1+2*rounds-2), slow.Dropped())
+	var kept []string
+	for range 2 {
+		e := <-slow.C()
+		kept = append(kept, fmt.Sprintf("%s %d", e.Type, e.DevID))
+	}
+	assert.Equal(t, []string{"DeviceAdded 1", "DeviceRemoved 1"}, kept, "the newest events are kept")
+}
@@ -0,0 +1,36 @@
+package usb
+
+import (
+	"time"
+
+	pusb "github.com/Alia5/VIIPER/usb"
+)
+
+// Hooks for the external usb_test package, which needs the device packages
+// (and thereby this one through the API server) to exercise real descriptors.
+
+// ProcessSubmit runs one transfer on a connection without halted endpoints
+// and returns its data and RET_SUBMIT status.
+func (s *Server) ProcessSubmit(dev pusb.Device, ep, dir uint32, setup, out []byte) ([]byte, int32) {
+	return s.processSubmit(dev, newConnState(), ep, dir, setup, out)
+}
+
+func (s *Server) BuildConfigDescriptor(desc *pusb.Descriptor) []byte {
+	return s.buildConfigDescriptor(desc)
+}
+
+// DescriptorCache returns the cache identity for desc, building it if needed.
+func (s *Server) DescriptorCache(desc *pusb.Descriptor) any { return s.descriptors(desc) }
+
+func (s *Server) DropDescriptors(desc *pusb.Descriptor) { s.dropDescriptors(desc) }
+
+// PollMonitor exposes the slow-host detector of a single endpoint.
+type PollMonitor struct{ m *pollMonitor }
+
+func NewPollMonitor(interval time.Duration, threshold float64, window time.Duration) PollMonitor {
+	return PollMonitor{newPollMonitor(0x81, interval, threshold, window, time.Time{})}
+}
+
+func (p PollMonitor) Observe(now time.Time) bool { return p.m.observe(now) }
+
+func (p PollMonitor) Snapshot() EndpointPolling { return p.m.snapshot() }
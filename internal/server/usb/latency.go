@@ -0,0 +1,142 @@
+package usb
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Alia5/VIIPER/usb"
+)
+
+const (
+	// latencyWindow is the number of recent deliveries percentiles cover.
+	latencyWindow = 1024
+	// maxPendingInputs bounds the states waiting for a report, e.g. while no
+	// host polls the device; the oldest are dropped beyond it.
+	maxPendingInputs = 256
+)
+
+// InputLatency is a snapshot of the sequenced input of a device: how long the
+// states read from its stream took to reach a report built for the host.
+type InputLatency struct {
+	Received  uint64 // states read from the stream
+	SeqGaps   uint64 // sequence numbers skipped by the client
+	Delivered uint64 // states that reached a report
+	// Percentiles of the time from receiving a state to the first report
+	// built after it, over the last latencyWindow deliveries.
+	P50, P95, P99 time.Duration
+	// LastSeq and LastClientMonoNs identify the last delivered state.
+	LastSeq          uint32
+	LastClientMonoNs int64
+}
+
+// LatencyTracker measures the input latency of one device.
+type LatencyTracker struct {
+	mu      sync.Mutex
+	st      InputLatency
+	seen    bool
+	lastSeq uint32
+	pending []pendingInput
+	samples []time.Duration // ring of the last latencyWindow latencies
+	next    int
+}
+
+type pendingInput struct {
+	seq          uint32
+	clientMonoNs int64
+	at           time.Time
+}
+
+// Received records a state the client sent with sequence number seq and its
+// own monotonic timestamp.
+func (t *LatencyTracker) Received(seq uint32, clientMonoNs int64) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.st.Received++
+	// Sequence numbers wrap; a step backwards (a restarted client) is not
+	// a gap.
+	if d := seq - t.lastSeq; t.seen && d > 1 && d < 1<<31 {
+		t.st.SeqGaps += uint64(d - 1)
+	}
+	t.seen, t.lastSeq = true, seq
+	if len(t.pending) == maxPendingInputs {
+		t.pending = slices.Delete(t.pending, 0, 1)
+	}
+	t.pending = append(t.pending, pendingInput{seq: seq, clientMonoNs: clientMonoNs, at: now})
+}
+
+// delivered records that a report carrying every pending state was built.
+func (t *LatencyTracker) delivered(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, p := range t.pending {
+		if len(t.samples) < latencyWindow {
+			t.samples = append(t.samples, now.Sub(p.at))
+		} else {
+			t.samples[t.next] = now.Sub(p.at)
+			t.next = (t.next + 1) % latencyWindow
+		}
+		t.st.Delivered++
+		t.st.LastSeq, t.st.LastClientMonoNs = p.seq, p.clientMonoNs
+	}
+	t.pending = t.pending[:0]
+}
+
+func (t *LatencyTracker) snapshot() InputLatency {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.st
+	if len(t.samples) > 0 {
+		sorted := slices.Clone(t.samples)
+		slices.Sort(sorted)
+		pct := func(p int) time.Duration { return sorted[(len(sorted)-1)*p/100] }
+		st.P50, st.P95, st.P99 = pct(50), pct(95), pct(99)
+	}
+	return st
+}
+
+// LatencyTracker returns the latency tracker of dev, creating it on first
+// use. It is dropped once devCtx ends.
+func (s *Server) LatencyTracker(devCtx context.Context, dev usb.Device) *LatencyTracker {
+	s.latencyMu.Lock()
+	defer s.latencyMu.Unlock()
+	if t, ok := s.latency[dev]; ok {
+		return t
+	}
+	if s.latency == nil {
+		s.latency = make(map[usb.Device]*LatencyTracker)
+	}
+	t := &LatencyTracker{}
+	s.latency[dev] = t
+	go func() {
+		<-devCtx.Done()
+		s.latencyMu.Lock()
+		delete(s.latency, dev)
+		s.latencyMu.Unlock()
+	}()
+	return t
+}
+
+// InputLatency reports the input latency of dev. ok is false until a
+// sequenced stream sent it input.
+func (s *Server) InputLatency(dev usb.Device) (st InputLatency, ok bool) {
+	s.latencyMu.Lock()
+	t := s.latency[dev]
+	s.latencyMu.Unlock()
+	if t == nil {
+		return InputLatency{}, false
+	}
+	return t.snapshot(), true
+}
+
+// inputDelivered records that a report of dev was built for the host.
+func (s *Server) inputDelivered(dev usb.Device, now time.Time) {
+	s.latencyMu.Lock()
+	t := s.latency[dev]
+	s.latencyMu.Unlock()
+	if t != nil {
+		t.delivered(now)
+	}
+}
@@ -0,0 +1,115 @@
+package usb
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnStats counts the USB-IP connections of the server and those refused or
+// closed by the limits of ServerConfig.
+type ConnStats struct {
+	Active      int
+	Max         int    // MaxConcurrentConnections, 0 for no limit
+	Refused     uint64 // over MaxConcurrentConnections
+	RateLimited uint64 // over ConnectionRate of their IP address
+	IdleClosed  uint64 // imported without sending a URB within IdleTimeout
+}
+
+// bucket is the token bucket of one IP address.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// connLimiter admits connections within the concurrency and per-IP rate
+// limits.
+type connLimiter struct {
+	mu        sync.Mutex
+	active    int
+	buckets   map[string]*bucket
+	lastSweep time.Time
+
+	refused     atomic.Uint64
+	rateLimited atomic.Uint64
+	idleClosed  atomic.Uint64
+}
+
+// admit returns why a connection from addr is refused, or "" after counting
+// it as active until done.
+func (l *connLimiter) admit(cfg *ServerConfig, addr net.Addr, now time.Time) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if cfg.MaxConcurrentConnections > 0 && l.active >= cfg.MaxConcurrentConnections {
+		l.refused.Add(1)
+		return "max-connections"
+	}
+	if cfg.ConnectionRate > 0 && !l.take(cfg, hostOf(addr), now) {
+		l.rateLimited.Add(1)
+		return "rate-limit"
+	}
+	l.active++
+	return ""
+}
+
+// take removes a token from the bucket of host, refilled at ConnectionRate
+// up to ConnectionBurst.
+func (l *connLimiter) take(cfg *ServerConfig, host string, now time.Time) bool {
+	burst := float64(max(cfg.ConnectionBurst, 1))
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+	}
+	// Buckets refilled to the burst are the same as missing ones.
+	if now.Sub(l.lastSweep) > time.Second {
+		l.lastSweep = now
+		for h, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*cfg.ConnectionRate >= burst {
+				delete(l.buckets, h)
+			}
+		}
+	}
+	b, ok := l.buckets[host]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[host] = b
+	}
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*cfg.ConnectionRate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (l *connLimiter) done() {
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+}
+
+func hostOf(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// ConnStats returns the connection counters of the server.
+func (s *Server) ConnStats() ConnStats {
+	s.limiter.mu.Lock()
+	active := s.limiter.active
+	s.limiter.mu.Unlock()
+	return ConnStats{
+		Active:      active,
+		Max:         s.config.MaxConcurrentConnections,
+		Refused:     s.limiter.refused.Load(),
+		RateLimited: s.limiter.rateLimited.Load(),
+		IdleClosed:  s.limiter.idleClosed.Load(),
+	}
+}
@@ -0,0 +1,281 @@
+package usb
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+const (
+	// defaultSlowHostWindow is used when the configured window is zero.
+	defaultSlowHostWindow = 2 * time.Second
+	// A degraded endpoint recovers once its polling ratio drops below this
+	// fraction of the threshold, so a host hovering around the threshold
+	// does not flap between states.
+	slowHostClearRatio = 0.75
+)
+
+// EndpointPolling compares the host's polling of an interrupt IN endpoint
+// with the interval advertised by its descriptor.
+type EndpointPolling struct {
+	Address  uint8
+	Interval time.Duration // advertised
+	Measured time.Duration // mean of the last window; 0 until one completed
+	Degraded bool
+}
+
+// LinkStats is a snapshot of the traffic between the host and an attached
+// device. In is device to host, Out host to device.
+type LinkStats struct {
+	BytesIn        uint64
+	BytesOut       uint64
+	BytesInPerSec  uint64
+	BytesOutPerSec uint64
+	// ReportsIn counts the IN transfers of non-control endpoints that
+	// carried data, the input reports delivered to the host.
+	ReportsIn       uint64
+	ReportsInPerSec uint64
+	// HostPollingDegraded is set while any endpoint is degraded.
+	HostPollingDegraded bool
+	Endpoints           []EndpointPolling
+	// Deferred counts the URBs and reply writes held back for devices of a
+	// higher Priority.
+	Deferred uint64
+}
+
+// LinkStats reports the traffic of dev. ok is false while no host has the
+// device imported.
+func (s *Server) LinkStats(dev usb.Device) (st LinkStats, ok bool) {
+	s.linkMu.Lock()
+	l := s.links[dev]
+	s.linkMu.Unlock()
+	if l == nil {
+		return LinkStats{}, false
+	}
+	return l.stats(time.Now()), true
+}
+
+func (s *Server) addLink(dev usb.Device, l *link) {
+	s.linkMu.Lock()
+	defer s.linkMu.Unlock()
+	if s.links == nil {
+		s.links = make(map[usb.Device]*link)
+	}
+	s.links[dev] = l
+}
+
+func (s *Server) dropLink(dev usb.Device, l *link) {
+	s.linkMu.Lock()
+	defer s.linkMu.Unlock()
+	if s.links[dev] == l {
+		delete(s.links, dev)
+	}
+}
+
+// logHostPolling reports a change of the degraded state of an endpoint.
+func (s *Server) logHostPolling(bus *virtualbus.VirtualBus, dev usb.Device, p EndpointPolling) {
+	var devID string
+	for _, m := range bus.GetAllDeviceMetas() {
+		if m.Dev == dev {
+			devID = virtualbus.DeviceID(&m.Meta)
+			break
+		}
+	}
+	attrs := []any{
+		"busID", bus.BusID(),
+		"deviceID", devID,
+		"endpoint", fmt.Sprintf("0x%02x", p.Address),
+		"advertised", p.Interval,
+		"measured", p.Measured,
+	}
+	if p.Degraded {
+		s.logger.Warn("host polls interrupt endpoint slower than advertised; input latency will suffer", attrs...)
+		return
+	}
+	s.logger.Info("host polling recovered", attrs...)
+}
+
+// link accounts the URB traffic of one imported device.
+type link struct {
+	mu      sync.Mutex
+	in      rateMeter
+	out     rateMeter
+	reports rateMeter
+	polls   map[uint32]*pollMonitor // by endpoint number
+	// deferred counts the work yielding to higher priorities; it is not
+	// under mu.
+	deferred atomic.Uint64
+}
+
+// newLink watches the interrupt IN endpoints of desc. A threshold of zero
+// disables the slow-host detection; bytes are accounted either way.
+func newLink(desc *usb.Descriptor, threshold float64, window time.Duration, now time.Time) *link {
+	l := &link{in: rateMeter{start: now}, out: rateMeter{start: now}, reports: rateMeter{start: now}, polls: map[uint32]*pollMonitor{}}
+	if threshold <= 0 {
+		return l
+	}
+	if window <= 0 {
+		window = defaultSlowHostWindow
+	}
+	for _, iface := range desc.Interfaces {
+		for _, ep := range iface.Endpoints {
+			if ep.BEndpointAddress&0x80 == 0 || ep.BMAttributes&0x03 != 0x03 {
+				continue
+			}
+			interval := pollInterval(desc.Device.Speed, ep.BInterval)
+			if interval <= 0 {
+				continue
+			}
+			l.polls[uint32(ep.BEndpointAddress&0x0f)] = newPollMonitor(ep.BEndpointAddress, interval, threshold, window, now)
+		}
+	}
+	return l
+}
+
+// pollInterval decodes bInterval of an interrupt endpoint: frames of 1 ms at
+// low and full speed, 2^(bInterval-1) microframes of 125 µs above.
+func pollInterval(speed uint32, bInterval uint8) time.Duration {
+	if bInterval == 0 {
+		return 0
+	}
+	if speed <= 2 {
+		return time.Duration(bInterval) * time.Millisecond
+	}
+	return (125 * time.Microsecond) << (min(bInterval, 16) - 1)
+}
+
+// transfer accounts one completed URB. It returns the endpoint whose
+// degraded state changed with this poll, if any.
+func (l *link) transfer(ep, dir uint32, in, out int, now time.Time) (changed *EndpointPolling) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.in.add(in, now)
+	l.out.add(out, now)
+	if dir != usbip.DirIn {
+		return nil
+	}
+	if ep != 0 && in > 0 {
+		l.reports.add(1, now)
+	}
+	m := l.polls[ep]
+	if m == nil || !m.observe(now) {
+		return nil
+	}
+	p := m.snapshot()
+	return &p
+}
+
+func (l *link) stats(now time.Time) LinkStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := LinkStats{
+		BytesIn:         l.in.total,
+		BytesOut:        l.out.total,
+		BytesInPerSec:   l.in.rate(now),
+		BytesOutPerSec:  l.out.rate(now),
+		ReportsIn:       l.reports.total,
+		ReportsInPerSec: l.reports.rate(now),
+		Deferred:        l.deferred.Load(),
+	}
+	for _, m := range l.polls {
+		p := m.snapshot()
+		st.HostPollingDegraded = st.HostPollingDegraded || p.Degraded
+		st.Endpoints = append(st.Endpoints, p)
+	}
+	sort.Slice(st.Endpoints, func(i, j int) bool { return st.Endpoints[i].Address < st.Endpoints[j].Address })
+	return st
+}
+
+// rateMeter counts bytes, or reports, and the rate over the last completed second.
+type rateMeter struct {
+	total    uint64
+	start    time.Time
+	cur      uint64
+	lastRate uint64
+}
+
+func (r *rateMeter) add(n int, now time.Time) {
+	r.roll(now)
+	r.total += uint64(n)
+	r.cur += uint64(n)
+}
+
+func (r *rateMeter) rate(now time.Time) uint64 {
+	r.roll(now)
+	return r.lastRate
+}
+
+func (r *rateMeter) roll(now time.Time) {
+	elapsed := now.Sub(r.start)
+	if elapsed < time.Second {
+		return
+	}
+	r.lastRate = uint64(float64(r.cur) / elapsed.Seconds())
+	r.cur = 0
+	r.start = now
+}
+
+// pollMonitor averages the interval between host polls of one endpoint over
+// fixed windows and flags the endpoint when a window's mean exceeds the
+// advertised interval by more than threshold times.
+type pollMonitor struct {
+	address   uint8
+	interval  time.Duration
+	threshold float64
+	window    time.Duration
+
+	winStart time.Time
+	last     time.Time
+	polls    int
+	measured time.Duration
+	degraded bool
+}
+
+func newPollMonitor(address uint8, interval time.Duration, threshold float64, window time.Duration, now time.Time) *pollMonitor {
+	return &pollMonitor{address: address, interval: interval, threshold: threshold, window: window, winStart: now}
+}
+
+// observe records a poll and reports whether it changed the degraded state.
+// A gap longer than a whole window means the host stopped polling, e.g.
+// because nothing has the device open; such a poll starts a fresh window
+// instead of counting as slow.
+func (m *pollMonitor) observe(now time.Time) bool {
+	last := m.last
+	m.last = now
+	if m.polls == 0 || now.Sub(last) >= m.window {
+		m.winStart = now
+		m.polls = 1
+		return false
+	}
+	m.polls++
+	elapsed := now.Sub(m.winStart)
+	if elapsed < m.window {
+		return false
+	}
+	// polls counts the poll that opened the window, so the window spans
+	// polls-1 intervals.
+	m.measured = elapsed / time.Duration(m.polls-1)
+	m.winStart = now
+	m.polls = 1
+
+	ratio := float64(m.measured) / float64(m.interval)
+	switch {
+	case !m.degraded && ratio > m.threshold:
+		m.degraded = true
+		return true
+	case m.degraded && ratio < m.threshold*slowHostClearRatio:
+		m.degraded = false
+		return true
+	}
+	return false
+}
+
+func (m *pollMonitor) snapshot() EndpointPolling {
+	return EndpointPolling{Address: m.address, Interval: m.interval, Measured: m.measured, Degraded: m.degraded}
+}
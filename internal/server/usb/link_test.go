@@ -0,0 +1,107 @@
+package usb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	"github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func TestPollMonitorHysteresis(t *testing.T) {
+	const interval = 4 * time.Millisecond
+	m := usb.NewPollMonitor(interval, 3, 100*time.Millisecond)
+	now := time.Unix(0, 0)
+	// poll runs one full window at the given spacing and reports whether the
+	// degraded state changed.
+	poll := func(every time.Duration) bool {
+		changed := false
+		for range int(100 * time.Millisecond / every) {
+			now = now.Add(every)
+			changed = m.Observe(now) || changed
+		}
+		return changed
+	}
+
+	m.Observe(now)
+	assert.False(t, poll(interval))
+	assert.Equal(t, interval, m.Snapshot().Measured)
+
+	assert.False(t, poll(10*time.Millisecond), "2.5x is below the threshold")
+	assert.True(t, poll(20*time.Millisecond), "5x sets the flag")
+	assert.True(t, m.Snapshot().Degraded)
+	assert.Equal(t, 20*time.Millisecond, m.Snapshot().Measured)
+
+	assert.False(t, poll(10*time.Millisecond), "2.5x is above the clear ratio")
+	assert.True(t, m.Snapshot().Degraded)
+	assert.True(t, poll(5*time.Millisecond), "1.25x clears the flag")
+	assert.False(t, m.Snapshot().Degraded)
+
+	// A host that stopped polling for a while is idle, not slow.
+	now = now.Add(time.Second)
+	assert.False(t, poll(interval))
+	assert.False(t, m.Snapshot().Degraded)
+}
+
+func TestSlowHostDetector(t *testing.T) {
+	cfg := viiperTesting.TestServerConfig(t)
+	cfg.Server.UsbServerConfig.SlowHostThreshold = 3
+	cfg.Server.UsbServerConfig.SlowHostWindow = 200 * time.Millisecond
+	s := viiperTesting.NewTestServerWithConfig(t, cfg)
+	defer s.UsbServer.Close()
+
+	b, err := virtualbus.NewWithBusId(90118)
+	require.NoError(t, err)
+	defer b.Close()
+	require.NoError(t, s.UsbServer.AddBus(b))
+	dev, err := xbox360.New(nil)
+	require.NoError(t, err)
+	_, err = b.Add(dev)
+	require.NoError(t, err)
+
+	_, ok := s.UsbServer.LinkStats(dev)
+	assert.False(t, ok, "no stats before a host imports the device")
+
+	client := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	devs, err := client.ListDevices()
+	require.NoError(t, err)
+	require.Len(t, devs, 1)
+	imp, err := client.AttachDevice(devs[0].BusID)
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+
+	// pollFor reads input reports, pausing every between them, for d and
+	// returns the stats afterwards.
+	pollFor := func(d, every time.Duration) usb.LinkStats {
+		t.Helper()
+		deadline := time.Now().Add(d)
+		for time.Now().Before(deadline) {
+			_, err := client.ReadInputReport(imp.Conn)
+			require.NoError(t, err)
+			time.Sleep(every)
+		}
+		st, ok := s.UsbServer.LinkStats(dev)
+		require.True(t, ok)
+		return st
+	}
+
+	// The main input endpoint 0x81 advertises 4 ms at full speed.
+	st := pollFor(500*time.Millisecond, 0)
+	require.NotEmpty(t, st.Endpoints)
+	assert.Equal(t, uint8(0x81), st.Endpoints[0].Address)
+	assert.Equal(t, 4*time.Millisecond, st.Endpoints[0].Interval)
+	assert.False(t, st.HostPollingDegraded)
+	assert.Positive(t, st.BytesIn)
+
+	st = pollFor(time.Second, 40*time.Millisecond)
+	assert.True(t, st.HostPollingDegraded, "polling every 40 ms is 10x slower than advertised")
+	assert.Greater(t, st.Endpoints[0].Measured, 12*time.Millisecond)
+
+	st = pollFor(time.Second, 0)
+	assert.False(t, st.HostPollingDegraded, "flag clears once the host catches up")
+}
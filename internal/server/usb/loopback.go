@@ -0,0 +1,170 @@
+package usb
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Alia5/VIIPER/usbipclient"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// loopbackImportTimeout bounds the import handshake of a loopback attach.
+const loopbackImportTimeout = 5 * time.Second
+
+// LoopbackStatus is the state of the attachment of a device to the local
+// vhci_hcd by AutoAttachLocal.
+type LoopbackStatus struct {
+	Attached bool
+	// Port is the vhci_hcd port the device is attached on.
+	Port int
+	// Err is why the attach failed; nil while it is pending or attached.
+	Err error
+}
+
+type loopback struct {
+	mu     sync.Mutex
+	status map[string]LoopbackStatus // by USB-IP busid
+}
+
+func (l *loopback) set(busID string, st LoopbackStatus) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.status == nil {
+		l.status = make(map[string]LoopbackStatus)
+	}
+	l.status[busID] = st
+}
+
+// claim records busID as pending unless it has a status already, and
+// reports whether it did.
+func (l *loopback) claim(busID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.status[busID]; ok {
+		return false
+	}
+	if l.status == nil {
+		l.status = make(map[string]LoopbackStatus)
+	}
+	l.status[busID] = LoopbackStatus{}
+	return true
+}
+
+// take removes the status of busID and returns it.
+func (l *loopback) take(busID string) (LoopbackStatus, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st, ok := l.status[busID]
+	delete(l.status, busID)
+	return st, ok
+}
+
+// LoopbackStatus reports the local attachment of the device id of bus
+// busID. ok is false if AutoAttachLocal does not handle the device.
+func (s *Server) LoopbackStatus(busID uint32, id string) (st LoopbackStatus, ok bool) {
+	s.loopback.mu.Lock()
+	defer s.loopback.mu.Unlock()
+	st, ok = s.loopback.status[fmt.Sprintf("%d-%s", busID, id)]
+	return st, ok
+}
+
+// AutoAttachLocal attaches every device of the server, present or added
+// later, to the vhci_hcd of this host over a connection to the server
+// itself, and detaches it again when it is removed. It returns when ctx is
+// done. Failures are logged and kept as the LoopbackStatus of the device.
+//
+// Attaching is only supported on Linux.
+func (s *Server) AutoAttachLocal(ctx context.Context) {
+	if err := vhciAvailable(); err != nil {
+		s.logger.Warn("Local auto-attach unavailable; devices will not be attached", "error", err)
+	}
+	sub := s.SubscribeEvents(64)
+	defer sub.Close()
+
+	for _, busID := range s.ListBuses() {
+		if b := s.GetBus(busID); b != nil {
+			for _, m := range b.GetAllDeviceMetas() {
+				s.loopbackAttach(ctx, m.Meta.BusIDString())
+			}
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			switch e.Type {
+			case EventType(virtualbus.DeviceAdded):
+				s.loopbackAttach(ctx, fmt.Sprintf("%d-%s", e.BusID, e.ID))
+			case EventType(virtualbus.DeviceRemoved):
+				s.loopbackDetach(fmt.Sprintf("%d-%s", e.BusID, e.ID))
+			case EventBusRemoved:
+				s.loopback.mu.Lock()
+				var gone []string
+				prefix := strconv.FormatUint(uint64(e.BusID), 10) + "-"
+				for busID := range s.loopback.status {
+					if strings.HasPrefix(busID, prefix) {
+						gone = append(gone, busID)
+					}
+				}
+				s.loopback.mu.Unlock()
+				for _, busID := range gone {
+					s.loopbackDetach(busID)
+				}
+			}
+		}
+	}
+}
+
+// loopbackAttach imports the device busID from the server and hands the
+// connection to vhci_hcd.
+func (s *Server) loopbackAttach(ctx context.Context, busID string) {
+	if !s.loopback.claim(busID) {
+		return
+	}
+
+	port, err := func() (int, error) {
+		if err := vhciAvailable(); err != nil {
+			return 0, err
+		}
+		ctx, cancel := context.WithTimeout(ctx, loopbackImportTimeout)
+		defer cancel()
+		addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(s.GetListenPort())))
+		conn, err := usbipclient.AttachCtx(ctx, addr, busID)
+		if err != nil {
+			return 0, err
+		}
+		// vhci_hcd holds its own reference to the socket.
+		defer conn.Close()
+		return vhciAttach(conn.NetConn(), conn.Device)
+	}()
+	if err != nil {
+		s.logger.Warn("Local auto-attach failed", "busID", busID, "error", err)
+		s.loopback.set(busID, LoopbackStatus{Err: err})
+		return
+	}
+	s.logger.Info("Attached device to local vhci_hcd", "busID", busID, "port", port)
+	s.loopback.set(busID, LoopbackStatus{Attached: true, Port: port})
+}
+
+// loopbackDetach detaches the device busID from vhci_hcd if it is attached.
+func (s *Server) loopbackDetach(busID string) {
+	st, ok := s.loopback.take(busID)
+	if !ok || !st.Attached {
+		return
+	}
+	if err := vhciDetach(st.Port); err != nil {
+		// The kernel also detaches once the server closes the connection.
+		s.logger.Debug("Local detach failed", "busID", busID, "port", st.Port, "error", err)
+		return
+	}
+	s.logger.Info("Detached device from local vhci_hcd", "busID", busID, "port", st.Port)
+}
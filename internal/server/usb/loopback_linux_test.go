@@ -0,0 +1,60 @@
+//go:build linux
+
+package usb_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	srvusb "github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// TestAutoAttachLocal attaches to the vhci_hcd of the machine running the
+// test, so it only runs where the module is loaded.
+func TestAutoAttachLocal(t *testing.T) {
+	if _, err := os.Stat("/sys/devices/platform/vhci_hcd.0"); err != nil {
+		t.Skip("vhci_hcd is not loaded")
+	}
+	s := viiperTesting.NewTestServer(t)
+	t.Cleanup(func() { _ = s.UsbServer.Close() })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.UsbServer.AutoAttachLocal(ctx)
+
+	b, err := virtualbus.NewWithBusId(90177)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+	require.NoError(t, s.UsbServer.AddBus(b))
+	dev, err := xbox360.New(nil)
+	require.NoError(t, err)
+	_, err = b.Add(dev)
+	require.NoError(t, err)
+
+	var st srvusb.LoopbackStatus
+	require.Eventually(t, func() bool {
+		var ok bool
+		st, ok = s.UsbServer.LoopbackStatus(90177, "1")
+		return ok && (st.Attached || st.Err != nil)
+	}, 5*time.Second, 10*time.Millisecond)
+	if errors.Is(st.Err, fs.ErrPermission) {
+		t.Skip("attaching needs root")
+	}
+	require.NoError(t, st.Err)
+	assert.True(t, st.Attached)
+
+	require.NoError(t, b.RemoveDeviceByID("1"))
+	require.Eventually(t, func() bool {
+		_, ok := s.UsbServer.LoopbackStatus(90177, "1")
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond, "forgotten once detached")
+}
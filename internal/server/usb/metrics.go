@@ -0,0 +1,83 @@
+package usb
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Alia5/VIIPER/internal/metrics"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// buildBuckets bound viiper_input_report_build_seconds, from 1µs to 10ms.
+var buildBuckets = []float64{1e-6, 5e-6, 1e-5, 5e-5, 1e-4, 5e-4, 1e-3, 5e-3, 1e-2}
+
+// serverMetrics are the series the URB loop updates.
+type serverMetrics struct {
+	urbs         *metrics.CounterVec
+	urbErrors    *metrics.CounterVec
+	inputReports *metrics.CounterVec
+	build        *metrics.HistogramVec
+	events       *metrics.CounterVec
+}
+
+func (s *Server) registerMetrics() {
+	r := s.metrics
+	r.GaugeFunc("viiper_buses", "Virtual buses.", nil, func(emit func(float64, ...string)) {
+		emit(float64(len(s.ListBuses())))
+	})
+	r.GaugeFunc("viiper_usbip_connections", "Open USBIP connections.", nil, func(emit func(float64, ...string)) {
+		emit(float64(s.ConnStats().Active))
+	})
+	r.CounterFunc("viiper_usbip_refused_total", "USBIP connections refused or closed by the connection limits.", []string{"reason"}, func(emit func(float64, ...string)) {
+		st := s.ConnStats()
+		emit(float64(st.Refused), "max-connections")
+		emit(float64(st.RateLimited), "rate-limit")
+		emit(float64(st.IdleClosed), "idle")
+	})
+	s.m = serverMetrics{
+		urbs:         r.Counter("viiper_urbs_total", "Completed URBs by direction and endpoint number.", "dir", "ep"),
+		urbErrors:    r.Counter("viiper_urb_errors_total", "URBs completed with a non-zero status.", "dir", "ep"),
+		inputReports: r.Counter("viiper_input_reports_total", "Input reports delivered to the host.", "device"),
+		build:        r.Histogram("viiper_input_report_build_seconds", "Time devices take to build an input report.", buildBuckets, "device"),
+		events:       r.Counter("viiper_lifecycle_events_total", "Buses and devices created, removed, attached and detached.", "type"),
+	}
+}
+
+// Metrics returns the metrics registry of the server. The API server adds
+// its own series to it.
+func (s *Server) Metrics() *metrics.Registry { return s.metrics }
+
+// countURB accounts a completed URB.
+func (s *Server) countURB(c *urbConn, u *urb, n int, status int32) {
+	dir, ep := "out", strconv.FormatUint(uint64(u.ep&0x0f), 10)
+	if u.dir == usbip.DirIn {
+		dir = "in"
+	}
+	s.m.urbs.With(dir, ep).Inc()
+	if status != 0 {
+		s.m.urbErrors.With(dir, ep).Inc()
+	} else if u.dir == usbip.DirIn && u.ep != 0 && u.iso == nil && n > 0 {
+		s.m.inputReports.With(c.id).Inc()
+	}
+}
+
+// observeBuild records how long an input transfer started at start took to
+// produce its report. Transfers without data built none.
+func (s *Server) observeBuild(c *urbConn, u *urb, resp []byte, status int32, start time.Time) {
+	if u.dir != usbip.DirIn || u.ep == 0 || status != 0 || len(resp) == 0 {
+		return
+	}
+	s.m.build.With(c.id).Observe(time.Since(start).Seconds())
+}
+
+// deviceID returns "<bus>-<dev>" of dev on b.
+func deviceID(b *virtualbus.VirtualBus, dev usb.Device) string {
+	for _, m := range b.GetAllDeviceMetas() {
+		if m.Dev == dev {
+			return m.Meta.BusIDString()
+		}
+	}
+	return strconv.FormatUint(uint64(b.BusID()), 10)
+}
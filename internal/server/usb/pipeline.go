@@ -0,0 +1,201 @@
+package usb
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// maxQueuedURBs bounds the URBs of a connection read ahead of the one being
+// served; reading pauses while the queue is full.
+const maxQueuedURBs = 64
+
+// maxReplyBatch is the size beyond which the replies ready at once are
+// written in several writes.
+const maxReplyBatch = 64 * 1024
+
+// urb is a decoded CMD_SUBMIT.
+type urb struct {
+	seq        uint32
+	dir        uint32
+	ep         uint32
+	setup      [8]byte
+	out        []byte
+	iso        []usbip.IsoPacketDescriptor // nil unless isochronous
+	numPkts    uint32
+	startFrame uint32
+	prio       Priority // of the connection when it was queued
+}
+
+// urbConn is the URB stream of one imported device.
+type urbConn struct {
+	id    string // <bus>-<dev>, the device label of its metrics
+	dev   usb.Device
+	bus   *virtualbus.VirtualBus
+	state connState
+	link  *link
+	// ctx is the device's lifetime; connCtx also ends once reading stops,
+	// releasing a worker waiting for a deterministic step and held URBs.
+	ctx     context.Context
+	connCtx context.Context
+	queue   *urbQueue
+	replies *replyWriter
+	// held tracks the goroutines completing held URBs; lastHeld is the done
+	// channel of the latest per endpoint number, so completions stay in
+	// order. lastHeld is only used by the worker.
+	held     sync.WaitGroup
+	lastHeld map[uint32]chan struct{}
+}
+
+// urbQueue carries the URBs of a connection from the reader to the worker.
+// URBs stay pending until the worker takes them, and an UNLINK can take them
+// back until then, or while the device holds them.
+type urbQueue struct {
+	ch      chan *urb
+	prio    *connPriority
+	mu      sync.Mutex
+	pending map[uint32]bool
+	held    map[uint32]chan struct{}
+}
+
+func newURBQueue(prio *connPriority) *urbQueue {
+	return &urbQueue{
+		ch:      make(chan *urb, maxQueuedURBs),
+		prio:    prio,
+		pending: map[uint32]bool{},
+		held:    map[uint32]chan struct{}{},
+	}
+}
+
+// push queues u, blocking while the queue is full. u counts as work of the
+// connection's priority until the worker called served.
+func (q *urbQueue) push(u *urb) {
+	q.mu.Lock()
+	q.pending[u.seq] = true
+	q.mu.Unlock()
+	u.prio = q.prio.current()
+	q.prio.add(u.prio, 1)
+	q.ch <- u
+}
+
+// served ends u counting as work, whether it was served or unlinked.
+func (q *urbQueue) served(u *urb) {
+	q.prio.done(u.prio, 1)
+}
+
+// take ends the URB seq being pending or held and reports whether it was.
+// The worker takes the URBs it serves, the reader those it unlinks. Taking a
+// held URB closes the channel hold returned.
+func (q *urbQueue) take(seq uint32) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending[seq] {
+		delete(q.pending, seq)
+		return true
+	}
+	if ch, ok := q.held[seq]; ok {
+		close(ch)
+		delete(q.held, seq)
+		return true
+	}
+	return false
+}
+
+// hold marks the URB seq as held by its device. The returned channel is
+// closed if the URB is unlinked.
+func (q *urbQueue) hold(seq uint32) <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ch := make(chan struct{})
+	q.held[seq] = ch
+	return ch
+}
+
+// release ends the URB seq being held and reports whether it still was, in
+// which case it is to be completed.
+func (q *urbQueue) release(seq uint32) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.held[seq]; !ok {
+		return false
+	}
+	delete(q.held, seq)
+	return true
+}
+
+// replyWriter writes the replies of the reader and the worker to the
+// connection in the order they are sent, replies ready together in one write.
+// After a failed write it discards further replies; close reports the error.
+// Replies count as work of the connection's priority until written, and a
+// connection yields to higher priorities before each write.
+type replyWriter struct {
+	ch   chan queuedReply
+	prio *connPriority
+	done chan error
+}
+
+type queuedReply struct {
+	b    []byte
+	prio Priority
+}
+
+// newReplyWriter starts writing replies to w. onErr is called once a write
+// failed, to stop the reader.
+func newReplyWriter(w io.Writer, prio *connPriority, onErr func()) *replyWriter {
+	rw := &replyWriter{ch: make(chan queuedReply, maxQueuedURBs), prio: prio, done: make(chan error, 1)}
+	go func() {
+		var err error
+		var buf []byte
+		var counted [3]int64 // replies in buf, by priority
+		take := func(r queuedReply) {
+			buf = append(buf, r.b...)
+			counted[r.prio-PriorityLow]++
+		}
+		for r := range rw.ch {
+			buf = buf[:0]
+			take(r)
+			for more := true; more && len(buf) < maxReplyBatch; {
+				select {
+				case r, ok := <-rw.ch:
+					if ok {
+						take(r)
+					}
+					more = ok
+				default:
+					more = false
+				}
+			}
+			if err == nil {
+				rw.prio.yield(rw.prio.current())
+				if _, err = w.Write(buf); err != nil {
+					onErr()
+				}
+			}
+			for i, n := range counted {
+				if n > 0 {
+					rw.prio.done(Priority(i)+PriorityLow, n)
+					counted[i] = 0
+				}
+			}
+		}
+		rw.done <- err
+	}()
+	return rw
+}
+
+func (rw *replyWriter) send(b []byte) {
+	p := rw.prio.current()
+	rw.prio.add(p, 1)
+	rw.ch <- queuedReply{b: b, prio: p}
+}
+
+// close waits for the queued replies to be written and returns the first
+// write error. Nothing may be sent afterwards.
+func (rw *replyWriter) close() error {
+	close(rw.ch)
+	return <-rw.done
+}
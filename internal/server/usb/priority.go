@@ -0,0 +1,179 @@
+package usb
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// Priority orders the URB work of imported devices. While a connection of a
+// higher priority has URBs queued or replies unwritten, connections below it
+// hold back serving and writing theirs, for at most maxPriorityYield at a
+// time so they never starve.
+type Priority int8
+
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+// maxPriorityYield bounds how long work waits for that of a higher priority.
+const maxPriorityYield = time.Millisecond
+
+// ParsePriority parses "high", "normal" or "low".
+func ParsePriority(s string) (Priority, error) {
+	switch s {
+	case "high":
+		return PriorityHigh, nil
+	case "normal":
+		return PriorityNormal, nil
+	case "low":
+		return PriorityLow, nil
+	}
+	return PriorityNormal, fmt.Errorf("unknown priority %q", s)
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityLow:
+		return "low"
+	}
+	return "normal"
+}
+
+// scheduler counts the runnable work of each priority across connections.
+type scheduler struct {
+	// busy counts the URBs queued and replies unwritten of the normal and
+	// high priorities; low work is never waited for.
+	busy [2]atomic.Int64
+	mu   sync.Mutex
+	idle chan struct{} // closed, and replaced, when a count drops to zero
+}
+
+func (s *scheduler) counter(p Priority) *atomic.Int64 {
+	if p <= PriorityLow {
+		return nil
+	}
+	return &s.busy[p]
+}
+
+// add counts n more units of work of priority p.
+func (s *scheduler) add(p Priority, n int64) {
+	if c := s.counter(p); c != nil {
+		c.Add(n)
+	}
+}
+
+// done uncounts n units of work of priority p, waking the work yielding to it
+// once none is left.
+func (s *scheduler) done(p Priority, n int64) {
+	c := s.counter(p)
+	if c == nil || c.Add(-n) != 0 {
+		return
+	}
+	s.mu.Lock()
+	if s.idle != nil {
+		close(s.idle)
+		s.idle = nil
+	}
+	s.mu.Unlock()
+}
+
+// busyAbove reports whether work of a priority above p is counted.
+func (s *scheduler) busyAbove(p Priority) bool {
+	for q := p + 1; q <= PriorityHigh; q++ {
+		if s.busy[q].Load() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// yield waits while work of a priority above p is counted, at most
+// maxPriorityYield, and reports whether it waited.
+func (s *scheduler) yield(p Priority) bool {
+	if !s.busyAbove(p) {
+		return false
+	}
+	timer := time.NewTimer(maxPriorityYield)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		if !s.busyAbove(p) {
+			s.mu.Unlock()
+			return true
+		}
+		if s.idle == nil {
+			s.idle = make(chan struct{})
+		}
+		idle := s.idle
+		s.mu.Unlock()
+		select {
+		case <-idle:
+		case <-timer.C:
+			return true
+		}
+	}
+}
+
+// connPriority is the view of one connection on the scheduler of its server.
+type connPriority struct {
+	s    *Server
+	dev  usb.Device
+	link *link // counts the deferrals
+}
+
+// current returns the priority of the connection's device.
+func (cp *connPriority) current() Priority {
+	return cp.s.Priority(cp.dev)
+}
+
+func (cp *connPriority) add(p Priority, n int64) {
+	cp.s.sched.add(p, n)
+}
+
+func (cp *connPriority) done(p Priority, n int64) {
+	cp.s.sched.done(p, n)
+}
+
+// yield holds back work of priority p for that of higher priorities.
+func (cp *connPriority) yield(p Priority) {
+	if cp.s.sched.yield(p) {
+		cp.link.deferred.Add(1)
+	}
+}
+
+// SetPriority sets the priority of dev's URB work until the returned func is
+// called, which restores the previous one. Devices are PriorityNormal unless
+// set.
+func (s *Server) SetPriority(dev usb.Device, p Priority) (restore func()) {
+	s.prioMu.Lock()
+	defer s.prioMu.Unlock()
+	if s.prios == nil {
+		s.prios = make(map[usb.Device]Priority)
+	}
+	prev := s.prios[dev]
+	s.prios[dev] = p
+	return func() {
+		s.prioMu.Lock()
+		defer s.prioMu.Unlock()
+		if prev == PriorityNormal {
+			delete(s.prios, dev)
+			return
+		}
+		s.prios[dev] = prev
+	}
+}
+
+// Priority returns the priority of dev's URB work.
+func (s *Server) Priority(dev usb.Device) Priority {
+	s.prioMu.RLock()
+	defer s.prioMu.RUnlock()
+	return s.prios[dev]
+}
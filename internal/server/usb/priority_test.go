@@ -0,0 +1,281 @@
+package usb_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	"github.com/Alia5/VIIPER/internal/log"
+	srvusb "github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// attachAll adds devs to one bus of a new server logging to raw, and imports
+// each of them.
+func attachAll(t testing.TB, busID uint32, raw log.RawLogger, devs ...usb.Device) (*srvusb.Server, []net.Conn) {
+	t.Helper()
+	s := srvusb.New(viiperTesting.TestServerConfig(t).Server.UsbServerConfig, slog.Default(), raw)
+	go func() { _ = s.ListenAndServe() }()
+	<-s.Ready()
+	t.Cleanup(func() { s.Close() })
+	b, err := virtualbus.NewWithBusId(busID)
+	require.NoError(t, err)
+	t.Cleanup(func() { b.Close() })
+	require.NoError(t, s.AddBus(b))
+	client := viiperTesting.NewUsbIpClient(t, s.Addr())
+	conns := make([]net.Conn, len(devs))
+	for i, dev := range devs {
+		_, err := b.Add(dev)
+		require.NoError(t, err)
+		imp, err := client.AttachDevice(fmt.Sprintf("%d-%d", busID, i+1))
+		require.NoError(t, err)
+		t.Cleanup(func() { imp.Conn.Close() })
+		conns[i] = imp.Conn
+	}
+	return s, conns
+}
+
+func TestParsePriority(t *testing.T) {
+	for _, p := range []srvusb.Priority{srvusb.PriorityLow, srvusb.PriorityNormal, srvusb.PriorityHigh} {
+		got, err := srvusb.ParsePriority(p.String())
+		require.NoError(t, err)
+		assert.Equal(t, p, got)
+	}
+	_, err := srvusb.ParsePriority("urgent")
+	assert.Error(t, err)
+}
+
+func TestPriority(t *testing.T) {
+	x, err := xbox360.New(nil)
+	require.NoError(t, err)
+	pad := &blockingTransfer{Xbox360: x, started: make(chan struct{}, 4), release: make(chan struct{})}
+	var release sync.Once
+	t.Cleanup(func() { release.Do(func() { close(pad.release) }) })
+	bulk, err := xbox360.New(nil)
+	require.NoError(t, err)
+	s, conns := attachAll(t, 90191, nil, pad, bulk)
+
+	assert.Equal(t, srvusb.PriorityNormal, s.Priority(bulk))
+	restoreBulk := s.SetPriority(bulk, srvusb.PriorityLow)
+	restore := s.SetPriority(pad, srvusb.PriorityHigh)
+	inner := s.SetPriority(pad, srvusb.PriorityLow)
+	inner()
+	assert.Equal(t, srvusb.PriorityHigh, s.Priority(pad), "restored to the outer priority")
+
+	deferred := func() uint64 {
+		st, ok := s.LinkStats(bulk)
+		require.True(t, ok)
+		return st.Deferred
+	}
+	roundTrip := func() {
+		t.Helper()
+		_ = conns[1].SetReadDeadline(time.Now().Add(time.Second))
+		submitIn(t, conns[1], 1)
+		_, _, status := readReply(t, conns[1])
+		require.Equal(t, int32(0), status)
+	}
+	roundTrip()
+	assert.Zero(t, deferred(), "nothing of a higher priority to wait for")
+
+	// The pad's URB stays queued while its device blocks: the bulk device
+	// defers to it, but only for a bounded time.
+	submitIn(t, conns[0], 1)
+	<-pad.started
+	roundTrip()
+	assert.NotZero(t, deferred())
+
+	release.Do(func() { close(pad.release) })
+	_ = conns[0].SetReadDeadline(time.Now().Add(time.Second))
+	_, _, status := readReply(t, conns[0])
+	require.Equal(t, int32(0), status)
+	before := deferred()
+	roundTrip()
+	assert.Equal(t, before, deferred(), "the pad's work is done")
+
+	restore()
+	restoreBulk()
+	assert.Equal(t, srvusb.PriorityNormal, s.Priority(pad))
+	assert.Equal(t, srvusb.PriorityNormal, s.Priority(bulk))
+}
+
+// readReport reads one RET_SUBMIT from r and returns its data.
+func readReport(t testing.TB, r io.Reader) []byte {
+	t.Helper()
+	var hdr [48]byte
+	require.NoError(t, usbip.ReadExactly(r, hdr[:]))
+	if status := int32(binary.BigEndian.Uint32(hdr[20:24])); status != 0 {
+		t.Fatalf("status %d", status)
+	}
+	data := make([]byte, binary.BigEndian.Uint32(hdr[24:28]))
+	require.NoError(t, usbip.ReadExactly(r, data))
+	return data
+}
+
+// writeStamps is a raw logger noting when the server first writes to the
+// connection importing busID after arm was set.
+type writeStamps struct {
+	busID string
+	arm   atomic.Bool
+	at    atomic.Int64 // Unix nanoseconds
+}
+
+func (w *writeStamps) Log(bool, []byte) {}
+
+func (w *writeStamps) Stream() log.RawLogger { return &stampStream{w: w} }
+
+type stampStream struct {
+	w        *writeStamps
+	imported atomic.Bool
+}
+
+func (s *stampStream) Log(in bool, data []byte) {
+	if in {
+		// OP_REQ_IMPORT carries the NUL-padded bus ID.
+		if bytes.Contains(data, []byte(s.w.busID+"\x00")) {
+			s.imported.Store(true)
+		}
+		return
+	}
+	if s.imported.Load() && s.w.arm.CompareAndSwap(true, false) {
+		s.w.at.Store(time.Now().UnixNano())
+	}
+}
+
+// BenchmarkPriorityUnderLoad measures the input-to-report latency of an
+// xbox360, from an input change to the server writing the report to the
+// host, while background connections keep 8 control transfers each in
+// flight, saturating the server. "baseline" runs all devices at normal
+// priority, "prio" the pad at high and the background at low. p50-ns and
+// p99-ns are percentiles of the latencies.
+func BenchmarkPriorityUnderLoad(b *testing.B) {
+	for i, prio := range []bool{false, true} {
+		name := "baseline"
+		if prio {
+			name = "prio"
+		}
+		b.Run(name, func(b *testing.B) {
+			pad, err := xbox360.New(nil)
+			require.NoError(b, err)
+			devs := []usb.Device{pad}
+			for range 4 * runtime.GOMAXPROCS(0) {
+				bg, err := xbox360.New(nil)
+				require.NoError(b, err)
+				devs = append(devs, bg)
+			}
+			busID := 90192 + uint32(i)
+			stamps := &writeStamps{busID: fmt.Sprintf("%d-1", busID)}
+			s, conns := attachAll(b, busID, stamps, devs...)
+			if prio {
+				defer s.SetPriority(pad, srvusb.PriorityHigh)()
+				for _, bg := range devs[1:] {
+					defer s.SetPriority(bg, srvusb.PriorityLow)()
+				}
+			}
+
+			stop := make(chan struct{})
+			var load sync.WaitGroup
+			for _, conn := range conns[1:] {
+				load.Go(func() { getDescriptors(conn, stop) })
+			}
+			defer func() {
+				close(stop)
+				for _, conn := range conns[1:] {
+					_ = conn.Close()
+				}
+				load.Wait()
+			}()
+
+			// As a host does, the pad always has an interrupt IN URB in
+			// flight, which the server holds until the input changes.
+			r := bufio.NewReader(conns[0])
+			seq := uint32(1)
+			submitIn(b, conns[0], seq)
+			last := readReport(b, r)
+			seq++
+			submitIn(b, conns[0], seq)
+			var latencies []time.Duration
+			for b.Loop() {
+				state := xbox360.InputState{}
+				if len(latencies)%2 == 0 {
+					state.Buttons = xbox360.ButtonA
+				}
+				stamps.arm.Store(true)
+				start := time.Now()
+				pad.UpdateInputState(state)
+				// A report the interval completed unchanged in between
+				// spoils the stamp.
+				for first := true; ; first = false {
+					data := readReport(b, r)
+					seq++
+					submitIn(b, conns[0], seq)
+					if bytes.Equal(data, last) {
+						continue
+					}
+					last = data
+					if first {
+						latencies = append(latencies, time.Duration(stamps.at.Load()-start.UnixNano()))
+					}
+					break
+				}
+			}
+			slices.Sort(latencies)
+			b.ReportMetric(float64(latencies[len(latencies)/2].Nanoseconds()), "p50-ns")
+			b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+		})
+	}
+}
+
+// getDescriptors keeps 8 GET_DESCRIPTOR(device) transfers in flight on conn
+// until stop is closed or conn fails.
+func getDescriptors(conn net.Conn, stop <-chan struct{}) {
+	submit := func(seq uint32) error {
+		cmd := usbip.CmdSubmit{
+			Basic:             usbip.HeaderBasic{Command: usbip.CmdSubmitCode, Seqnum: seq, Dir: usbip.DirIn},
+			TransferBufferLen: 18,
+			Setup:             [8]byte{0x80, 0x06, 0x00, 0x01, 0x00, 0x00, 18, 0x00},
+		}
+		return cmd.Write(conn)
+	}
+	seq := uint32(1)
+	for ; seq <= 8; seq++ {
+		if submit(seq) != nil {
+			return
+		}
+	}
+	r := bufio.NewReader(conn)
+	var hdr [48]byte
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if usbip.ReadExactly(r, hdr[:]) != nil {
+			return
+		}
+		if _, err := r.Discard(int(binary.BigEndian.Uint32(hdr[24:28]))); err != nil {
+			return
+		}
+		if submit(seq) != nil {
+			return
+		}
+		seq++
+	}
+}
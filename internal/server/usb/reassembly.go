@@ -0,0 +1,64 @@
+package usb
+
+import (
+	"slices"
+	"time"
+
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+)
+
+// fragmentTimeout is how long the fragments of an output report are kept
+// waiting for the rest. Hosts send the fragments of a report back to back.
+const fragmentTimeout = 100 * time.Millisecond
+
+// outputFragment is an output report being reassembled on an interrupt OUT
+// endpoint.
+type outputFragment struct {
+	buf  []byte
+	size int       // of the whole report
+	at   time.Time // of the last fragment
+}
+
+// assembleOutput collects the fragments of output reports of dev on
+// interrupt OUT endpoints into fragments. It returns the data to pass the
+// device and whether there is any: a whole report, data not needing
+// reassembly, or the fragments of a report the host ended early. As with
+// packets, a fragment shorter than the endpoint's wMaxPacketSize ends the
+// transfer. Fragments not followed up within fragmentTimeout are dropped.
+func (s *Server) assembleOutput(dev usb.Device, fragments map[uint8]*outputFragment, ep uint32, dir uint32, out []byte) ([]byte, bool) {
+	od, ok := dev.(usb.OutputReportDevice)
+	if !ok || ep == 0 || dir != usbip.DirOut {
+		return out, true
+	}
+	addr := uint8(ep)
+	maxPacket, interrupt := s.descriptors(dev.GetDescriptor()).maxPackets[addr]
+	if !interrupt {
+		return out, true
+	}
+	now := time.Now()
+	f := fragments[addr]
+	if f != nil && now.Sub(f.at) > fragmentTimeout {
+		s.logger.Debug("Dropped incomplete output report", "ep", addr, "have", len(f.buf), "want", f.size)
+		delete(fragments, addr)
+		f = nil
+	}
+	if f == nil {
+		if len(out) == 0 {
+			return out, true
+		}
+		size := od.OutputReportSize(ep, out[0])
+		if len(out) >= size || len(out) < maxPacket {
+			return out, true
+		}
+		fragments[addr] = &outputFragment{buf: slices.Clone(out), size: size, at: now}
+		return nil, false
+	}
+	f.buf = append(f.buf, out...)
+	f.at = now
+	if len(f.buf) < f.size && len(out) >= maxPacket {
+		return nil, false
+	}
+	delete(fragments, addr)
+	return f.buf, true
+}
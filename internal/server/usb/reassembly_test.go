@@ -0,0 +1,118 @@
+package usb_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/device/dualshock4"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func TestOutputReportReassembly(t *testing.T) {
+	report := make([]byte, 32)
+	report[dualshock4.OutOffsetReportID] = dualshock4.ReportIDOutput
+	copy(report[dualshock4.OutOffsetRumbleSmall:], []byte{0x12, 0xfe, 0x01, 0x02, 0x03, 0x04, 0x05})
+	want := dualshock4.OutputState{RumbleSmall: 0x12, RumbleLarge: 0xfe, LedRed: 0x01, LedGreen: 0x02, LedBlue: 0x03, FlashOn: 0x04, FlashOff: 0x05}
+
+	type testCase struct {
+		name string
+		// before is sent ahead of the report, fragment by fragment, with the
+		// pause after each.
+		before [][]byte
+		pause  time.Duration
+	}
+
+	cases := []testCase{
+		{
+			name: "fragments",
+		},
+		{
+			name:   "stale fragment dropped",
+			before: [][]byte{{dualshock4.ReportIDOutput, 0, 0, 0, 0xaa, 0xaa, 0xaa, 0xaa}},
+			pause:  150 * time.Millisecond,
+		},
+		{
+			name:   "short fragment ends the report",
+			before: [][]byte{{dualshock4.ReportIDOutput, 0, 0, 0, 0, 0, 0, 0}, {0, 0, 0}},
+		},
+	}
+
+	s := viiperTesting.NewTestServer(t)
+	b, err := virtualbus.NewWithBusId(90181)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	pad, err := dualshock4.New(nil)
+	require.NoError(t, err)
+	got := make(chan dualshock4.OutputState, 8)
+	pad.SetOutputCallback(func(o dualshock4.OutputState) { got <- o })
+	// A host splits the 32 byte report across packets of 8 bytes.
+	_, err = b.Add(newPatchedPad(pad, func(ep *usb.EndpointDescriptor) {
+		if ep.BEndpointAddress == dualshock4.EndpointOut {
+			ep.WMaxPacketSize = 8
+		}
+	}))
+	require.NoError(t, err)
+
+	client := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := client.AttachDevice("90181-1")
+	require.NoError(t, err)
+	defer imp.Conn.Close()
+	send := func(t *testing.T, fragment []byte) {
+		require.NoError(t, client.Submit(imp.Conn, usbip.DirOut, dualshock4.EndpointOut, fragment, nil))
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, f := range tc.before {
+				send(t, f)
+				time.Sleep(tc.pause)
+			}
+			// Whatever the fragments before made of the report.
+			for len(got) > 0 {
+				<-got
+			}
+			for off := 0; off < len(report); off += 8 {
+				send(t, report[off:off+8])
+			}
+			select {
+			case o := <-got:
+				assert.Equal(t, want, o)
+			case <-time.After(time.Second):
+				require.FailNow(t, "no output state")
+			}
+			select {
+			case o := <-got:
+				assert.Fail(t, "more than one output state", "%+v", o)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+// patchedPad is a DualShock 4 with endpoint descriptors changed by a test.
+type patchedPad struct {
+	*dualshock4.DualShock4
+	desc usb.Descriptor
+}
+
+func newPatchedPad(pad *dualshock4.DualShock4, patch func(ep *usb.EndpointDescriptor)) *patchedPad {
+	p := &patchedPad{DualShock4: pad, desc: *pad.GetDescriptor()}
+	p.desc.Interfaces = slices.Clone(p.desc.Interfaces)
+	for i := range p.desc.Interfaces {
+		eps := slices.Clone(p.desc.Interfaces[i].Endpoints)
+		for j := range eps {
+			patch(&eps[j])
+		}
+		p.desc.Interfaces[i].Endpoints = eps
+	}
+	return p
+}
+
+func (p *patchedPad) GetDescriptor() *usb.Descriptor { return &p.desc }
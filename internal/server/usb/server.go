@@ -3,7 +3,9 @@ package usb
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -12,10 +14,14 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/Alia5/VIIPER/device"
 	"github.com/Alia5/VIIPER/internal/log"
+	"github.com/Alia5/VIIPER/internal/metrics"
+	"github.com/Alia5/VIIPER/internal/util"
 	"github.com/Alia5/VIIPER/usb"
 	"github.com/Alia5/VIIPER/usbip"
 	"github.com/Alia5/VIIPER/virtualbus"
@@ -125,18 +131,39 @@ const (
 	usbReqSetDescriptor    = 0x07
 	usbReqGetConfiguration = 0x08
 	usbReqSetConfiguration = 0x09
+	usbReqGetInterface     = 0x0a
+	usbReqSetInterface     = 0x0b
+
+	// USB standard feature selectors
+	usbFeatureEndpointHalt       = 0x00
+	usbFeatureDeviceRemoteWakeup = 0x01
 
 	// USB descriptor types
 	usbDescTypeDevice        = 0x01
 	usbDescTypeConfiguration = 0x02
 	usbDescTypeString        = 0x03
+	usbDescTypeBOS           = 0x0f
 	usbDescTypeHID           = 0x21
 	usbDescTypeHIDReport     = 0x22
 
 	// USB request types (bmRequestType)
-	usbReqTypeStandardToDevice    = 0x00
-	usbReqTypeStandardToInterface = 0x81
-	usbReqTypeStandardFromDevice  = 0x80
+	usbReqTypeStandardToDevice        = 0x00
+	usbReqTypeStandardToInterface     = 0x81
+	usbReqTypeStandardFromDevice      = 0x80
+	usbReqTypeStandardHostToInterface = 0x01
+	usbReqTypeStandardHostToEndpoint  = 0x02
+	usbReqTypeStandardFromEndpoint    = 0x82
+	usbReqTypeClassToInterface        = 0x21
+	usbReqTypeClassFromInterface      = 0xa1
+	usbReqTypeVendorFromDevice        = 0xc0
+
+	// HID class requests every HID interface must accept; the server answers
+	// them for devices that do not.
+	hidReqGetIdle     = 0x02
+	hidReqGetProtocol = 0x03
+	hidReqSetIdle     = 0x0a
+	hidReqSetProtocol = 0x0b
+	hidProtocolReport = 0x01
 
 	// USB configuration values
 	usbConfigValueDefault   = 1
@@ -144,16 +171,30 @@ const (
 	usbConfigMaxPower100mA  = 50 // In units of 2mA
 
 	// URB header field offsets
-	urbHdrSize          = 0x30
-	urbHdrOffsetCommand = 0x00
-	urbHdrOffsetSeqnum  = 0x04
-	urbHdrOffsetDevid   = 0x08
-	urbHdrOffsetDir     = 0x0c
-	urbHdrOffsetEp      = 0x10
-	urbHdrOffsetUnlink  = 0x14
-	urbHdrOffsetFlags   = 0x14
-	urbHdrOffsetLength  = 0x18
-	urbHdrOffsetSetup   = 0x28
+	urbHdrSize             = 0x30
+	urbHdrOffsetCommand    = 0x00
+	urbHdrOffsetSeqnum     = 0x04
+	urbHdrOffsetDevid      = 0x08
+	urbHdrOffsetDir        = 0x0c
+	urbHdrOffsetEp         = 0x10
+	urbHdrOffsetUnlink     = 0x14
+	urbHdrOffsetFlags      = 0x14
+	urbHdrOffsetLength     = 0x18
+	urbHdrOffsetSetup      = 0x28
+	urbHdrOffsetNumPkts    = 0x20
+	urbHdrOffsetStartFrame = 0x1c
+
+	// urbReadBufferSize is the read buffer of a URB stream.
+	urbReadBufferSize = 64 * 1024
+
+	// maxIsoPackets bounds the packets of one isochronous URB; Linux
+	// submits at most a few dozen.
+	maxIsoPackets = 1024
+
+	// Unsupported URB commands are skipped rather than closing the connection,
+	// up to maxUnknownCommands per stream and maxUnknownPayload bytes each.
+	maxUnknownCommands = 16
+	maxUnknownPayload  = 64 * 1024
 
 	// Standard header peek size
 	headerPeekSize = 8
@@ -161,8 +202,15 @@ const (
 	// BUSID buffer size for import
 	busIDSize = 32
 
-	// Error codes
+	// Error codes, also used as RET_SUBMIT status
+	errNoEntry   = -2   // -ENOENT: no such endpoint
+	errPipe      = -32  // -EPIPE: stall
 	errConnReset = -104 // -ECONNRESET
+	errShutdown  = -108 // -ESHUTDOWN: device removed
+
+	// statusPending is never sent: the device holds the transfer, see
+	// usb.ErrPending.
+	statusPending = 1
 )
 
 type Server struct {
@@ -174,16 +222,38 @@ type Server struct {
 	ready     chan struct{}
 	readyOnce sync.Once
 	ln        net.Listener
+	descCache sync.Map // *usb.Descriptor -> *descriptorCache
+	aliases   map[usb.Device]AliasSource
+	aliasMu   sync.RWMutex
+	links     map[usb.Device]*link
+	linkMu    sync.Mutex
+	latency   map[usb.Device]*LatencyTracker
+	latencyMu sync.Mutex
+	prios     map[usb.Device]Priority
+	prioMu    sync.RWMutex
+	sched     scheduler
+	events    eventHub
+	loopback  loopback
+	limiter   connLimiter
+	conns     util.ConnSet
+	accepting chan struct{} // closed when ListenAndServe returns
+	closing   atomic.Bool   // set by Shutdown
+	metrics   *metrics.Registry
+	m         serverMetrics
 }
 
 func New(config ServerConfig, logger *slog.Logger, rawLogger log.RawLogger) *Server {
-	return &Server{
+	s := &Server{
 		config:    &config,
 		logger:    logger,
 		rawLogger: rawLogger,
 		busses:    make(map[uint32]*virtualbus.VirtualBus),
 		ready:     make(chan struct{}),
+		accepting: make(chan struct{}),
+		metrics:   metrics.NewRegistry(),
 	}
+	s.registerMetrics()
+	return s
 }
 
 // AddBus registers a bus with the server. If the bus number is already present,
@@ -198,6 +268,8 @@ func (s *Server) AddBus(bus *virtualbus.VirtualBus) error {
 		return fmt.Errorf("bus %d already registered", bus.BusID())
 	}
 	s.busses[bus.BusID()] = bus
+	s.watchBus(bus)
+	s.publish(Event{Type: EventBusCreated, BusID: bus.BusID()})
 	return nil
 }
 
@@ -223,6 +295,8 @@ func (s *Server) RemoveBus(busID uint32) error {
 	s.busesMu.Lock()
 	delete(s.busses, busID)
 	s.busesMu.Unlock()
+	bus.OnDeviceEvent(nil)
+	s.publish(Event{Type: EventBusRemoved, BusID: busID})
 
 	return bus.Close()
 }
@@ -290,6 +364,8 @@ func (s *Server) GetBus(busID uint32) *virtualbus.VirtualBus {
 	return s.busses[busID]
 }
 
+// NextFreeBusID returns the lowest bus ID not registered on the server. The ID
+// may be taken by the time it is used; AddFreeBus allocates atomically.
 func (s *Server) NextFreeBusID() uint32 {
 	s.busesMu.Lock()
 	defer s.busesMu.Unlock()
@@ -302,6 +378,39 @@ func (s *Server) NextFreeBusID() uint32 {
 	}
 }
 
+// AddFreeBus creates a bus with the lowest free bus ID and registers it.
+// Picking the ID and registering happen under one lock, so concurrent callers
+// get distinct buses. setup, if not nil, configures the bus before anyone
+// can see it; its error aborts the creation. opts are passed on to
+// virtualbus.NewWithBusId.
+func (s *Server) AddFreeBus(setup func(b *virtualbus.VirtualBus) error, opts ...virtualbus.Option) (*virtualbus.VirtualBus, error) {
+	s.busesMu.Lock()
+	defer s.busesMu.Unlock()
+	for id := uint32(1); id != 0; id++ {
+		if _, exists := s.busses[id]; exists {
+			continue
+		}
+		b, err := virtualbus.NewWithBusId(id, opts...)
+		if errors.Is(err, virtualbus.ErrBusAllocated) {
+			continue // allocated outside this server
+		}
+		if err != nil {
+			return nil, err
+		}
+		if setup != nil {
+			if err := setup(b); err != nil {
+				_ = b.Close()
+				return nil, err
+			}
+		}
+		s.busses[id] = b
+		s.watchBus(b)
+		s.publish(Event{Type: EventBusCreated, BusID: id})
+		return b, nil
+	}
+	return nil, fmt.Errorf("no free bus ID")
+}
+
 func (s *Server) Addr() string {
 	if s.ln != nil {
 		return s.ln.Addr().String()
@@ -319,6 +428,7 @@ func (s *Server) ListenAndServe() error {
 		return err
 	}
 	s.ln = ln
+	defer close(s.accepting)
 	s.config.Addr = ln.Addr().String()
 	s.readyOnce.Do(func() { close(s.ready) })
 	s.logger.Info("USBIP server listening", "addr", s.config.Addr)
@@ -332,13 +442,19 @@ func (s *Server) ListenAndServe() error {
 			s.logger.Error("Accept error", "error", err)
 			continue
 		}
+		if reason := s.limiter.admit(s.config, c.RemoteAddr(), time.Now()); reason != "" {
+			s.logger.Warn("USBIP connection refused", "remote", c.RemoteAddr(), "reason", reason)
+			_ = c.Close()
+			continue
+		}
 		if tcpConn, ok := c.(*net.TCPConn); ok {
 			if err := tcpConn.SetNoDelay(true); err != nil {
 				s.logger.Warn("failed to set TCP_NODELAY", "error", err)
 			}
 		}
 		s.logger.Info("Client connected", "remote", c.RemoteAddr())
-		go func() {
+		s.conns.Serve(c, func(c net.Conn) {
+			defer s.limiter.done()
 			if err := s.handleConn(c); err != nil {
 				if isClientDisconnect(err) {
 					s.logger.Info("Client disconnected", "error", err)
@@ -346,7 +462,7 @@ func (s *Server) ListenAndServe() error {
 					s.logger.Error("Connection handler error", "error", err)
 				}
 			}
-		}()
+		})
 	}
 }
 
@@ -380,7 +496,7 @@ func (s *Server) GetListenPort() uint16 {
 
 func (s *Server) handleConn(conn net.Conn) error {
 	defer conn.Close()
-	conn = &logConn{Conn: conn, s: s}
+	conn = &logConn{Conn: conn, raw: log.StreamOf(s.rawLogger)}
 	if err := conn.SetDeadline(time.Now().Add(s.config.ConnectionTimeout)); err != nil {
 		s.logger.Warn("Failed to set deadline", "error", err)
 	}
@@ -436,10 +552,13 @@ func (s *Server) handleDevList(conn net.Conn) error {
 			BDeviceProtocol:     desc.Device.BDeviceProtocol,
 			BConfigurationValue: usbConfigValueDefault,
 			BNumConfigurations:  desc.Device.BNumConfigurations,
-			BNumInterfaces:      uint8(len(desc.Interfaces)),
+			BNumInterfaces:      uint8(desc.NumInterfaces()),
 		}
 
 		for _, iface := range desc.Interfaces {
+			if iface.Descriptor.BAlternateSetting != 0 {
+				continue
+			}
 			exp.Interfaces = append(exp.Interfaces, usbip.InterfaceDesc{
 				Class:    iface.Descriptor.BInterfaceClass,
 				SubClass: iface.Descriptor.BInterfaceSubClass,
@@ -466,9 +585,7 @@ func (s *Server) handleImport(conn net.Conn, first8 []byte) (usb.Device, error)
 	var chosenDesc *usb.Descriptor
 	for _, m := range s.getAllDeviceMetas() {
 		meta := m.Meta
-		end := bytes.IndexByte(meta.USBBusId[:], 0)
-		bid := string(meta.USBBusId[:end])
-		if bid == reqBus {
+		if meta.BusIDString() == reqBus {
 			chosen = m.Dev
 			chosenMeta = &meta
 			chosenDesc = m.Dev.GetDescriptor()
@@ -492,9 +609,12 @@ func (s *Server) handleImport(conn net.Conn, first8 []byte) (usb.Device, error)
 		BDeviceProtocol:     chosenDesc.Device.BDeviceProtocol,
 		BConfigurationValue: usbConfigValueDefault,
 		BNumConfigurations:  chosenDesc.Device.BNumConfigurations,
-		BNumInterfaces:      uint8(len(chosenDesc.Interfaces)),
+		BNumInterfaces:      uint8(chosenDesc.NumInterfaces()),
 	}
 	for _, iface := range chosenDesc.Interfaces {
+		if iface.Descriptor.BAlternateSetting != 0 {
+			continue
+		}
 		exp.Interfaces = append(exp.Interfaces, usbip.InterfaceDesc{
 			Class:    iface.Descriptor.BInterfaceClass,
 			SubClass: iface.Descriptor.BInterfaceSubClass,
@@ -535,27 +655,30 @@ func (r *readBufferConn) Read(p []byte) (int, error) {
 
 type logConn struct {
 	net.Conn
-	s *Server
+	raw log.RawLogger
 }
 
 func (lc *logConn) Read(p []byte) (int, error) {
 	n, err := lc.Conn.Read(p)
-	if n > 0 && lc.s.rawLogger != nil {
-		lc.s.rawLogger.Log(true, p[:n])
+	if n > 0 && lc.raw != nil {
+		lc.raw.Log(true, p[:n])
 	}
 	return n, err
 }
 
 func (lc *logConn) Write(p []byte) (int, error) {
 	n, err := lc.Conn.Write(p)
-	if n > 0 && lc.s.rawLogger != nil {
-		lc.s.rawLogger.Log(false, p[:n])
+	if n > 0 && lc.raw != nil {
+		lc.raw.Log(false, p[:n])
 	}
 	return n, err
 }
 
 func (s *Server) handleUrbStream(conn net.Conn, dev usb.Device) error {
 	_ = conn.SetDeadline(time.Time{})
+	if s.config.IdleTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(s.config.IdleTimeout))
+	}
 
 	var writer io.Writer
 	var bw *batchingWriter
@@ -588,108 +711,325 @@ func (s *Server) handleUrbStream(conn net.Conn, dev usb.Device) error {
 	if ctx == nil {
 		return fmt.Errorf("no device context available from bus")
 	}
+	detach := owningBus.SetAttached(dev, conn.RemoteAddr().String())
+	defer detach()
+	if rd, ok := dev.(usb.ResetDevice); ok {
+		rd.BusReset()
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			s.logger.Info("device removed, closing URB stream")
-			busID := owningBus.BusID()
-			if emptyCtx := owningBus.GetBusEmptyContext(); emptyCtx != nil {
-				go func() {
-					slog.Debug("Started bus cleanup goroutine (HandleUrbStream ctx.Done)")
-					select {
-					case <-emptyCtx.Done():
-						// Cancelled - a new device was added
-						return
-					case <-time.After(s.config.BusCleanupTimeout):
-						if b := s.GetBus(busID); b != nil && len(b.Devices()) == 0 {
-							if err := s.RemoveBus(busID); err != nil {
-								s.logger.Error("timeout: failed to remove empty bus", "busID", busID, "error", err)
-							} else {
-								s.logger.Info("timeout: removed empty bus", "busID", busID)
-							}
-						}
-					}
-				}()
-			} else {
-				s.logger.Debug("No bus empty context; Cleaning bus immediately")
-				if b := s.GetBus(busID); b != nil && len(b.Devices()) == 0 {
-					if err := s.RemoveBus(busID); err != nil {
-						s.logger.Error("timeout: failed to remove empty bus", "busID", busID, "error", err)
-					} else {
-						s.logger.Info("timeout: removed empty bus", "busID", busID)
-					}
+	desc := dev.GetDescriptor()
+	s.descriptors(desc)
+	defer s.dropDescriptors(desc)
+
+	ln := newLink(desc, s.config.SlowHostThreshold, s.config.SlowHostWindow, time.Now())
+	s.addLink(dev, ln)
+	defer s.dropLink(dev, ln)
+
+	// The host learns of a removal by the connection closing: a blocked read
+	// of the next URB is cut short rather than waiting for the host to send
+	// one.
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	// The connection's URBs are read, served and answered by separate
+	// goroutines, so the host can keep several in flight. A single worker
+	// serves them in order; URBs the device holds complete on their own.
+	connCtx, cancelConn := context.WithCancel(ctx)
+	defer cancelConn()
+	prio := &connPriority{s: s, dev: dev, link: ln}
+	c := &urbConn{
+		id:       deviceID(owningBus, dev),
+		dev:      dev,
+		bus:      owningBus,
+		state:    newConnState(),
+		link:     ln,
+		ctx:      ctx,
+		connCtx:  connCtx,
+		queue:    newURBQueue(prio),
+		replies:  newReplyWriter(writer, prio, func() { _ = conn.SetReadDeadline(time.Now()) }),
+		lastHeld: map[uint32]chan struct{}{},
+	}
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		for u := range c.queue.ch {
+			prio.yield(u.prio)
+			if c.queue.take(u.seq) {
+				if reply := s.serveURB(c, u); reply != nil {
+					c.replies.send(reply)
 				}
 			}
-			return nil
-		default:
+			c.queue.served(u)
 		}
+	}()
+
+	readErr := s.readURBs(ctx, conn, c.queue, c.replies)
+	cancelConn()
+	close(c.queue.ch)
+	<-served
+	c.held.Wait()
+	if err := c.replies.close(); err != nil {
+		return fmt.Errorf("write reply: %w", err)
+	}
+	if ctx.Err() != nil {
+		s.closeRemoved(owningBus)
+		return nil
+	}
+	return readErr
+}
 
+// readURBs decodes the commands of conn until it fails or ctx ends, queueing
+// CMD_SUBMITs and answering CMD_UNLINKs. Until the first command arrives,
+// conn is under the IdleTimeout read deadline.
+func (s *Server) readURBs(ctx context.Context, conn net.Conn, queue *urbQueue, replies *replyWriter) error {
+	// Hosts keeping several URBs in flight send them back to back.
+	r := bufio.NewReaderSize(conn, urbReadBufferSize)
+	unknownCmds := 0
+	idle := s.config.IdleTimeout > 0
+	for ctx.Err() == nil {
 		var hdr [urbHdrSize]byte
-		if err := usbip.ReadExactly(conn, hdr[:]); err != nil {
+		if err := usbip.ReadExactly(r, hdr[:]); err != nil {
+			var ne net.Error
+			if idle && errors.As(err, &ne) && ne.Timeout() && ctx.Err() == nil {
+				s.limiter.idleClosed.Add(1)
+				s.logger.Warn("USBIP connection closed", "remote", conn.RemoteAddr(), "reason", "idle", "timeout", s.config.IdleTimeout)
+				return fmt.Errorf("no URB within %s of import", s.config.IdleTimeout)
+			}
 			return fmt.Errorf("read URB header: %w", err)
 		}
+		if idle {
+			idle = false
+			_ = conn.SetReadDeadline(time.Time{})
+			// A removal may have cut the read short in between.
+			if ctx.Err() != nil {
+				_ = conn.SetReadDeadline(time.Now())
+			}
+		}
 		cmd := binary.BigEndian.Uint32(hdr[urbHdrOffsetCommand : urbHdrOffsetCommand+4])
 		seq := binary.BigEndian.Uint32(hdr[urbHdrOffsetSeqnum : urbHdrOffsetSeqnum+4])
 		devid := binary.BigEndian.Uint32(hdr[urbHdrOffsetDevid : urbHdrOffsetDevid+4])
-		dir := binary.BigEndian.Uint32(hdr[urbHdrOffsetDir : urbHdrOffsetDir+4])
-		ep := binary.BigEndian.Uint32(hdr[urbHdrOffsetEp : urbHdrOffsetEp+4])
 		if cmd == usbip.CmdUnlinkCode {
 			unlinkSeq := binary.BigEndian.Uint32(hdr[urbHdrOffsetUnlink : urbHdrOffsetUnlink+4])
-			s.logger.Debug("USBIP_CMD_UNLINK", "seq", seq, "unlink", unlinkSeq)
-			// Reply with -ECONNRESET
-			ret := usbip.RetUnlink{Basic: usbip.HeaderBasic{Command: usbip.RetUnlinkCode, Seqnum: seq, Devid: 0, Dir: 0, Ep: 0}, Status: errConnReset}
-			_ = ret.Write(writer)
+			// A URB still queued is dropped without RET_SUBMIT; one the
+			// worker took completes normally, and the unlink is a no-op.
+			var status int32
+			if queue.take(unlinkSeq) {
+				status = errConnReset
+			}
+			s.logger.Debug("USBIP_CMD_UNLINK", "seq", seq, "unlink", unlinkSeq, "status", status)
+			ret := usbip.RetUnlink{Basic: usbip.HeaderBasic{Command: usbip.RetUnlinkCode, Seqnum: seq, Devid: 0, Dir: 0, Ep: 0}, Status: status}
+			var out bytes.Buffer
+			_ = ret.Write(&out)
+			replies.send(out.Bytes())
 			continue
 		}
 		if cmd != usbip.CmdSubmitCode {
-			return fmt.Errorf("unsupported cmd %d (seq=%d, devid=%d)", cmd, seq, devid)
+			unknownCmds++
+			if err := s.skipUnknownCommand(r, hdr[:], unknownCmds); err != nil {
+				return fmt.Errorf("unsupported cmd %d (seq=%d, devid=%d): %w", cmd, seq, devid, err)
+			}
+			continue
 		}
-		xferFlags := binary.BigEndian.Uint32(hdr[urbHdrOffsetFlags : urbHdrOffsetFlags+4])
+		u := &urb{
+			seq:        seq,
+			dir:        binary.BigEndian.Uint32(hdr[urbHdrOffsetDir : urbHdrOffsetDir+4]),
+			ep:         binary.BigEndian.Uint32(hdr[urbHdrOffsetEp : urbHdrOffsetEp+4]),
+			numPkts:    binary.BigEndian.Uint32(hdr[urbHdrOffsetNumPkts : urbHdrOffsetNumPkts+4]),
+			startFrame: binary.BigEndian.Uint32(hdr[urbHdrOffsetStartFrame : urbHdrOffsetStartFrame+4]),
+		}
+		copy(u.setup[:], hdr[urbHdrOffsetSetup:urbHdrSize])
 		xferLen := binary.BigEndian.Uint32(hdr[urbHdrOffsetLength : urbHdrOffsetLength+4])
-		setup := hdr[urbHdrOffsetSetup:urbHdrSize]
-
-		var outPayload []byte
-		if dir == usbip.DirOut && xferLen > 0 {
-			outPayload = make([]byte, xferLen)
-			if err := usbip.ReadExactly(conn, outPayload); err != nil {
+		if u.dir == usbip.DirOut && xferLen > 0 {
+			u.out = make([]byte, xferLen)
+			if err := usbip.ReadExactly(r, u.out); err != nil {
 				return fmt.Errorf("read OUT payload: %w", err)
 			}
 		}
+		if isIsoSubmit(u.numPkts) {
+			if u.numPkts > maxIsoPackets {
+				return fmt.Errorf("isochronous URB with %d packets (seq=%d)", u.numPkts, seq)
+			}
+			raw := make([]byte, u.numPkts*usbip.IsoPacketDescriptorSize)
+			if err := usbip.ReadExactly(r, raw); err != nil {
+				return fmt.Errorf("read iso packet descriptors: %w", err)
+			}
+			u.iso = usbip.ParseIsoPacketDescriptors(raw)
+		}
+		queue.push(u)
+	}
+	return nil
+}
 
-		respData := s.processSubmit(dev, ep, dir, setup, outPayload)
-
-		actualLen := uint32(len(respData))
-		if dir == usbip.DirOut {
-			actualLen = uint32(len(outPayload))
+// serveURB runs u on the device and returns its RET_SUBMIT, or nil if the
+// device holds it. URBs of a removed device fail with errShutdown without
+// reaching it.
+func (s *Server) serveURB(c *urbConn, u *urb) []byte {
+	var respData []byte
+	var status int32
+	if c.ctx.Err() == nil {
+		var wake <-chan struct{}
+		if u.dir == usbip.DirIn && u.ep != 0 {
+			s.stepInput(c.connCtx, c.dev, u.ep)
+			if pd, ok := s.inputSource(c.dev).(usb.PendingDevice); ok {
+				wake = pd.InputNotifier().Wait()
+			}
+		}
+		start := time.Now()
+		if u.iso != nil {
+			respData, status = s.processIsoSubmit(c.dev, c.state, u.ep, u.dir, u.out, u.iso)
+		} else if whole, ok := s.assembleOutput(c.dev, c.state.fragments, u.ep, u.dir, u.out); ok {
+			respData, status = s.processSubmit(c.dev, c.state, u.ep, u.dir, u.setup[:], whole)
+			s.observeBuild(c, u, respData, status, start)
 		}
+		if status == statusPending {
+			s.holdURB(c, u, wake)
+			return nil
+		}
+	}
+	return s.retSubmit(c, u, respData, status)
+}
 
-		ret := usbip.RetSubmit{
-			Basic:           usbip.HeaderBasic{Command: usbip.RetSubmitCode, Seqnum: seq, Devid: 0, Dir: 0, Ep: 0},
-			Status:          0,
-			ActualLength:    actualLen,
-			StartFrame:      0,
-			NumberOfPackets: 0,
-			ErrorCount:      0,
+// holdURB completes u, which its device held, once the device has new data
+// or the endpoint's interval has passed, unless the host unlinks it first.
+// wake is the device's notifier channel from before the transfer was tried,
+// so data arriving in between is not missed.
+func (s *Server) holdURB(c *urbConn, u *urb, wake <-chan struct{}) {
+	unlinked := c.queue.hold(u.seq)
+	var timeout <-chan time.Time
+	if d := s.descriptors(c.dev.GetDescriptor()).intervals[uint8(u.ep&0x0f)|0x80]; d > 0 {
+		timeout = time.After(d)
+	}
+	prev := c.lastHeld[u.ep]
+	done := make(chan struct{})
+	c.lastHeld[u.ep] = done
+	src := s.inputSource(c.dev)
+	c.held.Go(func() {
+		defer close(done)
+		select {
+		case <-wake:
+		case <-timeout:
+		case <-unlinked:
+			return
+		case <-c.connCtx.Done():
+			if c.ctx.Err() == nil {
+				// The connection is gone; there is nobody to answer.
+				return
+			}
+		}
+		if prev != nil {
+			<-prev
+		}
+		if !c.queue.release(u.seq) {
+			return
+		}
+		var resp []byte
+		var status int32
+		if c.ctx.Err() == nil {
+			start := time.Now()
+			resp = src.HandleTransfer(u.ep, u.dir, nil)
+			s.observeBuild(c, u, resp, status, start)
 		}
-		var out bytes.Buffer
-		out.Grow(retSubmitHeaderSize)
-		if err := ret.Write(&out); err != nil {
-			return fmt.Errorf("build RET_SUBMIT header: %w", err)
+		c.replies.send(s.retSubmit(c, u, resp, status))
+	})
+}
+
+// retSubmit encodes the RET_SUBMIT completing u and accounts it on the link.
+func (s *Server) retSubmit(c *urbConn, u *urb, respData []byte, status int32) []byte {
+	outPayload := u.out
+	if status == 0 && c.ctx.Err() != nil {
+		status = errShutdown
+	}
+	if status != 0 {
+		s.logger.Debug("URB failed", "seq", u.seq, "ep", u.ep, "dir", u.dir, "status", status)
+		respData, outPayload = nil, nil
+		for i := range u.iso {
+			u.iso[i].ActualLength, u.iso[i].Status = 0, status
 		}
-		if _, err := writer.Write(out.Bytes()); err != nil {
-			return fmt.Errorf("write RET_SUBMIT: %w", err)
+	}
+
+	outLen := len(outPayload)
+	if u.iso != nil && u.dir == usbip.DirOut {
+		outLen = 0
+		for _, p := range u.iso {
+			outLen += int(p.ActualLength)
 		}
-		if len(respData) > 0 {
-			if _, err := writer.Write(respData); err != nil {
-				return fmt.Errorf("write RET_SUBMIT payload: %w", err)
+	}
+	s.countURB(c, u, len(respData), status)
+	now := time.Now()
+	if p := c.link.transfer(u.ep, u.dir, len(respData), outLen, now); p != nil {
+		s.logHostPolling(c.bus, c.dev, *p)
+	}
+	if u.dir == usbip.DirIn && u.ep != 0 && u.iso == nil && len(respData) > 0 {
+		s.inputDelivered(c.dev, now)
+	}
+
+	actualLen := uint32(len(respData))
+	if u.dir == usbip.DirOut {
+		actualLen = uint32(outLen)
+	}
+
+	ret := usbip.RetSubmit{
+		Basic:        usbip.HeaderBasic{Command: usbip.RetSubmitCode, Seqnum: u.seq, Devid: 0, Dir: 0, Ep: 0},
+		Status:       status,
+		ActualLength: actualLen,
+	}
+	if u.iso != nil {
+		ret.StartFrame = u.startFrame
+		ret.NumberOfPackets = u.numPkts
+	}
+	var out bytes.Buffer
+	out.Grow(retSubmitHeaderSize + len(respData) + len(u.iso)*usbip.IsoPacketDescriptorSize)
+	_ = ret.Write(&out)
+	out.Write(respData)
+	for i := range u.iso {
+		_ = u.iso[i].Write(&out)
+	}
+	return out.Bytes()
+}
+
+// closeRemoved ends the URB stream of a device removed from b, and removes b
+// once it stays empty.
+func (s *Server) closeRemoved(b *virtualbus.VirtualBus) {
+	s.logger.Info("device removed, closing URB stream")
+	if s.closing.Load() {
+		return // Shutdown removes the buses itself
+	}
+	busID := b.BusID()
+	if emptyCtx := b.GetBusEmptyContext(); emptyCtx != nil {
+		go func() {
+			slog.Debug("Started bus cleanup goroutine (HandleUrbStream ctx.Done)")
+			select {
+			case <-emptyCtx.Done():
+				// Cancelled - a new device was added
+				return
+			case <-time.After(s.config.BusCleanupTimeout):
+				if b := s.GetBus(busID); b != nil && len(b.Devices()) == 0 {
+					if err := s.RemoveBus(busID); err != nil {
+						s.logger.Error("timeout: failed to remove empty bus", "busID", busID, "error", err)
+					} else {
+						s.logger.Info("timeout: removed empty bus", "busID", busID)
+					}
+				}
+			}
+		}()
+	} else {
+		s.logger.Debug("No bus empty context; Cleaning bus immediately")
+		if b := s.GetBus(busID); b != nil && len(b.Devices()) == 0 {
+			if err := s.RemoveBus(busID); err != nil {
+				s.logger.Error("timeout: failed to remove empty bus", "busID", busID, "error", err)
+			} else {
+				s.logger.Info("timeout: removed empty bus", "busID", busID)
 			}
 		}
-		_ = xferFlags
-		_ = devid
 	}
 }
 
+// isIsoSubmit reports whether a CMD_SUBMIT with numPkts packets is
+// isochronous; other transfers send 0 or 0xffffffff.
+func isIsoSubmit(numPkts uint32) bool {
+	return numPkts != 0 && numPkts != 0xffffffff
+}
+
 // isClientDisconnect tests whether an error represents a normal client
 // disconnect (EOF, ECONNRESET, broken pipe, or the Windows WSAECONNRESET
 // translated error). We treat those as normal client disconnects and log
@@ -717,12 +1057,149 @@ func isClientDisconnect(err error) bool {
 	return false
 }
 
-func (s *Server) processSubmit(dev usb.Device, ep uint32, dir uint32, setup []byte, out []byte) []byte {
+// skipUnknownCommand discards the frame of an unsupported URB command so the
+// stream stays usable. The frame is assumed to follow CMD_SUBMIT framing
+// (header, then transfer_buffer_length bytes for OUT); if that length cannot
+// be trusted, an error is returned and the connection must be dropped.
+func (s *Server) skipUnknownCommand(r io.Reader, hdr []byte, count int) error {
+	cmd := binary.BigEndian.Uint32(hdr[urbHdrOffsetCommand : urbHdrOffsetCommand+4])
+	dir := binary.BigEndian.Uint32(hdr[urbHdrOffsetDir : urbHdrOffsetDir+4])
+	xferLen := binary.BigEndian.Uint32(hdr[urbHdrOffsetLength : urbHdrOffsetLength+4])
+	numPkts := binary.BigEndian.Uint32(hdr[urbHdrOffsetNumPkts : urbHdrOffsetNumPkts+4])
+
+	if count > maxUnknownCommands {
+		return fmt.Errorf("more than %d unsupported commands", maxUnknownCommands)
+	}
+	// Command codes are small; anything else means the stream is out of sync.
+	if cmd > 0xffff {
+		return errors.New("frame length undeterminable: implausible command code")
+	}
+	if isIsoSubmit(numPkts) {
+		return errors.New("frame length undeterminable: iso packet descriptors")
+	}
+	var skip uint32
+	switch dir {
+	case usbip.DirIn:
+	case usbip.DirOut:
+		skip = xferLen
+	default:
+		return fmt.Errorf("frame length undeterminable: direction %d", dir)
+	}
+	if skip > maxUnknownPayload {
+		return fmt.Errorf("frame length undeterminable: %d byte payload", skip)
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(skip)); err != nil {
+		return fmt.Errorf("skip payload: %w", err)
+	}
+	s.logger.Warn("skipped unsupported URB command",
+		"cmd", cmd,
+		"header", hex.EncodeToString(hdr),
+		"skipped", skip,
+		"count", count,
+	)
+	return nil
+}
+
+// stepInput advances a device in deterministic mode by one state before the
+// host reads its input endpoint, waiting until the state is streamed. The
+// other transfers of the connection wait meanwhile.
+func (s *Server) stepInput(ctx context.Context, dev usb.Device, ep uint32) {
+	src := s.inputSource(dev)
+	st, ok := src.(device.Steppable)
+	if !ok || uint8(ep)|0x80 != s.descriptors(src.GetDescriptor()).inputEndpoint {
+		return
+	}
+	_ = st.Stepper().Poll(ctx)
+}
+
+// connState is the device state the host set on one connection: the
+// endpoints it halted, by address, the alternate setting it selected per
+// interface and the device features it set, by selector. fragments holds
+// the output reports being reassembled, by endpoint address. It is only used
+// by the connection's URB worker.
+type connState struct {
+	halts     map[uint8]bool
+	alts      map[uint8]uint8
+	features  map[uint16]bool
+	fragments map[uint8]*outputFragment
+}
+
+func newConnState() connState {
+	return connState{halts: map[uint8]bool{}, alts: map[uint8]uint8{}, features: map[uint16]bool{}, fragments: map[uint8]*outputFragment{}}
+}
+
+// processIsoSubmit runs an isochronous transfer packet by packet, filling in
+// the packets' actual lengths. IN data is returned packed, without the gaps
+// the host left between packets.
+func (s *Server) processIsoSubmit(dev usb.Device, state connState, ep uint32, dir uint32, out []byte, pkts []usbip.IsoPacketDescriptor) ([]byte, int32) {
+	var in []byte
+	for i := range pkts {
+		p := &pkts[i]
+		var chunk []byte
+		if dir == usbip.DirOut {
+			end := uint64(p.Offset) + uint64(p.Length)
+			if end > uint64(len(out)) {
+				return nil, errPipe
+			}
+			chunk = out[p.Offset:end]
+		}
+		resp, status := s.processSubmit(dev, state, ep, dir, nil, chunk)
+		if status == statusPending {
+			// Isochronous transfers are never held; an empty packet is
+			// what a device without new data sends.
+			resp, status = nil, 0
+		}
+		if status != 0 {
+			return nil, status
+		}
+		if dir == usbip.DirOut {
+			p.ActualLength = p.Length
+			continue
+		}
+		resp = resp[:min(len(resp), int(p.Length))]
+		in = append(in, resp...)
+		p.ActualLength = uint32(len(resp))
+	}
+	return in, 0
+}
+
+// processSubmit runs one transfer and returns its data and RET_SUBMIT
+// status: 0 on success, even without data, errPipe if the endpoint or
+// request stalls, errNoEntry for endpoints missing from the descriptor,
+// statusPending if the device holds an IN transfer and the device's
+// usb.TransferError otherwise.
+func (s *Server) processSubmit(dev usb.Device, state connState, ep uint32, dir uint32, setup []byte, out []byte) ([]byte, int32) {
+	desc := s.descriptors(dev.GetDescriptor())
 	if ep != 0 {
-		return dev.HandleTransfer(ep, dir, out)
+		addr := uint8(ep & 0x0f)
+		src := dev
+		if dir == usbip.DirIn {
+			addr |= 0x80
+			src = s.inputSource(dev)
+		}
+		switch {
+		case !desc.endpoints[addr]:
+			return nil, errNoEntry
+		case state.halts[addr]:
+			return nil, errPipe
+		}
+		resp, err := usb.Transfer(src, ep, dir, out)
+		if dir == usbip.DirIn && errors.Is(err, usb.ErrPending) {
+			return nil, statusPending
+		}
+		if err != nil {
+			status := usb.StatusProtocol
+			errors.As(err, &status)
+			if status == usb.StatusStall {
+				// A functional stall lasts until the host clears it.
+				state.halts[addr] = true
+			}
+			return nil, int32(status)
+		}
+		return resp, 0
 	}
 	if len(setup) != 8 {
-		return nil
+		return nil, errPipe
 	}
 	bm := setup[0]
 	breq := setup[1]
@@ -730,102 +1207,130 @@ func (s *Server) processSubmit(dev usb.Device, ep uint32, dir uint32, setup []by
 	wIndex := binary.LittleEndian.Uint16(setup[4:6])
 	wLength := binary.LittleEndian.Uint16(setup[6:8])
 
-	if breq == usbReqSetAddress && bm == usbReqTypeStandardToDevice {
-		return nil
+	if resp, ok := standardRequest(desc, state, bm, breq, wValue, wIndex); ok {
+		if resp == nil {
+			return nil, errPipe
+		}
+		return clip(resp, wLength), 0
 	}
-	if breq == usbReqSetConfiguration && bm == usbReqTypeStandardToDevice {
-		return nil
+	if resp, ok := desc.msosDescriptorSet(bm, breq, wIndex); ok {
+		return clip(resp, wLength), 0
 	}
-	if breq == usbReqGetConfiguration && bm == usbReqTypeStandardFromDevice {
-		return []byte{0x01}
+
+	if cd, ok := dev.(usb.ControlDevice); ok {
+		if resp, handled := cd.HandleControl(bm, breq, wValue, wIndex, wLength, out); handled {
+			return clip(resp, wLength), 0
+		}
 	}
 
-	desc := dev.GetDescriptor()
+	if desc.interfaces[uint8(wIndex)] {
+		switch {
+		case bm == usbReqTypeClassToInterface && (breq == hidReqSetIdle || breq == hidReqSetProtocol):
+			return nil, 0
+		case bm == usbReqTypeClassFromInterface && breq == hidReqGetIdle:
+			return clip([]byte{0}, wLength), 0
+		case bm == usbReqTypeClassFromInterface && breq == hidReqGetProtocol:
+			return clip([]byte{hidProtocolReport}, wLength), 0
+		}
+	}
+	return nil, errPipe
+}
 
-	if breq == usbReqGetDescriptor && bm == usbReqTypeStandardFromDevice {
-		dtype := uint8(wValue >> 8)
-		dindex := uint8(wValue & 0xff)
-		var data []byte
-		switch dtype {
-		case usbDescTypeDevice:
-			data = desc.Bytes()
-		case usbDescTypeConfiguration:
-			data = s.buildConfigDescriptor(desc)
-		case usbDescTypeString:
-			if s, ok := desc.Strings[dindex]; ok {
-				data = usb.EncodeStringDescriptor(s)
-			}
+// standardRequest serves the standard requests the server answers for every
+// device. ok is false for requests left to the device; a nil resp stalls and
+// an empty one succeeds without data.
+func standardRequest(desc *descriptorCache, state connState, bm, breq uint8, wValue, wIndex uint16) (resp []byte, ok bool) {
+	none := []byte{}
+	switch {
+	case breq == usbReqSetAddress && bm == usbReqTypeStandardToDevice:
+		return none, true
+	case breq == usbReqSetConfiguration && bm == usbReqTypeStandardToDevice:
+		return none, true
+	case breq == usbReqGetConfiguration && bm == usbReqTypeStandardFromDevice:
+		return []byte{usbConfigValueDefault}, true
+
+	case breq == usbReqGetDescriptor && bm == usbReqTypeStandardFromDevice:
+		return nonEmpty(desc.standard(uint8(wValue>>8), uint8(wValue&0xff))), true
+	case breq == usbReqGetDescriptor && bm == usbReqTypeStandardToInterface:
+		return nonEmpty(desc.iface(uint8(wIndex&0xff), uint8(wValue>>8))), true
+
+	case breq == usbReqGetStatus && bm == usbReqTypeStandardFromDevice:
+		if state.features[usbFeatureDeviceRemoteWakeup] {
+			return []byte{1 << usbFeatureDeviceRemoteWakeup, 0}, true
 		}
-		if len(data) == 0 {
-			return nil
+		return []byte{0, 0}, true
+	case breq == usbReqGetStatus && bm == usbReqTypeStandardToInterface:
+		if _, ok := desc.interfaces[uint8(wIndex)]; !ok {
+			return nil, true
 		}
-		if int(wLength) < len(data) {
-			return data[:wLength]
-		}
-		return data
-	}
-	if breq == usbReqGetDescriptor && bm == usbReqTypeStandardToInterface {
-		dtype := uint8(wValue >> 8)
-		iface := uint8(wIndex & 0xff)
-		var data []byte
-		if int(iface) < len(desc.Interfaces) {
-			ifaceConf := desc.Interfaces[iface]
-			if ifaceConf.HID != nil {
-				switch dtype {
-				case usbDescTypeHID:
-					d, err := ifaceConf.HID.DescriptorBytes()
-					if err != nil {
-						s.logger.Error("failed to build HID descriptor", "iface", iface, "error", err)
-						return nil
-					}
-					data = []byte(d)
-				case usbDescTypeHIDReport:
-					d, err := ifaceConf.HID.ReportBytes()
-					if err != nil {
-						s.logger.Error("failed to build HID report descriptor", "iface", iface, "error", err)
-						return nil
-					}
-					data = []byte(d)
-				}
-			}
-			if len(data) == 0 {
-				for _, cd := range ifaceConf.ClassDescriptors {
-					if cd.DescriptorType == dtype {
-						data = []byte(cd.Bytes())
-						break
-					}
-				}
-			}
+		return []byte{0, 0}, true
+	case breq == usbReqGetStatus && bm == usbReqTypeStandardFromEndpoint:
+		addr := uint8(wIndex)
+		if !desc.endpoints[addr] {
+			return nil, true
 		}
-		if len(data) == 0 {
-			return nil
+		if state.halts[addr] {
+			return []byte{1, 0}, true
 		}
-		if int(wLength) < len(data) {
-			return data[:wLength]
+		return []byte{0, 0}, true
+
+	case (breq == usbReqSetFeature || breq == usbReqClearFeature) && bm == usbReqTypeStandardToDevice:
+		// Remote wakeup is only reported back by GET_STATUS, as a virtual
+		// device never suspends; test modes have no effect.
+		if wValue == usbFeatureDeviceRemoteWakeup {
+			state.features[wValue] = breq == usbReqSetFeature
 		}
-		return data
-	}
+		return none, true
+	case (breq == usbReqSetFeature || breq == usbReqClearFeature) && bm == usbReqTypeStandardHostToEndpoint:
+		addr := uint8(wIndex)
+		if wValue != usbFeatureEndpointHalt || !desc.endpoints[addr] {
+			return nil, true
+		}
+		if breq == usbReqSetFeature {
+			state.halts[addr] = true
+		} else {
+			delete(state.halts, addr)
+		}
+		return none, true
 
-	if cd, ok := dev.(usb.ControlDevice); ok {
-		if resp, handled := cd.HandleControl(bm, breq, wValue, wIndex, wLength, out); handled {
-			if resp == nil {
-				return nil
-			}
-			if int(wLength) < len(resp) {
-				return resp[:wLength]
-			}
-			return resp
+	case breq == usbReqSetInterface && bm == usbReqTypeStandardHostToInterface:
+		num := uint8(wIndex)
+		if wValue > 0xff || !desc.settings[[2]uint8{num, uint8(wValue)}] {
+			return nil, true
 		}
+		state.alts[num] = uint8(wValue)
+		return none, true
+	case breq == usbReqGetInterface && bm == usbReqTypeStandardToInterface:
+		num := uint8(wIndex)
+		if _, ok := desc.interfaces[num]; !ok {
+			return nil, true
+		}
+		return []byte{state.alts[num]}, true
 	}
+	return nil, false
+}
 
-	return nil
+// nonEmpty turns a missing descriptor into a stall.
+func nonEmpty(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+// clip cuts an IN data stage to the length the host asked for.
+func clip(b []byte, wLength uint16) []byte {
+	if int(wLength) < len(b) {
+		return b[:wLength]
+	}
+	return b
 }
 
 func (s *Server) buildConfigDescriptor(desc *usb.Descriptor) []byte {
 	var b bytes.Buffer
 	h := usb.ConfigHeader{
 		WTotalLength:        0, // to be patched
-		BNumInterfaces:      uint8(len(desc.Interfaces)),
+		BNumInterfaces:      uint8(desc.NumInterfaces()),
 		BConfigurationValue: usbConfigValueDefault,
 		IConfiguration:      0,
 		BMAttributes:        usbConfigAttrBusPowered,
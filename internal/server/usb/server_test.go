@@ -0,0 +1,581 @@
+package usb_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/device/dualshock4"
+	"github.com/Alia5/VIIPER/device/keyboard"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	srvusb "github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func unknownCommandFrame(cmd, dir uint32, payload []byte) []byte {
+	frame := make([]byte, 0x30, 0x30+len(payload))
+	binary.BigEndian.PutUint32(frame[0x00:], cmd)
+	binary.BigEndian.PutUint32(frame[0x04:], 0x1000)
+	binary.BigEndian.PutUint32(frame[0x0c:], dir)
+	binary.BigEndian.PutUint32(frame[0x18:], uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+func TestUrbStreamUnknownCommands(t *testing.T) {
+	tests := []struct {
+		name      string
+		frame     []byte
+		repeat    int
+		wantAlive bool
+	}{
+		{name: "well-framed IN", frame: unknownCommandFrame(0x5, usbip.DirIn, nil), wantAlive: true},
+		{name: "well-framed OUT with payload", frame: unknownCommandFrame(0x7, usbip.DirOut, []byte{1, 2, 3, 4}), wantAlive: true},
+		{name: "tolerated up to a limit", frame: unknownCommandFrame(0x5, usbip.DirIn, nil), repeat: 17},
+		{name: "implausible command code", frame: unknownCommandFrame(0xdeadbeef, usbip.DirIn, nil)},
+		{name: "unknown direction", frame: unknownCommandFrame(0x5, 7, nil)},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := viiperTesting.NewTestServer(t)
+			defer s.UsbServer.Close()
+
+			b, err := virtualbus.NewWithBusId(uint32(90110 + i))
+			require.NoError(t, err)
+			defer b.Close()
+			require.NoError(t, s.UsbServer.AddBus(b))
+			dev, err := xbox360.New(nil)
+			require.NoError(t, err)
+			_, err = b.Add(dev)
+			require.NoError(t, err)
+
+			client := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+			devs, err := client.ListDevices()
+			require.NoError(t, err)
+			require.Len(t, devs, 1)
+			imp, err := client.AttachDevice(devs[0].BusID)
+			require.NoError(t, err)
+			defer imp.Conn.Close()
+
+			_, err = client.ReadInputReport(imp.Conn)
+			require.NoError(t, err)
+
+			_, err = imp.Conn.Write(bytes.Repeat(tt.frame, max(tt.repeat, 1)))
+			require.NoError(t, err)
+
+			if tt.wantAlive {
+				_, err = client.ReadInputReport(imp.Conn)
+				assert.NoError(t, err, "submits after an unknown command must still complete")
+				return
+			}
+			var buf [1]byte
+			_ = imp.Conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = imp.Conn.Read(buf[:])
+			assert.ErrorIs(t, err, io.EOF, "connection must be closed")
+		})
+	}
+}
+
+// attach puts dev on a new bus and imports it.
+func attach(t testing.TB, busID uint32, dev usb.Device) (*viiperTesting.TestUsbIpClient, net.Conn, *virtualbus.VirtualBus) {
+	t.Helper()
+	s := viiperTesting.NewTestServer(t)
+	t.Cleanup(func() { s.UsbServer.Close() })
+	b, err := virtualbus.NewWithBusId(busID)
+	require.NoError(t, err)
+	t.Cleanup(func() { b.Close() })
+	require.NoError(t, s.UsbServer.AddBus(b))
+	_, err = b.Add(dev)
+	require.NoError(t, err)
+
+	client := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+	imp, err := client.AttachDevice(fmt.Sprintf("%d-1", busID))
+	require.NoError(t, err)
+	t.Cleanup(func() { imp.Conn.Close() })
+	return client, imp.Conn, b
+}
+
+func setupPacket(bm, req uint8, wValue, wIndex, wLength uint16) [8]byte {
+	var p [8]byte
+	p[0], p[1] = bm, req
+	binary.LittleEndian.PutUint16(p[2:], wValue)
+	binary.LittleEndian.PutUint16(p[4:], wIndex)
+	binary.LittleEndian.PutUint16(p[6:], wLength)
+	return p
+}
+
+func urbStatus(err error) int32 {
+	var urbErr *viiperTesting.UrbError
+	if errors.As(err, &urbErr) {
+		return urbErr.Status
+	}
+	return 0
+}
+
+const (
+	statusNoEntry   = -2
+	statusStall     = -32
+	statusConnReset = -104
+	statusShutdown  = -108
+)
+
+func TestUrbStatus(t *testing.T) {
+	dev, err := keyboard.New(nil)
+	require.NoError(t, err)
+	client, conn, _ := attach(t, 90125, dev)
+
+	control := func(setup [8]byte, out []byte) ([]byte, int32) {
+		t.Helper()
+		data, err := client.Control(conn, setup, out)
+		if status := urbStatus(err); status != 0 {
+			return nil, status
+		}
+		require.NoError(t, err)
+		return data, 0
+	}
+
+	tests := []struct {
+		name       string
+		setup      [8]byte
+		out        []byte
+		wantStatus int32
+		wantData   []byte
+	}{
+		{name: "zero-length success", setup: setupPacket(0x00, 0x09, 1, 0, 0)},
+		{name: "missing descriptor stalls", setup: setupPacket(0x80, 0x06, 0x0600, 0, 10), wantStatus: statusStall},
+		{name: "missing string stalls", setup: setupPacket(0x80, 0x06, 0x0399, 0x0409, 255), wantStatus: statusStall},
+		{name: "unknown class request stalls", setup: setupPacket(0x21, 0x05, 0, 0, 0), wantStatus: statusStall},
+		{name: "vendor request stalls", setup: setupPacket(0xc0, 0x01, 0, 0, 4), wantStatus: statusStall},
+		{name: "HID SET_IDLE", setup: setupPacket(0x21, 0x0a, 0, 0, 0)},
+		{name: "HID GET_PROTOCOL", setup: setupPacket(0xa1, 0x03, 0, 0, 1), wantData: []byte{1}},
+		{name: "HID request to missing interface", setup: setupPacket(0x21, 0x0a, 0, 5, 0), wantStatus: statusStall},
+		{name: "LEDs by SET_REPORT", setup: setupPacket(0x21, 0x09, 0x0200, 0, 1), out: []byte{keyboard.LEDCapsLock}},
+		{name: "device status", setup: setupPacket(0x80, 0x00, 0, 0, 2), wantData: []byte{0, 0}},
+		{name: "interface status", setup: setupPacket(0x81, 0x00, 0, 0, 2), wantData: []byte{0, 0}},
+		{name: "status of missing interface", setup: setupPacket(0x81, 0x00, 0, 4, 2), wantStatus: statusStall},
+		{name: "set remote wakeup", setup: setupPacket(0x00, 0x03, 1, 0, 0)},
+		{name: "device status with remote wakeup", setup: setupPacket(0x80, 0x00, 0, 0, 2), wantData: []byte{2, 0}},
+		{name: "clear remote wakeup", setup: setupPacket(0x00, 0x01, 1, 0, 0)},
+		{name: "device status after clearing", setup: setupPacket(0x80, 0x00, 0, 0, 2), wantData: []byte{0, 0}},
+		{name: "test mode", setup: setupPacket(0x00, 0x03, 2, 0x0400, 0)},
+		{name: "halt of missing endpoint", setup: setupPacket(0x02, 0x03, 0, 0x85, 0), wantStatus: statusStall},
+		{name: "alternate setting", setup: setupPacket(0x01, 0x0b, 1, 0, 0), wantStatus: statusStall},
+		{name: "default alternate setting", setup: setupPacket(0x01, 0x0b, 0, 0, 0)},
+		{name: "current alternate setting", setup: setupPacket(0x81, 0x0a, 0, 0, 1), wantData: []byte{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, status := control(tt.setup, tt.out)
+			assert.Equal(t, tt.wantStatus, status)
+			assert.Equal(t, tt.wantData, data)
+		})
+	}
+	assert.True(t, dev.GetLEDState().CapsLock)
+
+	t.Run("missing endpoint", func(t *testing.T) {
+		err := client.Submit(conn, usbip.DirIn, 2, nil, nil)
+		assert.Equal(t, int32(statusNoEntry), urbStatus(err))
+		err = client.Submit(conn, usbip.DirOut, 3, []byte{1}, nil)
+		assert.Equal(t, int32(statusNoEntry), urbStatus(err))
+	})
+
+	t.Run("halted endpoint", func(t *testing.T) {
+		_, status := control(setupPacket(0x02, 0x03, 0, 0x81, 0), nil)
+		require.Zero(t, status)
+		_, err := client.ReadInputReport(conn)
+		assert.Equal(t, int32(statusStall), urbStatus(err))
+		data, _ := control(setupPacket(0x82, 0x00, 0, 0x81, 2), nil)
+		assert.Equal(t, []byte{1, 0}, data, "GET_STATUS reports the halt")
+		assert.NoError(t, client.Submit(conn, usbip.DirOut, 1, []byte{0}, nil), "other endpoints keep working")
+
+		_, status = control(setupPacket(0x02, 0x01, 0, 0x81, 0), nil)
+		require.Zero(t, status)
+		_, err = client.ReadInputReport(conn)
+		assert.NoError(t, err)
+	})
+}
+
+// removedMidTransfer is an Xbox360 that leaves its bus while serving a
+// transfer.
+type removedMidTransfer struct {
+	*xbox360.Xbox360
+	remove func()
+}
+
+func (d *removedMidTransfer) HandleTransferStatus(ep uint32, dir uint32, out []byte) ([]byte, error) {
+	d.remove()
+	return d.Xbox360.HandleTransferStatus(ep, dir, out)
+}
+
+func TestUrbStatusDeviceRemoved(t *testing.T) {
+	x, err := xbox360.New(nil)
+	require.NoError(t, err)
+	dev := &removedMidTransfer{Xbox360: x}
+	client, conn, b := attach(t, 90126, dev)
+	dev.remove = func() { _ = b.Remove(dev) }
+
+	_, err = client.ReadInputReport(conn)
+	assert.Equal(t, int32(statusShutdown), urbStatus(err))
+}
+
+// failingTransfers is an Xbox360 whose transfers complete with err.
+type failingTransfers struct {
+	*xbox360.Xbox360
+	err error
+}
+
+func (d *failingTransfers) HandleTransferStatus(ep uint32, dir uint32, out []byte) ([]byte, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	resp := d.Xbox360.HandleTransfer(ep, dir, out)
+	return resp, nil
+}
+
+func TestUrbStatusFromDevice(t *testing.T) {
+	x, err := xbox360.New(nil)
+	require.NoError(t, err)
+	dev := &failingTransfers{Xbox360: x}
+	client, conn, _ := attach(t, 90137, dev)
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int32
+	}{
+		{name: "success", wantStatus: 0},
+		{name: "timeout", err: usb.StatusTimeout, wantStatus: -110},
+		{name: "wrapped overflow", err: fmt.Errorf("report: %w", usb.StatusOverflow), wantStatus: -75},
+		{name: "other errors", err: errors.New("boom"), wantStatus: -71},
+		{name: "stall", err: usb.StatusStall, wantStatus: statusStall},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev.err = tt.err
+			_, err := client.ReadInputReport(conn)
+			assert.Equal(t, tt.wantStatus, urbStatus(err))
+		})
+	}
+
+	// Only a stall halts the endpoint.
+	dev.err = nil
+	_, err = client.ReadInputReport(conn)
+	assert.Equal(t, int32(statusStall), urbStatus(err))
+	_, err = client.Control(conn, setupPacket(0x02, 0x01, 0, 0x81, 0), nil)
+	require.NoError(t, err)
+	_, err = client.ReadInputReport(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(statusNoEntry), urbStatus(client.Submit(conn, usbip.DirIn, 5, nil, nil)), "nonexistent endpoint")
+}
+
+// blockingTransfer is an Xbox360 whose IN transfers wait for release.
+type blockingTransfer struct {
+	*xbox360.Xbox360
+	started chan struct{}
+	release chan struct{}
+}
+
+func (d *blockingTransfer) HandleTransferStatus(ep uint32, dir uint32, out []byte) ([]byte, error) {
+	if dir == usbip.DirIn {
+		d.started <- struct{}{}
+		<-d.release
+	}
+	return d.Xbox360.HandleTransferStatus(ep, dir, out)
+}
+
+func submitIn(t testing.TB, conn net.Conn, seq uint32) {
+	t.Helper()
+	submitURB(t, conn, seq, usbip.DirIn, 1, nil)
+}
+
+// submitURB sends a CMD_SUBMIT without waiting for its reply. IN URBs ask
+// for 64 bytes.
+func submitURB(t testing.TB, conn net.Conn, seq, dir, ep uint32, out []byte) {
+	t.Helper()
+	cmd := usbip.CmdSubmit{
+		Basic:             usbip.HeaderBasic{Command: usbip.CmdSubmitCode, Seqnum: seq, Dir: dir, Ep: ep},
+		TransferBufferLen: 64,
+	}
+	if dir == usbip.DirOut {
+		cmd.TransferBufferLen = uint32(len(out))
+	}
+	require.NoError(t, cmd.Write(conn))
+	_, err := conn.Write(out)
+	require.NoError(t, err)
+}
+
+// readReply reads one RET_SUBMIT or RET_UNLINK from r, skipping its data.
+func readReply(t testing.TB, r io.Reader) (cmd, seq uint32, status int32) {
+	t.Helper()
+	var hdr [48]byte
+	require.NoError(t, usbip.ReadExactly(r, hdr[:]))
+	cmd = binary.BigEndian.Uint32(hdr[0:4])
+	seq = binary.BigEndian.Uint32(hdr[4:8])
+	status = int32(binary.BigEndian.Uint32(hdr[20:24]))
+	if cmd == usbip.RetSubmitCode {
+		if n := binary.BigEndian.Uint32(hdr[24:28]); n > 0 {
+			require.NoError(t, usbip.ReadExactly(r, make([]byte, n)))
+		}
+	}
+	return cmd, seq, status
+}
+
+func TestUrbUnlink(t *testing.T) {
+	x, err := xbox360.New(nil)
+	require.NoError(t, err)
+	dev := &blockingTransfer{Xbox360: x, started: make(chan struct{}, 4), release: make(chan struct{})}
+	_, conn, _ := attach(t, 90138, dev)
+	var release sync.Once
+	t.Cleanup(func() { release.Do(func() { close(dev.release) }) })
+
+	unlink := func(seq, target uint32) {
+		cmd := usbip.CmdUnlink{Basic: usbip.HeaderBasic{Command: usbip.CmdUnlinkCode, Seqnum: seq}, UnlinkSeqnum: target}
+		require.NoError(t, cmd.Write(conn))
+	}
+
+	submitIn(t, conn, 1)
+	<-dev.started
+	submitIn(t, conn, 2)
+	unlink(3, 2)
+	unlink(4, 1)
+
+	type reply struct {
+		cmd, seq uint32
+		status   int32
+	}
+	next := func() reply {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		cmd, seq, status := readReply(t, conn)
+		return reply{cmd, seq, status}
+	}
+	assert.Equal(t, reply{usbip.RetUnlinkCode, 3, statusConnReset}, next(), "queued URB is unlinked")
+	assert.Equal(t, reply{usbip.RetUnlinkCode, 4, 0}, next(), "URB being served is not")
+
+	release.Do(func() { close(dev.release) })
+	assert.Equal(t, reply{usbip.RetSubmitCode, 1, 0}, next())
+	submitIn(t, conn, 5)
+	assert.Equal(t, reply{usbip.RetSubmitCode, 5, 0}, next(), "no RET_SUBMIT for the unlinked URB")
+}
+
+// slowInput is a DualShock4 whose input endpoint is polled every 255 ms, so
+// a held URB only completes early when the input changes.
+type slowInput struct {
+	*dualshock4.DualShock4
+	desc usb.Descriptor
+}
+
+func newSlowInput(t *testing.T) *slowInput {
+	ds4, err := dualshock4.New(nil)
+	require.NoError(t, err)
+	d := &slowInput{DualShock4: ds4, desc: *ds4.GetDescriptor()}
+	d.desc.Interfaces = slices.Clone(d.desc.Interfaces)
+	d.desc.Interfaces[0].Endpoints = slices.Clone(d.desc.Interfaces[0].Endpoints)
+	for i, ep := range d.desc.Interfaces[0].Endpoints {
+		if ep.BEndpointAddress == 0x84 {
+			d.desc.Interfaces[0].Endpoints[i].BInterval = 255
+		}
+	}
+	return d
+}
+
+func (d *slowInput) GetDescriptor() *usb.Descriptor {
+	return &d.desc
+}
+
+func TestHeldInterruptIn(t *testing.T) {
+	type reply struct {
+		cmd, seq uint32
+		status   int32
+	}
+	next := func(t *testing.T, conn net.Conn) reply {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		cmd, seq, status := readReply(t, conn)
+		return reply{cmd, seq, status}
+	}
+	// An OUT URB is served after the worker held the IN URBs before it.
+	output := func(t *testing.T, conn net.Conn, seq uint32) {
+		submitURB(t, conn, seq, usbip.DirOut, 3, nil)
+		assert.Equal(t, reply{usbip.RetSubmitCode, seq, 0}, next(t, conn))
+	}
+
+	t.Run("completes on new input", func(t *testing.T) {
+		dev := newSlowInput(t)
+		_, conn, _ := attach(t, 90141, dev)
+
+		submitURB(t, conn, 1, usbip.DirIn, 4, nil)
+		assert.Equal(t, reply{usbip.RetSubmitCode, 1, 0}, next(t, conn), "the first report is not held")
+		submitURB(t, conn, 2, usbip.DirIn, 4, nil)
+		output(t, conn, 3)
+		dev.UpdateInputState(&dualshock4.InputState{Buttons: dualshock4.ButtonCross})
+		assert.Equal(t, reply{usbip.RetSubmitCode, 2, 0}, next(t, conn))
+	})
+
+	t.Run("completes after the interval", func(t *testing.T) {
+		dev, err := dualshock4.New(nil)
+		require.NoError(t, err)
+		_, conn, _ := attach(t, 90142, dev)
+
+		submitURB(t, conn, 1, usbip.DirIn, 4, nil)
+		require.Equal(t, reply{usbip.RetSubmitCode, 1, 0}, next(t, conn))
+		start := time.Now()
+		submitURB(t, conn, 2, usbip.DirIn, 4, nil)
+		assert.Equal(t, reply{usbip.RetSubmitCode, 2, 0}, next(t, conn))
+		assert.GreaterOrEqual(t, time.Since(start), 5*time.Millisecond, "held for bInterval")
+	})
+
+	t.Run("unlinked while held", func(t *testing.T) {
+		dev := newSlowInput(t)
+		_, conn, _ := attach(t, 90143, dev)
+
+		submitURB(t, conn, 1, usbip.DirIn, 4, nil)
+		require.Equal(t, reply{usbip.RetSubmitCode, 1, 0}, next(t, conn))
+		submitURB(t, conn, 2, usbip.DirIn, 4, nil)
+		output(t, conn, 3)
+		cmd := usbip.CmdUnlink{Basic: usbip.HeaderBasic{Command: usbip.CmdUnlinkCode, Seqnum: 4}, UnlinkSeqnum: 2}
+		require.NoError(t, cmd.Write(conn))
+		assert.Equal(t, reply{usbip.RetUnlinkCode, 4, statusConnReset}, next(t, conn))
+
+		dev.UpdateInputState(&dualshock4.InputState{Buttons: dualshock4.ButtonCross})
+		submitURB(t, conn, 5, usbip.DirIn, 4, nil)
+		assert.Equal(t, reply{usbip.RetSubmitCode, 5, 0}, next(t, conn), "no RET_SUBMIT for the unlinked URB")
+	})
+}
+
+// BenchmarkInterruptIn measures interrupt IN transfers of one connection
+// with 1 and 8 URBs in flight, as the Linux vhci driver keeps them.
+func BenchmarkInterruptIn(b *testing.B) {
+	for i, depth := range []int{1, 8} {
+		b.Run(fmt.Sprintf("depth-%d", depth), func(b *testing.B) {
+			dev, err := xbox360.New(nil)
+			require.NoError(b, err)
+			_, conn, _ := attach(b, 90139+uint32(i), dev)
+			r := bufio.NewReader(conn)
+
+			seq := uint32(1)
+			for range depth {
+				submitIn(b, conn, seq)
+				seq++
+			}
+			for b.Loop() {
+				_, _, status := readReply(b, r)
+				if status != 0 {
+					b.Fatalf("status %d", status)
+				}
+				submitIn(b, conn, seq)
+				seq++
+			}
+			for range depth {
+				readReply(b, r)
+			}
+		})
+	}
+}
+
+func TestConnectionLimits(t *testing.T) {
+	start := func(t *testing.T, busID uint32, tune func(cfg *srvusb.ServerConfig)) (*viiperTesting.MockServer, string) {
+		t.Helper()
+		cfg := viiperTesting.TestServerConfig(t)
+		tune(&cfg.Server.UsbServerConfig)
+		s := viiperTesting.NewTestServerWithConfig(t, cfg)
+		t.Cleanup(func() { _ = s.UsbServer.Close() })
+
+		b, err := virtualbus.NewWithBusId(busID)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = b.Close() })
+		require.NoError(t, s.UsbServer.AddBus(b))
+		dev, err := xbox360.New(nil)
+		require.NoError(t, err)
+		_, err = b.Add(dev)
+		require.NoError(t, err)
+		return s, fmt.Sprintf("%d-1", busID)
+	}
+	// refused reports whether the server closes a new connection unread.
+	refused := func(t *testing.T, addr string) bool {
+		t.Helper()
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		_, err = conn.Read(make([]byte, 1))
+		return errors.Is(err, io.EOF)
+	}
+
+	t.Run("max concurrent connections", func(t *testing.T) {
+		s, busID := start(t, 90161, func(cfg *srvusb.ServerConfig) { cfg.MaxConcurrentConnections = 2 })
+		client := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+		imp, err := client.AttachDevice(busID)
+		require.NoError(t, err)
+		defer imp.Conn.Close()
+		_, err = client.ReadInputReport(imp.Conn)
+		require.NoError(t, err)
+
+		second, err := net.Dial("tcp", s.UsbServer.Addr())
+		require.NoError(t, err)
+		defer second.Close()
+		require.Eventually(t, func() bool { return s.UsbServer.ConnStats().Active == 2 }, time.Second, 5*time.Millisecond)
+
+		assert.True(t, refused(t, s.UsbServer.Addr()), "connection beyond the limit")
+		st := s.UsbServer.ConnStats()
+		assert.Equal(t, uint64(1), st.Refused)
+		assert.Equal(t, 2, st.Active)
+		assert.Equal(t, 2, st.Max)
+
+		_, err = client.ReadInputReport(imp.Conn)
+		assert.NoError(t, err, "the attached device keeps streaming")
+
+		second.Close()
+		require.Eventually(t, func() bool { return s.UsbServer.ConnStats().Active == 1 }, time.Second, 5*time.Millisecond)
+		_, err = client.ListDevices()
+		assert.NoError(t, err, "a freed slot admits connections again")
+	})
+
+	t.Run("per-IP rate", func(t *testing.T) {
+		s, _ := start(t, 90162, func(cfg *srvusb.ServerConfig) {
+			cfg.ConnectionRate = 0.001
+			cfg.ConnectionBurst = 2
+		})
+		client := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+		for range 2 {
+			_, err := client.ListDevices()
+			require.NoError(t, err)
+		}
+		assert.True(t, refused(t, s.UsbServer.Addr()), "connection beyond the burst")
+		assert.Equal(t, uint64(1), s.UsbServer.ConnStats().RateLimited)
+	})
+
+	t.Run("idle after import", func(t *testing.T) {
+		s, busID := start(t, 90163, func(cfg *srvusb.ServerConfig) { cfg.IdleTimeout = 100 * time.Millisecond })
+		client := viiperTesting.NewUsbIpClient(t, s.UsbServer.Addr())
+
+		active, err := client.AttachDevice(busID)
+		require.NoError(t, err)
+		defer active.Conn.Close()
+		_, err = client.ReadInputReport(active.Conn)
+		require.NoError(t, err)
+
+		idle, err := client.AttachDevice(busID)
+		require.NoError(t, err)
+		defer idle.Conn.Close()
+		_ = idle.Conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = idle.Conn.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF, "an import without URBs is closed")
+		assert.Equal(t, uint64(1), s.UsbServer.ConnStats().IdleClosed)
+
+		time.Sleep(150 * time.Millisecond)
+		_, err = client.ReadInputReport(active.Conn)
+		assert.NoError(t, err, "the timeout ends with the first URB")
+	})
+}
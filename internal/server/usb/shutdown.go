@@ -0,0 +1,64 @@
+package usb
+
+import (
+	"cmp"
+	"context"
+	"slices"
+
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// Shutdown stops the server gracefully. It stops accepting connections and
+// removes every device, bus by bus in ID order, which fails the URBs still
+// in flight with -ESHUTDOWN and ends each URB stream once its replies are
+// written. The emptied buses are removed as well. Shutdown then waits for
+// the connections to finish; once ctx is done it closes those still open
+// and returns ctx.Err().
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closing.Store(true)
+	if s.ln != nil {
+		_ = s.ln.Close()
+		<-s.accepting
+	}
+	s.detachAll()
+
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.conns.CloseAll()
+		s.logger.Warn("USBIP shutdown timed out; closed remaining connections")
+		return ctx.Err()
+	}
+}
+
+// detachAll removes the devices of every bus in device ID order, then the
+// bus, going through the buses in ID order.
+func (s *Server) detachAll() {
+	ids := s.ListBuses()
+	slices.Sort(ids)
+	for _, id := range ids {
+		b := s.GetBus(id)
+		if b == nil {
+			continue
+		}
+		metas := b.GetAllDeviceMetas()
+		slices.SortFunc(metas, func(a, b virtualbus.DeviceMeta) int {
+			return cmp.Compare(a.Meta.DevId, b.Meta.DevId)
+		})
+		for _, m := range metas {
+			if err := b.Remove(m.Dev); err != nil {
+				continue // removed in the meantime
+			}
+			s.logger.Info("Detached device for shutdown", "busID", id, "deviceID", virtualbus.DeviceID(&m.Meta))
+		}
+		if err := s.RemoveBus(id); err != nil {
+			s.logger.Debug("Remove bus on shutdown", "busID", id, "error", err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package usb_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/device/dualshock4"
+	srvusb "github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/usbipclient"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+func TestShutdownDrainsURBs(t *testing.T) {
+	const errShutdown = -108
+	checkLeaks := viiperTesting.CheckGoroutines(t)
+
+	s := viiperTesting.NewTestServer(t)
+	b, err := virtualbus.NewWithBusId(90180)
+	require.NoError(t, err)
+	require.NoError(t, s.UsbServer.AddBus(b))
+	for range 2 {
+		pad, err := dualshock4.New(nil)
+		require.NoError(t, err)
+		// Polled every 255ms, the pad holds the URBs for the test.
+		_, err = b.Add(newPatchedPad(pad, func(ep *usb.EndpointDescriptor) {
+			if ep.BEndpointAddress == dualshock4.EndpointIn {
+				ep.BInterval = 255
+			}
+		}))
+		require.NoError(t, err)
+	}
+
+	conn, err := usbipclient.Attach(s.UsbServer.Addr(), "90180-2")
+	require.NoError(t, err)
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	// URBs in flight on the input endpoint: the pad answers the first and
+	// holds the others while its state is unchanged.
+	submitted := map[uint32]bool{}
+	for range 4 {
+		seq, err := conn.Submit(&usbipclient.URB{Dir: usbip.DirIn, Ep: dualshock4.EndpointIn & 0x0f, InLen: 64})
+		require.NoError(t, err)
+		submitted[seq] = true
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	sub := s.UsbServer.SubscribeEvents(16)
+	defer sub.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, s.UsbServer.Shutdown(ctx))
+
+	var removals []string
+	for len(removals) < 3 {
+		var ev srvusb.Event
+		select {
+		case ev = <-sub.C():
+		case <-time.After(time.Second):
+			require.FailNow(t, "missing removal events", "got %v", removals)
+		}
+		if ev.Type == srvusb.EventType(virtualbus.DeviceRemoved) || ev.Type == srvusb.EventBusRemoved {
+			removals = append(removals, fmt.Sprintf("%s %d-%s", ev.Type, ev.BusID, ev.ID))
+		}
+	}
+	assert.Equal(t, []string{"DeviceRemoved 90180-1", "DeviceRemoved 90180-2", "BusRemoved 90180-"}, removals, "devices detach in order, then the bus")
+
+	shutdownFailed := 0
+	for {
+		r, err := conn.ReadReply()
+		if err != nil {
+			assert.ErrorIs(t, err, io.EOF, "the server closes the connection after the replies")
+			break
+		}
+		assert.True(t, submitted[r.Seqnum], "reply to seq %d answered once", r.Seqnum)
+		delete(submitted, r.Seqnum)
+		if r.Status == errShutdown {
+			shutdownFailed++
+		} else {
+			assert.Zero(t, r.Status, "seq %d", r.Seqnum)
+		}
+	}
+	assert.Empty(t, submitted, "every URB the server read is answered")
+	assert.Equal(t, 3, shutdownFailed, "held URBs fail with -ESHUTDOWN")
+	assert.Empty(t, s.UsbServer.ListBuses())
+
+	checkLeaks()
+}
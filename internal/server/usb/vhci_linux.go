@@ -0,0 +1,122 @@
+//go:build linux
+
+package usb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/Alia5/VIIPER/usbipclient"
+)
+
+// vhciPath is the sysfs directory of the first vhci_hcd controller.
+var vhciPath = "/sys/devices/platform/vhci_hcd.0"
+
+const (
+	usbSpeedSuper = 5 // enum usb_device_speed
+	vdevStNull    = 4 // free port in the vhci_hcd status
+	// vhciAttachAttempts bounds the retries when another client takes the
+	// free port first.
+	vhciAttachAttempts = 3
+)
+
+func vhciAvailable() error {
+	if _, err := os.Stat(vhciPath); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return errors.New("vhci_hcd is not loaded (sudo modprobe vhci-hcd)")
+		}
+		return err
+	}
+	return nil
+}
+
+// vhciAttach hands the connection of the imported dev to vhci_hcd and
+// returns the port it was attached on. The connection may be closed
+// afterwards.
+func vhciAttach(conn net.Conn, dev usbipclient.Device) (int, error) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return 0, fmt.Errorf("vhci_hcd needs a TCP connection, got %T", conn)
+	}
+	f, err := tc.File()
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	for attempt := 1; ; attempt++ {
+		status, err := os.ReadFile(filepath.Join(vhciPath, "status"))
+		if err != nil {
+			return 0, err
+		}
+		port, err := vhciFreePort(string(status), dev.Speed)
+		if err != nil {
+			return 0, err
+		}
+		devID := dev.BusNum<<16 | dev.DeviceNum
+		err = vhciWrite("attach", fmt.Sprintf("%d %d %d %d", port, f.Fd(), devID, dev.Speed))
+		if errors.Is(err, syscall.EBUSY) && attempt < vhciAttachAttempts {
+			continue
+		}
+		return port, err
+	}
+}
+
+// vhciDetach detaches the device on port.
+func vhciDetach(port int) error {
+	return vhciWrite("detach", strconv.Itoa(port))
+}
+
+func vhciWrite(name, s string) error {
+	f, err := os.OpenFile(filepath.Join(vhciPath, name), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(s); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", f.Name(), err)
+	}
+	return f.Close()
+}
+
+// vhciFreePort returns the first free port of the vhci_hcd status that
+// fits a device of speed: a SuperSpeed port for SuperSpeed devices and a
+// high-speed one otherwise. Kernels before 4.13 list no hub column and only
+// high-speed ports.
+func vhciFreePort(status string, speed uint32) (int, error) {
+	want := "hs"
+	if speed == usbSpeedSuper {
+		want = "ss"
+	}
+	sc := bufio.NewScanner(strings.NewReader(status))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		hub := "hs"
+		if fields[0] == "hs" || fields[0] == "ss" {
+			hub, fields = fields[0], fields[1:]
+		}
+		port, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue // header
+		}
+		sta, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		if hub == want && sta == vdevStNull {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free %s port on vhci_hcd", want)
+}
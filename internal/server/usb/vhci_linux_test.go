@@ -0,0 +1,35 @@
+//go:build linux
+
+package usb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVhciFreePort(t *testing.T) {
+	status := "" +
+		"hub port sta spd dev      sockfd local_busid\n" +
+		"hs  0000 006 002 00010002 000005 1-2\n" +
+		"hs  0001 004 000 00000000 000000 0-0\n" +
+		"ss  0008 004 000 00000000 000000 0-0\n"
+	port, err := vhciFreePort(status, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, port)
+	port, err = vhciFreePort(status, usbSpeedSuper)
+	require.NoError(t, err)
+	assert.Equal(t, 8, port)
+
+	old := "" +
+		"prt sta spd bus dev socket           local_busid\n" +
+		"000 006 002 001 002 ffff8800b1b4a000 1-2\n" +
+		"001 004 000 000 000 0000000000000000 0-0\n"
+	port, err = vhciFreePort(old, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 1, port)
+
+	_, err = vhciFreePort(old, usbSpeedSuper)
+	assert.EqualError(t, err, "no free ss port on vhci_hcd")
+}
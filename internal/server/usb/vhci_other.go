@@ -0,0 +1,18 @@
+//go:build !linux
+
+package usb
+
+import (
+	"errors"
+	"net"
+
+	"github.com/Alia5/VIIPER/usbipclient"
+)
+
+var errVhciUnsupported = errors.New("local auto-attach through vhci_hcd is only supported on Linux")
+
+func vhciAvailable() error { return errVhciUnsupported }
+
+func vhciAttach(net.Conn, usbipclient.Device) (int, error) { return 0, errVhciUnsupported }
+
+func vhciDetach(int) error { return errVhciUnsupported }
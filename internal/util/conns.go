@@ -0,0 +1,45 @@
+package util
+
+import (
+	"net"
+	"sync"
+)
+
+// ConnSet tracks the connections a server is serving, so its Shutdown can
+// wait for them and close those outlasting its deadline. The zero value is
+// ready to use.
+type ConnSet struct {
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// Serve runs handle on c in a goroutine of its own.
+func (cs *ConnSet) Serve(c net.Conn, handle func(net.Conn)) {
+	cs.mu.Lock()
+	if cs.conns == nil {
+		cs.conns = make(map[net.Conn]struct{})
+	}
+	cs.conns[c] = struct{}{}
+	cs.mu.Unlock()
+	cs.wg.Go(func() {
+		defer func() {
+			cs.mu.Lock()
+			delete(cs.conns, c)
+			cs.mu.Unlock()
+		}()
+		handle(c)
+	})
+}
+
+// Wait waits for the handlers of all connections to return.
+func (cs *ConnSet) Wait() { cs.wg.Wait() }
+
+// CloseAll closes the connections still being served.
+func (cs *ConnSet) CloseAll() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for c := range cs.conns {
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/internal/cmd"
+	"github.com/Alia5/VIIPER/internal/server/api"
+	usbs "github.com/Alia5/VIIPER/internal/server/usb"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// Options configures a Server. Settings not covered here are the defaults
+// of `viiper server`, including VIIPER_* environment overrides, except that
+// devices are never auto-attached to the local USB-IP client.
+type Options struct {
+	// APIAddr is the API listen address; empty for ":3242", "localhost:0"
+	// for any free port.
+	APIAddr string
+	// USBAddr is the USB-IP listen address; empty for ":3241".
+	USBAddr string
+	// Password authenticates remote API clients. Without one, only clients
+	// on localhost can connect.
+	Password string
+	// Logger receives the server logs; nil discards them.
+	Logger *slog.Logger
+}
+
+// Server is a VIIPER USB-IP and API server running in-process. Buses and
+// devices can be added through the API like with `viiper server`, or
+// directly with the methods of Server; either way hosts import the devices
+// over USB-IP.
+type Server struct {
+	cfg    *Config
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	usb      *usbs.Server
+	api      *api.Server
+	usbErrCh chan error
+	closed   bool
+}
+
+// New returns a Server configured by opts. It listens once started.
+func New(opts Options) (*Server, error) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	if opts.APIAddr != "" {
+		cfg.ApiServerConfig.Addr = opts.APIAddr
+	}
+	if opts.USBAddr != "" {
+		cfg.UsbServerConfig.Addr = opts.USBAddr
+	}
+	cfg.ApiServerConfig.Password = opts.Password
+	cfg.ApiServerConfig.AutoAttachLocalClient = false
+	cfg.UsbServerConfig.ConnectionTimeout = cfg.ConnectionTimeout
+	cfg.ApiServerConfig.ConnectionTimeout = cfg.ConnectionTimeout
+	cfg.UsbServerConfig.BusCleanupTimeout = cfg.ApiServerConfig.DeviceHandlerConnectTimeout
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return &Server{cfg: cfg, logger: logger}, nil
+}
+
+// Start listens on the USB-IP and API addresses and returns once both
+// accept connections. The server runs until ctx is done or Close is called.
+func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("server closed")
+	}
+	if s.usb != nil {
+		return errors.New("server already started")
+	}
+
+	usbSrv := usbs.New(s.cfg.UsbServerConfig, s.logger, nil)
+	apiSrv := api.New(usbSrv, s.cfg.ApiServerConfig.Addr, s.cfg.ApiServerConfig, s.logger)
+	cmd.RegisterRoutes(apiSrv)
+
+	usbErrCh := make(chan error, 1)
+	go func() {
+		usbErrCh <- usbSrv.ListenAndServe()
+	}()
+	select {
+	case err := <-usbErrCh:
+		apiSrv.Close()
+		return err
+	case <-usbSrv.Ready():
+	}
+	if err := apiSrv.Start(); err != nil {
+		apiSrv.Close()
+		_ = usbSrv.Close()
+		<-usbErrCh
+		return err
+	}
+	s.usb, s.api, s.usbErrCh = usbSrv, apiSrv, usbErrCh
+
+	go func() {
+		<-ctx.Done()
+		_ = s.Close()
+	}()
+	return nil
+}
+
+// Close stops the server and removes its buses.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.usb == nil {
+		return nil
+	}
+	s.api.Close()
+	for _, id := range s.usb.ListBuses() {
+		_ = s.usb.RemoveBus(id)
+	}
+	err := s.usb.Close()
+	<-s.usbErrCh
+	return err
+}
+
+// APIAddr returns the address the API listens on, empty before Start.
+func (s *Server) APIAddr() string {
+	if a := s.started(); a != nil {
+		return a.api.Addr()
+	}
+	return ""
+}
+
+// USBAddr returns the address USB-IP hosts connect to, empty before Start.
+func (s *Server) USBAddr() string {
+	if a := s.started(); a != nil {
+		return a.usb.Addr()
+	}
+	return ""
+}
+
+// AddBus creates the bus busID, or the lowest free one for 0, and returns
+// its ID.
+func (s *Server) AddBus(busID uint32) (uint32, error) {
+	a := s.started()
+	if a == nil {
+		return 0, errNotStarted
+	}
+	if busID == 0 {
+		b, err := a.usb.AddFreeBus(nil)
+		if err != nil {
+			return 0, err
+		}
+		return b.BusID(), nil
+	}
+	b, err := virtualbus.NewWithBusId(busID)
+	if err != nil {
+		return 0, err
+	}
+	if err := a.usb.AddBus(b); err != nil {
+		_ = b.Close()
+		return 0, err
+	}
+	return busID, nil
+}
+
+// RemoveBus removes a bus and its devices.
+func (s *Server) RemoveBus(busID uint32) error {
+	a := s.started()
+	if a == nil {
+		return errNotStarted
+	}
+	return a.usb.RemoveBus(busID)
+}
+
+// AddDevice plugs dev into a bus and returns its export metadata, e.g. the
+// USB-IP bus ID hosts import it with. Unlike devices added through the
+// API, dev stays until removed, whether or not a stream is connected; the
+// caller feeds its input directly.
+func (s *Server) AddDevice(busID uint32, dev usb.Device) (*usbip.ExportMeta, error) {
+	a := s.started()
+	if a == nil {
+		return nil, errNotStarted
+	}
+	b := a.usb.GetBus(busID)
+	if b == nil {
+		return nil, fmt.Errorf("bus %d not found", busID)
+	}
+	devCtx, err := b.Add(dev)
+	if err != nil {
+		return nil, err
+	}
+	return device.GetDeviceMeta(devCtx), nil
+}
+
+// CreateDevice creates a device of a built-in or registered type (see
+// device.Register) and adds it to a bus like AddDevice.
+func (s *Server) CreateDevice(busID uint32, deviceType string, o *device.CreateOptions) (usb.Device, *usbip.ExportMeta, error) {
+	reg := api.GetRegistration(deviceType)
+	if reg == nil {
+		return nil, nil, fmt.Errorf("unknown device type: %s", deviceType)
+	}
+	dev, err := reg.CreateDevice(o)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta, err := s.AddDevice(busID, dev)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dev, meta, nil
+}
+
+// RemoveDevice unplugs a device from its bus.
+func (s *Server) RemoveDevice(busID, devID uint32) error {
+	a := s.started()
+	if a == nil {
+		return errNotStarted
+	}
+	return a.usb.RemoveDeviceByID(busID, fmt.Sprintf("%d", devID))
+}
+
+var errNotStarted = errors.New("server not started")
+
+// started returns s while it is running, nil otherwise.
+func (s *Server) started() *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.usb == nil || s.closed {
+		return nil
+	}
+	return s
+}
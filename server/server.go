@@ -0,0 +1,39 @@
+// Package server runs the VIIPER USB-IP and API servers inside another Go
+// program, e.g. one adding its own device types with device.Register.
+//
+// Server is the minimal embedding: a few Options, and buses and devices
+// managed in-process. Run serves a complete `viiper server` configuration.
+package server
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/Alia5/VIIPER/internal/cmd"
+
+	_ "github.com/Alia5/VIIPER/internal/registry" // Register all device handlers
+)
+
+// Config is the configuration of the `viiper server` command.
+type Config = cmd.Server
+
+// DefaultConfig returns the configuration `viiper server` uses without
+// flags, including VIIPER_* environment overrides.
+func DefaultConfig() (*Config, error) {
+	var cfg Config
+	k, err := kong.New(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := k.Parse(nil); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Run serves until ctx is done. Device types must be registered before.
+func Run(ctx context.Context, cfg *Config, logger *slog.Logger) error {
+	return cfg.StartServer(ctx, logger, nil)
+}
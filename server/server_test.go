@@ -0,0 +1,101 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/apiclient"
+	"github.com/Alia5/VIIPER/device/mouse"
+	"github.com/Alia5/VIIPER/server"
+)
+
+func startServer(t *testing.T) *server.Server {
+	t.Helper()
+	srv, err := server.New(server.Options{APIAddr: "localhost:0", USBAddr: "localhost:0"})
+	require.NoError(t, err)
+	require.NoError(t, srv.Start(context.Background()))
+	t.Cleanup(func() { _ = srv.Close() })
+	return srv
+}
+
+func TestServer(t *testing.T) {
+	t.Run("in-process device", func(t *testing.T) {
+		srv := startServer(t)
+		busID, err := srv.AddBus(90168)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(90168), busID)
+
+		dev, meta, err := srv.CreateDevice(busID, "mouse", nil)
+		require.NoError(t, err)
+		m := dev.(*mouse.Mouse)
+
+		client := viiperTesting.NewUsbIpClient(t, srv.USBAddr())
+		devs, err := client.ListDevices()
+		require.NoError(t, err)
+		require.Len(t, devs, 1)
+		imp, err := client.AttachDevice(fmt.Sprintf("%d-%d", meta.BusId, meta.DevId))
+		require.NoError(t, err)
+		defer imp.Conn.Close()
+
+		want := []byte{mouse.Btn_Left, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+		m.UpdateInputState(mouse.InputState{Buttons: mouse.Btn_Left, DX: 5})
+		got, err := client.PollInputReport(imp.Conn, want, time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+
+		list, err := apiclient.New(srv.APIAddr()).DevicesList(busID)
+		require.NoError(t, err)
+		require.Len(t, list.Devices, 1)
+		assert.Equal(t, "mouse", list.Devices[0].Type)
+
+		require.NoError(t, srv.RemoveDevice(busID, meta.DevId))
+		devs, err = client.ListDevices()
+		require.NoError(t, err)
+		assert.Empty(t, devs)
+	})
+
+	t.Run("api device", func(t *testing.T) {
+		srv := startServer(t)
+		busID, err := srv.AddBus(0)
+		require.NoError(t, err)
+
+		stream, added, err := apiclient.New(srv.APIAddr()).AddDeviceAndConnect(context.Background(), busID, "mouse", nil)
+		require.NoError(t, err)
+		defer stream.Close()
+
+		client := viiperTesting.NewUsbIpClient(t, srv.USBAddr())
+		imp, err := client.AttachDevice(fmt.Sprintf("%d-%s", added.BusID, added.DevId))
+		require.NoError(t, err)
+		defer imp.Conn.Close()
+
+		want := []byte{mouse.Btn_Right, 0x00, 0x00, 0xfd, 0xff, 0x00, 0x00, 0x00, 0x00}
+		require.NoError(t, stream.WriteBinary(&mouse.InputState{Buttons: mouse.Btn_Right, DY: -3}))
+		got, err := client.PollInputReport(imp.Conn, want, time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("lifecycle", func(t *testing.T) {
+		srv, err := server.New(server.Options{APIAddr: "localhost:0", USBAddr: "localhost:0"})
+		require.NoError(t, err)
+		_, err = srv.AddBus(0)
+		assert.Error(t, err, "not started")
+		assert.Empty(t, srv.APIAddr())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		require.NoError(t, srv.Start(ctx))
+		assert.Error(t, srv.Start(ctx), "started twice")
+		_, _, err = srv.CreateDevice(0, "nope", nil)
+		assert.ErrorContains(t, err, "unknown device type")
+
+		cancel()
+		assert.Eventually(t, func() bool { return srv.APIAddr() == "" }, time.Second, 10*time.Millisecond)
+		assert.NoError(t, srv.Close())
+	})
+}
@@ -1,11 +1,22 @@
 package usb
 
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
 // Device is the minimal interface a device must implement.
-// It only handles non-EP0 (interrupt/bulk) transfers.
+// It only handles non-EP0 (interrupt/bulk/isochronous) transfers.
 type Device interface {
-	// HandleTransfer processes a non-EP0 transfer (interrupt/bulk).
+	// HandleTransfer processes a non-EP0 transfer (interrupt/bulk/isochronous).
 	// ep is the endpoint number (without direction). dir is usbip.DirIn or usbip.DirOut.
 	// For IN transfers, return the payload to send; for OUT, consume 'out' and return nil.
+	//
+	// The server only passes transfers to endpoints in the descriptor.
+	// Isochronous transfers are passed packet by packet; IN data beyond the
+	// packet length is dropped. Devices stalling transfers, or failing them
+	// with other statuses, implement TransferStatusDevice.
 	HandleTransfer(ep uint32, dir uint32, out []byte) []byte
 	GetDescriptor() *Descriptor
 	GetDeviceSpecificArgs() map[string]any
@@ -24,7 +35,115 @@ type ControlDevice interface {
 	// - data is the OUT data stage payload (for host-to-device requests), and is nil for
 	//   device-to-host requests.
 	//
-	// If handled is false, the server will fall back to its default behavior.
+	// If handled is false, the server will fall back to its default behavior,
+	// stalling requests it does not know either.
 	// If handled is true, the returned bytes (if any) will be used as the IN data stage.
 	HandleControl(bmRequestType, bRequest uint8, wValue, wIndex, wLength uint16, data []byte) (resp []byte, handled bool)
 }
+
+// ResetDevice is an optional interface for devices with state a USB reset
+// clears, e.g. the protocol a host selected. The server calls BusReset
+// whenever a host imports the device.
+type ResetDevice interface {
+	BusReset()
+}
+
+// OutputReportDevice is an optional interface for devices with output reports
+// a host may split across several interrupt OUT transfers, e.g. reports
+// larger than the endpoint's wMaxPacketSize. The server reassembles the
+// fragments and passes HandleTransfer whole reports.
+type OutputReportDevice interface {
+	// OutputReportSize returns the size of the output report on endpoint ep
+	// starting with the byte first, the report ID for numbered reports,
+	// including that byte. 0 passes the data on as it arrives. Devices
+	// declaring their reports in a HID descriptor may derive the sizes with
+	// hid.Report.OutputReportSizes.
+	OutputReportSize(ep uint32, first byte) int
+}
+
+// TransferStatusDevice is an optional interface for devices that stall
+// transfers or fail them with another status. When implemented, the server
+// calls it instead of Device.HandleTransfer.
+type TransferStatusDevice interface {
+	// HandleTransferStatus is Device.HandleTransfer with the outcome as an
+	// error: nil on success, a TransferError for its status, ErrPending to
+	// hold an IN transfer, and any other error for StatusProtocol.
+	HandleTransferStatus(ep uint32, dir uint32, out []byte) (resp []byte, err error)
+}
+
+// TransferError is the status a transfer completes with: a negated Linux
+// errno, as carried by USB/IP.
+type TransferError int32
+
+const (
+	StatusStall    TransferError = -32  // -EPIPE; the endpoint stays halted until the host clears it
+	StatusProtocol TransferError = -71  // -EPROTO
+	StatusOverflow TransferError = -75  // -EOVERFLOW: more data than the host asked for
+	StatusTimeout  TransferError = -110 // -ETIMEDOUT
+)
+
+func (e TransferError) Error() string {
+	switch e {
+	case StatusStall:
+		return "transfer stalled"
+	case StatusProtocol:
+		return "transfer protocol error"
+	case StatusOverflow:
+		return "transfer overflow"
+	case StatusTimeout:
+		return "transfer timed out"
+	}
+	return fmt.Sprintf("transfer failed with status %d", int32(e))
+}
+
+// Transfer runs a non-EP0 transfer on dev through HandleTransferStatus if dev
+// implements TransferStatusDevice, and through HandleTransfer, which always
+// succeeds, otherwise.
+func Transfer(dev Device, ep uint32, dir uint32, out []byte) ([]byte, error) {
+	if sd, ok := dev.(TransferStatusDevice); ok {
+		return sd.HandleTransferStatus(ep, dir, out)
+	}
+	return dev.HandleTransfer(ep, dir, out), nil
+}
+
+// ErrPending holds an IN transfer until the device has new data, like real
+// hardware NAKing polls while its state is unchanged. Devices returning it
+// implement PendingDevice. The server completes the transfer through
+// HandleTransfer once the device's InputNotifier fires, or with the data
+// HandleTransfer then returns once the endpoint's interval has passed.
+var ErrPending = errors.New("transfer pending")
+
+// PendingDevice is implemented by devices that hold IN transfers with
+// ErrPending.
+type PendingDevice interface {
+	TransferStatusDevice
+	// InputNotifier returns the notifier fired when IN data changes.
+	InputNotifier() *Notifier
+}
+
+// Notifier wakes the transfers a device holds. The zero value is ready to
+// use.
+type Notifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// Wait returns a channel closed by the next Notify.
+func (n *Notifier) Wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+	return n.ch
+}
+
+// Notify wakes everything waiting.
+func (n *Notifier) Notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+}
@@ -11,23 +11,30 @@ const (
 	UsagePageKeyboard       uint16 = 0x07
 	UsagePageLEDs           uint16 = 0x08
 	UsagePageButton         uint16 = 0x09
+	UsagePageOrdinal        uint16 = 0x0A
 	UsagePageConsumer       uint16 = 0x0C
+	UsagePagePID            uint16 = 0x0F
 )
 
 // Generic Desktop usages.
 const (
-	UsagePointer  uint16 = 0x01
-	UsageMouse    uint16 = 0x02
-	UsageJoystick uint16 = 0x04
-	UsageGamePad  uint16 = 0x05
-	UsageKeyboard uint16 = 0x06
-	UsageX        uint16 = 0x30
-	UsageY        uint16 = 0x31
-	UsageZ        uint16 = 0x32
-	UsageRx       uint16 = 0x33
-	UsageRy       uint16 = 0x34
-	UsageRz       uint16 = 0x35
-	UsageWheel    uint16 = 0x38
+	UsagePointer   uint16 = 0x01
+	UsageMouse     uint16 = 0x02
+	UsageJoystick  uint16 = 0x04
+	UsageGamePad   uint16 = 0x05
+	UsageKeyboard  uint16 = 0x06
+	UsageX         uint16 = 0x30
+	UsageY         uint16 = 0x31
+	UsageZ         uint16 = 0x32
+	UsageRx        uint16 = 0x33
+	UsageRy        uint16 = 0x34
+	UsageRz        uint16 = 0x35
+	UsageSlider    uint16 = 0x36
+	UsageDial      uint16 = 0x37
+	UsageWheel     uint16 = 0x38
+	UsageHatSwitch uint16 = 0x39
+
+	UsageResolutionMultiplier uint16 = 0x48
 )
 
 // Consumer usages.
@@ -0,0 +1,278 @@
+package hid
+
+import (
+	"fmt"
+	"strings"
+)
+
+var usagePageNames = map[uint16]string{
+	0x01: "Generic Desktop",
+	0x02: "Simulation Controls",
+	0x03: "VR Controls",
+	0x04: "Sport Controls",
+	0x05: "Game Controls",
+	0x06: "Generic Device Controls",
+	0x07: "Keyboard",
+	0x08: "LEDs",
+	0x09: "Button",
+	0x0A: "Ordinal",
+	0x0B: "Telephony Devices",
+	0x0C: "Consumer Devices",
+	0x0D: "Digitizers",
+	0x0F: "Physical Interface Device",
+	0x20: "Sensor",
+}
+
+var usageNames = map[uint16]map[uint16]string{
+	UsagePageGenericDesktop: {
+		0x01: "Pointer",
+		0x02: "Mouse",
+		0x04: "Joystick",
+		0x05: "Game Pad",
+		0x06: "Keyboard",
+		0x07: "Keypad",
+		0x08: "Multi-Axis Controller",
+		0x30: "X",
+		0x31: "Y",
+		0x32: "Z",
+		0x33: "Rx",
+		0x34: "Ry",
+		0x35: "Rz",
+		0x36: "Slider",
+		0x37: "Dial",
+		0x38: "Wheel",
+		0x39: "Hat switch",
+		0x48: "Resolution Multiplier",
+		0x80: "System Control",
+	},
+	UsagePageConsumer: {
+		0x01:  "Consumer Control",
+		0xB5:  "Scan Next Track",
+		0xB6:  "Scan Previous Track",
+		0xB7:  "Stop",
+		0xCD:  "Play/Pause",
+		0xE2:  "Mute",
+		0xE9:  "Volume Increment",
+		0xEA:  "Volume Decrement",
+		0x238: "AC Pan",
+	},
+}
+
+var collectionNames = []string{
+	"Physical", "Application", "Logical", "Report", "Named Array", "Usage Switch", "Usage Modifier",
+}
+
+var itemNames = map[ItemType]map[uint8]string{
+	ItemTypeMain: {
+		0x8: "Input", 0x9: "Output", 0xA: "Collection", 0xB: "Feature", 0xC: "End Collection",
+	},
+	ItemTypeGlobal: {
+		0x0: "Usage Page", 0x1: "Logical Minimum", 0x2: "Logical Maximum", 0x3: "Physical Minimum",
+		0x4: "Physical Maximum", 0x5: "Unit Exponent", 0x6: "Unit", 0x7: "Report Size",
+		0x8: "Report ID", 0x9: "Report Count", 0xA: "Push", 0xB: "Pop",
+	},
+	ItemTypeLocal: {
+		0x0: "Usage", 0x1: "Usage Minimum", 0x2: "Usage Maximum", 0x3: "Designator Index",
+		0x4: "Designator Minimum", 0x5: "Designator Maximum", 0x7: "String Index",
+		0x8: "String Minimum", 0x9: "String Maximum", 0xA: "Delimiter",
+	},
+}
+
+// String dumps the encoded descriptor like hid-decode of hid-tools does, see
+// Dump.
+func (r Report) String() string {
+	data, err := r.Bytes()
+	if err != nil {
+		return err.Error()
+	}
+	return Dump(data)
+}
+
+// Dump formats a report descriptor one item per line in the style of
+// hid-decode of hid-tools: the item bytes, the decoded item indented by its
+// collection depth, and its offset, e.g.
+//
+//	0x05, 0x01,                    // Usage Page (Generic Desktop)        0
+//
+// Usages are named after the usage page in effect. A malformed descriptor is
+// dumped up to the first truncated item, which is reported on the last line.
+func Dump(data []byte) string {
+	var sb strings.Builder
+	var page uint16
+	var pages []uint16 // pushed usage pages
+	depth := 0
+	for off := 0; off < len(data); {
+		it, next, err := nextItem(data, off)
+		if err != nil {
+			fmt.Fprintf(&sb, "// %v\n", err)
+			break
+		}
+		switch {
+		case it.is(ItemTypeGlobal, 0x0):
+			page = uint16(it.unsigned())
+		case it.is(ItemTypeGlobal, tagPush):
+			pages = append(pages, page)
+		case it.is(ItemTypeGlobal, tagPop) && len(pages) > 0:
+			page = pages[len(pages)-1]
+			pages = pages[:len(pages)-1]
+		case it.is(ItemTypeMain, tagEndCollection):
+			depth = max(depth-1, 0)
+		}
+
+		hex := make([]string, 0, next-off)
+		for _, b := range data[off:next] {
+			hex = append(hex, fmt.Sprintf("0x%02x,", b))
+		}
+		desc := strings.Repeat(" ", depth) + it.describe(page)
+		fmt.Fprintf(&sb, "%-31s// %-35s %d\n", strings.Join(hex, " "), desc, off)
+
+		if it.is(ItemTypeMain, tagCollection) {
+			depth++
+		}
+		off = next
+	}
+	return sb.String()
+}
+
+// describe returns the name and value of the item, with usages named after
+// page.
+func (it rawItem) describe(page uint16) string {
+	if it.long {
+		return fmt.Sprintf("Long Item (tag 0x%02x, %d bytes)", it.tag, len(it.data))
+	}
+	name, ok := itemNames[it.typ][it.tag]
+	if !ok {
+		return fmt.Sprintf("Unknown (type %d, tag 0x%x)", it.typ, it.tag)
+	}
+	switch it.typ {
+	case ItemTypeMain:
+		switch it.tag {
+		case tagCollection:
+			return fmt.Sprintf("%s (%s)", name, collectionName(it.unsigned()))
+		case tagEndCollection:
+			return name
+		}
+		return fmt.Sprintf("%s (%s)", name, mainFlagNames(it.unsigned(), it.tag == 0x8))
+	case ItemTypeGlobal:
+		switch it.tag {
+		case 0x0:
+			return fmt.Sprintf("%s (%s)", name, usagePageName(uint16(it.unsigned())))
+		case 0x1, 0x2, 0x3, 0x4:
+			return fmt.Sprintf("%s (%d)", name, it.signed())
+		case 0x5:
+			exp := int(it.unsigned() & 0x0F)
+			if exp > 7 {
+				exp -= 16
+			}
+			return fmt.Sprintf("%s (%d)", name, exp)
+		case 0x6:
+			return fmt.Sprintf("%s (%s)", name, unitName(it.unsigned()))
+		case tagPush, tagPop:
+			return name
+		}
+	case ItemTypeLocal:
+		if it.tag == 0x0 {
+			u := it.unsigned()
+			if len(it.data) == 4 {
+				// An extended usage carries its own page.
+				page = uint16(u >> 16)
+			}
+			return fmt.Sprintf("%s (%s)", name, usageName(page, uint16(u)))
+		}
+	}
+	return fmt.Sprintf("%s (%d)", name, it.unsigned())
+}
+
+func usagePageName(page uint16) string {
+	if name, ok := usagePageNames[page]; ok {
+		return name
+	}
+	if page >= 0xFF00 {
+		return fmt.Sprintf("Vendor Defined Page 0x%04X", page)
+	}
+	return fmt.Sprintf("0x%04X", page)
+}
+
+func usageName(page, usage uint16) string {
+	if name, ok := usageNames[page][usage]; ok {
+		return name
+	}
+	switch {
+	case page == UsagePageButton:
+		return fmt.Sprintf("Button %d", usage)
+	case page >= 0xFF00:
+		return fmt.Sprintf("Vendor Usage 0x%02x", usage)
+	}
+	return fmt.Sprintf("0x%02x", usage)
+}
+
+func collectionName(kind uint32) string {
+	if int(kind) < len(collectionNames) {
+		return collectionNames[kind]
+	}
+	if kind >= 0x80 && kind <= 0xFF {
+		return fmt.Sprintf("Vendor Defined 0x%02x", kind)
+	}
+	return fmt.Sprintf("0x%02x", kind)
+}
+
+// mainFlagNames names the bits of an Input, Output or Feature item.
+func mainFlagNames(flags uint32, input bool) string {
+	pick := func(bit uint32, set, unset string) string {
+		if flags&bit != 0 {
+			return set
+		}
+		return unset
+	}
+	names := []string{pick(0x01, "Cnst", "Data"), pick(0x02, "Var", "Arr"), pick(0x04, "Rel", "Abs")}
+	for _, f := range []struct {
+		bit  uint32
+		name string
+	}{{0x08, "Wrap"}, {0x10, "NonLin"}, {0x20, "NoPref"}, {0x40, "Null"}, {0x80, "Vol"}, {0x100, "Buff"}} {
+		if flags&f.bit != 0 && !(input && f.bit == 0x80) {
+			names = append(names, f.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+var unitSystems = []struct {
+	name  string
+	units [6]string // length, mass, time, temperature, current, luminous intensity
+}{
+	{"SILinear", [6]string{"cm", "g", "s", "K", "A", "cd"}},
+	{"SIRotation", [6]string{"rad", "g", "s", "K", "A", "cd"}},
+	{"EnglishLinear", [6]string{"in", "slug", "s", "F", "A", "cd"}},
+	{"EnglishRotation", [6]string{"deg", "slug", "s", "F", "A", "cd"}},
+}
+
+// unitName decodes a Unit item: the system in the low nibble and the signed
+// exponent of each base unit in the nibbles above.
+func unitName(unit uint32) string {
+	sys := unit & 0x0F
+	if sys == 0 || int(sys) > len(unitSystems) {
+		if unit == 0 {
+			return "None"
+		}
+		return fmt.Sprintf("0x%x", unit)
+	}
+	s := unitSystems[sys-1]
+	var parts []string
+	for i, u := range s.units {
+		exp := int(unit >> (4 * (i + 1)) & 0x0F)
+		if exp > 7 {
+			exp -= 16
+		}
+		switch exp {
+		case 0:
+		case 1:
+			parts = append(parts, u)
+		default:
+			parts = append(parts, fmt.Sprintf("%s^%d", u, exp))
+		}
+	}
+	if len(parts) == 0 {
+		return s.name
+	}
+	return fmt.Sprintf("%s: %s", s.name, strings.Join(parts, " * "))
+}
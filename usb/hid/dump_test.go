@@ -0,0 +1,49 @@
+package hid_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Alia5/VIIPER/usb/hid"
+)
+
+func TestDump(t *testing.T) {
+	r := hid.Report{Items: []hid.Item{
+		hid.UsagePage{Page: hid.UsagePageGenericDesktop},
+		hid.Usage{Usage: hid.UsageMouse},
+		hid.Collection{Kind: hid.CollectionApplication, Items: []hid.Item{
+			hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0xA}, // Push
+			hid.UsagePage{Page: hid.UsagePageButton},
+			hid.Usage{Usage: 3},
+			hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0xB}, // Pop
+			hid.Usage{Usage: hid.UsageWheel},
+			hid.AnyItem{Type: hid.ItemTypeLocal, Tag: 0x0, Data: hid.Data{0x38, 0x02, 0x0C, 0x00}},
+			hid.LogicalMinimum{Min: -127},
+			hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x6, Data: hid.Data{0x01, 0x10}},
+			hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainRel},
+			hid.Output{Flags: hid.MainConst | hid.MainVolatile},
+		}},
+	}}
+	assert.Equal(t, ""+
+		"0x05, 0x01,                    // Usage Page (Generic Desktop)        0\n"+
+		"0x09, 0x02,                    // Usage (Mouse)                       2\n"+
+		"0xa1, 0x01,                    // Collection (Application)            4\n"+
+		"0xa4,                          //  Push                               6\n"+
+		"0x05, 0x09,                    //  Usage Page (Button)                7\n"+
+		"0x09, 0x03,                    //  Usage (Button 3)                   9\n"+
+		"0xb4,                          //  Pop                                11\n"+
+		"0x09, 0x38,                    //  Usage (Wheel)                      12\n"+
+		"0x0b, 0x38, 0x02, 0x0c, 0x00,  //  Usage (AC Pan)                     14\n"+
+		"0x15, 0x81,                    //  Logical Minimum (-127)             19\n"+
+		"0x66, 0x01, 0x10,              //  Unit (SILinear: s)                 21\n"+
+		"0x81, 0x06,                    //  Input (Data,Var,Rel)               24\n"+
+		"0x91, 0x81,                    //  Output (Cnst,Arr,Abs,Vol)          26\n"+
+		"0xc0,                          // End Collection                      28\n",
+		r.String())
+
+	assert.Equal(t, ""+
+		"0x05, 0x01,                    // Usage Page (Generic Desktop)        0\n"+
+		"// hid: item at offset 2 is truncated\n",
+		hid.Dump([]byte{0x05, 0x01, 0x26, 0xFF}))
+}
@@ -0,0 +1,176 @@
+package hid
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Tags of the items the parser and the dump treat specially.
+const (
+	tagCollection    = 0xA
+	tagEndCollection = 0xC
+	tagPush          = 0xA
+	tagPop           = 0xB
+	longItemHeader   = 0xFE
+)
+
+// rawItem is one item as found in a descriptor.
+type rawItem struct {
+	offset int
+	typ    ItemType
+	tag    uint8
+	data   Data
+	long   bool
+}
+
+// nextItem decodes the item at data[off:] and returns it with the offset of
+// the item following it.
+func nextItem(data []byte, off int) (rawItem, int, error) {
+	h := data[off]
+	if h == longItemHeader {
+		if off+3 > len(data) {
+			return rawItem{}, 0, fmt.Errorf("hid: long item at offset %d is truncated", off)
+		}
+		n := int(data[off+1])
+		end := off + 3 + n
+		if end > len(data) {
+			return rawItem{}, 0, fmt.Errorf("hid: long item at offset %d is truncated", off)
+		}
+		return rawItem{offset: off, tag: data[off+2], data: bytes.Clone(data[off+3 : end]), long: true}, end, nil
+	}
+	n := int(h & 0x03)
+	if n == 3 {
+		n = 4
+	}
+	end := off + 1 + n
+	if end > len(data) {
+		return rawItem{}, 0, fmt.Errorf("hid: item at offset %d is truncated", off)
+	}
+	it := rawItem{offset: off, typ: ItemType(h >> 2 & 0x03), tag: h >> 4}
+	if n > 0 {
+		it.data = bytes.Clone(data[off+1 : end])
+	}
+	return it, end, nil
+}
+
+func (it rawItem) is(typ ItemType, tag uint8) bool {
+	return !it.long && it.typ == typ && it.tag == tag
+}
+
+// unsigned returns the item data as a little-endian unsigned value.
+func (it rawItem) unsigned() uint32 {
+	var v uint32
+	for i, b := range it.data {
+		v |= uint32(b) << (8 * i)
+	}
+	return v
+}
+
+// signed returns the item data as a little-endian two's-complement value.
+func (it rawItem) signed() int32 {
+	switch len(it.data) {
+	case 1:
+		return int32(int8(it.data[0]))
+	case 2:
+		return int32(int16(it.unsigned()))
+	}
+	return int32(it.unsigned())
+}
+
+// item returns the typed item of this package encoding to exactly the bytes
+// of it, or an AnyItem or LongItem holding them.
+func (it rawItem) item() Item {
+	if it.long {
+		return LongItem{Tag: it.tag, Data: it.data}
+	}
+	var typed Item
+	switch {
+	case it.is(ItemTypeGlobal, 0x0) && it.unsigned() <= 0xFFFF:
+		typed = UsagePage{Page: uint16(it.unsigned())}
+	case it.is(ItemTypeLocal, 0x0) && it.unsigned() <= 0xFFFF:
+		typed = Usage{Usage: uint16(it.unsigned())}
+	case it.is(ItemTypeLocal, 0x1) && it.unsigned() <= 0xFFFF:
+		typed = UsageMinimum{Min: uint16(it.unsigned())}
+	case it.is(ItemTypeLocal, 0x2) && it.unsigned() <= 0xFFFF:
+		typed = UsageMaximum{Max: uint16(it.unsigned())}
+	case it.is(ItemTypeGlobal, 0x1):
+		typed = LogicalMinimum{Min: it.signed()}
+	case it.is(ItemTypeGlobal, 0x2):
+		typed = LogicalMaximum{Max: it.signed()}
+	case it.is(ItemTypeGlobal, 0x7) && it.unsigned() <= 0xFF:
+		typed = ReportSize{Bits: uint8(it.unsigned())}
+	case it.is(ItemTypeGlobal, 0x9) && it.unsigned() <= 0xFFFF:
+		typed = ReportCount{Count: uint16(it.unsigned())}
+	case it.is(ItemTypeMain, 0x8) && it.unsigned() <= 0xFF:
+		typed = Input{Flags: MainFlags(it.unsigned())}
+	case it.is(ItemTypeMain, 0x9) && it.unsigned() <= 0xFF:
+		typed = Output{Flags: MainFlags(it.unsigned())}
+	case it.is(ItemTypeMain, 0xB) && it.unsigned() <= 0xFF:
+		typed = Feature{Flags: MainFlags(it.unsigned())}
+	}
+	// Typed items use the shortest encoding; anything else stays raw.
+	if typed != nil {
+		e := &encoder{}
+		if typed.encode(e) == nil && bytes.Equal(e.buf[1:], it.data) {
+			return typed
+		}
+	}
+	return AnyItem{Type: it.typ, Tag: it.tag, Data: it.data}
+}
+
+// Parse decodes a report descriptor. Items are decoded into the typed items
+// of this package where those encode to the same bytes, collections into
+// nested Collections, and all other items into AnyItem or LongItem, so Bytes
+// of the result returns data unchanged.
+//
+// Truncated items, unbalanced collections and Pop items without a Push are
+// errors.
+func Parse(data []byte) (Report, error) {
+	type frame struct {
+		begin rawItem
+		items []Item
+	}
+	stack := []*frame{{}}
+	pushed := 0
+	for off := 0; off < len(data); {
+		it, next, err := nextItem(data, off)
+		if err != nil {
+			return Report{}, err
+		}
+		off = next
+		top := stack[len(stack)-1]
+		switch {
+		case it.is(ItemTypeMain, tagCollection):
+			stack = append(stack, &frame{begin: it})
+		case it.is(ItemTypeMain, tagEndCollection):
+			if len(stack) == 1 {
+				return Report{}, fmt.Errorf("hid: end collection at offset %d without collection", it.offset)
+			}
+			stack = stack[:len(stack)-1]
+			parent := stack[len(stack)-1]
+			if len(top.begin.data) == 1 && len(it.data) == 0 {
+				parent.items = append(parent.items, Collection{Kind: CollectionKind(top.begin.data[0]), Items: top.items})
+				break
+			}
+			// Collection encodes neither other sizes nor end data.
+			parent.items = append(parent.items, top.begin.item())
+			parent.items = append(parent.items, top.items...)
+			parent.items = append(parent.items, it.item())
+		default:
+			switch {
+			case it.is(ItemTypeGlobal, tagPush):
+				pushed++
+			case it.is(ItemTypeGlobal, tagPop):
+				if pushed == 0 {
+					return Report{}, fmt.Errorf("hid: pop at offset %d without push", it.offset)
+				}
+				pushed--
+			}
+			top.items = append(top.items, it.item())
+		}
+	}
+	if open := len(stack) - 1; open > 0 {
+		return Report{}, fmt.Errorf("hid: %d collections not closed", open)
+	}
+	return Report{Items: stack[0].items}, nil
+}
@@ -0,0 +1,150 @@
+package hid_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/device/dualshock4"
+	"github.com/Alia5/VIIPER/device/joystick"
+	"github.com/Alia5/VIIPER/device/keyboard"
+	"github.com/Alia5/VIIPER/device/mouse"
+	"github.com/Alia5/VIIPER/device/switchpro"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usb/hid"
+)
+
+// treeReports returns the encoded report descriptors of all HID devices in
+// the tree, in every variant their options select.
+func treeReports(t testing.TB) map[string][]byte {
+	opts := func(k string) *device.CreateOptions {
+		return &device.CreateOptions{DeviceSpecific: map[string]any{k: true}}
+	}
+	devices := map[string]func() (usb.Device, error){
+		"keyboard":             func() (usb.Device, error) { return keyboard.New(nil) },
+		"keyboard/mediaKeys":   func() (usb.Device, error) { return keyboard.New(opts("mediaKeys")) },
+		"mouse":                func() (usb.Device, error) { return mouse.New(nil) },
+		"joystick":             func() (usb.Device, error) { return joystick.New(nil) },
+		"joystick/ffb":         func() (usb.Device, error) { return joystick.New(opts("forceFeedback")) },
+		"dualshock4":           func() (usb.Device, error) { return dualshock4.New(nil) },
+		"dualshock4/audioStub": func() (usb.Device, error) { return dualshock4.New(opts("audioStub")) },
+		"switchpro":            func() (usb.Device, error) { return switchpro.New(nil) },
+	}
+	reports := map[string][]byte{}
+	for name, create := range devices {
+		dev, err := create()
+		require.NoError(t, err, name)
+		for _, iface := range dev.GetDescriptor().Interfaces {
+			if iface.HID == nil {
+				continue
+			}
+			data, err := iface.HID.Report.Bytes()
+			require.NoError(t, err, name)
+			reports[name] = data
+		}
+	}
+	return reports
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	for name, data := range treeReports(t) {
+		t.Run(name, func(t *testing.T) {
+			r, err := hid.Parse(data)
+			require.NoError(t, err)
+			out, err := r.Bytes()
+			require.NoError(t, err)
+			assert.Equal(t, hid.Data(data), out)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	r, err := hid.Parse([]byte{
+		0x05, 0x01, // Usage Page (Generic Desktop)
+		0x09, 0x05, // Usage (Game Pad)
+		0xA1, 0x01, // Collection (Application)
+		0x85, 0x01, //  Report ID (1)
+		0x15, 0x00, //  Logical Minimum (0)
+		0x26, 0xFF, 0x00, //  Logical Maximum (255)
+		0x0A, 0x01, 0x00, //  Usage (1), not in its shortest form
+		0xA1, 0x00, //  Collection (Physical)
+		0x81, 0x02, //   Input (Data,Var,Abs)
+		0xC0,                         //  End Collection
+		0xFE, 0x02, 0x10, 0xAA, 0xBB, //  Long Item
+		0xC0, // End Collection
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []hid.Item{
+		hid.UsagePage{Page: hid.UsagePageGenericDesktop},
+		hid.Usage{Usage: hid.UsageGamePad},
+		hid.Collection{Kind: hid.CollectionApplication, Items: []hid.Item{
+			hid.AnyItem{Type: hid.ItemTypeGlobal, Tag: 0x8, Data: hid.Data{0x01}},
+			hid.LogicalMinimum{Min: 0},
+			hid.LogicalMaximum{Max: 255},
+			hid.AnyItem{Type: hid.ItemTypeLocal, Tag: 0x0, Data: hid.Data{0x01, 0x00}},
+			hid.Collection{Kind: hid.CollectionPhysical, Items: []hid.Item{
+				hid.Input{Flags: hid.MainData | hid.MainVar | hid.MainAbs},
+			}},
+			hid.LongItem{Tag: 0x10, Data: hid.Data{0xAA, 0xBB}},
+		}},
+	}, r.Items)
+
+	t.Run("collection with data at its end stays raw", func(t *testing.T) {
+		data := []byte{0xA1, 0x01, 0x81, 0x02, 0xC1, 0x00}
+		r, err := hid.Parse(data)
+		require.NoError(t, err)
+		assert.Equal(t, []hid.Item{
+			hid.AnyItem{Type: hid.ItemTypeMain, Tag: 0xA, Data: hid.Data{0x01}},
+			hid.Input{Flags: hid.MainVar},
+			hid.AnyItem{Type: hid.ItemTypeMain, Tag: 0xC, Data: hid.Data{0x00}},
+		}, r.Items)
+		out, err := r.Bytes()
+		require.NoError(t, err)
+		assert.Equal(t, hid.Data(data), out)
+	})
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"truncated item", []byte{0x05, 0x01, 0x26, 0xFF}, "hid: item at offset 2 is truncated"},
+		{"truncated long item", []byte{0xFE, 0x04, 0x10, 0x00}, "hid: long item at offset 0 is truncated"},
+		{"long item header only", []byte{0xFE, 0x04}, "hid: long item at offset 0 is truncated"},
+		{"unopened collection", []byte{0xA1, 0x01, 0xC0, 0xC0}, "hid: end collection at offset 3 without collection"},
+		{"unclosed collections", []byte{0xA1, 0x01, 0xA1, 0x00}, "hid: 2 collections not closed"},
+		{"pop without push", []byte{0xA4, 0xB4, 0xB4}, "hid: pop at offset 2 without push"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := hid.Parse(tt.data)
+			assert.EqualError(t, err, tt.want)
+		})
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, data := range treeReports(f) {
+		f.Add(data)
+	}
+	f.Add([]byte{0xFE, 0x00, 0x00})
+	f.Add([]byte{0xA1, 0x01, 0xC1, 0x00})
+	f.Add([]byte{0x07, 0x01, 0x00, 0x09, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_ = hid.Dump(data)
+		r, err := hid.Parse(data)
+		if err != nil {
+			return
+		}
+		out, err := r.Bytes()
+		if err != nil {
+			t.Fatalf("parsed report does not encode: %v", err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("round trip changed the descriptor:\n got %x\nwant %x", out, data)
+		}
+		_ = r.String()
+	})
+}
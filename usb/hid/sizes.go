@@ -0,0 +1,65 @@
+package hid
+
+// Tags of the global items sizing reports.
+const (
+	tagReportSize  = 0x7
+	tagReportID    = 0x8
+	tagReportCount = 0x9
+	tagInput       = 0x8
+	tagOutput      = 0x9
+)
+
+// OutputReportSizes returns the size in bytes of each output report r
+// declares, by report ID, including the report ID byte. A descriptor without
+// report IDs declares a single report, returned under ID 0.
+func (r Report) OutputReportSizes() (map[uint8]int, error) {
+	return r.reportSizes(tagOutput)
+}
+
+// InputReportSizes is OutputReportSizes for the input reports.
+func (r Report) InputReportSizes() (map[uint8]int, error) {
+	return r.reportSizes(tagInput)
+}
+
+func (r Report) reportSizes(mainTag uint8) (map[uint8]int, error) {
+	data, err := r.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	type globals struct {
+		size, count uint32
+		id          uint8
+	}
+	var g globals
+	var stack []globals
+	bits := map[uint8]uint32{}
+	for off := 0; off < len(data); {
+		it, next, err := nextItem(data, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next
+		switch {
+		case it.is(ItemTypeGlobal, tagReportSize):
+			g.size = it.unsigned()
+		case it.is(ItemTypeGlobal, tagReportCount):
+			g.count = it.unsigned()
+		case it.is(ItemTypeGlobal, tagReportID):
+			g.id = uint8(it.unsigned())
+		case it.is(ItemTypeGlobal, tagPush):
+			stack = append(stack, g)
+		case it.is(ItemTypeGlobal, tagPop) && len(stack) > 0:
+			g, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		case it.is(ItemTypeMain, mainTag):
+			bits[g.id] += g.size * g.count
+		}
+	}
+	sizes := make(map[uint8]int, len(bits))
+	for id, n := range bits {
+		sizes[id] = int((n + 7) / 8)
+		if id != 0 {
+			sizes[id]++
+		}
+	}
+	return sizes, nil
+}
@@ -0,0 +1,79 @@
+package hid_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/usb/hid"
+)
+
+func TestOutputReportSizes(t *testing.T) {
+	type testCase struct {
+		name string
+		desc []byte
+		want map[uint8]int
+	}
+
+	reports := treeReports(t)
+	cases := []testCase{
+		{
+			name: "dualshock4",
+			desc: reports["dualshock4"],
+			want: map[uint8]int{0x05: 32},
+		},
+		{
+			name: "keyboard leds",
+			desc: reports["keyboard"],
+			want: map[uint8]int{0: 1},
+		},
+		{
+			name: "mouse without outputs",
+			desc: reports["mouse"],
+			want: map[uint8]int{},
+		},
+		{
+			name: "push and pop",
+			desc: []byte{
+				0x85, 0x02, // Report ID 2
+				0x75, 0x08, // Report Size 8
+				0x95, 0x03, // Report Count 3
+				0xa4,       // Push
+				0x85, 0x03, // Report ID 3
+				0x75, 0x01, // Report Size 1
+				0x91, 0x02, // Output: 3 bits of report 3
+				0xb4,       // Pop
+				0x91, 0x02, // Output: 3 bytes of report 2
+				0x81, 0x02, // Input
+			},
+			want: map[uint8]int{0x02: 4, 0x03: 2},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := hid.Parse(tc.desc)
+			require.NoError(t, err)
+			got, err := r.OutputReportSizes()
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestInputReportSizes(t *testing.T) {
+	reports := treeReports(t)
+	for name, want := range map[string]map[uint8]int{
+		"dualshock4": {0x01: 64},
+		"mouse":      {0: 9},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r, err := hid.Parse(reports[name])
+			require.NoError(t, err)
+			got, err := r.InputReportSizes()
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package usb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// BOS and Microsoft OS 2.0 descriptor constants.
+const (
+	BOSDescType              = 0x0F
+	DeviceCapabilityDescType = 0x10
+	PlatformCapabilityType   = 0x05
+	BOSDescLen               = 5
+
+	// MSOS20DescriptorIndex is the wIndex of the vendor request reading the
+	// MS OS 2.0 descriptor set.
+	MSOS20DescriptorIndex = 0x07
+	// MSOS20VendorCode is the bRequest of that request, unless MSOS20 sets
+	// another.
+	MSOS20VendorCode = 0x91
+	// MSOS20WindowsVersion is the minimum Windows version the descriptor
+	// set applies to, Windows 8.1.
+	MSOS20WindowsVersion = 0x06030000
+
+	msos20SetHeaderDescriptor = 0x00
+	msos20FeatureCompatibleID = 0x03
+	msos20SetHeaderLen        = 10
+	msos20CompatibleIDLen     = 20
+	msos20PlatformCapLen      = 28
+	msos20IDLen               = 8
+
+	// bcdUSB21 is the lowest bcdUSB hosts read a BOS descriptor from.
+	bcdUSB21 = 0x0201
+)
+
+// msos20PlatformUUID is the platform capability UUID
+// {D8DD60DF-4589-4CC7-9CD2-659D9E648A9F} in its wire byte order.
+var msos20PlatformUUID = [16]byte{
+	0xDF, 0x60, 0xDD, 0xD8, 0x89, 0x45, 0xC7, 0x4C,
+	0x9C, 0xD2, 0x65, 0x9D, 0x9E, 0x64, 0x8A, 0x9F,
+}
+
+// MSOS20 describes the Microsoft OS 2.0 descriptors of a device, which let
+// Windows bind a driver by compatible ID whatever the VID/PID. The compatible
+// ID applies to the whole device, which suits vendor-class (0xff) devices
+// Windows does not split into functions.
+type MSOS20 struct {
+	// VendorCode is the bRequest Windows reads the descriptor set with,
+	// MSOS20VendorCode if 0.
+	VendorCode uint8
+	// CompatibleID and SubCompatibleID are matched as
+	// USB\MS_COMP_<CompatibleID>&SUBCOMP_<SubCompatibleID>, up to 8 ASCII
+	// characters each.
+	CompatibleID    string
+	SubCompatibleID string
+}
+
+// Code returns the vendor code of the descriptor set request.
+func (m *MSOS20) Code() uint8 {
+	if m.VendorCode == 0 {
+		return MSOS20VendorCode
+	}
+	return m.VendorCode
+}
+
+// DescriptorSet returns the MS OS 2.0 descriptor set: the set header and a
+// compatible ID feature descriptor.
+func (m *MSOS20) DescriptorSet() []byte {
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, uint16(msos20SetHeaderLen))
+	_ = binary.Write(&b, binary.LittleEndian, uint16(msos20SetHeaderDescriptor))
+	_ = binary.Write(&b, binary.LittleEndian, uint32(MSOS20WindowsVersion))
+	_ = binary.Write(&b, binary.LittleEndian, uint16(msos20SetHeaderLen+msos20CompatibleIDLen))
+
+	_ = binary.Write(&b, binary.LittleEndian, uint16(msos20CompatibleIDLen))
+	_ = binary.Write(&b, binary.LittleEndian, uint16(msos20FeatureCompatibleID))
+	b.Write(padID(m.CompatibleID))
+	b.Write(padID(m.SubCompatibleID))
+	return b.Bytes()
+}
+
+// BOS returns the BOS descriptor announcing the descriptor set through the
+// MS OS 2.0 platform capability.
+func (m *MSOS20) BOS() []byte {
+	var b bytes.Buffer
+	b.WriteByte(BOSDescLen)
+	b.WriteByte(BOSDescType)
+	_ = binary.Write(&b, binary.LittleEndian, uint16(BOSDescLen+msos20PlatformCapLen))
+	b.WriteByte(1) // bNumDeviceCaps
+
+	b.WriteByte(msos20PlatformCapLen)
+	b.WriteByte(DeviceCapabilityDescType)
+	b.WriteByte(PlatformCapabilityType)
+	b.WriteByte(0) // bReserved
+	b.Write(msos20PlatformUUID[:])
+	_ = binary.Write(&b, binary.LittleEndian, uint32(MSOS20WindowsVersion))
+	_ = binary.Write(&b, binary.LittleEndian, uint16(msos20SetHeaderLen+msos20CompatibleIDLen))
+	b.WriteByte(m.Code())
+	b.WriteByte(0) // bAltEnumCode
+	return b.Bytes()
+}
+
+// Validate checks that the IDs fit their 8 byte fields.
+func (m *MSOS20) Validate() error {
+	for _, id := range [][2]string{{"compatible ID", m.CompatibleID}, {"sub-compatible ID", m.SubCompatibleID}} {
+		if len(id[1]) > msos20IDLen {
+			return fmt.Errorf("%s %q is longer than %d characters", id[0], id[1], msos20IDLen)
+		}
+		for _, r := range id[1] {
+			if r < 0x20 || r > 0x7e {
+				return fmt.Errorf("%s %q contains %q, only printable ASCII is allowed", id[0], id[1], r)
+			}
+		}
+	}
+	if m.CompatibleID == "" {
+		return fmt.Errorf("compatible ID is empty")
+	}
+	return nil
+}
+
+// padID returns id NUL-padded to 8 bytes.
+func padID(id string) []byte {
+	out := make([]byte, msos20IDLen)
+	copy(out, id)
+	return out
+}
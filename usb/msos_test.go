@@ -0,0 +1,68 @@
+package usb_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// The layouts follow the examples of Microsoft's "Microsoft OS 2.0
+// Descriptors Specification": the BOS descriptor with a single MS OS 2.0
+// platform capability and a descriptor set holding one compatible ID.
+var (
+	xusbBOS = []byte{
+		0x05, 0x0F, 0x21, 0x00, 0x01, // BOS header, wTotalLength 33, one capability
+		0x1C, 0x10, 0x05, 0x00, // platform capability, bLength 28
+		0xDF, 0x60, 0xDD, 0xD8, 0x89, 0x45, 0xC7, 0x4C, // {D8DD60DF-4589-4CC7-
+		0x9C, 0xD2, 0x65, 0x9D, 0x9E, 0x64, 0x8A, 0x9F, //  9CD2-659D9E648A9F}
+		0x00, 0x00, 0x03, 0x06, // dwWindowsVersion 6.3
+		0x1E, 0x00, // wMSOSDescriptorSetTotalLength 30
+		0x91, // bMS_VendorCode
+		0x00, // bAltEnumCode
+	}
+	xusbSet = []byte{
+		0x0A, 0x00, 0x00, 0x00, // set header, bLength 10
+		0x00, 0x00, 0x03, 0x06, // dwWindowsVersion 6.3
+		0x1E, 0x00, // wTotalLength 30
+		0x14, 0x00, 0x03, 0x00, // compatible ID, bLength 20
+		'X', 'U', 'S', 'B', '1', '0', 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+)
+
+func TestMSOS20(t *testing.T) {
+	m := &usb.MSOS20{CompatibleID: "XUSB10"}
+	assert.Equal(t, xusbBOS, m.BOS())
+	assert.Equal(t, xusbSet, m.DescriptorSet())
+
+	winusb := &usb.MSOS20{VendorCode: 0x01, CompatibleID: "WINUSB", SubCompatibleID: "SUB1"}
+	bos := winusb.BOS()
+	assert.Equal(t, byte(0x01), bos[31], "bMS_VendorCode")
+	assert.Equal(t, []byte{
+		0x14, 0x00, 0x03, 0x00,
+		'W', 'I', 'N', 'U', 'S', 'B', 0x00, 0x00,
+		'S', 'U', 'B', '1', 0x00, 0x00, 0x00, 0x00,
+	}, winusb.DescriptorSet()[10:])
+}
+
+func TestMSOS20BcdUSB(t *testing.T) {
+	d := validDescriptor()
+	assert.Equal(t, []byte{0x00, 0x02}, d.Bytes()[2:4])
+	d.MSOS20 = &usb.MSOS20{CompatibleID: "WINUSB"}
+	assert.Equal(t, []byte{0x01, 0x02}, d.Bytes()[2:4], "BOS needs USB 2.01")
+	d.Device.BcdUSB = 0x0210
+	assert.Equal(t, []byte{0x10, 0x02}, d.Bytes()[2:4])
+}
+
+func TestMSOS20Validate(t *testing.T) {
+	assert.NoError(t, (&usb.MSOS20{CompatibleID: "XUSB10", SubCompatibleID: "12345678"}).Validate())
+	assert.EqualError(t, (&usb.MSOS20{CompatibleID: "XUSB10XUSB"}).Validate(), `compatible ID "XUSB10XUSB" is longer than 8 characters`)
+	assert.EqualError(t, (&usb.MSOS20{CompatibleID: "XUSB10", SubCompatibleID: "Ü"}).Validate(), `sub-compatible ID "Ü" contains 'Ü', only printable ASCII is allowed`)
+	assert.EqualError(t, (&usb.MSOS20{}).Validate(), "compatible ID is empty")
+
+	d := validDescriptor()
+	d.MSOS20 = &usb.MSOS20{}
+	assert.ErrorContains(t, d.Validate(), "usb: MS OS 2.0 descriptors: compatible ID is empty")
+}
@@ -21,11 +21,19 @@ const (
 
 // Descriptor lengths in bytes (fixed values from USB spec)
 const (
-	DeviceDescLen    = 18
-	ConfigDescLen    = 9
-	InterfaceDescLen = 9
-	EndpointDescLen  = 7
-	HIDDescLen       = 9
+	DeviceDescLen        = 18
+	ConfigDescLen        = 9
+	InterfaceDescLen     = 9
+	EndpointDescLen      = 7
+	AudioEndpointDescLen = 9
+	HIDDescLen           = 9
+)
+
+// Endpoint transfer types, the low bits of bmAttributes.
+const (
+	EndpointTypeMask        = 0x03
+	EndpointTypeIsochronous = 0x01
+	EndpointTypeInterrupt   = 0x03
 )
 
 type Data []uint8
@@ -35,9 +43,28 @@ type Descriptor struct {
 	Device     DeviceDescriptor
 	Interfaces []InterfaceConfig
 	Strings    map[uint8]string
+	// MSOS20, if set, has the device report a BOS descriptor with the
+	// Microsoft OS 2.0 platform capability and answer the descriptor set
+	// request. Hosts only ask devices of USB 2.01 or later for their BOS
+	// descriptor, so Bytes reports at least that bcdUSB then.
+	MSOS20 *MSOS20
+}
+
+// NumInterfaces returns the number of interfaces, counting alternate
+// settings once.
+func (d *Descriptor) NumInterfaces() int {
+	n := 0
+	for _, iface := range d.Interfaces {
+		if iface.Descriptor.BAlternateSetting == 0 {
+			n++
+		}
+	}
+	return n
 }
 
 // InterfaceConfig holds all descriptors for a single interface for bus management.
+// Alternate settings of an interface are separate entries sharing
+// BInterfaceNumber, following the one with the setting before.
 type InterfaceConfig struct {
 	Descriptor InterfaceDescriptor
 	Endpoints  []EndpointDescriptor
@@ -97,7 +124,11 @@ func (d Descriptor) Bytes() []byte {
 	var b bytes.Buffer
 	b.WriteByte(DeviceDescLen)
 	b.WriteByte(DeviceDescType)
-	_ = binary.Write(&b, binary.LittleEndian, d.Device.BcdUSB)
+	bcdUSB := d.Device.BcdUSB
+	if d.MSOS20 != nil && bcdUSB < bcdUSB21 {
+		bcdUSB = bcdUSB21
+	}
+	_ = binary.Write(&b, binary.LittleEndian, bcdUSB)
 	b.WriteByte(d.Device.BDeviceClass)
 	b.WriteByte(d.Device.BDeviceSubClass)
 	b.WriteByte(d.Device.BDeviceProtocol)
@@ -164,16 +195,39 @@ type EndpointDescriptor struct {
 	BMAttributes     uint8
 	WMaxPacketSize   uint16 // LE
 	BInterval        uint8
+
+	// Audio marks an audio class 1.0 endpoint, whose descriptor has 9 bytes
+	// and ends with BRefresh and BSynchAddress.
+	Audio         bool
+	BRefresh      uint8
+	BSynchAddress uint8
+
+	// ClassDescriptors are class-specific endpoint descriptors emitted right
+	// after the endpoint descriptor.
+	ClassDescriptors []ClassSpecificDescriptor
 }
 
+// Type returns the transfer type, one of the EndpointType constants.
+func (e EndpointDescriptor) Type() uint8 { return e.BMAttributes & EndpointTypeMask }
+
 func (e EndpointDescriptor) Write(b *bytes.Buffer) {
-	b.WriteByte(EndpointDescLen)
+	if e.Audio {
+		b.WriteByte(AudioEndpointDescLen)
+	} else {
+		b.WriteByte(EndpointDescLen)
+	}
 	b.WriteByte(EndpointDescType)
 	b.WriteByte(e.BEndpointAddress)
 	b.WriteByte(e.BMAttributes)
 	_ = binary.Write(b, binary.LittleEndian, e.WMaxPacketSize)
 	b.WriteByte(e.BInterval)
-
+	if e.Audio {
+		b.WriteByte(e.BRefresh)
+		b.WriteByte(e.BSynchAddress)
+	}
+	for _, cd := range e.ClassDescriptors {
+		b.Write(cd.Bytes())
+	}
 }
 
 // HIDSubDescriptor is one subordinate descriptor entry in the HID class descriptor.
@@ -0,0 +1,350 @@
+package usb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxClassPayload is the largest payload of a class-specific descriptor,
+// whose bLength has to cover the 2 byte header.
+const maxClassPayload = 0xFF - 2
+
+// maxStringLen is the number of UTF-16 code units a string descriptor holds.
+const maxStringLen = (0xFF - 2) / 2
+
+// maxInterruptPacket is the largest interrupt wMaxPacketSize by Speed.
+var maxInterruptPacket = map[uint32]uint16{1: 8, 2: 64, 3: 1024}
+
+// Severity grades a Finding.
+type Severity int
+
+const (
+	// SeverityError marks mistakes a host chokes on during enumeration.
+	SeverityError Severity = iota
+	// SeverityWarning marks descriptors that enumerate but that some hosts
+	// or drivers mishandle.
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Finding is a problem Lint found in a descriptor. Code identifies the
+// check, e.g. "endpoint-duplicate", and Hint tells how to fix it.
+type Finding struct {
+	Code     string
+	Severity Severity
+	Message  string
+	Hint     string
+}
+
+func (f Finding) Error() string {
+	return "usb: " + f.Message
+}
+
+// LintReport is the list of findings of Lint, in descriptor order.
+type LintReport []Finding
+
+// Errors returns the findings of SeverityError.
+func (r LintReport) Errors() LintReport {
+	return r.filter(SeverityError)
+}
+
+// Warnings returns the findings of SeverityWarning.
+func (r LintReport) Warnings() LintReport {
+	return r.filter(SeverityWarning)
+}
+
+func (r LintReport) filter(s Severity) LintReport {
+	var out LintReport
+	for _, f := range r {
+		if f.Severity == s {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Codes returns the codes of all findings.
+func (r LintReport) Codes() []string {
+	codes := make([]string, len(r))
+	for i, f := range r {
+		codes[i] = f.Code
+	}
+	return codes
+}
+
+// Err returns the errors of r joined, each on its own line, or nil.
+func (r LintReport) Err() error {
+	var errs []error
+	for _, f := range r.Errors() {
+		errs = append(errs, f)
+	}
+	return errors.Join(errs...)
+}
+
+// String lists the findings with their hints, one per line.
+func (r LintReport) String() string {
+	var b strings.Builder
+	for _, f := range r {
+		fmt.Fprintf(&b, "%s [%s] %s (%s)\n", f.Severity, f.Code, f.Message, f.Hint)
+	}
+	return b.String()
+}
+
+// Validate checks d for mistakes a host would choke on during enumeration,
+// such as endpoint counts not matching the endpoints, endpoint addresses used
+// twice, HID functions without a report descriptor or string indices without
+// a string. All problems found are returned joined, each on its own line.
+// It is the errors of Lint.
+func (d *Descriptor) Validate() error {
+	return d.Lint().Err()
+}
+
+// Lint checks d like Validate and additionally warns about descriptors hosts
+// accept but drivers may mishandle, such as HID reports larger than the
+// interrupt endpoint's packets. Embedders can run it over the descriptors of
+// their own device types in tests.
+func (d *Descriptor) Lint() LintReport {
+	var r LintReport
+	add := func(s Severity, code, hint, format string, args ...any) {
+		r = append(r, Finding{Code: code, Severity: s, Message: fmt.Sprintf(format, args...), Hint: hint})
+	}
+	fail := func(code, hint, format string, args ...any) { add(SeverityError, code, hint, format, args...) }
+	warn := func(code, hint, format string, args ...any) { add(SeverityWarning, code, hint, format, args...) }
+
+	switch d.Device.BMaxPacketSize0 {
+	case 8, 16, 32, 64:
+	default:
+		fail("max-packet-size0", "use 64 for full and high speed devices",
+			"bMaxPacketSize0 is %d, want 8, 16, 32 or 64", d.Device.BMaxPacketSize0)
+	}
+	if d.Device.BNumConfigurations != 1 {
+		fail("num-configurations", "set bNumConfigurations to 1",
+			"bNumConfigurations is %d, only a single configuration is supported", d.Device.BNumConfigurations)
+	}
+	checkString := func(field string, idx uint8) {
+		if _, ok := d.Strings[idx]; idx != 0 && !ok {
+			fail("string-missing", "add the string to Strings or set the index to 0",
+				"%s references string %d, which is not defined", field, idx)
+		}
+	}
+	for _, idx := range slices.Sorted(maps.Keys(d.Strings)) {
+		if idx == 0 {
+			continue
+		}
+		s := d.Strings[idx]
+		if n := utf8.RuneCountInString(s); n > maxStringLen {
+			fail("string-too-long", "shorten the string",
+				"string %d has %d characters, at most %d fit", idx, n, maxStringLen)
+		}
+		if !utf8.ValidString(s) {
+			warn("string-encoding", "store strings as UTF-8",
+				"string %d is not valid UTF-8, hosts see replacement characters", idx)
+		}
+	}
+	checkString("iManufacturer", d.Device.IManufacturer)
+	checkString("iProduct", d.Device.IProduct)
+	checkString("iSerialNumber", d.Device.ISerialNumber)
+	if d.MSOS20 != nil {
+		if err := d.MSOS20.Validate(); err != nil {
+			fail("msos20", "keep compatible and sub-compatible IDs to 8 ASCII bytes",
+				"MS OS 2.0 descriptors: %v", err)
+		}
+	}
+
+	type setting struct{ num, alt uint8 }
+	seen := map[setting]bool{}
+	epOwner := map[uint8]uint8{}
+	for _, iface := range d.Interfaces {
+		s := setting{iface.Descriptor.BInterfaceNumber, iface.Descriptor.BAlternateSetting}
+		where := fmt.Sprintf("interface %d alt %d", s.num, s.alt)
+		if seen[s] {
+			fail("interface-duplicate", "give each setting its own bAlternateSetting",
+				"%s is defined twice", where)
+			continue
+		}
+		seen[s] = true
+		if s.alt > 0 && !seen[setting{s.num, s.alt - 1}] {
+			fail("alt-setting-order", "number alternate settings from 0 and list them in order",
+				"%s does not follow alt %d", where, s.alt-1)
+		}
+		checkString(where+": iInterface", iface.Descriptor.IInterface)
+		if n := iface.Descriptor.BNumEndpoints; int(n) != len(iface.Endpoints) {
+			fail("num-endpoints", "set bNumEndpoints to the number of Endpoints",
+				"%s: bNumEndpoints is %d but %d endpoints are defined", where, n, len(iface.Endpoints))
+		}
+		for _, cd := range iface.ClassDescriptors {
+			if len(cd.Payload) > maxClassPayload {
+				fail("class-descriptor-size", "split the payload into several class descriptors",
+					"%s: class descriptor 0x%02x has a %d byte payload, at most %d fit", where, cd.DescriptorType, len(cd.Payload), maxClassPayload)
+			}
+		}
+
+		inSetting := map[uint8]bool{}
+		var inPacket uint16
+		for _, ep := range iface.Endpoints {
+			addr := ep.BEndpointAddress
+			switch {
+			case addr&0x0F == 0:
+				fail("endpoint-zero", "use endpoint numbers 1-15",
+					"%s: endpoint address 0x%02x is endpoint 0, which is reserved for control transfers", where, addr)
+			case addr&0x70 != 0:
+				fail("endpoint-reserved-bits", "keep bits 4-6 of bEndpointAddress clear",
+					"%s: endpoint address 0x%02x has reserved bits set", where, addr)
+			case inSetting[addr]:
+				fail("endpoint-duplicate", "give each endpoint of a setting its own address",
+					"%s: endpoint address 0x%02x is used twice", where, addr)
+			default:
+				// Alternate settings of an interface may reuse its addresses.
+				if owner, ok := epOwner[addr]; ok && owner != s.num {
+					fail("endpoint-shared", "give each interface its own endpoint addresses",
+						"%s: endpoint address 0x%02x is already used by interface %d", where, addr, owner)
+				}
+				epOwner[addr] = s.num
+			}
+			inSetting[addr] = true
+			if ep.Type() == EndpointTypeInterrupt {
+				if ep.WMaxPacketSize == 0 {
+					fail("endpoint-packet-size", "set wMaxPacketSize to the largest report the endpoint carries",
+						"%s: endpoint 0x%02x has a wMaxPacketSize of 0", where, addr)
+				} else if limit, ok := maxInterruptPacket[d.Device.Speed]; ok && ep.WMaxPacketSize > limit {
+					warn("endpoint-packet-size", "lower wMaxPacketSize or raise Speed",
+						"%s: endpoint 0x%02x has a wMaxPacketSize of %d, interrupt endpoints of speed %d allow %d", where, addr, ep.WMaxPacketSize, d.Device.Speed, limit)
+				}
+				if addr&0x80 != 0 && inPacket == 0 {
+					inPacket = ep.WMaxPacketSize
+				}
+			}
+			for _, cd := range ep.ClassDescriptors {
+				if len(cd.Payload) > maxClassPayload {
+					fail("class-descriptor-size", "split the payload into several class descriptors",
+						"%s: endpoint 0x%02x: class descriptor 0x%02x has a %d byte payload, at most %d fit", where, addr, cd.DescriptorType, len(cd.Payload), maxClassPayload)
+				}
+			}
+		}
+
+		const classHID = 0x03
+		desc := iface.Descriptor
+		switch {
+		case iface.HID != nil && desc.BInterfaceClass != classHID:
+			fail("hid-class", "set bInterfaceClass to 0x03 or drop the HID function",
+				"%s: HID function on an interface of class 0x%02x", where, desc.BInterfaceClass)
+		case iface.HID == nil && desc.BInterfaceClass == classHID:
+			fail("hid-missing", "add a HIDFunction with the report descriptor",
+				"%s: HID class interface without a HID function", where)
+		}
+		if desc.BInterfaceClass == classHID {
+			switch {
+			case desc.BInterfaceSubClass > 1:
+				warn("hid-subclass", "use subclass 0, or 1 for boot interfaces",
+					"%s: HID subclass %d is reserved", where, desc.BInterfaceSubClass)
+			case desc.BInterfaceSubClass == 1 && desc.BInterfaceProtocol != 1 && desc.BInterfaceProtocol != 2:
+				warn("hid-boot-protocol", "boot interfaces use protocol 1 (keyboard) or 2 (mouse)",
+					"%s: boot interface with protocol %d", where, desc.BInterfaceProtocol)
+			case desc.BInterfaceSubClass == 0 && desc.BInterfaceProtocol != 0:
+				warn("hid-boot-protocol", "set subclass 1 for a boot interface, else protocol 0",
+					"%s: protocol %d on an interface without boot subclass", where, desc.BInterfaceProtocol)
+			}
+		}
+
+		if iface.HID != nil {
+			iface.HID.lint(where, inPacket, fail, warn)
+		}
+	}
+
+	numInterfaces := 0
+	for s := range seen {
+		if s.alt == 0 {
+			numInterfaces++
+		}
+	}
+	for num := range uint8(numInterfaces) {
+		if !seen[setting{num, 0}] {
+			fail("interface-gap", "number interfaces from 0 without gaps",
+				"interface numbers must start at 0 without gaps, interface %d alt 0 is missing", num)
+			break
+		}
+	}
+
+	if n := d.configLen(); n > 0xFFFF {
+		fail("config-length", "drop interfaces or alternate settings",
+			"configuration descriptor has %d bytes, wTotalLength holds at most 65535", n)
+	}
+	return r
+}
+
+// configLen returns the length of the configuration descriptor of d.
+func (d *Descriptor) configLen() int {
+	var b bytes.Buffer
+	ConfigHeader{}.Write(&b)
+	for _, iface := range d.Interfaces {
+		iface.Descriptor.Write(&b)
+		if iface.HID != nil {
+			hd, _ := iface.HID.DescriptorBytes()
+			b.Write(hd)
+		}
+		for _, cd := range iface.ClassDescriptors {
+			b.Write(cd.Bytes())
+		}
+		for _, ep := range iface.Endpoints {
+			ep.Write(&b)
+		}
+	}
+	return b.Len()
+}
+
+// lint checks that the HID descriptor references the report descriptor,
+// that the report encodes and that its input reports fit the packets of
+// the interrupt IN endpoint, inPacket, if there is one.
+func (f HIDFunction) lint(where string, inPacket uint16, fail, warn func(code, hint, format string, args ...any)) {
+	var ref *HIDSubDescriptor
+	for i, sd := range f.Descriptor.Descriptors {
+		if sd.Type == ReportDescType {
+			ref = &f.Descriptor.Descriptors[i]
+			break
+		}
+	}
+	if ref == nil {
+		fail("hid-report-missing", "add a HIDSubDescriptor of type ReportDescType",
+			"%s: HID descriptor does not reference a report descriptor", where)
+		return
+	}
+	rl, err := f.reportLen()
+	if err != nil {
+		fail("hid-report-invalid", "fix the report items",
+			"%s: HID report descriptor: %v", where, err)
+		return
+	}
+	if rl == 0 {
+		fail("hid-report-empty", "add the report items",
+			"%s: HID report descriptor is empty", where)
+		return
+	}
+	if ref.Length != 0 && ref.Length != rl {
+		fail("hid-report-length", "leave Length 0 to have it computed",
+			"%s: HID descriptor declares a %d byte report descriptor, but the report has %d bytes", where, ref.Length, rl)
+	}
+	if inPacket == 0 {
+		return
+	}
+	sizes, err := f.Report.InputReportSizes()
+	if err != nil {
+		return
+	}
+	for _, id := range slices.Sorted(maps.Keys(sizes)) {
+		if n := sizes[id]; n > int(inPacket) {
+			warn("hid-report-packet", "raise wMaxPacketSize or split the report; hosts reading one packet per report truncate it",
+				"%s: input report %d has %d bytes, the IN endpoint's packets %d", where, id, n, inPacket)
+		}
+	}
+}
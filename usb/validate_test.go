@@ -0,0 +1,337 @@
+package usb_test
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Alia5/VIIPER/device"
+	"github.com/Alia5/VIIPER/device/dualshock4"
+	"github.com/Alia5/VIIPER/device/joystick"
+	"github.com/Alia5/VIIPER/device/keyboard"
+	"github.com/Alia5/VIIPER/device/mouse"
+	"github.com/Alia5/VIIPER/device/switchpro"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	"github.com/Alia5/VIIPER/device/xboxone"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usb/hid"
+)
+
+// validDescriptor returns a HID device with an IN and an OUT endpoint and a
+// vendor interface with two alternate settings.
+func validDescriptor() usb.Descriptor {
+	return usb.Descriptor{
+		Device: usb.DeviceDescriptor{
+			BcdUSB:             0x0200,
+			BMaxPacketSize0:    64,
+			IDVendor:           0x1209,
+			IDProduct:          0x0001,
+			IManufacturer:      1,
+			IProduct:           2,
+			BNumConfigurations: 1,
+		},
+		Interfaces: []usb.InterfaceConfig{
+			{
+				Descriptor: usb.InterfaceDescriptor{BNumEndpoints: 2, BInterfaceClass: 0x03},
+				Endpoints: []usb.EndpointDescriptor{
+					{BEndpointAddress: 0x81, BMAttributes: usb.EndpointTypeInterrupt, WMaxPacketSize: 8, BInterval: 1},
+					{BEndpointAddress: 0x01, BMAttributes: usb.EndpointTypeInterrupt, WMaxPacketSize: 8, BInterval: 1},
+				},
+				HID: &usb.HIDFunction{
+					Descriptor: usb.HIDDescriptor{
+						BcdHID:      0x0111,
+						Descriptors: []usb.HIDSubDescriptor{{Type: usb.ReportDescType}},
+					},
+					Report: hid.Report{Items: []hid.Item{
+						hid.UsagePage{Page: hid.UsagePageGenericDesktop},
+						hid.Usage{Usage: hid.UsageGamePad},
+					}},
+				},
+			},
+			{Descriptor: usb.InterfaceDescriptor{BInterfaceNumber: 1, BInterfaceClass: 0xff}},
+			{
+				Descriptor: usb.InterfaceDescriptor{BInterfaceNumber: 1, BAlternateSetting: 1, BNumEndpoints: 1, BInterfaceClass: 0xff, IInterface: 3},
+				Endpoints: []usb.EndpointDescriptor{
+					{BEndpointAddress: 0x82, BMAttributes: usb.EndpointTypeIsochronous, WMaxPacketSize: 64, BInterval: 1},
+				},
+			},
+		},
+		Strings: map[uint8]string{0: "\x04\x09", 1: "VIIPER", 2: "Broken Pad", 3: "Stream"},
+	}
+}
+
+func TestDescriptorValidate(t *testing.T) {
+	require.NoError(t, validate(func(*usb.Descriptor) {}))
+
+	tests := []struct {
+		name   string
+		mutate func(d *usb.Descriptor)
+		want   string
+	}{
+		{
+			name:   "control packet size",
+			mutate: func(d *usb.Descriptor) { d.Device.BMaxPacketSize0 = 12 },
+			want:   "usb: bMaxPacketSize0 is 12, want 8, 16, 32 or 64",
+		},
+		{
+			name:   "several configurations",
+			mutate: func(d *usb.Descriptor) { d.Device.BNumConfigurations = 2 },
+			want:   "usb: bNumConfigurations is 2, only a single configuration is supported",
+		},
+		{
+			name:   "missing product string",
+			mutate: func(d *usb.Descriptor) { delete(d.Strings, 2) },
+			want:   "usb: iProduct references string 2, which is not defined",
+		},
+		{
+			name:   "missing serial number string",
+			mutate: func(d *usb.Descriptor) { d.Device.ISerialNumber = 9 },
+			want:   "usb: iSerialNumber references string 9, which is not defined",
+		},
+		{
+			name:   "missing interface string",
+			mutate: func(d *usb.Descriptor) { d.Interfaces[2].Descriptor.IInterface = 7 },
+			want:   "usb: interface 1 alt 1: iInterface references string 7, which is not defined",
+		},
+		{
+			name:   "string too long",
+			mutate: func(d *usb.Descriptor) { d.Strings[3] = strings.Repeat("x", 127) },
+			want:   "usb: string 3 has 127 characters, at most 126 fit",
+		},
+		{
+			name:   "too few endpoints",
+			mutate: func(d *usb.Descriptor) { d.Interfaces[0].Descriptor.BNumEndpoints = 3 },
+			want:   "usb: interface 0 alt 0: bNumEndpoints is 3 but 2 endpoints are defined",
+		},
+		{
+			name:   "too many endpoints",
+			mutate: func(d *usb.Descriptor) { d.Interfaces[2].Descriptor.BNumEndpoints = 0 },
+			want:   "usb: interface 1 alt 1: bNumEndpoints is 0 but 1 endpoints are defined",
+		},
+		{
+			name:   "duplicate endpoint in a setting",
+			mutate: func(d *usb.Descriptor) { d.Interfaces[0].Endpoints[1].BEndpointAddress = 0x81 },
+			want:   "usb: interface 0 alt 0: endpoint address 0x81 is used twice",
+		},
+		{
+			name:   "endpoint shared between interfaces",
+			mutate: func(d *usb.Descriptor) { d.Interfaces[2].Endpoints[0].BEndpointAddress = 0x81 },
+			want:   "usb: interface 1 alt 1: endpoint address 0x81 is already used by interface 0",
+		},
+		{
+			name:   "endpoint zero",
+			mutate: func(d *usb.Descriptor) { d.Interfaces[0].Endpoints[1].BEndpointAddress = 0x80 },
+			want:   "usb: interface 0 alt 0: endpoint address 0x80 is endpoint 0, which is reserved for control transfers",
+		},
+		{
+			name:   "reserved endpoint address bits",
+			mutate: func(d *usb.Descriptor) { d.Interfaces[0].Endpoints[1].BEndpointAddress = 0x11 },
+			want:   "usb: interface 0 alt 0: endpoint address 0x11 has reserved bits set",
+		},
+		{
+			name:   "interrupt endpoint without packets",
+			mutate: func(d *usb.Descriptor) { d.Interfaces[0].Endpoints[0].WMaxPacketSize = 0 },
+			want:   "usb: interface 0 alt 0: endpoint 0x81 has a wMaxPacketSize of 0",
+		},
+		{
+			name: "interface class descriptor too long",
+			mutate: func(d *usb.Descriptor) {
+				d.Interfaces[1].ClassDescriptors = []usb.ClassSpecificDescriptor{{DescriptorType: 0x24, Payload: make(usb.Data, 254)}}
+			},
+			want: "usb: interface 1 alt 0: class descriptor 0x24 has a 254 byte payload, at most 253 fit",
+		},
+		{
+			name: "endpoint class descriptor too long",
+			mutate: func(d *usb.Descriptor) {
+				d.Interfaces[2].Endpoints[0].ClassDescriptors = []usb.ClassSpecificDescriptor{{DescriptorType: 0x25, Payload: make(usb.Data, 300)}}
+			},
+			want: "usb: interface 1 alt 1: endpoint 0x82: class descriptor 0x25 has a 300 byte payload, at most 253 fit",
+		},
+		{
+			name:   "duplicate setting",
+			mutate: func(d *usb.Descriptor) { d.Interfaces = append(d.Interfaces, d.Interfaces[1]) },
+			want:   "usb: interface 1 alt 0 is defined twice",
+		},
+		{
+			name:   "alternate setting out of order",
+			mutate: func(d *usb.Descriptor) { d.Interfaces[2].Descriptor.BAlternateSetting = 2 },
+			want:   "usb: interface 1 alt 2 does not follow alt 1",
+		},
+		{
+			name: "interface number gap",
+			mutate: func(d *usb.Descriptor) {
+				d.Interfaces[1].Descriptor.BInterfaceNumber = 2
+				d.Interfaces[2].Descriptor.BInterfaceNumber = 2
+			},
+			want: "usb: interface numbers must start at 0 without gaps, interface 1 alt 0 is missing",
+		},
+		{
+			name:   "HID without report reference",
+			mutate: func(d *usb.Descriptor) { d.Interfaces[0].HID.Descriptor.Descriptors[0].Type = 0x23 },
+			want:   "usb: interface 0 alt 0: HID descriptor does not reference a report descriptor",
+		},
+		{
+			name:   "HID without subordinate descriptors",
+			mutate: func(d *usb.Descriptor) { d.Interfaces[0].HID.Descriptor.Descriptors = nil },
+			want:   "usb: interface 0 alt 0: HID descriptor does not reference a report descriptor",
+		},
+		{
+			name:   "empty HID report",
+			mutate: func(d *usb.Descriptor) { d.Interfaces[0].HID.Report = hid.Report{} },
+			want:   "usb: interface 0 alt 0: HID report descriptor is empty",
+		},
+		{
+			name: "HID report that does not encode",
+			mutate: func(d *usb.Descriptor) {
+				d.Interfaces[0].HID.Report.Items = append(d.Interfaces[0].HID.Report.Items, nil)
+			},
+			want: "usb: interface 0 alt 0: HID report descriptor: hid: nil item",
+		},
+		{
+			name:   "HID report length mismatch",
+			mutate: func(d *usb.Descriptor) { d.Interfaces[0].HID.Descriptor.Descriptors[0].Length = 10 },
+			want:   "usb: interface 0 alt 0: HID descriptor declares a 10 byte report descriptor, but the report has 4 bytes",
+		},
+		{
+			name: "all problems are reported",
+			mutate: func(d *usb.Descriptor) {
+				d.Device.IManufacturer = 5
+				d.Interfaces[0].Descriptor.BNumEndpoints = 1
+			},
+			want: "usb: iManufacturer references string 5, which is not defined\n" +
+				"usb: interface 0 alt 0: bNumEndpoints is 1 but 2 endpoints are defined",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.EqualError(t, validate(tt.mutate), tt.want)
+		})
+	}
+}
+
+func TestDescriptorLint(t *testing.T) {
+	assert.Empty(t, lint(func(*usb.Descriptor) {}))
+
+	tests := []struct {
+		name     string
+		mutate   func(d *usb.Descriptor)
+		code     string
+		severity usb.Severity
+	}{
+		{"endpoint count", func(d *usb.Descriptor) { d.Interfaces[0].Descriptor.BNumEndpoints = 1 }, "num-endpoints", usb.SeverityError},
+		{"endpoint collision", func(d *usb.Descriptor) { d.Interfaces[2].Endpoints[0].BEndpointAddress = 0x81 }, "endpoint-shared", usb.SeverityError},
+		{"missing string", func(d *usb.Descriptor) { d.Device.ISerialNumber = 9 }, "string-missing", usb.SeverityError},
+		{"string not UTF-8", func(d *usb.Descriptor) { d.Strings[3] = "\xff\xfe" }, "string-encoding", usb.SeverityWarning},
+		{"HID on vendor class", func(d *usb.Descriptor) { d.Interfaces[0].Descriptor.BInterfaceClass = 0xff }, "hid-class", usb.SeverityError},
+		{"HID class without function", func(d *usb.Descriptor) { d.Interfaces[1].Descriptor.BInterfaceClass = 0x03 }, "hid-missing", usb.SeverityError},
+		{"boot interface protocol", func(d *usb.Descriptor) { d.Interfaces[0].Descriptor.BInterfaceSubClass = 1 }, "hid-boot-protocol", usb.SeverityWarning},
+		{"protocol without boot", func(d *usb.Descriptor) { d.Interfaces[0].Descriptor.BInterfaceProtocol = 2 }, "hid-boot-protocol", usb.SeverityWarning},
+		{"reserved HID subclass", func(d *usb.Descriptor) { d.Interfaces[0].Descriptor.BInterfaceSubClass = 4 }, "hid-subclass", usb.SeverityWarning},
+		{"report length", func(d *usb.Descriptor) { d.Interfaces[0].HID.Descriptor.Descriptors[0].Length = 10 }, "hid-report-length", usb.SeverityError},
+		{
+			name: "report larger than packets",
+			mutate: func(d *usb.Descriptor) {
+				d.Interfaces[0].HID.Report.Items = append(d.Interfaces[0].HID.Report.Items,
+					hid.ReportSize{Bits: 8}, hid.ReportCount{Count: 9}, hid.Input{Flags: hid.MainData | hid.MainVar})
+			},
+			code:     "hid-report-packet",
+			severity: usb.SeverityWarning,
+		},
+		{
+			name: "interrupt packet above speed",
+			mutate: func(d *usb.Descriptor) {
+				d.Device.Speed = 1
+				d.Interfaces[0].Endpoints[1].WMaxPacketSize = 16
+			},
+			code:     "endpoint-packet-size",
+			severity: usb.SeverityWarning,
+		},
+		{
+			name: "configuration too long",
+			mutate: func(d *usb.Descriptor) {
+				for alt := range uint8(255) {
+					d.Interfaces = append(d.Interfaces, usb.InterfaceConfig{
+						Descriptor:       usb.InterfaceDescriptor{BInterfaceNumber: 2, BAlternateSetting: alt, BInterfaceClass: 0xff},
+						ClassDescriptors: []usb.ClassSpecificDescriptor{{DescriptorType: 0x24, Payload: make(usb.Data, 253)}},
+					})
+				}
+			},
+			code:     "config-length",
+			severity: usb.SeverityError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := lint(tt.mutate)
+			require.Len(t, r, 1, r.String())
+			assert.Equal(t, tt.code, r[0].Code)
+			assert.Equal(t, tt.severity, r[0].Severity)
+			assert.NotEmpty(t, r[0].Hint)
+			if tt.severity == usb.SeverityWarning {
+				assert.NoError(t, r.Err(), "warnings do not fail validation")
+			} else {
+				assert.EqualError(t, r.Err(), r[0].Error())
+			}
+		})
+	}
+}
+
+// lint is validate for Lint.
+func lint(mutate func(d *usb.Descriptor)) usb.LintReport {
+	return mutated(mutate).Lint()
+}
+
+// validate applies mutate to a copy of validDescriptor and validates it.
+func validate(mutate func(d *usb.Descriptor)) error {
+	return mutated(mutate).Validate()
+}
+
+// mutated returns a copy of validDescriptor changed by mutate.
+func mutated(mutate func(d *usb.Descriptor)) *usb.Descriptor {
+	d := validDescriptor()
+	d.Interfaces = slices.Clone(d.Interfaces)
+	for i := range d.Interfaces {
+		d.Interfaces[i].Endpoints = slices.Clone(d.Interfaces[i].Endpoints)
+	}
+	hidFn := *d.Interfaces[0].HID
+	hidFn.Descriptor.Descriptors = slices.Clone(hidFn.Descriptor.Descriptors)
+	hidFn.Report.Items = slices.Clone(hidFn.Report.Items)
+	d.Interfaces[0].HID = &hidFn
+	d.Strings = maps.Clone(d.Strings)
+	mutate(&d)
+	return &d
+}
+
+// TestBuiltinDescriptorsLint keeps the built-in devices free of lint
+// findings, with their default options and with the options changing their
+// descriptors.
+func TestBuiltinDescriptorsLint(t *testing.T) {
+	opts := func(k string, v any) *device.CreateOptions {
+		return &device.CreateOptions{DeviceSpecific: map[string]any{k: v}}
+	}
+	devices := map[string]func() (usb.Device, error){
+		"xbox360":              func() (usb.Device, error) { return xbox360.New(nil) },
+		"xbox360/headset":      func() (usb.Device, error) { return xbox360.New(opts("headset", true)) },
+		"keyboard":             func() (usb.Device, error) { return keyboard.New(nil) },
+		"keyboard/mediaKeys":   func() (usb.Device, error) { return keyboard.New(opts("mediaKeys", true)) },
+		"mouse":                func() (usb.Device, error) { return mouse.New(nil) },
+		"mouse/hiResScroll":    func() (usb.Device, error) { return mouse.New(opts("hiResScroll", true)) },
+		"joystick":             func() (usb.Device, error) { return joystick.New(nil) },
+		"joystick/ffb":         func() (usb.Device, error) { return joystick.New(opts("forceFeedback", true)) },
+		"dualshock4":           func() (usb.Device, error) { return dualshock4.New(nil) },
+		"dualshock4/audioStub": func() (usb.Device, error) { return dualshock4.New(opts("audioStub", true)) },
+		"switchpro":            func() (usb.Device, error) { return switchpro.New(nil) },
+		"xboxone":              func() (usb.Device, error) { return xboxone.New(nil) },
+	}
+	for name, create := range devices {
+		t.Run(name, func(t *testing.T) {
+			dev, err := create()
+			require.NoError(t, err)
+			assert.Empty(t, dev.GetDescriptor().Lint().String())
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package usbip
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
 )
@@ -63,6 +64,14 @@ type ExportMeta struct {
 	DevId    uint32
 }
 
+// BusIDString returns the busid hosts import the device by, e.g. "1-2".
+func (m *ExportMeta) BusIDString() string {
+	if end := bytes.IndexByte(m.USBBusId[:], 0); end >= 0 {
+		return string(m.USBBusId[:end])
+	}
+	return string(m.USBBusId[:])
+}
+
 // ExportedDevice describes one exported device in devlist/import replies.
 // Layout matches kernel doc, strings are fixed-size, remaining numbers are BE.
 type ExportedDevice struct {
@@ -283,6 +292,45 @@ func (r *RetSubmit) Write(w io.Writer) error {
 	return err
 }
 
+// IsoPacketDescriptorSize is the wire size of an IsoPacketDescriptor.
+const IsoPacketDescriptorSize = 16
+
+// IsoPacketDescriptor describes one packet of an isochronous transfer. An
+// isochronous CMD_SUBMIT carries NumberOfPackets of them after the OUT
+// payload; RET_SUBMIT returns them, with ActualLength and Status filled in,
+// after the IN payload, which holds the packets back to back.
+type IsoPacketDescriptor struct {
+	Offset       uint32
+	Length       uint32
+	ActualLength uint32
+	Status       int32
+}
+
+// ParseIsoPacketDescriptors decodes consecutive packet descriptors from b.
+func ParseIsoPacketDescriptors(b []byte) []IsoPacketDescriptor {
+	pkts := make([]IsoPacketDescriptor, len(b)/IsoPacketDescriptorSize)
+	for i := range pkts {
+		p := b[i*IsoPacketDescriptorSize:]
+		pkts[i] = IsoPacketDescriptor{
+			Offset:       binary.BigEndian.Uint32(p[0:4]),
+			Length:       binary.BigEndian.Uint32(p[4:8]),
+			ActualLength: binary.BigEndian.Uint32(p[8:12]),
+			Status:       int32(binary.BigEndian.Uint32(p[12:16])),
+		}
+	}
+	return pkts
+}
+
+func (p *IsoPacketDescriptor) Write(w io.Writer) error {
+	var buf [IsoPacketDescriptorSize]byte
+	binary.BigEndian.PutUint32(buf[0:4], p.Offset)
+	binary.BigEndian.PutUint32(buf[4:8], p.Length)
+	binary.BigEndian.PutUint32(buf[8:12], p.ActualLength)
+	binary.BigEndian.PutUint32(buf[12:16], uint32(p.Status))
+	_, err := w.Write(buf[:])
+	return err
+}
+
 // CmdUnlink and RetUnlink
 type CmdUnlink struct {
 	Basic        HeaderBasic
@@ -0,0 +1,205 @@
+// Package usbipclient implements the client side of the USB-IP protocol:
+// listing the devices a server exports, importing one and exchanging URBs
+// with it.
+//
+// It lets Go programs use VIIPER devices on hosts without a native USB-IP
+// client, e.g. to bridge an imported device into another input API.
+package usbipclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/Alia5/VIIPER/usbip"
+)
+
+// Device is a device as a server exports it in OP_REP_DEVLIST and
+// OP_REP_IMPORT. Interfaces are only listed by OP_REP_DEVLIST.
+type Device struct {
+	Path       string
+	BusID      string
+	BusNum     uint32
+	DeviceNum  uint32
+	Speed      uint32
+	IDVendor   uint16
+	IDProduct  uint16
+	BcdDevice  uint16
+	Class      uint8
+	SubClass   uint8
+	Protocol   uint8
+	ConfigVal  uint8
+	NumConfigs uint8
+	NumIfaces  uint8
+	Interfaces []usbip.InterfaceDesc
+}
+
+// exportedDeviceSize is the size of a device in OP_REP_DEVLIST and
+// OP_REP_IMPORT, without its interfaces.
+const exportedDeviceSize = 312
+
+// ListDevices returns the devices exported by the USB-IP server at addr.
+func ListDevices(addr string) ([]Device, error) {
+	return ListDevicesCtx(context.Background(), addr)
+}
+
+// ListDevicesCtx is ListDevices with a context bounding the whole exchange.
+func ListDevicesCtx(ctx context.Context, addr string) ([]Device, error) {
+	conn, err := dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := (&usbip.MgmtHeader{Version: usbip.Version, Command: usbip.OpReqDevlist}).Write(conn); err != nil {
+		return nil, err
+	}
+	var hdr [12]byte
+	if err := usbip.ReadExactly(conn, hdr[:]); err != nil {
+		return nil, err
+	}
+	if err := checkReply(hdr[:8], usbip.OpRepDevlist); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(hdr[8:12])
+	devices := make([]Device, 0, min(n, 256))
+	for range n {
+		dev, _, err := readDevice(conn, true)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+// Attach imports the device with busID, e.g. "1-2", from the USB-IP server
+// at addr and returns the connection to exchange URBs with it.
+func Attach(addr, busID string) (*Conn, error) {
+	return AttachCtx(context.Background(), addr, busID)
+}
+
+// AttachCtx is Attach with a context bounding the import. Cancelling it
+// afterwards does not affect the returned connection.
+func AttachCtx(ctx context.Context, addr, busID string) (*Conn, error) {
+	conn, err := dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := importDevice(conn, busID)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(noDeadline)
+	return c, nil
+}
+
+func importDevice(conn net.Conn, busID string) (*Conn, error) {
+	if len(busID) >= 32 {
+		return nil, fmt.Errorf("usbipclient: bus id %q too long", busID)
+	}
+	if err := (&usbip.MgmtHeader{Version: usbip.Version, Command: usbip.OpReqImport}).Write(conn); err != nil {
+		return nil, err
+	}
+	var bus [32]byte
+	copy(bus[:], busID)
+	if _, err := conn.Write(bus[:]); err != nil {
+		return nil, err
+	}
+
+	var hdr [8]byte
+	if err := usbip.ReadExactly(conn, hdr[:]); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("usbipclient: import of %s refused", busID)
+		}
+		return nil, err
+	}
+	if err := checkReply(hdr[:], usbip.OpRepImport); err != nil {
+		return nil, fmt.Errorf("usbipclient: import of %s: %w", busID, err)
+	}
+	dev, raw, err := readDevice(conn, false)
+	if err != nil {
+		return nil, err
+	}
+	c := NewConn(conn)
+	c.Device = dev
+	c.RawDevice = raw
+	return c, nil
+}
+
+func dial(ctx context.Context, addr string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	return conn, nil
+}
+
+// checkReply checks the version, command and status of an OP_REP header.
+func checkReply(hdr []byte, want uint16) error {
+	if v := binary.BigEndian.Uint16(hdr[0:2]); v != usbip.Version {
+		return fmt.Errorf("usbipclient: unexpected usbip version %x", v)
+	}
+	if cmd := binary.BigEndian.Uint16(hdr[2:4]); cmd != want {
+		return fmt.Errorf("usbipclient: unexpected reply command %x", cmd)
+	}
+	if status := binary.BigEndian.Uint32(hdr[4:8]); status != 0 {
+		return fmt.Errorf("usbipclient: reply status %d", status)
+	}
+	return nil
+}
+
+// readDevice reads an exported device and, if withIfaces is set, the
+// interfaces following it. It also returns the device bytes as read.
+func readDevice(r io.Reader, withIfaces bool) (Device, []byte, error) {
+	base := make([]byte, exportedDeviceSize)
+	if err := usbip.ReadExactly(r, base); err != nil {
+		return Device{}, nil, err
+	}
+	cstring := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return string(b)
+	}
+	dev := Device{
+		Path:       cstring(base[0:256]),
+		BusID:      cstring(base[256:288]),
+		BusNum:     binary.BigEndian.Uint32(base[288:292]),
+		DeviceNum:  binary.BigEndian.Uint32(base[292:296]),
+		Speed:      binary.BigEndian.Uint32(base[296:300]),
+		IDVendor:   binary.BigEndian.Uint16(base[300:302]),
+		IDProduct:  binary.BigEndian.Uint16(base[302:304]),
+		BcdDevice:  binary.BigEndian.Uint16(base[304:306]),
+		Class:      base[306],
+		SubClass:   base[307],
+		Protocol:   base[308],
+		ConfigVal:  base[309],
+		NumConfigs: base[310],
+		NumIfaces:  base[311],
+		Interfaces: make([]usbip.InterfaceDesc, 0, base[311]),
+	}
+	if withIfaces && dev.NumIfaces > 0 {
+		buf := make([]byte, int(dev.NumIfaces)*4)
+		if err := usbip.ReadExactly(r, buf); err != nil {
+			return Device{}, nil, err
+		}
+		for i := range int(dev.NumIfaces) {
+			dev.Interfaces = append(dev.Interfaces, usbip.InterfaceDesc{
+				Class:    buf[i*4],
+				SubClass: buf[i*4+1],
+				Protocol: buf[i*4+2],
+			})
+		}
+	}
+	return dev, base, nil
+}
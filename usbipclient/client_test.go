@@ -0,0 +1,124 @@
+package usbipclient_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	viiperTesting "github.com/Alia5/VIIPER/_testing"
+	"github.com/Alia5/VIIPER/device/dualshock4"
+	"github.com/Alia5/VIIPER/device/xbox360"
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+	"github.com/Alia5/VIIPER/usbipclient"
+	"github.com/Alia5/VIIPER/virtualbus"
+)
+
+// serve exports dev as the only device of bus busID and returns the
+// USB-IP address.
+func serve(t *testing.T, busID uint32, dev usb.Device) string {
+	t.Helper()
+	s := viiperTesting.NewTestServer(t)
+	t.Cleanup(func() { _ = s.UsbServer.Close() })
+	b, err := virtualbus.NewWithBusId(busID)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+	require.NoError(t, s.UsbServer.AddBus(b))
+	_, err = b.Add(dev)
+	require.NoError(t, err)
+	return s.UsbServer.Addr()
+}
+
+func TestListAndAttach(t *testing.T) {
+	dev, err := xbox360.New(nil)
+	require.NoError(t, err)
+	addr := serve(t, 90175, dev)
+
+	devices, err := usbipclient.ListDevices(addr)
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, "90175-1", devices[0].BusID)
+	assert.Equal(t, uint16(0x045e), devices[0].IDVendor)
+	assert.Len(t, devices[0].Interfaces, int(devices[0].NumIfaces))
+
+	_, err = usbipclient.Attach(addr, "90175-9")
+	assert.EqualError(t, err, "usbipclient: import of 90175-9 refused")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := usbipclient.AttachCtx(ctx, addr, "90175-1")
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, devices[0].IDProduct, conn.Device.IDProduct)
+	assert.Len(t, conn.RawDevice, 312)
+
+	// GET_DESCRIPTOR(device)
+	desc, err := conn.Control([8]byte{0x80, 0x06, 0x00, 0x01, 0x00, 0x00, 18, 0x00}, nil)
+	require.NoError(t, err)
+	require.Len(t, desc, 18)
+	assert.Equal(t, uint16(0x045e), binary.LittleEndian.Uint16(desc[8:10]))
+
+	report, err := conn.ReadEndpoint(1, 64)
+	require.NoError(t, err)
+	assert.Len(t, report, 20)
+	require.NoError(t, conn.WriteEndpoint(1, []byte{0x01, 0x03, 0x06}), "LED command")
+
+	// GET_DESCRIPTOR of a string the device lacks stalls.
+	_, err = conn.Control([8]byte{0x80, 0x06, 0x99, 0x03, 0x09, 0x04, 0xFF, 0x00}, nil)
+	var urbErr *usbipclient.URBError
+	require.True(t, errors.As(err, &urbErr), "got %v", err)
+	assert.Equal(t, int32(-32), urbErr.Status)
+}
+
+// slowInput is a DualShock4 whose input endpoint is polled every 255 ms, so
+// an IN URB after the first is held until the input changes.
+type slowInput struct {
+	*dualshock4.DualShock4
+	desc usb.Descriptor
+}
+
+func (d *slowInput) GetDescriptor() *usb.Descriptor { return &d.desc }
+
+func TestUnlink(t *testing.T) {
+	ds4, err := dualshock4.New(nil)
+	require.NoError(t, err)
+	dev := &slowInput{DualShock4: ds4, desc: *ds4.GetDescriptor()}
+	dev.desc.Interfaces = slices.Clone(dev.desc.Interfaces)
+	dev.desc.Interfaces[0].Endpoints = slices.Clone(dev.desc.Interfaces[0].Endpoints)
+	for i, ep := range dev.desc.Interfaces[0].Endpoints {
+		if ep.BEndpointAddress == 0x84 {
+			dev.desc.Interfaces[0].Endpoints[i].BInterval = 255
+		}
+	}
+	conn, err := usbipclient.Attach(serve(t, 90176, dev), "90176-1")
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.ReadEndpoint(4, 64)
+	require.NoError(t, err, "the first report is not held")
+
+	held, err := conn.Submit(&usbipclient.URB{Dir: usbip.DirIn, Ep: 4, InLen: 64})
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteEndpoint(3, nil), "served after the held URB was taken up")
+	unlink, err := conn.Unlink(held)
+	require.NoError(t, err)
+	r, err := conn.ReadReply()
+	require.NoError(t, err)
+	assert.Equal(t, &usbipclient.Reply{Seqnum: unlink, Unlink: true, Status: -104}, r, "the held URB is unlinked")
+	require.NoError(t, r.Err())
+
+	dev.UpdateInputState(&dualshock4.InputState{Buttons: dualshock4.ButtonCross})
+	in, err := conn.Submit(&usbipclient.URB{Dir: usbip.DirIn, Ep: 4, InLen: 64})
+	require.NoError(t, err)
+	r, err = conn.ReadReply()
+	require.NoError(t, err)
+	assert.Equal(t, in, r.Seqnum, "no RET_SUBMIT for the unlinked URB")
+	assert.NotEmpty(t, r.Data)
+}
@@ -0,0 +1,279 @@
+package usbipclient
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Alia5/VIIPER/usbip"
+)
+
+var noDeadline time.Time
+
+// URB is a USB request block to submit to an imported device.
+type URB struct {
+	Dir uint32 // usbip.DirIn or usbip.DirOut
+	Ep  uint32 // endpoint number, without the direction bit
+	// Setup is the setup packet of a control transfer on endpoint 0.
+	Setup [8]byte
+	// Out is the payload of an OUT transfer.
+	Out []byte
+	// InLen is the buffer size of an IN transfer.
+	InLen uint32
+	// IsoLengths makes the URB isochronous, with one packet of each length.
+	// OUT packets are taken from Out back to back.
+	IsoLengths    []uint32
+	Interval      uint32
+	TransferFlags uint32
+}
+
+// Reply is a RET_SUBMIT or RET_UNLINK.
+type Reply struct {
+	Seqnum uint32
+	// Unlink marks a RET_UNLINK; Status is then -ECONNRESET if the URB was
+	// unlinked and 0 if it had completed already.
+	Unlink bool
+	Status int32
+	// Data is the IN data of a RET_SUBMIT.
+	Data []byte
+	// IsoPackets are the packets of an isochronous URB as completed.
+	IsoPackets []usbip.IsoPacketDescriptor
+}
+
+// Err returns a *URBError for a RET_SUBMIT with a non-zero status.
+func (r *Reply) Err() error {
+	if r.Unlink || r.Status == 0 {
+		return nil
+	}
+	return &URBError{Status: r.Status}
+}
+
+// URBError is returned for a RET_SUBMIT with a non-zero status, e.g. -32
+// (-EPIPE) for a stall.
+type URBError struct {
+	Status int32
+}
+
+func (e *URBError) Error() string { return fmt.Sprintf("ret status %d", e.Status) }
+
+// pendingURB is what reading the RET_SUBMIT of a URB needs to know of it.
+type pendingURB struct {
+	dir uint32
+	iso bool
+}
+
+// Conn is the URB connection to an imported device. Submit and Unlink may
+// be called concurrently with each other and with one goroutine reading
+// replies; Do and the helpers built on it expect no other URBs in flight.
+type Conn struct {
+	conn net.Conn
+	// Device is the device as the server reported it on import.
+	Device Device
+	// RawDevice holds the device bytes of OP_REP_IMPORT.
+	RawDevice []byte
+
+	seq     atomic.Uint32
+	wmu     sync.Mutex
+	mu      sync.Mutex
+	pending map[uint32]pendingURB
+	unlinks map[uint32]uint32 // CMD_UNLINK seqnum to the seqnum it unlinks
+}
+
+// NewConn returns a Conn for a connection whose device is already imported.
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{
+		conn:    conn,
+		pending: make(map[uint32]pendingURB),
+		unlinks: make(map[uint32]uint32),
+	}
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn { return c.conn }
+
+// SetDeadline sets the read and write deadline of the connection.
+func (c *Conn) SetDeadline(t time.Time) error { return c.conn.SetDeadline(t) }
+
+// Close closes the connection, which detaches the device.
+func (c *Conn) Close() error { return c.conn.Close() }
+
+// Submit sends u as CMD_SUBMIT and returns its seqnum without waiting for
+// the reply.
+func (c *Conn) Submit(u *URB) (uint32, error) {
+	seq := c.seq.Add(1)
+	cmd := usbip.CmdSubmit{
+		Basic:         usbip.HeaderBasic{Command: usbip.CmdSubmitCode, Seqnum: seq, Dir: u.Dir, Ep: u.Ep},
+		TransferFlags: u.TransferFlags,
+		Interval:      u.Interval,
+		Setup:         u.Setup,
+	}
+	if u.Dir == usbip.DirOut {
+		cmd.TransferBufferLen = uint32(len(u.Out))
+	} else {
+		cmd.TransferBufferLen = u.InLen
+	}
+	var buf bytes.Buffer
+	if u.IsoLengths != nil {
+		cmd.NumberOfPackets = uint32(len(u.IsoLengths))
+		cmd.TransferBufferLen = 0
+		for _, l := range u.IsoLengths {
+			cmd.TransferBufferLen += l
+		}
+	}
+	_ = cmd.Write(&buf)
+	if u.Dir == usbip.DirOut {
+		buf.Write(u.Out)
+	}
+	var offset uint32
+	for _, l := range u.IsoLengths {
+		p := usbip.IsoPacketDescriptor{Offset: offset, Length: l}
+		_ = p.Write(&buf)
+		offset += l
+	}
+
+	c.mu.Lock()
+	c.pending[seq] = pendingURB{dir: u.Dir, iso: u.IsoLengths != nil}
+	c.mu.Unlock()
+	if err := c.write(buf.Bytes()); err != nil {
+		c.mu.Lock()
+		delete(c.pending, seq)
+		c.mu.Unlock()
+		return 0, err
+	}
+	return seq, nil
+}
+
+// Unlink sends CMD_UNLINK for the URB with seqnum target and returns the
+// seqnum of the unlink. Its RET_UNLINK tells whether the URB was unlinked;
+// if not, the RET_SUBMIT of the URB is read as usual.
+func (c *Conn) Unlink(target uint32) (uint32, error) {
+	seq := c.seq.Add(1)
+	cmd := usbip.CmdUnlink{
+		Basic:        usbip.HeaderBasic{Command: usbip.CmdUnlinkCode, Seqnum: seq},
+		UnlinkSeqnum: target,
+	}
+	var buf bytes.Buffer
+	_ = cmd.Write(&buf)
+	c.mu.Lock()
+	c.unlinks[seq] = target
+	c.mu.Unlock()
+	if err := c.write(buf.Bytes()); err != nil {
+		c.mu.Lock()
+		delete(c.unlinks, seq)
+		c.mu.Unlock()
+		return 0, err
+	}
+	return seq, nil
+}
+
+func (c *Conn) write(b []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// ReadReply reads the next RET_SUBMIT or RET_UNLINK. Replies come in the
+// order the server completes URBs, not the order they were submitted.
+func (c *Conn) ReadReply() (*Reply, error) {
+	var hdr [48]byte
+	if err := usbip.ReadExactly(c.conn, hdr[:]); err != nil {
+		return nil, err
+	}
+	r := &Reply{
+		Seqnum: binary.BigEndian.Uint32(hdr[4:8]),
+		Status: int32(binary.BigEndian.Uint32(hdr[20:24])),
+	}
+	switch cmd := binary.BigEndian.Uint32(hdr[0:4]); cmd {
+	case usbip.RetUnlinkCode:
+		r.Unlink = true
+		c.mu.Lock()
+		if target, ok := c.unlinks[r.Seqnum]; ok {
+			delete(c.unlinks, r.Seqnum)
+			if r.Status != 0 {
+				// Unlinked URBs get no RET_SUBMIT.
+				delete(c.pending, target)
+			}
+		}
+		c.mu.Unlock()
+		return r, nil
+	case usbip.RetSubmitCode:
+	default:
+		return nil, fmt.Errorf("usbipclient: unexpected reply command %x", cmd)
+	}
+
+	c.mu.Lock()
+	p, ok := c.pending[r.Seqnum]
+	delete(c.pending, r.Seqnum)
+	c.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("usbipclient: RET_SUBMIT for unknown seqnum %d", r.Seqnum)
+	}
+	if actual := binary.BigEndian.Uint32(hdr[24:28]); p.dir == usbip.DirIn && actual > 0 {
+		r.Data = make([]byte, actual)
+		if err := usbip.ReadExactly(c.conn, r.Data); err != nil {
+			return nil, err
+		}
+	}
+	if p.iso {
+		n := binary.BigEndian.Uint32(hdr[32:36])
+		raw := make([]byte, int(n)*usbip.IsoPacketDescriptorSize)
+		if err := usbip.ReadExactly(c.conn, raw); err != nil {
+			return nil, err
+		}
+		r.IsoPackets = usbip.ParseIsoPacketDescriptors(raw)
+	}
+	return r, nil
+}
+
+// Do submits u and reads its reply. A reply with a non-zero status is
+// returned along with a *URBError.
+func (c *Conn) Do(u *URB) (*Reply, error) {
+	seq, err := c.Submit(u)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.ReadReply()
+	if err != nil {
+		return nil, err
+	}
+	if r.Unlink || r.Seqnum != seq {
+		return nil, fmt.Errorf("usbipclient: reply for seqnum %d while waiting for %d", r.Seqnum, seq)
+	}
+	return r, r.Err()
+}
+
+// Control sends a control transfer on endpoint 0 and returns the IN data
+// stage. The direction and IN length follow the setup packet.
+func (c *Conn) Control(setup [8]byte, out []byte) ([]byte, error) {
+	u := &URB{Dir: usbip.DirOut, Setup: setup, Out: out}
+	if setup[0]&0x80 != 0 {
+		u.Dir = usbip.DirIn
+		u.InLen = uint32(binary.LittleEndian.Uint16(setup[6:8]))
+	}
+	r, err := c.Do(u)
+	if err != nil {
+		return nil, err
+	}
+	return r.Data, nil
+}
+
+// ReadEndpoint reads one IN transfer of up to maxLen bytes from endpoint
+// number ep.
+func (c *Conn) ReadEndpoint(ep, maxLen uint32) ([]byte, error) {
+	r, err := c.Do(&URB{Dir: usbip.DirIn, Ep: ep, InLen: maxLen})
+	if err != nil {
+		return nil, err
+	}
+	return r.Data, nil
+}
+
+// WriteEndpoint sends data as one OUT transfer to endpoint number ep.
+func (c *Conn) WriteEndpoint(ep uint32, data []byte) error {
+	_, err := c.Do(&URB{Dir: usbip.DirOut, Ep: ep, Out: data})
+	return err
+}
@@ -0,0 +1,72 @@
+package virtualbus
+
+import (
+	"time"
+
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// Attachment describes a USB/IP host importing a device.
+type Attachment struct {
+	Since      time.Time
+	RemoteAddr string
+}
+
+// SetAttached records that the host at remoteAddr imported dev. The returned
+// func clears the record again, unless another import replaced it since.
+func (vb *VirtualBus) SetAttached(dev usb.Device, remoteAddr string) (detach func()) {
+	a := &Attachment{Since: time.Now(), RemoteAddr: remoteAddr}
+	vb.setAttachment(dev, nil, a)
+	return func() { vb.setAttachment(dev, a, nil) }
+}
+
+// setAttachment replaces the attachment of dev with a; a nil old replaces any.
+func (vb *VirtualBus) setAttachment(dev usb.Device, old, a *Attachment) {
+	vb.mutex.Lock()
+	defer vb.mutex.Unlock()
+	for i := range vb.devices {
+		d := &vb.devices[i]
+		if d.dev != dev || (old != nil && d.attached != old) {
+			continue
+		}
+		d.attached = a
+		if a != nil {
+			vb.emit(DeviceAttached, d)
+		} else {
+			vb.emit(DeviceDetached, d)
+		}
+		if d.attachChanged != nil {
+			close(d.attachChanged)
+			d.attachChanged = nil
+		}
+		return
+	}
+}
+
+// Attachment returns the import of dev; ok is false while no host has it
+// imported.
+func (vb *VirtualBus) Attachment(dev usb.Device) (a Attachment, ok bool) {
+	a, ok, _ = vb.WatchAttachment(dev)
+	return a, ok
+}
+
+// WatchAttachment is Attachment, also returning a channel closed once the
+// attachment of dev changes. The channel is nil for devices not on the bus.
+func (vb *VirtualBus) WatchAttachment(dev usb.Device) (a Attachment, ok bool, changed <-chan struct{}) {
+	vb.mutex.Lock()
+	defer vb.mutex.Unlock()
+	for i := range vb.devices {
+		d := &vb.devices[i]
+		if d.dev != dev {
+			continue
+		}
+		if d.attachChanged == nil {
+			d.attachChanged = make(chan struct{})
+		}
+		if d.attached != nil {
+			a, ok = *d.attached, true
+		}
+		return a, ok, d.attachChanged
+	}
+	return Attachment{}, false, nil
+}
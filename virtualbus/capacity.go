@@ -0,0 +1,96 @@
+package virtualbus
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Alia5/VIIPER/usb"
+)
+
+// DefaultMaxDevices matches the ports of one Linux vhci_hcd controller;
+// imports beyond those fail on the host.
+const DefaultMaxDevices = 8
+
+// MaxDevicesLimit is the most devices a bus may be configured to hold, the
+// number of addresses on a USB bus.
+const MaxDevicesLimit = 127
+
+// ErrBusFull is returned by Add once every port of the bus is taken.
+var ErrBusFull = errors.New("bus is full")
+
+// Option configures a bus at creation.
+type Option func(vb *VirtualBus) error
+
+// WithMaxDevices limits the bus to n devices, each on a port 1 to n.
+func WithMaxDevices(n int) Option {
+	return func(vb *VirtualBus) error {
+		if n < 1 || n > MaxDevicesLimit {
+			return fmt.Errorf("maxDevices must be between 1 and %d", MaxDevicesLimit)
+		}
+		vb.maxDevices = n
+		return nil
+	}
+}
+
+// MaxDevices returns the number of devices the bus holds at most.
+func (vb *VirtualBus) MaxDevices() int {
+	vb.mutex.Lock()
+	defer vb.mutex.Unlock()
+	return vb.capacity()
+}
+
+func (vb *VirtualBus) capacity() int {
+	if vb.maxDevices == 0 {
+		return DefaultMaxDevices
+	}
+	return vb.maxDevices
+}
+
+// freePort returns the lowest port no device occupies. The caller holds
+// vb.mutex and has checked the bus is not full.
+func (vb *VirtualBus) freePort() int {
+	for port := 1; ; port++ {
+		taken := false
+		for _, d := range vb.devices {
+			if d.port == port {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			return port
+		}
+	}
+}
+
+// checkPort reports whether port exists on the bus and is free. The caller
+// holds vb.mutex.
+func (vb *VirtualBus) checkPort(port int) error {
+	if port < 1 || port > vb.capacity() {
+		return fmt.Errorf("port %d not on bus %d", port, vb.busId)
+	}
+	for _, d := range vb.devices {
+		if d.port == port {
+			return fmt.Errorf("port %d already taken on bus %d", port, vb.busId)
+		}
+	}
+	return nil
+}
+
+// Port returns the port dev occupies, 0 if it is not on the bus.
+func (vb *VirtualBus) Port(dev usb.Device) int {
+	vb.mutex.Lock()
+	defer vb.mutex.Unlock()
+	for _, d := range vb.devices {
+		if d.dev == dev {
+			return d.port
+		}
+	}
+	return 0
+}
+
+// PortPath renders the port of a device on busID the way Linux names USB
+// devices, e.g. "3-2".
+func PortPath(busID uint32, port int) string {
+	return fmt.Sprintf("%d-%d", busID, port)
+}
@@ -0,0 +1,44 @@
+package virtualbus
+
+import (
+	"maps"
+
+	"github.com/Alia5/VIIPER/device"
+)
+
+// AnyDeviceType keys bus defaults applying to every device type.
+const AnyDeviceType = "*"
+
+// SetDefaults replaces the bus-level default create options, keyed by device
+// type or AnyDeviceType.
+func (vb *VirtualBus) SetDefaults(defaults map[string]device.CreateOptions) {
+	vb.mutex.Lock()
+	defer vb.mutex.Unlock()
+	vb.defaults = maps.Clone(defaults)
+}
+
+// Defaults returns a copy of the bus-level default create options.
+func (vb *VirtualBus) Defaults() map[string]device.CreateOptions {
+	vb.mutex.Lock()
+	defer vb.mutex.Unlock()
+	return maps.Clone(vb.defaults)
+}
+
+// ResolveOptions merges the bus defaults for devType under o.
+// Explicit options win over type defaults, which win over AnyDeviceType.
+func (vb *VirtualBus) ResolveOptions(devType string, o device.CreateOptions) device.CreateOptions {
+	vb.mutex.Lock()
+	defer vb.mutex.Unlock()
+	return ResolveOptions(vb.defaults, devType, o)
+}
+
+// ResolveOptions merges defaults for devType under o, see VirtualBus.ResolveOptions.
+func ResolveOptions(defaults map[string]device.CreateOptions, devType string, o device.CreateOptions) device.CreateOptions {
+	if def, ok := defaults[devType]; ok {
+		o = o.WithDefaults(def)
+	}
+	if def, ok := defaults[AnyDeviceType]; ok {
+		o = o.WithDefaults(def)
+	}
+	return o
+}
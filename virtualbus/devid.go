@@ -0,0 +1,66 @@
+package virtualbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+)
+
+// MaxDeviceIDLen bounds caller-supplied device IDs, so "<busId>-<id>" fits
+// the 31 bytes of a USB-IP busid for any bus number.
+const MaxDeviceIDLen = 20
+
+// ErrDeviceIDTaken is returned by the Add functions when the requested
+// device ID is in use on the bus.
+var ErrDeviceIDTaken = errors.New("device id taken")
+
+// ValidateDeviceName reports whether name can address a device in place of
+// its number: lowercase letters, digits and dashes, not starting with a
+// dash, at most MaxDeviceIDLen bytes and not all digits.
+func ValidateDeviceName(name string) error {
+	if name == "" {
+		return fmt.Errorf("device id is empty")
+	}
+	if len(name) > MaxDeviceIDLen {
+		return fmt.Errorf("device id exceeds %d bytes", MaxDeviceIDLen)
+	}
+	digits := true
+	for _, r := range name {
+		switch {
+		case r >= '0' && r <= '9':
+		case r >= 'a' && r <= 'z', r == '-':
+			digits = false
+		default:
+			return fmt.Errorf("device id %q may only contain a-z, 0-9 and -", name)
+		}
+	}
+	if name[0] == '-' {
+		return fmt.Errorf("device id %q starts with a dash", name)
+	}
+	if digits {
+		return fmt.Errorf("device id %q is a device number", name)
+	}
+	return nil
+}
+
+// DeviceID returns the ID a device is addressed by on its bus: its name if
+// it was added with AddNamed, its number otherwise.
+func DeviceID(meta *usbip.ExportMeta) string {
+	_, id, _ := strings.Cut(meta.BusIDString(), "-")
+	return id
+}
+
+// AddNamed is AddWithID for a device addressed by name instead of its
+// number; name replaces the number in its USB-IP busid, e.g. "3-left-pad".
+// The device still gets a number, devID or the lowest free one for 0,
+// which hosts see in the import reply.
+func (vb *VirtualBus) AddNamed(dev usb.Device, name string, devID uint32, port int) (context.Context, error) {
+	if err := ValidateDeviceName(name); err != nil {
+		return nil, err
+	}
+	return vb.add(dev, devID, port, name)
+}
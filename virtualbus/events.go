@@ -0,0 +1,38 @@
+package virtualbus
+
+import "github.com/Alia5/VIIPER/usb"
+
+// DeviceEventType is the kind of a DeviceEvent.
+type DeviceEventType string
+
+const (
+	DeviceAdded    DeviceEventType = "DeviceAdded"
+	DeviceRemoved  DeviceEventType = "DeviceRemoved"
+	DeviceAttached DeviceEventType = "DeviceAttached" // imported by a USB/IP host
+	DeviceDetached DeviceEventType = "DeviceDetached"
+)
+
+// DeviceEvent is a change of a device on a bus.
+type DeviceEvent struct {
+	Type  DeviceEventType
+	BusID uint32
+	DevID uint32
+	ID    string // DevID as addressed through the API, see DeviceID
+	Dev   usb.Device
+}
+
+// OnDeviceEvent sets the func called with every device event of the bus.
+// It is called with the bus locked, so events arrive in order, and must
+// neither block nor use the bus.
+func (vb *VirtualBus) OnDeviceEvent(f func(DeviceEvent)) {
+	vb.mutex.Lock()
+	defer vb.mutex.Unlock()
+	vb.onEvent = f
+}
+
+// emit reports a device event; vb.mutex must be held.
+func (vb *VirtualBus) emit(t DeviceEventType, d *busDevice) {
+	if vb.onEvent != nil {
+		vb.onEvent(DeviceEvent{Type: t, BusID: vb.busId, DevID: d.meta.DevId, ID: DeviceID(&d.meta), Dev: d.dev})
+	}
+}
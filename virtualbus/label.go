@@ -0,0 +1,120 @@
+package virtualbus
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Alia5/VIIPER/usb"
+	"github.com/Alia5/VIIPER/usbip"
+)
+
+// Length limits for bus labels and descriptions, in bytes.
+const (
+	MaxLabelLen       = 64
+	MaxDescriptionLen = 256
+)
+
+// maxPathLabel bounds the label slug embedded in exported USB-IP paths.
+const maxPathLabel = 32
+
+// SetLabel sets the human-readable label and description of the bus.
+// The label is embedded in the USB-IP path of devices added afterwards.
+func (vb *VirtualBus) SetLabel(label, description string) error {
+	if len(label) > MaxLabelLen {
+		return fmt.Errorf("label exceeds %d bytes", MaxLabelLen)
+	}
+	if len(description) > MaxDescriptionLen {
+		return fmt.Errorf("description exceeds %d bytes", MaxDescriptionLen)
+	}
+	vb.mutex.Lock()
+	defer vb.mutex.Unlock()
+	vb.label = label
+	vb.description = description
+	return nil
+}
+
+// Label returns the label and description of the bus.
+func (vb *VirtualBus) Label() (label, description string) {
+	vb.mutex.Lock()
+	defer vb.mutex.Unlock()
+	return vb.label, vb.description
+}
+
+// ValidateDeviceLabel reports whether label can name a device: at most
+// MaxLabelLen bytes of valid UTF-8 without control characters.
+func ValidateDeviceLabel(label string) error {
+	if len(label) > MaxLabelLen {
+		return fmt.Errorf("label exceeds %d bytes", MaxLabelLen)
+	}
+	if !utf8.ValidString(label) {
+		return fmt.Errorf("label is not valid UTF-8")
+	}
+	if strings.IndexFunc(label, unicode.IsControl) >= 0 {
+		return fmt.Errorf("label contains control characters")
+	}
+	return nil
+}
+
+// SetDeviceLabel sets the label of dev, shown in device listings.
+// An empty label clears it.
+func (vb *VirtualBus) SetDeviceLabel(dev usb.Device, label string) error {
+	if err := ValidateDeviceLabel(label); err != nil {
+		return err
+	}
+	vb.mutex.Lock()
+	defer vb.mutex.Unlock()
+	for i := range vb.devices {
+		if vb.devices[i].dev == dev {
+			vb.devices[i].label = label
+			return nil
+		}
+	}
+	return fmt.Errorf("device not found")
+}
+
+// DeviceCount returns the number of devices attached to the bus.
+func (vb *VirtualBus) DeviceCount() int {
+	vb.mutex.Lock()
+	defer vb.mutex.Unlock()
+	return len(vb.devices)
+}
+
+// devicePath builds the sysfs-like path exported for a device, e.g.
+// ".../usb3-ci-rig-pads/3-1", so `usbip list -r` hints at the bus purpose.
+func devicePath(busID uint32, busDevID, label string) string {
+	prefix := fmt.Sprintf("%s%d", basepath, busID)
+	if slug := pathLabel(label); slug != "" {
+		prefix += "-" + slug
+	}
+	path := prefix + "/" + busDevID
+	if len(path) >= len(usbip.ExportMeta{}.Path) {
+		// Never truncate the device part; drop the label instead.
+		return fmt.Sprintf("%s%d/%s", basepath, busID, busDevID)
+	}
+	return path
+}
+
+// pathLabel reduces label to lowercase alphanumerics separated by single
+// dashes, truncated to maxPathLabel bytes.
+func pathLabel(label string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(label) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		default:
+			dash = true
+		}
+		if b.Len() >= maxPathLabel {
+			break
+		}
+	}
+	return strings.TrimRight(b.String()[:min(b.Len(), maxPathLabel)], "-")
+}
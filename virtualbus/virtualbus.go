@@ -3,6 +3,7 @@ package virtualbus
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -19,6 +20,13 @@ var (
 	globalMutex     sync.Mutex
 )
 
+// ErrBusAllocated is returned by NewWithBusId for a bus number in use.
+var ErrBusAllocated = errors.New("already allocated")
+
+// ErrInvalidDescriptor is returned by the Add functions for a device whose
+// descriptor fails usb.Descriptor.Validate.
+var ErrInvalidDescriptor = errors.New("invalid device descriptor")
+
 // VirtualBus manages USB bus topology and auto-assigns device addresses.
 type VirtualBus struct {
 	mutex           sync.Mutex
@@ -28,12 +36,23 @@ type VirtualBus struct {
 	devices         []busDevice
 	emptyCtx        context.Context
 	emptyCancel     context.CancelFunc
+	defaults        map[string]device.CreateOptions
+	label           string
+	description     string
+	onEvent         func(DeviceEvent)
+	maxDevices      int
 }
 
 // DeviceMeta exposes a registered device and its metadata for external queries.
 type DeviceMeta struct {
-	Dev  usb.Device
-	Meta usbip.ExportMeta
+	Dev        usb.Device
+	Meta       usbip.ExportMeta
+	Disconnect device.DisconnectPolicy
+	Port       int
+	// Mixer merges the streams of a device with the mixed stream policy,
+	// nil for others.
+	Mixer *device.Mixer
+	Label string
 }
 
 // New creates a new VirtualBus instance with a unique auto-assigned bus number.
@@ -51,21 +70,29 @@ func New(busID uint32) *VirtualBus {
 }
 
 // NewWithBusId creates a new VirtualBus instance starting at a specific bus number.
-// Returns an error if the bus number is already allocated.
-func NewWithBusId(busId uint32) (*VirtualBus, error) {
+// Returns an error if the bus number is already allocated or an option is
+// invalid. Without WithMaxDevices the bus holds DefaultMaxDevices devices.
+func NewWithBusId(busId uint32, opts ...Option) (*VirtualBus, error) {
+	vb := &VirtualBus{
+		busId:           busId,
+		nextDevID:       0,
+		allocatedDevIDs: make(map[uint32]bool),
+	}
+	for _, opt := range opts {
+		if err := opt(vb); err != nil {
+			return nil, err
+		}
+	}
+
 	globalMutex.Lock()
 	defer globalMutex.Unlock()
 
 	if allocatedBusIds[busId] {
-		return nil, fmt.Errorf("bus number %d already allocated", busId)
+		return nil, fmt.Errorf("bus number %d %w", busId, ErrBusAllocated)
 	}
 	allocatedBusIds[busId] = true
 
-	return &VirtualBus{
-		busId:           busId,
-		nextDevID:       0,
-		allocatedDevIDs: make(map[uint32]bool),
-	}, nil
+	return vb, nil
 }
 
 // Add registers a device using a descriptor provider implemented by the device.
@@ -76,7 +103,27 @@ func NewWithBusId(busId uint32) (*VirtualBus, error) {
 //
 // which returns a static descriptor that will be used for bus registration.
 // Returns a context containing the device's lifecycle and metadata (use GetDeviceMeta to extract).
+// A bus holding its maximum of devices fails with ErrBusFull.
 func (vb *VirtualBus) Add(dev usb.Device) (context.Context, error) {
+	return vb.add(dev, 0, 0, "")
+}
+
+// AddWithID is Add with a fixed device ID instead of the lowest free one, as
+// used to restore devices under their previous ID. A port of 0 picks the
+// lowest free port as well.
+func (vb *VirtualBus) AddWithID(dev usb.Device, devID uint32, port int) (context.Context, error) {
+	if devID == 0 {
+		return nil, fmt.Errorf("invalid device id 0")
+	}
+	return vb.add(dev, devID, port, "")
+}
+
+// add registers dev under devID on port, or the lowest free ID and port for 0,
+// addressed by name if set.
+func (vb *VirtualBus) add(dev usb.Device, devID uint32, port int, name string) (context.Context, error) {
+	if err := dev.GetDescriptor().Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDescriptor, err)
+	}
 	vb.mutex.Lock()
 	defer vb.mutex.Unlock()
 
@@ -92,17 +139,36 @@ func (vb *VirtualBus) Add(dev usb.Device) (context.Context, error) {
 		}
 	}
 	busID := vb.busId
-	var devID uint32
-	for i := uint32(1); ; i++ {
+	if n := vb.capacity(); len(vb.devices) >= n {
+		return nil, fmt.Errorf("%w: bus %d holds %d devices", ErrBusFull, busID, n)
+	}
+	if devID != 0 && vb.allocatedDevIDs[devID] {
+		return nil, fmt.Errorf("%w: %d on bus %d", ErrDeviceIDTaken, devID, busID)
+	}
+	if name != "" {
+		for i := range vb.devices {
+			if DeviceID(&vb.devices[i].meta) == name {
+				return nil, fmt.Errorf("%w: %s on bus %d", ErrDeviceIDTaken, name, busID)
+			}
+		}
+	}
+	if port == 0 {
+		port = vb.freePort()
+	} else if err := vb.checkPort(port); err != nil {
+		return nil, err
+	}
+	for i := uint32(1); devID == 0; i++ {
 		if !vb.allocatedDevIDs[i] {
 			devID = i
-			vb.allocatedDevIDs[i] = true
-			break
 		}
 	}
+	vb.allocatedDevIDs[devID] = true
 
 	busDevID := fmt.Sprintf("%d-%d", busID, devID)
-	path := fmt.Sprintf("%s%d/%s", basepath, busID, busDevID)
+	if name != "" {
+		busDevID = fmt.Sprintf("%d-%s", busID, name)
+	}
+	path := devicePath(busID, busDevID, vb.label)
 
 	var meta usbip.ExportMeta
 	copy(meta.Path[:], path)
@@ -115,7 +181,8 @@ func (vb *VirtualBus) Add(dev usb.Device) (context.Context, error) {
 	ctx = context.WithValue(ctx, device.ExportMetaKey, &meta)
 	ctx = context.WithValue(ctx, device.ConnTimerKey, connTimer)
 
-	vb.devices = append(vb.devices, busDevice{dev: dev, meta: meta, ctx: ctx, cancel: cancel})
+	vb.devices = append(vb.devices, busDevice{dev: dev, meta: meta, port: port, ctx: ctx, cancel: cancel})
+	vb.emit(DeviceAdded, &vb.devices[len(vb.devices)-1])
 	return ctx, nil
 }
 
@@ -125,7 +192,7 @@ func (vb *VirtualBus) GetAllDeviceMetas() []DeviceMeta {
 	defer vb.mutex.Unlock()
 	out := make([]DeviceMeta, 0, len(vb.devices))
 	for _, d := range vb.devices {
-		out = append(out, DeviceMeta{Dev: d.dev, Meta: d.meta})
+		out = append(out, DeviceMeta{Dev: d.dev, Meta: d.meta, Disconnect: d.disconnectPolicy(), Port: d.port, Mixer: d.mixer, Label: d.label})
 	}
 	return out
 }
@@ -166,13 +233,8 @@ func (vb *VirtualBus) RemoveDeviceByID(deviceID string) error {
 	vb.mutex.Lock()
 	defer vb.mutex.Unlock()
 	for i, d := range vb.devices {
-		if fmt.Sprintf("%d", d.meta.DevId) == deviceID {
-			if d.cancel != nil {
-				d.cancel()
-			}
-			delete(vb.allocatedDevIDs, d.meta.DevId)
-			vb.devices = append(vb.devices[:i], vb.devices[i+1:]...)
-
+		if DeviceID(&d.meta) == deviceID {
+			vb.removeAt(i)
 			return nil
 		}
 	}
@@ -188,17 +250,28 @@ func (vb *VirtualBus) Remove(dev usb.Device) error {
 	defer vb.mutex.Unlock()
 	for i, d := range vb.devices {
 		if d.dev == dev {
-			if d.cancel != nil {
-				d.cancel()
-			}
-			delete(vb.allocatedDevIDs, d.meta.DevId)
-			vb.devices = append(vb.devices[:i], vb.devices[i+1:]...)
+			vb.removeAt(i)
 			return nil
 		}
 	}
 	return fmt.Errorf("device not found")
 }
 
+// removeAt removes the device at index i, cancelling its context. A device
+// still imported reports its detach first. vb.mutex must be held.
+func (vb *VirtualBus) removeAt(i int) {
+	d := &vb.devices[i]
+	if d.cancel != nil {
+		d.cancel()
+	}
+	if d.attached != nil {
+		vb.emit(DeviceDetached, d)
+	}
+	vb.emit(DeviceRemoved, d)
+	delete(vb.allocatedDevIDs, d.meta.DevId)
+	vb.devices = append(vb.devices[:i], vb.devices[i+1:]...)
+}
+
 // Close frees the bus number allocated to this VirtualBus, allowing it to be
 // reused. After calling Close, this VirtualBus instance should not be used.
 func (vb *VirtualBus) Close() error {
@@ -236,9 +309,84 @@ func (vb *VirtualBus) GetDeviceContext(dev usb.Device) context.Context {
 	return nil
 }
 
+// SetDisconnectPolicy sets what happens to dev when its stream client goes
+// away. Devices start out with device.DisconnectHoldLastState.
+func (vb *VirtualBus) SetDisconnectPolicy(dev usb.Device, p device.DisconnectPolicy) error {
+	vb.mutex.Lock()
+	defer vb.mutex.Unlock()
+	for i := range vb.devices {
+		if vb.devices[i].dev == dev {
+			vb.devices[i].disconnect = p
+			return nil
+		}
+	}
+	return fmt.Errorf("device not found")
+}
+
+// SetStreamMixer gives dev the mixed stream policy, its streams being merged
+// by m.
+func (vb *VirtualBus) SetStreamMixer(dev usb.Device, m *device.Mixer) error {
+	vb.mutex.Lock()
+	defer vb.mutex.Unlock()
+	for i := range vb.devices {
+		if vb.devices[i].dev == dev {
+			vb.devices[i].mixer = m
+			return nil
+		}
+	}
+	return fmt.Errorf("device not found")
+}
+
+// StreamMixer returns the mixer of dev, nil unless it has the mixed stream
+// policy.
+func (vb *VirtualBus) StreamMixer(dev usb.Device) *device.Mixer {
+	vb.mutex.Lock()
+	defer vb.mutex.Unlock()
+	for i := range vb.devices {
+		if vb.devices[i].dev == dev {
+			return vb.devices[i].mixer
+		}
+	}
+	return nil
+}
+
+// StreamClosed applies the disconnect policy of dev once its stream client
+// went away and returns the policy. Resetting to the neutral state happens
+// here; detaching is left to the caller, which owns the USB/IP side.
+func (vb *VirtualBus) StreamClosed(dev usb.Device) device.DisconnectPolicy {
+	vb.mutex.Lock()
+	p := device.DisconnectHoldLastState
+	for i := range vb.devices {
+		if vb.devices[i].dev == dev {
+			p = vb.devices[i].disconnectPolicy()
+			break
+		}
+	}
+	vb.mutex.Unlock()
+	if r, ok := dev.(device.InputResetter); ok && p == device.DisconnectNeutralState {
+		r.ResetInputState()
+	}
+	return p
+}
+
 type busDevice struct {
-	dev    usb.Device
-	meta   usbip.ExportMeta
-	ctx    context.Context
-	cancel context.CancelFunc
+	dev        usb.Device
+	meta       usbip.ExportMeta
+	port       int
+	ctx        context.Context
+	cancel     context.CancelFunc
+	disconnect device.DisconnectPolicy
+	mixer      *device.Mixer
+	label      string
+	// attached is set while a host has the device imported; attachChanged
+	// is closed when it changes.
+	attached      *Attachment
+	attachChanged chan struct{}
+}
+
+func (d *busDevice) disconnectPolicy() device.DisconnectPolicy {
+	if d.disconnect == "" {
+		return device.DisconnectHoldLastState
+	}
+	return d.disconnect
 }